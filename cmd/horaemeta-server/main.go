@@ -24,10 +24,14 @@ import (
 	"syscall"
 
 	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/pkg/failpoint"
+	"github.com/CeresDB/horaemeta/pkg/goruntime"
 	"github.com/CeresDB/horaemeta/pkg/log"
 	"github.com/CeresDB/horaemeta/server"
 	"github.com/CeresDB/horaemeta/server/config"
+	"github.com/CeresDB/horaemeta/server/member"
 	"github.com/pelletier/go-toml/v2"
+	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
 )
 
@@ -66,10 +70,6 @@ func main() {
 		return
 	}
 
-	if err := cfg.ValidateAndAdjust(); err != nil {
-		panicf("invalid config, err:%v", err)
-	}
-
 	if err := cfgParser.ParseConfigFromToml(); err != nil {
 		panicf("fail to parse config from toml file, err:%v", err)
 	}
@@ -78,7 +78,17 @@ func main() {
 		panicf("fail to parse config from environment variable, err:%v", err)
 	}
 
-	cfgByte, err := toml.Marshal(cfg)
+	// The config is validated after all the sources are parsed, so that the deploy mode from any of them is applied.
+	if err := cfg.ValidateAndAdjust(); err != nil {
+		panicf("invalid config, err:%v", err)
+	}
+
+	// The admin token is masked in the logged config.
+	loggedCfg := *cfg
+	if len(loggedCfg.TenantAdminToken) > 0 {
+		loggedCfg.TenantAdminToken = "***"
+	}
+	cfgByte, err := toml.Marshal(loggedCfg)
 	if err != nil {
 		panicf("fail to marshal server config, err:%v", err)
 	}
@@ -96,11 +106,21 @@ func main() {
 	// TODO: Do adjustment to config for preparing joining existing cluster.
 	log.Info("server start with config", zap.String("config", string(cfgByte)))
 
+	goruntime.Apply(cfg.Runtime)
+	failpoint.SetEnabled(cfg.EnableFailpoints)
+	prometheus.MustRegister(goruntime.NewCollector())
+
 	srv, err := server.CreateServer(cfg)
 	if err != nil {
 		log.Error("fail to create server", zap.Error(err))
 		return
 	}
+	srv.SetConfigLoader(cfgParser.Reload)
+	srv.SetBuildInfo(member.BuildInfo{
+		CommitID:   commitID,
+		BranchName: branchName,
+		BuildDate:  buildDate,
+	})
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -113,7 +133,16 @@ func main() {
 
 	var sig os.Signal
 	go func() {
-		sig = <-sc
+		for {
+			sig = <-sc
+			// SIGHUP reloads the config instead of stopping the server.
+			if sig != syscall.SIGHUP {
+				break
+			}
+			if _, err := srv.ReloadConfig(); err != nil {
+				log.Error("fail to reload config", zap.Error(err))
+			}
+		}
 		cancel()
 	}()
 
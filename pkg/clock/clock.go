@@ -0,0 +1,78 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock is the source of the current time, so the time can be controlled in tests.
+type Clock interface {
+	Now() time.Time
+}
+
+type realClock struct{}
+
+// NewRealClock returns the clock of the system time in UTC.
+func NewRealClock() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// Mock is a clock which only moves when it is advanced or set manually.
+type Mock struct {
+	lock sync.RWMutex
+	now  time.Time
+}
+
+func NewMock(now time.Time) *Mock {
+	return &Mock{
+		lock: sync.RWMutex{},
+		now:  now.UTC(),
+	}
+}
+
+func (m *Mock) Now() time.Time {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.now
+}
+
+func (m *Mock) Advance(d time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.now = m.now.Add(d)
+}
+
+func (m *Mock) Set(now time.Time) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.now = now.UTC()
+}
+
+// UnixMilli returns the current time of the clock in unix milliseconds, which is the format of all the persisted
+// timestamps.
+func UnixMilli(c Clock) uint64 {
+	return uint64(c.Now().UnixMilli())
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package clock
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMock(t *testing.T) {
+	re := require.New(t)
+
+	start := time.UnixMilli(1700000000000)
+	mock := NewMock(start)
+	re.Equal(start.UTC(), mock.Now())
+	re.Equal(uint64(1700000000000), UnixMilli(mock))
+
+	mock.Advance(time.Second)
+	re.Equal(uint64(1700000001000), UnixMilli(mock))
+
+	mock.Set(start)
+	re.Equal(uint64(1700000000000), UnixMilli(mock))
+	re.Equal(time.UTC, mock.Now().Location())
+}
+
+func TestRealClock(t *testing.T) {
+	re := require.New(t)
+
+	c := NewRealClock()
+	re.Equal(time.UTC, c.Now().Location())
+	re.InDelta(time.Now().UnixMilli(), int64(UnixMilli(c)), float64(time.Minute.Milliseconds()))
+}
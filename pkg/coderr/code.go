@@ -26,14 +26,20 @@ const (
 	InvalidParams          = http.StatusBadRequest
 	BadRequest             = http.StatusBadRequest
 	NotFound               = http.StatusNotFound
+	Unauthorized           = http.StatusUnauthorized
+	Forbidden              = http.StatusForbidden
+	Conflict               = http.StatusConflict
 	TooManyRequests        = http.StatusTooManyRequests
 	Internal               = http.StatusInternalServerError
 	ErrNotImplemented      = http.StatusNotImplemented
+	Unavailable            = http.StatusServiceUnavailable
 
 	// HTTPCodeUpperBound is a bound under which any Code should have the same meaning with the http status code.
 	HTTPCodeUpperBound   = Code(1000)
 	PrintHelpUsage       = 1001
 	ClusterAlreadyExists = 1002
+	// HeartbeatFullReportRequired asks the node to report all its shards in the next heartbeat.
+	HeartbeatFullReportRequired = 1003
 )
 
 // ToHTTPCode converts the Code to http code.
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package failpoint injects the errors, the delays or the crashes at the named points of the code, which is used to test
+// the recovery from the partial failures. The failpoints are evaluated only if they are enabled for the process, and
+// evaluating a point without any action costs an atomic load.
+package failpoint
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"go.uber.org/zap"
+)
+
+// The kinds of the actions taken at the failpoints.
+const (
+	// ActionError makes the point return ErrInjected.
+	ActionError = "error"
+	// ActionDelay makes the point sleep for the delay, or until the context is done.
+	ActionDelay = "delay"
+	// ActionPanic makes the point panic, which crashes the process unless the panic is recovered.
+	ActionPanic = "panic"
+)
+
+var (
+	ErrInjected      = coderr.NewCodeError(coderr.Internal, "failpoint injected")
+	ErrDisabled      = coderr.NewCodeError(coderr.BadRequest, "failpoints are disabled")
+	ErrInvalidAction = coderr.NewCodeError(coderr.InvalidParams, "invalid failpoint action")
+)
+
+// Action is taken when the failpoint is evaluated.
+type Action struct {
+	Kind string `json:"kind"`
+	// Message is carried by the injected error or panic.
+	Message string `json:"message"`
+	DelayMs uint64 `json:"delayMs"`
+	// Count bounds the evaluations taking the action, and 0 means unlimited. The failpoint is removed once the count is
+	// exhausted.
+	Count uint64 `json:"count"`
+}
+
+// Status describes an active failpoint.
+type Status struct {
+	Name   string `json:"name"`
+	Action Action `json:"action"`
+	// Hits is the number of the evaluations taking the action.
+	Hits uint64 `json:"hits"`
+}
+
+func (a Action) validate() error {
+	switch a.Kind {
+	case ActionError, ActionPanic:
+		return nil
+	case ActionDelay:
+		if a.DelayMs == 0 {
+			return ErrInvalidAction.WithCausef("delay should be positive")
+		}
+		return nil
+	default:
+		return ErrInvalidAction.WithCausef("unknown kind:%s", a.Kind)
+	}
+}
+
+type registry struct {
+	enabled atomic.Bool
+	// active is the number of the failpoints, which makes the evaluation lock-free when there is none.
+	active atomic.Int64
+
+	lock   sync.Mutex
+	points map[string]*Status
+}
+
+var globalRegistry = &registry{
+	enabled: atomic.Bool{},
+	active:  atomic.Int64{},
+	lock:    sync.Mutex{},
+	points:  make(map[string]*Status),
+}
+
+// SetEnabled enables or disables the failpoints for the process, and all the failpoints are removed once disabled.
+func SetEnabled(enabled bool) {
+	globalRegistry.lock.Lock()
+	defer globalRegistry.lock.Unlock()
+
+	globalRegistry.enabled.Store(enabled)
+	if !enabled {
+		globalRegistry.points = make(map[string]*Status)
+		globalRegistry.active.Store(0)
+	}
+}
+
+func Enabled() bool {
+	return globalRegistry.enabled.Load()
+}
+
+// Enable sets the action of the failpoint, which replaces the existing one.
+func Enable(name string, action Action) error {
+	if len(name) == 0 {
+		return ErrInvalidAction.WithCausef("empty failpoint name")
+	}
+	if err := action.validate(); err != nil {
+		return err
+	}
+
+	globalRegistry.lock.Lock()
+	defer globalRegistry.lock.Unlock()
+
+	if !globalRegistry.enabled.Load() {
+		return ErrDisabled.WithCausef("enable failpoint, name:%s", name)
+	}
+	globalRegistry.points[name] = &Status{Name: name, Action: action, Hits: 0}
+	globalRegistry.active.Store(int64(len(globalRegistry.points)))
+	log.Warn("enable failpoint", zap.String("name", name), zap.String("kind", action.Kind), zap.Uint64("count", action.Count))
+	return nil
+}
+
+// Disable removes the failpoint, and the second output parameter bool: returns false if the failpoint doesn't exist.
+func Disable(name string) bool {
+	globalRegistry.lock.Lock()
+	defer globalRegistry.lock.Unlock()
+
+	if _, ok := globalRegistry.points[name]; !ok {
+		return false
+	}
+	delete(globalRegistry.points, name)
+	globalRegistry.active.Store(int64(len(globalRegistry.points)))
+	log.Warn("disable failpoint", zap.String("name", name))
+	return true
+}
+
+// List returns the active failpoints sorted by the name.
+func List() []Status {
+	globalRegistry.lock.Lock()
+	defer globalRegistry.lock.Unlock()
+
+	statuses := make([]Status, 0, len(globalRegistry.points))
+	for _, status := range globalRegistry.points {
+		statuses = append(statuses, *status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Inject evaluates the failpoint, and takes its action if it is active.
+func Inject(ctx context.Context, name string) error {
+	if globalRegistry.active.Load() == 0 {
+		return nil
+	}
+	action, ok := globalRegistry.hit(name)
+	if !ok {
+		return nil
+	}
+
+	log.Warn("failpoint is hit", zap.String("name", name), zap.String("kind", action.Kind))
+	switch action.Kind {
+	case ActionError:
+		return ErrInjected.WithCausef("name:%s, message:%s", name, action.Message)
+	case ActionDelay:
+		timer := time.NewTimer(time.Duration(action.DelayMs) * time.Millisecond)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+		}
+		return nil
+	case ActionPanic:
+		panic(fmt.Sprintf("failpoint injected, name:%s, message:%s", name, action.Message))
+	}
+	return nil
+}
+
+// hit counts the evaluation of the failpoint, and removes the failpoint if its count is exhausted.
+func (r *registry) hit(name string) (Action, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	status, ok := r.points[name]
+	if !ok {
+		return Action{}, false
+	}
+	status.Hits++
+	if status.Action.Count > 0 && status.Hits >= status.Action.Count {
+		delete(r.points, name)
+		r.active.Store(int64(len(r.points)))
+	}
+	return status.Action, true
+}
@@ -0,0 +1,69 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package failpoint
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailpoint(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	defer SetEnabled(false)
+
+	// The failpoints can't be enabled until they are enabled for the process.
+	re.True(coderr.Is(Enable("test/error", Action{Kind: ActionError, Message: "", DelayMs: 0, Count: 0}), ErrDisabled.Code()))
+	SetEnabled(true)
+	re.True(coderr.Is(Enable("test/error", Action{Kind: "crash", Message: "", DelayMs: 0, Count: 0}), ErrInvalidAction.Code()))
+	re.True(coderr.Is(Enable("test/delay", Action{Kind: ActionDelay, Message: "", DelayMs: 0, Count: 0}), ErrInvalidAction.Code()))
+	re.NoError(Inject(ctx, "test/error"))
+
+	// The error is injected until the count is exhausted.
+	re.NoError(Enable("test/error", Action{Kind: ActionError, Message: "boom", DelayMs: 0, Count: 2}))
+	err := Inject(ctx, "test/error")
+	re.True(coderr.Is(err, ErrInjected.Code()))
+	re.True(coderr.Is(err, coderr.Internal))
+	re.Equal([]Status{{Name: "test/error", Action: Action{Kind: ActionError, Message: "boom", DelayMs: 0, Count: 2}, Hits: 1}}, List())
+	re.True(coderr.Is(Inject(ctx, "test/error"), ErrInjected.Code()))
+	re.NoError(Inject(ctx, "test/error"))
+	re.Empty(List())
+
+	// The delay is cut off by the context.
+	re.NoError(Enable("test/delay", Action{Kind: ActionDelay, Message: "", DelayMs: 60000, Count: 0}))
+	timeoutCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	re.NoError(Inject(timeoutCtx, "test/delay"))
+	re.Less(time.Since(start), time.Minute)
+	re.True(Disable("test/delay"))
+	re.False(Disable("test/delay"))
+
+	re.NoError(Enable("test/panic", Action{Kind: ActionPanic, Message: "crash", DelayMs: 0, Count: 0}))
+	re.Panics(func() {
+		_ = Inject(ctx, "test/panic")
+	})
+
+	// All the failpoints are removed once disabled for the process.
+	SetEnabled(false)
+	re.Empty(List())
+	re.NoError(Inject(ctx, "test/panic"))
+}
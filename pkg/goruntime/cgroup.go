@@ -0,0 +1,132 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package goruntime
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// The cgroup of the process is assumed to be mounted at the cgroup root, which holds for the containers running in their
+// own cgroup namespaces.
+const (
+	defaultCgroupRoot = "/sys/fs/cgroup"
+
+	cgroupV2CPUMaxFile      = "cpu.max"
+	cgroupV2MemoryMaxFile   = "memory.max"
+	cgroupV1CPUQuotaFile    = "cpu/cpu.cfs_quota_us"
+	cgroupV1CPUPeriodFile   = "cpu/cpu.cfs_period_us"
+	cgroupV1MemoryLimitFile = "memory/memory.limit_in_bytes"
+
+	cgroupUnlimited = "max"
+	// cgroupV1MemoryUnlimited is the lower bound of the values written by cgroup v1 when the memory is not limited, which
+	// is the max int64 rounded down to the page size.
+	cgroupV1MemoryUnlimited = math.MaxInt64 / 4096 * 4096
+)
+
+// readCgroupFile returns the trimmed content of the file, the second output parameter bool: returns true if the file exists.
+func readCgroupFile(root, name string) (string, bool, error) {
+	content, err := os.ReadFile(filepath.Join(root, name))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, errors.WithMessagef(err, "read cgroup file:%s", name)
+	}
+	return strings.TrimSpace(string(content)), true, nil
+}
+
+// cpuQuota returns the number of cpus the cgroup is allowed to use, the second output parameter bool: returns true if the
+// cpu of the cgroup is limited.
+func cpuQuota(root string) (float64, bool, error) {
+	content, exists, err := readCgroupFile(root, cgroupV2CPUMaxFile)
+	if err != nil {
+		return 0, false, err
+	}
+	if exists {
+		// The content is "$MAX $PERIOD", and $MAX is "max" if the cpu is not limited.
+		fields := strings.Fields(content)
+		if len(fields) != 2 {
+			return 0, false, errors.Errorf("invalid cgroup cpu.max:%s", content)
+		}
+		if fields[0] == cgroupUnlimited {
+			return 0, false, nil
+		}
+		return parseCPUQuota(fields[0], fields[1])
+	}
+
+	quota, exists, err := readCgroupFile(root, cgroupV1CPUQuotaFile)
+	if err != nil || !exists {
+		return 0, false, err
+	}
+	period, exists, err := readCgroupFile(root, cgroupV1CPUPeriodFile)
+	if err != nil || !exists {
+		return 0, false, err
+	}
+	return parseCPUQuota(quota, period)
+}
+
+func parseCPUQuota(quota, period string) (float64, bool, error) {
+	q, err := strconv.ParseInt(quota, 10, 64)
+	if err != nil {
+		return 0, false, errors.WithMessagef(err, "parse cpu quota:%s", quota)
+	}
+	// The quota of cgroup v1 is -1 if the cpu is not limited.
+	if q <= 0 {
+		return 0, false, nil
+	}
+	p, err := strconv.ParseInt(period, 10, 64)
+	if err != nil {
+		return 0, false, errors.WithMessagef(err, "parse cpu period:%s", period)
+	}
+	if p <= 0 {
+		return 0, false, errors.Errorf("invalid cpu period:%d", p)
+	}
+	return float64(q) / float64(p), true, nil
+}
+
+// memoryLimit returns the memory limit of the cgroup in bytes, the second output parameter bool: returns true if the
+// memory of the cgroup is limited.
+func memoryLimit(root string) (int64, bool, error) {
+	content, exists, err := readCgroupFile(root, cgroupV2MemoryMaxFile)
+	if err != nil {
+		return 0, false, err
+	}
+	if exists && content == cgroupUnlimited {
+		return 0, false, nil
+	}
+	if !exists {
+		content, exists, err = readCgroupFile(root, cgroupV1MemoryLimitFile)
+		if err != nil || !exists {
+			return 0, false, err
+		}
+	}
+
+	limit, err := strconv.ParseInt(content, 10, 64)
+	if err != nil {
+		return 0, false, errors.WithMessagef(err, "parse memory limit:%s", content)
+	}
+	if limit <= 0 || limit >= cgroupV1MemoryUnlimited {
+		return 0, false, nil
+	}
+	return limit, true, nil
+}
@@ -0,0 +1,107 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package goruntime
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writeCgroupFiles(t *testing.T, files map[string]string) string {
+	root := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content+"\n"), 0o600))
+	}
+	return root
+}
+
+func TestCPUQuota(t *testing.T) {
+	re := require.New(t)
+
+	root := writeCgroupFiles(t, map[string]string{cgroupV2CPUMaxFile: "150000 100000"})
+	quota, limited, err := cpuQuota(root)
+	re.NoError(err)
+	re.True(limited)
+	re.Equal(1.5, quota)
+
+	root = writeCgroupFiles(t, map[string]string{cgroupV2CPUMaxFile: "max 100000"})
+	_, limited, err = cpuQuota(root)
+	re.NoError(err)
+	re.False(limited)
+
+	root = writeCgroupFiles(t, map[string]string{cgroupV1CPUQuotaFile: "200000", cgroupV1CPUPeriodFile: "100000"})
+	quota, limited, err = cpuQuota(root)
+	re.NoError(err)
+	re.True(limited)
+	re.Equal(2.0, quota)
+
+	root = writeCgroupFiles(t, map[string]string{cgroupV1CPUQuotaFile: "-1", cgroupV1CPUPeriodFile: "100000"})
+	_, limited, err = cpuQuota(root)
+	re.NoError(err)
+	re.False(limited)
+
+	// No cgroup files at all.
+	_, limited, err = cpuQuota(t.TempDir())
+	re.NoError(err)
+	re.False(limited)
+
+	root = writeCgroupFiles(t, map[string]string{cgroupV2CPUMaxFile: "invalid"})
+	_, _, err = cpuQuota(root)
+	re.Error(err)
+}
+
+func TestMemoryLimit(t *testing.T) {
+	re := require.New(t)
+
+	root := writeCgroupFiles(t, map[string]string{cgroupV2MemoryMaxFile: "1073741824"})
+	limit, limited, err := memoryLimit(root)
+	re.NoError(err)
+	re.True(limited)
+	re.Equal(int64(1073741824), limit)
+
+	root = writeCgroupFiles(t, map[string]string{cgroupV2MemoryMaxFile: "max"})
+	_, limited, err = memoryLimit(root)
+	re.NoError(err)
+	re.False(limited)
+
+	root = writeCgroupFiles(t, map[string]string{cgroupV1MemoryLimitFile: "9223372036854771712"})
+	_, limited, err = memoryLimit(root)
+	re.NoError(err)
+	re.False(limited)
+
+	root = writeCgroupFiles(t, map[string]string{cgroupV1MemoryLimitFile: "536870912"})
+	cfg := Config{
+		AutoMaxProcs:     DefaultAutoMaxProcs,
+		GCPercent:        DefaultGCPercent,
+		MemoryLimitBytes: DefaultMemoryLimitBytes,
+		MemoryLimitRatio: 0.5,
+	}
+	limit, ok := memoryLimitByConfig(cfg, root)
+	re.True(ok)
+	re.Equal(int64(268435456), limit)
+
+	// MemoryLimitBytes takes precedence over the ratio.
+	cfg.MemoryLimitBytes = 1024
+	limit, ok = memoryLimitByConfig(cfg, root)
+	re.True(ok)
+	re.Equal(int64(1024), limit)
+}
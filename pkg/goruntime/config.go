@@ -0,0 +1,36 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package goruntime
+
+const (
+	DefaultAutoMaxProcs     = true
+	DefaultGCPercent        = 0
+	DefaultMemoryLimitBytes = 0
+	DefaultMemoryLimitRatio = 0
+)
+
+type Config struct {
+	// AutoMaxProcs sets GOMAXPROCS by the cpu quota of the container, unless GOMAXPROCS is set in the environment.
+	AutoMaxProcs bool `toml:"auto-max-procs" env:"RUNTIME_AUTO_MAX_PROCS"`
+	// GCPercent overrides GOGC if it is not 0, and a negative value turns off the GC until the memory limit is reached.
+	GCPercent int `toml:"gc-percent" env:"RUNTIME_GC_PERCENT"`
+	// MemoryLimitBytes overrides GOMEMLIMIT if it is not 0.
+	MemoryLimitBytes int64 `toml:"memory-limit-bytes" env:"RUNTIME_MEMORY_LIMIT_BYTES"`
+	// MemoryLimitRatio derives the memory limit from the memory limit of the container if it is in (0, 1], and it only
+	// takes effect when neither MemoryLimitBytes nor GOMEMLIMIT is set.
+	MemoryLimitRatio float64 `toml:"memory-limit-ratio" env:"RUNTIME_MEMORY_LIMIT_RATIO"`
+}
@@ -0,0 +1,126 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package goruntime
+
+import (
+	"math"
+	"runtime/metrics"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	gcPercentMetric   = "/gc/gogc:percent"
+	memoryLimitMetric = "/gc/gomemlimit:bytes"
+	maxProcsMetric    = "/sched/gomaxprocs:threads"
+	gcPausesMetric    = "/gc/pauses:seconds"
+	heapGoalMetric    = "/gc/heap/goal:bytes"
+	heapLiveMetric    = "/gc/heap/live:bytes"
+	gcCyclesMetric    = "/gc/cycles/total:gc-cycles"
+)
+
+// Collector exports the runtime settings and the GC stats, which complements the go collector registered by default.
+type Collector struct {
+	samples []metrics.Sample
+
+	gcPercent   *prometheus.Desc
+	memoryLimit *prometheus.Desc
+	maxProcs    *prometheus.Desc
+	gcPauses    *prometheus.Desc
+	heapGoal    *prometheus.Desc
+	heapLive    *prometheus.Desc
+	gcCycles    *prometheus.Desc
+}
+
+func NewCollector() *Collector {
+	names := []string{gcPercentMetric, memoryLimitMetric, maxProcsMetric, gcPausesMetric, heapGoalMetric, heapLiveMetric, gcCyclesMetric}
+	samples := make([]metrics.Sample, 0, len(names))
+	for _, name := range names {
+		samples = append(samples, metrics.Sample{Name: name, Value: metrics.Value{}})
+	}
+
+	return &Collector{
+		samples:     samples,
+		gcPercent:   prometheus.NewDesc("horaemeta_runtime_gc_percent", "The GC percent of the go runtime.", nil, nil),
+		memoryLimit: prometheus.NewDesc("horaemeta_runtime_memory_limit_bytes", "The memory limit of the go runtime.", nil, nil),
+		maxProcs:    prometheus.NewDesc("horaemeta_runtime_max_procs", "The GOMAXPROCS of the go runtime.", nil, nil),
+		gcPauses:    prometheus.NewDesc("horaemeta_runtime_gc_pauses_seconds", "The distribution of the stop-the-world pauses of the GC.", nil, nil),
+		heapGoal:    prometheus.NewDesc("horaemeta_runtime_heap_goal_bytes", "The heap size target of the end of the GC cycle.", nil, nil),
+		heapLive:    prometheus.NewDesc("horaemeta_runtime_heap_live_bytes", "The heap memory occupied by the live objects marked by the last GC.", nil, nil),
+		gcCycles:    prometheus.NewDesc("horaemeta_runtime_gc_cycles_total", "The number of the completed GC cycles.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.gcPercent
+	ch <- c.memoryLimit
+	ch <- c.maxProcs
+	ch <- c.gcPauses
+	ch <- c.heapGoal
+	ch <- c.heapLive
+	ch <- c.gcCycles
+}
+
+// Collect implements prometheus.Collector.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	// The samples are read into a copy since the collection may run concurrently.
+	samples := make([]metrics.Sample, len(c.samples))
+	copy(samples, c.samples)
+	metrics.Read(samples)
+
+	for _, sample := range samples {
+		switch sample.Name {
+		case gcPercentMetric:
+			ch <- prometheus.MustNewConstMetric(c.gcPercent, prometheus.GaugeValue, float64(sample.Value.Uint64()))
+		case memoryLimitMetric:
+			ch <- prometheus.MustNewConstMetric(c.memoryLimit, prometheus.GaugeValue, float64(sample.Value.Uint64()))
+		case maxProcsMetric:
+			ch <- prometheus.MustNewConstMetric(c.maxProcs, prometheus.GaugeValue, float64(sample.Value.Uint64()))
+		case gcPausesMetric:
+			count, sum, buckets := convertHistogram(sample.Value.Float64Histogram())
+			ch <- prometheus.MustNewConstHistogram(c.gcPauses, count, sum, buckets)
+		case heapGoalMetric:
+			ch <- prometheus.MustNewConstMetric(c.heapGoal, prometheus.GaugeValue, float64(sample.Value.Uint64()))
+		case heapLiveMetric:
+			ch <- prometheus.MustNewConstMetric(c.heapLive, prometheus.GaugeValue, float64(sample.Value.Uint64()))
+		case gcCyclesMetric:
+			ch <- prometheus.MustNewConstMetric(c.gcCycles, prometheus.CounterValue, float64(sample.Value.Uint64()))
+		}
+	}
+}
+
+// convertHistogram converts the runtime histogram into the cumulative buckets keyed by the upper bounds. The sum is
+// estimated by the lower bounds of the buckets since the runtime does not record it.
+func convertHistogram(h *metrics.Float64Histogram) (uint64, float64, map[float64]uint64) {
+	buckets := make(map[float64]uint64, len(h.Counts))
+	count := uint64(0)
+	sum := float64(0)
+	for i, n := range h.Counts {
+		count += n
+		// The first lower bound may be -Inf, which is not counted in the sum.
+		if n > 0 && h.Buckets[i] > 0 {
+			sum += float64(n) * h.Buckets[i]
+		}
+		// The last upper bound may be +Inf, which is implied by the count of the histogram.
+		upperBound := h.Buckets[i+1]
+		if !math.IsInf(upperBound, 1) {
+			buckets[upperBound] = count
+		}
+	}
+	return count, sum, buckets
+}
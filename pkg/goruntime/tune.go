@@ -0,0 +1,107 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package goruntime
+
+import (
+	"math"
+	"os"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"go.uber.org/zap"
+)
+
+const (
+	maxProcsEnv    = "GOMAXPROCS"
+	memoryLimitEnv = "GOMEMLIMIT"
+)
+
+// Apply tunes the go runtime by the config and the limits of the container. The failure to read the limits of the
+// container is only logged, since the runtime works with its defaults anyway.
+func Apply(cfg Config) {
+	applyWithCgroupRoot(cfg, defaultCgroupRoot)
+}
+
+func applyWithCgroupRoot(cfg Config, cgroupRoot string) {
+	if cfg.AutoMaxProcs {
+		if maxProcs, ok := maxProcsByCPUQuota(cgroupRoot); ok {
+			prev := runtime.GOMAXPROCS(maxProcs)
+			log.Info("set GOMAXPROCS by cpu quota", zap.Int("maxProcs", maxProcs), zap.Int("prevMaxProcs", prev))
+		}
+	}
+
+	if cfg.GCPercent != 0 {
+		prev := debug.SetGCPercent(cfg.GCPercent)
+		log.Info("set GC percent", zap.Int("gcPercent", cfg.GCPercent), zap.Int("prevGCPercent", prev))
+	}
+
+	if limit, ok := memoryLimitByConfig(cfg, cgroupRoot); ok {
+		prev := debug.SetMemoryLimit(limit)
+		log.Info("set memory limit", zap.Int64("memoryLimitBytes", limit), zap.Int64("prevMemoryLimitBytes", prev))
+	}
+}
+
+// maxProcsByCPUQuota rounds the cpu quota down to decide GOMAXPROCS, the second output parameter bool: returns true if
+// GOMAXPROCS should be set.
+func maxProcsByCPUQuota(cgroupRoot string) (int, bool) {
+	if _, exists := os.LookupEnv(maxProcsEnv); exists {
+		return 0, false
+	}
+
+	quota, limited, err := cpuQuota(cgroupRoot)
+	if err != nil {
+		log.Warn("get cpu quota failed, keep GOMAXPROCS", zap.Error(err))
+		return 0, false
+	}
+	if !limited {
+		return 0, false
+	}
+
+	maxProcs := int(math.Floor(quota))
+	if maxProcs < 1 {
+		maxProcs = 1
+	}
+	if maxProcs >= runtime.NumCPU() {
+		return 0, false
+	}
+	return maxProcs, true
+}
+
+// memoryLimitByConfig decides the memory limit, the second output parameter bool: returns true if the memory limit
+// should be set.
+func memoryLimitByConfig(cfg Config, cgroupRoot string) (int64, bool) {
+	if cfg.MemoryLimitBytes != 0 {
+		return cfg.MemoryLimitBytes, true
+	}
+	if cfg.MemoryLimitRatio <= 0 || cfg.MemoryLimitRatio > 1 {
+		return 0, false
+	}
+	if _, exists := os.LookupEnv(memoryLimitEnv); exists {
+		return 0, false
+	}
+
+	limit, limited, err := memoryLimit(cgroupRoot)
+	if err != nil {
+		log.Warn("get memory limit of container failed, keep memory limit", zap.Error(err))
+		return 0, false
+	}
+	if !limited {
+		return 0, false
+	}
+	return int64(float64(limit) * cfg.MemoryLimitRatio), true
+}
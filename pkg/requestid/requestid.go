@@ -0,0 +1,99 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+// Package requestid identifies the requests served by the http and the grpc services, so that a failure reported by the
+// users can be correlated with the logs and the procedures.
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// Header is the http header carrying the request id, which is set on both the requests and the responses.
+	Header = "X-Request-ID"
+	// MetadataKey is the grpc metadata key carrying the request id.
+	MetadataKey = "x-request-id"
+
+	// maxLen bounds the request id given by the clients.
+	maxLen = 128
+)
+
+type contextKey struct{}
+
+// New generates a random request id.
+func New() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// The crypto random source never fails on the supported platforms.
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Resolve honors the request id given by the client if it is valid, otherwise a new one is generated.
+func Resolve(id string) string {
+	if isValid(id) {
+		return id
+	}
+	return New()
+}
+
+// isValid accepts the non-empty id consisting of the letters, the digits and `-_.:`, which is safe to be logged and sent
+// back in the headers.
+func isValid(id string) bool {
+	if len(id) == 0 || len(id) > maxLen {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-' || c == '_' || c == '.' || c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func WithID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request id carried by the context, which is empty if the context doesn't belong to a request.
+func FromContext(ctx context.Context) string {
+	if id, ok := ctx.Value(contextKey{}).(string); ok {
+		return id
+	}
+	return ""
+}
+
+// Field is the log field of the request id carried by the context, which is skipped if there is none.
+func Field(ctx context.Context) zap.Field {
+	return FieldOf(FromContext(ctx))
+}
+
+// FieldOf is the log field of the request id, which is skipped if it is empty.
+func FieldOf(id string) zap.Field {
+	if len(id) == 0 {
+		return zap.Skip()
+	}
+	return zap.String("requestID", id)
+}
@@ -0,0 +1,54 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package requestid
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolve(t *testing.T) {
+	re := require.New(t)
+
+	re.Len(New(), 32)
+	re.NotEqual(New(), New())
+
+	// The valid id given by the client is honored.
+	re.Equal("req-1_a.b:c", Resolve("req-1_a.b:c"))
+	// The invalid ones are replaced.
+	for _, id := range []string{"", "a b", "a\nb", strings.Repeat("a", maxLen+1)} {
+		resolved := Resolve(id)
+		re.NotEqual(id, resolved)
+		re.True(isValid(resolved))
+	}
+}
+
+func TestContext(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	re.Empty(FromContext(ctx))
+	re.Equal("", FieldOf(FromContext(ctx)).Key)
+
+	ctx = WithID(ctx, "req")
+	re.Equal("req", FromContext(ctx))
+	re.Equal("requestID", Field(ctx).Key)
+	re.Equal("req", Field(ctx).String)
+}
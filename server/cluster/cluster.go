@@ -19,13 +19,16 @@ package cluster
 import (
 	"context"
 	"strings"
+	"time"
 
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator"
 	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
 	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/manager"
+	"github.com/CeresDB/horaemeta/server/event"
 	"github.com/CeresDB/horaemeta/server/id"
+	"github.com/CeresDB/horaemeta/server/lifecycle"
 	"github.com/CeresDB/horaemeta/server/storage"
 	"github.com/pkg/errors"
 	clientv3 "go.etcd.io/etcd/client/v3"
@@ -33,21 +36,35 @@ import (
 )
 
 const (
-	defaultProcedurePrefixKey = "ProcedureID"
-	defaultAllocStep          = 50
+	defaultProcedurePrefixKey    = "ProcedureID"
+	defaultShardCommandPrefixKey = "ShardCommandID"
+	shardCommandIDAllocStep      = 100
+
+	procedureManagerStopTimeout = time.Second * 10
+	schedulerManagerStopTimeout = time.Second * 5
+	dispatchOutboxStopTimeout   = time.Second * 5
+	procedureGCStopTimeout      = time.Second * 5
+	eventBusStopTimeout         = time.Second * 5
+	tableStatsStopTimeout       = time.Second * 5
 )
 
 type Cluster struct {
 	logger   *zap.Logger
 	metadata *metadata.ClusterMetadata
 
-	procedureFactory *coordinator.Factory
-	procedureManager procedure.Manager
-	schedulerManager manager.SchedulerManager
+	piggybackDispatch *eventdispatch.PiggybackDispatch
+	procedureFactory  *coordinator.Factory
+	procedureManager  procedure.Manager
+	procedureStorage  procedure.Storage
+	schedulerManager  manager.SchedulerManager
+	eventBus          *event.Bus
+	tableStats        *TableStatsCollector
+	// lifecycle stops the scheduler manager before the procedure manager, so no procedure is submitted to the stopped one.
+	lifecycle *lifecycle.Manager
 }
 
-func NewCluster(logger *zap.Logger, metadata *metadata.ClusterMetadata, client *clientv3.Client, rootPath string) (*Cluster, error) {
-	procedureStorage := procedure.NewEtcdStorageImpl(client, rootPath, uint32(metadata.GetClusterID()))
+func NewCluster(logger *zap.Logger, metadata *metadata.ClusterMetadata, client *clientv3.Client, rootPath string, procedureIDAllocatorOpts id.AllocatorOptions, procedureGCOpts procedure.GCOptions, dependencyResolver coordinator.DependencyResolver, eventOpts event.BusOptions) (*Cluster, error) {
+	procedureStorage := procedure.NewEtcdStorageImpl(client, rootPath, uint32(metadata.GetClusterID()), metadata.Clock())
 	procedureManager, err := procedure.NewManagerImpl(logger, metadata)
 	if err != nil {
 		return nil, errors.WithMessage(err, "create procedure manager")
@@ -55,35 +72,156 @@ func NewCluster(logger *zap.Logger, metadata *metadata.ClusterMetadata, client *
 	dispatch := eventdispatch.NewDispatchImpl()
 
 	procedureIDRootPath := strings.Join([]string{rootPath, metadata.Name(), defaultProcedurePrefixKey}, "/")
-	procedureFactory := coordinator.NewFactory(logger, id.NewAllocatorImpl(logger, client, procedureIDRootPath, defaultAllocStep), dispatch, procedureStorage)
+	deps := dependencyResolver.Resolve(metadata.Name(), coordinator.Dependencies{
+		IDAllocator:   id.NewAllocatorImplWithOptions(logger, client, procedureIDRootPath, procedureIDAllocatorOpts),
+		Dispatch:      dispatch,
+		Storage:       procedureStorage,
+		ShardPicker:   coordinator.NewLeastTableShardPicker(),
+		AdmissionHook: coordinator.NewNoopAdmissionHook(),
+		Clock:         metadata.Clock(),
+	})
+	// The failures injected by the failpoints are taken as the ones of the nodes.
+	failpointDispatch := eventdispatch.NewFailpointDispatch(deps.Dispatch)
+	// The operations to the unreachable nodes are retried instead of failing the procedures at once.
+	retryDispatch := eventdispatch.NewRetryDispatch(logger, failpointDispatch, client, rootPath, metadata.GetClusterID(), metadata.Clock(), eventdispatch.DefaultRetryConfig(), func(ctx context.Context, procedureID uint64) (bool, error) {
+		return procedure.IsLive(ctx, procedureStorage, procedureID)
+	})
+	// The shard operations of the nodes the leader can't dial are piggybacked on their heartbeats.
+	// The ids of the piggybacked commands are allocated from etcd, so the commands of the new leader are never taken as the
+	// ones applied by the nodes.
+	shardCommandIDRootPath := strings.Join([]string{rootPath, metadata.Name(), defaultShardCommandPrefixKey}, "/")
+	shardCommandIDAllocator := id.NewAllocatorImplWithOptions(logger, client, shardCommandIDRootPath, id.AllocatorOptions{Step: shardCommandIDAllocStep, Preallocate: false})
+	piggybackDispatch := eventdispatch.NewPiggybackDispatch(retryDispatch, shardCommandIDAllocator, metadata.Clock(), eventdispatch.DefaultPiggybackConfig())
+	// The resolved dispatch is throttled as well, so that the shard operations of the procedures are always limited per node.
+	throttledDispatch := eventdispatch.NewThrottledDispatch(piggybackDispatch)
+	deps.Dispatch = throttledDispatch
+	procedureFactory := coordinator.NewFactory(logger, deps)
+
+	tableStats := NewTableStatsCollector(logger, client, rootPath, metadata.Name(), metadata.Clock())
+	schedulerManager := manager.NewManager(logger, procedureManager, procedureFactory, metadata, client, rootPath, metadata.GetTopologyType(), metadata.GetProcedureExecutingBatchSize(), throttledDispatch, tableStats)
 
-	schedulerManager := manager.NewManager(logger, procedureManager, procedureFactory, metadata, client, rootPath, metadata.GetTopologyType(), metadata.GetProcedureExecutingBatchSize())
+	clusterLifecycle := lifecycle.NewManager(logger)
+	if err := clusterLifecycle.Register(lifecycle.Component{
+		Name:        "dispatchOutbox",
+		DependsOn:   nil,
+		Start:       retryDispatch.Start,
+		Stop:        retryDispatch.Stop,
+		StopTimeout: dispatchOutboxStopTimeout,
+	}); err != nil {
+		return nil, err
+	}
+	if err := clusterLifecycle.Register(lifecycle.Component{
+		Name:        "procedureManager",
+		DependsOn:   nil,
+		Start:       procedureManager.Start,
+		Stop:        procedureManager.Stop,
+		StopTimeout: procedureManagerStopTimeout,
+	}); err != nil {
+		return nil, err
+	}
+	// The create partition table procedures left unfinished by the previous leader are resumed once the procedure
+	// manager is started, so that their sub tables are either all created or all rolled back.
+	if err := clusterLifecycle.Register(lifecycle.Component{
+		Name:      "procedureResume",
+		DependsOn: []string{"procedureManager"},
+		Start: func(ctx context.Context) error {
+			procedures, err := procedureFactory.ResumeCreatePartitionTableProcedures(ctx, metadata)
+			if err != nil {
+				return errors.WithMessage(err, "resume create partition table procedures")
+			}
+			for _, p := range procedures {
+				if err := procedureManager.Submit(ctx, p); err != nil {
+					return errors.WithMessagef(err, "submit resumed procedure, procedureID:%d", p.ID())
+				}
+			}
+			return nil
+		},
+		Stop:        nil,
+		StopTimeout: 0,
+	}); err != nil {
+		return nil, err
+	}
+	procedureGCWorker := procedure.NewGCWorker(logger, procedureStorage, metadata.Name(), metadata.Clock(), procedureGCOpts)
+	if err := clusterLifecycle.Register(lifecycle.Component{
+		Name:        "procedureGC",
+		DependsOn:   nil,
+		Start:       procedureGCWorker.Start,
+		Stop:        procedureGCWorker.Stop,
+		StopTimeout: procedureGCStopTimeout,
+	}); err != nil {
+		return nil, err
+	}
+	if err := clusterLifecycle.Register(lifecycle.Component{
+		Name:        "schedulerManager",
+		DependsOn:   []string{"procedureManager"},
+		Start:       schedulerManager.Start,
+		Stop:        schedulerManager.Stop,
+		StopTimeout: schedulerManagerStopTimeout,
+	}); err != nil {
+		return nil, err
+	}
+	// The changes of the cluster are only published while the cluster is served by the leader.
+	eventBus := event.NewBus(logger, metadata.Name(), metadata.Clock(), eventOpts)
+	if err := clusterLifecycle.Register(lifecycle.Component{
+		Name:      "eventBus",
+		DependsOn: nil,
+		Start: func(ctx context.Context) error {
+			metadata.SetEventPublisher(eventBus)
+			return eventBus.Start(ctx)
+		},
+		Stop: func(ctx context.Context) error {
+			metadata.SetEventPublisher(event.NewNoopPublisher())
+			return eventBus.Stop(ctx)
+		},
+		StopTimeout: eventBusStopTimeout,
+	}); err != nil {
+		return nil, err
+	}
+	nodeWatcher := newNodeWatcher(metadata, eventBus)
+	if err := clusterLifecycle.Register(lifecycle.Component{
+		Name:        "nodeWatcher",
+		DependsOn:   []string{"eventBus"},
+		Start:       nodeWatcher.Start,
+		Stop:        nodeWatcher.Stop,
+		StopTimeout: 0,
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := clusterLifecycle.Register(lifecycle.Component{
+		Name:        "tableStats",
+		DependsOn:   nil,
+		Start:       tableStats.Start,
+		Stop:        tableStats.Stop,
+		StopTimeout: tableStatsStopTimeout,
+	}); err != nil {
+		return nil, err
+	}
 
 	return &Cluster{
-		logger:           logger,
-		metadata:         metadata,
-		procedureFactory: procedureFactory,
-		procedureManager: procedureManager,
-		schedulerManager: schedulerManager,
+		logger:            logger,
+		metadata:          metadata,
+		piggybackDispatch: piggybackDispatch,
+		procedureFactory:  procedureFactory,
+		procedureManager:  procedureManager,
+		procedureStorage:  procedureStorage,
+		schedulerManager:  schedulerManager,
+		eventBus:          eventBus,
+		tableStats:        tableStats,
+		lifecycle:         clusterLifecycle,
 	}, nil
 }
 
 func (c *Cluster) Start(ctx context.Context) error {
-	if err := c.procedureManager.Start(ctx); err != nil {
-		return errors.WithMessage(err, "start procedure manager")
-	}
-	if err := c.schedulerManager.Start(ctx); err != nil {
-		return errors.WithMessage(err, "start scheduler manager")
+	if err := c.lifecycle.Start(ctx); err != nil {
+		return errors.WithMessage(err, "start cluster")
 	}
 	return nil
 }
 
 func (c *Cluster) Stop(ctx context.Context) error {
-	if err := c.procedureManager.Stop(ctx); err != nil {
-		return errors.WithMessage(err, "stop procedure manager")
-	}
-	if err := c.schedulerManager.Stop(ctx); err != nil {
-		return errors.WithMessage(err, "stop scheduler manager")
+	if err := c.lifecycle.Stop(ctx); err != nil {
+		return errors.WithMessage(err, "stop cluster")
 	}
 	return nil
 }
@@ -96,6 +234,16 @@ func (c *Cluster) GetProcedureManager() procedure.Manager {
 	return c.procedureManager
 }
 
+// GetProcedureStorage returns the storage where the procedures of the cluster are persisted.
+func (c *Cluster) GetProcedureStorage() procedure.Storage {
+	return c.procedureStorage
+}
+
+// GetPiggybackDispatch returns the dispatch delivering the shard operations by the heartbeats of the nodes opting in.
+func (c *Cluster) GetPiggybackDispatch() *eventdispatch.PiggybackDispatch {
+	return c.piggybackDispatch
+}
+
 func (c *Cluster) GetProcedureFactory() *coordinator.Factory {
 	return c.procedureFactory
 }
@@ -104,6 +252,16 @@ func (c *Cluster) GetSchedulerManager() manager.SchedulerManager {
 	return c.schedulerManager
 }
 
+// GetEventBus returns the bus publishing the changes of the cluster.
+func (c *Cluster) GetEventBus() *event.Bus {
+	return c.eventBus
+}
+
+// GetTableStats returns the collector of the stats of the shards and the tables reported by the heartbeats.
+func (c *Cluster) GetTableStats() *TableStatsCollector {
+	return c.tableStats
+}
+
 func (c *Cluster) GetShards() []storage.ShardID {
 	return c.metadata.GetShards()
 }
@@ -20,11 +20,16 @@ import (
 	"context"
 	"fmt"
 	"path"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/pkg/log"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/event"
 	"github.com/CeresDB/horaemeta/server/id"
 	"github.com/CeresDB/horaemeta/server/storage"
 	"github.com/pkg/errors"
@@ -34,6 +39,9 @@ import (
 
 const (
 	AllocClusterIDPrefix = "ClusterID"
+
+	// standbyCatchUpTimeout is the max time to wait for the standby cluster metadata catching up with the storage.
+	standbyCatchUpTimeout = time.Second * 5
 )
 
 type Manager interface {
@@ -41,24 +49,64 @@ type Manager interface {
 	Start(ctx context.Context) error
 	// Stop must be called before manager is dropped.
 	Stop(ctx context.Context) error
+	// StartStandby loads the clusters and keeps their metadata in sync with the storage until the manager is started, so
+	// that the clusters are started without reloading once the node becomes the leader.
+	StartStandby(ctx context.Context) error
 
 	ListClusters(ctx context.Context) ([]*Cluster, error)
 	CreateCluster(ctx context.Context, clusterName string, opts metadata.CreateClusterOpts) (*Cluster, error)
+	// CloneCluster creates the cluster with the same settings and schemas as the source one, and fresh shard views.
+	CloneCluster(ctx context.Context, sourceClusterName, clusterName string, opts metadata.CloneClusterOpts) (*Cluster, metadata.CloneClusterResult, error)
 	UpdateCluster(ctx context.Context, clusterName string, opt metadata.UpdateClusterOpts) error
 	GetCluster(ctx context.Context, clusterName string) (*Cluster, error)
-	// AllocSchemaID means get or create schema.
+	// AllocSchemaID means get or create schema, and the unknown schema is rejected if the schema creation policy of the
+	// cluster doesn't allow to create it.
 	// The second output parameter bool: Returns true if the table was newly created.
 	AllocSchemaID(ctx context.Context, clusterName, schemaName string) (storage.SchemaID, bool, error)
 	GetTables(clusterName, schemaName string, tableNames []string) ([]metadata.TableInfo, error)
 	GetTablesByIDs(clusterName string, tableID []storage.TableID) ([]metadata.TableInfo, error)
+	// SearchTables get the tables in the schema matching the request.
+	// The second output parameter bool: Returns true if more tables are matched than the limit.
+	SearchTables(clusterName string, req metadata.SearchTablesRequest) ([]metadata.TableInfo, bool, error)
 	GetTablesByShardIDs(clusterName, nodeName string, shardIDs []storage.ShardID) (map[storage.ShardID]metadata.ShardTables, error)
 	DropTable(ctx context.Context, clusterName, schemaName, tableName string) error
 	RouteTables(ctx context.Context, clusterName, schemaName string, tableNames []string) (metadata.RouteTablesResult, error)
+	// RouteTablesByIDs routes the tables by their ids, and the route entries are keyed by the decimal ids of the tables.
+	RouteTablesByIDs(ctx context.Context, clusterName string, tableIDs []storage.TableID) (metadata.RouteTablesResult, error)
 	GetNodeShards(ctx context.Context, clusterName string) (metadata.GetNodeShardsResult, error)
+	// GetMetadataForStaleRead returns the metadata of the cluster to serve the reads allowing stale results, which is the
+	// standby metadata if the manager is not started.
+	GetMetadataForStaleRead(clusterName string) (*metadata.ClusterMetadata, error)
 
 	RegisterNode(ctx context.Context, clusterName string, registeredNode metadata.RegisteredNode) error
 	GetRegisteredNode(ctx context.Context, clusterName string, node string) (metadata.RegisteredNode, error)
 	ListRegisteredNodes(ctx context.Context, clusterName string) ([]metadata.RegisteredNode, error)
+
+	// CreateRegistrationToken issues a join token of the cluster, and the returned string is the token for the nodes.
+	CreateRegistrationToken(ctx context.Context, clusterName string) (RegistrationToken, string, error)
+	RevokeRegistrationToken(ctx context.Context, clusterName, tokenID string) error
+	ListRegistrationTokens(ctx context.Context, clusterName string) ([]RegistrationToken, error)
+	// VerifyRegistrationToken checks the token presented by the node, and it is always passed if the cluster has no token issued.
+	VerifyRegistrationToken(ctx context.Context, clusterName, token string) error
+
+	// PutTenant creates the tenant or replaces the clusters and schemas owned by it.
+	PutTenant(ctx context.Context, tenant Tenant) (Tenant, error)
+	GetTenant(ctx context.Context, tenantName string) (Tenant, error)
+	ListTenants(ctx context.Context) ([]Tenant, error)
+	// DeleteTenant removes the tenant and revokes all its tokens.
+	DeleteTenant(ctx context.Context, tenantName string) error
+	// CreateTenantToken issues an api token of the tenant, and the returned string is the token for the clients.
+	CreateTenantToken(ctx context.Context, tenantName string) (TenantToken, string, error)
+	RevokeTenantToken(ctx context.Context, tenantName, tokenID string) error
+	ListTenantTokens(ctx context.Context, tenantName string) ([]TenantToken, error)
+	// AuthenticateTenant returns the tenant owning the token presented by the client.
+	AuthenticateTenant(ctx context.Context, token string) (Tenant, error)
+}
+
+// standbyCluster is the cluster metadata kept in sync with the storage on the node which is not the leader.
+type standbyCluster struct {
+	metadata *metadata.ClusterMetadata
+	syncer   *metadata.CacheSyncer
 }
 
 type managerImpl struct {
@@ -66,33 +114,51 @@ type managerImpl struct {
 	lock     sync.RWMutex
 	running  bool
 	clusters map[string]*Cluster
+	// standbys are the cluster metadata kept warm while the manager is not started.
+	standbys map[string]*standbyCluster
 
 	storage         storage.Storage
 	kv              clientv3.KV
 	client          *clientv3.Client
 	alloc           id.Allocator
 	rootPath        string
-	idAllocatorStep uint
+	idAllocatorOpts id.ResourceAllocatorOptions
+	procedureGCOpts procedure.GCOptions
+	// dependencyResolver resolves the dependencies of the procedure factory for every cluster.
+	dependencyResolver coordinator.DependencyResolver
+	// eventOpts are the options of the event bus of every cluster.
+	eventOpts          event.BusOptions
+	registrationTokens *registrationTokenStore
+	tenants            *tenantStore
+	// clock is shared by all the clusters as their time source.
+	clock clock.Clock
 
 	// TODO: topologyType is used to be compatible with cluster data changes and needs to be deleted later.
 	topologyType storage.TopologyType
 }
 
-func NewManagerImpl(storage storage.Storage, kv clientv3.KV, client *clientv3.Client, rootPath string, idAllocatorStep uint, topologyType storage.TopologyType) (Manager, error) {
-	alloc := id.NewAllocatorImpl(log.GetLogger(), kv, path.Join(rootPath, AllocClusterIDPrefix), idAllocatorStep)
+func NewManagerImpl(storage storage.Storage, kv clientv3.KV, client *clientv3.Client, rootPath string, idAllocatorOpts id.ResourceAllocatorOptions, procedureGCOpts procedure.GCOptions, topologyType storage.TopologyType, dependencyResolver coordinator.DependencyResolver, eventOpts event.BusOptions, clk clock.Clock) (Manager, error) {
+	alloc := id.NewAllocatorImplWithOptions(log.GetLogger(), kv, path.Join(rootPath, AllocClusterIDPrefix), idAllocatorOpts.Cluster)
 
 	manager := &managerImpl{
 		lock:     sync.RWMutex{},
 		running:  false,
 		clusters: map[string]*Cluster{},
-
-		kv:              kv,
-		storage:         storage,
-		client:          client,
-		alloc:           alloc,
-		rootPath:        rootPath,
-		idAllocatorStep: idAllocatorStep,
-		topologyType:    topologyType,
+		standbys: map[string]*standbyCluster{},
+
+		kv:                 kv,
+		storage:            storage,
+		client:             client,
+		alloc:              alloc,
+		rootPath:           rootPath,
+		idAllocatorOpts:    idAllocatorOpts,
+		procedureGCOpts:    procedureGCOpts,
+		dependencyResolver: dependencyResolver,
+		eventOpts:          eventOpts,
+		registrationTokens: newRegistrationTokenStore(client, rootPath, clk),
+		tenants:            newTenantStore(client, rootPath, clk),
+		clock:              clk,
+		topologyType:       topologyType,
 	}
 
 	return manager, nil
@@ -114,6 +180,15 @@ func (m *managerImpl) CreateCluster(ctx context.Context, clusterName string, opt
 		log.Error("cluster's nodeCount must > 0", zap.String("clusterName", clusterName))
 		return nil, metadata.ErrCreateCluster.WithCausef("nodeCount must > 0")
 	}
+	if err := metadata.ValidateHeartbeatIntervalBounds(opts.HeartbeatIntervalBounds); err != nil {
+		return nil, err
+	}
+	if !opts.SchemaCreationPolicy.IsValid() {
+		return nil, metadata.ErrInvalidSchemaCreationPolicy.WithCausef("cluster:%s, policy:%s", clusterName, opts.SchemaCreationPolicy)
+	}
+	if opts.Quota.MaxShards > 0 && opts.ShardTotal > opts.Quota.MaxShards {
+		return nil, metadata.ErrClusterShardQuotaExceeded.WithCausef("cluster:%s, shardTotal:%d, maxShards:%d", clusterName, opts.ShardTotal, opts.Quota.MaxShards)
+	}
 
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -129,7 +204,7 @@ func (m *managerImpl) CreateCluster(ctx context.Context, clusterName string, opt
 		return nil, errors.WithMessagef(err, "cluster manager CreateCluster, clusterName:%s", clusterName)
 	}
 
-	createTime := time.Now().UnixMilli()
+	createTime := clock.UnixMilli(m.clock)
 	clusterMetadataStorage := storage.Cluster{
 		ID:                          clusterID,
 		Name:                        clusterName,
@@ -137,8 +212,15 @@ func (m *managerImpl) CreateCluster(ctx context.Context, clusterName string, opt
 		ShardTotal:                  opts.ShardTotal,
 		TopologyType:                opts.TopologyType,
 		ProcedureExecutingBatchSize: opts.ProcedureExecutingBatchSize,
-		CreatedAt:                   uint64(createTime),
-		ModifiedAt:                  uint64(createTime),
+		CaseInsensitiveName:         opts.CaseInsensitiveName,
+		DefaultSchemaName:           opts.DefaultSchemaName,
+		HeartbeatIntervalBounds:     opts.HeartbeatIntervalBounds,
+		Quota:                       opts.Quota,
+		CordonedNodes:               nil,
+		SchemaCreationPolicy:        opts.SchemaCreationPolicy,
+		Protected:                   opts.Protected,
+		CreatedAt:                   createTime,
+		ModifiedAt:                  createTime,
 	}
 	err = m.storage.CreateCluster(ctx, storage.CreateClusterRequest{
 		Cluster: clusterMetadataStorage,
@@ -150,7 +232,7 @@ func (m *managerImpl) CreateCluster(ctx context.Context, clusterName string, opt
 
 	logger := log.With(zap.String("clusterName", clusterName))
 
-	clusterMetadata := metadata.NewClusterMetadata(logger, clusterMetadataStorage, m.storage, m.kv, m.rootPath, m.idAllocatorStep)
+	clusterMetadata := metadata.NewClusterMetadata(logger, clusterMetadataStorage, m.storage, m.kv, m.rootPath, m.idAllocatorOpts, m.clock)
 
 	if err = clusterMetadata.Init(ctx); err != nil {
 		log.Error("fail to init cluster", zap.Error(err), zap.String("clusterName", clusterName))
@@ -162,25 +244,97 @@ func (m *managerImpl) CreateCluster(ctx context.Context, clusterName string, opt
 		return nil, errors.WithMessage(err, "cluster load")
 	}
 
-	c, err := NewCluster(logger, clusterMetadata, m.client, m.rootPath)
+	if len(opts.DefaultSchemaName) > 0 {
+		if _, _, err := clusterMetadata.GetOrCreateSchema(ctx, opts.DefaultSchemaName); err != nil {
+			log.Error("fail to create default schema", zap.Error(err), zap.String("clusterName", clusterName), zap.String("schemaName", opts.DefaultSchemaName))
+			return nil, errors.WithMessage(err, "cluster create default schema")
+		}
+	}
+
+	// The shards are assigned before the cluster starts, so that the schedulers never see it empty.
+	if len(opts.ShardNodes) > 0 {
+		if err := clusterMetadata.UpdateClusterView(ctx, storage.ClusterStateStable, opts.ShardNodes); err != nil {
+			log.Error("fail to assign shards", zap.Error(err), zap.String("clusterName", clusterName))
+			return nil, errors.WithMessage(err, "cluster assign shards")
+		}
+	}
+
+	c, err := NewCluster(logger, clusterMetadata, m.client, m.rootPath, m.idAllocatorOpts.Procedure, m.procedureGCOpts, m.dependencyResolver, m.eventOpts)
 	if err != nil {
 		return nil, errors.WithMessage(err, "new cluster")
 	}
 	m.clusters[clusterName] = c
 
-	if err := c.Start(ctx); err != nil {
+	// The cluster keeps running after the request creating it is done, so it is not canceled along with the request.
+	if err := c.Start(context.WithoutCancel(ctx)); err != nil {
 		return nil, errors.WithMessage(err, "start cluster")
 	}
 
 	return c, nil
 }
 
+func (m *managerImpl) CloneCluster(ctx context.Context, sourceClusterName, clusterName string, opts metadata.CloneClusterOpts) (*Cluster, metadata.CloneClusterResult, error) {
+	var result metadata.CloneClusterResult
+	source, err := m.GetCluster(ctx, sourceClusterName)
+	if err != nil {
+		return nil, result, errors.WithMessagef(err, "get source cluster, clusterName:%s", sourceClusterName)
+	}
+
+	sourceMetadata := source.GetMetadata().GetStorageMetadata()
+	nodeCount := sourceMetadata.MinNodeCount
+	if opts.NodeCount > 0 {
+		nodeCount = opts.NodeCount
+	}
+	c, err := m.CreateCluster(ctx, clusterName, metadata.CreateClusterOpts{
+		NodeCount:                   nodeCount,
+		ShardTotal:                  sourceMetadata.ShardTotal,
+		EnableSchedule:              opts.EnableSchedule,
+		TopologyType:                sourceMetadata.TopologyType,
+		ProcedureExecutingBatchSize: sourceMetadata.ProcedureExecutingBatchSize,
+		CaseInsensitiveName:         sourceMetadata.CaseInsensitiveName,
+		DefaultSchemaName:           sourceMetadata.DefaultSchemaName,
+		HeartbeatIntervalBounds:     sourceMetadata.HeartbeatIntervalBounds,
+		Quota:                       sourceMetadata.Quota,
+		SchemaCreationPolicy:        sourceMetadata.SchemaCreationPolicy,
+		// The clone is a new cluster, so it isn't protected along with the source one.
+		Protected:  false,
+		ShardNodes: nil,
+	})
+	if err != nil {
+		return nil, result, err
+	}
+
+	// The cluster is left created if the cloning fails midway, and the cloning can't be retried on it.
+	result, err = c.GetMetadata().CloneFrom(ctx, source.GetMetadata(), opts.WithTables)
+	if err != nil {
+		log.Error("fail to clone cluster metadata", zap.Error(err), zap.String("sourceClusterName", sourceClusterName), zap.String("clusterName", clusterName))
+		return c, result, errors.WithMessagef(err, "clone cluster metadata, source:%s", sourceClusterName)
+	}
+	return c, result, nil
+}
+
 func (m *managerImpl) UpdateCluster(ctx context.Context, clusterName string, opt metadata.UpdateClusterOpts) error {
+	if err := metadata.ValidateHeartbeatIntervalBounds(opt.HeartbeatIntervalBounds); err != nil {
+		return err
+	}
+	if !opt.SchemaCreationPolicy.IsValid() {
+		return metadata.ErrInvalidSchemaCreationPolicy.WithCausef("cluster:%s, policy:%s", clusterName, opt.SchemaCreationPolicy)
+	}
+
 	c, err := m.getCluster(clusterName)
 	if err != nil {
 		log.Error("get cluster", zap.Error(err))
 		return err
 	}
+	if !opt.Protected {
+		if err := c.GetMetadata().CheckProtection("unprotect cluster", opt.Confirmation); err != nil {
+			return err
+		}
+	}
+	// Flipping the topology type alone leaves the running schedulers of the previous type, so it must be migrated.
+	if opt.TopologyType != c.GetMetadata().GetTopologyType() {
+		return metadata.ErrUpdateCluster.WithCausef("topology type could only be changed by the topology migration, current:%s, expect:%s", c.GetMetadata().GetTopologyType(), opt.TopologyType)
+	}
 
 	err = m.storage.UpdateCluster(ctx, storage.UpdateClusterRequest{Cluster: storage.Cluster{
 		ID:                          c.GetMetadata().GetClusterID(),
@@ -189,8 +343,15 @@ func (m *managerImpl) UpdateCluster(ctx context.Context, clusterName string, opt
 		ShardTotal:                  c.GetMetadata().GetTotalShardNum(),
 		TopologyType:                opt.TopologyType,
 		ProcedureExecutingBatchSize: opt.ProcedureExecutingBatchSize,
+		CaseInsensitiveName:         c.GetMetadata().IsCaseInsensitiveName(),
+		DefaultSchemaName:           c.GetMetadata().GetDefaultSchemaName(),
+		HeartbeatIntervalBounds:     opt.HeartbeatIntervalBounds,
+		Quota:                       opt.Quota,
+		CordonedNodes:               c.GetMetadata().GetCordonedNodes(),
+		SchemaCreationPolicy:        opt.SchemaCreationPolicy,
+		Protected:                   opt.Protected,
 		CreatedAt:                   c.GetMetadata().GetCreateTime(),
-		ModifiedAt:                  uint64(time.Now().UnixMilli()),
+		ModifiedAt:                  clock.UnixMilli(m.clock),
 	}})
 	if err != nil {
 		log.Error("update cluster", zap.Error(err))
@@ -222,8 +383,8 @@ func (m *managerImpl) AllocSchemaID(ctx context.Context, clusterName, schemaName
 		return 0, false, errors.WithMessage(err, "get cluster")
 	}
 
-	// create new schema
-	schema, exists, err := cluster.metadata.GetOrCreateSchema(ctx, schemaName)
+	// Create the new schema unless it is rejected by the schema creation policy.
+	schema, exists, err := cluster.metadata.GetOrCreateSchemaByPolicy(ctx, schemaName)
 	if err != nil {
 		log.Error("fail to create schema", zap.Error(err))
 		return 0, false, errors.WithMessage(err, "get or create schema")
@@ -256,6 +417,31 @@ func (m *managerImpl) GetTables(clusterName, schemaName string, tableNames []str
 	return tableInfos, nil
 }
 
+func (m *managerImpl) SearchTables(clusterName string, req metadata.SearchTablesRequest) ([]metadata.TableInfo, bool, error) {
+	cluster, err := m.getCluster(clusterName)
+	if err != nil {
+		return []metadata.TableInfo{}, false, errors.WithMessage(err, "get cluster")
+	}
+
+	result, err := cluster.metadata.SearchTables(req)
+	if err != nil {
+		return []metadata.TableInfo{}, false, errors.WithMessage(err, "metadata search tables")
+	}
+
+	tableInfos := make([]metadata.TableInfo, 0, len(result.Tables))
+	for _, table := range result.Tables {
+		tableInfos = append(tableInfos, metadata.TableInfo{
+			ID:            table.ID,
+			Name:          table.Name,
+			SchemaID:      table.SchemaID,
+			SchemaName:    req.SchemaName,
+			CreatedAt:     table.CreatedAt,
+			PartitionInfo: table.PartitionInfo,
+		})
+	}
+	return tableInfos, result.Truncated, nil
+}
+
 func (m *managerImpl) GetTablesByIDs(clusterName string, tableIDs []storage.TableID) ([]metadata.TableInfo, error) {
 	cluster, err := m.getCluster(clusterName)
 	if err != nil {
@@ -384,6 +570,124 @@ func (m *managerImpl) ListRegisteredNodes(_ context.Context, clusterName string)
 	return nodes, nil
 }
 
+func (m *managerImpl) CreateRegistrationToken(ctx context.Context, clusterName string) (RegistrationToken, string, error) {
+	if _, err := m.getCluster(clusterName); err != nil {
+		return RegistrationToken{}, "", errors.WithMessage(err, "get cluster")
+	}
+
+	token, presentedToken, err := m.registrationTokens.create(ctx, clusterName)
+	if err != nil {
+		return RegistrationToken{}, "", errors.WithMessage(err, "create registration token")
+	}
+	log.Info("registration token created", zap.String("clusterName", clusterName), zap.String("tokenID", token.ID))
+	return token, presentedToken, nil
+}
+
+func (m *managerImpl) RevokeRegistrationToken(ctx context.Context, clusterName, tokenID string) error {
+	if _, err := m.getCluster(clusterName); err != nil {
+		return errors.WithMessage(err, "get cluster")
+	}
+
+	if err := m.registrationTokens.revoke(ctx, clusterName, tokenID); err != nil {
+		return errors.WithMessage(err, "revoke registration token")
+	}
+	log.Info("registration token revoked", zap.String("clusterName", clusterName), zap.String("tokenID", tokenID))
+	return nil
+}
+
+func (m *managerImpl) ListRegistrationTokens(_ context.Context, clusterName string) ([]RegistrationToken, error) {
+	if _, err := m.getCluster(clusterName); err != nil {
+		return nil, errors.WithMessage(err, "get cluster")
+	}
+
+	return m.registrationTokens.list(clusterName), nil
+}
+
+func (m *managerImpl) VerifyRegistrationToken(_ context.Context, clusterName, token string) error {
+	return m.registrationTokens.verify(clusterName, token)
+}
+
+func (m *managerImpl) PutTenant(ctx context.Context, tenant Tenant) (Tenant, error) {
+	if len(tenant.Name) == 0 || strings.Contains(tenant.Name, "/") {
+		return Tenant{}, metadata.ErrInvalidTenant.WithCausef("invalid tenant name, tenant:%s", tenant.Name)
+	}
+	if len(tenant.Clusters) == 0 {
+		return Tenant{}, metadata.ErrInvalidTenant.WithCausef("no cluster owned, tenant:%s", tenant.Name)
+	}
+	for clusterName := range tenant.Clusters {
+		if _, err := m.getCluster(clusterName); err != nil {
+			return Tenant{}, errors.WithMessage(err, "get cluster")
+		}
+	}
+
+	tenant, err := m.tenants.put(ctx, tenant)
+	if err != nil {
+		return Tenant{}, errors.WithMessage(err, "put tenant")
+	}
+	log.Info("tenant updated", zap.String("tenantName", tenant.Name), zap.Any("clusters", tenant.Clusters))
+	return tenant, nil
+}
+
+func (m *managerImpl) GetTenant(ctx context.Context, tenantName string) (Tenant, error) {
+	tenant, exists, err := m.tenants.get(ctx, tenantName)
+	if err != nil {
+		return Tenant{}, errors.WithMessage(err, "get tenant")
+	}
+	if !exists {
+		return Tenant{}, metadata.ErrTenantNotFound.WithCausef("tenant:%s", tenantName)
+	}
+	return tenant, nil
+}
+
+func (m *managerImpl) ListTenants(ctx context.Context) ([]Tenant, error) {
+	tenants, err := m.tenants.list(ctx)
+	if err != nil {
+		return nil, errors.WithMessage(err, "list tenants")
+	}
+	return tenants, nil
+}
+
+func (m *managerImpl) DeleteTenant(ctx context.Context, tenantName string) error {
+	if err := m.tenants.delete(ctx, tenantName); err != nil {
+		return errors.WithMessage(err, "delete tenant")
+	}
+	log.Info("tenant deleted", zap.String("tenantName", tenantName))
+	return nil
+}
+
+func (m *managerImpl) CreateTenantToken(ctx context.Context, tenantName string) (TenantToken, string, error) {
+	token, presentedToken, err := m.tenants.createToken(ctx, tenantName)
+	if err != nil {
+		return TenantToken{}, "", errors.WithMessage(err, "create tenant token")
+	}
+	log.Info("tenant token created", zap.String("tenantName", tenantName), zap.String("tokenID", token.ID))
+	return token, presentedToken, nil
+}
+
+func (m *managerImpl) RevokeTenantToken(ctx context.Context, tenantName, tokenID string) error {
+	if err := m.tenants.revokeToken(ctx, tenantName, tokenID); err != nil {
+		return errors.WithMessage(err, "revoke tenant token")
+	}
+	log.Info("tenant token revoked", zap.String("tenantName", tenantName), zap.String("tokenID", tokenID))
+	return nil
+}
+
+func (m *managerImpl) ListTenantTokens(ctx context.Context, tenantName string) ([]TenantToken, error) {
+	if _, err := m.GetTenant(ctx, tenantName); err != nil {
+		return nil, err
+	}
+
+	tokens, err := m.tenants.listTokens(ctx, tenantName)
+	if err != nil {
+		return nil, errors.WithMessage(err, "list tenant tokens")
+	}
+	return tokens, nil
+}
+
+func (m *managerImpl) AuthenticateTenant(ctx context.Context, token string) (Tenant, error) {
+	return m.tenants.authenticate(ctx, token)
+}
+
 func (m *managerImpl) getCluster(clusterName string) (*Cluster, error) {
 	m.lock.RLock()
 	cluster, ok := m.clusters[clusterName]
@@ -420,9 +724,9 @@ func (m *managerImpl) Start(ctx context.Context) error {
 	m.clusters = make(map[string]*Cluster, len(clusters.Clusters))
 	for _, metadataStorage := range clusters.Clusters {
 		logger := log.With(zap.String("clusterName", metadataStorage.Name))
-		clusterMetadata := metadata.NewClusterMetadata(logger, metadataStorage, m.storage, m.kv, m.rootPath, m.idAllocatorStep)
-		if err = clusterMetadata.Load(ctx); err != nil {
-			log.Error("fail to load cluster", zap.String("cluster", clusterMetadata.Name()), zap.Error(err))
+		clusterMetadata, err := m.openClusterMetadata(ctx, logger, metadataStorage)
+		if err != nil {
+			log.Error("fail to load cluster", zap.String("cluster", metadataStorage.Name), zap.Error(err))
 			return errors.WithMessage(err, "fail to load cluster")
 		}
 
@@ -436,8 +740,15 @@ func (m *managerImpl) Start(ctx context.Context) error {
 					ShardTotal:                  metadataStorage.ShardTotal,
 					TopologyType:                m.topologyType,
 					ProcedureExecutingBatchSize: metadataStorage.ProcedureExecutingBatchSize,
+					CaseInsensitiveName:         metadataStorage.CaseInsensitiveName,
+					DefaultSchemaName:           metadataStorage.DefaultSchemaName,
+					HeartbeatIntervalBounds:     metadataStorage.HeartbeatIntervalBounds,
+					Quota:                       metadataStorage.Quota,
+					CordonedNodes:               metadataStorage.CordonedNodes,
+					SchemaCreationPolicy:        metadataStorage.SchemaCreationPolicy,
+					Protected:                   metadataStorage.Protected,
 					CreatedAt:                   metadataStorage.CreatedAt,
-					ModifiedAt:                  uint64(time.Now().UnixMilli()),
+					ModifiedAt:                  clock.UnixMilli(m.clock),
 				},
 			}
 			if err := m.storage.UpdateCluster(ctx, req); err != nil {
@@ -451,7 +762,7 @@ func (m *managerImpl) Start(ctx context.Context) error {
 		}
 
 		log.Info("open cluster successfully", zap.String("cluster", clusterMetadata.Name()))
-		c, err := NewCluster(logger, clusterMetadata, m.client, m.rootPath)
+		c, err := NewCluster(logger, clusterMetadata, m.client, m.rootPath, m.idAllocatorOpts.Procedure, m.procedureGCOpts, m.dependencyResolver, m.eventOpts)
 		if err != nil {
 			return errors.WithMessage(err, "new cluster")
 		}
@@ -461,6 +772,17 @@ func (m *managerImpl) Start(ctx context.Context) error {
 		}
 	}
 
+	// The standbys left are of the clusters no longer existing.
+	m.stopStandbys(ctx)
+
+	clusterNames := make([]string, 0, len(m.clusters))
+	for clusterName := range m.clusters {
+		clusterNames = append(clusterNames, clusterName)
+	}
+	if err := m.registrationTokens.load(ctx, clusterNames); err != nil {
+		return errors.WithMessage(err, "load registration tokens")
+	}
+
 	m.running = true
 
 	return nil
@@ -470,6 +792,7 @@ func (m *managerImpl) Stop(ctx context.Context) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
+	m.stopStandbys(ctx)
 	if !m.running {
 		return nil
 	}
@@ -485,6 +808,97 @@ func (m *managerImpl) Stop(ctx context.Context) error {
 	return nil
 }
 
+// StartStandby only keeps the clusters existing at the time warm, and the clusters created later are loaded when the
+// manager is started.
+func (m *managerImpl) StartStandby(ctx context.Context) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.running || len(m.standbys) > 0 {
+		return nil
+	}
+
+	clusters, err := m.storage.ListClusters(ctx)
+	if err != nil {
+		return errors.WithMessage(err, "list clusters")
+	}
+
+	for _, metadataStorage := range clusters.Clusters {
+		logger := log.With(zap.String("clusterName", metadataStorage.Name))
+		clusterMetadata := metadata.NewClusterMetadata(logger, metadataStorage, m.storage, m.kv, m.rootPath, m.idAllocatorOpts, m.clock)
+		if err := clusterMetadata.Load(ctx); err != nil {
+			m.stopStandbys(ctx)
+			return errors.WithMessagef(err, "load cluster, clusterName:%s", metadataStorage.Name)
+		}
+
+		syncer := metadata.NewCacheSyncer(logger, clusterMetadata)
+		if err := syncer.Start(ctx); err != nil {
+			m.stopStandbys(ctx)
+			return errors.WithMessagef(err, "start cache syncer, clusterName:%s", metadataStorage.Name)
+		}
+		m.standbys[metadataStorage.Name] = &standbyCluster{
+			metadata: clusterMetadata,
+			syncer:   syncer,
+		}
+	}
+
+	log.Info("cluster manager standby started", zap.Int("clusterCount", len(m.standbys)))
+	return nil
+}
+
+// openClusterMetadata takes over the cluster metadata from the standby if it catches up with the storage in time,
+// otherwise the cluster metadata is loaded from the storage.
+func (m *managerImpl) openClusterMetadata(ctx context.Context, logger *zap.Logger, metadataStorage storage.Cluster) (*metadata.ClusterMetadata, error) {
+	if standby, ok := m.standbys[metadataStorage.Name]; ok {
+		delete(m.standbys, metadataStorage.Name)
+
+		catchUpCtx, cancel := context.WithTimeout(ctx, standbyCatchUpTimeout)
+		err := standby.syncer.CatchUp(catchUpCtx)
+		cancel()
+		if stopErr := standby.syncer.Stop(ctx); stopErr != nil {
+			log.Warn("fail to stop cache syncer", zap.String("cluster", metadataStorage.Name), zap.Error(stopErr))
+		}
+
+		// The cluster info is out of the watched keys, so it is loaded again.
+		if err == nil {
+			err = standby.metadata.LoadMetadata(ctx)
+		}
+		if err == nil {
+			log.Info("take over cluster metadata from standby", zap.String("cluster", metadataStorage.Name), zap.Int64("revision", standby.metadata.Revision()))
+			return standby.metadata, nil
+		}
+		log.Warn("standby cluster metadata is not available, load it instead", zap.String("cluster", metadataStorage.Name), zap.Error(err))
+	}
+
+	clusterMetadata := metadata.NewClusterMetadata(logger, metadataStorage, m.storage, m.kv, m.rootPath, m.idAllocatorOpts, m.clock)
+	if err := clusterMetadata.Load(ctx); err != nil {
+		return nil, err
+	}
+	return clusterMetadata, nil
+}
+
+func (m *managerImpl) GetMetadataForStaleRead(clusterName string) (*metadata.ClusterMetadata, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if cluster, ok := m.clusters[clusterName]; ok {
+		return cluster.metadata, nil
+	}
+	if standby, ok := m.standbys[clusterName]; ok {
+		return standby.metadata, nil
+	}
+	return nil, errors.WithMessagef(metadata.ErrClusterNotFound, "cluster name:%s", clusterName)
+}
+
+func (m *managerImpl) stopStandbys(ctx context.Context) {
+	for clusterName, standby := range m.standbys {
+		if err := standby.syncer.Stop(ctx); err != nil {
+			log.Warn("fail to stop cache syncer", zap.String("cluster", clusterName), zap.Error(err))
+		}
+	}
+	m.standbys = make(map[string]*standbyCluster)
+}
+
 func (m *managerImpl) RouteTables(ctx context.Context, clusterName, schemaName string, tableNames []string) (metadata.RouteTablesResult, error) {
 	cluster, err := m.getCluster(clusterName)
 	if err != nil {
@@ -499,6 +913,20 @@ func (m *managerImpl) RouteTables(ctx context.Context, clusterName, schemaName s
 	return ret, nil
 }
 
+func (m *managerImpl) RouteTablesByIDs(ctx context.Context, clusterName string, tableIDs []storage.TableID) (metadata.RouteTablesResult, error) {
+	cluster, err := m.getCluster(clusterName)
+	if err != nil {
+		return metadata.RouteTablesResult{}, errors.WithMessage(err, "get cluster")
+	}
+
+	ret, err := cluster.metadata.RouteTablesByIDs(ctx, tableIDs)
+	if err != nil {
+		return metadata.RouteTablesResult{}, errors.WithMessage(err, "cluster route tables by ids")
+	}
+
+	return ret, nil
+}
+
 func (m *managerImpl) GetNodeShards(ctx context.Context, clusterName string) (metadata.GetNodeShardsResult, error) {
 	cluster, err := m.getCluster(clusterName)
 	if err != nil {
@@ -21,15 +21,23 @@ import (
 	"crypto/rand"
 	"fmt"
 	"math/big"
+	"slices"
 	"testing"
 	"time"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/pkg/coderr"
 	"github.com/CeresDB/horaemeta/server/cluster"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
 	"github.com/CeresDB/horaemeta/server/etcdutil"
+	"github.com/CeresDB/horaemeta/server/event"
+	"github.com/CeresDB/horaemeta/server/id"
 	"github.com/CeresDB/horaemeta/server/storage"
 	"github.com/stretchr/testify/require"
 	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
 )
 
 const (
@@ -56,7 +64,7 @@ func newTestStorage(t *testing.T) (storage.Storage, clientv3.KV, *clientv3.Clien
 }
 
 func newClusterManagerWithStorage(storage storage.Storage, kv clientv3.KV, client *clientv3.Client) (cluster.Manager, error) {
-	return cluster.NewManagerImpl(storage, kv, client, testRootPath, defaultIDAllocatorStep, defaultTopologyType)
+	return cluster.NewManagerImpl(storage, kv, client, testRootPath, id.NewResourceAllocatorOptions(defaultIDAllocatorStep), procedure.GCOptions{Interval: 0, Retention: 0, MaxOpsPerTxn: 0}, defaultTopologyType, coordinator.NewDefaultDependencyResolver(), event.BusOptions{Capacity: 0, Sinks: nil}, clock.NewRealClock())
 }
 
 func TestClusterManager(t *testing.T) {
@@ -101,6 +109,363 @@ func TestClusterManager(t *testing.T) {
 	re.NoError(manager.Stop(ctx))
 }
 
+func TestCreateClusterWithShardNodes(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	s, kv, client, closeSrv := newTestStorage(t)
+	defer closeSrv()
+	manager, err := newClusterManagerWithStorage(s, kv, client)
+	re.NoError(err)
+	re.NoError(manager.Start(ctx))
+
+	shardNodes := make([]storage.ShardNode, 0, defaultShardTotal)
+	for i := 0; i < defaultShardTotal; i++ {
+		nodeName := node1
+		if i%2 == 1 {
+			nodeName = node2
+		}
+		shardNodes = append(shardNodes, storage.ShardNode{ID: storage.ShardID(i), ShardRole: storage.ShardRoleLeader, NodeName: nodeName})
+	}
+	c, err := manager.CreateCluster(ctx, cluster1, metadata.CreateClusterOpts{
+		NodeCount:                   defaultNodeCount,
+		EnableSchedule:              false,
+		ShardTotal:                  defaultShardTotal,
+		TopologyType:                defaultTopologyType,
+		ProcedureExecutingBatchSize: defaultProcedureExecutingBatchSize,
+		CaseInsensitiveName:         false,
+		DefaultSchemaName:           "",
+		HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0},
+		Quota:                       storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0},
+		SchemaCreationPolicy:        storage.SchemaCreationPolicyAuto,
+		Protected:                   false,
+		ShardNodes:                  shardNodes,
+	})
+	re.NoError(err)
+
+	// The cluster is stable with the given assignment before any node registers.
+	clusterView := c.GetMetadata().GetClusterSnapshot().Topology.ClusterView
+	re.Equal(storage.ClusterStateStable, clusterView.State)
+	re.ElementsMatch(shardNodes, clusterView.ShardNodes)
+
+	re.NoError(manager.Stop(ctx))
+}
+
+func TestCreateClusterWithDefaultSchema(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	s, kv, client, closeSrv := newTestStorage(t)
+	defer closeSrv()
+	manager, err := newClusterManagerWithStorage(s, kv, client)
+	re.NoError(err)
+	re.NoError(manager.Start(ctx))
+
+	c, err := manager.CreateCluster(ctx, cluster1, metadata.CreateClusterOpts{
+		NodeCount:                   defaultNodeCount,
+		EnableSchedule:              false,
+		ShardTotal:                  defaultShardTotal,
+		TopologyType:                defaultTopologyType,
+		ProcedureExecutingBatchSize: defaultProcedureExecutingBatchSize,
+		CaseInsensitiveName:         false,
+		DefaultSchemaName:           defaultSchema,
+		HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0},
+		Quota:                       storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0},
+		SchemaCreationPolicy:        storage.SchemaCreationPolicyAuto,
+		Protected:                   false,
+		ShardNodes:                  nil,
+	})
+	re.NoError(err)
+	re.Equal(defaultSchema, c.GetMetadata().GetDefaultSchemaName())
+	_, exists, err := c.GetMetadata().GetOrCreateSchema(ctx, defaultSchema)
+	re.NoError(err)
+	re.True(exists)
+
+	// The default schema is kept after the clusters are reloaded.
+	re.NoError(manager.Stop(ctx))
+	re.NoError(manager.Start(ctx))
+	c, err = manager.GetCluster(ctx, cluster1)
+	re.NoError(err)
+	re.Equal(defaultSchema, c.GetMetadata().GetDefaultSchemaName())
+	_, exists, err = c.GetMetadata().GetOrCreateSchema(ctx, defaultSchema)
+	re.NoError(err)
+	re.True(exists)
+
+	re.NoError(manager.Stop(ctx))
+}
+
+func TestClusterQuota(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	s, kv, client, closeSrv := newTestStorage(t)
+	defer closeSrv()
+	manager, err := newClusterManagerWithStorage(s, kv, client)
+	re.NoError(err)
+	re.NoError(manager.Start(ctx))
+
+	opts := metadata.CreateClusterOpts{
+		NodeCount:                   defaultNodeCount,
+		EnableSchedule:              false,
+		ShardTotal:                  defaultShardTotal,
+		TopologyType:                defaultTopologyType,
+		ProcedureExecutingBatchSize: defaultProcedureExecutingBatchSize,
+		CaseInsensitiveName:         false,
+		DefaultSchemaName:           "",
+		HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0},
+		Quota:                       storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: defaultShardTotal - 1},
+		SchemaCreationPolicy:        storage.SchemaCreationPolicyAuto,
+		Protected:                   false,
+		ShardNodes:                  nil,
+	}
+	_, err = manager.CreateCluster(ctx, cluster1, opts)
+	re.True(coderr.Is(err, metadata.ErrClusterShardQuotaExceeded.Code()))
+
+	opts.Quota.MaxShards = defaultShardTotal
+	c, err := manager.CreateCluster(ctx, cluster1, opts)
+	re.NoError(err)
+	re.Equal(opts.Quota, c.GetMetadata().GetQuota())
+	re.True(coderr.Is(c.GetMetadata().CheckShardQuota(1), metadata.ErrClusterShardQuotaExceeded.Code()))
+
+	// The quota is adjusted at runtime, and it is kept after the clusters are reloaded.
+	quota := storage.ClusterQuota{MaxTables: 1, MaxTablesPerSchema: 0, MaxShards: 0}
+	re.NoError(manager.UpdateCluster(ctx, cluster1, metadata.UpdateClusterOpts{
+		TopologyType:                defaultTopologyType,
+		ProcedureExecutingBatchSize: defaultProcedureExecutingBatchSize,
+		HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0},
+		Quota:                       quota,
+		SchemaCreationPolicy:        storage.SchemaCreationPolicyAuto,
+		Protected:                   false,
+		Confirmation:                metadata.Confirmation{Force: false, Token: ""},
+	}))
+	re.NoError(c.GetMetadata().CheckShardQuota(1))
+	re.True(coderr.Is(c.GetMetadata().CheckTableQuota(defaultSchema, 2), metadata.ErrClusterTableQuotaExceeded.Code()))
+
+	re.NoError(manager.Stop(ctx))
+	re.NoError(manager.Start(ctx))
+	c, err = manager.GetCluster(ctx, cluster1)
+	re.NoError(err)
+	re.Equal(quota, c.GetMetadata().GetQuota())
+	re.True(coderr.Is(c.GetMetadata().CheckTableQuota(defaultSchema, 2), metadata.ErrClusterTableQuotaExceeded.Code()))
+
+	re.NoError(manager.Stop(ctx))
+}
+
+func TestSchemaCreationPolicy(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	s, kv, client, closeSrv := newTestStorage(t)
+	defer closeSrv()
+	manager, err := newClusterManagerWithStorage(s, kv, client)
+	re.NoError(err)
+	re.NoError(manager.Start(ctx))
+
+	opts := metadata.CreateClusterOpts{
+		NodeCount:                   defaultNodeCount,
+		EnableSchedule:              false,
+		ShardTotal:                  defaultShardTotal,
+		TopologyType:                defaultTopologyType,
+		ProcedureExecutingBatchSize: defaultProcedureExecutingBatchSize,
+		CaseInsensitiveName:         false,
+		DefaultSchemaName:           defaultSchema,
+		HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0},
+		Quota:                       storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0},
+		SchemaCreationPolicy:        "unknown",
+		ShardNodes:                  nil,
+	}
+	_, err = manager.CreateCluster(ctx, cluster1, opts)
+	re.True(coderr.Is(err, metadata.ErrInvalidSchemaCreationPolicy.Code()))
+
+	// The provisioned schemas are served, and the unknown ones are rejected.
+	opts.SchemaCreationPolicy = storage.SchemaCreationPolicyReject
+	c, err := manager.CreateCluster(ctx, cluster1, opts)
+	re.NoError(err)
+	_, exists, err := manager.AllocSchemaID(ctx, cluster1, defaultSchema)
+	re.NoError(err)
+	re.True(exists)
+	_, _, err = manager.AllocSchemaID(ctx, cluster1, "unknownSchema")
+	re.True(coderr.Is(err, metadata.ErrSchemaNotFound.Code()))
+	_, exists = c.GetMetadata().GetSchemaInfo("unknownSchema")
+	re.False(exists)
+
+	// The policy is switched at runtime, and it is kept after the clusters are reloaded.
+	re.NoError(manager.UpdateCluster(ctx, cluster1, metadata.UpdateClusterOpts{
+		TopologyType:                defaultTopologyType,
+		ProcedureExecutingBatchSize: defaultProcedureExecutingBatchSize,
+		HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0},
+		Quota:                       storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0},
+		SchemaCreationPolicy:        storage.SchemaCreationPolicyAuto,
+		Protected:                   false,
+		Confirmation:                metadata.Confirmation{Force: false, Token: ""},
+	}))
+	_, exists, err = manager.AllocSchemaID(ctx, cluster1, "unknownSchema")
+	re.NoError(err)
+	re.False(exists)
+
+	re.NoError(manager.Stop(ctx))
+	re.NoError(manager.Start(ctx))
+	c, err = manager.GetCluster(ctx, cluster1)
+	re.NoError(err)
+	re.Equal(storage.SchemaCreationPolicyAuto, c.GetMetadata().GetSchemaCreationPolicy())
+
+	re.NoError(manager.Stop(ctx))
+}
+
+func TestClusterProtection(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	s, kv, client, closeSrv := newTestStorage(t)
+	defer closeSrv()
+	manager, err := newClusterManagerWithStorage(s, kv, client)
+	re.NoError(err)
+	re.NoError(manager.Start(ctx))
+
+	c, err := manager.CreateCluster(ctx, cluster1, metadata.CreateClusterOpts{
+		NodeCount:                   defaultNodeCount,
+		EnableSchedule:              false,
+		ShardTotal:                  defaultShardTotal,
+		TopologyType:                defaultTopologyType,
+		ProcedureExecutingBatchSize: defaultProcedureExecutingBatchSize,
+		CaseInsensitiveName:         false,
+		DefaultSchemaName:           "",
+		HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0},
+		Quota:                       storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0},
+		SchemaCreationPolicy:        storage.SchemaCreationPolicyAuto,
+		Protected:                   true,
+		ShardNodes:                  nil,
+	})
+	re.NoError(err)
+	re.True(c.GetMetadata().IsProtected())
+	re.True(coderr.Is(c.GetMetadata().CheckProtection("test", metadata.Confirmation{Force: false, Token: cluster1}), metadata.ErrClusterProtected.Code()))
+	re.True(coderr.Is(c.GetMetadata().CheckProtection("test", metadata.Confirmation{Force: true, Token: "anotherCluster"}), metadata.ErrClusterProtected.Code()))
+	re.NoError(c.GetMetadata().CheckProtection("test", metadata.Confirmation{Force: true, Token: cluster1}))
+
+	// The protection is kept after the clusters are reloaded, and it is only removed with the confirmation.
+	re.NoError(manager.Stop(ctx))
+	re.NoError(manager.Start(ctx))
+	c, err = manager.GetCluster(ctx, cluster1)
+	re.NoError(err)
+	re.True(c.GetMetadata().IsProtected())
+
+	// The destructive procedures are rejected without the confirmation.
+	snapshot := c.GetMetadata().GetClusterSnapshot()
+	_, err = c.GetProcedureFactory().CreateMigrateTopologyProcedure(ctx, coordinator.MigrateTopologyRequest{
+		ClusterMetadata: c.GetMetadata(),
+		Snapshot:        snapshot,
+		TopologyType:    storage.TopologyTypeDynamic,
+		Confirmation:    metadata.Confirmation{Force: false, Token: ""},
+		OnSwitched:      nil,
+	})
+	re.True(coderr.Is(err, metadata.ErrClusterProtected.Code()))
+	_, err = c.GetProcedureFactory().CreateRepairShardsProcedure(ctx, coordinator.RepairShardsRequest{
+		ClusterMetadata: c.GetMetadata(),
+		Snapshot:        snapshot,
+		Actions:         nil,
+		Confirmation:    metadata.Confirmation{Force: true, Token: "anotherCluster"},
+		OnFinished:      nil,
+	})
+	re.True(coderr.Is(err, metadata.ErrClusterProtected.Code()))
+	_, err = c.GetProcedureFactory().CreateRepairShardsProcedure(ctx, coordinator.RepairShardsRequest{
+		ClusterMetadata: c.GetMetadata(),
+		Snapshot:        snapshot,
+		Actions:         nil,
+		Confirmation:    metadata.Confirmation{Force: true, Token: cluster1},
+		OnFinished:      nil,
+	})
+	// The confirmed request passes the protection, and it is rejected for having nothing to repair.
+	re.True(coderr.Is(err, procedure.ErrEmptyRepairActions.Code()))
+
+	opts := metadata.UpdateClusterOpts{
+		TopologyType:                defaultTopologyType,
+		ProcedureExecutingBatchSize: defaultProcedureExecutingBatchSize,
+		HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0},
+		Quota:                       storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0},
+		SchemaCreationPolicy:        storage.SchemaCreationPolicyAuto,
+		Protected:                   false,
+		Confirmation:                metadata.Confirmation{Force: false, Token: ""},
+	}
+	re.True(coderr.Is(manager.UpdateCluster(ctx, cluster1, opts), metadata.ErrClusterProtected.Code()))
+	re.True(c.GetMetadata().IsProtected())
+
+	opts.Confirmation = metadata.Confirmation{Force: true, Token: cluster1}
+	re.NoError(manager.UpdateCluster(ctx, cluster1, opts))
+	re.False(c.GetMetadata().IsProtected())
+	re.NoError(c.GetMetadata().CheckProtection("test", metadata.Confirmation{Force: false, Token: ""}))
+
+	re.NoError(manager.Stop(ctx))
+}
+
+func TestCloneCluster(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	s, kv, client, closeSrv := newTestStorage(t)
+	defer closeSrv()
+	manager, err := newClusterManagerWithStorage(s, kv, client)
+	re.NoError(err)
+	re.NoError(manager.Start(ctx))
+
+	shardNodes := make([]storage.ShardNode, 0, defaultShardTotal)
+	for i := 0; i < defaultShardTotal; i++ {
+		shardNodes = append(shardNodes, storage.ShardNode{ID: storage.ShardID(i), ShardRole: storage.ShardRoleLeader, NodeName: node1})
+	}
+	_, err = manager.CreateCluster(ctx, cluster1, metadata.CreateClusterOpts{
+		NodeCount:                   defaultNodeCount,
+		EnableSchedule:              false,
+		ShardTotal:                  defaultShardTotal,
+		TopologyType:                defaultTopologyType,
+		ProcedureExecutingBatchSize: defaultProcedureExecutingBatchSize,
+		CaseInsensitiveName:         false,
+		DefaultSchemaName:           "",
+		HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0},
+		Quota:                       storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0},
+		SchemaCreationPolicy:        storage.SchemaCreationPolicyAuto,
+		Protected:                   false,
+		ShardNodes:                  shardNodes,
+	})
+	re.NoError(err)
+	testAllocSchemaID(ctx, re, manager, cluster1, defaultSchema, defaultSchemaID)
+	testCreateTable(ctx, re, manager, cluster1, defaultSchema, "testTable0", storage.ShardID(0))
+	testCreateTable(ctx, re, manager, cluster1, defaultSchema, "testTable1", storage.ShardID(0))
+	testCreateTable(ctx, re, manager, cluster1, defaultSchema, "testTable2", storage.ShardID(3))
+
+	// Only the schemas are cloned without the tables.
+	c, result, err := manager.CloneCluster(ctx, cluster1, "schemaClone", metadata.CloneClusterOpts{NodeCount: 0, EnableSchedule: false, WithTables: false})
+	re.NoError(err)
+	re.Equal(metadata.CloneClusterResult{SchemaCount: 1, TableCount: 0}, result)
+	re.Equal(uint32(defaultNodeCount), c.GetMetadata().GetClusterMinNodeCount())
+	_, exists, err := c.GetMetadata().GetTable(defaultSchema, "testTable0")
+	re.NoError(err)
+	re.False(exists)
+
+	c, result, err = manager.CloneCluster(ctx, cluster1, "tableClone", metadata.CloneClusterOpts{NodeCount: 1, EnableSchedule: false, WithTables: true})
+	re.NoError(err)
+	re.Equal(metadata.CloneClusterResult{SchemaCount: 1, TableCount: 3}, result)
+	re.Equal(uint32(1), c.GetMetadata().GetClusterMinNodeCount())
+	re.Equal(storage.ClusterStateEmpty, c.GetMetadata().GetClusterState())
+
+	// The tables are put into the shards at the same positions as the source.
+	shardIDs := c.GetMetadata().GetShards()
+	slices.Sort(shardIDs)
+	counts := c.GetMetadata().GetShardTableCounts(shardIDs)
+	re.Equal(2, counts[shardIDs[0]].TableCount)
+	re.Equal(1, counts[shardIDs[3]].TableCount)
+	re.Equal(0, counts[shardIDs[1]].TableCount)
+
+	_, _, err = manager.CloneCluster(ctx, cluster1, "tableClone", metadata.CloneClusterOpts{NodeCount: 0, EnableSchedule: false, WithTables: false})
+	re.Error(err)
+
+	re.NoError(manager.Stop(ctx))
+}
+
 func testGetNodeAndShard(ctx context.Context, re *require.Assertions, manager cluster.Manager, clusterName string) {
 	c, err := manager.GetCluster(ctx, clusterName)
 	re.NoError(err)
@@ -143,6 +508,13 @@ func testCreateCluster(ctx context.Context, re *require.Assertions, manager clus
 		ShardTotal:                  defaultShardTotal,
 		TopologyType:                defaultTopologyType,
 		ProcedureExecutingBatchSize: defaultProcedureExecutingBatchSize,
+		CaseInsensitiveName:         false,
+		DefaultSchemaName:           "",
+		HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0},
+		Quota:                       storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0},
+		SchemaCreationPolicy:        storage.SchemaCreationPolicyAuto,
+		Protected:                   false,
+		ShardNodes:                  nil,
 	})
 	re.NoError(err)
 }
@@ -157,6 +529,7 @@ func testRegisterNode(ctx context.Context, re *require.Assertions, manager clust
 			State:         storage.NodeStateOnline,
 			NodeStats:     storage.NewEmptyNodeStats(),
 		}, ShardInfos: []metadata.ShardInfo{},
+		Heartbeat: metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
 	}
 	err := manager.RegisterNode(ctx, clusterName, node)
 	re.NoError(err)
@@ -216,3 +589,189 @@ func testDropTable(ctx context.Context, re *require.Assertions, manager cluster.
 	err := manager.DropTable(ctx, clusterName, schemaName, tableName)
 	re.NoError(err)
 }
+
+func TestRegistrationToken(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	s, kv, client, closeSrv := newTestStorage(t)
+	defer closeSrv()
+	manager, err := newClusterManagerWithStorage(s, kv, client)
+	re.NoError(err)
+	re.NoError(manager.Start(ctx))
+	testCreateCluster(ctx, re, manager, cluster1)
+
+	// Any node is accepted before the token is issued.
+	re.NoError(manager.VerifyRegistrationToken(ctx, cluster1, ""))
+
+	token, presentedToken, err := manager.CreateRegistrationToken(ctx, cluster1)
+	re.NoError(err)
+	re.Error(manager.VerifyRegistrationToken(ctx, cluster1, ""))
+	re.Error(manager.VerifyRegistrationToken(ctx, cluster1, token.ID+".invalid"))
+	re.NoError(manager.VerifyRegistrationToken(ctx, cluster1, presentedToken))
+
+	// The tokens are reloaded after restart.
+	re.NoError(manager.Stop(ctx))
+	manager, err = newClusterManagerWithStorage(s, kv, client)
+	re.NoError(err)
+	re.NoError(manager.Start(ctx))
+	tokens, err := manager.ListRegistrationTokens(ctx, cluster1)
+	re.NoError(err)
+	re.Equal([]cluster.RegistrationToken{token}, tokens)
+	re.NoError(manager.VerifyRegistrationToken(ctx, cluster1, presentedToken))
+
+	re.NoError(manager.RevokeRegistrationToken(ctx, cluster1, token.ID))
+	re.Error(manager.RevokeRegistrationToken(ctx, cluster1, token.ID))
+	re.NoError(manager.VerifyRegistrationToken(ctx, cluster1, ""))
+
+	re.NoError(manager.Stop(ctx))
+}
+
+func TestTableStats(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	_, _, client, closeSrv := newTestStorage(t)
+	defer closeSrv()
+	clk := clock.NewMock(time.Now())
+	collector := cluster.NewTableStatsCollector(zap.NewNop(), client, testRootPath, cluster1, clk)
+	re.NoError(collector.Start(ctx))
+
+	collector.Report(node1, cluster.StatsReport{
+		Shards: []cluster.ShardStatsReport{{ShardID: 0, RowCount: 100, Bytes: 1000, WriteRowsPerSec: 100}},
+		Tables: []cluster.TableStatsReport{{TableID: 1, RowCount: 100, Bytes: 1000, WriteRowsPerSec: 100}},
+	})
+	// The average and the peak of the write rate are decayed by half after the half life.
+	clk.Advance(5 * time.Minute)
+	collector.Report(node2, cluster.StatsReport{
+		Shards: []cluster.ShardStatsReport{{ShardID: 0, RowCount: 200, Bytes: 2000, WriteRowsPerSec: 20}, {ShardID: 1, RowCount: 10, Bytes: 100, WriteRowsPerSec: 1}},
+		Tables: nil,
+	})
+
+	stats := collector.Get()
+	re.Len(stats.Shards, 2)
+	shard := stats.Shards[0]
+	re.Equal(storage.ShardID(0), shard.ShardID)
+	re.Equal(uint64(200), shard.RowCount)
+	re.Equal(node2, shard.NodeName)
+	re.InDelta(60, shard.AvgWriteRowsPerSec, 0.001)
+	re.InDelta(50, shard.PeakWriteRowsPerSec, 0.001)
+	re.Equal(uint64(210), stats.Total.RowCount)
+	re.Equal(uint64(2100), stats.Total.Bytes)
+	re.Len(stats.Tables, 1)
+	re.Equal(node1, stats.Tables[0].NodeName)
+	re.Equal(int64(0), stats.SnapshotAt)
+
+	// The aggregates are recovered from the snapshot persisted on stop, and the expired ones are dropped.
+	clk.Advance(time.Hour - time.Minute)
+	re.NoError(collector.Stop(ctx))
+	collector = cluster.NewTableStatsCollector(zap.NewNop(), client, testRootPath, cluster1, clk)
+	re.NoError(collector.Start(ctx))
+	recovered := collector.Get()
+	re.Equal(stats.Shards, recovered.Shards)
+	re.Empty(recovered.Tables)
+	re.NoError(collector.Stop(ctx))
+}
+
+func TestGetMetadataForStaleRead(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	s, kv, client, closeSrv := newTestStorage(t)
+	defer closeSrv()
+	leader, err := newClusterManagerWithStorage(s, kv, client)
+	re.NoError(err)
+	re.NoError(leader.Start(ctx))
+	testCreateCluster(ctx, re, leader, cluster1)
+	testRegisterNode(ctx, re, leader, cluster1, node1)
+	testRegisterNode(ctx, re, leader, cluster1, node2)
+	testInitShardView(ctx, re, leader, cluster1)
+	testAllocSchemaID(ctx, re, leader, cluster1, defaultSchema, defaultSchemaID)
+
+	follower, err := newClusterManagerWithStorage(s, kv, client)
+	re.NoError(err)
+	_, err = follower.GetMetadataForStaleRead(cluster1)
+	re.True(coderr.Is(err, metadata.ErrClusterNotFound.Code()))
+
+	re.NoError(follower.StartStandby(ctx))
+	clusterMetadata, err := follower.GetMetadataForStaleRead(cluster1)
+	re.NoError(err)
+
+	// The table created by the leader becomes routable on the follower once the standby metadata catches up.
+	testCreateTable(ctx, re, leader, cluster1, defaultSchema, "testTable", 0)
+	re.Eventually(func() bool {
+		ret, err := clusterMetadata.RouteTables(ctx, defaultSchema, []string{"testTable"})
+		return err == nil && len(ret.RouteEntries) == 1
+	}, defaultTimeout, 50*time.Millisecond)
+
+	re.NoError(follower.Stop(ctx))
+	_, err = follower.GetMetadataForStaleRead(cluster1)
+	re.True(coderr.Is(err, metadata.ErrClusterNotFound.Code()))
+	re.NoError(leader.Stop(ctx))
+}
+
+func TestTenant(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultTimeout)
+	defer cancel()
+
+	s, kv, client, closeSrv := newTestStorage(t)
+	defer closeSrv()
+	manager, err := newClusterManagerWithStorage(s, kv, client)
+	re.NoError(err)
+	re.NoError(manager.Start(ctx))
+	testCreateCluster(ctx, re, manager, cluster1)
+
+	// The tenant must own the existing clusters only.
+	_, err = manager.PutTenant(ctx, cluster.Tenant{Name: "team1", Clusters: map[string][]string{"unknown": {}}, CreatedAt: 0})
+	re.Error(err)
+	_, _, err = manager.CreateTenantToken(ctx, "team1")
+	re.Error(err)
+
+	tenant, err := manager.PutTenant(ctx, cluster.Tenant{Name: "team1", Clusters: map[string][]string{cluster1: {"schema1"}}, CreatedAt: 0})
+	re.NoError(err)
+	re.True(tenant.CanAccessCluster(cluster1))
+	re.True(tenant.CanAccess(cluster1, "schema1"))
+	re.False(tenant.CanAccess(cluster1, "schema2"))
+	re.False(tenant.CanAccess(cluster1, ""))
+
+	token, presentedToken, err := manager.CreateTenantToken(ctx, "team1")
+	re.NoError(err)
+	authenticated, err := manager.AuthenticateTenant(ctx, presentedToken)
+	re.NoError(err)
+	re.Equal(tenant, authenticated)
+	_, err = manager.AuthenticateTenant(ctx, token.ID+".invalid")
+	re.Error(err)
+	_, err = manager.AuthenticateTenant(ctx, "")
+	re.Error(err)
+
+	// Owning the whole cluster grants the cluster level access.
+	tenant, err = manager.PutTenant(ctx, cluster.Tenant{Name: "team1", Clusters: map[string][]string{cluster1: {}}, CreatedAt: 0})
+	re.NoError(err)
+	re.Equal(authenticated.CreatedAt, tenant.CreatedAt)
+	re.True(tenant.CanAccess(cluster1, ""))
+	re.True(tenant.CanAccess(cluster1, "schema2"))
+
+	tokens, err := manager.ListTenantTokens(ctx, "team1")
+	re.NoError(err)
+	re.Equal([]cluster.TenantToken{token}, tokens)
+	re.NoError(manager.RevokeTenantToken(ctx, "team1", token.ID))
+	re.Error(manager.RevokeTenantToken(ctx, "team1", token.ID))
+	_, err = manager.AuthenticateTenant(ctx, presentedToken)
+	re.Error(err)
+
+	// The tokens are revoked together with the tenant.
+	_, presentedToken, err = manager.CreateTenantToken(ctx, "team1")
+	re.NoError(err)
+	re.NoError(manager.DeleteTenant(ctx, "team1"))
+	_, err = manager.AuthenticateTenant(ctx, presentedToken)
+	re.Error(err)
+	tenants, err := manager.ListTenants(ctx)
+	re.NoError(err)
+	re.Empty(tenants)
+
+	re.NoError(manager.Stop(ctx))
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// cacheReloadRetryInterval is the interval to retry reloading the caches if the reloading fails.
+	cacheReloadRetryInterval = time.Second
+	// cacheCatchUpCheckInterval is the interval to check whether the caches have caught up with the storage.
+	cacheCatchUpCheckInterval = 50 * time.Millisecond
+)
+
+// CacheSyncer keeps the caches of the cluster metadata up to date by applying the changes watched from the storage, instead
+// of reloading the whole cluster. It is used on the node which is not the leader, so that the caches are warm once the
+// node takes over the leadership. The leader must not use it, because the leader updates the caches by itself.
+type CacheSyncer struct {
+	logger   *zap.Logger
+	metadata *ClusterMetadata
+
+	// Mutex is used to protect following fields.
+	lock    sync.Mutex
+	running bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+}
+
+func NewCacheSyncer(logger *zap.Logger, metadata *ClusterMetadata) *CacheSyncer {
+	return &CacheSyncer{
+		logger:   logger,
+		metadata: metadata,
+		lock:     sync.Mutex{},
+		running:  false,
+		cancel:   nil,
+		done:     nil,
+	}
+}
+
+// Start starts syncing the changes after the revision the metadata is loaded at, so the metadata must have been loaded.
+func (s *CacheSyncer) Start(_ context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.running {
+		return nil
+	}
+
+	// The syncing outlives the ctx of the caller, e.g. the callbacks of the leadership, and it is only stopped by Stop.
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.done = make(chan struct{})
+	s.running = true
+	go s.sync(ctx, s.done)
+
+	s.logger.Info("cache syncer started", zap.Int64("revision", s.metadata.Revision()))
+	return nil
+}
+
+func (s *CacheSyncer) Stop(_ context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.running {
+		return nil
+	}
+
+	s.cancel()
+	<-s.done
+	s.running = false
+
+	s.logger.Info("cache syncer stopped", zap.Int64("revision", s.metadata.Revision()))
+	return nil
+}
+
+// CatchUp waits until the caches are up to the current revision of the storage, and it returns an error if the caches
+// fail to catch up before the ctx is done.
+func (s *CacheSyncer) CatchUp(ctx context.Context) error {
+	revision, err := s.metadata.storage.GetRevision(ctx)
+	if err != nil {
+		return errors.WithMessage(err, "get storage revision")
+	}
+
+	ticker := time.NewTicker(cacheCatchUpCheckInterval)
+	defer ticker.Stop()
+	for s.metadata.Revision() < revision {
+		// The cluster may not change after the revision at all, so the watch is requested to report its progress.
+		if err := s.metadata.storage.RequestWatchProgress(ctx); err != nil {
+			return errors.WithMessage(err, "request watch progress")
+		}
+
+		select {
+		case <-ctx.Done():
+			return errors.WithMessagef(ctx.Err(), "catch up with revision:%d, cache revision:%d", revision, s.metadata.Revision())
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+func (s *CacheSyncer) sync(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	for {
+		s.watch(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// The changes can't be watched any more, e.g. the revision to watch from has been compacted, so the caches are
+		// reloaded as a whole and the changes are watched from the new revision.
+		if err := s.metadata.Load(ctx); err != nil {
+			s.logger.Error("reload cluster metadata failed", zap.Error(err))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(cacheReloadRetryInterval):
+			}
+		}
+	}
+}
+
+// watch applies the watched changes to the caches until the watch is broken or the ctx is done.
+func (s *CacheSyncer) watch(ctx context.Context) {
+	watchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	revision := s.metadata.Revision()
+	results := s.metadata.storage.WatchCluster(watchCtx, storage.WatchClusterRequest{
+		ClusterID: s.metadata.clusterID,
+		Revision:  revision + 1,
+	})
+	for result := range results {
+		if result.Err != nil {
+			s.logger.Warn("watch cluster changes failed", zap.Int64("revision", revision), zap.Error(result.Err))
+			return
+		}
+		if err := s.metadata.ApplyChanges(ctx, result.Changes); err != nil {
+			s.logger.Warn("apply cluster changes failed", zap.Int64("revision", result.Changes.Revision), zap.Error(err))
+			return
+		}
+	}
+}
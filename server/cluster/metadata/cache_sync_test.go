@@ -0,0 +1,115 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/etcdutil"
+	"github.com/CeresDB/horaemeta/server/id"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestCacheSyncer(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
+	clusterStorage := storage.NewStorageWithEtcdBackend(client, TestRootPath, storage.Options{
+		MaxScanLimit: 100, MinScanLimit: 10, MaxOpsPerTxn: 10,
+	})
+	clusterMeta := storage.Cluster{
+		ID:                          TestClusterID,
+		Name:                        TestClusterName,
+		MinNodeCount:                1,
+		ShardTotal:                  2,
+		TopologyType:                storage.TopologyTypeStatic,
+		ProcedureExecutingBatchSize: 100,
+		CaseInsensitiveName:         false,
+		DefaultSchemaName:           "",
+		HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0},
+		Quota:                       storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0},
+		CordonedNodes:               nil,
+		SchemaCreationPolicy:        storage.SchemaCreationPolicyAuto,
+		Protected:                   false,
+		CreatedAt:                   0,
+		ModifiedAt:                  0,
+	}
+
+	leader := metadata.NewClusterMetadata(zap.NewNop(), clusterMeta, clusterStorage, client, TestRootPath, id.NewResourceAllocatorOptions(TestIDAllocatorStep), clock.NewRealClock())
+	re.NoError(leader.Init(ctx))
+	re.NoError(leader.Load(ctx))
+
+	standby := metadata.NewClusterMetadata(zap.NewNop(), clusterMeta, clusterStorage, client, TestRootPath, id.NewResourceAllocatorOptions(TestIDAllocatorStep), clock.NewRealClock())
+	re.NoError(standby.Load(ctx))
+	syncer := metadata.NewCacheSyncer(zap.NewNop(), standby)
+	re.NoError(syncer.Start(ctx))
+	defer func() {
+		re.NoError(syncer.Stop(ctx))
+	}()
+
+	// Make the cluster stable and create a table on the leader.
+	shardNodes := []storage.ShardNode{
+		{ID: 0, ShardRole: storage.ShardRoleLeader, NodeName: "node0"},
+		{ID: 1, ShardRole: storage.ShardRoleLeader, NodeName: "node1"},
+	}
+	re.NoError(leader.UpdateClusterView(ctx, storage.ClusterStateStable, shardNodes))
+	_, _, err := leader.GetOrCreateSchema(ctx, TestSchemaName)
+	re.NoError(err)
+	createResult, err := leader.CreateTable(ctx, metadata.CreateTableRequest{
+		ShardID:       0,
+		LatestVersion: 1,
+		SchemaName:    TestSchemaName,
+		TableName:     TestTableName,
+		PartitionInfo: storage.PartitionInfo{Info: nil},
+	})
+	re.NoError(err)
+
+	re.NoError(syncer.CatchUp(ctx))
+	table, exists, err := standby.GetTable(TestSchemaName, TestTableName)
+	re.NoError(err)
+	re.True(exists)
+	re.Equal(createResult.Table.ID, table.ID)
+	re.Equal(storage.ClusterStateStable, standby.GetClusterState())
+	re.Equal(leader.GetClusterViewVersion(), standby.GetClusterViewVersion())
+	shardView := standby.GetClusterSnapshot().Topology.ShardViewsMapping[0]
+	re.Equal(uint64(1), shardView.Version)
+	re.Equal([]storage.TableID{table.ID}, shardView.TableIDs)
+
+	// Drop the table on the leader.
+	re.NoError(leader.DropTable(ctx, metadata.DropTableRequest{
+		SchemaName:    TestSchemaName,
+		TableName:     TestTableName,
+		ShardID:       0,
+		LatestVersion: 2,
+	}))
+
+	re.NoError(syncer.CatchUp(ctx))
+	_, exists, err = standby.GetTable(TestSchemaName, TestTableName)
+	re.NoError(err)
+	re.False(exists)
+	shardView = standby.GetClusterSnapshot().Topology.ShardViewsMapping[0]
+	re.Equal(uint64(2), shardView.Version)
+	re.Empty(shardView.TableIDs)
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"context"
+	"slices"
+
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+type CloneClusterOpts struct {
+	// NodeCount overrides the min node count of the source cluster if it is not zero.
+	NodeCount      uint32
+	EnableSchedule bool
+	// WithTables clones the tables as well as the schemas.
+	WithTables bool
+}
+
+type CloneClusterResult struct {
+	SchemaCount int `json:"schemaCount"`
+	TableCount  int `json:"tableCount"`
+}
+
+// CloneFrom copies the schemas of the source cluster along with their settings, and the tables as well if withTables is
+// true. The cloned tables get new ids and are put into the shards at the same positions as in the source, so that the
+// shards are as balanced as the source ones.
+//
+// It is only used to populate the cluster just created, which has the same shard total as the source and is not required
+// to be stable, and the shard views are updated without dispatching anything because no node has opened the shards yet.
+func (c *ClusterMetadata) CloneFrom(ctx context.Context, source *ClusterMetadata, withTables bool) (CloneClusterResult, error) {
+	result := CloneClusterResult{SchemaCount: 0, TableCount: 0}
+
+	schemas := source.tableManager.GetSchemas()
+	for _, schema := range schemas {
+		if _, _, err := c.tableManager.GetOrCreateSchema(ctx, schema.Name); err != nil {
+			return result, errors.WithMessagef(err, "create schema, schemaName:%s", schema.Name)
+		}
+		if settings, ok := source.tableManager.GetSchemaSettings(schema.Name); ok {
+			if err := c.tableManager.UpdateSchemaSettings(ctx, schema.Name, settings); err != nil {
+				return result, errors.WithMessagef(err, "update schema settings, schemaName:%s", schema.Name)
+			}
+		}
+		result.SchemaCount++
+	}
+	if !withTables {
+		return result, nil
+	}
+
+	sourceShardIDs := source.GetShards()
+	shardIDs := c.GetShards()
+	if len(sourceShardIDs) != len(shardIDs) {
+		return result, ErrCreateCluster.WithCausef("shard count mismatch, source:%d, target:%d", len(sourceShardIDs), len(shardIDs))
+	}
+	slices.Sort(sourceShardIDs)
+	slices.Sort(shardIDs)
+	// The tables not in any shard, like the partitioned ones, only get their metadata cloned.
+	shardPositions := make(map[storage.TableID]int)
+	for i, shardID := range sourceShardIDs {
+		shardTableIDs, _ := source.getShardTableIDs(shardID)
+		for _, tableID := range shardTableIDs.TableIDs {
+			shardPositions[tableID] = i
+		}
+	}
+
+	shardTables := make(map[int][]storage.Table)
+	for _, schema := range schemas {
+		sourceTables, err := source.tableManager.GetTablesByPrefix(schema.Name, "")
+		if err != nil {
+			return result, errors.WithMessagef(err, "list source tables, schemaName:%s", schema.Name)
+		}
+
+		tableNames := make([]string, 0, len(sourceTables))
+		sourceTablesByName := make(map[string]storage.Table, len(sourceTables))
+		for _, sourceTable := range sourceTables {
+			if sourceTable.IsPartitioned() {
+				if _, err := c.tableManager.CreateTable(ctx, schema.Name, sourceTable.Name, sourceTable.PartitionInfo); err != nil {
+					return result, errors.WithMessagef(err, "create partitioned table, schemaName:%s, tableName:%s", schema.Name, sourceTable.Name)
+				}
+				result.TableCount++
+				continue
+			}
+			tableNames = append(tableNames, sourceTable.Name)
+			sourceTablesByName[sourceTable.Name] = sourceTable
+		}
+		if len(tableNames) == 0 {
+			continue
+		}
+
+		tables, err := c.tableManager.CreateTables(ctx, schema.Name, tableNames)
+		if err != nil {
+			return result, errors.WithMessagef(err, "create tables, schemaName:%s", schema.Name)
+		}
+		result.TableCount += len(tables)
+		for _, table := range tables {
+			if position, ok := shardPositions[sourceTablesByName[table.Name].ID]; ok {
+				shardTables[position] = append(shardTables[position], table)
+			}
+		}
+	}
+
+	for position, tables := range shardTables {
+		shardID := shardIDs[position]
+		shardTableIDs, _ := c.getShardTableIDs(shardID)
+		if _, err := c.addTablesToShard(ctx, shardID, shardTableIDs.Version+1, tables); err != nil {
+			return result, errors.WithMessagef(err, "add tables to shard, shardID:%d", shardID)
+		}
+	}
+
+	c.logger.Info("clone cluster metadata finish", zap.String("cluster", c.Name()), zap.String("source", source.Name()), zap.Int("schemaCount", result.SchemaCount), zap.Int("tableCount", result.TableCount))
+	return result, nil
+}
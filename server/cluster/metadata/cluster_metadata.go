@@ -22,24 +22,33 @@ import (
 	"fmt"
 	"math/big"
 	"path"
+	"slices"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/event"
 	"github.com/CeresDB/horaemeta/server/id"
 	"github.com/CeresDB/horaemeta/server/storage"
 	"github.com/pkg/errors"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 )
 
 const (
 	AllocSchemaIDPrefix = "SchemaID"
 	AllocTableIDPrefix  = "TableID"
+	AllocShardIDPrefix  = "ShardID"
 )
 
 type ClusterMetadata struct {
 	logger    *zap.Logger
 	clusterID storage.ClusterID
+	clock     clock.Clock
 
 	// RWMutex is used to protect following fields.
 	// TODO: Encapsulated maps as a specific struct.
@@ -48,6 +57,10 @@ type ClusterMetadata struct {
 
 	tableManager    TableManager
 	topologyManager TopologyManager
+	// shardTables is the projection of the tables on the shards, which is maintained along with the table operations.
+	shardTables *shardTablesIndex
+	// revision is the revision of the storage the caches are up to.
+	revision int64
 
 	// Manage the registered nodes from heartbeat.
 	registeredNodesCache map[string]RegisteredNode // nodeName -> NodeName
@@ -55,30 +68,52 @@ type ClusterMetadata struct {
 	storage      storage.Storage
 	kv           clientv3.KV
 	shardIDAlloc id.Allocator
+	events       *clusterEvents
+	stateMachine *clusterStateMachine
 }
 
-func NewClusterMetadata(logger *zap.Logger, meta storage.Cluster, storage storage.Storage, kv clientv3.KV, rootPath string, idAllocatorStep uint) *ClusterMetadata {
-	schemaIDAlloc := id.NewAllocatorImpl(logger, kv, path.Join(rootPath, meta.Name, AllocSchemaIDPrefix), idAllocatorStep)
-	tableIDAlloc := id.NewAllocatorImpl(logger, kv, path.Join(rootPath, meta.Name, AllocTableIDPrefix), idAllocatorStep)
-	// FIXME: Load ShardTopology when cluster create, pass exist ShardID to allocator.
-	shardIDAlloc := id.NewReusableAllocatorImpl([]uint64{}, MinShardID)
+func NewClusterMetadata(logger *zap.Logger, meta storage.Cluster, storage storage.Storage, kv clientv3.KV, rootPath string, idAllocatorOpts id.ResourceAllocatorOptions, clk clock.Clock) *ClusterMetadata {
+	schemaIDAlloc := id.NewAllocatorImplWithOptions(logger, kv, path.Join(rootPath, meta.Name, AllocSchemaIDPrefix), idAllocatorOpts.Schema)
+	tableIDAlloc := id.NewAllocatorImplWithOptions(logger, kv, path.Join(rootPath, meta.Name, AllocTableIDPrefix), idAllocatorOpts.Table)
+	var topologyManager TopologyManager
+	// The shard ids allocated before the allocator is persisted are the ids of the shard views, which are loaded by then.
+	shardIDAlloc := id.NewPersistentReusableAllocatorImpl(logger, kv, path.Join(rootPath, meta.Name, AllocShardIDPrefix), MinShardID, func() []uint64 {
+		shardIDs := topologyManager.GetShards()
+		existIDs := make([]uint64, 0, len(shardIDs))
+		for _, shardID := range shardIDs {
+			existIDs = append(existIDs, uint64(shardID))
+		}
+		return existIDs
+	})
+	topologyManager = NewTopologyManagerImpl(logger, storage, meta.ID, shardIDAlloc, clk)
 
 	cluster := &ClusterMetadata{
 		logger:               logger,
 		clusterID:            meta.ID,
+		clock:                clk,
 		lock:                 sync.RWMutex{},
 		metaData:             meta,
-		tableManager:         NewTableManagerImpl(logger, storage, meta.ID, schemaIDAlloc, tableIDAlloc),
-		topologyManager:      NewTopologyManagerImpl(logger, storage, meta.ID, shardIDAlloc),
+		tableManager:         NewTableManagerImpl(logger, storage, meta.ID, schemaIDAlloc, tableIDAlloc, meta.CaseInsensitiveName, clk),
+		topologyManager:      topologyManager,
+		shardTables:          newShardTablesIndex(),
+		revision:             0,
 		registeredNodesCache: map[string]RegisteredNode{},
 		storage:              storage,
 		kv:                   kv,
 		shardIDAlloc:         shardIDAlloc,
+		events:               newClusterEvents(),
+		stateMachine:         newClusterStateMachine(),
 	}
+	cluster.tableManager.SetClusterQuota(meta.Quota)
 
 	return cluster
 }
 
+// Clock returns the time source of the cluster, which is shared by the components making decisions on the time.
+func (c *ClusterMetadata) Clock() clock.Clock {
+	return c.clock
+}
+
 // Initialize the cluster view and shard view of the cluster.
 // It will be used when we create the cluster.
 func (c *ClusterMetadata) Init(ctx context.Context) error {
@@ -104,21 +139,77 @@ func (c *ClusterMetadata) Init(ctx context.Context) error {
 }
 
 // Load cluster NodeName from storage into memory.
+// The tables and the topology are independent, so they are loaded concurrently.
 func (c *ClusterMetadata) Load(ctx context.Context) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	if err := c.tableManager.Load(ctx); err != nil {
-		return errors.WithMessage(err, "load table manager")
+	start := time.Now()
+	// The revision is got before the loading, so the changes made during the loading are applied again instead of missed.
+	revision, err := c.storage.GetRevision(ctx)
+	if err != nil {
+		return errors.WithMessage(err, "get storage revision")
 	}
 
-	if err := c.topologyManager.Load(ctx); err != nil {
-		return errors.WithMessage(err, "load topology manager")
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		stageStart := time.Now()
+		if err := c.tableManager.Load(gctx); err != nil {
+			return errors.WithMessage(err, "load table manager")
+		}
+		loadDuration.WithLabelValues(c.metaData.Name, loadStageTables).Observe(time.Since(stageStart).Seconds())
+		return nil
+	})
+	g.Go(func() error {
+		stageStart := time.Now()
+		if err := c.topologyManager.Load(gctx); err != nil {
+			return errors.WithMessage(err, "load topology manager")
+		}
+		loadDuration.WithLabelValues(c.metaData.Name, loadStageTopology).Observe(time.Since(stageStart).Seconds())
+		return nil
+	})
+	if err := g.Wait(); err != nil {
+		return err
 	}
+	c.shardTables.reset()
+	c.revision = revision
 
+	elapsed := time.Since(start)
+	loadDuration.WithLabelValues(c.metaData.Name, loadStageTotal).Observe(elapsed.Seconds())
+	c.logger.Info("load cluster metadata finish", zap.String("cluster", c.metaData.Name), zap.Duration("elapsed", elapsed))
 	return nil
 }
 
+// ApplyChanges applies the changes watched from storage to the caches, and the changes the caches are already up to are
+// skipped.
+func (c *ClusterMetadata) ApplyChanges(ctx context.Context, changes storage.ClusterChanges) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if changes.Revision <= c.revision {
+		return nil
+	}
+
+	c.tableManager.ApplyTableChanges(changes.Schemas, changes.Tables)
+	if err := c.topologyManager.ApplyTopologyChanges(ctx, changes); err != nil {
+		return errors.WithMessage(err, "apply topology changes")
+	}
+	// The tables on the shards may be changed, and the projections are rebuilt once they are read.
+	if !changes.IsEmpty() {
+		c.shardTables.reset()
+	}
+	c.revision = changes.Revision
+	return nil
+}
+
+// Revision returns the revision of storage the caches are up to.
+func (c *ClusterMetadata) Revision() int64 {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.revision
+}
+
 func (c *ClusterMetadata) GetClusterID() storage.ClusterID {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
@@ -133,33 +224,17 @@ func (c *ClusterMetadata) Name() string {
 	return c.metaData.Name
 }
 
+// GetShardTables returns the tables of the shards, which are shared with the index of the shard tables, so they must not
+// be modified.
 func (c *ClusterMetadata) GetShardTables(shardIDs []storage.ShardID) map[storage.ShardID]ShardTables {
 	shardTableIDs := c.topologyManager.GetTableIDs(shardIDs)
 
 	result := make(map[storage.ShardID]ShardTables, len(shardIDs))
-
-	schemas := c.tableManager.GetSchemas()
-	schemaByID := make(map[storage.SchemaID]storage.Schema)
-	for _, schema := range schemas {
-		schemaByID[schema.ID] = schema
-	}
-
 	for shardID, shardTableID := range shardTableIDs {
-		tables := c.tableManager.GetTablesByIDs(shardTableID.TableIDs)
-		tableInfos := make([]TableInfo, 0, len(tables))
-		for _, table := range tables {
-			schema, ok := schemaByID[table.SchemaID]
-			if !ok {
-				c.logger.Warn("schema not exits", zap.Uint64("schemaID", uint64(table.SchemaID)))
-			}
-			tableInfos = append(tableInfos, TableInfo{
-				ID:            table.ID,
-				Name:          table.Name,
-				SchemaID:      table.SchemaID,
-				SchemaName:    schema.Name,
-				PartitionInfo: table.PartitionInfo,
-				CreatedAt:     table.CreatedAt,
-			})
+		tableInfos, ok := c.shardTables.get(shardID, shardTableID)
+		if !ok {
+			tableInfos = c.convertToTableInfos(c.tableManager.GetTablesByIDs(shardTableID.TableIDs))
+			c.shardTables.put(shardID, shardTableID, tableInfos)
 		}
 		result[shardID] = ShardTables{
 			Shard: ShardInfo{
@@ -189,6 +264,85 @@ func (c *ClusterMetadata) GetShardTables(shardIDs []storage.ShardID) map[storage
 	return result
 }
 
+// GetShardTableCounts returns the table counts and the versions of the shards, without collecting the table details.
+func (c *ClusterMetadata) GetShardTableCounts(shardIDs []storage.ShardID) map[storage.ShardID]ShardTableCount {
+	shardTableIDs := c.topologyManager.GetTableIDs(shardIDs)
+
+	result := make(map[storage.ShardID]ShardTableCount, len(shardIDs))
+	for _, shardID := range shardIDs {
+		// The shard not found in the topology is returned with zero version and no table, the same as GetShardTables.
+		shardTableID := shardTableIDs[shardID]
+		result[shardID] = ShardTableCount{
+			Shard: ShardInfo{
+				ID:      shardID,
+				Role:    storage.ShardRoleLeader,
+				Version: shardTableID.Version,
+				Status:  storage.ShardStatusUnknown,
+			},
+			TableCount: len(shardTableID.TableIDs),
+		}
+	}
+	return result
+}
+
+func (c *ClusterMetadata) convertToTableInfos(tables []storage.Table) []TableInfo {
+	schemaByID := make(map[storage.SchemaID]storage.Schema)
+	for _, schema := range c.tableManager.GetSchemas() {
+		schemaByID[schema.ID] = schema
+	}
+
+	tableInfos := make([]TableInfo, 0, len(tables))
+	for _, table := range tables {
+		schema, ok := schemaByID[table.SchemaID]
+		if !ok {
+			c.logger.Warn("schema not exits", zap.Uint64("schemaID", uint64(table.SchemaID)))
+		}
+		tableInfos = append(tableInfos, TableInfo{
+			ID:            table.ID,
+			Name:          table.Name,
+			SchemaID:      table.SchemaID,
+			SchemaName:    schema.Name,
+			PartitionInfo: table.PartitionInfo,
+			CreatedAt:     table.CreatedAt,
+		})
+	}
+	return tableInfos
+}
+
+// getShardTableIDs the second output parameter bool: returns true if the shard exists.
+func (c *ClusterMetadata) getShardTableIDs(shardID storage.ShardID) (ShardTableIDs, bool) {
+	shardTableIDs, ok := c.topologyManager.GetTableIDs([]storage.ShardID{shardID})[shardID]
+	return shardTableIDs, ok
+}
+
+// addTablesToShard adds the tables to the shard in the topology, applies the change to the shard tables index, and returns
+// the version of the shard persisted.
+func (c *ClusterMetadata) addTablesToShard(ctx context.Context, shardID storage.ShardID, latestVersion uint64, tables []storage.Table) (uint64, error) {
+	prev, _ := c.getShardTableIDs(shardID)
+	version, err := c.topologyManager.AddTable(ctx, shardID, latestVersion, tables)
+	if err != nil {
+		return 0, err
+	}
+
+	if latest, ok := c.getShardTableIDs(shardID); ok {
+		c.shardTables.update(shardID, prev.Version, latest, c.convertToTableInfos(tables), nil)
+	}
+	return version, nil
+}
+
+// removeTablesFromShard removes the tables from the shard in the topology, and applies the change to the shard tables index.
+func (c *ClusterMetadata) removeTablesFromShard(ctx context.Context, shardID storage.ShardID, latestVersion uint64, tableIDs []storage.TableID) error {
+	prev, _ := c.getShardTableIDs(shardID)
+	if err := c.topologyManager.RemoveTable(ctx, shardID, latestVersion, tableIDs); err != nil {
+		return err
+	}
+
+	if latest, ok := c.getShardTableIDs(shardID); ok {
+		c.shardTables.update(shardID, prev.Version, latest, nil, tableIDs)
+	}
+	return nil
+}
+
 // DropTable will drop table metadata and all mapping of this table.
 // If the table to be dropped has been opened multiple times, all its mapping will be dropped.
 func (c *ClusterMetadata) DropTable(ctx context.Context, request DropTableRequest) error {
@@ -214,16 +368,45 @@ func (c *ClusterMetadata) DropTable(ctx context.Context, request DropTableReques
 	}
 
 	// Remove dropped table in shard view.
-	err = c.topologyManager.RemoveTable(ctx, request.ShardID, request.LatestVersion, []storage.TableID{table.ID})
+	err = c.removeTablesFromShard(ctx, request.ShardID, request.LatestVersion, []storage.TableID{table.ID})
 	if err != nil {
 		return errors.WithMessage(err, "topology manager remove table")
 	}
 
+	c.publishTableEvents(event.TypeTableDropped, request.SchemaName, table)
 	c.logger.Info("drop table success", zap.String("cluster", c.Name()), zap.String("schemaName", request.SchemaName), zap.String("tableName", request.TableName))
 
 	return nil
 }
 
+// DropTables drops the tables on the same shard, the metadata of the tables is removed in batches.
+func (c *ClusterMetadata) DropTables(ctx context.Context, request DropTablesRequest) ([]storage.Table, error) {
+	c.logger.Info("drop tables start", zap.String("cluster", c.Name()), zap.String("schemaName", request.SchemaName), zap.Int("tableCount", len(request.TableNames)), zap.Uint32("shardID", uint32(request.ShardID)))
+
+	if !c.ensureClusterStable() {
+		return nil, errors.WithMessage(ErrClusterStateInvalid, "invalid cluster state, cluster state must be stable")
+	}
+
+	droppedTables, err := c.tableManager.DropTables(ctx, request.SchemaName, request.TableNames)
+	if err != nil {
+		return nil, errors.WithMessage(err, "table manager drop tables")
+	}
+
+	tableIDs := make([]storage.TableID, 0, len(droppedTables))
+	for _, table := range droppedTables {
+		tableIDs = append(tableIDs, table.ID)
+	}
+
+	// Remove dropped tables in shard view.
+	if err = c.removeTablesFromShard(ctx, request.ShardID, request.LatestVersion, tableIDs); err != nil {
+		return nil, errors.WithMessage(err, "topology manager remove tables")
+	}
+
+	c.publishTableEvents(event.TypeTableDropped, request.SchemaName, droppedTables...)
+	c.logger.Info("drop tables success", zap.String("cluster", c.Name()), zap.String("schemaName", request.SchemaName), zap.Int("droppedCount", len(droppedTables)), zap.Uint32("shardID", uint32(request.ShardID)))
+	return droppedTables, nil
+}
+
 // MigrateTable used to migrate tables from old shard to new shard.
 // The mapping relationship between table and shard will be modified.
 func (c *ClusterMetadata) MigrateTable(ctx context.Context, request MigrateTableRequest) error {
@@ -252,12 +435,12 @@ func (c *ClusterMetadata) MigrateTable(ctx context.Context, request MigrateTable
 		tableIDs = append(tableIDs, table.ID)
 	}
 
-	if err := c.topologyManager.RemoveTable(ctx, request.OldShardID, request.latestOldShardVersion, tableIDs); err != nil {
+	if err := c.removeTablesFromShard(ctx, request.OldShardID, request.latestOldShardVersion, tableIDs); err != nil {
 		c.logger.Error("remove table from topology")
 		return err
 	}
 
-	if err := c.topologyManager.AddTable(ctx, request.NewShardID, request.latestNewShardVersion, tables); err != nil {
+	if _, err := c.addTablesToShard(ctx, request.NewShardID, request.latestNewShardVersion, tables); err != nil {
 		c.logger.Error("add table from topology")
 		return err
 	}
@@ -266,11 +449,104 @@ func (c *ClusterMetadata) MigrateTable(ctx context.Context, request MigrateTable
 	return nil
 }
 
+// MoveTables moves the tables from the source shard to the target shard, and unlike MigrateTable, the shard views of both
+// shards are updated in one transaction, so the tables can never be lost or belong to both shards.
+func (c *ClusterMetadata) MoveTables(ctx context.Context, request MoveTablesRequest) error {
+	if !c.ensureClusterStable() {
+		return errors.WithMessage(ErrClusterStateInvalid, "invalid cluster state, cluster state must be stable")
+	}
+
+	sourcePrev, _ := c.getShardTableIDs(request.Source.ShardID)
+	targetPrev, _ := c.getShardTableIDs(request.Target.ShardID)
+	if err := c.topologyManager.MoveTables(ctx, request.Source, request.Target, request.Tables); err != nil {
+		return err
+	}
+
+	tableIDs := make([]storage.TableID, 0, len(request.Tables))
+	for _, table := range request.Tables {
+		tableIDs = append(tableIDs, table.ID)
+	}
+	if latest, ok := c.getShardTableIDs(request.Source.ShardID); ok {
+		c.shardTables.update(request.Source.ShardID, sourcePrev.Version, latest, nil, tableIDs)
+	}
+	if latest, ok := c.getShardTableIDs(request.Target.ShardID); ok {
+		c.shardTables.update(request.Target.ShardID, targetPrev.Version, latest, c.convertToTableInfos(request.Tables), nil)
+	}
+
+	c.logger.Info("move tables finish", zap.String("cluster", c.Name()), zap.Int("tableCount", len(request.Tables)), zap.Uint32("sourceShardID", uint32(request.Source.ShardID)), zap.Uint32("targetShardID", uint32(request.Target.ShardID)))
+	return nil
+}
+
 // GetOrCreateSchema the second output parameter bool: returns true if the schema was newly created.
 func (c *ClusterMetadata) GetOrCreateSchema(ctx context.Context, schemaName string) (storage.Schema, bool, error) {
 	return c.tableManager.GetOrCreateSchema(ctx, schemaName)
 }
 
+// GetOrCreateSchemaByPolicy is GetOrCreateSchema on the requests of the clients, which only creates the unknown schema if
+// the schema creation policy of the cluster allows.
+func (c *ClusterMetadata) GetOrCreateSchemaByPolicy(ctx context.Context, schemaName string) (storage.Schema, bool, error) {
+	if policy := c.GetSchemaCreationPolicy(); policy == storage.SchemaCreationPolicyReject {
+		schema, ok := c.tableManager.GetSchema(schemaName)
+		if !ok {
+			return schema, false, ErrSchemaNotFound.WithCausef("unknown schema is rejected by the schema creation policy, schemaName:%s, policy:%s", schemaName, policy)
+		}
+		return schema, true, nil
+	}
+	return c.tableManager.GetOrCreateSchema(ctx, schemaName)
+}
+
+// GetSchemaInfo the second output parameter bool: returns true if the schema exists.
+func (c *ClusterMetadata) GetSchemaInfo(schemaName string) (SchemaInfo, bool) {
+	return c.tableManager.GetSchemaInfo(schemaName)
+}
+
+func (c *ClusterMetadata) ListSchemaInfos() []SchemaInfo {
+	return c.tableManager.ListSchemaInfos()
+}
+
+// GetSchemaSettings the second output parameter bool: returns true if the schema has settings.
+func (c *ClusterMetadata) GetSchemaSettings(schemaName string) (storage.SchemaSettings, bool) {
+	return c.tableManager.GetSchemaSettings(schemaName)
+}
+
+func (c *ClusterMetadata) ListSchemaSettings() map[string]storage.SchemaSettings {
+	return c.tableManager.ListSchemaSettings()
+}
+
+// UpdateSchemaSettings creates the schema if it does not exist, so the quota can be set before any table is created.
+func (c *ClusterMetadata) UpdateSchemaSettings(ctx context.Context, schemaName string, settings storage.SchemaSettings) error {
+	if !settings.ShardPickingPolicy.IsValid() {
+		return ErrInvalidSchemaSettings.WithCausef("unknown shard picking policy:%s", settings.ShardPickingPolicy)
+	}
+	if _, _, err := c.tableManager.GetOrCreateSchema(ctx, schemaName); err != nil {
+		return errors.WithMessagef(err, "get or create schema, schemaName:%s", schemaName)
+	}
+	return c.tableManager.UpdateSchemaSettings(ctx, schemaName, settings)
+}
+
+func (c *ClusterMetadata) DeleteSchemaSettings(ctx context.Context, schemaName string) error {
+	return c.tableManager.DeleteSchemaSettings(ctx, schemaName)
+}
+
+// CheckTableQuota returns error if creating newTableCount tables in the schema exceeds its max table count, or the max
+// tables of the cluster.
+func (c *ClusterMetadata) CheckTableQuota(schemaName string, newTableCount int) error {
+	return c.tableManager.CheckTableQuota(schemaName, newTableCount)
+}
+
+// CheckShardQuota returns error if creating newShardCount shards exceeds the max shards of the cluster.
+func (c *ClusterMetadata) CheckShardQuota(newShardCount int) error {
+	maxShards := c.GetQuota().MaxShards
+	if maxShards == 0 {
+		return nil
+	}
+	shardCount := len(c.topologyManager.GetShards())
+	if shardCount+newShardCount > int(maxShards) {
+		return ErrClusterShardQuotaExceeded.WithCausef("cluster:%s, shardCount:%d, newShardCount:%d, maxShards:%d", c.Name(), shardCount, newShardCount, maxShards)
+	}
+	return nil
+}
+
 // GetTable the second output parameter bool: returns true if the table exists.
 func (c *ClusterMetadata) GetTable(schemaName, tableName string) (storage.Table, bool, error) {
 	return c.tableManager.GetTable(schemaName, tableName)
@@ -298,6 +574,7 @@ func (c *ClusterMetadata) CreateTableMetadata(ctx context.Context, request Creat
 		return CreateTableMetadataResult{}, errors.WithMessage(err, "table manager create table")
 	}
 
+	c.publishTableEvents(event.TypeTableCreated, request.SchemaName, table)
 	res := CreateTableMetadataResult{
 		Table: table,
 	}
@@ -306,21 +583,60 @@ func (c *ClusterMetadata) CreateTableMetadata(ctx context.Context, request Creat
 	return res, nil
 }
 
-func (c *ClusterMetadata) AddTableTopology(ctx context.Context, shardVersionUpdate ShardVersionUpdate, table storage.Table) error {
+// CreateTablesMetadata creates the metadata of the tables in the schema at once, and the tables are not assigned to any shard.
+func (c *ClusterMetadata) CreateTablesMetadata(ctx context.Context, schemaName string, tableNames []string) ([]storage.Table, error) {
+	c.logger.Info("create tables metadata start", zap.String("cluster", c.Name()), zap.String("schemaName", schemaName), zap.Int("tableCount", len(tableNames)))
+
+	if !c.ensureClusterStable() {
+		return nil, errors.WithMessage(ErrClusterStateInvalid, "invalid cluster state, cluster state must be stable")
+	}
+
+	tables, err := c.tableManager.CreateTables(ctx, schemaName, tableNames)
+	if err != nil {
+		return nil, errors.WithMessage(err, "table manager create tables")
+	}
+
+	c.publishTableEvents(event.TypeTableCreated, schemaName, tables...)
+	c.logger.Info("create tables metadata succeed", zap.String("cluster", c.Name()), zap.String("schemaName", schemaName), zap.Int("tableCount", len(tables)))
+	return tables, nil
+}
+
+// AddTablesTopology adds the tables to the shard with a single update of the shard view, and returns the version of the
+// shard persisted.
+func (c *ClusterMetadata) AddTablesTopology(ctx context.Context, shardVersionUpdate ShardVersionUpdate, tables []storage.Table) (ShardVersionUpdate, error) {
+	c.logger.Info("add tables topology start", zap.String("cluster", c.Name()), zap.Int("tableCount", len(tables)), zap.String("shardVersionUpdate", fmt.Sprintf("%+v", shardVersionUpdate)))
+
+	if !c.ensureClusterStable() {
+		return ShardVersionUpdate{}, errors.WithMessage(ErrClusterStateInvalid, "invalid cluster state, cluster state must be stable")
+	}
+
+	version, err := c.addTablesToShard(ctx, shardVersionUpdate.ShardID, shardVersionUpdate.LatestVersion, tables)
+	if err != nil {
+		return ShardVersionUpdate{}, errors.WithMessage(err, "topology manager add tables")
+	}
+	shardVersionUpdate.LatestVersion = version
+
+	c.logger.Info("add tables topology succeed", zap.String("cluster", c.Name()), zap.Int("tableCount", len(tables)), zap.String("shardVersionUpdate", fmt.Sprintf("%+v", shardVersionUpdate)))
+	return shardVersionUpdate, nil
+}
+
+// AddTableTopology adds the table to the shard, and returns the version of the shard persisted.
+func (c *ClusterMetadata) AddTableTopology(ctx context.Context, shardVersionUpdate ShardVersionUpdate, table storage.Table) (ShardVersionUpdate, error) {
 	c.logger.Info("add table topology start", zap.String("cluster", c.Name()), zap.String("tableName", table.Name))
 
 	if !c.ensureClusterStable() {
-		return errors.WithMessage(ErrClusterStateInvalid, "invalid cluster state, cluster state must be stable")
+		return ShardVersionUpdate{}, errors.WithMessage(ErrClusterStateInvalid, "invalid cluster state, cluster state must be stable")
 	}
 
 	// Add table to topology manager.
-	err := c.topologyManager.AddTable(ctx, shardVersionUpdate.ShardID, shardVersionUpdate.LatestVersion, []storage.Table{table})
+	version, err := c.addTablesToShard(ctx, shardVersionUpdate.ShardID, shardVersionUpdate.LatestVersion, []storage.Table{table})
 	if err != nil {
-		return errors.WithMessage(err, "topology manager add table")
+		return ShardVersionUpdate{}, errors.WithMessage(err, "topology manager add table")
 	}
+	shardVersionUpdate.LatestVersion = version
 
 	c.logger.Info("add table topology succeed", zap.String("cluster", c.Name()), zap.String("table", fmt.Sprintf("%+v", table)), zap.String("shardVersionUpdate", fmt.Sprintf("%+v", shardVersionUpdate)))
-	return nil
+	return shardVersionUpdate, nil
 }
 
 func (c *ClusterMetadata) DropTableMetadata(ctx context.Context, schemaName, tableName string) (DropTableMetadataResult, error) {
@@ -345,6 +661,7 @@ func (c *ClusterMetadata) DropTableMetadata(ctx context.Context, schemaName, tab
 		return dropRes, errors.WithMessage(err, "table manager drop table")
 	}
 
+	c.publishTableEvents(event.TypeTableDropped, schemaName, table)
 	c.logger.Info("drop table metadata success", zap.String("cluster", c.Name()), zap.String("schemaName", schemaName), zap.String("tableName", tableName), zap.String("result", fmt.Sprintf("%+v", table)))
 	dropRes = DropTableMetadataResult{Table: table}
 	return dropRes, nil
@@ -373,16 +690,17 @@ func (c *ClusterMetadata) CreateTable(ctx context.Context, request CreateTableRe
 	}
 
 	// Add table to topology manager.
-	err = c.topologyManager.AddTable(ctx, request.ShardID, request.LatestVersion, []storage.Table{table})
+	version, err := c.addTablesToShard(ctx, request.ShardID, request.LatestVersion, []storage.Table{table})
 	if err != nil {
 		return CreateTableResult{}, errors.WithMessage(err, "topology manager add table")
 	}
 
+	c.publishTableEvents(event.TypeTableCreated, request.SchemaName, table)
 	ret := CreateTableResult{
 		Table: table,
 		ShardVersionUpdate: ShardVersionUpdate{
 			ShardID:       request.ShardID,
-			LatestVersion: request.LatestVersion,
+			LatestVersion: version,
 		},
 	}
 	c.logger.Info("create table succeed", zap.String("cluster", c.Name()), zap.String("result", fmt.Sprintf("%+v", ret)))
@@ -417,7 +735,8 @@ func (c *ClusterMetadata) RegisterNode(ctx context.Context, registeredNode Regis
 	// When the number of nodes in the cluster reaches the threshold, modify the cluster status to prepare.
 	// TODO: Consider the design of the entire cluster state, which may require refactoring.
 	if uint32(len(c.registeredNodesCache)) >= c.metaData.MinNodeCount && c.topologyManager.GetClusterState() == storage.ClusterStateEmpty {
-		if err := c.UpdateClusterView(ctx, storage.ClusterStatePrepare, []storage.ShardNode{}); err != nil {
+		reason := fmt.Sprintf("registered nodes reach the min node count, minNodeCount:%d", c.metaData.MinNodeCount)
+		if err := c.updateClusterView(ctx, storage.ClusterStatePrepare, []storage.ShardNode{}, false, reason); err != nil {
 			c.logger.Error("update cluster view failed", zap.Error(err))
 		}
 	}
@@ -425,6 +744,10 @@ func (c *ClusterMetadata) RegisterNode(ctx context.Context, registeredNode Regis
 	// Update shard node mapping.
 	// Check whether to update persistence data.
 	oldCache, exists := c.registeredNodesCache[registeredNode.Node.Name]
+	if exists {
+		registeredNode.Heartbeat.JitterMs = oldCache.Heartbeat.JitterMs
+		registeredNode.Heartbeat.updateJitter(oldCache.Node.LastTouchTime, registeredNode.Node.LastTouchTime)
+	}
 	c.registeredNodesCache[registeredNode.Node.Name] = registeredNode
 	enableUpdateWhenStable := c.metaData.TopologyType == storage.TopologyTypeDynamic
 	if !enableUpdateWhenStable && c.topologyManager.GetClusterState() == storage.ClusterStateStable {
@@ -464,6 +787,55 @@ func (c *ClusterMetadata) GetRegisteredNodes() []RegisteredNode {
 	return nodes
 }
 
+// GetNodeShortfall counts the registered nodes not expired by now against the MinNodeCount.
+func (c *ClusterMetadata) GetNodeShortfall() NodeShortfall {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	now := c.clock.Now()
+	onlineNodeCount := uint32(0)
+	for _, node := range c.registeredNodesCache {
+		if !node.IsExpired(now) {
+			onlineNodeCount++
+		}
+	}
+
+	shortfall := uint32(0)
+	if onlineNodeCount < c.metaData.MinNodeCount {
+		shortfall = c.metaData.MinNodeCount - onlineNodeCount
+	}
+	return NodeShortfall{
+		MinNodeCount:    c.metaData.MinNodeCount,
+		OnlineNodeCount: onlineNodeCount,
+		Shortfall:       shortfall,
+	}
+}
+
+// GetMemoryStats estimates the memory footprint of the in-memory structures of the cluster, and each structure is
+// visited under its own lock, so the stats of different structures may be taken at slightly different moments.
+func (c *ClusterMetadata) GetMemoryStats() MemoryStats {
+	stats := MemoryStats{
+		TableManager:     c.tableManager.GetMemoryStats(),
+		Topology:         c.topologyManager.GetMemoryStats(),
+		ShardTablesIndex: c.shardTables.memoryStats(),
+		RegisteredNodes:  CacheMemoryStats{EntryCount: 0, ApproximateBytes: 0},
+		TotalBytes:       0,
+	}
+
+	c.lock.RLock()
+	for nodeName, node := range c.registeredNodesCache {
+		stats.RegisteredNodes.addEntry(stringSize(nodeName) + registeredNodeSize(node) + mapEntryOverhead)
+	}
+	c.lock.RUnlock()
+
+	stats.TotalBytes = stats.TableManager.Schemas.ApproximateBytes + stats.Topology.ShardViews.ApproximateBytes +
+		stats.Topology.TableShards.ApproximateBytes + stats.ShardTablesIndex.ApproximateBytes + stats.RegisteredNodes.ApproximateBytes
+	for _, schemaTables := range stats.TableManager.SchemaTables {
+		stats.TotalBytes += schemaTables.Tables.ApproximateBytes
+	}
+	return stats
+}
+
 func (c *ClusterMetadata) GetRegisteredNodeByName(nodeName string) (RegisteredNode, bool) {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
@@ -480,10 +852,18 @@ func (c *ClusterMetadata) AllocShardID(ctx context.Context) (uint32, error) {
 	return uint32(id), nil
 }
 
+// CollectShardID gives back the shard id which is allocated but not used by any shard, so that it can be reused.
+func (c *ClusterMetadata) CollectShardID(ctx context.Context, shardID uint32) error {
+	if err := c.shardIDAlloc.Collect(ctx, uint64(shardID)); err != nil {
+		return errors.WithMessage(err, "collect shard id")
+	}
+	return nil
+}
+
 func (c *ClusterMetadata) RouteTables(_ context.Context, schemaName string, tableNames []string) (RouteTablesResult, error) {
-	routeEntries := make(map[string]RouteEntry, len(tableNames))
-	tables := make(map[storage.TableID]storage.Table, len(tableNames))
-	tableIDs := make([]storage.TableID, 0, len(tableNames))
+	routeVersion := c.topologyManager.GetRouteVersion()
+	// The route entries are keyed by the requested names, which may differ from the stored names in case.
+	routedTables := make([]routedTable, 0, len(tableNames))
 	for _, tableName := range tableNames {
 		table, exists, err := c.tableManager.GetTable(schemaName, tableName)
 		if err != nil {
@@ -492,21 +872,55 @@ func (c *ClusterMetadata) RouteTables(_ context.Context, schemaName string, tabl
 		if !exists {
 			continue
 		}
+		routedTables = append(routedTables, routedTable{key: tableName, schemaName: schemaName, table: table})
+	}
+
+	return c.routeTables(routeVersion, routedTables)
+}
+
+// GetRouteVersion returns the version changed whenever the route of any table may change, which the clients caching the
+// route results can use to validate them.
+func (c *ClusterMetadata) GetRouteVersion() string {
+	return c.topologyManager.GetRouteVersion()
+}
+
+// RouteTablesByIDs routes the tables by their ids, and the route entries are keyed by the decimal ids of the tables. The
+// tables not found are absent from the result.
+func (c *ClusterMetadata) RouteTablesByIDs(_ context.Context, tableIDs []storage.TableID) (RouteTablesResult, error) {
+	routeVersion := c.topologyManager.GetRouteVersion()
+	tables := c.tableManager.GetTablesByIDs(tableIDs)
+	routedTables := make([]routedTable, 0, len(tables))
+	for _, table := range tables {
+		schema, exists := c.tableManager.GetSchemaByID(table.SchemaID)
+		if !exists {
+			return RouteTablesResult{}, ErrSchemaNotFound.WithCausef("schemaID:%d, tableID:%d", table.SchemaID, table.ID)
+		}
+		routedTables = append(routedTables, routedTable{key: strconv.FormatUint(uint64(table.ID), 10), schemaName: schema.Name, table: table})
+	}
+
+	return c.routeTables(routeVersion, routedTables)
+}
+
+// routedTable is the table to route, and its route entry is keyed by the key.
+type routedTable struct {
+	key        string
+	schemaName string
+	table      storage.Table
+}
 
+// routeTables routes the tables found after the routeVersion is taken.
+func (c *ClusterMetadata) routeTables(routeVersion string, routedTables []routedTable) (RouteTablesResult, error) {
+	routeEntries := make(map[string]RouteEntry, len(routedTables))
+	tables := make(map[storage.TableID]routedTable, len(routedTables))
+	tableIDs := make([]storage.TableID, 0, len(routedTables))
+	for _, routed := range routedTables {
 		// TODO: Adapt to the current implementation of the partition table, which may need to be reconstructed later.
-		if !table.IsPartitioned() {
-			tables[table.ID] = table
-			tableIDs = append(tableIDs, table.ID)
+		if !routed.table.IsPartitioned() {
+			tables[routed.table.ID] = routed
+			tableIDs = append(tableIDs, routed.table.ID)
 		} else {
-			routeEntries[table.Name] = RouteEntry{
-				Table: TableInfo{
-					ID:            table.ID,
-					Name:          table.Name,
-					SchemaID:      table.SchemaID,
-					SchemaName:    schemaName,
-					PartitionInfo: table.PartitionInfo,
-					CreatedAt:     table.CreatedAt,
-				},
+			routeEntries[routed.key] = RouteEntry{
+				Table:      newRouteTableInfo(routed),
 				NodeShards: nil,
 			}
 		}
@@ -538,25 +952,30 @@ func (c *ClusterMetadata) RouteTables(_ context.Context, schemaName string, tabl
 			}
 			nodeShardsResult = []ShardNodeWithVersion{nodeShards[selectIndex.Uint64()]}
 		}
-		table := tables[tableID]
-		routeEntries[table.Name] = RouteEntry{
-			Table: TableInfo{
-				ID:            table.ID,
-				Name:          table.Name,
-				SchemaID:      table.SchemaID,
-				SchemaName:    schemaName,
-				PartitionInfo: table.PartitionInfo,
-				CreatedAt:     table.CreatedAt,
-			},
+		routed := tables[tableID]
+		routeEntries[routed.key] = RouteEntry{
+			Table:      newRouteTableInfo(routed),
 			NodeShards: nodeShardsResult,
 		}
 	}
 	return RouteTablesResult{
 		ClusterViewVersion: c.topologyManager.GetVersion(),
+		RouteVersion:       routeVersion,
 		RouteEntries:       routeEntries,
 	}, nil
 }
 
+func newRouteTableInfo(routed routedTable) TableInfo {
+	return TableInfo{
+		ID:            routed.table.ID,
+		Name:          routed.table.Name,
+		SchemaID:      routed.table.SchemaID,
+		SchemaName:    routed.schemaName,
+		PartitionInfo: routed.table.PartitionInfo,
+		CreatedAt:     routed.table.CreatedAt,
+	}
+}
+
 func (c *ClusterMetadata) GetNodeShards(_ context.Context) (GetNodeShardsResult, error) {
 	getNodeShardsResult := c.topologyManager.GetShardNodes()
 
@@ -622,6 +1041,85 @@ func (c *ClusterMetadata) GetCreateTime() uint64 {
 	return c.metaData.CreatedAt
 }
 
+func (c *ClusterMetadata) IsCaseInsensitiveName() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.metaData.CaseInsensitiveName
+}
+
+// NormalizeName returns the schema or table name as the key the table manager resolves it by, which is lower-cased if
+// the cluster resolves the names case-insensitively.
+func (c *ClusterMetadata) NormalizeName(name string) string {
+	if c.IsCaseInsensitiveName() {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+func (c *ClusterMetadata) GetDefaultSchemaName() string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.metaData.DefaultSchemaName
+}
+
+func (c *ClusterMetadata) GetHeartbeatIntervalBounds() storage.HeartbeatIntervalBounds {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.metaData.HeartbeatIntervalBounds
+}
+
+func (c *ClusterMetadata) GetSchemaCreationPolicy() storage.SchemaCreationPolicy {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.metaData.SchemaCreationPolicy
+}
+
+func (c *ClusterMetadata) IsProtected() bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.metaData.Protected
+}
+
+// CheckProtection returns error if the cluster is protected and the destructive operation is not forced with the
+// confirmation token matching the name of the cluster.
+func (c *ClusterMetadata) CheckProtection(operation string, confirmation Confirmation) error {
+	if !c.IsProtected() {
+		return nil
+	}
+	if !confirmation.Force || confirmation.Token != c.Name() {
+		return ErrClusterProtected.WithCausef("%s is forbidden unless it is forced with the confirmation token, cluster:%s", operation, c.Name())
+	}
+	c.logger.Warn("destructive operation on the protected cluster is forced", zap.String("cluster", c.Name()), zap.String("operation", operation))
+	return nil
+}
+
+func (c *ClusterMetadata) GetQuota() storage.ClusterQuota {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return c.metaData.Quota
+}
+
+// GetCordonedNodes returns the names of the cordoned nodes in order.
+func (c *ClusterMetadata) GetCordonedNodes() []string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return slices.Clone(c.metaData.CordonedNodes)
+}
+
+func (c *ClusterMetadata) IsNodeCordoned(nodeName string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	return slices.Contains(c.metaData.CordonedNodes, nodeName)
+}
+
 func (c *ClusterMetadata) GetClusterState() storage.ClusterState {
 	c.lock.RLock()
 	defer c.lock.RUnlock()
@@ -637,17 +1135,25 @@ func (c *ClusterMetadata) GetClusterView() storage.ClusterView {
 	return c.topologyManager.GetClusterView()
 }
 
+// UpdateClusterView updates the cluster view, and the state is only allowed to change along the state machine of the
+// cluster.
 func (c *ClusterMetadata) UpdateClusterView(ctx context.Context, state storage.ClusterState, shardNodes []storage.ShardNode) error {
-	if err := c.topologyManager.UpdateClusterView(ctx, state, shardNodes); err != nil {
-		return errors.WithMessage(err, "update cluster view")
-	}
-	return nil
+	return c.updateClusterView(ctx, state, shardNodes, false, "update cluster view")
 }
 
 func (c *ClusterMetadata) UpdateClusterViewByNode(ctx context.Context, shardNodes map[string][]storage.ShardNode) error {
 	if err := c.topologyManager.UpdateClusterViewByNode(ctx, shardNodes); err != nil {
 		return errors.WithMessage(err, "update cluster view")
 	}
+	c.publishTopologyChanges()
+	return nil
+}
+
+func (c *ClusterMetadata) PromoteShardFollower(ctx context.Context, shardID storage.ShardID, oldLeaderNodeName, newLeaderNodeName string) error {
+	if err := c.topologyManager.PromoteShardFollower(ctx, shardID, oldLeaderNodeName, newLeaderNodeName); err != nil {
+		return errors.WithMessage(err, "promote shard follower")
+	}
+	c.publishTopologyChanges()
 	return nil
 }
 
@@ -655,6 +1161,7 @@ func (c *ClusterMetadata) DropShardNode(ctx context.Context, shardNodes []storag
 	if err := c.topologyManager.DropShardNodes(ctx, shardNodes); err != nil {
 		return errors.WithMessage(err, "drop shard nodes")
 	}
+	c.publishTopologyChanges()
 	return nil
 }
 
@@ -666,10 +1173,30 @@ func (c *ClusterMetadata) CreateShardViews(ctx context.Context, views []CreateSh
 	return nil
 }
 
+// DeleteShardViews deletes the shard views of the shards which have neither tables nor shard nodes.
+func (c *ClusterMetadata) DeleteShardViews(ctx context.Context, shardIDs []storage.ShardID) error {
+	if err := c.topologyManager.DeleteShardViews(ctx, shardIDs); err != nil {
+		return errors.WithMessage(err, "topology manager delete shard views")
+	}
+
+	return nil
+}
+
+// UpdateShardVersionWithExpect updates the version of the shard view only if its current version equals to the expect.
+func (c *ClusterMetadata) UpdateShardVersionWithExpect(ctx context.Context, shardID storage.ShardID, version uint64, expect uint64) error {
+	return c.topologyManager.UpdateShardVersionWithExpect(ctx, shardID, version, expect)
+}
+
 func (c *ClusterMetadata) GetClusterSnapshot() Snapshot {
+	cordonedNodes := c.GetCordonedNodes()
+	cordonedNodeSet := make(map[string]struct{}, len(cordonedNodes))
+	for _, nodeName := range cordonedNodes {
+		cordonedNodeSet[nodeName] = struct{}{}
+	}
 	return Snapshot{
 		Topology:        c.topologyManager.GetTopology(),
 		RegisteredNodes: c.GetRegisteredNodes(),
+		CordonedNodes:   cordonedNodeSet,
 	}
 }
 
@@ -680,6 +1207,80 @@ func (c *ClusterMetadata) GetStorageMetadata() storage.Cluster {
 	return c.metaData
 }
 
+// UpdateTopologyType persists the topology type of the cluster. The running schedulers are not reconciled here, which is
+// left to the topology migration procedure.
+func (c *ClusterMetadata) UpdateTopologyType(ctx context.Context, topologyType storage.TopologyType) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cluster := c.metaData
+	cluster.TopologyType = topologyType
+	cluster.ModifiedAt = clock.UnixMilli(c.clock)
+	if err := c.storage.UpdateCluster(ctx, storage.UpdateClusterRequest{Cluster: cluster}); err != nil {
+		return errors.WithMessage(err, "update cluster topology type")
+	}
+	c.metaData = cluster
+	return nil
+}
+
+// UpdateShardTotal persists the shard total of the cluster, which is changed after the shards are created or deleted by
+// the resharding.
+func (c *ClusterMetadata) UpdateShardTotal(ctx context.Context, shardTotal uint32) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cluster := c.metaData
+	cluster.ShardTotal = shardTotal
+	cluster.ModifiedAt = clock.UnixMilli(c.clock)
+	if err := c.storage.UpdateCluster(ctx, storage.UpdateClusterRequest{Cluster: cluster}); err != nil {
+		return errors.WithMessage(err, "update cluster shard total")
+	}
+	c.metaData = cluster
+	return nil
+}
+
+// CordonNode marks the registered node unschedulable and persists it, so that no new shards are assigned to the node
+// while the shards on it stay. Cordoning a cordoned node does nothing.
+func (c *ClusterMetadata) CordonNode(ctx context.Context, nodeName string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if _, ok := c.registeredNodesCache[nodeName]; !ok {
+		return ErrNodeNotFound.WithCausef("node:%s", nodeName)
+	}
+	if slices.Contains(c.metaData.CordonedNodes, nodeName) {
+		return nil
+	}
+	cordonedNodes := append(slices.Clone(c.metaData.CordonedNodes), nodeName)
+	slices.Sort(cordonedNodes)
+	return c.updateCordonedNodesWithLock(ctx, cordonedNodes)
+}
+
+// UncordonNode makes the node schedulable again, and the node isn't required to be registered, so that the nodes removed
+// from the cluster can be uncordoned too. Uncordoning a schedulable node does nothing.
+func (c *ClusterMetadata) UncordonNode(ctx context.Context, nodeName string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	idx := slices.Index(c.metaData.CordonedNodes, nodeName)
+	if idx < 0 {
+		return nil
+	}
+	cordonedNodes := slices.Delete(slices.Clone(c.metaData.CordonedNodes), idx, idx+1)
+	return c.updateCordonedNodesWithLock(ctx, cordonedNodes)
+}
+
+func (c *ClusterMetadata) updateCordonedNodesWithLock(ctx context.Context, cordonedNodes []string) error {
+	cluster := c.metaData
+	cluster.CordonedNodes = cordonedNodes
+	cluster.ModifiedAt = clock.UnixMilli(c.clock)
+	if err := c.storage.UpdateCluster(ctx, storage.UpdateClusterRequest{Cluster: cluster}); err != nil {
+		return errors.WithMessage(err, "update cluster cordoned nodes")
+	}
+	c.metaData = cluster
+	return nil
+}
+
 // LoadMetadata load cluster metadata from storage.
 func (c *ClusterMetadata) LoadMetadata(ctx context.Context) error {
 	c.lock.Lock()
@@ -690,6 +1291,7 @@ func (c *ClusterMetadata) LoadMetadata(ctx context.Context) error {
 		return errors.WithMessage(err, "get cluster")
 	}
 	c.metaData = metadata
+	c.tableManager.SetClusterQuota(metadata.Quota)
 	return nil
 }
 
@@ -701,6 +1303,14 @@ func (c *ClusterMetadata) GetTables(schemaName string, tableNames []string) ([]s
 	return c.tableManager.GetTables(schemaName, tableNames)
 }
 
+func (c *ClusterMetadata) GetTablesByPrefix(schemaName, prefix string) ([]storage.Table, error) {
+	return c.tableManager.GetTablesByPrefix(schemaName, prefix)
+}
+
+func (c *ClusterMetadata) SearchTables(req SearchTablesRequest) (SearchTablesResult, error) {
+	return c.tableManager.SearchTables(req)
+}
+
 func (c *ClusterMetadata) GetTablesByIDs(tableIDs []storage.TableID) []storage.Table {
 	return c.tableManager.GetTablesByIDs(tableIDs)
 }
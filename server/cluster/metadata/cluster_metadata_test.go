@@ -19,13 +19,18 @@ package metadata_test
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"testing"
 	"time"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/pkg/coderr"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/CeresDB/horaemeta/server/event"
 	"github.com/CeresDB/horaemeta/server/storage"
 	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
 )
 
 func TestClusterMetadata(t *testing.T) {
@@ -41,6 +46,116 @@ func TestClusterMetadata(t *testing.T) {
 	testMetadataOperation(ctx, re, metadata)
 }
 
+func TestNodeShortfall(t *testing.T) {
+	ctx := context.Background()
+	re := require.New(t)
+
+	clk := clock.NewMock(time.Now())
+	m := test.InitStableClusterWithClock(ctx, t, clk).GetMetadata()
+	minNodeCount := m.GetClusterMinNodeCount()
+
+	shortfall := m.GetNodeShortfall()
+	re.Equal(metadata.NodeShortfall{MinNodeCount: minNodeCount, OnlineNodeCount: minNodeCount, Shortfall: 0}, shortfall)
+
+	// All the nodes are expired after a long time without heartbeat.
+	clk.Advance(time.Hour)
+	shortfall = m.GetNodeShortfall()
+	re.Equal(metadata.NodeShortfall{MinNodeCount: minNodeCount, OnlineNodeCount: 0, Shortfall: minNodeCount}, shortfall)
+}
+
+func TestMemoryStats(t *testing.T) {
+	ctx := context.Background()
+	re := require.New(t)
+
+	m := test.InitStableCluster(ctx, t).GetMetadata()
+	stats := m.GetMemoryStats()
+	re.Equal(1, stats.TableManager.Schemas.EntryCount)
+	re.Equal(test.DefaultShardTotal, stats.Topology.ShardViews.EntryCount)
+	re.Equal(len(m.GetRegisteredNodes()), stats.RegisteredNodes.EntryCount)
+
+	tableNames := []string{"memoryStatsTable0", "memoryStatsTable1"}
+	for _, tableName := range tableNames {
+		_, err := m.CreateTable(ctx, metadata.CreateTableRequest{
+			ShardID:       0,
+			LatestVersion: 0,
+			SchemaName:    test.TestSchemaName,
+			TableName:     tableName,
+			PartitionInfo: storage.PartitionInfo{Info: nil},
+		})
+		re.NoError(err)
+	}
+
+	newStats := m.GetMemoryStats()
+	re.Len(newStats.TableManager.SchemaTables, 1)
+	re.Equal(test.TestSchemaName, newStats.TableManager.SchemaTables[0].SchemaName)
+	re.Equal(len(tableNames), newStats.TableManager.SchemaTables[0].Tables.EntryCount)
+	re.Equal(len(tableNames), newStats.Topology.TableShards.EntryCount)
+	re.Greater(newStats.TotalBytes, stats.TotalBytes)
+}
+
+func TestClusterEvents(t *testing.T) {
+	ctx := context.Background()
+	re := require.New(t)
+
+	m := test.InitStableCluster(ctx, t).GetMetadata()
+	bus := event.NewBus(zap.NewNop(), m.Name(), clock.NewRealClock(), event.BusOptions{Capacity: 0, Sinks: nil})
+	m.SetEventPublisher(bus)
+
+	_, err := m.CreateTable(ctx, metadata.CreateTableRequest{
+		ShardID:       0,
+		LatestVersion: 0,
+		SchemaName:    test.TestSchemaName,
+		TableName:     "eventTable",
+		PartitionInfo: storage.PartitionInfo{Info: nil},
+	})
+	re.NoError(err)
+	result := bus.List(0, 0)
+	re.Len(result.Events, 1)
+	re.Equal(event.TypeTableCreated, result.Events[0].Type)
+	re.Equal("eventTable", result.Events[0].Attributes[event.AttrTableName])
+
+	// The state change and the shard losing its leader are published, and nothing is published if the view is unchanged.
+	shardNodes := m.GetClusterSnapshot().Topology.ClusterView.ShardNodes
+	re.NoError(m.UpdateClusterView(ctx, storage.ClusterStatePrepare, shardNodes[1:]))
+	re.NoError(m.UpdateClusterView(ctx, storage.ClusterStatePrepare, shardNodes[1:]))
+	result = bus.List(result.LastID, 0)
+	re.Len(result.Events, 2)
+	re.Equal(event.TypeClusterStateChanged, result.Events[0].Type)
+	re.Equal(storage.ConvertClusterStateToString(storage.ClusterStatePrepare), result.Events[0].Attributes[event.AttrToState])
+	re.Equal(event.TypeShardMoved, result.Events[1].Type)
+	re.Equal(strconv.FormatUint(uint64(shardNodes[0].ID), 10), result.Events[1].Attributes[event.AttrShardID])
+	re.Equal(shardNodes[0].NodeName, result.Events[1].Attributes[event.AttrFromNode])
+	re.Equal("", result.Events[1].Attributes[event.AttrToNode])
+}
+
+func TestCordonNode(t *testing.T) {
+	ctx := context.Background()
+	re := require.New(t)
+
+	m := test.InitStableCluster(ctx, t).GetMetadata()
+	nodeName := m.GetRegisteredNodes()[0].Node.Name
+
+	re.NoError(m.CordonNode(ctx, nodeName))
+	re.NoError(m.CordonNode(ctx, nodeName))
+	re.True(m.IsNodeCordoned(nodeName))
+	re.Equal([]string{nodeName}, m.GetCordonedNodes())
+	re.True(m.GetClusterSnapshot().IsCordoned(nodeName))
+
+	err := m.CordonNode(ctx, "unknownNode")
+	re.True(coderr.Is(err, metadata.ErrNodeNotFound.Code()))
+
+	// The cordoned nodes are persisted and loaded again by the new leader.
+	re.NoError(m.LoadMetadata(ctx))
+	re.True(m.IsNodeCordoned(nodeName))
+
+	re.NoError(m.UncordonNode(ctx, nodeName))
+	re.NoError(m.UncordonNode(ctx, nodeName))
+	re.NoError(m.LoadMetadata(ctx))
+	re.False(m.IsNodeCordoned(nodeName))
+	re.Empty(m.GetCordonedNodes())
+	re.False(m.GetClusterSnapshot().IsCordoned(nodeName))
+}
+
 func testUpdateClusterView(ctx context.Context, re *require.Assertions, m *metadata.ClusterMetadata) {
 	// Remove a shard on node.
 	currentShardNodes := m.GetClusterSnapshot().Topology.ClusterView.ShardNodes
@@ -64,12 +179,53 @@ func testUpdateClusterView(ctx context.Context, re *require.Assertions, m *metad
 	re.Equal(len(currentShardNodes)-1, len(m.GetClusterSnapshot().Topology.ClusterView.ShardNodes))
 
 	// Update cluster state and reset shardNodes.
-	err = m.UpdateClusterView(ctx, storage.ClusterStateEmpty, currentShardNodes)
+	err = m.UpdateClusterView(ctx, storage.ClusterStatePrepare, currentShardNodes)
 	re.NoError(err)
-	re.Equal(storage.ClusterStateEmpty, m.GetClusterState())
+	re.Equal(storage.ClusterStatePrepare, m.GetClusterState())
 	re.Equal(len(currentShardNodes), len(m.GetClusterSnapshot().Topology.ClusterView.ShardNodes))
 }
 
+func TestClusterStateTransition(t *testing.T) {
+	ctx := context.Background()
+	re := require.New(t)
+
+	m := test.InitStableCluster(ctx, t).GetMetadata()
+	shardNodes := m.GetClusterSnapshot().Topology.ClusterView.ShardNodes
+	history := m.GetClusterStateStatus().History
+
+	// The cluster can't be back to empty unless forced.
+	re.True(coderr.Is(m.UpdateClusterView(ctx, storage.ClusterStateEmpty, shardNodes), metadata.ErrInvalidClusterStateTransition.Code()))
+	re.True(coderr.Is(m.TransitClusterState(ctx, storage.ClusterStateEmpty, false, "reset"), metadata.ErrInvalidClusterStateTransition.Code()))
+	re.Equal(storage.ClusterStateStable, m.GetClusterState())
+
+	re.NoError(m.TransitClusterState(ctx, storage.ClusterStateEmpty, true, "reset"))
+	re.Equal(storage.ClusterStateEmpty, m.GetClusterState())
+	re.Equal(len(shardNodes), len(m.GetClusterSnapshot().Topology.ClusterView.ShardNodes))
+
+	// The view updates without changing the state are not recorded.
+	re.NoError(m.UpdateClusterView(ctx, storage.ClusterStateEmpty, shardNodes))
+	re.NoError(m.UpdateClusterView(ctx, storage.ClusterStatePrepare, shardNodes))
+	re.True(coderr.Is(m.UpdateClusterView(ctx, storage.ClusterStateEmpty, shardNodes), metadata.ErrInvalidClusterStateTransition.Code()))
+	re.NoError(m.TransitClusterState(ctx, storage.ClusterStateStable, false, "assigned"))
+
+	status := m.GetClusterStateStatus()
+	re.Equal("stable", status.State)
+	re.Len(status.History, len(history)+3)
+	transitions := status.History[len(history):]
+	re.Equal(metadata.ClusterStateTransition{From: "stable", To: "empty", Forced: true, Reason: "reset", Version: transitions[0].Version, Time: transitions[0].Time}, transitions[0])
+	re.Equal("empty", transitions[1].From)
+	re.Equal("prepare", transitions[1].To)
+	re.False(transitions[1].Forced)
+	re.Equal("assigned", transitions[2].Reason)
+	re.Equal(m.GetClusterView().Version, transitions[2].Version)
+
+	state, err := metadata.ParseClusterState("PREPARE")
+	re.NoError(err)
+	re.Equal(storage.ClusterStatePrepare, state)
+	_, err = metadata.ParseClusterState("unknown")
+	re.True(coderr.Is(err, metadata.ErrInvalidClusterStateTransition.Code()))
+}
+
 func testRegisterNode(ctx context.Context, re *require.Assertions, m *metadata.ClusterMetadata) {
 	currentShardNodes := m.GetClusterSnapshot().Topology.ClusterView.ShardNodes
 	currentRegisterNodes := m.GetRegisteredNodes()
@@ -84,6 +240,7 @@ func testRegisterNode(ctx context.Context, re *require.Assertions, m *metadata.C
 			State:         0,
 		},
 		ShardInfos: nil,
+		Heartbeat:  metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
 	})
 	re.NoError(err)
 	re.Equal(len(currentRegisterNodes)+1, len(m.GetRegisteredNodes()))
@@ -100,6 +257,18 @@ func testRegisterNode(ctx context.Context, re *require.Assertions, m *metadata.C
 	node, exists = m.GetRegisteredNodeByName(newNodeName)
 	re.True(exists)
 	re.Equal(lastTouchTime, node.Node.LastTouchTime)
+	re.Equal(metadata.NodeExpiredThreshold, node.ExpiredThreshold())
+
+	// The jitter is tracked by the gaps between the heartbeats with the negotiated timing.
+	node.Heartbeat = metadata.HeartbeatStats{LeaseMs: 3000, IntervalMs: 1000, JitterMs: 0}
+	node.Node.LastTouchTime = lastTouchTime + 1800
+	re.NoError(m.RegisterNode(ctx, node))
+	node, exists = m.GetRegisteredNodeByName(newNodeName)
+	re.True(exists)
+	re.Equal(uint64(200), node.Heartbeat.JitterMs)
+	re.Equal(3200*time.Millisecond, node.ExpiredThreshold())
+	re.False(node.IsExpired(time.UnixMilli(int64(node.Node.LastTouchTime + 3100))))
+	re.True(node.IsExpired(time.UnixMilli(int64(node.Node.LastTouchTime + 3300))))
 
 	// Reset shardNodes.
 	err = m.UpdateClusterView(ctx, storage.ClusterStateStable, currentShardNodes)
@@ -162,6 +331,9 @@ func testTableOperation(ctx context.Context, re *require.Assertions, m *metadata
 	re.NoError(err)
 	re.Equal(1, len(routeResult.RouteEntries))
 
+	re.Equal(m.GetRouteVersion(), routeResult.RouteVersion)
+	routeVersion := routeResult.RouteVersion
+
 	// Migrate this table to another shard.
 	err = m.MigrateTable(ctx, metadata.MigrateTableRequest{
 		SchemaName: testSchema,
@@ -176,13 +348,54 @@ func testTableOperation(ctx context.Context, re *require.Assertions, m *metadata
 	re.NoError(err)
 	re.Equal(1, len(routeResult.RouteEntries))
 	re.Equal(storage.ShardID(1), routeResult.RouteEntries[testTableName].NodeShards[0].ShardInfo.ID)
+	// The route version changes even though the cluster view is not updated.
+	re.NotEqual(routeVersion, routeResult.RouteVersion)
+
+	// Move this table back, and the versions of both shards are updated.
+	err = m.MoveTables(ctx, metadata.MoveTablesRequest{
+		Tables: []storage.Table{createResult.Table},
+		Source: metadata.ShardVersionUpdate{ShardID: 1, LatestVersion: 1},
+		Target: metadata.ShardVersionUpdate{ShardID: 0, LatestVersion: 1},
+	})
+	re.NoError(err)
+	routeResult, err = m.RouteTables(ctx, testSchema, []string{testTableName})
+	re.NoError(err)
+	re.Equal(1, len(routeResult.RouteEntries[testTableName].NodeShards))
+	re.Equal(storage.ShardID(0), routeResult.RouteEntries[testTableName].NodeShards[0].ShardInfo.ID)
+
+	// Route the table by its id, and the unknown table is absent from the result.
+	tableIDKey := strconv.FormatUint(uint64(createResult.Table.ID), 10)
+	routeResult, err = m.RouteTablesByIDs(ctx, []storage.TableID{createResult.Table.ID, createResult.Table.ID + 1000})
+	re.NoError(err)
+	re.Len(routeResult.RouteEntries, 1)
+	re.Equal(testSchema, routeResult.RouteEntries[tableIDKey].Table.SchemaName)
+	re.Equal(testTableName, routeResult.RouteEntries[tableIDKey].Table.Name)
+	re.Equal(storage.ShardID(0), routeResult.RouteEntries[tableIDKey].NodeShards[0].ShardInfo.ID)
+	shardTables := m.GetShardTables([]storage.ShardID{0, 1})
+	re.Equal(uint64(1), shardTables[0].Shard.Version)
+	re.Equal(uint64(1), shardTables[1].Shard.Version)
+	re.Len(shardTables[0].Page(0, 1).Tables, 1)
+	re.Empty(shardTables[0].Page(1, 1).Tables)
+	re.Empty(shardTables[0].Page(2, 0).Tables)
+	shardTableCounts := m.GetShardTableCounts([]storage.ShardID{0, 1})
+	re.Equal(1, shardTableCounts[0].TableCount)
+	re.Equal(0, shardTableCounts[1].TableCount)
+	re.Equal(uint64(1), shardTableCounts[1].Shard.Version)
+
+	// The table is not in shard 1 any more.
+	err = m.MoveTables(ctx, metadata.MoveTablesRequest{
+		Tables: []storage.Table{createResult.Table},
+		Source: metadata.ShardVersionUpdate{ShardID: 1, LatestVersion: 2},
+		Target: metadata.ShardVersionUpdate{ShardID: 0, LatestVersion: 2},
+	})
+	re.True(coderr.Is(err, metadata.ErrTableNotFound.Code()))
 
 	// Drop table already created.
 	err = m.DropTable(ctx, metadata.DropTableRequest{
 		SchemaName:    testSchema,
 		TableName:     testTableName,
-		ShardID:       storage.ShardID(1),
-		LatestVersion: 0,
+		ShardID:       storage.ShardID(0),
+		LatestVersion: 2,
 	})
 	re.NoError(err)
 }
@@ -226,7 +439,9 @@ func testMetadataOperation(ctx context.Context, re *require.Assertions, m *metad
 	err = m.Load(ctx)
 	re.NoError(err)
 
-	// Load metadata from storage, it will throw error because it is not persisted。
+	// Load metadata from storage, it is the same as the one persisted when the cluster is created.
+	storageMetadata := m.GetStorageMetadata()
 	err = m.LoadMetadata(ctx)
-	re.Error(err)
+	re.NoError(err)
+	re.Equal(storageMetadata, m.GetStorageMetadata())
 }
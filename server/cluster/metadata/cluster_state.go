@@ -0,0 +1,159 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// maxClusterStateHistory bounds the transitions kept in memory, which are enough to find out how the cluster got stuck.
+const maxClusterStateHistory = 64
+
+// clusterStateTransitions is the state machine of the cluster:
+//   - EMPTY is the initial state, and the cluster is PREPARE once enough nodes are registered, or STABLE directly if the
+//     shards are assigned on creation;
+//   - PREPARE turns to STABLE after all the shards are assigned;
+//   - STABLE turns back to PREPARE if the shards need to be assigned again.
+//
+// The cluster view can always be updated without changing the state, and the other transitions, e.g. back to EMPTY, are
+// only allowed to be forced by the operators.
+var clusterStateTransitions = map[storage.ClusterState][]storage.ClusterState{
+	storage.ClusterStateEmpty:   {storage.ClusterStatePrepare, storage.ClusterStateStable},
+	storage.ClusterStatePrepare: {storage.ClusterStateStable},
+	storage.ClusterStateStable:  {storage.ClusterStatePrepare},
+}
+
+// ClusterStateTransition is a change of the cluster state made by this server.
+type ClusterStateTransition struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Forced bool   `json:"forced"`
+	Reason string `json:"reason"`
+	// Version is the version of the cluster view the transition results in.
+	Version uint64 `json:"version"`
+	// Time is the unix timestamp in milliseconds of the transition.
+	Time int64 `json:"time"`
+}
+
+type ClusterStateStatus struct {
+	State string `json:"state"`
+	// History is the transitions made since the server serves the cluster, the oldest first.
+	History []ClusterStateTransition `json:"history"`
+}
+
+// ParseClusterState parses the state in the form of storage.ConvertClusterStateToString, and it is case-insensitive.
+func ParseClusterState(rawString string) (storage.ClusterState, error) {
+	for _, state := range []storage.ClusterState{storage.ClusterStateEmpty, storage.ClusterStatePrepare, storage.ClusterStateStable} {
+		if strings.EqualFold(rawString, storage.ConvertClusterStateToString(state)) {
+			return state, nil
+		}
+	}
+	return 0, errors.WithMessagef(ErrInvalidClusterStateTransition, "unknown cluster state, state:%s", rawString)
+}
+
+func checkClusterStateTransition(from, to storage.ClusterState) error {
+	if from == to {
+		return nil
+	}
+	for _, state := range clusterStateTransitions[from] {
+		if state == to {
+			return nil
+		}
+	}
+	return errors.WithMessagef(ErrInvalidClusterStateTransition, "from:%s, to:%s", storage.ConvertClusterStateToString(from), storage.ConvertClusterStateToString(to))
+}
+
+// clusterStateMachine serializes the updates of the cluster view changing the state, so that every transition is
+// validated against the state it really changes from.
+type clusterStateMachine struct {
+	lock    sync.Mutex
+	history []ClusterStateTransition
+}
+
+func newClusterStateMachine() *clusterStateMachine {
+	return &clusterStateMachine{
+		lock:    sync.Mutex{},
+		history: []ClusterStateTransition{},
+	}
+}
+
+func (m *clusterStateMachine) record(transition ClusterStateTransition) {
+	m.history = append(m.history, transition)
+	if len(m.history) > maxClusterStateHistory {
+		m.history = m.history[len(m.history)-maxClusterStateHistory:]
+	}
+}
+
+// updateClusterView updates the cluster view with the state, and the shard nodes of the current view are kept if
+// shardNodes is nil.
+func (c *ClusterMetadata) updateClusterView(ctx context.Context, state storage.ClusterState, shardNodes []storage.ShardNode, force bool, reason string) error {
+	c.stateMachine.lock.Lock()
+	defer c.stateMachine.lock.Unlock()
+
+	view := c.topologyManager.GetClusterView()
+	if err := checkClusterStateTransition(view.State, state); err != nil {
+		if !force {
+			return err
+		}
+		c.logger.Warn("force cluster state transition", zap.Uint32("clusterID", uint32(c.clusterID)), zap.String("from", storage.ConvertClusterStateToString(view.State)), zap.String("to", storage.ConvertClusterStateToString(state)), zap.String("reason", reason))
+	}
+	if shardNodes == nil {
+		shardNodes = view.ShardNodes
+	}
+
+	if err := c.topologyManager.UpdateClusterView(ctx, state, shardNodes); err != nil {
+		return errors.WithMessage(err, "update cluster view")
+	}
+	if view.State != state {
+		transition := ClusterStateTransition{
+			From:    storage.ConvertClusterStateToString(view.State),
+			To:      storage.ConvertClusterStateToString(state),
+			Forced:  force,
+			Reason:  reason,
+			Version: c.topologyManager.GetClusterView().Version,
+			Time:    c.clock.Now().UnixMilli(),
+		}
+		c.stateMachine.record(transition)
+		c.logger.Info("cluster state transition", zap.Uint32("clusterID", uint32(c.clusterID)), zap.String("from", transition.From), zap.String("to", transition.To), zap.Bool("forced", force), zap.String("reason", reason))
+	}
+	c.publishTopologyChanges()
+	return nil
+}
+
+// TransitClusterState changes the state of the cluster with the shard nodes kept, which is the override of the operators,
+// and the transitions out of the state machine are made only if forced.
+func (c *ClusterMetadata) TransitClusterState(ctx context.Context, state storage.ClusterState, force bool, reason string) error {
+	return c.updateClusterView(ctx, state, nil, force, reason)
+}
+
+func (c *ClusterMetadata) GetClusterStateStatus() ClusterStateStatus {
+	c.stateMachine.lock.Lock()
+	defer c.stateMachine.lock.Unlock()
+
+	history := make([]ClusterStateTransition, len(c.stateMachine.history))
+	copy(history, c.stateMachine.history)
+	return ClusterStateStatus{
+		State:   storage.ConvertClusterStateToString(c.topologyManager.GetClusterState()),
+		History: history,
+	}
+}
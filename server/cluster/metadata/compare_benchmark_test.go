@@ -40,6 +40,7 @@ func buildRegisterNode(shardNumber int) RegisteredNode {
 			State:         storage.NodeStateUnknown,
 		},
 		ShardInfos: shardInfos,
+		Heartbeat:  HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
 	}
 }
 
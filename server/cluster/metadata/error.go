@@ -19,18 +19,35 @@ package metadata
 import "github.com/CeresDB/horaemeta/pkg/coderr"
 
 var (
-	ErrCreateCluster        = coderr.NewCodeError(coderr.BadRequest, "create cluster")
-	ErrUpdateCluster        = coderr.NewCodeError(coderr.Internal, "update cluster")
-	ErrStartCluster         = coderr.NewCodeError(coderr.Internal, "start cluster")
-	ErrClusterAlreadyExists = coderr.NewCodeError(coderr.ClusterAlreadyExists, "cluster already exists")
-	ErrClusterNotFound      = coderr.NewCodeError(coderr.NotFound, "cluster not found")
-	ErrClusterStateInvalid  = coderr.NewCodeError(coderr.Internal, "cluster state invalid")
-	ErrSchemaNotFound       = coderr.NewCodeError(coderr.NotFound, "schema not found")
-	ErrTableNotFound        = coderr.NewCodeError(coderr.NotFound, "table not found")
-	ErrShardNotFound        = coderr.NewCodeError(coderr.NotFound, "shard not found")
-	ErrVersionNotFound      = coderr.NewCodeError(coderr.NotFound, "version not found")
-	ErrNodeNotFound         = coderr.NewCodeError(coderr.NotFound, "NodeName not found")
-	ErrTableAlreadyExists   = coderr.NewCodeError(coderr.Internal, "table already exists")
-	ErrOpenTable            = coderr.NewCodeError(coderr.Internal, "open table")
-	ErrParseTopologyType    = coderr.NewCodeError(coderr.Internal, "parse topology type")
+	ErrCreateCluster                 = coderr.NewCodeError(coderr.BadRequest, "create cluster")
+	ErrUpdateCluster                 = coderr.NewCodeError(coderr.Internal, "update cluster")
+	ErrStartCluster                  = coderr.NewCodeError(coderr.Internal, "start cluster")
+	ErrClusterAlreadyExists          = coderr.NewCodeError(coderr.ClusterAlreadyExists, "cluster already exists")
+	ErrClusterNotFound               = coderr.NewCodeError(coderr.NotFound, "cluster not found")
+	ErrClusterStateInvalid           = coderr.NewCodeError(coderr.Internal, "cluster state invalid")
+	ErrInvalidClusterStateTransition = coderr.NewCodeError(coderr.BadRequest, "invalid cluster state transition")
+	ErrSchemaNotFound                = coderr.NewCodeError(coderr.NotFound, "schema not found")
+	ErrSchemaTableQuotaExceeded      = coderr.NewCodeError(coderr.TooManyRequests, "table count of schema exceeds quota")
+	ErrClusterTableQuotaExceeded     = coderr.NewCodeError(coderr.TooManyRequests, "table count of cluster exceeds quota")
+	ErrClusterShardQuotaExceeded     = coderr.NewCodeError(coderr.TooManyRequests, "shard count of cluster exceeds quota")
+	ErrInvalidSchemaSettings         = coderr.NewCodeError(coderr.InvalidParams, "invalid schema settings")
+	ErrTableNotFound                 = coderr.NewCodeError(coderr.NotFound, "table not found")
+	ErrShardNotFound                 = coderr.NewCodeError(coderr.NotFound, "shard not found")
+	ErrShardNotEmpty                 = coderr.NewCodeError(coderr.Internal, "shard not empty")
+	ErrVersionNotFound               = coderr.NewCodeError(coderr.NotFound, "version not found")
+	ErrNodeNotFound                  = coderr.NewCodeError(coderr.NotFound, "NodeName not found")
+	ErrTableAlreadyExists            = coderr.NewCodeError(coderr.Internal, "table already exists")
+	ErrOpenTable                     = coderr.NewCodeError(coderr.Internal, "open table")
+	ErrParseTopologyType             = coderr.NewCodeError(coderr.Internal, "parse topology type")
+	ErrRegistrationTokenNotFound     = coderr.NewCodeError(coderr.NotFound, "registration token not found")
+	ErrInvalidRegistrationToken      = coderr.NewCodeError(coderr.Unauthorized, "invalid registration token")
+	ErrInvalidHeartbeatInterval      = coderr.NewCodeError(coderr.InvalidParams, "invalid heartbeat interval bounds")
+	ErrInvalidSchemaCreationPolicy   = coderr.NewCodeError(coderr.InvalidParams, "invalid schema creation policy")
+	ErrClusterProtected              = coderr.NewCodeError(coderr.Forbidden, "cluster is protected")
+	ErrTenantNotFound                = coderr.NewCodeError(coderr.NotFound, "tenant not found")
+	ErrInvalidTenant                 = coderr.NewCodeError(coderr.InvalidParams, "invalid tenant")
+	ErrTenantTokenNotFound           = coderr.NewCodeError(coderr.NotFound, "tenant token not found")
+	ErrInvalidTenantToken            = coderr.NewCodeError(coderr.Unauthorized, "invalid tenant token")
+	ErrTenantTokenRequired           = coderr.NewCodeError(coderr.Unauthorized, "tenant token required")
+	ErrTenantAccessDenied            = coderr.NewCodeError(coderr.Forbidden, "tenant access denied")
 )
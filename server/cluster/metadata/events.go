@@ -0,0 +1,128 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/CeresDB/horaemeta/server/event"
+	"github.com/CeresDB/horaemeta/server/storage"
+)
+
+// clusterEvents publishes the changes of the cluster. The changes of the shard leaders and the cluster state are found by
+// comparing the current cluster view with the one published last time, so the changes made concurrently are neither
+// missed nor published twice.
+type clusterEvents struct {
+	lock      sync.Mutex
+	publisher event.Publisher
+	// shardLeaders maps the shards to their leader nodes published last time.
+	shardLeaders map[storage.ShardID]string
+	state        storage.ClusterState
+}
+
+func newClusterEvents() *clusterEvents {
+	return &clusterEvents{
+		lock:         sync.Mutex{},
+		publisher:    event.NewNoopPublisher(),
+		shardLeaders: map[storage.ShardID]string{},
+		state:        storage.ClusterStateEmpty,
+	}
+}
+
+func getShardLeaders(view storage.ClusterView) map[storage.ShardID]string {
+	shardLeaders := make(map[storage.ShardID]string, len(view.ShardNodes))
+	for _, shardNode := range view.ShardNodes {
+		if shardNode.ShardRole == storage.ShardRoleLeader {
+			shardLeaders[shardNode.ID] = shardNode.NodeName
+		}
+	}
+	return shardLeaders
+}
+
+// reset sets the publisher, and the changes are published against the view from now on.
+func (e *clusterEvents) reset(publisher event.Publisher, view storage.ClusterView) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.publisher = publisher
+	e.shardLeaders = getShardLeaders(view)
+	e.state = view.State
+}
+
+func (e *clusterEvents) getPublisher() event.Publisher {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.publisher
+}
+
+func (e *clusterEvents) publishChanges(view storage.ClusterView) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if view.State != e.state {
+		e.publisher.Publish(event.TypeClusterStateChanged, map[string]string{
+			event.AttrFromState: storage.ConvertClusterStateToString(e.state),
+			event.AttrToState:   storage.ConvertClusterStateToString(view.State),
+		})
+		e.state = view.State
+	}
+
+	shardLeaders := getShardLeaders(view)
+	for shardID, toNode := range shardLeaders {
+		if fromNode := e.shardLeaders[shardID]; fromNode != toNode {
+			e.publishShardMoved(shardID, fromNode, toNode)
+		}
+	}
+	// The shards without leaders are published as moved to no node.
+	for shardID, fromNode := range e.shardLeaders {
+		if _, ok := shardLeaders[shardID]; !ok {
+			e.publishShardMoved(shardID, fromNode, "")
+		}
+	}
+	e.shardLeaders = shardLeaders
+}
+
+func (e *clusterEvents) publishShardMoved(shardID storage.ShardID, fromNode, toNode string) {
+	e.publisher.Publish(event.TypeShardMoved, map[string]string{
+		event.AttrShardID:  strconv.FormatUint(uint64(shardID), 10),
+		event.AttrFromNode: fromNode,
+		event.AttrToNode:   toNode,
+	})
+}
+
+// SetEventPublisher sets the publisher of the changes of the cluster, which is set when the cluster is served by the
+// leader.
+func (c *ClusterMetadata) SetEventPublisher(publisher event.Publisher) {
+	c.events.reset(publisher, c.topologyManager.GetClusterView())
+}
+
+func (c *ClusterMetadata) publishTopologyChanges() {
+	c.events.publishChanges(c.topologyManager.GetClusterView())
+}
+
+func (c *ClusterMetadata) publishTableEvents(typ event.Type, schemaName string, tables ...storage.Table) {
+	publisher := c.events.getPublisher()
+	for _, table := range tables {
+		publisher.Publish(typ, map[string]string{
+			event.AttrSchemaName: schemaName,
+			event.AttrTableName:  table.Name,
+			event.AttrTableID:    strconv.FormatUint(uint64(table.ID), 10),
+		})
+	}
+}
@@ -0,0 +1,195 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/pkg/errors"
+)
+
+type FsckViolationKind string
+
+const (
+	// FsckDanglingTable means the shard view references a table which does not exist in any schema.
+	FsckDanglingTable FsckViolationKind = "danglingTable"
+	// FsckDuplicateTable means the table is referenced by more than one shard view.
+	FsckDuplicateTable FsckViolationKind = "duplicateTable"
+	// FsckMissingShard means the cluster view assigns a shard without the shard view to a node.
+	FsckMissingShard FsckViolationKind = "missingShard"
+	// FsckTableVersionAhead means the table is recorded to be added to the shard at a version later than the shard's.
+	FsckTableVersionAhead FsckViolationKind = "tableVersionAhead"
+	// FsckCacheVersionMismatch means the version of the cached shard view or cluster view differs from the stored one.
+	FsckCacheVersionMismatch FsckViolationKind = "cacheVersionMismatch"
+)
+
+type FsckViolation struct {
+	Kind       FsckViolationKind `json:"kind"`
+	Detail     string            `json:"detail"`
+	Suggestion string            `json:"suggestion"`
+}
+
+// FsckReport is the result of checking the invariants of the cluster metadata in the storage.
+type FsckReport struct {
+	SchemaCount int             `json:"schemaCount"`
+	TableCount  int             `json:"tableCount"`
+	ShardCount  int             `json:"shardCount"`
+	Violations  []FsckViolation `json:"violations"`
+}
+
+// fsckInput is the metadata read from the storage, and the cached topology is compared with it.
+type fsckInput struct {
+	schemas        []storage.Schema
+	tables         []storage.Table
+	shardViews     []storage.ShardView
+	clusterView    storage.ClusterView
+	cachedTopology Topology
+}
+
+// Fsck reads the metadata of the cluster from the storage rather than the caches, and reports the violations of the
+// invariants with the suggested fixes. Nothing is fixed by it.
+func (c *ClusterMetadata) Fsck(ctx context.Context) (FsckReport, error) {
+	var report FsckReport
+
+	schemasResult, err := c.storage.ListSchemas(ctx, storage.ListSchemasRequest{ClusterID: c.clusterID})
+	if err != nil {
+		return report, errors.WithMessage(err, "list schemas")
+	}
+	tables := make([]storage.Table, 0)
+	for _, schema := range schemasResult.Schemas {
+		tablesResult, err := c.storage.ListTables(ctx, storage.ListTableRequest{ClusterID: c.clusterID, SchemaID: schema.ID})
+		if err != nil {
+			return report, errors.WithMessagef(err, "list tables, schema:%s", schema.Name)
+		}
+		tables = append(tables, tablesResult.Tables...)
+	}
+	shardViewsResult, err := c.storage.ListShardViews(ctx, storage.ListShardViewsRequest{ClusterID: c.clusterID, ShardIDs: []storage.ShardID{}})
+	if err != nil {
+		return report, errors.WithMessage(err, "list shard views")
+	}
+	clusterViewResult, err := c.storage.GetClusterView(ctx, storage.GetClusterViewRequest{ClusterID: c.clusterID})
+	if err != nil {
+		return report, errors.WithMessage(err, "get cluster view")
+	}
+
+	return fsck(fsckInput{
+		schemas:        schemasResult.Schemas,
+		tables:         tables,
+		shardViews:     shardViewsResult.ShardViews,
+		clusterView:    clusterViewResult.ClusterView,
+		cachedTopology: c.topologyManager.GetTopology(),
+	}), nil
+}
+
+func fsck(input fsckInput) FsckReport {
+	violations := make([]FsckViolation, 0)
+
+	tableNames := make(map[storage.TableID]string, len(input.tables))
+	for _, table := range input.tables {
+		tableNames[table.ID] = table.Name
+	}
+
+	shardViews := make([]storage.ShardView, len(input.shardViews))
+	copy(shardViews, input.shardViews)
+	sort.Slice(shardViews, func(i, j int) bool {
+		return shardViews[i].ShardID < shardViews[j].ShardID
+	})
+
+	shardViewsMapping := make(map[storage.ShardID]storage.ShardView, len(shardViews))
+	tableShards := make(map[storage.TableID][]storage.ShardID)
+	for _, shardView := range shardViews {
+		shardViewsMapping[shardView.ShardID] = shardView
+		for _, tableID := range shardView.TableIDs {
+			if _, exists := tableNames[tableID]; !exists {
+				violations = append(violations, FsckViolation{
+					Kind:       FsckDanglingTable,
+					Detail:     fmt.Sprintf("table not found in any schema, shardID:%d, tableID:%d", shardView.ShardID, tableID),
+					Suggestion: fmt.Sprintf("remove the table %d from the shard view of shard %d", tableID, shardView.ShardID),
+				})
+			}
+			tableShards[tableID] = append(tableShards[tableID], shardView.ShardID)
+
+			if version, ok := shardView.TableVersions[tableID]; ok && version > shardView.Version {
+				violations = append(violations, FsckViolation{
+					Kind:       FsckTableVersionAhead,
+					Detail:     fmt.Sprintf("table is added at a later version than the shard, shardID:%d, tableID:%d, tableVersion:%d, shardVersion:%d", shardView.ShardID, tableID, version, shardView.Version),
+					Suggestion: fmt.Sprintf("reset the version of the table %d to the version of shard %d", tableID, shardView.ShardID),
+				})
+			}
+		}
+	}
+
+	tableIDs := make([]storage.TableID, 0, len(tableShards))
+	for tableID := range tableShards {
+		tableIDs = append(tableIDs, tableID)
+	}
+	sort.Slice(tableIDs, func(i, j int) bool {
+		return tableIDs[i] < tableIDs[j]
+	})
+	for _, tableID := range tableIDs {
+		shardIDs := tableShards[tableID]
+		if len(shardIDs) < 2 {
+			continue
+		}
+		violations = append(violations, FsckViolation{
+			Kind:       FsckDuplicateTable,
+			Detail:     fmt.Sprintf("table is referenced by multiple shard views, tableID:%d, tableName:%s, shardIDs:%v", tableID, tableNames[tableID], shardIDs),
+			Suggestion: fmt.Sprintf("keep the table %d on the shard actually serving it, and remove it from the others", tableID),
+		})
+	}
+
+	for _, shardNode := range input.clusterView.ShardNodes {
+		if _, exists := shardViewsMapping[shardNode.ID]; !exists {
+			violations = append(violations, FsckViolation{
+				Kind:       FsckMissingShard,
+				Detail:     fmt.Sprintf("shard in cluster view has no shard view, shardID:%d, node:%s", shardNode.ID, shardNode.NodeName),
+				Suggestion: fmt.Sprintf("remove the shard %d from the cluster view, or create the shard view for it", shardNode.ID),
+			})
+		}
+	}
+
+	// The caches are updated only after the storage, so a cached version differing from the stored one means the caches
+	// have missed some updates, or the storage is modified by others.
+	if cachedVersion := input.cachedTopology.ClusterView.Version; cachedVersion != input.clusterView.Version {
+		violations = append(violations, FsckViolation{
+			Kind:       FsckCacheVersionMismatch,
+			Detail:     fmt.Sprintf("cluster view version mismatch, cachedVersion:%d, storedVersion:%d", cachedVersion, input.clusterView.Version),
+			Suggestion: "transfer the leader to reload the cluster metadata from storage",
+		})
+	}
+	for _, shardView := range shardViews {
+		cached, exists := input.cachedTopology.ShardViewsMapping[shardView.ShardID]
+		if !exists || cached.Version == shardView.Version {
+			continue
+		}
+		violations = append(violations, FsckViolation{
+			Kind:       FsckCacheVersionMismatch,
+			Detail:     fmt.Sprintf("shard view version mismatch, shardID:%d, cachedVersion:%d, storedVersion:%d", shardView.ShardID, cached.Version, shardView.Version),
+			Suggestion: "transfer the leader to reload the cluster metadata from storage",
+		})
+	}
+
+	return FsckReport{
+		SchemaCount: len(input.schemas),
+		TableCount:  len(input.tables),
+		ShardCount:  len(shardViews),
+		Violations:  violations,
+	}
+}
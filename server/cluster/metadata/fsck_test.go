@@ -0,0 +1,88 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"testing"
+
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFsck(t *testing.T) {
+	re := require.New(t)
+
+	schemas := []storage.Schema{{ID: 0, ClusterID: 0, Name: "public", CreatedAt: 0}}
+	tables := []storage.Table{
+		{ID: 1, Name: "table1", SchemaID: 0, CreatedAt: 0, PartitionInfo: storage.PartitionInfo{Info: nil}, Version: storage.InitialTableVersion},
+		{ID: 2, Name: "table2", SchemaID: 0, CreatedAt: 0, PartitionInfo: storage.PartitionInfo{Info: nil}, Version: storage.InitialTableVersion},
+	}
+	shardViews := []storage.ShardView{
+		storage.NewShardView(0, 2, []storage.TableID{1}, 0),
+		storage.NewShardView(1, 3, []storage.TableID{2}, 0),
+	}
+	clusterView := storage.NewClusterView(0, 5, storage.ClusterStateStable, []storage.ShardNode{
+		{ID: 0, ShardRole: storage.ShardRoleLeader, NodeName: "node0"},
+		{ID: 1, ShardRole: storage.ShardRoleLeader, NodeName: "node1"},
+	}, 0)
+	cachedTopology := Topology{
+		ShardViewsMapping: map[storage.ShardID]storage.ShardView{0: shardViews[0], 1: shardViews[1]},
+		ClusterView:       clusterView,
+	}
+
+	// The consistent metadata has no violations.
+	report := fsck(fsckInput{
+		schemas:        schemas,
+		tables:         tables,
+		shardViews:     shardViews,
+		clusterView:    clusterView,
+		cachedTopology: cachedTopology,
+	})
+	re.Equal(1, report.SchemaCount)
+	re.Equal(2, report.TableCount)
+	re.Equal(2, report.ShardCount)
+	re.Empty(report.Violations)
+
+	// Table 2 is on both shards, table 3 does not exist, and shard 2 of the cluster view has no shard view.
+	brokenShardViews := []storage.ShardView{
+		storage.NewShardView(0, 2, []storage.TableID{1, 2, 3}, 0),
+		storage.NewShardView(1, 4, []storage.TableID{2}, 0),
+	}
+	brokenShardViews[0].TableVersions[1] = 3
+	brokenClusterView := clusterView
+	brokenClusterView.ShardNodes = append([]storage.ShardNode{}, clusterView.ShardNodes...)
+	brokenClusterView.ShardNodes = append(brokenClusterView.ShardNodes, storage.ShardNode{ID: 2, ShardRole: storage.ShardRoleLeader, NodeName: "node2"})
+	report = fsck(fsckInput{
+		schemas:        schemas,
+		tables:         tables,
+		shardViews:     brokenShardViews,
+		clusterView:    brokenClusterView,
+		cachedTopology: cachedTopology,
+	})
+
+	kinds := make([]FsckViolationKind, 0, len(report.Violations))
+	for _, violation := range report.Violations {
+		kinds = append(kinds, violation.Kind)
+	}
+	re.Equal([]FsckViolationKind{
+		FsckTableVersionAhead,
+		FsckDanglingTable,
+		FsckDuplicateTable,
+		FsckMissingShard,
+		FsckCacheVersionMismatch,
+	}, kinds)
+}
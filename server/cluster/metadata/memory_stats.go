@@ -0,0 +1,71 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"unsafe"
+
+	"github.com/CeresDB/horaemeta/server/storage"
+	"google.golang.org/protobuf/proto"
+)
+
+// mapEntryOverhead approximates the memory taken by a map entry besides its key and value, e.g. the tophash and the
+// free slots of the buckets.
+const mapEntryOverhead = 16
+
+func (s *CacheMemoryStats) addEntry(bytes uint64) {
+	s.EntryCount++
+	s.ApproximateBytes += bytes
+}
+
+func stringSize(s string) uint64 {
+	return uint64(unsafe.Sizeof(s)) + uint64(len(s))
+}
+
+func schemaSize(schema storage.Schema) uint64 {
+	return uint64(unsafe.Sizeof(schema)) + uint64(len(schema.Name))
+}
+
+func tableSize(table storage.Table) uint64 {
+	size := uint64(unsafe.Sizeof(table)) + uint64(len(table.Name))
+	if table.IsPartitioned() {
+		size += uint64(proto.Size(table.PartitionInfo.Info))
+	}
+	return size
+}
+
+func shardViewSize(shardView *storage.ShardView) uint64 {
+	tableIDSize := uint64(unsafe.Sizeof(storage.TableID(0)))
+	size := uint64(unsafe.Sizeof(*shardView)) + uint64(cap(shardView.TableIDs))*tableIDSize
+	size += uint64(len(shardView.TableVersions)) * (tableIDSize + uint64(unsafe.Sizeof(uint64(0))) + mapEntryOverhead)
+	return size
+}
+
+func registeredNodeSize(node RegisteredNode) uint64 {
+	var shardInfo ShardInfo
+	size := uint64(unsafe.Sizeof(node)) + uint64(cap(node.ShardInfos))*uint64(unsafe.Sizeof(shardInfo))
+	size += uint64(len(node.Node.Name) + len(node.Node.NodeStats.Zone) + len(node.Node.NodeStats.NodeVersion))
+	return size
+}
+
+// shardTableInfosSize leaves out the names of the tables, which share the memory with the tables in the table manager.
+func shardTableInfosSize(s *shardTableInfos) uint64 {
+	var tableInfo TableInfo
+	size := uint64(unsafe.Sizeof(*s)) + uint64(cap(s.tables))*uint64(unsafe.Sizeof(tableInfo))
+	size += uint64(len(s.positions)) * (uint64(unsafe.Sizeof(storage.TableID(0))+unsafe.Sizeof(0)) + mapEntryOverhead)
+	return size
+}
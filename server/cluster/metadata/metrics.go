@@ -0,0 +1,38 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const (
+	loadStageTotal    = "total"
+	loadStageTables   = "tables"
+	loadStageTopology = "topology"
+)
+
+// loadDuration observes how long it takes to load the cluster metadata from the storage, which dominates the time of the
+// leader failover for the cluster with lots of tables.
+var loadDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "horaemeta",
+	Subsystem: "cluster",
+	Name:      "load_duration_seconds",
+	Help:      "Duration of loading the cluster metadata from the storage.",
+	Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16),
+}, []string{"cluster", "stage"})
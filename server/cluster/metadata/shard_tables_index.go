@@ -0,0 +1,177 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/CeresDB/horaemeta/server/storage"
+)
+
+// shardTableInfos is the projection of the tables on a shard at the version of the shard view, and the tables are indexed
+// by the table id so that adding or removing tables needs no rebuilding of the whole list.
+//
+// The tables are shared with the readers as copy-on-write snapshots: a snapshot is capped at its length, so the tables
+// appended later are out of its reach, and the tables are copied before the first one is overwritten by a removal.
+type shardTableInfos struct {
+	version uint64
+	// viewTableCount is the number of the tables in the shard view, which may differ from len(tables) if some tables
+	// are missing in the table manager.
+	viewTableCount int
+	tables         []TableInfo
+	positions      map[storage.TableID]int // tableID -> position in tables
+	// shared is true if the tables are handed out as a snapshot since they are copied last time, and it is set by the
+	// readers holding the read lock.
+	shared atomic.Bool
+}
+
+func newShardTableInfos(version uint64, viewTableCount int, tables []TableInfo) *shardTableInfos {
+	s := &shardTableInfos{
+		version:        version,
+		viewTableCount: viewTableCount,
+		tables:         make([]TableInfo, 0, len(tables)),
+		positions:      make(map[storage.TableID]int, len(tables)),
+		shared:         atomic.Bool{},
+	}
+	s.add(tables)
+	return s
+}
+
+// add returns the number of the tables newly added.
+func (s *shardTableInfos) add(tables []TableInfo) int {
+	added := 0
+	for _, table := range tables {
+		if _, exists := s.positions[table.ID]; exists {
+			continue
+		}
+		s.positions[table.ID] = len(s.tables)
+		s.tables = append(s.tables, table)
+		added++
+	}
+	return added
+}
+
+// remove returns the number of the tables actually removed.
+func (s *shardTableInfos) remove(tableIDs []storage.TableID) int {
+	removed := 0
+	for _, tableID := range tableIDs {
+		pos, exists := s.positions[tableID]
+		if !exists {
+			continue
+		}
+		if s.shared.Load() {
+			s.tables = slices.Clone(s.tables)
+			s.shared.Store(false)
+		}
+		// Move the last table to the removed position to avoid shifting the tables.
+		lastPos := len(s.tables) - 1
+		s.tables[pos] = s.tables[lastPos]
+		s.positions[s.tables[pos].ID] = pos
+		s.tables = s.tables[:lastPos]
+		delete(s.positions, tableID)
+		removed++
+	}
+	return removed
+}
+
+// shardTablesIndex maintains the shardTableInfos of the shards incrementally along with the table operations on the
+// shards. A projection is served only if it matches the latest shard view, otherwise it is rebuilt by the reader.
+type shardTablesIndex struct {
+	lock   sync.RWMutex
+	shards map[storage.ShardID]*shardTableInfos
+}
+
+func newShardTablesIndex() *shardTablesIndex {
+	return &shardTablesIndex{
+		lock:   sync.RWMutex{},
+		shards: make(map[storage.ShardID]*shardTableInfos),
+	}
+}
+
+func (i *shardTablesIndex) reset() {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.shards = make(map[storage.ShardID]*shardTableInfos)
+}
+
+// snapshot returns the tables which are never modified afterwards, and the caller must not modify them either.
+func (s *shardTableInfos) snapshot() []TableInfo {
+	s.shared.Store(true)
+	return s.tables[:len(s.tables):len(s.tables)]
+}
+
+// get returns the snapshot of the tables of the shard, the second output parameter bool: returns true if the projection
+// matches the shard view.
+func (i *shardTablesIndex) get(shardID storage.ShardID, shardTableIDs ShardTableIDs) ([]TableInfo, bool) {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	s, ok := i.shards[shardID]
+	if !ok || s.version != shardTableIDs.Version || s.viewTableCount != len(shardTableIDs.TableIDs) {
+		return nil, false
+	}
+	return s.snapshot(), true
+}
+
+// put replaces the projection of the shard with the tables built from the shard view.
+func (i *shardTablesIndex) put(shardID storage.ShardID, shardTableIDs ShardTableIDs, tables []TableInfo) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	i.shards[shardID] = newShardTableInfos(shardTableIDs.Version, len(shardTableIDs.TableIDs), tables)
+}
+
+// update applies the tables added to or removed from the shard by an operation turning the shard view at prevVersion
+// into latest. The projection is dropped and left to be rebuilt if it is not at prevVersion, or the result does not
+// match latest, e.g. the tables added concurrently are merged into the shard view.
+func (i *shardTablesIndex) update(shardID storage.ShardID, prevVersion uint64, latest ShardTableIDs, added []TableInfo, removed []storage.TableID) {
+	i.lock.Lock()
+	defer i.lock.Unlock()
+
+	s, ok := i.shards[shardID]
+	if !ok {
+		return
+	}
+	if s.version != prevVersion {
+		delete(i.shards, shardID)
+		return
+	}
+
+	viewTableCount := s.viewTableCount - s.remove(removed) + s.add(added)
+	if viewTableCount != len(latest.TableIDs) {
+		delete(i.shards, shardID)
+		return
+	}
+	s.version = latest.Version
+	s.viewTableCount = viewTableCount
+}
+
+// memoryStats counts the shards having a projection as the entries.
+func (i *shardTablesIndex) memoryStats() CacheMemoryStats {
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+
+	stats := CacheMemoryStats{EntryCount: 0, ApproximateBytes: 0}
+	for shardID, s := range i.shards {
+		stats.addEntry(uint64(unsafe.Sizeof(shardID)+unsafe.Sizeof(s)) + shardTableInfosSize(s) + mapEntryOverhead)
+	}
+	return stats
+}
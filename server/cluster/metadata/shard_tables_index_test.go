@@ -0,0 +1,140 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package metadata
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+const benchmarkShardTableCount = 100000
+
+func buildTableInfos(startID, count int) []TableInfo {
+	tableInfos := make([]TableInfo, 0, count)
+	for i := startID; i < startID+count; i++ {
+		tableInfos = append(tableInfos, TableInfo{
+			ID:            storage.TableID(i),
+			Name:          fmt.Sprintf("table%d", i),
+			SchemaID:      0,
+			SchemaName:    "public",
+			PartitionInfo: storage.PartitionInfo{Info: nil},
+			CreatedAt:     0,
+		})
+	}
+	return tableInfos
+}
+
+func buildShardTableIDs(version uint64, tableInfos []TableInfo) ShardTableIDs {
+	tableIDs := make([]storage.TableID, 0, len(tableInfos))
+	for _, tableInfo := range tableInfos {
+		tableIDs = append(tableIDs, tableInfo.ID)
+	}
+	return ShardTableIDs{TableIDs: tableIDs, Version: version}
+}
+
+func TestShardTablesIndex(t *testing.T) {
+	re := require.New(t)
+	index := newShardTablesIndex()
+	shardID := storage.ShardID(0)
+
+	tables := buildTableInfos(0, 3)
+	_, ok := index.get(shardID, buildShardTableIDs(1, tables))
+	re.False(ok)
+
+	index.put(shardID, buildShardTableIDs(1, tables), tables)
+	got, ok := index.get(shardID, buildShardTableIDs(1, tables))
+	re.True(ok)
+	re.Equal(tables, got)
+	// The shard view has been updated by others.
+	_, ok = index.get(shardID, buildShardTableIDs(2, tables))
+	re.False(ok)
+
+	// Add a table.
+	added := buildTableInfos(3, 1)
+	index.update(shardID, 1, buildShardTableIDs(2, buildTableInfos(0, 4)), added, nil)
+	got, ok = index.get(shardID, buildShardTableIDs(2, buildTableInfos(0, 4)))
+	re.True(ok)
+	re.ElementsMatch(buildTableInfos(0, 4), got)
+
+	// Remove the first table, and the returned tables are not affected.
+	snapshot := slices.Clone(got)
+	index.update(shardID, 2, buildShardTableIDs(3, buildTableInfos(1, 3)), nil, []storage.TableID{0})
+	re.Equal(snapshot, got)
+	got, ok = index.get(shardID, buildShardTableIDs(3, buildTableInfos(1, 3)))
+	re.True(ok)
+	re.ElementsMatch(buildTableInfos(1, 3), got)
+
+	// The tables added later are out of the reach of the returned tables, and appending to them affects no projection.
+	snapshot = slices.Clone(got)
+	appended := append(got, buildTableInfos(100, 1)...)
+	re.Len(appended, len(snapshot)+1)
+	index.update(shardID, 3, buildShardTableIDs(4, buildTableInfos(1, 4)), buildTableInfos(4, 1), nil)
+	re.Equal(snapshot, got)
+	got, ok = index.get(shardID, buildShardTableIDs(4, buildTableInfos(1, 4)))
+	re.True(ok)
+	re.ElementsMatch(buildTableInfos(1, 4), got)
+
+	// The update from a stale version drops the projection.
+	index.update(shardID, 2, buildShardTableIDs(4, buildTableInfos(1, 4)), buildTableInfos(4, 1), nil)
+	_, ok = index.get(shardID, buildShardTableIDs(4, buildTableInfos(1, 4)))
+	re.False(ok)
+
+	// The update not matching the latest shard view drops the projection, e.g. other tables are merged.
+	index.put(shardID, buildShardTableIDs(4, buildTableInfos(1, 4)), buildTableInfos(1, 4))
+	index.update(shardID, 4, buildShardTableIDs(5, buildTableInfos(1, 6)), buildTableInfos(5, 1), nil)
+	_, ok = index.get(shardID, buildShardTableIDs(5, buildTableInfos(1, 6)))
+	re.False(ok)
+
+	index.put(shardID, buildShardTableIDs(5, buildTableInfos(1, 6)), buildTableInfos(1, 6))
+	index.reset()
+	_, ok = index.get(shardID, buildShardTableIDs(5, buildTableInfos(1, 6)))
+	re.False(ok)
+}
+
+func BenchmarkShardTablesIndexGet(b *testing.B) {
+	index := newShardTablesIndex()
+	tables := buildTableInfos(0, benchmarkShardTableCount)
+	shardTableIDs := buildShardTableIDs(1, tables)
+	index.put(0, shardTableIDs, tables)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok := index.get(0, shardTableIDs); !ok {
+			b.Fatal("projection should match the shard view")
+		}
+	}
+}
+
+func BenchmarkShardTablesIndexUpdate(b *testing.B) {
+	index := newShardTablesIndex()
+	tables := buildTableInfos(0, benchmarkShardTableCount)
+	index.put(0, buildShardTableIDs(0, tables), tables)
+	latest := ShardTableIDs{TableIDs: make([]storage.TableID, benchmarkShardTableCount), Version: 0}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Replace a table with a new one, so the number of the tables stays the same.
+		added := buildTableInfos(benchmarkShardTableCount+i, 1)
+		removed := []storage.TableID{storage.TableID(i)}
+		latest.Version = uint64(i + 1)
+		index.update(0, uint64(i), latest, added, removed)
+	}
+}
@@ -19,15 +19,24 @@ package metadata
 import (
 	"context"
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
-	"time"
+	"unsafe"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/server/id"
 	"github.com/CeresDB/horaemeta/server/storage"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 )
 
+// loadTablesConcurrency is the max number of the schemas whose tables are scanned concurrently when loading.
+const loadTablesConcurrency = 16
+
+var unlimitedClusterQuota = storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0}
+
 // TableManager manages table metadata by schema.
 type TableManager interface {
 	// Load load table meta data from storage.
@@ -40,20 +49,50 @@ type TableManager interface {
 	GetTablesByIDs(tableIDs []storage.TableID) []storage.Table
 	// CreateTable create table with schemaName and tableName.
 	CreateTable(ctx context.Context, schemaName string, tableName string, partitionInfo storage.PartitionInfo) (storage.Table, error)
+	// CreateTables create the tables in the schema at once, and none of them is created if any one fails.
+	CreateTables(ctx context.Context, schemaName string, tableNames []string) ([]storage.Table, error)
 	// DropTable drop table with schemaName and tableName.
 	DropTable(ctx context.Context, schemaName string, tableName string) error
+	// DropTables drop tables with schemaName and tableNames, the tables not found are skipped and the dropped tables are returned.
+	DropTables(ctx context.Context, schemaName string, tableNames []string) ([]storage.Table, error)
+	// GetTablesByPrefix get tables whose names start with the prefix in the schema.
+	GetTablesByPrefix(schemaName string, prefix string) ([]storage.Table, error)
+	// SearchTables get the tables matching both the prefix and the pattern in the schema, ordered by the table name.
+	SearchTables(req SearchTablesRequest) (SearchTablesResult, error)
 	// GetSchema get schema with schemaName.
 	GetSchema(schemaName string) (storage.Schema, bool)
 	// GetSchemaByID get schema with schemaName.
 	GetSchemaByID(schemaID storage.SchemaID) (storage.Schema, bool)
 	// GetSchemas get all schemas in cluster.
 	GetSchemas() []storage.Schema
+	// GetSchemaInfo get the schema along with its table count and quota, the second output parameter bool: returns true if
+	// the schema exists.
+	GetSchemaInfo(schemaName string) (SchemaInfo, bool)
+	// ListSchemaInfos list the schemas along with their table counts and quotas, ordered by the schema name.
+	ListSchemaInfos() []SchemaInfo
 	// GetOrCreateSchema get or create schema with schemaName.
 	GetOrCreateSchema(ctx context.Context, schemaName string) (storage.Schema, bool, error)
+	// GetSchemaSettings get the settings of the schema, the second output parameter bool: returns true if the schema has settings.
+	GetSchemaSettings(schemaName string) (storage.SchemaSettings, bool)
+	// ListSchemaSettings list the settings of the schemas by the schema names.
+	ListSchemaSettings() map[string]storage.SchemaSettings
+	// UpdateSchemaSettings create or replace the settings of the existing schema.
+	UpdateSchemaSettings(ctx context.Context, schemaName string, settings storage.SchemaSettings) error
+	// DeleteSchemaSettings delete the settings of the schema.
+	DeleteSchemaSettings(ctx context.Context, schemaName string) error
+	// CheckTableQuota return error if creating newTableCount tables in the schema exceeds its max table count or the max
+	// table count of the cluster.
+	CheckTableQuota(schemaName string, newTableCount int) error
+	// SetClusterQuota set the quota of the cluster checked along with the max table count of the schemas.
+	SetClusterQuota(quota storage.ClusterQuota)
+	// GetMemoryStats get the approximate memory footprint of the cached schemas and tables.
+	GetMemoryStats() TableManagerMemoryStats
+	// ApplyTableChanges apply the schemas and tables changed in storage to the cache.
+	ApplyTableChanges(schemas []storage.Schema, tableChanges []storage.TableChange)
 }
 
 type Tables struct {
-	tables     map[string]storage.Table          // tableName -> table
+	tables     map[string]storage.Table          // normalized tableName -> table
 	tablesByID map[storage.TableID]storage.Table // tableID -> table
 }
 
@@ -63,25 +102,37 @@ type TableManagerImpl struct {
 	clusterID     storage.ClusterID
 	schemaIDAlloc id.Allocator
 	tableIDAlloc  id.Allocator
+	// caseInsensitiveName decides whether the names are lower-cased before being used as keys.
+	caseInsensitiveName bool
+	clock               clock.Clock
 
 	// RWMutex is used to protect following fields.
 	lock         sync.RWMutex
-	schemas      map[string]storage.Schema    // schemaName -> schema
+	schemas      map[string]storage.Schema    // normalized schemaName -> schema
 	schemaTables map[storage.SchemaID]*Tables // schemaName -> tables
+	// schemaSettings only contains the schemas with settings.
+	schemaSettings map[storage.SchemaID]storage.SchemaSettings
+	clusterQuota   storage.ClusterQuota
 }
 
-func NewTableManagerImpl(logger *zap.Logger, storage storage.Storage, clusterID storage.ClusterID, schemaIDAlloc id.Allocator, tableIDAlloc id.Allocator) TableManager {
+func NewTableManagerImpl(logger *zap.Logger, storage storage.Storage, clusterID storage.ClusterID, schemaIDAlloc id.Allocator, tableIDAlloc id.Allocator, caseInsensitiveName bool, clk clock.Clock) TableManager {
 	return &TableManagerImpl{
-		logger:        logger,
-		storage:       storage,
-		clusterID:     clusterID,
-		schemaIDAlloc: schemaIDAlloc,
-		tableIDAlloc:  tableIDAlloc,
-		lock:          sync.RWMutex{},
+		logger:              logger,
+		storage:             storage,
+		clusterID:           clusterID,
+		schemaIDAlloc:       schemaIDAlloc,
+		tableIDAlloc:        tableIDAlloc,
+		caseInsensitiveName: caseInsensitiveName,
+		clock:               clk,
+		lock:                sync.RWMutex{},
 		// It will be initialized in loadSchemas.
 		schemas: nil,
 		// It will be initialized in loadTables.
 		schemaTables: nil,
+		// It will be initialized in loadSchemaSettings.
+		schemaSettings: nil,
+		// It will be set by the cluster metadata.
+		clusterQuota: unlimitedClusterQuota,
 	}
 }
 
@@ -97,6 +148,10 @@ func (m *TableManagerImpl) Load(ctx context.Context) error {
 		return errors.WithMessage(err, "load tables")
 	}
 
+	if err := m.loadSchemaSettings(ctx); err != nil {
+		return errors.WithMessage(err, "load schema settings")
+	}
+
 	return nil
 }
 
@@ -119,15 +174,13 @@ func (m *TableManagerImpl) GetTablesByIDs(tableIDs []storage.TableID) []storage.
 	defer m.lock.RUnlock()
 
 	result := make([]storage.Table, 0, len(tableIDs))
-	for _, tables := range m.schemaTables {
-		for _, tableID := range tableIDs {
-			table, ok := tables.tablesByID[tableID]
-			if !ok {
-				m.logger.Warn("table not exists", zap.Uint64("tableID", uint64(tableID)))
-				continue
-			}
-			result = append(result, table)
+	for _, tableID := range tableIDs {
+		table, ok := m.getTableByID(tableID)
+		if !ok {
+			m.logger.Warn("table not exists", zap.Uint64("tableID", uint64(tableID)))
+			continue
 		}
+		result = append(result, table)
 	}
 
 	return result
@@ -148,10 +201,13 @@ func (m *TableManagerImpl) CreateTable(ctx context.Context, schemaName string, t
 	}
 
 	// Create table in storage.
-	schema, ok := m.schemas[schemaName]
+	schema, ok := m.schemas[m.normalizeName(schemaName)]
 	if !ok {
 		return emptyTable, ErrSchemaNotFound.WithCausef("schema name:%s", schemaName)
 	}
+	if err := m.checkTableQuota(schema, 1); err != nil {
+		return emptyTable, err
+	}
 
 	id, err := m.tableIDAlloc.Alloc(ctx)
 	if err != nil {
@@ -162,13 +218,16 @@ func (m *TableManagerImpl) CreateTable(ctx context.Context, schemaName string, t
 		ID:            storage.TableID(id),
 		Name:          tableName,
 		SchemaID:      schema.ID,
-		CreatedAt:     uint64(time.Now().UnixMilli()),
+		CreatedAt:     clock.UnixMilli(m.clock),
 		PartitionInfo: partitionInfo,
+		Version:       storage.InitialTableVersion,
 	}
+	normalizedName := m.normalizeName(tableName)
 	err = m.storage.CreateTable(ctx, storage.CreateTableRequest{
-		ClusterID: m.clusterID,
-		SchemaID:  schema.ID,
-		Table:     table,
+		ClusterID:      m.clusterID,
+		SchemaID:       schema.ID,
+		Table:          table,
+		NormalizedName: normalizedName,
 	})
 
 	if err != nil {
@@ -184,22 +243,108 @@ func (m *TableManagerImpl) CreateTable(ctx context.Context, schemaName string, t
 		}
 	}
 	tables := m.schemaTables[schema.ID]
-	tables.tables[tableName] = table
+	tables.tables[normalizedName] = table
 	tables.tablesByID[table.ID] = table
 
 	return table, nil
 }
 
+func (m *TableManagerImpl) CreateTables(ctx context.Context, schemaName string, tableNames []string) ([]storage.Table, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	schema, ok := m.schemas[m.normalizeName(schemaName)]
+	if !ok {
+		return nil, ErrSchemaNotFound.WithCausef("schema name:%s", schemaName)
+	}
+
+	normalizedNames := make(map[string]struct{}, len(tableNames))
+	for _, tableName := range tableNames {
+		normalizedName := m.normalizeName(tableName)
+		if _, ok := normalizedNames[normalizedName]; ok {
+			return nil, errors.WithMessagef(ErrTableAlreadyExists, "table is listed twice, tableName:%s", tableName)
+		}
+		normalizedNames[normalizedName] = struct{}{}
+
+		_, exists, err := m.getTable(schemaName, tableName)
+		if err != nil {
+			return nil, errors.WithMessage(err, "get table")
+		}
+		if exists {
+			return nil, errors.WithMessagef(ErrTableAlreadyExists, "tableName:%s", tableName)
+		}
+	}
+	if err := m.checkTableQuota(schema, len(tableNames)); err != nil {
+		return nil, err
+	}
+
+	createdAt := clock.UnixMilli(m.clock)
+	tables := make([]storage.Table, 0, len(tableNames))
+	tablesToCreate := make(map[string]storage.Table, len(tableNames))
+	for _, tableName := range tableNames {
+		id, err := m.tableIDAlloc.Alloc(ctx)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "alloc table id, table name:%s", tableName)
+		}
+		table := storage.Table{
+			ID:            storage.TableID(id),
+			Name:          tableName,
+			SchemaID:      schema.ID,
+			CreatedAt:     createdAt,
+			PartitionInfo: storage.PartitionInfo{Info: nil},
+			Version:       storage.InitialTableVersion,
+		}
+		tables = append(tables, table)
+		tablesToCreate[m.normalizeName(tableName)] = table
+	}
+
+	if err := m.storage.CreateTables(ctx, storage.CreateTablesRequest{
+		ClusterID: m.clusterID,
+		SchemaID:  schema.ID,
+		Tables:    tablesToCreate,
+	}); err != nil {
+		// The tables may be created partially if the creations are split into multiple txns, so remove them all.
+		tablesToDelete := make(map[string]storage.TableID, len(tablesToCreate))
+		for normalizedName, table := range tablesToCreate {
+			tablesToDelete[normalizedName] = table.ID
+		}
+		if deleteErr := m.storage.DeleteTables(ctx, storage.DeleteTablesRequest{
+			ClusterID: m.clusterID,
+			SchemaID:  schema.ID,
+			Tables:    tablesToDelete,
+		}); deleteErr != nil {
+			m.logger.Error("remove tables created partially failed", zap.String("schemaName", schemaName), zap.Error(deleteErr))
+		}
+		return nil, errors.WithMessage(err, "storage create tables")
+	}
+
+	// Update tables in memory.
+	if _, ok := m.schemaTables[schema.ID]; !ok {
+		m.schemaTables[schema.ID] = &Tables{
+			tables:     make(map[string]storage.Table),
+			tablesByID: make(map[storage.TableID]storage.Table),
+		}
+	}
+	schemaTables := m.schemaTables[schema.ID]
+	for normalizedName, table := range tablesToCreate {
+		schemaTables.tables[normalizedName] = table
+		schemaTables.tablesByID[table.ID] = table
+	}
+
+	return tables, nil
+}
+
 func (m *TableManagerImpl) DropTable(ctx context.Context, schemaName string, tableName string) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	schema, ok := m.schemas[schemaName]
+	schema, ok := m.schemas[m.normalizeName(schemaName)]
 	if !ok {
 		return nil
 	}
 
-	table, ok := m.schemaTables[schema.ID].tables[tableName]
+	normalizedName := m.normalizeName(tableName)
+	table, ok := m.schemaTables[schema.ID].tables[normalizedName]
 	if !ok {
 		return nil
 	}
@@ -208,23 +353,127 @@ func (m *TableManagerImpl) DropTable(ctx context.Context, schemaName string, tab
 	err := m.storage.DeleteTable(ctx, storage.DeleteTableRequest{
 		ClusterID: m.clusterID,
 		SchemaID:  schema.ID,
-		TableName: tableName,
+		TableName: normalizedName,
+		Version:   table.Version,
 	})
 	if err != nil {
 		return errors.WithMessagef(err, "storage delete table")
 	}
 
 	tables := m.schemaTables[schema.ID]
-	delete(tables.tables, tableName)
+	delete(tables.tables, normalizedName)
 	delete(tables.tablesByID, table.ID)
 	return nil
 }
 
+func (m *TableManagerImpl) DropTables(ctx context.Context, schemaName string, tableNames []string) ([]storage.Table, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	schema, ok := m.schemas[m.normalizeName(schemaName)]
+	if !ok {
+		return []storage.Table{}, nil
+	}
+	tables, ok := m.schemaTables[schema.ID]
+	if !ok {
+		return []storage.Table{}, nil
+	}
+
+	tablesToDelete := make(map[string]storage.TableID, len(tableNames))
+	droppedTables := make([]storage.Table, 0, len(tableNames))
+	for _, tableName := range tableNames {
+		normalizedName := m.normalizeName(tableName)
+		table, ok := tables.tables[normalizedName]
+		if !ok {
+			continue
+		}
+		if _, ok := tablesToDelete[normalizedName]; ok {
+			continue
+		}
+		tablesToDelete[normalizedName] = table.ID
+		droppedTables = append(droppedTables, table)
+	}
+
+	// Delete tables in storage.
+	if err := m.storage.DeleteTables(ctx, storage.DeleteTablesRequest{
+		ClusterID: m.clusterID,
+		SchemaID:  schema.ID,
+		Tables:    tablesToDelete,
+	}); err != nil {
+		return nil, errors.WithMessage(err, "storage delete tables")
+	}
+
+	for normalizedName, tableID := range tablesToDelete {
+		delete(tables.tables, normalizedName)
+		delete(tables.tablesByID, tableID)
+	}
+	return droppedTables, nil
+}
+
+func (m *TableManagerImpl) GetTablesByPrefix(schemaName string, prefix string) ([]storage.Table, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	schema, ok := m.schemas[m.normalizeName(schemaName)]
+	if !ok {
+		return []storage.Table{}, ErrSchemaNotFound.WithCausef("schema name:%s", schemaName)
+	}
+	tables, ok := m.schemaTables[schema.ID]
+	if !ok {
+		return []storage.Table{}, nil
+	}
+
+	normalizedPrefix := m.normalizeName(prefix)
+	result := make([]storage.Table, 0)
+	for normalizedName, table := range tables.tables {
+		if strings.HasPrefix(normalizedName, normalizedPrefix) {
+			result = append(result, table)
+		}
+	}
+	return result, nil
+}
+
+func (m *TableManagerImpl) SearchTables(req SearchTablesRequest) (SearchTablesResult, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	schema, ok := m.schemas[m.normalizeName(req.SchemaName)]
+	if !ok {
+		return SearchTablesResult{}, ErrSchemaNotFound.WithCausef("schema name:%s", req.SchemaName)
+	}
+	tables, ok := m.schemaTables[schema.ID]
+	if !ok {
+		return SearchTablesResult{Tables: []storage.Table{}, Truncated: false}, nil
+	}
+
+	normalizedPrefix := m.normalizeName(req.Prefix)
+	matched := make([]storage.Table, 0)
+	for normalizedName, table := range tables.tables {
+		if !strings.HasPrefix(normalizedName, normalizedPrefix) {
+			continue
+		}
+		// The pattern is matched against the display name, which is what the users see.
+		if req.Pattern != nil && !req.Pattern.MatchString(table.Name) {
+			continue
+		}
+		matched = append(matched, table)
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].Name < matched[j].Name
+	})
+
+	truncated := req.Limit > 0 && len(matched) > req.Limit
+	if truncated {
+		matched = matched[:req.Limit]
+	}
+	return SearchTablesResult{Tables: matched, Truncated: truncated}, nil
+}
+
 func (m *TableManagerImpl) GetSchema(schemaName string) (storage.Schema, bool) {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
-	schema, ok := m.schemas[schemaName]
+	schema, ok := m.schemas[m.normalizeName(schemaName)]
 	return schema, ok
 }
 
@@ -246,7 +495,7 @@ func (m *TableManagerImpl) GetSchemas() []storage.Schema {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
 
-	schemas := make([]storage.Schema, len(m.schemas))
+	schemas := make([]storage.Schema, 0, len(m.schemas))
 
 	for _, schema := range m.schemas {
 		schemas = append(schemas, schema)
@@ -255,11 +504,90 @@ func (m *TableManagerImpl) GetSchemas() []storage.Schema {
 	return schemas
 }
 
+func (m *TableManagerImpl) GetSchemaInfo(schemaName string) (SchemaInfo, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	schema, ok := m.schemas[m.normalizeName(schemaName)]
+	if !ok {
+		var emptyInfo SchemaInfo
+		return emptyInfo, false
+	}
+	return m.schemaInfo(schema), true
+}
+
+func (m *TableManagerImpl) ListSchemaInfos() []SchemaInfo {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	infos := make([]SchemaInfo, 0, len(m.schemas))
+	for _, schema := range m.schemas {
+		infos = append(infos, m.schemaInfo(schema))
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name < infos[j].Name
+	})
+	return infos
+}
+
+func (m *TableManagerImpl) schemaInfo(schema storage.Schema) SchemaInfo {
+	info := SchemaInfo{
+		ID:            schema.ID,
+		Name:          schema.Name,
+		CreatedAt:     schema.CreatedAt,
+		TableCount:    0,
+		Settings:      nil,
+		MaxTableCount: m.clusterQuota.MaxTablesPerSchema,
+	}
+	if tables, ok := m.schemaTables[schema.ID]; ok {
+		info.TableCount = len(tables.tables)
+	}
+	if settings, ok := m.schemaSettings[schema.ID]; ok {
+		info.Settings = &settings
+		if settings.MaxTableCount > 0 {
+			info.MaxTableCount = settings.MaxTableCount
+		}
+	}
+	return info
+}
+
+func (m *TableManagerImpl) GetMemoryStats() TableManagerMemoryStats {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	stats := TableManagerMemoryStats{
+		Schemas:      CacheMemoryStats{EntryCount: 0, ApproximateBytes: 0},
+		SchemaTables: make([]SchemaTablesMemoryStats, 0, len(m.schemaTables)),
+	}
+	schemaNames := make(map[storage.SchemaID]string, len(m.schemas))
+	for key, schema := range m.schemas {
+		stats.Schemas.addEntry(stringSize(key) + schemaSize(schema) + mapEntryOverhead)
+		schemaNames[schema.ID] = schema.Name
+	}
+
+	for schemaID, tables := range m.schemaTables {
+		tablesStats := SchemaTablesMemoryStats{
+			SchemaName: schemaNames[schemaID],
+			Tables:     CacheMemoryStats{EntryCount: 0, ApproximateBytes: 0},
+		}
+		for key, table := range tables.tables {
+			// The table is kept in both maps, and the entry in tablesByID shares the name with the one in tables.
+			tablesStats.Tables.addEntry(stringSize(key) + tableSize(table) + uint64(unsafe.Sizeof(table.ID)) + uint64(unsafe.Sizeof(table)) + 2*mapEntryOverhead)
+		}
+		stats.SchemaTables = append(stats.SchemaTables, tablesStats)
+	}
+	sort.Slice(stats.SchemaTables, func(i, j int) bool {
+		return stats.SchemaTables[i].SchemaName < stats.SchemaTables[j].SchemaName
+	})
+
+	return stats
+}
+
 func (m *TableManagerImpl) GetOrCreateSchema(ctx context.Context, schemaName string) (storage.Schema, bool, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	schema, ok := m.schemas[schemaName]
+	schema, ok := m.schemas[m.normalizeName(schemaName)]
 	if ok {
 		return schema, true, nil
 	}
@@ -273,7 +601,7 @@ func (m *TableManagerImpl) GetOrCreateSchema(ctx context.Context, schemaName str
 		ID:        storage.SchemaID(id),
 		ClusterID: m.clusterID,
 		Name:      schemaName,
-		CreatedAt: uint64(time.Now().UnixMilli()),
+		CreatedAt: clock.UnixMilli(m.clock),
 	}
 
 	// Create schema in storage.
@@ -284,10 +612,173 @@ func (m *TableManagerImpl) GetOrCreateSchema(ctx context.Context, schemaName str
 		return storage.Schema{}, false, errors.WithMessage(err, "storage create schema")
 	}
 	// Update schema in memory.
-	m.schemas[schemaName] = schema
+	m.schemas[m.normalizeName(schemaName)] = schema
 	return schema, false, nil
 }
 
+func (m *TableManagerImpl) GetSchemaSettings(schemaName string) (storage.SchemaSettings, bool) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var emptySettings storage.SchemaSettings
+	schema, ok := m.schemas[m.normalizeName(schemaName)]
+	if !ok {
+		return emptySettings, false
+	}
+	settings, ok := m.schemaSettings[schema.ID]
+	return settings, ok
+}
+
+func (m *TableManagerImpl) ListSchemaSettings() map[string]storage.SchemaSettings {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	result := make(map[string]storage.SchemaSettings, len(m.schemaSettings))
+	for _, schema := range m.schemas {
+		if settings, ok := m.schemaSettings[schema.ID]; ok {
+			result[schema.Name] = settings
+		}
+	}
+	return result
+}
+
+func (m *TableManagerImpl) UpdateSchemaSettings(ctx context.Context, schemaName string, settings storage.SchemaSettings) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	schema, ok := m.schemas[m.normalizeName(schemaName)]
+	if !ok {
+		return ErrSchemaNotFound.WithCausef("schema name:%s", schemaName)
+	}
+
+	if err := m.storage.PutSchemaSettings(ctx, storage.PutSchemaSettingsRequest{
+		ClusterID: m.clusterID,
+		SchemaID:  schema.ID,
+		Settings:  settings,
+	}); err != nil {
+		return errors.WithMessage(err, "storage put schema settings")
+	}
+	m.schemaSettings[schema.ID] = settings
+	return nil
+}
+
+func (m *TableManagerImpl) DeleteSchemaSettings(ctx context.Context, schemaName string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	schema, ok := m.schemas[m.normalizeName(schemaName)]
+	if !ok {
+		return ErrSchemaNotFound.WithCausef("schema name:%s", schemaName)
+	}
+
+	if err := m.storage.DeleteSchemaSettings(ctx, storage.DeleteSchemaSettingsRequest{
+		ClusterID: m.clusterID,
+		SchemaID:  schema.ID,
+	}); err != nil {
+		return errors.WithMessage(err, "storage delete schema settings")
+	}
+	delete(m.schemaSettings, schema.ID)
+	return nil
+}
+
+func (m *TableManagerImpl) CheckTableQuota(schemaName string, newTableCount int) error {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	schema, ok := m.schemas[m.normalizeName(schemaName)]
+	if !ok {
+		// The schema will be created along with the table, and it has no settings or tables yet.
+		if err := m.checkClusterTableQuota(newTableCount); err != nil {
+			return err
+		}
+		if maxTableCount := m.clusterQuota.MaxTablesPerSchema; maxTableCount > 0 && newTableCount > int(maxTableCount) {
+			return ErrSchemaTableQuotaExceeded.WithCausef("schema:%s, tableCount:0, newTableCount:%d, maxTablesPerSchema:%d", schemaName, newTableCount, maxTableCount)
+		}
+		return nil
+	}
+	return m.checkTableQuota(schema, newTableCount)
+}
+
+func (m *TableManagerImpl) SetClusterQuota(quota storage.ClusterQuota) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.clusterQuota = quota
+}
+
+// checkTableQuota checks the max table count in the schema settings, or the max tables per schema of the cluster if the
+// schema has none, and then the max tables of the cluster.
+func (m *TableManagerImpl) checkTableQuota(schema storage.Schema, newTableCount int) error {
+	if err := m.checkClusterTableQuota(newTableCount); err != nil {
+		return err
+	}
+
+	tableCount := 0
+	if tables, ok := m.schemaTables[schema.ID]; ok {
+		tableCount = len(tables.tables)
+	}
+	if settings, ok := m.schemaSettings[schema.ID]; ok && settings.MaxTableCount > 0 {
+		if tableCount+newTableCount > int(settings.MaxTableCount) {
+			return ErrSchemaTableQuotaExceeded.WithCausef("schema:%s, tableCount:%d, newTableCount:%d, maxTableCount:%d", schema.Name, tableCount, newTableCount, settings.MaxTableCount)
+		}
+		return nil
+	}
+	if maxTableCount := m.clusterQuota.MaxTablesPerSchema; maxTableCount > 0 && tableCount+newTableCount > int(maxTableCount) {
+		return ErrSchemaTableQuotaExceeded.WithCausef("schema:%s, tableCount:%d, newTableCount:%d, maxTablesPerSchema:%d", schema.Name, tableCount, newTableCount, maxTableCount)
+	}
+	return nil
+}
+
+func (m *TableManagerImpl) checkClusterTableQuota(newTableCount int) error {
+	if m.clusterQuota.MaxTables == 0 {
+		return nil
+	}
+
+	tableCount := 0
+	for _, tables := range m.schemaTables {
+		tableCount += len(tables.tables)
+	}
+	if tableCount+newTableCount > int(m.clusterQuota.MaxTables) {
+		return ErrClusterTableQuotaExceeded.WithCausef("tableCount:%d, newTableCount:%d, maxTables:%d", tableCount, newTableCount, m.clusterQuota.MaxTables)
+	}
+	return nil
+}
+
+// ApplyTableChanges applies the changes in order, and applying a change again is harmless, so the changes already loaded
+// can be applied safely.
+func (m *TableManagerImpl) ApplyTableChanges(schemas []storage.Schema, tableChanges []storage.TableChange) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, schema := range schemas {
+		m.schemas[m.normalizeName(schema.Name)] = schema
+	}
+
+	for _, change := range tableChanges {
+		tables, ok := m.schemaTables[change.SchemaID]
+		switch change.Type {
+		case storage.ChangeTypePut:
+			if !ok {
+				tables = &Tables{
+					tables:     make(map[string]storage.Table),
+					tablesByID: make(map[storage.TableID]storage.Table),
+				}
+				m.schemaTables[change.SchemaID] = tables
+			}
+			tables.tables[m.normalizeName(change.Table.Name)] = change.Table
+			tables.tablesByID[change.TableID] = change.Table
+		case storage.ChangeTypeDelete:
+			if !ok {
+				continue
+			}
+			if table, exists := tables.tablesByID[change.TableID]; exists {
+				delete(tables.tables, m.normalizeName(table.Name))
+				delete(tables.tablesByID, change.TableID)
+			}
+		}
+	}
+}
+
 func (m *TableManagerImpl) loadSchemas(ctx context.Context) error {
 	schemasResult, err := m.storage.ListSchemas(ctx, storage.ListSchemasRequest{ClusterID: m.clusterID})
 	if err != nil {
@@ -298,25 +789,57 @@ func (m *TableManagerImpl) loadSchemas(ctx context.Context) error {
 	// Reset data in memory.
 	m.schemas = make(map[string]storage.Schema, len(schemasResult.Schemas))
 	for _, schema := range schemasResult.Schemas {
-		m.schemas[schema.Name] = schema
+		m.schemas[m.normalizeName(schema.Name)] = schema
 	}
 
 	return nil
 }
 
+func (m *TableManagerImpl) loadSchemaSettings(ctx context.Context) error {
+	settingsResult, err := m.storage.ListSchemaSettings(ctx, storage.ListSchemaSettingsRequest{ClusterID: m.clusterID})
+	if err != nil {
+		return errors.WithMessage(err, "list schema settings")
+	}
+
+	m.schemaSettings = settingsResult.Settings
+	return nil
+}
+
+// loadTables scans the tables of the schemas concurrently, at most loadTablesConcurrency schemas at a time.
 func (m *TableManagerImpl) loadTables(ctx context.Context) error {
-	// Reset data in memory.
-	m.schemaTables = make(map[storage.SchemaID]*Tables, len(m.schemas))
+	schemaTablesResults := make([]storage.ListTablesResult, len(m.schemas))
+	schemas := make([]storage.Schema, 0, len(m.schemas))
 	for _, schema := range m.schemas {
-		tablesResult, err := m.storage.ListTables(ctx, storage.ListTableRequest{
-			ClusterID: m.clusterID,
-			SchemaID:  schema.ID,
+		schemas = append(schemas, schema)
+	}
+
+	g, gctx := errgroup.WithContext(ctx)
+	limiter := make(chan struct{}, loadTablesConcurrency)
+	for i, schema := range schemas {
+		i, schema := i, schema
+		g.Go(func() error {
+			limiter <- struct{}{}
+			defer func() { <-limiter }()
+
+			tablesResult, err := m.storage.ListTables(gctx, storage.ListTableRequest{
+				ClusterID: m.clusterID,
+				SchemaID:  schema.ID,
+			})
+			if err != nil {
+				return errors.WithMessagef(err, "list tables, schema:%s", schema.Name)
+			}
+			m.logger.Debug("load table", zap.String("schema", fmt.Sprintf("%+v", schema)), zap.Int("tableCount", len(tablesResult.Tables)))
+			schemaTablesResults[i] = tablesResult
+			return nil
 		})
-		if err != nil {
-			return errors.WithMessage(err, "list tables")
-		}
-		m.logger.Debug("load table", zap.String("schema", fmt.Sprintf("%+v", schema)), zap.String("tables", fmt.Sprintf("%+v", tablesResult)))
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
 
+	// Reset data in memory.
+	m.schemaTables = make(map[storage.SchemaID]*Tables, len(m.schemas))
+	for _, tablesResult := range schemaTablesResults {
 		for _, table := range tablesResult.Tables {
 			tables, ok := m.schemaTables[table.SchemaID]
 			if !ok {
@@ -327,7 +850,7 @@ func (m *TableManagerImpl) loadTables(ctx context.Context) error {
 				m.schemaTables[table.SchemaID] = tables
 			}
 
-			tables.tables[table.Name] = table
+			tables.tables[m.normalizeName(table.Name)] = table
 			tables.tablesByID[table.ID] = table
 		}
 	}
@@ -335,7 +858,7 @@ func (m *TableManagerImpl) loadTables(ctx context.Context) error {
 }
 
 func (m *TableManagerImpl) getTable(schemaName, tableName string) (storage.Table, bool, error) {
-	schema, ok := m.schemas[schemaName]
+	schema, ok := m.schemas[m.normalizeName(schemaName)]
 	var emptyTable storage.Table
 	if !ok {
 		return emptyTable, false, ErrSchemaNotFound.WithCausef("schema name", schemaName)
@@ -346,12 +869,22 @@ func (m *TableManagerImpl) getTable(schemaName, tableName string) (storage.Table
 		return emptyTable, false, nil
 	}
 
-	table, ok := tables.tables[tableName]
+	table, ok := tables.tables[m.normalizeName(tableName)]
 	return table, ok, nil
 }
 
+// getTableByID the second output parameter bool: returns true if the table exists.
+func (m *TableManagerImpl) getTableByID(tableID storage.TableID) (storage.Table, bool) {
+	for _, tables := range m.schemaTables {
+		if table, ok := tables.tablesByID[tableID]; ok {
+			return table, true
+		}
+	}
+	return storage.Table{}, false
+}
+
 func (m *TableManagerImpl) getTables(schemaName string, tableNames []string) ([]storage.Table, error) {
-	schema, ok := m.schemas[schemaName]
+	schema, ok := m.schemas[m.normalizeName(schemaName)]
 	if !ok {
 		return []storage.Table{}, ErrSchemaNotFound.WithCausef("schema name", schemaName)
 	}
@@ -363,10 +896,19 @@ func (m *TableManagerImpl) getTables(schemaName string, tableNames []string) ([]
 
 	tables := make([]storage.Table, 0, len(tableNames))
 	for _, tableName := range tableNames {
-		if table, ok := schemaTables.tables[tableName]; ok {
+		if table, ok := schemaTables.tables[m.normalizeName(tableName)]; ok {
 			tables = append(tables, table)
 		}
 	}
 
 	return tables, nil
 }
+
+// normalizeName returns the key of the schema or table name in the caches and the storage.
+// The original name is still kept in the metadata for display.
+func (m *TableManagerImpl) normalizeName(name string) string {
+	if m.caseInsensitiveName {
+		return strings.ToLower(name)
+	}
+	return name
+}
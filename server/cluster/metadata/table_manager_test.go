@@ -18,9 +18,14 @@ package metadata_test
 
 import (
 	"context"
+	"fmt"
 	"path"
+	"regexp"
+	"strings"
 	"testing"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/pkg/coderr"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/etcdutil"
 	"github.com/CeresDB/horaemeta/server/id"
@@ -51,7 +56,7 @@ func TestTableManager(t *testing.T) {
 
 	schemaIDAlloc := id.NewAllocatorImpl(zap.NewNop(), client, path.Join(TestRootPath, TestClusterName, TestSchemaIDPrefix), TestIDAllocatorStep)
 	tableIDAlloc := id.NewAllocatorImpl(zap.NewNop(), client, path.Join(TestRootPath, TestClusterName, TestTableIDPrefix), TestIDAllocatorStep)
-	tableManager := metadata.NewTableManagerImpl(zap.NewNop(), clusterStorage, storage.ClusterID(TestClusterID), schemaIDAlloc, tableIDAlloc)
+	tableManager := metadata.NewTableManagerImpl(zap.NewNop(), clusterStorage, storage.ClusterID(TestClusterID), schemaIDAlloc, tableIDAlloc, false, clock.NewRealClock())
 	err := tableManager.Load(ctx)
 	re.NoError(err)
 
@@ -76,11 +81,13 @@ func testCreateAndDropTable(ctx context.Context, re *require.Assertions, manager
 	t, err := manager.CreateTable(ctx, TestSchemaName, TestTableName, storage.PartitionInfo{Info: nil})
 	re.NoError(err)
 	re.Equal(TestTableName, t.Name)
+	re.Equal(storage.InitialTableVersion, t.Version)
 
 	t, exists, err = manager.GetTable(TestSchemaName, TestTableName)
 	re.NoError(err)
 	re.True(exists)
 	re.Equal(TestTableName, t.Name)
+	re.Equal(storage.InitialTableVersion, t.Version)
 
 	err = manager.DropTable(ctx, TestSchemaName, TestTableName)
 	re.NoError(err)
@@ -89,3 +96,272 @@ func testCreateAndDropTable(ctx context.Context, re *require.Assertions, manager
 	re.NoError(err)
 	re.False(exists)
 }
+
+func TestTableManagerCaseInsensitiveName(t *testing.T) {
+	ctx := context.Background()
+	re := require.New(t)
+
+	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
+	clusterStorage := storage.NewStorageWithEtcdBackend(client, TestRootPath, storage.Options{
+		MaxScanLimit: 100, MinScanLimit: 10, MaxOpsPerTxn: 10,
+	})
+
+	schemaIDAlloc := id.NewAllocatorImpl(zap.NewNop(), client, path.Join(TestRootPath, TestClusterName, TestSchemaIDPrefix), TestIDAllocatorStep)
+	tableIDAlloc := id.NewAllocatorImpl(zap.NewNop(), client, path.Join(TestRootPath, TestClusterName, TestTableIDPrefix), TestIDAllocatorStep)
+	tableManager := metadata.NewTableManagerImpl(zap.NewNop(), clusterStorage, storage.ClusterID(TestClusterID), schemaIDAlloc, tableIDAlloc, true, clock.NewRealClock())
+	re.NoError(tableManager.Load(ctx))
+
+	_, _, err := tableManager.GetOrCreateSchema(ctx, TestSchemaName)
+	re.NoError(err)
+	_, exists, err := tableManager.GetOrCreateSchema(ctx, strings.ToUpper(TestSchemaName))
+	re.NoError(err)
+	re.True(exists)
+
+	_, err = tableManager.CreateTable(ctx, TestSchemaName, TestTableName, storage.PartitionInfo{Info: nil})
+	re.NoError(err)
+	_, err = tableManager.CreateTable(ctx, TestSchemaName, strings.ToLower(TestTableName), storage.PartitionInfo{Info: nil})
+	re.Error(err)
+
+	// The display name is kept after reloading from the storage.
+	re.NoError(tableManager.Load(ctx))
+	table, exists, err := tableManager.GetTable(strings.ToLower(TestSchemaName), strings.ToUpper(TestTableName))
+	re.NoError(err)
+	re.True(exists)
+	re.Equal(TestTableName, table.Name)
+
+	re.NoError(tableManager.DropTable(ctx, TestSchemaName, strings.ToLower(TestTableName)))
+	_, exists, err = tableManager.GetTable(TestSchemaName, TestTableName)
+	re.NoError(err)
+	re.False(exists)
+}
+
+func TestSearchTables(t *testing.T) {
+	ctx := context.Background()
+	re := require.New(t)
+
+	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
+	clusterStorage := storage.NewStorageWithEtcdBackend(client, TestRootPath, storage.Options{
+		MaxScanLimit: 100, MinScanLimit: 10, MaxOpsPerTxn: 10,
+	})
+
+	schemaIDAlloc := id.NewAllocatorImpl(zap.NewNop(), client, path.Join(TestRootPath, TestClusterName, TestSchemaIDPrefix), TestIDAllocatorStep)
+	tableIDAlloc := id.NewAllocatorImpl(zap.NewNop(), client, path.Join(TestRootPath, TestClusterName, TestTableIDPrefix), TestIDAllocatorStep)
+	tableManager := metadata.NewTableManagerImpl(zap.NewNop(), clusterStorage, storage.ClusterID(TestClusterID), schemaIDAlloc, tableIDAlloc, false, clock.NewRealClock())
+	re.NoError(tableManager.Load(ctx))
+
+	_, err := tableManager.SearchTables(metadata.SearchTablesRequest{SchemaName: TestSchemaName, Prefix: "", Pattern: nil, Limit: 0})
+	re.Error(err)
+
+	_, _, err = tableManager.GetOrCreateSchema(ctx, TestSchemaName)
+	re.NoError(err)
+	for _, tableName := range []string{"____cpu_2", "____cpu_0", "____cpu_1", "cpu", "mem_0"} {
+		_, err := tableManager.CreateTable(ctx, TestSchemaName, tableName, storage.PartitionInfo{Info: nil})
+		re.NoError(err)
+	}
+
+	tableNames := func(tables []storage.Table) []string {
+		names := make([]string, 0, len(tables))
+		for _, table := range tables {
+			names = append(names, table.Name)
+		}
+		return names
+	}
+
+	// The sub tables of the partition table are ordered by the name.
+	result, err := tableManager.SearchTables(metadata.SearchTablesRequest{SchemaName: TestSchemaName, Prefix: "____cpu_", Pattern: nil, Limit: 0})
+	re.NoError(err)
+	re.Equal([]string{"____cpu_0", "____cpu_1", "____cpu_2"}, tableNames(result.Tables))
+	re.False(result.Truncated)
+
+	result, err = tableManager.SearchTables(metadata.SearchTablesRequest{SchemaName: TestSchemaName, Prefix: "", Pattern: regexp.MustCompile(`_\d$`), Limit: 2})
+	re.NoError(err)
+	re.Equal([]string{"____cpu_0", "____cpu_1"}, tableNames(result.Tables))
+	re.True(result.Truncated)
+
+	result, err = tableManager.SearchTables(metadata.SearchTablesRequest{SchemaName: TestSchemaName, Prefix: "mem", Pattern: regexp.MustCompile(`_\d$`), Limit: 2})
+	re.NoError(err)
+	re.Equal([]string{"mem_0"}, tableNames(result.Tables))
+	re.False(result.Truncated)
+}
+
+func TestTableManagerLoadSchemas(t *testing.T) {
+	ctx := context.Background()
+	re := require.New(t)
+
+	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
+	clusterStorage := storage.NewStorageWithEtcdBackend(client, TestRootPath, storage.Options{
+		MaxScanLimit: 100, MinScanLimit: 10, MaxOpsPerTxn: 10,
+	})
+
+	schemaIDAlloc := id.NewAllocatorImpl(zap.NewNop(), client, path.Join(TestRootPath, TestClusterName, TestSchemaIDPrefix), TestIDAllocatorStep)
+	tableIDAlloc := id.NewAllocatorImpl(zap.NewNop(), client, path.Join(TestRootPath, TestClusterName, TestTableIDPrefix), TestIDAllocatorStep)
+	tableManager := metadata.NewTableManagerImpl(zap.NewNop(), clusterStorage, storage.ClusterID(TestClusterID), schemaIDAlloc, tableIDAlloc, false, clock.NewRealClock())
+	re.NoError(tableManager.Load(ctx))
+
+	// More schemas than the concurrency of loading them.
+	schemaNum, tableNum := 20, 3
+	for i := 0; i < schemaNum; i++ {
+		schemaName := fmt.Sprintf("%s%d", TestSchemaName, i)
+		_, _, err := tableManager.GetOrCreateSchema(ctx, schemaName)
+		re.NoError(err)
+		for j := 0; j < tableNum; j++ {
+			_, err := tableManager.CreateTable(ctx, schemaName, fmt.Sprintf("%s%d", TestTableName, j), storage.PartitionInfo{Info: nil})
+			re.NoError(err)
+		}
+	}
+
+	// All the tables of all the schemas are loaded.
+	reloaded := metadata.NewTableManagerImpl(zap.NewNop(), clusterStorage, storage.ClusterID(TestClusterID), schemaIDAlloc, tableIDAlloc, false, clock.NewRealClock())
+	re.NoError(reloaded.Load(ctx))
+	for i := 0; i < schemaNum; i++ {
+		schemaName := fmt.Sprintf("%s%d", TestSchemaName, i)
+		for j := 0; j < tableNum; j++ {
+			tableName := fmt.Sprintf("%s%d", TestTableName, j)
+			table, exists, err := reloaded.GetTable(schemaName, tableName)
+			re.NoError(err)
+			re.True(exists)
+			re.Equal(tableName, table.Name)
+		}
+	}
+}
+
+func TestSchemaSettings(t *testing.T) {
+	ctx := context.Background()
+	re := require.New(t)
+
+	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
+	clusterStorage := storage.NewStorageWithEtcdBackend(client, TestRootPath, storage.Options{
+		MaxScanLimit: 100, MinScanLimit: 10, MaxOpsPerTxn: 10,
+	})
+
+	schemaIDAlloc := id.NewAllocatorImpl(zap.NewNop(), client, path.Join(TestRootPath, TestClusterName, TestSchemaIDPrefix), TestIDAllocatorStep)
+	tableIDAlloc := id.NewAllocatorImpl(zap.NewNop(), client, path.Join(TestRootPath, TestClusterName, TestTableIDPrefix), TestIDAllocatorStep)
+	tableManager := metadata.NewTableManagerImpl(zap.NewNop(), clusterStorage, storage.ClusterID(TestClusterID), schemaIDAlloc, tableIDAlloc, false, clock.NewRealClock())
+	re.NoError(tableManager.Load(ctx))
+
+	settings := storage.SchemaSettings{
+		MaxTableCount:       2,
+		DefaultTableOptions: map[string]string{"ttl": "7d"},
+		ShardPickingPolicy:  storage.ShardPickingPolicyRandom,
+	}
+	err := tableManager.UpdateSchemaSettings(ctx, TestSchemaName, settings)
+	re.True(coderr.Is(err, metadata.ErrSchemaNotFound.Code()))
+
+	_, _, err = tableManager.GetOrCreateSchema(ctx, TestSchemaName)
+	re.NoError(err)
+	re.NoError(tableManager.UpdateSchemaSettings(ctx, TestSchemaName, settings))
+
+	// The tables can be created until the quota is reached.
+	re.NoError(tableManager.CheckTableQuota(TestSchemaName, 2))
+	re.True(coderr.Is(tableManager.CheckTableQuota(TestSchemaName, 3), metadata.ErrSchemaTableQuotaExceeded.Code()))
+	for i := 0; i < 2; i++ {
+		_, err := tableManager.CreateTable(ctx, TestSchemaName, fmt.Sprintf("%s%d", TestTableName, i), storage.PartitionInfo{Info: nil})
+		re.NoError(err)
+	}
+	_, err = tableManager.CreateTable(ctx, TestSchemaName, fmt.Sprintf("%s%d", TestTableName, 2), storage.PartitionInfo{Info: nil})
+	re.True(coderr.Is(err, metadata.ErrSchemaTableQuotaExceeded.Code()))
+
+	// The settings are loaded along with the schemas.
+	reloaded := metadata.NewTableManagerImpl(zap.NewNop(), clusterStorage, storage.ClusterID(TestClusterID), schemaIDAlloc, tableIDAlloc, false, clock.NewRealClock())
+	re.NoError(reloaded.Load(ctx))
+	loadedSettings, ok := reloaded.GetSchemaSettings(TestSchemaName)
+	re.True(ok)
+	re.Equal(settings, loadedSettings)
+	re.Equal(map[string]storage.SchemaSettings{TestSchemaName: settings}, reloaded.ListSchemaSettings())
+
+	re.NoError(reloaded.DeleteSchemaSettings(ctx, TestSchemaName))
+	_, ok = reloaded.GetSchemaSettings(TestSchemaName)
+	re.False(ok)
+	_, err = reloaded.CreateTable(ctx, TestSchemaName, fmt.Sprintf("%s%d", TestTableName, 2), storage.PartitionInfo{Info: nil})
+	re.NoError(err)
+}
+
+func TestClusterTableQuota(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
+	clusterStorage := storage.NewStorageWithEtcdBackend(client, TestRootPath, storage.Options{
+		MaxScanLimit: 100, MinScanLimit: 10, MaxOpsPerTxn: 10,
+	})
+
+	schemaIDAlloc := id.NewAllocatorImpl(zap.NewNop(), client, path.Join(TestRootPath, TestClusterName, TestSchemaIDPrefix), TestIDAllocatorStep)
+	tableIDAlloc := id.NewAllocatorImpl(zap.NewNop(), client, path.Join(TestRootPath, TestClusterName, TestTableIDPrefix), TestIDAllocatorStep)
+	tableManager := metadata.NewTableManagerImpl(zap.NewNop(), clusterStorage, storage.ClusterID(TestClusterID), schemaIDAlloc, tableIDAlloc, false, clock.NewRealClock())
+	re.NoError(tableManager.Load(ctx))
+	tableManager.SetClusterQuota(storage.ClusterQuota{MaxTables: 3, MaxTablesPerSchema: 2, MaxShards: 0})
+
+	// The max tables per schema applies to the schema not created yet.
+	re.True(coderr.Is(tableManager.CheckTableQuota(TestSchemaName, 3), metadata.ErrSchemaTableQuotaExceeded.Code()))
+	_, _, err := tableManager.GetOrCreateSchema(ctx, TestSchemaName)
+	re.NoError(err)
+	for i := 0; i < 2; i++ {
+		_, err := tableManager.CreateTable(ctx, TestSchemaName, fmt.Sprintf("%s%d", TestTableName, i), storage.PartitionInfo{Info: nil})
+		re.NoError(err)
+	}
+	_, err = tableManager.CreateTable(ctx, TestSchemaName, fmt.Sprintf("%s%d", TestTableName, 2), storage.PartitionInfo{Info: nil})
+	re.True(coderr.Is(err, metadata.ErrSchemaTableQuotaExceeded.Code()))
+
+	// The max table count in the schema settings overrides the max tables per schema, but not the max tables of the cluster.
+	re.NoError(tableManager.UpdateSchemaSettings(ctx, TestSchemaName, storage.SchemaSettings{
+		MaxTableCount:       10,
+		DefaultTableOptions: nil,
+		ShardPickingPolicy:  storage.ShardPickingPolicyRandom,
+	}))
+	re.NoError(tableManager.CheckTableQuota(TestSchemaName, 1))
+	re.True(coderr.Is(tableManager.CheckTableQuota(TestSchemaName, 2), metadata.ErrClusterTableQuotaExceeded.Code()))
+	_, err = tableManager.CreateTables(ctx, TestSchemaName, []string{"table_a", "table_b"})
+	re.True(coderr.Is(err, metadata.ErrClusterTableQuotaExceeded.Code()))
+
+	// The tables are unlimited once the quota is removed.
+	tableManager.SetClusterQuota(storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0})
+	_, err = tableManager.CreateTables(ctx, TestSchemaName, []string{"table_a", "table_b"})
+	re.NoError(err)
+}
+
+func TestSchemaInfos(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
+	clusterStorage := storage.NewStorageWithEtcdBackend(client, TestRootPath, storage.Options{
+		MaxScanLimit: 100, MinScanLimit: 10, MaxOpsPerTxn: 10,
+	})
+
+	schemaIDAlloc := id.NewAllocatorImpl(zap.NewNop(), client, path.Join(TestRootPath, TestClusterName, TestSchemaIDPrefix), TestIDAllocatorStep)
+	tableIDAlloc := id.NewAllocatorImpl(zap.NewNop(), client, path.Join(TestRootPath, TestClusterName, TestTableIDPrefix), TestIDAllocatorStep)
+	tableManager := metadata.NewTableManagerImpl(zap.NewNop(), clusterStorage, storage.ClusterID(TestClusterID), schemaIDAlloc, tableIDAlloc, false, clock.NewRealClock())
+	re.NoError(tableManager.Load(ctx))
+	tableManager.SetClusterQuota(storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 10, MaxShards: 0})
+
+	_, ok := tableManager.GetSchemaInfo(TestSchemaName)
+	re.False(ok)
+
+	otherSchemaName := TestSchemaName + "_other"
+	for _, schemaName := range []string{TestSchemaName, otherSchemaName} {
+		_, _, err := tableManager.GetOrCreateSchema(ctx, schemaName)
+		re.NoError(err)
+	}
+	_, err := tableManager.CreateTables(ctx, TestSchemaName, []string{"table_a", "table_b"})
+	re.NoError(err)
+	settings := storage.SchemaSettings{
+		MaxTableCount:       3,
+		DefaultTableOptions: nil,
+		ShardPickingPolicy:  storage.ShardPickingPolicyRandom,
+	}
+	re.NoError(tableManager.UpdateSchemaSettings(ctx, TestSchemaName, settings))
+
+	info, ok := tableManager.GetSchemaInfo(TestSchemaName)
+	re.True(ok)
+	re.Equal(TestSchemaName, info.Name)
+	re.Equal(2, info.TableCount)
+	re.Equal(&settings, info.Settings)
+	re.Equal(uint32(3), info.MaxTableCount)
+
+	// The schemas are ordered by the name, and the one without settings is limited by the quota of the cluster.
+	infos := tableManager.ListSchemaInfos()
+	re.Len(infos, 2)
+	re.Equal(info, infos[0])
+	re.Equal(otherSchemaName, infos[1].Name)
+	re.Equal(0, infos[1].TableCount)
+	re.Nil(infos[1].Settings)
+	re.Equal(uint32(10), infos[1].MaxTableCount)
+}
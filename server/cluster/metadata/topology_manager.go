@@ -18,13 +18,20 @@ package metadata
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"slices"
 	"sync"
+	"time"
+	"unsafe"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/server/id"
 	"github.com/CeresDB/horaemeta/server/storage"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 )
 
 // TopologyManager manages the cluster topology, including the mapping relationship between shards, nodes, and tables.
@@ -33,14 +40,19 @@ type TopologyManager interface {
 	Load(ctx context.Context) error
 	// GetVersion get cluster view version.
 	GetVersion() uint64
+	// GetRouteVersion returns the version changed whenever the route of any table may change.
+	GetRouteVersion() string
 	// GetClusterState get cluster view state.
 	GetClusterState() storage.ClusterState
 	// GetTableIDs get shardNode and tablesIDs with shardID and nodeName.
 	GetTableIDs(shardIDs []storage.ShardID) map[storage.ShardID]ShardTableIDs
-	// AddTable add table to cluster topology.
-	AddTable(ctx context.Context, shardID storage.ShardID, latestVersion uint64, tables []storage.Table) error
+	// AddTable add table to cluster topology, and returns the version of the shard persisted, which is newer than the
+	// latestVersion if the tables are merged with the ones added concurrently.
+	AddTable(ctx context.Context, shardID storage.ShardID, latestVersion uint64, tables []storage.Table) (uint64, error)
 	// RemoveTable remove table on target shards from cluster topology.
 	RemoveTable(ctx context.Context, shardID storage.ShardID, latestVersion uint64, tableIDs []storage.TableID) error
+	// MoveTables move tables from the source shard to the target shard, and the shard views of both are updated atomically.
+	MoveTables(ctx context.Context, source, target ShardVersionUpdate, tables []storage.Table) error
 	// GetShards get all shards in cluster topology.
 	GetShards() []storage.ShardID
 	// GetShardNodesByID get shardNodes with shardID.
@@ -51,6 +63,8 @@ type TopologyManager interface {
 	GetShardNodes() GetShardNodesResult
 	// DropShardNodes drop target shardNodes in cluster topology.
 	DropShardNodes(ctx context.Context, shardNodes []storage.ShardNode) error
+	// PromoteShardFollower makes the follower of the shard on the new leader node be the leader, and removes the old leader.
+	PromoteShardFollower(ctx context.Context, shardID storage.ShardID, oldLeaderNodeName, newLeaderNodeName string) error
 	// InitClusterView init cluster view when create new cluster.
 	InitClusterView(ctx context.Context) error
 	// UpdateClusterView update cluster view with shardNodes.
@@ -61,10 +75,17 @@ type TopologyManager interface {
 	GetClusterView() storage.ClusterView
 	// CreateShardViews create shardViews.
 	CreateShardViews(ctx context.Context, shardViews []CreateShardView) error
+	// DeleteShardViews delete the shardViews without any table, and the shards must have been dropped from the cluster view.
+	// The ids of the deleted shards are collected to be reused.
+	DeleteShardViews(ctx context.Context, shardIDs []storage.ShardID) error
 	// UpdateShardVersionWithExpect update shard version when pre version is same as expect version.
 	UpdateShardVersionWithExpect(ctx context.Context, shardID storage.ShardID, version uint64, expect uint64) error
 	// GetTopology get current topology snapshot.
 	GetTopology() Topology
+	// GetMemoryStats get the approximate memory footprint of the shard views and the table to shards mapping.
+	GetMemoryStats() TopologyMemoryStats
+	// ApplyTopologyChanges apply the shard views, nodes and cluster view changed in storage to the cache.
+	ApplyTopologyChanges(ctx context.Context, changes storage.ClusterChanges) error
 }
 
 type ShardTableIDs struct {
@@ -100,7 +121,14 @@ func (t *Topology) IsStable() bool {
 	if t.ClusterView.State != storage.ClusterStateStable {
 		return false
 	}
-	if len(t.ClusterView.ShardNodes) != len(t.ShardViewsMapping) {
+	// The followers are not counted, because every shard is stable once it has a leader.
+	leaderCount := 0
+	for _, shardNode := range t.ClusterView.ShardNodes {
+		if shardNode.ShardRole == storage.ShardRoleLeader {
+			leaderCount++
+		}
+	}
+	if leaderCount != len(t.ShardViewsMapping) {
 		return false
 	}
 	return true
@@ -121,6 +149,7 @@ type TopologyManagerImpl struct {
 	storage      storage.Storage
 	clusterID    storage.ClusterID
 	shardIDAlloc id.Allocator
+	clock        clock.Clock
 
 	// RWMutex is used to protect following fields.
 	lock              sync.RWMutex
@@ -130,16 +159,21 @@ type TopologyManagerImpl struct {
 	// ShardView in memory.
 	shardTablesMapping map[storage.ShardID]*storage.ShardView // ShardID -> shardTopology
 	tableShardMapping  map[storage.TableID][]storage.ShardID  // tableID -> ShardID
+	// routeGeneration is increased whenever the topology in memory is updated, and it restarts from zero with another
+	// routeEpoch in another topology manager.
+	routeEpoch      string
+	routeGeneration uint64
 
 	nodes map[string]storage.Node // NodeName in memory.
 }
 
-func NewTopologyManagerImpl(logger *zap.Logger, storage storage.Storage, clusterID storage.ClusterID, shardIDAlloc id.Allocator) TopologyManager {
+func NewTopologyManagerImpl(logger *zap.Logger, storage storage.Storage, clusterID storage.ClusterID, shardIDAlloc id.Allocator, clk clock.Clock) TopologyManager {
 	return &TopologyManagerImpl{
 		logger:       logger,
 		storage:      storage,
 		clusterID:    clusterID,
 		shardIDAlloc: shardIDAlloc,
+		clock:        clk,
 		lock:         sync.RWMutex{},
 		// The following fields will be initialized in the Load method.
 		clusterView:        nil,
@@ -147,26 +181,44 @@ func NewTopologyManagerImpl(logger *zap.Logger, storage storage.Storage, cluster
 		nodeShardsMapping:  nil,
 		shardTablesMapping: nil,
 		tableShardMapping:  nil,
+		routeEpoch:         newRouteEpoch(),
+		routeGeneration:    0,
 		nodes:              nil,
 	}
 }
 
+func newRouteEpoch() string {
+	epoch := make([]byte, 8)
+	if _, err := rand.Read(epoch); err != nil {
+		// The epoch only needs to differ from the ones of the other topology managers.
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(epoch)
+}
+
+// bumpRouteGenerationWithLock should be called with the write lock held after the topology in memory is updated.
+func (m *TopologyManagerImpl) bumpRouteGenerationWithLock() {
+	m.routeGeneration++
+}
+
+// Load loads the cluster view, the shard views and the nodes concurrently, and they are independent of each other as
+// every load only resets its own part of the topology.
 func (m *TopologyManagerImpl) Load(ctx context.Context) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
-	if err := m.loadClusterView(ctx); err != nil {
-		return errors.WithMessage(err, "load cluster view")
-	}
-
-	if err := m.loadShardViews(ctx); err != nil {
-		return errors.WithMessage(err, "load shard views")
-	}
-
-	if err := m.loadNodes(ctx); err != nil {
-		return errors.WithMessage(err, "load nodes")
-	}
-	return nil
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		return errors.WithMessage(m.loadClusterView(gctx), "load cluster view")
+	})
+	g.Go(func() error {
+		return errors.WithMessage(m.loadShardViews(gctx), "load shard views")
+	})
+	g.Go(func() error {
+		return errors.WithMessage(m.loadNodes(gctx), "load nodes")
+	})
+	defer m.bumpRouteGenerationWithLock()
+	return g.Wait()
 }
 
 func (m *TopologyManagerImpl) GetVersion() uint64 {
@@ -176,6 +228,16 @@ func (m *TopologyManagerImpl) GetVersion() uint64 {
 	return m.clusterView.Version
 }
 
+// GetRouteVersion returns the epoch and the generation of the topology in memory. The versions of the shard views can't
+// tell the changes of the routes, because they are given by the callers and the tables added concurrently are merged
+// into the same version.
+func (m *TopologyManagerImpl) GetRouteVersion() string {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return fmt.Sprintf("%s-%d", m.routeEpoch, m.routeGeneration)
+}
+
 func (m *TopologyManagerImpl) GetClusterState() storage.ClusterState {
 	m.lock.RLock()
 	defer m.lock.RUnlock()
@@ -189,7 +251,10 @@ func (m *TopologyManagerImpl) GetTableIDs(shardIDs []storage.ShardID) map[storag
 
 	shardTableIDs := make(map[storage.ShardID]ShardTableIDs, len(shardIDs))
 	for _, shardID := range shardIDs {
-		shardView := m.shardTablesMapping[shardID]
+		shardView, ok := m.shardTablesMapping[shardID]
+		if !ok {
+			continue
+		}
 		shardTableIDs[shardID] = ShardTableIDs{
 			TableIDs: shardView.TableIDs,
 			Version:  shardView.Version,
@@ -199,13 +264,13 @@ func (m *TopologyManagerImpl) GetTableIDs(shardIDs []storage.ShardID) map[storag
 	return shardTableIDs
 }
 
-func (m *TopologyManagerImpl) AddTable(ctx context.Context, shardID storage.ShardID, latestVersion uint64, tables []storage.Table) error {
+func (m *TopologyManagerImpl) AddTable(ctx context.Context, shardID storage.ShardID, latestVersion uint64, tables []storage.Table) (uint64, error) {
 	m.lock.Lock()
 	defer m.lock.Unlock()
 
 	shardView, ok := m.shardTablesMapping[shardID]
 	if !ok {
-		return ErrShardNotFound.WithCausef("shard id:%d", shardID)
+		return 0, ErrShardNotFound.WithCausef("shard id:%d", shardID)
 	}
 
 	tableIDsToAdd := make([]storage.TableID, 0, len(tables))
@@ -217,30 +282,32 @@ func (m *TopologyManagerImpl) AddTable(ctx context.Context, shardID storage.Shar
 	tableIDs = append(tableIDs, shardView.TableIDs...)
 	tableIDs = append(tableIDs, tableIDsToAdd...)
 
-	newShardView := storage.NewShardView(shardID, latestVersion, tableIDs)
+	newShardView := storage.NewShardView(shardID, latestVersion, tableIDs, clock.UnixMilli(m.clock))
+	newShardView.InheritTableVersions(*shardView)
 
-	// Update shard view in storage.
-	err := m.storage.UpdateShardView(ctx, storage.UpdateShardViewRequest{
-		ClusterID:   m.clusterID,
-		ShardView:   newShardView,
-		PrevVersion: shardView.Version,
+	// Update shard view in storage, and the added tables may be merged with the tables added concurrently by another
+	// meta, e.g. the previous leader not stepping down yet.
+	result, err := m.storage.UpdateShardView(ctx, storage.UpdateShardViewRequest{
+		ClusterID:     m.clusterID,
+		ShardView:     newShardView,
+		PrevVersion:   shardView.Version,
+		AddedTableIDs: tableIDsToAdd,
 	})
 	if err != nil {
-		return errors.WithMessage(err, "storage update shard view")
+		return 0, errors.WithMessage(err, "storage update shard view")
 	}
 
 	// Update shard view in memory.
-	m.shardTablesMapping[shardID] = &newShardView
-	for _, tableID := range tableIDsToAdd {
-		_, exists := m.tableShardMapping[tableID]
-		if !exists {
-			m.tableShardMapping[tableID] = []storage.ShardID{shardID}
-		} else {
+	mergedShardView := result.ShardView
+	m.shardTablesMapping[shardID] = &mergedShardView
+	for _, tableID := range mergedShardView.TableIDs {
+		if !slices.Contains(m.tableShardMapping[tableID], shardID) {
 			m.tableShardMapping[tableID] = append(m.tableShardMapping[tableID], shardID)
 		}
 	}
+	m.bumpRouteGenerationWithLock()
 
-	return nil
+	return mergedShardView.Version, nil
 }
 
 func (m *TopologyManagerImpl) RemoveTable(ctx context.Context, shardID storage.ShardID, latestVersion uint64, tableIDs []storage.TableID) error {
@@ -252,21 +319,25 @@ func (m *TopologyManagerImpl) RemoveTable(ctx context.Context, shardID storage.S
 		return ErrShardNotFound.WithCausef("shard id:%d", shardID)
 	}
 
+	tableIDsToRemove := make(map[storage.TableID]struct{}, len(tableIDs))
+	for _, tableID := range tableIDs {
+		tableIDsToRemove[tableID] = struct{}{}
+	}
 	newTableIDs := make([]storage.TableID, 0, len(shardView.TableIDs))
 	for _, tableID := range shardView.TableIDs {
-		for _, tableIDToRemove := range tableIDs {
-			if tableID != tableIDToRemove {
-				newTableIDs = append(newTableIDs, tableID)
-			}
+		if _, ok := tableIDsToRemove[tableID]; !ok {
+			newTableIDs = append(newTableIDs, tableID)
 		}
 	}
 
 	// Update shardView in storage.
-	newShardView := storage.NewShardView(shardView.ShardID, latestVersion, newTableIDs)
-	if err := m.storage.UpdateShardView(ctx, storage.UpdateShardViewRequest{
-		ClusterID:   m.clusterID,
-		ShardView:   newShardView,
-		PrevVersion: shardView.Version,
+	newShardView := storage.NewShardView(shardView.ShardID, latestVersion, newTableIDs, clock.UnixMilli(m.clock))
+	newShardView.InheritTableVersions(*shardView)
+	if _, err := m.storage.UpdateShardView(ctx, storage.UpdateShardViewRequest{
+		ClusterID:     m.clusterID,
+		ShardView:     newShardView,
+		PrevVersion:   shardView.Version,
+		AddedTableIDs: nil,
 	}); err != nil {
 		return errors.WithMessage(err, "storage update shard view")
 	}
@@ -274,6 +345,7 @@ func (m *TopologyManagerImpl) RemoveTable(ctx context.Context, shardID storage.S
 	// Update shardView in memory.
 	shardView.Version = latestVersion
 	shardView.TableIDs = newTableIDs
+	shardView.TableVersions = newShardView.TableVersions
 	for _, tableID := range tableIDs {
 		delete(m.tableShardMapping, tableID)
 	}
@@ -287,6 +359,76 @@ func (m *TopologyManagerImpl) RemoveTable(ctx context.Context, shardID storage.S
 			}
 		}
 	}
+	m.bumpRouteGenerationWithLock()
+
+	return nil
+}
+
+func (m *TopologyManagerImpl) MoveTables(ctx context.Context, source, target ShardVersionUpdate, tables []storage.Table) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	sourceShardView, ok := m.shardTablesMapping[source.ShardID]
+	if !ok {
+		return ErrShardNotFound.WithCausef("shard id:%d", source.ShardID)
+	}
+	targetShardView, ok := m.shardTablesMapping[target.ShardID]
+	if !ok {
+		return ErrShardNotFound.WithCausef("shard id:%d", target.ShardID)
+	}
+
+	tableIDsToMove := make(map[storage.TableID]struct{}, len(tables))
+	for _, table := range tables {
+		if !slices.Contains(sourceShardView.TableIDs, table.ID) {
+			return errors.WithMessagef(ErrTableNotFound, "table not in the source shard, shardID:%d, tableID:%d", source.ShardID, table.ID)
+		}
+		tableIDsToMove[table.ID] = struct{}{}
+	}
+
+	sourceTableIDs := make([]storage.TableID, 0, len(sourceShardView.TableIDs))
+	for _, tableID := range sourceShardView.TableIDs {
+		if _, ok := tableIDsToMove[tableID]; !ok {
+			sourceTableIDs = append(sourceTableIDs, tableID)
+		}
+	}
+	targetTableIDs := make([]storage.TableID, 0, len(targetShardView.TableIDs)+len(tables))
+	targetTableIDs = append(targetTableIDs, targetShardView.TableIDs...)
+	for _, table := range tables {
+		targetTableIDs = append(targetTableIDs, table.ID)
+	}
+
+	now := clock.UnixMilli(m.clock)
+	newSourceShardView := storage.NewShardView(source.ShardID, source.LatestVersion, sourceTableIDs, now)
+	newSourceShardView.InheritTableVersions(*sourceShardView)
+	newTargetShardView := storage.NewShardView(target.ShardID, target.LatestVersion, targetTableIDs, now)
+	newTargetShardView.InheritTableVersions(*targetShardView)
+
+	if err := m.storage.UpdateShardViews(ctx, storage.UpdateShardViewsRequest{
+		ClusterID: m.clusterID,
+		Updates: []storage.ShardViewUpdate{
+			{ShardView: newSourceShardView, PrevVersion: sourceShardView.Version},
+			{ShardView: newTargetShardView, PrevVersion: targetShardView.Version},
+		},
+	}); err != nil {
+		return errors.WithMessage(err, "storage update shard views")
+	}
+
+	// Update shard views in memory.
+	m.shardTablesMapping[source.ShardID] = &newSourceShardView
+	m.shardTablesMapping[target.ShardID] = &newTargetShardView
+	for tableID := range tableIDsToMove {
+		shardIDs := make([]storage.ShardID, 0, len(m.tableShardMapping[tableID]))
+		for _, shardID := range m.tableShardMapping[tableID] {
+			if shardID != source.ShardID {
+				shardIDs = append(shardIDs, shardID)
+			}
+		}
+		if !slices.Contains(shardIDs, target.ShardID) {
+			shardIDs = append(shardIDs, target.ShardID)
+		}
+		m.tableShardMapping[tableID] = shardIDs
+	}
+	m.bumpRouteGenerationWithLock()
 
 	return nil
 }
@@ -390,6 +532,37 @@ func (m *TopologyManagerImpl) DropShardNodes(ctx context.Context, shardNodes []s
 	return m.updateClusterViewWithLock(ctx, m.clusterView.State, newShardNodes)
 }
 
+func (m *TopologyManagerImpl) PromoteShardFollower(ctx context.Context, shardID storage.ShardID, oldLeaderNodeName, newLeaderNodeName string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	followerFound := false
+	newShardNodes := make([]storage.ShardNode, 0, len(m.clusterView.ShardNodes))
+	for _, shardNode := range m.clusterView.ShardNodes {
+		if shardNode.ID != shardID {
+			newShardNodes = append(newShardNodes, shardNode)
+			continue
+		}
+		switch shardNode.NodeName {
+		case oldLeaderNodeName:
+			// The old leader is dropped, and it will be closed by the repair if it comes back.
+		case newLeaderNodeName:
+			if shardNode.ShardRole == storage.ShardRoleFollower {
+				followerFound = true
+			}
+			shardNode.ShardRole = storage.ShardRoleLeader
+			newShardNodes = append(newShardNodes, shardNode)
+		default:
+			newShardNodes = append(newShardNodes, shardNode)
+		}
+	}
+	if !followerFound {
+		return errors.WithMessagef(ErrShardNotFound, "shard follower not found, shardID:%d, node:%s", shardID, newLeaderNodeName)
+	}
+
+	return m.updateClusterViewWithLock(ctx, m.clusterView.State, newShardNodes)
+}
+
 func contains(shardNodes []storage.ShardNode, originShardNode storage.ShardNode) bool {
 	for _, dropShardNode := range shardNodes {
 		if originShardNode.NodeName == dropShardNode.NodeName && originShardNode.ID == dropShardNode.ID {
@@ -400,7 +573,7 @@ func contains(shardNodes []storage.ShardNode, originShardNode storage.ShardNode)
 }
 
 func (m *TopologyManagerImpl) InitClusterView(ctx context.Context) error {
-	clusterView := storage.NewClusterView(m.clusterID, 0, storage.ClusterStateEmpty, []storage.ShardNode{})
+	clusterView := storage.NewClusterView(m.clusterID, 0, storage.ClusterStateEmpty, []storage.ShardNode{}, clock.UnixMilli(m.clock))
 
 	err := m.storage.CreateClusterView(ctx, storage.CreateClusterViewRequest{ClusterView: clusterView})
 	if err != nil {
@@ -409,6 +582,7 @@ func (m *TopologyManagerImpl) InitClusterView(ctx context.Context) error {
 
 	m.lock.Lock()
 	defer m.lock.Unlock()
+	defer m.bumpRouteGenerationWithLock()
 	// Load cluster view into memory.
 	if err := m.loadClusterView(ctx); err != nil {
 		return errors.WithMessage(err, "load cluster view")
@@ -425,7 +599,7 @@ func (m *TopologyManagerImpl) UpdateClusterView(ctx context.Context, state stora
 
 func (m *TopologyManagerImpl) updateClusterViewWithLock(ctx context.Context, state storage.ClusterState, shardNodes []storage.ShardNode) error {
 	// Update cluster view in storage.
-	newClusterView := storage.NewClusterView(m.clusterID, m.clusterView.Version+1, state, shardNodes)
+	newClusterView := storage.NewClusterView(m.clusterID, m.clusterView.Version+1, state, shardNodes, clock.UnixMilli(m.clock))
 	if err := m.storage.UpdateClusterView(ctx, storage.UpdateClusterViewRequest{
 		ClusterID:     m.clusterID,
 		ClusterView:   newClusterView,
@@ -434,6 +608,7 @@ func (m *TopologyManagerImpl) updateClusterViewWithLock(ctx context.Context, sta
 		return errors.WithMessage(err, "storage update cluster view")
 	}
 
+	defer m.bumpRouteGenerationWithLock()
 	// Load cluster view into memory.
 	if err := m.loadClusterView(ctx); err != nil {
 		return errors.WithMessage(err, "load cluster view")
@@ -474,7 +649,7 @@ func (m *TopologyManagerImpl) CreateShardViews(ctx context.Context, createShardV
 	// Create shard view in storage.
 	shardViews := make([]storage.ShardView, 0, len(createShardViews))
 	for _, createShardView := range createShardViews {
-		shardViews = append(shardViews, storage.NewShardView(createShardView.ShardID, 0, createShardView.Tables))
+		shardViews = append(shardViews, storage.NewShardView(createShardView.ShardID, 0, createShardView.Tables, clock.UnixMilli(m.clock)))
 	}
 	if err := m.storage.CreateShardViews(ctx, storage.CreateShardViewsRequest{
 		ClusterID:  m.clusterID,
@@ -483,6 +658,7 @@ func (m *TopologyManagerImpl) CreateShardViews(ctx context.Context, createShardV
 		return errors.WithMessage(err, "storage create shard view")
 	}
 
+	defer m.bumpRouteGenerationWithLock()
 	// Load shard view into memory.
 	if err := m.loadShardViews(ctx); err != nil {
 		return errors.WithMessage(err, "load shard view")
@@ -490,6 +666,37 @@ func (m *TopologyManagerImpl) CreateShardViews(ctx context.Context, createShardV
 	return nil
 }
 
+func (m *TopologyManagerImpl) DeleteShardViews(ctx context.Context, shardIDs []storage.ShardID) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, shardID := range shardIDs {
+		if shardView, ok := m.shardTablesMapping[shardID]; ok && len(shardView.TableIDs) > 0 {
+			return ErrShardNotEmpty.WithCausef("shardID:%d, tableCount:%d", shardID, len(shardView.TableIDs))
+		}
+		if _, ok := m.shardNodesMapping[shardID]; ok {
+			return ErrShardNotEmpty.WithCausef("shard is still assigned to the nodes, shardID:%d", shardID)
+		}
+	}
+
+	if err := m.storage.DeleteShardViews(ctx, storage.DeleteShardViewsRequest{
+		ClusterID: m.clusterID,
+		ShardIDs:  shardIDs,
+	}); err != nil {
+		return errors.WithMessage(err, "storage delete shard views")
+	}
+
+	defer m.bumpRouteGenerationWithLock()
+	for _, shardID := range shardIDs {
+		delete(m.shardTablesMapping, shardID)
+		// The shard id failing to be collected is only never reused.
+		if err := m.shardIDAlloc.Collect(ctx, uint64(shardID)); err != nil {
+			m.logger.Warn("collect shard id failed", zap.Uint32("shardID", uint32(shardID)), zap.Error(err))
+		}
+	}
+	return nil
+}
+
 func (m *TopologyManagerImpl) UpdateShardVersionWithExpect(ctx context.Context, shardID storage.ShardID, version uint64, expect uint64) error {
 	m.lock.Lock()
 	defer m.lock.Unlock()
@@ -499,17 +706,20 @@ func (m *TopologyManagerImpl) UpdateShardVersionWithExpect(ctx context.Context,
 		return ErrShardNotFound.WithCausef("shard id:%d", shardID)
 	}
 
-	newShardView := storage.NewShardView(shardID, version, shardView.TableIDs)
-	if err := m.storage.UpdateShardView(ctx, storage.UpdateShardViewRequest{
-		ClusterID:   m.clusterID,
-		ShardView:   newShardView,
-		PrevVersion: expect,
+	newShardView := storage.NewShardView(shardID, version, shardView.TableIDs, clock.UnixMilli(m.clock))
+	newShardView.InheritTableVersions(*shardView)
+	if _, err := m.storage.UpdateShardView(ctx, storage.UpdateShardViewRequest{
+		ClusterID:     m.clusterID,
+		ShardView:     newShardView,
+		PrevVersion:   expect,
+		AddedTableIDs: nil,
 	}); err != nil {
 		return errors.WithMessage(err, "storage update shard view")
 	}
 
 	// Update shard view into memory.
 	m.shardTablesMapping[shardID] = &newShardView
+	m.bumpRouteGenerationWithLock()
 
 	return nil
 }
@@ -521,10 +731,11 @@ func (m *TopologyManagerImpl) GetTopology() Topology {
 	shardViewsMapping := make(map[storage.ShardID]storage.ShardView, len(m.shardTablesMapping))
 	for shardID, view := range m.shardTablesMapping {
 		shardViewsMapping[shardID] = storage.ShardView{
-			ShardID:   view.ShardID,
-			Version:   view.Version,
-			TableIDs:  view.TableIDs,
-			CreatedAt: view.CreatedAt,
+			ShardID:       view.ShardID,
+			Version:       view.Version,
+			TableIDs:      view.TableIDs,
+			TableVersions: view.TableVersions,
+			CreatedAt:     view.CreatedAt,
 		}
 	}
 
@@ -534,6 +745,120 @@ func (m *TopologyManagerImpl) GetTopology() Topology {
 	}
 }
 
+func (m *TopologyManagerImpl) GetMemoryStats() TopologyMemoryStats {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	stats := TopologyMemoryStats{
+		ShardViews:  CacheMemoryStats{EntryCount: 0, ApproximateBytes: 0},
+		TableShards: CacheMemoryStats{EntryCount: 0, ApproximateBytes: 0},
+	}
+	for shardID, shardView := range m.shardTablesMapping {
+		stats.ShardViews.addEntry(uint64(unsafe.Sizeof(shardID)+unsafe.Sizeof(shardView)) + shardViewSize(shardView) + mapEntryOverhead)
+	}
+	for tableID, shardIDs := range m.tableShardMapping {
+		stats.TableShards.addEntry(uint64(unsafe.Sizeof(tableID)+unsafe.Sizeof(shardIDs)) + uint64(cap(shardIDs))*uint64(unsafe.Sizeof(storage.ShardID(0))) + mapEntryOverhead)
+	}
+	return stats
+}
+
+// ApplyTopologyChanges gets the latest shard views and cluster view if they are changed, and the ones older than those in
+// memory are ignored, because the memory may have been updated by the later changes.
+func (m *TopologyManagerImpl) ApplyTopologyChanges(ctx context.Context, changes storage.ClusterChanges) error {
+	// The changed views are got before the lock is held, so that the reads of the topology are not blocked by the storage.
+	var shardViews []storage.ShardView
+	if len(changes.ShardIDs) > 0 {
+		shardViewsResult, err := m.storage.ListShardViews(ctx, storage.ListShardViewsRequest{
+			ClusterID: m.clusterID,
+			ShardIDs:  changes.ShardIDs,
+		})
+		if err != nil {
+			return errors.WithMessage(err, "storage list shard views")
+		}
+		shardViews = shardViewsResult.ShardViews
+	}
+	var clusterView *storage.ClusterView
+	if changes.ClusterViewChanged {
+		clusterViewResult, err := m.storage.GetClusterView(ctx, storage.GetClusterViewRequest{
+			ClusterID: m.clusterID,
+		})
+		if err != nil {
+			return errors.WithMessage(err, "storage get cluster view")
+		}
+		clusterView = &clusterViewResult.ClusterView
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	for _, shardView := range shardViews {
+		if cached, ok := m.shardTablesMapping[shardView.ShardID]; ok && cached.Version > shardView.Version {
+			continue
+		}
+		m.replaceShardView(shardView)
+	}
+	for _, shardID := range changes.DeletedShardIDs {
+		m.removeShardView(shardID)
+	}
+	if clusterView != nil && clusterView.Version >= m.clusterView.Version {
+		m.setClusterView(clusterView)
+	}
+	for _, change := range changes.Nodes {
+		switch change.Type {
+		case storage.ChangeTypePut:
+			m.nodes[change.NodeName] = change.Node
+		case storage.ChangeTypeDelete:
+			delete(m.nodes, change.NodeName)
+		}
+	}
+	m.bumpRouteGenerationWithLock()
+
+	return nil
+}
+
+// replaceShardView replaces the shard view in memory, and moves the tables in the table to shards mapping accordingly.
+func (m *TopologyManagerImpl) replaceShardView(shardView storage.ShardView) {
+	if prev, ok := m.shardTablesMapping[shardView.ShardID]; ok {
+		for _, tableID := range prev.TableIDs {
+			shardIDs := make([]storage.ShardID, 0, len(m.tableShardMapping[tableID]))
+			for _, shardID := range m.tableShardMapping[tableID] {
+				if shardID != shardView.ShardID {
+					shardIDs = append(shardIDs, shardID)
+				}
+			}
+			if len(shardIDs) == 0 {
+				delete(m.tableShardMapping, tableID)
+				continue
+			}
+			m.tableShardMapping[tableID] = shardIDs
+		}
+	}
+
+	m.shardTablesMapping[shardView.ShardID] = &shardView
+	for _, tableID := range shardView.TableIDs {
+		if !slices.Contains(m.tableShardMapping[tableID], shardView.ShardID) {
+			m.tableShardMapping[tableID] = append(m.tableShardMapping[tableID], shardView.ShardID)
+		}
+	}
+}
+
+// removeShardView removes the shard view in memory, along with its tables in the table to shards mapping.
+func (m *TopologyManagerImpl) removeShardView(shardID storage.ShardID) {
+	prev, ok := m.shardTablesMapping[shardID]
+	if !ok {
+		return
+	}
+	for _, tableID := range prev.TableIDs {
+		shardIDs := slices.DeleteFunc(slices.Clone(m.tableShardMapping[tableID]), func(id storage.ShardID) bool { return id == shardID })
+		if len(shardIDs) == 0 {
+			delete(m.tableShardMapping, tableID)
+			continue
+		}
+		m.tableShardMapping[tableID] = shardIDs
+	}
+	delete(m.shardTablesMapping, shardID)
+}
+
 func (m *TopologyManagerImpl) loadClusterView(ctx context.Context) error {
 	clusterViewResult, err := m.storage.GetClusterView(ctx, storage.GetClusterViewRequest{
 		ClusterID: m.clusterID,
@@ -543,15 +868,19 @@ func (m *TopologyManagerImpl) loadClusterView(ctx context.Context) error {
 	}
 	m.logger.Debug("load cluster view", zap.String("clusterViews", fmt.Sprintf("%+v", clusterViewResult)))
 
-	m.shardNodesMapping = make(map[storage.ShardID][]storage.ShardNode, len(clusterViewResult.ClusterView.ShardNodes))
-	m.nodeShardsMapping = make(map[string][]storage.ShardNode, len(clusterViewResult.ClusterView.ShardNodes))
-	for _, shardNode := range clusterViewResult.ClusterView.ShardNodes {
+	m.setClusterView(&clusterViewResult.ClusterView)
+	return nil
+}
+
+// setClusterView replaces the cluster view in memory and rebuilds the mappings between the shards and the nodes.
+func (m *TopologyManagerImpl) setClusterView(clusterView *storage.ClusterView) {
+	m.shardNodesMapping = make(map[storage.ShardID][]storage.ShardNode, len(clusterView.ShardNodes))
+	m.nodeShardsMapping = make(map[string][]storage.ShardNode, len(clusterView.ShardNodes))
+	for _, shardNode := range clusterView.ShardNodes {
 		m.shardNodesMapping[shardNode.ID] = append(m.shardNodesMapping[shardNode.ID], shardNode)
 		m.nodeShardsMapping[shardNode.NodeName] = append(m.nodeShardsMapping[shardNode.NodeName], shardNode)
 	}
-	m.clusterView = &clusterViewResult.ClusterView
-
-	return nil
+	m.clusterView = clusterView
 }
 
 func (m *TopologyManagerImpl) loadShardViews(ctx context.Context) error {
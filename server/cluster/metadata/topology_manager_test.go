@@ -20,6 +20,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/etcdutil"
 	"github.com/CeresDB/horaemeta/server/id"
@@ -49,7 +50,7 @@ func TestTopologyManager(t *testing.T) {
 	})
 	shardIDAlloc := id.NewReusableAllocatorImpl([]uint64{}, TestMinShardID)
 
-	topologyManager := metadata.NewTopologyManagerImpl(zap.NewNop(), clusterStorage, TestClusterID, shardIDAlloc)
+	topologyManager := metadata.NewTopologyManagerImpl(zap.NewNop(), clusterStorage, TestClusterID, shardIDAlloc, clock.NewRealClock())
 
 	err := topologyManager.InitClusterView(ctx)
 	re.NoError(err)
@@ -65,8 +66,58 @@ func TestTopologyManager(t *testing.T) {
 	testShardTopology(ctx, re, topologyManager)
 }
 
+func TestTopologyManagerMergeAddTable(t *testing.T) {
+	ctx := context.Background()
+	re := require.New(t)
+
+	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
+	clusterStorage := storage.NewStorageWithEtcdBackend(client, TestRootPath, storage.Options{
+		MaxScanLimit: 100, MinScanLimit: 10, MaxOpsPerTxn: 32,
+	})
+	newTopologyManager := func() metadata.TopologyManager {
+		return metadata.NewTopologyManagerImpl(zap.NewNop(), clusterStorage, TestClusterID, id.NewReusableAllocatorImpl([]uint64{}, TestMinShardID), clock.NewRealClock())
+	}
+	newTable := func(tableID storage.TableID) []storage.Table {
+		return []storage.Table{{
+			ID:            tableID,
+			Name:          TestTableName,
+			SchemaID:      TestSchemaID,
+			CreatedAt:     0,
+			PartitionInfo: storage.PartitionInfo{Info: nil},
+		}}
+	}
+
+	current := newTopologyManager()
+	re.NoError(current.InitClusterView(ctx))
+	re.NoError(current.CreateShardViews(ctx, []metadata.CreateShardView{{ShardID: TestShardID, Tables: nil}}))
+
+	// The stale manager loads the same shard view, just like the previous leader not stepping down yet.
+	stale := newTopologyManager()
+	re.NoError(stale.Load(ctx))
+
+	version, err := current.AddTable(ctx, TestShardID, 1, newTable(1))
+	re.NoError(err)
+	re.Equal(uint64(1), version)
+
+	// Both tables are added from the version 0, and the later one is merged at the version returned by the node.
+	_, err = stale.AddTable(ctx, TestShardID, 1, newTable(2))
+	re.Error(err)
+	version, err = stale.AddTable(ctx, TestShardID, 2, newTable(2))
+	re.NoError(err)
+	re.Equal(uint64(2), version)
+
+	shardTableIDs := stale.GetTableIDs([]storage.ShardID{TestShardID})[TestShardID]
+	re.Equal(uint64(2), shardTableIDs.Version)
+	re.ElementsMatch([]storage.TableID{1, 2}, shardTableIDs.TableIDs)
+	re.Equal(map[storage.TableID]uint64{1: 1, 2: 2}, stale.GetTopology().ShardViewsMapping[TestShardID].TableVersions)
+
+	// Adding the table already added by the other one can't be merged.
+	_, err = current.AddTable(ctx, TestShardID, 3, newTable(2))
+	re.Error(err)
+}
+
 func testTableTopology(ctx context.Context, re *require.Assertions, manager metadata.TopologyManager) {
-	err := manager.AddTable(ctx, TestShardID, 0, []storage.Table{{
+	_, err := manager.AddTable(ctx, TestShardID, 0, []storage.Table{{
 		ID:            TestTableID,
 		Name:          TestTableName,
 		SchemaID:      TestSchemaID,
@@ -86,7 +137,7 @@ func testTableTopology(ctx context.Context, re *require.Assertions, manager meta
 	found = foundTable(TestTableID, shardTables, TestTableID)
 	re.Equal(false, found)
 
-	err = manager.AddTable(ctx, TestShardID, 0, []storage.Table{{
+	_, err = manager.AddTable(ctx, TestShardID, 0, []storage.Table{{
 		ID:            TestTableID,
 		Name:          TestTableName,
 		SchemaID:      TestSchemaID,
@@ -17,6 +17,7 @@
 package metadata
 
 import (
+	"regexp"
 	"time"
 
 	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
@@ -25,13 +26,22 @@ import (
 )
 
 const (
-	expiredThreshold = time.Second * 10
-	MinShardID       = 0
+	// NodeExpiredThreshold is how long a node is regarded as online after its last heartbeat.
+	NodeExpiredThreshold = time.Second * 10
+	MinShardID           = 0
 )
 
 type Snapshot struct {
 	Topology        Topology
 	RegisteredNodes []RegisteredNode
+	// CordonedNodes are the nodes no new shards should be assigned to, while the shards on them stay.
+	CordonedNodes map[string]struct{}
+}
+
+// IsCordoned returns true if no new shards should be assigned to the node.
+func (s Snapshot) IsCordoned(nodeName string) bool {
+	_, ok := s.CordonedNodes[nodeName]
+	return ok
 }
 
 type TableInfo struct {
@@ -48,6 +58,26 @@ type ShardTables struct {
 	Tables []TableInfo
 }
 
+// Page returns the tables in the range of [offset, offset+limit) in the order of the shard view, and the zero limit
+// means no limit.
+func (s ShardTables) Page(offset, limit int) ShardTables {
+	start := min(offset, len(s.Tables))
+	end := len(s.Tables)
+	if limit > 0 {
+		end = min(start+limit, end)
+	}
+	return ShardTables{
+		Shard:  s.Shard,
+		Tables: s.Tables[start:end],
+	}
+}
+
+// ShardTableCount is the summary of the shard tables without the table details.
+type ShardTableCount struct {
+	Shard      ShardInfo
+	TableCount int
+}
+
 type ShardInfo struct {
 	ID   storage.ShardID
 	Role storage.ShardRole
@@ -68,11 +98,65 @@ type CreateClusterOpts struct {
 	EnableSchedule              bool
 	TopologyType                storage.TopologyType
 	ProcedureExecutingBatchSize uint32
+	// CaseInsensitiveName can only be set when the cluster is created, because it decides the keys of the stored tables.
+	CaseInsensitiveName bool
+	// DefaultSchemaName is the schema created along with the cluster, and no schema is created if it is empty.
+	DefaultSchemaName string
+	// HeartbeatIntervalBounds bounds the heartbeat interval advised to the nodes, and nothing is advised if it is zero.
+	HeartbeatIntervalBounds storage.HeartbeatIntervalBounds
+	// Quota limits the tables and shards of the cluster, and the shard total can't exceed its max shards.
+	Quota storage.ClusterQuota
+	// SchemaCreationPolicy decides whether the unknown schemas are created on AllocSchemaID and CreateTable.
+	SchemaCreationPolicy storage.SchemaCreationPolicy
+	// Protected blocks the destructive operations on the cluster unless they are forced with the confirmation.
+	Protected bool
+	// ShardNodes assigns the shards to the nodes when the cluster is created, and the cluster is stable at once if it is
+	// not empty, which is used to provision a cluster of the static topology.
+	ShardNodes []storage.ShardNode
+}
+
+// ValidateHeartbeatIntervalBounds requires the min interval to be set and not greater than the max one if the bounds are enabled.
+func ValidateHeartbeatIntervalBounds(bounds storage.HeartbeatIntervalBounds) error {
+	if !bounds.Enabled() {
+		return nil
+	}
+	if bounds.MinMs == 0 || bounds.MinMs > bounds.MaxMs {
+		return ErrInvalidHeartbeatInterval.WithCausef("minMs:%d, maxMs:%d", bounds.MinMs, bounds.MaxMs)
+	}
+	return nil
 }
 
 type UpdateClusterOpts struct {
 	TopologyType                storage.TopologyType
 	ProcedureExecutingBatchSize uint32
+	HeartbeatIntervalBounds     storage.HeartbeatIntervalBounds
+	Quota                       storage.ClusterQuota
+	SchemaCreationPolicy        storage.SchemaCreationPolicy
+	Protected                   bool
+	// Confirmation is required to unprotect the protected cluster.
+	Confirmation Confirmation
+}
+
+// Confirmation forces the destructive operation on the protected cluster, which is rejected without it.
+type Confirmation struct {
+	Force bool
+	// Token must be the name of the cluster, so the forced operation can't be aimed at another cluster by mistake.
+	Token string
+}
+
+type SearchTablesRequest struct {
+	SchemaName string
+	Prefix     string
+	// Pattern is optional, and all the tables with the prefix are matched if it is nil.
+	Pattern *regexp.Regexp
+	// Limit is the max number of the returned tables, and zero means no limit.
+	Limit int
+}
+
+type SearchTablesResult struct {
+	Tables []storage.Table
+	// Truncated is true if there are more tables matched than the limit.
+	Truncated bool
 }
 
 type CreateTableMetadataRequest struct {
@@ -105,6 +189,14 @@ type DropTableRequest struct {
 	LatestVersion uint64
 }
 
+// DropTablesRequest is used to drop multiple tables on the same shard.
+type DropTablesRequest struct {
+	SchemaName    string
+	TableNames    []string
+	ShardID       storage.ShardID
+	LatestVersion uint64
+}
+
 type DropTableMetadataResult struct {
 	Table storage.Table
 }
@@ -138,6 +230,13 @@ type ShardVersionUpdate struct {
 	LatestVersion uint64
 }
 
+type MoveTablesRequest struct {
+	Tables []storage.Table
+	// Source and Target carry the versions of the shards after the tables are moved.
+	Source ShardVersionUpdate
+	Target ShardVersionUpdate
+}
+
 type RouteEntry struct {
 	Table      TableInfo
 	NodeShards []ShardNodeWithVersion
@@ -145,7 +244,9 @@ type RouteEntry struct {
 
 type RouteTablesResult struct {
 	ClusterViewVersion uint64
-	RouteEntries       map[string]RouteEntry
+	// RouteVersion is taken before the tables are looked up, so the entries are never older than it.
+	RouteVersion string
+	RouteEntries map[string]RouteEntry
 }
 
 type GetNodeShardsResult struct {
@@ -153,24 +254,110 @@ type GetNodeShardsResult struct {
 	NodeShards             []ShardNodeWithVersion
 }
 
+// NodeShortfall describes how many online nodes the cluster lacks to reach the MinNodeCount.
+type NodeShortfall struct {
+	MinNodeCount    uint32 `json:"minNodeCount"`
+	OnlineNodeCount uint32 `json:"onlineNodeCount"`
+	Shortfall       uint32 `json:"shortfall"`
+}
+
+// CacheMemoryStats is the approximate memory footprint of an in-memory structure, which is estimated from the sizes of
+// the entries rather than measured.
+type CacheMemoryStats struct {
+	EntryCount       int    `json:"entryCount"`
+	ApproximateBytes uint64 `json:"approximateBytes"`
+}
+
+type SchemaTablesMemoryStats struct {
+	SchemaName string           `json:"schemaName"`
+	Tables     CacheMemoryStats `json:"tables"`
+}
+
+// SchemaInfo is the schema along with its table count and quota.
+type SchemaInfo struct {
+	ID         storage.SchemaID `json:"id"`
+	Name       string           `json:"name"`
+	CreatedAt  uint64           `json:"createdAt"`
+	TableCount int              `json:"tableCount"`
+	// Settings is nil if the schema has no settings.
+	Settings *storage.SchemaSettings `json:"settings"`
+	// MaxTableCount is the max table count in the settings of the schema, or the max tables per schema of the cluster if
+	// the schema has none, and 0 means unlimited.
+	MaxTableCount uint32 `json:"maxTableCount"`
+}
+
+type TableManagerMemoryStats struct {
+	Schemas      CacheMemoryStats          `json:"schemas"`
+	SchemaTables []SchemaTablesMemoryStats `json:"schemaTables"`
+}
+
+type TopologyMemoryStats struct {
+	ShardViews  CacheMemoryStats `json:"shardViews"`
+	TableShards CacheMemoryStats `json:"tableShards"`
+}
+
+type MemoryStats struct {
+	TableManager     TableManagerMemoryStats `json:"tableManager"`
+	Topology         TopologyMemoryStats     `json:"topology"`
+	ShardTablesIndex CacheMemoryStats        `json:"shardTablesIndex"`
+	RegisteredNodes  CacheMemoryStats        `json:"registeredNodes"`
+	TotalBytes       uint64                  `json:"totalBytes"`
+}
+
 type RegisteredNode struct {
 	Node       storage.Node
 	ShardInfos []ShardInfo
+	// Heartbeat is the timing negotiated with the node, and it is empty for the nodes not registered by the heartbeats.
+	Heartbeat HeartbeatStats
+}
+
+// heartbeatJitterSmoothing is the weight of the previous jitter against the deviation of the latest heartbeat.
+const heartbeatJitterSmoothing = 4
+
+// HeartbeatStats describes the timing of the heartbeats of a node.
+type HeartbeatStats struct {
+	// LeaseMs is how long the node is regarded as online after its last heartbeat, and zero means NodeExpiredThreshold.
+	LeaseMs uint64 `json:"leaseMs"`
+	// IntervalMs is the heartbeat interval expected from the node.
+	IntervalMs uint64 `json:"intervalMs"`
+	// JitterMs is the smoothed deviation of the heartbeat gaps of the node from the expected interval.
+	JitterMs uint64 `json:"jitterMs"`
 }
 
 func NewRegisteredNode(meta storage.Node, shardInfos []ShardInfo) RegisteredNode {
 	return RegisteredNode{
 		meta,
 		shardInfos,
+		HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
+	}
+}
+
+// ExpiredThreshold returns how long the node is regarded as online after its last heartbeat. The jitter of the node is
+// tolerated up to one heartbeat interval, so the nodes with the noisy heartbeats are not expired by mistake.
+func (n RegisteredNode) ExpiredThreshold() time.Duration {
+	if n.Heartbeat.LeaseMs == 0 {
+		return NodeExpiredThreshold
 	}
+	return time.Duration(n.Heartbeat.LeaseMs+min(n.Heartbeat.JitterMs, n.Heartbeat.IntervalMs)) * time.Millisecond
 }
 
 func (n RegisteredNode) IsExpired(now time.Time) bool {
-	expiredTime := time.UnixMilli(int64(n.Node.LastTouchTime)).Add(expiredThreshold)
+	expiredTime := time.UnixMilli(int64(n.Node.LastTouchTime)).Add(n.ExpiredThreshold())
 
 	return now.After(expiredTime)
 }
 
+// updateJitter updates the jitter of the heartbeats by the gap since the previous heartbeat, and the jitter is smoothed
+// in the same way as the round-trip time variation of TCP.
+func (s *HeartbeatStats) updateJitter(prevTouchTime, touchTime uint64) {
+	if s.IntervalMs == 0 || touchTime <= prevTouchTime {
+		return
+	}
+	gapMs := touchTime - prevTouchTime
+	deviationMs := max(gapMs, s.IntervalMs) - min(gapMs, s.IntervalMs)
+	s.JitterMs = (s.JitterMs*(heartbeatJitterSmoothing-1) + deviationMs) / heartbeatJitterSmoothing
+}
+
 func ConvertShardsInfoToPB(shard ShardInfo) *metaservicepb.ShardInfo {
 	status := storage.ConvertShardStatusToPB(shard.Status)
 	return &metaservicepb.ShardInfo{
@@ -210,3 +397,11 @@ func ParseTopologyType(rawString string) (storage.TopologyType, error) {
 
 	return "", errors.WithMessagef(ErrParseTopologyType, "could not be parsed to topologyType, rawString:%s", rawString)
 }
+
+func ParseSchemaCreationPolicy(rawString string) (storage.SchemaCreationPolicy, error) {
+	policy := storage.SchemaCreationPolicy(rawString)
+	if !policy.IsValid() {
+		return "", ErrInvalidSchemaCreationPolicy.WithCausef("could not be parsed to schemaCreationPolicy, rawString:%s", rawString)
+	}
+	return policy, nil
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/event"
+)
+
+const nodeWatchInterval = time.Second
+
+// nodeWatcher publishes the nodes going offline when their heartbeats expire, and the ones coming back online. The nodes
+// seen for the first time are not published, so the nodes reporting to a new leader are not published as online.
+type nodeWatcher struct {
+	metadata  *metadata.ClusterMetadata
+	publisher event.Publisher
+
+	// online is only accessed by the watching loop.
+	online map[string]bool
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newNodeWatcher(metadata *metadata.ClusterMetadata, publisher event.Publisher) *nodeWatcher {
+	return &nodeWatcher{
+		metadata:  metadata,
+		publisher: publisher,
+		online:    map[string]bool{},
+		cancel:    nil,
+		wg:        sync.WaitGroup{},
+	}
+}
+
+func (w *nodeWatcher) Start(_ context.Context) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+
+		ticker := time.NewTicker(nodeWatchInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				w.check()
+			}
+		}
+	}()
+	return nil
+}
+
+func (w *nodeWatcher) Stop(_ context.Context) error {
+	if w.cancel != nil {
+		w.cancel()
+	}
+	w.wg.Wait()
+	return nil
+}
+
+func (w *nodeWatcher) check() {
+	now := w.metadata.Clock().Now()
+	for _, node := range w.metadata.GetRegisteredNodes() {
+		online := !node.IsExpired(now)
+		wasOnline, seen := w.online[node.Node.Name]
+		w.online[node.Node.Name] = online
+		if !seen || online == wasOnline {
+			continue
+		}
+
+		typ := event.TypeNodeOffline
+		if online {
+			typ = event.TypeNodeOnline
+		}
+		w.publisher.Publish(typ, map[string]string{event.AttrNodeName: node.Node.Name})
+	}
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	registrationTokenPrefix    = "registration_token"
+	registrationTokenIDLen     = 8
+	registrationTokenSecretLen = 32
+	// registrationTokenSeparator separates the token id and the secret in the token presented by the nodes.
+	registrationTokenSeparator = "."
+)
+
+// RegistrationToken is a join token of the cluster, and only the hash of the secret is persisted.
+type RegistrationToken struct {
+	ID         string `json:"id"`
+	SecretHash string `json:"secretHash"`
+	CreatedAt  uint64 `json:"createdAt"`
+}
+
+// registrationTokenStore persists the registration tokens in etcd and caches them, so the verification on every
+// heartbeat needs no access to etcd.
+type registrationTokenStore struct {
+	client   *clientv3.Client
+	rootPath string
+	clock    clock.Clock
+
+	lock sync.RWMutex
+	// tokens is keyed by the cluster name and then the token id.
+	tokens map[string]map[string]RegistrationToken
+}
+
+func newRegistrationTokenStore(client *clientv3.Client, rootPath string, clk clock.Clock) *registrationTokenStore {
+	return &registrationTokenStore{
+		client:   client,
+		rootPath: rootPath,
+		clock:    clk,
+		lock:     sync.RWMutex{},
+		tokens:   make(map[string]map[string]RegistrationToken),
+	}
+}
+
+func (s *registrationTokenStore) makeKey(clusterName, tokenID string) string {
+	return path.Join(s.rootPath, registrationTokenPrefix, clusterName, tokenID)
+}
+
+// load reloads all the persisted tokens of the given clusters.
+func (s *registrationTokenStore) load(ctx context.Context, clusterNames []string) error {
+	tokens := make(map[string]map[string]RegistrationToken, len(clusterNames))
+	for _, clusterName := range clusterNames {
+		prefix := s.makeKey(clusterName, "") + "/"
+		resp, err := s.client.Get(ctx, prefix, clientv3.WithPrefix())
+		if err != nil {
+			return errors.WithMessagef(err, "list registration tokens, prefix:%s", prefix)
+		}
+
+		clusterTokens := make(map[string]RegistrationToken, len(resp.Kvs))
+		for _, kv := range resp.Kvs {
+			var token RegistrationToken
+			if err := json.Unmarshal(kv.Value, &token); err != nil {
+				return errors.WithMessagef(err, "decode registration token, key:%s", string(kv.Key))
+			}
+			clusterTokens[token.ID] = token
+		}
+		tokens[clusterName] = clusterTokens
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.tokens = tokens
+	return nil
+}
+
+// create issues a new token of the cluster, and the returned string is the token to be presented by the nodes, which
+// can't be recovered later.
+func (s *registrationTokenStore) create(ctx context.Context, clusterName string) (RegistrationToken, string, error) {
+	tokenID, err := randomHex(registrationTokenIDLen)
+	if err != nil {
+		return RegistrationToken{}, "", err
+	}
+	secret, err := randomHex(registrationTokenSecretLen)
+	if err != nil {
+		return RegistrationToken{}, "", err
+	}
+
+	token := RegistrationToken{
+		ID:         tokenID,
+		SecretHash: hashSecret(secret),
+		CreatedAt:  clock.UnixMilli(s.clock),
+	}
+	value, err := json.Marshal(token)
+	if err != nil {
+		return RegistrationToken{}, "", errors.WithMessage(err, "encode registration token")
+	}
+
+	key := s.makeKey(clusterName, tokenID)
+	if _, err := s.client.Put(ctx, key, string(value)); err != nil {
+		return RegistrationToken{}, "", errors.WithMessagef(err, "put registration token, key:%s", key)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.tokens[clusterName]; !ok {
+		s.tokens[clusterName] = make(map[string]RegistrationToken)
+	}
+	s.tokens[clusterName][tokenID] = token
+	return token, tokenID + registrationTokenSeparator + secret, nil
+}
+
+func (s *registrationTokenStore) revoke(ctx context.Context, clusterName, tokenID string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.tokens[clusterName][tokenID]; !ok {
+		return metadata.ErrRegistrationTokenNotFound.WithCausef("cluster:%s, tokenID:%s", clusterName, tokenID)
+	}
+
+	key := s.makeKey(clusterName, tokenID)
+	if _, err := s.client.Delete(ctx, key); err != nil {
+		return errors.WithMessagef(err, "delete registration token, key:%s", key)
+	}
+	delete(s.tokens[clusterName], tokenID)
+	return nil
+}
+
+// list returns the tokens of the cluster ordered by the creation time.
+func (s *registrationTokenStore) list(clusterName string) []RegistrationToken {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	tokens := make([]RegistrationToken, 0, len(s.tokens[clusterName]))
+	for _, token := range s.tokens[clusterName] {
+		tokens = append(tokens, token)
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt < tokens[j].CreatedAt
+	})
+	return tokens
+}
+
+// verify checks the token presented by a node, and any token is accepted if the cluster has no token issued.
+func (s *registrationTokenStore) verify(clusterName, presentedToken string) error {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	clusterTokens := s.tokens[clusterName]
+	if len(clusterTokens) == 0 {
+		return nil
+	}
+
+	tokenID, secret, found := strings.Cut(presentedToken, registrationTokenSeparator)
+	if !found {
+		return metadata.ErrInvalidRegistrationToken.WithCausef("malformed or missing token, cluster:%s", clusterName)
+	}
+	token, ok := clusterTokens[tokenID]
+	if !ok {
+		return metadata.ErrInvalidRegistrationToken.WithCausef("token not issued, cluster:%s, tokenID:%s", clusterName, tokenID)
+	}
+	if subtle.ConstantTimeCompare([]byte(token.SecretHash), []byte(hashSecret(secret))) != 1 {
+		return metadata.ErrInvalidRegistrationToken.WithCausef("secret mismatch, cluster:%s, tokenID:%s", clusterName, tokenID)
+	}
+	return nil
+}
+
+func hashSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.WithMessage(err, "generate random bytes")
+	}
+	return hex.EncodeToString(b), nil
+}
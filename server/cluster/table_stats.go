@@ -0,0 +1,349 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/hotspot"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	tableStatsPrefix = "table_stats"
+	// tableStatsSnapshotInterval is the interval to persist the aggregates, which are recovered by the next leader.
+	tableStatsSnapshotInterval = time.Minute
+	// tableStatsExpiration is the time the stats are kept without being reported, e.g. the stats of the dropped tables.
+	tableStatsExpiration = time.Hour
+	// writeRateHalfLife is the half life of the reported write rates in the average.
+	writeRateHalfLife = 5 * time.Minute
+)
+
+// ShardStatsReport is the stats of a shard reported by the heartbeat of the node serving it.
+type ShardStatsReport struct {
+	ShardID         storage.ShardID `json:"shardID"`
+	RowCount        uint64          `json:"rowCount"`
+	Bytes           uint64          `json:"bytes"`
+	WriteRowsPerSec float64         `json:"writeRowsPerSec"`
+}
+
+// TableStatsReport is the stats of a table reported by the heartbeat of the node serving it.
+type TableStatsReport struct {
+	TableID         storage.TableID `json:"tableID"`
+	RowCount        uint64          `json:"rowCount"`
+	Bytes           uint64          `json:"bytes"`
+	WriteRowsPerSec float64         `json:"writeRowsPerSec"`
+}
+
+// StatsReport is the optional stats carried by a heartbeat, and either part can be empty.
+type StatsReport struct {
+	Shards []ShardStatsReport `json:"shards"`
+	Tables []TableStatsReport `json:"tables"`
+}
+
+// Stats is the rolling aggregate of the stats reported of a shard or a table.
+type Stats struct {
+	RowCount uint64 `json:"rowCount"`
+	Bytes    uint64 `json:"bytes"`
+	// WriteRowsPerSec is the latest reported write rate, and AvgWriteRowsPerSec is the average decayed by the time, which
+	// is the one to plan with.
+	WriteRowsPerSec     float64 `json:"writeRowsPerSec"`
+	AvgWriteRowsPerSec  float64 `json:"avgWriteRowsPerSec"`
+	PeakWriteRowsPerSec float64 `json:"peakWriteRowsPerSec"`
+	// NodeName is the node reporting the stats last time.
+	NodeName string `json:"nodeName"`
+	// UpdatedAt is the unix timestamp in milliseconds of the latest report.
+	UpdatedAt int64 `json:"updatedAt"`
+}
+
+type ShardStats struct {
+	ShardID storage.ShardID `json:"shardID"`
+	Stats
+}
+
+type TableStats struct {
+	TableID storage.TableID `json:"tableID"`
+	Stats
+}
+
+// ClusterStats is the stats of the shards and the tables, and Total sums up the stats of the shards.
+type ClusterStats struct {
+	Shards []ShardStats `json:"shards"`
+	Tables []TableStats `json:"tables"`
+	Total  Stats        `json:"total"`
+	// SnapshotAt is the unix timestamp in milliseconds of the latest snapshot persisted, and zero if none is persisted.
+	SnapshotAt int64 `json:"snapshotAt"`
+}
+
+// tableStatsSnapshot is the persisted form of the aggregates.
+type tableStatsSnapshot struct {
+	Shards map[storage.ShardID]Stats `json:"shards"`
+	Tables map[storage.TableID]Stats `json:"tables"`
+}
+
+// TableStatsCollector aggregates the stats reported by the heartbeats in memory, and persists them periodically, so the
+// aggregates survive the change of the leader except the reports after the latest snapshot.
+type TableStatsCollector struct {
+	logger *zap.Logger
+	client *clientv3.Client
+	key    string
+	clock  clock.Clock
+
+	// RWMutex is used to protect following fields.
+	lock       sync.RWMutex
+	shards     map[storage.ShardID]Stats
+	tables     map[storage.TableID]Stats
+	snapshotAt int64
+	cancel     context.CancelFunc
+	wg         sync.WaitGroup
+}
+
+func NewTableStatsCollector(logger *zap.Logger, client *clientv3.Client, rootPath string, clusterName string, clk clock.Clock) *TableStatsCollector {
+	return &TableStatsCollector{
+		logger:     logger,
+		client:     client,
+		key:        path.Join(rootPath, tableStatsPrefix, clusterName),
+		clock:      clk,
+		lock:       sync.RWMutex{},
+		shards:     map[storage.ShardID]Stats{},
+		tables:     map[storage.TableID]Stats{},
+		snapshotAt: 0,
+		cancel:     nil,
+		wg:         sync.WaitGroup{},
+	}
+}
+
+// Start recovers the aggregates from the latest snapshot, and persists them periodically.
+func (c *TableStatsCollector) Start(ctx context.Context) error {
+	if err := c.load(ctx); err != nil {
+		// The stats are advisory, so the cluster is served without the history.
+		c.logger.Warn("load table stats snapshot failed", zap.String("key", c.key), zap.Error(err))
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+
+		ticker := time.NewTicker(tableStatsSnapshotInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.snapshot(ctx); err != nil {
+					c.logger.Warn("persist table stats snapshot failed", zap.String("key", c.key), zap.Error(err))
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops the periodic snapshots, and persists the aggregates for the last time.
+func (c *TableStatsCollector) Stop(ctx context.Context) error {
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.wg.Wait()
+	if err := c.snapshot(ctx); err != nil {
+		c.logger.Warn("persist table stats snapshot failed", zap.String("key", c.key), zap.Error(err))
+	}
+	return nil
+}
+
+func (c *TableStatsCollector) load(ctx context.Context) error {
+	resp, err := c.client.Get(ctx, c.key)
+	if err != nil {
+		return errors.WithMessagef(err, "get table stats snapshot, key:%s", c.key)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil
+	}
+	var snapshot tableStatsSnapshot
+	if err := json.Unmarshal(resp.Kvs[0].Value, &snapshot); err != nil {
+		return errors.WithMessagef(err, "decode table stats snapshot, key:%s", c.key)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	// The reports received before the snapshot is loaded are newer.
+	for shardID, stats := range snapshot.Shards {
+		if _, ok := c.shards[shardID]; !ok {
+			c.shards[shardID] = stats
+		}
+	}
+	for tableID, stats := range snapshot.Tables {
+		if _, ok := c.tables[tableID]; !ok {
+			c.tables[tableID] = stats
+		}
+	}
+	return nil
+}
+
+func (c *TableStatsCollector) snapshot(ctx context.Context) error {
+	now := c.clock.Now()
+
+	c.lock.Lock()
+	c.expireWithLock(now)
+	snapshot := tableStatsSnapshot{
+		Shards: make(map[storage.ShardID]Stats, len(c.shards)),
+		Tables: make(map[storage.TableID]Stats, len(c.tables)),
+	}
+	for shardID, stats := range c.shards {
+		snapshot.Shards[shardID] = stats
+	}
+	for tableID, stats := range c.tables {
+		snapshot.Tables[tableID] = stats
+	}
+	c.lock.Unlock()
+
+	value, err := json.Marshal(snapshot)
+	if err != nil {
+		return errors.WithMessage(err, "encode table stats snapshot")
+	}
+	if _, err := c.client.Put(ctx, c.key, string(value)); err != nil {
+		return errors.WithMessagef(err, "put table stats snapshot, key:%s", c.key)
+	}
+
+	c.lock.Lock()
+	c.snapshotAt = now.UnixMilli()
+	c.lock.Unlock()
+	return nil
+}
+
+func (c *TableStatsCollector) expireWithLock(now time.Time) {
+	expiredAt := now.Add(-tableStatsExpiration).UnixMilli()
+	for shardID, stats := range c.shards {
+		if stats.UpdatedAt < expiredAt {
+			delete(c.shards, shardID)
+		}
+	}
+	for tableID, stats := range c.tables {
+		if stats.UpdatedAt < expiredAt {
+			delete(c.tables, tableID)
+		}
+	}
+}
+
+// Report merges the stats reported by the heartbeat of the node into the aggregates.
+func (c *TableStatsCollector) Report(nodeName string, report StatsReport) {
+	now := c.clock.Now()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	for _, shard := range report.Shards {
+		c.shards[shard.ShardID] = aggregateStats(c.shards[shard.ShardID], nodeName, shard.RowCount, shard.Bytes, shard.WriteRowsPerSec, now)
+	}
+	for _, table := range report.Tables {
+		c.tables[table.TableID] = aggregateStats(c.tables[table.TableID], nodeName, table.RowCount, table.Bytes, table.WriteRowsPerSec, now)
+	}
+}
+
+// aggregateStats takes the latest sizes, and decays the previous average and peak of the write rate by the time elapsed.
+func aggregateStats(prev Stats, nodeName string, rowCount, bytes uint64, writeRowsPerSec float64, now time.Time) Stats {
+	avg, peak := writeRowsPerSec, writeRowsPerSec
+	if prev.UpdatedAt > 0 {
+		elapsed := max(now.Sub(time.UnixMilli(prev.UpdatedAt)), 0)
+		decay := math.Exp2(-float64(elapsed) / float64(writeRateHalfLife))
+		avg = prev.AvgWriteRowsPerSec*decay + writeRowsPerSec*(1-decay)
+		peak = max(prev.PeakWriteRowsPerSec*decay, writeRowsPerSec)
+	}
+	return Stats{
+		RowCount:            rowCount,
+		Bytes:               bytes,
+		WriteRowsPerSec:     writeRowsPerSec,
+		AvgWriteRowsPerSec:  avg,
+		PeakWriteRowsPerSec: peak,
+		NodeName:            nodeName,
+		UpdatedAt:           now.UnixMilli(),
+	}
+}
+
+// ShardLoads implements hotspot.LoadProvider.
+func (c *TableStatsCollector) ShardLoads() map[storage.ShardID]hotspot.Load {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	loads := make(map[storage.ShardID]hotspot.Load, len(c.shards))
+	for shardID, stats := range c.shards {
+		loads[shardID] = hotspot.Load{WriteRowsPerSec: stats.AvgWriteRowsPerSec, Bytes: stats.Bytes}
+	}
+	return loads
+}
+
+// TableLoads implements hotspot.LoadProvider.
+func (c *TableStatsCollector) TableLoads() map[storage.TableID]hotspot.Load {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	loads := make(map[storage.TableID]hotspot.Load, len(c.tables))
+	for tableID, stats := range c.tables {
+		loads[tableID] = hotspot.Load{WriteRowsPerSec: stats.AvgWriteRowsPerSec, Bytes: stats.Bytes}
+	}
+	return loads
+}
+
+// Get returns the stats of the shards and the tables ordered by their ids.
+func (c *TableStatsCollector) Get() ClusterStats {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+
+	result := ClusterStats{
+		Shards: make([]ShardStats, 0, len(c.shards)),
+		Tables: make([]TableStats, 0, len(c.tables)),
+		Total: Stats{
+			RowCount:            0,
+			Bytes:               0,
+			WriteRowsPerSec:     0,
+			AvgWriteRowsPerSec:  0,
+			PeakWriteRowsPerSec: 0,
+			NodeName:            "",
+			UpdatedAt:           0,
+		},
+		SnapshotAt: c.snapshotAt,
+	}
+	for shardID, stats := range c.shards {
+		result.Shards = append(result.Shards, ShardStats{ShardID: shardID, Stats: stats})
+		result.Total.RowCount += stats.RowCount
+		result.Total.Bytes += stats.Bytes
+		result.Total.WriteRowsPerSec += stats.WriteRowsPerSec
+		result.Total.AvgWriteRowsPerSec += stats.AvgWriteRowsPerSec
+		result.Total.PeakWriteRowsPerSec += stats.PeakWriteRowsPerSec
+		result.Total.UpdatedAt = max(result.Total.UpdatedAt, stats.UpdatedAt)
+	}
+	for tableID, stats := range c.tables {
+		result.Tables = append(result.Tables, TableStats{TableID: tableID, Stats: stats})
+	}
+	sort.Slice(result.Shards, func(i, j int) bool { return result.Shards[i].ShardID < result.Shards[j].ShardID })
+	sort.Slice(result.Tables, func(i, j int) bool { return result.Tables[i].TableID < result.Tables[j].TableID })
+	return result
+}
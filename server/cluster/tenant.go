@@ -0,0 +1,316 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package cluster
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/etcdutil"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/clientv3util"
+)
+
+const (
+	tenantPrefix      = "tenant"
+	tenantTokenPrefix = "tenant_token"
+	tenantTokenIDLen  = 8
+	// tenantTokenSecretLen is the same as the registration token, and the token is presented in the same format.
+	tenantTokenSecretLen = 32
+)
+
+// Tenant owns a set of clusters or schemas, and the requests carrying its token are restricted to them.
+type Tenant struct {
+	Name string `json:"name"`
+	// Clusters maps the cluster names to the schemas owned by the tenant, and an empty list means the whole cluster.
+	Clusters  map[string][]string `json:"clusters"`
+	CreatedAt uint64              `json:"createdAt"`
+}
+
+// CanAccessCluster returns true if the tenant owns the whole cluster or any schema of it.
+func (t Tenant) CanAccessCluster(clusterName string) bool {
+	_, ok := t.Clusters[clusterName]
+	return ok
+}
+
+// CanAccess returns true if the schema of the cluster is owned by the tenant, and the empty schema name stands for
+// the whole cluster, which is only accessible if the tenant owns all the schemas of it.
+func (t Tenant) CanAccess(clusterName, schemaName string) bool {
+	schemaNames, ok := t.Clusters[clusterName]
+	if !ok {
+		return false
+	}
+	if len(schemaNames) == 0 {
+		return true
+	}
+	if len(schemaName) == 0 {
+		return false
+	}
+	return slices.Contains(schemaNames, schemaName)
+}
+
+// TenantToken is an api token scoped to a tenant, and only the hash of the secret is persisted.
+type TenantToken struct {
+	ID         string `json:"id"`
+	TenantName string `json:"tenantName"`
+	SecretHash string `json:"secretHash"`
+	CreatedAt  uint64 `json:"createdAt"`
+}
+
+// tenantStore persists the tenants and their tokens in etcd. Nothing is cached so that the tokens are able to be
+// authenticated by every node, including the ones not being the leader.
+type tenantStore struct {
+	client   *clientv3.Client
+	rootPath string
+	clock    clock.Clock
+}
+
+func newTenantStore(client *clientv3.Client, rootPath string, clk clock.Clock) *tenantStore {
+	return &tenantStore{
+		client:   client,
+		rootPath: rootPath,
+		clock:    clk,
+	}
+}
+
+func (s *tenantStore) makeTenantKey(tenantName string) string {
+	return path.Join(s.rootPath, tenantPrefix, tenantName)
+}
+
+func (s *tenantStore) makeTokenKey(tokenID string) string {
+	return path.Join(s.rootPath, tenantTokenPrefix, tokenID)
+}
+
+// get returns the tenant, the second output parameter bool: returns true if the tenant exists.
+func (s *tenantStore) get(ctx context.Context, tenantName string) (Tenant, bool, error) {
+	var tenant Tenant
+	key := s.makeTenantKey(tenantName)
+	value, err := etcdutil.Get(ctx, s.client, key)
+	if err == etcdutil.ErrEtcdKVGetNotFound {
+		return tenant, false, nil
+	}
+	if err != nil {
+		return tenant, false, errors.WithMessagef(err, "get tenant, key:%s", key)
+	}
+
+	if err := json.Unmarshal([]byte(value), &tenant); err != nil {
+		return tenant, false, errors.WithMessagef(err, "decode tenant, key:%s", key)
+	}
+	return tenant, true, nil
+}
+
+// list returns all the tenants ordered by the name.
+func (s *tenantStore) list(ctx context.Context) ([]Tenant, error) {
+	prefix := s.makeTenantKey("") + "/"
+	resp, err := s.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, errors.WithMessagef(err, "list tenants, prefix:%s", prefix)
+	}
+
+	tenants := make([]Tenant, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var tenant Tenant
+		if err := json.Unmarshal(kv.Value, &tenant); err != nil {
+			return nil, errors.WithMessagef(err, "decode tenant, key:%s", string(kv.Key))
+		}
+		tenants = append(tenants, tenant)
+	}
+	sort.Slice(tenants, func(i, j int) bool {
+		return tenants[i].Name < tenants[j].Name
+	})
+	return tenants, nil
+}
+
+// put creates the tenant or replaces the resources of the existing one, and the creation time is kept.
+func (s *tenantStore) put(ctx context.Context, tenant Tenant) (Tenant, error) {
+	old, exists, err := s.get(ctx, tenant.Name)
+	if err != nil {
+		return Tenant{}, err
+	}
+	if exists {
+		tenant.CreatedAt = old.CreatedAt
+	} else {
+		tenant.CreatedAt = clock.UnixMilli(s.clock)
+	}
+
+	value, err := json.Marshal(tenant)
+	if err != nil {
+		return Tenant{}, errors.WithMessage(err, "encode tenant")
+	}
+	key := s.makeTenantKey(tenant.Name)
+	if _, err := s.client.Put(ctx, key, string(value)); err != nil {
+		return Tenant{}, errors.WithMessagef(err, "put tenant, key:%s", key)
+	}
+	return tenant, nil
+}
+
+// delete removes the tenant together with all its tokens.
+func (s *tenantStore) delete(ctx context.Context, tenantName string) error {
+	_, exists, err := s.get(ctx, tenantName)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return metadata.ErrTenantNotFound.WithCausef("tenant:%s", tenantName)
+	}
+
+	tokens, err := s.listTokens(ctx, tenantName)
+	if err != nil {
+		return err
+	}
+	ops := make([]clientv3.Op, 0, len(tokens)+1)
+	for _, token := range tokens {
+		ops = append(ops, clientv3.OpDelete(s.makeTokenKey(token.ID)))
+	}
+	ops = append(ops, clientv3.OpDelete(s.makeTenantKey(tenantName)))
+	if _, err := s.client.Txn(ctx).Then(ops...).Commit(); err != nil {
+		return errors.WithMessagef(err, "delete tenant, tenant:%s", tenantName)
+	}
+	return nil
+}
+
+// createToken issues a new token of the tenant, and the returned string is the token to be presented by the clients,
+// which can't be recovered later.
+func (s *tenantStore) createToken(ctx context.Context, tenantName string) (TenantToken, string, error) {
+	tokenID, err := randomHex(tenantTokenIDLen)
+	if err != nil {
+		return TenantToken{}, "", err
+	}
+	secret, err := randomHex(tenantTokenSecretLen)
+	if err != nil {
+		return TenantToken{}, "", err
+	}
+
+	token := TenantToken{
+		ID:         tokenID,
+		TenantName: tenantName,
+		SecretHash: hashSecret(secret),
+		CreatedAt:  clock.UnixMilli(s.clock),
+	}
+	value, err := json.Marshal(token)
+	if err != nil {
+		return TenantToken{}, "", errors.WithMessage(err, "encode tenant token")
+	}
+
+	// The token must not outlive the tenant deleted concurrently.
+	tenantKey := s.makeTenantKey(tenantName)
+	key := s.makeTokenKey(tokenID)
+	resp, err := s.client.Txn(ctx).
+		If(clientv3util.KeyExists(tenantKey)).
+		Then(clientv3.OpPut(key, string(value))).
+		Commit()
+	if err != nil {
+		return TenantToken{}, "", errors.WithMessagef(err, "put tenant token, key:%s", key)
+	}
+	if !resp.Succeeded {
+		return TenantToken{}, "", metadata.ErrTenantNotFound.WithCausef("tenant:%s", tenantName)
+	}
+	return token, tokenID + registrationTokenSeparator + secret, nil
+}
+
+// getToken returns the token, the second output parameter bool: returns true if the token exists.
+func (s *tenantStore) getToken(ctx context.Context, tokenID string) (TenantToken, bool, error) {
+	var token TenantToken
+	key := s.makeTokenKey(tokenID)
+	value, err := etcdutil.Get(ctx, s.client, key)
+	if err == etcdutil.ErrEtcdKVGetNotFound {
+		return token, false, nil
+	}
+	if err != nil {
+		return token, false, errors.WithMessagef(err, "get tenant token, key:%s", key)
+	}
+
+	if err := json.Unmarshal([]byte(value), &token); err != nil {
+		return token, false, errors.WithMessagef(err, "decode tenant token, key:%s", key)
+	}
+	return token, true, nil
+}
+
+func (s *tenantStore) revokeToken(ctx context.Context, tenantName, tokenID string) error {
+	token, exists, err := s.getToken(ctx, tokenID)
+	if err != nil {
+		return err
+	}
+	if !exists || token.TenantName != tenantName {
+		return metadata.ErrTenantTokenNotFound.WithCausef("tenant:%s, tokenID:%s", tenantName, tokenID)
+	}
+
+	key := s.makeTokenKey(tokenID)
+	if _, err := s.client.Delete(ctx, key); err != nil {
+		return errors.WithMessagef(err, "delete tenant token, key:%s", key)
+	}
+	return nil
+}
+
+// listTokens returns the tokens of the tenant ordered by the creation time.
+func (s *tenantStore) listTokens(ctx context.Context, tenantName string) ([]TenantToken, error) {
+	prefix := s.makeTokenKey("") + "/"
+	resp, err := s.client.Get(ctx, prefix, clientv3.WithPrefix())
+	if err != nil {
+		return nil, errors.WithMessagef(err, "list tenant tokens, prefix:%s", prefix)
+	}
+
+	tokens := make([]TenantToken, 0)
+	for _, kv := range resp.Kvs {
+		var token TenantToken
+		if err := json.Unmarshal(kv.Value, &token); err != nil {
+			return nil, errors.WithMessagef(err, "decode tenant token, key:%s", string(kv.Key))
+		}
+		if token.TenantName == tenantName {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreatedAt < tokens[j].CreatedAt
+	})
+	return tokens, nil
+}
+
+// authenticate returns the tenant owning the presented token.
+func (s *tenantStore) authenticate(ctx context.Context, presentedToken string) (Tenant, error) {
+	tokenID, secret, found := strings.Cut(presentedToken, registrationTokenSeparator)
+	if !found {
+		return Tenant{}, metadata.ErrInvalidTenantToken.WithCausef("malformed token")
+	}
+	token, exists, err := s.getToken(ctx, tokenID)
+	if err != nil {
+		return Tenant{}, err
+	}
+	if !exists {
+		return Tenant{}, metadata.ErrInvalidTenantToken.WithCausef("token not issued, tokenID:%s", tokenID)
+	}
+	if subtle.ConstantTimeCompare([]byte(token.SecretHash), []byte(hashSecret(secret))) != 1 {
+		return Tenant{}, metadata.ErrInvalidTenantToken.WithCausef("secret mismatch, tokenID:%s", tokenID)
+	}
+
+	tenant, exists, err := s.get(ctx, token.TenantName)
+	if err != nil {
+		return Tenant{}, err
+	}
+	if !exists {
+		return Tenant{}, metadata.ErrInvalidTenantToken.WithCausef("tenant not found, tenant:%s, tokenID:%s", token.TenantName, tokenID)
+	}
+	return tenant, nil
+}
@@ -20,10 +20,14 @@ import (
 	"flag"
 	"fmt"
 	"math"
+	"net"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/CeresDB/horaemeta/pkg/goruntime"
 	"github.com/CeresDB/horaemeta/pkg/log"
 	"github.com/caarlos0/env/v6"
 	"github.com/pelletier/go-toml/v2"
@@ -33,11 +37,32 @@ import (
 )
 
 const (
+	// DeployModeCluster runs the server as a member of a horaemeta cluster, whose clusters of HoraeDB are set up by the
+	// configs or the admin api.
+	DeployModeCluster = "cluster"
+	// DeployModeStandalone runs the server alone with the embedded etcd for development, and the default cluster is
+	// provisioned with all the shards assigned to the StandaloneNodes statically.
+	DeployModeStandalone = "standalone"
+)
+
+const (
+	defaultDeployMode = DeployModeCluster
+	// defaultStandaloneNode is the name reported by a HoraeDB node started with its default config on the same host.
+	defaultStandaloneNode = "127.0.0.1:8831"
+
 	defaultEnableEmbedEtcd bool = true
 	defaultEtcdCaCertPath       = ""
 	defaultEtcdKeyPath          = ""
 	defaultEtcdCertPath         = ""
 
+	defaultAccessLogSampleRatio           = 1.0
+	defaultAccessLogMaxBodyBytes          = 1024
+	defaultAccessLogSlowThresholdMs int64 = 3 * 1000
+
+	defaultEventCapacity            = 10000
+	defaultEventKafkaTopic          = "horaemeta-events"
+	defaultEventSinkTimeoutMs int64 = 3000
+
 	defaultEnableLimiter          bool  = true
 	defaultInitialLimiterCapacity int   = 100 * 1000
 	defaultInitialLimiterRate     int   = 10 * 1000
@@ -53,6 +78,7 @@ const (
 	defaultGrpcServiceMaxRecvMsgSize int = 100 * 1024 * 1024
 	// GrpcServiceKeepAlivePingMinIntervalSec controls the min interval for one keepalive ping.
 	defaultGrpcServiceKeepAlivePingMinIntervalSec int = 20
+	defaultGrpcSlowRequestThresholdMs             int = 1000
 
 	defaultNodeNamePrefix          = "horaemeta"
 	defaultEndpoint                = "127.0.0.1"
@@ -70,21 +96,76 @@ const (
 
 	defaultMaxRequestBytes uint = 2 * 1024 * 1024 // 2MB
 
-	defaultMaxScanLimit    int  = 100
-	defaultMinScanLimit    int  = 20
-	defaultMaxOpsPerTxn    int  = 32
-	defaultIDAllocatorStep uint = 20
+	defaultMaxScanLimit int = 100
+	defaultMinScanLimit int = 20
+	defaultMaxOpsPerTxn int = 32
+	// defaultStorageSlowOpThresholdMs is far beyond the latency of the etcd round trips in a healthy cluster.
+	defaultStorageSlowOpThresholdMs int64 = 500
+	defaultIDAllocatorStep          uint  = 20
+	// The steps of the resources fall back to the defaultIDAllocatorStep unless they are configured, except the procedure
+	// ids which are allocated much more frequently.
+	defaultSchemaIDAllocatorStep    uint = 0
+	defaultTableIDAllocatorStep     uint = 0
+	defaultProcedureIDAllocatorStep uint = 50
+	defaultEnableIDPreallocation         = false
 
 	DefaultClusterName       = "defaultCluster"
 	defaultClusterNodeCount  = 2
 	defaultClusterShardTotal = 8
-	enableSchedule           = true
+	// Keep the names case-sensitive by default to be compatible with the existing clusters.
+	defaultClusterCaseInsensitiveName = false
+	// No default schema is created unless it is configured.
+	defaultClusterSchemaName = ""
+	// No heartbeat interval is advised to the nodes unless the bounds are configured.
+	defaultClusterHeartbeatIntervalMinMs uint64 = 0
+	defaultClusterHeartbeatIntervalMaxMs uint64 = 0
+	// Create the unknown schemas on demand by default to be compatible with the existing clients.
+	defaultClusterSchemaCreationPolicy = "auto"
+	// Protect the default cluster from the destructive operations unless they are forced.
+	defaultClusterProtected = true
+	enableSchedule          = true
 	// topologyType is used to determine the scheduling cluster strategy of HoraeMeta. It should be determined according to the storage method of HoraeDB. The default is static to support local storage.
 	defaultTopologyType                = "static"
 	defaultProcedureExecutingBatchSize = math.MaxUint32
+	defaultStaticTopologyFile          = ""
+
+	defaultIdempotencyKeyTTLSec int64 = 24 * 60 * 60
+
+	defaultEnableDebugKV = false
+
+	defaultEnableFailpoints = false
+
+	defaultProcedureGCIntervalSec int64 = 10 * 60
+	defaultProcedureRetentionSec  int64 = 7 * 24 * 60 * 60
+
+	// The etcd maintenance is disabled by default, and the embedded etcd relies on its auto compaction.
+	defaultEtcdCompactionIntervalSec       int64 = 0
+	defaultEtcdCompactionRetainedRevisions int64 = 10000
+	defaultEtcdDefragWindow                      = ""
+
+	defaultEtcdAutoSyncIntervalSec    int64 = 60
+	defaultEtcdHealthCheckIntervalMs  int64 = 5000
+	defaultEtcdDialBackoffBaseDelayMs int64 = 1000
+	defaultEtcdDialBackoffMaxDelayMs  int64 = 10000
+
+	defaultHeartbeatRateBudget uint32 = 1000
+	// The nodes asking for no lease get metadata.NodeExpiredThreshold, which is kept in the bounds.
+	defaultNodeLeaseMinMs uint64 = 5000
+	defaultNodeLeaseMaxMs uint64 = 60000
+
+	// No admission webhook is called unless it is configured.
+	defaultAdmissionWebhook                = ""
+	defaultAdmissionWebhookTimeoutMs int64 = 3000
+	defaultAdmissionWebhookFailOpen        = false
 
 	defaultHTTPPort = 8080
-	defaultGrpcPort = 2379
+	// The handling of most http requests is bounded by the default timeout, and the long one bounds the requests doing the
+	// slow work, e.g. inspecting all the keys or asking all the nodes.
+	defaultHTTPHandleTimeoutMs     int64 = 10 * 1000
+	defaultHTTPLongHandleTimeoutMs int64 = 60 * 1000
+	defaultGrpcPort                      = 2379
+	// The in-flight requests are waited for the grace period before the server is stopped.
+	defaultShutdownGracePeriodMs int64 = 10 * 1000
 
 	defaultDataDir = "/tmp/horaemeta"
 
@@ -101,6 +182,57 @@ type LimiterConfig struct {
 	Limit int `toml:"limit" env:"FLOW_LIMITER_LIMIT"`
 	// Burst is the maximum number of tokens.
 	Burst int `toml:"burst" env:"FLOW_LIMITER_BURST"`
+	// MethodWeights is the number of tokens taken by a grpc request of the method, and the methods not listed are not limited.
+	MethodWeights map[string]int `toml:"method-weights"`
+}
+
+// defaultLimiterMethodWeights limits the grpc requests which may burst from the data nodes. The heartbeats are not limited
+// to avoid the nodes being regarded as offline.
+func defaultLimiterMethodWeights() map[string]int {
+	return map[string]int{
+		"AllocSchemaID":     1,
+		"CreateTable":       1,
+		"DropTable":         1,
+		"RouteTables":       1,
+		"RouteTablesByID":   1,
+		"GetNodes":          1,
+		"GetTablesOfShards": 5,
+	}
+}
+
+// AccessLogConfig controls the access log of the http requests.
+type AccessLogConfig struct {
+	// SampleRatio is the ratio of the succeeded requests logged, in [0, 1]. The failed and the slow requests are always
+	// logged.
+	SampleRatio float64 `toml:"sample-ratio" env:"ACCESS_LOG_SAMPLE_RATIO"`
+	// MaxBodyBytes caps the request body in the log, and 0 logs no body.
+	MaxBodyBytes int `toml:"max-body-bytes" env:"ACCESS_LOG_MAX_BODY_BYTES"`
+	// SlowThresholdMs is the latency above which the request is always logged, and 0 disables it.
+	SlowThresholdMs int64 `toml:"slow-threshold-ms" env:"ACCESS_LOG_SLOW_THRESHOLD_MS"`
+	// RedactedFields are the json fields of the body and the query parameters whose values are masked in the log, besides
+	// the tokens and the passwords which are always masked.
+	RedactedFields []string `toml:"redacted-fields" env:"ACCESS_LOG_REDACTED_FIELDS"`
+}
+
+func (c AccessLogConfig) SlowThreshold() time.Duration {
+	return time.Duration(c.SlowThresholdMs) * time.Millisecond
+}
+
+// EventConfig controls the publishing of the changes of the clusters.
+type EventConfig struct {
+	// Capacity is the number of the latest events of every cluster kept for polling.
+	Capacity int `toml:"capacity" env:"EVENT_CAPACITY"`
+	// Webhooks are posted the events in json arrays.
+	Webhooks []string `toml:"webhooks" env:"EVENT_WEBHOOKS"`
+	// KafkaRESTProxy is the url of the REST proxy of kafka producing the events to the KafkaTopic, and empty disables it.
+	KafkaRESTProxy string `toml:"kafka-rest-proxy" env:"EVENT_KAFKA_REST_PROXY"`
+	KafkaTopic     string `toml:"kafka-topic" env:"EVENT_KAFKA_TOPIC"`
+	// SinkTimeoutMs bounds every sending of the events to the webhooks and kafka.
+	SinkTimeoutMs int64 `toml:"sink-timeout-ms" env:"EVENT_SINK_TIMEOUT_MS"`
+}
+
+func (c EventConfig) SinkTimeout() time.Duration {
+	return time.Duration(c.SinkTimeoutMs) * time.Millisecond
 }
 
 // Config is server start config, it has three input modes:
@@ -109,9 +241,18 @@ type LimiterConfig struct {
 // Their loading has priority, and low priority configurations will be overwritten by high priority configurations.
 // The priority from high to low is: env variables > toml config file.
 type Config struct {
-	Log         log.Config    `toml:"log" env:"LOG"`
-	EtcdLog     log.Config    `toml:"etcd-log" env:"ETCD_LOG"`
-	FlowLimiter LimiterConfig `toml:"flow-limiter" env:"FLOW_LIMITER"`
+	Log         log.Config       `toml:"log" env:"LOG"`
+	EtcdLog     log.Config       `toml:"etcd-log" env:"ETCD_LOG"`
+	FlowLimiter LimiterConfig    `toml:"flow-limiter" env:"FLOW_LIMITER"`
+	AccessLog   AccessLogConfig  `toml:"access-log" env:"ACCESS_LOG"`
+	Event       EventConfig      `toml:"event" env:"EVENT"`
+	Runtime     goruntime.Config `toml:"runtime" env:"RUNTIME"`
+
+	// DeployMode is either cluster or standalone, and the standalone mode overrides the configs of the embedded etcd, the
+	// topology type and the default cluster, see ValidateAndAdjust.
+	DeployMode string `toml:"deploy-mode" env:"DEPLOY_MODE"`
+	// StandaloneNodes are the names of the HoraeDB nodes serving the default cluster in the standalone mode.
+	StandaloneNodes []string `toml:"standalone-nodes" env:"STANDALONE_NODES"`
 
 	EnableEmbedEtcd bool   `toml:"enable-embed-etcd" env:"ENABLE_EMBED_ETCD"`
 	EtcdCaCertPath  string `toml:"etcd-ca-cert-path" env:"ETCD_CA_CERT_PATH"`
@@ -122,10 +263,30 @@ type Config struct {
 	EtcdCallTimeoutMs  int64 `toml:"etcd-call-timeout-ms" env:"ETCD_CALL_TIMEOUT_MS"`
 	EtcdMaxTxnOps      int64 `toml:"etcd-max-txn-ops" env:"ETCD_MAX_TXN_OPS"`
 
+	// EtcdCompactionIntervalSec is the interval the leader compacts the revisions of etcd, and 0 disables the maintenance
+	// of etcd, including the defragmentation.
+	EtcdCompactionIntervalSec int64 `toml:"etcd-compaction-interval-sec" env:"ETCD_COMPACTION_INTERVAL_SEC"`
+	// EtcdCompactionRetainedRevisions is the number of the latest revisions kept by the compaction.
+	EtcdCompactionRetainedRevisions int64 `toml:"etcd-compaction-retained-revisions" env:"ETCD_COMPACTION_RETAINED_REVISIONS"`
+	// EtcdDefragWindow is the daily low-traffic window in UTC like "02:00-04:00", in which the leader defragments the
+	// members of etcd once after the compaction. Empty disables the defragmentation.
+	EtcdDefragWindow string `toml:"etcd-defrag-window" env:"ETCD_DEFRAG_WINDOW"`
+
+	// EtcdAutoSyncIntervalSec is the interval to refresh the etcd endpoints with the members of etcd, and 0 disables it.
+	EtcdAutoSyncIntervalSec int64 `toml:"etcd-auto-sync-interval-sec" env:"ETCD_AUTO_SYNC_INTERVAL_SEC"`
+	// EtcdHealthCheckIntervalMs is the interval to check the etcd endpoint in use, which is rotated to the next healthy
+	// one once it is unhealthy. 0 disables it, and the requests are balanced among all the endpoints.
+	EtcdHealthCheckIntervalMs int64 `toml:"etcd-health-check-interval-ms" env:"ETCD_HEALTH_CHECK_INTERVAL_MS"`
+	// EtcdDialBackoffBaseDelayMs and EtcdDialBackoffMaxDelayMs bound the exponential backoff of redialing etcd.
+	EtcdDialBackoffBaseDelayMs int64 `toml:"etcd-dial-backoff-base-delay-ms" env:"ETCD_DIAL_BACKOFF_BASE_DELAY_MS"`
+	EtcdDialBackoffMaxDelayMs  int64 `toml:"etcd-dial-backoff-max-delay-ms" env:"ETCD_DIAL_BACKOFF_MAX_DELAY_MS"`
+
 	GrpcHandleTimeoutMs                    int `toml:"grpc-handle-timeout-ms" env:"GRPC_HANDLER_TIMEOUT_MS"`
 	GrpcServiceMaxSendMsgSize              int `toml:"grpc-service-max-send-msg-size" env:"GRPC_SERVICE_MAX_SEND_MSG_SIZE"`
 	GrpcServiceMaxRecvMsgSize              int `toml:"grpc-service-max-recv-msg-size" env:"GRPC_SERVICE_MAX_RECV_MSG_SIZE"`
 	GrpcServiceKeepAlivePingMinIntervalSec int `toml:"grpc-service-keep-alive-ping-min-interval-sec" env:"GRPC_SERVICE_KEEP_ALIVE_PING_MIN_INTERVAL_SEC"`
+	// GrpcSlowRequestThresholdMs is the latency above which the grpc request is logged as a slow one, and zero disables it.
+	GrpcSlowRequestThresholdMs int `toml:"grpc-slow-request-threshold-ms" env:"GRPC_SLOW_REQUEST_THRESHOLD_MS"`
 
 	LeaseTTLSec int64 `toml:"lease-sec" env:"LEASE_SEC"`
 
@@ -155,12 +316,49 @@ type Config struct {
 	MaxScanLimit            int    `toml:"max-scan-limit" env:"MAX_SCAN_LIMIT"`
 	MinScanLimit            int    `toml:"min-scan-limit" env:"MIN_SCAN_LIMIT"`
 	MaxOpsPerTxn            int    `toml:"max-ops-per-txn" env:"MAX_OPS_PER_TXN"`
-	IDAllocatorStep         uint   `toml:"id-allocator-step" env:"ID_ALLOCATOR_STEP"`
+	// StorageSlowOpThresholdMs is the latency above which the operation of the metadata storage is logged with the keys it
+	// accesses, and zero disables it.
+	StorageSlowOpThresholdMs int64 `toml:"storage-slow-op-threshold-ms" env:"STORAGE_SLOW_OP_THRESHOLD_MS"`
+	IDAllocatorStep          uint  `toml:"id-allocator-step" env:"ID_ALLOCATOR_STEP"`
+	// SchemaIDAllocatorStep, TableIDAllocatorStep and ProcedureIDAllocatorStep override the IDAllocatorStep for the
+	// resources if they are not 0. The shard ids are reused in memory instead of being reserved from etcd, so there is
+	// no step for them.
+	SchemaIDAllocatorStep    uint `toml:"schema-id-allocator-step" env:"SCHEMA_ID_ALLOCATOR_STEP"`
+	TableIDAllocatorStep     uint `toml:"table-id-allocator-step" env:"TABLE_ID_ALLOCATOR_STEP"`
+	ProcedureIDAllocatorStep uint `toml:"procedure-id-allocator-step" env:"PROCEDURE_ID_ALLOCATOR_STEP"`
+	// EnableIDPreallocation makes the id allocators reserve the next range of ids in background before the current one
+	// is exhausted, which cuts the etcd round-trips on the allocation path during bursts of table creation.
+	EnableIDPreallocation bool `toml:"enable-id-preallocation" env:"ENABLE_ID_PREALLOCATION"`
 
 	// Following fields are the settings for the default cluster.
 	DefaultClusterName       string `toml:"default-cluster-name" env:"DEFAULT_CLUSTER_NAME"`
 	DefaultClusterNodeCount  int    `toml:"default-cluster-node-count" env:"DEFAULT_CLUSTER_NODE_COUNT"`
 	DefaultClusterShardTotal int    `toml:"default-cluster-shard-total" env:"DEFAULT_CLUSTER_SHARD_TOTAL"`
+	// DefaultClusterCaseInsensitiveName makes the default cluster resolve the schema and table names case-insensitively.
+	// It only takes effect when the default cluster is created.
+	DefaultClusterCaseInsensitiveName bool `toml:"default-cluster-case-insensitive-name" env:"DEFAULT_CLUSTER_CASE_INSENSITIVE_NAME"`
+	// DefaultClusterSchemaName is the schema created along with the default cluster, so the clients needn't create it.
+	DefaultClusterSchemaName string `toml:"default-cluster-schema-name" env:"DEFAULT_CLUSTER_SCHEMA_NAME"`
+	// DefaultClusterHeartbeatIntervalMinMs and DefaultClusterHeartbeatIntervalMaxMs bound the heartbeat interval advised
+	// to the nodes of the default cluster, and no interval is advised if the max one is zero.
+	DefaultClusterHeartbeatIntervalMinMs uint64 `toml:"default-cluster-heartbeat-interval-min-ms" env:"DEFAULT_CLUSTER_HEARTBEAT_INTERVAL_MIN_MS"`
+	DefaultClusterHeartbeatIntervalMaxMs uint64 `toml:"default-cluster-heartbeat-interval-max-ms" env:"DEFAULT_CLUSTER_HEARTBEAT_INTERVAL_MAX_MS"`
+	// DefaultClusterSchemaCreationPolicy is auto or reject, and the default cluster rejects the unknown schemas instead of
+	// creating them if it is reject, so the schemas must be provisioned in advance.
+	DefaultClusterSchemaCreationPolicy string `toml:"default-cluster-schema-creation-policy" env:"DEFAULT_CLUSTER_SCHEMA_CREATION_POLICY"`
+	// DefaultClusterProtected protects the default cluster from the destructive operations, e.g. shrinking the shard total,
+	// unless they are forced with the confirmation token. The existing default cluster is protected on startup as well, so
+	// it must be unset to keep the default cluster unprotected.
+	DefaultClusterProtected bool `toml:"default-cluster-protected" env:"DEFAULT_CLUSTER_PROTECTED"`
+
+	// TenantTokenRequired rejects the requests without the tenant token, so no client gets around the tenant isolation
+	// by leaving the token out. The http health check and metrics are still allowed, while the http routes no tenant can
+	// access, e.g. the management of the tenants, are only available with the TenantAdminToken then, and the nodes must
+	// present the token in their table requests.
+	TenantTokenRequired bool `toml:"tenant-token-required" env:"TENANT_TOKEN_REQUIRED"`
+	// TenantAdminToken is presented in the place of the tenant token to access all the http routes, including the
+	// management of the tenants and the creation of the clusters, and it is disabled if empty.
+	TenantAdminToken string `toml:"tenant-admin-token" env:"TENANT_ADMIN_TOKEN"`
 
 	// When the EnableSchedule is turned on, the failover scheduling will be turned on, which is used for HoraeDB cluster publishing and using local storage.
 	EnableSchedule bool `toml:"enable-schedule" env:"ENABLE_SCHEDULE"`
@@ -168,6 +366,9 @@ type Config struct {
 	TopologyType string `toml:"topology-type" env:"TOPOLOGY_TYPE"`
 	// ProcedureExecutingBatchSize determines the maximum number of shards in a single batch when opening shards concurrently.
 	ProcedureExecutingBatchSize uint32 `toml:"procedure-executing-batch-size" env:"PROCEDURE_EXECUTING_BATCH_SIZE"`
+	// StaticTopologyFile is the path of the toml file declaring a cluster of the static topology, which is created with
+	// the declared shard assignment if it does not exist. Empty means the default cluster is created instead.
+	StaticTopologyFile string `toml:"static-topology-file" env:"STATIC_TOPOLOGY_FILE"`
 
 	ClientUrls          string `toml:"client-urls" env:"CLIENT_URLS"`
 	PeerUrls            string `toml:"peer-urls" env:"PEER_URLS"`
@@ -176,12 +377,77 @@ type Config struct {
 
 	HTTPPort int `toml:"http-port" env:"HTTP_PORT"`
 	GrpcPort int `toml:"grpc-port" env:"GRPC_PORT"`
+	// AdvertiseHTTPURL and AdvertiseGrpcURL are the urls of the http and grpc services reachable by the other servers and
+	// the nodes, e.g. the address of the NAT or the service of Kubernetes, while the services listen on the ports of all
+	// the interfaces. See HTTPAdvertiseURL and GrpcAdvertiseURL for the defaults.
+	AdvertiseHTTPURL string `toml:"advertise-http-url" env:"ADVERTISE_HTTP_URL"`
+	AdvertiseGrpcURL string `toml:"advertise-grpc-url" env:"ADVERTISE_GRPC_URL"`
+	// HTTPHandleTimeoutMs and HTTPLongHandleTimeoutMs bound the handling of the http requests, and 0 means no timeout.
+	HTTPHandleTimeoutMs     int64 `toml:"http-handle-timeout-ms" env:"HTTP_HANDLE_TIMEOUT_MS"`
+	HTTPLongHandleTimeoutMs int64 `toml:"http-long-handle-timeout-ms" env:"HTTP_LONG_HANDLE_TIMEOUT_MS"`
+	// ShutdownGracePeriodMs is how long the in-flight http and grpc requests are waited to finish when the server is
+	// stopped, and the new requests are rejected meanwhile. The requests still running after it are cut off.
+	ShutdownGracePeriodMs int64 `toml:"shutdown-grace-period-ms" env:"SHUTDOWN_GRACE_PERIOD_MS"`
+
+	// IdempotencyKeyTTLSec is how long the result of a request with the Idempotency-Key header is kept for replay.
+	IdempotencyKeyTTLSec int64 `toml:"idempotency-key-ttl-sec" env:"IDEMPOTENCY_KEY_TTL_SEC"`
+
+	// EnableDebugKV enables the debug api listing the raw keys and the decoded values under the root path.
+	EnableDebugKV bool `toml:"enable-debug-kv" env:"ENABLE_DEBUG_KV"`
+	// EnableFailpoints allows the failures to be injected to the procedures and the event dispatch by the debug api, which
+	// is only for the tests and the game days.
+	EnableFailpoints bool `toml:"enable-failpoints" env:"ENABLE_FAILPOINTS"`
+
+	// ProcedureGCIntervalSec is the interval the leader deletes the procedures done or marked deleted beyond the
+	// ProcedureRetentionSec, and 0 disables the gc.
+	ProcedureGCIntervalSec int64 `toml:"procedure-gc-interval-sec" env:"PROCEDURE_GC_INTERVAL_SEC"`
+	ProcedureRetentionSec  int64 `toml:"procedure-retention-sec" env:"PROCEDURE_RETENTION_SEC"`
+
+	// HeartbeatRateBudget is the number of heartbeats per second the leader expects to handle, and the heartbeat interval
+	// advised to the nodes grows with the nodes served by the leader to stay under it.
+	HeartbeatRateBudget uint32 `toml:"heartbeat-rate-budget" env:"HEARTBEAT_RATE_BUDGET"`
+	// NodeLeaseMinMs and NodeLeaseMaxMs bound the lease negotiated with the nodes, which is asked by the nodes in their
+	// heartbeats. A node is regarded as offline if no heartbeat is received within its lease, and the max one of zero
+	// means no upper bound.
+	NodeLeaseMinMs uint64 `toml:"node-lease-min-ms" env:"NODE_LEASE_MIN_MS"`
+	NodeLeaseMaxMs uint64 `toml:"node-lease-max-ms" env:"NODE_LEASE_MAX_MS"`
+
+	// AdmissionWebhook is the url asked before creating or dropping a table, which may deny the request or mutate the
+	// options of the table to create. Empty means all the requests are admitted.
+	AdmissionWebhook string `toml:"admission-webhook" env:"ADMISSION_WEBHOOK"`
+	// AdmissionWebhooks are asked in order after the AdmissionWebhook, e.g. one enforces the naming conventions and another
+	// checks the quotas. The request is rejected once any of them denies it, and the options mutated by one are seen by
+	// the next one.
+	AdmissionWebhooks         []string `toml:"admission-webhooks" env:"ADMISSION_WEBHOOKS"`
+	AdmissionWebhookTimeoutMs int64    `toml:"admission-webhook-timeout-ms" env:"ADMISSION_WEBHOOK_TIMEOUT_MS"`
+	// AdmissionWebhookFailOpen admits the requests when the webhook is unavailable, otherwise they are rejected.
+	AdmissionWebhookFailOpen bool `toml:"admission-webhook-fail-open" env:"ADMISSION_WEBHOOK_FAIL_OPEN"`
 }
 
 func (c *Config) GrpcHandleTimeout() time.Duration {
 	return time.Duration(c.GrpcHandleTimeoutMs) * time.Millisecond
 }
 
+func (c *Config) HTTPHandleTimeout() time.Duration {
+	return time.Duration(c.HTTPHandleTimeoutMs) * time.Millisecond
+}
+
+func (c *Config) HTTPLongHandleTimeout() time.Duration {
+	return time.Duration(c.HTTPLongHandleTimeoutMs) * time.Millisecond
+}
+
+func (c *Config) ShutdownGracePeriod() time.Duration {
+	return time.Duration(c.ShutdownGracePeriodMs) * time.Millisecond
+}
+
+func (c *Config) GrpcSlowRequestThreshold() time.Duration {
+	return time.Duration(c.GrpcSlowRequestThresholdMs) * time.Millisecond
+}
+
+func (c *Config) StorageSlowOpThreshold() time.Duration {
+	return time.Duration(c.StorageSlowOpThresholdMs) * time.Millisecond
+}
+
 func (c *Config) EtcdStartTimeout() time.Duration {
 	return time.Duration(c.EtcdStartTimeoutMs) * time.Millisecond
 }
@@ -190,12 +456,160 @@ func (c *Config) EtcdCallTimeout() time.Duration {
 	return time.Duration(c.EtcdCallTimeoutMs) * time.Millisecond
 }
 
+func (c *Config) EtcdCompactionInterval() time.Duration {
+	return time.Duration(c.EtcdCompactionIntervalSec) * time.Second
+}
+
+func (c *Config) EtcdAutoSyncInterval() time.Duration {
+	return time.Duration(c.EtcdAutoSyncIntervalSec) * time.Second
+}
+
+func (c *Config) EtcdHealthCheckInterval() time.Duration {
+	return time.Duration(c.EtcdHealthCheckIntervalMs) * time.Millisecond
+}
+
+func (c *Config) EtcdDialBackoffBaseDelay() time.Duration {
+	return time.Duration(c.EtcdDialBackoffBaseDelayMs) * time.Millisecond
+}
+
+func (c *Config) EtcdDialBackoffMaxDelay() time.Duration {
+	return time.Duration(c.EtcdDialBackoffMaxDelayMs) * time.Millisecond
+}
+
+func (c *Config) ProcedureGCInterval() time.Duration {
+	return time.Duration(c.ProcedureGCIntervalSec) * time.Second
+}
+
+func (c *Config) ProcedureRetention() time.Duration {
+	return time.Duration(c.ProcedureRetentionSec) * time.Second
+}
+
+func (c *Config) AdmissionWebhookTimeout() time.Duration {
+	return time.Duration(c.AdmissionWebhookTimeoutMs) * time.Millisecond
+}
+
+// AdmissionWebhookURLs returns all the admission webhooks in the order they are asked.
+func (c *Config) AdmissionWebhookURLs() []string {
+	urls := make([]string, 0, len(c.AdmissionWebhooks)+1)
+	if len(c.AdmissionWebhook) > 0 {
+		urls = append(urls, c.AdmissionWebhook)
+	}
+	for _, url := range c.AdmissionWebhooks {
+		if len(url) > 0 {
+			urls = append(urls, url)
+		}
+	}
+	return urls
+}
+
 // ValidateAndAdjust validates the config fields and adjusts some fields which should be adjusted.
 // Return error if any field is invalid.
 func (c *Config) ValidateAndAdjust() error {
+	for _, advertiseURL := range []string{c.AdvertiseHTTPURL, c.AdvertiseGrpcURL} {
+		if err := validateAdvertiseURL(advertiseURL); err != nil {
+			return err
+		}
+	}
+	// The results of the idempotent requests are kept by the etcd lease, whose ttl must be positive.
+	if c.IdempotencyKeyTTLSec <= 0 {
+		return ErrInvalidIdempotencyTTL.WithCausef("idempotencyKeyTTLSec:%d", c.IdempotencyKeyTTLSec)
+	}
+
+	switch c.DeployMode {
+	case DeployModeCluster:
+		return nil
+	case DeployModeStandalone:
+		return c.adjustForStandalone()
+	default:
+		return ErrInvalidDeployMode.WithCausef("deployMode:%s", c.DeployMode)
+	}
+}
+
+// validateAdvertiseURL makes sure the advertised url has the scheme and the host, and the empty url is valid for the
+// default one.
+func validateAdvertiseURL(advertiseURL string) error {
+	if len(advertiseURL) == 0 {
+		return nil
+	}
+	u, err := url.Parse(advertiseURL)
+	if err != nil {
+		return ErrInvalidAdvertiseURL.WithCausef("url:%s, err:%v", advertiseURL, err)
+	}
+	if len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return ErrInvalidAdvertiseURL.WithCausef("url:%s, scheme or host is missing", advertiseURL)
+	}
 	return nil
 }
 
+// GrpcAdvertiseURL returns the url of the grpc service advertised as the endpoint of the server, which is
+// AdvertiseGrpcURL if it is set, otherwise the first advertised client url of the embedded etcd serving the grpc service,
+// or the addr of the server with the grpc port if etcd is not embedded.
+func (c *Config) GrpcAdvertiseURL() (string, error) {
+	if len(c.AdvertiseGrpcURL) > 0 {
+		return c.AdvertiseGrpcURL, nil
+	}
+	if c.EnableEmbedEtcd {
+		urls, err := parseUrls(c.AdvertiseClientUrls)
+		if err != nil {
+			return "", err
+		}
+		return urls[0].String(), nil
+	}
+	return fmt.Sprintf("http://%s:%d", c.Addr, c.GrpcPort), nil
+}
+
+// HTTPAdvertiseURL returns the url of the http service advertised to the other servers, which is AdvertiseHTTPURL if it
+// is set, otherwise the host of the advertised grpc url with the http port.
+func (c *Config) HTTPAdvertiseURL() (string, error) {
+	if len(c.AdvertiseHTTPURL) > 0 {
+		return c.AdvertiseHTTPURL, nil
+	}
+	grpcURL, err := c.GrpcAdvertiseURL()
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(grpcURL)
+	if err != nil {
+		return "", ErrInvalidAdvertiseURL.WithCausef("url:%s, err:%v", grpcURL, err)
+	}
+	return fmt.Sprintf("http://%s", net.JoinHostPort(u.Hostname(), strconv.Itoa(c.HTTPPort))), nil
+}
+
+// adjustForStandalone makes the server a single member of the embedded etcd, and the default cluster of the static
+// topology is served by the StandaloneNodes.
+func (c *Config) adjustForStandalone() error {
+	if len(c.StandaloneNodes) == 0 {
+		return ErrInvalidDeployMode.WithCausef("no standalone nodes are configured")
+	}
+
+	c.EnableEmbedEtcd = true
+	c.InitialCluster = fmt.Sprintf("%s=%s", c.NodeName, c.AdvertisePeerUrls)
+	c.InitialClusterState = embed.ClusterStateFlagNew
+	c.TopologyType = defaultTopologyType
+	c.DefaultClusterNodeCount = len(c.StandaloneNodes)
+	return c.StandaloneTopology().Validate()
+}
+
+// StandaloneTopology assigns the shards of the default cluster to the StandaloneNodes evenly.
+func (c *Config) StandaloneTopology() StaticTopology {
+	nodes := make([]StaticTopologyNode, 0, len(c.StandaloneNodes))
+	for _, name := range c.StandaloneNodes {
+		nodes = append(nodes, StaticTopologyNode{Name: name, ShardIDs: []uint32{}})
+	}
+	if len(nodes) > 0 {
+		for shardID := 0; shardID < c.DefaultClusterShardTotal; shardID++ {
+			node := &nodes[shardID%len(nodes)]
+			node.ShardIDs = append(node.ShardIDs, uint32(shardID))
+		}
+	}
+
+	return StaticTopology{
+		ClusterName: c.DefaultClusterName,
+		ShardTotal:  uint32(c.DefaultClusterShardTotal),
+		Nodes:       nodes,
+	}
+}
+
 func (c *Config) GenEtcdConfig() (*embed.Config, error) {
 	cfg := embed.NewConfig()
 
@@ -277,14 +691,14 @@ func makeDefaultInitialCluster(nodeName string) string {
 	return fmt.Sprintf("%s=%s", nodeName, defaultPeerUrls)
 }
 
-func MakeConfigParser() (*Parser, error) {
+func makeDefaultConfig() (*Config, error) {
 	defaultNodeName, err := makeDefaultNodeName()
 	if err != nil {
 		return nil, err
 	}
 	defaultInitialCluster := makeDefaultInitialCluster(defaultNodeName)
 
-	fs, cfg := flag.NewFlagSet("meta", flag.ContinueOnError), &Config{
+	return &Config{
 		Log: log.Config{
 			Level: log.DefaultLogLevel,
 			File:  log.DefaultLogFile,
@@ -294,10 +708,33 @@ func MakeConfigParser() (*Parser, error) {
 			File:  log.DefaultLogFile,
 		},
 		FlowLimiter: LimiterConfig{
-			Enable: defaultEnableLimiter,
-			Limit:  defaultInitialLimiterRate,
-			Burst:  defaultInitialLimiterCapacity,
+			Enable:        defaultEnableLimiter,
+			Limit:         defaultInitialLimiterRate,
+			Burst:         defaultInitialLimiterCapacity,
+			MethodWeights: defaultLimiterMethodWeights(),
+		},
+		AccessLog: AccessLogConfig{
+			SampleRatio:     defaultAccessLogSampleRatio,
+			MaxBodyBytes:    defaultAccessLogMaxBodyBytes,
+			SlowThresholdMs: defaultAccessLogSlowThresholdMs,
+			RedactedFields:  []string{},
+		},
+		Event: EventConfig{
+			Capacity:       defaultEventCapacity,
+			Webhooks:       []string{},
+			KafkaRESTProxy: "",
+			KafkaTopic:     defaultEventKafkaTopic,
+			SinkTimeoutMs:  defaultEventSinkTimeoutMs,
 		},
+		Runtime: goruntime.Config{
+			AutoMaxProcs:     goruntime.DefaultAutoMaxProcs,
+			GCPercent:        goruntime.DefaultGCPercent,
+			MemoryLimitBytes: goruntime.DefaultMemoryLimitBytes,
+			MemoryLimitRatio: goruntime.DefaultMemoryLimitRatio,
+		},
+
+		DeployMode:      defaultDeployMode,
+		StandaloneNodes: []string{defaultStandaloneNode},
 
 		EnableEmbedEtcd: defaultEnableEmbedEtcd,
 		EtcdCaCertPath:  defaultEtcdCaCertPath,
@@ -308,10 +745,20 @@ func MakeConfigParser() (*Parser, error) {
 		EtcdCallTimeoutMs:  defaultCallTimeoutMs,
 		EtcdMaxTxnOps:      defaultEtcdMaxTxnOps,
 
+		EtcdCompactionIntervalSec:       defaultEtcdCompactionIntervalSec,
+		EtcdCompactionRetainedRevisions: defaultEtcdCompactionRetainedRevisions,
+		EtcdDefragWindow:                defaultEtcdDefragWindow,
+
+		EtcdAutoSyncIntervalSec:    defaultEtcdAutoSyncIntervalSec,
+		EtcdHealthCheckIntervalMs:  defaultEtcdHealthCheckIntervalMs,
+		EtcdDialBackoffBaseDelayMs: defaultEtcdDialBackoffBaseDelayMs,
+		EtcdDialBackoffMaxDelayMs:  defaultEtcdDialBackoffMaxDelayMs,
+
 		GrpcHandleTimeoutMs:                    defaultGrpcHandleTimeoutMs,
 		GrpcServiceMaxSendMsgSize:              defaultGrpcServiceMaxSendMsgSize,
 		GrpcServiceMaxRecvMsgSize:              defaultGrpcServiceMaxRecvMsgSize,
 		GrpcServiceKeepAlivePingMinIntervalSec: defaultGrpcServiceKeepAlivePingMinIntervalSec,
+		GrpcSlowRequestThresholdMs:             defaultGrpcSlowRequestThresholdMs,
 
 		LeaseTTLSec: defaultEtcdLeaseTTLSec,
 
@@ -332,26 +779,69 @@ func MakeConfigParser() (*Parser, error) {
 		TickIntervalMs:    defaultTickIntervalMs,
 		ElectionTimeoutMs: defaultElectionTimeoutMs,
 
-		QuotaBackendBytes:       defaultQuotaBackendBytes,
-		AutoCompactionMode:      defaultCompactionMode,
-		AutoCompactionRetention: defaultAutoCompactionRetention,
-		MaxRequestBytes:         defaultMaxRequestBytes,
-		MaxScanLimit:            defaultMaxScanLimit,
-		MinScanLimit:            defaultMinScanLimit,
-		MaxOpsPerTxn:            defaultMaxOpsPerTxn,
-		IDAllocatorStep:         defaultIDAllocatorStep,
-
-		DefaultClusterName:          DefaultClusterName,
-		DefaultClusterNodeCount:     defaultClusterNodeCount,
-		DefaultClusterShardTotal:    defaultClusterShardTotal,
-		EnableSchedule:              enableSchedule,
-		TopologyType:                defaultTopologyType,
-		ProcedureExecutingBatchSize: defaultProcedureExecutingBatchSize,
-
-		HTTPPort: defaultHTTPPort,
-		GrpcPort: defaultGrpcPort,
+		QuotaBackendBytes:        defaultQuotaBackendBytes,
+		AutoCompactionMode:       defaultCompactionMode,
+		AutoCompactionRetention:  defaultAutoCompactionRetention,
+		MaxRequestBytes:          defaultMaxRequestBytes,
+		MaxScanLimit:             defaultMaxScanLimit,
+		MinScanLimit:             defaultMinScanLimit,
+		MaxOpsPerTxn:             defaultMaxOpsPerTxn,
+		StorageSlowOpThresholdMs: defaultStorageSlowOpThresholdMs,
+		IDAllocatorStep:          defaultIDAllocatorStep,
+
+		SchemaIDAllocatorStep:    defaultSchemaIDAllocatorStep,
+		TableIDAllocatorStep:     defaultTableIDAllocatorStep,
+		ProcedureIDAllocatorStep: defaultProcedureIDAllocatorStep,
+		EnableIDPreallocation:    defaultEnableIDPreallocation,
+
+		DefaultClusterName:                   DefaultClusterName,
+		DefaultClusterNodeCount:              defaultClusterNodeCount,
+		DefaultClusterShardTotal:             defaultClusterShardTotal,
+		DefaultClusterCaseInsensitiveName:    defaultClusterCaseInsensitiveName,
+		DefaultClusterSchemaName:             defaultClusterSchemaName,
+		DefaultClusterHeartbeatIntervalMinMs: defaultClusterHeartbeatIntervalMinMs,
+		DefaultClusterHeartbeatIntervalMaxMs: defaultClusterHeartbeatIntervalMaxMs,
+		DefaultClusterSchemaCreationPolicy:   defaultClusterSchemaCreationPolicy,
+		DefaultClusterProtected:              defaultClusterProtected,
+		TenantTokenRequired:                  false,
+		TenantAdminToken:                     "",
+		EnableSchedule:                       enableSchedule,
+		TopologyType:                         defaultTopologyType,
+		ProcedureExecutingBatchSize:          defaultProcedureExecutingBatchSize,
+		StaticTopologyFile:                   defaultStaticTopologyFile,
+
+		HTTPPort:                defaultHTTPPort,
+		GrpcPort:                defaultGrpcPort,
+		AdvertiseHTTPURL:        "",
+		AdvertiseGrpcURL:        "",
+		HTTPHandleTimeoutMs:     defaultHTTPHandleTimeoutMs,
+		HTTPLongHandleTimeoutMs: defaultHTTPLongHandleTimeoutMs,
+		ShutdownGracePeriodMs:   defaultShutdownGracePeriodMs,
+
+		IdempotencyKeyTTLSec: defaultIdempotencyKeyTTLSec,
+		EnableDebugKV:        defaultEnableDebugKV,
+		EnableFailpoints:     defaultEnableFailpoints,
+		HeartbeatRateBudget:  defaultHeartbeatRateBudget,
+		NodeLeaseMinMs:       defaultNodeLeaseMinMs,
+		NodeLeaseMaxMs:       defaultNodeLeaseMaxMs,
+
+		ProcedureGCIntervalSec: defaultProcedureGCIntervalSec,
+		ProcedureRetentionSec:  defaultProcedureRetentionSec,
+
+		AdmissionWebhook:          defaultAdmissionWebhook,
+		AdmissionWebhooks:         []string{},
+		AdmissionWebhookTimeoutMs: defaultAdmissionWebhookTimeoutMs,
+		AdmissionWebhookFailOpen:  defaultAdmissionWebhookFailOpen,
+	}, nil
+}
+
+func MakeConfigParser() (*Parser, error) {
+	cfg, err := makeDefaultConfig()
+	if err != nil {
+		return nil, err
 	}
 
+	fs := flag.NewFlagSet("meta", flag.ContinueOnError)
 	version := fs.Bool("version", false, "print version information")
 
 	builder := &Parser{
@@ -0,0 +1,84 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"testing"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdvertiseURL(t *testing.T) {
+	re := require.New(t)
+
+	cfg, err := makeDefaultConfig()
+	re.NoError(err)
+	cfg.Addr = "10.0.0.1"
+	cfg.GrpcPort = 2379
+	cfg.HTTPPort = 8080
+
+	// The defaults are derived from the addr or the advertised client url of the embedded etcd.
+	cfg.EnableEmbedEtcd = false
+	grpcURL, err := cfg.GrpcAdvertiseURL()
+	re.NoError(err)
+	re.Equal("http://10.0.0.1:2379", grpcURL)
+	httpURL, err := cfg.HTTPAdvertiseURL()
+	re.NoError(err)
+	re.Equal("http://10.0.0.1:8080", httpURL)
+
+	cfg.EnableEmbedEtcd = true
+	cfg.AdvertiseClientUrls = "http://meta-0.meta:2379,http://10.0.0.1:2379"
+	grpcURL, err = cfg.GrpcAdvertiseURL()
+	re.NoError(err)
+	re.Equal("http://meta-0.meta:2379", grpcURL)
+	httpURL, err = cfg.HTTPAdvertiseURL()
+	re.NoError(err)
+	re.Equal("http://meta-0.meta:8080", httpURL)
+
+	// The advertised urls are used as they are once set.
+	cfg.AdvertiseGrpcURL = "http://203.0.113.1:32379"
+	cfg.AdvertiseHTTPURL = "http://203.0.113.1:38080"
+	re.NoError(cfg.ValidateAndAdjust())
+	grpcURL, err = cfg.GrpcAdvertiseURL()
+	re.NoError(err)
+	re.Equal("http://203.0.113.1:32379", grpcURL)
+	httpURL, err = cfg.HTTPAdvertiseURL()
+	re.NoError(err)
+	re.Equal("http://203.0.113.1:38080", httpURL)
+
+	for _, invalid := range []string{"203.0.113.1:38080", "http://", "://"} {
+		cfg.AdvertiseHTTPURL = invalid
+		re.True(coderr.Is(cfg.ValidateAndAdjust(), ErrInvalidAdvertiseURL.Code()), "url:%s", invalid)
+	}
+}
+
+func TestIdempotencyKeyTTL(t *testing.T) {
+	re := require.New(t)
+
+	cfg, err := makeDefaultConfig()
+	re.NoError(err)
+	re.NoError(cfg.ValidateAndAdjust())
+
+	for _, invalid := range []int64{0, -1} {
+		cfg.IdempotencyKeyTTLSec = invalid
+		err := cfg.ValidateAndAdjust()
+		re.Error(err)
+		re.True(coderr.Is(err, ErrInvalidIdempotencyTTL.Code()), "ttl:%d", invalid)
+		re.Contains(err.Error(), "invalid idempotency key ttl")
+	}
+}
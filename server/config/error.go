@@ -21,8 +21,12 @@ import (
 )
 
 var (
-	ErrHelpRequested      = coderr.NewCodeError(coderr.PrintHelpUsage, "help requested")
-	ErrInvalidPeerURL     = coderr.NewCodeError(coderr.InvalidParams, "invalid peers url")
-	ErrInvalidCommandArgs = coderr.NewCodeError(coderr.InvalidParams, "invalid command arguments")
-	ErrRetrieveHostname   = coderr.NewCodeError(coderr.Internal, "retrieve local hostname")
+	ErrHelpRequested         = coderr.NewCodeError(coderr.PrintHelpUsage, "help requested")
+	ErrInvalidPeerURL        = coderr.NewCodeError(coderr.InvalidParams, "invalid peers url")
+	ErrInvalidCommandArgs    = coderr.NewCodeError(coderr.InvalidParams, "invalid command arguments")
+	ErrRetrieveHostname      = coderr.NewCodeError(coderr.Internal, "retrieve local hostname")
+	ErrInvalidStaticTopology = coderr.NewCodeError(coderr.InvalidParams, "invalid static topology")
+	ErrInvalidDeployMode     = coderr.NewCodeError(coderr.InvalidParams, "invalid deploy mode")
+	ErrInvalidAdvertiseURL   = coderr.NewCodeError(coderr.InvalidParams, "invalid advertise url")
+	ErrInvalidIdempotencyTTL = coderr.NewCodeError(coderr.InvalidParams, "invalid idempotency key ttl")
 )
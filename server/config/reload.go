@@ -0,0 +1,145 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/caarlos0/env/v6"
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pkg/errors"
+)
+
+// The keys of the settings which can be applied to the running server when the config is reloaded, and the key ending
+// with the dot covers all the settings under it. The other settings only take effect after restarting the server.
+const (
+	LogLevelKey          = "log.level"
+	FlowLimiterKeyPrefix = "flow-limiter."
+	EtcdCallTimeoutKey   = "etcd-call-timeout-ms"
+)
+
+var reloadableKeys = []string{
+	LogLevelKey,
+	FlowLimiterKeyPrefix,
+	EtcdCallTimeoutKey,
+}
+
+// Change is a setting whose value differs in the reloaded config, and the Key is the path of the setting in the toml
+// file like `log.level`.
+type Change struct {
+	Key      string `json:"key"`
+	OldValue string `json:"oldValue"`
+	NewValue string `json:"newValue"`
+	// Reason tells why the change is rejected.
+	Reason string `json:"reason"`
+}
+
+// ReloadResult tells the changes applied to the running server and the ones rejected.
+type ReloadResult struct {
+	Applied  []Change `json:"applied"`
+	Rejected []Change `json:"rejected"`
+}
+
+// IsReloadable tells whether the setting of the key can be applied without restarting the server.
+func IsReloadable(key string) bool {
+	for _, reloadableKey := range reloadableKeys {
+		if strings.HasSuffix(reloadableKey, ".") {
+			if strings.HasPrefix(key, reloadableKey) {
+				return true
+			}
+			continue
+		}
+		if key == reloadableKey {
+			return true
+		}
+	}
+	return false
+}
+
+// Diff returns the changes of the settings from oldCfg to newCfg ordered by the keys.
+func Diff(oldCfg, newCfg *Config) []Change {
+	changes := make([]Change, 0)
+	diffStruct("", reflect.ValueOf(*oldCfg), reflect.ValueOf(*newCfg), &changes)
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Key < changes[j].Key
+	})
+	return changes
+}
+
+func diffStruct(prefix string, oldValue, newValue reflect.Value, changes *[]Change) {
+	typ := oldValue.Type()
+	for i := 0; i < typ.NumField(); i++ {
+		field := typ.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("toml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = field.Name
+		}
+		key := prefix + name
+
+		oldField, newField := oldValue.Field(i), newValue.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			diffStruct(key+".", oldField, newField, changes)
+			continue
+		}
+		if reflect.DeepEqual(oldField.Interface(), newField.Interface()) {
+			continue
+		}
+		*changes = append(*changes, Change{
+			Key:      key,
+			OldValue: fmt.Sprintf("%v", oldField.Interface()),
+			NewValue: fmt.Sprintf("%v", newField.Interface()),
+			Reason:   "",
+		})
+	}
+}
+
+// Reload builds the config again from the defaults, the config file and the env variables in the same way as the
+// server starts, so that the modifications of the config file are picked up.
+func (p *Parser) Reload() (*Config, error) {
+	cfg, err := makeDefaultConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(p.configFilePath) > 0 {
+		file, err := os.ReadFile(p.configFilePath)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "read config file, configFile:%s", p.configFilePath)
+		}
+		if err := toml.Unmarshal(file, cfg); err != nil {
+			return nil, errors.WithMessagef(err, "unmarshal toml config, configFile:%s", p.configFilePath)
+		}
+	}
+
+	if err := env.Parse(cfg); err != nil {
+		return nil, errors.WithMessage(err, "parse config from env variables")
+	}
+	if err := cfg.ValidateAndAdjust(); err != nil {
+		return nil, errors.WithMessage(err, "validate reloaded config")
+	}
+	return cfg, nil
+}
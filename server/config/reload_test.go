@@ -0,0 +1,72 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testReloadedConfig = `
+etcd-call-timeout-ms = 3000
+http-port = 9000
+
+[log]
+level = "debug"
+
+[flow-limiter]
+limit = 100
+`
+
+func TestReload(t *testing.T) {
+	re := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "config.toml")
+	parser, err := MakeConfigParser()
+	re.NoError(err)
+	cfg, err := parser.Parse([]string{"-config", path})
+	re.NoError(err)
+
+	re.NoError(os.WriteFile(path, []byte(testReloadedConfig), 0o600))
+	reloaded, err := parser.Reload()
+	re.NoError(err)
+
+	changes := Diff(cfg, reloaded)
+	keys := make([]string, 0, len(changes))
+	for _, change := range changes {
+		keys = append(keys, change.Key)
+	}
+	re.Equal([]string{"etcd-call-timeout-ms", "flow-limiter.limit", "http-port", "log.level"}, keys)
+	re.Equal("info", changes[3].OldValue)
+	re.Equal("debug", changes[3].NewValue)
+
+	re.Empty(Diff(reloaded, reloaded))
+}
+
+func TestIsReloadable(t *testing.T) {
+	re := require.New(t)
+
+	re.True(IsReloadable("log.level"))
+	re.True(IsReloadable("flow-limiter.method-weights"))
+	re.True(IsReloadable("etcd-call-timeout-ms"))
+	re.False(IsReloadable("log.File"))
+	re.False(IsReloadable("etcd-call-timeout-ms-extra"))
+	re.False(IsReloadable("http-port"))
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"os"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/pkg/errors"
+)
+
+// StaticTopology declares a cluster of the static topology and the nodes serving its shards, and it is provisioned when
+// the cluster does not exist yet, so that the bootstrap of the cluster needs no manual calls.
+type StaticTopology struct {
+	ClusterName string               `toml:"cluster-name"`
+	ShardTotal  uint32               `toml:"shard-total"`
+	Nodes       []StaticTopologyNode `toml:"nodes"`
+}
+
+type StaticTopologyNode struct {
+	// Name is the name of the node reported by its heartbeat.
+	Name     string   `toml:"name"`
+	ShardIDs []uint32 `toml:"shard-ids"`
+}
+
+// LoadStaticTopology reads the topology from the toml file and validates it.
+func LoadStaticTopology(path string) (StaticTopology, error) {
+	var topology StaticTopology
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return topology, errors.WithMessagef(err, "read static topology file, path:%s", path)
+	}
+	if err := toml.Unmarshal(content, &topology); err != nil {
+		return topology, errors.WithMessagef(err, "unmarshal static topology file, path:%s", path)
+	}
+	if err := topology.Validate(); err != nil {
+		return topology, errors.WithMessagef(err, "validate static topology file, path:%s", path)
+	}
+	return topology, nil
+}
+
+// Validate requires every shard in [0, ShardTotal) to be assigned to exactly one node.
+func (t StaticTopology) Validate() error {
+	if len(t.ClusterName) == 0 {
+		return ErrInvalidStaticTopology.WithCausef("cluster name is empty")
+	}
+	if t.ShardTotal == 0 {
+		return ErrInvalidStaticTopology.WithCausef("shard total should be positive")
+	}
+	if len(t.Nodes) == 0 {
+		return ErrInvalidStaticTopology.WithCausef("no nodes are declared")
+	}
+
+	nodeNames := make(map[string]struct{}, len(t.Nodes))
+	shardNodes := make(map[uint32]string, t.ShardTotal)
+	for _, node := range t.Nodes {
+		if len(node.Name) == 0 {
+			return ErrInvalidStaticTopology.WithCausef("node name is empty")
+		}
+		if _, exists := nodeNames[node.Name]; exists {
+			return ErrInvalidStaticTopology.WithCausef("duplicate node, name:%s", node.Name)
+		}
+		nodeNames[node.Name] = struct{}{}
+
+		for _, shardID := range node.ShardIDs {
+			if shardID >= t.ShardTotal {
+				return ErrInvalidStaticTopology.WithCausef("shard id out of range, shardID:%d, shardTotal:%d", shardID, t.ShardTotal)
+			}
+			if assigned, exists := shardNodes[shardID]; exists {
+				return ErrInvalidStaticTopology.WithCausef("shard assigned to multiple nodes, shardID:%d, nodes:%s,%s", shardID, assigned, node.Name)
+			}
+			shardNodes[shardID] = node.Name
+		}
+	}
+	if uint32(len(shardNodes)) != t.ShardTotal {
+		return ErrInvalidStaticTopology.WithCausef("not all shards are assigned, assigned:%d, shardTotal:%d", len(shardNodes), t.ShardTotal)
+	}
+	return nil
+}
@@ -0,0 +1,110 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/stretchr/testify/require"
+)
+
+const testStaticTopology = `
+cluster-name = "staticCluster"
+shard-total = 4
+
+[[nodes]]
+name = "127.0.0.1:8831"
+shard-ids = [0, 2]
+
+[[nodes]]
+name = "127.0.0.1:8832"
+shard-ids = [1, 3]
+`
+
+func TestLoadStaticTopology(t *testing.T) {
+	re := require.New(t)
+
+	path := filepath.Join(t.TempDir(), "topology.toml")
+	re.NoError(os.WriteFile(path, []byte(testStaticTopology), 0o600))
+
+	topology, err := LoadStaticTopology(path)
+	re.NoError(err)
+	re.Equal(StaticTopology{
+		ClusterName: "staticCluster",
+		ShardTotal:  4,
+		Nodes: []StaticTopologyNode{
+			{Name: "127.0.0.1:8831", ShardIDs: []uint32{0, 2}},
+			{Name: "127.0.0.1:8832", ShardIDs: []uint32{1, 3}},
+		},
+	}, topology)
+}
+
+func TestValidateStaticTopology(t *testing.T) {
+	re := require.New(t)
+
+	newTopology := func(nodes ...StaticTopologyNode) StaticTopology {
+		return StaticTopology{ClusterName: "staticCluster", ShardTotal: 2, Nodes: nodes}
+	}
+
+	re.NoError(newTopology(StaticTopologyNode{Name: "node0", ShardIDs: []uint32{0, 1}}).Validate())
+	// Shard out of range.
+	err := newTopology(StaticTopologyNode{Name: "node0", ShardIDs: []uint32{0, 2}}).Validate()
+	re.True(coderr.Is(err, ErrInvalidStaticTopology.Code()))
+	// Shard assigned twice.
+	err = newTopology(StaticTopologyNode{Name: "node0", ShardIDs: []uint32{0, 1}}, StaticTopologyNode{Name: "node1", ShardIDs: []uint32{1}}).Validate()
+	re.True(coderr.Is(err, ErrInvalidStaticTopology.Code()))
+	// Shard not assigned.
+	err = newTopology(StaticTopologyNode{Name: "node0", ShardIDs: []uint32{0}}).Validate()
+	re.True(coderr.Is(err, ErrInvalidStaticTopology.Code()))
+	// Duplicate node.
+	err = newTopology(StaticTopologyNode{Name: "node0", ShardIDs: []uint32{0}}, StaticTopologyNode{Name: "node0", ShardIDs: []uint32{1}}).Validate()
+	re.True(coderr.Is(err, ErrInvalidStaticTopology.Code()))
+}
+
+func TestStandaloneDeployMode(t *testing.T) {
+	re := require.New(t)
+
+	cfg, err := makeDefaultConfig()
+	re.NoError(err)
+	cfg.DeployMode = DeployModeStandalone
+	cfg.EnableEmbedEtcd = false
+	cfg.TopologyType = "dynamic"
+	cfg.StandaloneNodes = []string{"127.0.0.1:8831", "127.0.0.1:8832"}
+	cfg.DefaultClusterShardTotal = 3
+	re.NoError(cfg.ValidateAndAdjust())
+
+	re.True(cfg.EnableEmbedEtcd)
+	re.Equal(defaultTopologyType, cfg.TopologyType)
+	re.Equal(cfg.NodeName+"="+cfg.AdvertisePeerUrls, cfg.InitialCluster)
+	re.Equal(2, cfg.DefaultClusterNodeCount)
+	re.Equal(StaticTopology{
+		ClusterName: DefaultClusterName,
+		ShardTotal:  3,
+		Nodes: []StaticTopologyNode{
+			{Name: "127.0.0.1:8831", ShardIDs: []uint32{0, 2}},
+			{Name: "127.0.0.1:8832", ShardIDs: []uint32{1}},
+		},
+	}, cfg.StandaloneTopology())
+
+	cfg.StandaloneNodes = nil
+	re.True(coderr.Is(cfg.ValidateAndAdjust(), ErrInvalidDeployMode.Code()))
+	cfg.DeployMode = "unknown"
+	re.True(coderr.Is(cfg.ValidateAndAdjust(), ErrInvalidDeployMode.Code()))
+}
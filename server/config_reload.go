@@ -0,0 +1,101 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package server
+
+import (
+	"strings"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/config"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const staticSettingReason = "the setting only takes effect after restarting"
+
+// SetConfigLoader sets how the config is loaded again when it is reloaded, and the config can't be reloaded without it.
+func (srv *Server) SetConfigLoader(loader func() (*config.Config, error)) {
+	srv.configLock.Lock()
+	defer srv.configLock.Unlock()
+
+	srv.configLoader = loader
+}
+
+// ReloadConfig loads the config again and applies the changed settings which are reloadable to the running server, and
+// the changes of the other settings are rejected.
+func (srv *Server) ReloadConfig() (config.ReloadResult, error) {
+	srv.configLock.Lock()
+	defer srv.configLock.Unlock()
+
+	result := config.ReloadResult{
+		Applied:  []config.Change{},
+		Rejected: []config.Change{},
+	}
+	if srv.configLoader == nil {
+		return result, ErrConfigLoaderNotSet
+	}
+	newCfg, err := srv.configLoader()
+	if err != nil {
+		return result, errors.WithMessage(err, "load config")
+	}
+
+	for _, change := range config.Diff(srv.cfg, newCfg) {
+		if !config.IsReloadable(change.Key) {
+			change.Reason = staticSettingReason
+			result.Rejected = append(result.Rejected, change)
+			continue
+		}
+		if err := srv.applyConfigChange(change.Key, newCfg); err != nil {
+			change.Reason = err.Error()
+			result.Rejected = append(result.Rejected, change)
+			continue
+		}
+		result.Applied = append(result.Applied, change)
+	}
+
+	log.Info("reload config", zap.Any("applied", result.Applied), zap.Any("rejected", result.Rejected))
+	return result, nil
+}
+
+// applyConfigChange applies the reloadable setting of the key in newCfg, and the config of the server is updated
+// only if the setting is applied.
+func (srv *Server) applyConfigChange(key string, newCfg *config.Config) error {
+	switch {
+	case key == config.LogLevelKey:
+		if err := log.SetLevel(newCfg.Log.Level); err != nil {
+			return ErrInvalidReloadConfig.WithCausef("log level:%s, err:%v", newCfg.Log.Level, err)
+		}
+		srv.cfg.Log.Level = newCfg.Log.Level
+	case strings.HasPrefix(key, config.FlowLimiterKeyPrefix):
+		if srv.flowLimiter == nil {
+			return ErrFlowLimiterNotFound
+		}
+		if err := srv.flowLimiter.UpdateLimiter(newCfg.FlowLimiter); err != nil {
+			return err
+		}
+		srv.cfg.FlowLimiter = newCfg.FlowLimiter
+	case key == config.EtcdCallTimeoutKey:
+		if newCfg.EtcdCallTimeoutMs <= 0 {
+			return ErrInvalidReloadConfig.WithCausef("etcd call timeout must be positive, timeoutMs:%d", newCfg.EtcdCallTimeoutMs)
+		}
+		if srv.member != nil {
+			srv.member.SetRPCTimeout(newCfg.EtcdCallTimeout())
+		}
+		srv.cfg.EtcdCallTimeoutMs = newCfg.EtcdCallTimeoutMs
+	}
+	return nil
+}
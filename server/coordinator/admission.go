@@ -0,0 +1,226 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// maxWebhookMessageBytes bounds the message read from the failed webhook response.
+const maxWebhookMessageBytes = 4096
+
+type AdmissionOperation string
+
+const (
+	AdmissionOperationCreateTable AdmissionOperation = "createTable"
+	AdmissionOperationDropTable   AdmissionOperation = "dropTable"
+)
+
+type AdmissionDecision string
+
+const (
+	AdmissionDecisionAllow AdmissionDecision = "allow"
+	AdmissionDecisionDeny  AdmissionDecision = "deny"
+	// AdmissionDecisionMutate admits the request with the options replaced, and it is the same as allow for the drop table.
+	AdmissionDecisionMutate AdmissionDecision = "mutate"
+)
+
+// AdmissionRequest describes the table creation or drop waiting for the admission.
+type AdmissionRequest struct {
+	ClusterName string             `json:"clusterName"`
+	Operation   AdmissionOperation `json:"operation"`
+	SchemaName  string             `json:"schemaName"`
+	TableName   string             `json:"tableName"`
+	// Options are the options of the table to create, and it is empty for the drop table.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+type AdmissionResponse struct {
+	Decision AdmissionDecision `json:"decision"`
+	Reason   string            `json:"reason,omitempty"`
+	// Options replace the options of the table to create if the decision is mutate.
+	Options map[string]string `json:"options,omitempty"`
+}
+
+// AdmissionHook decides whether the table creation or drop is executed before the procedure is built.
+type AdmissionHook interface {
+	Admit(ctx context.Context, request AdmissionRequest) (AdmissionResponse, error)
+}
+
+type noopAdmissionHook struct{}
+
+// NewNoopAdmissionHook returns the hook admitting all the requests.
+func NewNoopAdmissionHook() AdmissionHook {
+	return noopAdmissionHook{}
+}
+
+func (noopAdmissionHook) Admit(_ context.Context, _ AdmissionRequest) (AdmissionResponse, error) {
+	return AdmissionResponse{
+		Decision: AdmissionDecisionAllow,
+		Reason:   "",
+		Options:  nil,
+	}, nil
+}
+
+// chainAdmissionHook asks the hooks in order, and the request is denied once any of them denies it.
+type chainAdmissionHook struct {
+	hooks []AdmissionHook
+}
+
+// NewChainAdmissionHook returns the hook asking all the hooks in order, and the options mutated by a hook are passed to
+// the next one.
+func NewChainAdmissionHook(hooks ...AdmissionHook) AdmissionHook {
+	if len(hooks) == 1 {
+		return hooks[0]
+	}
+	return &chainAdmissionHook{hooks: hooks}
+}
+
+func (h *chainAdmissionHook) Admit(ctx context.Context, request AdmissionRequest) (AdmissionResponse, error) {
+	result := AdmissionResponse{
+		Decision: AdmissionDecisionAllow,
+		Reason:   "",
+		Options:  nil,
+	}
+	for _, hook := range h.hooks {
+		resp, err := hook.Admit(ctx, request)
+		if err != nil {
+			return AdmissionResponse{}, err
+		}
+
+		switch resp.Decision {
+		case AdmissionDecisionDeny:
+			return resp, nil
+		case AdmissionDecisionMutate:
+			request.Options = resp.Options
+			result = resp
+		case AdmissionDecisionAllow:
+		}
+	}
+	return result, nil
+}
+
+// webhookAdmissionHook posts the request to the external endpoint and honors the decision in its response.
+type webhookAdmissionHook struct {
+	url        string
+	httpClient *http.Client
+	// failOpen admits the request if the webhook fails, otherwise the request is rejected.
+	failOpen bool
+}
+
+func NewWebhookAdmissionHook(url string, timeout time.Duration, failOpen bool) AdmissionHook {
+	return &webhookAdmissionHook{
+		url:        url,
+		httpClient: &http.Client{Timeout: timeout},
+		failOpen:   failOpen,
+	}
+}
+
+func (h *webhookAdmissionHook) Admit(ctx context.Context, request AdmissionRequest) (AdmissionResponse, error) {
+	resp, err := h.post(ctx, request)
+	if err == nil {
+		return resp, nil
+	}
+
+	if !h.failOpen {
+		return AdmissionResponse{}, ErrAdmissionWebhook.WithCausef("webhook:%s, err:%v", h.url, err)
+	}
+	log.Warn("admission webhook failed, admit the request", zap.String("webhook", h.url), zap.String("operation", string(request.Operation)), zap.String("tableName", request.TableName), zap.Error(err))
+	return AdmissionResponse{
+		Decision: AdmissionDecisionAllow,
+		Reason:   "",
+		Options:  nil,
+	}, nil
+}
+
+func (h *webhookAdmissionHook) post(ctx context.Context, request AdmissionRequest) (AdmissionResponse, error) {
+	var admissionResp AdmissionResponse
+
+	body, err := json.Marshal(request)
+	if err != nil {
+		return admissionResp, errors.WithMessage(err, "encode admission request")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return admissionResp, errors.WithMessage(err, "build webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := h.httpClient.Do(req)
+	if err != nil {
+		return admissionResp, errors.WithMessage(err, "post webhook request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return admissionResp, errors.Errorf("unexpected webhook response status:%d, message:%s", resp.StatusCode, readWebhookMessage(resp.Body))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&admissionResp); err != nil {
+		return admissionResp, errors.WithMessage(err, "decode admission response")
+	}
+
+	switch admissionResp.Decision {
+	case AdmissionDecisionAllow, AdmissionDecisionDeny, AdmissionDecisionMutate:
+		return admissionResp, nil
+	default:
+		return admissionResp, errors.Errorf("unknown admission decision:%s", admissionResp.Decision)
+	}
+}
+
+// readWebhookMessage reads the message of the failed webhook response, which is the reason in the body if the body is
+// an admission response, otherwise the raw body.
+func readWebhookMessage(body io.Reader) string {
+	b, err := io.ReadAll(io.LimitReader(body, maxWebhookMessageBytes))
+	if err != nil {
+		return ""
+	}
+	var admissionResp AdmissionResponse
+	if err := json.Unmarshal(b, &admissionResp); err == nil && len(admissionResp.Reason) > 0 {
+		return admissionResp.Reason
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// admit asks the admission hook for the decision, and the returned options are the ones to create the table with.
+func (f *Factory) admit(ctx context.Context, request AdmissionRequest) (map[string]string, error) {
+	resp, err := f.deps.AdmissionHook.Admit(ctx, request)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "admit %s, table:%s", request.Operation, request.TableName)
+	}
+
+	switch resp.Decision {
+	case AdmissionDecisionDeny:
+		f.logger.Info("table request is denied by admission hook", zap.String("operation", string(request.Operation)), zap.String("schemaName", request.SchemaName), zap.String("tableName", request.TableName), zap.String("reason", resp.Reason))
+		return nil, ErrAdmissionDenied.WithCausef("operation:%s, table:%s, reason:%s", request.Operation, request.TableName, resp.Reason)
+	case AdmissionDecisionMutate:
+		f.logger.Info("table options are mutated by admission hook", zap.String("tableName", request.TableName), zap.Any("options", resp.Options), zap.String("reason", resp.Reason))
+		return resp.Options, nil
+	default:
+		return request.Options, nil
+	}
+}
@@ -0,0 +1,209 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package coordinator_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/coordinator"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func newAdmissionServer(t *testing.T, decide func(coordinator.AdmissionRequest) coordinator.AdmissionResponse) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req coordinator.AdmissionRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(decide(req))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestWebhookAdmissionHook(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	srv := newAdmissionServer(t, func(req coordinator.AdmissionRequest) coordinator.AdmissionResponse {
+		if req.Operation == coordinator.AdmissionOperationDropTable {
+			return coordinator.AdmissionResponse{Decision: coordinator.AdmissionDecisionDeny, Reason: "drop is frozen", Options: nil}
+		}
+		return coordinator.AdmissionResponse{Decision: coordinator.AdmissionDecisionMutate, Reason: "", Options: map[string]string{"ttl": "7d"}}
+	})
+
+	hook := coordinator.NewWebhookAdmissionHook(srv.URL, time.Second, false)
+	resp, err := hook.Admit(ctx, coordinator.AdmissionRequest{
+		ClusterName: test.ClusterName,
+		Operation:   coordinator.AdmissionOperationCreateTable,
+		SchemaName:  test.TestSchemaName,
+		TableName:   "t",
+		Options:     nil,
+	})
+	re.NoError(err)
+	re.Equal(coordinator.AdmissionDecisionMutate, resp.Decision)
+	re.Equal(map[string]string{"ttl": "7d"}, resp.Options)
+
+	// The unavailable webhook rejects the request unless it fails open.
+	unavailable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unavailable.Close()
+	req := coordinator.AdmissionRequest{
+		ClusterName: test.ClusterName,
+		Operation:   coordinator.AdmissionOperationDropTable,
+		SchemaName:  test.TestSchemaName,
+		TableName:   "t",
+		Options:     nil,
+	}
+	_, err = coordinator.NewWebhookAdmissionHook(unavailable.URL, time.Second, false).Admit(ctx, req)
+	re.True(coderr.Is(err, coordinator.ErrAdmissionWebhook.Code()))
+	re.ErrorContains(err, "admission webhook")
+	resp, err = coordinator.NewWebhookAdmissionHook(unavailable.URL, time.Second, true).Admit(ctx, req)
+	re.NoError(err)
+	re.Equal(coordinator.AdmissionDecisionAllow, resp.Decision)
+
+	// The message of the failed webhook is told by the error.
+	failed := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(coordinator.AdmissionResponse{Decision: "", Reason: "ticket reference is required", Options: nil})
+	}))
+	defer failed.Close()
+	_, err = coordinator.NewWebhookAdmissionHook(failed.URL, time.Second, false).Admit(ctx, req)
+	re.True(coderr.Is(err, coordinator.ErrAdmissionWebhook.Code()))
+	re.ErrorContains(err, "admission webhook")
+	re.Contains(err.Error(), "ticket reference is required")
+}
+
+func TestChainAdmissionHook(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	var asked []string
+	mutating := newAdmissionServer(t, func(_ coordinator.AdmissionRequest) coordinator.AdmissionResponse {
+		asked = append(asked, "mutating")
+		return coordinator.AdmissionResponse{Decision: coordinator.AdmissionDecisionMutate, Reason: "", Options: map[string]string{"ttl": "7d"}}
+	})
+	allowing := newAdmissionServer(t, func(req coordinator.AdmissionRequest) coordinator.AdmissionResponse {
+		asked = append(asked, "allowing")
+		// The options mutated by the previous hook are seen.
+		re.Equal(map[string]string{"ttl": "7d"}, req.Options)
+		return coordinator.AdmissionResponse{Decision: coordinator.AdmissionDecisionAllow, Reason: "", Options: nil}
+	})
+	denying := newAdmissionServer(t, func(_ coordinator.AdmissionRequest) coordinator.AdmissionResponse {
+		asked = append(asked, "denying")
+		return coordinator.AdmissionResponse{Decision: coordinator.AdmissionDecisionDeny, Reason: "quota exceeded", Options: nil}
+	})
+	req := coordinator.AdmissionRequest{
+		ClusterName: test.ClusterName,
+		Operation:   coordinator.AdmissionOperationCreateTable,
+		SchemaName:  test.TestSchemaName,
+		TableName:   "t",
+		Options:     map[string]string{"ttl": "30d"},
+	}
+
+	hook := coordinator.NewChainAdmissionHook(
+		coordinator.NewWebhookAdmissionHook(mutating.URL, time.Second, false),
+		coordinator.NewWebhookAdmissionHook(allowing.URL, time.Second, false),
+	)
+	resp, err := hook.Admit(ctx, req)
+	re.NoError(err)
+	re.Equal(coordinator.AdmissionDecisionMutate, resp.Decision)
+	re.Equal(map[string]string{"ttl": "7d"}, resp.Options)
+	re.Equal([]string{"mutating", "allowing"}, asked)
+
+	// The hooks after the denying one are not asked.
+	asked = nil
+	hook = coordinator.NewChainAdmissionHook(
+		coordinator.NewWebhookAdmissionHook(denying.URL, time.Second, false),
+		coordinator.NewWebhookAdmissionHook(mutating.URL, time.Second, false),
+	)
+	resp, err = hook.Admit(ctx, req)
+	re.NoError(err)
+	re.Equal(coordinator.AdmissionDecisionDeny, resp.Decision)
+	re.Equal("quota exceeded", resp.Reason)
+	re.Equal([]string{"denying"}, asked)
+}
+
+func TestFactoryAdmission(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	_, m := setupFactory(t)
+
+	srv := newAdmissionServer(t, func(req coordinator.AdmissionRequest) coordinator.AdmissionResponse {
+		if req.Operation == coordinator.AdmissionOperationDropTable {
+			return coordinator.AdmissionResponse{Decision: coordinator.AdmissionDecisionDeny, Reason: "drop is frozen", Options: nil}
+		}
+		return coordinator.AdmissionResponse{Decision: coordinator.AdmissionDecisionMutate, Reason: "", Options: map[string]string{"ttl": "7d"}}
+	})
+	deps := test.NewMockDependencies(t)
+	deps.AdmissionHook = coordinator.NewWebhookAdmissionHook(srv.URL, time.Second, false)
+	f := coordinator.NewFactory(zap.NewNop(), deps)
+
+	createReq := &metaservicepb.CreateTableRequest{
+		Header:             nil,
+		SchemaName:         test.TestSchemaName,
+		Name:               "admitted",
+		EncodedSchema:      nil,
+		Engine:             "",
+		CreateIfNotExist:   false,
+		Options:            map[string]string{"ttl": "30d"},
+		PartitionTableInfo: nil,
+	}
+	_, err := f.MakeCreateTableProcedure(ctx, coordinator.CreateTableRequest{
+		ClusterMetadata: m,
+		SourceReq:       createReq,
+		OnSucceeded:     nil,
+		OnFailed:        nil,
+	})
+	re.NoError(err)
+	// The options are replaced by the mutating decision.
+	re.Equal(map[string]string{"ttl": "7d"}, createReq.Options)
+
+	_, _, err = f.CreateDropTableProcedure(ctx, coordinator.DropTableRequest{
+		ClusterMetadata: m,
+		ClusterSnapshot: m.GetClusterSnapshot(),
+		SourceReq: &metaservicepb.DropTableRequest{
+			Header:             nil,
+			SchemaName:         test.TestSchemaName,
+			Name:               "admitted",
+			PartitionTableInfo: nil,
+		},
+		OnSucceeded: nil,
+		OnFailed:    nil,
+	})
+	re.True(coderr.Is(err, coordinator.ErrAdmissionDenied.Code()))
+	// The tables dropped in batch are admitted as well.
+	table := storage.Table{ID: 0, Name: "admitted", SchemaID: 0, CreatedAt: 0, PartitionInfo: storage.PartitionInfo{Info: nil}, Version: 0}
+	_, err = f.CreateBatchDropTableProcedure(ctx, coordinator.BatchDropTableRequest{
+		ClusterMetadata: m,
+		SchemaName:      test.TestSchemaName,
+		Tables:          []storage.Table{table},
+	})
+	re.True(coderr.Is(err, coordinator.ErrAdmissionDenied.Code()))
+}
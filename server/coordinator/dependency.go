@@ -0,0 +1,113 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/id"
+)
+
+// Dependencies are the components used by the Factory to build procedures.
+type Dependencies struct {
+	IDAllocator id.Allocator
+	Dispatch    eventdispatch.Dispatch
+	Storage     procedure.Storage
+	ShardPicker ShardPicker
+	// AdmissionHook is asked before the procedures to create or drop tables are built.
+	AdmissionHook AdmissionHook
+	// Clock is the source of the time of the procedures not bound to the cluster metadata, e.g. the transfer leader ones.
+	Clock clock.Clock
+}
+
+// DependencyResolver resolves the dependencies of the Factory for every cluster.
+type DependencyResolver interface {
+	// Resolve returns the dependencies of the cluster, and the defaults are built by the cluster itself.
+	Resolve(clusterName string, defaults Dependencies) Dependencies
+}
+
+type defaultDependencyResolver struct{}
+
+// NewDefaultDependencyResolver returns a resolver which always uses the default dependencies.
+func NewDefaultDependencyResolver() DependencyResolver {
+	return defaultDependencyResolver{}
+}
+
+func (defaultDependencyResolver) Resolve(_ string, defaults Dependencies) Dependencies {
+	return defaults
+}
+
+// overrideDependencyResolver replaces the default dependencies with the non-nil ones configured for the cluster, e.g. a
+// mock dispatch for a test cluster coexisting with the real clusters.
+type overrideDependencyResolver struct {
+	overrides map[string]Dependencies
+}
+
+func NewOverrideDependencyResolver(overrides map[string]Dependencies) DependencyResolver {
+	return overrideDependencyResolver{overrides: overrides}
+}
+
+func (r overrideDependencyResolver) Resolve(clusterName string, defaults Dependencies) Dependencies {
+	override, ok := r.overrides[clusterName]
+	if !ok {
+		return defaults
+	}
+
+	resolved := defaults
+	if override.IDAllocator != nil {
+		resolved.IDAllocator = override.IDAllocator
+	}
+	if override.Dispatch != nil {
+		resolved.Dispatch = override.Dispatch
+	}
+	if override.Storage != nil {
+		resolved.Storage = override.Storage
+	}
+	if override.ShardPicker != nil {
+		resolved.ShardPicker = override.ShardPicker
+	}
+	if override.AdmissionHook != nil {
+		resolved.AdmissionHook = override.AdmissionHook
+	}
+	if override.Clock != nil {
+		resolved.Clock = override.Clock
+	}
+	return resolved
+}
+
+// admissionDependencyResolver sets the admission hook of all the clusters on the dependencies resolved by the inner
+// resolver, unless the inner one has configured a hook other than the noop one for the cluster.
+type admissionDependencyResolver struct {
+	inner DependencyResolver
+	hook  AdmissionHook
+}
+
+func NewAdmissionDependencyResolver(inner DependencyResolver, hook AdmissionHook) DependencyResolver {
+	return admissionDependencyResolver{
+		inner: inner,
+		hook:  hook,
+	}
+}
+
+func (r admissionDependencyResolver) Resolve(clusterName string, defaults Dependencies) Dependencies {
+	resolved := r.inner.Resolve(clusterName, defaults)
+	if _, noop := resolved.AdmissionHook.(noopAdmissionHook); resolved.AdmissionHook == nil || noop {
+		resolved.AdmissionHook = r.hook
+	}
+	return resolved
+}
@@ -0,0 +1,95 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package coordinator_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/coordinator"
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverrideDependencyResolver(t *testing.T) {
+	re := require.New(t)
+
+	defaults := coordinator.Dependencies{
+		IDAllocator:   test.MockIDAllocator{},
+		Dispatch:      eventdispatch.NewDispatchImpl(),
+		Storage:       test.NewTestStorage(t),
+		ShardPicker:   coordinator.NewLeastTableShardPicker(),
+		AdmissionHook: coordinator.NewNoopAdmissionHook(),
+		Clock:         clock.NewRealClock(),
+	}
+	re.Equal(defaults, coordinator.NewDefaultDependencyResolver().Resolve(test.ClusterName, defaults))
+
+	resolver := coordinator.NewOverrideDependencyResolver(map[string]coordinator.Dependencies{
+		test.ClusterName: {
+			IDAllocator:   nil,
+			Dispatch:      test.MockDispatch{},
+			Storage:       nil,
+			ShardPicker:   nil,
+			AdmissionHook: nil,
+			Clock:         nil,
+		},
+	})
+
+	// Only the dispatch of the overridden cluster is replaced.
+	resolved := resolver.Resolve(test.ClusterName, defaults)
+	re.Equal(test.MockDispatch{}, resolved.Dispatch)
+	re.Equal(defaults.IDAllocator, resolved.IDAllocator)
+	re.Equal(defaults.Storage, resolved.Storage)
+	re.Equal(defaults.ShardPicker, resolved.ShardPicker)
+	re.Equal(defaults.AdmissionHook, resolved.AdmissionHook)
+	re.Equal(defaults.Clock, resolved.Clock)
+
+	// The other clusters keep the defaults.
+	re.Equal(defaults, resolver.Resolve("otherCluster", defaults))
+}
+
+func TestAdmissionDependencyResolver(t *testing.T) {
+	re := require.New(t)
+
+	defaults := coordinator.Dependencies{
+		IDAllocator:   test.MockIDAllocator{},
+		Dispatch:      eventdispatch.NewDispatchImpl(),
+		Storage:       test.NewTestStorage(t),
+		ShardPicker:   coordinator.NewLeastTableShardPicker(),
+		AdmissionHook: coordinator.NewNoopAdmissionHook(),
+		Clock:         clock.NewRealClock(),
+	}
+	hook := coordinator.NewWebhookAdmissionHook("http://127.0.0.1:1/admit", time.Second, false)
+	clusterHook := coordinator.NewWebhookAdmissionHook("http://127.0.0.1:1/cluster/admit", time.Second, false)
+	resolver := coordinator.NewAdmissionDependencyResolver(coordinator.NewOverrideDependencyResolver(map[string]coordinator.Dependencies{
+		test.ClusterName: {
+			IDAllocator:   nil,
+			Dispatch:      nil,
+			Storage:       nil,
+			ShardPicker:   nil,
+			AdmissionHook: clusterHook,
+			Clock:         nil,
+		},
+	}), hook)
+
+	// The hook configured for the cluster is kept.
+	re.Equal(clusterHook, resolver.Resolve(test.ClusterName, defaults).AdmissionHook)
+	// The noop hook of the other clusters is replaced.
+	re.Equal(hook, resolver.Resolve("otherCluster", defaults).AdmissionHook)
+}
@@ -19,6 +19,9 @@ package coordinator
 import "github.com/CeresDB/horaemeta/pkg/coderr"
 
 var (
-	ErrNodeNumberNotEnough = coderr.NewCodeError(coderr.Internal, "node number not enough")
-	ErrPickNode            = coderr.NewCodeError(coderr.Internal, "no node is picked")
+	ErrNodeNumberNotEnough     = coderr.NewCodeError(coderr.Internal, "node number not enough")
+	ErrPickNode                = coderr.NewCodeError(coderr.Internal, "no node is picked")
+	ErrAdmissionDenied         = coderr.NewCodeError(coderr.BadRequest, "denied by admission hook")
+	ErrAdmissionWebhook        = coderr.NewCodeError(coderr.Internal, "admission webhook")
+	ErrTargetShardNotSupported = coderr.NewCodeError(coderr.BadRequest, "target shard is not supported")
 )
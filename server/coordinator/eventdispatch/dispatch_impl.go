@@ -18,7 +18,6 @@ package eventdispatch
 
 import (
 	"context"
-	"sync"
 
 	"github.com/CeresDB/horaedbproto/golang/pkg/metaeventpb"
 	"github.com/CeresDB/horaemeta/pkg/coderr"
@@ -31,12 +30,12 @@ import (
 var ErrDispatch = coderr.NewCodeError(coderr.Internal, "event dispatch failed")
 
 type DispatchImpl struct {
-	conns sync.Map
+	conns *service.ConnPool
 }
 
 func NewDispatchImpl() *DispatchImpl {
 	return &DispatchImpl{
-		conns: sync.Map{},
+		conns: service.NewConnPool("eventDispatch", service.DefaultConnPoolOptions()),
 	}
 }
 
@@ -45,9 +44,7 @@ func (d *DispatchImpl) OpenShard(ctx context.Context, addr string, request OpenS
 	if err != nil {
 		return err
 	}
-	resp, err := client.OpenShard(ctx, &metaeventpb.OpenShardRequest{
-		Shard: metadata.ConvertShardsInfoToPB(request.Shard),
-	})
+	resp, err := client.OpenShard(ctx, convertOpenShardRequestToPB(request))
 	if err != nil {
 		return errors.WithMessagef(err, "open shard, addr:%s, request:%v", addr, request)
 	}
@@ -62,9 +59,7 @@ func (d *DispatchImpl) CloseShard(ctx context.Context, addr string, request Clos
 	if err != nil {
 		return err
 	}
-	resp, err := client.CloseShard(ctx, &metaeventpb.CloseShardRequest{
-		ShardId: request.ShardID,
-	})
+	resp, err := client.CloseShard(ctx, convertCloseShardRequestToPB(request))
 	if err != nil {
 		return errors.WithMessagef(err, "close shard, addr:%s, request:%v", addr, request)
 	}
@@ -137,16 +132,7 @@ func (d *DispatchImpl) CloseTableOnShard(ctx context.Context, addr string, reque
 }
 
 func (d *DispatchImpl) getGrpcClient(ctx context.Context, addr string) (*grpc.ClientConn, error) {
-	client, ok := d.conns.Load(addr)
-	if !ok {
-		cc, err := service.GetClientConn(ctx, addr)
-		if err != nil {
-			return nil, err
-		}
-		client = cc
-		d.conns.Store(addr, cc)
-	}
-	return client.(*grpc.ClientConn), nil
+	return d.conns.Get(ctx, addr)
 }
 
 func (d *DispatchImpl) getMetaEventClient(ctx context.Context, addr string) (metaeventpb.MetaEventServiceClient, error) {
@@ -157,6 +143,18 @@ func (d *DispatchImpl) getMetaEventClient(ctx context.Context, addr string) (met
 	return metaeventpb.NewMetaEventServiceClient(client), nil
 }
 
+func convertOpenShardRequestToPB(request OpenShardRequest) *metaeventpb.OpenShardRequest {
+	return &metaeventpb.OpenShardRequest{
+		Shard: metadata.ConvertShardsInfoToPB(request.Shard),
+	}
+}
+
+func convertCloseShardRequestToPB(request CloseShardRequest) *metaeventpb.CloseShardRequest {
+	return &metaeventpb.CloseShardRequest{
+		ShardId: request.ShardID,
+	}
+}
+
 func convertCreateTableOnShardRequestToPB(request CreateTableOnShardRequest) *metaeventpb.CreateTableOnShardRequest {
 	return &metaeventpb.CreateTableOnShardRequest{
 		UpdateShardInfo:  convertUpdateShardInfoToPB(request.UpdateShardInfo),
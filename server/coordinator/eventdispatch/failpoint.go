@@ -0,0 +1,85 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventdispatch
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CeresDB/horaemeta/pkg/failpoint"
+)
+
+// FailpointDispatch evaluates the failpoints before the operations are dispatched to the nodes. Every operation is preceded
+// by the failpoint named "eventdispatch/<operation>", e.g. "eventdispatch/openShard", and then the one of the node
+// "eventdispatch/<operation>/<address>", so that the failures can be injected to all the nodes or a single one.
+type FailpointDispatch struct {
+	Dispatch
+}
+
+func NewFailpointDispatch(dispatch Dispatch) *FailpointDispatch {
+	return &FailpointDispatch{Dispatch: dispatch}
+}
+
+func injectDispatchFailpoints(ctx context.Context, operation, addr string) error {
+	name := fmt.Sprintf("eventdispatch/%s", operation)
+	if err := failpoint.Inject(ctx, name); err != nil {
+		return err
+	}
+	return failpoint.Inject(ctx, fmt.Sprintf("%s/%s", name, addr))
+}
+
+func (d *FailpointDispatch) OpenShard(ctx context.Context, addr string, request OpenShardRequest) error {
+	if err := injectDispatchFailpoints(ctx, "openShard", addr); err != nil {
+		return err
+	}
+	return d.Dispatch.OpenShard(ctx, addr, request)
+}
+
+func (d *FailpointDispatch) CloseShard(ctx context.Context, addr string, request CloseShardRequest) error {
+	if err := injectDispatchFailpoints(ctx, "closeShard", addr); err != nil {
+		return err
+	}
+	return d.Dispatch.CloseShard(ctx, addr, request)
+}
+
+func (d *FailpointDispatch) CreateTableOnShard(ctx context.Context, addr string, request CreateTableOnShardRequest) (uint64, error) {
+	if err := injectDispatchFailpoints(ctx, "createTableOnShard", addr); err != nil {
+		return 0, err
+	}
+	return d.Dispatch.CreateTableOnShard(ctx, addr, request)
+}
+
+func (d *FailpointDispatch) DropTableOnShard(ctx context.Context, addr string, request DropTableOnShardRequest) (uint64, error) {
+	if err := injectDispatchFailpoints(ctx, "dropTableOnShard", addr); err != nil {
+		return 0, err
+	}
+	return d.Dispatch.DropTableOnShard(ctx, addr, request)
+}
+
+func (d *FailpointDispatch) OpenTableOnShard(ctx context.Context, addr string, request OpenTableOnShardRequest) error {
+	if err := injectDispatchFailpoints(ctx, "openTableOnShard", addr); err != nil {
+		return err
+	}
+	return d.Dispatch.OpenTableOnShard(ctx, addr, request)
+}
+
+func (d *FailpointDispatch) CloseTableOnShard(ctx context.Context, addr string, request CloseTableOnShardRequest) error {
+	if err := injectDispatchFailpoints(ctx, "closeTableOnShard", addr); err != nil {
+		return err
+	}
+	return d.Dispatch.CloseTableOnShard(ctx, addr, request)
+}
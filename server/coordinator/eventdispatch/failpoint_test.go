@@ -0,0 +1,54 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventdispatch
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/pkg/failpoint"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailpointDispatch(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	defer failpoint.SetEnabled(false)
+
+	inner := &countingDispatch{Dispatch: nil, openCount: 0}
+	dispatch := NewFailpointDispatch(inner)
+	request := OpenShardRequest{
+		Shard: metadata.ShardInfo{ID: 1, Role: storage.ShardRoleLeader, Version: 2, Status: storage.ShardStatusReady},
+	}
+	re.NoError(dispatch.OpenShard(ctx, "node0", request))
+	re.Equal(1, inner.openCount)
+
+	// The failure is injected to the node only.
+	failpoint.SetEnabled(true)
+	re.NoError(failpoint.Enable("eventdispatch/openShard/node1", failpoint.Action{Kind: failpoint.ActionError, Message: "", DelayMs: 0, Count: 0}))
+	re.NoError(dispatch.OpenShard(ctx, "node0", request))
+	re.True(coderr.Is(dispatch.OpenShard(ctx, "node1", request), failpoint.ErrInjected.Code()))
+	re.Equal(2, inner.openCount)
+
+	// The failure is injected to all the nodes.
+	re.NoError(failpoint.Enable("eventdispatch/openShard", failpoint.Action{Kind: failpoint.ActionError, Message: "", DelayMs: 0, Count: 0}))
+	re.True(coderr.Is(dispatch.OpenShard(ctx, "node0", request), failpoint.ErrInjected.Code()))
+	re.Equal(2, inner.openCount)
+}
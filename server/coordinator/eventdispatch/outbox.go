@@ -0,0 +1,452 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventdispatch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaeventpb"
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	outboxVersion = "v1"
+	outboxPath    = "dispatchOutbox"
+
+	defaultRetryInitialBackoff = time.Millisecond * 200
+	defaultRetryMaxBackoff     = time.Second * 5
+	defaultRetryDeadline       = time.Minute
+)
+
+type outboxOp string
+
+const (
+	outboxOpOpenShard          outboxOp = "openShard"
+	outboxOpCloseShard         outboxOp = "closeShard"
+	outboxOpCreateTableOnShard outboxOp = "createTableOnShard"
+	outboxOpDropTableOnShard   outboxOp = "dropTableOnShard"
+	outboxOpOpenTableOnShard   outboxOp = "openTableOnShard"
+	outboxOpCloseTableOnShard  outboxOp = "closeTableOnShard"
+)
+
+// outboxEntry is the dispatch operation being retried, and the request is kept as the proto message sent to the node.
+type outboxEntry struct {
+	Op      outboxOp `json:"op"`
+	Addr    string   `json:"addr"`
+	Payload []byte   `json:"payload"`
+	// Deadline is the unix milliseconds after which the operation is not retried any more.
+	Deadline int64  `json:"deadline"`
+	Attempts uint32 `json:"attempts"`
+	// ProcedureID is the procedure issuing the operation, and zero means the operation is not issued by a procedure.
+	ProcedureID uint64 `json:"procedureID,omitempty"`
+}
+
+type procedureIDContextKey struct{}
+
+// WithProcedureID returns the context of the procedure, and the operations dispatched with it are replayed by the next
+// leader only if the procedure is still live.
+func WithProcedureID(ctx context.Context, procedureID uint64) context.Context {
+	return context.WithValue(ctx, procedureIDContextKey{}, procedureID)
+}
+
+// procedureIDFromContext returns the procedure issuing the operation, and zero is returned if there is none.
+func procedureIDFromContext(ctx context.Context) uint64 {
+	procedureID, _ := ctx.Value(procedureIDContextKey{}).(uint64)
+	return procedureID
+}
+
+// ProcedureChecker returns true if the procedure is still live, i.e. it is neither done nor removed.
+type ProcedureChecker func(ctx context.Context, procedureID uint64) (bool, error)
+
+type RetryConfig struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// Deadline is how long an operation is retried since it is dispatched for the first time.
+	Deadline time.Duration
+}
+
+func DefaultRetryConfig() RetryConfig {
+	return RetryConfig{
+		InitialBackoff: defaultRetryInitialBackoff,
+		MaxBackoff:     defaultRetryMaxBackoff,
+		Deadline:       defaultRetryDeadline,
+	}
+}
+
+// RetryDispatch retries the operations failing because the node is unreachable with the exponential backoff, and the
+// operations being retried are kept in the outbox in etcd. The outbox is replayed when the dispatch is started by the
+// new leader, so the operations interrupted by the leader failover are still delivered before their deadlines. The
+// operations rejected by the node are not retried.
+type RetryDispatch struct {
+	Dispatch
+
+	logger           *zap.Logger
+	client           *clientv3.Client
+	rootPath         string
+	config           RetryConfig
+	procedureChecker ProcedureChecker
+	// clock stamps the deadlines of the operations, which is the cluster clock so that the next leader sees the same time.
+	clock clock.Clock
+	// nextID makes the keys of the entries added in the same nanosecond unique.
+	nextID atomic.Uint64
+
+	// Mutex is used to protect following fields.
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewRetryDispatch(logger *zap.Logger, dispatch Dispatch, client *clientv3.Client, rootPath string, clusterID storage.ClusterID, clk clock.Clock, config RetryConfig, procedureChecker ProcedureChecker) *RetryDispatch {
+	return &RetryDispatch{
+		Dispatch:         dispatch,
+		logger:           logger,
+		client:           client,
+		rootPath:         path.Join(rootPath, outboxVersion, outboxPath, fmt.Sprintf("%020d", clusterID)),
+		config:           config,
+		procedureChecker: procedureChecker,
+		clock:            clk,
+		nextID:           atomic.Uint64{},
+		lock:             sync.Mutex{},
+		cancel:           nil,
+		wg:               sync.WaitGroup{},
+	}
+}
+
+// Start replays the operations left in the outbox by the former leader in the background.
+func (d *RetryDispatch) Start(ctx context.Context) error {
+	resp, err := d.client.Get(ctx, d.rootPath+"/", clientv3.WithPrefix())
+	if err != nil {
+		return errors.WithMessage(err, "list dispatch outbox")
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	replayCtx, cancel := context.WithCancel(context.Background())
+	d.cancel = cancel
+	for _, kv := range resp.Kvs {
+		key := string(kv.Key)
+		var entry outboxEntry
+		if err := json.Unmarshal(kv.Value, &entry); err != nil {
+			d.logger.Error("decode dispatch outbox entry failed", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		d.wg.Add(1)
+		go func() {
+			defer d.wg.Done()
+			if err := d.replay(replayCtx, key, entry); err != nil {
+				d.logger.Warn("replay dispatch outbox entry failed", zap.String("key", key), zap.String("op", string(entry.Op)), zap.String("addr", entry.Addr), zap.Error(err))
+				return
+			}
+			d.logger.Info("replay dispatch outbox entry succeed", zap.String("key", key), zap.String("op", string(entry.Op)), zap.String("addr", entry.Addr))
+		}()
+	}
+	return nil
+}
+
+// Stop cancels the replays, and the entries being replayed are kept for the next leader.
+func (d *RetryDispatch) Stop(_ context.Context) error {
+	d.lock.Lock()
+	cancel := d.cancel
+	d.cancel = nil
+	d.lock.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	d.wg.Wait()
+	return nil
+}
+
+func (d *RetryDispatch) OpenShard(ctx context.Context, addr string, request OpenShardRequest) error {
+	return d.dispatch(ctx, outboxOpOpenShard, addr, convertOpenShardRequestToPB(request), func(ctx context.Context) error {
+		return d.Dispatch.OpenShard(ctx, addr, request)
+	})
+}
+
+func (d *RetryDispatch) CloseShard(ctx context.Context, addr string, request CloseShardRequest) error {
+	return d.dispatch(ctx, outboxOpCloseShard, addr, convertCloseShardRequestToPB(request), func(ctx context.Context) error {
+		return d.Dispatch.CloseShard(ctx, addr, request)
+	})
+}
+
+func (d *RetryDispatch) CreateTableOnShard(ctx context.Context, addr string, request CreateTableOnShardRequest) (uint64, error) {
+	var latestVersion uint64
+	err := d.dispatch(ctx, outboxOpCreateTableOnShard, addr, convertCreateTableOnShardRequestToPB(request), func(ctx context.Context) error {
+		version, err := d.Dispatch.CreateTableOnShard(ctx, addr, request)
+		latestVersion = version
+		return err
+	})
+	return latestVersion, err
+}
+
+func (d *RetryDispatch) DropTableOnShard(ctx context.Context, addr string, request DropTableOnShardRequest) (uint64, error) {
+	var latestVersion uint64
+	err := d.dispatch(ctx, outboxOpDropTableOnShard, addr, convertDropTableOnShardRequestToPB(request), func(ctx context.Context) error {
+		version, err := d.Dispatch.DropTableOnShard(ctx, addr, request)
+		latestVersion = version
+		return err
+	})
+	return latestVersion, err
+}
+
+func (d *RetryDispatch) OpenTableOnShard(ctx context.Context, addr string, request OpenTableOnShardRequest) error {
+	return d.dispatch(ctx, outboxOpOpenTableOnShard, addr, convertOpenTableOnShardRequestToPB(request), func(ctx context.Context) error {
+		return d.Dispatch.OpenTableOnShard(ctx, addr, request)
+	})
+}
+
+func (d *RetryDispatch) CloseTableOnShard(ctx context.Context, addr string, request CloseTableOnShardRequest) error {
+	return d.dispatch(ctx, outboxOpCloseTableOnShard, addr, convertCloseTableOnShardRequestToPB(request), func(ctx context.Context) error {
+		return d.Dispatch.CloseTableOnShard(ctx, addr, request)
+	})
+}
+
+// dispatch tries the operation once, and it is added to the outbox and retried only if the node is unreachable.
+func (d *RetryDispatch) dispatch(ctx context.Context, op outboxOp, addr string, request proto.Message, f func(context.Context) error) error {
+	err := f(ctx)
+	if err == nil || !isRetryable(err) {
+		return err
+	}
+
+	payload, encodeErr := proto.Marshal(request)
+	if encodeErr != nil {
+		return errors.WithMessagef(err, "encode request for retry failed, err:%v", encodeErr)
+	}
+	entry := outboxEntry{
+		Op:          op,
+		Addr:        addr,
+		Payload:     payload,
+		Deadline:    d.clock.Now().Add(d.config.Deadline).UnixMilli(),
+		Attempts:    1,
+		ProcedureID: procedureIDFromContext(ctx),
+	}
+	key := path.Join(d.rootPath, fmt.Sprintf("%020d-%010d", d.clock.Now().UnixNano(), d.nextID.Add(1)))
+	if putErr := d.put(ctx, key, entry); putErr != nil {
+		// The operation is still retried, but it is lost if the leader fails over.
+		d.logger.Warn("add dispatch outbox entry failed", zap.String("key", key), zap.Error(putErr))
+	}
+
+	err = d.retry(ctx, key, entry, f)
+	// The entry is kept for the next leader if the caller stops waiting, e.g. the leader steps down.
+	if ctx.Err() == nil {
+		d.delete(key)
+	}
+	return err
+}
+
+// replay retries the operation decoded from the outbox entry, and the entry is removed once the operation is done. The
+// entry past its deadline or issued by the procedure no longer live is removed without being tried.
+func (d *RetryDispatch) replay(ctx context.Context, key string, entry outboxEntry) error {
+	if d.clock.Now().UnixMilli() > entry.Deadline {
+		d.delete(key)
+		return errors.WithMessagef(ErrDispatch, "retry deadline exceeded before replay, op:%s, addr:%s, attempts:%d", entry.Op, entry.Addr, entry.Attempts)
+	}
+	if entry.ProcedureID != 0 && d.procedureChecker != nil {
+		live, err := d.procedureChecker(ctx, entry.ProcedureID)
+		if err != nil {
+			// The entry is kept for the next leader.
+			return errors.WithMessagef(err, "check procedure, procedureID:%d", entry.ProcedureID)
+		}
+		if !live {
+			d.delete(key)
+			return errors.WithMessagef(ErrDispatch, "procedure is not live, procedureID:%d, op:%s, addr:%s", entry.ProcedureID, entry.Op, entry.Addr)
+		}
+	}
+
+	f, err := d.decodeOperation(entry)
+	if err != nil {
+		d.delete(key)
+		return err
+	}
+
+	// The entry may be left by the leader stepping down before its first try, so it is tried once at least.
+	err = f(ctx)
+	if err != nil && isRetryable(err) {
+		err = d.retry(ctx, key, entry, f)
+	}
+	if ctx.Err() == nil {
+		d.delete(key)
+	}
+	return err
+}
+
+// retry the operation with the exponential backoff until it is done, the node rejects it or the deadline is reached.
+func (d *RetryDispatch) retry(ctx context.Context, key string, entry outboxEntry, f func(context.Context) error) error {
+	deadline := time.UnixMilli(entry.Deadline)
+	backoff := d.config.InitialBackoff
+	for {
+		if d.clock.Now().Add(backoff).After(deadline) {
+			return errors.WithMessagef(ErrDispatch, "retry deadline exceeded, op:%s, addr:%s, attempts:%d", entry.Op, entry.Addr, entry.Attempts)
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.WithMessagef(ctx.Err(), "wait for retry, op:%s, addr:%s", entry.Op, entry.Addr)
+		case <-timer.C:
+		}
+
+		entry.Attempts++
+		err := f(ctx)
+		if err == nil || !isRetryable(err) {
+			return err
+		}
+		d.logger.Warn("dispatch to unreachable node, retry later", zap.String("op", string(entry.Op)), zap.String("addr", entry.Addr), zap.Uint32("attempts", entry.Attempts), zap.Error(err))
+		if err := d.put(ctx, key, entry); err != nil {
+			d.logger.Warn("update dispatch outbox entry failed", zap.String("key", key), zap.Error(err))
+		}
+		backoff = min(backoff*2, d.config.MaxBackoff)
+	}
+}
+
+func (d *RetryDispatch) put(ctx context.Context, key string, entry outboxEntry) error {
+	value, err := json.Marshal(entry)
+	if err != nil {
+		return errors.WithMessage(err, "encode dispatch outbox entry")
+	}
+	if _, err := d.client.Put(ctx, key, string(value)); err != nil {
+		return errors.WithMessagef(err, "put dispatch outbox entry, key:%s", key)
+	}
+	return nil
+}
+
+// delete the entry with a fresh context, because the entry should be removed even if the operation fails with the
+// context of the caller.
+func (d *RetryDispatch) delete(key string) {
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRetryMaxBackoff)
+	defer cancel()
+
+	if _, err := d.client.Delete(ctx, key); err != nil {
+		d.logger.Warn("delete dispatch outbox entry failed", zap.String("key", key), zap.Error(err))
+	}
+}
+
+// decodeOperation rebuilds the operation of the entry against the wrapped dispatch.
+func (d *RetryDispatch) decodeOperation(entry outboxEntry) (func(context.Context) error, error) {
+	addr := entry.Addr
+	switch entry.Op {
+	case outboxOpOpenShard:
+		var pb metaeventpb.OpenShardRequest
+		if err := proto.Unmarshal(entry.Payload, &pb); err != nil {
+			return nil, errors.WithMessage(err, "decode open shard request")
+		}
+		request := OpenShardRequest{Shard: metadata.ConvertShardsInfoPB(pb.GetShard())}
+		return func(ctx context.Context) error { return d.Dispatch.OpenShard(ctx, addr, request) }, nil
+	case outboxOpCloseShard:
+		var pb metaeventpb.CloseShardRequest
+		if err := proto.Unmarshal(entry.Payload, &pb); err != nil {
+			return nil, errors.WithMessage(err, "decode close shard request")
+		}
+		request := CloseShardRequest{ShardID: pb.GetShardId()}
+		return func(ctx context.Context) error { return d.Dispatch.CloseShard(ctx, addr, request) }, nil
+	case outboxOpCreateTableOnShard:
+		var pb metaeventpb.CreateTableOnShardRequest
+		if err := proto.Unmarshal(entry.Payload, &pb); err != nil {
+			return nil, errors.WithMessage(err, "decode create table on shard request")
+		}
+		request := CreateTableOnShardRequest{
+			UpdateShardInfo:  convertUpdateShardInfoPB(pb.GetUpdateShardInfo()),
+			TableInfo:        convertTableInfoPB(pb.GetTableInfo()),
+			EncodedSchema:    pb.GetEncodedSchema(),
+			Engine:           pb.GetEngine(),
+			CreateIfNotExist: pb.GetCreateIfNotExist(),
+			Options:          pb.GetOptions(),
+		}
+		return func(ctx context.Context) error {
+			_, err := d.Dispatch.CreateTableOnShard(ctx, addr, request)
+			return err
+		}, nil
+	case outboxOpDropTableOnShard:
+		var pb metaeventpb.DropTableOnShardRequest
+		if err := proto.Unmarshal(entry.Payload, &pb); err != nil {
+			return nil, errors.WithMessage(err, "decode drop table on shard request")
+		}
+		request := DropTableOnShardRequest{
+			UpdateShardInfo: convertUpdateShardInfoPB(pb.GetUpdateShardInfo()),
+			TableInfo:       convertTableInfoPB(pb.GetTableInfo()),
+		}
+		return func(ctx context.Context) error {
+			_, err := d.Dispatch.DropTableOnShard(ctx, addr, request)
+			return err
+		}, nil
+	case outboxOpOpenTableOnShard:
+		var pb metaeventpb.OpenTableOnShardRequest
+		if err := proto.Unmarshal(entry.Payload, &pb); err != nil {
+			return nil, errors.WithMessage(err, "decode open table on shard request")
+		}
+		request := OpenTableOnShardRequest{
+			UpdateShardInfo: convertUpdateShardInfoPB(pb.GetUpdateShardInfo()),
+			TableInfo:       convertTableInfoPB(pb.GetTableInfo()),
+		}
+		return func(ctx context.Context) error { return d.Dispatch.OpenTableOnShard(ctx, addr, request) }, nil
+	case outboxOpCloseTableOnShard:
+		var pb metaeventpb.CloseTableOnShardRequest
+		if err := proto.Unmarshal(entry.Payload, &pb); err != nil {
+			return nil, errors.WithMessage(err, "decode close table on shard request")
+		}
+		request := CloseTableOnShardRequest{
+			UpdateShardInfo: convertUpdateShardInfoPB(pb.GetUpdateShardInfo()),
+			TableInfo:       convertTableInfoPB(pb.GetTableInfo()),
+		}
+		return func(ctx context.Context) error { return d.Dispatch.CloseTableOnShard(ctx, addr, request) }, nil
+	}
+	return nil, errors.WithMessagef(ErrDispatch, "unknown dispatch outbox op:%s", entry.Op)
+}
+
+// isRetryable returns true if the operation fails because the node is unreachable rather than rejected by the node.
+func isRetryable(err error) bool {
+	cause := errors.Cause(err)
+	if errors.Is(cause, context.DeadlineExceeded) {
+		return true
+	}
+	code := status.Code(cause)
+	return code == codes.Unavailable || code == codes.DeadlineExceeded
+}
+
+func convertUpdateShardInfoPB(updateShardInfo *metaeventpb.UpdateShardInfo) UpdateShardInfo {
+	return UpdateShardInfo{
+		CurrShardInfo: metadata.ConvertShardsInfoPB(updateShardInfo.GetCurrShardInfo()),
+	}
+}
+
+func convertTableInfoPB(table *metaservicepb.TableInfo) metadata.TableInfo {
+	return metadata.TableInfo{
+		ID:            storage.TableID(table.GetId()),
+		Name:          table.GetName(),
+		SchemaID:      storage.SchemaID(table.GetSchemaId()),
+		SchemaName:    table.GetSchemaName(),
+		PartitionInfo: storage.PartitionInfo{Info: table.GetPartitionInfo()},
+		CreatedAt:     0,
+	}
+}
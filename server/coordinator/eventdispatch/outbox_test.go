@@ -0,0 +1,170 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventdispatch
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/etcdutil"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// flakyDispatch fails the OpenShard with the given error until the failures are used up.
+type flakyDispatch struct {
+	Dispatch
+
+	err      error
+	failures atomic.Int32
+	calls    atomic.Int32
+}
+
+func (d *flakyDispatch) OpenShard(_ context.Context, _ string, _ OpenShardRequest) error {
+	d.calls.Add(1)
+	if d.failures.Add(-1) >= 0 {
+		return d.err
+	}
+	return nil
+}
+
+func newFlakyDispatch(err error, failures int32) *flakyDispatch {
+	d := &flakyDispatch{
+		Dispatch: nil,
+		err:      err,
+		failures: atomic.Int32{},
+		calls:    atomic.Int32{},
+	}
+	d.failures.Store(failures)
+	return d
+}
+
+func countOutboxEntries(re *require.Assertions, client *clientv3.Client, d *RetryDispatch) int64 {
+	resp, err := client.Get(context.Background(), d.rootPath+"/", clientv3.WithPrefix(), clientv3.WithCountOnly())
+	re.NoError(err)
+	return resp.Count
+}
+
+func TestRetryDispatch(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	_, client, closeSrv := etcdutil.PrepareEtcdServerAndClient(t)
+	defer closeSrv()
+
+	config := RetryConfig{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond * 10, Deadline: time.Second * 10}
+	request := OpenShardRequest{
+		Shard: metadata.ShardInfo{ID: 1, Role: storage.ShardRoleLeader, Version: 2, Status: storage.ShardStatusReady},
+	}
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+
+	// The operation to the unreachable node is retried until it succeeds.
+	inner := newFlakyDispatch(unavailable, 3)
+	d := NewRetryDispatch(zap.NewNop(), inner, client, "/rootPath", 0, clock.NewRealClock(), config, nil)
+	re.NoError(d.OpenShard(ctx, "node0", request))
+	re.Equal(int32(4), inner.calls.Load())
+	re.Equal(int64(0), countOutboxEntries(re, client, d))
+
+	// The operation rejected by the node is not retried.
+	inner = newFlakyDispatch(ErrDispatch.WithCausef("shard not found"), 1)
+	d = NewRetryDispatch(zap.NewNop(), inner, client, "/rootPath", 0, clock.NewRealClock(), config, nil)
+	re.Error(d.OpenShard(ctx, "node0", request))
+	re.Equal(int32(1), inner.calls.Load())
+
+	// The operation is not retried after the deadline.
+	inner = newFlakyDispatch(unavailable, 100)
+	d = NewRetryDispatch(zap.NewNop(), inner, client, "/rootPath", 0, clock.NewRealClock(), RetryConfig{InitialBackoff: time.Millisecond * 20, MaxBackoff: time.Millisecond * 20, Deadline: time.Millisecond * 50}, nil)
+	re.Error(d.OpenShard(ctx, "node0", request))
+	re.Equal(int64(0), countOutboxEntries(re, client, d))
+
+	// The operation is kept in the outbox if the caller stops waiting, and it is replayed by the next leader.
+	inner = newFlakyDispatch(unavailable, 100)
+	d = NewRetryDispatch(zap.NewNop(), inner, client, "/rootPath", 0, clock.NewRealClock(), config, nil)
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond*50)
+	defer cancel()
+	re.Error(d.OpenShard(timeoutCtx, "node0", request))
+	re.Equal(int64(1), countOutboxEntries(re, client, d))
+
+	inner = newFlakyDispatch(unavailable, 1)
+	d = NewRetryDispatch(zap.NewNop(), inner, client, "/rootPath", 0, clock.NewRealClock(), config, nil)
+	re.NoError(d.Start(ctx))
+	re.Eventually(func() bool {
+		return countOutboxEntries(re, client, d) == 0
+	}, time.Second*5, time.Millisecond*10)
+	re.NoError(d.Stop(ctx))
+	re.Equal(int32(2), inner.calls.Load())
+}
+
+func TestRetryDispatchReplaySkipped(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	_, client, closeSrv := etcdutil.PrepareEtcdServerAndClient(t)
+	defer closeSrv()
+
+	config := RetryConfig{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond * 10, Deadline: time.Second * 10}
+	payload, err := proto.Marshal(convertCloseShardRequestToPB(CloseShardRequest{ShardID: 1}))
+	re.NoError(err)
+	newEntry := func(deadline time.Time, procedureID uint64) outboxEntry {
+		return outboxEntry{
+			Op:          outboxOpCloseShard,
+			Addr:        "node0",
+			Payload:     payload,
+			Deadline:    deadline.UnixMilli(),
+			Attempts:    1,
+			ProcedureID: procedureID,
+		}
+	}
+
+	inner := &closeCountingDispatch{Dispatch: nil, calls: atomic.Int32{}}
+	// The deadlines are checked by the cluster clock instead of the local one.
+	clk := clock.NewMock(time.Now().Add(time.Hour))
+	d := NewRetryDispatch(zap.NewNop(), inner, client, "/rootPath", 0, clk, config, func(_ context.Context, procedureID uint64) (bool, error) {
+		return procedureID == 1, nil
+	})
+	// The expired entry and the entry of the procedure no longer live are removed without being tried.
+	re.NoError(d.put(ctx, d.rootPath+"/expired", newEntry(time.Now().Add(time.Minute), 0)))
+	re.NoError(d.put(ctx, d.rootPath+"/done", newEntry(clk.Now().Add(time.Minute), 2)))
+	// The entry of the live procedure is replayed.
+	re.NoError(d.put(ctx, d.rootPath+"/live", newEntry(clk.Now().Add(time.Minute), 1)))
+
+	re.NoError(d.Start(ctx))
+	re.Eventually(func() bool {
+		return countOutboxEntries(re, client, d) == 0
+	}, time.Second*5, time.Millisecond*10)
+	re.NoError(d.Stop(ctx))
+	re.Equal(int32(1), inner.calls.Load())
+}
+
+// closeCountingDispatch counts the CloseShard dialed to the nodes.
+type closeCountingDispatch struct {
+	Dispatch
+
+	calls atomic.Int32
+}
+
+func (d *closeCountingDispatch) CloseShard(_ context.Context, _ string, _ CloseShardRequest) error {
+	d.calls.Add(1)
+	return nil
+}
@@ -0,0 +1,286 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventdispatch
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/id"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrShardCommandFailed  = coderr.NewCodeError(coderr.Internal, "shard command failed on node")
+	ErrShardCommandAborted = coderr.NewCodeError(coderr.Internal, "shard command aborted")
+)
+
+const (
+	defaultPiggybackAckTimeout = time.Minute
+	// defaultPiggybackNodeExpiry is a few heartbeats of the default interval, which is the lease of the nodes asking for
+	// no lease.
+	defaultPiggybackNodeExpiry = time.Second * 10
+)
+
+type ShardCommandType string
+
+const (
+	ShardCommandOpenShard  ShardCommandType = "openShard"
+	ShardCommandCloseShard ShardCommandType = "closeShard"
+)
+
+// ShardCommand is the shard operation delivered to the node by the heartbeat response instead of being dialed to it.
+type ShardCommand struct {
+	// ID is allocated from etcd, so it is unique across the leaders, and the node should apply the command of the same id
+	// only once because the command is delivered again by every heartbeat response until it is acked.
+	ID      uint64           `json:"id"`
+	Type    ShardCommandType `json:"type"`
+	ShardID uint32           `json:"shardID"`
+	// Role and Version describe the shard to open, and they are empty for the close.
+	Role    string `json:"role,omitempty"`
+	Version uint64 `json:"version,omitempty"`
+}
+
+// ShardCommandAck is reported by the node in the next heartbeat once the command is applied.
+type ShardCommandAck struct {
+	ID uint64 `json:"id"`
+	// Error is empty if the command succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+type pendingShardCommand struct {
+	command ShardCommand
+	done    chan error
+}
+
+type PiggybackConfig struct {
+	// AckTimeout is how long a command waits for the ack of the node.
+	AckTimeout time.Duration
+	// NodeExpiry is how long the node opting in is kept since its last heartbeat, and the commands pending on the expired
+	// node are aborted because they will never be delivered.
+	NodeExpiry time.Duration
+}
+
+func DefaultPiggybackConfig() PiggybackConfig {
+	return PiggybackConfig{
+		AckTimeout: defaultPiggybackAckTimeout,
+		NodeExpiry: defaultPiggybackNodeExpiry,
+	}
+}
+
+// PiggybackDispatch delivers the OpenShard and CloseShard of the nodes opting in by their heartbeats, which are the nodes
+// the leader can't dial, e.g. behind the NAT. The operation waits until the node acks the command in a later heartbeat,
+// and the other operations and the other nodes are passed through.
+type PiggybackDispatch struct {
+	Dispatch
+	cfg         PiggybackConfig
+	idAllocator id.Allocator
+	clock       clock.Clock
+
+	// Mutex is used to protect following fields.
+	lock    sync.Mutex
+	nodes   map[string]time.Time                       // addr -> last heartbeat of the node opting in
+	pending map[string]map[uint64]*pendingShardCommand // addr -> command id -> command
+}
+
+func NewPiggybackDispatch(dispatch Dispatch, idAllocator id.Allocator, clk clock.Clock, cfg PiggybackConfig) *PiggybackDispatch {
+	return &PiggybackDispatch{
+		Dispatch:    dispatch,
+		cfg:         cfg,
+		idAllocator: idAllocator,
+		clock:       clk,
+		lock:        sync.Mutex{},
+		nodes:       make(map[string]time.Time),
+		pending:     make(map[string]map[uint64]*pendingShardCommand),
+	}
+}
+
+// SetPiggyback records whether the node opts in to the piggybacked commands by its heartbeat, and the commands pending on
+// the node opting out are aborted because they will never be delivered.
+func (d *PiggybackDispatch) SetPiggyback(addr string, enabled bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if enabled {
+		d.nodes[addr] = d.clock.Now()
+		return
+	}
+	if _, ok := d.nodes[addr]; !ok {
+		return
+	}
+	d.removeNode(addr, ErrShardCommandAborted.WithCausef("node opts out of piggybacked commands, addr:%s", addr))
+}
+
+// RemoveExpiredNodes removes the nodes missing their heartbeats for the NodeExpiry, and the commands pending on them are
+// aborted.
+func (d *PiggybackDispatch) RemoveExpiredNodes(now time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for addr, lastHeartbeat := range d.nodes {
+		if now.Sub(lastHeartbeat) > d.cfg.NodeExpiry {
+			d.removeNode(addr, ErrShardCommandAborted.WithCausef("node expires, addr:%s, lastHeartbeat:%s", addr, lastHeartbeat))
+		}
+	}
+}
+
+// removeNode removes the node and aborts the commands pending on it with the err, and the lock must be held.
+func (d *PiggybackDispatch) removeNode(addr string, err error) {
+	delete(d.nodes, addr)
+	for _, pending := range d.pending[addr] {
+		pending.done <- err
+	}
+	delete(d.pending, addr)
+}
+
+// PendingCommands returns the commands not acked by the node in the order of their ids.
+func (d *PiggybackDispatch) PendingCommands(addr string) []ShardCommand {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	commands := make([]ShardCommand, 0, len(d.pending[addr]))
+	for _, pending := range d.pending[addr] {
+		commands = append(commands, pending.command)
+	}
+	sort.Slice(commands, func(i, j int) bool {
+		return commands[i].ID < commands[j].ID
+	})
+	return commands
+}
+
+// Ack completes the commands applied by the node, and the unknown ids, e.g. the ones issued by the former leader, are
+// ignored.
+func (d *PiggybackDispatch) Ack(addr string, acks []ShardCommandAck) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	for _, ack := range acks {
+		pending, ok := d.pending[addr][ack.ID]
+		if !ok {
+			continue
+		}
+		delete(d.pending[addr], ack.ID)
+		if len(ack.Error) > 0 {
+			pending.done <- ErrShardCommandFailed.WithCausef("addr:%s, command:%d, err:%s", addr, ack.ID, ack.Error)
+			continue
+		}
+		pending.done <- nil
+	}
+}
+
+func (d *PiggybackDispatch) OpenShard(ctx context.Context, addr string, request OpenShardRequest) error {
+	pending, ok, err := d.enqueue(ctx, addr, ShardCommand{
+		ID:      0,
+		Type:    ShardCommandOpenShard,
+		ShardID: uint32(request.Shard.ID),
+		Role:    storage.ConvertShardRoleToString(request.Shard.Role),
+		Version: request.Shard.Version,
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return d.Dispatch.OpenShard(ctx, addr, request)
+	}
+	return d.wait(ctx, addr, pending)
+}
+
+func (d *PiggybackDispatch) CloseShard(ctx context.Context, addr string, request CloseShardRequest) error {
+	pending, ok, err := d.enqueue(ctx, addr, ShardCommand{
+		ID:      0,
+		Type:    ShardCommandCloseShard,
+		ShardID: request.ShardID,
+		Role:    "",
+		Version: 0,
+	})
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return d.Dispatch.CloseShard(ctx, addr, request)
+	}
+	return d.wait(ctx, addr, pending)
+}
+
+// isPiggyback returns true if the node opts in to the piggybacked commands.
+func (d *PiggybackDispatch) isPiggyback(addr string) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	_, ok := d.nodes[addr]
+	return ok
+}
+
+// enqueue assigns the id to the command, the second output parameter bool: returns false if the node doesn't opt in, and
+// the command is not enqueued.
+func (d *PiggybackDispatch) enqueue(ctx context.Context, addr string, command ShardCommand) (*pendingShardCommand, bool, error) {
+	if !d.isPiggyback(addr) {
+		return nil, false, nil
+	}
+	commandID, err := d.idAllocator.Alloc(ctx)
+	if err != nil {
+		return nil, false, errors.WithMessage(err, "alloc shard command id")
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// The node may opt out while the id is allocated.
+	if _, ok := d.nodes[addr]; !ok {
+		return nil, false, nil
+	}
+	command.ID = commandID
+	pending := &pendingShardCommand{
+		command: command,
+		// The result is sent at most once, so it never blocks the sender.
+		done: make(chan error, 1),
+	}
+	if _, ok := d.pending[addr]; !ok {
+		d.pending[addr] = make(map[uint64]*pendingShardCommand)
+	}
+	d.pending[addr][pending.command.ID] = pending
+	return pending, true, nil
+}
+
+// wait for the ack of the command until the AckTimeout, and the command is withdrawn if the ctx is done first. The
+// command is aborted once the node expires, which is checked while waiting. The node may have applied the withdrawn
+// command already, which is corrected by the shard infos of its heartbeats like the other lost operations.
+func (d *PiggybackDispatch) wait(ctx context.Context, addr string, pending *pendingShardCommand) error {
+	ctx, cancel := context.WithTimeout(ctx, d.cfg.AckTimeout)
+	defer cancel()
+	ticker := time.NewTicker(d.cfg.NodeExpiry / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-pending.done:
+			return err
+		case <-ticker.C:
+			d.RemoveExpiredNodes(d.clock.Now())
+		case <-ctx.Done():
+			d.lock.Lock()
+			delete(d.pending[addr], pending.command.ID)
+			d.lock.Unlock()
+			return errors.WithMessagef(ctx.Err(), "wait for shard command ack, addr:%s, command:%d, type:%s", addr, pending.command.ID, pending.command.Type)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventdispatch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/id"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// countingDispatch counts the OpenShard dialed to the nodes.
+type countingDispatch struct {
+	Dispatch
+
+	openCount int
+}
+
+func (d *countingDispatch) OpenShard(_ context.Context, _ string, _ OpenShardRequest) error {
+	d.openCount++
+	return nil
+}
+
+func waitPendingCommands(re *require.Assertions, d *PiggybackDispatch, addr string, count int) []ShardCommand {
+	re.Eventually(func() bool {
+		return len(d.PendingCommands(addr)) == count
+	}, time.Second, time.Millisecond*10)
+	return d.PendingCommands(addr)
+}
+
+func TestPiggybackDispatch(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	inner := &countingDispatch{Dispatch: nil, openCount: 0}
+	d := NewPiggybackDispatch(inner, id.NewReusableAllocatorImpl([]uint64{}, 1), clock.NewRealClock(), DefaultPiggybackConfig())
+	request := OpenShardRequest{
+		Shard: metadata.ShardInfo{ID: 1, Role: storage.ShardRoleLeader, Version: 2, Status: storage.ShardStatusReady},
+	}
+
+	// The nodes not opting in are dialed.
+	re.NoError(d.OpenShard(ctx, "node0", request))
+	re.Equal(1, inner.openCount)
+
+	// The command to the node opting in waits for the ack.
+	d.SetPiggyback("node1", true)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- d.OpenShard(ctx, "node1", request)
+	}()
+	commands := waitPendingCommands(re, d, "node1", 1)
+	re.Equal(ShardCommandOpenShard, commands[0].Type)
+	re.Equal(uint32(1), commands[0].ShardID)
+	re.Equal(uint64(2), commands[0].Version)
+	// The unknown acks are ignored, and the command is delivered again until it is acked.
+	d.Ack("node1", []ShardCommandAck{{ID: commands[0].ID + 1, Error: ""}})
+	re.Equal(commands, d.PendingCommands("node1"))
+	d.Ack("node1", []ShardCommandAck{{ID: commands[0].ID, Error: ""}})
+	re.NoError(<-errs)
+	re.Empty(d.PendingCommands("node1"))
+	re.Equal(1, inner.openCount)
+
+	// The failure reported by the node is returned.
+	go func() {
+		errs <- d.CloseShard(ctx, "node1", CloseShardRequest{ShardID: 1})
+	}()
+	commands = waitPendingCommands(re, d, "node1", 1)
+	re.Equal(ShardCommandCloseShard, commands[0].Type)
+	d.Ack("node1", []ShardCommandAck{{ID: commands[0].ID, Error: "shard not found"}})
+	re.ErrorContains(<-errs, "shard not found")
+
+	// The pending commands are aborted once the node opts out.
+	go func() {
+		errs <- d.OpenShard(ctx, "node1", request)
+	}()
+	waitPendingCommands(re, d, "node1", 1)
+	d.SetPiggyback("node1", false)
+	re.ErrorContains(<-errs, "opts out")
+
+	// The command is withdrawn if the caller stops waiting.
+	d.SetPiggyback("node1", true)
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond*10)
+	defer cancel()
+	re.Error(d.OpenShard(timeoutCtx, "node1", request))
+	re.Empty(d.PendingCommands("node1"))
+}
+
+func TestPiggybackDispatchNodeExpiry(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	d := NewPiggybackDispatch(&countingDispatch{Dispatch: nil, openCount: 0}, id.NewReusableAllocatorImpl([]uint64{}, 1), clock.NewRealClock(), PiggybackConfig{
+		AckTimeout: time.Minute,
+		NodeExpiry: time.Millisecond * 100,
+	})
+	request := OpenShardRequest{
+		Shard: metadata.ShardInfo{ID: 1, Role: storage.ShardRoleLeader, Version: 2, Status: storage.ShardStatusReady},
+	}
+
+	// The command is aborted once the node misses its heartbeats, and the node is not opting in any more.
+	d.SetPiggyback("node1", true)
+	start := time.Now()
+	err := d.OpenShard(ctx, "node1", request)
+	re.ErrorContains(err, "node expires")
+	re.Less(time.Since(start), time.Second*5)
+	re.Empty(d.PendingCommands("node1"))
+	_, ok, err := d.enqueue(ctx, "node1", ShardCommand{ID: 0, Type: ShardCommandCloseShard, ShardID: 1, Role: "", Version: 0})
+	re.NoError(err)
+	re.False(ok)
+
+	// The node keeping its heartbeats is not removed.
+	d.SetPiggyback("node2", true)
+	d.RemoveExpiredNodes(time.Now())
+	_, ok, err = d.enqueue(ctx, "node2", ShardCommand{ID: 0, Type: ShardCommandCloseShard, ShardID: 1, Role: "", Version: 0})
+	re.NoError(err)
+	re.True(ok)
+}
+
+func TestPiggybackDispatchAckTimeout(t *testing.T) {
+	re := require.New(t)
+
+	d := NewPiggybackDispatch(&countingDispatch{Dispatch: nil, openCount: 0}, id.NewReusableAllocatorImpl([]uint64{}, 1), clock.NewRealClock(), PiggybackConfig{
+		AckTimeout: time.Millisecond * 50,
+		NodeExpiry: time.Minute,
+	})
+
+	// The command is withdrawn if it is not acked in time even if the caller keeps waiting.
+	d.SetPiggyback("node1", true)
+	err := d.CloseShard(context.Background(), "node1", CloseShardRequest{ShardID: 1})
+	re.ErrorIs(err, context.DeadlineExceeded)
+	re.Empty(d.PendingCommands("node1"))
+}
+
+func TestPiggybackDispatchAcrossLeaders(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	// The dispatches of the former leader and the new one share the id allocator, just like the one backed by etcd.
+	idAllocator := id.NewReusableAllocatorImpl([]uint64{}, 1)
+	clk := clock.NewMock(time.Now())
+	cfg := PiggybackConfig{AckTimeout: time.Minute, NodeExpiry: time.Second * 10}
+	command := ShardCommand{ID: 0, Type: ShardCommandCloseShard, ShardID: 1, Role: "", Version: 0}
+
+	former := NewPiggybackDispatch(&countingDispatch{Dispatch: nil, openCount: 0}, idAllocator, clk, cfg)
+	former.SetPiggyback("node1", true)
+	formerPending, ok, err := former.enqueue(ctx, "node1", command)
+	re.NoError(err)
+	re.True(ok)
+
+	// The command of the new leader is never taken as the one applied by the node.
+	current := NewPiggybackDispatch(&countingDispatch{Dispatch: nil, openCount: 0}, idAllocator, clk, cfg)
+	current.SetPiggyback("node1", true)
+	currentPending, ok, err := current.enqueue(ctx, "node1", command)
+	re.NoError(err)
+	re.True(ok)
+	re.Greater(currentPending.command.ID, formerPending.command.ID)
+
+	// The heartbeats are tracked by the clock of the cluster.
+	clk.Advance(cfg.NodeExpiry)
+	current.RemoveExpiredNodes(clk.Now())
+	re.True(current.isPiggyback("node1"))
+	clk.Advance(time.Second)
+	current.RemoveExpiredNodes(clk.Now())
+	re.False(current.isPiggyback("node1"))
+}
@@ -0,0 +1,169 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventdispatch
+
+import (
+	"context"
+	"sync"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/pkg/errors"
+	"golang.org/x/time/rate"
+)
+
+var ErrInvalidThrottleConfig = coderr.NewCodeError(coderr.InvalidParams, "invalid shard operation throttle config")
+
+const defaultMaxConcurrencyPerNode = 16
+
+// ShardOperationThrottleConfig limits the open and close shard operations dispatched to a node, so that a restarted node
+// is not overwhelmed by opening all its shards at once.
+type ShardOperationThrottleConfig struct {
+	// MaxConcurrencyPerNode is the max number of the shard operations in flight on a node, and zero means no limit.
+	MaxConcurrencyPerNode uint32 `json:"maxConcurrencyPerNode"`
+	// RatePerNode is the number of the shard operations started on a node per second, and zero means no limit.
+	RatePerNode float64 `json:"ratePerNode"`
+	// BurstPerNode is the number of the shard operations allowed to start at once if RatePerNode is set.
+	BurstPerNode uint32 `json:"burstPerNode"`
+}
+
+func DefaultShardOperationThrottleConfig() ShardOperationThrottleConfig {
+	return ShardOperationThrottleConfig{
+		MaxConcurrencyPerNode: defaultMaxConcurrencyPerNode,
+		RatePerNode:           0,
+		BurstPerNode:          0,
+	}
+}
+
+func (c ShardOperationThrottleConfig) Validate() error {
+	if c.RatePerNode < 0 {
+		return ErrInvalidThrottleConfig.WithCausef("rate should not be negative, ratePerNode:%f", c.RatePerNode)
+	}
+	if c.RatePerNode > 0 && c.BurstPerNode == 0 {
+		return ErrInvalidThrottleConfig.WithCausef("burst should be positive if rate is set, ratePerNode:%f", c.RatePerNode)
+	}
+	return nil
+}
+
+// nodeThrottle is replaced as a whole when the config is updated, and the operations in flight release the slots of the
+// throttle they acquired.
+type nodeThrottle struct {
+	slots   chan struct{}
+	limiter *rate.Limiter
+}
+
+func newNodeThrottle(config ShardOperationThrottleConfig) *nodeThrottle {
+	var slots chan struct{}
+	if config.MaxConcurrencyPerNode > 0 {
+		slots = make(chan struct{}, config.MaxConcurrencyPerNode)
+	}
+	var limiter *rate.Limiter
+	if config.RatePerNode > 0 {
+		limiter = rate.NewLimiter(rate.Limit(config.RatePerNode), int(config.BurstPerNode))
+	}
+	return &nodeThrottle{
+		slots:   slots,
+		limiter: limiter,
+	}
+}
+
+// acquire waits until the operation is allowed to start, and the returned function must be called when it finishes.
+func (t *nodeThrottle) acquire(ctx context.Context) (func(), error) {
+	if t.limiter != nil {
+		if err := t.limiter.Wait(ctx); err != nil {
+			return nil, errors.WithMessage(err, "wait for shard operation rate limiter")
+		}
+	}
+	if t.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case t.slots <- struct{}{}:
+		return func() { <-t.slots }, nil
+	case <-ctx.Done():
+		return nil, errors.WithMessage(ctx.Err(), "wait for shard operation slot")
+	}
+}
+
+// ThrottledDispatch throttles the OpenShard and CloseShard per node address, and the other operations are passed through.
+type ThrottledDispatch struct {
+	Dispatch
+
+	// RWMutex is used to protect following fields.
+	lock   sync.RWMutex
+	config ShardOperationThrottleConfig
+	nodes  map[string]*nodeThrottle // addr -> throttle
+}
+
+func NewThrottledDispatch(dispatch Dispatch) *ThrottledDispatch {
+	return &ThrottledDispatch{
+		Dispatch: dispatch,
+		lock:     sync.RWMutex{},
+		config:   DefaultShardOperationThrottleConfig(),
+		nodes:    make(map[string]*nodeThrottle),
+	}
+}
+
+func (d *ThrottledDispatch) UpdateThrottleConfig(config ShardOperationThrottleConfig) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if d.config == config {
+		return
+	}
+	d.config = config
+	d.nodes = make(map[string]*nodeThrottle)
+}
+
+func (d *ThrottledDispatch) getNodeThrottle(addr string) *nodeThrottle {
+	d.lock.RLock()
+	throttle, ok := d.nodes[addr]
+	d.lock.RUnlock()
+	if ok {
+		return throttle
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	throttle, ok = d.nodes[addr]
+	if !ok {
+		throttle = newNodeThrottle(d.config)
+		d.nodes[addr] = throttle
+	}
+	return throttle
+}
+
+func (d *ThrottledDispatch) OpenShard(ctx context.Context, addr string, request OpenShardRequest) error {
+	release, err := d.getNodeThrottle(addr).acquire(ctx)
+	if err != nil {
+		return errors.WithMessagef(err, "throttle open shard, addr:%s, shardID:%d", addr, request.Shard.ID)
+	}
+	defer release()
+
+	return d.Dispatch.OpenShard(ctx, addr, request)
+}
+
+func (d *ThrottledDispatch) CloseShard(ctx context.Context, addr string, request CloseShardRequest) error {
+	release, err := d.getNodeThrottle(addr).acquire(ctx)
+	if err != nil {
+		return errors.WithMessagef(err, "throttle close shard, addr:%s, shardID:%d", addr, request.ShardID)
+	}
+	defer release()
+
+	return d.Dispatch.CloseShard(ctx, addr, request)
+}
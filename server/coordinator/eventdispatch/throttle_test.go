@@ -0,0 +1,108 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package eventdispatch
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+// blockingDispatch blocks the OpenShard until it is released, and records the max number of the concurrent calls.
+type blockingDispatch struct {
+	Dispatch
+
+	release       chan struct{}
+	running       atomic.Int32
+	maxConcurrent atomic.Int32
+}
+
+func (d *blockingDispatch) OpenShard(_ context.Context, _ string, _ OpenShardRequest) error {
+	running := d.running.Add(1)
+	for {
+		maxConcurrent := d.maxConcurrent.Load()
+		if running <= maxConcurrent || d.maxConcurrent.CompareAndSwap(maxConcurrent, running) {
+			break
+		}
+	}
+	<-d.release
+	d.running.Add(-1)
+	return nil
+}
+
+func TestThrottledDispatchConcurrency(t *testing.T) {
+	re := require.New(t)
+
+	inner := &blockingDispatch{
+		Dispatch:      nil,
+		release:       make(chan struct{}),
+		running:       atomic.Int32{},
+		maxConcurrent: atomic.Int32{},
+	}
+	d := NewThrottledDispatch(inner)
+	d.UpdateThrottleConfig(ShardOperationThrottleConfig{MaxConcurrencyPerNode: 2, RatePerNode: 0, BurstPerNode: 0})
+
+	request := OpenShardRequest{
+		Shard: metadata.ShardInfo{ID: 0, Role: storage.ShardRoleLeader, Version: 0, Status: storage.ShardStatusReady},
+	}
+	var wg sync.WaitGroup
+	errs := make(chan error, 6)
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- d.OpenShard(context.Background(), "node0", request)
+		}()
+	}
+	// The operations beyond the max concurrency wait until the running ones are released.
+	re.Eventually(func() bool { return inner.running.Load() == 2 }, time.Second, time.Millisecond)
+	for i := 0; i < 6; i++ {
+		inner.release <- struct{}{}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		re.NoError(err)
+	}
+	re.Equal(int32(2), inner.maxConcurrent.Load())
+
+	// The waiting operation is given up when the context is done.
+	d.UpdateThrottleConfig(ShardOperationThrottleConfig{MaxConcurrencyPerNode: 1, RatePerNode: 0, BurstPerNode: 0})
+	go func() {
+		_ = d.OpenShard(context.Background(), "node0", request)
+	}()
+	re.Eventually(func() bool { return inner.running.Load() == 1 }, time.Second, time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	re.Error(d.OpenShard(ctx, "node0", request))
+	inner.release <- struct{}{}
+}
+
+func TestShardOperationThrottleConfigValidate(t *testing.T) {
+	re := require.New(t)
+
+	re.NoError(DefaultShardOperationThrottleConfig().Validate())
+	re.NoError(ShardOperationThrottleConfig{MaxConcurrencyPerNode: 0, RatePerNode: 5, BurstPerNode: 1}.Validate())
+	re.Error(ShardOperationThrottleConfig{MaxConcurrencyPerNode: 0, RatePerNode: -1, BurstPerNode: 1}.Validate())
+	re.Error(ShardOperationThrottleConfig{MaxConcurrencyPerNode: 0, RatePerNode: 5, BurstPerNode: 0}.Validate())
+}
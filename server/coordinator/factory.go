@@ -18,34 +18,43 @@ package coordinator
 
 import (
 	"context"
+	"maps"
 
 	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
-	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl/batchcreatetable"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl/batchdroptable"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl/createpartitiontable"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl/createtable"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl/droppartitiontable"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl/droptable"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/failover"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/migratetopology"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/rebalancepartitiontable"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/repair"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/reshard"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/split"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/transferleader"
-	"github.com/CeresDB/horaemeta/server/id"
 	"github.com/CeresDB/horaemeta/server/storage"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
 )
 
+// resumeProcedureListBatchSize is the batch size of listing the persisted procedures to be resumed.
+const resumeProcedureListBatchSize = 100
+
 type Factory struct {
-	logger      *zap.Logger
-	idAllocator id.Allocator
-	dispatch    eventdispatch.Dispatch
-	storage     procedure.Storage
-	shardPicker ShardPicker
+	logger *zap.Logger
+	deps   Dependencies
 }
 
 type CreateTableRequest struct {
 	ClusterMetadata *metadata.ClusterMetadata
 	SourceReq       *metaservicepb.CreateTableRequest
+	// TargetShardID places the table on the shard instead of the one picked by the shard picker if it is not nil, and
+	// it is not supported by the partition table.
+	TargetShardID *storage.ShardID
 
 	OnSucceeded func(metadata.CreateTableResult) error
 	OnFailed    func(error) error
@@ -68,6 +77,21 @@ func (d DropTableRequest) IsPartitionTable() bool {
 	return d.SourceReq.PartitionTableInfo != nil
 }
 
+type BatchDropTableRequest struct {
+	ClusterMetadata *metadata.ClusterMetadata
+	SchemaName      string
+	Tables          []storage.Table
+}
+
+type BatchCreateTableRequest struct {
+	ClusterMetadata *metadata.ClusterMetadata
+	SchemaName      string
+	// SourceReqs are the tables to create in the schema, and the partition tables are not supported.
+	SourceReqs []*metaservicepb.CreateTableRequest
+
+	OnFinished func([]batchcreatetable.TableResult) error
+}
+
 type TransferLeaderRequest struct {
 	Snapshot          metadata.Snapshot
 	ShardID           storage.ShardID
@@ -75,6 +99,14 @@ type TransferLeaderRequest struct {
 	NewLeaderNodeName string
 }
 
+type FailoverRequest struct {
+	ClusterMetadata   *metadata.ClusterMetadata
+	Snapshot          metadata.Snapshot
+	ShardID           storage.ShardID
+	OldLeaderNodeName string
+	NewLeaderNodeName string
+}
+
 type SplitRequest struct {
 	ClusterMetadata *metadata.ClusterMetadata
 	SchemaName      string
@@ -85,6 +117,15 @@ type SplitRequest struct {
 	TargetNodeName  string
 }
 
+type RebalancePartitionTableRequest struct {
+	ClusterMetadata *metadata.ClusterMetadata
+	SchemaName      string
+	TableName       string
+	SubTableNames   []string
+	// SkewThreshold is the max difference allowed between the numbers of the sub tables on the shards.
+	SkewThreshold uint32
+}
+
 type CreatePartitionTableRequest struct {
 	ClusterMetadata *metadata.ClusterMetadata
 	SourceReq       *metaservicepb.CreateTableRequest
@@ -93,61 +134,174 @@ type CreatePartitionTableRequest struct {
 	OnFailed    func(error) error
 }
 
+type RepairShardsRequest struct {
+	ClusterMetadata *metadata.ClusterMetadata
+	Snapshot        metadata.Snapshot
+	Actions         []repair.Action
+	// Confirmation is required to repair the shards of the protected cluster.
+	Confirmation metadata.Confirmation
+
+	OnFinished func([]repair.ActionResult) error
+}
+
+type MigrateTopologyRequest struct {
+	ClusterMetadata *metadata.ClusterMetadata
+	Snapshot        metadata.Snapshot
+	TopologyType    storage.TopologyType
+	// Confirmation is required to migrate the topology of the protected cluster.
+	Confirmation metadata.Confirmation
+
+	OnSwitched func(context.Context, storage.TopologyType) error
+}
+
+type ReshardRequest struct {
+	ClusterMetadata *metadata.ClusterMetadata
+	Snapshot        metadata.Snapshot
+	ShardTotal      uint32
+	// Confirmation is required to shrink the shard total of the protected cluster.
+	Confirmation metadata.Confirmation
+}
+
 type BatchRequest struct {
 	Batch     []procedure.Procedure
 	BatchType procedure.Kind
 }
 
-func NewFactory(logger *zap.Logger, allocator id.Allocator, dispatch eventdispatch.Dispatch, storage procedure.Storage) *Factory {
+func NewFactory(logger *zap.Logger, deps Dependencies) *Factory {
 	return &Factory{
-		idAllocator: allocator,
-		dispatch:    dispatch,
-		storage:     storage,
-		logger:      logger,
-		shardPicker: NewLeastTableShardPicker(),
+		logger: logger,
+		deps:   deps,
 	}
 }
 
 func (f *Factory) MakeCreateTableProcedure(ctx context.Context, request CreateTableRequest) (procedure.Procedure, error) {
+	schemaName := request.SourceReq.GetSchemaName()
+	newTableCount := 1
+	if request.isPartitionTable() {
+		// The sub tables are counted besides the partition table itself.
+		newTableCount += len(request.SourceReq.PartitionTableInfo.GetSubTableNames())
+	}
+	if err := request.ClusterMetadata.CheckTableQuota(schemaName, newTableCount); err != nil {
+		return nil, err
+	}
+
+	if err := f.prepareTableOptions(ctx, request.ClusterMetadata, request.SourceReq); err != nil {
+		return nil, err
+	}
+
 	isPartitionTable := request.isPartitionTable()
 
 	if isPartitionTable {
-		req := CreatePartitionTableRequest(request)
-		return f.makeCreatePartitionTableProcedure(ctx, req)
+		if request.TargetShardID != nil {
+			return nil, errors.WithMessagef(ErrTargetShardNotSupported, "partition table:%s", request.SourceReq.GetName())
+		}
+		return f.makeCreatePartitionTableProcedure(ctx, CreatePartitionTableRequest{
+			ClusterMetadata: request.ClusterMetadata,
+			SourceReq:       request.SourceReq,
+			OnSucceeded:     request.OnSucceeded,
+			OnFailed:        request.OnFailed,
+		})
 	}
 
 	return f.makeCreateTableProcedure(ctx, request)
 }
 
 func (f *Factory) makeCreateTableProcedure(ctx context.Context, request CreateTableRequest) (procedure.Procedure, error) {
+	snapshot := request.ClusterMetadata.GetClusterSnapshot()
+	if err := procedure.ValidateParams(procedure.CreateTable, snapshot, procedure.Params{
+		"schemaName": request.SourceReq.GetSchemaName(),
+		"tableName":  request.SourceReq.GetName(),
+	}); err != nil {
+		return nil, err
+	}
+
 	id, err := f.allocProcedureID(ctx)
 	if err != nil {
 		return nil, err
 	}
-	snapshot := request.ClusterMetadata.GetClusterSnapshot()
 
-	shards, err := f.shardPicker.PickShards(ctx, snapshot, 1)
+	shardPicker := f.shardPicker(request.ClusterMetadata, request.SourceReq.GetSchemaName())
+	shardID, err := f.pickTableShard(ctx, snapshot, shardPicker, request.TargetShardID)
 	if err != nil {
-		f.logger.Error("pick table shard", zap.Error(err))
-		return nil, errors.WithMessage(err, "pick table shard")
-	}
-	if len(shards) != 1 {
-		f.logger.Error("pick table shards length not equal 1", zap.Int("shards", len(shards)))
-		return nil, errors.WithMessagef(procedure.ErrPickShard, "pick table shard, shards length:%d", len(shards))
+		return nil, err
 	}
 
 	return createtable.NewProcedure(createtable.ProcedureParams{
-		Dispatch:        f.dispatch,
+		Dispatch:        f.deps.Dispatch,
 		ClusterMetadata: request.ClusterMetadata,
 		ClusterSnapshot: snapshot,
 		ID:              id,
-		ShardID:         shards[0].ID,
+		ShardID:         shardID,
 		SourceReq:       request.SourceReq,
 		OnSucceeded:     request.OnSucceeded,
 		OnFailed:        request.OnFailed,
 	})
 }
 
+// prepareTableOptions merges the default table options of the schema into the request, where the options given in the
+// request win, and then applies the options decided by the admission hook.
+func (f *Factory) prepareTableOptions(ctx context.Context, clusterMetadata *metadata.ClusterMetadata, sourceReq *metaservicepb.CreateTableRequest) error {
+	if settings, ok := clusterMetadata.GetSchemaSettings(sourceReq.GetSchemaName()); ok && len(settings.DefaultTableOptions) > 0 {
+		options := maps.Clone(settings.DefaultTableOptions)
+		maps.Copy(options, sourceReq.GetOptions())
+		sourceReq.Options = options
+	}
+
+	options, err := f.admit(ctx, AdmissionRequest{
+		ClusterName: clusterMetadata.Name(),
+		Operation:   AdmissionOperationCreateTable,
+		SchemaName:  sourceReq.GetSchemaName(),
+		TableName:   sourceReq.GetName(),
+		Options:     sourceReq.GetOptions(),
+	})
+	if err != nil {
+		return err
+	}
+	sourceReq.Options = options
+	return nil
+}
+
+// shardPicker returns the shard picker for the tables of the schema by its shard picking policy.
+func (f *Factory) shardPicker(clusterMetadata *metadata.ClusterMetadata, schemaName string) ShardPicker {
+	settings, _ := clusterMetadata.GetSchemaSettings(schemaName)
+	switch settings.ShardPickingPolicy {
+	case storage.ShardPickingPolicyLeastTable:
+		return NewLeastTableShardPicker()
+	case storage.ShardPickingPolicyRandom:
+		return NewRandomShardPicker()
+	case storage.ShardPickingPolicyDefault:
+		return f.deps.ShardPicker
+	}
+	return f.deps.ShardPicker
+}
+
+// pickTableShard returns the target shard if it is specified, otherwise the shard is picked by the shard picker. The
+// target shard must exist and have a leader, which the table is created on.
+func (f *Factory) pickTableShard(ctx context.Context, snapshot metadata.Snapshot, shardPicker ShardPicker, targetShardID *storage.ShardID) (storage.ShardID, error) {
+	if targetShardID != nil {
+		if _, exists := snapshot.Topology.ShardViewsMapping[*targetShardID]; !exists {
+			return 0, errors.WithMessagef(metadata.ErrShardNotFound, "target shard not found, shardID:%d", *targetShardID)
+		}
+		for _, shardNode := range snapshot.Topology.ClusterView.ShardNodes {
+			if shardNode.ID == *targetShardID && shardNode.ShardRole == storage.ShardRoleLeader {
+				return *targetShardID, nil
+			}
+		}
+		return 0, errors.WithMessagef(procedure.ErrShardLeaderNotFound, "target shard has no leader, shardID:%d", *targetShardID)
+	}
+
+	shards, err := shardPicker.PickShards(ctx, snapshot, 1)
+	if err != nil {
+		f.logger.Error("pick table shard", zap.Error(err))
+		return 0, errors.WithMessage(err, "pick table shard")
+	}
+	if len(shards) != 1 {
+		f.logger.Error("pick table shards length not equal 1", zap.Int("shards", len(shards)))
+		return 0, errors.WithMessagef(procedure.ErrPickShard, "pick table shard, shards length:%d", len(shards))
+	}
+	return shards[0].ID, nil
+}
+
 func (f *Factory) makeCreatePartitionTableProcedure(ctx context.Context, request CreatePartitionTableRequest) (procedure.Procedure, error) {
 	id, err := f.allocProcedureID(ctx)
 	if err != nil {
@@ -161,7 +315,8 @@ func (f *Factory) makeCreatePartitionTableProcedure(ctx context.Context, request
 		nodeNames[shardNode.NodeName] = 1
 	}
 
-	subTableShards, err := f.shardPicker.PickShards(ctx, snapshot, len(request.SourceReq.PartitionTableInfo.SubTableNames))
+	shardPicker := f.shardPicker(request.ClusterMetadata, request.SourceReq.GetSchemaName())
+	subTableShards, err := shardPicker.PickShards(ctx, snapshot, len(request.SourceReq.PartitionTableInfo.SubTableNames))
 	if err != nil {
 		return nil, errors.WithMessage(err, "pick sub table shards")
 	}
@@ -187,8 +342,8 @@ func (f *Factory) makeCreatePartitionTableProcedure(ctx context.Context, request
 		ID:              id,
 		ClusterMetadata: request.ClusterMetadata,
 		ClusterSnapshot: snapshot,
-		Dispatch:        f.dispatch,
-		Storage:         f.storage,
+		Dispatch:        f.deps.Dispatch,
+		Storage:         f.deps.Storage,
 		SourceReq:       request.SourceReq,
 		SubTablesShards: shardNodesWithVersion,
 		OnSucceeded:     request.OnSucceeded,
@@ -196,25 +351,90 @@ func (f *Factory) makeCreatePartitionTableProcedure(ctx context.Context, request
 	})
 }
 
+// ResumeCreatePartitionTableProcedures rebuilds the create partition table procedures left unfinished by the previous
+// leader, which complete the sub tables not created yet or roll back all of them once submitted.
+//
+// The procedures failed to be decoded are skipped, because there is nothing to resume for them.
+func (f *Factory) ResumeCreatePartitionTableProcedures(ctx context.Context, clusterMetadata *metadata.ClusterMetadata) ([]procedure.Procedure, error) {
+	metas, err := f.deps.Storage.List(ctx, procedure.CreatePartitionTable, resumeProcedureListBatchSize)
+	if err != nil {
+		return nil, errors.WithMessage(err, "list create partition table procedures")
+	}
+
+	procedures := make([]procedure.Procedure, 0, len(metas))
+	for _, meta := range metas {
+		if meta.State != procedure.StateInit && meta.State != procedure.StateRunning {
+			continue
+		}
+
+		procedureID := meta.ID
+		params, err := createpartitiontable.ParamsFromMeta(createpartitiontable.ProcedureParams{
+			ID:              0,
+			ClusterMetadata: clusterMetadata,
+			ClusterSnapshot: clusterMetadata.GetClusterSnapshot(),
+			Dispatch:        f.deps.Dispatch,
+			Storage:         f.deps.Storage,
+			SourceReq:       nil,
+			SubTablesShards: nil,
+			// The client issuing the request is gone with the previous leader, so the result is only logged.
+			OnSucceeded: func(result metadata.CreateTableResult) error {
+				f.logger.Info("resumed create partition table procedure succeeded", zap.Uint64("procedureID", procedureID), zap.String("tableName", result.Table.Name))
+				return nil
+			},
+			OnFailed: func(err error) error {
+				f.logger.Error("resumed create partition table procedure failed", zap.Uint64("procedureID", procedureID), zap.Error(err))
+				return nil
+			},
+		}, *meta)
+		if err != nil {
+			f.logger.Warn("skip the create partition table procedure failed to be decoded", zap.Uint64("procedureID", procedureID), zap.Error(err))
+			continue
+		}
+		p, err := createpartitiontable.NewProcedureFromMeta(params, *meta)
+		if err != nil {
+			f.logger.Warn("skip the create partition table procedure failed to be resumed", zap.Uint64("procedureID", procedureID), zap.Error(err))
+			continue
+		}
+		procedures = append(procedures, p)
+	}
+	return procedures, nil
+}
+
 // CreateDropTableProcedure creates a procedure to do drop table.
 //
 // And if no error is thrown, the returned boolean value is used to tell whether the procedure is created.
 // In some cases, e.g. the table doesn't exist, it should not be an error and false will be returned.
 func (f *Factory) CreateDropTableProcedure(ctx context.Context, request DropTableRequest) (procedure.Procedure, bool, error) {
-	id, err := f.allocProcedureID(ctx)
-	if err != nil {
+	if _, err := f.admit(ctx, AdmissionRequest{
+		ClusterName: request.ClusterMetadata.Name(),
+		Operation:   AdmissionOperationDropTable,
+		SchemaName:  request.SourceReq.GetSchemaName(),
+		TableName:   request.SourceReq.GetName(),
+		Options:     nil,
+	}); err != nil {
 		return nil, false, err
 	}
 
 	snapshot := request.ClusterMetadata.GetClusterSnapshot()
+	if err := procedure.ValidateParams(procedure.DropTable, snapshot, procedure.Params{
+		"schemaName": request.SourceReq.GetSchemaName(),
+		"tableName":  request.SourceReq.GetName(),
+	}); err != nil {
+		return nil, false, err
+	}
+
+	id, err := f.allocProcedureID(ctx)
+	if err != nil {
+		return nil, false, err
+	}
 
 	if request.IsPartitionTable() {
 		return droppartitiontable.NewProcedure(droppartitiontable.ProcedureParams{
 			ID:              id,
 			ClusterMetadata: request.ClusterMetadata,
 			ClusterSnapshot: request.ClusterSnapshot,
-			Dispatch:        f.dispatch,
-			Storage:         f.storage,
+			Dispatch:        f.deps.Dispatch,
+			Storage:         f.deps.Storage,
 			SourceReq:       request.SourceReq,
 			OnSucceeded:     request.OnSucceeded,
 			OnFailed:        request.OnFailed,
@@ -223,7 +443,7 @@ func (f *Factory) CreateDropTableProcedure(ctx context.Context, request DropTabl
 
 	return droptable.NewDropTableProcedure(droptable.ProcedureParams{
 		ID:              id,
-		Dispatch:        f.dispatch,
+		Dispatch:        f.deps.Dispatch,
 		ClusterMetadata: request.ClusterMetadata,
 		ClusterSnapshot: snapshot,
 		SourceReq:       request.SourceReq,
@@ -232,7 +452,112 @@ func (f *Factory) CreateDropTableProcedure(ctx context.Context, request DropTabl
 	})
 }
 
+func (f *Factory) CreateBatchDropTableProcedure(ctx context.Context, request BatchDropTableRequest) (procedure.Procedure, error) {
+	snapshot := request.ClusterMetadata.GetClusterSnapshot()
+	tableNames := make([]string, 0, len(request.Tables))
+	for _, table := range request.Tables {
+		tableNames = append(tableNames, table.Name)
+	}
+	if err := procedure.ValidateParams(procedure.BatchDropTable, snapshot, procedure.Params{
+		"schemaName": request.SchemaName,
+		"tableNames": tableNames,
+	}); err != nil {
+		return nil, err
+	}
+	// Every table is admitted as it is dropped alone, and the whole batch is rejected if any of them is denied.
+	for _, tableName := range tableNames {
+		if _, err := f.admit(ctx, AdmissionRequest{
+			ClusterName: request.ClusterMetadata.Name(),
+			Operation:   AdmissionOperationDropTable,
+			SchemaName:  request.SchemaName,
+			TableName:   tableName,
+			Options:     nil,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := f.allocProcedureID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return batchdroptable.NewProcedure(batchdroptable.ProcedureParams{
+		ID:              id,
+		Dispatch:        f.deps.Dispatch,
+		ClusterMetadata: request.ClusterMetadata,
+		ClusterSnapshot: snapshot,
+		SchemaName:      request.SchemaName,
+		Tables:          request.Tables,
+	})
+}
+
+// CreateBatchCreateTableProcedure creates the procedure to create the tables in the schema at once, and the tables are
+// spread over the shards by the shard picker of the schema.
+func (f *Factory) CreateBatchCreateTableProcedure(ctx context.Context, request BatchCreateTableRequest) (procedure.Procedure, error) {
+	snapshot := request.ClusterMetadata.GetClusterSnapshot()
+	tableNames := make([]string, 0, len(request.SourceReqs))
+	for _, sourceReq := range request.SourceReqs {
+		tableNames = append(tableNames, sourceReq.GetName())
+	}
+	if err := procedure.ValidateParams(procedure.BatchCreateTable, snapshot, procedure.Params{
+		"schemaName": request.SchemaName,
+		"tableNames": tableNames,
+	}); err != nil {
+		return nil, err
+	}
+	if err := request.ClusterMetadata.CheckTableQuota(request.SchemaName, len(request.SourceReqs)); err != nil {
+		return nil, err
+	}
+
+	for _, sourceReq := range request.SourceReqs {
+		if sourceReq.PartitionTableInfo != nil {
+			return nil, errors.WithMessagef(procedure.ErrBatchCreatePartitionTable, "table:%s", sourceReq.GetName())
+		}
+		sourceReq.SchemaName = request.SchemaName
+		if err := f.prepareTableOptions(ctx, request.ClusterMetadata, sourceReq); err != nil {
+			return nil, err
+		}
+	}
+
+	shardNodes, err := f.shardPicker(request.ClusterMetadata, request.SchemaName).PickShards(ctx, snapshot, len(request.SourceReqs))
+	if err != nil {
+		return nil, errors.WithMessage(err, "pick table shards")
+	}
+	if len(shardNodes) != len(request.SourceReqs) {
+		return nil, errors.WithMessagef(procedure.ErrPickShard, "pick table shards, expect:%d, shards:%d", len(request.SourceReqs), len(shardNodes))
+	}
+	shardTables := make(map[storage.ShardID][]*metaservicepb.CreateTableRequest)
+	for i, sourceReq := range request.SourceReqs {
+		shardID := shardNodes[i].ID
+		shardTables[shardID] = append(shardTables[shardID], sourceReq)
+	}
+
+	id, err := f.allocProcedureID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return batchcreatetable.NewProcedure(batchcreatetable.ProcedureParams{
+		ID:              id,
+		Dispatch:        f.deps.Dispatch,
+		ClusterMetadata: request.ClusterMetadata,
+		ClusterSnapshot: snapshot,
+		SchemaName:      request.SchemaName,
+		ShardTables:     shardTables,
+		OnFinished:      request.OnFinished,
+	})
+}
+
 func (f *Factory) CreateTransferLeaderProcedure(ctx context.Context, request TransferLeaderRequest) (procedure.Procedure, error) {
+	if err := procedure.ValidateParams(procedure.TransferLeader, request.Snapshot, procedure.Params{
+		"shardID":           request.ShardID,
+		"oldLeaderNodeName": request.OldLeaderNodeName,
+		"newLeaderNodeName": request.NewLeaderNodeName,
+	}); err != nil {
+		return nil, err
+	}
+
 	id, err := f.allocProcedureID(ctx)
 	if err != nil {
 		return nil, err
@@ -240,8 +565,34 @@ func (f *Factory) CreateTransferLeaderProcedure(ctx context.Context, request Tra
 
 	return transferleader.NewProcedure(transferleader.ProcedureParams{
 		ID:                id,
-		Dispatch:          f.dispatch,
-		Storage:           f.storage,
+		Dispatch:          f.deps.Dispatch,
+		Storage:           f.deps.Storage,
+		Clock:             f.deps.Clock,
+		ClusterSnapshot:   request.Snapshot,
+		ShardID:           request.ShardID,
+		OldLeaderNodeName: request.OldLeaderNodeName,
+		NewLeaderNodeName: request.NewLeaderNodeName,
+	})
+}
+
+func (f *Factory) CreateFailoverProcedure(ctx context.Context, request FailoverRequest) (procedure.Procedure, error) {
+	if err := procedure.ValidateParams(procedure.Failover, request.Snapshot, procedure.Params{
+		"shardID":           request.ShardID,
+		"oldLeaderNodeName": request.OldLeaderNodeName,
+		"newLeaderNodeName": request.NewLeaderNodeName,
+	}); err != nil {
+		return nil, err
+	}
+
+	id, err := f.allocProcedureID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return failover.NewProcedure(failover.ProcedureParams{
+		ID:                id,
+		Dispatch:          f.deps.Dispatch,
+		ClusterMetadata:   request.ClusterMetadata,
 		ClusterSnapshot:   request.Snapshot,
 		ShardID:           request.ShardID,
 		OldLeaderNodeName: request.OldLeaderNodeName,
@@ -250,6 +601,19 @@ func (f *Factory) CreateTransferLeaderProcedure(ctx context.Context, request Tra
 }
 
 func (f *Factory) CreateSplitProcedure(ctx context.Context, request SplitRequest) (procedure.Procedure, error) {
+	if err := procedure.ValidateParams(procedure.Split, request.Snapshot, procedure.Params{
+		"schemaName":     request.SchemaName,
+		"tableNames":     request.TableNames,
+		"shardID":        request.ShardID,
+		"newShardID":     request.NewShardID,
+		"targetNodeName": request.TargetNodeName,
+	}); err != nil {
+		return nil, err
+	}
+	if err := request.ClusterMetadata.CheckShardQuota(1); err != nil {
+		return nil, err
+	}
+
 	id, err := f.allocProcedureID(ctx)
 	if err != nil {
 		return nil, err
@@ -258,8 +622,8 @@ func (f *Factory) CreateSplitProcedure(ctx context.Context, request SplitRequest
 	return split.NewProcedure(
 		split.ProcedureParams{
 			ID:              id,
-			Dispatch:        f.dispatch,
-			Storage:         f.storage,
+			Dispatch:        f.deps.Dispatch,
+			Storage:         f.deps.Storage,
 			ClusterMetadata: request.ClusterMetadata,
 			ClusterSnapshot: request.Snapshot,
 			ShardID:         request.ShardID,
@@ -271,6 +635,135 @@ func (f *Factory) CreateSplitProcedure(ctx context.Context, request SplitRequest
 	)
 }
 
+// CreateRebalancePartitionTableProcedure creates the procedure spreading the sub tables of the partition table evenly
+// across the shards. The returned bool is false if the skew of the sub tables doesn't exceed the threshold, and no
+// procedure is needed in this case.
+func (f *Factory) CreateRebalancePartitionTableProcedure(ctx context.Context, request RebalancePartitionTableRequest) (procedure.Procedure, bool, error) {
+	snapshot := request.ClusterMetadata.GetClusterSnapshot()
+	if err := procedure.ValidateParams(procedure.RebalancePartitionTable, snapshot, procedure.Params{
+		"schemaName":    request.SchemaName,
+		"tableName":     request.TableName,
+		"subTableNames": request.SubTableNames,
+	}); err != nil {
+		return nil, false, err
+	}
+
+	table, exists, err := request.ClusterMetadata.GetTable(request.SchemaName, request.TableName)
+	if err != nil {
+		return nil, false, err
+	}
+	if !exists {
+		return nil, false, errors.WithMessagef(procedure.ErrTableNotExists, "table:%s", request.TableName)
+	}
+	if !table.IsPartitioned() {
+		return nil, false, errors.WithMessagef(procedure.ErrInvalidParams, "table is not partitioned, table:%s", request.TableName)
+	}
+
+	subTables, err := request.ClusterMetadata.GetTables(request.SchemaName, request.SubTableNames)
+	if err != nil {
+		return nil, false, err
+	}
+	if len(subTables) != len(request.SubTableNames) {
+		return nil, false, errors.WithMessagef(procedure.ErrTableNotExists, "some sub tables not exist, table:%s, found:%d, expect:%d", request.TableName, len(subTables), len(request.SubTableNames))
+	}
+
+	skew := rebalancepartitiontable.Skew(snapshot, subTables)
+	moves := rebalancepartitiontable.PlanMoves(snapshot, subTables)
+	if skew <= int(request.SkewThreshold) || len(moves) == 0 {
+		f.logger.Info("partition table is balanced", zap.String("tableName", request.TableName), zap.Int("skew", skew), zap.Uint32("threshold", request.SkewThreshold))
+		return nil, false, nil
+	}
+
+	id, err := f.allocProcedureID(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	p, err := rebalancepartitiontable.NewProcedure(rebalancepartitiontable.ProcedureParams{
+		ID:              id,
+		Dispatch:        f.deps.Dispatch,
+		Storage:         f.deps.Storage,
+		ClusterMetadata: request.ClusterMetadata,
+		ClusterSnapshot: snapshot,
+		SchemaName:      request.SchemaName,
+		TableName:       request.TableName,
+		Moves:           moves,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return p, true, nil
+}
+
+func (f *Factory) CreateRepairShardsProcedure(ctx context.Context, request RepairShardsRequest) (procedure.Procedure, error) {
+	if err := request.ClusterMetadata.CheckProtection("repair shards", request.Confirmation); err != nil {
+		return nil, err
+	}
+
+	id, err := f.allocProcedureID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return repair.NewProcedure(repair.ProcedureParams{
+		ID:              id,
+		Dispatch:        f.deps.Dispatch,
+		ClusterMetadata: request.ClusterMetadata,
+		ClusterSnapshot: request.Snapshot,
+		Actions:         request.Actions,
+		OnFinished:      request.OnFinished,
+	})
+}
+
+// CreateMigrateTopologyProcedure creates the procedure converting the cluster to the topology type, and it fails at once
+// if the cluster is not ready to be migrated.
+func (f *Factory) CreateMigrateTopologyProcedure(ctx context.Context, request MigrateTopologyRequest) (procedure.Procedure, error) {
+	if err := request.ClusterMetadata.CheckProtection("migrate topology", request.Confirmation); err != nil {
+		return nil, err
+	}
+
+	id, err := f.allocProcedureID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return migratetopology.NewProcedure(migratetopology.ProcedureParams{
+		ID:              id,
+		ClusterMetadata: request.ClusterMetadata,
+		ClusterSnapshot: request.Snapshot,
+		TopologyType:    request.TopologyType,
+		OnSwitched:      request.OnSwitched,
+	})
+}
+
+// CreateReshardProcedure creates the procedure changing the shard total of the cluster, and it fails at once if the
+// cluster is not ready to be resharded.
+func (f *Factory) CreateReshardProcedure(ctx context.Context, request ReshardRequest) (procedure.Procedure, error) {
+	if newShardCount := int(request.ShardTotal) - int(request.ClusterMetadata.GetTotalShardNum()); newShardCount > 0 {
+		if err := request.ClusterMetadata.CheckShardQuota(newShardCount); err != nil {
+			return nil, err
+		}
+	} else if newShardCount < 0 {
+		if err := request.ClusterMetadata.CheckProtection("shrink shard total", request.Confirmation); err != nil {
+			return nil, err
+		}
+	}
+
+	id, err := f.allocProcedureID(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return reshard.NewProcedure(reshard.ProcedureParams{
+		ID:              id,
+		Dispatch:        f.deps.Dispatch,
+		Storage:         f.deps.Storage,
+		ClusterMetadata: request.ClusterMetadata,
+		ClusterSnapshot: request.Snapshot,
+		ShardTotal:      request.ShardTotal,
+	})
+}
+
 func (f *Factory) CreateBatchTransferLeaderProcedure(ctx context.Context, request BatchRequest) (procedure.Procedure, error) {
 	id, err := f.allocProcedureID(ctx)
 	if err != nil {
@@ -281,7 +774,7 @@ func (f *Factory) CreateBatchTransferLeaderProcedure(ctx context.Context, reques
 }
 
 func (f *Factory) allocProcedureID(ctx context.Context) (uint64, error) {
-	id, err := f.idAllocator.Alloc(ctx)
+	id, err := f.deps.IDAllocator.Alloc(ctx)
 	if err != nil {
 		return 0, errors.WithMessage(err, "alloc procedure id")
 	}
@@ -18,13 +18,18 @@ package coordinator_test
 
 import (
 	"context"
+	"fmt"
 	"testing"
 
+	"github.com/CeresDB/horaedbproto/golang/pkg/clusterpb"
 	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/coderr"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/CeresDB/horaemeta/server/etcdutil"
+	"github.com/CeresDB/horaemeta/server/storage"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
@@ -33,10 +38,7 @@ func setupFactory(t *testing.T) (*coordinator.Factory, *metadata.ClusterMetadata
 	ctx := context.Background()
 	c := test.InitStableCluster(ctx, t)
 
-	dispatch := test.MockDispatch{}
-	allocator := test.MockIDAllocator{}
-	storage := test.NewTestStorage(t)
-	f := coordinator.NewFactory(zap.NewNop(), allocator, dispatch, storage)
+	f := coordinator.NewFactory(zap.NewNop(), test.NewMockDependencies(t))
 
 	return f, c.GetMetadata()
 }
@@ -89,6 +91,91 @@ func TestCreateTable(t *testing.T) {
 	re.Equal(procedure.StateInit, string(p.State()))
 }
 
+func TestCreateTableOnTargetShard(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	f, m := setupFactory(t)
+
+	makeRequest := func(tableName string, targetShardID storage.ShardID, partitionTableInfo *metaservicepb.PartitionTableInfo) coordinator.CreateTableRequest {
+		return coordinator.CreateTableRequest{
+			ClusterMetadata: m,
+			SourceReq: &metaservicepb.CreateTableRequest{
+				Header:             nil,
+				SchemaName:         test.TestSchemaName,
+				Name:               tableName,
+				EncodedSchema:      nil,
+				Engine:             "",
+				CreateIfNotExist:   false,
+				Options:            nil,
+				PartitionTableInfo: partitionTableInfo,
+			},
+			TargetShardID: &targetShardID,
+			OnSucceeded:   nil,
+			OnFailed:      nil,
+		}
+	}
+
+	p, err := f.MakeCreateTableProcedure(ctx, makeRequest("test1", 2, nil))
+	re.NoError(err)
+	re.Equal(procedure.CreateTable, p.Kind())
+	_, ok := p.RelatedVersionInfo().ShardWithVersion[2]
+	re.True(ok)
+
+	_, err = f.MakeCreateTableProcedure(ctx, makeRequest("test2", test.DefaultShardTotal, nil))
+	re.True(coderr.Is(err, metadata.ErrShardNotFound.Code()))
+
+	_, err = f.MakeCreateTableProcedure(ctx, makeRequest("test3", 2, &metaservicepb.PartitionTableInfo{
+		PartitionInfo: nil,
+		SubTableNames: []string{"test3-0"},
+	}))
+	re.True(coderr.Is(err, coordinator.ErrTargetShardNotSupported.Code()))
+}
+
+func TestCreateTableWithSchemaSettings(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	f, m := setupFactory(t)
+
+	re.NoError(m.UpdateSchemaSettings(ctx, test.TestSchemaName, storage.SchemaSettings{
+		MaxTableCount:       2,
+		DefaultTableOptions: map[string]string{"ttl": "7d", "enable_ttl": "true"},
+		ShardPickingPolicy:  storage.ShardPickingPolicyRandom,
+	}))
+
+	makeRequest := func(tableName string, options map[string]string, partitionTableInfo *metaservicepb.PartitionTableInfo) coordinator.CreateTableRequest {
+		return coordinator.CreateTableRequest{
+			ClusterMetadata: m,
+			SourceReq: &metaservicepb.CreateTableRequest{
+				Header:             nil,
+				SchemaName:         test.TestSchemaName,
+				Name:               tableName,
+				EncodedSchema:      nil,
+				Engine:             "",
+				CreateIfNotExist:   false,
+				Options:            options,
+				PartitionTableInfo: partitionTableInfo,
+			},
+			TargetShardID: nil,
+			OnSucceeded:   nil,
+			OnFailed:      nil,
+		}
+	}
+
+	// The default options are overridden by the options in the request.
+	request := makeRequest("test1", map[string]string{"ttl": "1d"}, nil)
+	p, err := f.MakeCreateTableProcedure(ctx, request)
+	re.NoError(err)
+	re.Equal(procedure.CreateTable, p.Kind())
+	re.Equal(map[string]string{"ttl": "1d", "enable_ttl": "true"}, request.SourceReq.GetOptions())
+
+	// The partition table and its sub tables exceed the quota.
+	_, err = f.MakeCreateTableProcedure(ctx, makeRequest("test2", nil, &metaservicepb.PartitionTableInfo{
+		PartitionInfo: nil,
+		SubTableNames: []string{"test2-0", "test2-1"},
+	}))
+	re.True(coderr.Is(err, metadata.ErrSchemaTableQuotaExceeded.Code()))
+}
+
 func TestDropTable(t *testing.T) {
 	re := require.New(t)
 	ctx := context.Background()
@@ -154,9 +241,9 @@ func TestSplit(t *testing.T) {
 	f, m := setupFactory(t)
 	snapshot := m.GetClusterSnapshot()
 	p, err := f.CreateSplitProcedure(ctx, coordinator.SplitRequest{
-		ClusterMetadata: nil,
-		SchemaName:      "",
-		TableNames:      nil,
+		ClusterMetadata: m,
+		SchemaName:      test.TestSchemaName,
+		TableNames:      []string{test.TestTableName0},
 		Snapshot:        snapshot,
 		ShardID:         snapshot.Topology.ClusterView.ShardNodes[0].ID,
 		NewShardID:      100,
@@ -165,4 +252,133 @@ func TestSplit(t *testing.T) {
 	re.NoError(err)
 	re.Equal(procedure.Split, p.Kind())
 	re.Equal(procedure.StateInit, string(p.State()))
+
+	// Malformed split request should be rejected before any procedure is created.
+	_, err = f.CreateSplitProcedure(ctx, coordinator.SplitRequest{
+		ClusterMetadata: nil,
+		SchemaName:      "",
+		TableNames:      nil,
+		Snapshot:        snapshot,
+		ShardID:         snapshot.Topology.ClusterView.ShardNodes[0].ID,
+		NewShardID:      snapshot.Topology.ClusterView.ShardNodes[0].ID,
+		TargetNodeName:  "unknown-node",
+	})
+	var validationErr *procedure.ParamValidationError
+	re.ErrorAs(err, &validationErr)
+	re.Len(validationErr.FieldErrors, 4)
+}
+
+func TestRebalancePartitionTable(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	f, m := setupFactory(t)
+
+	tableName := "partitionTable"
+	_, err := m.CreateTableMetadata(ctx, metadata.CreateTableMetadataRequest{
+		SchemaName:    test.TestSchemaName,
+		TableName:     tableName,
+		PartitionInfo: storage.PartitionInfo{Info: &clusterpb.PartitionInfo{Info: nil}},
+	})
+	re.NoError(err)
+	subTableNames := make([]string, 0, test.DefaultShardTotal)
+	for i := 0; i < test.DefaultShardTotal; i++ {
+		subTableName := fmt.Sprintf("__%s_%d", tableName, i)
+		_, err := m.CreateTable(ctx, metadata.CreateTableRequest{
+			ShardID:       0,
+			LatestVersion: 0,
+			SchemaName:    test.TestSchemaName,
+			TableName:     subTableName,
+			PartitionInfo: storage.PartitionInfo{Info: nil},
+		})
+		re.NoError(err)
+		subTableNames = append(subTableNames, subTableName)
+	}
+
+	// All the sub tables are on shard 0, and the skew doesn't exceed the threshold equal to the number of them.
+	request := coordinator.RebalancePartitionTableRequest{
+		ClusterMetadata: m,
+		SchemaName:      test.TestSchemaName,
+		TableName:       tableName,
+		SubTableNames:   subTableNames,
+		SkewThreshold:   test.DefaultShardTotal,
+	}
+	_, ok, err := f.CreateRebalancePartitionTableProcedure(ctx, request)
+	re.NoError(err)
+	re.False(ok)
+
+	request.SkewThreshold = 1
+	p, ok, err := f.CreateRebalancePartitionTableProcedure(ctx, request)
+	re.NoError(err)
+	re.True(ok)
+	re.Equal(procedure.RebalancePartitionTable, p.Kind())
+	re.Equal(procedure.StateInit, string(p.State()))
+
+	// Only the partition table can be rebalanced.
+	request.TableName = subTableNames[0]
+	_, _, err = f.CreateRebalancePartitionTableProcedure(ctx, request)
+	re.True(coderr.Is(err, procedure.ErrInvalidParams.Code()))
+}
+
+// finishDroppingStorage drops the finished procedures, as if the leader crashes before persisting them.
+type finishDroppingStorage struct {
+	procedure.Storage
+
+	dropFinished bool
+}
+
+func (s *finishDroppingStorage) CreateOrUpdate(ctx context.Context, meta procedure.Meta) error {
+	if s.dropFinished && meta.State == procedure.StateFinished {
+		return nil
+	}
+	return s.Storage.CreateOrUpdate(ctx, meta)
+}
+
+func TestResumeCreatePartitionTableProcedures(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	c := test.InitStableCluster(ctx, t)
+	m := c.GetMetadata()
+	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
+	s := &finishDroppingStorage{Storage: procedure.NewEtcdStorageImpl(client, test.TestRootPath, uint32(m.GetClusterID()), m.Clock()), dropFinished: true}
+	deps := test.NewMockDependencies(t)
+	deps.Storage = s
+	f := coordinator.NewFactory(zap.NewNop(), deps)
+
+	p, err := f.MakeCreateTableProcedure(ctx, coordinator.CreateTableRequest{
+		ClusterMetadata: m,
+		SourceReq: &metaservicepb.CreateTableRequest{
+			Header:           nil,
+			SchemaName:       test.TestSchemaName,
+			Name:             "test1",
+			EncodedSchema:    nil,
+			Engine:           "",
+			CreateIfNotExist: false,
+			Options:          nil,
+			PartitionTableInfo: &metaservicepb.PartitionTableInfo{
+				PartitionInfo: nil,
+				SubTableNames: []string{"test1-0", "test1-1"},
+			},
+		},
+		TargetShardID: nil,
+		OnSucceeded:   func(metadata.CreateTableResult) error { return nil },
+		OnFailed:      func(error) error { return nil },
+	})
+	re.NoError(err)
+	re.NoError(p.Start(ctx))
+
+	// The procedure not persisted as finished is resumed with the source request restored.
+	procedures, err := f.ResumeCreatePartitionTableProcedures(ctx, m)
+	re.NoError(err)
+	re.Len(procedures, 1)
+	resumed := procedures[0]
+	re.Equal(p.ID(), resumed.ID())
+	re.Equal(procedure.CreatePartitionTable, resumed.Kind())
+
+	s.dropFinished = false
+	re.NoError(resumed.Start(ctx))
+	re.Equal(procedure.StateFinished, string(resumed.State()))
+
+	procedures, err = f.ResumeCreatePartitionTableProcedures(ctx, m)
+	re.NoError(err)
+	re.Empty(procedures)
 }
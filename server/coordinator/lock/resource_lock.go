@@ -0,0 +1,131 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lock
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Resource is a node in the hierarchy of cluster > schema > table, and the empty names denote the upper levels, that is,
+// Resource{Schema: "s"} is the whole schema s and Resource{} is the whole cluster.
+type Resource struct {
+	Schema string
+	Table  string
+}
+
+func ClusterResource() Resource {
+	return Resource{Schema: "", Table: ""}
+}
+
+func SchemaResource(schema string) Resource {
+	return Resource{Schema: schema, Table: ""}
+}
+
+func TableResource(schema, table string) Resource {
+	return Resource{Schema: schema, Table: table}
+}
+
+func (r Resource) String() string {
+	switch {
+	case len(r.Schema) == 0:
+		return "cluster"
+	case len(r.Table) == 0:
+		return fmt.Sprintf("schema:%s", r.Schema)
+	default:
+		return fmt.Sprintf("table:%s.%s", r.Schema, r.Table)
+	}
+}
+
+// ResourceLock locks the resources of a cluster hierarchically: a resource is exclusive with itself, the levels above it
+// and the ones below it, e.g. a table is exclusive with its schema and the cluster, while the tables in the same schema are
+// independent of each other.
+type ResourceLock struct {
+	lock sync.Mutex
+	held map[Resource]struct{}
+	// heldInSchema is the number of the held resources in the schema, including the schema itself.
+	heldInSchema map[string]int
+}
+
+func NewResourceLock() *ResourceLock {
+	return &ResourceLock{
+		lock:         sync.Mutex{},
+		held:         make(map[Resource]struct{}),
+		heldInSchema: make(map[string]int),
+	}
+}
+
+// TryLock locks all the resources or none of them, and returns false if any of them conflicts with the held ones or the
+// others in the resources.
+func (l *ResourceLock) TryLock(resources []Resource) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for i, resource := range resources {
+		if l.conflictsLocked(resource) {
+			l.unlockLocked(resources[:i])
+			return false
+		}
+		l.held[resource] = struct{}{}
+		if len(resource.Schema) > 0 {
+			l.heldInSchema[resource.Schema]++
+		}
+	}
+	return true
+}
+
+func (l *ResourceLock) UnLock(resources []Resource) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for _, resource := range resources {
+		if _, exists := l.held[resource]; !exists {
+			panic(fmt.Sprintf("try to unlock nonexistent resource lock, resource:%s, unlock resources:%v", resource, resources))
+		}
+	}
+	l.unlockLocked(resources)
+}
+
+func (l *ResourceLock) conflictsLocked(resource Resource) bool {
+	if _, exists := l.held[ClusterResource()]; exists {
+		return true
+	}
+	if len(resource.Schema) == 0 {
+		return len(l.held) > 0
+	}
+	if _, exists := l.held[SchemaResource(resource.Schema)]; exists {
+		return true
+	}
+	if len(resource.Table) == 0 {
+		return l.heldInSchema[resource.Schema] > 0
+	}
+	_, exists := l.held[resource]
+	return exists
+}
+
+func (l *ResourceLock) unlockLocked(resources []Resource) {
+	for _, resource := range resources {
+		delete(l.held, resource)
+		if len(resource.Schema) == 0 {
+			continue
+		}
+		l.heldInSchema[resource.Schema]--
+		if l.heldInSchema[resource.Schema] == 0 {
+			delete(l.heldInSchema, resource.Schema)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lock
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourceLock(t *testing.T) {
+	re := require.New(t)
+
+	lock := NewResourceLock()
+
+	table1 := []Resource{TableResource("public", "t1")}
+	table2 := []Resource{TableResource("public", "t2")}
+	re.True(lock.TryLock(table1))
+	re.False(lock.TryLock(table1))
+	// The tables in the same schema are independent.
+	re.True(lock.TryLock(table2))
+	// The schema and the cluster conflict with the tables in them.
+	re.False(lock.TryLock([]Resource{SchemaResource("public")}))
+	re.False(lock.TryLock([]Resource{ClusterResource()}))
+	re.True(lock.TryLock([]Resource{SchemaResource("other")}))
+	lock.UnLock([]Resource{SchemaResource("other")})
+
+	// Nothing is locked if any of the resources conflicts.
+	re.False(lock.TryLock([]Resource{TableResource("public", "t3"), TableResource("public", "t1")}))
+	re.True(lock.TryLock([]Resource{TableResource("public", "t3")}))
+	lock.UnLock([]Resource{TableResource("public", "t3")})
+
+	lock.UnLock(table1)
+	lock.UnLock(table2)
+	schema := []Resource{SchemaResource("public")}
+	re.True(lock.TryLock(schema))
+	re.False(lock.TryLock(table1))
+	re.False(lock.TryLock([]Resource{ClusterResource()}))
+	lock.UnLock(schema)
+
+	cluster := []Resource{ClusterResource()}
+	re.True(lock.TryLock(cluster))
+	re.False(lock.TryLock(table1))
+	re.False(lock.TryLock([]Resource{SchemaResource("other")}))
+	lock.UnLock(cluster)
+	re.True(lock.TryLock(table1))
+	lock.UnLock(table1)
+
+	re.Panics(func() {
+		lock.UnLock(table1)
+	}, "this function did not panic")
+}
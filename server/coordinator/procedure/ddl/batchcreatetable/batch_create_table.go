@@ -0,0 +1,382 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package batchcreatetable
+
+import (
+	"context"
+	"slices"
+	"sync"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/lock"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/looplab/fsm"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// fsm state change:
+// ┌────────┐     ┌────────────────┐     ┌───────────┐
+// │ Begin  ├─────▶  CreateTables  ├─────▶  Finish   │
+// └────────┘     └────────────────┘     └───────────┘
+const (
+	eventCreateTables = "EventCreateTables"
+	eventFinish       = "EventFinish"
+
+	stateBegin        = "StateBegin"
+	stateCreateTables = "StateCreateTables"
+	stateFinish       = "StateFinish"
+)
+
+var (
+	batchCreateTableEvents = fsm.Events{
+		{Name: eventCreateTables, Src: []string{stateBegin}, Dst: stateCreateTables},
+		{Name: eventFinish, Src: []string{stateCreateTables}, Dst: stateFinish},
+	}
+	batchCreateTableCallbacks = fsm.Callbacks{
+		eventCreateTables: createTablesCallback,
+		eventFinish:       finishCallback,
+	}
+)
+
+// TableResult is the result of creating a table in the batch.
+type TableResult struct {
+	TableName string          `json:"tableName"`
+	TableID   storage.TableID `json:"tableID"`
+	ShardID   storage.ShardID `json:"shardID"`
+	// ShardVersion is the version of the shard persisted after the table is added, which is the one returned by the node.
+	ShardVersion uint64 `json:"shardVersion,omitempty"`
+	Succeeded    bool   `json:"succeeded"`
+	Error        string `json:"error,omitempty"`
+}
+
+type ProcedureParams struct {
+	ID              uint64
+	Dispatch        eventdispatch.Dispatch
+	ClusterMetadata *metadata.ClusterMetadata
+	ClusterSnapshot metadata.Snapshot
+	SchemaName      string
+	// ShardTables assigns the tables to create to the shards, and the partition tables should not be included.
+	ShardTables map[storage.ShardID][]*metaservicepb.CreateTableRequest
+
+	OnFinished func([]TableResult) error
+}
+
+type Procedure struct {
+	fsm                *fsm.FSM
+	params             ProcedureParams
+	relatedVersionInfo procedure.RelatedVersionInfo
+	// shardIDs are the shards in params.ShardTables in a fixed order.
+	shardIDs []storage.ShardID
+
+	// Protect the results written by the shards concurrently.
+	resultLock sync.Mutex
+	results    []TableResult
+
+	// Protect the state.
+	lock  sync.RWMutex
+	state procedure.State
+}
+
+func NewProcedure(params ProcedureParams) (procedure.Procedure, error) {
+	shardWithVersion := make(map[storage.ShardID]uint64, len(params.ShardTables))
+	shardIDs := make([]storage.ShardID, 0, len(params.ShardTables))
+	tableCount := 0
+	for shardID, reqs := range params.ShardTables {
+		shardView, exists := params.ClusterSnapshot.Topology.ShardViewsMapping[shardID]
+		if !exists {
+			return nil, errors.WithMessagef(metadata.ErrShardNotFound, "shard not found in topology, shardID:%d", shardID)
+		}
+		for _, req := range reqs {
+			if req.PartitionTableInfo != nil {
+				return nil, errors.WithMessagef(procedure.ErrBatchCreatePartitionTable, "table:%s", req.GetName())
+			}
+		}
+		shardWithVersion[shardID] = shardView.Version
+		shardIDs = append(shardIDs, shardID)
+		tableCount += len(reqs)
+	}
+	slices.Sort(shardIDs)
+
+	return &Procedure{
+		fsm: procedure.NewFSM(
+			procedure.BatchCreateTable,
+			params.ClusterMetadata.Clock(),
+			stateBegin,
+			batchCreateTableEvents,
+			batchCreateTableCallbacks,
+		),
+		params: params,
+		relatedVersionInfo: procedure.RelatedVersionInfo{
+			ClusterID:        params.ClusterSnapshot.Topology.ClusterView.ClusterID,
+			ShardWithVersion: shardWithVersion,
+			ClusterVersion:   params.ClusterSnapshot.Topology.ClusterView.Version,
+		},
+		shardIDs:   shardIDs,
+		resultLock: sync.Mutex{},
+		results:    make([]TableResult, 0, tableCount),
+		lock:       sync.RWMutex{},
+		state:      procedure.StateInit,
+	}, nil
+}
+
+func (p *Procedure) ID() uint64 {
+	return p.params.ID
+}
+
+func (p *Procedure) Kind() procedure.Kind {
+	return procedure.BatchCreateTable
+}
+
+func (p *Procedure) RelatedVersionInfo() procedure.RelatedVersionInfo {
+	return p.relatedVersionInfo
+}
+
+func (p *Procedure) Priority() procedure.Priority {
+	return procedure.PriorityLow
+}
+
+func (p *Procedure) StepInfo() procedure.StepInfo {
+	return procedure.FSMStepInfo(p.fsm)
+}
+
+func (p *Procedure) LockedResources() []lock.Resource {
+	tableNames := p.tableNames()
+	resources := make([]lock.Resource, 0, len(tableNames))
+	// A table listed twice must not conflict with itself, and it is rejected when creating the metadata.
+	seen := make(map[string]struct{}, len(tableNames))
+	schemaName := p.params.ClusterMetadata.NormalizeName(p.params.SchemaName)
+	for _, tableName := range tableNames {
+		tableName = p.params.ClusterMetadata.NormalizeName(tableName)
+		if _, ok := seen[tableName]; ok {
+			continue
+		}
+		seen[tableName] = struct{}{}
+		resources = append(resources, lock.TableResource(schemaName, tableName))
+	}
+	return resources
+}
+
+// tableNames returns the names of the tables to create ordered by the shards.
+func (p *Procedure) tableNames() []string {
+	tableNames := make([]string, 0, cap(p.results))
+	for _, shardID := range p.shardIDs {
+		for _, req := range p.params.ShardTables[shardID] {
+			tableNames = append(tableNames, req.GetName())
+		}
+	}
+	return tableNames
+}
+
+func (p *Procedure) Start(ctx context.Context) error {
+	p.updateStateWithLock(procedure.StateRunning)
+
+	req := &callbackRequest{
+		ctx: ctx,
+		p:   p,
+	}
+
+	for {
+		switch p.fsm.Current() {
+		case stateBegin:
+			if err := p.fsm.Event(eventCreateTables, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "batch create table procedure create tables")
+			}
+		case stateCreateTables:
+			if err := p.fsm.Event(eventFinish, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "batch create table procedure finish")
+			}
+		case stateFinish:
+			p.updateStateWithLock(procedure.StateFinished)
+			return nil
+		}
+	}
+}
+
+func (p *Procedure) Cancel(_ context.Context) error {
+	p.updateStateWithLock(procedure.StateCancelled)
+	return nil
+}
+
+func (p *Procedure) State() procedure.State {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.state
+}
+
+func (p *Procedure) updateStateWithLock(state procedure.State) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.state = state
+}
+
+func (p *Procedure) addResultsWithLock(results ...TableResult) {
+	p.resultLock.Lock()
+	defer p.resultLock.Unlock()
+
+	p.results = append(p.results, results...)
+}
+
+type callbackRequest struct {
+	ctx context.Context
+	p   *Procedure
+}
+
+// 1. Create the metadata of all the tables at once, and then create the tables shard by shard, and the shards are
+// handled concurrently. The failures are recorded in the results instead of failing the procedure.
+func createTablesCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[*callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+	p := req.p
+	params := p.params
+
+	tables, err := params.ClusterMetadata.CreateTablesMetadata(req.ctx, params.SchemaName, p.tableNames())
+	if err != nil {
+		log.Error("create tables metadata failed", zap.Uint64("procedureID", p.ID()), zap.Error(err))
+		for shardID, reqs := range params.ShardTables {
+			for _, sourceReq := range reqs {
+				p.addResultsWithLock(failedResult(shardID, storage.Table{
+					ID:            0,
+					Name:          sourceReq.GetName(),
+					SchemaID:      0,
+					CreatedAt:     0,
+					PartitionInfo: storage.PartitionInfo{Info: nil},
+					Version:       0,
+				}, err))
+			}
+		}
+		return
+	}
+
+	// The tables are created in the same order of the table names.
+	tableIdx := 0
+	var wg sync.WaitGroup
+	for _, shardID := range p.shardIDs {
+		shardID := shardID
+		reqs := params.ShardTables[shardID]
+		shardTables := tables[tableIdx : tableIdx+len(reqs)]
+		tableIdx += len(reqs)
+		shardVersion := p.relatedVersionInfo.ShardWithVersion[shardID]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.addResultsWithLock(createTablesOnShard(req.ctx, params, shardID, shardVersion, shardTables, reqs)...)
+		}()
+	}
+	wg.Wait()
+}
+
+// 2. Finish the procedure.
+func finishCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[*callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+
+	log.Info("batch create table finish", zap.Uint64("procedureID", req.p.ID()), zap.Int("tableCount", len(req.p.results)))
+	if req.p.params.OnFinished != nil {
+		if err := req.p.params.OnFinished(req.p.results); err != nil {
+			procedure.CancelEventWithLog(event, err, "batch create table on finished")
+			return
+		}
+	}
+}
+
+// createTablesOnShard dispatches the creation of every table to the shard, and then adds the created tables to the shard
+// view at once. The dispatch stops at the first failure, and the metadata of the tables not created on the shard is
+// removed.
+func createTablesOnShard(ctx context.Context, params ProcedureParams, shardID storage.ShardID, shardVersion uint64, tables []storage.Table, reqs []*metaservicepb.CreateTableRequest) []TableResult {
+	results := make([]TableResult, 0, len(tables))
+	createdTables := make([]storage.Table, 0, len(tables))
+	latestVersion := shardVersion
+
+	var dispatchErr error
+	for i, table := range tables {
+		createTableRequest := ddl.BuildCreateTableRequest(table, metadata.ShardVersionUpdate{
+			ShardID:       shardID,
+			LatestVersion: latestVersion,
+		}, reqs[i])
+		version, err := ddl.CreateTableOnShard(ctx, params.ClusterMetadata, params.Dispatch, shardID, createTableRequest)
+		if err != nil {
+			dispatchErr = errors.WithMessagef(err, "create table on shard, shardID:%d, table:%s", shardID, table.Name)
+			break
+		}
+		latestVersion = version
+		createdTables = append(createdTables, table)
+	}
+
+	createdCount := len(createdTables)
+	if createdCount > 0 {
+		shardVersionUpdate, err := params.ClusterMetadata.AddTablesTopology(ctx, metadata.ShardVersionUpdate{
+			ShardID:       shardID,
+			LatestVersion: latestVersion,
+		}, createdTables)
+		if err != nil {
+			// The tables created on the shard are left without a shard in the metadata, which are reported as the dangling
+			// tables by fsck.
+			log.Error("add tables topology failed", zap.Uint32("shardID", uint32(shardID)), zap.Error(err))
+			for _, table := range createdTables {
+				results = append(results, failedResult(shardID, table, errors.WithMessagef(err, "add tables topology, shardID:%d", shardID)))
+			}
+			createdTables = createdTables[:0]
+		} else {
+			latestVersion = shardVersionUpdate.LatestVersion
+		}
+	}
+
+	for _, table := range createdTables {
+		results = append(results, TableResult{
+			TableName:    table.Name,
+			TableID:      table.ID,
+			ShardID:      shardID,
+			ShardVersion: latestVersion,
+			Succeeded:    true,
+			Error:        "",
+		})
+	}
+	for _, table := range tables[createdCount:] {
+		if _, err := params.ClusterMetadata.DropTableMetadata(ctx, params.SchemaName, table.Name); err != nil {
+			log.Warn("drop metadata of table not created failed", zap.String("tableName", table.Name), zap.Error(err))
+		}
+		results = append(results, failedResult(shardID, table, dispatchErr))
+	}
+	return results
+}
+
+func failedResult(shardID storage.ShardID, table storage.Table, err error) TableResult {
+	return TableResult{
+		TableName:    table.Name,
+		TableID:      table.ID,
+		ShardID:      shardID,
+		ShardVersion: 0,
+		Succeeded:    false,
+		Error:        err.Error(),
+	}
+}
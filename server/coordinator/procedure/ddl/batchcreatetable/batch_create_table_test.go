@@ -0,0 +1,113 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package batchcreatetable_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl/batchcreatetable"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchCreateTable(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	dispatch := test.MockDispatch{}
+	c := test.InitStableCluster(ctx, t)
+
+	snapshot := c.GetMetadata().GetClusterSnapshot()
+	shardNodes := snapshot.Topology.ClusterView.ShardNodes
+	testTableNum := 20
+	shardTables := make(map[storage.ShardID][]*metaservicepb.CreateTableRequest)
+	for i := 0; i < testTableNum; i++ {
+		// Spread the tables over the shards.
+		shardID := shardNodes[i%len(shardNodes)].ID
+		shardTables[shardID] = append(shardTables[shardID], &metaservicepb.CreateTableRequest{
+			Header:             nil,
+			SchemaName:         test.TestSchemaName,
+			Name:               fmt.Sprintf("%s_%d", test.TestTableName0, i),
+			EncodedSchema:      nil,
+			Engine:             "",
+			CreateIfNotExist:   false,
+			Options:            nil,
+			PartitionTableInfo: nil,
+		})
+	}
+
+	var results []batchcreatetable.TableResult
+	p, err := batchcreatetable.NewProcedure(batchcreatetable.ProcedureParams{
+		ID:              0,
+		Dispatch:        dispatch,
+		ClusterMetadata: c.GetMetadata(),
+		ClusterSnapshot: snapshot,
+		SchemaName:      test.TestSchemaName,
+		ShardTables:     shardTables,
+		OnFinished: func(tableResults []batchcreatetable.TableResult) error {
+			results = tableResults
+			return nil
+		},
+	})
+	re.NoError(err)
+	re.Equal(len(shardTables), len(p.RelatedVersionInfo().ShardWithVersion))
+	re.Equal(testTableNum, len(p.(procedure.ResourceLocker).LockedResources()))
+	re.NoError(p.Start(ctx))
+
+	re.Equal(testTableNum, len(results))
+	for _, result := range results {
+		re.True(result.Succeeded, result.Error)
+		table, exists, err := c.GetMetadata().GetTable(test.TestSchemaName, result.TableName)
+		re.NoError(err)
+		re.True(exists)
+		re.Equal(table.ID, result.TableID)
+	}
+
+	// Check the tables are added to the shards they are assigned to.
+	shardIDs := make([]storage.ShardID, 0, len(shardTables))
+	for shardID := range shardTables {
+		shardIDs = append(shardIDs, shardID)
+	}
+	for shardID, tables := range c.GetMetadata().GetShardTables(shardIDs) {
+		re.Equal(len(shardTables[shardID]), len(tables.Tables))
+	}
+
+	// The tables already exist, so the batch fails as a whole and nothing is created.
+	results = nil
+	p, err = batchcreatetable.NewProcedure(batchcreatetable.ProcedureParams{
+		ID:              1,
+		Dispatch:        dispatch,
+		ClusterMetadata: c.GetMetadata(),
+		ClusterSnapshot: c.GetMetadata().GetClusterSnapshot(),
+		SchemaName:      test.TestSchemaName,
+		ShardTables:     shardTables,
+		OnFinished: func(tableResults []batchcreatetable.TableResult) error {
+			results = tableResults
+			return nil
+		},
+	})
+	re.NoError(err)
+	re.NoError(p.Start(ctx))
+	re.Equal(testTableNum, len(results))
+	for _, result := range results {
+		re.False(result.Succeeded)
+	}
+}
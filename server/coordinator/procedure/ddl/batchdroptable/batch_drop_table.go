@@ -0,0 +1,288 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package batchdroptable
+
+import (
+	"context"
+	"sync"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/lock"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/looplab/fsm"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
+)
+
+// fsm state change:
+// ┌────────┐     ┌──────────────┐     ┌───────────┐
+// │ Begin  ├─────▶  DropTables  ├─────▶  Finish   │
+// └────────┘     └──────────────┘     └───────────┘
+const (
+	eventDropTables = "EventDropTables"
+	eventFinish     = "EventFinish"
+
+	stateBegin      = "StateBegin"
+	stateDropTables = "StateDropTables"
+	stateFinish     = "StateFinish"
+)
+
+var (
+	batchDropTableEvents = fsm.Events{
+		{Name: eventDropTables, Src: []string{stateBegin}, Dst: stateDropTables},
+		{Name: eventFinish, Src: []string{stateDropTables}, Dst: stateFinish},
+	}
+	batchDropTableCallbacks = fsm.Callbacks{
+		eventDropTables: dropTablesCallback,
+		eventFinish:     finishCallback,
+	}
+)
+
+type ProcedureParams struct {
+	ID              uint64
+	Dispatch        eventdispatch.Dispatch
+	ClusterMetadata *metadata.ClusterMetadata
+	ClusterSnapshot metadata.Snapshot
+	SchemaName      string
+	// Tables to drop, and the partitioned tables should not be included.
+	Tables []storage.Table
+}
+
+type Procedure struct {
+	fsm                *fsm.FSM
+	params             ProcedureParams
+	relatedVersionInfo procedure.RelatedVersionInfo
+	// shardTables groups the tables to drop by the shards they belong to.
+	shardTables map[storage.ShardID][]storage.Table
+	// orphanTables are the tables not allocated to any shard, only their metadata needs to be removed.
+	orphanTables []storage.Table
+
+	// Protect the state.
+	lock  sync.RWMutex
+	state procedure.State
+}
+
+func NewProcedure(params ProcedureParams) (procedure.Procedure, error) {
+	tableShardMapping := make(map[storage.TableID]storage.ShardID)
+	for shardID, shardView := range params.ClusterSnapshot.Topology.ShardViewsMapping {
+		for _, tableID := range shardView.TableIDs {
+			tableShardMapping[tableID] = shardID
+		}
+	}
+
+	shardTables := make(map[storage.ShardID][]storage.Table)
+	orphanTables := make([]storage.Table, 0)
+	shardWithVersion := make(map[storage.ShardID]uint64)
+	for _, table := range params.Tables {
+		if table.IsPartitioned() {
+			return nil, errors.WithMessagef(procedure.ErrBatchDropPartitionTable, "table:%s", table.Name)
+		}
+
+		shardID, exists := tableShardMapping[table.ID]
+		if !exists {
+			orphanTables = append(orphanTables, table)
+			continue
+		}
+		shardView, exists := params.ClusterSnapshot.Topology.ShardViewsMapping[shardID]
+		if !exists {
+			return nil, errors.WithMessagef(metadata.ErrShardNotFound, "shard not found in topology, shardID:%d", shardID)
+		}
+		shardWithVersion[shardID] = shardView.Version
+		shardTables[shardID] = append(shardTables[shardID], table)
+	}
+
+	return &Procedure{
+		fsm: procedure.NewFSM(
+			procedure.BatchDropTable,
+			params.ClusterMetadata.Clock(),
+			stateBegin,
+			batchDropTableEvents,
+			batchDropTableCallbacks,
+		),
+		params: params,
+		relatedVersionInfo: procedure.RelatedVersionInfo{
+			ClusterID:        params.ClusterSnapshot.Topology.ClusterView.ClusterID,
+			ShardWithVersion: shardWithVersion,
+			ClusterVersion:   params.ClusterSnapshot.Topology.ClusterView.Version,
+		},
+		shardTables:  shardTables,
+		orphanTables: orphanTables,
+		lock:         sync.RWMutex{},
+		state:        procedure.StateInit,
+	}, nil
+}
+
+func (p *Procedure) ID() uint64 {
+	return p.params.ID
+}
+
+func (p *Procedure) Kind() procedure.Kind {
+	return procedure.BatchDropTable
+}
+
+func (p *Procedure) RelatedVersionInfo() procedure.RelatedVersionInfo {
+	return p.relatedVersionInfo
+}
+
+func (p *Procedure) Priority() procedure.Priority {
+	return procedure.PriorityLow
+}
+
+func (p *Procedure) StepInfo() procedure.StepInfo {
+	return procedure.FSMStepInfo(p.fsm)
+}
+
+func (p *Procedure) LockedResources() []lock.Resource {
+	resources := make([]lock.Resource, 0, len(p.params.Tables))
+	// A table listed twice must not conflict with itself.
+	seen := make(map[string]struct{}, len(p.params.Tables))
+	schemaName := p.params.ClusterMetadata.NormalizeName(p.params.SchemaName)
+	for _, table := range p.params.Tables {
+		tableName := p.params.ClusterMetadata.NormalizeName(table.Name)
+		if _, ok := seen[tableName]; ok {
+			continue
+		}
+		seen[tableName] = struct{}{}
+		resources = append(resources, lock.TableResource(schemaName, tableName))
+	}
+	return resources
+}
+
+func (p *Procedure) Start(ctx context.Context) error {
+	p.updateStateWithLock(procedure.StateRunning)
+
+	req := &callbackRequest{
+		ctx: ctx,
+		p:   p,
+	}
+
+	for {
+		switch p.fsm.Current() {
+		case stateBegin:
+			if err := p.fsm.Event(eventDropTables, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "batch drop table procedure drop tables")
+			}
+		case stateDropTables:
+			if err := p.fsm.Event(eventFinish, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "batch drop table procedure finish")
+			}
+		case stateFinish:
+			p.updateStateWithLock(procedure.StateFinished)
+			return nil
+		}
+	}
+}
+
+func (p *Procedure) Cancel(_ context.Context) error {
+	p.updateStateWithLock(procedure.StateCancelled)
+	return nil
+}
+
+func (p *Procedure) State() procedure.State {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.state
+}
+
+func (p *Procedure) updateStateWithLock(state procedure.State) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.state = state
+}
+
+type callbackRequest struct {
+	ctx context.Context
+	p   *Procedure
+}
+
+// 1. Drop the tables shard by shard, and the shards are handled concurrently.
+func dropTablesCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[*callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+	params := req.p.params
+
+	for _, table := range req.p.orphanTables {
+		if _, err := params.ClusterMetadata.DropTableMetadata(req.ctx, params.SchemaName, table.Name); err != nil {
+			procedure.CancelEventWithLog(event, err, "drop table metadata", zap.String("tableName", table.Name))
+			return
+		}
+	}
+
+	g, _ := errgroup.WithContext(req.ctx)
+	for shardID, tables := range req.p.shardTables {
+		shardID := shardID
+		tables := tables
+		shardVersion := req.p.relatedVersionInfo.ShardWithVersion[shardID]
+		g.Go(func() error {
+			return dropTablesOnShard(req.ctx, params, shardID, shardVersion, tables)
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		procedure.CancelEventWithLog(event, err, "drop tables on shards")
+		return
+	}
+}
+
+// 2. Finish the procedure.
+func finishCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[*callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+
+	log.Info("batch drop table finish", zap.Uint64("procedureID", req.p.ID()), zap.Int("tableCount", len(req.p.params.Tables)))
+}
+
+// dropTablesOnShard dispatches the drops of the tables to the shard as one batch, and then removes the metadata of the
+// dropped tables at once. The metadata of the tables already dropped on the shard is still removed if the batch fails
+// midway, so that the shard version in the metadata keeps up with the shard.
+func dropTablesOnShard(ctx context.Context, params ProcedureParams, shardID storage.ShardID, shardVersion uint64, tables []storage.Table) error {
+	droppedTableNames, latestVersion, dispatchErr := ddl.DropTablesOnShard(ctx, params.ClusterMetadata, params.Dispatch, params.SchemaName, tables, metadata.ShardVersionUpdate{
+		ShardID:       shardID,
+		LatestVersion: shardVersion,
+	})
+	if dispatchErr != nil {
+		dispatchErr = errors.WithMessagef(dispatchErr, "drop tables on shard, shardID:%d", shardID)
+	}
+
+	if len(droppedTableNames) > 0 {
+		if _, err := params.ClusterMetadata.DropTables(ctx, metadata.DropTablesRequest{
+			SchemaName:    params.SchemaName,
+			TableNames:    droppedTableNames,
+			ShardID:       shardID,
+			LatestVersion: latestVersion,
+		}); err != nil {
+			return errors.WithMessagef(err, "drop tables metadata, shardID:%d", shardID)
+		}
+	}
+
+	return dispatchErr
+}
@@ -0,0 +1,148 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package batchdroptable_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/lock"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl/batchdroptable"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl/createtable"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/CeresDB/horaemeta/server/id"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestBatchDropTable(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	dispatch := test.MockDispatch{}
+	c := test.InitStableCluster(ctx, t)
+
+	shardNodes := c.GetMetadata().GetClusterSnapshot().Topology.ClusterView.ShardNodes
+	testTableNum := 20
+	tableNames := make([]string, 0, testTableNum)
+	for i := 0; i < testTableNum; i++ {
+		// Spread the tables over the shards.
+		shardNode := shardNodes[i%len(shardNodes)]
+		tableName := fmt.Sprintf("%s_%d", test.TestTableName0, i)
+		p, err := createtable.NewProcedure(createtable.ProcedureParams{
+			Dispatch:        dispatch,
+			ClusterMetadata: c.GetMetadata(),
+			ClusterSnapshot: c.GetMetadata().GetClusterSnapshot(),
+			ID:              uint64(i),
+			ShardID:         shardNode.ID,
+			SourceReq: &metaservicepb.CreateTableRequest{
+				Header: &metaservicepb.RequestHeader{
+					Node:        shardNode.NodeName,
+					ClusterName: test.ClusterName,
+				},
+				SchemaName: test.TestSchemaName,
+				Name:       tableName,
+			},
+			OnSucceeded: func(_ metadata.CreateTableResult) error {
+				return nil
+			},
+			OnFailed: func(err error) error {
+				panic(fmt.Sprintf("create table failed, err:%v", err))
+			},
+		})
+		re.NoError(err)
+		re.NoError(p.Start(ctx))
+		tableNames = append(tableNames, tableName)
+	}
+
+	tables, err := c.GetMetadata().GetTablesByPrefix(test.TestSchemaName, test.TestTableName0)
+	re.NoError(err)
+	re.Equal(testTableNum, len(tables))
+
+	snapshot := c.GetMetadata().GetClusterSnapshot()
+	p, err := batchdroptable.NewProcedure(batchdroptable.ProcedureParams{
+		ID:              uint64(testTableNum),
+		Dispatch:        dispatch,
+		ClusterMetadata: c.GetMetadata(),
+		ClusterSnapshot: snapshot,
+		SchemaName:      test.TestSchemaName,
+		Tables:          tables,
+	})
+	re.NoError(err)
+	re.Equal(len(shardNodes), len(p.RelatedVersionInfo().ShardWithVersion))
+	re.NoError(p.Start(ctx))
+
+	// Check tables not exist.
+	for _, tableName := range tableNames {
+		_, exists, err := c.GetMetadata().GetTable(test.TestSchemaName, tableName)
+		re.NoError(err)
+		re.False(exists)
+	}
+
+	// Check no table is left on the shards.
+	shardIDs := make([]storage.ShardID, 0, len(snapshot.Topology.ShardViewsMapping))
+	for shardID := range snapshot.Topology.ShardViewsMapping {
+		shardIDs = append(shardIDs, shardID)
+	}
+	for _, shardTables := range c.GetMetadata().GetShardTables(shardIDs) {
+		re.Equal(0, len(shardTables.Tables))
+	}
+}
+
+func TestBatchDropTableLockedResourcesCaseInsensitive(t *testing.T) {
+	re := require.New(t)
+	clusterMetadata := metadata.NewClusterMetadata(zap.NewNop(), storage.Cluster{
+		ID:                          0,
+		Name:                        test.ClusterName,
+		MinNodeCount:                test.DefaultNodeCount,
+		ShardTotal:                  test.DefaultShardTotal,
+		TopologyType:                test.DefaultTopologyType,
+		ProcedureExecutingBatchSize: test.DefaultProcedureExecutingBatchSize,
+		CaseInsensitiveName:         true,
+		DefaultSchemaName:           "",
+		HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0},
+		Quota:                       storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0},
+		CordonedNodes:               nil,
+		SchemaCreationPolicy:        storage.SchemaCreationPolicyAuto,
+		Protected:                   false,
+		CreatedAt:                   0,
+		ModifiedAt:                  0,
+	}, nil, nil, test.TestRootPath, id.NewResourceAllocatorOptions(test.DefaultIDAllocatorStep), clock.NewRealClock())
+
+	p, err := batchdroptable.NewProcedure(batchdroptable.ProcedureParams{
+		ID:              1,
+		Dispatch:        test.MockDispatch{},
+		ClusterMetadata: clusterMetadata,
+		ClusterSnapshot: test.InitStableCluster(context.Background(), t).GetMetadata().GetClusterSnapshot(),
+		SchemaName:      "Schema",
+		Tables: []storage.Table{
+			{ID: 1000, Name: "Table", SchemaID: 0, CreatedAt: 0, PartitionInfo: storage.PartitionInfo{Info: nil}, Version: 0},
+			{ID: 1001, Name: "table", SchemaID: 0, CreatedAt: 0, PartitionInfo: storage.PartitionInfo{Info: nil}, Version: 0},
+		},
+	})
+	re.NoError(err)
+
+	// The names in different cases are the same table, which is locked once.
+	resources := p.(procedure.ResourceLocker).LockedResources()
+	re.Equal([]lock.Resource{lock.TableResource("schema", "table")}, resources)
+	re.True(lock.NewResourceLock().TryLock(resources))
+}
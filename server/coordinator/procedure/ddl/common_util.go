@@ -168,3 +168,50 @@ func DropTableOnShard(ctx context.Context, clusterMetadata *metadata.ClusterMeta
 
 	return latestVersion, nil
 }
+
+// DropTablesOnShard dispatches the drops of the tables on the same shard as one batch, so the shard nodes are resolved
+// once for the whole batch. The node drops a single table per request, so the drops are sent in order and every drop
+// carries the shard version returned by the previous one. The names of the tables dropped before the failure are returned
+// with the latest shard version, so that their metadata can still be removed.
+func DropTablesOnShard(ctx context.Context, clusterMetadata *metadata.ClusterMetadata, dispatch eventdispatch.Dispatch, schemaName string, tables []storage.Table, version metadata.ShardVersionUpdate) ([]string, uint64, error) {
+	shardNodes, err := clusterMetadata.GetShardNodesByShardID(version.ShardID)
+	if err != nil {
+		return nil, version.LatestVersion, errors.WithMessage(err, "cluster get shard by shard id")
+	}
+
+	droppedTableNames := make([]string, 0, len(tables))
+	latestVersion := version.LatestVersion
+	for _, table := range tables {
+		request := eventdispatch.DropTableOnShardRequest{
+			UpdateShardInfo: eventdispatch.UpdateShardInfo{
+				CurrShardInfo: metadata.ShardInfo{
+					ID:      version.ShardID,
+					Role:    storage.ShardRoleLeader,
+					Version: latestVersion,
+					// FIXME: We have no need to update the status, but it must be set. Maybe we should provide another struct without status field.
+					Status: storage.ShardStatusUnknown,
+				},
+			},
+			TableInfo: metadata.TableInfo{
+				ID:            table.ID,
+				Name:          table.Name,
+				SchemaID:      table.SchemaID,
+				SchemaName:    schemaName,
+				PartitionInfo: storage.PartitionInfo{Info: nil},
+				CreatedAt:     0,
+			},
+		}
+
+		var tableVersion uint64
+		for _, shardNode := range shardNodes {
+			tableVersion, err = dispatch.DropTableOnShard(ctx, shardNode.NodeName, request)
+			if err != nil {
+				return droppedTableNames, latestVersion, errors.WithMessagef(err, "dispatch drop table on shard, table:%s", table.Name)
+			}
+		}
+		latestVersion = tableVersion
+		droppedTableNames = append(droppedTableNames, table.Name)
+	}
+
+	return droppedTableNames, latestVersion, nil
+}
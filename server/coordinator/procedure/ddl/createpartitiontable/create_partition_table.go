@@ -20,19 +20,23 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
 	"github.com/CeresDB/horaemeta/pkg/assert"
 	"github.com/CeresDB/horaemeta/pkg/log"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/lock"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl"
 	"github.com/CeresDB/horaemeta/server/storage"
 	"github.com/looplab/fsm"
 	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 )
 
 // fsm state change:
@@ -63,14 +67,55 @@ var (
 	}
 )
 
+// The steps of creating a sub table, which are persisted so that the creation can be completed on resume or rolled back.
+const (
+	subTableStepPending         = "pending"
+	subTableStepMetadataCreated = "metadataCreated"
+	subTableStepCreatedOnShard  = "createdOnShard"
+	subTableStepCreated         = "created"
+	subTableStepRolledBack      = "rolledBack"
+)
+
+type subTableProgress struct {
+	Name    string
+	ShardID storage.ShardID
+	TableID storage.TableID
+	Step    string
+}
+
+// RollbackResult is the outcome of rolling back the partition table and its sub tables after the creation fails.
+type RollbackResult struct {
+	// RolledBackTables are the tables removed from the metadata and the shards, including the partition table.
+	RolledBackTables []string
+	// FailedTables are the tables failed to be rolled back, which should be dropped manually.
+	FailedTables []string
+	Errors       []string
+}
+
+func (r RollbackResult) Succeeded() bool {
+	return len(r.FailedTables) == 0
+}
+
+func (r RollbackResult) String() string {
+	if r.Succeeded() {
+		return fmt.Sprintf("rolled back tables:[%s]", strings.Join(r.RolledBackTables, ","))
+	}
+	return fmt.Sprintf("rolled back tables:[%s], failed tables:[%s], errors:[%s]", strings.Join(r.RolledBackTables, ","), strings.Join(r.FailedTables, ","), strings.Join(r.Errors, "; "))
+}
+
 type Procedure struct {
 	fsm                        *fsm.FSM
 	params                     ProcedureParams
 	relatedVersionInfo         procedure.RelatedVersionInfo
 	createPartitionTableResult *metadata.CreateTableMetadataResult
 
-	lock  sync.RWMutex
-	state procedure.State
+	// persistLock serializes the persistence, so that the progress persisted later is never older.
+	persistLock sync.Mutex
+
+	lock           sync.RWMutex
+	state          procedure.State
+	subTables      []subTableProgress
+	rollbackResult *RollbackResult
 }
 
 type ProcedureParams struct {
@@ -91,7 +136,9 @@ func NewProcedure(params ProcedureParams) (procedure.Procedure, error) {
 		return nil, err
 	}
 
-	fsm := fsm.NewFSM(
+	fsm := procedure.NewFSM(
+		procedure.CreatePartitionTable,
+		params.ClusterMetadata.Clock(),
 		stateBegin,
 		createPartitionTableEvents,
 		createPartitionTableCallbacks,
@@ -102,11 +149,90 @@ func NewProcedure(params ProcedureParams) (procedure.Procedure, error) {
 		params:                     params,
 		relatedVersionInfo:         relatedVersionInfo,
 		createPartitionTableResult: nil,
+		persistLock:                sync.Mutex{},
 		lock:                       sync.RWMutex{},
 		state:                      procedure.StateInit,
+		subTables:                  nil,
+		rollbackResult:             nil,
 	}, nil
 }
 
+// NewProcedureFromMeta resumes the procedure from the persisted meta, where the sub tables created already are skipped.
+func NewProcedureFromMeta(params ProcedureParams, meta procedure.Meta) (procedure.Procedure, error) {
+	var data rawData
+	if err := json.Unmarshal(meta.RawData, &data); err != nil {
+		return nil, procedure.ErrDecodeRawData.WithCausef("unmarshal raw data, procedureID:%d, err:%v", meta.ID, err)
+	}
+	if data.RollbackResult != nil {
+		return nil, procedure.ErrDecodeRawData.WithCausef("procedure is rolled back already, procedureID:%d", meta.ID)
+	}
+
+	relatedVersionInfo, err := buildRelatedVersionInfo(params)
+	if err != nil {
+		return nil, err
+	}
+
+	fsmState := data.FsmState
+	if len(fsmState) == 0 {
+		fsmState = stateBegin
+	}
+	// The progress of the sub tables is persisted in the callback, where the state of the state machine is the destination
+	// already, so the sub tables are created again unless all of them are created.
+	if fsmState == stateCreateSubTables {
+		for _, subTable := range data.SubTables {
+			if subTable.Step != subTableStepCreated {
+				fsmState = stateCreatePartitionTable
+				break
+			}
+		}
+	}
+	fsm := procedure.NewFSM(
+		procedure.CreatePartitionTable,
+		params.ClusterMetadata.Clock(),
+		fsmState,
+		createPartitionTableEvents,
+		createPartitionTableCallbacks,
+	)
+
+	var createPartitionTableResult *metadata.CreateTableMetadataResult
+	if data.CreateTableResult != nil {
+		createPartitionTableResult = &metadata.CreateTableMetadataResult{Table: data.CreateTableResult.Table}
+	}
+
+	return &Procedure{
+		fsm:                        fsm,
+		params:                     params,
+		relatedVersionInfo:         relatedVersionInfo,
+		createPartitionTableResult: createPartitionTableResult,
+		persistLock:                sync.Mutex{},
+		lock:                       sync.RWMutex{},
+		state:                      procedure.StateInit,
+		subTables:                  data.SubTables,
+		rollbackResult:             nil,
+	}, nil
+}
+
+// ParamsFromMeta restores the source request and the shards of the sub tables persisted in the meta into the params, so
+// that the procedure left unfinished by the previous leader can be resumed by NewProcedureFromMeta.
+func ParamsFromMeta(params ProcedureParams, meta procedure.Meta) (ProcedureParams, error) {
+	var data rawData
+	if err := json.Unmarshal(meta.RawData, &data); err != nil {
+		return params, procedure.ErrDecodeRawData.WithCausef("unmarshal raw data, procedureID:%d, err:%v", meta.ID, err)
+	}
+	if len(data.SourceReq) == 0 {
+		return params, procedure.ErrDecodeRawData.WithCausef("source request isn't persisted, procedureID:%d", meta.ID)
+	}
+	sourceReq := &metaservicepb.CreateTableRequest{}
+	if err := proto.Unmarshal(data.SourceReq, sourceReq); err != nil {
+		return params, procedure.ErrDecodeRawData.WithCausef("unmarshal source request, procedureID:%d, err:%v", meta.ID, err)
+	}
+
+	params.ID = meta.ID
+	params.SourceReq = sourceReq
+	params.SubTablesShards = data.SubTablesShards
+	return params, nil
+}
+
 func buildRelatedVersionInfo(params ProcedureParams) (procedure.RelatedVersionInfo, error) {
 	shardWithVersion := make(map[storage.ShardID]uint64, len(params.SubTablesShards))
 	for _, subTableShard := range params.SubTablesShards {
@@ -140,6 +266,14 @@ func (p *Procedure) Priority() procedure.Priority {
 	return procedure.PriorityLow
 }
 
+func (p *Procedure) StepInfo() procedure.StepInfo {
+	return procedure.FSMStepInfo(p.fsm)
+}
+
+func (p *Procedure) LockedResources() []lock.Resource {
+	return []lock.Resource{lock.TableResource(p.params.ClusterMetadata.NormalizeName(p.params.SourceReq.GetSchemaName()), p.params.ClusterMetadata.NormalizeName(p.params.SourceReq.GetName()))}
+}
+
 func (p *Procedure) Start(ctx context.Context) error {
 	p.updateStateWithLock(procedure.StateRunning)
 
@@ -201,6 +335,19 @@ func (p *Procedure) State() procedure.State {
 	return p.state
 }
 
+// RollbackResult returns the outcome of the rollback, and the second output parameter bool: returns false if the
+// procedure isn't rolled back.
+func (p *Procedure) RollbackResult() (RollbackResult, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	if p.rollbackResult == nil {
+		var emptyResult RollbackResult
+		return emptyResult, false
+	}
+	return *p.rollbackResult, true
+}
+
 type callbackRequest struct {
 	ctx context.Context
 	p   *Procedure
@@ -214,6 +361,10 @@ func createPartitionTableCallback(event *fsm.Event) {
 		return
 	}
 	params := req.p.params
+	// The partition table is created already if the procedure is resumed.
+	if req.p.createPartitionTableResult != nil {
+		return
+	}
 
 	createTableMetadataResult, err := params.ClusterMetadata.CreateTableMetadata(req.ctx, metadata.CreateTableMetadataRequest{
 		SchemaName:    params.SourceReq.GetSchemaName(),
@@ -227,7 +378,7 @@ func createPartitionTableCallback(event *fsm.Event) {
 	req.p.createPartitionTableResult = &createTableMetadataResult
 }
 
-// 2. Create data tables in target nodes.
+// 2. Create data tables in target nodes, and roll back the partition table and the created sub tables if any of them fails.
 func createDataTablesCallback(event *fsm.Event) {
 	req, err := procedure.GetRequestFromEvent[*callbackRequest](event)
 	if err != nil {
@@ -239,46 +390,70 @@ func createDataTablesCallback(event *fsm.Event) {
 		panic(fmt.Sprintf("shards number must be equal to sub tables number, shardNumber:%d, subTableNumber:%d", len(params.SubTablesShards), len(params.SourceReq.GetPartitionTableInfo().SubTableNames)))
 	}
 
-	shardVersions := req.p.relatedVersionInfo.ShardWithVersion
-	shardTableMetaDatas := make(map[storage.ShardID][]metadata.CreateTableMetadataRequest, 0)
-	for i, subTableShard := range params.SubTablesShards {
-		tableMetaData := metadata.CreateTableMetadataRequest{
-			SchemaName:    params.SourceReq.GetSchemaName(),
-			TableName:     params.SourceReq.GetPartitionTableInfo().SubTableNames[i],
-			PartitionInfo: storage.PartitionInfo{Info: nil},
+	subTables := req.p.initSubTables()
+	shardSubTables := make(map[storage.ShardID][]int, 0)
+	for i, subTable := range subTables {
+		if subTable.Step == subTableStepCreated {
+			continue
 		}
-		shardTableMetaDatas[subTableShard.ShardInfo.ID] = append(shardTableMetaDatas[subTableShard.ShardInfo.ID], tableMetaData)
-	}
-	succeedCh := make(chan bool)
-	errCh := make(chan error)
-	for shardID, tableMetaDatas := range shardTableMetaDatas {
-		shardVersion := shardVersions[shardID]
-		go createDataTables(req, shardID, tableMetaDatas, shardVersion, succeedCh, errCh)
+		shardSubTables[subTable.ShardID] = append(shardSubTables[subTable.ShardID], i)
 	}
 
-	goRoutineNumber := len(shardTableMetaDatas)
-	for {
-		select {
-		case err := <-errCh:
-			procedure.CancelEventWithLog(event, err, "create data tables")
-			return
-		case <-succeedCh:
-			goRoutineNumber--
-			if goRoutineNumber == 0 {
-				return
+	shardVersions := req.p.relatedVersionInfo.ShardWithVersion
+	var wg sync.WaitGroup
+	var failed atomic.Bool
+	errCh := make(chan error, len(shardSubTables))
+	for shardID, indexes := range shardSubTables {
+		wg.Add(1)
+		go func(shardID storage.ShardID, indexes []int) {
+			defer wg.Done()
+			if err := createDataTables(req, shardID, indexes, shardVersions[shardID], &failed); err != nil {
+				failed.Store(true)
+				errCh <- err
 			}
-		}
+		}(shardID, indexes)
+	}
+	// The rollback can't start until all the sub tables being created are done.
+	wg.Wait()
+	close(errCh)
+
+	if err, ok := <-errCh; ok {
+		rollbackResult := rollback(req)
+		procedure.CancelEventWithLog(event, errors.WithMessagef(err, "rollback partition table, %s", rollbackResult), "create data tables")
+		return
 	}
 }
 
-func createDataTables(req *callbackRequest, shardID storage.ShardID, tableMetaDatas []metadata.CreateTableMetadataRequest, shardVersion uint64, succeedCh chan bool, errCh chan error) {
+func createDataTables(req *callbackRequest, shardID storage.ShardID, indexes []int, shardVersion uint64, failed *atomic.Bool) error {
 	params := req.p.params
 
-	for _, tableMetaData := range tableMetaDatas {
-		result, err := params.ClusterMetadata.CreateTableMetadata(req.ctx, tableMetaData)
-		if err != nil {
-			errCh <- errors.WithMessage(err, "create table metadata")
-			return
+	for _, i := range indexes {
+		// Stop creating the rest of the sub tables once any of them fails, which will be rolled back.
+		if failed.Load() {
+			return nil
+		}
+		subTable := req.p.getSubTable(i)
+
+		var table storage.Table
+		if subTable.Step == subTableStepPending {
+			result, err := params.ClusterMetadata.CreateTableMetadata(req.ctx, metadata.CreateTableMetadataRequest{
+				SchemaName:    params.SourceReq.GetSchemaName(),
+				TableName:     subTable.Name,
+				PartitionInfo: storage.PartitionInfo{Info: nil},
+			})
+			if err != nil {
+				return errors.WithMessage(err, "create table metadata")
+			}
+			table = result.Table
+			if err := req.p.updateSubTable(req.ctx, i, table.ID, subTableStepMetadataCreated); err != nil {
+				return err
+			}
+		} else {
+			t, err := ddl.GetTableMetadata(params.ClusterMetadata, params.SourceReq.GetSchemaName(), subTable.Name)
+			if err != nil {
+				return errors.WithMessage(err, "get table metadata")
+			}
+			table = t
 		}
 
 		shardVersionUpdate := metadata.ShardVersionUpdate{
@@ -286,23 +461,114 @@ func createDataTables(req *callbackRequest, shardID storage.ShardID, tableMetaDa
 			LatestVersion: shardVersion,
 		}
 
-		latestShardVersion, err := ddl.CreateTableOnShard(req.ctx, params.ClusterMetadata, params.Dispatch, shardID, ddl.BuildCreateTableRequest(result.Table, shardVersionUpdate, params.SourceReq))
+		latestShardVersion, err := ddl.CreateTableOnShard(req.ctx, params.ClusterMetadata, params.Dispatch, shardID, ddl.BuildCreateTableRequest(table, shardVersionUpdate, params.SourceReq))
 		if err != nil {
-			errCh <- errors.WithMessage(err, "dispatch create table on shard")
-			return
+			return errors.WithMessage(err, "dispatch create table on shard")
+		}
+		if err := req.p.updateSubTable(req.ctx, i, table.ID, subTableStepCreatedOnShard); err != nil {
+			return err
 		}
 
-		err = params.ClusterMetadata.AddTableTopology(req.ctx, metadata.ShardVersionUpdate{
+		shardVersionUpdate, err = params.ClusterMetadata.AddTableTopology(req.ctx, metadata.ShardVersionUpdate{
 			ShardID:       shardID,
 			LatestVersion: latestShardVersion,
-		}, result.Table)
+		}, table)
 		if err != nil {
-			errCh <- errors.WithMessage(err, "create table metadata")
-			return
+			return errors.WithMessage(err, "create table metadata")
+		}
+		if err := req.p.updateSubTable(req.ctx, i, table.ID, subTableStepCreated); err != nil {
+			return err
+		}
+		shardVersion = shardVersionUpdate.LatestVersion
+	}
+	return nil
+}
+
+// rollback drops the created sub tables in the reverse order and then the partition table, and the outcome is persisted.
+func rollback(req *callbackRequest) RollbackResult {
+	params := req.p.params
+	schemaName := params.SourceReq.GetSchemaName()
+	result := RollbackResult{
+		RolledBackTables: []string{},
+		FailedTables:     []string{},
+		Errors:           []string{},
+	}
+	addFailure := func(tableName string, err error) {
+		log.Error("rollback table failed", zap.String("tableName", tableName), zap.Uint64("procedureID", params.ID), zap.Error(err))
+		result.FailedTables = append(result.FailedTables, tableName)
+		result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", tableName, err))
+	}
+
+	subTables := req.p.initSubTables()
+	for i := len(subTables) - 1; i >= 0; i-- {
+		subTable := subTables[i]
+		if subTable.Step == subTableStepPending || subTable.Step == subTableStepRolledBack {
+			continue
 		}
-		shardVersion++
+		if err := dropSubTable(req, subTable); err != nil {
+			addFailure(subTable.Name, err)
+			continue
+		}
+		req.p.setSubTableStep(i, subTableStepRolledBack)
+		result.RolledBackTables = append(result.RolledBackTables, subTable.Name)
+	}
+
+	if req.p.createPartitionTableResult != nil {
+		if _, err := params.ClusterMetadata.DropTableMetadata(req.ctx, schemaName, params.SourceReq.GetName()); err != nil {
+			addFailure(params.SourceReq.GetName(), errors.WithMessage(err, "drop partition table metadata"))
+		} else {
+			result.RolledBackTables = append(result.RolledBackTables, params.SourceReq.GetName())
+		}
+	}
+
+	req.p.lock.Lock()
+	req.p.rollbackResult = &result
+	req.p.lock.Unlock()
+	if err := req.p.persist(req.ctx); err != nil {
+		log.Error("persist rollback result failed", zap.Uint64("procedureID", params.ID), zap.Error(err))
 	}
-	succeedCh <- true
+
+	log.Info("rollback partition table finish", zap.String("tableName", params.SourceReq.GetName()), zap.Strings("rolledBackTables", result.RolledBackTables), zap.Strings("failedTables", result.FailedTables))
+	return result
+}
+
+// dropSubTable drops the sub table according to how far it is created.
+func dropSubTable(req *callbackRequest, subTable subTableProgress) error {
+	params := req.p.params
+	schemaName := params.SourceReq.GetSchemaName()
+
+	if subTable.Step == subTableStepMetadataCreated {
+		if _, err := params.ClusterMetadata.DropTableMetadata(req.ctx, schemaName, subTable.Name); err != nil {
+			return errors.WithMessage(err, "drop table metadata")
+		}
+		return nil
+	}
+
+	table, err := ddl.GetTableMetadata(params.ClusterMetadata, schemaName, subTable.Name)
+	if err != nil {
+		return errors.WithMessage(err, "get table metadata")
+	}
+	shardView, ok := params.ClusterMetadata.GetClusterSnapshot().Topology.ShardViewsMapping[subTable.ShardID]
+	if !ok {
+		return errors.WithMessagef(metadata.ErrShardNotFound, "shardID:%d", subTable.ShardID)
+	}
+	latestShardVersion, err := ddl.DropTableOnShard(req.ctx, params.ClusterMetadata, params.Dispatch, schemaName, table, metadata.ShardVersionUpdate{
+		ShardID:       subTable.ShardID,
+		LatestVersion: shardView.Version,
+	})
+	if err != nil {
+		return errors.WithMessage(err, "dispatch drop table on shard")
+	}
+	// The table is removed from the shard view even if it isn't added yet, which keeps the shard version in sync.
+	if err := params.ClusterMetadata.DropTable(req.ctx, metadata.DropTableRequest{
+		SchemaName:    schemaName,
+		TableName:     subTable.Name,
+		ShardID:       subTable.ShardID,
+		LatestVersion: latestShardVersion,
+	}); err != nil {
+		return errors.WithMessage(err, "drop table")
+	}
+	return nil
 }
 
 func finishCallback(event *fsm.Event) {
@@ -331,7 +597,60 @@ func (p *Procedure) updateStateWithLock(state procedure.State) {
 	p.state = state
 }
 
+// initSubTables initializes the progress of the sub tables if it isn't restored from the persisted procedure.
+func (p *Procedure) initSubTables() []subTableProgress {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.subTables == nil {
+		subTableNames := p.params.SourceReq.GetPartitionTableInfo().SubTableNames
+		p.subTables = make([]subTableProgress, 0, len(subTableNames))
+		for i, subTableShard := range p.params.SubTablesShards {
+			p.subTables = append(p.subTables, subTableProgress{
+				Name:    subTableNames[i],
+				ShardID: subTableShard.ShardInfo.ID,
+				TableID: 0,
+				Step:    subTableStepPending,
+			})
+		}
+	}
+	subTables := make([]subTableProgress, len(p.subTables))
+	copy(subTables, p.subTables)
+	return subTables
+}
+
+func (p *Procedure) getSubTable(i int) subTableProgress {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.subTables[i]
+}
+
+func (p *Procedure) setSubTableStep(i int, step string) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.subTables[i].Step = step
+}
+
+// updateSubTable records the step of the sub table and persists the progress.
+func (p *Procedure) updateSubTable(ctx context.Context, i int, tableID storage.TableID, step string) error {
+	p.lock.Lock()
+	p.subTables[i].TableID = tableID
+	p.subTables[i].Step = step
+	tableName := p.subTables[i].Name
+	p.lock.Unlock()
+
+	if err := p.persist(ctx); err != nil {
+		return errors.WithMessagef(err, "persist sub table progress, tableName:%s, step:%s", tableName, step)
+	}
+	return nil
+}
+
 func (p *Procedure) persist(ctx context.Context) error {
+	p.persistLock.Lock()
+	defer p.persistLock.Unlock()
+
 	meta, err := p.convertToMeta()
 	if err != nil {
 		return errors.WithMessage(err, "convert to meta")
@@ -352,19 +671,38 @@ type rawData struct {
 	CreateTableResult    *metadata.CreateTableResult
 	PartitionTableShards []metadata.ShardNodeWithVersion
 	SubTablesShards      []metadata.ShardNodeWithVersion
+	SubTables            []subTableProgress
+	RollbackResult       *RollbackResult
+	// SourceReq is the request encoded by protobuf, which is required to resume the procedure.
+	SourceReq []byte
 }
 
 func (p *Procedure) convertToMeta() (procedure.Meta, error) {
 	p.lock.RLock()
 	defer p.lock.RUnlock()
 
+	var createTableResult *metadata.CreateTableResult
+	if p.createPartitionTableResult != nil {
+		createTableResult = &metadata.CreateTableResult{
+			Table:              p.createPartitionTableResult.Table,
+			ShardVersionUpdate: metadata.ShardVersionUpdate{ShardID: 0, LatestVersion: 0},
+		}
+	}
+	sourceReq, err := proto.Marshal(p.params.SourceReq)
+	if err != nil {
+		var emptyMeta procedure.Meta
+		return emptyMeta, procedure.ErrEncodeRawData.WithCausef("marshal source request, procedureID:%v, err:%v", p.params.ID, err)
+	}
 	rawData := rawData{
 		ID:                   p.params.ID,
 		FsmState:             p.fsm.Current(),
 		State:                p.state,
-		CreateTableResult:    nil,
+		CreateTableResult:    createTableResult,
 		PartitionTableShards: []metadata.ShardNodeWithVersion{},
 		SubTablesShards:      p.params.SubTablesShards,
+		SubTables:            p.subTables,
+		RollbackResult:       p.rollbackResult,
+		SourceReq:            sourceReq,
 	}
 	rawDataBytes, err := json.Marshal(rawData)
 	if err != nil {
@@ -377,8 +715,27 @@ func (p *Procedure) convertToMeta() (procedure.Meta, error) {
 		Kind:  procedure.CreatePartitionTable,
 		State: p.state,
 
-		RawData: rawDataBytes,
+		RawData:   rawDataBytes,
+		UpdatedAt: 0,
 	}
 
 	return meta, nil
 }
+
+// NewReplayer returns the replayer re-driving the persisted procedure in the dry-run mode.
+func NewReplayer() procedure.Replayer {
+	return procedure.Replayer{
+		Events:       createPartitionTableEvents,
+		InitialState: stateBegin,
+		FinishState:  stateFinish,
+		Decode:       decodeRawData,
+	}
+}
+
+func decodeRawData(rawDataBytes []byte) (string, procedure.Params, error) {
+	var data rawData
+	if err := json.Unmarshal(rawDataBytes, &data); err != nil {
+		return "", nil, err
+	}
+	return data.FsmState, procedure.Params{}, nil
+}
@@ -18,15 +18,20 @@ package createpartitiontable_test
 
 import (
 	"context"
+	"encoding/json"
+	"sync"
 	"testing"
 
 	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator"
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl/createpartitiontable"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
 	"github.com/CeresDB/horaemeta/server/storage"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
 )
 
 func TestCreatePartitionTable(t *testing.T) {
@@ -89,3 +94,184 @@ func TestCreatePartitionTable(t *testing.T) {
 	err = procedure.Start(ctx)
 	re.NoError(err)
 }
+
+// failingDispatch fails creating the table of the name on the shard, and counts the tables created and dropped.
+type failingDispatch struct {
+	test.MockDispatch
+	failedTableName string
+
+	lock          sync.Mutex
+	createdTables []string
+	droppedTables []string
+}
+
+func (d *failingDispatch) CreateTableOnShard(_ context.Context, _ string, request eventdispatch.CreateTableOnShardRequest) (uint64, error) {
+	if request.TableInfo.Name == d.failedTableName {
+		return 0, metadata.ErrShardNotFound
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.createdTables = append(d.createdTables, request.TableInfo.Name)
+	return 0, nil
+}
+
+func (d *failingDispatch) DropTableOnShard(_ context.Context, _ string, request eventdispatch.DropTableOnShardRequest) (uint64, error) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.droppedTables = append(d.droppedTables, request.TableInfo.Name)
+	return 0, nil
+}
+
+// metasStorage keeps all the persisted metas.
+type metasStorage struct {
+	test.MockStorage
+
+	lock  sync.Mutex
+	metas []procedure.Meta
+}
+
+func (s *metasStorage) CreateOrUpdate(_ context.Context, meta procedure.Meta) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.metas = append(s.metas, meta)
+	return nil
+}
+
+func newSameShardParams(c *metadata.ClusterMetadata, dispatch eventdispatch.Dispatch, s procedure.Storage, subTableNames []string) createpartitiontable.ProcedureParams {
+	snapshot := c.GetClusterSnapshot()
+	shardNode := snapshot.Topology.ClusterView.ShardNodes[0]
+	shardView := snapshot.Topology.ShardViewsMapping[shardNode.ID]
+	subTablesShards := make([]metadata.ShardNodeWithVersion, 0, len(subTableNames))
+	for range subTableNames {
+		subTablesShards = append(subTablesShards, metadata.ShardNodeWithVersion{
+			ShardInfo: metadata.ShardInfo{
+				ID:      shardView.ShardID,
+				Role:    shardNode.ShardRole,
+				Version: shardView.Version,
+				Status:  storage.ShardStatusUnknown,
+			},
+			ShardNode: shardNode,
+		})
+	}
+
+	return createpartitiontable.ProcedureParams{
+		ID:              1,
+		ClusterMetadata: c,
+		ClusterSnapshot: snapshot,
+		Dispatch:        dispatch,
+		Storage:         s,
+		SourceReq: &metaservicepb.CreateTableRequest{
+			Header: &metaservicepb.RequestHeader{
+				Node:        shardNode.NodeName,
+				ClusterName: test.ClusterName,
+			},
+			PartitionTableInfo: &metaservicepb.PartitionTableInfo{
+				SubTableNames: subTableNames,
+			},
+			SchemaName: test.TestSchemaName,
+			Name:       test.TestTableName0,
+		},
+		SubTablesShards: subTablesShards,
+		OnSucceeded: func(result metadata.CreateTableResult) error {
+			return nil
+		},
+		OnFailed: func(err error) error {
+			return nil
+		},
+	}
+}
+
+func TestCreatePartitionTableRollback(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	c := test.InitStableCluster(ctx, t)
+	dispatch := &failingDispatch{MockDispatch: test.MockDispatch{}, failedTableName: "p2", lock: sync.Mutex{}, createdTables: nil, droppedTables: nil}
+	s := &metasStorage{MockStorage: test.MockStorage{}, lock: sync.Mutex{}, metas: nil}
+
+	var failedErr error
+	params := newSameShardParams(c.GetMetadata(), dispatch, s, []string{"p1", "p2", "p3"})
+	params.OnFailed = func(err error) error {
+		failedErr = err
+		return nil
+	}
+	p, err := createpartitiontable.NewProcedure(params)
+	re.NoError(err)
+	re.Error(p.Start(ctx))
+	re.Error(failedErr)
+	re.Contains(failedErr.Error(), "rolled back tables")
+
+	// The sub table created on the shard is dropped, and the one failed is removed from the metadata only.
+	result, ok := p.(*createpartitiontable.Procedure).RollbackResult()
+	re.True(ok)
+	re.True(result.Succeeded())
+	re.Equal([]string{"p2", "p1", test.TestTableName0}, result.RolledBackTables)
+	re.Equal([]string{"p1"}, dispatch.createdTables)
+	re.Equal([]string{"p1"}, dispatch.droppedTables)
+	for _, tableName := range []string{"p1", "p2", "p3", test.TestTableName0} {
+		_, exists, err := c.GetMetadata().GetTable(test.TestSchemaName, tableName)
+		re.NoError(err)
+		re.False(exists)
+	}
+
+	// The rollback outcome is persisted.
+	var data struct {
+		RollbackResult *createpartitiontable.RollbackResult
+	}
+	re.NoError(json.Unmarshal(s.metas[len(s.metas)-1].RawData, &data))
+	re.Equal(&result, data.RollbackResult)
+}
+
+func TestResumeCreatePartitionTable(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	c := test.InitStableCluster(ctx, t)
+	dispatch := &failingDispatch{MockDispatch: test.MockDispatch{}, failedTableName: "", lock: sync.Mutex{}, createdTables: nil, droppedTables: nil}
+	s := &metasStorage{MockStorage: test.MockStorage{}, lock: sync.Mutex{}, metas: nil}
+
+	p, err := createpartitiontable.NewProcedure(newSameShardParams(c.GetMetadata(), dispatch, s, []string{"p1", "p2"}))
+	re.NoError(err)
+	re.NoError(p.Start(ctx))
+
+	// Find the progress persisted once the first sub table is created.
+	type subTableProgress struct {
+		Name string
+		Step string
+	}
+	var resumedMeta *procedure.Meta
+	for i := range s.metas {
+		var data struct {
+			SubTables []subTableProgress
+		}
+		re.NoError(json.Unmarshal(s.metas[i].RawData, &data))
+		if len(data.SubTables) == 2 && data.SubTables[0].Step == "created" && data.SubTables[1].Step == "pending" {
+			resumedMeta = &s.metas[i]
+			break
+		}
+	}
+	re.NotNil(resumedMeta)
+
+	// Drop the second sub table as if the procedure is interrupted before creating it.
+	shardID := c.GetMetadata().GetClusterSnapshot().Topology.ClusterView.ShardNodes[0].ID
+	re.NoError(c.GetMetadata().DropTable(ctx, metadata.DropTableRequest{SchemaName: test.TestSchemaName, TableName: "p2", ShardID: shardID, LatestVersion: 0}))
+
+	// The resumed procedure creates the rest of the sub tables only.
+	resumedDispatch := &failingDispatch{MockDispatch: test.MockDispatch{}, failedTableName: "", lock: sync.Mutex{}, createdTables: nil, droppedTables: nil}
+	// The source request and the shards of the sub tables are restored from the meta.
+	params := newSameShardParams(c.GetMetadata(), resumedDispatch, s, []string{"p1", "p2"})
+	sourceReq := params.SourceReq
+	params.SourceReq = nil
+	params.SubTablesShards = nil
+	params, err = createpartitiontable.ParamsFromMeta(params, *resumedMeta)
+	re.NoError(err)
+	re.True(proto.Equal(sourceReq, params.SourceReq))
+	re.Len(params.SubTablesShards, 2)
+	resumed, err := createpartitiontable.NewProcedureFromMeta(params, *resumedMeta)
+	re.NoError(err)
+	re.NoError(resumed.Start(ctx))
+	re.Equal([]string{"p2"}, resumedDispatch.createdTables)
+	for _, tableName := range []string{"p1", "p2", test.TestTableName0} {
+		_, exists, err := c.GetMetadata().GetTable(test.TestSchemaName, tableName)
+		re.NoError(err)
+		re.True(exists)
+	}
+}
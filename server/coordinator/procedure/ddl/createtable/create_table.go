@@ -25,6 +25,7 @@ import (
 	"github.com/CeresDB/horaemeta/pkg/log"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/lock"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl"
 	"github.com/CeresDB/horaemeta/server/storage"
@@ -93,7 +94,7 @@ func prepareCallback(event *fsm.Event) {
 	log.Debug("dispatch createTableOnShard finish", zap.String("tableName", createTableMetadataRequest.TableName))
 
 	shardVersionUpdate.LatestVersion = latestShardVersion
-	err = params.ClusterMetadata.AddTableTopology(req.ctx, shardVersionUpdate, result.Table)
+	shardVersionUpdate, err = params.ClusterMetadata.AddTableTopology(req.ctx, shardVersionUpdate, result.Table)
 	if err != nil {
 		procedure.CancelEventWithLog(event, err, "add table topology")
 		return
@@ -152,7 +153,9 @@ type ProcedureParams struct {
 }
 
 func NewProcedure(params ProcedureParams) (procedure.Procedure, error) {
-	fsm := fsm.NewFSM(
+	fsm := procedure.NewFSM(
+		procedure.CreateTable,
+		params.ClusterMetadata.Clock(),
 		stateBegin,
 		createTableEvents,
 		createTableCallbacks,
@@ -203,6 +206,14 @@ func (p *Procedure) Priority() procedure.Priority {
 	return procedure.PriorityLow
 }
 
+func (p *Procedure) StepInfo() procedure.StepInfo {
+	return procedure.FSMStepInfo(p.fsm)
+}
+
+func (p *Procedure) LockedResources() []lock.Resource {
+	return []lock.Resource{lock.TableResource(p.params.ClusterMetadata.NormalizeName(p.params.SourceReq.GetSchemaName()), p.params.ClusterMetadata.NormalizeName(p.params.SourceReq.GetName()))}
+}
+
 func (p *Procedure) ID() uint64 {
 	return p.params.ID
 }
@@ -26,6 +26,7 @@ import (
 	"github.com/CeresDB/horaemeta/pkg/log"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/lock"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl"
 	"github.com/CeresDB/horaemeta/server/storage"
@@ -85,7 +86,9 @@ type ProcedureParams struct {
 }
 
 func NewProcedure(params ProcedureParams) (*Procedure, bool, error) {
-	fsm := fsm.NewFSM(
+	fsm := procedure.NewFSM(
+		procedure.DropPartitionTable,
+		params.ClusterMetadata.Clock(),
 		stateBegin,
 		createDropPartitionTableEvents,
 		createDropPartitionTableCallbacks,
@@ -155,6 +158,14 @@ func (p *Procedure) Priority() procedure.Priority {
 	return procedure.PriorityMed
 }
 
+func (p *Procedure) StepInfo() procedure.StepInfo {
+	return procedure.FSMStepInfo(p.fsm)
+}
+
+func (p *Procedure) LockedResources() []lock.Resource {
+	return []lock.Resource{lock.TableResource(p.params.ClusterMetadata.NormalizeName(p.params.SourceReq.GetSchemaName()), p.params.ClusterMetadata.NormalizeName(p.params.SourceReq.GetName()))}
+}
+
 func (p *Procedure) Start(ctx context.Context) error {
 	p.updateStateWithLock(procedure.StateRunning)
 
@@ -257,7 +268,8 @@ func (p *Procedure) convertToMeta() (procedure.Meta, error) {
 		Kind:  procedure.DropPartitionTable,
 		State: p.state,
 
-		RawData: rawDataBytes,
+		RawData:   rawDataBytes,
+		UpdatedAt: 0,
 	}
 
 	return meta, nil
@@ -419,3 +431,21 @@ func dispatchDropDataTable(req *callbackRequest, dispatch eventdispatch.Dispatch
 	}
 	return nil
 }
+
+// NewReplayer returns the replayer re-driving the persisted procedure in the dry-run mode.
+func NewReplayer() procedure.Replayer {
+	return procedure.Replayer{
+		Events:       createDropPartitionTableEvents,
+		InitialState: stateBegin,
+		FinishState:  stateFinish,
+		Decode:       decodeRawData,
+	}
+}
+
+func decodeRawData(rawDataBytes []byte) (string, procedure.Params, error) {
+	var data rawData
+	if err := json.Unmarshal(rawDataBytes, &data); err != nil {
+		return "", nil, err
+	}
+	return data.FsmState, procedure.Params{}, nil
+}
@@ -25,6 +25,7 @@ import (
 	"github.com/CeresDB/horaemeta/pkg/log"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/lock"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl"
 	"github.com/CeresDB/horaemeta/server/storage"
@@ -180,7 +181,9 @@ func NewDropTableProcedure(params ProcedureParams) (procedure.Procedure, bool, e
 		return nil, false, err
 	}
 
-	fsm := fsm.NewFSM(
+	fsm := procedure.NewFSM(
+		procedure.DropTable,
+		params.ClusterMetadata.Clock(),
 		stateBegin,
 		dropTableEvents,
 		dropTableCallbacks,
@@ -240,6 +243,14 @@ func (p *Procedure) Priority() procedure.Priority {
 	return procedure.PriorityLow
 }
 
+func (p *Procedure) StepInfo() procedure.StepInfo {
+	return procedure.FSMStepInfo(p.fsm)
+}
+
+func (p *Procedure) LockedResources() []lock.Resource {
+	return []lock.Resource{lock.TableResource(p.params.ClusterMetadata.NormalizeName(p.params.SourceReq.GetSchemaName()), p.params.ClusterMetadata.NormalizeName(p.params.SourceReq.GetName()))}
+}
+
 func (p *Procedure) ID() uint64 {
 	return p.params.ID
 }
@@ -22,6 +22,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/pkg/errors"
 )
 
@@ -32,6 +33,7 @@ type procedureScheduleEntry struct {
 
 type DelayQueue struct {
 	maxLen int
+	clock  clock.Clock
 
 	// This lock is used to protect the following fields.
 	lock      sync.RWMutex
@@ -85,9 +87,10 @@ func (q *heapPriorityQueue) Peek() any {
 	return item
 }
 
-func NewProcedureDelayQueue(maxLen int) *DelayQueue {
+func NewProcedureDelayQueue(maxLen int, clk clock.Clock) *DelayQueue {
 	return &DelayQueue{
 		maxLen: maxLen,
+		clock:  clk,
 
 		lock:          sync.RWMutex{},
 		heapQueue:     &heapPriorityQueue{procedures: []*procedureScheduleEntry{}},
@@ -116,7 +119,7 @@ func (q *DelayQueue) Push(p Procedure, delay time.Duration) error {
 
 	heap.Push(q.heapQueue, &procedureScheduleEntry{
 		procedure: p,
-		runAfter:  time.Now().Add(delay),
+		runAfter:  q.clock.Now().Add(delay),
 	})
 	q.existingProcs[p.ID()] = struct{}{}
 
@@ -132,7 +135,7 @@ func (q *DelayQueue) Pop() Procedure {
 	}
 
 	entry := q.heapQueue.Peek().(*procedureScheduleEntry)
-	if time.Now().Before(entry.runAfter) {
+	if q.clock.Now().Before(entry.runAfter) {
 		return nil
 	}
 
@@ -21,6 +21,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/server/storage"
 	"github.com/stretchr/testify/require"
 )
@@ -67,7 +68,8 @@ func TestDelayQueue(t *testing.T) {
 	testProcedure2 := TestProcedure{ProcedureID: 2}
 	testProcedure3 := TestProcedure{ProcedureID: 3}
 
-	queue := NewProcedureDelayQueue(3)
+	clk := clock.NewMock(time.Now())
+	queue := NewProcedureDelayQueue(3, clk)
 	err := queue.Push(testProcedure0, time.Millisecond*40)
 	re.NoError(err)
 	err = queue.Push(testProcedure0, time.Millisecond*30)
@@ -83,7 +85,7 @@ func TestDelayQueue(t *testing.T) {
 	po := queue.Pop()
 	re.Nil(po)
 
-	time.Sleep(time.Millisecond * 100)
+	clk.Advance(time.Millisecond * 100)
 
 	p0 := queue.Pop()
 	re.Equal(uint64(1), p0.ID())
@@ -97,11 +99,11 @@ func TestDelayQueue(t *testing.T) {
 	err = queue.Push(testProcedure0, time.Millisecond*20)
 	re.NoError(err)
 
-	time.Sleep(time.Millisecond * 10)
+	clk.Advance(time.Millisecond * 10)
 	p0 = queue.Pop()
 	re.Nil(p0)
 
-	time.Sleep(time.Millisecond * 10)
+	clk.Advance(time.Millisecond * 10)
 	p0 = queue.Pop()
 	re.Equal(uint64(0), p0.ID())
 }
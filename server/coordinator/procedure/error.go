@@ -19,24 +19,32 @@ package procedure
 import "github.com/CeresDB/horaemeta/pkg/coderr"
 
 var (
-	ErrShardLeaderNotFound     = coderr.NewCodeError(coderr.Internal, "shard leader not found")
-	ErrProcedureNotFound       = coderr.NewCodeError(coderr.Internal, "procedure not found")
-	ErrClusterConfigChanged    = coderr.NewCodeError(coderr.Internal, "cluster config changed")
-	ErrTableNotExists          = coderr.NewCodeError(coderr.Internal, "table not exists")
-	ErrTableAlreadyExists      = coderr.NewCodeError(coderr.Internal, "table already exists")
-	ErrListRunningProcedure    = coderr.NewCodeError(coderr.Internal, "procedure type not match")
-	ErrListProcedure           = coderr.NewCodeError(coderr.Internal, "list running procedure")
-	ErrDecodeRawData           = coderr.NewCodeError(coderr.Internal, "decode raw data")
-	ErrEncodeRawData           = coderr.NewCodeError(coderr.Internal, "encode raw data")
-	ErrGetRequest              = coderr.NewCodeError(coderr.Internal, "get request from event")
-	ErrNodeNumberNotEnough     = coderr.NewCodeError(coderr.Internal, "node number not enough")
-	ErrEmptyPartitionNames     = coderr.NewCodeError(coderr.Internal, "partition names is empty")
-	ErrDropTableResult         = coderr.NewCodeError(coderr.Internal, "length of shard not correct")
-	ErrPickShard               = coderr.NewCodeError(coderr.Internal, "pick shard failed")
-	ErrSubmitProcedure         = coderr.NewCodeError(coderr.Internal, "submit new procedure")
-	ErrQueueFull               = coderr.NewCodeError(coderr.Internal, "queue is full, unable to offer more data")
-	ErrPushDuplicatedProcedure = coderr.NewCodeError(coderr.Internal, "try to push duplicated procedure")
-	ErrShardNumberNotEnough    = coderr.NewCodeError(coderr.Internal, "shard number not enough")
-	ErrEmptyBatchProcedure     = coderr.NewCodeError(coderr.Internal, "procedure batch is empty")
-	ErrMergeBatchProcedure     = coderr.NewCodeError(coderr.Internal, "failed to merge procedures batch")
+	ErrShardLeaderNotFound       = coderr.NewCodeError(coderr.Internal, "shard leader not found")
+	ErrProcedureNotFound         = coderr.NewCodeError(coderr.NotFound, "procedure not found")
+	ErrClusterConfigChanged      = coderr.NewCodeError(coderr.Internal, "cluster config changed")
+	ErrTableNotExists            = coderr.NewCodeError(coderr.Internal, "table not exists")
+	ErrTableAlreadyExists        = coderr.NewCodeError(coderr.Internal, "table already exists")
+	ErrListRunningProcedure      = coderr.NewCodeError(coderr.Internal, "procedure type not match")
+	ErrListProcedure             = coderr.NewCodeError(coderr.Internal, "list running procedure")
+	ErrDecodeRawData             = coderr.NewCodeError(coderr.Internal, "decode raw data")
+	ErrEncodeRawData             = coderr.NewCodeError(coderr.Internal, "encode raw data")
+	ErrGetRequest                = coderr.NewCodeError(coderr.Internal, "get request from event")
+	ErrNodeNumberNotEnough       = coderr.NewCodeError(coderr.Internal, "node number not enough")
+	ErrEmptyPartitionNames       = coderr.NewCodeError(coderr.Internal, "partition names is empty")
+	ErrDropTableResult           = coderr.NewCodeError(coderr.Internal, "length of shard not correct")
+	ErrPickShard                 = coderr.NewCodeError(coderr.Internal, "pick shard failed")
+	ErrSubmitProcedure           = coderr.NewCodeError(coderr.Internal, "submit new procedure")
+	ErrQueueFull                 = coderr.NewCodeError(coderr.Internal, "queue is full, unable to offer more data")
+	ErrPushDuplicatedProcedure   = coderr.NewCodeError(coderr.Internal, "try to push duplicated procedure")
+	ErrShardNumberNotEnough      = coderr.NewCodeError(coderr.Internal, "shard number not enough")
+	ErrEmptyBatchProcedure       = coderr.NewCodeError(coderr.Internal, "procedure batch is empty")
+	ErrMergeBatchProcedure       = coderr.NewCodeError(coderr.Internal, "failed to merge procedures batch")
+	ErrBatchDropPartitionTable   = coderr.NewCodeError(coderr.Internal, "partition table can not be dropped in batch")
+	ErrBatchCreatePartitionTable = coderr.NewCodeError(coderr.Internal, "partition table can not be created in batch")
+	ErrEmptyRepairActions        = coderr.NewCodeError(coderr.Internal, "repair actions is empty")
+	ErrUnknownRepairAction       = coderr.NewCodeError(coderr.Internal, "unknown repair action")
+	ErrShardFollowerNotFound     = coderr.NewCodeError(coderr.Internal, "shard follower not found")
+	ErrInvalidParams             = coderr.NewCodeError(coderr.InvalidParams, "invalid procedure params")
+	ErrTopologyTypeNotChanged    = coderr.NewCodeError(coderr.InvalidParams, "topology type not changed")
+	ErrShardTotalNotChanged      = coderr.NewCodeError(coderr.InvalidParams, "shard total not changed")
 )
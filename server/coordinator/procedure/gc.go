@@ -0,0 +1,223 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package procedure
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	gcScanBatchSize = 100
+	gcStateDeleted  = "deleted"
+)
+
+type GCOptions struct {
+	// Interval is the interval between the gc rounds, and 0 disables the gc.
+	Interval time.Duration
+	// Retention is how long the procedures are kept after they are done or marked deleted.
+	Retention time.Duration
+	// MaxOpsPerTxn bounds the keys deleted in a txn.
+	MaxOpsPerTxn int
+}
+
+// GCWorker deletes the procedures which are done or marked deleted beyond the retention. It is started along with the
+// cluster, so it only runs on the leader.
+type GCWorker struct {
+	logger      *zap.Logger
+	storage     Storage
+	clusterName string
+	clock       clock.Clock
+	opts        GCOptions
+
+	// firstScannedAt records when the metas without the update time are scanned first, which is taken as their update
+	// time, so that the procedures persisted before the update time is recorded aren't deleted at once. It is only
+	// accessed by Collect.
+	firstScannedAt map[gcKey]int64
+
+	// Mutex is used to protect following fields.
+	lock   sync.Mutex
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// gcKey identifies the meta scanned by the gc.
+type gcKey struct {
+	deleted bool
+	kind    Kind
+	id      uint64
+}
+
+func NewGCWorker(logger *zap.Logger, storage Storage, clusterName string, clk clock.Clock, opts GCOptions) *GCWorker {
+	return &GCWorker{
+		logger:         logger,
+		storage:        storage,
+		clusterName:    clusterName,
+		clock:          clk,
+		opts:           opts,
+		firstScannedAt: map[gcKey]int64{},
+		lock:           sync.Mutex{},
+		cancel:         nil,
+		wg:             sync.WaitGroup{},
+	}
+}
+
+func (w *GCWorker) Start(_ context.Context) error {
+	if w.opts.Interval <= 0 {
+		w.logger.Info("procedure gc is disabled")
+		return nil
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancel = cancel
+	w.wg.Add(1)
+	go func() {
+		defer w.wg.Done()
+		w.run(ctx)
+	}()
+	return nil
+}
+
+func (w *GCWorker) Stop(_ context.Context) error {
+	w.lock.Lock()
+	cancel := w.cancel
+	w.cancel = nil
+	w.lock.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	w.wg.Wait()
+	return nil
+}
+
+func (w *GCWorker) run(ctx context.Context) {
+	ticker := time.NewTicker(w.opts.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reclaimed, err := w.Collect(ctx, w.clock.Now())
+			if err != nil {
+				gcFailures.WithLabelValues(w.clusterName).Inc()
+				w.logger.Error("procedure gc failed", zap.Int("reclaimed", reclaimed), zap.Error(err))
+				continue
+			}
+			if reclaimed > 0 {
+				w.logger.Info("procedure gc finished", zap.Int("reclaimed", reclaimed))
+			}
+		}
+	}
+}
+
+// Collect runs a gc round and returns the number of the deleted keys, which are counted even if the round fails midway.
+// The procedures updated before the update time is recorded are regarded as updated when they are scanned first.
+func (w *GCWorker) Collect(ctx context.Context, now time.Time) (int, error) {
+	expiredBefore := now.Add(-w.opts.Retention).UnixMilli()
+	scanned := make(map[gcKey]struct{})
+	defer func() {
+		for key := range w.firstScannedAt {
+			if _, ok := scanned[key]; !ok {
+				delete(w.firstScannedAt, key)
+			}
+		}
+	}()
+
+	metas, err := w.storage.ListAll(ctx, false, gcScanBatchSize)
+	if err != nil {
+		return 0, errors.WithMessage(err, "list procedures")
+	}
+	doneMetas := make([]*Meta, 0)
+	for _, meta := range metas {
+		if isDone(meta.State) && w.updatedAt(now, scanned, false, meta) < expiredBefore {
+			doneMetas = append(doneMetas, meta)
+		}
+	}
+	reclaimed, err := w.delete(ctx, false, doneMetas)
+	if err != nil {
+		return reclaimed, errors.WithMessage(err, "delete done procedures")
+	}
+
+	metas, err = w.storage.ListAll(ctx, true, gcScanBatchSize)
+	if err != nil {
+		return reclaimed, errors.WithMessage(err, "list deleted procedures")
+	}
+	deletedMetas := make([]*Meta, 0)
+	for _, meta := range metas {
+		if w.updatedAt(now, scanned, true, meta) < expiredBefore {
+			deletedMetas = append(deletedMetas, meta)
+		}
+	}
+	n, err := w.delete(ctx, true, deletedMetas)
+	reclaimed += n
+	if err != nil {
+		return reclaimed, errors.WithMessage(err, "delete marked deleted procedures")
+	}
+	return reclaimed, nil
+}
+
+// updatedAt returns the update time of the meta, which is the time it is scanned first if the update time isn't recorded.
+func (w *GCWorker) updatedAt(now time.Time, scanned map[gcKey]struct{}, deleted bool, meta *Meta) int64 {
+	if meta.UpdatedAt > 0 {
+		return meta.UpdatedAt
+	}
+
+	key := gcKey{deleted: deleted, kind: meta.Kind, id: meta.ID}
+	scanned[key] = struct{}{}
+	firstScannedAt, ok := w.firstScannedAt[key]
+	if !ok {
+		firstScannedAt = now.UnixMilli()
+		w.firstScannedAt[key] = firstScannedAt
+	}
+	return firstScannedAt
+}
+
+func (w *GCWorker) delete(ctx context.Context, deleted bool, metas []*Meta) (int, error) {
+	batchSize := max(w.opts.MaxOpsPerTxn, 1)
+	reclaimed := 0
+	for start := 0; start < len(metas); start += batchSize {
+		batch := metas[start:min(start+batchSize, len(metas))]
+		if err := w.storage.BatchDelete(ctx, deleted, batch); err != nil {
+			return reclaimed, err
+		}
+
+		reclaimed += len(batch)
+		for _, meta := range batch {
+			state := string(meta.State)
+			if deleted {
+				state = gcStateDeleted
+			}
+			gcReclaimedKeys.WithLabelValues(w.clusterName, state).Inc()
+		}
+	}
+	return reclaimed, nil
+}
+
+func isDone(state State) bool {
+	return state == StateFinished || state == StateFailed || state == StateCancelled
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package procedure
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestGCWorker(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	clk := clock.NewMock(time.Now())
+	storage := NewTestStorageWithClock(t, clk)
+	metas := []Meta{
+		{ID: 1, Kind: TransferLeader, State: StateFinished, RawData: []byte("test"), UpdatedAt: 0},
+		{ID: 2, Kind: TransferLeader, State: StateRunning, RawData: []byte("test"), UpdatedAt: 0},
+		{ID: 3, Kind: TransferLeader, State: StateFailed, RawData: []byte("test"), UpdatedAt: 0},
+		{ID: 4, Kind: Split, State: StateCancelled, RawData: []byte("test"), UpdatedAt: 0},
+		{ID: 5, Kind: Split, State: StateInit, RawData: []byte("test"), UpdatedAt: 0},
+	}
+	for _, meta := range metas {
+		re.NoError(storage.CreateOrUpdate(ctx, meta))
+	}
+	re.NoError(storage.MarkDeleted(ctx, Split, 5))
+
+	worker := NewGCWorker(zap.NewNop(), storage, "testCluster", clk, GCOptions{
+		Interval:     time.Minute,
+		Retention:    time.Hour,
+		MaxOpsPerTxn: 2,
+	})
+
+	// Nothing is reclaimed within the retention.
+	reclaimed, err := worker.Collect(ctx, clk.Now())
+	re.NoError(err)
+	re.Equal(0, reclaimed)
+
+	clk.Advance(time.Hour * 2)
+	reclaimed, err = worker.Collect(ctx, clk.Now())
+	re.NoError(err)
+	re.Equal(4, reclaimed)
+
+	remained, err := storage.ListAll(ctx, false, DefaultScanBatchSie)
+	re.NoError(err)
+	re.Len(remained, 1)
+	re.Equal(uint64(2), remained[0].ID)
+
+	remained, err = storage.ListAll(ctx, true, DefaultScanBatchSie)
+	re.NoError(err)
+	re.Empty(remained)
+}
+
+// legacyStorage lists the metas without the update time, as the ones persisted before the update time is recorded.
+type legacyStorage struct {
+	Storage
+}
+
+func (s legacyStorage) ListAll(ctx context.Context, deleted bool, batchSize int) ([]*Meta, error) {
+	metas, err := s.Storage.ListAll(ctx, deleted, batchSize)
+	for _, meta := range metas {
+		meta.UpdatedAt = 0
+	}
+	return metas, err
+}
+
+func TestGCWorkerWithoutUpdateTime(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultTimeout)
+	defer cancel()
+
+	clk := clock.NewMock(time.Now())
+	storage := legacyStorage{Storage: NewTestStorageWithClock(t, clk)}
+	re.NoError(storage.CreateOrUpdate(ctx, Meta{ID: 1, Kind: TransferLeader, State: StateFinished, RawData: []byte("test"), UpdatedAt: 0}))
+
+	worker := NewGCWorker(zap.NewNop(), storage, "testCluster", clk, GCOptions{
+		Interval:     time.Minute,
+		Retention:    time.Hour,
+		MaxOpsPerTxn: 2,
+	})
+
+	// The meta is regarded as updated when it is scanned first.
+	reclaimed, err := worker.Collect(ctx, clk.Now())
+	re.NoError(err)
+	re.Equal(0, reclaimed)
+
+	clk.Advance(time.Minute * 30)
+	reclaimed, err = worker.Collect(ctx, clk.Now())
+	re.NoError(err)
+	re.Equal(0, reclaimed)
+
+	clk.Advance(time.Hour)
+	reclaimed, err = worker.Collect(ctx, clk.Now())
+	re.NoError(err)
+	re.Equal(1, reclaimed)
+}
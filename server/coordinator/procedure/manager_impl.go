@@ -18,10 +18,14 @@ package procedure
 
 import (
 	"context"
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/CeresDB/horaemeta/pkg/requestid"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
 	"github.com/CeresDB/horaemeta/server/coordinator/lock"
 	"github.com/CeresDB/horaemeta/server/storage"
 	"go.uber.org/zap"
@@ -33,6 +37,10 @@ const (
 	defaultWaitingQueueDelay         = time.Millisecond * 500
 	defaultPromoteDelay              = time.Millisecond * 100
 	defaultProcedureWorkerChanBufSiz = 10
+
+	// The results of the procedures labeling the metrics.
+	procedureResultSucceeded = "succeeded"
+	procedureResultFailed    = "failed"
 )
 
 type ManagerImpl struct {
@@ -41,6 +49,8 @@ type ManagerImpl struct {
 
 	// ProcedureShardLock is used to ensure the consistency of procedures' concurrent running on shard, that is to say, only one procedure is allowed to run on a specific shard.
 	procedureShardLock *lock.EntryLock
+	// procedureResourceLock serializes the procedures operating on the same schemas or tables.
+	procedureResourceLock *lock.ResourceLock
 	// All procedure will be put into waiting queue first, when runningProcedure is empty, try to promote some waiting procedures to new running procedures.
 	waitingProcedures *DelayQueue
 	// ProcedureWorkerChan is used to notify that a procedure has been submitted or completed, and the manager will perform promote after receiving the signal.
@@ -52,6 +62,10 @@ type ManagerImpl struct {
 	// There is only one procedure running for every shard.
 	// It will be removed when the procedure is finished or failed.
 	runningProcedures map[storage.ShardID]Procedure
+	// retryCounts records how many times the procedures are put back into the waiting queue, keyed by the procedure id.
+	retryCounts map[uint64]int
+	// requestIDs records the ids of the requests submitting the procedures, keyed by the procedure id.
+	requestIDs map[uint64]string
 }
 
 func (m *ManagerImpl) Start(ctx context.Context) error {
@@ -90,10 +104,21 @@ func (m *ManagerImpl) Stop(ctx context.Context) error {
 }
 
 // TODO: Filter duplicate submitted Procedure.
-func (m *ManagerImpl) Submit(_ context.Context, procedure Procedure) error {
+func (m *ManagerImpl) Submit(ctx context.Context, procedure Procedure) error {
+	requestID := requestid.FromContext(ctx)
+	if len(requestID) > 0 {
+		m.lock.Lock()
+		m.requestIDs[procedure.ID()] = requestID
+		m.lock.Unlock()
+	}
 	if err := m.waitingProcedures.Push(procedure, 0); err != nil {
+		m.lock.Lock()
+		delete(m.requestIDs, procedure.ID())
+		m.lock.Unlock()
 		return err
 	}
+	m.logger.Info("submit procedure", zap.Uint64("procedureID", procedure.ID()), zap.String("kind", procedure.Kind().String()), requestid.FieldOf(requestID))
+	procedureSubmitted.WithLabelValues(m.metadata.Name(), procedure.Kind().String()).Inc()
 
 	select {
 	case m.procedureWorkerChan <- struct{}{}:
@@ -110,11 +135,7 @@ func (m *ManagerImpl) ListRunningProcedure(_ context.Context) ([]*Info, error) {
 	procedureInfos := make([]*Info, 0, len(m.runningProcedures))
 	for _, procedure := range m.runningProcedures {
 		if procedure.State() == StateRunning {
-			procedureInfos = append(procedureInfos, &Info{
-				ID:    procedure.ID(),
-				Kind:  procedure.Kind(),
-				State: procedure.State(),
-			})
+			procedureInfos = append(procedureInfos, m.buildInfo(procedure))
 		}
 	}
 	return procedureInfos, nil
@@ -123,14 +144,17 @@ func (m *ManagerImpl) ListRunningProcedure(_ context.Context) ([]*Info, error) {
 func NewManagerImpl(logger *zap.Logger, metadata *metadata.ClusterMetadata) (Manager, error) {
 	entryLock := lock.NewEntryLock(10)
 	manager := &ManagerImpl{
-		logger:              logger,
-		metadata:            metadata,
-		procedureShardLock:  &entryLock,
-		waitingProcedures:   NewProcedureDelayQueue(defaultWaitingQueueLen),
-		procedureWorkerChan: make(chan struct{}),
-		lock:                sync.RWMutex{},
-		running:             false,
-		runningProcedures:   map[storage.ShardID]Procedure{},
+		logger:                logger,
+		metadata:              metadata,
+		procedureShardLock:    &entryLock,
+		procedureResourceLock: lock.NewResourceLock(),
+		waitingProcedures:     NewProcedureDelayQueue(defaultWaitingQueueLen, metadata.Clock()),
+		procedureWorkerChan:   make(chan struct{}),
+		lock:                  sync.RWMutex{},
+		running:               false,
+		runningProcedures:     map[storage.ShardID]Procedure{},
+		retryCounts:           map[uint64]int{},
+		requestIDs:            map[uint64]string{},
 	}
 	return manager, nil
 }
@@ -174,13 +198,26 @@ func (m *ManagerImpl) startProcedurePromoteInternal(ctx context.Context, procedu
 func (m *ManagerImpl) startProcedureWorker(ctx context.Context, newProcedure Procedure, procedureWorkerChan chan struct{}) {
 	go func() {
 		start := time.Now()
-		m.logger.Info("procedure start", zap.Uint64("procedureID", newProcedure.ID()))
-		err := newProcedure.Start(ctx)
+		m.lock.RLock()
+		requestIDField := requestid.FieldOf(m.requestIDs[newProcedure.ID()])
+		m.lock.RUnlock()
+		m.logger.Info("procedure start", zap.Uint64("procedureID", newProcedure.ID()), requestIDField)
+		// The operations dispatched by the procedure are replayed by the next leader only if the procedure is still live.
+		err := newProcedure.Start(eventdispatch.WithProcedureID(ctx, newProcedure.ID()))
+		result := procedureResultSucceeded
 		if err != nil {
-			m.logger.Error("procedure start failed", zap.Error(err), zap.Int64("costTime", time.Since(start).Milliseconds()))
+			result = procedureResultFailed
+			m.logger.Error("procedure start failed", zap.Error(err), zap.Uint64("procedureID", newProcedure.ID()), zap.Int64("costTime", time.Since(start).Milliseconds()), requestIDField)
 		} else {
-			m.logger.Info("procedure start finish", zap.Uint64("procedureID", newProcedure.ID()), zap.Int64("costTime", time.Since(start).Milliseconds()))
+			m.logger.Info("procedure start finish", zap.Uint64("procedureID", newProcedure.ID()), zap.Int64("costTime", time.Since(start).Milliseconds()), requestIDField)
 		}
+		clusterName, kind := m.metadata.Name(), newProcedure.Kind().String()
+		procedureFinished.WithLabelValues(clusterName, kind, result).Inc()
+		procedureDuration.WithLabelValues(clusterName, kind, result).Observe(time.Since(start).Seconds())
+		m.lock.Lock()
+		delete(m.retryCounts, newProcedure.ID())
+		delete(m.requestIDs, newProcedure.ID())
+		m.lock.Unlock()
 		for shardID := range newProcedure.RelatedVersionInfo().ShardWithVersion {
 			m.lock.Lock()
 			delete(m.runningProcedures, shardID)
@@ -188,6 +225,7 @@ func (m *ManagerImpl) startProcedureWorker(ctx context.Context, newProcedure Pro
 
 			m.procedureShardLock.UnLock([]uint64{uint64(shardID)})
 		}
+		m.procedureResourceLock.UnLock(m.lockedResources(newProcedure))
 		select {
 		case procedureWorkerChan <- struct{}{}:
 		default:
@@ -234,6 +272,11 @@ func (m *ManagerImpl) promoteProcedure(_ context.Context) ([]Procedure, error) {
 
 		if !checkValid(p, m.metadata) {
 			// This procedure is invalid, just remove it.
+			procedureDiscarded.WithLabelValues(m.metadata.Name(), p.Kind().String()).Inc()
+			m.lock.Lock()
+			delete(m.retryCounts, p.ID())
+			delete(m.requestIDs, p.ID())
+			m.lock.Unlock()
 			continue
 		}
 
@@ -243,6 +286,13 @@ func (m *ManagerImpl) promoteProcedure(_ context.Context) ([]Procedure, error) {
 			shardIDs = append(shardIDs, uint64(shardID))
 		}
 		lockResult := m.procedureShardLock.TryLock(shardIDs)
+		if lockResult {
+			// The shard locks are released if the resources of the procedure are held by others.
+			lockResult = m.procedureResourceLock.TryLock(m.lockedResources(p))
+			if !lockResult {
+				m.procedureShardLock.UnLock(shardIDs)
+			}
+		}
 		if lockResult {
 			// Get lock success, procedure will be executed.
 			readyProcs = append(readyProcs, p)
@@ -251,6 +301,52 @@ func (m *ManagerImpl) promoteProcedure(_ context.Context) ([]Procedure, error) {
 			if err := queue.Push(p, defaultWaitingQueueDelay); err != nil {
 				return nil, err
 			}
+			m.lock.Lock()
+			m.retryCounts[p.ID()]++
+			m.lock.Unlock()
+			procedureRetries.WithLabelValues(m.metadata.Name(), p.Kind().String()).Inc()
+		}
+	}
+}
+
+// buildInfo describes the procedure, and the caller should hold the lock of the manager.
+func (m *ManagerImpl) buildInfo(p Procedure) *Info {
+	info := &Info{
+		ID:               p.ID(),
+		Kind:             p.Kind(),
+		State:            p.State(),
+		FSMState:         "",
+		LastTransitionAt: time.Time{},
+		RetryCount:       m.retryCounts[p.ID()],
+		RequestID:        m.requestIDs[p.ID()],
+		ShardIDs:         make([]storage.ShardID, 0, len(p.RelatedVersionInfo().ShardWithVersion)),
+		Tables:           []string{},
+	}
+	if reporter, ok := p.(StepReporter); ok {
+		step := reporter.StepInfo()
+		info.FSMState = step.FSMState
+		info.LastTransitionAt = step.LastTransitionAt
+	}
+	for shardID := range p.RelatedVersionInfo().ShardWithVersion {
+		info.ShardIDs = append(info.ShardIDs, shardID)
+	}
+	sort.Slice(info.ShardIDs, func(i, j int) bool {
+		return info.ShardIDs[i] < info.ShardIDs[j]
+	})
+	for _, resource := range m.lockedResources(p) {
+		if len(resource.Table) > 0 {
+			info.Tables = append(info.Tables, fmt.Sprintf("%s.%s", resource.Schema, resource.Table))
 		}
 	}
+	return info
+}
+
+// lockedResources returns the resources to lock for the procedure, whose names are normalized by the procedure the way
+// the table manager resolves them.
+func (m *ManagerImpl) lockedResources(p Procedure) []lock.Resource {
+	locker, ok := p.(ResourceLocker)
+	if !ok {
+		return nil
+	}
+	return locker.LockedResources()
 }
@@ -18,12 +18,16 @@ package procedure_test
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
 	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
 )
@@ -171,4 +175,15 @@ func TestManager(t *testing.T) {
 		err = manager.Stop(ctx)
 		re.NoError(err)
 	}
+
+	// All the procedures are counted by the kind.
+	expected := fmt.Sprintf(`
+# HELP horaemeta_procedure_submitted_total Number of the procedures submitted.
+# TYPE horaemeta_procedure_submitted_total counter
+horaemeta_procedure_submitted_total{cluster="%[1]s",kind="createTable"} %[2]d
+# HELP horaemeta_procedure_finished_total Number of the procedures finished.
+# TYPE horaemeta_procedure_finished_total counter
+horaemeta_procedure_finished_total{cluster="%[1]s",kind="createTable",result="succeeded"} %[2]d
+`, test.ClusterName, procedureID)
+	re.NoError(testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "horaemeta_procedure_submitted_total", "horaemeta_procedure_finished_total"))
 }
@@ -0,0 +1,78 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package procedure
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// gcReclaimedKeys counts the procedure keys deleted by the gc, and the state is "deleted" for the ones marked deleted.
+var gcReclaimedKeys = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "horaemeta",
+	Subsystem: "procedure",
+	Name:      "gc_reclaimed_keys_total",
+	Help:      "Number of the procedure keys reclaimed by the gc.",
+}, []string{"cluster", "state"})
+
+var gcFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "horaemeta",
+	Subsystem: "procedure",
+	Name:      "gc_failures_total",
+	Help:      "Number of the failed gc rounds of the procedures.",
+}, []string{"cluster"})
+
+// procedureSubmitted counts the procedures submitted to the manager.
+var procedureSubmitted = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "horaemeta",
+	Subsystem: "procedure",
+	Name:      "submitted_total",
+	Help:      "Number of the procedures submitted.",
+}, []string{"cluster", "kind"})
+
+// procedureFinished counts the procedures finished by the manager, labeled by the result of succeeded or failed.
+var procedureFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "horaemeta",
+	Subsystem: "procedure",
+	Name:      "finished_total",
+	Help:      "Number of the procedures finished.",
+}, []string{"cluster", "kind", "result"})
+
+// procedureDuration observes the execution duration of the procedures, excluding the time waiting in the queue.
+var procedureDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "horaemeta",
+	Subsystem: "procedure",
+	Name:      "duration_seconds",
+	Help:      "Execution duration of the procedures.",
+	Buckets:   prometheus.ExponentialBuckets(0.01, 2, 16),
+}, []string{"cluster", "kind", "result"})
+
+// procedureRetries counts the procedures put back into the waiting queue for the locks held by others.
+var procedureRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "horaemeta",
+	Subsystem: "procedure",
+	Name:      "retries_total",
+	Help:      "Number of the procedures retried for the conflicting locks.",
+}, []string{"cluster", "kind"})
+
+// procedureDiscarded counts the waiting procedures discarded for the outdated versions of the cluster or the shards.
+var procedureDiscarded = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "horaemeta",
+	Subsystem: "procedure",
+	Name:      "discarded_total",
+	Help:      "Number of the procedures discarded for the outdated versions.",
+}, []string{"cluster", "kind"})
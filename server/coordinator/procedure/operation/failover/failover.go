@@ -0,0 +1,294 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package failover
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/looplab/fsm"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Fsm state change: Begin -> PromoteFollower -> UpdateTopology -> Finish.
+// PromoteFollower opens the shard as the leader on the node holding the follower, which is much faster than opening the
+// shard cold on another node because the follower has caught up with the leader already.
+// UpdateTopology makes the follower be the leader of the shard in the cluster view, and drops the dead leader.
+const (
+	eventPromoteFollower = "EventPromoteFollower"
+	eventUpdateTopology  = "EventUpdateTopology"
+	eventFinish          = "EventFinish"
+
+	stateBegin           = "StateBegin"
+	statePromoteFollower = "StatePromoteFollower"
+	stateUpdateTopology  = "StateUpdateTopology"
+	stateFinish          = "StateFinish"
+)
+
+var (
+	failoverEvents = fsm.Events{
+		{Name: eventPromoteFollower, Src: []string{stateBegin}, Dst: statePromoteFollower},
+		{Name: eventUpdateTopology, Src: []string{statePromoteFollower}, Dst: stateUpdateTopology},
+		{Name: eventFinish, Src: []string{stateUpdateTopology}, Dst: stateFinish},
+	}
+	failoverCallbacks = fsm.Callbacks{
+		eventPromoteFollower: promoteFollowerCallback,
+		eventUpdateTopology:  updateTopologyCallback,
+		eventFinish:          finishCallback,
+	}
+)
+
+type ProcedureParams struct {
+	ID              uint64
+	Dispatch        eventdispatch.Dispatch
+	ClusterMetadata *metadata.ClusterMetadata
+	ClusterSnapshot metadata.Snapshot
+
+	ShardID           storage.ShardID
+	OldLeaderNodeName string
+	NewLeaderNodeName string
+}
+
+type Procedure struct {
+	fsm                *fsm.FSM
+	params             ProcedureParams
+	relatedVersionInfo procedure.RelatedVersionInfo
+
+	// Protect the state.
+	lock  sync.RWMutex
+	state procedure.State
+}
+
+// callbackRequest is fsm callbacks param.
+type callbackRequest struct {
+	ctx context.Context
+	p   *Procedure
+}
+
+func NewProcedure(params ProcedureParams) (procedure.Procedure, error) {
+	shardView, exists := params.ClusterSnapshot.Topology.ShardViewsMapping[params.ShardID]
+	if !exists {
+		return nil, errors.WithMessagef(metadata.ErrShardNotFound, "shard not found in topology, shardID:%d", params.ShardID)
+	}
+	if err := validateShardNodes(params.ClusterSnapshot.Topology.ClusterView.ShardNodes, params.ShardID, params.OldLeaderNodeName, params.NewLeaderNodeName); err != nil {
+		return nil, err
+	}
+
+	return &Procedure{
+		fsm: procedure.NewFSM(
+			procedure.Failover,
+			params.ClusterMetadata.Clock(),
+			stateBegin,
+			failoverEvents,
+			failoverCallbacks,
+		),
+		params: params,
+		relatedVersionInfo: procedure.RelatedVersionInfo{
+			ClusterID:        params.ClusterSnapshot.Topology.ClusterView.ClusterID,
+			ShardWithVersion: map[storage.ShardID]uint64{params.ShardID: shardView.Version},
+			ClusterVersion:   params.ClusterSnapshot.Topology.ClusterView.Version,
+		},
+		lock:  sync.RWMutex{},
+		state: procedure.StateInit,
+	}, nil
+}
+
+// validateShardNodes checks the old leader node is the leader of the shard, and the new leader node holds its follower.
+// The old leader node name is empty if the leader has been dropped from the topology already.
+func validateShardNodes(shardNodes []storage.ShardNode, shardID storage.ShardID, oldLeaderNodeName, newLeaderNodeName string) error {
+	leaderFound, followerFound := len(oldLeaderNodeName) == 0, false
+	for _, shardNode := range shardNodes {
+		if shardNode.ID != shardID {
+			continue
+		}
+		if shardNode.NodeName == oldLeaderNodeName && shardNode.ShardRole == storage.ShardRoleLeader {
+			leaderFound = true
+		}
+		if shardNode.NodeName == newLeaderNodeName && shardNode.ShardRole == storage.ShardRoleFollower {
+			followerFound = true
+		}
+	}
+	if !leaderFound {
+		return errors.WithMessagef(procedure.ErrShardLeaderNotFound, "shardID:%d, oldLeader:%s", shardID, oldLeaderNodeName)
+	}
+	if !followerFound {
+		return errors.WithMessagef(procedure.ErrShardFollowerNotFound, "shardID:%d, newLeader:%s", shardID, newLeaderNodeName)
+	}
+	return nil
+}
+
+// PickNewLeader picks the follower of the shard on an online node not cordoned to be the new leader, and returns the
+// current leader as well, which is empty if the shard has no leader in the topology.
+func PickNewLeader(snapshot metadata.Snapshot, shardID storage.ShardID, now time.Time) (string, string, error) {
+	onlineNodes := make(map[string]struct{}, len(snapshot.RegisteredNodes))
+	for _, node := range snapshot.RegisteredNodes {
+		if !node.IsExpired(now) {
+			onlineNodes[node.Node.Name] = struct{}{}
+		}
+	}
+
+	oldLeaderNodeName := ""
+	followerNodeNames := make([]string, 0)
+	for _, shardNode := range snapshot.Topology.ClusterView.ShardNodes {
+		if shardNode.ID != shardID {
+			continue
+		}
+		switch shardNode.ShardRole {
+		case storage.ShardRoleLeader:
+			oldLeaderNodeName = shardNode.NodeName
+		case storage.ShardRoleFollower:
+			// The leadership is a new shard for the node, so the cordoned followers are never promoted.
+			if _, online := onlineNodes[shardNode.NodeName]; online && !snapshot.IsCordoned(shardNode.NodeName) {
+				followerNodeNames = append(followerNodeNames, shardNode.NodeName)
+			}
+		}
+	}
+	if len(followerNodeNames) == 0 {
+		return "", "", errors.WithMessagef(procedure.ErrShardFollowerNotFound, "no online follower not cordoned, shardID:%d", shardID)
+	}
+
+	// Pick the follower in order to make the choice stable.
+	sort.Strings(followerNodeNames)
+	return oldLeaderNodeName, followerNodeNames[0], nil
+}
+
+func (p *Procedure) ID() uint64 {
+	return p.params.ID
+}
+
+func (p *Procedure) Kind() procedure.Kind {
+	return procedure.Failover
+}
+
+func (p *Procedure) RelatedVersionInfo() procedure.RelatedVersionInfo {
+	return p.relatedVersionInfo
+}
+
+func (p *Procedure) Priority() procedure.Priority {
+	return procedure.PriorityHigh
+}
+
+func (p *Procedure) StepInfo() procedure.StepInfo {
+	return procedure.FSMStepInfo(p.fsm)
+}
+
+func (p *Procedure) Start(ctx context.Context) error {
+	p.updateStateWithLock(procedure.StateRunning)
+
+	req := callbackRequest{
+		ctx: ctx,
+		p:   p,
+	}
+
+	for {
+		switch p.fsm.Current() {
+		case stateBegin:
+			if err := p.fsm.Event(eventPromoteFollower, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "failover procedure promote follower")
+			}
+		case statePromoteFollower:
+			if err := p.fsm.Event(eventUpdateTopology, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "failover procedure update topology")
+			}
+		case stateUpdateTopology:
+			if err := p.fsm.Event(eventFinish, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "failover procedure finish")
+			}
+		case stateFinish:
+			p.updateStateWithLock(procedure.StateFinished)
+			return nil
+		}
+	}
+}
+
+func (p *Procedure) Cancel(_ context.Context) error {
+	p.updateStateWithLock(procedure.StateCancelled)
+	return nil
+}
+
+func (p *Procedure) State() procedure.State {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.state
+}
+
+func (p *Procedure) updateStateWithLock(state procedure.State) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.state = state
+}
+
+func promoteFollowerCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+	params := req.p.params
+
+	log.Info("try to promote shard follower", zap.Uint64("procedureID", req.p.ID()), zap.Uint32("shardID", uint32(params.ShardID)), zap.String("oldLeader", params.OldLeaderNodeName), zap.String("newLeader", params.NewLeaderNodeName))
+
+	openShardRequest := eventdispatch.OpenShardRequest{
+		Shard: metadata.ShardInfo{
+			ID:      params.ShardID,
+			Role:    storage.ShardRoleLeader,
+			Version: req.p.relatedVersionInfo.ShardWithVersion[params.ShardID],
+			Status:  storage.ShardStatusUnknown,
+		},
+	}
+	if err := params.Dispatch.OpenShard(req.ctx, params.NewLeaderNodeName, openShardRequest); err != nil {
+		procedure.CancelEventWithLog(event, err, "open shard as leader", zap.Uint32("shardID", uint32(params.ShardID)), zap.String("newLeader", params.NewLeaderNodeName))
+		return
+	}
+}
+
+func updateTopologyCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+	params := req.p.params
+
+	if err := params.ClusterMetadata.PromoteShardFollower(req.ctx, params.ShardID, params.OldLeaderNodeName, params.NewLeaderNodeName); err != nil {
+		procedure.CancelEventWithLog(event, err, "promote shard follower in topology", zap.Uint32("shardID", uint32(params.ShardID)))
+		return
+	}
+}
+
+func finishCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+
+	log.Info("failover finish", zap.Uint64("procedureID", req.p.ID()), zap.Uint32("shardID", uint32(req.p.params.ShardID)), zap.String("oldLeader", req.p.params.OldLeaderNodeName), zap.String("newLeader", req.p.params.NewLeaderNodeName))
+}
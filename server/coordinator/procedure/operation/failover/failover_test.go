@@ -0,0 +1,85 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package failover_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/failover"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailover(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	c := test.InitStableCluster(ctx, t)
+
+	snapshot := c.GetMetadata().GetClusterSnapshot()
+	leader := snapshot.Topology.ClusterView.ShardNodes[0]
+	followerNodeName := ""
+	for _, node := range snapshot.RegisteredNodes {
+		if node.Node.Name != leader.NodeName {
+			followerNodeName = node.Node.Name
+			break
+		}
+	}
+	re.NotEmpty(followerNodeName)
+
+	// The procedure can't be created if the shard has no follower on the node.
+	params := failover.ProcedureParams{
+		ID:                1,
+		Dispatch:          test.MockDispatch{},
+		ClusterMetadata:   c.GetMetadata(),
+		ClusterSnapshot:   snapshot,
+		ShardID:           leader.ID,
+		OldLeaderNodeName: leader.NodeName,
+		NewLeaderNodeName: followerNodeName,
+	}
+	_, err := failover.NewProcedure(params)
+	re.True(coderr.Is(err, procedure.ErrShardFollowerNotFound.Code()))
+
+	shardNodes := append(snapshot.Topology.ClusterView.ShardNodes, storage.ShardNode{
+		ID:        leader.ID,
+		ShardRole: storage.ShardRoleFollower,
+		NodeName:  followerNodeName,
+	})
+	re.NoError(c.GetMetadata().UpdateClusterView(ctx, storage.ClusterStateStable, shardNodes))
+
+	params.ClusterSnapshot = c.GetMetadata().GetClusterSnapshot()
+	p, err := failover.NewProcedure(params)
+	re.NoError(err)
+	re.NoError(p.Start(ctx))
+	re.Equal(procedure.StateFinished, string(p.State()))
+
+	// The follower is the only node of the shard, and it becomes the leader.
+	var newShardNodes []storage.ShardNode
+	for _, shardNode := range c.GetMetadata().GetClusterSnapshot().Topology.ClusterView.ShardNodes {
+		if shardNode.ID == leader.ID {
+			newShardNodes = append(newShardNodes, shardNode)
+		}
+	}
+	re.Equal([]storage.ShardNode{{
+		ID:        leader.ID,
+		ShardRole: storage.ShardRoleLeader,
+		NodeName:  followerNodeName,
+	}}, newShardNodes)
+}
@@ -0,0 +1,292 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migratetopology
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/looplab/fsm"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Fsm state change: Begin -> Validate -> UpdateTopologyType -> Reconcile -> Finish.
+// Validate checks the cluster again when the procedure runs, because the nodes may be offline since it is submitted.
+// UpdateTopologyType persists the topology type of the cluster.
+// Reconcile re-registers the shards with the shard watch and the schedulers of the new topology type, and the persisted
+// topology type is restored if it fails.
+const (
+	eventValidate           = "EventValidate"
+	eventUpdateTopologyType = "EventUpdateTopologyType"
+	eventReconcile          = "EventReconcile"
+	eventFinish             = "EventFinish"
+
+	stateBegin              = "StateBegin"
+	stateValidate           = "StateValidate"
+	stateUpdateTopologyType = "StateUpdateTopologyType"
+	stateReconcile          = "StateReconcile"
+	stateFinish             = "StateFinish"
+)
+
+var (
+	migrateTopologyEvents = fsm.Events{
+		{Name: eventValidate, Src: []string{stateBegin}, Dst: stateValidate},
+		{Name: eventUpdateTopologyType, Src: []string{stateValidate}, Dst: stateUpdateTopologyType},
+		{Name: eventReconcile, Src: []string{stateUpdateTopologyType}, Dst: stateReconcile},
+		{Name: eventFinish, Src: []string{stateReconcile}, Dst: stateFinish},
+	}
+	migrateTopologyCallbacks = fsm.Callbacks{
+		eventValidate:           validateCallback,
+		eventUpdateTopologyType: updateTopologyTypeCallback,
+		eventReconcile:          reconcileCallback,
+		eventFinish:             finishCallback,
+	}
+)
+
+// Validate checks whether the cluster can be migrated from one topology type to the other. The cluster must be stable
+// with enough online nodes, and the leaders of all the shards must be on the online nodes, so that the shards are served
+// without interruption by the schedulers of the new topology type.
+func Validate(snapshot metadata.Snapshot, from, to storage.TopologyType, minNodeCount uint32, now time.Time) error {
+	if to != storage.TopologyTypeStatic && to != storage.TopologyTypeDynamic {
+		return errors.WithMessagef(procedure.ErrInvalidParams, "unknown topology type:%s", to)
+	}
+	if from == to {
+		return errors.WithMessagef(procedure.ErrTopologyTypeNotChanged, "topology type:%s", to)
+	}
+	if !snapshot.Topology.IsStable() {
+		return errors.WithMessagef(metadata.ErrClusterStateInvalid, "cluster must be stable, state:%d", snapshot.Topology.ClusterView.State)
+	}
+
+	onlineNodes := make(map[string]struct{}, len(snapshot.RegisteredNodes))
+	for _, node := range snapshot.RegisteredNodes {
+		if !node.IsExpired(now) {
+			onlineNodes[node.Node.Name] = struct{}{}
+		}
+	}
+	if uint32(len(onlineNodes)) < minNodeCount {
+		return errors.WithMessagef(procedure.ErrNodeNumberNotEnough, "onlineNodeCount:%d, minNodeCount:%d", len(onlineNodes), minNodeCount)
+	}
+	for _, shardNode := range snapshot.Topology.ClusterView.ShardNodes {
+		if shardNode.ShardRole != storage.ShardRoleLeader {
+			continue
+		}
+		if _, online := onlineNodes[shardNode.NodeName]; !online {
+			return errors.WithMessagef(procedure.ErrShardLeaderNotFound, "leader node is offline, shardID:%d, node:%s", shardNode.ID, shardNode.NodeName)
+		}
+	}
+	return nil
+}
+
+type ProcedureParams struct {
+	ID              uint64
+	ClusterMetadata *metadata.ClusterMetadata
+	ClusterSnapshot metadata.Snapshot
+	TopologyType    storage.TopologyType
+
+	// OnSwitched reconciles the running schedulers with the persisted topology type.
+	OnSwitched func(context.Context, storage.TopologyType) error
+}
+
+type Procedure struct {
+	fsm                *fsm.FSM
+	params             ProcedureParams
+	relatedVersionInfo procedure.RelatedVersionInfo
+	// previousTopologyType is restored if the reconciling fails.
+	previousTopologyType storage.TopologyType
+
+	// Protect the state.
+	lock  sync.RWMutex
+	state procedure.State
+}
+
+// callbackRequest is fsm callbacks param.
+type callbackRequest struct {
+	ctx context.Context
+	p   *Procedure
+}
+
+func NewProcedure(params ProcedureParams) (procedure.Procedure, error) {
+	clusterMetadata := params.ClusterMetadata
+	if err := Validate(params.ClusterSnapshot, clusterMetadata.GetTopologyType(), params.TopologyType, clusterMetadata.GetClusterMinNodeCount(), clusterMetadata.Clock().Now()); err != nil {
+		return nil, err
+	}
+
+	// All the shards are held, so that no other procedure moves the shards during the migration.
+	shardWithVersion := make(map[storage.ShardID]uint64, len(params.ClusterSnapshot.Topology.ShardViewsMapping))
+	for shardID, shardView := range params.ClusterSnapshot.Topology.ShardViewsMapping {
+		shardWithVersion[shardID] = shardView.Version
+	}
+
+	return &Procedure{
+		fsm: procedure.NewFSM(
+			procedure.MigrateTopology,
+			params.ClusterMetadata.Clock(),
+			stateBegin,
+			migrateTopologyEvents,
+			migrateTopologyCallbacks,
+		),
+		params: params,
+		relatedVersionInfo: procedure.RelatedVersionInfo{
+			ClusterID:        params.ClusterSnapshot.Topology.ClusterView.ClusterID,
+			ShardWithVersion: shardWithVersion,
+			ClusterVersion:   params.ClusterSnapshot.Topology.ClusterView.Version,
+		},
+		previousTopologyType: clusterMetadata.GetTopologyType(),
+		lock:                 sync.RWMutex{},
+		state:                procedure.StateInit,
+	}, nil
+}
+
+func (p *Procedure) ID() uint64 {
+	return p.params.ID
+}
+
+func (p *Procedure) Kind() procedure.Kind {
+	return procedure.MigrateTopology
+}
+
+func (p *Procedure) RelatedVersionInfo() procedure.RelatedVersionInfo {
+	return p.relatedVersionInfo
+}
+
+func (p *Procedure) Priority() procedure.Priority {
+	return procedure.PriorityMed
+}
+
+func (p *Procedure) StepInfo() procedure.StepInfo {
+	return procedure.FSMStepInfo(p.fsm)
+}
+
+func (p *Procedure) Start(ctx context.Context) error {
+	p.updateStateWithLock(procedure.StateRunning)
+
+	req := callbackRequest{
+		ctx: ctx,
+		p:   p,
+	}
+
+	for {
+		switch p.fsm.Current() {
+		case stateBegin:
+			if err := p.fsm.Event(eventValidate, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "migrate topology procedure validate")
+			}
+		case stateValidate:
+			if err := p.fsm.Event(eventUpdateTopologyType, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "migrate topology procedure update topology type")
+			}
+		case stateUpdateTopologyType:
+			if err := p.fsm.Event(eventReconcile, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "migrate topology procedure reconcile")
+			}
+		case stateReconcile:
+			if err := p.fsm.Event(eventFinish, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "migrate topology procedure finish")
+			}
+		case stateFinish:
+			p.updateStateWithLock(procedure.StateFinished)
+			return nil
+		}
+	}
+}
+
+func (p *Procedure) Cancel(_ context.Context) error {
+	p.updateStateWithLock(procedure.StateCancelled)
+	return nil
+}
+
+func (p *Procedure) State() procedure.State {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.state
+}
+
+func (p *Procedure) updateStateWithLock(state procedure.State) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.state = state
+}
+
+func validateCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+	clusterMetadata := req.p.params.ClusterMetadata
+
+	snapshot := clusterMetadata.GetClusterSnapshot()
+	if err := Validate(snapshot, clusterMetadata.GetTopologyType(), req.p.params.TopologyType, clusterMetadata.GetClusterMinNodeCount(), clusterMetadata.Clock().Now()); err != nil {
+		procedure.CancelEventWithLog(event, err, "validate topology migration", zap.String("topologyType", string(req.p.params.TopologyType)))
+		return
+	}
+}
+
+func updateTopologyTypeCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+
+	if err := req.p.params.ClusterMetadata.UpdateTopologyType(req.ctx, req.p.params.TopologyType); err != nil {
+		procedure.CancelEventWithLog(event, err, "update topology type", zap.String("topologyType", string(req.p.params.TopologyType)))
+		return
+	}
+}
+
+func reconcileCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+	params := req.p.params
+
+	if params.OnSwitched == nil {
+		return
+	}
+	if err := params.OnSwitched(req.ctx, params.TopologyType); err != nil {
+		if restoreErr := params.ClusterMetadata.UpdateTopologyType(req.ctx, req.p.previousTopologyType); restoreErr != nil {
+			log.Error("restore topology type failed", zap.Uint64("procedureID", req.p.ID()), zap.String("topologyType", string(req.p.previousTopologyType)), zap.Error(restoreErr))
+		}
+		procedure.CancelEventWithLog(event, err, "reconcile topology type", zap.String("topologyType", string(params.TopologyType)))
+		return
+	}
+}
+
+func finishCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+
+	log.Info("migrate topology finish", zap.Uint64("procedureID", req.p.ID()), zap.String("from", string(req.p.previousTopologyType)), zap.String("to", string(req.p.params.TopologyType)))
+}
@@ -0,0 +1,98 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package migratetopology_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/migratetopology"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrateTopology(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	c := test.InitStableCluster(ctx, t)
+	re.Equal(storage.TopologyType(storage.TopologyTypeStatic), c.GetMetadata().GetTopologyType())
+
+	switched := make([]storage.TopologyType, 0)
+	params := migratetopology.ProcedureParams{
+		ID:              1,
+		ClusterMetadata: c.GetMetadata(),
+		ClusterSnapshot: c.GetMetadata().GetClusterSnapshot(),
+		TopologyType:    storage.TopologyTypeStatic,
+		OnSwitched: func(_ context.Context, topologyType storage.TopologyType) error {
+			switched = append(switched, topologyType)
+			return nil
+		},
+	}
+	_, err := migratetopology.NewProcedure(params)
+	re.True(coderr.Is(err, procedure.ErrTopologyTypeNotChanged.Code()))
+
+	params.TopologyType = storage.TopologyTypeDynamic
+	p, err := migratetopology.NewProcedure(params)
+	re.NoError(err)
+	re.Equal(len(params.ClusterSnapshot.Topology.ShardViewsMapping), len(p.RelatedVersionInfo().ShardWithVersion))
+	re.NoError(p.Start(ctx))
+	re.Equal(procedure.StateFinished, string(p.State()))
+	re.Equal(storage.TopologyType(storage.TopologyTypeDynamic), c.GetMetadata().GetTopologyType())
+	re.Equal([]storage.TopologyType{storage.TopologyTypeDynamic}, switched)
+
+	// The persisted topology type is restored if the schedulers fail to be reconciled.
+	params.ID = 2
+	params.TopologyType = storage.TopologyTypeStatic
+	params.OnSwitched = func(_ context.Context, _ storage.TopologyType) error {
+		return errors.New("reconcile failed")
+	}
+	p, err = migratetopology.NewProcedure(params)
+	re.NoError(err)
+	re.Error(p.Start(ctx))
+	re.Equal(procedure.StateFailed, string(p.State()))
+	re.Equal(storage.TopologyType(storage.TopologyTypeDynamic), c.GetMetadata().GetTopologyType())
+}
+
+func TestValidateMigrateTopology(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	prepareCluster := test.InitPrepareCluster(ctx, t)
+	snapshot := prepareCluster.GetMetadata().GetClusterSnapshot()
+	err := migratetopology.Validate(snapshot, storage.TopologyTypeStatic, storage.TopologyTypeDynamic, test.DefaultNodeCount, time.Now())
+	re.True(coderr.Is(err, metadata.ErrClusterStateInvalid.Code()))
+
+	clk := clock.NewMock(time.Now())
+	c := test.InitStableClusterWithClock(ctx, t, clk)
+	snapshot = c.GetMetadata().GetClusterSnapshot()
+	re.NoError(migratetopology.Validate(snapshot, storage.TopologyTypeStatic, storage.TopologyTypeDynamic, test.DefaultNodeCount, clk.Now()))
+	err = migratetopology.Validate(snapshot, storage.TopologyTypeStatic, storage.TopologyTypeUnknown, test.DefaultNodeCount, clk.Now())
+	re.True(coderr.Is(err, procedure.ErrInvalidParams.Code()))
+
+	// Too few nodes are online after they are expired.
+	err = migratetopology.Validate(snapshot, storage.TopologyTypeStatic, storage.TopologyTypeDynamic, test.DefaultNodeCount, clk.Now().Add(time.Hour))
+	re.True(coderr.Is(err, procedure.ErrNodeNumberNotEnough.Code()))
+	err = migratetopology.Validate(snapshot, storage.TopologyTypeStatic, storage.TopologyTypeDynamic, 0, clk.Now().Add(time.Hour))
+	re.True(coderr.Is(err, procedure.ErrShardLeaderNotFound.Code()))
+}
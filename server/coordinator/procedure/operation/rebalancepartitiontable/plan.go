@@ -0,0 +1,128 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rebalancepartitiontable
+
+import (
+	"sort"
+
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+)
+
+// TableMove moves a sub table from the source shard to the target shard.
+type TableMove struct {
+	Table         storage.Table
+	SourceShardID storage.ShardID
+	TargetShardID storage.ShardID
+}
+
+// shardLoad is the number of the sub tables and of all the tables on a shard.
+type shardLoad struct {
+	shardID   storage.ShardID
+	subTables []storage.Table
+	numTables int
+}
+
+// buildShardLoads collects the sub tables on every shard with a leader, and the sub tables not allocated to such a shard
+// are ignored because they can't be moved.
+func buildShardLoads(snapshot metadata.Snapshot, subTables []storage.Table) []*shardLoad {
+	leaderShards := make(map[storage.ShardID]struct{}, len(snapshot.Topology.ShardViewsMapping))
+	for _, shardNode := range snapshot.Topology.ClusterView.ShardNodes {
+		if shardNode.ShardRole == storage.ShardRoleLeader {
+			leaderShards[shardNode.ID] = struct{}{}
+		}
+	}
+
+	subTablesByID := make(map[storage.TableID]storage.Table, len(subTables))
+	for _, table := range subTables {
+		subTablesByID[table.ID] = table
+	}
+
+	loads := make([]*shardLoad, 0, len(leaderShards))
+	for shardID, shardView := range snapshot.Topology.ShardViewsMapping {
+		if _, ok := leaderShards[shardID]; !ok {
+			continue
+		}
+		load := &shardLoad{
+			shardID:   shardID,
+			subTables: []storage.Table{},
+			numTables: len(shardView.TableIDs),
+		}
+		for _, tableID := range shardView.TableIDs {
+			if table, ok := subTablesByID[tableID]; ok {
+				load.subTables = append(load.subTables, table)
+			}
+		}
+		sort.Slice(load.subTables, func(i, j int) bool {
+			return load.subTables[i].ID < load.subTables[j].ID
+		})
+		loads = append(loads, load)
+	}
+	return loads
+}
+
+// Skew returns the difference between the most and the fewest sub tables on the shards.
+func Skew(snapshot metadata.Snapshot, subTables []storage.Table) int {
+	return skew(buildShardLoads(snapshot, subTables))
+}
+
+func skew(loads []*shardLoad) int {
+	if len(loads) == 0 {
+		return 0
+	}
+
+	maxNum, minNum := len(loads[0].subTables), len(loads[0].subTables)
+	for _, load := range loads[1:] {
+		maxNum = max(maxNum, len(load.subTables))
+		minNum = min(minNum, len(load.subTables))
+	}
+	return maxNum - minNum
+}
+
+// PlanMoves plans the moves spreading the sub tables evenly across the shards, so that the numbers of the sub tables on
+// any two shards differ by one at most. The sub table is always moved from the shard having the most sub tables to the
+// one having the fewest, and the shard with fewer tables in total is preferred if there is a tie.
+func PlanMoves(snapshot metadata.Snapshot, subTables []storage.Table) []TableMove {
+	loads := buildShardLoads(snapshot, subTables)
+	moves := make([]TableMove, 0)
+	for skew(loads) > 1 {
+		sort.Slice(loads, func(i, j int) bool {
+			if len(loads[i].subTables) != len(loads[j].subTables) {
+				return len(loads[i].subTables) < len(loads[j].subTables)
+			}
+			if loads[i].numTables != loads[j].numTables {
+				return loads[i].numTables < loads[j].numTables
+			}
+			return loads[i].shardID < loads[j].shardID
+		})
+		target, source := loads[0], loads[len(loads)-1]
+
+		last := len(source.subTables) - 1
+		table := source.subTables[last]
+		source.subTables = source.subTables[:last]
+		source.numTables--
+		target.subTables = append(target.subTables, table)
+		target.numTables++
+
+		moves = append(moves, TableMove{
+			Table:         table,
+			SourceShardID: source.shardID,
+			TargetShardID: target.shardID,
+		})
+	}
+	return moves
+}
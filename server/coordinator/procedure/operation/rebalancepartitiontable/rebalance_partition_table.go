@@ -0,0 +1,401 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rebalancepartitiontable
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/lock"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/looplab/fsm"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Fsm state change: Begin -> MoveTables -> Finish.
+// MoveTables moves the sub tables one by one: the sub table is closed on the leader of the source shard and opened on the
+// leader of the target shard, then the shard views of both shards are updated in one transaction.
+const (
+	eventMoveTables = "EventMoveTables"
+	eventFinish     = "EventFinish"
+
+	stateBegin      = "StateBegin"
+	stateMoveTables = "StateMoveTables"
+	stateFinish     = "StateFinish"
+)
+
+var (
+	rebalanceEvents = fsm.Events{
+		{Name: eventMoveTables, Src: []string{stateBegin}, Dst: stateMoveTables},
+		{Name: eventFinish, Src: []string{stateMoveTables}, Dst: stateFinish},
+	}
+	rebalanceCallbacks = fsm.Callbacks{
+		eventMoveTables: moveTablesCallback,
+		eventFinish:     finishCallback,
+	}
+)
+
+type ProcedureParams struct {
+	ID uint64
+
+	Dispatch eventdispatch.Dispatch
+	Storage  procedure.Storage
+
+	ClusterMetadata *metadata.ClusterMetadata
+	ClusterSnapshot metadata.Snapshot
+
+	SchemaName string
+	// TableName is the name of the partition table.
+	TableName string
+	Moves     []TableMove
+}
+
+type Procedure struct {
+	fsm                *fsm.FSM
+	params             ProcedureParams
+	relatedVersionInfo procedure.RelatedVersionInfo
+
+	// Protect the state.
+	lock  sync.RWMutex
+	state procedure.State
+}
+
+func NewProcedure(params ProcedureParams) (procedure.Procedure, error) {
+	if len(params.Moves) == 0 {
+		return nil, errors.WithMessagef(procedure.ErrInvalidParams, "no sub table to move, table:%s", params.TableName)
+	}
+
+	relatedVersionInfo, err := buildRelatedVersionInfo(params)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Procedure{
+		fsm: procedure.NewFSM(
+			procedure.RebalancePartitionTable,
+			params.ClusterMetadata.Clock(),
+			stateBegin,
+			rebalanceEvents,
+			rebalanceCallbacks,
+		),
+		params:             params,
+		relatedVersionInfo: relatedVersionInfo,
+		lock:               sync.RWMutex{},
+		state:              procedure.StateInit,
+	}, nil
+}
+
+func buildRelatedVersionInfo(params ProcedureParams) (procedure.RelatedVersionInfo, error) {
+	shardWithVersion := make(map[storage.ShardID]uint64, len(params.Moves)*2)
+	for _, move := range params.Moves {
+		for _, shardID := range []storage.ShardID{move.SourceShardID, move.TargetShardID} {
+			shardView, exists := params.ClusterSnapshot.Topology.ShardViewsMapping[shardID]
+			if !exists {
+				return procedure.RelatedVersionInfo{}, errors.WithMessagef(metadata.ErrShardNotFound, "shard not found in topology, shardID:%d", shardID)
+			}
+			shardWithVersion[shardID] = shardView.Version
+		}
+	}
+
+	return procedure.RelatedVersionInfo{
+		ClusterID:        params.ClusterSnapshot.Topology.ClusterView.ClusterID,
+		ShardWithVersion: shardWithVersion,
+		ClusterVersion:   params.ClusterSnapshot.Topology.ClusterView.Version,
+	}, nil
+}
+
+type callbackRequest struct {
+	ctx context.Context
+	p   *Procedure
+}
+
+func (p *Procedure) ID() uint64 {
+	return p.params.ID
+}
+
+func (p *Procedure) Kind() procedure.Kind {
+	return procedure.RebalancePartitionTable
+}
+
+func (p *Procedure) RelatedVersionInfo() procedure.RelatedVersionInfo {
+	return p.relatedVersionInfo
+}
+
+func (p *Procedure) Priority() procedure.Priority {
+	return procedure.PriorityLow
+}
+
+func (p *Procedure) StepInfo() procedure.StepInfo {
+	return procedure.FSMStepInfo(p.fsm)
+}
+
+func (p *Procedure) LockedResources() []lock.Resource {
+	return []lock.Resource{lock.TableResource(p.params.ClusterMetadata.NormalizeName(p.params.SchemaName), p.params.ClusterMetadata.NormalizeName(p.params.TableName))}
+}
+
+func (p *Procedure) Start(ctx context.Context) error {
+	p.updateStateWithLock(procedure.StateRunning)
+
+	req := callbackRequest{
+		ctx: ctx,
+		p:   p,
+	}
+
+	for {
+		switch p.fsm.Current() {
+		case stateBegin:
+			if err := p.persist(ctx); err != nil {
+				return errors.WithMessage(err, "rebalance partition table procedure persist")
+			}
+			if err := p.fsm.Event(eventMoveTables, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "rebalance partition table procedure move tables")
+			}
+		case stateMoveTables:
+			if err := p.persist(ctx); err != nil {
+				return errors.WithMessage(err, "rebalance partition table procedure persist")
+			}
+			if err := p.fsm.Event(eventFinish, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "rebalance partition table procedure finish")
+			}
+		case stateFinish:
+			p.updateStateWithLock(procedure.StateFinished)
+			if err := p.persist(ctx); err != nil {
+				return errors.WithMessage(err, "rebalance partition table procedure persist")
+			}
+			return nil
+		}
+	}
+}
+
+func (p *Procedure) Cancel(_ context.Context) error {
+	p.updateStateWithLock(procedure.StateCancelled)
+	return nil
+}
+
+func (p *Procedure) State() procedure.State {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.state
+}
+
+func (p *Procedure) updateStateWithLock(state procedure.State) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.state = state
+}
+
+func moveTablesCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+	params := req.p.params
+
+	// The shard versions are bumped by every move, so they are tracked across the moves.
+	shardVersions := make(map[storage.ShardID]uint64, len(req.p.relatedVersionInfo.ShardWithVersion))
+	for shardID, version := range req.p.relatedVersionInfo.ShardWithVersion {
+		shardVersions[shardID] = version
+	}
+
+	for _, move := range params.Moves {
+		if err := moveTable(req.ctx, params, move, shardVersions); err != nil {
+			procedure.CancelEventWithLog(event, err, "move sub table", zap.String("tableName", move.Table.Name), zap.Uint32("sourceShardID", uint32(move.SourceShardID)), zap.Uint32("targetShardID", uint32(move.TargetShardID)))
+			return
+		}
+	}
+}
+
+func finishCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+
+	log.Info("rebalance partition table finish", zap.Uint64("procedureID", req.p.ID()), zap.String("tableName", req.p.params.TableName), zap.Int("moveCount", len(req.p.params.Moves)))
+}
+
+// moveTable closes the sub table on the source shard and opens it on the target shard before updating the shard views.
+// If the sub table fails to open on the target shard, it is reopened on the source shard and the shard views are left
+// unchanged, and the shard version bumped on the node is corrected by the heartbeat later.
+func moveTable(ctx context.Context, params ProcedureParams, move TableMove, shardVersions map[storage.ShardID]uint64) error {
+	sourceLeader, err := findLeaderNode(params.ClusterSnapshot, move.SourceShardID)
+	if err != nil {
+		return err
+	}
+	targetLeader, err := findLeaderNode(params.ClusterSnapshot, move.TargetShardID)
+	if err != nil {
+		return err
+	}
+
+	tableInfo := metadata.TableInfo{
+		ID:            move.Table.ID,
+		Name:          move.Table.Name,
+		SchemaID:      move.Table.SchemaID,
+		SchemaName:    params.SchemaName,
+		PartitionInfo: storage.PartitionInfo{Info: nil},
+		CreatedAt:     move.Table.CreatedAt,
+	}
+	sourceVersion := shardVersions[move.SourceShardID] + 1
+	targetVersion := shardVersions[move.TargetShardID] + 1
+
+	if err := params.Dispatch.CloseTableOnShard(ctx, sourceLeader, eventdispatch.CloseTableOnShardRequest{
+		UpdateShardInfo: buildUpdateShardInfo(move.SourceShardID, sourceVersion),
+		TableInfo:       tableInfo,
+	}); err != nil {
+		return errors.WithMessage(err, "close table on source shard")
+	}
+
+	if err := params.Dispatch.OpenTableOnShard(ctx, targetLeader, eventdispatch.OpenTableOnShardRequest{
+		UpdateShardInfo: buildUpdateShardInfo(move.TargetShardID, targetVersion),
+		TableInfo:       tableInfo,
+	}); err != nil {
+		if reopenErr := params.Dispatch.OpenTableOnShard(ctx, sourceLeader, eventdispatch.OpenTableOnShardRequest{
+			UpdateShardInfo: buildUpdateShardInfo(move.SourceShardID, sourceVersion),
+			TableInfo:       tableInfo,
+		}); reopenErr != nil {
+			log.Error("reopen table on source shard failed", zap.String("tableName", move.Table.Name), zap.Uint32("shardID", uint32(move.SourceShardID)), zap.Error(reopenErr))
+		}
+		return errors.WithMessage(err, "open table on target shard")
+	}
+
+	if err := params.ClusterMetadata.MoveTables(ctx, metadata.MoveTablesRequest{
+		Tables: []storage.Table{move.Table},
+		Source: metadata.ShardVersionUpdate{ShardID: move.SourceShardID, LatestVersion: sourceVersion},
+		Target: metadata.ShardVersionUpdate{ShardID: move.TargetShardID, LatestVersion: targetVersion},
+	}); err != nil {
+		return errors.WithMessage(err, "move table metadata")
+	}
+
+	shardVersions[move.SourceShardID] = sourceVersion
+	shardVersions[move.TargetShardID] = targetVersion
+	return nil
+}
+
+func findLeaderNode(snapshot metadata.Snapshot, shardID storage.ShardID) (string, error) {
+	for _, shardNode := range snapshot.Topology.ClusterView.ShardNodes {
+		if shardNode.ID == shardID && shardNode.ShardRole == storage.ShardRoleLeader {
+			return shardNode.NodeName, nil
+		}
+	}
+	return "", errors.WithMessagef(procedure.ErrShardLeaderNotFound, "shardID:%d", shardID)
+}
+
+func buildUpdateShardInfo(shardID storage.ShardID, version uint64) eventdispatch.UpdateShardInfo {
+	return eventdispatch.UpdateShardInfo{
+		CurrShardInfo: metadata.ShardInfo{
+			ID:      shardID,
+			Role:    storage.ShardRoleLeader,
+			Version: version,
+			Status:  storage.ShardStatusUnknown,
+		},
+	}
+}
+
+func (p *Procedure) persist(ctx context.Context) error {
+	meta, err := p.convertToMeta()
+	if err != nil {
+		return errors.WithMessage(err, "convert to meta")
+	}
+	err = p.params.Storage.CreateOrUpdate(ctx, meta)
+	if err != nil {
+		return errors.WithMessage(err, "createOrUpdate procedure storage")
+	}
+	return nil
+}
+
+type rawMove struct {
+	TableName     string
+	SourceShardID uint32
+	TargetShardID uint32
+}
+
+type rawData struct {
+	SchemaName string
+	TableName  string
+	Moves      []rawMove
+}
+
+func (p *Procedure) convertToMeta() (procedure.Meta, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	moves := make([]rawMove, 0, len(p.params.Moves))
+	for _, move := range p.params.Moves {
+		moves = append(moves, rawMove{
+			TableName:     move.Table.Name,
+			SourceShardID: uint32(move.SourceShardID),
+			TargetShardID: uint32(move.TargetShardID),
+		})
+	}
+	rawDataBytes, err := json.Marshal(rawData{
+		SchemaName: p.params.SchemaName,
+		TableName:  p.params.TableName,
+		Moves:      moves,
+	})
+	if err != nil {
+		var emptyMeta procedure.Meta
+		return emptyMeta, procedure.ErrEncodeRawData.WithCausef("marshal raw data, procedureID:%v, err:%v", p.params.ID, err)
+	}
+
+	return procedure.Meta{
+		ID:    p.params.ID,
+		Kind:  procedure.RebalancePartitionTable,
+		State: p.state,
+
+		RawData:   rawDataBytes,
+		UpdatedAt: 0,
+	}, nil
+}
+
+// NewReplayer returns the replayer re-driving the persisted procedure in the dry-run mode.
+func NewReplayer() procedure.Replayer {
+	return procedure.Replayer{
+		Events:       rebalanceEvents,
+		InitialState: stateBegin,
+		FinishState:  stateFinish,
+		Decode:       decodeRawData,
+	}
+}
+
+func decodeRawData(rawDataBytes []byte) (string, procedure.Params, error) {
+	var data rawData
+	if err := json.Unmarshal(rawDataBytes, &data); err != nil {
+		return "", nil, err
+	}
+	subTableNames := make([]string, 0, len(data.Moves))
+	for _, move := range data.Moves {
+		subTableNames = append(subTableNames, move.TableName)
+	}
+	// The state isn't persisted, so the procedure resumes from the beginning.
+	return "", procedure.Params{
+		"schemaName":    data.SchemaName,
+		"tableName":     data.TableName,
+		"subTableNames": subTableNames,
+	}, nil
+}
@@ -0,0 +1,140 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package rebalancepartitiontable_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/rebalancepartitiontable"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+const testPartitionTableName = "partitionTable"
+
+// openTableFailedDispatch fails to open any table on the shard.
+type openTableFailedDispatch struct {
+	test.MockDispatch
+}
+
+func (d openTableFailedDispatch) OpenTableOnShard(_ context.Context, _ string, _ eventdispatch.OpenTableOnShardRequest) error {
+	return errors.New("open table on shard failed")
+}
+
+// createSubTables creates the sub tables all on the shard.
+func createSubTables(ctx context.Context, re *require.Assertions, m *metadata.ClusterMetadata, shardID storage.ShardID, num int) []storage.Table {
+	subTables := make([]storage.Table, 0, num)
+	for i := 0; i < num; i++ {
+		result, err := m.CreateTable(ctx, metadata.CreateTableRequest{
+			ShardID:       shardID,
+			LatestVersion: 0,
+			SchemaName:    test.TestSchemaName,
+			TableName:     fmt.Sprintf("__%s_%d", testPartitionTableName, i),
+			PartitionInfo: storage.PartitionInfo{Info: nil},
+		})
+		re.NoError(err)
+		subTables = append(subTables, result.Table)
+	}
+	return subTables
+}
+
+func TestPlanMoves(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	m := test.InitStableCluster(ctx, t).GetMetadata()
+
+	subTables := createSubTables(ctx, re, m, 0, test.DefaultShardTotal)
+	snapshot := m.GetClusterSnapshot()
+	re.Equal(test.DefaultShardTotal, rebalancepartitiontable.Skew(snapshot, subTables))
+
+	// Every other shard gets one sub table from shard 0.
+	moves := rebalancepartitiontable.PlanMoves(snapshot, subTables)
+	re.Equal(test.DefaultShardTotal-1, len(moves))
+	targets := make(map[storage.ShardID]struct{}, len(moves))
+	for _, move := range moves {
+		re.Equal(storage.ShardID(0), move.SourceShardID)
+		targets[move.TargetShardID] = struct{}{}
+	}
+	re.Equal(test.DefaultShardTotal-1, len(targets))
+
+	// Nothing to move if the sub tables are spread already.
+	re.Empty(rebalancepartitiontable.PlanMoves(snapshot, subTables[:1]))
+}
+
+func TestRebalancePartitionTable(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	m := test.InitStableCluster(ctx, t).GetMetadata()
+
+	subTables := createSubTables(ctx, re, m, 0, test.DefaultShardTotal)
+	snapshot := m.GetClusterSnapshot()
+	p, err := rebalancepartitiontable.NewProcedure(rebalancepartitiontable.ProcedureParams{
+		ID:              0,
+		Dispatch:        test.MockDispatch{},
+		Storage:         test.NewTestStorage(t),
+		ClusterMetadata: m,
+		ClusterSnapshot: snapshot,
+		SchemaName:      test.TestSchemaName,
+		TableName:       testPartitionTableName,
+		Moves:           rebalancepartitiontable.PlanMoves(snapshot, subTables),
+	})
+	re.NoError(err)
+	re.NoError(p.Start(ctx))
+	re.Equal(procedure.StateFinished, string(p.State()))
+
+	snapshot = m.GetClusterSnapshot()
+	re.Equal(0, rebalancepartitiontable.Skew(snapshot, subTables))
+	// The version of shard 0 is bumped by every move out of it.
+	re.Equal(uint64(test.DefaultShardTotal-1), snapshot.Topology.ShardViewsMapping[0].Version)
+	routeResult, err := m.RouteTables(ctx, test.TestSchemaName, []string{subTables[0].Name})
+	re.NoError(err)
+	re.Equal(1, len(routeResult.RouteEntries[subTables[0].Name].NodeShards))
+}
+
+func TestRebalancePartitionTableOpenFailed(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	m := test.InitStableCluster(ctx, t).GetMetadata()
+
+	subTables := createSubTables(ctx, re, m, 0, test.DefaultShardTotal)
+	snapshot := m.GetClusterSnapshot()
+	p, err := rebalancepartitiontable.NewProcedure(rebalancepartitiontable.ProcedureParams{
+		ID:              0,
+		Dispatch:        openTableFailedDispatch{MockDispatch: test.MockDispatch{}},
+		Storage:         test.NewTestStorage(t),
+		ClusterMetadata: m,
+		ClusterSnapshot: snapshot,
+		SchemaName:      test.TestSchemaName,
+		TableName:       testPartitionTableName,
+		Moves:           rebalancepartitiontable.PlanMoves(snapshot, subTables),
+	})
+	re.NoError(err)
+	re.Error(p.Start(ctx))
+	re.Equal(procedure.StateFailed, string(p.State()))
+
+	// The shard views are left unchanged.
+	snapshot = m.GetClusterSnapshot()
+	re.Equal(test.DefaultShardTotal, rebalancepartitiontable.Skew(snapshot, subTables))
+	re.Equal(uint64(0), snapshot.Topology.ShardViewsMapping[0].Version)
+}
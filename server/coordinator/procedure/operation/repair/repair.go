@@ -0,0 +1,337 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repair
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/looplab/fsm"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Fsm state change: Begin -> Repair -> Finish.
+// Repair executes every action independently, and the failure of an action doesn't stop the others.
+const (
+	eventRepair = "EventRepair"
+	eventFinish = "EventFinish"
+
+	stateBegin  = "StateBegin"
+	stateRepair = "StateRepair"
+	stateFinish = "StateFinish"
+)
+
+var (
+	repairEvents = fsm.Events{
+		{Name: eventRepair, Src: []string{stateBegin}, Dst: stateRepair},
+		{Name: eventFinish, Src: []string{stateRepair}, Dst: stateFinish},
+	}
+	repairCallbacks = fsm.Callbacks{
+		eventRepair: repairCallback,
+		eventFinish: finishCallback,
+	}
+)
+
+type ActionType string
+
+const (
+	// ActionReopenShard opens the shard which is assigned to the leader node in meta but not registered by it.
+	ActionReopenShard ActionType = "reopenShard"
+	// ActionCloseShard closes the shard which is registered by the node but not assigned to it in meta.
+	ActionCloseShard ActionType = "closeShard"
+	// ActionBumpVersion updates the shard view version to the newer one registered by the node.
+	ActionBumpVersion ActionType = "bumpVersion"
+)
+
+type Action struct {
+	Type     ActionType      `json:"type"`
+	ShardID  storage.ShardID `json:"shardID"`
+	NodeName string          `json:"nodeName"`
+	// Version is the version to open the shard with, or to bump the shard view to.
+	Version uint64 `json:"version"`
+}
+
+type ActionResult struct {
+	Action
+	Succeeded bool   `json:"succeeded"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Plan builds the actions to repair the shards by comparing the topology with the shards registered by the nodes. Both
+// the leaders and the followers in the topology are assigned, and only the leaders are reopened or bump the version.
+func Plan(snapshot metadata.Snapshot) []Action {
+	assignedShards := make(map[storage.ShardID]map[string]storage.ShardRole, len(snapshot.Topology.ClusterView.ShardNodes))
+	leaderShards := make(map[storage.ShardID]string, len(snapshot.Topology.ClusterView.ShardNodes))
+	for _, shardNode := range snapshot.Topology.ClusterView.ShardNodes {
+		if _, ok := assignedShards[shardNode.ID]; !ok {
+			assignedShards[shardNode.ID] = make(map[string]storage.ShardRole)
+		}
+		assignedShards[shardNode.ID][shardNode.NodeName] = shardNode.ShardRole
+		if shardNode.ShardRole == storage.ShardRoleLeader {
+			leaderShards[shardNode.ID] = shardNode.NodeName
+		}
+	}
+
+	actions := make([]Action, 0)
+	registeredLeaders := make(map[storage.ShardID]struct{}, len(leaderShards))
+	for _, node := range snapshot.RegisteredNodes {
+		for _, shardInfo := range node.ShardInfos {
+			role, assigned := assignedShards[shardInfo.ID][node.Node.Name]
+			if !assigned {
+				actions = append(actions, Action{
+					Type:     ActionCloseShard,
+					ShardID:  shardInfo.ID,
+					NodeName: node.Node.Name,
+					Version:  shardInfo.Version,
+				})
+				continue
+			}
+			if role != storage.ShardRoleLeader {
+				continue
+			}
+			registeredLeaders[shardInfo.ID] = struct{}{}
+
+			shardView, exists := snapshot.Topology.ShardViewsMapping[shardInfo.ID]
+			if exists && shardView.Version < shardInfo.Version {
+				actions = append(actions, Action{
+					Type:     ActionBumpVersion,
+					ShardID:  shardInfo.ID,
+					NodeName: node.Node.Name,
+					Version:  shardInfo.Version,
+				})
+			}
+		}
+	}
+
+	for shardID, nodeName := range leaderShards {
+		if _, registered := registeredLeaders[shardID]; registered {
+			continue
+		}
+		shardView, exists := snapshot.Topology.ShardViewsMapping[shardID]
+		if !exists {
+			continue
+		}
+		actions = append(actions, Action{
+			Type:     ActionReopenShard,
+			ShardID:  shardID,
+			NodeName: nodeName,
+			Version:  shardView.Version,
+		})
+	}
+
+	sort.Slice(actions, func(i, j int) bool {
+		if actions[i].ShardID != actions[j].ShardID {
+			return actions[i].ShardID < actions[j].ShardID
+		}
+		return actions[i].Type < actions[j].Type
+	})
+	return actions
+}
+
+type ProcedureParams struct {
+	ID              uint64
+	Dispatch        eventdispatch.Dispatch
+	ClusterMetadata *metadata.ClusterMetadata
+	ClusterSnapshot metadata.Snapshot
+	Actions         []Action
+
+	OnFinished func([]ActionResult) error
+}
+
+type Procedure struct {
+	fsm                *fsm.FSM
+	params             ProcedureParams
+	relatedVersionInfo procedure.RelatedVersionInfo
+	results            []ActionResult
+
+	// Protect the state.
+	lock  sync.RWMutex
+	state procedure.State
+}
+
+// callbackRequest is fsm callbacks param.
+type callbackRequest struct {
+	ctx context.Context
+	p   *Procedure
+}
+
+func NewProcedure(params ProcedureParams) (procedure.Procedure, error) {
+	if len(params.Actions) == 0 {
+		return nil, errors.WithMessage(procedure.ErrEmptyRepairActions, "new repair procedure")
+	}
+
+	shardWithVersion := make(map[storage.ShardID]uint64, len(params.Actions))
+	for _, action := range params.Actions {
+		// The orphan shard may not exist in the topology, and it is unnecessary to lock it.
+		if shardView, exists := params.ClusterSnapshot.Topology.ShardViewsMapping[action.ShardID]; exists {
+			shardWithVersion[action.ShardID] = shardView.Version
+		}
+	}
+
+	return &Procedure{
+		fsm: procedure.NewFSM(
+			procedure.RepairShards,
+			params.ClusterMetadata.Clock(),
+			stateBegin,
+			repairEvents,
+			repairCallbacks,
+		),
+		params: params,
+		relatedVersionInfo: procedure.RelatedVersionInfo{
+			ClusterID:        params.ClusterSnapshot.Topology.ClusterView.ClusterID,
+			ShardWithVersion: shardWithVersion,
+			ClusterVersion:   params.ClusterSnapshot.Topology.ClusterView.Version,
+		},
+		results: make([]ActionResult, 0, len(params.Actions)),
+		lock:    sync.RWMutex{},
+		state:   procedure.StateInit,
+	}, nil
+}
+
+func (p *Procedure) ID() uint64 {
+	return p.params.ID
+}
+
+func (p *Procedure) Kind() procedure.Kind {
+	return procedure.RepairShards
+}
+
+func (p *Procedure) RelatedVersionInfo() procedure.RelatedVersionInfo {
+	return p.relatedVersionInfo
+}
+
+func (p *Procedure) Priority() procedure.Priority {
+	return procedure.PriorityHigh
+}
+
+func (p *Procedure) StepInfo() procedure.StepInfo {
+	return procedure.FSMStepInfo(p.fsm)
+}
+
+func (p *Procedure) Start(ctx context.Context) error {
+	p.updateStateWithLock(procedure.StateRunning)
+
+	req := callbackRequest{
+		ctx: ctx,
+		p:   p,
+	}
+
+	for {
+		switch p.fsm.Current() {
+		case stateBegin:
+			if err := p.fsm.Event(eventRepair, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "repair procedure repair")
+			}
+		case stateRepair:
+			if err := p.fsm.Event(eventFinish, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "repair procedure finish")
+			}
+		case stateFinish:
+			p.updateStateWithLock(procedure.StateFinished)
+			return nil
+		}
+	}
+}
+
+func (p *Procedure) Cancel(_ context.Context) error {
+	p.updateStateWithLock(procedure.StateCancelled)
+	return nil
+}
+
+func (p *Procedure) State() procedure.State {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.state
+}
+
+func (p *Procedure) updateStateWithLock(state procedure.State) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.state = state
+}
+
+func repairCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+
+	for _, action := range req.p.params.Actions {
+		result := ActionResult{
+			Action:    action,
+			Succeeded: true,
+			Error:     "",
+		}
+		if err := req.p.execute(req.ctx, action); err != nil {
+			log.Warn("repair shard failed", zap.Uint64("procedureID", req.p.ID()), zap.String("action", string(action.Type)), zap.Uint32("shardID", uint32(action.ShardID)), zap.String("nodeName", action.NodeName), zap.Error(err))
+			result.Succeeded = false
+			result.Error = err.Error()
+		}
+		req.p.results = append(req.p.results, result)
+	}
+}
+
+func finishCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+
+	log.Info("repair shards finish", zap.Uint64("procedureID", req.p.ID()), zap.Int("actions", len(req.p.results)))
+	if req.p.params.OnFinished != nil {
+		if err := req.p.params.OnFinished(req.p.results); err != nil {
+			procedure.CancelEventWithLog(event, err, "repair shards on finished")
+			return
+		}
+	}
+}
+
+func (p *Procedure) execute(ctx context.Context, action Action) error {
+	switch action.Type {
+	case ActionReopenShard:
+		return p.params.Dispatch.OpenShard(ctx, action.NodeName, eventdispatch.OpenShardRequest{
+			Shard: metadata.ShardInfo{
+				ID:      action.ShardID,
+				Role:    storage.ShardRoleLeader,
+				Version: action.Version,
+				Status:  storage.ShardStatusUnknown,
+			},
+		})
+	case ActionCloseShard:
+		return p.params.Dispatch.CloseShard(ctx, action.NodeName, eventdispatch.CloseShardRequest{
+			ShardID: uint32(action.ShardID),
+		})
+	case ActionBumpVersion:
+		expect := p.relatedVersionInfo.ShardWithVersion[action.ShardID]
+		return p.params.ClusterMetadata.UpdateShardVersionWithExpect(ctx, action.ShardID, action.Version, expect)
+	default:
+		return errors.WithMessagef(procedure.ErrUnknownRepairAction, "action type:%s", action.Type)
+	}
+}
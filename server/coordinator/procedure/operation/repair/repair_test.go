@@ -0,0 +1,136 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package repair_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/repair"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepairShards(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	c := test.InitStableCluster(ctx, t)
+
+	snapshot := c.GetMetadata().GetClusterSnapshot()
+	shardNodes := snapshot.Topology.ClusterView.ShardNodes
+	re.GreaterOrEqual(len(shardNodes), 2)
+
+	// No node registers the shards, so all of them need to be reopened.
+	actions := repair.Plan(snapshot)
+	re.Equal(len(shardNodes), len(actions))
+	for _, action := range actions {
+		re.Equal(repair.ActionReopenShard, action.Type)
+	}
+
+	// The first shard is registered with a newer version, and the second one is registered by an unexpected node.
+	bumpShard := shardNodes[0]
+	orphanShard := shardNodes[1]
+	snapshot.RegisteredNodes = []metadata.RegisteredNode{
+		{
+			Node: storage.Node{
+				Name:          bumpShard.NodeName,
+				NodeStats:     storage.NewEmptyNodeStats(),
+				LastTouchTime: 0,
+				State:         storage.NodeStateOnline,
+			},
+			ShardInfos: []metadata.ShardInfo{{
+				ID:      bumpShard.ID,
+				Role:    storage.ShardRoleLeader,
+				Version: snapshot.Topology.ShardViewsMapping[bumpShard.ID].Version + 1,
+				Status:  storage.ShardStatusReady,
+			}},
+			Heartbeat: metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
+		},
+		{
+			Node: storage.Node{
+				Name:          "unexpectedNode",
+				NodeStats:     storage.NewEmptyNodeStats(),
+				LastTouchTime: 0,
+				State:         storage.NodeStateOnline,
+			},
+			ShardInfos: []metadata.ShardInfo{{
+				ID:      orphanShard.ID,
+				Role:    storage.ShardRoleLeader,
+				Version: 0,
+				Status:  storage.ShardStatusReady,
+			}},
+			Heartbeat: metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
+		},
+	}
+	actions = repair.Plan(snapshot)
+	actionTypes := make(map[storage.ShardID][]repair.ActionType)
+	for _, action := range actions {
+		actionTypes[action.ShardID] = append(actionTypes[action.ShardID], action.Type)
+	}
+	re.Equal([]repair.ActionType{repair.ActionBumpVersion}, actionTypes[bumpShard.ID])
+	re.Equal([]repair.ActionType{repair.ActionCloseShard, repair.ActionReopenShard}, actionTypes[orphanShard.ID])
+
+	// The follower registered by the node it is assigned to is kept, even if its version is newer.
+	followerSnapshot := snapshot
+	followerSnapshot.Topology.ClusterView.ShardNodes = append(append([]storage.ShardNode{}, shardNodes...), storage.ShardNode{
+		ID:        bumpShard.ID,
+		ShardRole: storage.ShardRoleFollower,
+		NodeName:  "followerNode",
+	})
+	followerSnapshot.RegisteredNodes = append(append([]metadata.RegisteredNode{}, snapshot.RegisteredNodes...), metadata.RegisteredNode{
+		Node: storage.Node{
+			Name:          "followerNode",
+			NodeStats:     storage.NewEmptyNodeStats(),
+			LastTouchTime: 0,
+			State:         storage.NodeStateOnline,
+		},
+		ShardInfos: []metadata.ShardInfo{{
+			ID:      bumpShard.ID,
+			Role:    storage.ShardRoleFollower,
+			Version: snapshot.Topology.ShardViewsMapping[bumpShard.ID].Version + 2,
+			Status:  storage.ShardStatusReady,
+		}},
+		Heartbeat: metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
+	})
+	re.Equal(actions, repair.Plan(followerSnapshot))
+
+	var results []repair.ActionResult
+	p, err := repair.NewProcedure(repair.ProcedureParams{
+		ID:              1,
+		Dispatch:        test.MockDispatch{},
+		ClusterMetadata: c.GetMetadata(),
+		ClusterSnapshot: snapshot,
+		Actions:         actions,
+		OnFinished: func(ret []repair.ActionResult) error {
+			results = ret
+			return nil
+		},
+	})
+	re.NoError(err)
+	re.NoError(p.Start(ctx))
+	re.Equal(procedure.StateFinished, string(p.State()))
+
+	re.Equal(len(actions), len(results))
+	for _, result := range results {
+		re.True(result.Succeeded)
+	}
+	newShardView := c.GetMetadata().GetClusterSnapshot().Topology.ShardViewsMapping[bumpShard.ID]
+	re.Equal(snapshot.Topology.ShardViewsMapping[bumpShard.ID].Version+1, newShardView.Version)
+}
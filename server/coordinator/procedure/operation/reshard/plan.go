@@ -0,0 +1,141 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reshard
+
+import (
+	"sort"
+	"time"
+
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/pkg/errors"
+)
+
+// Plan describes the shards created or removed by the resharding. The shards of the ids in [0, ShardTotal) are always
+// kept by the expanding, and the shards of the ids not less than the new shard total are removed by the shrinking.
+type Plan struct {
+	ShardTotal    uint32
+	NewShardTotal uint32
+	// NewShardNodes are the leaders of the shards created when the shard total is increased, and their ids are taken from
+	// the shard id allocator when the shards are created.
+	NewShardNodes []storage.ShardNode
+	// DrainShardIDs are the shards drained and removed when the shard total is decreased.
+	DrainShardIDs []storage.ShardID
+}
+
+// IsExpand returns true if the shard total is increased by the plan.
+func (p Plan) IsExpand() bool {
+	return p.NewShardTotal > p.ShardTotal
+}
+
+// BuildPlan checks whether the shard total of the cluster can be changed to the newShardTotal, and plans the shards to
+// create or remove. The cluster must be stable with the shards of the ids in [0, shardTotal), besides which there may be
+// the shards split out, and the leaders of all the shards must be on the online nodes, so that the tables can be moved
+// out of the drained shards.
+func BuildPlan(snapshot metadata.Snapshot, shardTotal, newShardTotal uint32, now time.Time) (Plan, error) {
+	var emptyPlan Plan
+	if newShardTotal == 0 {
+		return emptyPlan, errors.WithMessage(procedure.ErrInvalidParams, "shard total must be positive")
+	}
+	if newShardTotal == shardTotal {
+		return emptyPlan, errors.WithMessagef(procedure.ErrShardTotalNotChanged, "shard total:%d", shardTotal)
+	}
+	if !snapshot.Topology.IsStable() {
+		return emptyPlan, errors.WithMessagef(metadata.ErrClusterStateInvalid, "cluster must be stable, state:%d", snapshot.Topology.ClusterView.State)
+	}
+	for shardID := uint32(0); shardID < shardTotal; shardID++ {
+		if _, ok := snapshot.Topology.ShardViewsMapping[storage.ShardID(shardID)]; !ok {
+			return emptyPlan, errors.WithMessagef(procedure.ErrInvalidParams, "shard ids are not contiguous, missing shardID:%d, shardTotal:%d", shardID, shardTotal)
+		}
+	}
+
+	onlineNodes := make(map[string]struct{}, len(snapshot.RegisteredNodes))
+	for _, node := range snapshot.RegisteredNodes {
+		if !node.IsExpired(now) {
+			onlineNodes[node.Node.Name] = struct{}{}
+		}
+	}
+	leaderCounts := make(map[string]int, len(onlineNodes))
+	for nodeName := range onlineNodes {
+		leaderCounts[nodeName] = 0
+	}
+	for _, shardNode := range snapshot.Topology.ClusterView.ShardNodes {
+		if shardNode.ShardRole != storage.ShardRoleLeader {
+			continue
+		}
+		if _, online := onlineNodes[shardNode.NodeName]; !online {
+			return emptyPlan, errors.WithMessagef(procedure.ErrShardLeaderNotFound, "leader node is offline, shardID:%d, node:%s", shardNode.ID, shardNode.NodeName)
+		}
+		leaderCounts[shardNode.NodeName]++
+	}
+
+	plan := Plan{
+		ShardTotal:    shardTotal,
+		NewShardTotal: newShardTotal,
+		NewShardNodes: []storage.ShardNode{},
+		DrainShardIDs: []storage.ShardID{},
+	}
+	if !plan.IsExpand() {
+		for shardID := range snapshot.Topology.ShardViewsMapping {
+			if uint32(shardID) >= newShardTotal {
+				plan.DrainShardIDs = append(plan.DrainShardIDs, shardID)
+			}
+		}
+		sort.Slice(plan.DrainShardIDs, func(i, j int) bool {
+			return plan.DrainShardIDs[i] < plan.DrainShardIDs[j]
+		})
+		return plan, nil
+	}
+
+	if len(onlineNodes) == 0 {
+		return emptyPlan, errors.WithMessage(procedure.ErrNodeNumberNotEnough, "no online node to assign the new shards")
+	}
+	nodeNames := make([]string, 0, len(onlineNodes))
+	for nodeName := range onlineNodes {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	sort.Strings(nodeNames)
+	// Every new shard is assigned to the node with the fewest leaders, and the ties are broken by the node name.
+	for i := shardTotal; i < newShardTotal; i++ {
+		target := nodeNames[0]
+		for _, nodeName := range nodeNames[1:] {
+			if leaderCounts[nodeName] < leaderCounts[target] {
+				target = nodeName
+			}
+		}
+		leaderCounts[target]++
+		plan.NewShardNodes = append(plan.NewShardNodes, storage.ShardNode{
+			ID:        0,
+			ShardRole: storage.ShardRoleLeader,
+			NodeName:  target,
+		})
+	}
+	return plan, nil
+}
+
+// pickTargetShard returns the shard with the fewest tables among the shards kept by the resharding, and the ties are
+// broken by the shard id.
+func pickTargetShard(tableCounts map[storage.ShardID]int, shardTotal uint32) storage.ShardID {
+	target := storage.ShardID(0)
+	for shardID := storage.ShardID(1); uint32(shardID) < shardTotal; shardID++ {
+		if tableCounts[shardID] < tableCounts[target] {
+			target = shardID
+		}
+	}
+	return target
+}
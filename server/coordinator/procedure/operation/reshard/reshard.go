@@ -0,0 +1,550 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reshard
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/looplab/fsm"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// Fsm state change: Begin -> CreateShards -> DrainShards -> RemoveShards -> UpdateShardTotal -> Finish.
+// CreateShards creates the shard views of the new shards, assigns them to the nodes and opens them, if the shard total
+// is increased.
+// DrainShards moves the tables out of the shards to remove one by one, if the shard total is decreased. Every table is
+// closed on the drained shard and opened on the kept shard with the fewest tables before the shard views are updated.
+// RemoveShards closes the drained shards on the nodes, and drops them from the cluster view and the shard views.
+// UpdateShardTotal persists the new shard total of the cluster.
+// The steps not required by the direction of the resharding change nothing.
+const (
+	eventCreateShards     = "EventCreateShards"
+	eventDrainShards      = "EventDrainShards"
+	eventRemoveShards     = "EventRemoveShards"
+	eventUpdateShardTotal = "EventUpdateShardTotal"
+	eventFinish           = "EventFinish"
+
+	stateBegin            = "StateBegin"
+	stateCreateShards     = "StateCreateShards"
+	stateDrainShards      = "StateDrainShards"
+	stateRemoveShards     = "StateRemoveShards"
+	stateUpdateShardTotal = "StateUpdateShardTotal"
+	stateFinish           = "StateFinish"
+)
+
+var (
+	reshardEvents = fsm.Events{
+		{Name: eventCreateShards, Src: []string{stateBegin}, Dst: stateCreateShards},
+		{Name: eventDrainShards, Src: []string{stateCreateShards}, Dst: stateDrainShards},
+		{Name: eventRemoveShards, Src: []string{stateDrainShards}, Dst: stateRemoveShards},
+		{Name: eventUpdateShardTotal, Src: []string{stateRemoveShards}, Dst: stateUpdateShardTotal},
+		{Name: eventFinish, Src: []string{stateUpdateShardTotal}, Dst: stateFinish},
+	}
+	reshardCallbacks = fsm.Callbacks{
+		eventCreateShards:     createShardsCallback,
+		eventDrainShards:      drainShardsCallback,
+		eventRemoveShards:     removeShardsCallback,
+		eventUpdateShardTotal: updateShardTotalCallback,
+		eventFinish:           finishCallback,
+	}
+)
+
+type ProcedureParams struct {
+	ID uint64
+
+	Dispatch eventdispatch.Dispatch
+	Storage  procedure.Storage
+
+	ClusterMetadata *metadata.ClusterMetadata
+	ClusterSnapshot metadata.Snapshot
+	ShardTotal      uint32
+}
+
+type Procedure struct {
+	fsm                *fsm.FSM
+	params             ProcedureParams
+	plan               Plan
+	relatedVersionInfo procedure.RelatedVersionInfo
+
+	// Protect the state.
+	lock  sync.RWMutex
+	state procedure.State
+}
+
+// callbackRequest is fsm callbacks param.
+type callbackRequest struct {
+	ctx context.Context
+	p   *Procedure
+}
+
+func NewProcedure(params ProcedureParams) (procedure.Procedure, error) {
+	clusterMetadata := params.ClusterMetadata
+	plan, err := BuildPlan(params.ClusterSnapshot, clusterMetadata.GetTotalShardNum(), params.ShardTotal, clusterMetadata.Clock().Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// All the shards are held, so that no other procedure moves the shards or the tables during the resharding.
+	shardWithVersion := make(map[storage.ShardID]uint64, len(params.ClusterSnapshot.Topology.ShardViewsMapping))
+	for shardID, shardView := range params.ClusterSnapshot.Topology.ShardViewsMapping {
+		shardWithVersion[shardID] = shardView.Version
+	}
+
+	return &Procedure{
+		fsm: procedure.NewFSM(
+			procedure.Reshard,
+			params.ClusterMetadata.Clock(),
+			stateBegin,
+			reshardEvents,
+			reshardCallbacks,
+		),
+		params: params,
+		plan:   plan,
+		relatedVersionInfo: procedure.RelatedVersionInfo{
+			ClusterID:        params.ClusterSnapshot.Topology.ClusterView.ClusterID,
+			ShardWithVersion: shardWithVersion,
+			ClusterVersion:   params.ClusterSnapshot.Topology.ClusterView.Version,
+		},
+		lock:  sync.RWMutex{},
+		state: procedure.StateInit,
+	}, nil
+}
+
+func (p *Procedure) ID() uint64 {
+	return p.params.ID
+}
+
+func (p *Procedure) Kind() procedure.Kind {
+	return procedure.Reshard
+}
+
+func (p *Procedure) RelatedVersionInfo() procedure.RelatedVersionInfo {
+	return p.relatedVersionInfo
+}
+
+func (p *Procedure) Priority() procedure.Priority {
+	return procedure.PriorityMed
+}
+
+func (p *Procedure) StepInfo() procedure.StepInfo {
+	return procedure.FSMStepInfo(p.fsm)
+}
+
+// Plan returns the shards created or removed by the procedure.
+func (p *Procedure) Plan() Plan {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.plan
+}
+
+// setNewShardNodes records the new shards with the allocated ids in the plan, which is persisted then.
+func (p *Procedure) setNewShardNodes(shardNodes []storage.ShardNode) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.plan.NewShardNodes = shardNodes
+}
+
+func (p *Procedure) Start(ctx context.Context) error {
+	p.updateStateWithLock(procedure.StateRunning)
+
+	req := callbackRequest{
+		ctx: ctx,
+		p:   p,
+	}
+
+	for {
+		switch p.fsm.Current() {
+		case stateBegin:
+			if err := p.persist(ctx); err != nil {
+				return errors.WithMessage(err, "reshard procedure persist")
+			}
+			if err := p.fsm.Event(eventCreateShards, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "reshard procedure create shards")
+			}
+		case stateCreateShards:
+			if err := p.persist(ctx); err != nil {
+				return errors.WithMessage(err, "reshard procedure persist")
+			}
+			if err := p.fsm.Event(eventDrainShards, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "reshard procedure drain shards")
+			}
+		case stateDrainShards:
+			if err := p.persist(ctx); err != nil {
+				return errors.WithMessage(err, "reshard procedure persist")
+			}
+			if err := p.fsm.Event(eventRemoveShards, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "reshard procedure remove shards")
+			}
+		case stateRemoveShards:
+			if err := p.persist(ctx); err != nil {
+				return errors.WithMessage(err, "reshard procedure persist")
+			}
+			if err := p.fsm.Event(eventUpdateShardTotal, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "reshard procedure update shard total")
+			}
+		case stateUpdateShardTotal:
+			if err := p.persist(ctx); err != nil {
+				return errors.WithMessage(err, "reshard procedure persist")
+			}
+			if err := p.fsm.Event(eventFinish, req); err != nil {
+				p.updateStateWithLock(procedure.StateFailed)
+				return errors.WithMessage(err, "reshard procedure finish")
+			}
+		case stateFinish:
+			p.updateStateWithLock(procedure.StateFinished)
+			if err := p.persist(ctx); err != nil {
+				return errors.WithMessage(err, "reshard procedure persist")
+			}
+			return nil
+		}
+	}
+}
+
+func (p *Procedure) Cancel(_ context.Context) error {
+	p.updateStateWithLock(procedure.StateCancelled)
+	return nil
+}
+
+func (p *Procedure) State() procedure.State {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.state
+}
+
+func (p *Procedure) updateStateWithLock(state procedure.State) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.state = state
+}
+
+func createShardsCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+	params := req.p.params
+	plan := req.p.plan
+	if len(plan.NewShardNodes) == 0 {
+		return
+	}
+
+	// The ids of the new shards are taken from the allocator, so that they are not allocated again for the other shards,
+	// and all the allocated ids are given back if the shards fail to be created.
+	newShardNodes := make([]storage.ShardNode, 0, len(plan.NewShardNodes))
+	createShardViews := make([]metadata.CreateShardView, 0, len(plan.NewShardNodes))
+	collectShardIDs := func() {
+		for _, shardNode := range newShardNodes {
+			if err := params.ClusterMetadata.CollectShardID(req.ctx, uint32(shardNode.ID)); err != nil {
+				log.Warn("collect shard id failed", zap.Uint32("shardID", uint32(shardNode.ID)), zap.Error(err))
+			}
+		}
+	}
+	for _, shardNode := range plan.NewShardNodes {
+		shardID, err := params.ClusterMetadata.AllocShardID(req.ctx)
+		if err != nil {
+			collectShardIDs()
+			procedure.CancelEventWithLog(event, err, "alloc shard id")
+			return
+		}
+		shardNode.ID = storage.ShardID(shardID)
+		newShardNodes = append(newShardNodes, shardNode)
+		createShardViews = append(createShardViews, metadata.CreateShardView{
+			ShardID: shardNode.ID,
+			Tables:  []storage.TableID{},
+		})
+	}
+	if err := params.ClusterMetadata.CreateShardViews(req.ctx, createShardViews); err != nil {
+		collectShardIDs()
+		procedure.CancelEventWithLog(event, err, "create shard views")
+		return
+	}
+	req.p.setNewShardNodes(newShardNodes)
+
+	clusterView := params.ClusterMetadata.GetClusterView()
+	shardNodes := make([]storage.ShardNode, 0, len(clusterView.ShardNodes)+len(newShardNodes))
+	shardNodes = append(shardNodes, clusterView.ShardNodes...)
+	shardNodes = append(shardNodes, newShardNodes...)
+	if err := params.ClusterMetadata.UpdateClusterView(req.ctx, clusterView.State, shardNodes); err != nil {
+		procedure.CancelEventWithLog(event, err, "assign new shards")
+		return
+	}
+
+	// The new shards failing to open are left to the schedulers, which reopen the shards missing on the nodes.
+	for _, shardNode := range newShardNodes {
+		if err := params.Dispatch.OpenShard(req.ctx, shardNode.NodeName, eventdispatch.OpenShardRequest{
+			Shard: metadata.ShardInfo{
+				ID:      shardNode.ID,
+				Role:    storage.ShardRoleLeader,
+				Version: 0,
+				Status:  storage.ShardStatusUnknown,
+			},
+		}); err != nil {
+			log.Warn("open new shard failed", zap.Uint32("shardID", uint32(shardNode.ID)), zap.String("node", shardNode.NodeName), zap.Error(err))
+		}
+	}
+}
+
+func drainShardsCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+	params := req.p.params
+	plan := req.p.plan
+	if len(plan.DrainShardIDs) == 0 {
+		return
+	}
+
+	snapshot := params.ClusterMetadata.GetClusterSnapshot()
+	// The shard versions are bumped by every move, so they are tracked across the moves.
+	shardVersions := make(map[storage.ShardID]uint64, len(snapshot.Topology.ShardViewsMapping))
+	tableCounts := make(map[storage.ShardID]int, plan.NewShardTotal)
+	for shardID, shardView := range snapshot.Topology.ShardViewsMapping {
+		shardVersions[shardID] = shardView.Version
+		if uint32(shardID) < plan.NewShardTotal {
+			tableCounts[shardID] = len(shardView.TableIDs)
+		}
+	}
+
+	shardTables := params.ClusterMetadata.GetShardTables(plan.DrainShardIDs)
+	for _, sourceShardID := range plan.DrainShardIDs {
+		for _, tableInfo := range shardTables[sourceShardID].Tables {
+			targetShardID := pickTargetShard(tableCounts, plan.NewShardTotal)
+			if err := moveTable(req.ctx, params, snapshot, tableInfo, sourceShardID, targetShardID, shardVersions); err != nil {
+				procedure.CancelEventWithLog(event, err, "move table", zap.String("tableName", tableInfo.Name), zap.Uint32("sourceShardID", uint32(sourceShardID)), zap.Uint32("targetShardID", uint32(targetShardID)))
+				return
+			}
+			tableCounts[targetShardID]++
+		}
+	}
+}
+
+func removeShardsCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+	params := req.p.params
+	plan := req.p.plan
+	if len(plan.DrainShardIDs) == 0 {
+		return
+	}
+
+	// The drained shards failing to close are closed by the repair later, since they are unknown to the cluster view.
+	dropShardNodes := make([]storage.ShardNode, 0, len(plan.DrainShardIDs))
+	for _, shardID := range plan.DrainShardIDs {
+		shardNodes, err := params.ClusterMetadata.GetShardNodesByShardID(shardID)
+		if err != nil {
+			continue
+		}
+		for _, shardNode := range shardNodes {
+			if err := params.Dispatch.CloseShard(req.ctx, shardNode.NodeName, eventdispatch.CloseShardRequest{ShardID: uint32(shardID)}); err != nil {
+				log.Warn("close drained shard failed", zap.Uint32("shardID", uint32(shardID)), zap.String("node", shardNode.NodeName), zap.Error(err))
+			}
+		}
+		dropShardNodes = append(dropShardNodes, shardNodes...)
+	}
+	if len(dropShardNodes) > 0 {
+		if err := params.ClusterMetadata.DropShardNode(req.ctx, dropShardNodes); err != nil {
+			procedure.CancelEventWithLog(event, err, "drop drained shard nodes")
+			return
+		}
+	}
+
+	if err := params.ClusterMetadata.DeleteShardViews(req.ctx, plan.DrainShardIDs); err != nil {
+		procedure.CancelEventWithLog(event, err, "delete drained shard views")
+		return
+	}
+}
+
+func updateShardTotalCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+
+	if err := req.p.params.ClusterMetadata.UpdateShardTotal(req.ctx, req.p.plan.NewShardTotal); err != nil {
+		procedure.CancelEventWithLog(event, err, "update shard total", zap.Uint32("shardTotal", req.p.plan.NewShardTotal))
+		return
+	}
+}
+
+func finishCallback(event *fsm.Event) {
+	req, err := procedure.GetRequestFromEvent[callbackRequest](event)
+	if err != nil {
+		procedure.CancelEventWithLog(event, err, "get request from event")
+		return
+	}
+
+	log.Info("reshard finish", zap.Uint64("procedureID", req.p.ID()), zap.Uint32("from", req.p.plan.ShardTotal), zap.Uint32("to", req.p.plan.NewShardTotal))
+}
+
+// moveTable closes the table on the drained shard and opens it on the target shard before updating the shard views.
+// If the table fails to open on the target shard, it is reopened on the drained shard and the shard views are left
+// unchanged.
+func moveTable(ctx context.Context, params ProcedureParams, snapshot metadata.Snapshot, tableInfo metadata.TableInfo, sourceShardID, targetShardID storage.ShardID, shardVersions map[storage.ShardID]uint64) error {
+	tables := params.ClusterMetadata.GetTablesByIDs([]storage.TableID{tableInfo.ID})
+	if len(tables) == 0 {
+		return errors.WithMessagef(metadata.ErrTableNotFound, "tableID:%d", tableInfo.ID)
+	}
+	sourceLeader, err := findLeaderNode(snapshot, sourceShardID)
+	if err != nil {
+		return err
+	}
+	targetLeader, err := findLeaderNode(snapshot, targetShardID)
+	if err != nil {
+		return err
+	}
+
+	sourceVersion := shardVersions[sourceShardID] + 1
+	targetVersion := shardVersions[targetShardID] + 1
+
+	if err := params.Dispatch.CloseTableOnShard(ctx, sourceLeader, eventdispatch.CloseTableOnShardRequest{
+		UpdateShardInfo: buildUpdateShardInfo(sourceShardID, sourceVersion),
+		TableInfo:       tableInfo,
+	}); err != nil {
+		return errors.WithMessage(err, "close table on drained shard")
+	}
+
+	if err := params.Dispatch.OpenTableOnShard(ctx, targetLeader, eventdispatch.OpenTableOnShardRequest{
+		UpdateShardInfo: buildUpdateShardInfo(targetShardID, targetVersion),
+		TableInfo:       tableInfo,
+	}); err != nil {
+		if reopenErr := params.Dispatch.OpenTableOnShard(ctx, sourceLeader, eventdispatch.OpenTableOnShardRequest{
+			UpdateShardInfo: buildUpdateShardInfo(sourceShardID, sourceVersion),
+			TableInfo:       tableInfo,
+		}); reopenErr != nil {
+			log.Error("reopen table on drained shard failed", zap.String("tableName", tableInfo.Name), zap.Uint32("shardID", uint32(sourceShardID)), zap.Error(reopenErr))
+		}
+		return errors.WithMessage(err, "open table on target shard")
+	}
+
+	if err := params.ClusterMetadata.MoveTables(ctx, metadata.MoveTablesRequest{
+		Tables: tables,
+		Source: metadata.ShardVersionUpdate{ShardID: sourceShardID, LatestVersion: sourceVersion},
+		Target: metadata.ShardVersionUpdate{ShardID: targetShardID, LatestVersion: targetVersion},
+	}); err != nil {
+		return errors.WithMessage(err, "move table metadata")
+	}
+
+	shardVersions[sourceShardID] = sourceVersion
+	shardVersions[targetShardID] = targetVersion
+	return nil
+}
+
+func findLeaderNode(snapshot metadata.Snapshot, shardID storage.ShardID) (string, error) {
+	for _, shardNode := range snapshot.Topology.ClusterView.ShardNodes {
+		if shardNode.ID == shardID && shardNode.ShardRole == storage.ShardRoleLeader {
+			return shardNode.NodeName, nil
+		}
+	}
+	return "", errors.WithMessagef(procedure.ErrShardLeaderNotFound, "shardID:%d", shardID)
+}
+
+func buildUpdateShardInfo(shardID storage.ShardID, version uint64) eventdispatch.UpdateShardInfo {
+	return eventdispatch.UpdateShardInfo{
+		CurrShardInfo: metadata.ShardInfo{
+			ID:      shardID,
+			Role:    storage.ShardRoleLeader,
+			Version: version,
+			Status:  storage.ShardStatusUnknown,
+		},
+	}
+}
+
+func (p *Procedure) persist(ctx context.Context) error {
+	meta, err := p.convertToMeta()
+	if err != nil {
+		return errors.WithMessage(err, "convert to meta")
+	}
+	err = p.params.Storage.CreateOrUpdate(ctx, meta)
+	if err != nil {
+		return errors.WithMessage(err, "createOrUpdate procedure storage")
+	}
+	return nil
+}
+
+type rawData struct {
+	ShardTotal    uint32
+	NewShardTotal uint32
+	NewShardNodes []storage.ShardNode
+	DrainShardIDs []storage.ShardID
+}
+
+func (p *Procedure) convertToMeta() (procedure.Meta, error) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	rawDataBytes, err := json.Marshal(rawData{
+		ShardTotal:    p.plan.ShardTotal,
+		NewShardTotal: p.plan.NewShardTotal,
+		NewShardNodes: p.plan.NewShardNodes,
+		DrainShardIDs: p.plan.DrainShardIDs,
+	})
+	if err != nil {
+		var emptyMeta procedure.Meta
+		return emptyMeta, procedure.ErrEncodeRawData.WithCausef("marshal raw data, procedureID:%v, err:%v", p.params.ID, err)
+	}
+
+	return procedure.Meta{
+		ID:    p.params.ID,
+		Kind:  procedure.Reshard,
+		State: p.state,
+
+		RawData:   rawDataBytes,
+		UpdatedAt: 0,
+	}, nil
+}
+
+// NewReplayer returns the replayer re-driving the persisted procedure in the dry-run mode.
+func NewReplayer() procedure.Replayer {
+	return procedure.Replayer{
+		Events:       reshardEvents,
+		InitialState: stateBegin,
+		FinishState:  stateFinish,
+		Decode:       decodeRawData,
+	}
+}
+
+func decodeRawData(rawDataBytes []byte) (string, procedure.Params, error) {
+	var data rawData
+	if err := json.Unmarshal(rawDataBytes, &data); err != nil {
+		return "", nil, err
+	}
+	// The state isn't persisted, so the procedure resumes from the beginning, and there is no param schema of the kind.
+	return "", procedure.Params{}, nil
+}
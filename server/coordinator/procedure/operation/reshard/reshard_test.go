@@ -0,0 +1,203 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package reshard_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/reshard"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildPlan(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	prepareCluster := test.InitPrepareCluster(ctx, t)
+	_, err := reshard.BuildPlan(prepareCluster.GetMetadata().GetClusterSnapshot(), test.DefaultShardTotal, test.DefaultShardTotal+1, time.Now())
+	re.True(coderr.Is(err, metadata.ErrClusterStateInvalid.Code()))
+
+	c := test.InitStableCluster(ctx, t)
+	snapshot := c.GetMetadata().GetClusterSnapshot()
+	_, err = reshard.BuildPlan(snapshot, test.DefaultShardTotal, test.DefaultShardTotal, time.Now())
+	re.True(coderr.Is(err, procedure.ErrShardTotalNotChanged.Code()))
+	_, err = reshard.BuildPlan(snapshot, test.DefaultShardTotal, 0, time.Now())
+	re.True(coderr.Is(err, procedure.ErrInvalidParams.Code()))
+	_, err = reshard.BuildPlan(snapshot, test.DefaultShardTotal+1, test.DefaultShardTotal+2, time.Now())
+	re.True(coderr.Is(err, procedure.ErrInvalidParams.Code()))
+
+	// The new shards are spread over the nodes with the fewest leaders.
+	plan, err := reshard.BuildPlan(snapshot, test.DefaultShardTotal, test.DefaultShardTotal*2, time.Now())
+	re.NoError(err)
+	re.True(plan.IsExpand())
+	re.Empty(plan.DrainShardIDs)
+	re.Equal(test.DefaultShardTotal, len(plan.NewShardNodes))
+	leaderCounts := make(map[string]int, test.DefaultNodeCount)
+	for _, shardNode := range snapshot.Topology.ClusterView.ShardNodes {
+		leaderCounts[shardNode.NodeName]++
+	}
+	for _, shardNode := range plan.NewShardNodes {
+		leaderCounts[shardNode.NodeName]++
+	}
+	for _, count := range leaderCounts {
+		re.Equal(test.DefaultShardTotal*2/test.DefaultNodeCount, count)
+	}
+
+	// The shards at the end of the id range are drained.
+	plan, err = reshard.BuildPlan(snapshot, test.DefaultShardTotal, 1, time.Now())
+	re.NoError(err)
+	re.False(plan.IsExpand())
+	re.Empty(plan.NewShardNodes)
+	re.Equal([]storage.ShardID{1, 2, 3}, plan.DrainShardIDs)
+
+	// The tables can't be moved if the leaders are offline.
+	_, err = reshard.BuildPlan(snapshot, test.DefaultShardTotal, 1, time.Now().Add(time.Hour))
+	re.True(coderr.Is(err, procedure.ErrShardLeaderNotFound.Code()))
+}
+
+func TestReshardExpand(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	m := test.InitStableCluster(ctx, t).GetMetadata()
+
+	newShardTotal := uint32(test.DefaultShardTotal + 2)
+	p, err := reshard.NewProcedure(reshard.ProcedureParams{
+		ID:              1,
+		Dispatch:        test.MockDispatch{},
+		Storage:         test.NewTestStorage(t),
+		ClusterMetadata: m,
+		ClusterSnapshot: m.GetClusterSnapshot(),
+		ShardTotal:      newShardTotal,
+	})
+	re.NoError(err)
+	re.NoError(p.Start(ctx))
+	re.Equal(procedure.StateFinished, string(p.State()))
+
+	snapshot := m.GetClusterSnapshot()
+	re.Equal(newShardTotal, m.GetTotalShardNum())
+	re.Equal(int(newShardTotal), len(snapshot.Topology.ShardViewsMapping))
+	re.True(snapshot.Topology.IsStable())
+}
+
+func TestReshardExpandAfterSplit(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	m := test.InitStableCluster(ctx, t).GetMetadata()
+
+	// The shard split out takes the lowest free id without changing the shard total.
+	splitShardID, err := m.AllocShardID(ctx)
+	re.NoError(err)
+	re.Equal(uint32(test.DefaultShardTotal), splitShardID)
+	re.NoError(m.CreateShardViews(ctx, []metadata.CreateShardView{{ShardID: storage.ShardID(splitShardID), Tables: []storage.TableID{}}}))
+	clusterView := m.GetClusterView()
+	shardNodes := append([]storage.ShardNode{}, clusterView.ShardNodes...)
+	shardNodes = append(shardNodes, storage.ShardNode{
+		ID:        storage.ShardID(splitShardID),
+		ShardRole: storage.ShardRoleLeader,
+		NodeName:  clusterView.ShardNodes[0].NodeName,
+	})
+	re.NoError(m.UpdateClusterView(ctx, clusterView.State, shardNodes))
+
+	newShardTotal := uint32(test.DefaultShardTotal + 2)
+	p, err := reshard.NewProcedure(reshard.ProcedureParams{
+		ID:              1,
+		Dispatch:        test.MockDispatch{},
+		Storage:         test.NewTestStorage(t),
+		ClusterMetadata: m,
+		ClusterSnapshot: m.GetClusterSnapshot(),
+		ShardTotal:      newShardTotal,
+	})
+	re.NoError(err)
+	re.NoError(p.Start(ctx))
+	re.Equal(procedure.StateFinished, string(p.State()))
+
+	// The new shards take the ids next to the shard split out.
+	newShardIDs := make([]storage.ShardID, 0, len(p.(*reshard.Procedure).Plan().NewShardNodes))
+	for _, shardNode := range p.(*reshard.Procedure).Plan().NewShardNodes {
+		newShardIDs = append(newShardIDs, shardNode.ID)
+	}
+	re.Equal([]storage.ShardID{test.DefaultShardTotal + 1, test.DefaultShardTotal + 2}, newShardIDs)
+	snapshot := m.GetClusterSnapshot()
+	re.Equal(int(newShardTotal)+1, len(snapshot.Topology.ShardViewsMapping))
+	for _, shardID := range newShardIDs {
+		re.Contains(snapshot.Topology.ShardViewsMapping, shardID)
+	}
+}
+
+func TestReshardShrink(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	m := test.InitStableCluster(ctx, t).GetMetadata()
+
+	// Every shard has two tables.
+	tableNames := make([]string, 0, test.DefaultShardTotal*2)
+	for i := 0; i < test.DefaultShardTotal*2; i++ {
+		tableName := fmt.Sprintf("table%d", i)
+		_, err := m.CreateTable(ctx, metadata.CreateTableRequest{
+			ShardID:       storage.ShardID(i % test.DefaultShardTotal),
+			LatestVersion: 0,
+			SchemaName:    test.TestSchemaName,
+			TableName:     tableName,
+			PartitionInfo: storage.PartitionInfo{Info: nil},
+		})
+		re.NoError(err)
+		tableNames = append(tableNames, tableName)
+	}
+
+	newShardTotal := uint32(2)
+	p, err := reshard.NewProcedure(reshard.ProcedureParams{
+		ID:              1,
+		Dispatch:        test.MockDispatch{},
+		Storage:         test.NewTestStorage(t),
+		ClusterMetadata: m,
+		ClusterSnapshot: m.GetClusterSnapshot(),
+		ShardTotal:      newShardTotal,
+	})
+	re.NoError(err)
+	re.NoError(p.Start(ctx))
+	re.Equal(procedure.StateFinished, string(p.State()))
+
+	snapshot := m.GetClusterSnapshot()
+	re.Equal(newShardTotal, m.GetTotalShardNum())
+	re.Equal(int(newShardTotal), len(snapshot.Topology.ShardViewsMapping))
+	re.True(snapshot.Topology.IsStable())
+	for _, shardView := range snapshot.Topology.ShardViewsMapping {
+		re.Equal(test.DefaultShardTotal, len(shardView.TableIDs))
+	}
+
+	// All the tables are routed to the kept shards.
+	routeResult, err := m.RouteTables(ctx, test.TestSchemaName, tableNames)
+	re.NoError(err)
+	re.Equal(len(tableNames), len(routeResult.RouteEntries))
+	for _, entry := range routeResult.RouteEntries {
+		re.Equal(1, len(entry.NodeShards))
+		re.Less(uint32(entry.NodeShards[0].ShardInfo.ID), newShardTotal)
+	}
+
+	// The ids of the removed shards are reused.
+	shardID, err := m.AllocShardID(ctx)
+	re.NoError(err)
+	re.Equal(newShardTotal, shardID)
+}
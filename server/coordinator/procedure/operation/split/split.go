@@ -100,7 +100,9 @@ func NewProcedure(params ProcedureParams) (procedure.Procedure, error) {
 		return nil, err
 	}
 
-	splitFsm := fsm.NewFSM(
+	splitFsm := procedure.NewFSM(
+		procedure.Split,
+		params.ClusterMetadata.Clock(),
 		stateBegin,
 		splitEvents,
 		splitCallbacks,
@@ -182,6 +184,10 @@ func (p *Procedure) Priority() procedure.Priority {
 	return procedure.PriorityHigh
 }
 
+func (p *Procedure) StepInfo() procedure.StepInfo {
+	return procedure.FSMStepInfo(p.fsm)
+}
+
 func (p *Procedure) Start(ctx context.Context) error {
 	p.updateStateWithLock(procedure.StateRunning)
 
@@ -360,8 +366,34 @@ func (p *Procedure) convertToMeta() (procedure.Meta, error) {
 		Kind:  procedure.Split,
 		State: p.state,
 
-		RawData: rawDataBytes,
+		RawData:   rawDataBytes,
+		UpdatedAt: 0,
 	}
 
 	return meta, nil
 }
+
+// NewReplayer returns the replayer re-driving the persisted procedure in the dry-run mode.
+func NewReplayer() procedure.Replayer {
+	return procedure.Replayer{
+		Events:       splitEvents,
+		InitialState: stateBegin,
+		FinishState:  stateFinish,
+		Decode:       decodeRawData,
+	}
+}
+
+func decodeRawData(rawDataBytes []byte) (string, procedure.Params, error) {
+	var data rawData
+	if err := json.Unmarshal(rawDataBytes, &data); err != nil {
+		return "", nil, err
+	}
+	// The state isn't persisted, so the procedure resumes from the beginning.
+	return "", procedure.Params{
+		"schemaName":     data.SchemaName,
+		"tableNames":     data.TableNames,
+		"shardID":        storage.ShardID(data.ShardID),
+		"newShardID":     storage.ShardID(data.NewShardID),
+		"targetNodeName": data.TargetNodeName,
+	}, nil
+}
@@ -21,6 +21,7 @@ import (
 	"encoding/json"
 	"sync"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/pkg/log"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
@@ -92,6 +93,7 @@ type ProcedureParams struct {
 
 	Dispatch eventdispatch.Dispatch
 	Storage  procedure.Storage
+	Clock    clock.Clock
 
 	ClusterSnapshot metadata.Snapshot
 
@@ -110,7 +112,9 @@ func NewProcedure(params ProcedureParams) (procedure.Procedure, error) {
 		return nil, err
 	}
 
-	transferLeaderOperationFsm := fsm.NewFSM(
+	transferLeaderOperationFsm := procedure.NewFSM(
+		procedure.TransferLeader,
+		params.Clock,
 		stateBegin,
 		transferLeaderEvents,
 		transferLeaderCallbacks,
@@ -183,6 +187,10 @@ func (p *Procedure) Priority() procedure.Priority {
 	return procedure.PriorityHigh
 }
 
+func (p *Procedure) StepInfo() procedure.StepInfo {
+	return procedure.FSMStepInfo(p.fsm)
+}
+
 func (p *Procedure) Start(ctx context.Context) error {
 	p.updateStateWithLock(procedure.StateRunning)
 
@@ -351,8 +359,31 @@ func (p *Procedure) convertToMeta() (procedure.Meta, error) {
 		Kind:  procedure.TransferLeader,
 		State: p.state,
 
-		RawData: rawDataBytes,
+		RawData:   rawDataBytes,
+		UpdatedAt: 0,
 	}
 
 	return meta, nil
 }
+
+// NewReplayer returns the replayer re-driving the persisted procedure in the dry-run mode.
+func NewReplayer() procedure.Replayer {
+	return procedure.Replayer{
+		Events:       transferLeaderEvents,
+		InitialState: stateBegin,
+		FinishState:  stateFinish,
+		Decode:       decodeRawData,
+	}
+}
+
+func decodeRawData(rawDataBytes []byte) (string, procedure.Params, error) {
+	var data rawData
+	if err := json.Unmarshal(rawDataBytes, &data); err != nil {
+		return "", nil, err
+	}
+	return data.FsmState, procedure.Params{
+		"shardID":           data.ShardID,
+		"oldLeaderNodeName": data.OldLeaderNodeName,
+		"newLeaderNodeName": data.NewLeaderNodeName,
+	}, nil
+}
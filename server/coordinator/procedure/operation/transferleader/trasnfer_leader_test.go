@@ -18,8 +18,12 @@ package transferleader_test
 
 import (
 	"context"
+	"encoding/json"
 	"testing"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/transferleader"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
 	"github.com/CeresDB/horaemeta/server/storage"
@@ -46,6 +50,7 @@ func TestTransferLeader(t *testing.T) {
 		ID:                0,
 		Dispatch:          dispatch,
 		Storage:           s,
+		Clock:             clock.NewRealClock(),
 		ClusterSnapshot:   snapshot,
 		ShardID:           targetShardID,
 		OldLeaderNodeName: "",
@@ -56,3 +61,64 @@ func TestTransferLeader(t *testing.T) {
 	err = p.Start(ctx)
 	re.NoError(err)
 }
+
+// recordingStorage keeps the last persisted meta.
+type recordingStorage struct {
+	test.MockStorage
+	meta *procedure.Meta
+}
+
+func (s *recordingStorage) CreateOrUpdate(_ context.Context, meta procedure.Meta) error {
+	s.meta = &meta
+	return nil
+}
+
+func TestReplayTransferLeader(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	c := test.InitStableCluster(ctx, t)
+	s := &recordingStorage{MockStorage: test.MockStorage{}, meta: nil}
+
+	snapshot := c.GetMetadata().GetClusterSnapshot()
+	shardID := snapshot.Topology.ClusterView.ShardNodes[0].ID
+	p, err := transferleader.NewProcedure(transferleader.ProcedureParams{
+		ID:                1,
+		Dispatch:          test.MockDispatch{},
+		Storage:           s,
+		Clock:             clock.NewRealClock(),
+		ClusterSnapshot:   snapshot,
+		ShardID:           shardID,
+		OldLeaderNodeName: "",
+		NewLeaderNodeName: snapshot.RegisteredNodes[0].Node.Name,
+	})
+	re.NoError(err)
+	re.NoError(p.Start(ctx))
+	re.NotNil(s.meta)
+
+	// The finished procedure can't resume.
+	result, err := procedure.Replay(transferleader.NewReplayer(), snapshot, s.meta)
+	re.NoError(err)
+	re.False(result.Resumable)
+	re.Equal("StateFinish", result.FSMState)
+	re.Empty(result.Steps)
+
+	// The procedure rewound to the step closing the old leader resumes unless the new leader is unregistered.
+	var rawData map[string]any
+	re.NoError(json.Unmarshal(s.meta.RawData, &rawData))
+	rawData["FsmState"] = "StateCloseOldLeader"
+	s.meta.RawData, err = json.Marshal(rawData)
+	re.NoError(err)
+	s.meta.State = procedure.StateRunning
+
+	result, err = procedure.Replay(transferleader.NewReplayer(), snapshot, s.meta)
+	re.NoError(err)
+	re.True(result.Resumable)
+	re.Len(result.Steps, 2)
+
+	unregisteredSnapshot := metadata.Snapshot{Topology: snapshot.Topology, RegisteredNodes: []metadata.RegisteredNode{}}
+	result, err = procedure.Replay(transferleader.NewReplayer(), unregisteredSnapshot, s.meta)
+	re.NoError(err)
+	re.False(result.Resumable)
+	re.Len(result.Reasons, 1)
+	re.Contains(result.Reasons[0], "newLeaderNodeName")
+}
@@ -0,0 +1,262 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package procedure
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+)
+
+type ParamType string
+
+const (
+	// ParamTypeString is a non-empty string.
+	ParamTypeString ParamType = "string"
+	// ParamTypeStrings is a list of non-empty and distinct strings.
+	ParamTypeStrings ParamType = "strings"
+	// ParamTypeShardID is the id of a shard existing in the cluster topology.
+	ParamTypeShardID ParamType = "shardID"
+	// ParamTypeNewShardID is the id of a shard not existing in the cluster topology yet.
+	ParamTypeNewShardID ParamType = "newShardID"
+	// ParamTypeNodeName is the name of a node registered in the cluster.
+	ParamTypeNodeName ParamType = "nodeName"
+)
+
+type ParamField struct {
+	Name     string    `json:"name"`
+	Type     ParamType `json:"type"`
+	Required bool      `json:"required"`
+}
+
+// ParamSchema describes the params accepted by the procedure of the kind.
+type ParamSchema struct {
+	Kind   Kind         `json:"kind"`
+	Name   string       `json:"name"`
+	Fields []ParamField `json:"fields"`
+}
+
+// Params are the values of the procedure params keyed by the field names in the schema. The values are typed as
+// string, []string or storage.ShardID according to the field types.
+type Params map[string]any
+
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// ParamValidationError carries all the field-level errors found when validating the params of a procedure.
+type ParamValidationError struct {
+	Kind        Kind
+	FieldErrors []FieldError
+}
+
+func (e *ParamValidationError) Error() string {
+	reasons := make([]string, 0, len(e.FieldErrors))
+	for _, fieldError := range e.FieldErrors {
+		reasons = append(reasons, fmt.Sprintf("%s: %s", fieldError.Field, fieldError.Reason))
+	}
+	return fmt.Sprintf("invalid params of procedure kind %d, %s", e.Kind, strings.Join(reasons, "; "))
+}
+
+// Cause makes the error carry the code of ErrInvalidParams.
+func (e *ParamValidationError) Cause() error {
+	return ErrInvalidParams
+}
+
+var paramSchemas = map[Kind]ParamSchema{
+	TransferLeader: {
+		Kind: TransferLeader,
+		Name: "transferLeader",
+		Fields: []ParamField{
+			{Name: "shardID", Type: ParamTypeShardID, Required: true},
+			// The old leader may be offline already, so it is not required to be a registered node.
+			{Name: "oldLeaderNodeName", Type: ParamTypeString, Required: false},
+			{Name: "newLeaderNodeName", Type: ParamTypeNodeName, Required: true},
+		},
+	},
+	Failover: {
+		Kind: Failover,
+		Name: "failover",
+		Fields: []ParamField{
+			{Name: "shardID", Type: ParamTypeShardID, Required: true},
+			// The old leader is offline, so it is not required to be a registered node, and it may be dropped already.
+			{Name: "oldLeaderNodeName", Type: ParamTypeString, Required: false},
+			{Name: "newLeaderNodeName", Type: ParamTypeNodeName, Required: true},
+		},
+	},
+	Split: {
+		Kind: Split,
+		Name: "split",
+		Fields: []ParamField{
+			{Name: "schemaName", Type: ParamTypeString, Required: true},
+			{Name: "tableNames", Type: ParamTypeStrings, Required: true},
+			{Name: "shardID", Type: ParamTypeShardID, Required: true},
+			{Name: "newShardID", Type: ParamTypeNewShardID, Required: true},
+			{Name: "targetNodeName", Type: ParamTypeNodeName, Required: true},
+		},
+	},
+	RebalancePartitionTable: {
+		Kind: RebalancePartitionTable,
+		Name: "rebalancePartitionTable",
+		Fields: []ParamField{
+			{Name: "schemaName", Type: ParamTypeString, Required: true},
+			{Name: "tableName", Type: ParamTypeString, Required: true},
+			{Name: "subTableNames", Type: ParamTypeStrings, Required: true},
+		},
+	},
+	CreateTable: {
+		Kind: CreateTable,
+		Name: "createTable",
+		Fields: []ParamField{
+			{Name: "schemaName", Type: ParamTypeString, Required: true},
+			{Name: "tableName", Type: ParamTypeString, Required: true},
+		},
+	},
+	DropTable: {
+		Kind: DropTable,
+		Name: "dropTable",
+		Fields: []ParamField{
+			{Name: "schemaName", Type: ParamTypeString, Required: true},
+			{Name: "tableName", Type: ParamTypeString, Required: true},
+		},
+	},
+	BatchCreateTable: {
+		Kind: BatchCreateTable,
+		Name: "batchCreateTable",
+		Fields: []ParamField{
+			{Name: "schemaName", Type: ParamTypeString, Required: true},
+			{Name: "tableNames", Type: ParamTypeStrings, Required: true},
+		},
+	},
+	BatchDropTable: {
+		Kind: BatchDropTable,
+		Name: "batchDropTable",
+		Fields: []ParamField{
+			{Name: "schemaName", Type: ParamTypeString, Required: true},
+			{Name: "tableNames", Type: ParamTypeStrings, Required: true},
+		},
+	},
+}
+
+// ListParamSchemas returns the param schemas of all the procedure kinds which have one, ordered by kind.
+func ListParamSchemas() []ParamSchema {
+	schemas := make([]ParamSchema, 0, len(paramSchemas))
+	for _, schema := range paramSchemas {
+		schemas = append(schemas, schema)
+	}
+	sort.Slice(schemas, func(i, j int) bool {
+		return schemas[i].Kind < schemas[j].Kind
+	})
+	return schemas
+}
+
+// ValidateParams validates the params against the schema of the kind, and the snapshot is used to check the shards and
+// nodes referred by the params. A *ParamValidationError is returned if any field is invalid.
+func ValidateParams(kind Kind, snapshot metadata.Snapshot, params Params) error {
+	schema, ok := paramSchemas[kind]
+	if !ok {
+		return nil
+	}
+
+	fieldErrors := make([]FieldError, 0)
+	for _, field := range schema.Fields {
+		value, exists := params[field.Name]
+		if !exists || isZeroParam(value) {
+			if field.Required {
+				fieldErrors = append(fieldErrors, FieldError{Field: field.Name, Reason: "required"})
+			}
+			continue
+		}
+		if reason := validateParam(field.Type, snapshot, value); len(reason) > 0 {
+			fieldErrors = append(fieldErrors, FieldError{Field: field.Name, Reason: reason})
+		}
+	}
+
+	if len(fieldErrors) > 0 {
+		return &ParamValidationError{
+			Kind:        kind,
+			FieldErrors: fieldErrors,
+		}
+	}
+	return nil
+}
+
+func isZeroParam(value any) bool {
+	switch v := value.(type) {
+	case nil:
+		return true
+	case string:
+		return len(v) == 0
+	case []string:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
+
+// validateParam returns the reason why the value is invalid, and empty reason means the value is valid.
+func validateParam(paramType ParamType, snapshot metadata.Snapshot, value any) string {
+	switch paramType {
+	case ParamTypeString:
+		if _, ok := value.(string); !ok {
+			return fmt.Sprintf("expect string, but got %T", value)
+		}
+	case ParamTypeStrings:
+		values, ok := value.([]string)
+		if !ok {
+			return fmt.Sprintf("expect string list, but got %T", value)
+		}
+		distinct := make(map[string]struct{}, len(values))
+		for _, v := range values {
+			if len(v) == 0 {
+				return "contains empty string"
+			}
+			if _, exists := distinct[v]; exists {
+				return fmt.Sprintf("contains duplicated value %s", v)
+			}
+			distinct[v] = struct{}{}
+		}
+	case ParamTypeShardID, ParamTypeNewShardID:
+		shardID, ok := value.(storage.ShardID)
+		if !ok {
+			return fmt.Sprintf("expect shard id, but got %T", value)
+		}
+		_, exists := snapshot.Topology.ShardViewsMapping[shardID]
+		if paramType == ParamTypeShardID && !exists {
+			return fmt.Sprintf("shard %d not found", shardID)
+		}
+		if paramType == ParamTypeNewShardID && exists {
+			return fmt.Sprintf("shard %d already exists", shardID)
+		}
+	case ParamTypeNodeName:
+		nodeName, ok := value.(string)
+		if !ok {
+			return fmt.Sprintf("expect string, but got %T", value)
+		}
+		for _, node := range snapshot.RegisteredNodes {
+			if node.Node.Name == nodeName {
+				return ""
+			}
+		}
+		return fmt.Sprintf("node %s not registered", nodeName)
+	}
+	return ""
+}
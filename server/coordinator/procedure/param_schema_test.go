@@ -0,0 +1,81 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package procedure_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateParams(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	c := test.InitStableCluster(ctx, t)
+	snapshot := c.GetMetadata().GetClusterSnapshot()
+	shardNode := snapshot.Topology.ClusterView.ShardNodes[0]
+
+	err := procedure.ValidateParams(procedure.TransferLeader, snapshot, procedure.Params{
+		"shardID":           shardNode.ID,
+		"oldLeaderNodeName": "",
+		"newLeaderNodeName": shardNode.NodeName,
+	})
+	re.NoError(err)
+
+	err = procedure.ValidateParams(procedure.TransferLeader, snapshot, procedure.Params{
+		"shardID":           storage.ShardID(1000),
+		"newLeaderNodeName": "unknown-node",
+	})
+	var validationErr *procedure.ParamValidationError
+	re.ErrorAs(err, &validationErr)
+	re.Equal(procedure.TransferLeader, validationErr.Kind)
+	re.Equal([]procedure.FieldError{
+		{Field: "shardID", Reason: "shard 1000 not found"},
+		{Field: "newLeaderNodeName", Reason: "node unknown-node not registered"},
+	}, validationErr.FieldErrors)
+
+	err = procedure.ValidateParams(procedure.BatchDropTable, snapshot, procedure.Params{
+		"schemaName": test.TestSchemaName,
+		"tableNames": []string{test.TestTableName0, test.TestTableName0},
+	})
+	re.ErrorAs(err, &validationErr)
+	re.Equal("tableNames", validationErr.FieldErrors[0].Field)
+
+	err = procedure.ValidateParams(procedure.CreateTable, snapshot, procedure.Params{
+		"schemaName": test.TestSchemaName,
+		"tableName":  1,
+	})
+	re.ErrorAs(err, &validationErr)
+	re.Equal([]procedure.FieldError{{Field: "tableName", Reason: "expect string, but got int"}}, validationErr.FieldErrors)
+
+	// The kind without schema is not validated.
+	re.NoError(procedure.ValidateParams(procedure.Scatter, snapshot, procedure.Params{}))
+}
+
+func TestListParamSchemas(t *testing.T) {
+	re := require.New(t)
+
+	schemas := procedure.ListParamSchemas()
+	re.NotEmpty(schemas)
+	for i := 1; i < len(schemas); i++ {
+		re.Less(schemas[i-1].Kind, schemas[i].Kind)
+	}
+}
@@ -18,7 +18,10 @@ package procedure
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/CeresDB/horaemeta/server/coordinator/lock"
 	"github.com/CeresDB/horaemeta/server/storage"
 )
 
@@ -49,8 +52,44 @@ const (
 	DropTable
 	CreatePartitionTable
 	DropPartitionTable
+	BatchDropTable
+	RepairShards
+	Failover
+	RebalancePartitionTable
+	BatchCreateTable
+	MigrateTopology
+	Reshard
 )
 
+var kindNames = map[Kind]string{
+	Create:                  "create",
+	Delete:                  "delete",
+	TransferLeader:          "transferLeader",
+	Migrate:                 "migrate",
+	Split:                   "split",
+	Merge:                   "merge",
+	Scatter:                 "scatter",
+	CreateTable:             "createTable",
+	DropTable:               "dropTable",
+	CreatePartitionTable:    "createPartitionTable",
+	DropPartitionTable:      "dropPartitionTable",
+	BatchDropTable:          "batchDropTable",
+	RepairShards:            "repairShards",
+	Failover:                "failover",
+	RebalancePartitionTable: "rebalancePartitionTable",
+	BatchCreateTable:        "batchCreateTable",
+	MigrateTopology:         "migrateTopology",
+	Reshard:                 "reshard",
+}
+
+// String returns the name of the kind, e.g. "transferLeader", which is used to label the metrics of the procedures.
+func (k Kind) String() string {
+	if name, ok := kindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", uint(k))
+}
+
 type Priority uint32
 
 // Lower value means higher priority.
@@ -84,11 +123,40 @@ type Procedure interface {
 	Priority() Priority
 }
 
+// ResourceLocker is implemented by the procedures operating on the schemas or tables, e.g. the DDL procedures. The
+// procedures on the same table are run one by one, while the ones on the unrelated tables are run concurrently.
+type ResourceLocker interface {
+	// LockedResources returns the resources held by the procedure during its running, whose names are normalized the way
+	// the cluster resolves them, so the procedures naming the same table in different cases conflict.
+	LockedResources() []lock.Resource
+}
+
+// StepInfo describes the step of the state machine driving the procedure.
+type StepInfo struct {
+	FSMState         string
+	LastTransitionAt time.Time
+}
+
+// StepReporter is implemented by the procedures driven by a state machine, so the progress of them can be inspected.
+type StepReporter interface {
+	StepInfo() StepInfo
+}
+
 // Info is used to provide immutable description procedure information.
 type Info struct {
 	ID    uint64
 	Kind  Kind
 	State State
+	// FSMState and LastTransitionAt are left empty if the procedure is not driven by a state machine.
+	FSMState         string
+	LastTransitionAt time.Time
+	// RetryCount is the number of times the procedure is put back into the waiting queue before it runs.
+	RetryCount int
+	// RequestID is the id of the request submitting the procedure, which is empty if it is submitted by the server itself.
+	RequestID string
+	ShardIDs  []storage.ShardID
+	// Tables are the names of the tables operated by the procedure, in the format of `schema.table`.
+	Tables []string
 }
 
 type RelatedVersionInfo struct {
@@ -0,0 +1,159 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package procedure
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/looplab/fsm"
+	"github.com/pkg/errors"
+)
+
+// Replayer describes how the persisted procedure of a kind is re-driven without any side effect, which tells why it can't
+// resume, e.g. after the leader changes.
+type Replayer struct {
+	Events fsm.Events
+	// InitialState is the state the procedure resumes from if its raw data doesn't carry the state.
+	InitialState string
+	FinishState  string
+	// Decode returns the state of the state machine carried by the raw data, which is empty if it isn't persisted, and
+	// the params validated against the snapshot by the param schema of the kind.
+	Decode func(rawData []byte) (string, Params, error)
+}
+
+// ReplayStep is a transition of the state machine taken by the replay.
+type ReplayStep struct {
+	Event string `json:"event"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+type ReplayResult struct {
+	ID       uint64 `json:"id"`
+	Kind     string `json:"kind"`
+	State    State  `json:"state"`
+	FSMState string `json:"fsmState"`
+	// Steps are the transitions from the persisted state to the finish state.
+	Steps     []ReplayStep `json:"steps"`
+	Resumable bool         `json:"resumable"`
+	// Reasons tell why the procedure can't resume, and it is empty if the procedure is resumable.
+	Reasons []string `json:"reasons"`
+}
+
+// Replay re-drives the state machine of the persisted procedure from its persisted state to the finish state in the dry-run
+// mode, where no callback is invoked, and checks its params against the current snapshot.
+func Replay(replayer Replayer, snapshot metadata.Snapshot, meta *Meta) (ReplayResult, error) {
+	fsmState, params, err := replayer.Decode(meta.RawData)
+	if err != nil {
+		var emptyResult ReplayResult
+		return emptyResult, ErrDecodeRawData.WithCausef("decode raw data, procedureID:%d, err:%v", meta.ID, err)
+	}
+	if len(fsmState) == 0 {
+		fsmState = replayer.InitialState
+	}
+
+	result := ReplayResult{
+		ID:        meta.ID,
+		Kind:      meta.Kind.String(),
+		State:     meta.State,
+		FSMState:  fsmState,
+		Steps:     []ReplayStep{},
+		Resumable: false,
+		Reasons:   []string{},
+	}
+	switch meta.State {
+	case StateFinished, StateFailed, StateCancelled:
+		result.Reasons = append(result.Reasons, fmt.Sprintf("procedure is %s already", meta.State))
+	}
+
+	var validationErr *ParamValidationError
+	if err := ValidateParams(meta.Kind, snapshot, params); errors.As(err, &validationErr) {
+		for _, fieldError := range validationErr.FieldErrors {
+			result.Reasons = append(result.Reasons, fmt.Sprintf("param %s is invalid in the current snapshot: %s", fieldError.Field, fieldError.Reason))
+		}
+	}
+
+	steps, ok := findReplaySteps(replayer.Events, fsmState, replayer.FinishState)
+	if !ok {
+		result.Reasons = append(result.Reasons, fmt.Sprintf("no transition leads state %s to %s", fsmState, replayer.FinishState))
+	}
+	f := fsm.NewFSM(fsmState, replayer.Events, fsm.Callbacks{})
+	for _, step := range steps {
+		if err := f.Event(step.Event); err != nil {
+			result.Reasons = append(result.Reasons, fmt.Sprintf("replay event %s from state %s: %v", step.Event, step.From, err))
+			break
+		}
+		result.Steps = append(result.Steps, step)
+	}
+
+	result.Resumable = len(result.Reasons) == 0
+	return result, nil
+}
+
+// findReplaySteps finds the shortest transitions from the state to the finish state.
+func findReplaySteps(events fsm.Events, from, finish string) ([]ReplayStep, bool) {
+	prev := map[string]ReplayStep{from: {Event: "", From: "", To: from}}
+	queue := []string{from}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		for _, event := range events {
+			if _, visited := prev[event.Dst]; visited || !containsState(event.Src, state) {
+				continue
+			}
+			prev[event.Dst] = ReplayStep{Event: event.Name, From: state, To: event.Dst}
+			queue = append(queue, event.Dst)
+		}
+	}
+	if _, ok := prev[finish]; !ok {
+		return nil, false
+	}
+
+	steps := make([]ReplayStep, 0)
+	for state := finish; state != from; state = prev[state].From {
+		steps = append([]ReplayStep{prev[state]}, steps...)
+	}
+	return steps, true
+}
+
+func containsState(states []string, state string) bool {
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// FindMeta finds the persisted procedure by the id, and the second output parameter bool: returns true if the procedure
+// is marked deleted.
+func FindMeta(ctx context.Context, storage Storage, id uint64) (*Meta, bool, error) {
+	for _, deleted := range []bool{false, true} {
+		metas, err := storage.ListAll(ctx, deleted, metaListBatchSize)
+		if err != nil {
+			return nil, false, err
+		}
+		for _, meta := range metas {
+			if meta.ID == id {
+				return meta, deleted, nil
+			}
+		}
+	}
+	return nil, false, ErrProcedureNotFound.WithCausef("procedureID:%d", id)
+}
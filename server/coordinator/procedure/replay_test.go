@@ -0,0 +1,106 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package procedure
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/looplab/fsm"
+	"github.com/stretchr/testify/require"
+)
+
+var testReplayEvents = fsm.Events{
+	{Name: "prepare", Src: []string{"begin"}, Dst: "prepared"},
+	{Name: "commit", Src: []string{"prepared"}, Dst: "finish"},
+	{Name: "abort", Src: []string{"begin", "prepared"}, Dst: "aborted"},
+}
+
+func newTestReplayer() Replayer {
+	return Replayer{
+		Events:       testReplayEvents,
+		InitialState: "begin",
+		FinishState:  "finish",
+		Decode: func(rawData []byte) (string, Params, error) {
+			return string(rawData), Params{}, nil
+		},
+	}
+}
+
+func TestFindReplaySteps(t *testing.T) {
+	re := require.New(t)
+
+	steps, ok := findReplaySteps(testReplayEvents, "begin", "finish")
+	re.True(ok)
+	re.Equal([]ReplayStep{
+		{Event: "prepare", From: "begin", To: "prepared"},
+		{Event: "commit", From: "prepared", To: "finish"},
+	}, steps)
+
+	steps, ok = findReplaySteps(testReplayEvents, "finish", "finish")
+	re.True(ok)
+	re.Empty(steps)
+
+	_, ok = findReplaySteps(testReplayEvents, "aborted", "finish")
+	re.False(ok)
+}
+
+func TestReplay(t *testing.T) {
+	re := require.New(t)
+	snapshot := metadata.Snapshot{Topology: metadata.Topology{}, RegisteredNodes: nil}
+	replayer := newTestReplayer()
+
+	// The procedure resumes from the initial state if its state isn't persisted.
+	result, err := Replay(replayer, snapshot, &Meta{ID: 1, Kind: Scatter, State: StateRunning, RawData: []byte(""), UpdatedAt: 0})
+	re.NoError(err)
+	re.True(result.Resumable)
+	re.Equal("begin", result.FSMState)
+	re.Len(result.Steps, 2)
+	re.Empty(result.Reasons)
+
+	result, err = Replay(replayer, snapshot, &Meta{ID: 2, Kind: Scatter, State: StateFailed, RawData: []byte("aborted"), UpdatedAt: 0})
+	re.NoError(err)
+	re.False(result.Resumable)
+	re.Empty(result.Steps)
+	re.Len(result.Reasons, 2)
+}
+
+func TestFindMeta(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	storage := NewTestStorage(t)
+
+	meta := Meta{ID: 1, Kind: TransferLeader, State: StateRunning, RawData: []byte("{}"), UpdatedAt: 0}
+	re.NoError(storage.CreateOrUpdate(ctx, meta))
+	re.NoError(storage.CreateOrUpdate(ctx, Meta{ID: 2, Kind: Split, State: StateFinished, RawData: []byte("{}"), UpdatedAt: 0}))
+	re.NoError(storage.MarkDeleted(ctx, Split, 2))
+
+	found, deleted, err := FindMeta(ctx, storage, 1)
+	re.NoError(err)
+	re.False(deleted)
+	re.Equal(meta.Kind, found.Kind)
+
+	found, deleted, err = FindMeta(ctx, storage, 2)
+	re.NoError(err)
+	re.True(deleted)
+	re.Equal(Split, found.Kind)
+
+	_, _, err = FindMeta(ctx, storage, 3)
+	re.True(coderr.Is(err, ErrProcedureNotFound.Code()))
+}
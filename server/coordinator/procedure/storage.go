@@ -30,6 +30,8 @@ type Meta struct {
 	Kind    Kind
 	State   State
 	RawData []byte
+	// UpdatedAt is the unix milliseconds the meta is written or marked deleted, which is stamped by the storage.
+	UpdatedAt int64
 }
 
 type Storage interface {
@@ -37,4 +39,22 @@ type Storage interface {
 	List(ctx context.Context, procedureType Kind, batchSize int) ([]*Meta, error)
 	Delete(ctx context.Context, procedureType Kind, id uint64) error
 	MarkDeleted(ctx context.Context, procedureType Kind, id uint64) error
+	// ListAll lists the procedures of all the kinds, and the ones marked deleted are listed instead if deleted is true.
+	ListAll(ctx context.Context, deleted bool, batchSize int) ([]*Meta, error)
+	// BatchDelete deletes the procedures in a txn, and the ones marked deleted are deleted instead if deleted is true.
+	BatchDelete(ctx context.Context, deleted bool, metas []*Meta) error
+}
+
+// IsLive returns true if the procedure is persisted, and it is neither finished, failed nor cancelled.
+func IsLive(ctx context.Context, s Storage, procedureID uint64) (bool, error) {
+	metas, err := s.ListAll(ctx, false, metaListBatchSize)
+	if err != nil {
+		return false, err
+	}
+	for _, meta := range metas {
+		if meta.ID == procedureID {
+			return meta.State == StateInit || meta.State == StateRunning, nil
+		}
+	}
+	return false, nil
 }
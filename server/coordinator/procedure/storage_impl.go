@@ -24,6 +24,7 @@ import (
 	"path"
 	"strconv"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/pkg/log"
 	"github.com/CeresDB/horaemeta/server/etcdutil"
 	"github.com/pkg/errors"
@@ -42,13 +43,16 @@ type EtcdStorageImpl struct {
 	client    *clientv3.Client
 	clusterID uint32
 	rootPath  string
+	// clock stamps the update time of the metas, which is the cluster clock so that the gc sees the same time.
+	clock clock.Clock
 }
 
-func NewEtcdStorageImpl(client *clientv3.Client, rootPath string, clusterID uint32) Storage {
+func NewEtcdStorageImpl(client *clientv3.Client, rootPath string, clusterID uint32, clk clock.Clock) Storage {
 	return &EtcdStorageImpl{
 		client:    client,
 		clusterID: clusterID,
 		rootPath:  rootPath,
+		clock:     clk,
 	}
 }
 
@@ -56,6 +60,7 @@ func NewEtcdStorageImpl(client *clientv3.Client, rootPath string, clusterID uint
 // /{rootPath}/v1/procedure/{procedureType}/{procedureID} ->  {procedureState} + {data}
 // ttl is only valid when greater than 0, if it is less than or equal to 0, it will be ignored.
 func (e EtcdStorageImpl) CreateOrUpdate(ctx context.Context, meta Meta) error {
+	meta.UpdatedAt = e.clock.Now().UnixMilli()
 	s, err := encode(&meta)
 	if err != nil {
 		return errors.WithMessage(err, "encode meta failed")
@@ -75,6 +80,7 @@ func (e EtcdStorageImpl) CreateOrUpdate(ctx context.Context, meta Meta) error {
 // CreateOrUpdateWithTTL
 // ttl is only valid when greater than 0, if it is less than or equal to 0, it will be ignored.
 func (e EtcdStorageImpl) CreateOrUpdateWithTTL(ctx context.Context, meta Meta, ttlSec int64) error {
+	meta.UpdatedAt = e.clock.Now().UnixMilli()
 	s, err := encode(&meta)
 	if err != nil {
 		return errors.WithMessage(err, "encode meta failed")
@@ -120,14 +126,24 @@ func (e EtcdStorageImpl) Delete(ctx context.Context, procedureType Kind, id uint
 // /{rootPath}/v1/historyProcedure/{clusterID}/{procedureID}
 func (e EtcdStorageImpl) MarkDeleted(ctx context.Context, procedureType Kind, id uint64) error {
 	keyPath := e.generaNormalKeyPath(procedureType, id)
-	meta, err := etcdutil.Get(ctx, e.client, keyPath)
+	value, err := etcdutil.Get(ctx, e.client, keyPath)
 	if err != nil {
 		return errors.WithMessage(err, "get meta failed")
 	}
+	// The retention of the deleted procedure starts from the deletion.
+	meta, err := decodeMeta(value)
+	if err != nil {
+		return errors.WithMessagef(err, "decode meta failed, key:%s", keyPath)
+	}
+	meta.UpdatedAt = e.clock.Now().UnixMilli()
+	s, err := encode(meta)
+	if err != nil {
+		return errors.WithMessage(err, "encode meta failed")
+	}
 
 	deletedKeyPath := e.generaDeletedKeyPath(procedureType, id)
 	opDelete := clientv3.OpDelete(keyPath)
-	opPut := clientv3.OpPut(deletedKeyPath, meta)
+	opPut := clientv3.OpPut(deletedKeyPath, s)
 
 	_, err = e.client.Txn(ctx).Then(opDelete, opPut).Commit()
 
@@ -156,6 +172,44 @@ func (e EtcdStorageImpl) List(ctx context.Context, procedureType Kind, batchSize
 	return metas, nil
 }
 
+func (e EtcdStorageImpl) ListAll(ctx context.Context, deleted bool, batchSize int) ([]*Meta, error) {
+	var metas []*Meta
+	do := func(key string, value []byte) error {
+		meta, err := decodeMeta(string(value))
+		if err != nil {
+			return errors.WithMessagef(err, "decode meta failed, key:%s, value:%v", key, value)
+		}
+
+		metas = append(metas, meta)
+		return nil
+	}
+
+	procedurePath := PathProcedure
+	if deleted {
+		procedurePath = PathDeletedProcedure
+	}
+	startKey := path.Join(e.rootPath, Version, procedurePath, fmtID(uint64(e.clusterID))) + "/"
+	endKey := clientv3.GetPrefixRangeEnd(startKey)
+
+	err := etcdutil.Scan(ctx, e.client, startKey, endKey, batchSize, do)
+	if err != nil {
+		return nil, errors.WithMessage(err, "scan procedure failed")
+	}
+	return metas, nil
+}
+
+func (e EtcdStorageImpl) BatchDelete(ctx context.Context, deleted bool, metas []*Meta) error {
+	ops := make([]clientv3.Op, 0, len(metas))
+	for _, meta := range metas {
+		ops = append(ops, clientv3.OpDelete(e.generateKeyPath(meta.ID, meta.Kind, deleted)))
+	}
+
+	if _, err := e.client.Txn(ctx).Then(ops...).Commit(); err != nil {
+		return errors.WithMessagef(err, "etcd delete procedures failed, count:%d", len(metas))
+	}
+	return nil
+}
+
 func (e EtcdStorageImpl) generaNormalKeyPath(procedureType Kind, procedureID uint64) string {
 	return e.generateKeyPath(procedureID, procedureType, false)
 }
@@ -21,6 +21,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/server/etcdutil"
 	"github.com/stretchr/testify/require"
 )
@@ -38,10 +39,11 @@ func testWrite(t *testing.T, storage Storage) {
 	defer cancel()
 
 	testMeta1 := Meta{
-		ID:      uint64(1),
-		Kind:    TransferLeader,
-		State:   StateInit,
-		RawData: []byte("test"),
+		ID:        uint64(1),
+		Kind:      TransferLeader,
+		State:     StateInit,
+		RawData:   []byte("test"),
+		UpdatedAt: 0,
 	}
 
 	// Test create new procedure
@@ -49,10 +51,11 @@ func testWrite(t *testing.T, storage Storage) {
 	re.NoError(err)
 
 	testMeta2 := Meta{
-		ID:      uint64(2),
-		Kind:    TransferLeader,
-		State:   StateInit,
-		RawData: []byte("test"),
+		ID:        uint64(2),
+		Kind:      TransferLeader,
+		State:     StateInit,
+		RawData:   []byte("test"),
+		UpdatedAt: 0,
 	}
 	err = storage.CreateOrUpdate(ctx, testMeta2)
 	re.NoError(err)
@@ -81,10 +84,11 @@ func testDelete(t *testing.T, storage Storage) {
 	defer cancel()
 
 	testMeta1 := &Meta{
-		ID:      uint64(1),
-		Kind:    TransferLeader,
-		State:   StateInit,
-		RawData: []byte("test"),
+		ID:        uint64(1),
+		Kind:      TransferLeader,
+		State:     StateInit,
+		RawData:   []byte("test"),
+		UpdatedAt: 0,
 	}
 	err := storage.MarkDeleted(ctx, TransferLeader, testMeta1.ID)
 	re.NoError(err)
@@ -94,10 +98,11 @@ func testDelete(t *testing.T, storage Storage) {
 	re.Equal(1, len(metas))
 
 	testMeta2 := Meta{
-		ID:      uint64(2),
-		Kind:    TransferLeader,
-		State:   StateInit,
-		RawData: []byte("test"),
+		ID:        uint64(2),
+		Kind:      TransferLeader,
+		State:     StateInit,
+		RawData:   []byte("test"),
+		UpdatedAt: 0,
 	}
 	err = storage.Delete(ctx, TransferLeader, testMeta2.ID)
 	re.NoError(err)
@@ -108,8 +113,13 @@ func testDelete(t *testing.T, storage Storage) {
 }
 
 func NewTestStorage(t *testing.T) Storage {
+	return NewTestStorageWithClock(t, clock.NewRealClock())
+}
+
+// NewTestStorageWithClock is the same as NewTestStorage, except that the update time of the metas is stamped by the clock.
+func NewTestStorageWithClock(t *testing.T, clk clock.Clock) Storage {
 	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
-	storage := NewEtcdStorageImpl(client, TestRootPath, TestClusterID)
+	storage := NewEtcdStorageImpl(client, TestRootPath, TestClusterID, clk)
 	return storage
 }
 
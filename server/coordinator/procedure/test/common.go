@@ -23,15 +23,18 @@ import (
 	"math"
 	"math/big"
 	"testing"
-	"time"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/server/cluster"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator"
 	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
 	"github.com/CeresDB/horaemeta/server/coordinator/scheduler"
 	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/nodepicker"
 	"github.com/CeresDB/horaemeta/server/etcdutil"
+	"github.com/CeresDB/horaemeta/server/event"
+	"github.com/CeresDB/horaemeta/server/id"
 	"github.com/CeresDB/horaemeta/server/storage"
 	"github.com/stretchr/testify/require"
 	"go.uber.org/zap"
@@ -99,6 +102,14 @@ func (m MockStorage) MarkDeleted(_ context.Context, _ procedure.Kind, _ uint64)
 	return nil
 }
 
+func (m MockStorage) ListAll(_ context.Context, _ bool, _ int) ([]*procedure.Meta, error) {
+	return nil, nil
+}
+
+func (m MockStorage) BatchDelete(_ context.Context, _ bool, _ []*procedure.Meta) error {
+	return nil
+}
+
 func NewTestStorage(_ *testing.T) procedure.Storage {
 	return MockStorage{}
 }
@@ -113,8 +124,25 @@ func (m MockIDAllocator) Collect(_ context.Context, _ uint64) error {
 	return nil
 }
 
+// NewMockDependencies returns the factory dependencies built on the mock dispatch and id allocator.
+func NewMockDependencies(t *testing.T) coordinator.Dependencies {
+	return coordinator.Dependencies{
+		IDAllocator:   MockIDAllocator{},
+		Dispatch:      MockDispatch{},
+		Storage:       NewTestStorage(t),
+		ShardPicker:   coordinator.NewLeastTableShardPicker(),
+		AdmissionHook: coordinator.NewNoopAdmissionHook(),
+		Clock:         clock.NewRealClock(),
+	}
+}
+
 // InitEmptyCluster will return a cluster that has created shards and nodes, but it does not have any shard node mapping.
 func InitEmptyCluster(ctx context.Context, t *testing.T) *cluster.Cluster {
+	return InitEmptyClusterWithClock(ctx, t, clock.NewRealClock())
+}
+
+// InitEmptyClusterWithClock is the same as InitEmptyCluster, except that the cluster and its nodes are driven by the given clock.
+func InitEmptyClusterWithClock(ctx context.Context, t *testing.T, clk clock.Clock) *cluster.Cluster {
 	re := require.New(t)
 
 	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
@@ -124,40 +152,52 @@ func InitEmptyCluster(ctx context.Context, t *testing.T) *cluster.Cluster {
 
 	logger := zap.NewNop()
 
-	clusterMetadata := metadata.NewClusterMetadata(logger, storage.Cluster{
+	clusterInfo := storage.Cluster{
 		ID:                          0,
 		Name:                        ClusterName,
 		MinNodeCount:                DefaultNodeCount,
 		ShardTotal:                  DefaultShardTotal,
 		TopologyType:                DefaultTopologyType,
 		ProcedureExecutingBatchSize: DefaultProcedureExecutingBatchSize,
+		CaseInsensitiveName:         false,
+		DefaultSchemaName:           "",
+		HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0},
+		Quota:                       storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0},
+		CordonedNodes:               nil,
+		SchemaCreationPolicy:        storage.SchemaCreationPolicyAuto,
+		Protected:                   false,
 		CreatedAt:                   0,
 		ModifiedAt:                  0,
-	}, clusterStorage, client, TestRootPath, DefaultIDAllocatorStep)
+	}
+	// The cluster is persisted as well, so that its settings can be updated.
+	err := clusterStorage.CreateCluster(ctx, storage.CreateClusterRequest{Cluster: clusterInfo})
+	re.NoError(err)
+	clusterMetadata := metadata.NewClusterMetadata(logger, clusterInfo, clusterStorage, client, TestRootPath, id.NewResourceAllocatorOptions(DefaultIDAllocatorStep), clk)
 
-	err := clusterMetadata.Init(ctx)
+	err = clusterMetadata.Init(ctx)
 	re.NoError(err)
 
 	err = clusterMetadata.Load(ctx)
 	re.NoError(err)
 
-	c, err := cluster.NewCluster(logger, clusterMetadata, client, TestRootPath)
+	c, err := cluster.NewCluster(logger, clusterMetadata, client, TestRootPath, id.AllocatorOptions{Step: DefaultIDAllocatorStep, Preallocate: false}, procedure.GCOptions{Interval: 0, Retention: 0, MaxOpsPerTxn: 0}, coordinator.NewDefaultDependencyResolver(), event.BusOptions{Capacity: 0, Sinks: nil})
 	re.NoError(err)
 
 	_, _, err = c.GetMetadata().GetOrCreateSchema(ctx, TestSchemaName)
 	re.NoError(err)
 
-	lastTouchTime := time.Now().UnixMilli()
+	lastTouchTime := clock.UnixMilli(clk)
 	for i := 0; i < DefaultNodeCount; i++ {
 		node := storage.Node{
 			Name:          fmt.Sprintf("node%d", i),
 			NodeStats:     storage.NewEmptyNodeStats(),
-			LastTouchTime: uint64(lastTouchTime),
+			LastTouchTime: lastTouchTime,
 			State:         storage.NodeStateUnknown,
 		}
 		err = c.GetMetadata().RegisterNode(ctx, metadata.RegisteredNode{
 			Node:       node,
 			ShardInfos: nil,
+			Heartbeat:  metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
 		})
 		re.NoError(err)
 	}
@@ -182,9 +222,16 @@ func InitEmptyClusterWithConfig(ctx context.Context, t *testing.T, shardNumber i
 		ShardTotal:                  uint32(shardNumber),
 		TopologyType:                DefaultTopologyType,
 		ProcedureExecutingBatchSize: DefaultProcedureExecutingBatchSize,
+		CaseInsensitiveName:         false,
+		DefaultSchemaName:           "",
+		HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0},
+		Quota:                       storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0},
+		CordonedNodes:               nil,
+		SchemaCreationPolicy:        storage.SchemaCreationPolicyAuto,
+		Protected:                   false,
 		CreatedAt:                   0,
 		ModifiedAt:                  0,
-	}, clusterStorage, client, TestRootPath, DefaultIDAllocatorStep)
+	}, clusterStorage, client, TestRootPath, id.NewResourceAllocatorOptions(DefaultIDAllocatorStep), clock.NewRealClock())
 
 	err := clusterMetadata.Init(ctx)
 	re.NoError(err)
@@ -192,23 +239,24 @@ func InitEmptyClusterWithConfig(ctx context.Context, t *testing.T, shardNumber i
 	err = clusterMetadata.Load(ctx)
 	re.NoError(err)
 
-	c, err := cluster.NewCluster(logger, clusterMetadata, client, TestRootPath)
+	c, err := cluster.NewCluster(logger, clusterMetadata, client, TestRootPath, id.AllocatorOptions{Step: DefaultIDAllocatorStep, Preallocate: false}, procedure.GCOptions{Interval: 0, Retention: 0, MaxOpsPerTxn: 0}, coordinator.NewDefaultDependencyResolver(), event.BusOptions{Capacity: 0, Sinks: nil})
 	re.NoError(err)
 
 	_, _, err = c.GetMetadata().GetOrCreateSchema(ctx, TestSchemaName)
 	re.NoError(err)
 
-	lastTouchTime := time.Now().UnixMilli()
+	lastTouchTime := clock.UnixMilli(clusterMetadata.Clock())
 	for i := 0; i < nodeNumber; i++ {
 		node := storage.Node{
 			Name:          fmt.Sprintf("node%d", i),
 			NodeStats:     storage.NewEmptyNodeStats(),
-			LastTouchTime: uint64(lastTouchTime),
+			LastTouchTime: lastTouchTime,
 			State:         storage.NodeStateUnknown,
 		}
 		err = c.GetMetadata().RegisterNode(ctx, metadata.RegisteredNode{
 			Node:       node,
 			ShardInfos: []metadata.ShardInfo{},
+			Heartbeat:  metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
 		})
 		re.NoError(err)
 	}
@@ -229,8 +277,13 @@ func InitPrepareCluster(ctx context.Context, t *testing.T) *cluster.Cluster {
 
 // InitStableCluster will return a cluster that has created shards and nodes, and shards have been assigned to existing nodes.
 func InitStableCluster(ctx context.Context, t *testing.T) *cluster.Cluster {
+	return InitStableClusterWithClock(ctx, t, clock.NewRealClock())
+}
+
+// InitStableClusterWithClock is the same as InitStableCluster, except that the cluster and its nodes are driven by the given clock.
+func InitStableClusterWithClock(ctx context.Context, t *testing.T, clk clock.Clock) *cluster.Cluster {
 	re := require.New(t)
-	c := InitEmptyCluster(ctx, t)
+	c := InitEmptyClusterWithClock(ctx, t, clk)
 	snapshot := c.GetMetadata().GetClusterSnapshot()
 	shardNodes := make([]storage.ShardNode, 0, DefaultShardTotal)
 	for _, shardView := range snapshot.Topology.ShardViewsMapping {
@@ -254,7 +307,7 @@ func InitStableClusterWithConfig(ctx context.Context, t *testing.T, nodeNumber i
 	c := InitEmptyClusterWithConfig(ctx, t, shardNumber, nodeNumber)
 	snapshot := c.GetMetadata().GetClusterSnapshot()
 	shardNodes := make([]storage.ShardNode, 0, DefaultShardTotal)
-	nodePicker := nodepicker.NewConsistentUniformHashNodePicker(zap.NewNop())
+	nodePicker := nodepicker.NewConsistentUniformHashNodePicker(zap.NewNop(), c.GetMetadata().Clock())
 	var unAssignedShardIDs []storage.ShardID
 	for i := 0; i < shardNumber; i++ {
 		unAssignedShardIDs = append(unAssignedShardIDs, storage.ShardID(i))
@@ -262,6 +315,7 @@ func InitStableClusterWithConfig(ctx context.Context, t *testing.T, nodeNumber i
 	shardNodeMapping, err := nodePicker.PickNode(ctx, nodepicker.Config{
 		NumTotalShards:    uint32(shardNumber),
 		ShardAffinityRule: map[storage.ShardID]scheduler.ShardAffinity{},
+		CordonedNodes:     snapshot.CordonedNodes,
 	}, unAssignedShardIDs, snapshot.RegisteredNodes)
 	re.NoError(err)
 
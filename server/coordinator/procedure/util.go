@@ -17,6 +17,12 @@
 package procedure
 
 import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/pkg/failpoint"
 	"github.com/CeresDB/horaemeta/pkg/log"
 	"github.com/looplab/fsm"
 	"github.com/pkg/errors"
@@ -46,3 +52,43 @@ func GetRequestFromEvent[T any](event *fsm.Event) (T, error) {
 		return *new(T), ErrGetRequest.WithCausef("event arg type must be same as return type")
 	}
 }
+
+const fsmLastTransitionAtKey = "lastTransitionAt"
+
+// NewFSM creates the state machine driving a procedure, which records the time of its last transition by the clock so
+// that FSMStepInfo can tell how long the procedure has stayed in the current state.
+//
+// Every callback is preceded by the failpoint named "procedure/<kind>/<callback>", e.g.
+// "procedure/createTable/EventPrepare", and the event is cancelled with the error injected by the failpoint.
+func NewFSM(kind Kind, clk clock.Clock, initial string, events fsm.Events, callbacks fsm.Callbacks) *fsm.FSM {
+	trackedCallbacks := make(fsm.Callbacks, len(callbacks)+1)
+	for name, callback := range callbacks {
+		failpointName, callback := fmt.Sprintf("procedure/%s/%s", kind, name), callback
+		trackedCallbacks[name] = func(event *fsm.Event) {
+			if err := failpoint.Inject(context.Background(), failpointName); err != nil {
+				CancelEventWithLog(event, err, "inject failpoint", zap.String("failpoint", failpointName))
+				return
+			}
+			callback(event)
+		}
+	}
+	trackedCallbacks["enter_state"] = func(event *fsm.Event) {
+		event.FSM.SetMetadata(fsmLastTransitionAtKey, clk.Now())
+	}
+
+	f := fsm.NewFSM(initial, events, trackedCallbacks)
+	f.SetMetadata(fsmLastTransitionAtKey, clk.Now())
+	return f
+}
+
+// FSMStepInfo returns the step the state machine created by NewFSM is at.
+func FSMStepInfo(f *fsm.FSM) StepInfo {
+	var lastTransitionAt time.Time
+	if value, ok := f.Metadata(fsmLastTransitionAtKey); ok {
+		lastTransitionAt, _ = value.(time.Time)
+	}
+	return StepInfo{
+		FSMState:         f.Current(),
+		LastTransitionAt: lastTransitionAt,
+	}
+}
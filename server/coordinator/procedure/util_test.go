@@ -0,0 +1,83 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package procedure
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/pkg/failpoint"
+	"github.com/looplab/fsm"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFSMStepInfo(t *testing.T) {
+	re := require.New(t)
+
+	clk := clock.NewMock(time.Now())
+	called := false
+	f := NewFSM(CreateTable, clk, "begin", fsm.Events{
+		{Name: "finish", Src: []string{"begin"}, Dst: "end"},
+	}, fsm.Callbacks{
+		"finish": func(_ *fsm.Event) {
+			called = true
+		},
+	})
+
+	step := FSMStepInfo(f)
+	re.Equal("begin", step.FSMState)
+	re.Equal(clk.Now(), step.LastTransitionAt)
+
+	clk.Advance(time.Second)
+	re.NoError(f.Event("finish"))
+	re.True(called)
+	nextStep := FSMStepInfo(f)
+	re.Equal("end", nextStep.FSMState)
+	re.Equal(clk.Now(), nextStep.LastTransitionAt)
+}
+
+func TestFSMFailpoint(t *testing.T) {
+	re := require.New(t)
+	defer failpoint.SetEnabled(false)
+
+	called := false
+	f := NewFSM(CreateTable, clock.NewRealClock(), "begin", fsm.Events{
+		{Name: "finish", Src: []string{"begin"}, Dst: "end"},
+	}, fsm.Callbacks{
+		"finish": func(_ *fsm.Event) {
+			called = true
+		},
+	})
+
+	// The callback is skipped and the event fails with the injected error.
+	failpoint.SetEnabled(true)
+	re.NoError(failpoint.Enable("procedure/createTable/finish", failpoint.Action{Kind: failpoint.ActionError, Message: "", DelayMs: 0, Count: 1}))
+	err := f.Event("finish")
+	re.True(coderr.Is(err, failpoint.ErrInjected.Code()))
+	re.False(called)
+}
+
+func TestKindString(t *testing.T) {
+	re := require.New(t)
+
+	re.Equal("transferLeader", TransferLeader.String())
+	re.Equal("dropPartitionTable", DropPartitionTable.String())
+	re.Equal("reshard", Reshard.String())
+	re.Equal("unknown(100)", Kind(100).String())
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package coordinator
+
+import (
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl/createpartitiontable"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl/droppartitiontable"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/rebalancepartitiontable"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/reshard"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/split"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/transferleader"
+)
+
+// replayers are keyed by the kinds of the procedures persisted in the storage.
+var replayers = map[procedure.Kind]func() procedure.Replayer{
+	procedure.TransferLeader:          transferleader.NewReplayer,
+	procedure.Split:                   split.NewReplayer,
+	procedure.RebalancePartitionTable: rebalancepartitiontable.NewReplayer,
+	procedure.Reshard:                 reshard.NewReplayer,
+	procedure.CreatePartitionTable:    createpartitiontable.NewReplayer,
+	procedure.DropPartitionTable:      droppartitiontable.NewReplayer,
+}
+
+// GetReplayer returns the replayer of the procedure kind, and the second output parameter bool: returns false if the
+// procedure of the kind is never persisted.
+func GetReplayer(kind procedure.Kind) (procedure.Replayer, bool) {
+	newReplayer, ok := replayers[kind]
+	if !ok {
+		var emptyReplayer procedure.Replayer
+		return emptyReplayer, false
+	}
+	return newReplayer(), true
+}
@@ -0,0 +1,166 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package failover
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/failover"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler"
+	"github.com/CeresDB/horaemeta/server/storage"
+)
+
+// schedulerImpl used to promote a follower of the shard whose leader node is dead.
+type schedulerImpl struct {
+	factory                     *coordinator.Factory
+	clusterMetadata             *metadata.ClusterMetadata
+	procedureExecutingBatchSize uint32
+	clock                       clock.Clock
+}
+
+// SchedulerName is the name of the failover scheduler.
+const SchedulerName = "failover_scheduler"
+
+func NewShardScheduler(factory *coordinator.Factory, clusterMetadata *metadata.ClusterMetadata, procedureExecutingBatchSize uint32, clk clock.Clock) scheduler.Scheduler {
+	return schedulerImpl{
+		factory:                     factory,
+		clusterMetadata:             clusterMetadata,
+		procedureExecutingBatchSize: procedureExecutingBatchSize,
+		clock:                       clk,
+	}
+}
+
+func (s schedulerImpl) Name() string {
+	return SchedulerName
+}
+
+func (s schedulerImpl) UpdateEnableSchedule(_ context.Context, _ bool) {
+	// FailoverShardScheduler do not need enableSchedule, because the shard is unavailable until the follower is promoted.
+}
+
+func (s schedulerImpl) AddShardAffinityRule(_ context.Context, _ scheduler.ShardAffinityRule) error {
+	return nil
+}
+
+func (s schedulerImpl) RemoveShardAffinityRule(_ context.Context, _ storage.ShardID) error {
+	return nil
+}
+
+func (s schedulerImpl) ReplaceShardAffinityRule(_ context.Context, _ scheduler.ShardAffinityRule) error {
+	return nil
+}
+
+func (s schedulerImpl) ListShardAffinityRule(_ context.Context) (scheduler.ShardAffinityRule, error) {
+	return scheduler.ShardAffinityRule{Affinities: []scheduler.ShardAffinity{}}, nil
+}
+
+func (s schedulerImpl) Schedule(ctx context.Context, clusterSnapshot metadata.Snapshot) (scheduler.ScheduleResult, error) {
+	var scheduleRes scheduler.ScheduleResult
+	// FailoverShardScheduler can only be scheduled when the cluster is stable.
+	if !clusterSnapshot.Topology.IsStable() {
+		return scheduleRes, nil
+	}
+
+	var procedures []procedure.Procedure
+	var reasons strings.Builder
+
+	now := s.clock.Now()
+	for _, shardID := range findLeaderlessShards(clusterSnapshot, now) {
+		oldLeaderNodeName, newLeaderNodeName, err := failover.PickNewLeader(clusterSnapshot, shardID, now)
+		if err != nil {
+			// The shard without any online follower is left to the other schedulers.
+			continue
+		}
+		p, err := s.factory.CreateFailoverProcedure(ctx, coordinator.FailoverRequest{
+			ClusterMetadata:   s.clusterMetadata,
+			Snapshot:          clusterSnapshot,
+			ShardID:           shardID,
+			OldLeaderNodeName: oldLeaderNodeName,
+			NewLeaderNodeName: newLeaderNodeName,
+		})
+		if err != nil {
+			return scheduleRes, err
+		}
+
+		procedures = append(procedures, p)
+		reasons.WriteString(fmt.Sprintf("the leader node of the shard is dead, promote the follower, shardID:%d, oldLeader:%s, newLeader:%s. ", shardID, oldLeaderNodeName, newLeaderNodeName))
+		if len(procedures) >= int(s.procedureExecutingBatchSize) {
+			break
+		}
+	}
+
+	if len(procedures) == 0 {
+		return scheduleRes, nil
+	}
+
+	batchProcedure, err := s.factory.CreateBatchTransferLeaderProcedure(ctx, coordinator.BatchRequest{
+		Batch:     procedures,
+		BatchType: procedure.Failover,
+	})
+	if err != nil {
+		return scheduleRes, err
+	}
+
+	scheduleRes = scheduler.ScheduleResult{
+		Procedure: batchProcedure,
+		Reason:    reasons.String(),
+	}
+	return scheduleRes, nil
+}
+
+// findLeaderlessShards finds the shards whose leader node is offline or dropped from the topology, and whether they have
+// any online follower to take over is left to the picking.
+func findLeaderlessShards(clusterSnapshot metadata.Snapshot, now time.Time) []storage.ShardID {
+	onlineNodes := make(map[string]struct{}, len(clusterSnapshot.RegisteredNodes))
+	for _, node := range clusterSnapshot.RegisteredNodes {
+		if !node.IsExpired(now) {
+			onlineNodes[node.Node.Name] = struct{}{}
+		}
+	}
+
+	leaderless := make(map[storage.ShardID]bool)
+	for _, shardNode := range clusterSnapshot.Topology.ClusterView.ShardNodes {
+		switch shardNode.ShardRole {
+		case storage.ShardRoleLeader:
+			_, online := onlineNodes[shardNode.NodeName]
+			leaderless[shardNode.ID] = !online
+		case storage.ShardRoleFollower:
+			if _, exists := leaderless[shardNode.ID]; !exists {
+				leaderless[shardNode.ID] = true
+			}
+		}
+	}
+
+	shardIDs := make([]storage.ShardID, 0)
+	for shardID, isLeaderless := range leaderless {
+		if isLeaderless {
+			shardIDs = append(shardIDs, shardID)
+		}
+	}
+	sort.Slice(shardIDs, func(i, j int) bool {
+		return shardIDs[i] < shardIDs[j]
+	})
+	return shardIDs
+}
@@ -0,0 +1,93 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package failover_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/failover"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestFailoverShardScheduler(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	procedureFactory := coordinator.NewFactory(zap.NewNop(), test.NewMockDependencies(t))
+	clk := clock.NewMock(time.Now())
+
+	emptyCluster := test.InitEmptyCluster(ctx, t)
+	s := failover.NewShardScheduler(procedureFactory, emptyCluster.GetMetadata(), 1, clk)
+	// FailoverShardScheduler should not schedule when cluster is not stable.
+	result, err := s.Schedule(ctx, emptyCluster.GetMetadata().GetClusterSnapshot())
+	re.NoError(err)
+	re.Nil(result.Procedure)
+
+	stableCluster := test.InitStableCluster(ctx, t)
+	s = failover.NewShardScheduler(procedureFactory, stableCluster.GetMetadata(), 1, clk)
+	snapshot := stableCluster.GetMetadata().GetClusterSnapshot()
+	leader := snapshot.Topology.ClusterView.ShardNodes[0]
+	followerNodeName := "follower"
+	snapshot.Topology.ClusterView.ShardNodes = append(snapshot.Topology.ClusterView.ShardNodes, storage.ShardNode{
+		ID:        leader.ID,
+		ShardRole: storage.ShardRoleFollower,
+		NodeName:  followerNodeName,
+	})
+	snapshot.RegisteredNodes = []metadata.RegisteredNode{
+		newRegisteredNode(leader.NodeName, clk.Now()),
+		newRegisteredNode(followerNodeName, clk.Now()),
+	}
+
+	// No failover is needed when the leader node is online.
+	result, err = s.Schedule(ctx, snapshot)
+	re.NoError(err)
+	re.Nil(result.Procedure)
+
+	// The follower is promoted after the leader node is expired.
+	snapshot.RegisteredNodes[0] = newRegisteredNode(leader.NodeName, clk.Now().Add(-time.Hour))
+	result, err = s.Schedule(ctx, snapshot)
+	re.NoError(err)
+	re.NotNil(result.Procedure)
+	re.Contains(result.Procedure.RelatedVersionInfo().ShardWithVersion, leader.ID)
+
+	// Nothing can be done if the follower node is expired too.
+	snapshot.RegisteredNodes[1] = newRegisteredNode(followerNodeName, clk.Now().Add(-time.Hour))
+	result, err = s.Schedule(ctx, snapshot)
+	re.NoError(err)
+	re.Nil(result.Procedure)
+}
+
+func newRegisteredNode(name string, lastTouchTime time.Time) metadata.RegisteredNode {
+	return metadata.RegisteredNode{
+		Node: storage.Node{
+			Name:          name,
+			NodeStats:     storage.NewEmptyNodeStats(),
+			LastTouchTime: uint64(lastTouchTime.UnixMilli()),
+			State:         storage.NodeStateOnline,
+		},
+		ShardInfos: []metadata.ShardInfo{},
+		Heartbeat:  metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
+	}
+}
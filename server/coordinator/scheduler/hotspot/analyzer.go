@@ -0,0 +1,238 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hotspot
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultMaxShardWriteRowsPerSec = 200000
+	defaultMaxShardTableCount      = 2000
+)
+
+// Config is the thresholds to flag the hot shards, and zero disables the threshold.
+type Config struct {
+	MaxShardWriteRowsPerSec float64 `json:"maxShardWriteRowsPerSec"`
+	MaxShardTableCount      uint32  `json:"maxShardTableCount"`
+	// AutoSplit opts in the scheduler splitting the hot shards by the suggestions automatically.
+	AutoSplit bool `json:"autoSplit"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		MaxShardWriteRowsPerSec: defaultMaxShardWriteRowsPerSec,
+		MaxShardTableCount:      defaultMaxShardTableCount,
+		AutoSplit:               false,
+	}
+}
+
+func (c Config) Validate() error {
+	if c.MaxShardWriteRowsPerSec < 0 {
+		return errors.Errorf("max shard write rows per sec should not be negative, value:%f", c.MaxShardWriteRowsPerSec)
+	}
+	return nil
+}
+
+// Load is the load of a shard or a table, and the write rate is the one averaged over time.
+type Load struct {
+	WriteRowsPerSec float64
+	Bytes           uint64
+}
+
+// LoadProvider provides the latest loads reported by the nodes, and the shards and the tables not reported have no load.
+type LoadProvider interface {
+	ShardLoads() map[storage.ShardID]Load
+	TableLoads() map[storage.TableID]Load
+}
+
+// StaticLoads is the LoadProvider of the fixed loads.
+type StaticLoads struct {
+	Shards map[storage.ShardID]Load
+	Tables map[storage.TableID]Load
+}
+
+func (l StaticLoads) ShardLoads() map[storage.ShardID]Load {
+	return l.Shards
+}
+
+func (l StaticLoads) TableLoads() map[storage.TableID]Load {
+	return l.Tables
+}
+
+type HotShard struct {
+	ShardID         storage.ShardID `json:"shardID"`
+	NodeName        string          `json:"nodeName"`
+	WriteRowsPerSec float64         `json:"writeRowsPerSec"`
+	TableCount      int             `json:"tableCount"`
+	// Reasons tell the thresholds the shard exceeds.
+	Reasons []string `json:"reasons"`
+}
+
+// SplitSuggestion tells the tables to be moved out of the hot shard to a new shard, which are in the same schema as
+// required by the split.
+type SplitSuggestion struct {
+	ShardID              storage.ShardID `json:"shardID"`
+	SchemaName           string          `json:"schemaName"`
+	TableNames           []string        `json:"tableNames"`
+	MovedWriteRowsPerSec float64         `json:"movedWriteRowsPerSec"`
+}
+
+type Report struct {
+	// HotShards are ordered by the write rate from the hottest.
+	HotShards []HotShard `json:"hotShards"`
+	// Suggestions are in the order of the hot shards, and the shards whose tables can't be split have no suggestion.
+	Suggestions []SplitSuggestion `json:"suggestions"`
+}
+
+// Analyze flags the shards of the snapshot exceeding the thresholds, and suggests the tables to be split from them.
+func Analyze(config Config, snapshot metadata.Snapshot, shardTables map[storage.ShardID]metadata.ShardTables, loads LoadProvider) Report {
+	shardLoads := loads.ShardLoads()
+	tableLoads := loads.TableLoads()
+	leaders := make(map[storage.ShardID]string, len(snapshot.Topology.ClusterView.ShardNodes))
+	for _, shardNode := range snapshot.Topology.ClusterView.ShardNodes {
+		if shardNode.ShardRole == storage.ShardRoleLeader {
+			leaders[shardNode.ID] = shardNode.NodeName
+		}
+	}
+
+	report := Report{HotShards: []HotShard{}, Suggestions: []SplitSuggestion{}}
+	for shardID, shardView := range snapshot.Topology.ShardViewsMapping {
+		hotShard := HotShard{
+			ShardID:         shardID,
+			NodeName:        leaders[shardID],
+			WriteRowsPerSec: shardLoads[shardID].WriteRowsPerSec,
+			TableCount:      len(shardView.TableIDs),
+			Reasons:         []string{},
+		}
+		if config.MaxShardWriteRowsPerSec > 0 && hotShard.WriteRowsPerSec > config.MaxShardWriteRowsPerSec {
+			hotShard.Reasons = append(hotShard.Reasons, fmt.Sprintf("write rate exceeds the threshold, writeRowsPerSec:%.2f, threshold:%.2f", hotShard.WriteRowsPerSec, config.MaxShardWriteRowsPerSec))
+		}
+		if config.MaxShardTableCount > 0 && hotShard.TableCount > int(config.MaxShardTableCount) {
+			hotShard.Reasons = append(hotShard.Reasons, fmt.Sprintf("table count exceeds the threshold, tableCount:%d, threshold:%d", hotShard.TableCount, config.MaxShardTableCount))
+		}
+		if len(hotShard.Reasons) > 0 {
+			report.HotShards = append(report.HotShards, hotShard)
+		}
+	}
+	sort.Slice(report.HotShards, func(i, j int) bool {
+		if report.HotShards[i].WriteRowsPerSec != report.HotShards[j].WriteRowsPerSec {
+			return report.HotShards[i].WriteRowsPerSec > report.HotShards[j].WriteRowsPerSec
+		}
+		return report.HotShards[i].ShardID < report.HotShards[j].ShardID
+	})
+
+	for _, hotShard := range report.HotShards {
+		writeHot := config.MaxShardWriteRowsPerSec > 0 && hotShard.WriteRowsPerSec > config.MaxShardWriteRowsPerSec
+		if suggestion, ok := suggestSplit(hotShard.ShardID, shardTables[hotShard.ShardID].Tables, tableLoads, writeHot); ok {
+			report.Suggestions = append(report.Suggestions, suggestion)
+		}
+	}
+	return report
+}
+
+// suggestSplit picks the tables of a schema to be moved, and at least one table is left in the shard:
+//   - the shard written too fast moves the hottest table alone if it takes at least half of the writes, otherwise the hot
+//     tables whose write rates sum up to at most half of the shard;
+//   - the shard with too many tables, or without the loads of its tables, moves half of its tables, the coldest first as
+//     they are the cheapest to move.
+func suggestSplit(shardID storage.ShardID, tables []metadata.TableInfo, tableLoads map[storage.TableID]Load, writeHot bool) (SplitSuggestion, bool) {
+	if len(tables) < 2 {
+		return SplitSuggestion{}, false
+	}
+
+	// The tables of the schema with the most load, or the most tables, are the candidates.
+	schemaTables := make(map[string][]metadata.TableInfo)
+	schemaLoads := make(map[string]float64)
+	totalLoad := float64(0)
+	for _, table := range tables {
+		schemaTables[table.SchemaName] = append(schemaTables[table.SchemaName], table)
+		schemaLoads[table.SchemaName] += tableLoads[table.ID].WriteRowsPerSec
+		totalLoad += tableLoads[table.ID].WriteRowsPerSec
+	}
+	schemaName := ""
+	for name := range schemaTables {
+		if len(schemaName) == 0 || betterSchema(name, schemaName, schemaTables, schemaLoads, writeHot) {
+			schemaName = name
+		}
+	}
+	candidates := schemaTables[schemaName]
+	sort.Slice(candidates, func(i, j int) bool {
+		loadI, loadJ := tableLoads[candidates[i].ID].WriteRowsPerSec, tableLoads[candidates[j].ID].WriteRowsPerSec
+		if loadI != loadJ {
+			// The hottest first for the write rate, and the coldest first for the table count.
+			return (loadI > loadJ) == writeHot
+		}
+		return candidates[i].ID < candidates[j].ID
+	})
+
+	moved := make([]metadata.TableInfo, 0, len(candidates))
+	movedLoad := float64(0)
+	if writeHot && tableLoads[candidates[0].ID].WriteRowsPerSec >= totalLoad/2 {
+		moved = append(moved, candidates[0])
+		movedLoad = tableLoads[candidates[0].ID].WriteRowsPerSec
+	} else if writeHot {
+		for _, table := range candidates {
+			if load := tableLoads[table.ID].WriteRowsPerSec; load > 0 && movedLoad+load <= totalLoad/2 {
+				moved = append(moved, table)
+				movedLoad += load
+			}
+		}
+	}
+	// The tables are split by the count if the loads of the tables are not reported.
+	if movedLoad == 0 {
+		moved, movedLoad = moved[:0], 0
+		for _, table := range candidates[:min(len(candidates), len(tables)/2)] {
+			moved = append(moved, table)
+			movedLoad += tableLoads[table.ID].WriteRowsPerSec
+		}
+	}
+	if len(moved) == len(tables) {
+		last := moved[len(moved)-1]
+		moved = moved[:len(moved)-1]
+		movedLoad -= tableLoads[last.ID].WriteRowsPerSec
+	}
+	if len(moved) == 0 {
+		return SplitSuggestion{}, false
+	}
+
+	tableNames := make([]string, 0, len(moved))
+	for _, table := range moved {
+		tableNames = append(tableNames, table.Name)
+	}
+	return SplitSuggestion{
+		ShardID:              shardID,
+		SchemaName:           schemaName,
+		TableNames:           tableNames,
+		MovedWriteRowsPerSec: movedLoad,
+	}, true
+}
+
+func betterSchema(a, b string, schemaTables map[string][]metadata.TableInfo, schemaLoads map[string]float64, writeHot bool) bool {
+	if writeHot && schemaLoads[a] != schemaLoads[b] {
+		return schemaLoads[a] > schemaLoads[b]
+	}
+	if len(schemaTables[a]) != len(schemaTables[b]) {
+		return len(schemaTables[a]) > len(schemaTables[b])
+	}
+	return a < b
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hotspot
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+// splitCooldown is the time a split shard is not split again, which leaves the time for the loads of the shard to be
+// reported and averaged again after the split.
+const splitCooldown = 30 * time.Minute
+
+// ConfigUpdater is implemented by the schedulers depending on the hot spot config of the cluster.
+type ConfigUpdater interface {
+	UpdateHotSpotConfig(ctx context.Context, config Config)
+}
+
+// schedulerImpl splits the hottest shard by its suggestion once at a time if the auto split is opted in.
+type schedulerImpl struct {
+	logger          *zap.Logger
+	factory         *coordinator.Factory
+	clusterMetadata *metadata.ClusterMetadata
+	loads           LoadProvider
+	clock           clock.Clock
+
+	// The lock is used to protect following fields.
+	lock             sync.Mutex
+	config           Config
+	enableSchedule   bool
+	rebalanceAllowed bool
+	// splitAt records when the shards are split last time.
+	splitAt map[storage.ShardID]time.Time
+}
+
+func NewShardScheduler(logger *zap.Logger, factory *coordinator.Factory, clusterMetadata *metadata.ClusterMetadata, loads LoadProvider, clk clock.Clock) scheduler.Scheduler {
+	return &schedulerImpl{
+		logger:           logger,
+		factory:          factory,
+		clusterMetadata:  clusterMetadata,
+		loads:            loads,
+		clock:            clk,
+		lock:             sync.Mutex{},
+		config:           DefaultConfig(),
+		enableSchedule:   false,
+		rebalanceAllowed: true,
+		splitAt:          map[storage.ShardID]time.Time{},
+	}
+}
+
+func (s *schedulerImpl) Name() string {
+	return "hot_spot_split_scheduler"
+}
+
+func (s *schedulerImpl) UpdateEnableSchedule(_ context.Context, enable bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.enableSchedule = enable
+}
+
+// UpdateRebalanceAllowed implements scheduler.Rebalancer, for the split moves the tables between the nodes for balance.
+func (s *schedulerImpl) UpdateRebalanceAllowed(_ context.Context, allowed bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.rebalanceAllowed = allowed
+}
+
+func (s *schedulerImpl) UpdateHotSpotConfig(_ context.Context, config Config) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.config = config
+}
+
+func (s *schedulerImpl) AddShardAffinityRule(_ context.Context, _ scheduler.ShardAffinityRule) error {
+	return nil
+}
+
+func (s *schedulerImpl) RemoveShardAffinityRule(_ context.Context, _ storage.ShardID) error {
+	return nil
+}
+
+func (s *schedulerImpl) ReplaceShardAffinityRule(_ context.Context, _ scheduler.ShardAffinityRule) error {
+	return nil
+}
+
+func (s *schedulerImpl) ListShardAffinityRule(_ context.Context) (scheduler.ShardAffinityRule, error) {
+	return scheduler.ShardAffinityRule{Affinities: []scheduler.ShardAffinity{}}, nil
+}
+
+func (s *schedulerImpl) Schedule(ctx context.Context, clusterSnapshot metadata.Snapshot) (scheduler.ScheduleResult, error) {
+	var scheduleRes scheduler.ScheduleResult
+	s.lock.Lock()
+	config, enableSchedule, rebalanceAllowed := s.config, s.enableSchedule, s.rebalanceAllowed
+	s.lock.Unlock()
+	// The split can only be scheduled when the cluster is stable.
+	if !config.AutoSplit || !clusterSnapshot.Topology.IsStable() {
+		return scheduleRes, nil
+	}
+
+	shardIDs := make([]storage.ShardID, 0, len(clusterSnapshot.Topology.ShardViewsMapping))
+	for shardID := range clusterSnapshot.Topology.ShardViewsMapping {
+		shardIDs = append(shardIDs, shardID)
+	}
+	report := Analyze(config, clusterSnapshot, s.clusterMetadata.GetShardTables(shardIDs), s.loads)
+	now := s.clock.Now()
+	var suggestion *SplitSuggestion
+	for i := range report.Suggestions {
+		if splitAt, ok := s.getSplitAt(report.Suggestions[i].ShardID); !ok || now.Sub(splitAt) >= splitCooldown {
+			suggestion = &report.Suggestions[i]
+			break
+		}
+	}
+	if suggestion == nil {
+		return scheduleRes, nil
+	}
+	if enableSchedule || !rebalanceAllowed {
+		scheduleRes.Reason = fmt.Sprintf("hot shard split is deferred, shardID:%d, enableSchedule:%t, rebalanceAllowed:%t", suggestion.ShardID, enableSchedule, rebalanceAllowed)
+		return scheduleRes, nil
+	}
+
+	targetNodeName, ok := pickTargetNode(clusterSnapshot, suggestion.ShardID, now)
+	if !ok {
+		return scheduleRes, nil
+	}
+	newShardID, err := s.clusterMetadata.AllocShardID(ctx)
+	if err != nil {
+		return scheduleRes, errors.WithMessage(err, "alloc shard id")
+	}
+	p, err := s.factory.CreateSplitProcedure(ctx, coordinator.SplitRequest{
+		ClusterMetadata: s.clusterMetadata,
+		SchemaName:      suggestion.SchemaName,
+		TableNames:      suggestion.TableNames,
+		Snapshot:        clusterSnapshot,
+		ShardID:         suggestion.ShardID,
+		NewShardID:      storage.ShardID(newShardID),
+		TargetNodeName:  targetNodeName,
+	})
+	if err != nil {
+		// The new shard id is given back if the split is not created.
+		if err := s.clusterMetadata.CollectShardID(ctx, newShardID); err != nil {
+			s.logger.Warn("collect shard id failed", zap.Uint32("shardID", newShardID), zap.Error(err))
+		}
+		return scheduleRes, err
+	}
+
+	s.lock.Lock()
+	s.splitAt[suggestion.ShardID] = now
+	s.lock.Unlock()
+	scheduleRes.Procedure = p
+	scheduleRes.Reason = fmt.Sprintf("hot shard is split, shardID:%d, newShardID:%d, targetNode:%s, schemaName:%s, tableCount:%d, movedWriteRowsPerSec:%.2f", suggestion.ShardID, newShardID, targetNodeName, suggestion.SchemaName, len(suggestion.TableNames), suggestion.MovedWriteRowsPerSec)
+	return scheduleRes, nil
+}
+
+func (s *schedulerImpl) getSplitAt(shardID storage.ShardID) (time.Time, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	splitAt, ok := s.splitAt[shardID]
+	return splitAt, ok
+}
+
+// pickTargetNode picks the online node serving the fewest shards for the new shard, and the node of the hot shard is
+// only picked if it is the only online node. The cordoned nodes are never picked.
+func pickTargetNode(clusterSnapshot metadata.Snapshot, shardID storage.ShardID, now time.Time) (string, bool) {
+	shardCounts := make(map[string]int, len(clusterSnapshot.RegisteredNodes))
+	for _, node := range clusterSnapshot.RegisteredNodes {
+		if !node.IsExpired(now) && !clusterSnapshot.IsCordoned(node.Node.Name) {
+			shardCounts[node.Node.Name] = 0
+		}
+	}
+	hotNodeName := ""
+	for _, shardNode := range clusterSnapshot.Topology.ClusterView.ShardNodes {
+		if _, ok := shardCounts[shardNode.NodeName]; ok {
+			shardCounts[shardNode.NodeName]++
+		}
+		if shardNode.ID == shardID && shardNode.ShardRole == storage.ShardRoleLeader {
+			hotNodeName = shardNode.NodeName
+		}
+	}
+	if len(shardCounts) > 1 {
+		delete(shardCounts, hotNodeName)
+	}
+
+	nodeNames := make([]string, 0, len(shardCounts))
+	for nodeName := range shardCounts {
+		nodeNames = append(nodeNames, nodeName)
+	}
+	if len(nodeNames) == 0 {
+		return "", false
+	}
+	sort.Slice(nodeNames, func(i, j int) bool {
+		if shardCounts[nodeNames[i]] != shardCounts[nodeNames[j]] {
+			return shardCounts[nodeNames[i]] < shardCounts[nodeNames[j]]
+		}
+		return nodeNames[i] < nodeNames[j]
+	})
+	return nodeNames[0], true
+}
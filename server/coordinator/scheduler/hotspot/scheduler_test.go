@@ -0,0 +1,151 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package hotspot_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/hotspot"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func createTables(ctx context.Context, re *require.Assertions, m *metadata.ClusterMetadata, shardID storage.ShardID, tableNames ...string) []storage.TableID {
+	tableIDs := make([]storage.TableID, 0, len(tableNames))
+	for _, tableName := range tableNames {
+		version := m.GetClusterSnapshot().Topology.ShardViewsMapping[shardID].Version
+		result, err := m.CreateTable(ctx, metadata.CreateTableRequest{
+			ShardID:       shardID,
+			LatestVersion: version,
+			SchemaName:    test.TestSchemaName,
+			TableName:     tableName,
+			PartitionInfo: storage.PartitionInfo{Info: nil},
+		})
+		re.NoError(err)
+		tableIDs = append(tableIDs, result.Table.ID)
+	}
+	return tableIDs
+}
+
+func TestAnalyze(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	m := test.InitStableCluster(ctx, t).GetMetadata()
+	tableIDs := createTables(ctx, re, m, 0, "t0", "t1", "t2")
+	snapshot := m.GetClusterSnapshot()
+	shardTables := m.GetShardTables(m.GetShards())
+	loads := hotspot.StaticLoads{
+		Shards: map[storage.ShardID]hotspot.Load{0: {WriteRowsPerSec: 1000, Bytes: 0}, 1: {WriteRowsPerSec: 100, Bytes: 0}},
+		Tables: map[storage.TableID]hotspot.Load{
+			tableIDs[0]: {WriteRowsPerSec: 800, Bytes: 0},
+			tableIDs[1]: {WriteRowsPerSec: 150, Bytes: 0},
+			tableIDs[2]: {WriteRowsPerSec: 50, Bytes: 0},
+		},
+	}
+
+	// The hottest table alone is moved if it takes most of the writes.
+	config := hotspot.Config{MaxShardWriteRowsPerSec: 500, MaxShardTableCount: 0, AutoSplit: false}
+	report := hotspot.Analyze(config, snapshot, shardTables, loads)
+	re.Len(report.HotShards, 1)
+	re.Equal(storage.ShardID(0), report.HotShards[0].ShardID)
+	re.Equal(3, report.HotShards[0].TableCount)
+	re.Len(report.HotShards[0].Reasons, 1)
+	re.Equal([]hotspot.SplitSuggestion{{ShardID: 0, SchemaName: test.TestSchemaName, TableNames: []string{"t0"}, MovedWriteRowsPerSec: 800}}, report.Suggestions)
+
+	// The hot tables are moved up to half of the writes.
+	loads.Tables[tableIDs[0]] = hotspot.Load{WriteRowsPerSec: 400, Bytes: 0}
+	loads.Tables[tableIDs[1]] = hotspot.Load{WriteRowsPerSec: 350, Bytes: 0}
+	loads.Tables[tableIDs[2]] = hotspot.Load{WriteRowsPerSec: 250, Bytes: 0}
+	report = hotspot.Analyze(config, snapshot, shardTables, loads)
+	re.Equal([]string{"t0"}, report.Suggestions[0].TableNames)
+	loads.Tables[tableIDs[0]] = hotspot.Load{WriteRowsPerSec: 300, Bytes: 0}
+	loads.Tables[tableIDs[2]] = hotspot.Load{WriteRowsPerSec: 200, Bytes: 0}
+	report = hotspot.Analyze(config, snapshot, shardTables, loads)
+	re.Equal([]string{"t1"}, report.Suggestions[0].TableNames)
+
+	// The coldest half of the tables are moved for the table count.
+	config = hotspot.Config{MaxShardWriteRowsPerSec: 0, MaxShardTableCount: 2, AutoSplit: false}
+	report = hotspot.Analyze(config, snapshot, shardTables, loads)
+	re.Len(report.HotShards, 1)
+	re.Equal([]hotspot.SplitSuggestion{{ShardID: 0, SchemaName: test.TestSchemaName, TableNames: []string{"t2"}, MovedWriteRowsPerSec: 200}}, report.Suggestions)
+
+	// The shard with a single table can't be split.
+	config = hotspot.Config{MaxShardWriteRowsPerSec: 50, MaxShardTableCount: 0, AutoSplit: false}
+	report = hotspot.Analyze(config, snapshot, shardTables, loads)
+	re.Len(report.HotShards, 2)
+	re.Len(report.Suggestions, 1)
+}
+
+func TestSplitScheduler(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	procedureFactory := coordinator.NewFactory(zap.NewNop(), test.NewMockDependencies(t))
+	clk := clock.NewMock(time.Now())
+	c := test.InitStableClusterWithClock(ctx, t, clk)
+	m := c.GetMetadata()
+	tableIDs := createTables(ctx, re, m, 0, "t0", "t1")
+	loads := hotspot.StaticLoads{
+		Shards: map[storage.ShardID]hotspot.Load{0: {WriteRowsPerSec: 1000, Bytes: 0}},
+		Tables: map[storage.TableID]hotspot.Load{tableIDs[0]: {WriteRowsPerSec: 900, Bytes: 0}, tableIDs[1]: {WriteRowsPerSec: 100, Bytes: 0}},
+	}
+	s := hotspot.NewShardScheduler(zap.NewNop(), procedureFactory, m, loads, clk)
+	config := hotspot.Config{MaxShardWriteRowsPerSec: 500, MaxShardTableCount: 0, AutoSplit: false}
+	s.(hotspot.ConfigUpdater).UpdateHotSpotConfig(ctx, config)
+
+	// Nothing is split unless the auto split is opted in.
+	result, err := s.Schedule(ctx, m.GetClusterSnapshot())
+	re.NoError(err)
+	re.Nil(result.Procedure)
+
+	// The split is deferred outside the rebalance windows.
+	config.AutoSplit = true
+	s.(hotspot.ConfigUpdater).UpdateHotSpotConfig(ctx, config)
+	s.(scheduler.Rebalancer).UpdateRebalanceAllowed(ctx, false)
+	result, err = s.Schedule(ctx, m.GetClusterSnapshot())
+	re.NoError(err)
+	re.Nil(result.Procedure)
+	re.Contains(result.Reason, "deferred")
+
+	s.(scheduler.Rebalancer).UpdateRebalanceAllowed(ctx, true)
+	result, err = s.Schedule(ctx, m.GetClusterSnapshot())
+	re.NoError(err)
+	re.NotNil(result.Procedure)
+	re.Contains(result.Procedure.RelatedVersionInfo().ShardWithVersion, storage.ShardID(0))
+
+	// The split shard is not split again until the cooldown passes.
+	result, err = s.Schedule(ctx, m.GetClusterSnapshot())
+	re.NoError(err)
+	re.Nil(result.Procedure)
+	clk.Advance(time.Hour)
+	snapshot := m.GetClusterSnapshot()
+	for i := range snapshot.RegisteredNodes {
+		snapshot.RegisteredNodes[i].Node.LastTouchTime = uint64(clk.Now().UnixMilli())
+	}
+	result, err = s.Schedule(ctx, snapshot)
+	re.NoError(err)
+	re.NotNil(result.Procedure)
+}
@@ -0,0 +1,117 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+)
+
+const (
+	decisionLogCapacity = 256
+
+	// The reasons recorded for the ticks or the schedulers which submit nothing.
+	reasonClusterPreparing = "cluster is updated to stable instead of being scheduled"
+	reasonNodeShortfall    = "cluster is not allowed to be stable for lack of online nodes"
+	reasonNoProcedure      = "no procedure is generated"
+	reasonDisabled         = "scheduler is disabled"
+	reasonMaxProcedures    = "procedure is dropped for reaching the max procedures per tick"
+)
+
+// SchedulerDecision is the outcome of a scheduler in one round of scheduling.
+type SchedulerDecision struct {
+	Scheduler string `json:"scheduler"`
+	// Reason is why the procedure is generated, or why nothing is submitted.
+	Reason string `json:"reason"`
+	// ProcedureID is zero if no procedure is submitted.
+	ProcedureID uint64 `json:"procedureID"`
+	Submitted   bool   `json:"submitted"`
+	Error       string `json:"error"`
+}
+
+// SchedulerTick records one round of scheduling.
+type SchedulerTick struct {
+	Timestamp          int64  `json:"timestamp"`
+	ClusterViewVersion uint64 `json:"clusterViewVersion"`
+	ClusterState       string `json:"clusterState"`
+	// Reason is why the schedulers are not invoked in this round, and empty if they are.
+	Reason    string              `json:"reason"`
+	Decisions []SchedulerDecision `json:"decisions"`
+}
+
+// decisionLog keeps the latest ticks of the scheduler manager in a ring buffer.
+type decisionLog struct {
+	lock  sync.RWMutex
+	ticks []SchedulerTick
+	// next is the position the next tick is written to.
+	next int
+	full bool
+}
+
+func newDecisionLog(capacity int) *decisionLog {
+	return &decisionLog{
+		lock:  sync.RWMutex{},
+		ticks: make([]SchedulerTick, capacity),
+		next:  0,
+		full:  false,
+	}
+}
+
+func (l *decisionLog) record(tick SchedulerTick) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	l.ticks[l.next] = tick
+	l.next = (l.next + 1) % len(l.ticks)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// list returns at most limit ticks, the latest first.
+func (l *decisionLog) list(limit int) []SchedulerTick {
+	l.lock.RLock()
+	defer l.lock.RUnlock()
+
+	size := l.next
+	if l.full {
+		size = len(l.ticks)
+	}
+	if limit <= 0 || limit > size {
+		limit = size
+	}
+
+	ticks := make([]SchedulerTick, 0, limit)
+	for i := 1; i <= limit; i++ {
+		ticks = append(ticks, l.ticks[(l.next-i+len(l.ticks))%len(l.ticks)])
+	}
+	return ticks
+}
+
+func newSchedulerTick(now time.Time, clusterSnapshot metadata.Snapshot) SchedulerTick {
+	clusterView := clusterSnapshot.Topology.ClusterView
+	return SchedulerTick{
+		Timestamp:          now.UnixMilli(),
+		ClusterViewVersion: clusterView.Version,
+		ClusterState:       storage.ConvertClusterStateToString(clusterView.State),
+		Reason:             "",
+		Decisions:          []SchedulerDecision{},
+	}
+}
@@ -0,0 +1,52 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecisionLog(t *testing.T) {
+	re := require.New(t)
+
+	ticksLog := newDecisionLog(3)
+	re.Empty(ticksLog.list(10))
+
+	for version := uint64(1); version <= 2; version++ {
+		ticksLog.record(SchedulerTick{Timestamp: 0, ClusterViewVersion: version, ClusterState: "", Reason: "", Decisions: nil})
+	}
+	ticks := ticksLog.list(10)
+	re.Len(ticks, 2)
+	re.Equal(uint64(2), ticks[0].ClusterViewVersion)
+	re.Equal(uint64(1), ticks[1].ClusterViewVersion)
+
+	// The oldest ticks are overwritten once the log is full.
+	for version := uint64(3); version <= 5; version++ {
+		ticksLog.record(SchedulerTick{Timestamp: 0, ClusterViewVersion: version, ClusterState: "", Reason: "", Decisions: nil})
+	}
+	ticks = ticksLog.list(0)
+	re.Len(ticks, 3)
+	re.Equal(uint64(5), ticks[0].ClusterViewVersion)
+	re.Equal(uint64(3), ticks[2].ClusterViewVersion)
+
+	ticks = ticksLog.list(2)
+	re.Len(ticks, 2)
+	re.Equal(uint64(5), ticks[0].ClusterViewVersion)
+	re.Equal(uint64(4), ticks[1].ClusterViewVersion)
+}
@@ -18,4 +18,9 @@ package manager
 
 import "github.com/CeresDB/horaemeta/pkg/coderr"
 
-var ErrInvalidTopologyType = coderr.NewCodeError(coderr.InvalidParams, "invalid topology type")
+var (
+	ErrInvalidTopologyType    = coderr.NewCodeError(coderr.InvalidParams, "invalid topology type")
+	ErrInvalidSchedulerConfig = coderr.NewCodeError(coderr.InvalidParams, "invalid scheduler config")
+	// ErrInvalidShardAffinityRule is returned if the imported rule doesn't match the shards of the cluster.
+	ErrInvalidShardAffinityRule = coderr.NewCodeError(coderr.InvalidParams, "invalid shard affinity rule")
+)
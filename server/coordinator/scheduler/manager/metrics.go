@@ -0,0 +1,31 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// suppressedProcedures counts the procedures generated by the schedulers but suppressed for the shards with a pending
+// procedure or in the cooldown, labeled by the reason of pending or cooldown.
+var suppressedProcedures = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "horaemeta",
+	Subsystem: "scheduler",
+	Name:      "suppressed_procedures_total",
+	Help:      "Number of the procedures generated by the schedulers but suppressed.",
+}, []string{"cluster", "scheduler", "reason"})
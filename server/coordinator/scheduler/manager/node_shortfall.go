@@ -0,0 +1,111 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const nodeShortfallWebhookTimeout = time.Second * 3
+
+// NodeShortfallEvent is posted to the webhook when the online nodes of the cluster fall below the MinNodeCount, so that
+// the external autoscalers can add nodes.
+type NodeShortfallEvent struct {
+	ClusterName   string                 `json:"clusterName"`
+	NodeShortfall metadata.NodeShortfall `json:"nodeShortfall"`
+	Timestamp     uint64                 `json:"timestamp"`
+}
+
+type nodeShortfallNotifier struct {
+	logger      *zap.Logger
+	clusterName string
+	clock       clock.Clock
+	httpClient  *http.Client
+
+	// lastShortfall is only accessed by the scheduling loop.
+	lastShortfall uint32
+}
+
+func newNodeShortfallNotifier(logger *zap.Logger, clusterName string, clk clock.Clock) *nodeShortfallNotifier {
+	return &nodeShortfallNotifier{
+		logger:        logger,
+		clusterName:   clusterName,
+		clock:         clk,
+		httpClient:    &http.Client{Timeout: nodeShortfallWebhookTimeout},
+		lastShortfall: 0,
+	}
+}
+
+// maybeNotify notifies only when the shortfall grows, so the webhook is not called repeatedly for the same shortfall.
+func (n *nodeShortfallNotifier) maybeNotify(webhook string, shortfall metadata.NodeShortfall) {
+	lastShortfall := n.lastShortfall
+	n.lastShortfall = shortfall.Shortfall
+	if shortfall.Shortfall <= lastShortfall {
+		return
+	}
+
+	n.logger.Warn("online nodes fall below min node count", zap.Uint32("minNodeCount", shortfall.MinNodeCount), zap.Uint32("onlineNodeCount", shortfall.OnlineNodeCount))
+	if len(webhook) == 0 {
+		return
+	}
+
+	event := NodeShortfallEvent{
+		ClusterName:   n.clusterName,
+		NodeShortfall: shortfall,
+		Timestamp:     clock.UnixMilli(n.clock),
+	}
+	go func() {
+		if err := n.post(webhook, event); err != nil {
+			n.logger.Error("notify node shortfall webhook failed", zap.String("webhook", webhook), zap.Error(err))
+		}
+	}()
+}
+
+func (n *nodeShortfallNotifier) post(webhook string, event NodeShortfallEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return errors.WithMessage(err, "encode node shortfall event")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), nodeShortfallWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(body))
+	if err != nil {
+		return errors.WithMessage(err, "build webhook request")
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := n.httpClient.Do(req)
+	if err != nil {
+		return errors.WithMessage(err, "post webhook request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected webhook response status:%d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestNodeShortfallNotifier(t *testing.T) {
+	re := require.New(t)
+
+	events := make(chan NodeShortfallEvent, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event NodeShortfallEvent
+		re.NoError(json.NewDecoder(r.Body).Decode(&event))
+		events <- event
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	now := time.Now()
+	notifier := newNodeShortfallNotifier(zap.NewNop(), "testCluster", clock.NewMock(now))
+	shortfall := func(online uint32) metadata.NodeShortfall {
+		return metadata.NodeShortfall{MinNodeCount: 3, OnlineNodeCount: online, Shortfall: 3 - online}
+	}
+
+	// No shortfall, no notification.
+	notifier.maybeNotify(server.URL, shortfall(3))
+	// Falls below the min node count.
+	notifier.maybeNotify(server.URL, shortfall(2))
+	event := <-events
+	re.Equal("testCluster", event.ClusterName)
+	re.Equal(uint64(now.UnixMilli()), event.Timestamp)
+	re.Equal(shortfall(2), event.NodeShortfall)
+
+	// The same shortfall is notified only once.
+	notifier.maybeNotify(server.URL, shortfall(2))
+	// Falls further.
+	notifier.maybeNotify(server.URL, shortfall(1))
+	event = <-events
+	re.Equal(shortfall(1), event.NodeShortfall)
+
+	// Recover and then fall below again.
+	notifier.maybeNotify(server.URL, shortfall(3))
+	notifier.maybeNotify(server.URL, shortfall(2))
+	event = <-events
+	re.Equal(shortfall(2), event.NodeShortfall)
+
+	select {
+	case event := <-events:
+		re.Failf("unexpected notification", "%+v", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,273 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxRebalanceWindowDurationMin bounds the duration of a window to a week, which is the longest period of the cron.
+	maxRebalanceWindowDurationMin = 7 * 24 * 60
+	// rebalanceWindowLookahead bounds the search of the next opening of the windows.
+	rebalanceWindowLookahead = 366 * 24 * time.Hour
+)
+
+// RebalanceWindow is a period the shards are allowed to be moved for balance automatically, which is opened at every
+// minute matched by the cron and lasts for the duration.
+type RebalanceWindow struct {
+	// Cron is a standard cron expression of five fields: minute, hour, day of month, month and day of week, e.g.
+	// "0 1 * * 1-5" opens the window at 01:00 on weekdays.
+	Cron        string `json:"cron"`
+	DurationMin uint32 `json:"durationMin"`
+	// Timezone is the IANA name of the timezone the cron is evaluated in, and empty means UTC.
+	Timezone string `json:"timezone"`
+}
+
+// RebalanceWindowStatus tells whether the shards are allowed to be moved for balance now.
+type RebalanceWindowStatus struct {
+	// Restricted is false if no window is configured, and the shards are allowed to be moved at any time.
+	Restricted bool `json:"restricted"`
+	Open       bool `json:"open"`
+	// ClosesAt is the unix timestamp in milliseconds when the open window closes, and zero if no window is open.
+	ClosesAt int64 `json:"closesAt"`
+	// NextOpensAt is the unix timestamp in milliseconds when a window opens next time, and zero if no window is closed
+	// or no window opens within a year.
+	NextOpensAt int64 `json:"nextOpensAt"`
+}
+
+func (w RebalanceWindow) validate() error {
+	if _, err := parseCronSchedule(w.Cron); err != nil {
+		return err
+	}
+	if w.DurationMin == 0 || w.DurationMin > maxRebalanceWindowDurationMin {
+		return errors.Errorf("duration should be in [1, %d] minutes, durationMin:%d", maxRebalanceWindowDurationMin, w.DurationMin)
+	}
+	if _, err := time.LoadLocation(w.Timezone); err != nil {
+		return errors.WithMessagef(err, "load timezone, timezone:%s", w.Timezone)
+	}
+	return nil
+}
+
+// openedAt returns the latest opening of the window not after now, and false if the window is not open now.
+func (w RebalanceWindow) openedAt(now time.Time) (time.Time, bool) {
+	schedule, location, err := w.parse()
+	if err != nil {
+		return time.Time{}, false
+	}
+	minute := now.In(location).Truncate(time.Minute)
+	for i := uint32(0); i < w.DurationMin; i++ {
+		opened := minute.Add(-time.Duration(i) * time.Minute)
+		if schedule.matches(opened) {
+			return opened, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// nextOpening returns the earliest opening of the window after now, and false if it doesn't open within the lookahead.
+func (w RebalanceWindow) nextOpening(now time.Time) (time.Time, bool) {
+	schedule, location, err := w.parse()
+	if err != nil {
+		return time.Time{}, false
+	}
+	minute := now.In(location).Truncate(time.Minute)
+	for next := minute.Add(time.Minute); next.Sub(minute) <= rebalanceWindowLookahead; next = next.Add(time.Minute) {
+		if schedule.matches(next) {
+			return next, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func (w RebalanceWindow) parse() (cronSchedule, *time.Location, error) {
+	schedule, err := parseCronSchedule(w.Cron)
+	if err != nil {
+		return cronSchedule{}, nil, err
+	}
+	location, err := time.LoadLocation(w.Timezone)
+	if err != nil {
+		return cronSchedule{}, nil, err
+	}
+	return schedule, location, nil
+}
+
+// isRebalanceAllowed tells whether any of the windows is open, and it is always allowed if no window is configured.
+func isRebalanceAllowed(windows []RebalanceWindow, now time.Time) bool {
+	if len(windows) == 0 {
+		return true
+	}
+	for _, window := range windows {
+		if _, open := window.openedAt(now); open {
+			return true
+		}
+	}
+	return false
+}
+
+func getRebalanceWindowStatus(windows []RebalanceWindow, now time.Time) RebalanceWindowStatus {
+	status := RebalanceWindowStatus{
+		Restricted:  len(windows) > 0,
+		Open:        len(windows) == 0,
+		ClosesAt:    0,
+		NextOpensAt: 0,
+	}
+	for _, window := range windows {
+		if opened, open := window.openedAt(now); open {
+			status.Open = true
+			closesAt := opened.Add(time.Duration(window.DurationMin) * time.Minute).UnixMilli()
+			status.ClosesAt = max(status.ClosesAt, closesAt)
+		}
+	}
+	if status.Open {
+		return status
+	}
+
+	for _, window := range windows {
+		if next, ok := window.nextOpening(now); ok {
+			if status.NextOpensAt == 0 || next.UnixMilli() < status.NextOpensAt {
+				status.NextOpensAt = next.UnixMilli()
+			}
+		}
+	}
+	return status
+}
+
+// cronField describes the allowed values of a field of the cron expression.
+type cronField struct {
+	name string
+	min  int
+	max  int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	// Both 0 and 7 are sunday.
+	{name: "day of week", min: 0, max: 7},
+}
+
+// cronSchedule keeps the matched values of every field in bitsets.
+type cronSchedule struct {
+	minute     uint64
+	hour       uint64
+	dayOfMonth uint64
+	month      uint64
+	dayOfWeek  uint64
+	// The days are matched if either the day of month or the day of week matches when both of them are restricted,
+	// which follows the standard cron.
+	dayOfMonthAny bool
+	dayOfWeekAny  bool
+}
+
+func parseCronSchedule(expr string) (cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return cronSchedule{}, errors.Errorf("cron should have %d fields, cron:%s", len(cronFields), expr)
+	}
+
+	bits := make([]uint64, len(fields))
+	for i, field := range fields {
+		b, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return cronSchedule{}, errors.WithMessagef(err, "parse cron, cron:%s", expr)
+		}
+		bits[i] = b
+	}
+	dayOfWeek := bits[4]
+	if dayOfWeek&(1<<7) != 0 {
+		dayOfWeek |= 1
+	}
+	return cronSchedule{
+		minute:        bits[0],
+		hour:          bits[1],
+		dayOfMonth:    bits[2],
+		month:         bits[3],
+		dayOfWeek:     dayOfWeek,
+		dayOfMonthAny: fields[2] == "*",
+		dayOfWeekAny:  fields[4] == "*",
+	}, nil
+}
+
+// parseCronField parses the comma separated list of "*", "a" or "a-b", each of which can be followed by "/step".
+func parseCronField(expr string, field cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(expr, ",") {
+		rangeExpr, stepExpr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			parsed, err := strconv.Atoi(stepExpr)
+			if err != nil || parsed <= 0 {
+				return 0, errors.Errorf("invalid step of %s, expr:%s", field.name, part)
+			}
+			step = parsed
+		}
+
+		start, end := field.min, field.max
+		if rangeExpr != "*" {
+			startExpr, endExpr, isRange := strings.Cut(rangeExpr, "-")
+			var err error
+			if start, err = parseCronValue(startExpr, field); err != nil {
+				return 0, err
+			}
+			end = start
+			if isRange {
+				if end, err = parseCronValue(endExpr, field); err != nil {
+					return 0, err
+				}
+			} else if hasStep {
+				// "a/step" starts from a to the max.
+				end = field.max
+			}
+			if start > end {
+				return 0, errors.Errorf("invalid range of %s, expr:%s", field.name, part)
+			}
+		}
+
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(expr string, field cronField) (int, error) {
+	v, err := strconv.Atoi(expr)
+	if err != nil || v < field.min || v > field.max {
+		return 0, errors.Errorf("%s should be in [%d, %d], value:%s", field.name, field.min, field.max, expr)
+	}
+	return v, nil
+}
+
+func (s cronSchedule) matches(t time.Time) bool {
+	if s.minute&(1<<uint(t.Minute())) == 0 || s.hour&(1<<uint(t.Hour())) == 0 || s.month&(1<<uint(t.Month())) == 0 {
+		return false
+	}
+
+	dayOfMonthMatched := s.dayOfMonth&(1<<uint(t.Day())) != 0
+	dayOfWeekMatched := s.dayOfWeek&(1<<uint(t.Weekday())) != 0
+	if s.dayOfMonthAny || s.dayOfWeekAny {
+		return dayOfMonthMatched && dayOfWeekMatched
+	}
+	return dayOfMonthMatched || dayOfWeekMatched
+}
@@ -0,0 +1,97 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	re := require.New(t)
+
+	for _, expr := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "a * * * *"} {
+		_, err := parseCronSchedule(expr)
+		re.Error(err, expr)
+	}
+
+	// 01:30 on weekdays, and sunday can be 7.
+	schedule, err := parseCronSchedule("30 1 * * 1-5")
+	re.NoError(err)
+	re.True(schedule.matches(time.Date(2024, 1, 1, 1, 30, 0, 0, time.UTC)))
+	re.False(schedule.matches(time.Date(2024, 1, 6, 1, 30, 0, 0, time.UTC)))
+	re.False(schedule.matches(time.Date(2024, 1, 1, 1, 31, 0, 0, time.UTC)))
+	schedule, err = parseCronSchedule("0 0 * * 7")
+	re.NoError(err)
+	re.True(schedule.matches(time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)))
+
+	// Steps and lists.
+	schedule, err = parseCronSchedule("*/15 2,4 * * *")
+	re.NoError(err)
+	re.True(schedule.matches(time.Date(2024, 1, 1, 4, 45, 0, 0, time.UTC)))
+	re.False(schedule.matches(time.Date(2024, 1, 1, 3, 45, 0, 0, time.UTC)))
+	re.False(schedule.matches(time.Date(2024, 1, 1, 2, 50, 0, 0, time.UTC)))
+
+	// Either the day of month or the day of week matches if both are restricted.
+	schedule, err = parseCronSchedule("0 0 1 * 1")
+	re.NoError(err)
+	re.True(schedule.matches(time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)))
+	re.True(schedule.matches(time.Date(2024, 2, 5, 0, 0, 0, 0, time.UTC)))
+	re.False(schedule.matches(time.Date(2024, 2, 6, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestRebalanceWindowStatus(t *testing.T) {
+	re := require.New(t)
+
+	// No window means no restriction.
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	re.True(isRebalanceAllowed(nil, now))
+	re.Equal(RebalanceWindowStatus{Restricted: false, Open: true, ClosesAt: 0, NextOpensAt: 0}, getRebalanceWindowStatus(nil, now))
+
+	// 02:00-04:00 in Shanghai every day, which is 18:00-20:00 in UTC.
+	windows := []RebalanceWindow{{Cron: "0 2 * * *", DurationMin: 120, Timezone: "Asia/Shanghai"}}
+	re.NoError(windows[0].validate())
+	re.False(isRebalanceAllowed(windows, now))
+	re.Equal(RebalanceWindowStatus{
+		Restricted:  true,
+		Open:        false,
+		ClosesAt:    0,
+		NextOpensAt: time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC).UnixMilli(),
+	}, getRebalanceWindowStatus(windows, now))
+
+	now = time.Date(2024, 1, 1, 19, 59, 59, 0, time.UTC)
+	re.True(isRebalanceAllowed(windows, now))
+	re.Equal(RebalanceWindowStatus{
+		Restricted:  true,
+		Open:        true,
+		ClosesAt:    time.Date(2024, 1, 1, 20, 0, 0, 0, time.UTC).UnixMilli(),
+		NextOpensAt: 0,
+	}, getRebalanceWindowStatus(windows, now))
+	re.False(isRebalanceAllowed(windows, now.Add(time.Second)))
+
+	// Invalid windows are rejected by the config.
+	config := DefaultSchedulerConfig()
+	config.RebalanceWindows = []RebalanceWindow{{Cron: "0 2 * * *", DurationMin: 0, Timezone: ""}}
+	re.True(coderr.Is(config.validate(nil), ErrInvalidSchedulerConfig.Code()))
+	config.RebalanceWindows = []RebalanceWindow{{Cron: "0 2 * * *", DurationMin: 60, Timezone: "Mars/Olympus"}}
+	re.True(coderr.Is(config.validate(nil), ErrInvalidSchedulerConfig.Code()))
+	config.RebalanceWindows = []RebalanceWindow{{Cron: "0 2 * * *", DurationMin: 60, Timezone: ""}}
+	re.NoError(config.validate(nil))
+}
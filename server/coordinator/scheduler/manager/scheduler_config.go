@@ -0,0 +1,161 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"path"
+	"slices"
+	"time"
+
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/hotspot"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/nodepicker"
+	"github.com/CeresDB/horaemeta/server/etcdutil"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+const (
+	schedulerConfigKeyPrefix = "scheduler_config"
+	minSchedulerIntervalMs   = 100
+	defaultShardMoveCooldown = 30 * time.Second
+)
+
+// SchedulerConfig is the settings of the scheduler manager which can be updated at runtime, and it is persisted per cluster.
+type SchedulerConfig struct {
+	IntervalMs uint64 `json:"intervalMs"`
+	// MaxProceduresPerTick limits the procedures submitted in one round of scheduling, and zero means no limit.
+	MaxProceduresPerTick uint32 `json:"maxProceduresPerTick"`
+	// DisabledSchedulers are the names of the schedulers which are skipped when scheduling.
+	DisabledSchedulers []string `json:"disabledSchedulers"`
+	// NodeShortfallWebhook is the url notified when the online nodes fall below the MinNodeCount, and empty means no notification.
+	NodeShortfallWebhook string `json:"nodeShortfallWebhook"`
+	// ShardOperationThrottle limits the open and close shard operations dispatched to each node of the cluster.
+	ShardOperationThrottle eventdispatch.ShardOperationThrottleConfig `json:"shardOperationThrottle"`
+	// NodeShardCapacity limits the number of the shards assigned to each node by the node picker.
+	NodeShardCapacity nodepicker.ShardCapacityConfig `json:"nodeShardCapacity"`
+	// RebalanceWindows restrict the shards to be moved for balance automatically within the windows, and empty means no
+	// restriction. The shards without leaders are still assigned and the manual procedures are still allowed at any time.
+	RebalanceWindows []RebalanceWindow `json:"rebalanceWindows"`
+	// HotSpot is the thresholds to flag the hot shards, and whether they are split automatically.
+	HotSpot hotspot.Config `json:"hotSpot"`
+	// ShardMoveCooldownMs is how long a shard is not operated again by the schedulers after a procedure of it is submitted,
+	// except the failover, and zero means no cooldown. A shard never has two pending procedures of the schedulers anyway.
+	ShardMoveCooldownMs uint64 `json:"shardMoveCooldownMs"`
+}
+
+type SchedulerStatus struct {
+	Name    string `json:"name"`
+	Enabled bool   `json:"enabled"`
+}
+
+func DefaultSchedulerConfig() SchedulerConfig {
+	return SchedulerConfig{
+		IntervalMs:             uint64(schedulerInterval.Milliseconds()),
+		MaxProceduresPerTick:   0,
+		DisabledSchedulers:     []string{},
+		NodeShortfallWebhook:   "",
+		ShardOperationThrottle: eventdispatch.DefaultShardOperationThrottleConfig(),
+		NodeShardCapacity:      nodepicker.DefaultShardCapacityConfig(),
+		RebalanceWindows:       []RebalanceWindow{},
+		HotSpot:                hotspot.DefaultConfig(),
+		ShardMoveCooldownMs:    uint64(defaultShardMoveCooldown.Milliseconds()),
+	}
+}
+
+func (c SchedulerConfig) interval() time.Duration {
+	return time.Duration(c.IntervalMs) * time.Millisecond
+}
+
+func (c SchedulerConfig) shardMoveCooldown() time.Duration {
+	return time.Duration(c.ShardMoveCooldownMs) * time.Millisecond
+}
+
+func (c SchedulerConfig) isDisabled(schedulerName string) bool {
+	return slices.Contains(c.DisabledSchedulers, schedulerName)
+}
+
+// validate checks the config, and the names of the disabled schedulers must be found in the schedulerNames.
+func (c SchedulerConfig) validate(schedulerNames []string) error {
+	if c.IntervalMs < minSchedulerIntervalMs {
+		return ErrInvalidSchedulerConfig.WithCausef("interval should not be less than %dms, intervalMs:%d", minSchedulerIntervalMs, c.IntervalMs)
+	}
+	for _, name := range c.DisabledSchedulers {
+		if !slices.Contains(schedulerNames, name) {
+			return ErrInvalidSchedulerConfig.WithCausef("scheduler not found, name:%s", name)
+		}
+	}
+	if len(c.NodeShortfallWebhook) > 0 {
+		webhook, err := url.ParseRequestURI(c.NodeShortfallWebhook)
+		if err != nil || (webhook.Scheme != "http" && webhook.Scheme != "https") {
+			return ErrInvalidSchedulerConfig.WithCausef("invalid node shortfall webhook, url:%s", c.NodeShortfallWebhook)
+		}
+	}
+	if err := c.ShardOperationThrottle.Validate(); err != nil {
+		return ErrInvalidSchedulerConfig.WithCausef("%v", err)
+	}
+	if err := c.NodeShardCapacity.Validate(); err != nil {
+		return ErrInvalidSchedulerConfig.WithCausef("%v", err)
+	}
+	if err := c.HotSpot.Validate(); err != nil {
+		return ErrInvalidSchedulerConfig.WithCausef("invalid hot spot config, err:%v", err)
+	}
+	for i, window := range c.RebalanceWindows {
+		if err := window.validate(); err != nil {
+			return ErrInvalidSchedulerConfig.WithCausef("invalid rebalance window, index:%d, err:%v", i, err)
+		}
+	}
+	return nil
+}
+
+func makeSchedulerConfigKey(rootPath, clusterName string) string {
+	return path.Join(rootPath, schedulerConfigKeyPrefix, clusterName)
+}
+
+// loadSchedulerConfig returns the persisted config of the cluster, and the default config is returned if nothing is persisted.
+func loadSchedulerConfig(ctx context.Context, client *clientv3.Client, rootPath, clusterName string) (SchedulerConfig, error) {
+	key := makeSchedulerConfigKey(rootPath, clusterName)
+	value, err := etcdutil.Get(ctx, client, key)
+	if err == etcdutil.ErrEtcdKVGetNotFound {
+		return DefaultSchedulerConfig(), nil
+	}
+	if err != nil {
+		return SchedulerConfig{}, errors.WithMessagef(err, "get scheduler config, key:%s", key)
+	}
+
+	config := DefaultSchedulerConfig()
+	if err := json.Unmarshal([]byte(value), &config); err != nil {
+		return SchedulerConfig{}, errors.WithMessagef(err, "decode scheduler config, key:%s", key)
+	}
+	return config, nil
+}
+
+func saveSchedulerConfig(ctx context.Context, client *clientv3.Client, rootPath, clusterName string, config SchedulerConfig) error {
+	value, err := json.Marshal(config)
+	if err != nil {
+		return errors.WithMessage(err, "encode scheduler config")
+	}
+
+	key := makeSchedulerConfigKey(rootPath, clusterName)
+	if _, err := client.Put(ctx, key, string(value)); err != nil {
+		return errors.WithMessagef(err, "put scheduler config, key:%s", key)
+	}
+	return nil
+}
@@ -27,8 +27,11 @@ import (
 	"github.com/CeresDB/horaemeta/pkg/log"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator"
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
 	"github.com/CeresDB/horaemeta/server/coordinator/scheduler"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/failover"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/hotspot"
 	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/nodepicker"
 	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/rebalanced"
 	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/reopen"
@@ -70,6 +73,35 @@ type SchedulerManager interface {
 	// ListShardAffinityRules lists all the rules about shard affinity of all the registered schedulers.
 	ListShardAffinityRules(ctx context.Context) (map[string]scheduler.ShardAffinityRule, error)
 
+	// ExportShardAffinityRule returns the complete set of the shard affinities of all the registered schedulers.
+	ExportShardAffinityRule(ctx context.Context) (scheduler.ShardAffinityRule, error)
+
+	// ImportShardAffinityRule validates the rule and replaces the rules of all the registered schedulers with it at once,
+	// and the returned diff describes the change against the exported rule. Nothing is replaced if dryRun is set.
+	ImportShardAffinityRule(ctx context.Context, rule scheduler.ShardAffinityRule, dryRun bool) (ShardAffinityRuleDiff, error)
+
+	// GetSchedulerConfig returns the config used by the running scheduler manager.
+	GetSchedulerConfig(ctx context.Context) SchedulerConfig
+
+	// UpdateSchedulerConfig persists the config and applies it from the next round of scheduling without restart.
+	UpdateSchedulerConfig(ctx context.Context, config SchedulerConfig) error
+
+	// ListSchedulerStatus lists the registered schedulers and whether they are enabled.
+	ListSchedulerStatus(ctx context.Context) []SchedulerStatus
+
+	// UpdateSchedulerEnabled enables or disables a single scheduler by its name, and the others are not affected.
+	UpdateSchedulerEnabled(ctx context.Context, schedulerName string, enable bool) error
+
+	// GetRebalanceWindowStatus tells whether the shards are allowed to be moved for balance now by the rebalance windows.
+	GetRebalanceWindowStatus(ctx context.Context) RebalanceWindowStatus
+
+	// ListSchedulerDecisions lists at most limit latest rounds of scheduling, with the decisions of every scheduler in them.
+	ListSchedulerDecisions(ctx context.Context, limit int) []SchedulerTick
+
+	// UpdateTopologyType replaces the shard watch and the schedulers with the ones of the topology type, and the shard
+	// affinity rules are carried over to the new schedulers.
+	UpdateTopologyType(ctx context.Context, topologyType storage.TopologyType) error
+
 	// Scheduler will be called when received new heartbeat, every scheduler registered in schedulerManager will be called to generate procedures.
 	// Scheduler cloud be schedule with fix time interval or heartbeat.
 	Scheduler(ctx context.Context, clusterSnapshot metadata.Snapshot) []scheduler.ScheduleResult
@@ -83,6 +115,8 @@ type schedulerManagerImpl struct {
 	client           *clientv3.Client
 	clusterMetadata  *metadata.ClusterMetadata
 	rootPath         string
+	// loads are the loads of the shards and the tables reported by the nodes.
+	loads hotspot.LoadProvider
 
 	// This lock is used to protect the following field.
 	lock                        sync.RWMutex
@@ -93,26 +127,28 @@ type schedulerManagerImpl struct {
 	procedureExecutingBatchSize uint32
 	enableSchedule              bool
 	shardAffinities             map[storage.ShardID]scheduler.ShardAffinityRule
+	schedulerConfig             SchedulerConfig
+	// configUpdated wakes up the scheduling loop to apply the updated config at once.
+	configUpdated         chan struct{}
+	nodeShortfallNotifier *nodeShortfallNotifier
+	// shardOperationThrottle applies the throttle config of the cluster to the dispatch of the procedures.
+	shardOperationThrottle *eventdispatch.ThrottledDispatch
+	decisionLog            *decisionLog
+	shardMoveLimiter       *shardMoveLimiter
 }
 
-func NewManager(logger *zap.Logger, procedureManager procedure.Manager, factory *coordinator.Factory, clusterMetadata *metadata.ClusterMetadata, client *clientv3.Client, rootPath string, topologyType storage.TopologyType, procedureExecutingBatchSize uint32) SchedulerManager {
-	var shardWatch watch.ShardWatch
-	switch topologyType {
-	case storage.TopologyTypeDynamic:
-		shardWatch = watch.NewEtcdShardWatch(logger, clusterMetadata.Name(), rootPath, client)
-		shardWatch.RegisteringEventCallback(&schedulerWatchCallback{c: clusterMetadata})
-	case storage.TopologyTypeStatic:
-		shardWatch = watch.NewNoopShardWatch()
-	}
+func NewManager(logger *zap.Logger, procedureManager procedure.Manager, factory *coordinator.Factory, clusterMetadata *metadata.ClusterMetadata, client *clientv3.Client, rootPath string, topologyType storage.TopologyType, procedureExecutingBatchSize uint32, shardOperationThrottle *eventdispatch.ThrottledDispatch, loads hotspot.LoadProvider) SchedulerManager {
+	shardWatch := newShardWatch(logger, clusterMetadata, client, rootPath, topologyType)
 
 	return &schedulerManagerImpl{
 		logger:                      logger,
 		procedureManager:            procedureManager,
 		factory:                     factory,
-		nodePicker:                  nodepicker.NewConsistentUniformHashNodePicker(logger),
+		nodePicker:                  nodepicker.NewConsistentUniformHashNodePicker(logger, clusterMetadata.Clock()),
 		client:                      client,
 		clusterMetadata:             clusterMetadata,
 		rootPath:                    rootPath,
+		loads:                       loads,
 		lock:                        sync.RWMutex{},
 		registerSchedulers:          []scheduler.Scheduler{},
 		shardWatch:                  shardWatch,
@@ -121,7 +157,27 @@ func NewManager(logger *zap.Logger, procedureManager procedure.Manager, factory
 		procedureExecutingBatchSize: procedureExecutingBatchSize,
 		enableSchedule:              false,
 		shardAffinities:             make(map[storage.ShardID]scheduler.ShardAffinityRule),
+		schedulerConfig:             DefaultSchedulerConfig(),
+		configUpdated:               make(chan struct{}, 1),
+		nodeShortfallNotifier:       newNodeShortfallNotifier(logger, clusterMetadata.Name(), clusterMetadata.Clock()),
+		shardOperationThrottle:      shardOperationThrottle,
+		decisionLog:                 newDecisionLog(decisionLogCapacity),
+		shardMoveLimiter:            newShardMoveLimiter(),
+	}
+}
+
+// newShardWatch creates the shard watch of the topology type, and the shards registered by the nodes are watched only in
+// the dynamic topology.
+func newShardWatch(logger *zap.Logger, clusterMetadata *metadata.ClusterMetadata, client *clientv3.Client, rootPath string, topologyType storage.TopologyType) watch.ShardWatch {
+	var shardWatch watch.ShardWatch
+	switch topologyType {
+	case storage.TopologyTypeDynamic:
+		shardWatch = watch.NewEtcdShardWatch(logger, clusterMetadata.Name(), rootPath, client)
+		shardWatch.RegisteringEventCallback(&schedulerWatchCallback{c: clusterMetadata})
+	case storage.TopologyTypeStatic:
+		shardWatch = watch.NewNoopShardWatch()
 	}
+	return shardWatch
 }
 
 func (m *schedulerManagerImpl) Stop(ctx context.Context) error {
@@ -147,42 +203,68 @@ func (m *schedulerManagerImpl) Start(ctx context.Context) error {
 		return nil
 	}
 
+	schedulerConfig, err := loadSchedulerConfig(ctx, m.client, m.rootPath, m.clusterMetadata.Name())
+	if err != nil {
+		return errors.WithMessage(err, "load scheduler config")
+	}
+	m.schedulerConfig = schedulerConfig
+	m.shardOperationThrottle.UpdateThrottleConfig(schedulerConfig.ShardOperationThrottle)
+	m.nodePicker.UpdateShardCapacity(schedulerConfig.NodeShardCapacity)
+
 	m.initRegister()
 
 	if err := m.shardWatch.Start(ctx); err != nil {
 		return errors.WithMessage(err, "start shard watch failed")
 	}
 
+	// Mark it running before the loop starts, otherwise a Stop called in between would miss the shard watch and the loop.
+	m.isRunning.Store(true)
 	go func() {
-		m.isRunning.Store(true)
 		for {
 			if !m.isRunning.Load() {
 				m.logger.Info("scheduler manager is canceled")
 				return
 			}
 
-			time.Sleep(schedulerInterval)
+			schedulerConfig := m.GetSchedulerConfig(ctx)
+			select {
+			case <-time.After(schedulerConfig.interval()):
+			case <-m.configUpdated:
+				// Restart the waiting with the updated interval.
+				continue
+			}
 			// Get latest cluster snapshot.
 			clusterSnapshot := m.clusterMetadata.GetClusterSnapshot()
 			m.logger.Debug("scheduler manager invoke", zap.String("clusterSnapshot", fmt.Sprintf("%v", clusterSnapshot)))
 
+			nodeShortfall := m.clusterMetadata.GetNodeShortfall()
+			m.nodeShortfallNotifier.maybeNotify(schedulerConfig.NodeShortfallWebhook, nodeShortfall)
+
+			tick := newSchedulerTick(m.clusterMetadata.Clock().Now(), clusterSnapshot)
 			if clusterSnapshot.Topology.IsPrepareFinished() {
+				// The cluster should not be stable until enough nodes are online to serve the shards.
+				if nodeShortfall.Shortfall > 0 {
+					m.logger.Warn("cluster is not allowed to be stable for lack of online nodes", zap.Uint32("minNodeCount", nodeShortfall.MinNodeCount), zap.Uint32("onlineNodeCount", nodeShortfall.OnlineNodeCount))
+					tick.Reason = reasonNodeShortfall
+					m.decisionLog.record(tick)
+					continue
+				}
 				m.logger.Info("try to update cluster state to stable")
+				tick.Reason = reasonClusterPreparing
 				if err := m.clusterMetadata.UpdateClusterView(ctx, storage.ClusterStateStable, clusterSnapshot.Topology.ClusterView.ShardNodes); err != nil {
 					m.logger.Error("update cluster view failed", zap.Error(err))
 				}
+				m.decisionLog.record(tick)
 				continue
 			}
 
-			results := m.Scheduler(ctx, clusterSnapshot)
-			for _, result := range results {
-				if result.Procedure != nil {
-					m.logger.Info("scheduler submit new procedure", zap.Uint64("ProcedureID", result.Procedure.ID()), zap.String("Reason", result.Reason))
-					if err := m.procedureManager.Submit(ctx, result.Procedure); err != nil {
-						m.logger.Error("scheduler submit new procedure failed", zap.Uint64("ProcedureID", result.Procedure.ID()), zap.Error(err))
-					}
-				}
+			outcomes := m.schedule(ctx, clusterSnapshot, schedulerConfig)
+			submittedCount := uint32(0)
+			for _, outcome := range outcomes {
+				tick.Decisions = append(tick.Decisions, m.submit(ctx, outcome, schedulerConfig, &submittedCount))
 			}
+			m.shardMoveLimiter.gc(m.clusterMetadata.Clock().Now(), schedulerConfig.shardMoveCooldown())
+			m.decisionLog.record(tick)
 		}
 	}()
 
@@ -224,15 +306,19 @@ func (m *schedulerManagerImpl) initRegister() {
 }
 
 func (m *schedulerManagerImpl) createStaticTopologySchedulers() []scheduler.Scheduler {
-	staticTopologyShardScheduler := static.NewShardScheduler(m.factory, m.nodePicker, m.procedureExecutingBatchSize)
-	reopenShardScheduler := reopen.NewShardScheduler(m.factory, m.procedureExecutingBatchSize)
-	return []scheduler.Scheduler{staticTopologyShardScheduler, reopenShardScheduler}
+	// The failover scheduler goes first, so that promoting the warm follower wins over reopening the shard cold.
+	failoverShardScheduler := failover.NewShardScheduler(m.factory, m.clusterMetadata, m.procedureExecutingBatchSize, m.clusterMetadata.Clock())
+	staticTopologyShardScheduler := static.NewShardScheduler(m.factory, m.nodePicker, m.procedureExecutingBatchSize, m.clusterMetadata.Clock())
+	reopenShardScheduler := reopen.NewShardScheduler(m.factory, m.procedureExecutingBatchSize, m.clusterMetadata.Clock())
+	return []scheduler.Scheduler{failoverShardScheduler, staticTopologyShardScheduler, reopenShardScheduler}
 }
 
 func (m *schedulerManagerImpl) createDynamicTopologySchedulers() []scheduler.Scheduler {
-	rebalancedShardScheduler := rebalanced.NewShardScheduler(m.logger, m.factory, m.nodePicker, m.procedureExecutingBatchSize)
-	reopenShardScheduler := reopen.NewShardScheduler(m.factory, m.procedureExecutingBatchSize)
-	return []scheduler.Scheduler{rebalancedShardScheduler, reopenShardScheduler}
+	failoverShardScheduler := failover.NewShardScheduler(m.factory, m.clusterMetadata, m.procedureExecutingBatchSize, m.clusterMetadata.Clock())
+	rebalancedShardScheduler := rebalanced.NewShardScheduler(m.logger, m.factory, m.nodePicker, m.procedureExecutingBatchSize, m.clusterMetadata.Clock())
+	reopenShardScheduler := reopen.NewShardScheduler(m.factory, m.procedureExecutingBatchSize, m.clusterMetadata.Clock())
+	hotSpotSplitScheduler := hotspot.NewShardScheduler(m.logger, m.factory, m.clusterMetadata, m.loads, m.clusterMetadata.Clock())
+	return []scheduler.Scheduler{failoverShardScheduler, rebalancedShardScheduler, reopenShardScheduler, hotSpotSplitScheduler}
 }
 
 func (m *schedulerManagerImpl) registerScheduler(scheduler scheduler.Scheduler) {
@@ -248,17 +334,168 @@ func (m *schedulerManagerImpl) ListScheduler() []scheduler.Scheduler {
 }
 
 func (m *schedulerManagerImpl) Scheduler(ctx context.Context, clusterSnapshot metadata.Snapshot) []scheduler.ScheduleResult {
+	outcomes := m.schedule(ctx, clusterSnapshot, m.GetSchedulerConfig(ctx))
+	results := make([]scheduler.ScheduleResult, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		if !outcome.disabled && outcome.err == nil {
+			results = append(results, outcome.result)
+		}
+	}
+	return results
+}
+
+// scheduleOutcome is what a registered scheduler produces in one round of scheduling.
+type scheduleOutcome struct {
+	schedulerName string
+	disabled      bool
+	result        scheduler.ScheduleResult
+	err           error
+}
+
+func (m *schedulerManagerImpl) schedule(ctx context.Context, clusterSnapshot metadata.Snapshot, schedulerConfig SchedulerConfig) []scheduleOutcome {
+	rebalanceAllowed := isRebalanceAllowed(schedulerConfig.RebalanceWindows, m.clusterMetadata.Clock().Now())
 	// TODO: Every scheduler should run in an independent goroutine.
-	results := make([]scheduler.ScheduleResult, 0, len(m.registerSchedulers))
-	for _, scheduler := range m.registerSchedulers {
-		result, err := scheduler.Schedule(ctx, clusterSnapshot)
+	outcomes := make([]scheduleOutcome, 0, len(m.registerSchedulers))
+	for _, s := range m.registerSchedulers {
+		if rebalancer, ok := s.(scheduler.Rebalancer); ok {
+			rebalancer.UpdateRebalanceAllowed(ctx, rebalanceAllowed)
+		}
+		if updater, ok := s.(hotspot.ConfigUpdater); ok {
+			updater.UpdateHotSpotConfig(ctx, schedulerConfig.HotSpot)
+		}
+		outcome := scheduleOutcome{
+			schedulerName: s.Name(),
+			disabled:      schedulerConfig.isDisabled(s.Name()),
+			result:        scheduler.ScheduleResult{Procedure: nil, Reason: ""},
+			err:           nil,
+		}
+		if !outcome.disabled {
+			outcome.result, outcome.err = s.Schedule(ctx, clusterSnapshot)
+			if outcome.err != nil {
+				m.logger.Error("scheduler failed", zap.Error(outcome.err))
+			}
+		}
+		outcomes = append(outcomes, outcome)
+	}
+	return outcomes
+}
+
+// submit submits the procedure generated by the scheduler unless its shards have pending procedures or are in the
+// cooldown, or the max procedures per tick is reached, and describes what happens to it.
+func (m *schedulerManagerImpl) submit(ctx context.Context, outcome scheduleOutcome, schedulerConfig SchedulerConfig, submittedCount *uint32) SchedulerDecision {
+	decision := SchedulerDecision{
+		Scheduler:   outcome.schedulerName,
+		Reason:      outcome.result.Reason,
+		ProcedureID: 0,
+		Submitted:   false,
+		Error:       "",
+	}
+	switch {
+	case outcome.disabled:
+		decision.Reason = reasonDisabled
+		return decision
+	case outcome.err != nil:
+		decision.Error = outcome.err.Error()
+		return decision
+	case outcome.result.Procedure == nil:
+		if len(decision.Reason) == 0 {
+			decision.Reason = reasonNoProcedure
+		}
+		return decision
+	}
+
+	p := outcome.result.Procedure
+	decision.ProcedureID = p.ID()
+	// The failover restores the shards without the online leaders, which should not wait for the cooldown.
+	cooldown := schedulerConfig.shardMoveCooldown()
+	if outcome.schedulerName == failover.SchedulerName {
+		cooldown = 0
+	}
+	now := m.clusterMetadata.Clock().Now()
+	if reason, desc, suppressed := m.shardMoveLimiter.check(p, now, cooldown); suppressed {
+		m.logger.Info("scheduler procedure is suppressed", zap.Uint64("ProcedureID", p.ID()), zap.String("scheduler", outcome.schedulerName), zap.String("reason", desc))
+		suppressedProcedures.WithLabelValues(m.clusterMetadata.Name(), outcome.schedulerName, reason).Inc()
+		decision.Error = desc
+		return decision
+	}
+	if schedulerConfig.MaxProceduresPerTick > 0 && *submittedCount >= schedulerConfig.MaxProceduresPerTick {
+		m.logger.Info("scheduler reaches max procedures per tick, procedure is dropped", zap.Uint64("ProcedureID", p.ID()), zap.Uint32("maxProceduresPerTick", schedulerConfig.MaxProceduresPerTick))
+		decision.Error = reasonMaxProcedures
+		return decision
+	}
+	*submittedCount++
+	m.logger.Info("scheduler submit new procedure", zap.Uint64("ProcedureID", p.ID()), zap.String("Reason", outcome.result.Reason))
+	if err := m.procedureManager.Submit(ctx, p); err != nil {
+		m.logger.Error("scheduler submit new procedure failed", zap.Uint64("ProcedureID", p.ID()), zap.Error(err))
+		decision.Error = err.Error()
+		return decision
+	}
+	m.shardMoveLimiter.record(p, now)
+	decision.Submitted = true
+	return decision
+}
+
+func (m *schedulerManagerImpl) GetRebalanceWindowStatus(ctx context.Context) RebalanceWindowStatus {
+	return getRebalanceWindowStatus(m.GetSchedulerConfig(ctx).RebalanceWindows, m.clusterMetadata.Clock().Now())
+}
+
+func (m *schedulerManagerImpl) ListSchedulerDecisions(_ context.Context, limit int) []SchedulerTick {
+	return m.decisionLog.list(limit)
+}
+
+func (m *schedulerManagerImpl) UpdateTopologyType(ctx context.Context, topologyType storage.TopologyType) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.topologyType == topologyType {
+		return nil
+	}
+
+	// Some schedulers don't support the shard affinity, and their rules are not carried over.
+	previousRules := make(map[string]scheduler.ShardAffinityRule, len(m.registerSchedulers))
+	for _, s := range m.registerSchedulers {
+		rule, err := s.ListShardAffinityRule(ctx)
 		if err != nil {
-			m.logger.Error("scheduler failed", zap.Error(err))
+			m.logger.Warn("list shard affinity rule failed", zap.String("scheduler", s.Name()), zap.Error(err))
 			continue
 		}
-		results = append(results, result)
+		previousRules[s.Name()] = rule
 	}
-	return results
+
+	shardWatch := newShardWatch(m.logger, m.clusterMetadata, m.client, m.rootPath, topologyType)
+	if m.isRunning.Load() {
+		if err := m.shardWatch.Stop(ctx); err != nil {
+			return errors.WithMessage(err, "stop shard watch")
+		}
+		if err := shardWatch.Start(ctx); err != nil {
+			// Keep watching the shards in the previous way, so the manager is left as it was.
+			if restartErr := m.shardWatch.Start(ctx); restartErr != nil {
+				m.logger.Error("restart previous shard watch failed", zap.Error(restartErr))
+			}
+			return errors.WithMessage(err, "start shard watch")
+		}
+	}
+	m.shardWatch = shardWatch
+	m.topologyType = topologyType
+
+	// The schedulers are registered on start, and the stopped manager registers the new ones when it starts again.
+	if m.isRunning.Load() {
+		m.registerSchedulers = []scheduler.Scheduler{}
+		m.initRegister()
+		rule := mergeShardAffinityRules(previousRules)
+		for _, s := range m.registerSchedulers {
+			s.UpdateEnableSchedule(ctx, m.enableSchedule)
+			if len(rule.Affinities) == 0 {
+				continue
+			}
+			if err := s.ReplaceShardAffinityRule(ctx, rule); err != nil {
+				m.logger.Warn("carry over shard affinity rule failed", zap.String("scheduler", s.Name()), zap.Error(err))
+			}
+		}
+	}
+
+	m.logger.Info("scheduler manager topology type updated", zap.String("topologyType", string(topologyType)))
+	return nil
 }
 
 func (m *schedulerManagerImpl) UpdateEnableSchedule(ctx context.Context, enable bool) error {
@@ -328,3 +565,136 @@ func (m *schedulerManagerImpl) ListShardAffinityRules(ctx context.Context) (map[
 
 	return rules, lastErr
 }
+
+func (m *schedulerManagerImpl) ExportShardAffinityRule(ctx context.Context) (scheduler.ShardAffinityRule, error) {
+	var emptyRule scheduler.ShardAffinityRule
+	rules, err := m.ListShardAffinityRules(ctx)
+	if err != nil {
+		return emptyRule, err
+	}
+
+	return mergeShardAffinityRules(rules), nil
+}
+
+func (m *schedulerManagerImpl) ImportShardAffinityRule(ctx context.Context, rule scheduler.ShardAffinityRule, dryRun bool) (ShardAffinityRuleDiff, error) {
+	var emptyDiff ShardAffinityRuleDiff
+	if err := validateShardAffinityRule(rule, m.clusterMetadata.GetClusterSnapshot()); err != nil {
+		return emptyDiff, err
+	}
+
+	// Hold the lock so that the concurrent imports are applied one by one.
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	previousRules := make(map[string]scheduler.ShardAffinityRule, len(m.registerSchedulers))
+	for _, scheduler := range m.registerSchedulers {
+		previousRule, err := scheduler.ListShardAffinityRule(ctx)
+		if err != nil {
+			return emptyDiff, errors.WithMessagef(err, "list shard affinity rule, scheduler:%s", scheduler.Name())
+		}
+		previousRules[scheduler.Name()] = previousRule
+	}
+
+	diff := diffShardAffinityRules(mergeShardAffinityRules(previousRules), rule)
+	if dryRun {
+		return diff, nil
+	}
+
+	for i, s := range m.registerSchedulers {
+		if err := s.ReplaceShardAffinityRule(ctx, rule); err != nil {
+			// Restore the schedulers already replaced, so the rules are never applied partially.
+			for _, replaced := range m.registerSchedulers[:i] {
+				if restoreErr := replaced.ReplaceShardAffinityRule(ctx, previousRules[replaced.Name()]); restoreErr != nil {
+					m.logger.Error("restore shard affinity rule failed", zap.String("scheduler", replaced.Name()), zap.Error(restoreErr))
+				}
+			}
+			return emptyDiff, errors.WithMessagef(err, "replace shard affinity rule, scheduler:%s", s.Name())
+		}
+	}
+
+	m.logger.Info("shard affinity rule imported", zap.Int("added", len(diff.Added)), zap.Int("removed", len(diff.Removed)), zap.Int("changed", len(diff.Changed)))
+	return diff, nil
+}
+
+func (m *schedulerManagerImpl) GetSchedulerConfig(_ context.Context) SchedulerConfig {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.schedulerConfig
+}
+
+func (m *schedulerManagerImpl) UpdateSchedulerConfig(ctx context.Context, config SchedulerConfig) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.updateSchedulerConfigWithLock(ctx, config)
+}
+
+func (m *schedulerManagerImpl) ListSchedulerStatus(_ context.Context) []SchedulerStatus {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	statuses := make([]SchedulerStatus, 0, len(m.registerSchedulers))
+	for _, scheduler := range m.registerSchedulers {
+		statuses = append(statuses, SchedulerStatus{
+			Name:    scheduler.Name(),
+			Enabled: !m.schedulerConfig.isDisabled(scheduler.Name()),
+		})
+	}
+	return statuses
+}
+
+func (m *schedulerManagerImpl) UpdateSchedulerEnabled(ctx context.Context, schedulerName string, enable bool) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	registered := false
+	for _, scheduler := range m.registerSchedulers {
+		if scheduler.Name() == schedulerName {
+			registered = true
+			break
+		}
+	}
+	if !registered {
+		return ErrInvalidSchedulerConfig.WithCausef("scheduler not found, name:%s", schedulerName)
+	}
+
+	config := m.schedulerConfig
+	disabledSchedulers := make([]string, 0, len(config.DisabledSchedulers)+1)
+	for _, name := range config.DisabledSchedulers {
+		if name != schedulerName {
+			disabledSchedulers = append(disabledSchedulers, name)
+		}
+	}
+	if !enable {
+		disabledSchedulers = append(disabledSchedulers, schedulerName)
+	}
+	config.DisabledSchedulers = disabledSchedulers
+
+	return m.updateSchedulerConfigWithLock(ctx, config)
+}
+
+func (m *schedulerManagerImpl) updateSchedulerConfigWithLock(ctx context.Context, config SchedulerConfig) error {
+	schedulerNames := make([]string, 0, len(m.registerSchedulers))
+	for _, scheduler := range m.registerSchedulers {
+		schedulerNames = append(schedulerNames, scheduler.Name())
+	}
+	if err := config.validate(schedulerNames); err != nil {
+		return err
+	}
+
+	if err := saveSchedulerConfig(ctx, m.client, m.rootPath, m.clusterMetadata.Name(), config); err != nil {
+		return errors.WithMessage(err, "save scheduler config")
+	}
+	m.schedulerConfig = config
+	m.shardOperationThrottle.UpdateThrottleConfig(config.ShardOperationThrottle)
+	m.nodePicker.UpdateShardCapacity(config.NodeShardCapacity)
+
+	select {
+	case m.configUpdated <- struct{}{}:
+	default:
+	}
+
+	m.logger.Info("scheduler config updated", zap.String("config", fmt.Sprintf("%+v", config)))
+	return nil
+}
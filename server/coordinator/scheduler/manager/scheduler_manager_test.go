@@ -20,10 +20,15 @@ import (
 	"context"
 	"testing"
 
+	"github.com/CeresDB/horaemeta/pkg/coderr"
 	"github.com/CeresDB/horaemeta/server/coordinator"
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/hotspot"
 	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/manager"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/nodepicker"
 	"github.com/CeresDB/horaemeta/server/etcdutil"
 	"github.com/CeresDB/horaemeta/server/storage"
 	"github.com/stretchr/testify/require"
@@ -38,34 +43,209 @@ func TestSchedulerManager(t *testing.T) {
 	c := test.InitStableCluster(ctx, t)
 	procedureManager, err := procedure.NewManagerImpl(zap.NewNop(), c.GetMetadata())
 	re.NoError(err)
-	dispatch := test.MockDispatch{}
-	allocator := test.MockIDAllocator{}
-	s := test.NewTestStorage(t)
-	f := coordinator.NewFactory(zap.NewNop(), allocator, dispatch, s)
+	f := coordinator.NewFactory(zap.NewNop(), test.NewMockDependencies(t))
 	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
 
 	// Create scheduler manager with enableScheduler equal to false.
-	schedulerManager := manager.NewManager(zap.NewNop(), procedureManager, f, c.GetMetadata(), client, "/rootPath", storage.TopologyTypeStatic, 1)
+	schedulerManager := manager.NewManager(zap.NewNop(), procedureManager, f, c.GetMetadata(), client, "/rootPath", storage.TopologyTypeStatic, 1, eventdispatch.NewThrottledDispatch(test.MockDispatch{}), hotspot.StaticLoads{Shards: nil, Tables: nil})
 	err = schedulerManager.Start(ctx)
 	re.NoError(err)
 	err = schedulerManager.Stop(ctx)
 	re.NoError(err)
 
 	// Create scheduler manager with static topology.
-	schedulerManager = manager.NewManager(zap.NewNop(), procedureManager, f, c.GetMetadata(), client, "/rootPath", storage.TopologyTypeStatic, 1)
+	schedulerManager = manager.NewManager(zap.NewNop(), procedureManager, f, c.GetMetadata(), client, "/rootPath", storage.TopologyTypeStatic, 1, eventdispatch.NewThrottledDispatch(test.MockDispatch{}), hotspot.StaticLoads{Shards: nil, Tables: nil})
 	err = schedulerManager.Start(ctx)
 	re.NoError(err)
 	schedulers := schedulerManager.ListScheduler()
-	re.Equal(2, len(schedulers))
+	re.Equal(3, len(schedulers))
 	err = schedulerManager.Stop(ctx)
 	re.NoError(err)
 
 	// Create scheduler manager with dynamic topology.
-	schedulerManager = manager.NewManager(zap.NewNop(), procedureManager, f, c.GetMetadata(), client, "/rootPath", storage.TopologyTypeDynamic, 1)
+	schedulerManager = manager.NewManager(zap.NewNop(), procedureManager, f, c.GetMetadata(), client, "/rootPath", storage.TopologyTypeDynamic, 1, eventdispatch.NewThrottledDispatch(test.MockDispatch{}), hotspot.StaticLoads{Shards: nil, Tables: nil})
 	err = schedulerManager.Start(ctx)
 	re.NoError(err)
 	schedulers = schedulerManager.ListScheduler()
-	re.Equal(2, len(schedulers))
+	re.Equal(4, len(schedulers))
 	err = schedulerManager.Stop(ctx)
 	re.NoError(err)
 }
+
+func TestUpdateTopologyType(t *testing.T) {
+	ctx := context.Background()
+	re := require.New(t)
+
+	c := test.InitStableCluster(ctx, t)
+	procedureManager, err := procedure.NewManagerImpl(zap.NewNop(), c.GetMetadata())
+	re.NoError(err)
+	f := coordinator.NewFactory(zap.NewNop(), test.NewMockDependencies(t))
+	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
+
+	schedulerManager := manager.NewManager(zap.NewNop(), procedureManager, f, c.GetMetadata(), client, "/rootPath", storage.TopologyTypeStatic, 1, eventdispatch.NewThrottledDispatch(test.MockDispatch{}), hotspot.StaticLoads{Shards: nil, Tables: nil})
+	re.NoError(schedulerManager.Start(ctx))
+	_, err = schedulerManager.GetEnableSchedule(ctx)
+	re.Error(err)
+	re.Equal("static_scheduler", schedulerManager.ListScheduler()[1].Name())
+
+	// The schedulers of the dynamic topology are registered instead.
+	re.NoError(schedulerManager.UpdateTopologyType(ctx, storage.TopologyTypeDynamic))
+	re.Equal(4, len(schedulerManager.ListScheduler()))
+	re.Equal("rebalanced_scheduler", schedulerManager.ListScheduler()[1].Name())
+	_, err = schedulerManager.GetEnableSchedule(ctx)
+	re.NoError(err)
+
+	re.NoError(schedulerManager.UpdateTopologyType(ctx, storage.TopologyTypeStatic))
+	re.Equal("static_scheduler", schedulerManager.ListScheduler()[1].Name())
+	re.NoError(schedulerManager.Stop(ctx))
+}
+
+func TestSchedulerConfig(t *testing.T) {
+	ctx := context.Background()
+	re := require.New(t)
+
+	c := test.InitStableCluster(ctx, t)
+	procedureManager, err := procedure.NewManagerImpl(zap.NewNop(), c.GetMetadata())
+	re.NoError(err)
+	f := coordinator.NewFactory(zap.NewNop(), test.NewMockDependencies(t))
+	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
+
+	schedulerManager := manager.NewManager(zap.NewNop(), procedureManager, f, c.GetMetadata(), client, "/rootPath", storage.TopologyTypeDynamic, 1, eventdispatch.NewThrottledDispatch(test.MockDispatch{}), hotspot.StaticLoads{Shards: nil, Tables: nil})
+	re.NoError(schedulerManager.Start(ctx))
+	re.Equal(manager.DefaultSchedulerConfig(), schedulerManager.GetSchedulerConfig(ctx))
+
+	// Invalid interval and unknown scheduler name are rejected.
+	err = schedulerManager.UpdateSchedulerConfig(ctx, manager.SchedulerConfig{IntervalMs: 1, MaxProceduresPerTick: 0, DisabledSchedulers: []string{}, NodeShortfallWebhook: "", ShardOperationThrottle: eventdispatch.DefaultShardOperationThrottleConfig(), NodeShardCapacity: nodepicker.DefaultShardCapacityConfig()})
+	re.Error(err)
+	err = schedulerManager.UpdateSchedulerConfig(ctx, manager.SchedulerConfig{IntervalMs: 1000, MaxProceduresPerTick: 0, DisabledSchedulers: []string{"unknown"}, NodeShortfallWebhook: "", ShardOperationThrottle: eventdispatch.DefaultShardOperationThrottleConfig(), NodeShardCapacity: nodepicker.DefaultShardCapacityConfig()})
+	re.Error(err)
+	err = schedulerManager.UpdateSchedulerConfig(ctx, manager.SchedulerConfig{IntervalMs: 1000, MaxProceduresPerTick: 0, DisabledSchedulers: []string{}, NodeShortfallWebhook: "ftp://autoscale", ShardOperationThrottle: eventdispatch.DefaultShardOperationThrottleConfig(), NodeShardCapacity: nodepicker.DefaultShardCapacityConfig()})
+	re.Error(err)
+	err = schedulerManager.UpdateSchedulerConfig(ctx, manager.SchedulerConfig{IntervalMs: 1000, MaxProceduresPerTick: 0, DisabledSchedulers: []string{}, NodeShortfallWebhook: "", ShardOperationThrottle: eventdispatch.ShardOperationThrottleConfig{MaxConcurrencyPerNode: 0, RatePerNode: 10, BurstPerNode: 0}, NodeShardCapacity: nodepicker.DefaultShardCapacityConfig()})
+	re.Error(err)
+	err = schedulerManager.UpdateSchedulerConfig(ctx, manager.SchedulerConfig{IntervalMs: 1000, MaxProceduresPerTick: 0, DisabledSchedulers: []string{}, NodeShortfallWebhook: "", ShardOperationThrottle: eventdispatch.DefaultShardOperationThrottleConfig(), NodeShardCapacity: nodepicker.ShardCapacityConfig{MaxShardsPerNode: 0, Overrides: []nodepicker.ShardCapacityOverride{{Labels: map[string]string{"unknown": "0"}, MaxShards: 1}}}})
+	re.Error(err)
+
+	schedulerName := schedulerManager.ListScheduler()[0].Name()
+	newConfig := manager.SchedulerConfig{IntervalMs: 1000, MaxProceduresPerTick: 2, DisabledSchedulers: []string{schedulerName}, NodeShortfallWebhook: "http://127.0.0.1:8080/autoscale", ShardOperationThrottle: eventdispatch.ShardOperationThrottleConfig{MaxConcurrencyPerNode: 4, RatePerNode: 10, BurstPerNode: 2}, NodeShardCapacity: nodepicker.ShardCapacityConfig{MaxShardsPerNode: 16, Overrides: []nodepicker.ShardCapacityOverride{{Labels: map[string]string{nodepicker.NodeLabelZone: "small"}, MaxShards: 4}}}}
+	re.NoError(schedulerManager.UpdateSchedulerConfig(ctx, newConfig))
+	re.Equal(newConfig, schedulerManager.GetSchedulerConfig(ctx))
+	re.NoError(schedulerManager.Stop(ctx))
+
+	// The persisted config is loaded when the scheduler manager starts again.
+	schedulerManager = manager.NewManager(zap.NewNop(), procedureManager, f, c.GetMetadata(), client, "/rootPath", storage.TopologyTypeDynamic, 1, eventdispatch.NewThrottledDispatch(test.MockDispatch{}), hotspot.StaticLoads{Shards: nil, Tables: nil})
+	re.NoError(schedulerManager.Start(ctx))
+	re.Equal(newConfig, schedulerManager.GetSchedulerConfig(ctx))
+	re.NoError(schedulerManager.Stop(ctx))
+}
+
+func TestUpdateSchedulerEnabled(t *testing.T) {
+	ctx := context.Background()
+	re := require.New(t)
+
+	c := test.InitStableCluster(ctx, t)
+	procedureManager, err := procedure.NewManagerImpl(zap.NewNop(), c.GetMetadata())
+	re.NoError(err)
+	f := coordinator.NewFactory(zap.NewNop(), test.NewMockDependencies(t))
+	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
+
+	schedulerManager := manager.NewManager(zap.NewNop(), procedureManager, f, c.GetMetadata(), client, "/rootPath", storage.TopologyTypeDynamic, 1, eventdispatch.NewThrottledDispatch(test.MockDispatch{}), hotspot.StaticLoads{Shards: nil, Tables: nil})
+	re.NoError(schedulerManager.Start(ctx))
+
+	statuses := schedulerManager.ListSchedulerStatus(ctx)
+	re.Equal(4, len(statuses))
+	for _, status := range statuses {
+		re.True(status.Enabled)
+	}
+
+	// Only the target scheduler is disabled.
+	target := statuses[0].Name
+	re.NoError(schedulerManager.UpdateSchedulerEnabled(ctx, target, false))
+	re.NoError(schedulerManager.UpdateSchedulerEnabled(ctx, target, false))
+	re.Equal([]string{target}, schedulerManager.GetSchedulerConfig(ctx).DisabledSchedulers)
+	for _, status := range schedulerManager.ListSchedulerStatus(ctx) {
+		re.Equal(status.Name != target, status.Enabled)
+	}
+
+	re.NoError(schedulerManager.UpdateSchedulerEnabled(ctx, target, true))
+	re.Empty(schedulerManager.GetSchedulerConfig(ctx).DisabledSchedulers)
+
+	re.Error(schedulerManager.UpdateSchedulerEnabled(ctx, "unknown", false))
+	re.NoError(schedulerManager.Stop(ctx))
+}
+
+func TestImportShardAffinityRule(t *testing.T) {
+	ctx := context.Background()
+	re := require.New(t)
+
+	c := test.InitStableCluster(ctx, t)
+	procedureManager, err := procedure.NewManagerImpl(zap.NewNop(), c.GetMetadata())
+	re.NoError(err)
+	f := coordinator.NewFactory(zap.NewNop(), test.NewMockDependencies(t))
+	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
+
+	schedulerManager := manager.NewManager(zap.NewNop(), procedureManager, f, c.GetMetadata(), client, "/rootPath", storage.TopologyTypeDynamic, 1, eventdispatch.NewThrottledDispatch(test.MockDispatch{}), hotspot.StaticLoads{Shards: nil, Tables: nil})
+	re.NoError(schedulerManager.Start(ctx))
+	defer func() {
+		re.NoError(schedulerManager.Stop(ctx))
+	}()
+
+	re.NoError(schedulerManager.AddShardAffinityRule(ctx, scheduler.ShardAffinityRule{Affinities: []scheduler.ShardAffinity{
+		{ShardID: 0, NumAllowedOtherShards: 0, Weight: 0, ExpireAt: 0},
+		{ShardID: 1, NumAllowedOtherShards: 1, Weight: 0, ExpireAt: 0},
+	}}))
+
+	rule := scheduler.ShardAffinityRule{Affinities: []scheduler.ShardAffinity{
+		{ShardID: 1, NumAllowedOtherShards: 2, Weight: 0, ExpireAt: 0},
+		{ShardID: 2, NumAllowedOtherShards: 0, Weight: 0, ExpireAt: 0},
+	}}
+	expectDiff := manager.ShardAffinityRuleDiff{
+		Added:   []scheduler.ShardAffinity{{ShardID: 2, NumAllowedOtherShards: 0, Weight: 0, ExpireAt: 0}},
+		Removed: []scheduler.ShardAffinity{{ShardID: 0, NumAllowedOtherShards: 0, Weight: 0, ExpireAt: 0}},
+		Changed: []manager.ShardAffinityChange{{ShardID: 1, OldNumAllowedOtherShards: 1, NewNumAllowedOtherShards: 2, OldWeight: 0, NewWeight: 0, OldExpireAt: 0, NewExpireAt: 0}},
+	}
+
+	// The dry run only previews the diff.
+	diff, err := schedulerManager.ImportShardAffinityRule(ctx, rule, true)
+	re.NoError(err)
+	re.Equal(expectDiff, diff)
+	exported, err := schedulerManager.ExportShardAffinityRule(ctx)
+	re.NoError(err)
+	re.Equal([]scheduler.ShardAffinity{{ShardID: 0, NumAllowedOtherShards: 0, Weight: 0, ExpireAt: 0}, {ShardID: 1, NumAllowedOtherShards: 1, Weight: 0, ExpireAt: 0}}, exported.Affinities)
+
+	diff, err = schedulerManager.ImportShardAffinityRule(ctx, rule, false)
+	re.NoError(err)
+	re.Equal(expectDiff, diff)
+	exported, err = schedulerManager.ExportShardAffinityRule(ctx)
+	re.NoError(err)
+	re.Equal(rule.Affinities, exported.Affinities)
+
+	// The invalid rule is rejected as a whole.
+	_, err = schedulerManager.ImportShardAffinityRule(ctx, scheduler.ShardAffinityRule{Affinities: []scheduler.ShardAffinity{
+		{ShardID: 0, NumAllowedOtherShards: 0, Weight: 0, ExpireAt: 0},
+		{ShardID: 10000, NumAllowedOtherShards: 0, Weight: 0, ExpireAt: 0},
+	}}, false)
+	re.True(coderr.Is(err, manager.ErrInvalidShardAffinityRule.Code()))
+	_, err = schedulerManager.ImportShardAffinityRule(ctx, scheduler.ShardAffinityRule{Affinities: []scheduler.ShardAffinity{
+		{ShardID: 0, NumAllowedOtherShards: 0, Weight: 0, ExpireAt: 0},
+		{ShardID: 0, NumAllowedOtherShards: 1, Weight: 0, ExpireAt: 0},
+	}}, false)
+	re.True(coderr.Is(err, manager.ErrInvalidShardAffinityRule.Code()))
+	exported, err = schedulerManager.ExportShardAffinityRule(ctx)
+	re.NoError(err)
+	re.Equal(rule.Affinities, exported.Affinities)
+
+	// The change of the weight is in the diff, and the expired affinity is dropped.
+	diff, err = schedulerManager.ImportShardAffinityRule(ctx, scheduler.ShardAffinityRule{Affinities: []scheduler.ShardAffinity{
+		{ShardID: 1, NumAllowedOtherShards: 2, Weight: 5, ExpireAt: 0},
+		{ShardID: 2, NumAllowedOtherShards: 0, Weight: 0, ExpireAt: 1},
+	}}, false)
+	re.NoError(err)
+	re.Equal([]manager.ShardAffinityChange{
+		{ShardID: 1, OldNumAllowedOtherShards: 2, NewNumAllowedOtherShards: 2, OldWeight: 0, NewWeight: 5, OldExpireAt: 0, NewExpireAt: 0},
+		{ShardID: 2, OldNumAllowedOtherShards: 0, NewNumAllowedOtherShards: 0, OldWeight: 0, NewWeight: 0, OldExpireAt: 0, NewExpireAt: 1},
+	}, diff.Changed)
+	exported, err = schedulerManager.ExportShardAffinityRule(ctx)
+	re.NoError(err)
+	re.Equal([]scheduler.ShardAffinity{{ShardID: 1, NumAllowedOtherShards: 2, Weight: 5, ExpireAt: 0}}, exported.Affinities)
+}
@@ -0,0 +1,128 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"sort"
+
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler"
+	"github.com/CeresDB/horaemeta/server/storage"
+)
+
+type ShardAffinityChange struct {
+	ShardID                  storage.ShardID `json:"shardID"`
+	OldNumAllowedOtherShards uint            `json:"oldNumAllowedOtherShards"`
+	NewNumAllowedOtherShards uint            `json:"newNumAllowedOtherShards"`
+	OldWeight                uint            `json:"oldWeight"`
+	NewWeight                uint            `json:"newWeight"`
+	OldExpireAt              uint64          `json:"oldExpireAt"`
+	NewExpireAt              uint64          `json:"newExpireAt"`
+}
+
+// ShardAffinityRuleDiff describes how the imported rule changes the current one, and all the affinities are sorted by the shard id.
+type ShardAffinityRuleDiff struct {
+	Added   []scheduler.ShardAffinity `json:"added"`
+	Removed []scheduler.ShardAffinity `json:"removed"`
+	Changed []ShardAffinityChange     `json:"changed"`
+}
+
+// mergeShardAffinityRules merges the rules of the schedulers into one sorted by the shard id.
+func mergeShardAffinityRules(rules map[string]scheduler.ShardAffinityRule) scheduler.ShardAffinityRule {
+	affinities := make(map[storage.ShardID]scheduler.ShardAffinity)
+	for _, rule := range rules {
+		for _, affinity := range rule.Affinities {
+			affinities[affinity.ShardID] = affinity
+		}
+	}
+
+	return scheduler.ShardAffinityRule{Affinities: sortedShardAffinities(affinities)}
+}
+
+func sortedShardAffinities(affinities map[storage.ShardID]scheduler.ShardAffinity) []scheduler.ShardAffinity {
+	sorted := make([]scheduler.ShardAffinity, 0, len(affinities))
+	for _, affinity := range affinities {
+		sorted = append(sorted, affinity)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].ShardID < sorted[j].ShardID
+	})
+	return sorted
+}
+
+// validateShardAffinityRule makes sure every shard of the rule exists in the cluster and appears only once.
+func validateShardAffinityRule(rule scheduler.ShardAffinityRule, snapshot metadata.Snapshot) error {
+	seen := make(map[storage.ShardID]struct{}, len(rule.Affinities))
+	for _, affinity := range rule.Affinities {
+		if _, ok := snapshot.Topology.ShardViewsMapping[affinity.ShardID]; !ok {
+			return ErrInvalidShardAffinityRule.WithCausef("shard not found, shardID:%d", affinity.ShardID)
+		}
+		if _, ok := seen[affinity.ShardID]; ok {
+			return ErrInvalidShardAffinityRule.WithCausef("duplicate shard, shardID:%d", affinity.ShardID)
+		}
+		seen[affinity.ShardID] = struct{}{}
+	}
+	return nil
+}
+
+func diffShardAffinityRules(current, next scheduler.ShardAffinityRule) ShardAffinityRuleDiff {
+	currentAffinities := make(map[storage.ShardID]scheduler.ShardAffinity, len(current.Affinities))
+	for _, affinity := range current.Affinities {
+		currentAffinities[affinity.ShardID] = affinity
+	}
+	nextAffinities := make(map[storage.ShardID]scheduler.ShardAffinity, len(next.Affinities))
+	for _, affinity := range next.Affinities {
+		nextAffinities[affinity.ShardID] = affinity
+	}
+
+	added := make(map[storage.ShardID]scheduler.ShardAffinity)
+	changed := make([]ShardAffinityChange, 0)
+	for shardID, affinity := range nextAffinities {
+		currentAffinity, ok := currentAffinities[shardID]
+		if !ok {
+			added[shardID] = affinity
+			continue
+		}
+		if currentAffinity != affinity {
+			changed = append(changed, ShardAffinityChange{
+				ShardID:                  shardID,
+				OldNumAllowedOtherShards: currentAffinity.NumAllowedOtherShards,
+				NewNumAllowedOtherShards: affinity.NumAllowedOtherShards,
+				OldWeight:                currentAffinity.Weight,
+				NewWeight:                affinity.Weight,
+				OldExpireAt:              currentAffinity.ExpireAt,
+				NewExpireAt:              affinity.ExpireAt,
+			})
+		}
+	}
+	sort.Slice(changed, func(i, j int) bool {
+		return changed[i].ShardID < changed[j].ShardID
+	})
+
+	removed := make(map[storage.ShardID]scheduler.ShardAffinity)
+	for shardID, affinity := range currentAffinities {
+		if _, ok := nextAffinities[shardID]; !ok {
+			removed[shardID] = affinity
+		}
+	}
+
+	return ShardAffinityRuleDiff{
+		Added:   sortedShardAffinities(added),
+		Removed: sortedShardAffinities(removed),
+		Changed: changed,
+	}
+}
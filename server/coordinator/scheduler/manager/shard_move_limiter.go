@@ -0,0 +1,109 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/storage"
+)
+
+const (
+	// pendingShardMoveTimeout bounds how long a procedure is regarded as pending, because the procedures discarded by the
+	// procedure manager for the outdated versions stay in the init state.
+	pendingShardMoveTimeout = 10 * time.Minute
+
+	// The reasons of the suppressed procedures, which are the labels of the metric too.
+	suppressReasonPending  = "pending"
+	suppressReasonCooldown = "cooldown"
+)
+
+// shardMoveLimiter suppresses the procedures generated by the schedulers for the shards which have a pending procedure or
+// are operated within the cooldown, so that a flapping node can't make the shards move back and forth.
+type shardMoveLimiter struct {
+	lock sync.Mutex
+	// pending are the latest procedures submitted for the shards.
+	pending map[storage.ShardID]shardMove
+}
+
+type shardMove struct {
+	procedure   procedure.Procedure
+	submittedAt time.Time
+}
+
+func newShardMoveLimiter() *shardMoveLimiter {
+	return &shardMoveLimiter{
+		lock:    sync.Mutex{},
+		pending: map[storage.ShardID]shardMove{},
+	}
+}
+
+// check returns the reason and the description if the procedure should be suppressed, and the second output parameter
+// bool: returns false if the procedure is allowed. Zero cooldown only suppresses the procedure with pending ones.
+func (l *shardMoveLimiter) check(p procedure.Procedure, now time.Time, cooldown time.Duration) (string, string, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for shardID := range p.RelatedVersionInfo().ShardWithVersion {
+		move, ok := l.pending[shardID]
+		if !ok {
+			continue
+		}
+		elapsed := now.Sub(move.submittedAt)
+		if move.isPending(elapsed) {
+			return suppressReasonPending, fmt.Sprintf("shard has a pending procedure, shardID:%d, procedureID:%d", shardID, move.procedure.ID()), true
+		}
+		if elapsed < cooldown {
+			return suppressReasonCooldown, fmt.Sprintf("shard is in the cooldown, shardID:%d, procedureID:%d, remaining:%s", shardID, move.procedure.ID(), cooldown-elapsed), true
+		}
+	}
+	return "", "", false
+}
+
+// record remembers the procedure submitted for its shards.
+func (l *shardMoveLimiter) record(p procedure.Procedure, now time.Time) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for shardID := range p.RelatedVersionInfo().ShardWithVersion {
+		l.pending[shardID] = shardMove{procedure: p, submittedAt: now}
+	}
+}
+
+// gc drops the moves neither pending nor in the cooldown, so that the removed shards are not kept.
+func (l *shardMoveLimiter) gc(now time.Time, cooldown time.Duration) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for shardID, move := range l.pending {
+		elapsed := now.Sub(move.submittedAt)
+		if !move.isPending(elapsed) && elapsed >= cooldown {
+			delete(l.pending, shardID)
+		}
+	}
+}
+
+func (m shardMove) isPending(elapsed time.Duration) bool {
+	if elapsed >= pendingShardMoveTimeout {
+		return false
+	}
+	state := m.procedure.State()
+	return state == procedure.StateInit || state == procedure.StateRunning
+}
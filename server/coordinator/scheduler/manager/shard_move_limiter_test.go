@@ -0,0 +1,102 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package manager
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+type mockShardProcedure struct {
+	id      uint64
+	state   procedure.State
+	shardID storage.ShardID
+}
+
+func (p *mockShardProcedure) ID() uint64 {
+	return p.id
+}
+
+func (p *mockShardProcedure) Kind() procedure.Kind {
+	return procedure.TransferLeader
+}
+
+func (p *mockShardProcedure) Start(_ context.Context) error {
+	return nil
+}
+
+func (p *mockShardProcedure) Cancel(_ context.Context) error {
+	return nil
+}
+
+func (p *mockShardProcedure) State() procedure.State {
+	return p.state
+}
+
+func (p *mockShardProcedure) RelatedVersionInfo() procedure.RelatedVersionInfo {
+	return procedure.RelatedVersionInfo{
+		ClusterID:        0,
+		ShardWithVersion: map[storage.ShardID]uint64{p.shardID: 0},
+		ClusterVersion:   0,
+	}
+}
+
+func (p *mockShardProcedure) Priority() procedure.Priority {
+	return procedure.PriorityLow
+}
+
+func TestShardMoveLimiter(t *testing.T) {
+	re := require.New(t)
+
+	limiter := newShardMoveLimiter()
+	now := time.Now()
+	cooldown := time.Minute
+	first := &mockShardProcedure{id: 1, state: procedure.StateInit, shardID: 0}
+	_, _, suppressed := limiter.check(first, now, cooldown)
+	re.False(suppressed)
+	limiter.record(first, now)
+
+	// The shard with a pending procedure is never operated again, even without the cooldown.
+	second := &mockShardProcedure{id: 2, state: procedure.StateInit, shardID: 0}
+	reason, _, suppressed := limiter.check(second, now.Add(2*cooldown), 0)
+	re.True(suppressed)
+	re.Equal(suppressReasonPending, reason)
+
+	// The other shards are not affected.
+	_, _, suppressed = limiter.check(&mockShardProcedure{id: 3, state: procedure.StateInit, shardID: 1}, now, cooldown)
+	re.False(suppressed)
+
+	first.state = procedure.StateFinished
+	reason, _, suppressed = limiter.check(second, now.Add(cooldown/2), cooldown)
+	re.True(suppressed)
+	re.Equal(suppressReasonCooldown, reason)
+	_, _, suppressed = limiter.check(second, now.Add(cooldown), cooldown)
+	re.False(suppressed)
+
+	// The procedure discarded in the init state stops being pending after the timeout.
+	first.state = procedure.StateInit
+	_, _, suppressed = limiter.check(second, now.Add(pendingShardMoveTimeout), cooldown)
+	re.False(suppressed)
+
+	limiter.gc(now.Add(pendingShardMoveTimeout), cooldown)
+	re.Empty(limiter.pending)
+}
@@ -0,0 +1,164 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nodepicker
+
+import (
+	"sort"
+
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+)
+
+const (
+	NodeLabelName    = "name"
+	NodeLabelZone    = "zone"
+	NodeLabelVersion = "version"
+)
+
+// ShardCapacityConfig limits the number of the shards assigned to a node, so that a small node doesn't get the same
+// shard count as the large ones.
+type ShardCapacityConfig struct {
+	// MaxShardsPerNode is the max number of the shards assigned to a node, and zero means no limit.
+	MaxShardsPerNode uint32 `json:"maxShardsPerNode"`
+	// Overrides replace the MaxShardsPerNode for the nodes matching their labels, and the first matched one takes effect.
+	Overrides []ShardCapacityOverride `json:"overrides"`
+}
+
+// ShardCapacityOverride matches the nodes whose labels contain all the Labels, and the supported labels are name, zone
+// and version of the node.
+type ShardCapacityOverride struct {
+	Labels map[string]string `json:"labels"`
+	// MaxShards is the max number of the shards assigned to the matched nodes, and zero means no limit.
+	MaxShards uint32 `json:"maxShards"`
+}
+
+func DefaultShardCapacityConfig() ShardCapacityConfig {
+	return ShardCapacityConfig{
+		MaxShardsPerNode: 0,
+		Overrides:        []ShardCapacityOverride{},
+	}
+}
+
+func (c ShardCapacityConfig) Validate() error {
+	for i, override := range c.Overrides {
+		if len(override.Labels) == 0 {
+			return ErrInvalidShardCapacityConfig.WithCausef("labels of the override should not be empty, index:%d", i)
+		}
+		for label := range override.Labels {
+			if label != NodeLabelName && label != NodeLabelZone && label != NodeLabelVersion {
+				return ErrInvalidShardCapacityConfig.WithCausef("unknown label, index:%d, label:%s", i, label)
+			}
+		}
+	}
+	return nil
+}
+
+func (c ShardCapacityConfig) isUnlimited() bool {
+	if c.MaxShardsPerNode > 0 {
+		return false
+	}
+	for _, override := range c.Overrides {
+		if override.MaxShards > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// NodeLabels returns the labels of the node used to match the overrides.
+func NodeLabels(node storage.Node) map[string]string {
+	return map[string]string{
+		NodeLabelName:    node.Name,
+		NodeLabelZone:    node.NodeStats.Zone,
+		NodeLabelVersion: node.NodeStats.NodeVersion,
+	}
+}
+
+// MaxShards returns the max number of the shards assigned to the node, and zero means no limit.
+func (c ShardCapacityConfig) MaxShards(node storage.Node) uint32 {
+	labels := NodeLabels(node)
+	for _, override := range c.Overrides {
+		if matchLabels(labels, override.Labels) {
+			return override.MaxShards
+		}
+	}
+	return c.MaxShardsPerNode
+}
+
+func matchLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// applyShardCapacity moves the shards exceeding the capacity of their owners to the nodes with spare capacity, and the
+// node owning the fewest shards is picked first. The owners are given in the order of the shard id and the members are
+// given in a stable order, so the result is deterministic.
+func applyShardCapacity(config ShardCapacityConfig, owners []string, members []string, aliveNodes map[string]metadata.RegisteredNode) error {
+	if config.isUnlimited() {
+		return nil
+	}
+
+	capacities := make(map[string]int, len(members))
+	counts := make(map[string]int, len(members))
+	totalCapacity := 0
+	for _, name := range members {
+		maxShards := config.MaxShards(aliveNodes[name].Node)
+		if maxShards == 0 {
+			// The node is unlimited, so the total capacity is always enough.
+			capacities[name] = len(owners)
+			totalCapacity = len(owners)
+		} else {
+			capacities[name] = int(maxShards)
+			if totalCapacity < len(owners) {
+				totalCapacity += int(maxShards)
+			}
+		}
+	}
+	if totalCapacity < len(owners) {
+		return ErrInsufficientShardCapacity.WithCausef("numTotalShards:%d, totalCapacity:%d", len(owners), totalCapacity)
+	}
+
+	overflowShards := make([]int, 0)
+	for shardID, owner := range owners {
+		if counts[owner] >= capacities[owner] {
+			overflowShards = append(overflowShards, shardID)
+			continue
+		}
+		counts[owner]++
+	}
+
+	candidates := make([]string, len(members))
+	copy(candidates, members)
+	for _, shardID := range overflowShards {
+		sort.SliceStable(candidates, func(i, j int) bool {
+			return counts[candidates[i]] < counts[candidates[j]]
+		})
+		for _, name := range candidates {
+			if counts[name] < capacities[name] {
+				owners[shardID] = name
+				counts[name]++
+				break
+			}
+		}
+	}
+
+	return nil
+}
@@ -19,3 +19,8 @@ package nodepicker
 import "github.com/CeresDB/horaemeta/pkg/coderr"
 
 var ErrNoAliveNodes = coderr.NewCodeError(coderr.InvalidParams, "no alive nodes is found")
+
+var (
+	ErrInvalidShardCapacityConfig = coderr.NewCodeError(coderr.InvalidParams, "invalid shard capacity config")
+	ErrInsufficientShardCapacity  = coderr.NewCodeError(coderr.InvalidParams, "total shard capacity of the alive nodes is insufficient")
+)
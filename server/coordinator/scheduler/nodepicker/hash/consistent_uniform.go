@@ -62,6 +62,9 @@ var (
 
 	// ErrEmptyMembers will be thrown if no member is provided.
 	ErrEmptyMembers = errors.New("at least one member is required")
+
+	// ErrInvalidMemberWeight will be thrown if the weight of any member is not positive.
+	ErrInvalidMemberWeight = errors.New("weight of the member must be positive")
 )
 
 // hashSeparator is used to building the virtual node name for member.
@@ -80,6 +83,9 @@ type Member interface {
 type PartitionAffinity struct {
 	PartitionID               int
 	NumAllowedOtherPartitions uint
+	// Weight makes the affinity soft if it is positive, and the soft affinity is only ensured without breaking the
+	// uniform distribution. The soft affinities with larger weights are ensured first.
+	Weight uint
 }
 
 // Config represents a structure to control consistent package.
@@ -94,17 +100,30 @@ type Config struct {
 
 	// The rule describes the partition affinity.
 	PartitionAffinities []PartitionAffinity
+
+	// MemberWeights makes the loads of the members proportional to their weights, and the members absent from it take
+	// the weight of 1. The distribution is uniform if it is empty.
+	MemberWeights map[string]float64
 }
 
 type virtualNode uint64
 
+// memberLoad is the bounds of the number of the partitions allocated to a member.
+type memberLoad struct {
+	minLoad int
+	maxLoad int
+}
+
 // ConsistentUniformHash generates a uniform distribution of partitions over the members, and this distribution will keep as
 // consistent as possible while the members has some tiny changes.
 type ConsistentUniformHash struct {
 	config        Config
 	minLoad       int
+	maxMinLoad    int
 	maxLoad       int
 	numPartitions uint32
+	// Member name => Load bounds of the member
+	memLoads map[string]memberLoad
 	// Member name => Member
 	members map[string]Member
 	// Member name => Partitions allocated to this member
@@ -125,6 +144,12 @@ func (c *Config) Sanitize() error {
 		return ErrInvalidReplicationFactor
 	}
 
+	for _, weight := range c.MemberWeights {
+		if !(weight > 0) || math.IsInf(weight, 1) {
+			return ErrInvalidMemberWeight
+		}
+	}
+
 	return nil
 }
 
@@ -141,13 +166,15 @@ func BuildConsistentUniformHash(numPartitions int, members []Member, config Conf
 	}
 
 	numReplicatedNodes := len(members) * config.ReplicationFactor
-	avgLoad := float64(numPartitions) / float64(len(members))
-	minLoad := int(math.Floor(avgLoad))
-	maxLoad := int(math.Ceil(avgLoad))
-
+	memLoads := computeMemberLoads(numPartitions, members, config.MemberWeights)
+	minLoad, maxMinLoad, maxLoad := numPartitions, 0, 0
 	memPartitions := make(map[string]map[int]struct{}, len(members))
 	for _, mem := range members {
-		memPartitions[mem.String()] = make(map[int]struct{}, maxLoad)
+		load := memLoads[mem.String()]
+		minLoad = min(minLoad, load.minLoad)
+		maxMinLoad = max(maxMinLoad, load.minLoad)
+		maxLoad = max(maxLoad, load.maxLoad)
+		memPartitions[mem.String()] = make(map[int]struct{}, load.maxLoad)
 	}
 
 	// Sort the affinity rule to ensure consistency.
@@ -157,8 +184,10 @@ func BuildConsistentUniformHash(numPartitions int, members []Member, config Conf
 	c := &ConsistentUniformHash{
 		config:        config,
 		minLoad:       minLoad,
+		maxMinLoad:    maxMinLoad,
 		maxLoad:       maxLoad,
 		numPartitions: uint32(numPartitions),
+		memLoads:      memLoads,
 		sortedRing:    make([]virtualNode, 0, numReplicatedNodes),
 		memPartitions: memPartitions,
 		members:       make(map[string]Member, len(members)),
@@ -172,9 +201,44 @@ func BuildConsistentUniformHash(numPartitions int, members []Member, config Conf
 	return c, nil
 }
 
-func (c *ConsistentUniformHash) distributePartitionWithLoad(partID, virtualNodeIdx int, allowedLoad int) bool {
+// computeMemberLoads splits the partitions among the members in proportion to their weights.
+func computeMemberLoads(numPartitions int, members []Member, weights map[string]float64) map[string]memberLoad {
+	weightOf := func(mem Member) float64 {
+		if weight, ok := weights[mem.String()]; ok {
+			return weight
+		}
+		return 1
+	}
+
+	totalWeight := 0.0
+	for _, mem := range members {
+		totalWeight += weightOf(mem)
+	}
+
+	memLoads := make(map[string]memberLoad, len(members))
+	for _, mem := range members {
+		avgLoad := float64(numPartitions) * weightOf(mem) / totalWeight
+		memLoads[mem.String()] = memberLoad{
+			minLoad: int(math.Floor(avgLoad)),
+			maxLoad: int(math.Ceil(avgLoad)),
+		}
+	}
+	return memLoads
+}
+
+func (c *ConsistentUniformHash) memMinLoad(mem string) int {
+	return c.memLoads[mem].minLoad
+}
+
+func (c *ConsistentUniformHash) memMaxLoad(mem string) int {
+	return c.memLoads[mem].maxLoad
+}
+
+// distributePartitionWithLoad distributes the partition to the first member whose load is allowed, and the upperBound is
+// the max of the allowed loads of all the members.
+func (c *ConsistentUniformHash) distributePartitionWithLoad(partID, virtualNodeIdx int, allowedLoad func(mem string) int, upperBound int) bool {
 	// A fast path to avoid unnecessary loop.
-	if allowedLoad == 0 {
+	if upperBound == 0 {
 		return false
 	}
 
@@ -189,7 +253,7 @@ func (c *ConsistentUniformHash) distributePartitionWithLoad(partID, virtualNodeI
 		partitions, ok := c.memPartitions[member.String()]
 		assert.Assert(ok)
 
-		if len(partitions)+1 <= allowedLoad {
+		if len(partitions)+1 <= allowedLoad(member.String()) {
 			c.partitionDist[partID] = virtualNodeIdx
 			partitions[partID] = struct{}{}
 			return true
@@ -202,12 +266,12 @@ func (c *ConsistentUniformHash) distributePartitionWithLoad(partID, virtualNodeI
 }
 
 func (c *ConsistentUniformHash) distributePartition(partID, virtualNodeIdx int) {
-	ok := c.distributePartitionWithLoad(partID, virtualNodeIdx, c.minLoad)
+	ok := c.distributePartitionWithLoad(partID, virtualNodeIdx, c.memMinLoad, c.maxMinLoad)
 	if ok {
 		return
 	}
 
-	ok = c.distributePartitionWithLoad(partID, virtualNodeIdx, c.maxLoad)
+	ok = c.distributePartitionWithLoad(partID, virtualNodeIdx, c.memMaxLoad, c.maxLoad)
 	assert.Assertf(ok, "not enough room to distribute partitions")
 }
 
@@ -286,7 +350,15 @@ func (c *ConsistentUniformHash) initializeVirtualNodes(members []Member) {
 func (c *ConsistentUniformHash) ensureAffinity() {
 	offloadedMems := make(map[string]struct{}, len(c.config.PartitionAffinities))
 
-	for _, affinity := range c.config.PartitionAffinities {
+	// The hard affinities go first, and then the soft ones by their weights.
+	affinities := slices.Clone(c.config.PartitionAffinities)
+	sort.SliceStable(affinities, func(i, j int) bool {
+		if affinities[i].Weight == 0 || affinities[j].Weight == 0 {
+			return affinities[i].Weight == 0 && affinities[j].Weight != 0
+		}
+		return affinities[i].Weight > affinities[j].Weight
+	})
+	for _, affinity := range affinities {
 		partID := affinity.PartitionID
 		vNodeIdx := c.partitionDist[partID]
 		vNode := c.sortedRing[vNodeIdx]
@@ -299,13 +371,14 @@ func (c *ConsistentUniformHash) ensureAffinity() {
 		assert.Assert(ok)
 		memLoad := len(memPartIDs)
 		if memLoad > allowedLoad {
-			c.offloadMember(mem, memPartIDs, partID, allowedLoad, offloadedMems)
+			c.offloadMember(mem, memPartIDs, partID, allowedLoad, affinity.Weight > 0, offloadedMems)
 		}
 	}
 }
 
-// offloadMember tries to offload the given member by moving its partitions to other members.
-func (c *ConsistentUniformHash) offloadMember(mem Member, memPartitions map[int]struct{}, retainedPartID, numAllowedParts int, offloadedMems map[string]struct{}) {
+// offloadMember tries to offload the given member by moving its partitions to other members, and the partitions are not
+// moved to the members beyond the max load if the affinity is soft.
+func (c *ConsistentUniformHash) offloadMember(mem Member, memPartitions map[int]struct{}, retainedPartID, numAllowedParts int, soft bool, offloadedMems map[string]struct{}) {
 	assert.Assertf(numAllowedParts >= 1, "At least the partition itself should be allowed")
 	partIDsToOffload := make([]int, 0, len(memPartitions)-numAllowedParts)
 	// The `retainedPartID` must be retained.
@@ -325,15 +398,19 @@ func (c *ConsistentUniformHash) offloadMember(mem Member, memPartitions map[int]
 
 	slices.Sort(partIDsToOffload)
 	for _, partID := range partIDsToOffload {
+		if soft {
+			c.offloadPartitionWithAllowedLoad(partID, mem, 0, offloadedMems)
+			continue
+		}
 		c.offloadPartition(partID, mem, offloadedMems)
 	}
 }
 
 func (c *ConsistentUniformHash) offloadPartition(sourcePartID int, sourceMem Member, blackedMembers map[string]struct{}) {
 	// Ensure all members' load smaller than the max load as much as possible.
-	loadUpperBound := c.numPartitions
-	for load := c.maxLoad; load < int(loadUpperBound); load++ {
-		if done := c.offloadPartitionWithAllowedLoad(sourcePartID, sourceMem, load, blackedMembers); done {
+	loadUpperBound := int(c.numPartitions) - c.maxLoad
+	for extraLoad := 0; extraLoad < loadUpperBound; extraLoad++ {
+		if done := c.offloadPartitionWithAllowedLoad(sourcePartID, sourceMem, extraLoad, blackedMembers); done {
 			return
 		}
 	}
@@ -341,7 +418,9 @@ func (c *ConsistentUniformHash) offloadPartition(sourcePartID int, sourceMem Mem
 	log.Warn("failed to offload partition")
 }
 
-func (c *ConsistentUniformHash) offloadPartitionWithAllowedLoad(sourcePartID int, sourceMem Member, allowedMaxLoad int, blackedMembers map[string]struct{}) bool {
+// offloadPartitionWithAllowedLoad moves the partition to the next member whose load doesn't exceed its max load plus the
+// extraLoad after the move.
+func (c *ConsistentUniformHash) offloadPartitionWithAllowedLoad(sourcePartID int, sourceMem Member, extraLoad int, blackedMembers map[string]struct{}) bool {
 	vNodeIdx := c.partitionDist[sourcePartID]
 	// Skip the first member which must not be the target to move.
 	for loopCnt := 1; loopCnt < len(c.sortedRing); loopCnt++ {
@@ -363,7 +442,7 @@ func (c *ConsistentUniformHash) offloadPartitionWithAllowedLoad(sourcePartID int
 		assert.Assert(ok)
 		memLoad := len(memPartitions)
 		// Check whether the member's load is too allowed.
-		if memLoad+1 > allowedMaxLoad {
+		if memLoad+1 > c.memMaxLoad(mem.String())+extraLoad {
 			continue
 		}
 
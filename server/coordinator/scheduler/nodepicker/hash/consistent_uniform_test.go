@@ -61,6 +61,7 @@ func checkUniform(t *testing.T, numPartitions, numMembers int) {
 		ReplicationFactor:   127,
 		Hasher:              testHasher{},
 		PartitionAffinities: []PartitionAffinity{},
+		MemberWeights:       nil,
 	}
 	c, err := BuildConsistentUniformHash(numPartitions, members, cfg)
 	assert.NoError(t, err)
@@ -84,6 +85,7 @@ func TestZeroReplicationFactor(t *testing.T) {
 		ReplicationFactor:   0,
 		Hasher:              testHasher{},
 		PartitionAffinities: []PartitionAffinity{},
+		MemberWeights:       nil,
 	}
 	_, err := BuildConsistentUniformHash(0, []Member{testMember("")}, cfg)
 	assert.Error(t, err)
@@ -94,6 +96,7 @@ func TestEmptyHasher(t *testing.T) {
 		Hasher:              nil,
 		ReplicationFactor:   127,
 		PartitionAffinities: []PartitionAffinity{},
+		MemberWeights:       nil,
 	}
 	_, err := BuildConsistentUniformHash(0, []Member{testMember("")}, cfg)
 	assert.Error(t, err)
@@ -104,6 +107,7 @@ func TestEmptyMembers(t *testing.T) {
 		Hasher:              testHasher{},
 		ReplicationFactor:   127,
 		PartitionAffinities: []PartitionAffinity{},
+		MemberWeights:       nil,
 	}
 	_, err := BuildConsistentUniformHash(0, []Member{}, cfg)
 	assert.Error(t, err)
@@ -114,6 +118,7 @@ func TestNegativeNumPartitions(t *testing.T) {
 		Hasher:              testHasher{},
 		ReplicationFactor:   127,
 		PartitionAffinities: []PartitionAffinity{},
+		MemberWeights:       nil,
 	}
 	_, err := BuildConsistentUniformHash(-1, []Member{testMember("")}, cfg)
 	assert.Error(t, err)
@@ -148,6 +153,7 @@ func checkConsistent(t *testing.T, numPartitions, numMembers, maxDiff int) {
 		Hasher:              testHasher{},
 		ReplicationFactor:   127,
 		PartitionAffinities: []PartitionAffinity{},
+		MemberWeights:       nil,
 	}
 	c, err := BuildConsistentUniformHash(numPartitions, members, cfg)
 	assert.NoError(t, err)
@@ -218,6 +224,7 @@ func checkAffinity(t *testing.T, numPartitions, numMembers int, affinities []Par
 		ReplicationFactor:   127,
 		Hasher:              testHasher{},
 		PartitionAffinities: affinities,
+		MemberWeights:       nil,
 	}
 	c, err := BuildConsistentUniformHash(numPartitions, members, cfg)
 	assert.NoError(t, err)
@@ -270,23 +277,23 @@ func TestAffinity(t *testing.T) {
 	checkAffinity(t, 0, 72, rule, 0)
 
 	rule = []PartitionAffinity{
-		{0, 0},
-		{1, 0},
-		{2, 120},
+		{0, 0, 0},
+		{1, 0, 0},
+		{2, 120, 0},
 	}
 	checkAffinity(t, 3, 72, rule, 0)
 	checkAffinity(t, 72, 72, rule, 0)
 
 	rule = []PartitionAffinity{
-		{7, 0},
-		{31, 0},
-		{41, 0},
-		{45, 0},
-		{58, 0},
-		{81, 0},
-		{87, 0},
-		{88, 0},
-		{89, 0},
+		{7, 0, 0},
+		{31, 0, 0},
+		{41, 0, 0},
+		{45, 0, 0},
+		{58, 0, 0},
+		{81, 0, 0},
+		{87, 0, 0},
+		{88, 0, 0},
+		{89, 0, 0},
 	}
 	checkAffinity(t, 128, 72, rule, 0)
 }
@@ -294,10 +301,10 @@ func TestAffinity(t *testing.T) {
 func TestInvalidAffinity(t *testing.T) {
 	// This affinity rule requires at least 4 member, but it should work too.
 	rule := []PartitionAffinity{
-		{0, 0},
-		{1, 0},
-		{2, 0},
-		{3, 0},
+		{0, 0, 0},
+		{1, 0, 0},
+		{2, 0, 0},
+		{3, 0, 0},
 	}
 
 	members := buildTestMembers(3)
@@ -305,7 +312,67 @@ func TestInvalidAffinity(t *testing.T) {
 		ReplicationFactor:   127,
 		Hasher:              testHasher{},
 		PartitionAffinities: rule,
+		MemberWeights:       nil,
 	}
 	_, err := BuildConsistentUniformHash(4, members, cfg)
 	assert.NoError(t, err)
 }
+
+func TestSoftAffinity(t *testing.T) {
+	members := buildTestMembers(72)
+	cfg := Config{
+		ReplicationFactor:   127,
+		Hasher:              testHasher{},
+		PartitionAffinities: []PartitionAffinity{{7, 0, 1}},
+		MemberWeights:       nil,
+	}
+	c, err := BuildConsistentUniformHash(128, members, cfg)
+	assert.NoError(t, err)
+	loadDistribution := c.LoadDistribution()
+	assert.Equal(t, uint(1), loadDistribution[c.GetPartitionOwner(7).String()])
+	for _, load := range loadDistribution {
+		assert.LessOrEqual(t, load, c.MaxLoad())
+	}
+
+	// The soft affinity can't be ensured without breaking the uniform distribution, so it is given up.
+	members = buildTestMembers(2)
+	cfg.PartitionAffinities = []PartitionAffinity{{0, 0, 1}}
+	c, err = BuildConsistentUniformHash(4, members, cfg)
+	assert.NoError(t, err)
+	for _, load := range c.LoadDistribution() {
+		assert.Equal(t, uint(2), load)
+	}
+}
+
+func TestWeightedMembers(t *testing.T) {
+	members := buildTestMembers(3)
+	cfg := Config{
+		ReplicationFactor:   127,
+		Hasher:              testHasher{},
+		PartitionAffinities: []PartitionAffinity{},
+		MemberWeights:       map[string]float64{"node-0": 2, "node-1": 1},
+	}
+	c, err := BuildConsistentUniformHash(120, members, cfg)
+	assert.NoError(t, err)
+	// The node-2 absent from the weights takes the weight of 1.
+	loadDistribution := c.LoadDistribution()
+	assert.Equal(t, uint(60), loadDistribution["node-0"])
+	assert.Equal(t, uint(30), loadDistribution["node-1"])
+	assert.Equal(t, uint(30), loadDistribution["node-2"])
+	assert.Equal(t, uint(30), c.MinLoad())
+	assert.Equal(t, uint(60), c.MaxLoad())
+
+	// The loads are bounded by the weights even if they are not divisible.
+	cfg.MemberWeights = map[string]float64{"node-0": 1.5, "node-1": 1, "node-2": 0.5}
+	c, err = BuildConsistentUniformHash(10, members, cfg)
+	assert.NoError(t, err)
+	loadDistribution = c.LoadDistribution()
+	assert.Contains(t, []uint{5, 6}, loadDistribution["node-0"])
+	assert.Contains(t, []uint{3, 4}, loadDistribution["node-1"])
+	assert.Contains(t, []uint{1, 2}, loadDistribution["node-2"])
+	assert.Equal(t, uint(10), loadDistribution["node-0"]+loadDistribution["node-1"]+loadDistribution["node-2"])
+
+	cfg.MemberWeights = map[string]float64{"node-0": 0}
+	_, err = BuildConsistentUniformHash(10, members, cfg)
+	assert.ErrorIs(t, err, ErrInvalidMemberWeight)
+}
@@ -18,9 +18,11 @@ package nodepicker
 
 import (
 	"context"
+	"sync"
 	"time"
 
 	"github.com/CeresDB/horaemeta/pkg/assert"
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator/scheduler"
 	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/nodepicker/hash"
@@ -32,6 +34,8 @@ import (
 type Config struct {
 	NumTotalShards    uint32
 	ShardAffinityRule map[storage.ShardID]scheduler.ShardAffinity
+	// CordonedNodes are excluded from the picking, so no shards are picked for them.
+	CordonedNodes map[string]struct{}
 }
 
 func (c Config) genPartitionAffinities() []hash.PartitionAffinity {
@@ -41,6 +45,7 @@ func (c Config) genPartitionAffinities() []hash.PartitionAffinity {
 		affinities = append(affinities, hash.PartitionAffinity{
 			PartitionID:               partitionID,
 			NumAllowedOtherPartitions: affinity.NumAllowedOtherShards,
+			Weight:                    affinity.Weight,
 		})
 	}
 
@@ -49,14 +54,39 @@ func (c Config) genPartitionAffinities() []hash.PartitionAffinity {
 
 type NodePicker interface {
 	PickNode(ctx context.Context, config Config, shardIDs []storage.ShardID, registerNodes []metadata.RegisteredNode) (map[storage.ShardID]metadata.RegisteredNode, error)
+	// UpdateShardCapacity replaces the shard capacity limits of the nodes taking effect in the following picks.
+	UpdateShardCapacity(config ShardCapacityConfig)
 }
 
 type ConsistentUniformHashNodePicker struct {
 	logger *zap.Logger
+	clock  clock.Clock
+
+	lock           sync.RWMutex
+	capacityConfig ShardCapacityConfig
+}
+
+func NewConsistentUniformHashNodePicker(logger *zap.Logger, clk clock.Clock) NodePicker {
+	return &ConsistentUniformHashNodePicker{
+		logger:         logger,
+		clock:          clk,
+		lock:           sync.RWMutex{},
+		capacityConfig: DefaultShardCapacityConfig(),
+	}
+}
+
+func (p *ConsistentUniformHashNodePicker) UpdateShardCapacity(config ShardCapacityConfig) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.capacityConfig = config
 }
 
-func NewConsistentUniformHashNodePicker(logger *zap.Logger) NodePicker {
-	return &ConsistentUniformHashNodePicker{logger: logger}
+func (p *ConsistentUniformHashNodePicker) getShardCapacity() ShardCapacityConfig {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+
+	return p.capacityConfig
 }
 
 type nodeMember string
@@ -75,12 +105,13 @@ func (h hasher) Sum64(data []byte) uint64 {
 	return murmur3.Sum64(data)
 }
 
-// filterExpiredNodes will retain the alive nodes only.
-func filterExpiredNodes(nodes []metadata.RegisteredNode) map[string]metadata.RegisteredNode {
-	now := time.Now()
-
+// filterSchedulableNodes will retain the nodes alive at now and not cordoned only.
+func filterSchedulableNodes(nodes []metadata.RegisteredNode, cordonedNodes map[string]struct{}, now time.Time) map[string]metadata.RegisteredNode {
 	aliveNodes := make(map[string]metadata.RegisteredNode, len(nodes))
 	for _, node := range nodes {
+		if _, cordoned := cordonedNodes[node.Node.Name]; cordoned {
+			continue
+		}
 		if !node.IsExpired(now) {
 			aliveNodes[node.Node.Name] = node
 		}
@@ -90,15 +121,17 @@ func filterExpiredNodes(nodes []metadata.RegisteredNode) map[string]metadata.Reg
 }
 
 func (p *ConsistentUniformHashNodePicker) PickNode(_ context.Context, config Config, shardIDs []storage.ShardID, registerNodes []metadata.RegisteredNode) (map[storage.ShardID]metadata.RegisteredNode, error) {
-	aliveNodes := filterExpiredNodes(registerNodes)
+	aliveNodes := filterSchedulableNodes(registerNodes, config.CordonedNodes, p.clock.Now())
 	if len(aliveNodes) == 0 {
-		return nil, ErrNoAliveNodes.WithCausef("registerNodes:%+v", registerNodes)
+		return nil, ErrNoAliveNodes.WithCausef("registerNodes:%+v, cordonedNodes:%v", registerNodes, config.CordonedNodes)
 	}
 
 	mems := make([]hash.Member, 0, len(aliveNodes))
+	memNames := make([]string, 0, len(aliveNodes))
 	for _, node := range registerNodes {
 		if _, alive := aliveNodes[node.Node.Name]; alive {
 			mems = append(mems, nodeMember(node.Node.Name))
+			memNames = append(memNames, node.Node.Name)
 		}
 	}
 
@@ -106,17 +139,26 @@ func (p *ConsistentUniformHashNodePicker) PickNode(_ context.Context, config Con
 		ReplicationFactor:   uniformHashReplicationFactor,
 		Hasher:              hasher{},
 		PartitionAffinities: config.genPartitionAffinities(),
+		MemberWeights:       genMemberWeights(aliveNodes),
 	}
 	h, err := hash.BuildConsistentUniformHash(int(config.NumTotalShards), mems, hashConf)
 	if err != nil {
 		return nil, err
 	}
 
+	owners := make([]string, config.NumTotalShards)
+	for partID := range owners {
+		owners[partID] = h.GetPartitionOwner(partID).String()
+	}
+	if err := applyShardCapacity(p.getShardCapacity(), owners, memNames, aliveNodes); err != nil {
+		return nil, err
+	}
+
 	shardNodes := make(map[storage.ShardID]metadata.RegisteredNode, len(registerNodes))
 	for _, shardID := range shardIDs {
 		assert.Assert(shardID < storage.ShardID(config.NumTotalShards))
 		partID := int(shardID)
-		nodeName := h.GetPartitionOwner(partID).String()
+		nodeName := owners[partID]
 		node, ok := aliveNodes[nodeName]
 		assert.Assertf(ok, "node:%s must be in the aliveNodes:%v", nodeName, aliveNodes)
 		shardNodes[storage.ShardID(partID)] = node
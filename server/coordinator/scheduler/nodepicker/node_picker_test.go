@@ -23,6 +23,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/nodepicker"
 	"github.com/CeresDB/horaemeta/server/storage"
@@ -40,12 +41,14 @@ func TestNodePicker(t *testing.T) {
 	re := require.New(t)
 	ctx := context.Background()
 
-	nodePicker := nodepicker.NewConsistentUniformHashNodePicker(zap.NewNop())
+	clk := clock.NewMock(time.Now())
+	nodePicker := nodepicker.NewConsistentUniformHashNodePicker(zap.NewNop(), clk)
 
 	var nodes []metadata.RegisteredNode
 	config := nodepicker.Config{
 		NumTotalShards:    defaultTotalShardNum,
 		ShardAffinityRule: nil,
+		CordonedNodes:     nil,
 	}
 	_, err := nodePicker.PickNode(ctx, config, []storage.ShardID{0}, nodes)
 	re.Error(err)
@@ -54,12 +57,13 @@ func TestNodePicker(t *testing.T) {
 		node := storage.Node{
 			Name:          strconv.Itoa(i),
 			NodeStats:     storage.NewEmptyNodeStats(),
-			LastTouchTime: generateLastTouchTime(time.Minute),
+			LastTouchTime: generateLastTouchTime(clk, time.Minute),
 			State:         storage.NodeStateUnknown,
 		}
 		nodes = append(nodes, metadata.RegisteredNode{
 			Node:       node,
 			ShardInfos: nil,
+			Heartbeat:  metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
 		})
 	}
 	_, err = nodePicker.PickNode(ctx, config, []storage.ShardID{0}, nodes)
@@ -70,12 +74,13 @@ func TestNodePicker(t *testing.T) {
 		node := storage.Node{
 			Name:          strconv.Itoa(i),
 			NodeStats:     storage.NewEmptyNodeStats(),
-			LastTouchTime: generateLastTouchTime(0),
+			LastTouchTime: generateLastTouchTime(clk, 0),
 			State:         storage.NodeStateUnknown,
 		}
 		nodes = append(nodes, metadata.RegisteredNode{
 			Node:       node,
 			ShardInfos: nil,
+			Heartbeat:  metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
 		})
 	}
 	_, err = nodePicker.PickNode(ctx, config, []storage.ShardID{0}, nodes)
@@ -86,15 +91,16 @@ func TestNodePicker(t *testing.T) {
 		node := storage.Node{
 			Name:          strconv.Itoa(i),
 			NodeStats:     storage.NewEmptyNodeStats(),
-			LastTouchTime: generateLastTouchTime(time.Minute),
+			LastTouchTime: generateLastTouchTime(clk, time.Minute),
 			State:         storage.NodeStateUnknown,
 		}
 		nodes = append(nodes, metadata.RegisteredNode{
 			Node:       node,
 			ShardInfos: nil,
+			Heartbeat:  metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
 		})
 	}
-	nodes[selectOnlineNodeIndex].Node.LastTouchTime = uint64(time.Now().UnixMilli())
+	nodes[selectOnlineNodeIndex].Node.LastTouchTime = clock.UnixMilli(clk)
 	shardNodeMapping, err := nodePicker.PickNode(ctx, config, []storage.ShardID{0}, nodes)
 	re.NoError(err)
 	re.Equal(strconv.Itoa(selectOnlineNodeIndex), shardNodeMapping[0].Node.Name)
@@ -104,7 +110,7 @@ func TestUniformity(t *testing.T) {
 	re := require.New(t)
 	ctx := context.Background()
 
-	nodePicker := nodepicker.NewConsistentUniformHashNodePicker(zap.NewNop())
+	nodePicker := nodepicker.NewConsistentUniformHashNodePicker(zap.NewNop(), clock.NewRealClock())
 	mapping := allocShards(ctx, nodePicker, 30, 256, re)
 	maxShardNum := 256/30 + 1
 	for _, shards := range mapping {
@@ -155,18 +161,150 @@ func TestUniformity(t *testing.T) {
 	}
 }
 
+func TestShardCapacity(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	nodePicker := nodepicker.NewConsistentUniformHashNodePicker(zap.NewNop(), clock.NewRealClock())
+	re.NoError(nodepicker.DefaultShardCapacityConfig().Validate())
+	re.Error(nodepicker.ShardCapacityConfig{MaxShardsPerNode: 0, Overrides: []nodepicker.ShardCapacityOverride{{Labels: map[string]string{}, MaxShards: 1}}}.Validate())
+	re.Error(nodepicker.ShardCapacityConfig{MaxShardsPerNode: 0, Overrides: []nodepicker.ShardCapacityOverride{{Labels: map[string]string{"unknown": "0"}, MaxShards: 1}}}.Validate())
+
+	// The small node is limited by the override, and the others are limited by the global setting.
+	nodePicker.UpdateShardCapacity(nodepicker.ShardCapacityConfig{
+		MaxShardsPerNode: 12,
+		Overrides:        []nodepicker.ShardCapacityOverride{{Labels: map[string]string{nodepicker.NodeLabelName: "0"}, MaxShards: 2}},
+	})
+	mapping := allocShards(ctx, nodePicker, 3, 24, re)
+	re.LessOrEqual(len(mapping["0"]), 2)
+	re.LessOrEqual(len(mapping["1"]), 12)
+	re.LessOrEqual(len(mapping["2"]), 12)
+	numShards := 0
+	for _, shards := range mapping {
+		numShards += len(shards)
+	}
+	re.Equal(24, numShards)
+
+	// The result remains unchanged through the same nodes and shards.
+	newMapping := allocShards(ctx, nodePicker, 3, 24, re)
+	for nodeName, shardIDs := range mapping {
+		re.Empty(diffShardIds(shardIDs, newMapping[nodeName]))
+	}
+
+	// The shards can't be allocated if the total capacity is insufficient.
+	nodePicker.UpdateShardCapacity(nodepicker.ShardCapacityConfig{MaxShardsPerNode: 2, Overrides: []nodepicker.ShardCapacityOverride{}})
+	shardIDs := []storage.ShardID{0}
+	nodes := []metadata.RegisteredNode{{
+		Node: storage.Node{
+			Name:          "0",
+			NodeStats:     storage.NewEmptyNodeStats(),
+			LastTouchTime: generateLastTouchTime(clock.NewRealClock(), 0),
+			State:         storage.NodeStateUnknown,
+		},
+		ShardInfos: nil,
+		Heartbeat:  metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
+	}}
+	_, err := nodePicker.PickNode(ctx, nodepicker.Config{NumTotalShards: 3, ShardAffinityRule: nil, CordonedNodes: nil}, shardIDs, nodes)
+	re.Error(err)
+}
+
+func TestWeightedNodePicker(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	nodePicker := nodepicker.NewConsistentUniformHashNodePicker(zap.NewNop(), clock.NewRealClock())
+	capacities := []storage.NodeCapacity{
+		{CPUCores: 32, MemoryBytes: 0, DiskBytes: 0},
+		{CPUCores: 16, MemoryBytes: 0, DiskBytes: 0},
+		// The node not reporting its capacity is regarded as an average one.
+		{CPUCores: 0, MemoryBytes: 0, DiskBytes: 0},
+	}
+	var nodes []metadata.RegisteredNode
+	shardIDs := make([]storage.ShardID, 0, 24)
+	for i := 0; i < 24; i++ {
+		shardIDs = append(shardIDs, storage.ShardID(i))
+	}
+	for i, capacity := range capacities {
+		nodeStats := storage.NewEmptyNodeStats()
+		nodeStats.Capacity = capacity
+		nodes = append(nodes, metadata.RegisteredNode{
+			Node: storage.Node{
+				Name:          strconv.Itoa(i),
+				NodeStats:     nodeStats,
+				LastTouchTime: generateLastTouchTime(clock.NewRealClock(), 0),
+				State:         storage.NodeStateUnknown,
+			},
+			ShardInfos: nil,
+			Heartbeat:  metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
+		})
+	}
+
+	// The weights are 4:2:3 relative to the average cpu cores.
+	shardNodeMapping, err := nodePicker.PickNode(ctx, nodepicker.Config{NumTotalShards: 24, ShardAffinityRule: nil, CordonedNodes: nil}, shardIDs, nodes)
+	re.NoError(err)
+	numShards := make(map[string]int, len(nodes))
+	for _, node := range shardNodeMapping {
+		numShards[node.Node.Name]++
+	}
+	re.Contains([]int{10, 11}, numShards["0"])
+	re.Contains([]int{5, 6}, numShards["1"])
+	re.Contains([]int{8, 9}, numShards["2"])
+}
+
+func TestCordonedNodePicker(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	nodePicker := nodepicker.NewConsistentUniformHashNodePicker(zap.NewNop(), clock.NewRealClock())
+	var nodes []metadata.RegisteredNode
+	for i := 0; i < nodeLength; i++ {
+		nodes = append(nodes, metadata.RegisteredNode{
+			Node: storage.Node{
+				Name:          strconv.Itoa(i),
+				NodeStats:     storage.NewEmptyNodeStats(),
+				LastTouchTime: generateLastTouchTime(clock.NewRealClock(), 0),
+				State:         storage.NodeStateUnknown,
+			},
+			ShardInfos: nil,
+			Heartbeat:  metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
+		})
+	}
+	shardIDs := make([]storage.ShardID, 0, defaultTotalShardNum)
+	for i := 0; i < defaultTotalShardNum; i++ {
+		shardIDs = append(shardIDs, storage.ShardID(i))
+	}
+
+	config := nodepicker.Config{
+		NumTotalShards:    defaultTotalShardNum,
+		ShardAffinityRule: nil,
+		CordonedNodes:     map[string]struct{}{"1": {}},
+	}
+	shardNodeMapping, err := nodePicker.PickNode(ctx, config, shardIDs, nodes)
+	re.NoError(err)
+	re.Len(shardNodeMapping, defaultTotalShardNum)
+	for _, node := range shardNodeMapping {
+		re.NotEqual("1", node.Node.Name)
+	}
+
+	// No shards could be picked if all the nodes are cordoned.
+	config.CordonedNodes = map[string]struct{}{"0": {}, "1": {}, "2": {}}
+	_, err = nodePicker.PickNode(ctx, config, shardIDs, nodes)
+	re.Error(err)
+}
+
 func allocShards(ctx context.Context, nodePicker nodepicker.NodePicker, nodeNum int, shardNum int, re *require.Assertions) map[string][]int {
 	var nodes []metadata.RegisteredNode
 	for i := 0; i < nodeNum; i++ {
 		node := storage.Node{
 			Name:          strconv.Itoa(i),
 			NodeStats:     storage.NewEmptyNodeStats(),
-			LastTouchTime: generateLastTouchTime(0),
+			LastTouchTime: generateLastTouchTime(clock.NewRealClock(), 0),
 			State:         storage.NodeStateUnknown,
 		}
 		nodes = append(nodes, metadata.RegisteredNode{
 			Node:       node,
 			ShardInfos: nil,
+			Heartbeat:  metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
 		})
 	}
 	mapping := make(map[string][]int, 0)
@@ -177,6 +315,7 @@ func allocShards(ctx context.Context, nodePicker nodepicker.NodePicker, nodeNum
 	config := nodepicker.Config{
 		NumTotalShards:    uint32(shardNum),
 		ShardAffinityRule: nil,
+		CordonedNodes:     nil,
 	}
 	shardNodeMapping, err := nodePicker.PickNode(ctx, config, shardIDs, nodes)
 	re.NoError(err)
@@ -187,8 +326,8 @@ func allocShards(ctx context.Context, nodePicker nodepicker.NodePicker, nodeNum
 	return mapping
 }
 
-func generateLastTouchTime(duration time.Duration) uint64 {
-	return uint64(time.Now().UnixMilli() - int64(duration))
+func generateLastTouchTime(clk clock.Clock, duration time.Duration) uint64 {
+	return clock.UnixMilli(clk) - uint64(duration.Milliseconds())
 }
 
 func diffShardIds(oldShardIDs, newShardIDs []int) []int {
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package nodepicker
+
+import (
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+)
+
+// capacityResources are the resources of the node capacity taken into account by the weights.
+var capacityResources = []func(storage.NodeCapacity) float64{
+	func(c storage.NodeCapacity) float64 { return float64(c.CPUCores) },
+	func(c storage.NodeCapacity) float64 { return float64(c.MemoryBytes) },
+	func(c storage.NodeCapacity) float64 { return float64(c.DiskBytes) },
+}
+
+// genMemberWeights derives the weights of the nodes from their reported capacity. The weight of a node is the mean of
+// its resources relative to the average of the nodes reporting the same resource, and the unreported resource of a
+// node is regarded as the average one. Nil is returned if no capacity is reported, which means uniform distribution.
+func genMemberWeights(nodes map[string]metadata.RegisteredNode) map[string]float64 {
+	avgs := make([]float64, 0, len(capacityResources))
+	numResources := 0
+	for _, resource := range capacityResources {
+		total, numReported := 0.0, 0
+		for _, node := range nodes {
+			if value := resource(node.Node.NodeStats.Capacity); value > 0 {
+				total += value
+				numReported++
+			}
+		}
+		if numReported > 0 {
+			avgs = append(avgs, total/float64(numReported))
+			numResources++
+		} else {
+			avgs = append(avgs, 0)
+		}
+	}
+	if numResources == 0 {
+		return nil
+	}
+
+	weights := make(map[string]float64, len(nodes))
+	for name, node := range nodes {
+		weight := 0.0
+		for i, resource := range capacityResources {
+			if avgs[i] == 0 {
+				continue
+			}
+			if value := resource(node.Node.NodeStats.Capacity); value > 0 {
+				weight += value / avgs[i]
+			} else {
+				weight++
+			}
+		}
+		weights[name] = weight / float64(numResources)
+	}
+	return weights
+}
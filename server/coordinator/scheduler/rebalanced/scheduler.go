@@ -24,6 +24,7 @@ import (
 	"sync"
 
 	"github.com/CeresDB/horaemeta/pkg/assert"
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
@@ -38,6 +39,7 @@ type schedulerImpl struct {
 	factory                     *coordinator.Factory
 	nodePicker                  nodepicker.NodePicker
 	procedureExecutingBatchSize uint32
+	clock                       clock.Clock
 
 	// The lock is used to protect following fields.
 	lock sync.Mutex
@@ -48,18 +50,22 @@ type schedulerImpl struct {
 	enableSchedule bool
 	// shardAffinityRule is used to control the shard distribution.
 	shardAffinityRule map[storage.ShardID]scheduler.ShardAffinity
+	// rebalanceAllowed is false outside the rebalance windows, when only the shards without online leaders are moved.
+	rebalanceAllowed bool
 }
 
-func NewShardScheduler(logger *zap.Logger, factory *coordinator.Factory, nodePicker nodepicker.NodePicker, procedureExecutingBatchSize uint32) scheduler.Scheduler {
+func NewShardScheduler(logger *zap.Logger, factory *coordinator.Factory, nodePicker nodepicker.NodePicker, procedureExecutingBatchSize uint32, clk clock.Clock) scheduler.Scheduler {
 	return &schedulerImpl{
 		logger:                      logger,
 		factory:                     factory,
 		nodePicker:                  nodePicker,
 		procedureExecutingBatchSize: procedureExecutingBatchSize,
+		clock:                       clk,
 		lock:                        sync.Mutex{},
 		latestShardNodeMapping:      map[storage.ShardID]metadata.RegisteredNode{},
 		enableSchedule:              false,
 		shardAffinityRule:           map[storage.ShardID]scheduler.ShardAffinity{},
+		rebalanceAllowed:            true,
 	}
 }
 
@@ -71,6 +77,13 @@ func (r *schedulerImpl) UpdateEnableSchedule(_ context.Context, enable bool) {
 	r.updateEnableSchedule(enable)
 }
 
+func (r *schedulerImpl) UpdateRebalanceAllowed(_ context.Context, allowed bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.rebalanceAllowed = allowed
+}
+
 func (r *schedulerImpl) AddShardAffinityRule(_ context.Context, rule scheduler.ShardAffinityRule) error {
 	r.lock.Lock()
 	defer r.lock.Unlock()
@@ -91,10 +104,25 @@ func (r *schedulerImpl) RemoveShardAffinityRule(_ context.Context, shardID stora
 	return nil
 }
 
+func (r *schedulerImpl) ReplaceShardAffinityRule(_ context.Context, rule scheduler.ShardAffinityRule) error {
+	shardAffinityRule := make(map[storage.ShardID]scheduler.ShardAffinity, len(rule.Affinities))
+	for _, shardAffinity := range rule.Affinities {
+		shardAffinityRule[shardAffinity.ShardID] = shardAffinity
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.shardAffinityRule = shardAffinityRule
+
+	return nil
+}
+
 func (r *schedulerImpl) ListShardAffinityRule(_ context.Context) (scheduler.ShardAffinityRule, error) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
 
+	r.removeExpiredShardAffinitiesWithLock()
 	affinities := make([]scheduler.ShardAffinity, 0, len(r.shardAffinityRule))
 	for _, affinity := range r.shardAffinityRule {
 		affinities = append(affinities, affinity)
@@ -119,9 +147,20 @@ func (r *schedulerImpl) Schedule(ctx context.Context, clusterSnapshot metadata.S
 		return emptySchedulerRes, nil
 	}
 
+	r.lock.Lock()
+	rebalanceAllowed := r.rebalanceAllowed
+	r.lock.Unlock()
+	onlineNodes := make(map[string]struct{}, len(clusterSnapshot.RegisteredNodes))
+	for _, node := range clusterSnapshot.RegisteredNodes {
+		if !node.IsExpired(r.clock.Now()) {
+			onlineNodes[node.Node.Name] = struct{}{}
+		}
+	}
+
 	numShards := uint32(len(clusterSnapshot.Topology.ShardViewsMapping))
 	// Generate assigned shards mapping and transfer leader if node is changed.
 	assignedShardIDs := make(map[storage.ShardID]struct{}, numShards)
+	deferredShardCount := 0
 	for _, shardNode := range clusterSnapshot.Topology.ClusterView.ShardNodes {
 		if len(procedures) >= int(r.procedureExecutingBatchSize) {
 			r.logger.Warn("procedure length reached procedure executing batch size", zap.Uint32("procedureExecutingBatchSize", r.procedureExecutingBatchSize))
@@ -133,6 +172,16 @@ func (r *schedulerImpl) Schedule(ctx context.Context, clusterSnapshot metadata.S
 		newLeaderNode, ok := shardNodeMapping[shardNode.ID]
 		assert.Assert(ok)
 		if newLeaderNode.Node.Name != shardNode.NodeName {
+			_, online := onlineNodes[shardNode.NodeName]
+			// The shards stay on the online cordoned nodes, which only receive no new shards.
+			if online && clusterSnapshot.IsCordoned(shardNode.NodeName) {
+				continue
+			}
+			// The shard served by an online node is only moved for balance, which waits for the rebalance window.
+			if online && !rebalanceAllowed {
+				deferredShardCount++
+				continue
+			}
 			r.logger.Info("rebalanced shard scheduler try to assign shard to another node", zap.Uint64("shardID", uint64(shardNode.ID)), zap.String("originNode", shardNode.NodeName), zap.String("newNode", newLeaderNode.Node.Name))
 			p, err := r.factory.CreateTransferLeaderProcedure(ctx, coordinator.TransferLeaderRequest{
 				Snapshot:          clusterSnapshot,
@@ -177,8 +226,12 @@ func (r *schedulerImpl) Schedule(ctx context.Context, clusterSnapshot metadata.S
 		}
 	}
 
+	if deferredShardCount > 0 {
+		r.logger.Debug("shard movement is deferred to the rebalance window", zap.Int("deferredShardCount", deferredShardCount))
+		reasons.WriteString(fmt.Sprintf("shard movement is deferred to the rebalance window, shardCount:%d\n", deferredShardCount))
+	}
 	if len(procedures) == 0 {
-		return emptySchedulerRes, nil
+		return scheduler.ScheduleResult{Procedure: nil, Reason: reasons.String()}, nil
 	}
 
 	batchProcedure, err := r.factory.CreateBatchTransferLeaderProcedure(ctx, coordinator.BatchRequest{
@@ -205,9 +258,11 @@ func (r *schedulerImpl) generateLatestShardNodeMapping(ctx context.Context, snap
 	var err error
 	shardNodeMapping := r.latestShardNodeMapping
 	if !r.enableSchedule {
+		r.removeExpiredShardAffinitiesWithLock()
 		pickConfig := nodepicker.Config{
 			NumTotalShards:    numShards,
 			ShardAffinityRule: maps.Clone(r.shardAffinityRule),
+			CordonedNodes:     snapshot.CordonedNodes,
 		}
 		shardNodeMapping, err = r.nodePicker.PickNode(ctx, pickConfig, shardIDs, snapshot.RegisteredNodes)
 		if err != nil {
@@ -219,6 +274,17 @@ func (r *schedulerImpl) generateLatestShardNodeMapping(ctx context.Context, snap
 	return shardNodeMapping, nil
 }
 
+// removeExpiredShardAffinitiesWithLock drops the expired affinities, so that the shards pinned temporarily are rebalanced.
+func (r *schedulerImpl) removeExpiredShardAffinitiesWithLock() {
+	now := r.clock.Now()
+	for shardID, affinity := range r.shardAffinityRule {
+		if affinity.IsExpired(now) {
+			r.logger.Info("shard affinity expired", zap.Uint32("shardID", uint32(shardID)), zap.Uint64("expireAt", affinity.ExpireAt))
+			delete(r.shardAffinityRule, shardID)
+		}
+	}
+}
+
 func (r *schedulerImpl) updateEnableSchedule(enableSchedule bool) {
 	r.lock.Lock()
 	defer r.lock.Unlock()
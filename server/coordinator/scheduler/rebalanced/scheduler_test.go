@@ -20,8 +20,10 @@ import (
 	"context"
 	"testing"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/server/coordinator"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler"
 	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/nodepicker"
 	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/rebalanced"
 	"github.com/stretchr/testify/require"
@@ -32,9 +34,9 @@ func TestRebalancedScheduler(t *testing.T) {
 	re := require.New(t)
 	ctx := context.Background()
 
-	procedureFactory := coordinator.NewFactory(zap.NewNop(), test.MockIDAllocator{}, test.MockDispatch{}, test.NewTestStorage(t))
+	procedureFactory := coordinator.NewFactory(zap.NewNop(), test.NewMockDependencies(t))
 
-	s := rebalanced.NewShardScheduler(zap.NewNop(), procedureFactory, nodepicker.NewConsistentUniformHashNodePicker(zap.NewNop()), 1)
+	s := rebalanced.NewShardScheduler(zap.NewNop(), procedureFactory, nodepicker.NewConsistentUniformHashNodePicker(zap.NewNop(), clock.NewRealClock()), 1, clock.NewRealClock())
 
 	// EmptyCluster would be scheduled an empty procedure.
 	emptyCluster := test.InitEmptyCluster(ctx, t)
@@ -52,3 +54,48 @@ func TestRebalancedScheduler(t *testing.T) {
 	_, err = s.Schedule(ctx, stableCluster.GetMetadata().GetClusterSnapshot())
 	re.NoError(err)
 }
+
+func TestRebalancedSchedulerOutsideWindow(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	procedureFactory := coordinator.NewFactory(zap.NewNop(), test.NewMockDependencies(t))
+	s := rebalanced.NewShardScheduler(zap.NewNop(), procedureFactory, nodepicker.NewConsistentUniformHashNodePicker(zap.NewNop(), clock.NewRealClock()), test.DefaultShardTotal, clock.NewRealClock())
+	snapshot := test.InitStableCluster(ctx, t).GetMetadata().GetClusterSnapshot()
+	// All the shards are served by a node, so that some of them must be moved for balance.
+	for i := range snapshot.Topology.ClusterView.ShardNodes {
+		snapshot.Topology.ClusterView.ShardNodes[i].NodeName = snapshot.RegisteredNodes[0].Node.Name
+	}
+
+	// The shards served by the online nodes are not moved outside the rebalance windows.
+	s.(scheduler.Rebalancer).UpdateRebalanceAllowed(ctx, false)
+	result, err := s.Schedule(ctx, snapshot)
+	re.NoError(err)
+	re.Nil(result.Procedure)
+	re.Contains(result.Reason, "deferred to the rebalance window")
+
+	s.(scheduler.Rebalancer).UpdateRebalanceAllowed(ctx, true)
+	result, err = s.Schedule(ctx, snapshot)
+	re.NoError(err)
+	re.NotNil(result.Procedure)
+}
+
+func TestRebalancedSchedulerCordonedNode(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	procedureFactory := coordinator.NewFactory(zap.NewNop(), test.NewMockDependencies(t))
+	s := rebalanced.NewShardScheduler(zap.NewNop(), procedureFactory, nodepicker.NewConsistentUniformHashNodePicker(zap.NewNop(), clock.NewRealClock()), test.DefaultShardTotal, clock.NewRealClock())
+	s.(scheduler.Rebalancer).UpdateRebalanceAllowed(ctx, true)
+	snapshot := test.InitStableCluster(ctx, t).GetMetadata().GetClusterSnapshot()
+	cordonedNodeName := snapshot.RegisteredNodes[0].Node.Name
+	for i := range snapshot.Topology.ClusterView.ShardNodes {
+		snapshot.Topology.ClusterView.ShardNodes[i].NodeName = cordonedNodeName
+	}
+
+	// The shards stay on the online cordoned node even if they are unbalanced.
+	snapshot.CordonedNodes = map[string]struct{}{cordonedNodeName: {}}
+	result, err := s.Schedule(ctx, snapshot)
+	re.NoError(err)
+	re.Nil(result.Procedure)
+}
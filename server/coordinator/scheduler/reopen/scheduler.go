@@ -20,8 +20,8 @@ import (
 	"context"
 	"fmt"
 	"strings"
-	"time"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
@@ -33,12 +33,14 @@ import (
 type schedulerImpl struct {
 	factory                     *coordinator.Factory
 	procedureExecutingBatchSize uint32
+	clock                       clock.Clock
 }
 
-func NewShardScheduler(factory *coordinator.Factory, procedureExecutingBatchSize uint32) scheduler.Scheduler {
+func NewShardScheduler(factory *coordinator.Factory, procedureExecutingBatchSize uint32, clk clock.Clock) scheduler.Scheduler {
 	return schedulerImpl{
 		factory:                     factory,
 		procedureExecutingBatchSize: procedureExecutingBatchSize,
+		clock:                       clk,
 	}
 }
 
@@ -58,6 +60,10 @@ func (r schedulerImpl) RemoveShardAffinityRule(_ context.Context, _ storage.Shar
 	return nil
 }
 
+func (r schedulerImpl) ReplaceShardAffinityRule(_ context.Context, _ scheduler.ShardAffinityRule) error {
+	return nil
+}
+
 func (r schedulerImpl) ListShardAffinityRule(_ context.Context) (scheduler.ShardAffinityRule, error) {
 	return scheduler.ShardAffinityRule{Affinities: []scheduler.ShardAffinity{}}, nil
 }
@@ -68,7 +74,7 @@ func (r schedulerImpl) Schedule(ctx context.Context, clusterSnapshot metadata.Sn
 	if !clusterSnapshot.Topology.IsStable() {
 		return scheduleRes, nil
 	}
-	now := time.Now()
+	now := r.clock.Now()
 
 	var procedures []procedure.Procedure
 	var reasons strings.Builder
@@ -20,6 +20,7 @@ import (
 	"context"
 	"testing"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
@@ -33,9 +34,9 @@ func TestReopenShardScheduler(t *testing.T) {
 	re := require.New(t)
 	ctx := context.Background()
 
-	procedureFactory := coordinator.NewFactory(zap.NewNop(), test.MockIDAllocator{}, test.MockDispatch{}, test.NewTestStorage(t))
+	procedureFactory := coordinator.NewFactory(zap.NewNop(), test.NewMockDependencies(t))
 
-	s := reopen.NewShardScheduler(procedureFactory, 1)
+	s := reopen.NewShardScheduler(procedureFactory, 1, clock.NewRealClock())
 
 	emptyCluster := test.InitEmptyCluster(ctx, t)
 	// ReopenShardScheduler should not schedule when cluster is not stable.
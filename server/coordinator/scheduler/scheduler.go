@@ -18,6 +18,7 @@ package scheduler
 
 import (
 	"context"
+	"time"
 
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
@@ -33,6 +34,19 @@ type ScheduleResult struct {
 type ShardAffinity struct {
 	ShardID               storage.ShardID `json:"shardID"`
 	NumAllowedOtherShards uint            `json:"numAllowedOtherShards"`
+	// Weight makes the affinity soft if it is positive, that is, the affinity is kept only if the shards can still be
+	// balanced across the nodes, and the soft ones with larger weights are kept first. Zero means a hard constraint.
+	Weight uint `json:"weight"`
+	// ExpireAt is the unix timestamp in milliseconds after which the affinity is dropped, and zero means never.
+	ExpireAt uint64 `json:"expireAt"`
+}
+
+func (a ShardAffinity) IsSoft() bool {
+	return a.Weight > 0
+}
+
+func (a ShardAffinity) IsExpired(now time.Time) bool {
+	return a.ExpireAt > 0 && uint64(now.UnixMilli()) >= a.ExpireAt
 }
 
 type ShardAffinityRule struct {
@@ -48,5 +62,15 @@ type Scheduler interface {
 	UpdateEnableSchedule(ctx context.Context, enable bool)
 	AddShardAffinityRule(ctx context.Context, rule ShardAffinityRule) error
 	RemoveShardAffinityRule(ctx context.Context, shardID storage.ShardID) error
+	// ReplaceShardAffinityRule replaces all the existing affinities with the ones in the rule.
+	ReplaceShardAffinityRule(ctx context.Context, rule ShardAffinityRule) error
 	ListShardAffinityRule(ctx context.Context) (ShardAffinityRule, error)
 }
+
+// Rebalancer is implemented by the schedulers moving the shards between the online nodes for balance, which can be
+// restricted to the rebalance windows of the cluster.
+type Rebalancer interface {
+	// UpdateRebalanceAllowed tells whether the shards served by the online nodes are allowed to be moved now, and the
+	// shards without online leaders are always allowed to be assigned.
+	UpdateRebalanceAllowed(ctx context.Context, allowed bool)
+}
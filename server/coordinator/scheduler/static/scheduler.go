@@ -24,6 +24,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
@@ -37,10 +38,11 @@ type schedulerImpl struct {
 	factory                     *coordinator.Factory
 	nodePicker                  nodepicker.NodePicker
 	procedureExecutingBatchSize uint32
+	clock                       clock.Clock
 }
 
-func NewShardScheduler(factory *coordinator.Factory, nodePicker nodepicker.NodePicker, procedureExecutingBatchSize uint32) scheduler.Scheduler {
-	return schedulerImpl{factory: factory, nodePicker: nodePicker, procedureExecutingBatchSize: procedureExecutingBatchSize}
+func NewShardScheduler(factory *coordinator.Factory, nodePicker nodepicker.NodePicker, procedureExecutingBatchSize uint32, clk clock.Clock) scheduler.Scheduler {
+	return schedulerImpl{factory: factory, nodePicker: nodePicker, procedureExecutingBatchSize: procedureExecutingBatchSize, clock: clk}
 }
 
 func (s schedulerImpl) Name() string {
@@ -59,6 +61,10 @@ func (s schedulerImpl) RemoveShardAffinityRule(_ context.Context, _ storage.Shar
 	return ErrNotImplemented.WithCausef("static topology scheduler doesn't support shard affinity")
 }
 
+func (s schedulerImpl) ReplaceShardAffinityRule(_ context.Context, _ scheduler.ShardAffinityRule) error {
+	return ErrNotImplemented.WithCausef("static topology scheduler doesn't support shard affinity")
+}
+
 func (s schedulerImpl) ListShardAffinityRule(_ context.Context) (scheduler.ShardAffinityRule, error) {
 	var emptyRule scheduler.ShardAffinityRule
 	return emptyRule, ErrNotImplemented.WithCausef("static topology scheduler doesn't support shard affinity")
@@ -84,6 +90,7 @@ func (s schedulerImpl) Schedule(ctx context.Context, clusterSnapshot metadata.Sn
 		pickConfig := nodepicker.Config{
 			NumTotalShards:    uint32(len(clusterSnapshot.Topology.ShardViewsMapping)),
 			ShardAffinityRule: map[storage.ShardID]scheduler.ShardAffinity{},
+			CordonedNodes:     clusterSnapshot.CordonedNodes,
 		}
 		// Assign shards
 		shardNodeMapping, err := s.nodePicker.PickNode(ctx, pickConfig, unassignedShardIds, clusterSnapshot.RegisteredNodes)
@@ -110,7 +117,7 @@ func (s schedulerImpl) Schedule(ctx context.Context, clusterSnapshot metadata.Sn
 	case storage.ClusterStateStable:
 		for i := 0; i < len(clusterSnapshot.Topology.ClusterView.ShardNodes); i++ {
 			shardNode := clusterSnapshot.Topology.ClusterView.ShardNodes[i]
-			node, err := findOnlineNodeByName(shardNode.NodeName, clusterSnapshot.RegisteredNodes)
+			node, err := findOnlineNodeByName(shardNode.NodeName, clusterSnapshot.RegisteredNodes, s.clock.Now())
 			if err != nil {
 				continue
 			}
@@ -149,8 +156,7 @@ func (s schedulerImpl) Schedule(ctx context.Context, clusterSnapshot metadata.Sn
 	return scheduler.ScheduleResult{Procedure: batchProcedure, Reason: reasons.String()}, nil
 }
 
-func findOnlineNodeByName(nodeName string, nodes []metadata.RegisteredNode) (metadata.RegisteredNode, error) {
-	now := time.Now()
+func findOnlineNodeByName(nodeName string, nodes []metadata.RegisteredNode, now time.Time) (metadata.RegisteredNode, error) {
 	for i := 0; i < len(nodes); i++ {
 		node := nodes[i]
 		if node.IsExpired(now) {
@@ -18,6 +18,7 @@ package coordinator
 
 import (
 	"context"
+	"math/rand"
 	"sort"
 
 	"github.com/CeresDB/horaemeta/pkg/assert"
@@ -77,3 +78,25 @@ func (l leastTableShardPicker) PickShards(_ context.Context, snapshot metadata.S
 
 	return result, nil
 }
+
+// randomShardPicker picks the shards assigned to the nodes randomly, and a shard is picked again only after all the
+// shards are picked.
+type randomShardPicker struct{}
+
+func NewRandomShardPicker() ShardPicker {
+	return &randomShardPicker{}
+}
+
+func (r randomShardPicker) PickShards(_ context.Context, snapshot metadata.Snapshot, expectShardNum int) ([]storage.ShardNode, error) {
+	shardNodes := snapshot.Topology.ClusterView.ShardNodes
+	if len(shardNodes) == 0 {
+		return nil, errors.WithMessage(ErrNodeNumberNotEnough, "no shard is assigned")
+	}
+
+	perm := rand.Perm(len(shardNodes))
+	result := make([]storage.ShardNode, 0, expectShardNum)
+	for i := 0; i < expectShardNum; i++ {
+		result = append(result, shardNodes[perm[i%len(perm)]])
+	}
+	return result, nil
+}
@@ -21,6 +21,7 @@ import (
 	"sort"
 	"testing"
 
+	"github.com/CeresDB/horaemeta/pkg/coderr"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/coordinator"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
@@ -120,3 +121,27 @@ func checkPartitionTable(ctx context.Context, shardPicker coordinator.ShardPicke
 	maxTableNumber := nodeTableNumberSlice[len(nodeTableNumberSlice)-1]
 	re.LessOrEqual(maxTableNumber-minTableNumber, maxDifference)
 }
+
+func TestRandomShardPicker(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	c := test.InitStableCluster(ctx, t)
+	snapshot := c.GetMetadata().GetClusterSnapshot()
+
+	shardPicker := coordinator.NewRandomShardPicker()
+
+	// Each shard is picked once before any shard is picked again.
+	shardNodes, err := shardPicker.PickShards(ctx, snapshot, test.DefaultShardTotal+1)
+	re.NoError(err)
+	re.Len(shardNodes, test.DefaultShardTotal+1)
+	shardIDs := map[storage.ShardID]struct{}{}
+	for _, shardNode := range shardNodes[:test.DefaultShardTotal] {
+		shardIDs[shardNode.ID] = struct{}{}
+	}
+	re.Len(shardIDs, test.DefaultShardTotal)
+
+	snapshot.Topology.ClusterView.ShardNodes = nil
+	_, err = shardPicker.PickShards(ctx, snapshot, 1)
+	re.True(coderr.Is(err, coordinator.ErrNodeNumberNotEnough.Code()))
+}
@@ -107,13 +107,15 @@ func (w *EtcdShardWatch) Start(ctx context.Context) error {
 	w.lock.Lock()
 	defer w.lock.Unlock()
 
+	// A second watch would never be canceled, because only the cancel of the last one is kept.
+	if w.isRunning {
+		return nil
+	}
+
 	shardKeyPrefix := encodeShardKeyPrefix(w.rootPath, w.clusterName, shardPath)
 	if err := w.startWatch(ctx, shardKeyPrefix); err != nil {
 		return errors.WithMessage(err, "etcd register watch failed")
 	}
-	if w.isRunning {
-		return nil
-	}
 
 	w.isRunning = true
 	return nil
@@ -123,6 +125,10 @@ func (w *EtcdShardWatch) Stop(_ context.Context) error {
 	w.lock.Lock()
 	defer w.lock.Unlock()
 
+	if !w.isRunning {
+		return nil
+	}
+
 	w.cancel()
 
 	w.isRunning = false
@@ -135,9 +141,10 @@ func (w *EtcdShardWatch) RegisteringEventCallback(eventCallback ShardEventCallba
 
 func (w *EtcdShardWatch) startWatch(ctx context.Context, path string) error {
 	w.logger.Info("register shard watch", zap.String("watchPath", path))
+	// The cancel is set before the watch goroutine runs, so that the Stop right after the Start cancels the watch too.
+	ctxWithCancel, cancel := context.WithCancel(ctx)
+	w.cancel = cancel
 	go func() {
-		ctxWithCancel, cancel := context.WithCancel(ctx)
-		w.cancel = cancel
 		respChan := w.etcdClient.Watch(ctxWithCancel, path, clientv3.WithPrefix(), clientv3.WithPrevKV())
 		for resp := range respChan {
 			for _, event := range resp.Events {
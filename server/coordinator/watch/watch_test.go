@@ -70,6 +70,23 @@ func TestWatch(t *testing.T) {
 	re.Equal(1, testCallback.result)
 }
 
+func TestWatchRestart(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	_, client, _ := etcdutil.PrepareEtcdServerAndClient(t)
+	watch := NewEtcdShardWatch(zap.NewNop(), TestClusterName, TestRootPath, client)
+	// Stopping the watch not started is a no-op.
+	re.NoError(watch.Stop(ctx))
+
+	// The watch stopped right after the start is canceled as well, and it can be started again.
+	for i := 0; i < 3; i++ {
+		re.NoError(watch.Start(ctx))
+		re.NoError(watch.Start(ctx))
+		re.NoError(watch.Stop(ctx))
+	}
+}
+
 type testShardEventCallback struct {
 	result int
 	re     *require.Assertions
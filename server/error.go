@@ -24,4 +24,6 @@ var (
 	ErrStartEtcdTimeout    = coderr.NewCodeError(coderr.Internal, "start etcd server timeout")
 	ErrStartServer         = coderr.NewCodeError(coderr.Internal, "start server")
 	ErrFlowLimiterNotFound = coderr.NewCodeError(coderr.Internal, "flow limiter not found")
+	ErrConfigLoaderNotSet  = coderr.NewCodeError(coderr.Internal, "config loader not set")
+	ErrInvalidReloadConfig = coderr.NewCodeError(coderr.InvalidParams, "invalid reloaded config")
 )
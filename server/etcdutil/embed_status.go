@@ -0,0 +1,206 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etcdutil
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/types"
+	"go.etcd.io/etcd/server/v3/etcdserver"
+	"go.uber.org/zap"
+)
+
+type EmbedAlarm struct {
+	MemberID string `json:"memberID"`
+	// Type is the type of the alarm, e.g. NOSPACE or CORRUPT.
+	Type string `json:"type"`
+}
+
+// EmbedStatus is the status of the embedded etcd server, and the alarms are those of the whole etcd cluster.
+type EmbedStatus struct {
+	MemberID           string       `json:"memberID"`
+	Name               string       `json:"name"`
+	LeaderID           string       `json:"leaderID"`
+	IsLeader           bool         `json:"isLeader"`
+	RaftTerm           uint64       `json:"raftTerm"`
+	RaftCommittedIndex uint64       `json:"raftCommittedIndex"`
+	RaftAppliedIndex   uint64       `json:"raftAppliedIndex"`
+	DBSizeBytes        int64        `json:"dbSizeBytes"`
+	DBSizeInUseBytes   int64        `json:"dbSizeInUseBytes"`
+	QuotaBackendBytes  int64        `json:"quotaBackendBytes"`
+	Alarms             []EmbedAlarm `json:"alarms"`
+}
+
+// GetEmbedStatus reads the status from the embedded etcd server directly, so it is available even if the etcd cluster
+// has no quorum.
+func GetEmbedStatus(srv *etcdserver.EtcdServer) EmbedStatus {
+	backend := srv.Backend()
+	return EmbedStatus{
+		MemberID:           srv.ID().String(),
+		Name:               srv.Cfg.Name,
+		LeaderID:           types.ID(srv.Lead()).String(),
+		IsLeader:           srv.Lead() == uint64(srv.ID()),
+		RaftTerm:           srv.Term(),
+		RaftCommittedIndex: srv.CommittedIndex(),
+		RaftAppliedIndex:   srv.AppliedIndex(),
+		DBSizeBytes:        backend.Size(),
+		DBSizeInUseBytes:   backend.SizeInUse(),
+		QuotaBackendBytes:  srv.Cfg.QuotaBackendBytes,
+		Alarms:             getEmbedAlarms(srv),
+	}
+}
+
+func getEmbedAlarms(srv *etcdserver.EtcdServer) []EmbedAlarm {
+	members := srv.Alarms()
+	alarms := make([]EmbedAlarm, 0, len(members))
+	for _, member := range members {
+		alarms = append(alarms, EmbedAlarm{
+			MemberID: types.ID(member.MemberID).String(),
+			Type:     member.Alarm.String(),
+		})
+	}
+	// The alarms are kept in a map by etcd, and they are sorted to be compared.
+	slices.SortFunc(alarms, func(a, b EmbedAlarm) int {
+		if c := cmp.Compare(a.Type, b.Type); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.MemberID, b.MemberID)
+	})
+	return alarms
+}
+
+// EmbedMonitor checks the alarms of the embedded etcd periodically, and reports the alarms once they are raised or
+// cleared, so that the server is marked as degraded while etcd rejects the writes.
+type EmbedMonitor struct {
+	logger   *zap.Logger
+	srv      *etcdserver.EtcdServer
+	interval time.Duration
+	// onAlarms is called with the current alarms whenever they change, and the alarms are empty once cleared.
+	onAlarms func(alarms []EmbedAlarm)
+
+	// Protect the fields below.
+	lock   sync.Mutex
+	alarms string
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewEmbedMonitor(logger *zap.Logger, srv *etcdserver.EtcdServer, interval time.Duration, onAlarms func(alarms []EmbedAlarm)) *EmbedMonitor {
+	return &EmbedMonitor{
+		logger:   logger,
+		srv:      srv,
+		interval: interval,
+		onAlarms: onAlarms,
+		lock:     sync.Mutex{},
+		alarms:   "",
+		cancel:   nil,
+		done:     nil,
+	}
+}
+
+func (m *EmbedMonitor) Start() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.cancel != nil || m.interval <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	go m.run(ctx, m.done)
+
+	m.logger.Info("embedded etcd monitor started", zap.Duration("interval", m.interval))
+}
+
+// Stop stops checking the alarms, and it should be called before the embedded etcd is closed.
+func (m *EmbedMonitor) Stop() {
+	m.lock.Lock()
+	if m.cancel == nil {
+		m.lock.Unlock()
+		return
+	}
+	cancel, done := m.cancel, m.done
+	m.cancel, m.done = nil, nil
+	m.lock.Unlock()
+
+	cancel()
+	<-done
+	m.logger.Info("embedded etcd monitor stopped")
+}
+
+func (m *EmbedMonitor) Status() EmbedStatus {
+	return GetEmbedStatus(m.srv)
+}
+
+func (m *EmbedMonitor) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	m.check()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.check()
+		}
+	}
+}
+
+func (m *EmbedMonitor) check() {
+	alarms := getEmbedAlarms(m.srv)
+
+	embedEtcdAlarms.Reset()
+	for _, alarm := range alarms {
+		embedEtcdAlarms.WithLabelValues(alarm.Type).Inc()
+	}
+
+	desc := DescribeEmbedAlarms(alarms)
+	m.lock.Lock()
+	changed := desc != m.alarms
+	m.alarms = desc
+	m.lock.Unlock()
+	if !changed {
+		return
+	}
+
+	if len(alarms) > 0 {
+		m.logger.Error("embedded etcd raises alarms", zap.String("alarms", desc))
+	} else {
+		m.logger.Info("alarms of embedded etcd are cleared")
+	}
+	m.onAlarms(alarms)
+}
+
+// DescribeEmbedAlarms describes the alarms like "NOSPACE(member:8e9e05c52164694d)", and it is empty if no alarm.
+func DescribeEmbedAlarms(alarms []EmbedAlarm) string {
+	descs := make([]string, 0, len(alarms))
+	for _, alarm := range alarms {
+		descs = append(descs, fmt.Sprintf("%s(member:%s)", alarm.Type, alarm.MemberID))
+	}
+	return strings.Join(descs, ",")
+}
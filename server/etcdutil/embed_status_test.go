@@ -0,0 +1,96 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etcdutil
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+func TestEmbedMonitor(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	cfg := NewTestSingleConfig()
+	// The quota is small enough to raise the NOSPACE alarm by a few writes.
+	cfg.QuotaBackendBytes = 1 << 20
+	etcd, err := embed.StartEtcd(cfg)
+	re.NoError(err)
+	defer CleanConfig(cfg)
+	defer etcd.Close()
+	<-etcd.Server.ReadyNotify()
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints: []string{cfg.LCUrls[0].String()},
+	})
+	re.NoError(err)
+	defer client.Close()
+
+	var reported [][]EmbedAlarm
+	m := NewEmbedMonitor(log.GetLogger(), etcd.Server, time.Hour, func(alarms []EmbedAlarm) {
+		reported = append(reported, alarms)
+	})
+
+	status := m.Status()
+	re.Equal(etcd.Server.ID().String(), status.MemberID)
+	re.Equal(cfg.Name, status.Name)
+	re.True(status.IsLeader)
+	re.Equal(status.MemberID, status.LeaderID)
+	re.Positive(status.RaftTerm)
+	re.Positive(status.DBSizeBytes)
+	re.Equal(int64(1<<20), status.QuotaBackendBytes)
+	re.Empty(status.Alarms)
+
+	// Nothing is reported without alarms.
+	m.check()
+	re.Empty(reported)
+
+	value := strings.Repeat("v", 64*1024)
+	for i := 0; ; i++ {
+		_, err := client.Put(ctx, fmt.Sprintf("key%d", i), value)
+		if err != nil {
+			re.ErrorIs(err, rpctypes.ErrNoSpace)
+			break
+		}
+		re.Less(i, 1000, "NOSPACE alarm is not raised")
+	}
+
+	m.check()
+	expected := []EmbedAlarm{{MemberID: status.MemberID, Type: etcdserverpb.AlarmType_NOSPACE.String()}}
+	re.Equal([][]EmbedAlarm{expected}, reported)
+	re.Equal(expected, m.Status().Alarms)
+	re.Equal(fmt.Sprintf("NOSPACE(member:%s)", status.MemberID), DescribeEmbedAlarms(expected))
+	// The unchanged alarms are not reported again.
+	m.check()
+	re.Len(reported, 1)
+
+	_, err = client.AlarmDisarm(ctx, &clientv3.AlarmMember{MemberID: uint64(etcd.Server.ID()), Alarm: etcdserverpb.AlarmType_NOSPACE})
+	re.NoError(err)
+	m.check()
+	re.Len(reported, 2)
+	re.Empty(reported[1])
+}
@@ -0,0 +1,329 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etcdutil
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+)
+
+// dialBackoffMultiplier and dialBackoffJitter are the defaults of grpc, and only the delays are configurable.
+const (
+	dialBackoffMultiplier = 1.6
+	dialBackoffJitter     = 0.2
+)
+
+type EndpointOptions struct {
+	// AutoSyncInterval is the interval to refresh the endpoints with the client urls of the members of etcd, and zero
+	// keeps the endpoints given at startup.
+	AutoSyncInterval time.Duration
+	// HealthCheckInterval is the interval to check the endpoint in use, which is rotated to the next healthy one if it is
+	// unhealthy, and zero disables the rotation, i.e. the requests are balanced among all the endpoints by the client.
+	HealthCheckInterval time.Duration
+	// HealthCheckTimeout bounds the check of each endpoint.
+	HealthCheckTimeout time.Duration
+}
+
+// DialBackoffOptions returns the dial options making the client reconnect to etcd with the exponential backoff between
+// the delays, and the dial is given the timeout at least for each attempt.
+func DialBackoffOptions(baseDelay, maxDelay, dialTimeout time.Duration) []grpc.DialOption {
+	return []grpc.DialOption{grpc.WithConnectParams(grpc.ConnectParams{
+		Backoff: backoff.Config{
+			BaseDelay:  baseDelay,
+			Multiplier: dialBackoffMultiplier,
+			Jitter:     dialBackoffJitter,
+			MaxDelay:   maxDelay,
+		},
+		MinConnectTimeout: dialTimeout,
+	})}
+}
+
+type EndpointStatus struct {
+	// Endpoints are all the known endpoints, which are refreshed by the auto sync.
+	Endpoints []string `json:"endpoints"`
+	// CurrentEndpoint is the endpoint the client is pinned to, and empty if the client uses all the endpoints.
+	CurrentEndpoint string `json:"currentEndpoint"`
+	// CurrentMemberID and CurrentMemberName describe the member of etcd serving the current endpoint.
+	CurrentMemberID   string    `json:"currentMemberID"`
+	CurrentMemberName string    `json:"currentMemberName"`
+	Healthy           bool      `json:"healthy"`
+	Rotations         int       `json:"rotations"`
+	LastCheckTime     time.Time `json:"lastCheckTime"`
+	LastCheckError    string    `json:"lastCheckError"`
+	LastSyncTime      time.Time `json:"lastSyncTime"`
+	LastSyncError     string    `json:"lastSyncError"`
+}
+
+// EndpointManager keeps the etcd client on a healthy endpoint, so that the server fails over to another member once the
+// member in use is down, and keeps the endpoints up to date with the members of etcd.
+//
+// The auto sync of the client itself is not used, because it resets the endpoints the client is pinned to.
+type EndpointManager struct {
+	logger *zap.Logger
+	client *clientv3.Client
+	opts   EndpointOptions
+	clock  clock.Clock
+
+	// Protect the fields below.
+	lock   sync.RWMutex
+	status EndpointStatus
+	// memberNames are the names of the members of etcd keyed by their client urls.
+	memberNames map[string]string
+	cancel      context.CancelFunc
+	done        chan struct{}
+}
+
+func NewEndpointManager(logger *zap.Logger, client *clientv3.Client, opts EndpointOptions, clk clock.Clock) *EndpointManager {
+	return &EndpointManager{
+		logger: logger,
+		client: client,
+		opts:   opts,
+		clock:  clk,
+		lock:   sync.RWMutex{},
+		status: EndpointStatus{
+			Endpoints:         slices.Clone(client.Endpoints()),
+			CurrentEndpoint:   "",
+			CurrentMemberID:   "",
+			CurrentMemberName: "",
+			Healthy:           false,
+			Rotations:         0,
+			LastCheckTime:     time.Time{},
+			LastCheckError:    "",
+			LastSyncTime:      time.Time{},
+			LastSyncError:     "",
+		},
+		memberNames: map[string]string{},
+		cancel:      nil,
+		done:        nil,
+	}
+}
+
+// Start syncs the endpoints and checks the endpoint in use in background, if any of them is enabled.
+func (m *EndpointManager) Start() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.cancel != nil || (m.opts.AutoSyncInterval <= 0 && m.opts.HealthCheckInterval <= 0) {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	go m.run(ctx, m.done)
+
+	m.logger.Info("etcd endpoint manager started", zap.Strings("endpoints", m.status.Endpoints), zap.Duration("autoSyncInterval", m.opts.AutoSyncInterval), zap.Duration("healthCheckInterval", m.opts.HealthCheckInterval))
+}
+
+// Stop stops the background sync and check, and it should be called before the client is closed.
+func (m *EndpointManager) Stop() {
+	m.lock.Lock()
+	if m.cancel == nil {
+		m.lock.Unlock()
+		return
+	}
+	cancel, done := m.cancel, m.done
+	m.cancel, m.done = nil, nil
+	m.lock.Unlock()
+
+	cancel()
+	<-done
+	m.logger.Info("etcd endpoint manager stopped")
+}
+
+func (m *EndpointManager) Status() EndpointStatus {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	status := m.status
+	status.Endpoints = slices.Clone(m.status.Endpoints)
+	return status
+}
+
+func (m *EndpointManager) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	// The tickers of the disabled tasks never fire.
+	syncC, checkC := make(<-chan time.Time), make(<-chan time.Time)
+	if m.opts.AutoSyncInterval > 0 {
+		ticker := time.NewTicker(m.opts.AutoSyncInterval)
+		defer ticker.Stop()
+		syncC = ticker.C
+		m.sync(ctx)
+	}
+	if m.opts.HealthCheckInterval > 0 {
+		ticker := time.NewTicker(m.opts.HealthCheckInterval)
+		defer ticker.Stop()
+		checkC = ticker.C
+		m.check(ctx)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-syncC:
+			m.sync(ctx)
+		case <-checkC:
+			m.check(ctx)
+		}
+	}
+}
+
+// sync refreshes the endpoints with the client urls of the started members, and the learners are excluded because they
+// serve no requests.
+func (m *EndpointManager) sync(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(ctx, m.opts.HealthCheckTimeout)
+	defer cancel()
+
+	resp, err := m.client.MemberList(ctx)
+	now := m.clock.Now()
+	if err != nil {
+		m.logger.Warn("sync etcd endpoints failed", zap.Error(err))
+		m.lock.Lock()
+		m.status.LastSyncTime = now
+		m.status.LastSyncError = err.Error()
+		m.lock.Unlock()
+		return
+	}
+
+	var endpoints []string
+	memberNames := make(map[string]string)
+	for _, member := range resp.Members {
+		if member.IsLearner {
+			continue
+		}
+		for _, url := range member.ClientURLs {
+			endpoints = append(endpoints, url)
+			memberNames[url] = member.Name
+		}
+	}
+	slices.Sort(endpoints)
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.status.LastSyncTime = now
+	m.status.LastSyncError = ""
+	// The members without the client urls are not started yet, and the known endpoints are kept if none is started.
+	if len(endpoints) == 0 {
+		return
+	}
+	m.memberNames = memberNames
+	if !slices.Equal(endpoints, m.status.Endpoints) {
+		m.logger.Info("etcd endpoints are synced", zap.Strings("prevEndpoints", m.status.Endpoints), zap.Strings("endpoints", endpoints))
+		m.status.Endpoints = endpoints
+		// The pinned endpoint is rotated by the next check if it is removed, and the client keeps all the endpoints
+		// otherwise.
+		if len(m.status.CurrentEndpoint) == 0 {
+			m.client.SetEndpoints(endpoints...)
+		}
+	}
+}
+
+// check pins the client to the current endpoint if it is healthy, or to the next healthy endpoint otherwise. The client
+// is given all the endpoints if none of them is healthy, and it tries them by itself.
+func (m *EndpointManager) check(ctx context.Context) {
+	m.lock.RLock()
+	endpoints := slices.Clone(m.status.Endpoints)
+	current := m.status.CurrentEndpoint
+	m.lock.RUnlock()
+
+	if len(endpoints) == 0 {
+		return
+	}
+
+	// Try the current endpoint first, and then the others in order after it.
+	start := max(slices.Index(endpoints, current), 0)
+	candidates := make([]string, 0, len(endpoints))
+	for i := range endpoints {
+		candidates = append(candidates, endpoints[(start+i)%len(endpoints)])
+	}
+
+	var lastErr error
+	for _, endpoint := range candidates {
+		memberID, err := m.checkEndpoint(ctx, endpoint)
+		if err != nil {
+			m.logger.Warn("etcd endpoint is unhealthy", zap.String("endpoint", endpoint), zap.Error(err))
+			lastErr = err
+			continue
+		}
+		m.pin(endpoint, memberID, current)
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.logger.Error("no healthy etcd endpoint, use all the endpoints", zap.Strings("endpoints", endpoints), zap.Error(lastErr))
+	m.client.SetEndpoints(endpoints...)
+	m.status.CurrentEndpoint = ""
+	m.status.CurrentMemberID = ""
+	m.status.CurrentMemberName = ""
+	m.status.Healthy = false
+	m.status.LastCheckTime = m.clock.Now()
+	m.status.LastCheckError = ""
+	if lastErr != nil {
+		m.status.LastCheckError = lastErr.Error()
+	}
+}
+
+func (m *EndpointManager) checkEndpoint(ctx context.Context, endpoint string) (uint64, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.opts.HealthCheckTimeout)
+	defer cancel()
+
+	resp, err := m.client.Status(ctx, endpoint)
+	if err != nil {
+		return 0, err
+	}
+	if len(resp.Errors) > 0 {
+		return 0, ErrEtcdEndpointUnhealthy.WithCausef("endpoint:%s, errors:%v", endpoint, resp.Errors)
+	}
+	return resp.Header.MemberId, nil
+}
+
+func (m *EndpointManager) pin(endpoint string, memberID uint64, prevEndpoint string) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if endpoint != prevEndpoint {
+		m.client.SetEndpoints(endpoint)
+		if len(prevEndpoint) > 0 {
+			m.status.Rotations++
+			m.logger.Warn("etcd endpoint is rotated", zap.String("prevEndpoint", prevEndpoint), zap.String("endpoint", endpoint))
+		} else {
+			m.logger.Info("etcd client is pinned to endpoint", zap.String("endpoint", endpoint))
+		}
+	}
+	m.status.CurrentEndpoint = endpoint
+	m.status.CurrentMemberID = strconv.FormatUint(memberID, 16)
+	m.status.CurrentMemberName = m.memberNames[endpoint]
+	m.status.Healthy = true
+	m.status.LastCheckTime = m.clock.Now()
+	m.status.LastCheckError = ""
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etcdutil
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointManager(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	etcd, client, closeSrv := PrepareEtcdServerAndClient(t)
+	defer closeSrv()
+
+	healthy := etcd.Config().ACUrls[0].String()
+	// Nothing listens on the port, so the endpoint is always unhealthy.
+	unhealthy := "http://127.0.0.1:1"
+	m := NewEndpointManager(log.GetLogger(), client, EndpointOptions{
+		AutoSyncInterval:    0,
+		HealthCheckInterval: 0,
+		HealthCheckTimeout:  time.Second,
+	}, clock.NewRealClock())
+
+	// The client is pinned to the unhealthy endpoint, and it is rotated to the healthy one.
+	m.status.Endpoints = []string{unhealthy, healthy}
+	m.status.CurrentEndpoint = unhealthy
+	m.check(ctx)
+	status := m.Status()
+	re.True(status.Healthy)
+	re.Equal(healthy, status.CurrentEndpoint)
+	re.Equal(etcd.Server.ID().String(), status.CurrentMemberID)
+	re.Equal(1, status.Rotations)
+	re.Equal([]string{healthy}, client.Endpoints())
+	_, err := client.Get(ctx, "key")
+	re.NoError(err)
+
+	// The sync drops the unknown endpoint, and the pinned endpoint is kept.
+	m.sync(ctx)
+	status = m.Status()
+	re.Empty(status.LastSyncError)
+	re.Equal([]string{healthy}, status.Endpoints)
+	m.check(ctx)
+	status = m.Status()
+	re.Equal(healthy, status.CurrentEndpoint)
+	re.Equal(etcd.Config().Name, status.CurrentMemberName)
+	re.Equal(1, status.Rotations)
+
+	// No endpoint is healthy, and the client is given all the endpoints.
+	m.status.Endpoints = []string{unhealthy}
+	m.check(ctx)
+	status = m.Status()
+	re.False(status.Healthy)
+	re.Empty(status.CurrentEndpoint)
+	re.NotEmpty(status.LastCheckError)
+	re.Equal([]string{unhealthy}, client.Endpoints())
+}
+
+func TestEndpointManagerStartStop(t *testing.T) {
+	re := require.New(t)
+
+	_, client, closeSrv := PrepareEtcdServerAndClient(t)
+	defer closeSrv()
+
+	m := NewEndpointManager(log.GetLogger(), client, EndpointOptions{
+		AutoSyncInterval:    time.Hour,
+		HealthCheckInterval: time.Hour,
+		HealthCheckTimeout:  time.Second,
+	}, clock.NewRealClock())
+	m.Start()
+	// Start is idempotent, and the endpoints are synced and checked once started.
+	m.Start()
+	re.Eventually(func() bool {
+		return m.Status().Healthy
+	}, 5*time.Second, 10*time.Millisecond)
+	m.Stop()
+	m.Stop()
+	re.False(m.Status().LastSyncTime.IsZero())
+}
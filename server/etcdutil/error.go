@@ -19,7 +19,11 @@ package etcdutil
 import "github.com/CeresDB/horaemeta/pkg/coderr"
 
 var (
-	ErrEtcdKVGet         = coderr.NewCodeError(coderr.Internal, "etcd KV get failed")
-	ErrEtcdKVGetResponse = coderr.NewCodeError(coderr.Internal, "etcd invalid get value response must only one")
-	ErrEtcdKVGetNotFound = coderr.NewCodeError(coderr.Internal, "etcd KV get value not found")
+	ErrEtcdKVGet             = coderr.NewCodeError(coderr.Internal, "etcd KV get failed")
+	ErrEtcdKVGetResponse     = coderr.NewCodeError(coderr.Internal, "etcd invalid get value response must only one")
+	ErrEtcdKVGetNotFound     = coderr.NewCodeError(coderr.Internal, "etcd KV get value not found")
+	ErrEtcdCompact           = coderr.NewCodeError(coderr.Internal, "etcd compact failed")
+	ErrEtcdDefragment        = coderr.NewCodeError(coderr.Internal, "etcd defragment failed")
+	ErrInvalidDefragWindow   = coderr.NewCodeError(coderr.InvalidParams, "invalid etcd defragment window")
+	ErrEtcdEndpointUnhealthy = coderr.NewCodeError(coderr.Internal, "etcd endpoint is unhealthy")
 )
@@ -0,0 +1,299 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etcdutil
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const minutesPerDay = 24 * 60
+
+// DefragWindow is the daily window in UTC in which the members of etcd are allowed to be defragmented, and it wraps around
+// the midnight if the end is before the start.
+type DefragWindow struct {
+	startMinute int
+	endMinute   int
+}
+
+// ParseDefragWindow parses the window in the format of "HH:MM-HH:MM", e.g. "02:00-04:00" or "23:30-01:00".
+func ParseDefragWindow(window string) (DefragWindow, error) {
+	start, end, found := strings.Cut(window, "-")
+	if !found {
+		return DefragWindow{startMinute: 0, endMinute: 0}, errors.WithMessagef(ErrInvalidDefragWindow, "window:%s", window)
+	}
+
+	startMinute, err := parseMinuteOfDay(start)
+	if err != nil {
+		return DefragWindow{startMinute: 0, endMinute: 0}, errors.WithMessagef(err, "window:%s", window)
+	}
+	endMinute, err := parseMinuteOfDay(end)
+	if err != nil {
+		return DefragWindow{startMinute: 0, endMinute: 0}, errors.WithMessagef(err, "window:%s", window)
+	}
+	if startMinute == endMinute {
+		return DefragWindow{startMinute: 0, endMinute: 0}, errors.WithMessagef(ErrInvalidDefragWindow, "empty window:%s", window)
+	}
+
+	return DefragWindow{startMinute: startMinute, endMinute: endMinute}, nil
+}
+
+func parseMinuteOfDay(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, errors.WithMessagef(ErrInvalidDefragWindow, "parse time:%s, err:%v", s, err)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+func (w DefragWindow) String() string {
+	return fmt.Sprintf("%02d:%02d-%02d:%02d", w.startMinute/60, w.startMinute%60, w.endMinute/60, w.endMinute%60)
+}
+
+// windowStart returns the start of the window containing the time, the second output parameter bool: returns true if the
+// time is in the window.
+func (w DefragWindow) windowStart(t time.Time) (time.Time, bool) {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	minute := t.Hour()*60 + t.Minute()
+
+	if w.startMinute < w.endMinute {
+		if minute >= w.startMinute && minute < w.endMinute {
+			return midnight.Add(time.Duration(w.startMinute) * time.Minute), true
+		}
+		return time.Time{}, false
+	}
+
+	// The window wraps around the midnight.
+	if minute >= w.startMinute {
+		return midnight.Add(time.Duration(w.startMinute) * time.Minute), true
+	}
+	if minute < w.endMinute {
+		return midnight.Add(time.Duration(w.startMinute-minutesPerDay) * time.Minute), true
+	}
+	return time.Time{}, false
+}
+
+type MaintenanceOptions struct {
+	// CompactionInterval is the interval to compact the revisions, and the maintenance is disabled if it is zero.
+	CompactionInterval time.Duration
+	// RetainedRevisions is the number of the latest revisions kept by the compaction.
+	RetainedRevisions int64
+	// DefragWindow is nil if the defragmentation is disabled.
+	DefragWindow *DefragWindow
+}
+
+type MaintenanceStatus struct {
+	Enabled               bool      `json:"enabled"`
+	Running               bool      `json:"running"`
+	CompactionInterval    string    `json:"compactionInterval"`
+	RetainedRevisions     int64     `json:"retainedRevisions"`
+	DefragWindow          string    `json:"defragWindow"`
+	LastCompactionTime    time.Time `json:"lastCompactionTime"`
+	LastCompactedRevision int64     `json:"lastCompactedRevision"`
+	LastCompactionError   string    `json:"lastCompactionError"`
+	LastDefragTime        time.Time `json:"lastDefragTime"`
+	LastDefragError       string    `json:"lastDefragError"`
+}
+
+// Maintainer compacts the revisions of etcd periodically, and defragments the members once a day in the defragment window
+// after the compaction. It should only be run by the leader, so that the members are not compacted or defragmented by
+// several servers at the same time.
+type Maintainer struct {
+	logger *zap.Logger
+	client *clientv3.Client
+	opts   MaintenanceOptions
+	clock  clock.Clock
+
+	// Protect the fields below.
+	lock   sync.RWMutex
+	status MaintenanceStatus
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func NewMaintainer(logger *zap.Logger, client *clientv3.Client, opts MaintenanceOptions, clk clock.Clock) *Maintainer {
+	defragWindow := ""
+	if opts.DefragWindow != nil {
+		defragWindow = opts.DefragWindow.String()
+	}
+
+	return &Maintainer{
+		logger: logger,
+		client: client,
+		opts:   opts,
+		clock:  clk,
+		lock:   sync.RWMutex{},
+		status: MaintenanceStatus{
+			Enabled:               opts.CompactionInterval > 0,
+			Running:               false,
+			CompactionInterval:    opts.CompactionInterval.String(),
+			RetainedRevisions:     opts.RetainedRevisions,
+			DefragWindow:          defragWindow,
+			LastCompactionTime:    time.Time{},
+			LastCompactedRevision: 0,
+			LastCompactionError:   "",
+			LastDefragTime:        time.Time{},
+			LastDefragError:       "",
+		},
+		cancel: nil,
+		done:   nil,
+	}
+}
+
+// Start starts the maintenance in background if it is enabled and not started yet.
+func (m *Maintainer) Start() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if !m.status.Enabled || m.status.Running {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+	m.status.Running = true
+	go m.run(ctx, m.done)
+
+	m.logger.Info("etcd maintenance started", zap.Duration("compactionInterval", m.opts.CompactionInterval), zap.Int64("retainedRevisions", m.opts.RetainedRevisions), zap.String("defragWindow", m.status.DefragWindow))
+}
+
+// Stop stops the maintenance and waits for the ongoing compaction or defragmentation to be cancelled.
+func (m *Maintainer) Stop() {
+	m.lock.Lock()
+	if !m.status.Running {
+		m.lock.Unlock()
+		return
+	}
+	cancel, done := m.cancel, m.done
+	m.cancel, m.done = nil, nil
+	m.status.Running = false
+	m.lock.Unlock()
+
+	cancel()
+	<-done
+	m.logger.Info("etcd maintenance stopped")
+}
+
+func (m *Maintainer) Status() MaintenanceStatus {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return m.status
+}
+
+func (m *Maintainer) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	ticker := time.NewTicker(m.opts.CompactionInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.maintain(ctx)
+		}
+	}
+}
+
+// maintain runs one round of the maintenance, and the errors are recorded in the status to be retried in the next round.
+func (m *Maintainer) maintain(ctx context.Context) {
+	now := m.clock.Now()
+
+	revision, err := m.compact(ctx)
+	m.lock.Lock()
+	m.status.LastCompactionTime = now
+	if err != nil {
+		m.status.LastCompactionError = err.Error()
+	} else {
+		m.status.LastCompactionError = ""
+		m.status.LastCompactedRevision = max(m.status.LastCompactedRevision, revision)
+	}
+	lastDefragTime := m.status.LastDefragTime
+	m.lock.Unlock()
+	if err != nil {
+		m.logger.Warn("etcd compaction failed", zap.Error(err))
+	}
+
+	if m.opts.DefragWindow == nil {
+		return
+	}
+	windowStart, ok := m.opts.DefragWindow.windowStart(now)
+	if !ok || !lastDefragTime.Before(windowStart) {
+		return
+	}
+
+	err = m.defragment(ctx)
+	m.lock.Lock()
+	if err != nil {
+		m.status.LastDefragError = err.Error()
+	} else {
+		m.status.LastDefragError = ""
+		m.status.LastDefragTime = now
+	}
+	m.lock.Unlock()
+	if err != nil {
+		m.logger.Warn("etcd defragmentation failed", zap.Error(err))
+	}
+}
+
+// compact compacts the revisions older than the retained ones, and returns the compacted revision.
+func (m *Maintainer) compact(ctx context.Context) (int64, error) {
+	resp, err := m.client.Get(ctx, "/", clientv3.WithCountOnly())
+	if err != nil {
+		return 0, errors.WithMessagef(ErrEtcdCompact, "get current revision, err:%v", err)
+	}
+
+	revision := resp.Header.Revision - m.opts.RetainedRevisions
+	if revision <= m.Status().LastCompactedRevision {
+		return revision, nil
+	}
+
+	if _, err := m.client.Compact(ctx, revision, clientv3.WithCompactPhysical()); err != nil {
+		// The revision may be compacted by the auto compaction of etcd already.
+		if errors.Is(err, rpctypes.ErrCompacted) {
+			return revision, nil
+		}
+		return 0, errors.WithMessagef(ErrEtcdCompact, "revision:%d, err:%v", revision, err)
+	}
+
+	m.logger.Info("etcd revisions compacted", zap.Int64("revision", revision))
+	return revision, nil
+}
+
+// defragment defragments the members one by one, so that at most one member is blocked at the same time.
+func (m *Maintainer) defragment(ctx context.Context) error {
+	for _, endpoint := range m.client.Endpoints() {
+		start := time.Now()
+		if _, err := m.client.Defragment(ctx, endpoint); err != nil {
+			return errors.WithMessagef(ErrEtcdDefragment, "endpoint:%s, err:%v", endpoint, err)
+		}
+		m.logger.Info("etcd member defragmented", zap.String("endpoint", endpoint), zap.Duration("cost", time.Since(start)))
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etcdutil
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/stretchr/testify/require"
+	"go.etcd.io/etcd/api/v3/v3rpc/rpctypes"
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+func TestParseDefragWindow(t *testing.T) {
+	re := require.New(t)
+
+	window, err := ParseDefragWindow("02:00-04:30")
+	re.NoError(err)
+	re.Equal("02:00-04:30", window.String())
+
+	for _, invalid := range []string{"", "02:00", "02:00-02:00", "2am-4am", "25:00-01:00"} {
+		_, err := ParseDefragWindow(invalid)
+		re.True(coderr.Is(err, ErrInvalidDefragWindow.Code()), "window:%s", invalid)
+	}
+}
+
+func TestDefragWindowStart(t *testing.T) {
+	re := require.New(t)
+	day := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+
+	window, err := ParseDefragWindow("02:00-04:00")
+	re.NoError(err)
+	start, ok := window.windowStart(day.Add(3 * time.Hour))
+	re.True(ok)
+	re.Equal(day.Add(2*time.Hour), start)
+	_, ok = window.windowStart(day.Add(4 * time.Hour))
+	re.False(ok)
+
+	// The window wraps around the midnight.
+	window, err = ParseDefragWindow("23:00-01:00")
+	re.NoError(err)
+	start, ok = window.windowStart(day.Add(23*time.Hour + 30*time.Minute))
+	re.True(ok)
+	re.Equal(day.Add(23*time.Hour), start)
+	start, ok = window.windowStart(day.Add(30 * time.Minute))
+	re.True(ok)
+	re.Equal(day.Add(-time.Hour), start)
+	_, ok = window.windowStart(day.Add(12 * time.Hour))
+	re.False(ok)
+}
+
+func TestMaintainer(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	_, client, closeSrv := PrepareEtcdServerAndClient(t)
+	defer closeSrv()
+
+	var lastResp *clientv3.PutResponse
+	for i := 0; i < 20; i++ {
+		resp, err := client.Put(ctx, "key", fmt.Sprintf("value%d", i))
+		re.NoError(err)
+		lastResp = resp
+	}
+	latestRevision := lastResp.Header.Revision
+
+	window, err := ParseDefragWindow("02:00-04:00")
+	re.NoError(err)
+	clk := clock.NewMock(time.Date(2024, 1, 2, 1, 0, 0, 0, time.UTC))
+	maintainer := NewMaintainer(log.GetLogger(), client, MaintenanceOptions{
+		CompactionInterval: time.Minute,
+		RetainedRevisions:  5,
+		DefragWindow:       &window,
+	}, clk)
+
+	// Out of the window, only the revisions are compacted.
+	maintainer.maintain(ctx)
+	status := maintainer.Status()
+	re.Empty(status.LastCompactionError)
+	re.Equal(latestRevision-5, status.LastCompactedRevision)
+	re.True(status.LastDefragTime.IsZero())
+	_, err = client.Get(ctx, "key", clientv3.WithRev(latestRevision-6))
+	re.ErrorIs(err, rpctypes.ErrCompacted)
+	_, err = client.Get(ctx, "key", clientv3.WithRev(latestRevision-5))
+	re.NoError(err)
+
+	// The members are defragmented only once in the window.
+	clk.Advance(2 * time.Hour)
+	maintainer.maintain(ctx)
+	status = maintainer.Status()
+	re.Empty(status.LastDefragError)
+	re.Equal(clk.Now(), status.LastDefragTime)
+
+	clk.Advance(time.Minute)
+	maintainer.maintain(ctx)
+	re.Equal(clk.Now().Add(-time.Minute), maintainer.Status().LastDefragTime)
+
+	// The maintenance is only started if it is enabled.
+	maintainer.Start()
+	re.True(maintainer.Status().Running)
+	maintainer.Stop()
+	re.False(maintainer.Status().Running)
+
+	disabled := NewMaintainer(log.GetLogger(), client, MaintenanceOptions{
+		CompactionInterval: 0,
+		RetainedRevisions:  5,
+		DefragWindow:       nil,
+	}, clk)
+	disabled.Start()
+	re.False(disabled.Status().Running)
+}
@@ -0,0 +1,31 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package etcdutil
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// embedEtcdAlarms is the number of the alarms raised in the embedded etcd by the type, and the other metrics of the
+// embedded etcd, e.g. the db size and the raft status, are exported by etcd itself.
+var embedEtcdAlarms = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "horaemeta",
+	Subsystem: "etcd",
+	Name:      "alarms",
+	Help:      "Number of the alarms raised in the embedded etcd.",
+}, []string{"type"})
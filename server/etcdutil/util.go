@@ -20,11 +20,12 @@ import (
 	"context"
 
 	"github.com/CeresDB/horaemeta/pkg/log"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.uber.org/zap"
 )
 
-func Get(ctx context.Context, client *clientv3.Client, key string) (string, error) {
+func Get(ctx context.Context, client clientv3.KV, key string) (string, error) {
 	resp, err := client.Get(ctx, key)
 	if err != nil {
 		return "", ErrEtcdKVGet.WithCause(err)
@@ -38,7 +39,7 @@ func Get(ctx context.Context, client *clientv3.Client, key string) (string, erro
 	return string(resp.Kvs[0].Value), nil
 }
 
-func List(ctx context.Context, client *clientv3.Client, prefix string) ([]string, error) {
+func List(ctx context.Context, client clientv3.KV, prefix string) ([]string, error) {
 	resp, err := client.Get(ctx, prefix, clientv3.WithPrefix(), clientv3.WithKeysOnly())
 	if err != nil {
 		return []string{}, ErrEtcdKVGet.WithCause(err)
@@ -50,7 +51,15 @@ func List(ctx context.Context, client *clientv3.Client, prefix string) ([]string
 	return result, nil
 }
 
-func Scan(ctx context.Context, client *clientv3.Client, startKey, endKey string, batchSize int, do func(key string, val []byte) error) error {
+func Scan(ctx context.Context, client clientv3.KV, startKey, endKey string, batchSize int, do func(key string, val []byte) error) error {
+	return ScanKV(ctx, client, startKey, endKey, batchSize, func(kv *mvccpb.KeyValue) error {
+		// TODO: avoid such a copy on key.
+		return do(string(kv.Key), kv.Value)
+	})
+}
+
+// ScanKV is the same as Scan, except that the whole key-value including its version is passed to do.
+func ScanKV(ctx context.Context, client clientv3.KV, startKey, endKey string, batchSize int, do func(kv *mvccpb.KeyValue) error) error {
 	withRange := clientv3.WithRange(endKey)
 	withLimit := clientv3.WithLimit(int64(batchSize))
 
@@ -63,18 +72,16 @@ func Scan(ctx context.Context, client *clientv3.Client, startKey, endKey string,
 		return nil
 	}
 
-	doIfNotEndKey := func(key, val []byte) error {
-		// TODO: avoid such a copy on key.
-		keyStr := string(key)
-		if keyStr == endKey {
+	doIfNotEndKey := func(kv *mvccpb.KeyValue) error {
+		if string(kv.Key) == endKey {
 			return nil
 		}
 
-		return do(keyStr, val)
+		return do(kv)
 	}
 
 	for _, item := range resp.Kvs {
-		err := doIfNotEndKey(item.Key, item.Value)
+		err := doIfNotEndKey(item)
 		if err != nil {
 			return err
 		}
@@ -109,7 +116,7 @@ func Scan(ctx context.Context, client *clientv3.Client, startKey, endKey string,
 
 		// Skip the first key which is processed already.
 		for _, item := range resp.Kvs[1:] {
-			err := doIfNotEndKey(item.Key, item.Value)
+			err := doIfNotEndKey(item)
 			if err != nil {
 				return err
 			}
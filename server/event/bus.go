@@ -0,0 +1,247 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package event
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"go.uber.org/zap"
+)
+
+const (
+	// DefaultCapacity is the default number of the latest events kept for polling.
+	DefaultCapacity = 10000
+
+	sinkBufferSize    = 1024
+	sinkBatchSize     = 100
+	sinkMaxAttempts   = 3
+	sinkRetryInterval = time.Second
+)
+
+type BusOptions struct {
+	// Capacity is the number of the latest events kept for polling, and the older ones are evicted.
+	Capacity int
+	// Sinks are sent all the events published to the bus.
+	Sinks []Sink
+}
+
+// ListResult is the events published after the given id.
+type ListResult struct {
+	Events []Event `json:"events"`
+	// LastID is the id of the latest event listed, or the given id if no event is listed, which is the id to list from
+	// next time.
+	LastID uint64 `json:"lastID"`
+	// Truncated tells the events after the given id have been evicted partly, so some events are missed.
+	Truncated bool `json:"truncated"`
+}
+
+// Bus publishes the events of a cluster to the sinks, and keeps the latest events in memory for polling. The events are
+// not persisted, so the events kept by the old leader are lost when the leadership changes.
+type Bus struct {
+	logger      *zap.Logger
+	clusterName string
+	clock       clock.Clock
+	capacity    int
+	sinks       []*sinkWorker
+
+	// RWMutex is used to protect following fields.
+	lock   sync.RWMutex
+	lastID uint64
+	events []Event
+	// evictedID is the id of the latest evicted event.
+	evictedID uint64
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+}
+
+func NewBus(logger *zap.Logger, clusterName string, clk clock.Clock, opts BusOptions) *Bus {
+	capacity := opts.Capacity
+	if capacity <= 0 {
+		capacity = DefaultCapacity
+	}
+	sinks := make([]*sinkWorker, 0, len(opts.Sinks))
+	for _, sink := range opts.Sinks {
+		sinks = append(sinks, &sinkWorker{
+			logger:      logger.With(zap.String("sink", sink.Name())),
+			clusterName: clusterName,
+			sink:        sink,
+			ch:          make(chan Event, sinkBufferSize),
+		})
+	}
+
+	return &Bus{
+		logger:      logger,
+		clusterName: clusterName,
+		clock:       clk,
+		capacity:    capacity,
+		sinks:       sinks,
+		lock:        sync.RWMutex{},
+		lastID:      0,
+		events:      make([]Event, 0),
+		evictedID:   0,
+		cancel:      nil,
+		wg:          sync.WaitGroup{},
+	}
+}
+
+// Start starts sending the events to the sinks, and the events published before are sent as well unless they are dropped
+// for the full buffers.
+func (b *Bus) Start(_ context.Context) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b.cancel = cancel
+	for _, sink := range b.sinks {
+		b.wg.Add(1)
+		go func(sink *sinkWorker) {
+			defer b.wg.Done()
+			sink.run(ctx)
+		}(sink)
+	}
+	return nil
+}
+
+// Stop stops sending the events, and the events not sent yet are discarded.
+func (b *Bus) Stop(_ context.Context) error {
+	b.lock.Lock()
+	cancel := b.cancel
+	b.cancel = nil
+	b.lock.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+	b.wg.Wait()
+	return nil
+}
+
+// Publish implements Publisher.
+func (b *Bus) Publish(typ Type, attributes map[string]string) {
+	now := b.clock.Now()
+
+	b.lock.Lock()
+	b.lastID = max(b.lastID+1, uint64(now.UnixMicro()))
+	event := Event{
+		ID:          b.lastID,
+		Type:        typ,
+		ClusterName: b.clusterName,
+		Timestamp:   uint64(now.UnixMilli()),
+		Attributes:  attributes,
+	}
+	b.events = append(b.events, event)
+	if len(b.events) > b.capacity {
+		b.evictedID = b.events[0].ID
+		b.events = b.events[1:]
+	}
+	b.lock.Unlock()
+
+	b.logger.Debug("publish event", zap.String("type", string(typ)), zap.Uint64("id", event.ID), zap.Any("attributes", attributes))
+	for _, sink := range b.sinks {
+		sink.offer(event)
+	}
+}
+
+// List lists at most limit events published after the id since, and 0 lists from the oldest event kept.
+func (b *Bus) List(since uint64, limit int) ListResult {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	start := sort.Search(len(b.events), func(i int) bool {
+		return b.events[i].ID > since
+	})
+	end := len(b.events)
+	if limit > 0 && start+limit < end {
+		end = start + limit
+	}
+
+	events := make([]Event, end-start)
+	copy(events, b.events[start:end])
+	lastID := since
+	if len(events) > 0 {
+		lastID = events[len(events)-1].ID
+	}
+	return ListResult{
+		Events:    events,
+		LastID:    lastID,
+		Truncated: since < b.evictedID,
+	}
+}
+
+// sinkWorker sends the events to the sink in batches, and the events are dropped if the sink can't keep up with them.
+type sinkWorker struct {
+	logger      *zap.Logger
+	clusterName string
+	sink        Sink
+	ch          chan Event
+}
+
+func (w *sinkWorker) offer(event Event) {
+	select {
+	case w.ch <- event:
+	default:
+		sinkEvents.WithLabelValues(w.clusterName, w.sink.Name(), "dropped").Inc()
+		w.logger.Warn("drop event for full sink buffer", zap.Uint64("id", event.ID), zap.String("type", string(event.Type)))
+	}
+}
+
+func (w *sinkWorker) run(ctx context.Context) {
+	for {
+		var event Event
+		select {
+		case <-ctx.Done():
+			return
+		case event = <-w.ch:
+		}
+
+		batch := []Event{event}
+	collect:
+		for len(batch) < sinkBatchSize {
+			select {
+			case event = <-w.ch:
+				batch = append(batch, event)
+			default:
+				break collect
+			}
+		}
+		w.send(ctx, batch)
+	}
+}
+
+// send retries the failed batch for a few times, and the batch is given up at last, which can be caught up by polling.
+func (w *sinkWorker) send(ctx context.Context, batch []Event) {
+	var err error
+	for attempt := 1; attempt <= sinkMaxAttempts; attempt++ {
+		if err = w.sink.Send(ctx, batch); err == nil {
+			sinkEvents.WithLabelValues(w.clusterName, w.sink.Name(), "delivered").Add(float64(len(batch)))
+			return
+		}
+		w.logger.Warn("send events failed", zap.Int("attempt", attempt), zap.Int("eventCount", len(batch)), zap.Error(err))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sinkRetryInterval):
+		}
+	}
+	sinkEvents.WithLabelValues(w.clusterName, w.sink.Name(), "failed").Add(float64(len(batch)))
+	w.logger.Error("give up sending events", zap.Uint64("firstID", batch[0].ID), zap.Int("eventCount", len(batch)), zap.Error(err))
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package event_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/event"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+const testClusterName = "defaultCluster"
+
+func TestBusList(t *testing.T) {
+	re := require.New(t)
+
+	clk := clock.NewMock(time.Unix(1700000000, 0))
+	bus := event.NewBus(zap.NewNop(), testClusterName, clk, event.BusOptions{Capacity: 3, Sinks: nil})
+	for i := 0; i < 2; i++ {
+		bus.Publish(event.TypeNodeOnline, map[string]string{event.AttrNodeName: "node"})
+	}
+
+	// The ids increase even if the clock doesn't move.
+	result := bus.List(0, 0)
+	re.Len(result.Events, 2)
+	re.Equal(uint64(clk.Now().UnixMicro()), result.Events[0].ID)
+	re.Equal(result.Events[0].ID+1, result.Events[1].ID)
+	re.Equal(result.Events[1].ID, result.LastID)
+	re.Equal(testClusterName, result.Events[0].ClusterName)
+	re.False(result.Truncated)
+
+	// Nothing is listed after the last id.
+	result = bus.List(result.LastID, 0)
+	re.Empty(result.Events)
+	re.Equal(bus.List(0, 0).LastID, result.LastID)
+
+	// The oldest events are evicted beyond the capacity, and the polling falling behind is told.
+	first := bus.List(0, 1)
+	re.Len(first.Events, 1)
+	clk.Advance(time.Second)
+	bus.Publish(event.TypeNodeOffline, nil)
+	bus.Publish(event.TypeNodeOffline, nil)
+	result = bus.List(0, 0)
+	re.Len(result.Events, 3)
+	re.True(result.Truncated)
+	re.Equal(event.TypeNodeOffline, result.Events[2].Type)
+	re.Equal(uint64(clk.Now().UnixMicro())+1, result.Events[2].ID)
+	// Nothing is missed after the evicted event.
+	re.False(bus.List(first.LastID, 0).Truncated)
+	re.Equal(result.Events, bus.List(first.LastID, 0).Events)
+}
+
+func TestBusSinks(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	webhookEvents := make(chan []event.Event, 10)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var events []event.Event
+		if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		webhookEvents <- events
+	}))
+	defer webhook.Close()
+
+	type kafkaRequest struct {
+		path        string
+		contentType string
+		body        map[string][]map[string]json.RawMessage
+	}
+	kafkaRequests := make(chan kafkaRequest, 10)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string][]map[string]json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		kafkaRequests <- kafkaRequest{path: r.URL.Path, contentType: r.Header.Get("Content-Type"), body: body}
+	}))
+	defer proxy.Close()
+
+	bus := event.NewBus(zap.NewNop(), testClusterName, clock.NewRealClock(), event.BusOptions{
+		Capacity: 0,
+		Sinks: []event.Sink{
+			event.NewWebhookSink(webhook.URL, time.Second),
+			event.NewKafkaRESTSink(proxy.URL, "events", time.Second),
+		},
+	})
+	re.NoError(bus.Start(ctx))
+	defer func() {
+		re.NoError(bus.Stop(ctx))
+	}()
+	bus.Publish(event.TypeTableCreated, map[string]string{event.AttrSchemaName: "public", event.AttrTableName: "t"})
+
+	select {
+	case events := <-webhookEvents:
+		re.Len(events, 1)
+		re.Equal(event.TypeTableCreated, events[0].Type)
+		re.Equal("t", events[0].Attributes[event.AttrTableName])
+	case <-time.After(5 * time.Second):
+		re.FailNow("webhook is not posted")
+	}
+
+	select {
+	case req := <-kafkaRequests:
+		re.Equal("/topics/events", req.path)
+		re.Equal("application/vnd.kafka.json.v2+json", req.contentType)
+		re.Len(req.body["records"], 1)
+		re.Equal(`"`+testClusterName+`"`, string(req.body["records"][0]["key"]))
+		var value event.Event
+		re.NoError(json.Unmarshal(req.body["records"][0]["value"], &value))
+		re.Equal(event.TypeTableCreated, value.Type)
+	case <-time.After(5 * time.Second):
+		re.FailNow("kafka records are not produced")
+	}
+}
@@ -0,0 +1,67 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package event
+
+// Type is the kind of the change of the cluster.
+type Type string
+
+const (
+	TypeShardMoved          Type = "shardMoved"
+	TypeNodeOnline          Type = "nodeOnline"
+	TypeNodeOffline         Type = "nodeOffline"
+	TypeTableCreated        Type = "tableCreated"
+	TypeTableDropped        Type = "tableDropped"
+	TypeClusterStateChanged Type = "clusterStateChanged"
+)
+
+// The keys of the attributes of the events.
+const (
+	AttrShardID    = "shardID"
+	AttrFromNode   = "fromNode"
+	AttrToNode     = "toNode"
+	AttrNodeName   = "nodeName"
+	AttrSchemaName = "schemaName"
+	AttrTableName  = "tableName"
+	AttrTableID    = "tableID"
+	AttrFromState  = "fromState"
+	AttrToState    = "toState"
+)
+
+// Event describes a change of the cluster published by the leader.
+type Event struct {
+	// ID increases with the events of the cluster, and it is not less than the publish time in unix microseconds, so the
+	// events published by a new leader always have larger ids than the ones published by the old leader.
+	ID          uint64            `json:"id"`
+	Type        Type              `json:"type"`
+	ClusterName string            `json:"clusterName"`
+	Timestamp   uint64            `json:"timestamp"`
+	Attributes  map[string]string `json:"attributes"`
+}
+
+// Publisher publishes the events of a cluster.
+type Publisher interface {
+	Publish(typ Type, attributes map[string]string)
+}
+
+type noopPublisher struct{}
+
+// NewNoopPublisher returns the publisher dropping all the events, which is used by the clusters not served by the leader.
+func NewNoopPublisher() Publisher {
+	return noopPublisher{}
+}
+
+func (noopPublisher) Publish(_ Type, _ map[string]string) {}
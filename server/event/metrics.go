@@ -0,0 +1,30 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package event
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// sinkEvents counts the events handled by the sinks by the results: delivered, failed or dropped for the full buffer.
+var sinkEvents = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "horaemeta",
+	Subsystem: "event",
+	Name:      "sink_events_total",
+	Help:      "Number of the events handled by the sinks.",
+}, []string{"cluster", "sink", "result"})
@@ -0,0 +1,124 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package event
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Sink receives the events published to the bus, e.g. the external systems reacting to the changes of the clusters.
+type Sink interface {
+	// Name identifies the sink in the logs and the metrics.
+	Name() string
+	Send(ctx context.Context, events []Event) error
+}
+
+// webhookSink posts the events as a json array to the url.
+type webhookSink struct {
+	url        string
+	httpClient *http.Client
+}
+
+func NewWebhookSink(url string, timeout time.Duration) Sink {
+	return &webhookSink{
+		url:        url,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *webhookSink) Name() string {
+	return "webhook:" + s.url
+}
+
+func (s *webhookSink) Send(ctx context.Context, events []Event) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return errors.WithMessage(err, "encode events")
+	}
+	return post(ctx, s.httpClient, s.url, "application/json", body)
+}
+
+// kafkaRecord is a record produced by the REST proxy of kafka, and the records keyed by the cluster name are kept in
+// order within a partition.
+type kafkaRecord struct {
+	Key   string `json:"key"`
+	Value Event  `json:"value"`
+}
+
+type kafkaProduceRequest struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+// kafkaRESTSink produces the events to the topic by the REST proxy of kafka with its v2 api, so no kafka client is
+// needed by the server.
+type kafkaRESTSink struct {
+	proxyURL   string
+	topic      string
+	httpClient *http.Client
+}
+
+func NewKafkaRESTSink(proxyURL, topic string, timeout time.Duration) Sink {
+	return &kafkaRESTSink{
+		proxyURL:   proxyURL,
+		topic:      topic,
+		httpClient: &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *kafkaRESTSink) Name() string {
+	return "kafka:" + s.topic
+}
+
+func (s *kafkaRESTSink) Send(ctx context.Context, events []Event) error {
+	records := make([]kafkaRecord, 0, len(events))
+	for _, event := range events {
+		records = append(records, kafkaRecord{Key: event.ClusterName, Value: event})
+	}
+	body, err := json.Marshal(kafkaProduceRequest{Records: records})
+	if err != nil {
+		return errors.WithMessage(err, "encode kafka records")
+	}
+	produceURL := fmt.Sprintf("%s/topics/%s", s.proxyURL, url.PathEscape(s.topic))
+	return post(ctx, s.httpClient, produceURL, "application/vnd.kafka.json.v2+json", body)
+}
+
+func post(ctx context.Context, httpClient *http.Client, target, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(body))
+	if err != nil {
+		return errors.WithMessage(err, "build request")
+	}
+	req.Header.Set("Content-Type", contentType)
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return errors.WithMessage(err, "post request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return errors.Errorf("unexpected response status:%d, url:%s", resp.StatusCode, target)
+	}
+	return nil
+}
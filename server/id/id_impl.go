@@ -21,6 +21,7 @@ import (
 	"fmt"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/CeresDB/horaemeta/server/etcdutil"
 	"github.com/pkg/errors"
@@ -29,29 +30,75 @@ import (
 	"go.uber.org/zap"
 )
 
+const preallocateTimeout = time.Second * 10
+
+type AllocatorOptions struct {
+	// Step is the number of the ids reserved from etcd at a time.
+	Step uint
+	// Preallocate reserves the next range of ids in background once half of the current range is consumed, so that the
+	// allocation rarely waits for etcd during bursts.
+	Preallocate bool
+}
+
+// ResourceAllocatorOptions is the options of the id allocators of the resources whose ids are reserved from etcd.
+type ResourceAllocatorOptions struct {
+	Cluster   AllocatorOptions
+	Schema    AllocatorOptions
+	Table     AllocatorOptions
+	Procedure AllocatorOptions
+}
+
+// NewResourceAllocatorOptions returns the options reserving the ids of all the resources by the same step without the
+// preallocation.
+func NewResourceAllocatorOptions(step uint) ResourceAllocatorOptions {
+	opts := AllocatorOptions{Step: step, Preallocate: false}
+	return ResourceAllocatorOptions{
+		Cluster:   opts,
+		Schema:    opts,
+		Table:     opts,
+		Procedure: opts,
+	}
+}
+
 type AllocatorImpl struct {
 	logger *zap.Logger
 	// RWMutex is used to protect following fields.
 	lock sync.Mutex
 	base uint64
 	end  uint64
+	// [nextBase, nextEnd) is the range reserved by the preallocation, and it is empty if they are equal.
+	nextBase        uint64
+	nextEnd         uint64
+	isPreallocating bool
 
 	kv            clientv3.KV
 	key           string
 	allocStep     uint
+	preallocate   bool
 	isInitialized bool
 }
 
 func NewAllocatorImpl(logger *zap.Logger, kv clientv3.KV, key string, allocStep uint) Allocator {
+	return NewAllocatorImplWithOptions(logger, kv, key, AllocatorOptions{
+		Step:        allocStep,
+		Preallocate: false,
+	})
+}
+
+func NewAllocatorImplWithOptions(logger *zap.Logger, kv clientv3.KV, key string, opts AllocatorOptions) Allocator {
 	return &AllocatorImpl{
-		logger:        logger,
-		lock:          sync.Mutex{},
-		base:          0,
-		end:           0,
-		kv:            kv,
-		key:           key,
-		allocStep:     allocStep,
-		isInitialized: false,
+		logger:          logger,
+		lock:            sync.Mutex{},
+		base:            0,
+		end:             0,
+		nextBase:        0,
+		nextEnd:         0,
+		isPreallocating: false,
+		kv:              kv,
+		key:             key,
+		allocStep:       opts.Step,
+		preallocate:     opts.Preallocate,
+		isInitialized:   false,
 	}
 }
 
@@ -71,7 +118,10 @@ func (a *AllocatorImpl) Alloc(ctx context.Context) (uint64, error) {
 	}
 
 	if a.isExhausted() {
-		if err := a.fastRebaseLocked(ctx); err != nil {
+		if a.nextBase < a.nextEnd {
+			a.base, a.end = a.nextBase, a.nextEnd
+			a.nextBase, a.nextEnd = 0, 0
+		} else if err := a.fastRebaseLocked(ctx); err != nil {
 			a.logger.Warn("fast rebase failed", zap.Error(err))
 
 			if err = a.slowRebaseLocked(ctx); err != nil {
@@ -82,9 +132,40 @@ func (a *AllocatorImpl) Alloc(ctx context.Context) (uint64, error) {
 
 	ret := a.base
 	a.base++
+	a.maybePreallocateLocked()
 	return ret, nil
 }
 
+// maybePreallocateLocked reserves the range following the current one in background if half of the current range is
+// consumed. The reservation competes with the rebase in Alloc by the same compare-and-swap on the end id, and the loser
+// just gives up, so the ranges held by the allocator never overlap.
+func (a *AllocatorImpl) maybePreallocateLocked() {
+	if !a.preallocate || a.isPreallocating || a.nextBase < a.nextEnd {
+		return
+	}
+	if a.end-a.base > uint64(a.allocStep)/2 {
+		return
+	}
+
+	a.isPreallocating = true
+	currEnd := a.end
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), preallocateTimeout)
+		defer cancel()
+		newEnd, err := a.reserve(ctx, currEnd)
+
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		a.isPreallocating = false
+		if err != nil {
+			a.logger.Warn("preallocate id failed", zap.String("key", a.key), zap.Error(err))
+			return
+		}
+		a.nextBase, a.nextEnd = currEnd, newEnd
+		a.logger.Info("Allocator preallocates the next ids", zap.String("key", a.key), zap.Uint64("base", currEnd), zap.Uint64("end", newEnd))
+	}()
+}
+
 func (a *AllocatorImpl) Collect(_ context.Context, _ uint64) error {
 	return ErrCollectNotSupported
 }
@@ -140,6 +221,21 @@ func (a *AllocatorImpl) doRebaseLocked(ctx context.Context, currEnd uint64) erro
 		return ErrAllocID.WithCausef("ID in storage can't less than memory, base:%d, end:%d", a.base, currEnd)
 	}
 
+	newEnd, err := a.reserve(ctx, currEnd)
+	if err != nil {
+		return err
+	}
+
+	a.base = currEnd
+	a.end = newEnd
+
+	a.logger.Info("Allocator allocates a new base id", zap.String("key", a.key), zap.Uint64("id", a.base))
+
+	return nil
+}
+
+// reserve moves the end id in the storage from currEnd by a step, and returns the new end id.
+func (a *AllocatorImpl) reserve(ctx context.Context, currEnd uint64) (uint64, error) {
 	newEnd := currEnd + uint64(a.allocStep)
 
 	endEquals := clientv3.Compare(clientv3.Value(a.key), "=", encodeID(currEnd))
@@ -150,17 +246,11 @@ func (a *AllocatorImpl) doRebaseLocked(ctx context.Context, currEnd uint64) erro
 		Then(opPutEnd).
 		Commit()
 	if err != nil {
-		return errors.WithMessagef(err, "put end id failed, key:%s, old value:%d, new value:%d", a.key, currEnd, newEnd)
+		return 0, errors.WithMessagef(err, "put end id failed, key:%s, old value:%d, new value:%d", a.key, currEnd, newEnd)
 	} else if !resp.Succeeded {
-		return ErrTxnPutEndID.WithCausef("txn put end id failed, endEquals failed, key:%s, value:%d, resp:%v", a.key, currEnd, resp)
+		return 0, ErrTxnPutEndID.WithCausef("txn put end id failed, endEquals failed, key:%s, value:%d, resp:%v", a.key, currEnd, resp)
 	}
-
-	a.base = currEnd
-	a.end = newEnd
-
-	a.logger.Info("Allocator allocates a new base id", zap.String("key", a.key), zap.Uint64("id", a.base))
-
-	return nil
+	return newEnd, nil
 }
 
 func encodeID(value uint64) string {
@@ -56,3 +56,39 @@ func testAllocIDValue(t *testing.T, kv clientv3.KV, start, size int) {
 		re.Equal(uint64(i), value)
 	}
 }
+
+func TestAllocWithPreallocation(t *testing.T) {
+	re := require.New(t)
+	_, kv, closeSrv := etcdutil.PrepareEtcdServerAndClient(t)
+	defer closeSrv()
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+
+	key := defaultRootPath + defaultAllocIDKey
+	opts := AllocatorOptions{Step: 10, Preallocate: true}
+	alloc := NewAllocatorImplWithOptions(zap.NewNop(), kv, key, opts)
+
+	// The next range is reserved once half of the first range is consumed.
+	for i := 0; i < 5; i++ {
+		value, err := alloc.Alloc(ctx)
+		re.NoError(err)
+		re.Equal(uint64(i), value)
+	}
+	re.Eventually(func() bool {
+		resp, err := kv.Get(ctx, key)
+		return err == nil && len(resp.Kvs) == 1 && string(resp.Kvs[0].Value) == "20"
+	}, defaultRequestTimeout, 10*time.Millisecond)
+
+	// The allocators sharing the key never allocate the same id.
+	other := NewAllocatorImplWithOptions(zap.NewNop(), kv, key, opts)
+	allocated := make(map[uint64]struct{})
+	for i := 0; i < 100; i++ {
+		for _, a := range []Allocator{alloc, other} {
+			value, err := a.Alloc(ctx)
+			re.NoError(err)
+			_, exists := allocated[value]
+			re.False(exists, "id:%d", value)
+			allocated[value] = struct{}{}
+		}
+	}
+}
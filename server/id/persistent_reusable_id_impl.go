@@ -0,0 +1,213 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package id
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sync"
+
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/clientv3util"
+	"go.uber.org/zap"
+)
+
+// reusableIDState is the persisted state of PersistentReusableAllocatorImpl.
+type reusableIDState struct {
+	// Next is the minimum id never allocated.
+	Next uint64 `json:"next"`
+	// Free are the collected ids less than Next in ascending order, which are allocated before Next.
+	Free []uint64 `json:"free"`
+}
+
+// buildReusableIDState builds the state in which the existIDs are allocated and the holes among them are free.
+func buildReusableIDState(existIDs []uint64, minID uint64) reusableIDState {
+	sorted := slices.Clone(existIDs)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+
+	state := reusableIDState{Next: minID, Free: []uint64{}}
+	for _, id := range sorted {
+		if id < minID {
+			continue
+		}
+		for ; state.Next < id; state.Next++ {
+			state.Free = append(state.Free, state.Next)
+		}
+		state.Next = id + 1
+	}
+	return state
+}
+
+// PersistentReusableAllocatorImpl allocates the minimum unused id as ReusableAllocatorImpl does, and persists the next id
+// and the free list in the storage, so that the collected ids are reused across the restarts and the leader changes.
+type PersistentReusableAllocatorImpl struct {
+	logger *zap.Logger
+	kv     clientv3.KV
+	key    string
+	minID  uint64
+	// loadExistIDs returns the ids in use, and the state is built from them if it has not been persisted yet, e.g. the
+	// ids are allocated by the allocator which is not persisted.
+	loadExistIDs func() []uint64
+
+	// Mutex is used to protect following fields.
+	lock          sync.Mutex
+	isInitialized bool
+	// persisted is the encoded state in the storage, which the storage is compared with before the state is updated.
+	persisted string
+	state     reusableIDState
+}
+
+func NewPersistentReusableAllocatorImpl(logger *zap.Logger, kv clientv3.KV, key string, minID uint64, loadExistIDs func() []uint64) Allocator {
+	return &PersistentReusableAllocatorImpl{
+		logger:        logger,
+		kv:            kv,
+		key:           key,
+		minID:         minID,
+		loadExistIDs:  loadExistIDs,
+		lock:          sync.Mutex{},
+		isInitialized: false,
+		persisted:     "",
+		state:         reusableIDState{Next: minID, Free: []uint64{}},
+	}
+}
+
+func (a *PersistentReusableAllocatorImpl) Alloc(ctx context.Context) (uint64, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if err := a.initLocked(ctx); err != nil {
+		return 0, err
+	}
+
+	newState := reusableIDState{Next: a.state.Next, Free: slices.Clone(a.state.Free)}
+	var id uint64
+	if len(newState.Free) > 0 {
+		id = newState.Free[0]
+		newState.Free = newState.Free[1:]
+	} else {
+		id = newState.Next
+		newState.Next++
+	}
+	if err := a.persistLocked(ctx, newState); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func (a *PersistentReusableAllocatorImpl) Collect(ctx context.Context, id uint64) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if err := a.initLocked(ctx); err != nil {
+		return err
+	}
+
+	if id < a.minID || id >= a.state.Next {
+		return ErrCollectID.WithCausef("id is never allocated, id:%d, next:%d", id, a.state.Next)
+	}
+	idx, found := slices.BinarySearch(a.state.Free, id)
+	if found {
+		return ErrCollectID.WithCausef("id is collected already, id:%d", id)
+	}
+
+	newState := reusableIDState{Next: a.state.Next, Free: slices.Insert(slices.Clone(a.state.Free), idx, id)}
+	// The free ids right below the next id are given back to it, so the free list doesn't grow after the shards at the
+	// end are removed.
+	for len(newState.Free) > 0 && newState.Free[len(newState.Free)-1] == newState.Next-1 {
+		newState.Free = newState.Free[:len(newState.Free)-1]
+		newState.Next--
+	}
+	return a.persistLocked(ctx, newState)
+}
+
+// initLocked loads the state from the storage, or builds it from the ids in use and persists it if it is missing.
+func (a *PersistentReusableAllocatorImpl) initLocked(ctx context.Context) error {
+	if a.isInitialized {
+		return nil
+	}
+
+	resp, err := a.kv.Get(ctx, a.key)
+	if err != nil {
+		return errors.WithMessagef(err, "get reusable id state, key:%s", a.key)
+	}
+	if len(resp.Kvs) > 0 {
+		var state reusableIDState
+		if err := json.Unmarshal(resp.Kvs[0].Value, &state); err != nil {
+			return ErrAllocID.WithCausef("decode reusable id state, key:%s, err:%v", a.key, err)
+		}
+		a.state = state
+		a.persisted = string(resp.Kvs[0].Value)
+		a.isInitialized = true
+		return nil
+	}
+
+	var existIDs []uint64
+	if a.loadExistIDs != nil {
+		existIDs = a.loadExistIDs()
+	}
+	state := buildReusableIDState(existIDs, a.minID)
+	value, err := json.Marshal(state)
+	if err != nil {
+		return ErrAllocID.WithCausef("encode reusable id state, key:%s, err:%v", a.key, err)
+	}
+	txnResp, err := a.kv.Txn(ctx).
+		If(clientv3util.KeyMissing(a.key)).
+		Then(clientv3.OpPut(a.key, string(value))).
+		Commit()
+	if err != nil {
+		return errors.WithMessagef(err, "put reusable id state, key:%s", a.key)
+	}
+	if !txnResp.Succeeded {
+		return ErrTxnPutEndID.WithCausef("reusable id state is created concurrently, key:%s", a.key)
+	}
+
+	a.state = state
+	a.persisted = string(value)
+	a.isInitialized = true
+	a.logger.Info("reusable id allocator initializes state", zap.String("key", a.key), zap.Uint64("next", state.Next), zap.Int("freeCount", len(state.Free)))
+	return nil
+}
+
+// persistLocked replaces the state in the storage if it is not modified by others, and the state is reloaded by the next
+// allocation or collection otherwise.
+func (a *PersistentReusableAllocatorImpl) persistLocked(ctx context.Context, state reusableIDState) error {
+	value, err := json.Marshal(state)
+	if err != nil {
+		return ErrAllocID.WithCausef("encode reusable id state, key:%s, err:%v", a.key, err)
+	}
+
+	resp, err := a.kv.Txn(ctx).
+		If(clientv3.Compare(clientv3.Value(a.key), "=", a.persisted)).
+		Then(clientv3.OpPut(a.key, string(value))).
+		Commit()
+	if err != nil {
+		// The put may be applied even if it fails, so the state is reloaded as well.
+		a.isInitialized = false
+		return errors.WithMessagef(err, "put reusable id state, key:%s", a.key)
+	}
+	if !resp.Succeeded {
+		a.isInitialized = false
+		return ErrTxnPutEndID.WithCausef("reusable id state is modified concurrently, key:%s", a.key)
+	}
+
+	a.state = state
+	a.persisted = string(value)
+	return nil
+}
@@ -0,0 +1,80 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package id
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/etcdutil"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+func TestPersistentReusableAlloc(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+	_, kv, closeSrv := etcdutil.PrepareEtcdServerAndClient(t)
+	defer closeSrv()
+
+	key := defaultRootPath + defaultAllocIDKey
+	alloc := NewPersistentReusableAllocatorImpl(zap.NewNop(), kv, key, 0, nil)
+	for i := 0; i < 4; i++ {
+		id, err := alloc.Alloc(ctx)
+		re.NoError(err)
+		re.Equal(uint64(i), id)
+	}
+
+	// IDs: [0,2,3]
+	re.NoError(alloc.Collect(ctx, 1))
+	re.True(coderr.Is(alloc.Collect(ctx, 1), ErrCollectID.Code()))
+	re.True(coderr.Is(alloc.Collect(ctx, 4), ErrCollectID.Code()))
+
+	// The collected id is reused after the allocator is recreated.
+	alloc = NewPersistentReusableAllocatorImpl(zap.NewNop(), kv, key, 0, nil)
+	id, err := alloc.Alloc(ctx)
+	re.NoError(err)
+	re.Equal(uint64(1), id)
+
+	// IDs: [0,1], and the ids at the end are given back to the next id.
+	re.NoError(alloc.Collect(ctx, 3))
+	re.NoError(alloc.Collect(ctx, 2))
+	alloc = NewPersistentReusableAllocatorImpl(zap.NewNop(), kv, key, 0, nil)
+	id, err = alloc.Alloc(ctx)
+	re.NoError(err)
+	re.Equal(uint64(2), id)
+}
+
+func TestPersistentReusableAllocWithExistIDs(t *testing.T) {
+	re := require.New(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+	_, kv, closeSrv := etcdutil.PrepareEtcdServerAndClient(t)
+	defer closeSrv()
+
+	// The state missing in the storage is built from the ids in use.
+	alloc := NewPersistentReusableAllocatorImpl(zap.NewNop(), kv, defaultRootPath+defaultAllocIDKey, 1, func() []uint64 {
+		return []uint64{6, 1, 3, 3}
+	})
+	for _, expected := range []uint64{2, 4, 5, 7} {
+		id, err := alloc.Alloc(ctx)
+		re.NoError(err)
+		re.Equal(expected, id)
+	}
+}
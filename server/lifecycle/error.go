@@ -0,0 +1,28 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lifecycle
+
+import "github.com/CeresDB/horaemeta/pkg/coderr"
+
+var (
+	ErrDuplicateComponent = coderr.NewCodeError(coderr.Internal, "duplicate component")
+	ErrUnknownDependency  = coderr.NewCodeError(coderr.Internal, "unknown dependency of component")
+	ErrDependencyCycle    = coderr.NewCodeError(coderr.Internal, "dependency cycle of components")
+	ErrAlreadyStarted     = coderr.NewCodeError(coderr.Internal, "components already started")
+	ErrStartComponent     = coderr.NewCodeError(coderr.Internal, "start component")
+	ErrStopComponent      = coderr.NewCodeError(coderr.Internal, "stop component")
+)
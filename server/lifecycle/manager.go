@@ -0,0 +1,216 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lifecycle
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Component is a subsystem started and stopped by the Manager.
+type Component struct {
+	Name string
+	// DependsOn are the names of the components started before this one and stopped after it.
+	DependsOn []string
+	// Start and Stop are optional.
+	Start func(ctx context.Context) error
+	Stop  func(ctx context.Context) error
+	// StopTimeout bounds the waiting for the stop, and the component not stopped in time is logged as a straggler and left
+	// behind, so that it can't block the other components from stopping. Zero means waiting until the context is done.
+	StopTimeout time.Duration
+}
+
+// Manager starts the registered components in the order of their dependencies and stops them in the reverse order.
+// The components can be started again after they are stopped.
+type Manager struct {
+	logger *zap.Logger
+
+	// This lock is used to protect the following fields, and it is held during the whole start or stop.
+	lock       sync.Mutex
+	components []Component
+	// started are the components in the order they were started.
+	started []Component
+	running bool
+}
+
+func NewManager(logger *zap.Logger) *Manager {
+	return &Manager{
+		logger:     logger,
+		lock:       sync.Mutex{},
+		components: []Component{},
+		started:    []Component{},
+		running:    false,
+	}
+}
+
+func (m *Manager) Register(component Component) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.running {
+		return ErrAlreadyStarted.WithCausef("register component:%s", component.Name)
+	}
+	for _, registered := range m.components {
+		if registered.Name == component.Name {
+			return ErrDuplicateComponent.WithCausef("component:%s", component.Name)
+		}
+	}
+
+	m.components = append(m.components, component)
+	return nil
+}
+
+// Start starts all the components, and the ones already started are stopped if any component fails to start.
+func (m *Manager) Start(ctx context.Context) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if m.running {
+		return ErrAlreadyStarted
+	}
+
+	ordered, err := sortByDependencies(m.components)
+	if err != nil {
+		return err
+	}
+
+	m.running = true
+	for _, component := range ordered {
+		if component.Start != nil {
+			m.logger.Info("start component", zap.String("component", component.Name))
+			if err := component.Start(ctx); err != nil {
+				m.logger.Error("start component failed, stop the started ones", zap.String("component", component.Name), zap.Error(err))
+				m.stopStarted(ctx)
+				return ErrStartComponent.WithCausef("component:%s, err:%v", component.Name, err)
+			}
+		}
+		m.started = append(m.started, component)
+	}
+	return nil
+}
+
+// Stop stops the started components in the reverse order, and the error tells which components failed or didn't stop
+// in time, while the others are stopped anyway.
+func (m *Manager) Stop(ctx context.Context) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if !m.running {
+		return nil
+	}
+
+	if failed := m.stopStarted(ctx); len(failed) > 0 {
+		return ErrStopComponent.WithCausef("components:%v", failed)
+	}
+	return nil
+}
+
+// stopStarted returns the names of the components failed to stop.
+func (m *Manager) stopStarted(ctx context.Context) []string {
+	failed := make([]string, 0)
+	for i := len(m.started) - 1; i >= 0; i-- {
+		component := m.started[i]
+		if component.Stop == nil {
+			continue
+		}
+
+		m.logger.Info("stop component", zap.String("component", component.Name))
+		if err := m.stopComponent(ctx, component); err != nil {
+			m.logger.Error("stop component failed", zap.String("component", component.Name), zap.Error(err))
+			failed = append(failed, component.Name)
+		}
+	}
+
+	m.started = m.started[:0]
+	m.running = false
+	return failed
+}
+
+func (m *Manager) stopComponent(ctx context.Context, component Component) error {
+	stopCtx, cancel := ctx, context.CancelFunc(func() {})
+	if component.StopTimeout > 0 {
+		stopCtx, cancel = context.WithTimeout(ctx, component.StopTimeout)
+	}
+	defer cancel()
+
+	begin := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		done <- component.Stop(stopCtx)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-stopCtx.Done():
+		m.logger.Warn("component doesn't stop in time, leave it behind", zap.String("component", component.Name), zap.Duration("timeout", component.StopTimeout))
+		go func() {
+			err := <-done
+			m.logger.Warn("straggler component stopped", zap.String("component", component.Name), zap.Duration("elapsed", time.Since(begin)), zap.Error(err))
+		}()
+		return stopCtx.Err()
+	}
+}
+
+// sortByDependencies orders the components so that every component follows its dependencies, and the registration
+// order is kept among the independent ones.
+func sortByDependencies(components []Component) ([]Component, error) {
+	registered := make(map[string]struct{}, len(components))
+	for _, component := range components {
+		registered[component.Name] = struct{}{}
+	}
+	for _, component := range components {
+		for _, dependency := range component.DependsOn {
+			if _, ok := registered[dependency]; !ok {
+				return nil, ErrUnknownDependency.WithCausef("component:%s, dependency:%s", component.Name, dependency)
+			}
+		}
+	}
+
+	ordered := make([]Component, 0, len(components))
+	sorted := make(map[string]struct{}, len(components))
+	for len(ordered) < len(components) {
+		progressed := false
+		for _, component := range components {
+			if _, ok := sorted[component.Name]; ok {
+				continue
+			}
+			if !dependenciesSorted(component, sorted) {
+				continue
+			}
+			ordered = append(ordered, component)
+			sorted[component.Name] = struct{}{}
+			progressed = true
+		}
+		if !progressed {
+			return nil, ErrDependencyCycle.WithCausef("sorted components:%d, total:%d", len(ordered), len(components))
+		}
+	}
+	return ordered, nil
+}
+
+func dependenciesSorted(component Component, sorted map[string]struct{}) bool {
+	for _, dependency := range component.DependsOn {
+		if _, ok := sorted[dependency]; !ok {
+			return false
+		}
+	}
+	return true
+}
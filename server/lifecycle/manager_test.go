@@ -0,0 +1,162 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package lifecycle_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/lifecycle"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+)
+
+type recorder struct {
+	lock   sync.Mutex
+	events []string
+}
+
+func (r *recorder) record(event string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.events = append(r.events, event)
+}
+
+func (r *recorder) take() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	events := r.events
+	r.events = nil
+	return events
+}
+
+func newComponent(r *recorder, name string, dependsOn ...string) lifecycle.Component {
+	return lifecycle.Component{
+		Name:      name,
+		DependsOn: dependsOn,
+		Start: func(_ context.Context) error {
+			r.record("start " + name)
+			return nil
+		},
+		Stop: func(_ context.Context) error {
+			r.record("stop " + name)
+			return nil
+		},
+		StopTimeout: time.Second,
+	}
+}
+
+func TestStartAndStopInDependencyOrder(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	r := &recorder{lock: sync.Mutex{}, events: nil}
+
+	m := lifecycle.NewManager(zap.NewNop())
+	re.NoError(m.Register(newComponent(r, "http", "clusterManager")))
+	re.NoError(m.Register(newComponent(r, "clusterManager", "etcdClient")))
+	re.NoError(m.Register(newComponent(r, "etcdClient")))
+	err := m.Register(newComponent(r, "etcdClient"))
+	re.True(coderr.Is(err, lifecycle.ErrDuplicateComponent.Code()))
+	re.ErrorContains(err, "duplicate component")
+
+	re.NoError(m.Start(ctx))
+	re.Equal([]string{"start etcdClient", "start clusterManager", "start http"}, r.take())
+	err = m.Start(ctx)
+	re.True(coderr.Is(err, lifecycle.ErrAlreadyStarted.Code()))
+	re.ErrorContains(err, "components already started")
+
+	re.NoError(m.Stop(ctx))
+	re.Equal([]string{"stop http", "stop clusterManager", "stop etcdClient"}, r.take())
+
+	// The components can be started again.
+	re.NoError(m.Start(ctx))
+	re.Equal([]string{"start etcdClient", "start clusterManager", "start http"}, r.take())
+	re.NoError(m.Stop(ctx))
+}
+
+func TestInvalidDependencies(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	r := &recorder{lock: sync.Mutex{}, events: nil}
+
+	m := lifecycle.NewManager(zap.NewNop())
+	re.NoError(m.Register(newComponent(r, "http", "unknown")))
+	err := m.Start(ctx)
+	re.True(coderr.Is(err, lifecycle.ErrUnknownDependency.Code()))
+	re.ErrorContains(err, "unknown dependency of component")
+
+	m = lifecycle.NewManager(zap.NewNop())
+	re.NoError(m.Register(newComponent(r, "a", "b")))
+	re.NoError(m.Register(newComponent(r, "b", "a")))
+	err = m.Start(ctx)
+	re.True(coderr.Is(err, lifecycle.ErrDependencyCycle.Code()))
+	re.ErrorContains(err, "dependency cycle of components")
+	re.Empty(r.take())
+}
+
+func TestStopStartedOnStartFailure(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	r := &recorder{lock: sync.Mutex{}, events: nil}
+
+	m := lifecycle.NewManager(zap.NewNop())
+	re.NoError(m.Register(newComponent(r, "etcdClient")))
+	failed := newComponent(r, "clusterManager", "etcdClient")
+	failed.Start = func(_ context.Context) error {
+		return errors.New("fail to start")
+	}
+	re.NoError(m.Register(failed))
+	re.NoError(m.Register(newComponent(r, "http", "clusterManager")))
+
+	err := m.Start(ctx)
+	re.True(coderr.Is(err, lifecycle.ErrStartComponent.Code()))
+	re.ErrorContains(err, "start component")
+	re.Equal([]string{"start etcdClient", "stop etcdClient"}, r.take())
+}
+
+func TestStopStraggler(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	r := &recorder{lock: sync.Mutex{}, events: nil}
+
+	m := lifecycle.NewManager(zap.NewNop())
+	re.NoError(m.Register(newComponent(r, "etcdClient")))
+	straggler := newComponent(r, "watcher", "etcdClient")
+	release := make(chan struct{})
+	defer close(release)
+	straggler.Stop = func(_ context.Context) error {
+		<-release
+		return nil
+	}
+	straggler.StopTimeout = time.Millisecond * 10
+	re.NoError(m.Register(straggler))
+
+	re.NoError(m.Start(ctx))
+	r.take()
+
+	// The straggler doesn't block the others from stopping.
+	err := m.Stop(ctx)
+	re.True(coderr.Is(err, lifecycle.ErrStopComponent.Code()))
+	re.ErrorContains(err, "stop component")
+	re.Equal([]string{"stop etcdClient"}, r.take())
+}
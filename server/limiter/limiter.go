@@ -17,7 +17,9 @@
 package limiter
 
 import (
+	"maps"
 	"sync"
+	"time"
 
 	"github.com/CeresDB/horaemeta/server/config"
 	"golang.org/x/time/rate"
@@ -33,17 +35,20 @@ type FlowLimiter struct {
 	limit int
 	// burst is the maximum number of tokens.
 	burst int
+	// methodWeights is the number of tokens taken by a request of the method.
+	methodWeights map[string]int
 }
 
 func NewFlowLimiter(config config.LimiterConfig) *FlowLimiter {
 	newLimiter := rate.NewLimiter(rate.Limit(config.Limit), config.Burst)
 
 	return &FlowLimiter{
-		enable: config.Enable,
-		l:      newLimiter,
-		lock:   sync.RWMutex{},
-		limit:  config.Limit,
-		burst:  config.Burst,
+		enable:        config.Enable,
+		l:             newLimiter,
+		lock:          sync.RWMutex{},
+		limit:         config.Limit,
+		burst:         config.Burst,
+		methodWeights: maps.Clone(config.MethodWeights),
 	}
 }
 
@@ -54,6 +59,23 @@ func (f *FlowLimiter) Allow() bool {
 	return f.l.Allow()
 }
 
+// AllowMethod reports whether a request of the method is allowed, which takes the tokens of the method weight. The
+// methods without weight are always allowed.
+func (f *FlowLimiter) AllowMethod(method string) bool {
+	if !f.enable {
+		return true
+	}
+
+	f.lock.RLock()
+	weight := f.methodWeights[method]
+	f.lock.RUnlock()
+
+	if weight <= 0 {
+		return true
+	}
+	return f.l.AllowN(time.Now(), weight)
+}
+
 func (f *FlowLimiter) UpdateLimiter(config config.LimiterConfig) error {
 	f.lock.Lock()
 	defer f.lock.Unlock()
@@ -63,13 +85,21 @@ func (f *FlowLimiter) UpdateLimiter(config config.LimiterConfig) error {
 	f.l.SetBurst(config.Burst)
 	f.limit = config.Limit
 	f.burst = config.Burst
+	// Keep the method weights if they are not specified.
+	if config.MethodWeights != nil {
+		f.methodWeights = maps.Clone(config.MethodWeights)
+	}
 	return nil
 }
 
 func (f *FlowLimiter) GetConfig() *config.LimiterConfig {
+	f.lock.RLock()
+	defer f.lock.RUnlock()
+
 	return &config.LimiterConfig{
-		Enable: f.enable,
-		Limit:  f.limit,
-		Burst:  f.burst,
+		Enable:        f.enable,
+		Limit:         f.limit,
+		Burst:         f.burst,
+		MethodWeights: maps.Clone(f.methodWeights),
 	}
 }
@@ -35,9 +35,10 @@ const (
 func TestFlowLimiter(t *testing.T) {
 	re := require.New(t)
 	flowLimiter := NewFlowLimiter(config.LimiterConfig{
-		Limit:  defaultInitialLimiterRate,
-		Burst:  defaultInitialLimiterCapacity,
-		Enable: defaultEnableLimiter,
+		Limit:         defaultInitialLimiterRate,
+		Burst:         defaultInitialLimiterCapacity,
+		Enable:        defaultEnableLimiter,
+		MethodWeights: nil,
 	})
 
 	for i := 0; i < defaultInitialLimiterCapacity; i++ {
@@ -55,6 +56,8 @@ func TestFlowLimiter(t *testing.T) {
 		Limit:  defaultUpdateLimiterRate,
 		Burst:  defaultUpdateLimiterCapacity,
 		Enable: defaultEnableLimiter,
+		// Keep the method weights unchanged.
+		MethodWeights: nil,
 	})
 	re.NoError(err)
 
@@ -69,3 +72,41 @@ func TestFlowLimiter(t *testing.T) {
 		re.Equal(true, flag)
 	}
 }
+
+func TestFlowLimiterMethodWeights(t *testing.T) {
+	re := require.New(t)
+	flowLimiter := NewFlowLimiter(config.LimiterConfig{
+		Limit:         1,
+		Burst:         10,
+		Enable:        defaultEnableLimiter,
+		MethodWeights: map[string]int{"RouteTables": 1, "GetTablesOfShards": 5},
+	})
+
+	// The burst is taken by 2 requests of weight 5.
+	re.True(flowLimiter.AllowMethod("GetTablesOfShards"))
+	re.True(flowLimiter.AllowMethod("GetTablesOfShards"))
+	re.False(flowLimiter.AllowMethod("GetTablesOfShards"))
+	re.False(flowLimiter.AllowMethod("RouteTables"))
+	// The methods without weight are not limited.
+	re.True(flowLimiter.AllowMethod("NodeHeartbeat"))
+
+	// The method weights are kept if not specified.
+	err := flowLimiter.UpdateLimiter(config.LimiterConfig{
+		Limit:         1,
+		Burst:         10,
+		Enable:        defaultEnableLimiter,
+		MethodWeights: nil,
+	})
+	re.NoError(err)
+	re.Equal(map[string]int{"RouteTables": 1, "GetTablesOfShards": 5}, flowLimiter.GetConfig().MethodWeights)
+
+	err = flowLimiter.UpdateLimiter(config.LimiterConfig{
+		Limit:         1,
+		Burst:         10,
+		Enable:        false,
+		MethodWeights: map[string]int{"RouteTables": 1},
+	})
+	re.NoError(err)
+	re.True(flowLimiter.AllowMethod("GetTablesOfShards"))
+	re.Equal(map[string]int{"RouteTables": 1}, flowLimiter.GetConfig().MethodWeights)
+}
@@ -28,4 +28,8 @@ var (
 	ErrGrantLease         = coderr.NewCodeError(coderr.Internal, "grant lease")
 	ErrRevokeLease        = coderr.NewCodeError(coderr.Internal, "revoke lease")
 	ErrCloseLease         = coderr.NewCodeError(coderr.Internal, "close lease")
+	ErrPutRegistration    = coderr.NewCodeError(coderr.Internal, "put member registration")
+	ErrListRegistrations  = coderr.NewCodeError(coderr.Internal, "list member registrations")
+	ErrGetRegistration    = coderr.NewCodeError(coderr.Internal, "get member registration")
+	ErrGetLeaderLease     = coderr.NewCodeError(coderr.Internal, "get lease of leader key")
 )
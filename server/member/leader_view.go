@@ -0,0 +1,75 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package member
+
+import (
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metastoragepb"
+)
+
+// leaderView caches the leader of the cluster in memory, which is refreshed by the leader watcher.
+// The cached leader is only valid within the lease of the leader key, so a leader which may have lost its lease is never
+// trusted, and the leader key is queried instead.
+type leaderView struct {
+	lock       sync.RWMutex
+	leader     *metastoragepb.Member
+	validUntil time.Time
+}
+
+func newLeaderView() *leaderView {
+	return &leaderView{
+		lock:       sync.RWMutex{},
+		leader:     nil,
+		validUntil: time.Time{},
+	}
+}
+
+// set caches the leader until validUntil.
+func (v *leaderView) set(leader *metastoragepb.Member, validUntil time.Time) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.leader = leader
+	v.validUntil = validUntil
+}
+
+// extend postpones the validity of the cached leader, and it does nothing if no leader is cached.
+func (v *leaderView) extend(validUntil time.Time) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if v.leader != nil && validUntil.After(v.validUntil) {
+		v.validUntil = validUntil
+	}
+}
+
+func (v *leaderView) reset() {
+	v.set(nil, time.Time{})
+}
+
+// get returns the cached leader, and false if no leader is cached or its validity has expired.
+func (v *leaderView) get(now time.Time) (*metastoragepb.Member, bool) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+
+	if v.leader == nil || !now.Before(v.validUntil) {
+		return nil, false
+	}
+	return v.leader, true
+}
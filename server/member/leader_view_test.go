@@ -0,0 +1,59 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package member
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metastoragepb"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLeaderView(t *testing.T) {
+	re := require.New(t)
+
+	view := newLeaderView()
+	now := time.Now()
+	_, ok := view.get(now)
+	re.False(ok)
+
+	// Nothing is extended without the cached leader.
+	view.extend(now.Add(time.Second))
+	_, ok = view.get(now)
+	re.False(ok)
+
+	leader := &metastoragepb.Member{Name: "mem0", Id: 0, Endpoint: "127.0.0.1:2379"}
+	view.set(leader, now.Add(time.Second))
+	cached, ok := view.get(now)
+	re.True(ok)
+	re.Equal(leader.Endpoint, cached.Endpoint)
+	_, ok = view.get(now.Add(time.Second))
+	re.False(ok)
+
+	// The validity is only postponed.
+	view.extend(now.Add(2 * time.Second))
+	view.extend(now.Add(time.Second))
+	_, ok = view.get(now.Add(time.Second))
+	re.True(ok)
+	_, ok = view.get(now.Add(2 * time.Second))
+	re.False(ok)
+
+	view.reset()
+	_, ok = view.get(now)
+	re.False(ok)
+}
@@ -20,6 +20,7 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/CeresDB/horaedbproto/golang/pkg/metastoragepb"
@@ -36,43 +37,59 @@ const leaderCheckInterval = time.Duration(100) * time.Millisecond
 
 // Member manages the leadership and the role of the node in the horaemeta cluster.
 type Member struct {
-	ID               uint64
-	Name             string
-	Endpoint         string
+	ID       uint64
+	Name     string
+	Endpoint string
+	// HTTPEndpoint is the advertised url of the http service, which is registered for the others to forward the http
+	// requests to the leader.
+	HTTPEndpoint     string
 	rootPath         string
 	leaderKey        string
 	etcdCli          *clientv3.Client
 	etcdLeaderGetter etcdutil.EtcdLeaderGetter
-	leader           *metastoragepb.Member
-	rpcTimeout       time.Duration
-	logger           *zap.Logger
+	leaderView       *leaderView
+	// rpcTimeout is stored as the nanoseconds, and it can be updated when the config is reloaded.
+	rpcTimeout atomic.Int64
+	logger     *zap.Logger
 }
 
 func formatLeaderKey(rootPath string) string {
 	return fmt.Sprintf("%s/members/leader", rootPath)
 }
 
-func NewMember(rootPath string, id uint64, name, endpoint string, etcdCli *clientv3.Client, etcdLeaderGetter etcdutil.EtcdLeaderGetter, rpcTimeout time.Duration) *Member {
+func NewMember(rootPath string, id uint64, name, endpoint, httpEndpoint string, etcdCli *clientv3.Client, etcdLeaderGetter etcdutil.EtcdLeaderGetter, rpcTimeout time.Duration) *Member {
 	leaderKey := formatLeaderKey(rootPath)
 	logger := log.With(zap.String("node-name", name), zap.Uint64("node-id", id))
-	return &Member{
+	member := &Member{
 		ID:               id,
 		Name:             name,
 		Endpoint:         endpoint,
+		HTTPEndpoint:     httpEndpoint,
 		rootPath:         rootPath,
 		leaderKey:        leaderKey,
 		etcdCli:          etcdCli,
 		etcdLeaderGetter: etcdLeaderGetter,
-		leader:           nil,
-		rpcTimeout:       rpcTimeout,
+		leaderView:       newLeaderView(),
+		rpcTimeout:       atomic.Int64{},
 		logger:           logger,
 	}
+	member.rpcTimeout.Store(int64(rpcTimeout))
+	return member
+}
+
+// SetRPCTimeout updates the timeout of the etcd calls made by the member.
+func (m *Member) SetRPCTimeout(rpcTimeout time.Duration) {
+	m.rpcTimeout.Store(int64(rpcTimeout))
+}
+
+func (m *Member) getRPCTimeout() time.Duration {
+	return time.Duration(m.rpcTimeout.Load())
 }
 
 // getLeader gets the leader of the cluster.
 // getLeaderResp.Leader == nil if no leader found.
 func (m *Member) getLeader(ctx context.Context) (*getLeaderResp, error) {
-	ctx, cancel := context.WithTimeout(ctx, m.rpcTimeout)
+	ctx, cancel := context.WithTimeout(ctx, m.getRPCTimeout())
 	defer cancel()
 	resp, err := m.etcdCli.Get(ctx, m.leaderKey)
 	if err != nil {
@@ -83,9 +100,11 @@ func (m *Member) getLeader(ctx context.Context) (*getLeaderResp, error) {
 	}
 	if len(resp.Kvs) == 0 {
 		return &getLeaderResp{
-			Leader:   nil,
-			Revision: 0,
-			IsLocal:  false,
+			Leader:         nil,
+			Revision:       0,
+			CreateRevision: 0,
+			LeaseID:        clientv3.NoLease,
+			IsLocal:        false,
 		}, nil
 	}
 
@@ -96,26 +115,57 @@ func (m *Member) getLeader(ctx context.Context) (*getLeaderResp, error) {
 		return nil, ErrInvalidLeaderValue.WithCause(err)
 	}
 
-	return &getLeaderResp{Leader: leader, Revision: leaderKv.ModRevision, IsLocal: leader.GetEndpoint() == m.Endpoint}, nil
+	return &getLeaderResp{
+		Leader:         leader,
+		Revision:       leaderKv.ModRevision,
+		CreateRevision: leaderKv.CreateRevision,
+		LeaseID:        clientv3.LeaseID(leaderKv.Lease),
+		IsLocal:        leader.GetEndpoint() == m.Endpoint,
+	}, nil
 }
 
-// GetLeaderAddr gets the leader address of the cluster with memory cache.
+// GetLeaderAddr gets the leader address of the cluster with memory cache, and the leader key is only queried if the
+// cache is not valid, e.g. the leader is changing.
 // return error if no leader found.
-func (m *Member) GetLeaderAddr(_ context.Context) (GetLeaderAddrResp, error) {
-	if m.leader == nil {
+func (m *Member) GetLeaderAddr(ctx context.Context) (GetLeaderAddrResp, error) {
+	if leader, ok := m.leaderView.get(time.Now()); ok {
 		return GetLeaderAddrResp{
+			LeaderName:     leader.Name,
+			LeaderEndpoint: leader.Endpoint,
+			IsLocal:        leader.Endpoint == m.Endpoint,
+		}, nil
+	}
+	return m.GetLeaderAddrFromEtcd(ctx)
+}
+
+// GetLeaderAddrFromEtcd gets the leader address of the cluster by querying the leader key, which is useful when the
+// memory cache may not catch up with the leader change yet.
+// return error if no leader found.
+func (m *Member) GetLeaderAddrFromEtcd(ctx context.Context) (GetLeaderAddrResp, error) {
+	resp, err := m.getLeader(ctx)
+	if err != nil {
+		return GetLeaderAddrResp{
+			LeaderName:     "",
+			LeaderEndpoint: "",
+			IsLocal:        false,
+		}, err
+	}
+	if resp.Leader == nil {
+		return GetLeaderAddrResp{
+			LeaderName:     "",
 			LeaderEndpoint: "",
 			IsLocal:        false,
 		}, errors.WithMessage(ErrGetLeader, "no leader found")
 	}
 	return GetLeaderAddrResp{
-		LeaderEndpoint: m.leader.Endpoint,
-		IsLocal:        m.leader.Endpoint == m.Endpoint,
+		LeaderName:     resp.Leader.Name,
+		LeaderEndpoint: resp.Leader.Endpoint,
+		IsLocal:        resp.IsLocal,
 	}, nil
 }
 
 func (m *Member) ResetLeader(ctx context.Context) error {
-	ctx, cancel := context.WithTimeout(ctx, m.rpcTimeout)
+	ctx, cancel := context.WithTimeout(ctx, m.getRPCTimeout())
 	defer cancel()
 	if _, err := m.etcdCli.Delete(ctx, m.leaderKey); err != nil {
 		return ErrResetLeader.WithCause(err)
@@ -123,7 +173,10 @@ func (m *Member) ResetLeader(ctx context.Context) error {
 	return nil
 }
 
-func (m *Member) WaitForLeaderChange(ctx context.Context, revision int64) {
+// WaitForLeaderChange blocks until the leader key is deleted, and the cached leader is kept valid for leaseTTL since the
+// leader key is known to be unchanged. The watch is canceled once the connected etcd member loses the etcd leader, so the
+// cached leader will expire if the changes of the leader key may be missed.
+func (m *Member) WaitForLeaderChange(ctx context.Context, revision int64, leaseTTL time.Duration) {
 	watcher := clientv3.NewWatcher(m.etcdCli)
 	defer func() {
 		if err := watcher.Close(); err != nil {
@@ -134,16 +187,32 @@ func (m *Member) WaitForLeaderChange(ctx context.Context, revision int64) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	refreshTicker := time.NewTicker(leaderCheckInterval)
+	defer refreshTicker.Stop()
+
 	for {
-		wch := watcher.Watch(ctx, m.leaderKey, clientv3.WithRev(revision))
-		for resp := range wch {
+		wch := watcher.Watch(clientv3.WithRequireLeader(ctx), m.leaderKey, clientv3.WithRev(revision))
+	L:
+		for {
+			var resp clientv3.WatchResponse
+			select {
+			case <-refreshTicker.C:
+				m.leaderView.extend(time.Now().Add(leaseTTL))
+				continue
+			case watchResp, ok := <-wch:
+				if !ok {
+					break L
+				}
+				resp = watchResp
+			}
+
 			// Meet compacted error, use the compact revision.
 			if resp.CompactRevision != 0 {
 				m.logger.Warn("required revision has been compacted, use the compact revision",
 					zap.Int64("required-revision", revision),
 					zap.Int64("compact-revision", resp.CompactRevision))
 				revision = resp.CompactRevision
-				break
+				break L
 			}
 
 			if resp.Canceled {
@@ -179,7 +248,7 @@ func (m *Member) CampaignAndKeepLeader(ctx context.Context, leaseTTLSec int64, l
 	closeLeaseWg := sync.WaitGroup{}
 	closeLease := func() {
 		log.Debug("try to close lease")
-		ctx1, cancel := context.WithTimeout(context.Background(), m.rpcTimeout)
+		ctx1, cancel := context.WithTimeout(context.Background(), m.getRPCTimeout())
 		defer cancel()
 		if err := newLease.Close(ctx1); err != nil {
 			m.logger.Error("close lease failed", zap.Error(err))
@@ -188,7 +257,7 @@ func (m *Member) CampaignAndKeepLeader(ctx context.Context, leaseTTLSec int64, l
 	}
 	defer closeLeaseOnce.Do(closeLease)
 
-	ctx1, cancel := context.WithTimeout(ctx, m.rpcTimeout)
+	ctx1, cancel := context.WithTimeout(ctx, m.getRPCTimeout())
 	defer cancel()
 	if err := newLease.Grant(ctx1); err != nil {
 		return err
@@ -196,7 +265,7 @@ func (m *Member) CampaignAndKeepLeader(ctx context.Context, leaseTTLSec int64, l
 
 	// The leader key must not exist, so the CreateRevision is 0.
 	cmp := clientv3.Compare(clientv3.CreateRevision(m.leaderKey), "=", 0)
-	ctx1, cancel = context.WithTimeout(ctx, m.rpcTimeout)
+	ctx1, cancel = context.WithTimeout(ctx, m.getRPCTimeout())
 	defer cancel()
 	resp, err := m.etcdCli.
 		Txn(ctx1).
@@ -210,12 +279,13 @@ func (m *Member) CampaignAndKeepLeader(ctx context.Context, leaseTTLSec int64, l
 	}
 
 	m.logger.Info("[SetLeader]", zap.String("leader-key", m.leaderKey), zap.String("leader", m.Name))
-	// Update leader memory cache.
-	m.leader = &metastoragepb.Member{
+	// Update leader memory cache, which is valid as long as the lease of the leader key.
+	m.leaderView.set(&metastoragepb.Member{
 		Name:     m.Name,
 		Id:       m.ID,
 		Endpoint: m.Endpoint,
-	}
+	}, newLease.getExpireTime())
+	defer m.leaderView.reset()
 
 	if callbacks != nil {
 		// The leader has been elected and trigger the callbacks.
@@ -245,6 +315,7 @@ func (m *Member) CampaignAndKeepLeader(ctx context.Context, leaseTTLSec int64, l
 				m.logger.Info("no longer a leader because lease has expired")
 				return nil
 			}
+			m.leaderView.extend(newLease.getExpireTime())
 
 			if !leadershipChecker.ShouldCampaign(m) {
 				m.logger.Info("etcd leader changed and should re-assign the leadership", zap.String("old-leader", m.Name))
@@ -274,10 +345,14 @@ func (m *Member) Marshal() (string, error) {
 type getLeaderResp struct {
 	Leader   *metastoragepb.Member
 	Revision int64
-	IsLocal  bool
+	// CreateRevision is the revision when the leader key is put by the leader.
+	CreateRevision int64
+	LeaseID        clientv3.LeaseID
+	IsLocal        bool
 }
 
 type GetLeaderAddrResp struct {
+	LeaderName     string
 	LeaderEndpoint string
 	IsLocal        bool
 }
@@ -0,0 +1,218 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package member
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const registerFailInterval = time.Duration(1) * time.Second
+
+// BuildInfo describes the build of the horaemeta server.
+type BuildInfo struct {
+	CommitID   string `json:"commitID"`
+	BranchName string `json:"branchName"`
+	BuildDate  string `json:"buildDate"`
+}
+
+// Registration is put by every member of the horaemeta cluster with a lease, so the alive members can be listed along
+// with their builds.
+type Registration struct {
+	Name     string `json:"name"`
+	ID       uint64 `json:"id"`
+	Endpoint string `json:"endpoint"`
+	// HTTPEndpoint is empty if the member is registered by an old version.
+	HTTPEndpoint string    `json:"httpEndpoint,omitempty"`
+	Build        BuildInfo `json:"build"`
+	StartTimeMs  int64     `json:"startTimeMs"`
+}
+
+// ElectionStatus describes the leader key of the horaemeta cluster.
+type ElectionStatus struct {
+	LeaderName     string `json:"leaderName"`
+	LeaderEndpoint string `json:"leaderEndpoint"`
+	// Term is the revision when the leader key is put, which increases whenever a new leader is elected.
+	Term    int64 `json:"term"`
+	LeaseID int64 `json:"leaseID"`
+	// LeaseGrantedTTLSec and LeaseRemainingTTLSec are zero if the lease of the leader key is not found.
+	LeaseGrantedTTLSec   int64 `json:"leaseGrantedTTLSec"`
+	LeaseRemainingTTLSec int64 `json:"leaseRemainingTTLSec"`
+}
+
+func formatRegistrationPrefix(rootPath string) string {
+	return fmt.Sprintf("%s/members/registrations/", rootPath)
+}
+
+func formatRegistrationKey(rootPath, name string) string {
+	return formatRegistrationPrefix(rootPath) + name
+}
+
+// KeepRegistered registers the member until ctx is done, and it registers again if the registration is lost, e.g. the
+// lease expires because etcd is not reachable for a while.
+func (m *Member) KeepRegistered(ctx context.Context, build BuildInfo, leaseTTLSec int64) {
+	registration := Registration{
+		Name:         m.Name,
+		ID:           m.ID,
+		Endpoint:     m.Endpoint,
+		HTTPEndpoint: m.HTTPEndpoint,
+		Build:        build,
+		StartTimeMs:  time.Now().UnixMilli(),
+	}
+	value, err := json.Marshal(registration)
+	if err != nil {
+		m.logger.Error("fail to marshal registration", zap.Error(err))
+		return
+	}
+
+	for {
+		if err := m.register(ctx, string(value), leaseTTLSec); err != nil {
+			m.logger.Error("fail to register member", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(registerFailInterval):
+		}
+	}
+}
+
+// register puts the registration with a new lease, and blocks until the lease is lost or ctx is done.
+func (m *Member) register(ctx context.Context, value string, leaseTTLSec int64) error {
+	rawLease := clientv3.NewLease(m.etcdCli)
+	defer func() {
+		if err := rawLease.Close(); err != nil {
+			m.logger.Error("close lease failed", zap.Error(err))
+		}
+	}()
+
+	ctx1, cancel := context.WithTimeout(ctx, m.getRPCTimeout())
+	defer cancel()
+	grantResp, err := rawLease.Grant(ctx1, leaseTTLSec)
+	if err != nil {
+		return ErrGrantLease.WithCause(err)
+	}
+	if _, err := m.etcdCli.Put(ctx1, formatRegistrationKey(m.rootPath, m.Name), value, clientv3.WithLease(grantResp.ID)); err != nil {
+		return ErrPutRegistration.WithCause(err)
+	}
+
+	keepAliveCh, err := rawLease.KeepAlive(ctx, grantResp.ID)
+	if err != nil {
+		return ErrGrantLease.WithCause(err)
+	}
+	for resp := range keepAliveCh {
+		m.logger.Debug("registration lease is renewed", zap.Int64("ttl", resp.TTL))
+	}
+
+	if ctx.Err() == nil {
+		return errors.WithMessage(ErrPutRegistration, "registration lease is lost")
+	}
+	// Revoke the lease so that the member is unregistered at once when it is stopped.
+	ctx2, cancel := context.WithTimeout(context.Background(), m.getRPCTimeout())
+	defer cancel()
+	if _, err := rawLease.Revoke(ctx2, grantResp.ID); err != nil {
+		return ErrRevokeLease.WithCause(err)
+	}
+	return nil
+}
+
+// ListRegistrations lists the registrations of the alive members, sorted by name.
+func (m *Member) ListRegistrations(ctx context.Context) ([]Registration, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.getRPCTimeout())
+	defer cancel()
+	resp, err := m.etcdCli.Get(ctx, formatRegistrationPrefix(m.rootPath), clientv3.WithPrefix())
+	if err != nil {
+		return nil, ErrListRegistrations.WithCause(err)
+	}
+
+	registrations := make([]Registration, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		var registration Registration
+		if err := json.Unmarshal(kv.Value, &registration); err != nil {
+			return nil, ErrListRegistrations.WithCausef("decode registration, key:%s, err:%v", kv.Key, err)
+		}
+		registrations = append(registrations, registration)
+	}
+	sort.Slice(registrations, func(i, j int) bool {
+		return registrations[i].Name < registrations[j].Name
+	})
+	return registrations, nil
+}
+
+// GetRegistration gets the registration of the member, and the second output parameter bool: returns true if the member is
+// registered.
+func (m *Member) GetRegistration(ctx context.Context, name string) (Registration, bool, error) {
+	var emptyRegistration Registration
+
+	ctx, cancel := context.WithTimeout(ctx, m.getRPCTimeout())
+	defer cancel()
+	resp, err := m.etcdCli.Get(ctx, formatRegistrationKey(m.rootPath, name))
+	if err != nil {
+		return emptyRegistration, false, ErrGetRegistration.WithCause(err)
+	}
+	if len(resp.Kvs) == 0 {
+		return emptyRegistration, false, nil
+	}
+
+	var registration Registration
+	if err := json.Unmarshal(resp.Kvs[0].Value, &registration); err != nil {
+		return emptyRegistration, false, ErrGetRegistration.WithCausef("decode registration, name:%s, err:%v", name, err)
+	}
+	return registration, true, nil
+}
+
+// GetElectionStatus gets the leader key and its lease.
+// return error if no leader found.
+func (m *Member) GetElectionStatus(ctx context.Context) (ElectionStatus, error) {
+	resp, err := m.getLeader(ctx)
+	if err != nil {
+		return ElectionStatus{}, err
+	}
+	if resp.Leader == nil {
+		return ElectionStatus{}, errors.WithMessage(ErrGetLeader, "no leader found")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.getRPCTimeout())
+	defer cancel()
+	ttlResp, err := m.etcdCli.TimeToLive(ctx, resp.LeaseID)
+	if err != nil {
+		return ElectionStatus{}, ErrGetLeaderLease.WithCause(err)
+	}
+
+	status := ElectionStatus{
+		LeaderName:           resp.Leader.Name,
+		LeaderEndpoint:       resp.Leader.Endpoint,
+		Term:                 resp.CreateRevision,
+		LeaseID:              int64(resp.LeaseID),
+		LeaseGrantedTTLSec:   0,
+		LeaseRemainingTTLSec: 0,
+	}
+	// The TTL is -1 if the lease has expired or been revoked.
+	if ttlResp.TTL >= 0 {
+		status.LeaseGrantedTTLSec = ttlResp.GrantedTTL
+		status.LeaseRemainingTTLSec = ttlResp.TTL
+	}
+	return status, nil
+}
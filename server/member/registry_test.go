@@ -0,0 +1,78 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package member
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/server/etcdutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepRegistered(t *testing.T) {
+	re := require.New(t)
+	etcd, client, closeSrv := etcdutil.PrepareEtcdServerAndClient(t)
+	defer closeSrv()
+
+	leaderGetter := &etcdutil.LeaderGetterWrapper{Server: etcd.Server}
+	rpcTimeout := time.Duration(10) * time.Second
+	mem0 := NewMember("/registry", 0, "mem0", "127.0.0.1:2379", "http://127.0.0.1:8080", client, leaderGetter, rpcTimeout)
+	mem1 := NewMember("/registry", 1, "mem1", "127.0.0.1:2380", "http://127.0.0.1:8081", client, leaderGetter, rpcTimeout)
+	build := BuildInfo{CommitID: "abc", BranchName: "main", BuildDate: "2024-01-01"}
+
+	ctx := context.Background()
+	ctx0, cancel0 := context.WithCancel(ctx)
+	ctx1, cancel1 := context.WithCancel(ctx)
+	defer cancel1()
+	done0 := make(chan struct{})
+	go func() {
+		mem0.KeepRegistered(ctx0, build, 10)
+		close(done0)
+	}()
+	go mem1.KeepRegistered(ctx1, build, 10)
+
+	re.Eventually(func() bool {
+		registrations, err := mem0.ListRegistrations(ctx)
+		re.NoError(err)
+		return len(registrations) == 2
+	}, 5*time.Second, 50*time.Millisecond)
+	registrations, err := mem1.ListRegistrations(ctx)
+	re.NoError(err)
+	re.Equal("mem0", registrations[0].Name)
+	re.Equal("127.0.0.1:2379", registrations[0].Endpoint)
+	re.Equal("http://127.0.0.1:8080", registrations[0].HTTPEndpoint)
+	re.Equal(build, registrations[0].Build)
+	re.Equal("mem1", registrations[1].Name)
+
+	registration, ok, err := mem0.GetRegistration(ctx, "mem1")
+	re.NoError(err)
+	re.True(ok)
+	re.Equal(registrations[1], registration)
+
+	// The registration is removed at once when the member is stopped.
+	cancel0()
+	<-done0
+	registrations, err = mem1.ListRegistrations(ctx)
+	re.NoError(err)
+	re.Len(registrations, 1)
+	re.Equal("mem1", registrations[0].Name)
+	_, ok, err = mem1.GetRegistration(ctx, "mem0")
+	re.NoError(err)
+	re.False(ok)
+}
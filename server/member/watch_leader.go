@@ -166,16 +166,18 @@ func (l *LeaderWatcher) Watch(ctx context.Context, callbacks LeadershipEventCall
 			// For other nodes that is not etcd leader, just wait for the new leader elected.
 			wait = waitReasonElectLeader
 		} else {
-			// Cache leader in memory.
-			l.self.leader = memLeader
-			log.Info("update leader cache", zap.String("endpoint", memLeader.Endpoint))
-
 			// Leader does exist.
 			// A new leader should be elected (the leader should be reset by the current leader itself) if the leader is
 			// not the etcd leader.
 			if l.leadershipChecker.IsValidLeader(memLeader) {
+				// Cache leader in memory until the lease of the leader key may expire.
+				leaseTTL := time.Duration(l.leaseTTLSec) * time.Second
+				l.self.leaderView.set(memLeader, time.Now().Add(leaseTTL))
+				log.Info("update leader cache", zap.String("endpoint", memLeader.Endpoint))
+
 				// watch the leader and block until leader changes.
-				l.self.WaitForLeaderChange(ctx, resp.Revision)
+				l.self.WaitForLeaderChange(ctx, resp.Revision, leaseTTL)
+				l.self.leaderView.reset()
 				logger.Warn("leader changes and stop watching")
 				continue
 			}
@@ -53,7 +53,7 @@ func TestWatchLeaderSingle(t *testing.T) {
 	leaderGetter := &etcdutil.LeaderGetterWrapper{Server: etcd.Server}
 	rpcTimeout := time.Duration(10) * time.Second
 	leaseTTLSec := int64(1)
-	mem := NewMember("", uint64(etcd.Server.ID()), "mem0", "", client, leaderGetter, rpcTimeout)
+	mem := NewMember("", uint64(etcd.Server.ID()), "mem0", "", "", client, leaderGetter, rpcTimeout)
 	leaderWatcher := NewLeaderWatcher(watchCtx, mem, leaseTTLSec, true)
 
 	ctx, cancelWatch := context.WithCancel(context.Background())
@@ -75,10 +75,31 @@ func TestWatchLeaderSingle(t *testing.T) {
 	assert.NotNil(t, resp)
 	assert.Equal(t, resp.Leader.Id, mem.ID)
 
+	// check the leader is cached
+	cachedLeader, ok := mem.leaderView.get(time.Now())
+	assert.True(t, ok)
+	assert.Equal(t, mem.ID, cachedLeader.Id)
+	leaderAddr, err := mem.GetLeaderAddr(ctx)
+	assert.NoError(t, err)
+	assert.True(t, leaderAddr.IsLocal)
+
+	// check the election status
+	election, err := mem.GetElectionStatus(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, "mem0", election.LeaderName)
+	assert.Positive(t, election.Term)
+	// etcd may grant a longer ttl than the requested one.
+	assert.GreaterOrEqual(t, election.LeaseGrantedTTLSec, leaseTTLSec)
+	assert.LessOrEqual(t, election.LeaseRemainingTTLSec, election.LeaseGrantedTTLSec)
+
 	// cancel the watch
 	cancelWatch()
 	<-watchedDone
 
+	// check the leader cache is reset
+	_, ok = mem.leaderView.get(time.Now())
+	assert.False(t, ok)
+
 	// check again whether the leader should be reset
 	ctx, cancel = context.WithTimeout(context.Background(), rpcTimeout)
 	defer cancel()
@@ -86,4 +107,6 @@ func TestWatchLeaderSingle(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotNil(t, resp)
 	assert.Nil(t, resp.Leader)
+	_, err = mem.GetElectionStatus(ctx)
+	assert.Error(t, err)
 }
@@ -21,19 +21,26 @@ import (
 	"crypto/tls"
 	"fmt"
 	"net"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
-	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/pkg/coderr"
 	"github.com/CeresDB/horaemeta/pkg/log"
 	"github.com/CeresDB/horaemeta/server/cluster"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/config"
+	"github.com/CeresDB/horaemeta/server/coordinator"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
 	"github.com/CeresDB/horaemeta/server/etcdutil"
+	"github.com/CeresDB/horaemeta/server/event"
+	"github.com/CeresDB/horaemeta/server/id"
+	"github.com/CeresDB/horaemeta/server/lifecycle"
 	"github.com/CeresDB/horaemeta/server/limiter"
 	"github.com/CeresDB/horaemeta/server/member"
+	"github.com/CeresDB/horaemeta/server/service"
 	metagrpc "github.com/CeresDB/horaemeta/server/service/grpc"
 	"github.com/CeresDB/horaemeta/server/service/http"
 	"github.com/CeresDB/horaemeta/server/status"
@@ -47,13 +54,44 @@ import (
 	"google.golang.org/grpc/keepalive"
 )
 
+// The components of the server, and the dependencies among them are declared in registerComponents.
+const (
+	componentEmbedEtcd      = "embedEtcd"
+	componentEtcdClient     = "etcdClient"
+	componentClusterManager = "clusterManager"
+	componentHTTPService    = "httpService"
+	componentGrpcServer     = "grpcServer"
+	componentLeaderElection = "leaderElection"
+
+	embedEtcdStopTimeout      = time.Second * 10
+	etcdClientStopTimeout     = time.Second * 3
+	clusterManagerStopTimeout = time.Second * 30
+	httpServiceStopTimeout    = time.Second * 10
+	httpReadTimeout           = time.Second * 10
+	httpWriteTimeout          = time.Second * 10
+	grpcServerStopTimeout     = time.Second * 10
+	leaderElectionStopTimeout = time.Second * 10
+
+	// embedEtcdAlarmCheckInterval is the interval to check the alarms of the embedded etcd.
+	embedEtcdAlarmCheckInterval = time.Second * 5
+)
+
 type Server struct {
 	isClosed int32
 	status   *status.ServerStatus
 
 	cfg *config.Config
+	// configLoader loads the config again to reload the dynamic settings, and configLock serializes the reloading.
+	configLoader func() (*config.Config, error)
+	configLock   sync.Mutex
+	// buildInfo is registered along with the member, so the builds of the members can be listed.
+	buildInfo member.BuildInfo
 
 	etcdCfg *embed.Config
+	// staticTopology is provisioned instead of the default cluster if it is not nil.
+	staticTopology *config.StaticTopology
+	// etcdMaintenanceOpts is parsed from the config before the server is started, so the invalid config fails early.
+	etcdMaintenanceOpts etcdutil.MaintenanceOptions
 
 	// The fields below are initialized after Run of server is called.
 	clusterManager cluster.Manager
@@ -63,9 +101,20 @@ type Server struct {
 	member  *member.Member
 	etcdCli *clientv3.Client
 	etcdSrv *embed.Etcd
+	// etcdEmbedMonitor marks the server as degraded if the embedded etcd raises alarms, and it is nil if etcd is not
+	// embedded.
+	etcdEmbedMonitor *etcdutil.EmbedMonitor
+	// etcdMaintainer compacts and defragments etcd only when the server is the leader.
+	etcdMaintainer *etcdutil.Maintainer
+	// etcdEndpointManager keeps the etcd client on a healthy member of etcd on every server.
+	etcdEndpointManager *etcdutil.EndpointManager
 
 	// httpService contains http server and api set.
 	httpService *http.Service
+	// grpcServer is only set if the etcd is not embedded, otherwise the grpc service is served by the etcd server.
+	grpcServer *grpc.Server
+	// lifecycle starts and stops the components of the server in the order of their dependencies.
+	lifecycle *lifecycle.Manager
 
 	// bgJobWg can be used to join with the background jobs.
 	bgJobWg sync.WaitGroup
@@ -80,25 +129,60 @@ func CreateServer(cfg *config.Config) (*Server, error) {
 		return nil, err
 	}
 
+	var staticTopology *config.StaticTopology
+	if len(cfg.StaticTopologyFile) > 0 {
+		topology, err := config.LoadStaticTopology(cfg.StaticTopologyFile)
+		if err != nil {
+			return nil, err
+		}
+		staticTopology = &topology
+	} else if cfg.DeployMode == config.DeployModeStandalone {
+		topology := cfg.StandaloneTopology()
+		staticTopology = &topology
+	}
+
+	etcdMaintenanceOpts := etcdutil.MaintenanceOptions{
+		CompactionInterval: cfg.EtcdCompactionInterval(),
+		RetainedRevisions:  cfg.EtcdCompactionRetainedRevisions,
+		DefragWindow:       nil,
+	}
+	if len(cfg.EtcdDefragWindow) > 0 {
+		defragWindow, err := etcdutil.ParseDefragWindow(cfg.EtcdDefragWindow)
+		if err != nil {
+			return nil, err
+		}
+		etcdMaintenanceOpts.DefragWindow = &defragWindow
+	}
+
 	srv := &Server{
-		isClosed: 0,
-		status:   status.NewServerStatus(),
-		cfg:      cfg,
-		etcdCfg:  etcdCfg,
-
-		clusterManager: nil,
-		flowLimiter:    nil,
-		member:         nil,
-		etcdCli:        nil,
-		etcdSrv:        nil,
-		httpService:    nil,
-		bgJobWg:        sync.WaitGroup{},
-		bgJobCancel:    nil,
-	}
-
-	grpcService := metagrpc.NewService(cfg.GrpcHandleTimeout(), srv)
+		isClosed:            0,
+		status:              status.NewServerStatus(),
+		cfg:                 cfg,
+		configLoader:        nil,
+		configLock:          sync.Mutex{},
+		buildInfo:           member.BuildInfo{CommitID: "", BranchName: "", BuildDate: ""},
+		etcdCfg:             etcdCfg,
+		staticTopology:      staticTopology,
+		etcdMaintenanceOpts: etcdMaintenanceOpts,
+
+		clusterManager:      nil,
+		flowLimiter:         nil,
+		member:              nil,
+		etcdCli:             nil,
+		etcdSrv:             nil,
+		etcdEmbedMonitor:    nil,
+		etcdMaintainer:      nil,
+		etcdEndpointManager: nil,
+		httpService:         nil,
+		grpcServer:          nil,
+		lifecycle:           lifecycle.NewManager(log.With(zap.String("module", "lifecycle"))),
+		bgJobWg:             sync.WaitGroup{},
+		bgJobCancel:         nil,
+	}
+
+	grpcService := metagrpc.NewService(grpcServiceOptions(cfg), srv)
 	etcdCfg.ServiceRegister = func(grpcSrv *grpc.Server) {
-		grpcSrv.RegisterService(&metaservicepb.CeresmetaRpcService_ServiceDesc, grpcService)
+		grpcSrv.RegisterService(grpcService.ServiceDesc(), grpcService)
 	}
 
 	return srv, nil
@@ -106,54 +190,121 @@ func CreateServer(cfg *config.Config) (*Server, error) {
 
 // Run runs the services and background jobs.
 func (srv *Server) Run(ctx context.Context) error {
-	// If enableEmbedEtcd is true, the grpc server is started in the same process as the etcd server.
-	if srv.cfg.EnableEmbedEtcd {
-		if err := srv.startEmbedEtcd(ctx); err != nil {
-			srv.status.Set(status.Terminated)
-			return err
-		}
-	} else {
-		// If enableEmbedEtcd is false, the grpc server is started in a separate process.
-		go func() {
-			if err := srv.startGrpcServer(ctx); err != nil {
-				srv.status.Set(status.Terminated)
-				log.Fatal("Grpc serve failed", zap.Error(err))
-			}
-		}()
-	}
-	if err := srv.initEtcdClient(srv.cfg.EnableEmbedEtcd); err != nil {
+	if err := srv.registerComponents(); err != nil {
 		srv.status.Set(status.Terminated)
 		return err
 	}
 
-	if err := srv.startServer(ctx); err != nil {
+	if err := srv.lifecycle.Start(ctx); err != nil {
 		srv.status.Set(status.Terminated)
 		return err
 	}
 
-	srv.startBgJobs(ctx)
 	srv.status.Set(status.StatusRunning)
 	return nil
 }
 
-func (srv *Server) Close() {
-	atomic.StoreInt32(&srv.isClosed, 1)
+// registerComponents declares the components of the server, which are started in the order of the dependencies and
+// stopped in the reverse order, e.g. the leader election is stopped before the cluster manager, and the etcd client is
+// closed after all the components using it.
+func (srv *Server) registerComponents() error {
+	components := make([]lifecycle.Component, 0, 6)
 
-	srv.stopBgJobs()
+	etcdClientDeps := []string{}
+	// If enableEmbedEtcd is true, the grpc server is started in the same process as the etcd server.
+	if srv.cfg.EnableEmbedEtcd {
+		components = append(components, lifecycle.Component{
+			Name:        componentEmbedEtcd,
+			DependsOn:   nil,
+			Start:       srv.startEmbedEtcd,
+			Stop:        srv.stopEmbedEtcd,
+			StopTimeout: embedEtcdStopTimeout,
+		})
+		etcdClientDeps = append(etcdClientDeps, componentEmbedEtcd)
+	}
 
-	if srv.etcdCli != nil {
-		err := srv.etcdCli.Close()
-		if err != nil {
-			log.Error("fail to close etcdCli", zap.Error(err))
+	components = append(components,
+		lifecycle.Component{
+			Name:        componentEtcdClient,
+			DependsOn:   etcdClientDeps,
+			Start:       srv.initEtcdClient,
+			Stop:        srv.closeEtcdClient,
+			StopTimeout: etcdClientStopTimeout,
+		},
+		lifecycle.Component{
+			Name:        componentClusterManager,
+			DependsOn:   []string{componentEtcdClient},
+			Start:       srv.startClusterManager,
+			Stop:        srv.stopClusterManager,
+			StopTimeout: clusterManagerStopTimeout,
+		},
+		lifecycle.Component{
+			Name:        componentHTTPService,
+			DependsOn:   []string{componentClusterManager},
+			Start:       srv.startHTTPService,
+			Stop:        srv.stopHTTPService,
+			StopTimeout: httpServiceStopTimeout,
+		},
+	)
+
+	leaderElectionDeps := []string{componentClusterManager, componentHTTPService}
+	// If enableEmbedEtcd is false, the grpc server is started separately.
+	if !srv.cfg.EnableEmbedEtcd {
+		components = append(components, lifecycle.Component{
+			Name:        componentGrpcServer,
+			DependsOn:   []string{componentClusterManager},
+			Start:       srv.startGrpcServer,
+			Stop:        srv.stopGrpcServer,
+			StopTimeout: grpcServerStopTimeout,
+		})
+		leaderElectionDeps = append(leaderElectionDeps, componentGrpcServer)
+	}
+
+	components = append(components, lifecycle.Component{
+		Name:      componentLeaderElection,
+		DependsOn: leaderElectionDeps,
+		Start: func(ctx context.Context) error {
+			srv.startBgJobs(ctx)
+			return nil
+		},
+		Stop: func(_ context.Context) error {
+			srv.stopBgJobs()
+			return nil
+		},
+		StopTimeout: leaderElectionStopTimeout,
+	})
+
+	for _, component := range components {
+		if err := srv.lifecycle.Register(component); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	err := srv.httpService.Stop()
-	if err != nil {
-		log.Error("fail to close http server", zap.Error(err))
+func (srv *Server) Close() {
+	atomic.StoreInt32(&srv.isClosed, 1)
+	srv.drain()
+
+	if err := srv.lifecycle.Stop(context.Background()); err != nil {
+		log.Error("fail to stop server components", zap.Error(err))
 	}
 }
 
+// drain rejects the new http and grpc requests, and waits for the in-flight ones to finish within the grace period before
+// the listeners are closed.
+func (srv *Server) drain() {
+	srv.status.Set(status.StatusDraining)
+	log.Info("server starts draining", zap.Int64("inflightRequests", srv.status.InflightRequests()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), srv.cfg.ShutdownGracePeriod())
+	defer cancel()
+	if err := srv.status.WaitInflightRequests(ctx); err != nil {
+		log.Warn("in-flight requests are not drained within grace period", zap.Int64("inflightRequests", srv.status.InflightRequests()), zap.Duration("gracePeriod", srv.cfg.ShutdownGracePeriod()))
+	}
+	srv.status.Set(status.Terminated)
+}
+
 func (srv *Server) IsClosed() bool {
 	return atomic.LoadInt32(&srv.isClosed) == 1
 }
@@ -173,14 +324,28 @@ func (srv *Server) startEmbedEtcd(ctx context.Context) error {
 		return ErrStartEtcdTimeout.WithCausef("timeout is:%v", srv.cfg.EtcdStartTimeout())
 	}
 	srv.etcdSrv = etcdSrv
+	srv.etcdEmbedMonitor = etcdutil.NewEmbedMonitor(log.With(zap.String("module", "etcdEmbedMonitor")), etcdSrv.Server, embedEtcdAlarmCheckInterval, func(alarms []etcdutil.EmbedAlarm) {
+		if len(alarms) == 0 {
+			srv.status.SetDegraded("")
+			return
+		}
+		srv.status.SetDegraded(fmt.Sprintf("etcd raises alarms:%s", etcdutil.DescribeEmbedAlarms(alarms)))
+	})
+	srv.etcdEmbedMonitor.Start()
+
+	return nil
+}
 
+func (srv *Server) stopEmbedEtcd(_ context.Context) error {
+	srv.etcdEmbedMonitor.Stop()
+	srv.etcdSrv.Close()
 	return nil
 }
 
-func (srv *Server) initEtcdClient(enableEmbedEtcd bool) error {
+func (srv *Server) initEtcdClient(_ context.Context) error {
 	// If enableEmbedEtcd is false, we should add tls config to connect remote Etcd server.
 	var tlsConfig *tls.Config
-	if !enableEmbedEtcd {
+	if !srv.cfg.EnableEmbedEtcd {
 		tlsInfo := transport.TLSInfo{
 			TrustedCAFile: srv.cfg.EtcdCaCertPath,
 			CertFile:      srv.cfg.EtcdCertPath,
@@ -201,6 +366,7 @@ func (srv *Server) initEtcdClient(enableEmbedEtcd bool) error {
 	client, err := clientv3.New(clientv3.Config{
 		Endpoints:   etcdEndpoints,
 		DialTimeout: srv.cfg.EtcdCallTimeout(),
+		DialOptions: etcdutil.DialBackoffOptions(srv.cfg.EtcdDialBackoffBaseDelay(), srv.cfg.EtcdDialBackoffMaxDelay(), srv.cfg.EtcdCallTimeout()),
 		LogConfig:   lgc,
 		TLS:         tlsConfig,
 	})
@@ -208,63 +374,186 @@ func (srv *Server) initEtcdClient(enableEmbedEtcd bool) error {
 		return ErrCreateEtcdClient.WithCause(err)
 	}
 	srv.etcdCli = client
-
+	srv.etcdMaintainer = etcdutil.NewMaintainer(log.With(zap.String("module", "etcdMaintainer")), client, srv.etcdMaintenanceOpts, clock.NewRealClock())
+	srv.etcdEndpointManager = etcdutil.NewEndpointManager(log.With(zap.String("module", "etcdEndpointManager")), client, etcdutil.EndpointOptions{
+		AutoSyncInterval:    srv.cfg.EtcdAutoSyncInterval(),
+		HealthCheckInterval: srv.cfg.EtcdHealthCheckInterval(),
+		HealthCheckTimeout:  srv.cfg.EtcdCallTimeout(),
+	}, clock.NewRealClock())
+	srv.etcdEndpointManager.Start()
+
+	// The advertised urls are used by the others to reach this server, e.g. the leader, rather than the listened ones.
+	endpoint, err := srv.cfg.GrpcAdvertiseURL()
+	if err != nil {
+		return ErrCreateEtcdClient.WithCause(err)
+	}
+	httpEndpoint, err := srv.cfg.HTTPAdvertiseURL()
+	if err != nil {
+		return ErrCreateEtcdClient.WithCause(err)
+	}
 	if srv.etcdSrv != nil {
 		etcdLeaderGetter := &etcdutil.LeaderGetterWrapper{Server: srv.etcdSrv.Server}
-		srv.member = member.NewMember(srv.cfg.StorageRootPath, uint64(srv.etcdSrv.Server.ID()), srv.cfg.NodeName, srv.etcdCfg.ACUrls[0].String(), client, etcdLeaderGetter, srv.cfg.EtcdCallTimeout())
+		srv.member = member.NewMember(srv.cfg.StorageRootPath, uint64(srv.etcdSrv.Server.ID()), srv.cfg.NodeName, endpoint, httpEndpoint, client, etcdLeaderGetter, srv.cfg.EtcdCallTimeout())
 	} else {
-		endpoint := fmt.Sprintf("http://%s:%d", srv.cfg.Addr, srv.cfg.GrpcPort)
-		srv.member = member.NewMember(srv.cfg.StorageRootPath, 0, srv.cfg.NodeName, endpoint, client, nil, srv.cfg.EtcdCallTimeout())
+		srv.member = member.NewMember(srv.cfg.StorageRootPath, 0, srv.cfg.NodeName, endpoint, httpEndpoint, client, nil, srv.cfg.EtcdCallTimeout())
 	}
 	return nil
 }
 
+func (srv *Server) closeEtcdClient(_ context.Context) error {
+	srv.etcdEndpointManager.Stop()
+	return srv.etcdCli.Close()
+}
+
 func (srv *Server) startGrpcServer(_ context.Context) error {
 	opts := srv.buildGrpcOptions()
 	server := grpc.NewServer(opts...)
 
-	grpcService := metagrpc.NewService(srv.cfg.GrpcHandleTimeout(), srv)
-	server.RegisterService(&metaservicepb.CeresmetaRpcService_ServiceDesc, grpcService)
+	grpcService := metagrpc.NewService(grpcServiceOptions(srv.cfg), srv)
+	server.RegisterService(grpcService.ServiceDesc(), grpcService)
 	addr := fmt.Sprintf(":%d", srv.cfg.GrpcPort)
 	lis, err := net.Listen("tcp", addr)
 	if err != nil {
 		return errors.Wrapf(err, "listen on %s failed", addr)
 	}
+	srv.grpcServer = server
 
-	if err = server.Serve(lis); err != nil {
-		return errors.Wrap(err, "serve failed")
-	}
+	go func() {
+		if err := server.Serve(lis); err != nil {
+			srv.status.Set(status.Terminated)
+			log.Fatal("Grpc serve failed", zap.Error(err))
+		}
+	}()
+
+	return nil
+}
 
+func (srv *Server) stopGrpcServer(_ context.Context) error {
+	// The requests still running after the grace period are cut off, instead of being waited by the graceful stop.
+	if srv.status.InflightRequests() > 0 {
+		srv.grpcServer.Stop()
+		return nil
+	}
+	srv.grpcServer.GracefulStop()
 	return nil
 }
 
-// startServer starts involved services.
-func (srv *Server) startServer(_ context.Context) error {
+// startClusterManager creates the cluster manager, and the clusters are started only after the leadership is gained.
+func (srv *Server) startClusterManager(ctx context.Context) error {
 	if srv.cfg.MaxScanLimit <= 1 {
 		return ErrStartServer.WithCausef("scan limit must be greater than 1")
 	}
 
-	storage := storage.NewStorageWithEtcdBackend(srv.etcdCli, srv.cfg.StorageRootPath,
+	storage := storage.NewInstrumentedStorage(storage.NewStorageWithEtcdBackend(srv.etcdCli, srv.cfg.StorageRootPath,
 		storage.Options{
 			MaxScanLimit: srv.cfg.MaxScanLimit,
 			MinScanLimit: srv.cfg.MinScanLimit,
 			MaxOpsPerTxn: srv.cfg.MaxOpsPerTxn,
-		})
+		}), srv.cfg.StorageSlowOpThreshold())
 
 	topologyType, err := metadata.ParseTopologyType(srv.cfg.TopologyType)
 	if err != nil {
 		return err
 	}
 
-	manager, err := cluster.NewManagerImpl(storage, srv.etcdCli, srv.etcdCli, srv.cfg.StorageRootPath, srv.cfg.IDAllocatorStep, topologyType)
+	dependencyResolver := coordinator.NewDefaultDependencyResolver()
+	if webhookURLs := srv.cfg.AdmissionWebhookURLs(); len(webhookURLs) > 0 {
+		admissionHooks := make([]coordinator.AdmissionHook, 0, len(webhookURLs))
+		for _, url := range webhookURLs {
+			admissionHooks = append(admissionHooks, coordinator.NewWebhookAdmissionHook(url, srv.cfg.AdmissionWebhookTimeout(), srv.cfg.AdmissionWebhookFailOpen))
+		}
+		dependencyResolver = coordinator.NewAdmissionDependencyResolver(dependencyResolver, coordinator.NewChainAdmissionHook(admissionHooks...))
+	}
+
+	manager, err := cluster.NewManagerImpl(storage, srv.etcdCli, srv.etcdCli, srv.cfg.StorageRootPath, srv.idAllocatorOptions(), srv.procedureGCOptions(), topologyType, dependencyResolver, srv.eventBusOptions(), clock.NewRealClock())
 	if err != nil {
 		return err
 	}
 	srv.clusterManager = manager
 	srv.flowLimiter = limiter.NewFlowLimiter(srv.cfg.FlowLimiter)
 
-	api := http.NewAPI(manager, srv.status, http.NewForwardClient(srv.member, srv.cfg.HTTPPort), srv.flowLimiter, srv.etcdCli)
-	httpService := http.NewHTTPService(srv.cfg.HTTPPort, time.Second*10, time.Second*10, api.NewAPIRouter())
+	// The warm cluster metadata only saves the loading after election, so the server still starts without it.
+	if err := manager.StartStandby(ctx); err != nil {
+		log.Warn("start cluster manager standby failed", zap.Error(err))
+	}
+	return nil
+}
+
+// idAllocatorOptions returns the options of the id allocators, and the resources without the configured step use the
+// IDAllocatorStep.
+func (srv *Server) procedureGCOptions() procedure.GCOptions {
+	return procedure.GCOptions{
+		Interval:     srv.cfg.ProcedureGCInterval(),
+		Retention:    srv.cfg.ProcedureRetention(),
+		MaxOpsPerTxn: srv.cfg.MaxOpsPerTxn,
+	}
+}
+
+func (srv *Server) idAllocatorOptions() id.ResourceAllocatorOptions {
+	stepOrDefault := func(step uint) id.AllocatorOptions {
+		if step == 0 {
+			step = srv.cfg.IDAllocatorStep
+		}
+		return id.AllocatorOptions{Step: step, Preallocate: srv.cfg.EnableIDPreallocation}
+	}
+
+	return id.ResourceAllocatorOptions{
+		Cluster:   stepOrDefault(0),
+		Schema:    stepOrDefault(srv.cfg.SchemaIDAllocatorStep),
+		Table:     stepOrDefault(srv.cfg.TableIDAllocatorStep),
+		Procedure: stepOrDefault(srv.cfg.ProcedureIDAllocatorStep),
+	}
+}
+
+// eventBusOptions builds the sinks of the events from the config, and the sinks are shared by all the clusters.
+func (srv *Server) eventBusOptions() event.BusOptions {
+	cfg := srv.cfg.Event
+	sinks := make([]event.Sink, 0, len(cfg.Webhooks)+1)
+	for _, webhook := range cfg.Webhooks {
+		if len(webhook) > 0 {
+			sinks = append(sinks, event.NewWebhookSink(webhook, cfg.SinkTimeout()))
+		}
+	}
+	if len(cfg.KafkaRESTProxy) > 0 {
+		sinks = append(sinks, event.NewKafkaRESTSink(cfg.KafkaRESTProxy, cfg.KafkaTopic, cfg.SinkTimeout()))
+	}
+	return event.BusOptions{
+		Capacity: cfg.Capacity,
+		Sinks:    sinks,
+	}
+}
+
+func (srv *Server) stopClusterManager(ctx context.Context) error {
+	return srv.clusterManager.Stop(ctx)
+}
+
+func (srv *Server) startHTTPService(_ context.Context) error {
+	api := http.NewAPI(http.APIOptions{
+		ClusterManager:       srv.clusterManager,
+		ServerStatus:         srv.status,
+		ForwardClient:        http.NewForwardClient(srv.member, srv.cfg.HTTPPort),
+		FlowLimiter:          srv.flowLimiter,
+		EtcdClient:           srv.etcdCli,
+		EtcdMaintainer:       srv.etcdMaintainer,
+		EtcdEndpointManager:  srv.etcdEndpointManager,
+		EtcdEmbedMonitor:     srv.etcdEmbedMonitor,
+		EtcdQuotaBytes:       srv.cfg.QuotaBackendBytes,
+		RootPath:             srv.cfg.StorageRootPath,
+		IdempotencyKeyTTLSec: srv.cfg.IdempotencyKeyTTLSec,
+		EnableDebugKV:        srv.cfg.EnableDebugKV,
+		ConfigReloader:       srv.ReloadConfig,
+		BuildInfo:            srv.buildInfo,
+		HandleTimeouts: http.HandleTimeouts{
+			Default: srv.cfg.HTTPHandleTimeout(),
+			Long:    srv.cfg.HTTPLongHandleTimeout(),
+		},
+		AccessLog:           srv.cfg.AccessLog,
+		TenantTokenRequired: srv.cfg.TenantTokenRequired,
+		TenantAdminToken:    srv.cfg.TenantAdminToken,
+	})
+	// The responses should be written after the handlers time out, so the write timeout covers the longest handle timeout.
+	writeTimeout := max(httpWriteTimeout, srv.cfg.HTTPHandleTimeout()+time.Second, srv.cfg.HTTPLongHandleTimeout()+time.Second)
+	httpService := http.NewHTTPService(srv.cfg.HTTPPort, httpReadTimeout, writeTimeout, api.NewAPIRouter())
 	go func() {
 		err := httpService.Start()
 		if err != nil {
@@ -277,24 +566,44 @@ func (srv *Server) startServer(_ context.Context) error {
 	return nil
 }
 
+func (srv *Server) stopHTTPService(_ context.Context) error {
+	return srv.httpService.Stop()
+}
+
 func (srv *Server) startBgJobs(ctx context.Context) {
 	var bgJobCtx context.Context
 	bgJobCtx, srv.bgJobCancel = context.WithCancel(ctx)
 
+	// The jobs are added before they run, otherwise the stopBgJobs called in between would not wait for them.
+	srv.bgJobWg.Add(3)
 	go srv.watchLeader(bgJobCtx)
 	go srv.watchEtcdLeaderPriority(bgJobCtx)
+	go srv.keepMemberRegistered(bgJobCtx)
+}
+
+// SetBuildInfo sets the build of the server, which should be called before the server runs.
+func (srv *Server) SetBuildInfo(buildInfo member.BuildInfo) {
+	srv.buildInfo = buildInfo
+}
+
+// keepMemberRegistered registers the member with a lease, so it is listed as an alive member of the cluster.
+func (srv *Server) keepMemberRegistered(ctx context.Context) {
+	defer srv.bgJobWg.Done()
+
+	srv.member.KeepRegistered(ctx, srv.buildInfo, srv.cfg.LeaseTTLSec)
 }
 
 func (srv *Server) stopBgJobs() {
 	srv.bgJobCancel()
 	srv.bgJobWg.Wait()
+	// The maintenance is started by the leader, and it should be stopped even if the leadership is not transferred.
+	srv.etcdMaintainer.Stop()
 }
 
 // watchLeader watches whether the leader of the cluster exists.
 // Every node campaigns the leadership if it finds the leader is offline and the leader should keep the leadership after
 // election. And Keep the leader node also be the leader of the etcd cluster during election.
 func (srv *Server) watchLeader(ctx context.Context) {
-	srv.bgJobWg.Add(1)
 	defer srv.bgJobWg.Done()
 
 	watchCtx := &leaderWatchContext{
@@ -310,7 +619,6 @@ func (srv *Server) watchLeader(ctx context.Context) {
 }
 
 func (srv *Server) watchEtcdLeaderPriority(_ context.Context) {
-	srv.bgJobWg.Add(1)
 	defer srv.bgJobWg.Done()
 }
 
@@ -321,11 +629,18 @@ func (srv *Server) createDefaultCluster(ctx context.Context) error {
 	}
 
 	// Create default cluster by the leader.
+	if resp.IsLocal && srv.staticTopology != nil {
+		return srv.provisionStaticTopology(ctx, *srv.staticTopology)
+	}
 	if resp.IsLocal {
 		topologyType, err := metadata.ParseTopologyType(srv.cfg.TopologyType)
 		if err != nil {
 			return err
 		}
+		schemaCreationPolicy, err := metadata.ParseSchemaCreationPolicy(srv.cfg.DefaultClusterSchemaCreationPolicy)
+		if err != nil {
+			return err
+		}
 		defaultCluster, err := srv.clusterManager.CreateCluster(ctx, srv.cfg.DefaultClusterName,
 			metadata.CreateClusterOpts{
 				NodeCount:                   uint32(srv.cfg.DefaultClusterNodeCount),
@@ -333,14 +648,22 @@ func (srv *Server) createDefaultCluster(ctx context.Context) error {
 				EnableSchedule:              srv.cfg.EnableSchedule,
 				TopologyType:                topologyType,
 				ProcedureExecutingBatchSize: srv.cfg.ProcedureExecutingBatchSize,
+				CaseInsensitiveName:         srv.cfg.DefaultClusterCaseInsensitiveName,
+				DefaultSchemaName:           srv.cfg.DefaultClusterSchemaName,
+				HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: srv.cfg.DefaultClusterHeartbeatIntervalMinMs, MaxMs: srv.cfg.DefaultClusterHeartbeatIntervalMaxMs},
+				Quota:                       storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0},
+				SchemaCreationPolicy:        schemaCreationPolicy,
+				Protected:                   srv.cfg.DefaultClusterProtected,
+				ShardNodes:                  nil,
 			})
 		if err != nil {
 			log.Warn("create default cluster failed", zap.Error(err))
 			if coderr.Is(err, metadata.ErrClusterAlreadyExists.Code()) {
-				_, err = srv.clusterManager.GetCluster(ctx, srv.cfg.DefaultClusterName)
+				defaultCluster, err = srv.clusterManager.GetCluster(ctx, srv.cfg.DefaultClusterName)
 				if err != nil {
 					return errors.WithMessage(err, "get default cluster failed")
 				}
+				srv.backfillDefaultClusterProtection(ctx, defaultCluster)
 			}
 		} else {
 			log.Info("create default cluster succeed", zap.String("cluster", defaultCluster.GetMetadata().Name()))
@@ -349,6 +672,82 @@ func (srv *Server) createDefaultCluster(ctx context.Context) error {
 	return nil
 }
 
+// backfillDefaultClusterProtection protects the existing default cluster if DefaultClusterProtected is set, e.g. the one
+// created before the protection is introduced. The protection is never removed here, which must be forced by the api,
+// and the failure is only logged because the cluster is still served without the protection.
+func (srv *Server) backfillDefaultClusterProtection(ctx context.Context, c *cluster.Cluster) {
+	clusterMetadata := c.GetMetadata()
+	if !srv.cfg.DefaultClusterProtected || clusterMetadata.IsProtected() {
+		return
+	}
+
+	if err := srv.clusterManager.UpdateCluster(ctx, clusterMetadata.Name(), metadata.UpdateClusterOpts{
+		TopologyType:                clusterMetadata.GetTopologyType(),
+		ProcedureExecutingBatchSize: clusterMetadata.GetProcedureExecutingBatchSize(),
+		HeartbeatIntervalBounds:     clusterMetadata.GetHeartbeatIntervalBounds(),
+		Quota:                       clusterMetadata.GetQuota(),
+		SchemaCreationPolicy:        clusterMetadata.GetSchemaCreationPolicy(),
+		Protected:                   true,
+		Confirmation:                metadata.Confirmation{Force: false, Token: ""},
+	}); err != nil {
+		log.Error("protect existing default cluster failed", zap.String("cluster", clusterMetadata.Name()), zap.Error(err))
+		return
+	}
+	log.Info("protect existing default cluster", zap.String("cluster", clusterMetadata.Name()))
+}
+
+// provisionStaticTopology creates the cluster declared by the static topology with its shard assignment, and nothing is
+// changed if the cluster already exists.
+func (srv *Server) provisionStaticTopology(ctx context.Context, topology config.StaticTopology) error {
+	if c, err := srv.clusterManager.GetCluster(ctx, topology.ClusterName); err == nil {
+		log.Info("cluster of static topology already exists, skip provisioning", zap.String("cluster", topology.ClusterName))
+		if topology.ClusterName == srv.cfg.DefaultClusterName {
+			srv.backfillDefaultClusterProtection(ctx, c)
+		}
+		return nil
+	}
+
+	schemaCreationPolicy, err := metadata.ParseSchemaCreationPolicy(srv.cfg.DefaultClusterSchemaCreationPolicy)
+	if err != nil {
+		return err
+	}
+
+	shardNodes := make([]storage.ShardNode, 0, topology.ShardTotal)
+	for _, node := range topology.Nodes {
+		for _, shardID := range node.ShardIDs {
+			shardNodes = append(shardNodes, storage.ShardNode{
+				ID:        storage.ShardID(shardID),
+				ShardRole: storage.ShardRoleLeader,
+				NodeName:  node.Name,
+			})
+		}
+	}
+	sort.Slice(shardNodes, func(i, j int) bool {
+		return shardNodes[i].ID < shardNodes[j].ID
+	})
+
+	c, err := srv.clusterManager.CreateCluster(ctx, topology.ClusterName,
+		metadata.CreateClusterOpts{
+			NodeCount:                   uint32(len(topology.Nodes)),
+			ShardTotal:                  topology.ShardTotal,
+			EnableSchedule:              srv.cfg.EnableSchedule,
+			TopologyType:                storage.TopologyTypeStatic,
+			ProcedureExecutingBatchSize: srv.cfg.ProcedureExecutingBatchSize,
+			CaseInsensitiveName:         srv.cfg.DefaultClusterCaseInsensitiveName,
+			DefaultSchemaName:           srv.cfg.DefaultClusterSchemaName,
+			HeartbeatIntervalBounds:     storage.HeartbeatIntervalBounds{MinMs: srv.cfg.DefaultClusterHeartbeatIntervalMinMs, MaxMs: srv.cfg.DefaultClusterHeartbeatIntervalMaxMs},
+			Quota:                       storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0},
+			SchemaCreationPolicy:        schemaCreationPolicy,
+			Protected:                   srv.cfg.DefaultClusterProtected && topology.ClusterName == srv.cfg.DefaultClusterName,
+			ShardNodes:                  shardNodes,
+		})
+	if err != nil {
+		return errors.WithMessagef(err, "provision static topology, cluster:%s", topology.ClusterName)
+	}
+	log.Info("provision static topology succeed", zap.String("cluster", c.GetMetadata().Name()), zap.Int("nodeCount", len(topology.Nodes)), zap.Uint32("shardTotal", topology.ShardTotal))
+	return nil
+}
+
 func (srv *Server) buildGrpcOptions() []grpc.ServerOption {
 	keepalivePolicy := keepalive.EnforcementPolicy{
 		MinTime:             time.Duration(srv.cfg.GrpcServiceKeepAlivePingMinIntervalSec) * time.Second,
@@ -356,12 +755,34 @@ func (srv *Server) buildGrpcOptions() []grpc.ServerOption {
 	}
 	opts := []grpc.ServerOption{
 		grpc.MaxSendMsgSize(srv.cfg.GrpcServiceMaxSendMsgSize),
-		grpc.MaxRecvMsgSize(srv.cfg.GrpcServiceMaxSendMsgSize),
+		grpc.MaxRecvMsgSize(srv.cfg.GrpcServiceMaxRecvMsgSize),
 		grpc.KeepaliveEnforcementPolicy(keepalivePolicy),
 	}
 	return opts
 }
 
+// forwardConnPoolOptions returns the options of the connections forwarding the requests to the leader, whose message
+// sizes match the limits of the grpc service of the leader, so the large responses, e.g. the tables of the large shards,
+// are not rejected by the forwarding server.
+func grpcServiceOptions(cfg *config.Config) metagrpc.ServiceOptions {
+	return metagrpc.ServiceOptions{
+		OpTimeout:            cfg.GrpcHandleTimeout(),
+		HeartbeatRateBudget:  cfg.HeartbeatRateBudget,
+		LeaseBounds:          metagrpc.LeaseBounds{MinMs: cfg.NodeLeaseMinMs, MaxMs: cfg.NodeLeaseMaxMs},
+		SlowRequestThreshold: cfg.GrpcSlowRequestThreshold(),
+		ForwardConnOptions:   forwardConnPoolOptions(cfg),
+		TenantTokenRequired:  cfg.TenantTokenRequired,
+		Clock:                clock.NewRealClock(),
+	}
+}
+
+func forwardConnPoolOptions(cfg *config.Config) service.ConnPoolOptions {
+	opts := service.DefaultConnPoolOptions()
+	opts.MaxCallSendMsgSize = cfg.GrpcServiceMaxRecvMsgSize
+	opts.MaxCallRecvMsgSize = cfg.GrpcServiceMaxSendMsgSize
+	return opts
+}
+
 type leaderWatchContext struct {
 	srv *Server
 }
@@ -386,6 +807,10 @@ func (srv *Server) GetLeader(ctx context.Context) (member.GetLeaderAddrResp, err
 	return srv.member.GetLeaderAddr(ctx)
 }
 
+func (srv *Server) GetServerStatus() *status.ServerStatus {
+	return srv.status
+}
+
 func (srv *Server) GetFlowLimiter() (*limiter.FlowLimiter, error) {
 	if srv.flowLimiter == nil {
 		return nil, ErrFlowLimiterNotFound
@@ -404,10 +829,15 @@ func (c *leadershipEventCallbacks) AfterElected(ctx context.Context) {
 	if err := c.srv.createDefaultCluster(ctx); err != nil {
 		panic(fmt.Sprintf("create default cluster failed, err:%v", err))
 	}
+	c.srv.etcdMaintainer.Start()
 }
 
 func (c *leadershipEventCallbacks) BeforeTransfer(ctx context.Context) {
+	c.srv.etcdMaintainer.Stop()
 	if err := c.srv.clusterManager.Stop(ctx); err != nil {
 		panic(fmt.Sprintf("cluster manager fail to stop, err:%v", err))
 	}
+	if err := c.srv.clusterManager.StartStandby(ctx); err != nil {
+		log.Warn("start cluster manager standby failed", zap.Error(err))
+	}
 }
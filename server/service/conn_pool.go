@@ -0,0 +1,289 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/keepalive"
+)
+
+const (
+	// The keepalive ping interval is longer than the min interval enforced by the grpc services of horaemeta.
+	defaultKeepaliveTime    = time.Second * 30
+	defaultKeepaliveTimeout = time.Second * 10
+
+	defaultConnIdleTimeout      = time.Minute * 10
+	defaultConnUnhealthyTimeout = time.Minute
+	// The default sizes of the messages are the same as the limits of the grpc services of horaemeta, and the default
+	// receiving limit of grpc, 4MB, is too small for the tables of the large shards.
+	defaultMaxCallSendMsgSize = 100 * 1024 * 1024
+	defaultMaxCallRecvMsgSize = 200 * 1024 * 1024
+	// connSweepInterval avoids sweeping the connections on every Get.
+	connSweepInterval = time.Second * 10
+)
+
+type ConnPoolOptions struct {
+	// IdleTimeout is how long a connection not used is kept.
+	IdleTimeout time.Duration
+	// UnhealthyTimeout is how long a connection failing to connect is kept, and the connection is dialed again after it
+	// is evicted, which picks up the changed address of the node.
+	UnhealthyTimeout time.Duration
+	// MaxCallSendMsgSize and MaxCallRecvMsgSize bound the sizes of the messages sent and received on the connections, and
+	// zero means the default of grpc.
+	MaxCallSendMsgSize int
+	MaxCallRecvMsgSize int
+	// Clock is the source of the time the connections are used, idle and unhealthy since.
+	Clock clock.Clock
+}
+
+func DefaultConnPoolOptions() ConnPoolOptions {
+	return ConnPoolOptions{
+		IdleTimeout:        defaultConnIdleTimeout,
+		UnhealthyTimeout:   defaultConnUnhealthyTimeout,
+		MaxCallSendMsgSize: defaultMaxCallSendMsgSize,
+		MaxCallRecvMsgSize: defaultMaxCallRecvMsgSize,
+		Clock:              clock.NewRealClock(),
+	}
+}
+
+// dialOptions returns the options of dialing the connections in the pool.
+func (o ConnPoolOptions) dialOptions() []grpc.DialOption {
+	opts := []grpc.DialOption{grpc.WithKeepaliveParams(keepalive.ClientParameters{
+		Time:                defaultKeepaliveTime,
+		Timeout:             defaultKeepaliveTimeout,
+		PermitWithoutStream: true,
+	})}
+	callOpts := make([]grpc.CallOption, 0, 2)
+	if o.MaxCallSendMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallSendMsgSize(o.MaxCallSendMsgSize))
+	}
+	if o.MaxCallRecvMsgSize > 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(o.MaxCallRecvMsgSize))
+	}
+	if len(callOpts) > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+	return opts
+}
+
+type pooledConn struct {
+	conn       *grpc.ClientConn
+	createdAt  time.Time
+	lastUsedAt time.Time
+	// unhealthySince is the time the connection is seen failing to connect, and it is zero if the connection is healthy.
+	unhealthySince time.Time
+}
+
+// ConnStats describes an outbound connection in the pool.
+type ConnStats struct {
+	Addr           string `json:"addr"`
+	State          string `json:"state"`
+	CreatedAt      int64  `json:"createdAt"`
+	LastUsedAt     int64  `json:"lastUsedAt"`
+	UnhealthySince int64  `json:"unhealthySince,omitempty"`
+}
+
+type ConnPoolStats struct {
+	Name  string      `json:"name"`
+	Conns []ConnStats `json:"conns"`
+}
+
+// ConnPool keeps the outbound grpc connections by the addresses. The connections are evicted lazily when they are idle
+// or unhealthy for too long, and the shutdown ones are dialed again on demand.
+type ConnPool struct {
+	name    string
+	options ConnPoolOptions
+
+	// Mutex is used to protect following fields.
+	lock      sync.Mutex
+	conns     map[string]*pooledConn
+	sweptAt   time.Time
+	closed    bool
+	createdAt time.Time
+}
+
+// connPools registers all the pools in the process, so their connections can be listed for debugging.
+var connPools = struct {
+	lock  sync.Mutex
+	pools map[*ConnPool]struct{}
+}{
+	lock:  sync.Mutex{},
+	pools: make(map[*ConnPool]struct{}),
+}
+
+// NewConnPool creates a pool registered for debugging until it is closed, and the name tells the owner of the pool.
+func NewConnPool(name string, options ConnPoolOptions) *ConnPool {
+	now := options.Clock.Now()
+	p := &ConnPool{
+		name:      name,
+		options:   options,
+		lock:      sync.Mutex{},
+		conns:     make(map[string]*pooledConn),
+		sweptAt:   now,
+		closed:    false,
+		createdAt: now,
+	}
+
+	connPools.lock.Lock()
+	connPools.pools[p] = struct{}{}
+	connPools.lock.Unlock()
+	return p
+}
+
+// ListConnPools returns the stats of all the pools in the process ordered by their names.
+func ListConnPools() []ConnPoolStats {
+	connPools.lock.Lock()
+	pools := make([]*ConnPool, 0, len(connPools.pools))
+	for p := range connPools.pools {
+		pools = append(pools, p)
+	}
+	connPools.lock.Unlock()
+
+	sort.Slice(pools, func(i, j int) bool {
+		if pools[i].name != pools[j].name {
+			return pools[i].name < pools[j].name
+		}
+		return pools[i].createdAt.Before(pools[j].createdAt)
+	})
+	stats := make([]ConnPoolStats, 0, len(pools))
+	for _, p := range pools {
+		stats = append(stats, ConnPoolStats{
+			Name:  p.name,
+			Conns: p.Stats(),
+		})
+	}
+	return stats
+}
+
+// Get returns the connection to the address, which is dialed if there is no usable one.
+func (p *ConnPool) Get(ctx context.Context, addr string) (*grpc.ClientConn, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	now := p.options.Clock.Now()
+	p.maybeSweepWithLock(now)
+	if c, ok := p.conns[addr]; ok {
+		if c.conn.GetState() != connectivity.Shutdown {
+			c.lastUsedAt = now
+			return c.conn, nil
+		}
+		delete(p.conns, addr)
+	}
+
+	log.Info("dial grpc connection", zap.String("pool", p.name), zap.String("addr", addr))
+	conn, err := GetClientConn(ctx, addr, p.options.dialOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	if !p.closed {
+		p.conns[addr] = &pooledConn{
+			conn:           conn,
+			createdAt:      now,
+			lastUsedAt:     now,
+			unhealthySince: time.Time{},
+		}
+	}
+	return conn, nil
+}
+
+// Stats returns the connections in the pool ordered by their addresses.
+func (p *ConnPool) Stats() []ConnStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	stats := make([]ConnStats, 0, len(p.conns))
+	for addr, c := range p.conns {
+		var unhealthySince int64
+		if !c.unhealthySince.IsZero() {
+			unhealthySince = c.unhealthySince.UnixMilli()
+		}
+		stats = append(stats, ConnStats{
+			Addr:           addr,
+			State:          c.conn.GetState().String(),
+			CreatedAt:      c.createdAt.UnixMilli(),
+			LastUsedAt:     c.lastUsedAt.UnixMilli(),
+			UnhealthySince: unhealthySince,
+		})
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Addr < stats[j].Addr
+	})
+	return stats
+}
+
+// Close closes all the connections and unregisters the pool, and the connections got later are not pooled any more.
+func (p *ConnPool) Close() {
+	connPools.lock.Lock()
+	delete(connPools.pools, p)
+	connPools.lock.Unlock()
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.closed = true
+	for addr, c := range p.conns {
+		p.closeConnWithLock(addr, c, "pool closed")
+	}
+}
+
+func (p *ConnPool) maybeSweepWithLock(now time.Time) {
+	if now.Sub(p.sweptAt) < connSweepInterval {
+		return
+	}
+	p.sweptAt = now
+
+	for addr, c := range p.conns {
+		state := c.conn.GetState()
+		switch state {
+		case connectivity.Shutdown:
+			delete(p.conns, addr)
+			continue
+		case connectivity.TransientFailure:
+			if c.unhealthySince.IsZero() {
+				c.unhealthySince = now
+			}
+		case connectivity.Idle, connectivity.Connecting, connectivity.Ready:
+			c.unhealthySince = time.Time{}
+		}
+
+		if now.Sub(c.lastUsedAt) > p.options.IdleTimeout {
+			p.closeConnWithLock(addr, c, "idle")
+			continue
+		}
+		if !c.unhealthySince.IsZero() && now.Sub(c.unhealthySince) > p.options.UnhealthyTimeout {
+			p.closeConnWithLock(addr, c, "unhealthy")
+		}
+	}
+}
+
+func (p *ConnPool) closeConnWithLock(addr string, c *pooledConn, reason string) {
+	delete(p.conns, addr)
+	if err := c.conn.Close(); err != nil {
+		log.Warn("close grpc connection failed", zap.String("pool", p.name), zap.String("addr", addr), zap.Error(err))
+		return
+	}
+	log.Info("close grpc connection", zap.String("pool", p.name), zap.String("addr", addr), zap.String("reason", reason))
+}
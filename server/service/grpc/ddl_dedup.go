@@ -0,0 +1,122 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"sync"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"go.uber.org/zap"
+)
+
+type ddlOp string
+
+const (
+	ddlOpCreateTable ddlOp = "createTable"
+	ddlOpDropTable   ddlOp = "dropTable"
+)
+
+// ddlKey identifies the DDL requests regarded as identical, which would race on the same table if they were executed
+// concurrently.
+type ddlKey struct {
+	op          ddlOp
+	clusterName string
+	schemaName  string
+	tableName   string
+}
+
+// newDDLKey returns the key of the DDL request, where the names are normalized by the normalizeName, so the requests
+// naming the same table in different cases are identical in the cluster resolving the names case-insensitively.
+func newDDLKey(op ddlOp, clusterName, schemaName, tableName string, normalizeName func(string) string) ddlKey {
+	return ddlKey{
+		op:          op,
+		clusterName: clusterName,
+		schemaName:  normalizeName(schemaName),
+		tableName:   normalizeName(tableName),
+	}
+}
+
+type ddlCall struct {
+	done chan struct{}
+	resp any
+	// err is set if the fn panics.
+	err error
+}
+
+// ddlDeduplicator coalesces the concurrent identical DDL requests, e.g. the same CreateTable sent by multiple HoraeDB
+// frontends, onto the one being executed, so only one procedure is submitted and its result is shared.
+type ddlDeduplicator struct {
+	lock  sync.Mutex
+	calls map[ddlKey]*ddlCall
+}
+
+func newDDLDeduplicator() *ddlDeduplicator {
+	return &ddlDeduplicator{
+		lock:  sync.Mutex{},
+		calls: make(map[ddlKey]*ddlCall),
+	}
+}
+
+// deduplicateDDL executes the fn unless an identical request is in flight, in which case the result of the in-flight
+// one is returned, the second output parameter bool: returns true if the result is shared.
+//
+// The fn is executed under a context detached from the cancellation of the ctx, so the shared call isn't cancelled when
+// the caller executing it goes away, while every caller stops waiting once its own ctx is done. The panic of the fn is
+// returned to all the callers as an error.
+func deduplicateDDL[T any](ctx context.Context, d *ddlDeduplicator, key ddlKey, fn func(ctx context.Context) T) (T, bool, error) {
+	d.lock.Lock()
+	call, shared := d.calls[key]
+	if !shared {
+		call = &ddlCall{
+			done: make(chan struct{}),
+			resp: nil,
+			err:  nil,
+		}
+		d.calls[key] = call
+		go d.execute(context.WithoutCancel(ctx), key, call, func(ctx context.Context) any { return fn(ctx) })
+	}
+	d.lock.Unlock()
+
+	var emptyResp T
+	select {
+	case <-ctx.Done():
+		return emptyResp, shared, ctx.Err()
+	case <-call.done:
+	}
+	if call.err != nil {
+		return emptyResp, shared, call.err
+	}
+	resp, _ := call.resp.(T)
+	return resp, shared, nil
+}
+
+func (d *ddlDeduplicator) execute(ctx context.Context, key ddlKey, call *ddlCall, fn func(ctx context.Context) any) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error("ddl request panics", zap.String("op", string(key.op)), zap.String("schemaName", key.schemaName), zap.String("tableName", key.tableName), zap.Any("panic", r), zap.Stack("stack"))
+			call.err = ErrHandlerPanic.WithCausef("op:%s, table:%s, panic:%v", key.op, key.tableName, r)
+		}
+
+		d.lock.Lock()
+		delete(d.calls, key)
+		d.lock.Unlock()
+		close(call.done)
+	}()
+
+	call.resp = fn(ctx)
+}
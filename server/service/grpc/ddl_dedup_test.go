@@ -0,0 +1,164 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeduplicateDDL(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	d := newDDLDeduplicator()
+	key := ddlKey{
+		op:          ddlOpCreateTable,
+		clusterName: "cluster",
+		schemaName:  "schema",
+		tableName:   "table",
+	}
+
+	var executed atomic.Int32
+	entered := make(chan struct{})
+	release := make(chan struct{})
+	fn := func(_ context.Context) int {
+		if executed.Add(1) == 1 {
+			close(entered)
+		}
+		<-release
+		return 42
+	}
+
+	// The first request is executed, and the identical ones wait for its result.
+	const concurrency = 8
+	var wg sync.WaitGroup
+	var sharedCount atomic.Int32
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		resp, shared, err := deduplicateDDL(ctx, d, key, fn)
+		re.NoError(err)
+		re.Equal(42, resp)
+		re.False(shared)
+	}()
+	<-entered
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, shared, err := deduplicateDDL(ctx, d, key, fn)
+			re.NoError(err)
+			re.Equal(42, resp)
+			if shared {
+				sharedCount.Add(1)
+			}
+		}()
+	}
+	// The request with a different key is not coalesced.
+	otherKey := key
+	otherKey.op = ddlOpDropTable
+	resp, shared, err := deduplicateDDL(ctx, d, otherKey, func(_ context.Context) int { return 1 })
+	re.NoError(err)
+	re.Equal(1, resp)
+	re.False(shared)
+
+	close(release)
+	wg.Wait()
+	// The requests arriving after the first one finishes are executed by themselves.
+	re.Equal(int32(concurrency), sharedCount.Load()+executed.Load()-1)
+
+	// The request is executed again once the in-flight one finishes.
+	_, shared, err = deduplicateDDL(ctx, d, key, func(_ context.Context) int { return 0 })
+	re.NoError(err)
+	re.False(shared)
+	d.lock.Lock()
+	re.Empty(d.calls)
+	d.lock.Unlock()
+}
+
+func TestDeduplicateDDLCancelled(t *testing.T) {
+	re := require.New(t)
+	d := newDDLDeduplicator()
+	key := newDDLKey(ddlOpCreateTable, "cluster", "schema", "table", func(name string) string { return name })
+
+	entered := make(chan struct{})
+	var enterOnce sync.Once
+	release := make(chan struct{})
+	callCtxErr := make(chan error, 2)
+	fn := func(ctx context.Context) int {
+		enterOnce.Do(func() { close(entered) })
+		<-release
+		callCtxErr <- ctx.Err()
+		return 42
+	}
+
+	// The caller executing the fn stops waiting once its ctx is cancelled, while the shared call keeps running.
+	ctx, cancel := context.WithCancel(context.Background())
+	firstErr := make(chan error, 1)
+	go func() {
+		_, _, err := deduplicateDDL(ctx, d, key, fn)
+		firstErr <- err
+	}()
+	<-entered
+	cancel()
+	re.ErrorIs(<-firstErr, context.Canceled)
+
+	waiterCtx, waiterCancel := context.WithCancel(context.Background())
+	defer waiterCancel()
+	waiterResp := make(chan int, 1)
+	go func() {
+		resp, _, err := deduplicateDDL(waiterCtx, d, key, fn)
+		re.NoError(err)
+		waiterResp <- resp
+	}()
+
+	close(release)
+	re.NoError(<-callCtxErr)
+	re.Equal(42, <-waiterResp)
+}
+
+func TestDeduplicateDDLPanic(t *testing.T) {
+	re := require.New(t)
+	d := newDDLDeduplicator()
+	key := newDDLKey(ddlOpDropTable, "cluster", "schema", "table", func(name string) string { return name })
+
+	resp, shared, err := deduplicateDDL(context.Background(), d, key, func(_ context.Context) *int {
+		panic("boom")
+	})
+	re.Nil(resp)
+	re.False(shared)
+	re.True(coderr.Is(err, ErrHandlerPanic.Code()))
+
+	// The key is released after the panic.
+	d.lock.Lock()
+	re.Empty(d.calls)
+	d.lock.Unlock()
+}
+
+func TestNewDDLKey(t *testing.T) {
+	re := require.New(t)
+
+	re.Equal(newDDLKey(ddlOpCreateTable, "cluster", "Schema", "Table", strings.ToLower), newDDLKey(ddlOpCreateTable, "cluster", "schema", "TABLE", strings.ToLower))
+	keep := func(name string) string { return name }
+	re.NotEqual(newDDLKey(ddlOpCreateTable, "cluster", "Schema", "Table", keep), newDDLKey(ddlOpCreateTable, "cluster", "schema", "TABLE", keep))
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcmetadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// leaderEndpointMetadataKey tells the endpoint of the leader in the header of the requests rejected by the draining server.
+const leaderEndpointMetadataKey = "x-horaemeta-leader-endpoint"
+
+// DrainUnaryInterceptor tracks the in-flight requests so that they can be waited before the server is stopped, and
+// rejects the new requests with Unavailable once the server starts draining.
+func DrainUnaryInterceptor(h Handler) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		serverStatus := h.GetServerStatus()
+		if !serverStatus.StartRequest() {
+			md := leaderEndpointMetadata(ctx, h)
+			if err := grpc.SetHeader(ctx, md); err != nil {
+				log.Debug("set leader endpoint header failed", zap.Error(err))
+			}
+			return nil, drainingError(info.FullMethod, md)
+		}
+		defer serverStatus.FinishRequest()
+
+		return handler(ctx, req)
+	}
+}
+
+// DrainStreamInterceptor tracks the streams as the in-flight requests until they are closed, and rejects the new streams
+// once the server starts draining.
+func DrainStreamInterceptor(h Handler) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		serverStatus := h.GetServerStatus()
+		if !serverStatus.StartRequest() {
+			md := leaderEndpointMetadata(ss.Context(), h)
+			if err := ss.SetHeader(md); err != nil {
+				log.Debug("set leader endpoint header failed", zap.Error(err))
+			}
+			return drainingError(info.FullMethod, md)
+		}
+		defer serverStatus.FinishRequest()
+
+		return handler(srv, ss)
+	}
+}
+
+// leaderEndpointMetadata returns the endpoint of the leader to retry on, and it is empty if the leader is unknown or it is
+// this server.
+func leaderEndpointMetadata(ctx context.Context, h Handler) grpcmetadata.MD {
+	resp, err := h.GetLeader(ctx)
+	if err != nil || resp.IsLocal {
+		return grpcmetadata.MD{}
+	}
+	return grpcmetadata.Pairs(leaderEndpointMetadataKey, resp.LeaderEndpoint)
+}
+
+func drainingError(fullMethod string, md grpcmetadata.MD) error {
+	log.Warn("grpc request is rejected by draining server", zap.String("method", fullMethod), zap.Strings("leader", md.Get(leaderEndpointMetadataKey)))
+	return status.Error(codes.Unavailable, ErrServerDraining.WithCausef("method:%s, leader:%v", fullMethod, md.Get(leaderEndpointMetadataKey)).Error())
+}
@@ -0,0 +1,99 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/server/member"
+	"github.com/CeresDB/horaemeta/server/status"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+type drainTestHandler struct {
+	Handler
+	serverStatus *status.ServerStatus
+}
+
+func (h *drainTestHandler) GetServerStatus() *status.ServerStatus {
+	return h.serverStatus
+}
+
+func (h *drainTestHandler) GetLeader(_ context.Context) (member.GetLeaderAddrResp, error) {
+	return member.GetLeaderAddrResp{LeaderEndpoint: "http://127.0.0.1:2379", IsLocal: false}, nil
+}
+
+func TestDrainUnaryInterceptor(t *testing.T) {
+	re := require.New(t)
+
+	serverStatus := status.NewServerStatus()
+	serverStatus.Set(status.StatusRunning)
+	interceptor := DrainUnaryInterceptor(&drainTestHandler{Handler: nil, serverStatus: serverStatus})
+	info := &grpc.UnaryServerInfo{Server: nil, FullMethod: "/test/Drain"}
+
+	// The in-flight request is tracked until it finishes.
+	resp, err := interceptor(context.Background(), "req", info, func(_ context.Context, req any) (any, error) {
+		re.Equal(int64(1), serverStatus.InflightRequests())
+		return req, nil
+	})
+	re.NoError(err)
+	re.Equal("req", resp)
+	re.Equal(int64(0), serverStatus.InflightRequests())
+
+	// The new requests are rejected once the server starts draining.
+	serverStatus.Set(status.StatusDraining)
+	resp, err = interceptor(context.Background(), "req", info, func(_ context.Context, _ any) (any, error) {
+		re.FailNow("the handler should not be called by the draining server")
+		return nil, nil
+	})
+	re.Nil(resp)
+	re.Equal(codes.Unavailable, grpcstatus.Code(err))
+	re.Contains(err.Error(), "http://127.0.0.1:2379")
+	re.Equal(int64(0), serverStatus.InflightRequests())
+}
+
+func TestWaitInflightRequests(t *testing.T) {
+	re := require.New(t)
+
+	serverStatus := status.NewServerStatus()
+	serverStatus.Set(status.StatusRunning)
+	interceptor := DrainUnaryInterceptor(&drainTestHandler{Handler: nil, serverStatus: serverStatus})
+	info := &grpc.UnaryServerInfo{Server: nil, FullMethod: "/test/Drain"}
+
+	started, finish := make(chan struct{}), make(chan struct{})
+	go func() {
+		_, _ = interceptor(context.Background(), "req", info, func(_ context.Context, req any) (any, error) {
+			close(started)
+			<-finish
+			return req, nil
+		})
+	}()
+	<-started
+	serverStatus.Set(status.StatusDraining)
+
+	// The in-flight request is not finished within the grace period.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	re.ErrorIs(serverStatus.WaitInflightRequests(ctx), context.Canceled)
+
+	close(finish)
+	re.NoError(serverStatus.WaitInflightRequests(context.Background()))
+}
@@ -21,9 +21,19 @@ import (
 )
 
 var (
-	ErrRecvHeartbeat         = coderr.NewCodeError(coderr.Internal, "receive heartbeat")
-	ErrBindHeartbeatStream   = coderr.NewCodeError(coderr.Internal, "bind heartbeat sender")
-	ErrUnbindHeartbeatStream = coderr.NewCodeError(coderr.Internal, "unbind heartbeat sender")
-	ErrForward               = coderr.NewCodeError(coderr.Internal, "grpc forward")
-	ErrFlowLimit             = coderr.NewCodeError(coderr.TooManyRequests, "flow limit")
+	ErrRecvHeartbeat              = coderr.NewCodeError(coderr.Internal, "receive heartbeat")
+	ErrBindHeartbeatStream        = coderr.NewCodeError(coderr.Internal, "bind heartbeat sender")
+	ErrUnbindHeartbeatStream      = coderr.NewCodeError(coderr.Internal, "unbind heartbeat sender")
+	ErrForward                    = coderr.NewCodeError(coderr.Internal, "grpc forward")
+	ErrFlowLimit                  = coderr.NewCodeError(coderr.TooManyRequests, "flow limit")
+	ErrHandlerPanic               = coderr.NewCodeError(coderr.Internal, "grpc handler panic")
+	ErrInvalidHeartbeatDelta      = coderr.NewCodeError(coderr.InvalidParams, "invalid heartbeat delta")
+	ErrFullReportRequired         = coderr.NewCodeError(coderr.HeartbeatFullReportRequired, "full heartbeat report required")
+	ErrInvalidTargetShard         = coderr.NewCodeError(coderr.InvalidParams, "invalid target shard")
+	ErrInvalidShardCommand        = coderr.NewCodeError(coderr.InvalidParams, "invalid shard command ack")
+	ErrInvalidNodeCapacity        = coderr.NewCodeError(coderr.InvalidParams, "invalid node capacity")
+	ErrInvalidTableID             = coderr.NewCodeError(coderr.InvalidParams, "invalid table id")
+	ErrInvalidNodeProtocolVersion = coderr.NewCodeError(coderr.InvalidParams, "invalid node protocol version")
+	ErrInvalidTableStats          = coderr.NewCodeError(coderr.InvalidParams, "invalid table stats")
+	ErrServerDraining             = coderr.NewCodeError(coderr.Unavailable, "server is draining")
 )
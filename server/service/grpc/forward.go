@@ -20,10 +20,7 @@ import (
 	"context"
 
 	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
-	"github.com/CeresDB/horaemeta/pkg/log"
-	"github.com/CeresDB/horaemeta/server/service"
 	"github.com/pkg/errors"
-	"go.uber.org/zap"
 	"google.golang.org/grpc"
 )
 
@@ -53,17 +50,7 @@ func (s *Service) getMetaClient(ctx context.Context, addr string) (metaservicepb
 }
 
 func (s *Service) getForwardedGrpcClient(ctx context.Context, forwardedAddr string) (*grpc.ClientConn, error) {
-	client, ok := s.conns.Load(forwardedAddr)
-	if !ok {
-		log.Info("try to create horaemeta client", zap.String("addr", forwardedAddr))
-		cc, err := service.GetClientConn(ctx, forwardedAddr)
-		if err != nil {
-			return nil, err
-		}
-		client = cc
-		s.conns.Store(forwardedAddr, cc)
-	}
-	return client.(*grpc.ClientConn), nil
+	return s.conns.Get(ctx, forwardedAddr)
 }
 
 func (s *Service) getForwardedAddr(ctx context.Context) (string, bool, error) {
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/CeresDB/horaemeta/server/storage"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+// The heartbeat proto doesn't describe the resources of the node, so the capacity is reported by the metadata of the
+// heartbeats, and the missing ones are regarded as not reported.
+const (
+	nodeCPUCoresMetadataKey    = "x-horaemeta-node-cpu-cores"
+	nodeMemoryBytesMetadataKey = "x-horaemeta-node-memory-bytes"
+	nodeDiskBytesMetadataKey   = "x-horaemeta-node-disk-bytes"
+)
+
+func parseNodeCapacity(ctx context.Context) (storage.NodeCapacity, error) {
+	capacity := storage.NodeCapacity{CPUCores: 0, MemoryBytes: 0, DiskBytes: 0}
+	md, ok := grpcmetadata.FromIncomingContext(ctx)
+	if !ok {
+		return capacity, nil
+	}
+
+	cpuCores, err := parseCapacityValue(md, nodeCPUCoresMetadataKey, 32)
+	if err != nil {
+		return capacity, err
+	}
+	memoryBytes, err := parseCapacityValue(md, nodeMemoryBytesMetadataKey, 64)
+	if err != nil {
+		return capacity, err
+	}
+	diskBytes, err := parseCapacityValue(md, nodeDiskBytesMetadataKey, 64)
+	if err != nil {
+		return capacity, err
+	}
+
+	capacity.CPUCores = uint32(cpuCores)
+	capacity.MemoryBytes = memoryBytes
+	capacity.DiskBytes = diskBytes
+	return capacity, nil
+}
+
+func parseCapacityValue(md grpcmetadata.MD, key string, bitSize int) (uint64, error) {
+	values := md.Get(key)
+	if len(values) == 0 {
+		return 0, nil
+	}
+	value, err := strconv.ParseUint(values[0], 10, bitSize)
+	if err != nil {
+		return 0, ErrInvalidNodeCapacity.WithCausef("parse %s:%s, err:%v", key, values[0], err)
+	}
+	return value, nil
+}
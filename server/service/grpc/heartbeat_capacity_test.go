@@ -0,0 +1,48 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+func TestParseNodeCapacity(t *testing.T) {
+	re := require.New(t)
+
+	// The capacity is not reported by the legacy nodes.
+	capacity, err := parseNodeCapacity(context.Background())
+	re.NoError(err)
+	re.Equal(storage.NodeCapacity{CPUCores: 0, MemoryBytes: 0, DiskBytes: 0}, capacity)
+
+	ctx := grpcmetadata.NewIncomingContext(context.Background(), grpcmetadata.Pairs(
+		nodeCPUCoresMetadataKey, "16",
+		nodeMemoryBytesMetadataKey, "68719476736",
+	))
+	capacity, err = parseNodeCapacity(ctx)
+	re.NoError(err)
+	re.Equal(storage.NodeCapacity{CPUCores: 16, MemoryBytes: 68719476736, DiskBytes: 0}, capacity)
+
+	ctx = grpcmetadata.NewIncomingContext(context.Background(), grpcmetadata.Pairs(nodeDiskBytesMetadataKey, "-1"))
+	_, err = parseNodeCapacity(ctx)
+	re.True(coderr.Is(err, coderr.InvalidParams))
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+// The heartbeat proto carries the full shard infos only, so the delta reports are described by the metadata of the
+// requests and the responses:
+//   - The server advertises the delta capability in the response headers of every heartbeat, and the nodes should keep
+//     sending the full reports until the capability is seen.
+//   - A node attaches the version of the report to the heartbeat, and the version is acked in the response headers once
+//     the report is applied.
+//   - A delta report carries the shard infos changed since the acked base version and the ids of the removed shards. The
+//     server answers ErrFullReportRequired if the base version is not the one it acked, e.g. after the leader changes.
+const (
+	heartbeatCapabilitiesMetadataKey  = "x-horaemeta-heartbeat-capabilities"
+	heartbeatDeltaCapability          = "delta"
+	heartbeatVersionMetadataKey       = "x-horaemeta-heartbeat-version"
+	heartbeatBaseVersionMetadataKey   = "x-horaemeta-heartbeat-base-version"
+	heartbeatRemovedShardsMetadataKey = "x-horaemeta-heartbeat-removed-shards"
+	heartbeatAckedVersionMetadataKey  = "x-horaemeta-heartbeat-acked-version"
+	heartbeatRemovedShardsSeparator   = ","
+)
+
+type heartbeatReport struct {
+	// versioned is false for the nodes not supporting the delta reports.
+	versioned bool
+	version   uint64
+	isDelta   bool
+	// baseVersion and removedShardIDs are only set for the delta reports.
+	baseVersion     uint64
+	removedShardIDs []storage.ShardID
+}
+
+func parseHeartbeatReport(ctx context.Context) (heartbeatReport, error) {
+	report := heartbeatReport{
+		versioned:       false,
+		version:         0,
+		isDelta:         false,
+		baseVersion:     0,
+		removedShardIDs: nil,
+	}
+	md, ok := grpcmetadata.FromIncomingContext(ctx)
+	if !ok {
+		return report, nil
+	}
+
+	versions := md.Get(heartbeatVersionMetadataKey)
+	if len(versions) == 0 {
+		return report, nil
+	}
+	version, err := strconv.ParseUint(versions[0], 10, 64)
+	if err != nil {
+		return report, ErrInvalidHeartbeatDelta.WithCausef("parse version:%s, err:%v", versions[0], err)
+	}
+	report.versioned = true
+	report.version = version
+
+	baseVersions := md.Get(heartbeatBaseVersionMetadataKey)
+	if len(baseVersions) == 0 {
+		return report, nil
+	}
+	baseVersion, err := strconv.ParseUint(baseVersions[0], 10, 64)
+	if err != nil {
+		return report, ErrInvalidHeartbeatDelta.WithCausef("parse base version:%s, err:%v", baseVersions[0], err)
+	}
+	report.isDelta = true
+	report.baseVersion = baseVersion
+
+	removedShardIDs := make([]storage.ShardID, 0)
+	for _, value := range md.Get(heartbeatRemovedShardsMetadataKey) {
+		for _, id := range strings.Split(value, heartbeatRemovedShardsSeparator) {
+			if len(id) == 0 {
+				continue
+			}
+			shardID, err := strconv.ParseUint(id, 10, 32)
+			if err != nil {
+				return report, ErrInvalidHeartbeatDelta.WithCausef("parse removed shard id:%s, err:%v", id, err)
+			}
+			removedShardIDs = append(removedShardIDs, storage.ShardID(shardID))
+		}
+	}
+	report.removedShardIDs = removedShardIDs
+	return report, nil
+}
+
+// heartbeatVersions records the report versions acked for the nodes, which are the bases of the delta reports.
+type heartbeatVersions struct {
+	lock     sync.Mutex
+	versions map[string]uint64 // clusterName/nodeName -> acked version
+}
+
+func newHeartbeatVersions() *heartbeatVersions {
+	return &heartbeatVersions{
+		lock:     sync.Mutex{},
+		versions: make(map[string]uint64),
+	}
+}
+
+func makeHeartbeatVersionKey(clusterName, nodeName string) string {
+	return clusterName + "/" + nodeName
+}
+
+// get the second output parameter bool: returns true if a version has been acked for the node.
+func (v *heartbeatVersions) get(clusterName, nodeName string) (uint64, bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	version, ok := v.versions[makeHeartbeatVersionKey(clusterName, nodeName)]
+	return version, ok
+}
+
+func (v *heartbeatVersions) ack(clusterName, nodeName string, version uint64) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	v.versions[makeHeartbeatVersionKey(clusterName, nodeName)] = version
+}
+
+func (v *heartbeatVersions) remove(clusterName, nodeName string) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	delete(v.versions, makeHeartbeatVersionKey(clusterName, nodeName))
+}
+
+// mergeShardInfos applies the changed and removed shards of a delta report to the shard infos of the base report, and
+// the result is sorted by the shard id.
+func mergeShardInfos(base []metadata.ShardInfo, changed []metadata.ShardInfo, removedShardIDs []storage.ShardID) []metadata.ShardInfo {
+	shardInfos := make(map[storage.ShardID]metadata.ShardInfo, len(base)+len(changed))
+	for _, shardInfo := range base {
+		shardInfos[shardInfo.ID] = shardInfo
+	}
+	for _, shardID := range removedShardIDs {
+		delete(shardInfos, shardID)
+	}
+	for _, shardInfo := range changed {
+		shardInfos[shardInfo.ID] = shardInfo
+	}
+
+	merged := make([]metadata.ShardInfo, 0, len(shardInfos))
+	for _, shardInfo := range shardInfos {
+		merged = append(merged, shardInfo)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].ID < merged[j].ID
+	})
+	return merged
+}
+
+func heartbeatResponseMetadata(ackedVersion uint64, acked bool) grpcmetadata.MD {
+	md := grpcmetadata.Pairs(heartbeatCapabilitiesMetadataKey, heartbeatDeltaCapability)
+	if acked {
+		md.Set(heartbeatAckedVersionMetadataKey, strconv.FormatUint(ackedVersion, 10))
+	}
+	return md
+}
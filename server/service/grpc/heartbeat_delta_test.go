@@ -0,0 +1,78 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+func TestParseHeartbeatReport(t *testing.T) {
+	re := require.New(t)
+
+	// The legacy nodes carry no report version.
+	report, err := parseHeartbeatReport(context.Background())
+	re.NoError(err)
+	re.False(report.versioned)
+	re.False(report.isDelta)
+
+	ctx := grpcmetadata.NewIncomingContext(context.Background(), grpcmetadata.Pairs(heartbeatVersionMetadataKey, "3"))
+	report, err = parseHeartbeatReport(ctx)
+	re.NoError(err)
+	re.True(report.versioned)
+	re.Equal(uint64(3), report.version)
+	re.False(report.isDelta)
+
+	ctx = grpcmetadata.NewIncomingContext(context.Background(), grpcmetadata.Pairs(
+		heartbeatVersionMetadataKey, "4",
+		heartbeatBaseVersionMetadataKey, "3",
+		heartbeatRemovedShardsMetadataKey, "1,2",
+	))
+	report, err = parseHeartbeatReport(ctx)
+	re.NoError(err)
+	re.True(report.isDelta)
+	re.Equal(uint64(4), report.version)
+	re.Equal(uint64(3), report.baseVersion)
+	re.Equal([]storage.ShardID{1, 2}, report.removedShardIDs)
+
+	ctx = grpcmetadata.NewIncomingContext(context.Background(), grpcmetadata.Pairs(
+		heartbeatVersionMetadataKey, "4",
+		heartbeatBaseVersionMetadataKey, "3",
+		heartbeatRemovedShardsMetadataKey, "1,x",
+	))
+	_, err = parseHeartbeatReport(ctx)
+	re.True(coderr.Is(err, coderr.InvalidParams))
+}
+
+func TestMergeShardInfos(t *testing.T) {
+	re := require.New(t)
+
+	shardInfo := func(id storage.ShardID, version uint64) metadata.ShardInfo {
+		return metadata.ShardInfo{ID: id, Role: storage.ShardRoleLeader, Version: version, Status: storage.ShardStatusReady}
+	}
+	base := []metadata.ShardInfo{shardInfo(0, 1), shardInfo(1, 1), shardInfo(2, 1)}
+	changed := []metadata.ShardInfo{shardInfo(3, 1), shardInfo(1, 2)}
+
+	merged := mergeShardInfos(base, changed, []storage.ShardID{2})
+	re.Equal([]metadata.ShardInfo{shardInfo(0, 1), shardInfo(1, 2), shardInfo(3, 1)}, merged)
+}
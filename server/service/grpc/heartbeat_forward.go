@@ -0,0 +1,263 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/pkg/requestid"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcmetadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// heartbeatForwardMaxAttempts bounds the attempts of forwarding a heartbeat, which covers the few seconds the leader
+	// takes to change.
+	heartbeatForwardMaxAttempts     = 5
+	heartbeatForwardInitialBackoff  = 200 * time.Millisecond
+	heartbeatForwardMaxBackoff      = time.Second
+	heartbeatForwardBackoffMultiple = 2
+)
+
+// resolveLeaderFunc returns the address of the leader, and the second output parameter bool: returns true if this server
+// is the leader.
+type resolveLeaderFunc func(ctx context.Context) (string, bool, error)
+
+// sendHeartbeatFunc sends the heartbeat with the metadata to the leader at the address, and returns the response headers
+// of the leader too.
+type sendHeartbeatFunc func(ctx context.Context, addr string, md grpcmetadata.MD, req *metaservicepb.NodeHeartbeatRequest) (*metaservicepb.NodeHeartbeatResponse, grpcmetadata.MD, error)
+
+// forwardedNodeInfo is the full shard infos of a node at the report version, which is rebuilt from the forwarded reports.
+type forwardedNodeInfo struct {
+	version    uint64
+	shardInfos []*metaservicepb.ShardInfo
+}
+
+// heartbeatForwarder forwards the heartbeats to the leader, and retries on the new leader if the forwarding fails for the
+// leader change, so that the nodes don't see the spurious heartbeat failures during the failover.
+//
+// The new leader doesn't know the base versions of the delta reports acked by the old one, so the forwarder keeps the
+// last full shard infos of the nodes and replays them as the full reports instead of asking the nodes for them.
+type heartbeatForwarder struct {
+	lock      sync.Mutex
+	nodeInfos map[string]forwardedNodeInfo // clusterName/nodeName -> last full node info
+}
+
+func newHeartbeatForwarder() *heartbeatForwarder {
+	return &heartbeatForwarder{
+		lock:      sync.Mutex{},
+		nodeInfos: make(map[string]forwardedNodeInfo),
+	}
+}
+
+// forward the heartbeat to the leader. The second output parameter bool: returns false if this server is the leader, and
+// the heartbeat should be handled locally.
+func (f *heartbeatForwarder) forward(ctx context.Context, resolve resolveLeaderFunc, send sendHeartbeatFunc, md grpcmetadata.MD, req *metaservicepb.NodeHeartbeatRequest) (*metaservicepb.NodeHeartbeatResponse, grpcmetadata.MD, bool, error) {
+	clusterName, nodeName := req.GetHeader().GetClusterName(), req.GetInfo().GetEndpoint()
+	backoff := heartbeatForwardInitialBackoff
+	// hintedAddr is the leader told by the draining server, which is tried before the leader known by this server.
+	hintedAddr := ""
+	var lastErr error
+	for attempt := 0; attempt < heartbeatForwardMaxAttempts; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, nil, true, errors.WithMessagef(lastErr, "forward heartbeat, ctx err:%v", ctx.Err())
+			case <-time.After(backoff):
+			}
+			backoff = min(backoff*heartbeatForwardBackoffMultiple, heartbeatForwardMaxBackoff)
+		}
+
+		addr := hintedAddr
+		if addr == "" {
+			leaderAddr, isLocal, err := resolve(ctx)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			if isLocal {
+				return nil, nil, false, nil
+			}
+			addr = leaderAddr
+		}
+
+		resp, header, err := f.sendAndReplay(ctx, send, addr, md, req)
+		if err == nil {
+			return resp, header, true, nil
+		}
+		if status.Code(err) != codes.Unavailable {
+			return nil, nil, true, err
+		}
+		hintedAddr = ""
+		if hinted := header.Get(leaderEndpointMetadataKey); len(hinted) > 0 && hinted[0] != addr {
+			hintedAddr = hinted[0]
+		}
+		log.Warn("forward heartbeat to unavailable leader, retry later", zap.String("clusterName", clusterName), zap.String("name", nodeName), zap.String("leader", addr), zap.String("hintedLeader", hintedAddr), zap.Int("attempt", attempt), zap.Error(err))
+		lastErr = err
+	}
+	return nil, nil, true, errors.WithMessagef(lastErr, "forward heartbeat, attempts:%d", heartbeatForwardMaxAttempts)
+}
+
+// sendAndReplay sends the heartbeat to the leader, and replays the last full node info if the leader asks for the full
+// report of the delta one.
+func (f *heartbeatForwarder) sendAndReplay(ctx context.Context, send sendHeartbeatFunc, addr string, md grpcmetadata.MD, req *metaservicepb.NodeHeartbeatRequest) (*metaservicepb.NodeHeartbeatResponse, grpcmetadata.MD, error) {
+	clusterName, nodeName := req.GetHeader().GetClusterName(), req.GetInfo().GetEndpoint()
+	// The invalid report is forwarded as is and rejected by the leader.
+	report, err := parseHeartbeatReport(grpcmetadata.NewIncomingContext(ctx, md))
+	if err != nil {
+		return send(ctx, addr, md, req)
+	}
+
+	resp, header, err := send(ctx, addr, md, req)
+	if err != nil {
+		return resp, header, err
+	}
+	if report.isDelta && resp.GetHeader().GetCode() == uint32(coderr.HeartbeatFullReportRequired) {
+		fullReq, fullMD, ok := f.buildFullReport(clusterName, report, md, req)
+		if !ok {
+			return resp, header, nil
+		}
+		log.Info("replay full node info of delta heartbeat to leader", zap.String("clusterName", clusterName), zap.String("name", nodeName), zap.String("leader", addr), zap.Uint64("baseVersion", report.baseVersion))
+		resp, header, err = send(ctx, addr, fullMD, fullReq)
+		if err != nil {
+			return resp, header, err
+		}
+		req, report.isDelta = fullReq, false
+	}
+	if resp.GetHeader().GetCode() == coderr.Ok {
+		f.record(clusterName, report, req)
+	}
+	return resp, header, nil
+}
+
+// buildFullReport rebuilds the full report from the delta one and the last full node info, and the third output parameter
+// bool: returns false if the node info at the base version of the delta report is unknown.
+func (f *heartbeatForwarder) buildFullReport(clusterName string, report heartbeatReport, md grpcmetadata.MD, req *metaservicepb.NodeHeartbeatRequest) (*metaservicepb.NodeHeartbeatRequest, grpcmetadata.MD, bool) {
+	if req.GetInfo() == nil {
+		return nil, nil, false
+	}
+	f.lock.Lock()
+	nodeInfo, ok := f.nodeInfos[makeHeartbeatVersionKey(clusterName, req.GetInfo().GetEndpoint())]
+	f.lock.Unlock()
+	if !ok || nodeInfo.version != report.baseVersion {
+		return nil, nil, false
+	}
+
+	fullReq := proto.Clone(req).(*metaservicepb.NodeHeartbeatRequest)
+	fullReq.Info.ShardInfos = mergeShardInfosPB(nodeInfo.shardInfos, req.GetInfo().GetShardInfos(), report.removedShardIDs)
+	fullMD := md.Copy()
+	fullMD.Delete(heartbeatBaseVersionMetadataKey)
+	fullMD.Delete(heartbeatRemovedShardsMetadataKey)
+	return fullReq, fullMD, true
+}
+
+// record the full node info of the report accepted by the leader.
+func (f *heartbeatForwarder) record(clusterName string, report heartbeatReport, req *metaservicepb.NodeHeartbeatRequest) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	key := makeHeartbeatVersionKey(clusterName, req.GetInfo().GetEndpoint())
+	if !report.versioned {
+		delete(f.nodeInfos, key)
+		return
+	}
+	if !report.isDelta {
+		f.nodeInfos[key] = forwardedNodeInfo{version: report.version, shardInfos: req.GetInfo().GetShardInfos()}
+		return
+	}
+	nodeInfo, ok := f.nodeInfos[key]
+	if !ok || nodeInfo.version != report.baseVersion {
+		// The base of the delta is unknown, e.g. it is reported through another server.
+		delete(f.nodeInfos, key)
+		return
+	}
+	f.nodeInfos[key] = forwardedNodeInfo{version: report.version, shardInfos: mergeShardInfosPB(nodeInfo.shardInfos, req.GetInfo().GetShardInfos(), report.removedShardIDs)}
+}
+
+// mergeShardInfosPB is mergeShardInfos of the shard infos in the proto.
+func mergeShardInfosPB(base []*metaservicepb.ShardInfo, changed []*metaservicepb.ShardInfo, removedShardIDs []storage.ShardID) []*metaservicepb.ShardInfo {
+	shardInfos := make(map[uint32]*metaservicepb.ShardInfo, len(base)+len(changed))
+	for _, shardInfo := range base {
+		shardInfos[shardInfo.GetId()] = shardInfo
+	}
+	for _, shardID := range removedShardIDs {
+		delete(shardInfos, uint32(shardID))
+	}
+	for _, shardInfo := range changed {
+		shardInfos[shardInfo.GetId()] = shardInfo
+	}
+
+	merged := make([]*metaservicepb.ShardInfo, 0, len(shardInfos))
+	for _, shardInfo := range shardInfos {
+		merged = append(merged, shardInfo)
+	}
+	sort.Slice(merged, func(i, j int) bool {
+		return merged[i].GetId() < merged[j].GetId()
+	})
+	return merged
+}
+
+// forwardHeartbeat forwards the heartbeat to the leader with the metadata of the request, and the response headers of the
+// leader are set to the response. The second output parameter bool: returns false if this server is the leader.
+func (s *Service) forwardHeartbeat(ctx context.Context, req *metaservicepb.NodeHeartbeatRequest) (*metaservicepb.NodeHeartbeatResponse, bool, error) {
+	md, ok := grpcmetadata.FromIncomingContext(ctx)
+	if !ok {
+		md = grpcmetadata.MD{}
+	}
+	// The request id may be generated by this server, and it is sent back already.
+	if requestID := requestid.FromContext(ctx); len(requestID) > 0 {
+		md = md.Copy()
+		md.Set(requestid.MetadataKey, requestID)
+	}
+	resp, header, forwarded, err := s.heartbeatForwarder.forward(ctx, s.getForwardedAddr, s.sendHeartbeat, md, req)
+	if err != nil || !forwarded {
+		return resp, forwarded, err
+	}
+	header.Delete(requestid.MetadataKey)
+	if len(header) > 0 {
+		if err := grpc.SetHeader(ctx, header); err != nil {
+			log.Warn("set heartbeat response header failed", zap.Error(err))
+		}
+	}
+	return resp, true, nil
+}
+
+func (s *Service) sendHeartbeat(ctx context.Context, addr string, md grpcmetadata.MD, req *metaservicepb.NodeHeartbeatRequest) (*metaservicepb.NodeHeartbeatResponse, grpcmetadata.MD, error) {
+	metaClient, err := s.getMetaClient(ctx, addr)
+	if err != nil {
+		return nil, nil, errors.WithMessage(err, "send heartbeat")
+	}
+	var header, trailer grpcmetadata.MD
+	resp, err := metaClient.NodeHeartbeat(grpcmetadata.NewOutgoingContext(ctx, md), req, grpc.Header(&header), grpc.Trailer(&trailer))
+	if err != nil {
+		// The headers of the rejected request may be carried by the trailers.
+		return nil, grpcmetadata.Join(header, trailer), err
+	}
+	return resp, header, nil
+}
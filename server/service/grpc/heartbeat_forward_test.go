@@ -0,0 +1,184 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/commonpb"
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	grpcmetadata "google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func newForwardTestRequest(shardIDs ...uint32) *metaservicepb.NodeHeartbeatRequest {
+	shardInfos := make([]*metaservicepb.ShardInfo, 0, len(shardIDs))
+	for _, id := range shardIDs {
+		shardInfos = append(shardInfos, &metaservicepb.ShardInfo{Id: id})
+	}
+	return &metaservicepb.NodeHeartbeatRequest{
+		Header: &metaservicepb.RequestHeader{ClusterName: "cluster"},
+		Info:   &metaservicepb.NodeInfo{Endpoint: "node0", ShardInfos: shardInfos},
+	}
+}
+
+func forwardTestShardIDs(req *metaservicepb.NodeHeartbeatRequest) []uint32 {
+	ids := make([]uint32, 0, len(req.GetInfo().GetShardInfos()))
+	for _, shardInfo := range req.GetInfo().GetShardInfos() {
+		ids = append(ids, shardInfo.GetId())
+	}
+	return ids
+}
+
+func TestHeartbeatForwardRetry(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	okResp := &metaservicepb.NodeHeartbeatResponse{Header: okResponseHeader()}
+
+	// The heartbeat is retried on the new leader once the old one is unavailable.
+	leaders := []string{"old", "new"}
+	resolve := func(_ context.Context) (string, bool, error) {
+		leader := leaders[0]
+		if len(leaders) > 1 {
+			leaders = leaders[1:]
+		}
+		return leader, false, nil
+	}
+	sentAddrs := make([]string, 0)
+	send := func(_ context.Context, addr string, _ grpcmetadata.MD, _ *metaservicepb.NodeHeartbeatRequest) (*metaservicepb.NodeHeartbeatResponse, grpcmetadata.MD, error) {
+		sentAddrs = append(sentAddrs, addr)
+		if addr == "old" {
+			return nil, nil, status.Error(codes.Unavailable, "connection refused")
+		}
+		return okResp, grpcmetadata.Pairs("key", "value"), nil
+	}
+	forwarder := newHeartbeatForwarder()
+	resp, header, forwarded, err := forwarder.forward(ctx, resolve, send, grpcmetadata.MD{}, newForwardTestRequest())
+	re.NoError(err)
+	re.True(forwarded)
+	re.Equal(okResp, resp)
+	re.Equal([]string{"value"}, header.Get("key"))
+	re.Equal([]string{"old", "new"}, sentAddrs)
+
+	// The leader hinted by the draining server is tried first.
+	sentAddrs = sentAddrs[:0]
+	resolve = func(_ context.Context) (string, bool, error) {
+		return "old", false, nil
+	}
+	send = func(_ context.Context, addr string, _ grpcmetadata.MD, _ *metaservicepb.NodeHeartbeatRequest) (*metaservicepb.NodeHeartbeatResponse, grpcmetadata.MD, error) {
+		sentAddrs = append(sentAddrs, addr)
+		if addr == "old" {
+			return nil, grpcmetadata.Pairs(leaderEndpointMetadataKey, "new"), status.Error(codes.Unavailable, "draining")
+		}
+		return okResp, nil, nil
+	}
+	_, _, forwarded, err = forwarder.forward(ctx, resolve, send, grpcmetadata.MD{}, newForwardTestRequest())
+	re.NoError(err)
+	re.True(forwarded)
+	re.Equal([]string{"old", "new"}, sentAddrs)
+
+	// The heartbeat is handled locally once this server becomes the leader.
+	leaders = []string{"old", ""}
+	resolve = func(_ context.Context) (string, bool, error) {
+		leader := leaders[0]
+		leaders = leaders[1:]
+		return leader, leader == "", nil
+	}
+	send = func(_ context.Context, _ string, _ grpcmetadata.MD, _ *metaservicepb.NodeHeartbeatRequest) (*metaservicepb.NodeHeartbeatResponse, grpcmetadata.MD, error) {
+		return nil, nil, status.Error(codes.Unavailable, "connection refused")
+	}
+	_, _, forwarded, err = forwarder.forward(ctx, resolve, send, grpcmetadata.MD{}, newForwardTestRequest())
+	re.NoError(err)
+	re.False(forwarded)
+
+	// The other errors are not retried.
+	sentAddrs = sentAddrs[:0]
+	send = func(_ context.Context, addr string, _ grpcmetadata.MD, _ *metaservicepb.NodeHeartbeatRequest) (*metaservicepb.NodeHeartbeatResponse, grpcmetadata.MD, error) {
+		sentAddrs = append(sentAddrs, addr)
+		return nil, nil, status.Error(codes.InvalidArgument, "invalid")
+	}
+	resolve = func(_ context.Context) (string, bool, error) {
+		return "new", false, nil
+	}
+	_, _, forwarded, err = forwarder.forward(ctx, resolve, send, grpcmetadata.MD{}, newForwardTestRequest())
+	re.Error(err)
+	re.True(forwarded)
+	re.Equal([]string{"new"}, sentAddrs)
+}
+
+func TestHeartbeatForwardReplay(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	resolve := func(_ context.Context) (string, bool, error) {
+		return "leader", false, nil
+	}
+
+	// The new leader knows no base version, and asks for the full reports.
+	ackedVersion, acked := uint64(0), false
+	sentReqs := make([]*metaservicepb.NodeHeartbeatRequest, 0)
+	send := func(_ context.Context, _ string, md grpcmetadata.MD, req *metaservicepb.NodeHeartbeatRequest) (*metaservicepb.NodeHeartbeatResponse, grpcmetadata.MD, error) {
+		sentReqs = append(sentReqs, req)
+		report, err := parseHeartbeatReport(grpcmetadata.NewIncomingContext(ctx, md))
+		re.NoError(err)
+		if report.isDelta && (!acked || report.baseVersion != ackedVersion) {
+			return &metaservicepb.NodeHeartbeatResponse{Header: &commonpb.ResponseHeader{Code: coderr.HeartbeatFullReportRequired, Error: ""}}, nil, nil
+		}
+		ackedVersion, acked = report.version, true
+		return &metaservicepb.NodeHeartbeatResponse{Header: okResponseHeader()}, heartbeatResponseMetadata(report.version, true), nil
+	}
+
+	forwarder := newHeartbeatForwarder()
+	fullMD := grpcmetadata.Pairs(heartbeatVersionMetadataKey, "1")
+	resp, _, _, err := forwarder.forward(ctx, resolve, send, fullMD, newForwardTestRequest(0, 1, 2))
+	re.NoError(err)
+	re.Equal(uint32(coderr.Ok), resp.GetHeader().GetCode())
+
+	// The leader changes, and the delta is replayed with the last full node info.
+	acked = false
+	deltaMD := grpcmetadata.Pairs(heartbeatVersionMetadataKey, "2", heartbeatBaseVersionMetadataKey, "1", heartbeatRemovedShardsMetadataKey, "1")
+	resp, header, _, err := forwarder.forward(ctx, resolve, send, deltaMD, newForwardTestRequest(3))
+	re.NoError(err)
+	re.Equal(uint32(coderr.Ok), resp.GetHeader().GetCode())
+	re.Equal([]string{"2"}, header.Get(heartbeatAckedVersionMetadataKey))
+	re.Len(sentReqs, 3)
+	re.Equal([]uint32{0, 2, 3}, forwardTestShardIDs(sentReqs[2]))
+
+	// The deltas accepted by the leader are merged too.
+	acked = true
+	deltaMD = grpcmetadata.Pairs(heartbeatVersionMetadataKey, "3", heartbeatBaseVersionMetadataKey, "2", heartbeatRemovedShardsMetadataKey, "0")
+	_, _, _, err = forwarder.forward(ctx, resolve, send, deltaMD, newForwardTestRequest(4))
+	re.NoError(err)
+	re.Len(sentReqs, 4)
+	acked = false
+	deltaMD = grpcmetadata.Pairs(heartbeatVersionMetadataKey, "4", heartbeatBaseVersionMetadataKey, "3")
+	_, _, _, err = forwarder.forward(ctx, resolve, send, deltaMD, newForwardTestRequest())
+	re.NoError(err)
+	re.Len(sentReqs, 6)
+	re.Equal([]uint32{2, 3, 4}, forwardTestShardIDs(sentReqs[5]))
+
+	// The delta with the unknown base is answered by the leader as is.
+	acked = false
+	deltaMD = grpcmetadata.Pairs(heartbeatVersionMetadataKey, "6", heartbeatBaseVersionMetadataKey, "5")
+	resp, _, _, err = forwarder.forward(ctx, resolve, send, deltaMD, newForwardTestRequest())
+	re.NoError(err)
+	re.Equal(uint32(coderr.HeartbeatFullReportRequired), resp.GetHeader().GetCode())
+	re.Len(sentReqs, 7)
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/server/cluster"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+// The heartbeat proto has no field for the interval or the lease either, so the negotiated timing is carried by the
+// response headers, and the nodes not knowing the headers simply keep their own intervals. The lease is enforced by the
+// failure detector anyway, so such nodes are still judged by the same lease as the others.
+const (
+	heartbeatIntervalMetadataKey = "x-horaemeta-heartbeat-interval-ms"
+	heartbeatLeaseMetadataKey    = "x-horaemeta-heartbeat-lease-ms"
+	// heartbeatsPerLease is the number of heartbeats expected within the lease, so the node survives the lost ones.
+	heartbeatsPerLease = 3
+	// leaderNodeCountRefreshInterval avoids counting the nodes of all the clusters on every heartbeat.
+	leaderNodeCountRefreshInterval = time.Second * 10
+)
+
+// adviseHeartbeatIntervalMs returns the interval keeping the heartbeats of all the nodes served by the leader under the
+// rate budget, which is clamped by the bounds of the cluster.
+func adviseHeartbeatIntervalMs(bounds storage.HeartbeatIntervalBounds, leaderNodeCount int, rateBudget uint32) uint64 {
+	intervalMs := bounds.MinMs
+	if rateBudget > 0 {
+		intervalMs = (uint64(leaderNodeCount)*1000 + uint64(rateBudget) - 1) / uint64(rateBudget)
+	}
+
+	if intervalMs < bounds.MinMs {
+		return bounds.MinMs
+	}
+	if intervalMs > bounds.MaxMs {
+		return bounds.MaxMs
+	}
+	return intervalMs
+}
+
+// LeaseBounds are the min and max leases negotiated with the nodes, and the max one of zero means no upper bound.
+type LeaseBounds struct {
+	MinMs uint64
+	MaxMs uint64
+}
+
+// negotiateHeartbeat returns the lease and the heartbeat interval of the node. The lease asked by the node in seconds is
+// clamped by the bounds, and the interval advised to the node is shortened to fit heartbeatsPerLease heartbeats in the
+// lease.
+func negotiateHeartbeat(bounds LeaseBounds, leaseSec uint32, advisedIntervalMs uint64, advised bool) metadata.HeartbeatStats {
+	leaseMs := uint64(leaseSec) * 1000
+	if leaseMs == 0 {
+		leaseMs = uint64(metadata.NodeExpiredThreshold.Milliseconds())
+	}
+	leaseMs = max(leaseMs, bounds.MinMs)
+	if bounds.MaxMs > 0 {
+		leaseMs = min(leaseMs, bounds.MaxMs)
+	}
+
+	intervalMs := leaseMs / heartbeatsPerLease
+	if advised {
+		intervalMs = min(intervalMs, advisedIntervalMs)
+	}
+	return metadata.HeartbeatStats{
+		LeaseMs:    leaseMs,
+		IntervalMs: intervalMs,
+		JitterMs:   0,
+	}
+}
+
+// heartbeatIntervalAdvisor advises the heartbeat interval to the nodes according to the load of the leader, which is
+// measured by the number of the nodes of all the clusters.
+type heartbeatIntervalAdvisor struct {
+	rateBudget uint32
+	clock      clock.Clock
+
+	lock            sync.Mutex
+	leaderNodeCount int
+	refreshedAt     time.Time
+}
+
+func newHeartbeatIntervalAdvisor(rateBudget uint32, clk clock.Clock) *heartbeatIntervalAdvisor {
+	return &heartbeatIntervalAdvisor{
+		rateBudget:      rateBudget,
+		clock:           clk,
+		lock:            sync.Mutex{},
+		leaderNodeCount: 0,
+		refreshedAt:     time.Time{},
+	}
+}
+
+// advise the second output parameter bool: returns false if no interval should be advised to the nodes of the cluster.
+func (a *heartbeatIntervalAdvisor) advise(ctx context.Context, clusterManager cluster.Manager, c *cluster.Cluster) (uint64, bool) {
+	bounds := c.GetMetadata().GetHeartbeatIntervalBounds()
+	if !bounds.Enabled() {
+		return 0, false
+	}
+
+	return adviseHeartbeatIntervalMs(bounds, a.getLeaderNodeCount(ctx, clusterManager), a.rateBudget), true
+}
+
+func (a *heartbeatIntervalAdvisor) getLeaderNodeCount(ctx context.Context, clusterManager cluster.Manager) int {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if a.clock.Now().Sub(a.refreshedAt) < leaderNodeCountRefreshInterval {
+		return a.leaderNodeCount
+	}
+
+	clusters, err := clusterManager.ListClusters(ctx)
+	if err != nil {
+		return a.leaderNodeCount
+	}
+	leaderNodeCount := 0
+	for _, c := range clusters {
+		leaderNodeCount += len(c.GetMetadata().GetRegisteredNodes())
+	}
+	a.leaderNodeCount = leaderNodeCount
+	a.refreshedAt = a.clock.Now()
+	return leaderNodeCount
+}
+
+func setHeartbeatTimingMetadata(md grpcmetadata.MD, heartbeat metadata.HeartbeatStats) {
+	md.Set(heartbeatIntervalMetadataKey, strconv.FormatUint(heartbeat.IntervalMs, 10))
+	md.Set(heartbeatLeaseMetadataKey, strconv.FormatUint(heartbeat.LeaseMs, 10))
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"testing"
+
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdviseHeartbeatIntervalMs(t *testing.T) {
+	re := require.New(t)
+
+	bounds := storage.HeartbeatIntervalBounds{MinMs: 1000, MaxMs: 10000}
+	// The small clusters keep the min interval.
+	re.Equal(uint64(1000), adviseHeartbeatIntervalMs(bounds, 10, 100))
+	// The interval grows with the nodes to keep the heartbeats under the budget.
+	re.Equal(uint64(5000), adviseHeartbeatIntervalMs(bounds, 500, 100))
+	re.Equal(uint64(5010), adviseHeartbeatIntervalMs(bounds, 501, 100))
+	// And it never exceeds the max interval.
+	re.Equal(uint64(10000), adviseHeartbeatIntervalMs(bounds, 5000, 100))
+	// No budget means the min interval.
+	re.Equal(uint64(1000), adviseHeartbeatIntervalMs(bounds, 5000, 0))
+}
+
+func TestNegotiateHeartbeat(t *testing.T) {
+	re := require.New(t)
+
+	bounds := LeaseBounds{MinMs: 5000, MaxMs: 60000}
+	// The nodes asking for no lease get the default one.
+	heartbeat := negotiateHeartbeat(bounds, 0, 0, false)
+	re.Equal(uint64(metadata.NodeExpiredThreshold.Milliseconds()), heartbeat.LeaseMs)
+	re.Equal(heartbeat.LeaseMs/heartbeatsPerLease, heartbeat.IntervalMs)
+	// The lease asked by the node is clamped by the bounds.
+	re.Equal(uint64(5000), negotiateHeartbeat(bounds, 1, 0, false).LeaseMs)
+	re.Equal(uint64(30000), negotiateHeartbeat(bounds, 30, 0, false).LeaseMs)
+	re.Equal(uint64(60000), negotiateHeartbeat(bounds, 600, 0, false).LeaseMs)
+	re.Equal(uint64(600000), negotiateHeartbeat(LeaseBounds{MinMs: 0, MaxMs: 0}, 600, 0, false).LeaseMs)
+	// The advised interval is kept if it fits in the lease, otherwise it is shortened.
+	re.Equal(uint64(2000), negotiateHeartbeat(bounds, 30, 2000, true).IntervalMs)
+	re.Equal(uint64(10000), negotiateHeartbeat(bounds, 30, 20000, true).IntervalMs)
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/service"
+	"go.uber.org/zap"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+// nodeProtocolVersionMetadataKey is the protocol version spoken by the node, which is reported by the metadata of the
+// heartbeats because the heartbeat proto doesn't describe it.
+const nodeProtocolVersionMetadataKey = "x-horaemeta-node-protocol-version"
+
+// parseNodeProtocolVersion returns zero if the protocol version is not reported.
+func parseNodeProtocolVersion(ctx context.Context) (uint32, error) {
+	md, ok := grpcmetadata.FromIncomingContext(ctx)
+	if !ok {
+		return 0, nil
+	}
+	values := md.Get(nodeProtocolVersionMetadataKey)
+	if len(values) == 0 {
+		return 0, nil
+	}
+	version, err := strconv.ParseUint(values[0], 10, 32)
+	if err != nil || version == 0 {
+		return 0, ErrInvalidNodeProtocolVersion.WithCausef("parse %s:%s, err:%v", nodeProtocolVersionMetadataKey, values[0], err)
+	}
+	return uint32(version), nil
+}
+
+// warnIncompatibleNode only warns about the node speaking an unsupported protocol version instead of rejecting its
+// heartbeats, so the rolling upgrade is not blocked by the skew between the versions.
+func warnIncompatibleNode(clusterName, nodeName, nodeVersion string, protocolVersion uint32) {
+	if service.IsProtocolVersionSupported(protocolVersion) {
+		return
+	}
+	incompatibleHeartbeats.WithLabelValues(clusterName, strconv.FormatUint(uint64(protocolVersion), 10)).Inc()
+	log.Warn("node protocol version is incompatible", zap.String("clusterName", clusterName), zap.String("name", nodeName), zap.String("nodeVersion", nodeVersion), zap.Uint32("protocolVersion", protocolVersion), zap.Any("supported", service.SupportedProtocolVersions()))
+}
@@ -0,0 +1,65 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/service"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/require"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+func TestParseNodeProtocolVersion(t *testing.T) {
+	re := require.New(t)
+
+	// The protocol version is not reported by the legacy nodes, which are compatible.
+	version, err := parseNodeProtocolVersion(context.Background())
+	re.NoError(err)
+	re.Equal(uint32(0), version)
+	re.True(service.IsProtocolVersionSupported(version))
+
+	ctx := grpcmetadata.NewIncomingContext(context.Background(), grpcmetadata.Pairs(nodeProtocolVersionMetadataKey, "1"))
+	version, err = parseNodeProtocolVersion(ctx)
+	re.NoError(err)
+	re.Equal(uint32(1), version)
+	re.True(service.IsProtocolVersionSupported(version))
+
+	for _, invalid := range []string{"0", "-1", "v1"} {
+		ctx = grpcmetadata.NewIncomingContext(context.Background(), grpcmetadata.Pairs(nodeProtocolVersionMetadataKey, invalid))
+		_, err = parseNodeProtocolVersion(ctx)
+		re.True(coderr.Is(err, coderr.InvalidParams))
+	}
+}
+
+func TestWarnIncompatibleNode(t *testing.T) {
+	re := require.New(t)
+
+	incompatibleVersion := service.MaxProtocolVersion + 1
+	re.False(service.IsProtocolVersionSupported(incompatibleVersion))
+	counter := incompatibleHeartbeats.WithLabelValues("testWarnIncompatibleNode", strconv.FormatUint(uint64(incompatibleVersion), 10))
+	before := testutil.ToFloat64(counter)
+
+	warnIncompatibleNode("testWarnIncompatibleNode", "node0", "1.0.0", service.MaxProtocolVersion)
+	re.Equal(before, testutil.ToFloat64(counter))
+	warnIncompatibleNode("testWarnIncompatibleNode", "node0", "2.0.0", incompatibleVersion)
+	re.Equal(before+1, testutil.ToFloat64(counter))
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/CeresDB/horaemeta/server/cluster"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+// tableStatsMetadataKey carries the optional stats of the shards and the tables of the node in the metadata of the
+// heartbeat, and the value is a json object of cluster.StatsReport.
+const tableStatsMetadataKey = "x-horaemeta-table-stats"
+
+// parseStatsReport parses the stats in the metadata of the heartbeat, and false is returned if no stats is reported.
+func parseStatsReport(ctx context.Context) (cluster.StatsReport, bool, error) {
+	report := cluster.StatsReport{Shards: nil, Tables: nil}
+	md, ok := grpcmetadata.FromIncomingContext(ctx)
+	if !ok {
+		return report, false, nil
+	}
+
+	values := md.Get(tableStatsMetadataKey)
+	if len(values) == 0 {
+		return report, false, nil
+	}
+	if err := json.Unmarshal([]byte(values[0]), &report); err != nil {
+		return report, false, ErrInvalidTableStats.WithCausef("parse %s:%s, err:%v", tableStatsMetadataKey, values[0], err)
+	}
+	return report, true, nil
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/cluster"
+	"github.com/stretchr/testify/require"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+func TestParseStatsReport(t *testing.T) {
+	re := require.New(t)
+
+	_, reported, err := parseStatsReport(context.Background())
+	re.NoError(err)
+	re.False(reported)
+
+	ctx := grpcmetadata.NewIncomingContext(context.Background(), grpcmetadata.Pairs(
+		tableStatsMetadataKey, `{"shards":[{"shardID":1,"rowCount":100,"bytes":4096,"writeRowsPerSec":10.5}],"tables":[{"tableID":7,"rowCount":60,"bytes":2048}]}`,
+	))
+	report, reported, err := parseStatsReport(ctx)
+	re.NoError(err)
+	re.True(reported)
+	re.Equal([]cluster.ShardStatsReport{{ShardID: 1, RowCount: 100, Bytes: 4096, WriteRowsPerSec: 10.5}}, report.Shards)
+	re.Equal([]cluster.TableStatsReport{{TableID: 7, RowCount: 60, Bytes: 2048, WriteRowsPerSec: 0}}, report.Tables)
+
+	ctx = grpcmetadata.NewIncomingContext(context.Background(), grpcmetadata.Pairs(tableStatsMetadataKey, "{"))
+	_, _, err = parseStatsReport(ctx)
+	re.True(coderr.Is(err, coderr.InvalidParams))
+}
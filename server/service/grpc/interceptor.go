@@ -0,0 +1,229 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/pkg/requestid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// FlowLimitUnaryInterceptor rejects the unary requests exceeding the flow limiter according to the weights of the methods.
+func FlowLimitUnaryInterceptor(h Handler) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		if err := allowMethod(h, info.FullMethod); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// FlowLimitStreamInterceptor rejects the streams exceeding the flow limiter according to the weights of the methods, and
+// only the opening of the stream takes the tokens.
+func FlowLimitStreamInterceptor(h Handler) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if err := allowMethod(h, info.FullMethod); err != nil {
+			return err
+		}
+		return handler(srv, ss)
+	}
+}
+
+func allowMethod(h Handler, fullMethod string) error {
+	flowLimiter, err := h.GetFlowLimiter()
+	if err != nil {
+		// The limiter is not ready until the server is started, and the requests should not be blocked by it.
+		return nil
+	}
+
+	// The weights are configured by the method names without the service name.
+	method := path.Base(fullMethod)
+	if !flowLimiter.AllowMethod(method) {
+		log.Warn("grpc request is rejected by flow limiter", zap.String("method", fullMethod))
+		return status.Error(codes.ResourceExhausted, ErrFlowLimit.WithCausef("method:%s", method).Error())
+	}
+	return nil
+}
+
+// RecoveryUnaryInterceptor turns the panic of the handler into an internal error, so a bad request can't crash the server.
+func RecoveryUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				resp, err = nil, recoveredError(info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+// RecoveryStreamInterceptor turns the panic of the stream handler into an internal error, which closes the stream only.
+func RecoveryStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recoveredError(info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+func recoveredError(fullMethod string, r any) error {
+	log.Error("grpc handler panics", zap.String("method", fullMethod), zap.Any("panic", r), zap.Stack("stack"))
+	return status.Error(codes.Internal, ErrHandlerPanic.WithCausef("method:%s, panic:%v", fullMethod, r).Error())
+}
+
+// LoggingUnaryInterceptor logs the requests with their responses and records the latency of them, and the requests slower
+// than the slowThreshold are warned. Zero slowThreshold disables the warning.
+func LoggingUnaryInterceptor(slowThreshold time.Duration) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		start := time.Now()
+		log.Debug("receive grpc request", zap.String("method", info.FullMethod), zap.String("client", peerAddr(ctx)), zap.Any("request", req), requestid.Field(ctx))
+		resp, err := handler(ctx, req)
+		latency := time.Since(start)
+		requestDuration.WithLabelValues(path.Base(info.FullMethod), status.Code(err).String()).Observe(latency.Seconds())
+
+		if err != nil {
+			log.Warn("grpc request failed", zap.String("method", info.FullMethod), zap.String("client", peerAddr(ctx)), zap.Duration("latency", latency), zap.Error(err), requestid.Field(ctx))
+		} else {
+			log.Debug("finish grpc request", zap.String("method", info.FullMethod), zap.Duration("latency", latency), zap.Any("response", resp))
+		}
+		if slowThreshold > 0 && latency > slowThreshold {
+			log.Warn("slow grpc request", zap.String("method", info.FullMethod), zap.String("client", peerAddr(ctx)), zap.Duration("latency", latency), zap.Any("request", req), requestid.Field(ctx))
+		}
+		return resp, err
+	}
+}
+
+// LoggingStreamInterceptor logs the opening and the closing of the streams and records how long they last. The streams
+// are expected to be long-lived, so they are never warned as the slow ones.
+func LoggingStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		log.Info("open grpc stream", zap.String("method", info.FullMethod), zap.String("client", peerAddr(ss.Context())), requestid.Field(ss.Context()))
+		err := handler(srv, ss)
+		duration := time.Since(start)
+		requestDuration.WithLabelValues(path.Base(info.FullMethod), status.Code(err).String()).Observe(duration.Seconds())
+		log.Info("close grpc stream", zap.String("method", info.FullMethod), zap.String("client", peerAddr(ss.Context())), zap.Duration("duration", duration), zap.Error(err))
+		return err
+	}
+}
+
+func peerAddr(ctx context.Context) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok || p.Addr == nil {
+		return ""
+	}
+	return p.Addr.String()
+}
+
+// ServiceDesc returns the description of the meta service whose handlers are wrapped by the interceptors, from the
+// outermost: the request id, the logging, the recovery, the drain and the flow limit ones, so the logs of the requests carry
+// the request ids, the recovered panics are logged as the failed requests, and the requests rejected by the draining server take no tokens of the flow limiter.
+// The interceptors are bound to the service instead of the grpc server, because the server created by the embedded etcd
+// accepts no extra interceptors and serves the requests of etcd too.
+func (s *Service) ServiceDesc() *grpc.ServiceDesc {
+	return withInterceptors(withGetTablesOfShardsStream(withRouteTablesByID(&metaservicepb.CeresmetaRpcService_ServiceDesc)),
+		chainUnaryInterceptors(RequestIDUnaryInterceptor(), LoggingUnaryInterceptor(s.slowRequestThreshold), RecoveryUnaryInterceptor(), DrainUnaryInterceptor(s.h), FlowLimitUnaryInterceptor(s.h)),
+		chainStreamInterceptors(RequestIDStreamInterceptor(), LoggingStreamInterceptor(), RecoveryStreamInterceptor(), DrainStreamInterceptor(s.h), FlowLimitStreamInterceptor(s.h)))
+}
+
+// chainUnaryInterceptors combines the interceptors into one, and the first one is the outermost.
+func chainUnaryInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(ctx context.Context, req any) (any, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return chained(ctx, req)
+	}
+}
+
+// chainStreamInterceptors combines the interceptors into one, and the first one is the outermost.
+func chainStreamInterceptors(interceptors ...grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		chained := handler
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], chained
+			chained = func(srv any, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, next)
+			}
+		}
+		return chained(srv, ss)
+	}
+}
+
+func withInterceptors(desc *grpc.ServiceDesc, unaryInterceptor grpc.UnaryServerInterceptor, streamInterceptor grpc.StreamServerInterceptor) *grpc.ServiceDesc {
+	methods := make([]grpc.MethodDesc, 0, len(desc.Methods))
+	for _, method := range desc.Methods {
+		methodHandler := method.Handler
+		methods = append(methods, grpc.MethodDesc{
+			MethodName: method.MethodName,
+			Handler: func(srv any, ctx context.Context, dec func(any) error, serverInterceptor grpc.UnaryServerInterceptor) (any, error) {
+				// Run the interceptor of the server, if any, after the unaryInterceptor.
+				interceptor := func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+					return unaryInterceptor(ctx, req, info, func(ctx context.Context, req any) (any, error) {
+						if serverInterceptor == nil {
+							return handler(ctx, req)
+						}
+						return serverInterceptor(ctx, req, info, handler)
+					})
+				}
+				return methodHandler(srv, ctx, dec, interceptor)
+			},
+		})
+	}
+
+	streams := make([]grpc.StreamDesc, 0, len(desc.Streams))
+	for _, stream := range desc.Streams {
+		streamHandler := stream.Handler
+		info := &grpc.StreamServerInfo{
+			FullMethod:     "/" + desc.ServiceName + "/" + stream.StreamName,
+			IsClientStream: stream.ClientStreams,
+			IsServerStream: stream.ServerStreams,
+		}
+		streams = append(streams, grpc.StreamDesc{
+			StreamName: stream.StreamName,
+			Handler: func(srv any, ss grpc.ServerStream) error {
+				return streamInterceptor(srv, ss, info, streamHandler)
+			},
+			ServerStreams: stream.ServerStreams,
+			ClientStreams: stream.ClientStreams,
+		})
+	}
+
+	return &grpc.ServiceDesc{
+		ServiceName: desc.ServiceName,
+		HandlerType: desc.HandlerType,
+		Methods:     methods,
+		Streams:     streams,
+		Metadata:    desc.Metadata,
+	}
+}
@@ -0,0 +1,75 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecoveryUnaryInterceptor(t *testing.T) {
+	re := require.New(t)
+
+	info := &grpc.UnaryServerInfo{Server: nil, FullMethod: "/test/Panic"}
+	interceptor := chainUnaryInterceptors(LoggingUnaryInterceptor(time.Second), RecoveryUnaryInterceptor())
+	resp, err := interceptor(context.Background(), "req", info, func(_ context.Context, _ any) (any, error) {
+		panic("boom")
+	})
+	re.Nil(resp)
+	re.Equal(codes.Internal, status.Code(err))
+
+	resp, err = interceptor(context.Background(), "req", info, func(_ context.Context, req any) (any, error) {
+		return req, nil
+	})
+	re.NoError(err)
+	re.Equal("req", resp)
+}
+
+func TestRecoveryStreamInterceptor(t *testing.T) {
+	re := require.New(t)
+
+	info := &grpc.StreamServerInfo{FullMethod: "/test/Panic", IsClientStream: true, IsServerStream: true}
+	err := RecoveryStreamInterceptor()(nil, nil, info, func(_ any, _ grpc.ServerStream) error {
+		panic("boom")
+	})
+	re.Equal(codes.Internal, status.Code(err))
+}
+
+func TestChainUnaryInterceptors(t *testing.T) {
+	re := require.New(t)
+
+	var order []string
+	record := func(name string) grpc.UnaryServerInterceptor {
+		return func(ctx context.Context, req any, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+			order = append(order, name)
+			return handler(ctx, req)
+		}
+	}
+	info := &grpc.UnaryServerInfo{Server: nil, FullMethod: "/test/Chain"}
+	_, err := chainUnaryInterceptors(record("first"), record("second"))(context.Background(), nil, info, func(_ context.Context, _ any) (any, error) {
+		order = append(order, "handler")
+		return nil, nil
+	})
+	re.NoError(err)
+	re.Equal([]string{"first", "second", "handler"}, order)
+}
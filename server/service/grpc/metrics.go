@@ -0,0 +1,39 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// requestDuration observes the latency of the grpc requests handled by the meta service, including the forwarded ones.
+var requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "horaemeta",
+	Subsystem: "grpc",
+	Name:      "request_duration_seconds",
+	Help:      "Duration of handling the grpc requests.",
+	Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+}, []string{"method", "code"})
+
+// incompatibleHeartbeats counts the heartbeats from the nodes speaking the protocol versions unsupported by the meta.
+var incompatibleHeartbeats = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "horaemeta",
+	Subsystem: "grpc",
+	Name:      "incompatible_heartbeats_total",
+	Help:      "Number of the heartbeats from the nodes with incompatible protocol versions.",
+}, []string{"cluster", "protocol_version"})
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+// The GetNodes proto carries the endpoints and the shards of the nodes only, so the status of the registered nodes is
+// described by the response headers, one JSON value per node, to let the clients prefer the healthy and zone-local nodes
+// when routing the follower reads.
+const nodeStatusMetadataKey = "x-horaemeta-node-status"
+
+type nodeStatus struct {
+	Endpoint string `json:"endpoint"`
+	// State is offline if the node is expired, even if its persisted state is online.
+	State          string `json:"state"`
+	Zone           string `json:"zone"`
+	HeartbeatAgeMs uint64 `json:"heartbeatAgeMs"`
+}
+
+func buildNodeStatusHeader(nodes []metadata.RegisteredNode, now time.Time) grpcmetadata.MD {
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Node.Name < nodes[j].Node.Name
+	})
+
+	md := grpcmetadata.MD{}
+	for _, node := range nodes {
+		state := storage.ConvertNodeStateToString(node.Node.State)
+		if node.IsExpired(now) {
+			state = storage.ConvertNodeStateToString(storage.NodeStateOffline)
+		}
+		var heartbeatAgeMs uint64
+		if nowMs := uint64(now.UnixMilli()); nowMs > node.Node.LastTouchTime {
+			heartbeatAgeMs = nowMs - node.Node.LastTouchTime
+		}
+
+		value, err := json.Marshal(nodeStatus{
+			Endpoint:       node.Node.Name,
+			State:          state,
+			Zone:           node.Node.NodeStats.Zone,
+			HeartbeatAgeMs: heartbeatAgeMs,
+		})
+		if err != nil {
+			log.Warn("encode node status failed", zap.String("node", node.Node.Name), zap.Error(err))
+			continue
+		}
+		md.Append(nodeStatusMetadataKey, string(value))
+	}
+	return md
+}
+
+// setNodeStatusHeader sends the status of the registered nodes of the cluster in the response headers.
+func setNodeStatusHeader(ctx context.Context, clusterMetadata *metadata.ClusterMetadata) {
+	md := buildNodeStatusHeader(clusterMetadata.GetRegisteredNodes(), clusterMetadata.Clock().Now())
+	if len(md) == 0 {
+		return
+	}
+	if err := grpc.SetHeader(ctx, md); err != nil {
+		log.Warn("set node status response header failed", zap.Error(err))
+	}
+}
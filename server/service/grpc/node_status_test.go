@@ -0,0 +1,67 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildNodeStatusHeader(t *testing.T) {
+	re := require.New(t)
+
+	now := time.UnixMilli(100_000)
+	registeredNode := func(name, zone string, lastTouchTime uint64) metadata.RegisteredNode {
+		nodeStats := storage.NewEmptyNodeStats()
+		nodeStats.Zone = zone
+		return metadata.RegisteredNode{
+			Node: storage.Node{
+				Name:          name,
+				NodeStats:     nodeStats,
+				LastTouchTime: lastTouchTime,
+				State:         storage.NodeStateOnline,
+			},
+			ShardInfos: nil,
+			Heartbeat:  metadata.HeartbeatStats{LeaseMs: 0, IntervalMs: 0, JitterMs: 0},
+		}
+	}
+	nodes := []metadata.RegisteredNode{
+		registeredNode("node-1", "zone-b", 0),
+		registeredNode("node-0", "zone-a", 99_000),
+	}
+
+	md := buildNodeStatusHeader(nodes, now)
+	values := md.Get(nodeStatusMetadataKey)
+	re.Len(values, 2)
+
+	statuses := make([]nodeStatus, 0, len(values))
+	for _, value := range values {
+		var status nodeStatus
+		re.NoError(json.Unmarshal([]byte(value), &status))
+		statuses = append(statuses, status)
+	}
+	re.Equal([]nodeStatus{
+		{Endpoint: "node-0", State: "online", Zone: "zone-a", HeartbeatAgeMs: 1000},
+		// The node without the heartbeats for a long time is regarded as offline.
+		{Endpoint: "node-1", State: "offline", Zone: "zone-b", HeartbeatAgeMs: 100_000},
+	}, statuses)
+}
@@ -0,0 +1,73 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/pkg/requestid"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+// RequestIDUnaryInterceptor honors the request id carried by the metadata of the request or generates one, which is put
+// into the context for the logs, sent back by the response header, and forwarded to the leader by the outgoing metadata.
+func RequestIDUnaryInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, requestID := withRequestID(ctx)
+		if err := grpc.SetHeader(ctx, grpcmetadata.Pairs(requestid.MetadataKey, requestID)); err != nil {
+			log.Debug("set request id header failed", zap.String("method", info.FullMethod), zap.Error(err))
+		}
+		return handler(ctx, req)
+	}
+}
+
+// RequestIDStreamInterceptor is RequestIDUnaryInterceptor for the streams, and all the messages of a stream share the
+// request id.
+func RequestIDStreamInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, requestID := withRequestID(ss.Context())
+		if err := ss.SetHeader(grpcmetadata.Pairs(requestid.MetadataKey, requestID)); err != nil {
+			log.Debug("set request id header failed", zap.String("method", info.FullMethod), zap.Error(err))
+		}
+		return handler(srv, &requestIDServerStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+func withRequestID(ctx context.Context) (context.Context, string) {
+	var presented string
+	if md, ok := grpcmetadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestid.MetadataKey); len(values) > 0 {
+			presented = values[0]
+		}
+	}
+	requestID := requestid.Resolve(presented)
+	ctx = requestid.WithID(ctx, requestID)
+	return grpcmetadata.AppendToOutgoingContext(ctx, requestid.MetadataKey, requestID), requestID
+}
+
+// requestIDServerStream replaces the context of the stream with the one carrying the request id.
+type requestIDServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *requestIDServerStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/pkg/requestid"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+func TestRequestIDUnaryInterceptor(t *testing.T) {
+	re := require.New(t)
+
+	info := &grpc.UnaryServerInfo{Server: nil, FullMethod: "/test/RequestID"}
+	interceptor := RequestIDUnaryInterceptor()
+	var requestID, forwardedID string
+	handler := func(ctx context.Context, _ any) (any, error) {
+		requestID = requestid.FromContext(ctx)
+		md, _ := grpcmetadata.FromOutgoingContext(ctx)
+		forwardedID = md.Get(requestid.MetadataKey)[0]
+		return nil, nil
+	}
+
+	// The request id presented by the client is honored.
+	ctx := grpcmetadata.NewIncomingContext(context.Background(), grpcmetadata.Pairs(requestid.MetadataKey, "req-1"))
+	_, err := interceptor(ctx, nil, info, handler)
+	re.NoError(err)
+	re.Equal("req-1", requestID)
+	re.Equal("req-1", forwardedID)
+
+	// A new one is generated without the request id.
+	_, err = interceptor(context.Background(), nil, info, handler)
+	re.NoError(err)
+	re.NotEmpty(requestID)
+	re.NotEqual("req-1", requestID)
+	re.Equal(requestID, forwardedID)
+}
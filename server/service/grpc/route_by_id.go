@@ -0,0 +1,157 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+// The proto has no method to route the tables by ids, so RouteTablesByID is registered to the meta service in addition
+// to the generated methods. It reuses the messages of RouteTables: the table names of the request carry the decimal ids
+// of the tables, the schema name is ignored, and the route entries of the response are keyed by the decimal ids.
+const routeTablesByIDMethod = "RouteTablesByID"
+
+var routeTablesByIDFullMethod = "/" + metaservicepb.CeresmetaRpcService_ServiceDesc.ServiceName + "/" + routeTablesByIDMethod
+
+// withRouteTablesByID returns the description of the meta service with the RouteTablesByID method added.
+func withRouteTablesByID(desc *grpc.ServiceDesc) *grpc.ServiceDesc {
+	methods := make([]grpc.MethodDesc, 0, len(desc.Methods)+1)
+	methods = append(methods, desc.Methods...)
+	methods = append(methods, grpc.MethodDesc{
+		MethodName: routeTablesByIDMethod,
+		Handler:    routeTablesByIDHandler,
+	})
+
+	return &grpc.ServiceDesc{
+		ServiceName: desc.ServiceName,
+		HandlerType: desc.HandlerType,
+		Methods:     methods,
+		Streams:     desc.Streams,
+		Metadata:    desc.Metadata,
+	}
+}
+
+func routeTablesByIDHandler(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(metaservicepb.RouteTablesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(*Service).RouteTablesByID(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: routeTablesByIDFullMethod,
+	}
+	handler := func(ctx context.Context, req any) (any, error) {
+		return srv.(*Service).RouteTablesByID(ctx, req.(*metaservicepb.RouteTablesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func parseRouteTableIDs(req *metaservicepb.RouteTablesRequest) ([]storage.TableID, error) {
+	tableIDs := make([]storage.TableID, 0, len(req.GetTableNames()))
+	for _, value := range req.GetTableNames() {
+		id, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return nil, ErrInvalidTableID.WithCausef("tableID:%s, err:%v", value, err)
+		}
+		tableIDs = append(tableIDs, storage.TableID(id))
+	}
+	return tableIDs, nil
+}
+
+// RouteTablesByID routes the tables by their ids for the data nodes holding the table ids only, e.g. during the replay of
+// the WAL, so the names of the tables are not required to be resolved first.
+func (s *Service) RouteTablesByID(ctx context.Context, req *metaservicepb.RouteTablesRequest) (*metaservicepb.RouteTablesResponse, error) {
+	tableIDs, err := parseRouteTableIDs(req)
+	if err != nil {
+		return &metaservicepb.RouteTablesResponse{Header: responseHeader(err, "grpc routeTablesByID")}, nil
+	}
+	clusterName := req.GetHeader().GetClusterName()
+
+	// Serve the request locally without forwarding if it allows the stale read.
+	if clusterMetadata, ok := s.getStaleReadMetadata(ctx, clusterName); ok {
+		if resp, notModified := routeNotModifiedResponse(ctx, clusterMetadata); notModified {
+			return resp, nil
+		}
+		routeTableResult, err := clusterMetadata.RouteTablesByIDs(ctx, tableIDs)
+		if err != nil {
+			return &metaservicepb.RouteTablesResponse{Header: responseHeader(err, "grpc stale routeTablesByID")}, nil
+		}
+		return s.convertAuthorizedRouteTableResult(ctx, clusterName, routeTableResult), nil
+	}
+
+	forwardedAddr, _, err := s.getForwardedAddr(ctx)
+	if err != nil {
+		return &metaservicepb.RouteTablesResponse{Header: responseHeader(err, "grpc routeTablesByID")}, nil
+	}
+
+	// Forward request to the leader, as well as the route version known by the client and the route headers of the leader.
+	if forwardedAddr != "" {
+		conn, err := s.getForwardedGrpcClient(ctx, forwardedAddr)
+		if err != nil {
+			return &metaservicepb.RouteTablesResponse{Header: responseHeader(err, "grpc routeTablesByID")}, nil
+		}
+		var header grpcmetadata.MD
+		forwardedCtx, headerOption := forwardRouteMetadata(forwardTenantToken(ctx), &header)
+		resp := new(metaservicepb.RouteTablesResponse)
+		if err := conn.Invoke(forwardedCtx, routeTablesByIDFullMethod, req, resp, headerOption); err != nil {
+			return nil, err
+		}
+		forwardRouteHeader(ctx, header)
+		return resp, nil
+	}
+
+	log.Debug("[RouteTablesByID]", zap.String("clusterName", clusterName), zap.Int("numTables", len(tableIDs)))
+
+	if resp, notModified := s.leaderRouteNotModifiedResponse(ctx, clusterName); notModified {
+		return resp, nil
+	}
+	routeTableResult, err := s.h.GetClusterManager().RouteTablesByIDs(ctx, clusterName, tableIDs)
+	if err != nil {
+		return &metaservicepb.RouteTablesResponse{Header: responseHeader(err, "grpc routeTablesByID")}, nil
+	}
+
+	return s.convertAuthorizedRouteTableResult(ctx, clusterName, routeTableResult), nil
+}
+
+// convertAuthorizedRouteTableResult converts the result if the tenant of the request can access the schemas of all the
+// routed tables, which are only known after the tables are found by their ids.
+func (s *Service) convertAuthorizedRouteTableResult(ctx context.Context, clusterName string, routeTableResult metadata.RouteTablesResult) *metaservicepb.RouteTablesResponse {
+	authorizedSchemas := make(map[string]struct{})
+	for _, entry := range routeTableResult.RouteEntries {
+		if _, ok := authorizedSchemas[entry.Table.SchemaName]; ok {
+			continue
+		}
+		if err := s.authorizeTenant(ctx, clusterName, entry.Table.SchemaName); err != nil {
+			return &metaservicepb.RouteTablesResponse{Header: responseHeader(err, "grpc routeTablesByID")}
+		}
+		authorizedSchemas[entry.Table.SchemaName] = struct{}{}
+	}
+	setRouteVersionHeader(ctx, routeTableResult.RouteVersion)
+	return convertRouteTableResult(routeTableResult)
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"testing"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRouteTableIDs(t *testing.T) {
+	re := require.New(t)
+
+	tableIDs, err := parseRouteTableIDs(&metaservicepb.RouteTablesRequest{Header: nil, SchemaName: "", TableNames: []string{"1", "18446744073709551615"}})
+	re.NoError(err)
+	re.Equal([]storage.TableID{1, 18446744073709551615}, tableIDs)
+
+	_, err = parseRouteTableIDs(&metaservicepb.RouteTablesRequest{Header: nil, SchemaName: "", TableNames: []string{"1", "table"}})
+	re.True(coderr.Is(err, coderr.InvalidParams))
+}
+
+func TestWithRouteTablesByID(t *testing.T) {
+	re := require.New(t)
+
+	desc := withRouteTablesByID(&metaservicepb.CeresmetaRpcService_ServiceDesc)
+	re.Equal(metaservicepb.CeresmetaRpcService_ServiceDesc.ServiceName, desc.ServiceName)
+	re.Len(desc.Methods, len(metaservicepb.CeresmetaRpcService_ServiceDesc.Methods)+1)
+	re.Equal(routeTablesByIDMethod, desc.Methods[len(desc.Methods)-1].MethodName)
+	re.Equal("/meta_service.CeresmetaRpcService/RouteTablesByID", routeTablesByIDFullMethod)
+}
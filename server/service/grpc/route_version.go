@@ -0,0 +1,117 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+// The route results can be cached by the clients and validated by the route version, which is described by the metadata
+// because the proto has no field for it:
+//   - The route version of the entries is sent in the response headers of RouteTables and RouteTablesByID.
+//   - A client attaches the route version of its cached entries to the request, and the server answers the response
+//     without any entry and with the not-modified header if the route version is unchanged, so the entries are not
+//     routed again. The cluster topology version of the response is still set.
+//
+// The route version changes whenever the topology in memory is updated, which is more than the cluster topology version
+// because the tables moved between the shards don't change the cluster view. It is only valid on the meta node that
+// answers it, so the route versions got from the former leader never match and the tables are just routed again.
+const (
+	routeVersionMetadataKey      = "x-horaemeta-route-version"
+	knownRouteVersionMetadataKey = "x-horaemeta-known-route-version"
+	routeNotModifiedMetadataKey  = "x-horaemeta-route-not-modified"
+	routeNotModified             = "true"
+)
+
+// getKnownRouteVersion returns the route version of the entries cached by the client, and empty if nothing is cached.
+func getKnownRouteVersion(ctx context.Context) string {
+	md, ok := grpcmetadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(knownRouteVersionMetadataKey)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// routeNotModifiedResponse returns the not-modified response if the route version known by the client is unchanged, and
+// the second output parameter bool returns false if the entries should be routed.
+func routeNotModifiedResponse(ctx context.Context, clusterMetadata *metadata.ClusterMetadata) (*metaservicepb.RouteTablesResponse, bool) {
+	knownVersion := getKnownRouteVersion(ctx)
+	if len(knownVersion) == 0 || knownVersion != clusterMetadata.GetRouteVersion() {
+		return nil, false
+	}
+
+	setRouteHeader(ctx, grpcmetadata.Pairs(routeVersionMetadataKey, knownVersion, routeNotModifiedMetadataKey, routeNotModified))
+	return &metaservicepb.RouteTablesResponse{
+		Header:                 okResponseHeader(),
+		ClusterTopologyVersion: clusterMetadata.GetClusterViewVersion(),
+		Entries:                map[string]*metaservicepb.RouteEntry{},
+	}, true
+}
+
+// leaderRouteNotModifiedResponse is routeNotModifiedResponse served by the leader with the metadata of the cluster.
+func (s *Service) leaderRouteNotModifiedResponse(ctx context.Context, clusterName string) (*metaservicepb.RouteTablesResponse, bool) {
+	if len(getKnownRouteVersion(ctx)) == 0 {
+		return nil, false
+	}
+	c, err := s.h.GetClusterManager().GetCluster(ctx, clusterName)
+	if err != nil {
+		return nil, false
+	}
+	return routeNotModifiedResponse(ctx, c.GetMetadata())
+}
+
+func setRouteVersionHeader(ctx context.Context, routeVersion string) {
+	setRouteHeader(ctx, grpcmetadata.Pairs(routeVersionMetadataKey, routeVersion))
+}
+
+func setRouteHeader(ctx context.Context, md grpcmetadata.MD) {
+	if err := grpc.SetHeader(ctx, md); err != nil {
+		log.Warn("set route response header failed", zap.Error(err))
+	}
+}
+
+// forwardRouteMetadata forwards the known route version of the request to the leader, and the header option returned
+// receives the route headers of the leader, which should be sent back by forwardRouteHeader.
+func forwardRouteMetadata(ctx context.Context, header *grpcmetadata.MD) (context.Context, grpc.CallOption) {
+	if knownVersion := getKnownRouteVersion(ctx); len(knownVersion) > 0 {
+		ctx = grpcmetadata.AppendToOutgoingContext(ctx, knownRouteVersionMetadataKey, knownVersion)
+	}
+	return ctx, grpc.Header(header)
+}
+
+func forwardRouteHeader(ctx context.Context, header grpcmetadata.MD) {
+	md := grpcmetadata.MD{}
+	for _, key := range []string{routeVersionMetadataKey, routeNotModifiedMetadataKey} {
+		if values := header.Get(key); len(values) > 0 {
+			md.Set(key, values...)
+		}
+	}
+	if len(md) > 0 {
+		setRouteHeader(ctx, md)
+	}
+}
@@ -0,0 +1,47 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/test"
+	"github.com/stretchr/testify/require"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+func TestRouteNotModifiedResponse(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	clusterMetadata := test.InitStableCluster(ctx, t).GetMetadata()
+	routeVersion := clusterMetadata.GetRouteVersion()
+
+	// The entries are routed if the client caches nothing or the cached ones are outdated.
+	_, notModified := routeNotModifiedResponse(ctx, clusterMetadata)
+	re.False(notModified)
+	outdatedCtx := grpcmetadata.NewIncomingContext(ctx, grpcmetadata.Pairs(knownRouteVersionMetadataKey, "0-0"))
+	_, notModified = routeNotModifiedResponse(outdatedCtx, clusterMetadata)
+	re.False(notModified)
+
+	knownCtx := grpcmetadata.NewIncomingContext(ctx, grpcmetadata.Pairs(knownRouteVersionMetadataKey, routeVersion))
+	resp, notModified := routeNotModifiedResponse(knownCtx, clusterMetadata)
+	re.True(notModified)
+	re.Empty(resp.GetEntries())
+	re.Equal(clusterMetadata.GetClusterViewVersion(), resp.GetClusterTopologyVersion())
+}
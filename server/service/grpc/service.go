@@ -20,12 +20,12 @@ import (
 	"context"
 	"fmt"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/CeresDB/horaedbproto/golang/pkg/clusterpb"
 	"github.com/CeresDB/horaedbproto/golang/pkg/commonpb"
 	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/clock"
 	"github.com/CeresDB/horaemeta/pkg/coderr"
 	"github.com/CeresDB/horaemeta/pkg/log"
 	"github.com/CeresDB/horaemeta/server/cluster"
@@ -33,9 +33,21 @@ import (
 	"github.com/CeresDB/horaemeta/server/coordinator"
 	"github.com/CeresDB/horaemeta/server/limiter"
 	"github.com/CeresDB/horaemeta/server/member"
+	"github.com/CeresDB/horaemeta/server/service"
+	"github.com/CeresDB/horaemeta/server/status"
 	"github.com/CeresDB/horaemeta/server/storage"
-	"github.com/pkg/errors"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	// Register the gzip compressor so that the nodes are able to compress the large heartbeats.
+	_ "google.golang.org/grpc/encoding/gzip"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+const (
+	// registrationTokenMetadataKey is the key of the registration token in the metadata of the heartbeat request.
+	registrationTokenMetadataKey = "x-horaemeta-registration-token"
+	// tenantTokenMetadataKey is the key of the tenant token restricting the request to the resources of the tenant.
+	tenantTokenMetadataKey = "x-horaemeta-tenant-token"
 )
 
 type Service struct {
@@ -43,17 +55,48 @@ type Service struct {
 	opTimeout time.Duration
 	h         Handler
 
-	// Store as map[string]*grpc.ClientConn
-	// TODO: remove unavailable connection
-	conns sync.Map
+	// conns keeps the connections to the leader for forwarding.
+	conns *service.ConnPool
+	// heartbeatVersions is used to apply the delta heartbeats.
+	heartbeatVersions        *heartbeatVersions
+	heartbeatIntervalAdvisor *heartbeatIntervalAdvisor
+	leaseBounds              LeaseBounds
+	ddlDeduplicator          *ddlDeduplicator
+	heartbeatForwarder       *heartbeatForwarder
+	// slowRequestThreshold is the latency above which the request is logged as a slow one, and zero disables it.
+	slowRequestThreshold time.Duration
+	// tenantTokenRequired rejects the table requests without the tenant token.
+	tenantTokenRequired bool
+}
+
+// ServiceOptions are the settings of the meta service.
+type ServiceOptions struct {
+	OpTimeout           time.Duration
+	HeartbeatRateBudget uint32
+	LeaseBounds         LeaseBounds
+	// SlowRequestThreshold is the latency above which the request is logged as a slow one, and zero disables it.
+	SlowRequestThreshold time.Duration
+	// ForwardConnOptions are the options of the connections to the leader for forwarding.
+	ForwardConnOptions service.ConnPoolOptions
+	// TenantTokenRequired rejects the table requests without the tenant token.
+	TenantTokenRequired bool
+	Clock               clock.Clock
 }
 
-func NewService(opTimeout time.Duration, h Handler) *Service {
+// NewService creates the meta service processing the requests by the handler.
+func NewService(opts ServiceOptions, h Handler) *Service {
 	return &Service{
 		UnimplementedCeresmetaRpcServiceServer: metaservicepb.UnimplementedCeresmetaRpcServiceServer{},
-		opTimeout:                              opTimeout,
+		opTimeout:                              opts.OpTimeout,
 		h:                                      h,
-		conns:                                  sync.Map{},
+		conns:                                  service.NewConnPool("metaForward", opts.ForwardConnOptions),
+		heartbeatVersions:                      newHeartbeatVersions(),
+		heartbeatIntervalAdvisor:               newHeartbeatIntervalAdvisor(opts.HeartbeatRateBudget, opts.Clock),
+		leaseBounds:                            opts.LeaseBounds,
+		ddlDeduplicator:                        newDDLDeduplicator(),
+		heartbeatForwarder:                     newHeartbeatForwarder(),
+		slowRequestThreshold:                   opts.SlowRequestThreshold,
+		tenantTokenRequired:                    opts.TenantTokenRequired,
 	}
 }
 
@@ -62,19 +105,27 @@ type Handler interface {
 	GetClusterManager() cluster.Manager
 	GetLeader(ctx context.Context) (member.GetLeaderAddrResp, error)
 	GetFlowLimiter() (*limiter.FlowLimiter, error)
+	GetServerStatus() *status.ServerStatus
 	// TODO: define the methods for handling other grpc requests.
 }
 
 // NodeHeartbeat implements gRPC HoraeMetaServer.
 func (s *Service) NodeHeartbeat(ctx context.Context, req *metaservicepb.NodeHeartbeatRequest) (*metaservicepb.NodeHeartbeatResponse, error) {
-	metaClient, err := s.getForwardedMetaClient(ctx)
-	if err != nil {
-		return &metaservicepb.NodeHeartbeatResponse{Header: responseHeader(err, "grpc heartbeat")}, nil
+	// Forward request to the leader, and the registration token and the report version carried by the metadata are
+	// forwarded too, as well as the response headers of the leader.
+	resp, forwarded, err := s.forwardHeartbeat(ctx, req)
+	if forwarded {
+		return resp, err
 	}
 
-	// Forward request to the leader.
-	if metaClient != nil {
-		return metaClient.NodeHeartbeat(ctx, req)
+	if err := s.h.GetClusterManager().VerifyRegistrationToken(ctx, req.GetHeader().GetClusterName(), getRegistrationToken(ctx)); err != nil {
+		log.Warn("reject heartbeat with invalid registration token", zap.String("clusterName", req.GetHeader().GetClusterName()), zap.String("name", req.GetInfo().GetEndpoint()), zap.Error(err))
+		return &metaservicepb.NodeHeartbeatResponse{Header: responseHeader(err, "grpc heartbeat, invalid registration token")}, nil
+	}
+
+	c, err := s.h.GetClusterManager().GetCluster(ctx, req.GetHeader().GetClusterName())
+	if err != nil {
+		return &metaservicepb.NodeHeartbeatResponse{Header: responseHeader(err, "grpc heartbeat")}, nil
 	}
 
 	shardInfos := make([]metadata.ShardInfo, 0, len(req.Info.ShardInfos))
@@ -82,26 +133,83 @@ func (s *Service) NodeHeartbeat(ctx context.Context, req *metaservicepb.NodeHear
 		shardInfos = append(shardInfos, metadata.ConvertShardsInfoPB(shardInfo))
 	}
 
+	clusterName := req.GetHeader().GetClusterName()
+	report, err := parseHeartbeatReport(ctx)
+	if err != nil {
+		return &metaservicepb.NodeHeartbeatResponse{Header: responseHeader(err, "grpc heartbeat, parse report")}, nil
+	}
+	shardCommandReport, err := parseShardCommandReport(ctx)
+	if err != nil {
+		return &metaservicepb.NodeHeartbeatResponse{Header: responseHeader(err, "grpc heartbeat, parse shard command acks")}, nil
+	}
+	capacity, err := parseNodeCapacity(ctx)
+	if err != nil {
+		return &metaservicepb.NodeHeartbeatResponse{Header: responseHeader(err, "grpc heartbeat, parse node capacity")}, nil
+	}
+	protocolVersion, err := parseNodeProtocolVersion(ctx)
+	if err != nil {
+		return &metaservicepb.NodeHeartbeatResponse{Header: responseHeader(err, "grpc heartbeat, parse node protocol version")}, nil
+	}
+	statsReport, statsReported, err := parseStatsReport(ctx)
+	if err != nil {
+		return &metaservicepb.NodeHeartbeatResponse{Header: responseHeader(err, "grpc heartbeat, parse table stats")}, nil
+	}
+	warnIncompatibleNode(clusterName, req.Info.Endpoint, req.GetInfo().BinaryVersion, protocolVersion)
+	if report.isDelta {
+		ackedVersion, acked := s.heartbeatVersions.get(clusterName, req.Info.Endpoint)
+		baseNode, exists := c.GetMetadata().GetRegisteredNodeByName(req.Info.Endpoint)
+		if !acked || ackedVersion != report.baseVersion || !exists {
+			log.Info("delta heartbeat falls back to full report", zap.String("clusterName", clusterName), zap.String("name", req.Info.Endpoint), zap.Uint64("baseVersion", report.baseVersion), zap.Uint64("ackedVersion", ackedVersion))
+			s.setHeartbeatResponseHeader(ctx, heartbeatResponseMetadata(ackedVersion, acked))
+			return &metaservicepb.NodeHeartbeatResponse{Header: responseHeader(ErrFullReportRequired.WithCausef("baseVersion:%d, ackedVersion:%d", report.baseVersion, ackedVersion), "grpc heartbeat, full report required")}, nil
+		}
+		shardInfos = mergeShardInfos(baseNode.ShardInfos, shardInfos, report.removedShardIDs)
+	}
+
+	advisedIntervalMs, advised := s.heartbeatIntervalAdvisor.advise(ctx, s.h.GetClusterManager(), c)
 	registeredNode := metadata.RegisteredNode{
 		Node: storage.Node{
 			Name: req.Info.Endpoint,
 			NodeStats: storage.NodeStats{
-				Lease:       req.GetInfo().Lease,
-				Zone:        req.GetInfo().Zone,
-				NodeVersion: req.GetInfo().BinaryVersion,
+				Lease:           req.GetInfo().Lease,
+				Zone:            req.GetInfo().Zone,
+				NodeVersion:     req.GetInfo().BinaryVersion,
+				Capacity:        capacity,
+				ProtocolVersion: protocolVersion,
 			},
-			LastTouchTime: uint64(time.Now().UnixMilli()),
+			LastTouchTime: clock.UnixMilli(c.GetMetadata().Clock()),
 			State:         storage.NodeStateOnline,
 		}, ShardInfos: shardInfos,
+		Heartbeat: negotiateHeartbeat(s.leaseBounds, req.GetInfo().Lease, advisedIntervalMs, advised),
 	}
 
 	log.Info("[NodeHeartbeat]", zap.String("clusterName", req.GetHeader().ClusterName), zap.String("name", req.Info.Endpoint), zap.String("info", fmt.Sprintf("%+v", registeredNode)))
 
-	err = s.h.GetClusterManager().RegisterNode(ctx, req.GetHeader().GetClusterName(), registeredNode)
+	err = s.h.GetClusterManager().RegisterNode(ctx, clusterName, registeredNode)
 	if err != nil {
 		return &metaservicepb.NodeHeartbeatResponse{Header: responseHeader(err, "grpc heartbeat")}, nil
 	}
 
+	if statsReported {
+		c.GetTableStats().Report(req.Info.Endpoint, statsReport)
+	}
+	if report.versioned {
+		s.heartbeatVersions.ack(clusterName, req.Info.Endpoint, report.version)
+	} else {
+		s.heartbeatVersions.remove(clusterName, req.Info.Endpoint)
+	}
+	// The acks are applied before the mode, so the commands acked by the node opting out are not aborted.
+	piggybackDispatch := c.GetPiggybackDispatch()
+	piggybackDispatch.Ack(req.Info.Endpoint, shardCommandReport.acks)
+	piggybackDispatch.SetPiggyback(req.Info.Endpoint, shardCommandReport.piggyback)
+
+	md := heartbeatResponseMetadata(report.version, report.versioned)
+	setHeartbeatTimingMetadata(md, registeredNode.Heartbeat)
+	if err := setShardCommandsMetadata(md, piggybackDispatch.PendingCommands(req.Info.Endpoint)); err != nil {
+		log.Warn("set shard commands of heartbeat response failed", zap.String("name", req.Info.Endpoint), zap.Error(err))
+	}
+	s.setHeartbeatResponseHeader(ctx, md)
+
 	return &metaservicepb.NodeHeartbeatResponse{
 		Header: okResponseHeader(),
 	}, nil
@@ -135,6 +243,15 @@ func (s *Service) AllocSchemaID(ctx context.Context, req *metaservicepb.AllocSch
 
 // GetTablesOfShards implements gRPC HoraeMetaServer.
 func (s *Service) GetTablesOfShards(ctx context.Context, req *metaservicepb.GetTablesOfShardsRequest) (*metaservicepb.GetTablesOfShardsResponse, error) {
+	// Serve the request locally without forwarding if it allows the stale read.
+	if clusterMetadata, ok := s.getStaleReadMetadata(ctx, req.GetHeader().GetClusterName()); ok {
+		shardIDs := make([]storage.ShardID, 0, len(req.GetShardIds()))
+		for _, shardID := range req.GetShardIds() {
+			shardIDs = append(shardIDs, storage.ShardID(shardID))
+		}
+		return convertToGetTablesOfShardsResponse(clusterMetadata.GetShardTables(shardIDs)), nil
+	}
+
 	metaClient, err := s.getForwardedMetaClient(ctx)
 	if err != nil {
 		return &metaservicepb.GetTablesOfShardsResponse{Header: responseHeader(err, "grpc get tables of shards")}, nil
@@ -164,9 +281,8 @@ func (s *Service) GetTablesOfShards(ctx context.Context, req *metaservicepb.GetT
 // CreateTable implements gRPC HoraeMetaServer.
 func (s *Service) CreateTable(ctx context.Context, req *metaservicepb.CreateTableRequest) (*metaservicepb.CreateTableResponse, error) {
 	start := time.Now()
-	// Since there may be too many table creation requests, a flow limiter is added here.
-	if ok, err := s.allow(); !ok {
-		return &metaservicepb.CreateTableResponse{Header: responseHeader(err, "create table grpc request is rejected by flow limiter")}, nil
+	if err := s.authorizeTenant(ctx, req.GetHeader().GetClusterName(), req.GetSchemaName()); err != nil {
+		return &metaservicepb.CreateTableResponse{Header: responseHeader(err, "create table")}, nil
 	}
 
 	metaClient, err := s.getForwardedMetaClient(ctx)
@@ -176,16 +292,53 @@ func (s *Service) CreateTable(ctx context.Context, req *metaservicepb.CreateTabl
 
 	// Forward request to the leader.
 	if metaClient != nil {
-		return metaClient.CreateTable(ctx, req)
+		return metaClient.CreateTable(forwardTenantToken(ctx), req)
 	}
 
 	log.Info("[CreateTable]", zap.String("schemaName", req.SchemaName), zap.String("clusterName", req.GetHeader().ClusterName), zap.String("tableName", req.GetName()))
 
+	clusterName := req.GetHeader().GetClusterName()
+	key := newDDLKey(ddlOpCreateTable, clusterName, req.GetSchemaName(), req.GetName(), s.nameNormalizer(ctx, clusterName))
+	resp, shared, err := deduplicateDDL(ctx, s.ddlDeduplicator, key, func(ctx context.Context) *metaservicepb.CreateTableResponse {
+		return s.createTable(ctx, req, start)
+	})
+	if err != nil {
+		return &metaservicepb.CreateTableResponse{Header: responseHeader(err, "create table")}, nil
+	}
+	if shared {
+		log.Info("create table request is coalesced with the identical one in flight", zap.String("schemaName", req.GetSchemaName()), zap.String("tableName", req.GetName()))
+	}
+	return resp, nil
+}
+
+// nameNormalizer returns how the cluster normalizes the schema and table names, and the names are kept as they are if
+// the cluster isn't found, in which case the request fails anyway.
+func (s *Service) nameNormalizer(ctx context.Context, clusterName string) func(string) string {
+	c, err := s.h.GetClusterManager().GetCluster(ctx, clusterName)
+	if err != nil {
+		return func(name string) string { return name }
+	}
+	return c.GetMetadata().NormalizeName
+}
+
+func (s *Service) createTable(ctx context.Context, req *metaservicepb.CreateTableRequest, start time.Time) *metaservicepb.CreateTableResponse {
 	clusterManager := s.h.GetClusterManager()
 	c, err := clusterManager.GetCluster(ctx, req.GetHeader().GetClusterName())
 	if err != nil {
 		log.Error("fail to create table", zap.Error(err))
-		return &metaservicepb.CreateTableResponse{Header: responseHeader(err, "create table")}, nil
+		return &metaservicepb.CreateTableResponse{Header: responseHeader(err, "create table")}
+	}
+
+	req, targetShardID, err := extractTargetShardID(req)
+	if err != nil {
+		log.Error("fail to create table", zap.Error(err))
+		return &metaservicepb.CreateTableResponse{Header: responseHeader(err, "create table")}
+	}
+
+	// The unknown schema is created before the table unless it is rejected by the schema creation policy.
+	if _, _, err := c.GetMetadata().GetOrCreateSchemaByPolicy(ctx, req.GetSchemaName()); err != nil {
+		log.Error("fail to create table, get or create schema", zap.Error(err), zap.String("schemaName", req.GetSchemaName()))
+		return &metaservicepb.CreateTableResponse{Header: responseHeader(err, "create table")}
 	}
 
 	errorCh := make(chan error, 1)
@@ -203,18 +356,19 @@ func (s *Service) CreateTable(ctx context.Context, req *metaservicepb.CreateTabl
 	p, err := c.GetProcedureFactory().MakeCreateTableProcedure(ctx, coordinator.CreateTableRequest{
 		ClusterMetadata: c.GetMetadata(),
 		SourceReq:       req,
+		TargetShardID:   targetShardID,
 		OnSucceeded:     onSucceeded,
 		OnFailed:        onFailed,
 	})
 	if err != nil {
 		log.Error("fail to create table, factory create procedure", zap.Error(err))
-		return &metaservicepb.CreateTableResponse{Header: responseHeader(err, "create table")}, nil
+		return &metaservicepb.CreateTableResponse{Header: responseHeader(err, fmt.Sprintf("create table, %s", err.Error()))}
 	}
 
 	err = c.GetProcedureManager().Submit(ctx, p)
 	if err != nil {
 		log.Error("fail to create table, manager submit procedure", zap.Error(err))
-		return &metaservicepb.CreateTableResponse{Header: responseHeader(err, "create table")}, nil
+		return &metaservicepb.CreateTableResponse{Header: responseHeader(err, "create table")}
 	}
 
 	select {
@@ -233,19 +387,18 @@ func (s *Service) CreateTable(ctx context.Context, req *metaservicepb.CreateTabl
 				Role:    clusterpb.ShardRole_LEADER,
 				Version: ret.ShardVersionUpdate.LatestVersion,
 			},
-		}, nil
+		}
 	case err = <-errorCh:
 		log.Warn("create table failed", zap.String("tableName", req.Name), zap.Int64("costTime", time.Since(start).Milliseconds()), zap.Error(err))
-		return &metaservicepb.CreateTableResponse{Header: responseHeader(err, "create table")}, nil
+		return &metaservicepb.CreateTableResponse{Header: responseHeader(err, "create table")}
 	}
 }
 
 // DropTable implements gRPC HoraeMetaServer.
 func (s *Service) DropTable(ctx context.Context, req *metaservicepb.DropTableRequest) (*metaservicepb.DropTableResponse, error) {
 	start := time.Now()
-	// Since there may be too many table dropping requests, a flow limiter is added here.
-	if ok, err := s.allow(); !ok {
-		return &metaservicepb.DropTableResponse{Header: responseHeader(err, "drop table grpc request is rejected by flow limiter")}, nil
+	if err := s.authorizeTenant(ctx, req.GetHeader().GetClusterName(), req.GetSchemaName()); err != nil {
+		return &metaservicepb.DropTableResponse{Header: responseHeader(err, "drop table")}, nil
 	}
 
 	metaClient, err := s.getForwardedMetaClient(ctx)
@@ -255,16 +408,31 @@ func (s *Service) DropTable(ctx context.Context, req *metaservicepb.DropTableReq
 
 	// Forward request to the leader.
 	if metaClient != nil {
-		return metaClient.DropTable(ctx, req)
+		return metaClient.DropTable(forwardTenantToken(ctx), req)
 	}
 
 	log.Info("[DropTable]", zap.String("schemaName", req.SchemaName), zap.String("clusterName", req.GetHeader().ClusterName), zap.String("tableName", req.Name))
 
+	clusterName := req.GetHeader().GetClusterName()
+	key := newDDLKey(ddlOpDropTable, clusterName, req.GetSchemaName(), req.GetName(), s.nameNormalizer(ctx, clusterName))
+	resp, shared, err := deduplicateDDL(ctx, s.ddlDeduplicator, key, func(ctx context.Context) *metaservicepb.DropTableResponse {
+		return s.dropTable(ctx, req, start)
+	})
+	if err != nil {
+		return &metaservicepb.DropTableResponse{Header: responseHeader(err, "drop table")}, nil
+	}
+	if shared {
+		log.Info("drop table request is coalesced with the identical one in flight", zap.String("schemaName", req.GetSchemaName()), zap.String("tableName", req.GetName()))
+	}
+	return resp, nil
+}
+
+func (s *Service) dropTable(ctx context.Context, req *metaservicepb.DropTableRequest, start time.Time) *metaservicepb.DropTableResponse {
 	clusterManager := s.h.GetClusterManager()
 	c, err := clusterManager.GetCluster(ctx, req.GetHeader().GetClusterName())
 	if err != nil {
 		log.Error("fail to drop table", zap.Error(err))
-		return &metaservicepb.DropTableResponse{Header: responseHeader(err, "drop table")}, nil
+		return &metaservicepb.DropTableResponse{Header: responseHeader(err, "drop table")}
 	}
 
 	errorCh := make(chan error, 1)
@@ -287,17 +455,17 @@ func (s *Service) DropTable(ctx context.Context, req *metaservicepb.DropTableReq
 	})
 	if err != nil {
 		log.Error("fail to drop table", zap.Error(err))
-		return &metaservicepb.DropTableResponse{Header: responseHeader(err, "drop table")}, nil
+		return &metaservicepb.DropTableResponse{Header: responseHeader(err, fmt.Sprintf("drop table, %s", err.Error()))}
 	}
 	if !ok {
 		log.Warn("table may have been dropped already")
-		return &metaservicepb.DropTableResponse{Header: okResponseHeader()}, nil
+		return &metaservicepb.DropTableResponse{Header: okResponseHeader()}
 	}
 
 	err = c.GetProcedureManager().Submit(ctx, procedure)
 	if err != nil {
 		log.Error("fail to drop table, manager submit procedure", zap.Error(err), zap.Int64("costTime", time.Since(start).Milliseconds()))
-		return &metaservicepb.DropTableResponse{Header: responseHeader(err, "drop table")}, nil
+		return &metaservicepb.DropTableResponse{Header: responseHeader(err, "drop table")}
 	}
 
 	select {
@@ -306,18 +474,30 @@ func (s *Service) DropTable(ctx context.Context, req *metaservicepb.DropTableReq
 		return &metaservicepb.DropTableResponse{
 			Header:       okResponseHeader(),
 			DroppedTable: metadata.ConvertTableInfoToPB(ret),
-		}, nil
+		}
 	case err = <-errorCh:
 		log.Info("drop table failed", zap.String("tableName", req.Name), zap.Int64("costTime", time.Since(start).Milliseconds()))
-		return &metaservicepb.DropTableResponse{Header: responseHeader(err, "drop table")}, nil
+		return &metaservicepb.DropTableResponse{Header: responseHeader(err, "drop table")}
 	}
 }
 
 // RouteTables implements gRPC HoraeMetaServer.
 func (s *Service) RouteTables(ctx context.Context, req *metaservicepb.RouteTablesRequest) (*metaservicepb.RouteTablesResponse, error) {
-	// Since there may be too many table routing requests, a flow limiter is added here.
-	if ok, err := s.allow(); !ok {
-		return &metaservicepb.RouteTablesResponse{Header: responseHeader(err, "routeTables grpc request is rejected by flow limiter")}, nil
+	if err := s.authorizeTenant(ctx, req.GetHeader().GetClusterName(), req.GetSchemaName()); err != nil {
+		return &metaservicepb.RouteTablesResponse{Header: responseHeader(err, "grpc routeTables")}, nil
+	}
+
+	// Serve the request locally without forwarding if it allows the stale read.
+	if clusterMetadata, ok := s.getStaleReadMetadata(ctx, req.GetHeader().GetClusterName()); ok {
+		if resp, notModified := routeNotModifiedResponse(ctx, clusterMetadata); notModified {
+			return resp, nil
+		}
+		routeTableResult, err := clusterMetadata.RouteTables(ctx, req.GetSchemaName(), req.GetTableNames())
+		if err != nil {
+			return &metaservicepb.RouteTablesResponse{Header: responseHeader(err, "grpc stale routeTables")}, nil
+		}
+		setRouteVersionHeader(ctx, routeTableResult.RouteVersion)
+		return convertRouteTableResult(routeTableResult), nil
 	}
 
 	metaClient, err := s.getForwardedMetaClient(ctx)
@@ -327,29 +507,56 @@ func (s *Service) RouteTables(ctx context.Context, req *metaservicepb.RouteTable
 
 	log.Debug("[RouteTable]", zap.String("schemaName", req.SchemaName), zap.String("clusterName", req.GetHeader().ClusterName), zap.String("tableNames", strings.Join(req.TableNames, ",")))
 
-	// Forward request to the leader.
+	// Forward request to the leader, as well as the route version known by the client and the route headers of the leader.
 	if metaClient != nil {
-		return metaClient.RouteTables(ctx, req)
+		var header grpcmetadata.MD
+		forwardedCtx, headerOption := forwardRouteMetadata(forwardTenantToken(ctx), &header)
+		resp, err := metaClient.RouteTables(forwardedCtx, req, headerOption)
+		if err == nil {
+			forwardRouteHeader(ctx, header)
+		}
+		return resp, err
 	}
 
+	if resp, notModified := s.leaderRouteNotModifiedResponse(ctx, req.GetHeader().GetClusterName()); notModified {
+		return resp, nil
+	}
 	routeTableResult, err := s.h.GetClusterManager().RouteTables(ctx, req.GetHeader().GetClusterName(), req.GetSchemaName(), req.GetTableNames())
 	if err != nil {
 		return &metaservicepb.RouteTablesResponse{Header: responseHeader(err, "grpc routeTables")}, nil
 	}
 
+	setRouteVersionHeader(ctx, routeTableResult.RouteVersion)
 	return convertRouteTableResult(routeTableResult), nil
 }
 
 // GetNodes implements gRPC HoraeMetaServer.
 func (s *Service) GetNodes(ctx context.Context, req *metaservicepb.GetNodesRequest) (*metaservicepb.GetNodesResponse, error) {
+	// Serve the request locally without forwarding if it allows the stale read.
+	if clusterMetadata, ok := s.getStaleReadMetadata(ctx, req.GetHeader().GetClusterName()); ok {
+		nodesResult, err := clusterMetadata.GetNodeShards(ctx)
+		if err != nil {
+			return &metaservicepb.GetNodesResponse{Header: responseHeader(err, "grpc stale get nodes")}, nil
+		}
+		setNodeStatusHeader(ctx, clusterMetadata)
+		return convertToGetNodesResponse(nodesResult), nil
+	}
+
 	metaClient, err := s.getForwardedMetaClient(ctx)
 	if err != nil {
 		return &metaservicepb.GetNodesResponse{Header: responseHeader(err, "grpc get nodes")}, nil
 	}
 
-	// Forward request to the leader.
+	// Forward request to the leader, as well as the node status in the response headers of the leader.
 	if metaClient != nil {
-		return metaClient.GetNodes(ctx, req)
+		var header grpcmetadata.MD
+		resp, err := metaClient.GetNodes(ctx, req, grpc.Header(&header))
+		if err == nil && len(header.Get(nodeStatusMetadataKey)) > 0 {
+			if err := grpc.SetHeader(ctx, grpcmetadata.MD{nodeStatusMetadataKey: header.Get(nodeStatusMetadataKey)}); err != nil {
+				log.Warn("set node status response header failed", zap.Error(err))
+			}
+		}
+		return resp, err
 	}
 
 	log.Info("[GetNodes]", zap.String("clusterName", req.GetHeader().ClusterName))
@@ -359,6 +566,9 @@ func (s *Service) GetNodes(ctx context.Context, req *metaservicepb.GetNodesReque
 		log.Error("fail to get nodes", zap.Error(err))
 		return &metaservicepb.GetNodesResponse{Header: responseHeader(err, "grpc get nodes")}, nil
 	}
+	if c, err := s.h.GetClusterManager().GetCluster(ctx, req.GetHeader().GetClusterName()); err == nil {
+		setNodeStatusHeader(ctx, c.GetMetadata())
+	}
 
 	return convertToGetNodesResponse(nodesResult), nil
 }
@@ -426,10 +636,72 @@ func convertToGetNodesResponse(nodesResult metadata.GetNodeShardsResult) *metase
 	}
 }
 
+// setHeartbeatResponseHeader sends the metadata describing the heartbeat, e.g. the acked report version, in the response headers.
+func (s *Service) setHeartbeatResponseHeader(ctx context.Context, md grpcmetadata.MD) {
+	if err := grpc.SetHeader(ctx, md); err != nil {
+		log.Warn("set heartbeat response header failed", zap.Error(err))
+	}
+}
+
 func okResponseHeader() *commonpb.ResponseHeader {
 	return responseHeader(nil, "")
 }
 
+// getRegistrationToken returns the registration token presented by the node in the request metadata.
+func getRegistrationToken(ctx context.Context) string {
+	md, ok := grpcmetadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(registrationTokenMetadataKey)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// getTenantToken returns the tenant token presented in the request metadata.
+func getTenantToken(ctx context.Context) string {
+	md, ok := grpcmetadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	values := md.Get(tenantTokenMetadataKey)
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// forwardTenantToken forwards the tenant token of the request to the leader, which authorizes the request again.
+func forwardTenantToken(ctx context.Context) context.Context {
+	if token := getTenantToken(ctx); len(token) > 0 {
+		return grpcmetadata.AppendToOutgoingContext(ctx, tenantTokenMetadataKey, token)
+	}
+	return ctx
+}
+
+// authorizeTenant checks the schema is owned by the tenant if the request carries a tenant token, and the requests
+// without the token are allowed as usual unless the token is required.
+func (s *Service) authorizeTenant(ctx context.Context, clusterName, schemaName string) error {
+	token := getTenantToken(ctx)
+	if len(token) == 0 {
+		if s.tenantTokenRequired {
+			return metadata.ErrTenantTokenRequired.WithCausef("cluster:%s, schema:%s", clusterName, schemaName)
+		}
+		return nil
+	}
+
+	tenant, err := s.h.GetClusterManager().AuthenticateTenant(ctx, token)
+	if err != nil {
+		return err
+	}
+	if !tenant.CanAccess(clusterName, schemaName) {
+		return metadata.ErrTenantAccessDenied.WithCausef("tenant:%s, cluster:%s, schema:%s", tenant.Name, clusterName, schemaName)
+	}
+	return nil
+}
+
 func responseHeader(err error, msg string) *commonpb.ResponseHeader {
 	if err == nil {
 		return &commonpb.ResponseHeader{Code: coderr.Ok, Error: msg}
@@ -442,14 +714,3 @@ func responseHeader(err error, msg string) *commonpb.ResponseHeader {
 
 	return &commonpb.ResponseHeader{Code: coderr.Internal, Error: msg}
 }
-
-func (s *Service) allow() (bool, error) {
-	flowLimiter, err := s.h.GetFlowLimiter()
-	if err != nil {
-		return false, errors.WithMessage(err, "get flow limiter failed")
-	}
-	if !flowLimiter.Allow() {
-		return false, ErrFlowLimit.WithCausef("the current flow has reached the threshold")
-	}
-	return true, nil
-}
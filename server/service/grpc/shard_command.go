@@ -0,0 +1,83 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/pkg/errors"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+// The shard commands are exchanged by the metadata of the heartbeats for the nodes the leader can't dial:
+//   - The server advertises the piggyback capability in the response headers of every heartbeat.
+//   - A node opting in sets the piggyback mode in every heartbeat, and then its shards are opened and closed by the
+//     commands in the response headers, which are sent again by every heartbeat until they are acked.
+//   - The node acks the applied commands in the next heartbeat, and a heartbeat without the mode opts the node out.
+const (
+	heartbeatPiggybackCapability = "piggyback"
+	shardCommandModeMetadataKey  = "x-horaemeta-shard-command-mode"
+	shardCommandModePiggyback    = "piggyback"
+	shardCommandsMetadataKey     = "x-horaemeta-shard-commands"
+	shardCommandAcksMetadataKey  = "x-horaemeta-shard-command-acks"
+)
+
+type shardCommandReport struct {
+	piggyback bool
+	acks      []eventdispatch.ShardCommandAck
+}
+
+// parseShardCommandReport parses the mode and the acks of the shard commands in the metadata of the heartbeat, and each
+// value of the acks is a json array.
+func parseShardCommandReport(ctx context.Context) (shardCommandReport, error) {
+	report := shardCommandReport{
+		piggyback: false,
+		acks:      nil,
+	}
+	md, ok := grpcmetadata.FromIncomingContext(ctx)
+	if !ok {
+		return report, nil
+	}
+
+	modes := md.Get(shardCommandModeMetadataKey)
+	report.piggyback = len(modes) > 0 && modes[0] == shardCommandModePiggyback
+	for _, value := range md.Get(shardCommandAcksMetadataKey) {
+		var acks []eventdispatch.ShardCommandAck
+		if err := json.Unmarshal([]byte(value), &acks); err != nil {
+			return report, ErrInvalidShardCommand.WithCausef("parse acks:%s, err:%v", value, err)
+		}
+		report.acks = append(report.acks, acks...)
+	}
+	return report, nil
+}
+
+// setShardCommandsMetadata advertises the piggyback capability, and sends the pending commands if there are any.
+func setShardCommandsMetadata(md grpcmetadata.MD, commands []eventdispatch.ShardCommand) error {
+	md.Append(heartbeatCapabilitiesMetadataKey, heartbeatPiggybackCapability)
+	if len(commands) == 0 {
+		return nil
+	}
+
+	value, err := json.Marshal(commands)
+	if err != nil {
+		return errors.WithMessage(err, "encode shard commands")
+	}
+	md.Set(shardCommandsMetadataKey, string(value))
+	return nil
+}
@@ -0,0 +1,58 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"maps"
+	"strconv"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/server/storage"
+)
+
+// targetShardOption is the table option for the clients managing the data locality themselves to place the table on
+// the shard, and it is removed from the options before the table is created.
+const targetShardOption = "horaemeta.target_shard_id"
+
+// extractTargetShardID returns the target shard in the options of the request, and the request with the option removed.
+// The request is returned as it is if no target shard is specified.
+func extractTargetShardID(req *metaservicepb.CreateTableRequest) (*metaservicepb.CreateTableRequest, *storage.ShardID, error) {
+	value, ok := req.GetOptions()[targetShardOption]
+	if !ok {
+		return req, nil, nil
+	}
+
+	id, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return nil, nil, ErrInvalidTargetShard.WithCausef("option:%s, value:%s, err:%v", targetShardOption, value, err)
+	}
+	shardID := storage.ShardID(id)
+
+	// The request may be shared by the coalesced requests, so it is copied rather than modified.
+	options := maps.Clone(req.GetOptions())
+	delete(options, targetShardOption)
+	return &metaservicepb.CreateTableRequest{
+		Header:             req.GetHeader(),
+		SchemaName:         req.GetSchemaName(),
+		Name:               req.GetName(),
+		EncodedSchema:      req.GetEncodedSchema(),
+		Engine:             req.GetEngine(),
+		CreateIfNotExist:   req.GetCreateIfNotExist(),
+		Options:            options,
+		PartitionTableInfo: req.GetPartitionTableInfo(),
+	}, &shardID, nil
+}
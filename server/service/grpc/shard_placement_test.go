@@ -0,0 +1,60 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"testing"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtractTargetShardID(t *testing.T) {
+	re := require.New(t)
+
+	makeRequest := func(options map[string]string) *metaservicepb.CreateTableRequest {
+		return &metaservicepb.CreateTableRequest{
+			Header:             nil,
+			SchemaName:         "public",
+			Name:               "test",
+			EncodedSchema:      nil,
+			Engine:             "",
+			CreateIfNotExist:   false,
+			Options:            options,
+			PartitionTableInfo: nil,
+		}
+	}
+
+	req := makeRequest(map[string]string{"ttl": "7d"})
+	extracted, shardID, err := extractTargetShardID(req)
+	re.NoError(err)
+	re.Nil(shardID)
+	re.Same(req, extracted)
+
+	req = makeRequest(map[string]string{"ttl": "7d", targetShardOption: "3"})
+	extracted, shardID, err = extractTargetShardID(req)
+	re.NoError(err)
+	re.Equal(storage.ShardID(3), *shardID)
+	re.Equal(map[string]string{"ttl": "7d"}, extracted.GetOptions())
+	// The original request is left untouched.
+	re.Contains(req.GetOptions(), targetShardOption)
+
+	_, _, err = extractTargetShardID(makeRequest(map[string]string{targetShardOption: "shard0"}))
+	re.True(coderr.Is(err, ErrInvalidTargetShard.Code()))
+}
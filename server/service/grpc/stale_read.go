@@ -0,0 +1,60 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"go.uber.org/zap"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+// staleReadMetadataKey is the key in the request metadata to allow the read being served by the node which is not the
+// leader, and the result may lag behind the leader.
+const staleReadMetadataKey = "x-horaemeta-stale-read"
+
+func isStaleReadAllowed(ctx context.Context) bool {
+	md, ok := grpcmetadata.FromIncomingContext(ctx)
+	if !ok {
+		return false
+	}
+	values := md.Get(staleReadMetadataKey)
+	if len(values) == 0 {
+		return false
+	}
+	allowed, err := strconv.ParseBool(values[0])
+	return err == nil && allowed
+}
+
+// getStaleReadMetadata returns the local metadata of the cluster to serve the read, the second output parameter bool:
+// returns true if the read allows the stale result and the metadata is available on this node. Otherwise, the read should
+// be forwarded to the leader as usual.
+func (s *Service) getStaleReadMetadata(ctx context.Context, clusterName string) (*metadata.ClusterMetadata, bool) {
+	if !isStaleReadAllowed(ctx) {
+		return nil, false
+	}
+
+	clusterMetadata, err := s.h.GetClusterManager().GetMetadataForStaleRead(clusterName)
+	if err != nil {
+		log.Debug("stale read is not available", zap.String("clusterName", clusterName), zap.Error(err))
+		return nil, false
+	}
+	return clusterMetadata, true
+}
@@ -0,0 +1,36 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+func TestIsStaleReadAllowed(t *testing.T) {
+	re := require.New(t)
+
+	re.False(isStaleReadAllowed(context.Background()))
+
+	for value, allowed := range map[string]bool{"true": true, "1": true, "false": false, "yes": false} {
+		ctx := grpcmetadata.NewIncomingContext(context.Background(), grpcmetadata.Pairs(staleReadMetadataKey, value))
+		re.Equal(allowed, isStaleReadAllowed(ctx), "value:%s", value)
+	}
+}
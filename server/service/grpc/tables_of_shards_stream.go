@@ -0,0 +1,180 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+)
+
+// The unary GetTablesOfShards may exceed the message limits for the shards with lots of tables, so the server-streaming
+// GetTablesOfShardsStream is registered to the meta service in addition to the generated methods. It reuses the messages
+// of GetTablesOfShards: every response is a batch of the tables of one shard along with the info and the version of the
+// shard, and a shard without tables is sent in one empty batch. All the batches are taken from the same view of the
+// shards, and the error is sent in the header of the only response.
+const (
+	getTablesOfShardsStreamMethod = "GetTablesOfShardsStream"
+	// tablesOfShardsBatchSize is the max number of the tables in a response of the stream.
+	tablesOfShardsBatchSize = 4096
+)
+
+var (
+	getTablesOfShardsStreamFullMethod = "/" + metaservicepb.CeresmetaRpcService_ServiceDesc.ServiceName + "/" + getTablesOfShardsStreamMethod
+	getTablesOfShardsStreamDesc       = grpc.StreamDesc{
+		StreamName:    getTablesOfShardsStreamMethod,
+		Handler:       getTablesOfShardsStreamHandler,
+		ServerStreams: true,
+		ClientStreams: false,
+	}
+)
+
+// withGetTablesOfShardsStream returns the description of the meta service with the GetTablesOfShardsStream method added.
+func withGetTablesOfShardsStream(desc *grpc.ServiceDesc) *grpc.ServiceDesc {
+	streams := make([]grpc.StreamDesc, 0, len(desc.Streams)+1)
+	streams = append(streams, desc.Streams...)
+	streams = append(streams, getTablesOfShardsStreamDesc)
+
+	return &grpc.ServiceDesc{
+		ServiceName: desc.ServiceName,
+		HandlerType: desc.HandlerType,
+		Methods:     desc.Methods,
+		Streams:     streams,
+		Metadata:    desc.Metadata,
+	}
+}
+
+func getTablesOfShardsStreamHandler(srv any, stream grpc.ServerStream) error {
+	in := new(metaservicepb.GetTablesOfShardsRequest)
+	if err := stream.RecvMsg(in); err != nil {
+		return err
+	}
+	return srv.(*Service).GetTablesOfShardsStream(in, stream)
+}
+
+// GetTablesOfShardsStream sends the tables of the shards in batches.
+func (s *Service) GetTablesOfShardsStream(req *metaservicepb.GetTablesOfShardsRequest, stream grpc.ServerStream) error {
+	ctx := stream.Context()
+	shardIDs := make([]storage.ShardID, 0, len(req.GetShardIds()))
+	for _, shardID := range req.GetShardIds() {
+		shardIDs = append(shardIDs, storage.ShardID(shardID))
+	}
+
+	// Serve the request locally without forwarding if it allows the stale read.
+	if clusterMetadata, ok := s.getStaleReadMetadata(ctx, req.GetHeader().GetClusterName()); ok {
+		return sendTablesOfShards(clusterMetadata.GetShardTables(shardIDs), tablesOfShardsBatchSize, stream.SendMsg)
+	}
+
+	forwardedAddr, _, err := s.getForwardedAddr(ctx)
+	if err != nil {
+		return stream.SendMsg(&metaservicepb.GetTablesOfShardsResponse{Header: responseHeader(err, "grpc get tables of shards stream")})
+	}
+
+	// Forward request to the leader, and relay the batches of the leader.
+	if forwardedAddr != "" {
+		return s.forwardGetTablesOfShardsStream(ctx, forwardedAddr, req, stream)
+	}
+
+	log.Info("[GetTablesOfShardsStream]", zap.String("clusterName", req.GetHeader().GetClusterName()), zap.String("shardIDs", fmt.Sprint(req.GetShardIds())))
+
+	tables, err := s.h.GetClusterManager().GetTablesByShardIDs(req.GetHeader().GetClusterName(), req.GetHeader().GetNode(), shardIDs)
+	if err != nil {
+		return stream.SendMsg(&metaservicepb.GetTablesOfShardsResponse{Header: responseHeader(err, "grpc get tables of shards stream")})
+	}
+	return sendTablesOfShards(tables, tablesOfShardsBatchSize, stream.SendMsg)
+}
+
+func (s *Service) forwardGetTablesOfShardsStream(ctx context.Context, forwardedAddr string, req *metaservicepb.GetTablesOfShardsRequest, stream grpc.ServerStream) error {
+	conn, err := s.getForwardedGrpcClient(ctx, forwardedAddr)
+	if err != nil {
+		return stream.SendMsg(&metaservicepb.GetTablesOfShardsResponse{Header: responseHeader(err, "grpc get tables of shards stream")})
+	}
+
+	// The handler is only used by the server, so it is left out of the description of the client stream.
+	leaderStream, err := conn.NewStream(ctx, &grpc.StreamDesc{
+		StreamName:    getTablesOfShardsStreamMethod,
+		Handler:       nil,
+		ServerStreams: true,
+		ClientStreams: false,
+	}, getTablesOfShardsStreamFullMethod)
+	if err != nil {
+		return err
+	}
+	if err := leaderStream.SendMsg(req); err != nil {
+		return err
+	}
+	if err := leaderStream.CloseSend(); err != nil {
+		return err
+	}
+	for {
+		resp := new(metaservicepb.GetTablesOfShardsResponse)
+		if err := leaderStream.RecvMsg(resp); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := stream.SendMsg(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// sendTablesOfShards sends the tables of the shards in the order of the shard ids, and each batch has at most batchSize
+// tables of one shard.
+func sendTablesOfShards(shardTables map[storage.ShardID]metadata.ShardTables, batchSize int, send func(any) error) error {
+	shardIDs := make([]storage.ShardID, 0, len(shardTables))
+	for shardID := range shardTables {
+		shardIDs = append(shardIDs, shardID)
+	}
+	sort.Slice(shardIDs, func(i, j int) bool {
+		return shardIDs[i] < shardIDs[j]
+	})
+
+	for _, shardID := range shardIDs {
+		shardTable := shardTables[shardID]
+		shardInfo := metadata.ConvertShardsInfoToPB(shardTable.Shard)
+		for start := 0; start == 0 || start < len(shardTable.Tables); start += batchSize {
+			end := min(start+batchSize, len(shardTable.Tables))
+			tables := make([]*metaservicepb.TableInfo, 0, end-start)
+			for _, table := range shardTable.Tables[start:end] {
+				tables = append(tables, metadata.ConvertTableInfoToPB(table))
+			}
+			if err := send(&metaservicepb.GetTablesOfShardsResponse{
+				Header: okResponseHeader(),
+				TablesByShard: map[uint32]*metaservicepb.TablesOfShard{
+					uint32(shardID): {
+						ShardInfo: shardInfo,
+						Tables:    tables,
+					},
+				},
+			}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
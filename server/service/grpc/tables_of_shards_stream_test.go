@@ -0,0 +1,88 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"testing"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSendTablesOfShards(t *testing.T) {
+	re := require.New(t)
+
+	newShardTables := func(shardID storage.ShardID, version uint64, numTables int) metadata.ShardTables {
+		tables := make([]metadata.TableInfo, 0, numTables)
+		for i := 0; i < numTables; i++ {
+			tables = append(tables, metadata.TableInfo{
+				ID:            storage.TableID(i),
+				Name:          "table",
+				SchemaID:      0,
+				SchemaName:    "public",
+				PartitionInfo: storage.PartitionInfo{Info: nil},
+				CreatedAt:     0,
+			})
+		}
+		return metadata.ShardTables{
+			Shard:  metadata.ShardInfo{ID: shardID, Role: storage.ShardRoleLeader, Version: version, Status: storage.ShardStatusReady},
+			Tables: tables,
+		}
+	}
+
+	var batches []*metaservicepb.GetTablesOfShardsResponse
+	send := func(m any) error {
+		batches = append(batches, m.(*metaservicepb.GetTablesOfShardsResponse))
+		return nil
+	}
+	err := sendTablesOfShards(map[storage.ShardID]metadata.ShardTables{
+		2: newShardTables(2, 7, 0),
+		1: newShardTables(1, 3, 5),
+	}, 2, send)
+	re.NoError(err)
+
+	// The tables of shard 1 are sent in three batches, and the empty shard 2 is sent in one batch.
+	re.Len(batches, 4)
+	for i, expectNumTables := range []int{2, 2, 1} {
+		tablesOfShard, ok := batches[i].TablesByShard[1]
+		re.True(ok)
+		re.Len(batches[i].TablesByShard, 1)
+		re.Equal(uint64(3), tablesOfShard.ShardInfo.Version)
+		re.Len(tablesOfShard.Tables, expectNumTables)
+		re.Equal(uint64(i*2), tablesOfShard.Tables[0].Id)
+	}
+	tablesOfShard, ok := batches[3].TablesByShard[2]
+	re.True(ok)
+	re.Equal(uint64(7), tablesOfShard.ShardInfo.Version)
+	re.Empty(tablesOfShard.Tables)
+}
+
+func TestWithGetTablesOfShardsStream(t *testing.T) {
+	re := require.New(t)
+
+	desc := withGetTablesOfShardsStream(&metaservicepb.CeresmetaRpcService_ServiceDesc)
+	re.Equal(metaservicepb.CeresmetaRpcService_ServiceDesc.ServiceName, desc.ServiceName)
+	re.Equal(metaservicepb.CeresmetaRpcService_ServiceDesc.Methods, desc.Methods)
+	re.Len(desc.Streams, len(metaservicepb.CeresmetaRpcService_ServiceDesc.Streams)+1)
+	stream := desc.Streams[len(desc.Streams)-1]
+	re.Equal(getTablesOfShardsStreamMethod, stream.StreamName)
+	re.True(stream.ServerStreams)
+	re.False(stream.ClientStreams)
+	re.Equal("/meta_service.CeresmetaRpcService/GetTablesOfShardsStream", getTablesOfShardsStreamFullMethod)
+}
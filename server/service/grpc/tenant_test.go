@@ -0,0 +1,91 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/clock"
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/service"
+	"github.com/stretchr/testify/require"
+	grpcmetadata "google.golang.org/grpc/metadata"
+)
+
+func newTenantTestService(tenantTokenRequired bool) *Service {
+	return NewService(ServiceOptions{
+		OpTimeout:            time.Second,
+		HeartbeatRateBudget:  0,
+		LeaseBounds:          LeaseBounds{MinMs: 0, MaxMs: 0},
+		SlowRequestThreshold: 0,
+		ForwardConnOptions:   service.DefaultConnPoolOptions(),
+		TenantTokenRequired:  tenantTokenRequired,
+		Clock:                clock.NewRealClock(),
+	}, nil)
+}
+
+func TestAuthorizeTenantWithoutToken(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+
+	// The requests without the token are allowed as usual if the token is not required.
+	re.NoError(newTenantTestService(false).authorizeTenant(ctx, "cluster", "schema"))
+
+	s := newTenantTestService(true)
+	err := s.authorizeTenant(ctx, "cluster", "schema")
+	re.True(coderr.Is(err, coderr.Unauthorized))
+
+	// The empty token is taken as no token.
+	ctx = grpcmetadata.NewIncomingContext(ctx, grpcmetadata.Pairs(tenantTokenMetadataKey, ""))
+	err = s.authorizeTenant(ctx, "cluster", "schema")
+	re.True(coderr.Is(err, coderr.Unauthorized))
+}
+
+func TestTableRequestsWithoutTenantToken(t *testing.T) {
+	re := require.New(t)
+	ctx := context.Background()
+	s := newTenantTestService(true)
+	header := &metaservicepb.RequestHeader{Node: "", ClusterName: "cluster"}
+
+	createResp, err := s.CreateTable(ctx, &metaservicepb.CreateTableRequest{Header: header, SchemaName: "schema", Name: "table"})
+	re.NoError(err)
+	re.Equal(uint32(coderr.Unauthorized), createResp.GetHeader().GetCode())
+
+	dropResp, err := s.DropTable(ctx, &metaservicepb.DropTableRequest{Header: header, SchemaName: "schema", Name: "table"})
+	re.NoError(err)
+	re.Equal(uint32(coderr.Unauthorized), dropResp.GetHeader().GetCode())
+
+	routeResp, err := s.RouteTables(ctx, &metaservicepb.RouteTablesRequest{Header: header, SchemaName: "schema", TableNames: []string{"table"}})
+	re.NoError(err)
+	re.Equal(uint32(coderr.Unauthorized), routeResp.GetHeader().GetCode())
+}
+
+func TestForwardTenantToken(t *testing.T) {
+	re := require.New(t)
+
+	ctx := forwardTenantToken(context.Background())
+	_, ok := grpcmetadata.FromOutgoingContext(ctx)
+	re.False(ok)
+
+	ctx = grpcmetadata.NewIncomingContext(context.Background(), grpcmetadata.Pairs(tenantTokenMetadataKey, "token"))
+	md, ok := grpcmetadata.FromOutgoingContext(forwardTenantToken(ctx))
+	re.True(ok)
+	re.Equal([]string{"token"}, md.Get(tenantTokenMetadataKey))
+}
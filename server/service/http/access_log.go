@@ -0,0 +1,182 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/pkg/requestid"
+	"github.com/CeresDB/horaemeta/server/config"
+	"go.uber.org/zap"
+)
+
+const redactedValue = "***"
+
+// defaultRedactedFields are always masked in the access log, and the fields are matched by the case-insensitive
+// substrings, e.g. `token` covers `tokenID` and `registrationToken`.
+var defaultRedactedFields = []string{"token", "password", "secret"}
+
+// accessLogger logs the http requests with their responses and records the latency of them by the routes.
+type accessLogger struct {
+	cfg config.AccessLogConfig
+	// redactedFields are the lower-cased fields whose values are masked.
+	redactedFields []string
+	// bodyRedactor matches the string values of the redacted fields in the json body, which may be truncated.
+	bodyRedactor *regexp.Regexp
+}
+
+func newAccessLogger(cfg config.AccessLogConfig) *accessLogger {
+	redactedFields := make([]string, 0, len(defaultRedactedFields)+len(cfg.RedactedFields))
+	quotedFields := make([]string, 0, cap(redactedFields))
+	for _, field := range append(append([]string{}, defaultRedactedFields...), cfg.RedactedFields...) {
+		if len(field) == 0 {
+			continue
+		}
+		redactedFields = append(redactedFields, strings.ToLower(field))
+		quotedFields = append(quotedFields, regexp.QuoteMeta(field))
+	}
+
+	return &accessLogger{
+		cfg:            cfg,
+		redactedFields: redactedFields,
+		bodyRedactor:   regexp.MustCompile(`(?i)("[^"]*(?:` + strings.Join(quotedFields, "|") + `)[^"]*"\s*:\s*)"(?:[^"\\]|\\.)*"?`),
+	}
+}
+
+// instrument is the instrumentation of the router logging the requests. The failed and the slow requests are always
+// logged, while the succeeded ones are sampled.
+func (l *accessLogger) instrument(handlerName string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		start := time.Now()
+		body := &cappedBody{ReadCloser: req.Body, buf: bytes.Buffer{}, limit: l.cfg.MaxBodyBytes, truncated: false}
+		req.Body = body
+		recorder := &responseRecorder{ResponseWriter: w, code: http.StatusOK, bytes: 0}
+
+		handler(recorder, req)
+
+		latency := time.Since(start)
+		requestDuration.WithLabelValues(handlerName, req.Method, strconv.Itoa(recorder.code)).Observe(latency.Seconds())
+
+		failed := recorder.code >= http.StatusBadRequest
+		slow := l.cfg.SlowThresholdMs > 0 && latency > l.cfg.SlowThreshold()
+		if !failed && !slow && rand.Float64() >= l.cfg.SampleRatio {
+			return
+		}
+
+		fields := []zap.Field{
+			zap.String("handlerName", handlerName),
+			zap.String("method", req.Method),
+			zap.String("path", req.URL.Path),
+			zap.String("query", l.redactQuery(req.URL.Query())),
+			zap.String("client", req.RemoteAddr),
+			zap.Int("code", recorder.code),
+			zap.Int("responseBytes", recorder.bytes),
+			zap.Duration("latency", latency),
+			zap.String("body", l.redactBody(body.buf.String())),
+			zap.Bool("bodyTruncated", body.truncated),
+			requestid.Field(req.Context()),
+		}
+		switch {
+		case failed:
+			log.Warn("http request failed", fields...)
+		case slow:
+			log.Warn("slow http request", fields...)
+		default:
+			log.Info("http request", fields...)
+		}
+	}
+}
+
+func (l *accessLogger) isRedacted(field string) bool {
+	field = strings.ToLower(field)
+	for _, redactedField := range l.redactedFields {
+		if strings.Contains(field, redactedField) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *accessLogger) redactQuery(query url.Values) string {
+	for key := range query {
+		if l.isRedacted(key) {
+			query[key] = []string{redactedValue}
+		}
+	}
+	return query.Encode()
+}
+
+func (l *accessLogger) redactBody(body string) string {
+	if len(body) == 0 {
+		return body
+	}
+	return l.bodyRedactor.ReplaceAllString(body, `${1}"`+redactedValue+`"`)
+}
+
+// cappedBody keeps the first bytes of the body read by the handler up to the limit, so the body is logged without being
+// read in advance.
+type cappedBody struct {
+	io.ReadCloser
+	buf       bytes.Buffer
+	limit     int
+	truncated bool
+}
+
+func (b *cappedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if remaining := b.limit - b.buf.Len(); n > 0 {
+		if n > remaining {
+			b.buf.Write(p[:max(remaining, 0)])
+			b.truncated = true
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	return n, err
+}
+
+// responseRecorder records the status code and the size of the response.
+type responseRecorder struct {
+	http.ResponseWriter
+	code  int
+	bytes int
+}
+
+func (r *responseRecorder) WriteHeader(code int) {
+	r.code = code
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += n
+	return n, err
+}
+
+// Unwrap returns the original writer, which is used by http.ResponseController to flush the streaming responses.
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
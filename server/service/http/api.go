@@ -17,77 +17,170 @@
 package http
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/http/pprof"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
 
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
 	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/pkg/failpoint"
 	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/pkg/requestid"
 	"github.com/CeresDB/horaemeta/server/cluster"
 	"github.com/CeresDB/horaemeta/server/cluster/metadata"
 	"github.com/CeresDB/horaemeta/server/config"
 	"github.com/CeresDB/horaemeta/server/coordinator"
 	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl/batchcreatetable"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/failover"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/migratetopology"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/repair"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/reshard"
 	"github.com/CeresDB/horaemeta/server/coordinator/scheduler"
-	"github.com/CeresDB/horaemeta/server/limiter"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/hotspot"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/manager"
 	"github.com/CeresDB/horaemeta/server/member"
-	"github.com/CeresDB/horaemeta/server/status"
+	"github.com/CeresDB/horaemeta/server/service"
 	"github.com/CeresDB/horaemeta/server/storage"
-	clientv3 "go.etcd.io/etcd/client/v3"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"go.uber.org/zap"
 )
 
-func NewAPI(clusterManager cluster.Manager, serverStatus *status.ServerStatus, forwardClient *ForwardClient, flowLimiter *limiter.FlowLimiter, etcdClient *clientv3.Client) *API {
+func NewAPI(opts APIOptions) *API {
 	return &API{
-		clusterManager: clusterManager,
-		serverStatus:   serverStatus,
-		forwardClient:  forwardClient,
-		flowLimiter:    flowLimiter,
-		etcdAPI:        NewEtcdAPI(etcdClient, forwardClient),
+		clusterManager:   opts.ClusterManager,
+		serverStatus:     opts.ServerStatus,
+		forwardClient:    opts.ForwardClient,
+		flowLimiter:      opts.FlowLimiter,
+		etcdAPI:          NewEtcdAPI(opts.EtcdClient, opts.ForwardClient, opts.EtcdMaintainer, opts.EtcdEndpointManager, opts.EtcdEmbedMonitor),
+		idempotencyCache: NewIdempotencyCache(opts.EtcdClient, opts.RootPath, opts.IdempotencyKeyTTLSec),
+		storageInspector: NewStorageInspector(opts.EtcdClient, opts.RootPath, opts.EtcdQuotaBytes),
+		enableDebugKV:    opts.EnableDebugKV,
+		configReloader:   opts.ConfigReloader,
+		buildInfo:        opts.BuildInfo,
+		handleTimeouts:   opts.HandleTimeouts,
+		accessLogger:     newAccessLogger(opts.AccessLog),
+
+		tenantTokenRequired: opts.TenantTokenRequired,
+		tenantAdminToken:    opts.TenantAdminToken,
+
+		transferLeaderBatches: newTransferLeaderBatches(),
 	}
 }
 
 func (a *API) NewAPIRouter() *Router {
-	router := New().WithPrefix(apiPrefix).WithInstrumentation(printRequestInfo)
+	router := New().WithPrefix(apiPrefix).WithCompression().WithTimeout(a.handleTimeouts.Default).WithInstrumentation(a.authorizeTenant).WithInstrumentation(a.drainRequests).WithInstrumentation(a.accessLogger.instrument)
+	longRouter := router.WithTimeout(a.handleTimeouts.Long)
+	// The requests bounded by themselves, e.g. the profiling for the given seconds, are not timed out by the router.
+	unboundedRouter := router.WithTimeout(0)
 
 	// Register API.
-	router.Post("/getShardTables", wrap(a.getShardTables, true, a.forwardClient))
+	router.Post("/getShardTables", wrapStaleRead(a.getShardTables, a.forwardClient))
 	router.Post("/transferLeader", wrap(a.transferLeader, true, a.forwardClient))
-	router.Post("/split", wrap(a.split, true, a.forwardClient))
-	router.Post("/route", wrap(a.route, true, a.forwardClient))
+	router.Post("/transferLeaderBatch", wrap(a.transferLeaderBatch, true, a.forwardClient))
+	router.Get("/transferLeaderBatch", wrap(a.listTransferLeaderBatches, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/transferLeaderBatch/:%s", batchIDParam), wrap(a.getTransferLeaderBatch, true, a.forwardClient))
+	router.Post("/split", wrap(a.idempotent("split", a.split), true, a.forwardClient))
+	router.Post("/rebalancePartitionTable", wrap(a.idempotent("rebalancePartitionTable", a.rebalancePartitionTable), true, a.forwardClient))
+	router.Post("/route", wrapStaleRead(a.route, a.forwardClient))
+	router.Get("/procedureSchemas", wrap(a.listProcedureSchemas, false, a.forwardClient))
 	router.Del("/table", wrap(a.dropTable, true, a.forwardClient))
-	router.Post("/getNodeShards", wrap(a.getNodeShards, true, a.forwardClient))
+	router.Post("/tables/batchCreate", wrap(a.batchCreateTables, true, a.forwardClient))
+	router.Post("/tables/batchDrop", wrap(a.batchDropTables, true, a.forwardClient))
+	router.Post("/getNodeShards", wrapStaleRead(a.getNodeShards, a.forwardClient))
 	router.Get("/flowLimiter", wrap(a.getFlowLimiter, true, a.forwardClient))
 	router.Put("/flowLimiter", wrap(a.updateFlowLimiter, true, a.forwardClient))
+	router.Post("/config/reload", wrap(a.reloadConfig, false, a.forwardClient))
 	router.Get("/health", wrap(a.health, false, a.forwardClient))
+	router.Get("/members", wrap(a.listMembers, false, a.forwardClient))
+	router.Get("/status", wrap(a.getStatus, false, a.forwardClient))
+	longRouter.Get("/debug/storage/stats", wrap(a.getStorageStats, false, a.forwardClient))
+	router.Get("/debug/connections", wrap(a.listConnections, false, a.forwardClient))
+	longRouter.Get("/debug/kv", wrap(a.listKVs, false, a.forwardClient))
 
 	// Register cluster API.
 	router.Get("/clusters", wrap(a.listClusters, true, a.forwardClient))
-	router.Post("/clusters", wrap(a.createCluster, true, a.forwardClient))
+	router.Post("/clusters", wrap(a.idempotent("createCluster", a.createCluster), true, a.forwardClient))
 	router.Put(fmt.Sprintf("/clusters/:%s", clusterNameParam), wrap(a.updateCluster, true, a.forwardClient))
+	router.Post(fmt.Sprintf("/clusters/:%s/clone", clusterNameParam), wrap(a.idempotent("cloneCluster", a.cloneCluster), true, a.forwardClient))
 	router.Get(fmt.Sprintf("/clusters/:%s/procedure", clusterNameParam), wrap(a.listProcedures, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/clusters/:%s/topology", clusterNameParam), wrap(a.getClusterTopology, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/clusters/:%s/nodes", clusterNameParam), wrap(a.listNodes, true, a.forwardClient))
+	router.Post(fmt.Sprintf("/clusters/:%s/nodes/:%s/cordon", clusterNameParam, nodeNameParam), wrap(a.cordonNode, true, a.forwardClient))
+	router.Post(fmt.Sprintf("/clusters/:%s/nodes/:%s/uncordon", clusterNameParam, nodeNameParam), wrap(a.uncordonNode, true, a.forwardClient))
+	router.Post(fmt.Sprintf("/clusters/:%s/repairShards", clusterNameParam), wrap(a.repairShards, true, a.forwardClient))
+	longRouter.Post(fmt.Sprintf("/clusters/:%s/fsck", clusterNameParam), wrap(a.fsck, true, a.forwardClient))
+	longRouter.Get(fmt.Sprintf("/clusters/:%s/export", clusterNameParam), wrap(a.exportMetadata, true, a.forwardClient))
+	longRouter.Post(fmt.Sprintf("/clusters/:%s/import", clusterNameParam), wrap(a.importMetadata, true, a.forwardClient))
+	router.Post(fmt.Sprintf("/clusters/:%s/shards/:%s/failover", clusterNameParam, shardIDParam), wrap(a.failoverShard, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/clusters/:%s/state", clusterNameParam), wrap(a.getClusterState, true, a.forwardClient))
+	router.Post(fmt.Sprintf("/clusters/:%s/state", clusterNameParam), wrap(a.transitClusterState, true, a.forwardClient))
+	router.Post(fmt.Sprintf("/clusters/:%s/migrateTopology", clusterNameParam), wrap(a.migrateTopology, true, a.forwardClient))
+	router.Post(fmt.Sprintf("/clusters/:%s/reshard", clusterNameParam), wrap(a.reshard, true, a.forwardClient))
 	router.Get(fmt.Sprintf("/clusters/:%s/shardAffinities", clusterNameParam), wrap(a.listShardAffinities, true, a.forwardClient))
 	router.Post(fmt.Sprintf("/clusters/:%s/shardAffinities", clusterNameParam), wrap(a.addShardAffinities, true, a.forwardClient))
 	router.Del(fmt.Sprintf("/clusters/:%s/shardAffinities", clusterNameParam), wrap(a.removeShardAffinities, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/clusters/:%s/shardAffinities/export", clusterNameParam), wrap(a.exportShardAffinities, true, a.forwardClient))
+	router.Post(fmt.Sprintf("/clusters/:%s/shardAffinities/import", clusterNameParam), wrap(a.importShardAffinities, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/clusters/:%s/scheduler/config", clusterNameParam), wrap(a.getSchedulerConfig, true, a.forwardClient))
+	router.Put(fmt.Sprintf("/clusters/:%s/scheduler/config", clusterNameParam), wrap(a.updateSchedulerConfig, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/clusters/:%s/scheduler/decisions", clusterNameParam), wrap(a.listSchedulerDecisions, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/clusters/:%s/scheduler/rebalanceWindow", clusterNameParam), wrap(a.getRebalanceWindowStatus, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/clusters/:%s/events", clusterNameParam), wrap(a.listClusterEvents, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/clusters/:%s/schemas", clusterNameParam), wrap(a.listSchemas, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/clusters/:%s/schemas/:%s", clusterNameParam, schemaNameParam), wrap(a.getSchema, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/clusters/:%s/schemaSettings", clusterNameParam), wrap(a.listSchemaSettings, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/clusters/:%s/schemaSettings/:%s", clusterNameParam, schemaNameParam), wrap(a.getSchemaSettings, true, a.forwardClient))
+	router.Put(fmt.Sprintf("/clusters/:%s/schemaSettings/:%s", clusterNameParam, schemaNameParam), wrap(a.updateSchemaSettings, true, a.forwardClient))
+	router.Del(fmt.Sprintf("/clusters/:%s/schemaSettings/:%s", clusterNameParam, schemaNameParam), wrap(a.deleteSchemaSettings, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/clusters/:%s/stats", clusterNameParam), wrap(a.getClusterStats, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/clusters/:%s/nodeShortfall", clusterNameParam), wrap(a.getNodeShortfall, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/clusters/:%s/registrationTokens", clusterNameParam), wrap(a.listRegistrationTokens, true, a.forwardClient))
+	router.Post(fmt.Sprintf("/clusters/:%s/registrationTokens", clusterNameParam), wrap(a.createRegistrationToken, true, a.forwardClient))
+	router.Del(fmt.Sprintf("/clusters/:%s/registrationTokens/:%s", clusterNameParam, tokenIDParam), wrap(a.revokeRegistrationToken, true, a.forwardClient))
 	router.Post("/table/query", wrap(a.queryTable, true, a.forwardClient))
 
+	// Tenant API.
+	router.Get("/tenants", wrap(a.listTenants, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/tenants/:%s", tenantNameParam), wrap(a.getTenant, true, a.forwardClient))
+	router.Put(fmt.Sprintf("/tenants/:%s", tenantNameParam), wrap(a.putTenant, true, a.forwardClient))
+	router.Del(fmt.Sprintf("/tenants/:%s", tenantNameParam), wrap(a.deleteTenant, true, a.forwardClient))
+	router.Get(fmt.Sprintf("/tenants/:%s/tokens", tenantNameParam), wrap(a.listTenantTokens, true, a.forwardClient))
+	router.Post(fmt.Sprintf("/tenants/:%s/tokens", tenantNameParam), wrap(a.createTenantToken, true, a.forwardClient))
+	router.Del(fmt.Sprintf("/tenants/:%s/tokens/:%s", tenantNameParam, tokenIDParam), wrap(a.revokeTenantToken, true, a.forwardClient))
+
 	// Register debug API.
-	router.DebugGet("/pprof/profile", pprof.Profile)
+	unboundedRouter.DebugGet("/pprof/profile", pprof.Profile)
 	router.DebugGet("/pprof/symbol", pprof.Symbol)
-	router.DebugGet("/pprof/trace", pprof.Trace)
+	unboundedRouter.DebugGet("/pprof/trace", pprof.Trace)
 	router.DebugGet("/pprof/heap", a.pprofHeap)
 	router.DebugGet("/pprof/allocs", a.pprofAllocs)
 	router.DebugGet("/pprof/block", a.pprofBlock)
 	router.DebugGet("/pprof/goroutine", a.pprofGoroutine)
 	router.DebugGet("/pprof/threadCreate", a.pprofThreadCreate)
-	router.DebugGet(fmt.Sprintf("/diagnose/:%s/shards", clusterNameParam), wrap(a.diagnoseShards, true, a.forwardClient))
+	router.DebugGet("/metrics", promhttp.Handler().ServeHTTP)
+	longRouter.DebugGet(fmt.Sprintf("/diagnose/:%s/shards", clusterNameParam), wrap(a.diagnoseShards, true, a.forwardClient))
 	router.DebugGet("/leader", wrap(a.getLeader, false, a.forwardClient))
+	router.DebugGet("/log/level", wrap(a.getLogLevel, false, a.forwardClient))
+	router.DebugPut("/log/level", wrap(a.updateLogLevel, false, a.forwardClient))
+	router.DebugGet("/failpoints", wrap(a.listFailpoints, true, a.forwardClient))
+	router.DebugPut("/failpoints", wrap(a.enableFailpoint, true, a.forwardClient))
+	router.DebugDel("/failpoints", wrap(a.disableFailpoint, true, a.forwardClient))
+	router.DebugGet(fmt.Sprintf("/procedures/:%s/raw", procedureIDParam), wrap(a.getProcedureRaw, true, a.forwardClient))
+	router.DebugGet(fmt.Sprintf("/procedures/:%s/replay", procedureIDParam), wrap(a.replayProcedure, true, a.forwardClient))
 	router.DebugGet(fmt.Sprintf("/clusters/:%s/enableSchedule", clusterNameParam), wrap(a.getEnableSchedule, true, a.forwardClient))
 	router.DebugPut(fmt.Sprintf("/clusters/:%s/enableSchedule", clusterNameParam), wrap(a.updateEnableSchedule, true, a.forwardClient))
+	router.DebugGet(fmt.Sprintf("/clusters/:%s/schedulers", clusterNameParam), wrap(a.listSchedulers, true, a.forwardClient))
+	router.DebugPut(fmt.Sprintf("/clusters/:%s/schedulers/:%s", clusterNameParam, schedulerParam), wrap(a.updateSchedulerEnabled, true, a.forwardClient))
+	router.DebugGet(fmt.Sprintf("/clusters/:%s/memoryStats", clusterNameParam), wrap(a.getMemoryStats, true, a.forwardClient))
 
 	// Register ETCD API.
 	router.Post("/etcd/promoteLearner", wrap(a.etcdAPI.promoteLearner, false, a.forwardClient))
@@ -96,6 +189,10 @@ func (a *API) NewAPIRouter() *Router {
 	router.Post("/etcd/member", wrap(a.etcdAPI.updateMember, false, a.forwardClient))
 	router.Del("/etcd/member", wrap(a.etcdAPI.removeMember, false, a.forwardClient))
 	router.Post("/etcd/moveLeader", wrap(a.etcdAPI.moveLeader, false, a.forwardClient))
+	router.Get("/etcd/maintenance", wrap(a.etcdAPI.getMaintenanceStatus, true, a.forwardClient))
+	router.Get("/etcd/endpoints", wrap(a.etcdAPI.getEndpointStatus, false, a.forwardClient))
+	router.Get("/etcd/status", wrap(a.etcdAPI.getEmbedStatus, false, a.forwardClient))
+	unboundedRouter.Post("/failoverDrill", wrap(a.failoverDrill, true, a.forwardClient))
 
 	return router
 }
@@ -109,6 +206,131 @@ func (a *API) getLeader(req *http.Request) apiFuncResult {
 	return okResult(leaderAddr)
 }
 
+func (a *API) getLogLevel(_ *http.Request) apiFuncResult {
+	return okResult(LogLevel{Level: log.GetLevel().String()})
+}
+
+// updateLogLevel changes the log level of the server receiving the request until it is restarted, and the config file
+// is left untouched.
+func (a *API) updateLogLevel(req *http.Request) apiFuncResult {
+	var logLevel LogLevel
+	if err := json.NewDecoder(req.Body).Decode(&logLevel); err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+
+	oldLevel := log.GetLevel().String()
+	if err := log.SetLevel(logLevel.Level); err != nil {
+		return errResult(ErrInvalidLogLevel, fmt.Sprintf("level:%s, err:%s", logLevel.Level, err.Error()))
+	}
+	log.Warn("log level is changed", zap.String("oldLevel", oldLevel), zap.String("newLevel", logLevel.Level))
+
+	return okResult(LogLevel{Level: log.GetLevel().String()})
+}
+
+// listFailpoints lists the failpoints of the leader, where the procedures are executed.
+func (a *API) listFailpoints(_ *http.Request) apiFuncResult {
+	return okResult(FailpointsStatus{Enabled: failpoint.Enabled(), Failpoints: failpoint.List()})
+}
+
+func (a *API) enableFailpoint(req *http.Request) apiFuncResult {
+	var enableReq EnableFailpointRequest
+	if err := json.NewDecoder(req.Body).Decode(&enableReq); err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+
+	if !failpoint.Enabled() {
+		return errResult(ErrFailpointsDisabled, "the server is not started with enable-failpoints")
+	}
+	if err := failpoint.Enable(enableReq.Name, enableReq.Action); err != nil {
+		return errResult(ErrInvalidFailpoint, err.Error())
+	}
+	return okResult(FailpointsStatus{Enabled: true, Failpoints: failpoint.List()})
+}
+
+func (a *API) disableFailpoint(req *http.Request) apiFuncResult {
+	name := req.URL.Query().Get(failpointNameParam)
+	if !failpoint.Disable(name) {
+		return errResult(ErrFailpointNotFound, fmt.Sprintf("name:%s", name))
+	}
+	return okResult(FailpointsStatus{Enabled: failpoint.Enabled(), Failpoints: failpoint.List()})
+}
+
+// findProcedure finds the persisted procedure by the id in the path, and the cluster is specified by the query. The
+// result is not nil if the procedure can't be found.
+func (a *API) findProcedure(req *http.Request) (*cluster.Cluster, *procedure.Meta, bool, *apiFuncResult) {
+	ctx := req.Context()
+	clusterName := req.URL.Query().Get(clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+	procedureID, err := strconv.ParseUint(Param(ctx, procedureIDParam), 10, 64)
+	if err != nil {
+		result := errResult(ErrParseRequest, fmt.Sprintf("invalid procedure id, err: %s", err.Error()))
+		return nil, nil, false, &result
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		result := errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+		return nil, nil, false, &result
+	}
+	meta, deleted, err := procedure.FindMeta(ctx, c.GetProcedureStorage(), procedureID)
+	if err != nil {
+		if coderr.Is(err, procedure.ErrProcedureNotFound.Code()) {
+			result := errResult(ErrProcedureNotFound, fmt.Sprintf("clusterName: %s, procedureID: %d", clusterName, procedureID))
+			return nil, nil, false, &result
+		}
+		result := errResult(ErrGetProcedure, err.Error())
+		return nil, nil, false, &result
+	}
+	return c, meta, deleted, nil
+}
+
+func (a *API) getProcedureRaw(req *http.Request) apiFuncResult {
+	_, meta, deleted, result := a.findProcedure(req)
+	if result != nil {
+		return *result
+	}
+
+	rawData := json.RawMessage(meta.RawData)
+	if !json.Valid(rawData) {
+		// The raw data is dumped as a JSON string if it is not encoded by JSON.
+		encoded, err := json.Marshal(string(meta.RawData))
+		if err != nil {
+			return errResult(ErrGetProcedure, err.Error())
+		}
+		rawData = encoded
+	}
+	return okResult(ProcedureRaw{
+		ID:        meta.ID,
+		Kind:      meta.Kind.String(),
+		KindID:    meta.Kind,
+		State:     meta.State,
+		UpdatedAt: meta.UpdatedAt,
+		Deleted:   deleted,
+		RawData:   rawData,
+	})
+}
+
+// replayProcedure re-drives the state machine of the persisted procedure in the dry-run mode against the current
+// snapshot, which tells why the procedure can't resume after the leader changes.
+func (a *API) replayProcedure(req *http.Request) apiFuncResult {
+	c, meta, _, result := a.findProcedure(req)
+	if result != nil {
+		return *result
+	}
+
+	replayer, ok := coordinator.GetReplayer(meta.Kind)
+	if !ok {
+		return errResult(ErrReplayProcedure, fmt.Sprintf("procedure of kind %s is not persisted", meta.Kind))
+	}
+	replayResult, err := procedure.Replay(replayer, c.GetMetadata().GetClusterSnapshot(), meta)
+	if err != nil {
+		return errResult(ErrReplayProcedure, err.Error())
+	}
+	return okResult(replayResult)
+}
+
 func (a *API) getShardTables(req *http.Request) apiFuncResult {
 	var getShardTablesReq GetShardTablesRequest
 	err := json.NewDecoder(req.Body).Decode(&getShardTablesReq)
@@ -116,25 +338,34 @@ func (a *API) getShardTables(req *http.Request) apiFuncResult {
 		return errResult(ErrParseRequest, err.Error())
 	}
 
-	c, err := a.clusterManager.GetCluster(req.Context(), getShardTablesReq.ClusterName)
+	clusterMetadata, err := a.clusterManager.GetMetadataForStaleRead(getShardTablesReq.ClusterName)
 	if err != nil {
 		return errResult(ErrParseRequest, err.Error())
 	}
 
 	// If ShardIDs in the request is empty, query with all shardIDs in the cluster.
-	shardIDs := make([]storage.ShardID, len(getShardTablesReq.ShardIDs))
+	shardIDs := make([]storage.ShardID, 0, len(getShardTablesReq.ShardIDs))
 	if len(getShardTablesReq.ShardIDs) != 0 {
 		for _, shardID := range getShardTablesReq.ShardIDs {
 			shardIDs = append(shardIDs, storage.ShardID(shardID))
 		}
 	} else {
-		shardViewsMapping := c.GetMetadata().GetClusterSnapshot().Topology.ShardViewsMapping
+		shardViewsMapping := clusterMetadata.GetClusterSnapshot().Topology.ShardViewsMapping
 		for shardID := range shardViewsMapping {
 			shardIDs = append(shardIDs, shardID)
 		}
 	}
 
-	shardTables := c.GetMetadata().GetShardTables(shardIDs)
+	if getShardTablesReq.CountOnly {
+		return okResult(clusterMetadata.GetShardTableCounts(shardIDs))
+	}
+
+	shardTables := clusterMetadata.GetShardTables(shardIDs)
+	if getShardTablesReq.Offset > 0 || getShardTablesReq.Limit > 0 {
+		for shardID, tables := range shardTables {
+			shardTables[shardID] = tables.Page(int(getShardTablesReq.Offset), int(getShardTablesReq.Limit))
+		}
+	}
 	return okResult(shardTables)
 }
 
@@ -160,7 +391,7 @@ func (a *API) transferLeader(req *http.Request) apiFuncResult {
 	})
 	if err != nil {
 		log.Error("create transfer leader procedure failed", zap.Error(err))
-		return errResult(ErrCreateProcedure, err.Error())
+		return createProcedureErrResult(err)
 	}
 	err = c.GetProcedureManager().Submit(req.Context(), transferLeaderProcedure)
 	if err != nil {
@@ -171,6 +402,19 @@ func (a *API) transferLeader(req *http.Request) apiFuncResult {
 	return okResult(statusSuccess)
 }
 
+// createProcedureErrResult returns the field errors as the data of the result if the procedure params are invalid.
+func createProcedureErrResult(err error) apiFuncResult {
+	var validationErr *procedure.ParamValidationError
+	if errors.As(err, &validationErr) {
+		return errResultWithData(ErrInvalidProcedureParams, err.Error(), validationErr.FieldErrors)
+	}
+	return errResult(ErrCreateProcedure, err.Error())
+}
+
+func (a *API) listProcedureSchemas(_ *http.Request) apiFuncResult {
+	return okResult(procedure.ListParamSchemas())
+}
+
 func (a *API) route(req *http.Request) apiFuncResult {
 	var routeRequest RouteRequest
 	err := json.NewDecoder(req.Body).Decode(&routeRequest)
@@ -178,7 +422,13 @@ func (a *API) route(req *http.Request) apiFuncResult {
 		return errResult(ErrParseRequest, err.Error())
 	}
 
-	result, err := a.clusterManager.RouteTables(context.Background(), routeRequest.ClusterName, routeRequest.SchemaName, routeRequest.Tables)
+	clusterMetadata, err := a.clusterManager.GetMetadataForStaleRead(routeRequest.ClusterName)
+	if err != nil {
+		log.Error("get cluster metadata failed", zap.String("clusterName", routeRequest.ClusterName), zap.Error(err))
+		return errResult(ErrGetCluster, err.Error())
+	}
+
+	result, err := clusterMetadata.RouteTables(req.Context(), routeRequest.SchemaName, routeRequest.Tables)
 	if err != nil {
 		log.Error("route tables failed", zap.Error(err))
 		return errResult(ErrRoute, err.Error())
@@ -194,7 +444,13 @@ func (a *API) getNodeShards(req *http.Request) apiFuncResult {
 		return errResult(ErrParseRequest, err.Error())
 	}
 
-	result, err := a.clusterManager.GetNodeShards(context.Background(), nodeShardsRequest.ClusterName)
+	clusterMetadata, err := a.clusterManager.GetMetadataForStaleRead(nodeShardsRequest.ClusterName)
+	if err != nil {
+		log.Error("get cluster metadata failed", zap.String("clusterName", nodeShardsRequest.ClusterName), zap.Error(err))
+		return errResult(ErrGetCluster, err.Error())
+	}
+
+	result, err := clusterMetadata.GetNodeShards(req.Context())
 	if err != nil {
 		log.Error("get node shards failed", zap.Error(err))
 		return errResult(ErrGetNodeShards, err.Error())
@@ -211,7 +467,7 @@ func (a *API) dropTable(req *http.Request) apiFuncResult {
 	}
 	log.Info("drop table request", zap.String("request", fmt.Sprintf("%+v", dropTableRequest)))
 
-	if err := a.clusterManager.DropTable(context.Background(), dropTableRequest.ClusterName, dropTableRequest.SchemaName, dropTableRequest.Table); err != nil {
+	if err := a.clusterManager.DropTable(req.Context(), dropTableRequest.ClusterName, dropTableRequest.SchemaName, dropTableRequest.Table); err != nil {
 		log.Error("drop table failed", zap.Error(err))
 		return errResult(ErrTable, err.Error())
 	}
@@ -219,6 +475,152 @@ func (a *API) dropTable(req *http.Request) apiFuncResult {
 	return okResult(statusSuccess)
 }
 
+// batchCreateTables creates the tables in one procedure, which is much cheaper than creating them one by one when
+// restoring a schema with lots of tables. The per table results are returned once the procedure finishes.
+func (a *API) batchCreateTables(req *http.Request) apiFuncResult {
+	var batchCreateTableRequest BatchCreateTableRequest
+	if err := json.NewDecoder(req.Body).Decode(&batchCreateTableRequest); err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+	log.Info("batch create tables request", zap.String("clusterName", batchCreateTableRequest.ClusterName), zap.String("schemaName", batchCreateTableRequest.SchemaName), zap.Int("tableCount", len(batchCreateTableRequest.Tables)))
+
+	ctx := req.Context()
+	c, err := a.clusterManager.GetCluster(ctx, batchCreateTableRequest.ClusterName)
+	if err != nil {
+		log.Error("get cluster failed", zap.String("clusterName", batchCreateTableRequest.ClusterName), zap.Error(err))
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", batchCreateTableRequest.ClusterName, err.Error()))
+	}
+
+	sourceReqs := make([]*metaservicepb.CreateTableRequest, 0, len(batchCreateTableRequest.Tables))
+	for _, table := range batchCreateTableRequest.Tables {
+		sourceReqs = append(sourceReqs, &metaservicepb.CreateTableRequest{
+			Header:             nil,
+			SchemaName:         batchCreateTableRequest.SchemaName,
+			Name:               table.Name,
+			EncodedSchema:      table.EncodedSchema,
+			Engine:             table.Engine,
+			CreateIfNotExist:   table.CreateIfNotExist,
+			Options:            table.Options,
+			PartitionTableInfo: nil,
+		})
+	}
+
+	result, errResult := runBatchCreateTables(ctx, c, batchCreateTableRequest.SchemaName, sourceReqs)
+	if errResult != nil {
+		return *errResult
+	}
+	return okResult(result)
+}
+
+// runBatchCreateTables submits the batch create table procedure and waits for it to finish, and the second output
+// parameter is the error result, which is nil if the procedure finishes.
+func runBatchCreateTables(ctx context.Context, c *cluster.Cluster, schemaName string, sourceReqs []*metaservicepb.CreateTableRequest) (BatchCreateTableResponse, *apiFuncResult) {
+	resultCh := make(chan []batchcreatetable.TableResult, 1)
+	batchCreateProcedure, err := c.GetProcedureFactory().CreateBatchCreateTableProcedure(ctx, coordinator.BatchCreateTableRequest{
+		ClusterMetadata: c.GetMetadata(),
+		SchemaName:      schemaName,
+		SourceReqs:      sourceReqs,
+		OnFinished: func(results []batchcreatetable.TableResult) error {
+			resultCh <- results
+			return nil
+		},
+	})
+	if err != nil {
+		log.Error("create batch create table procedure failed", zap.Error(err))
+		result := createProcedureErrResult(err)
+		return BatchCreateTableResponse{ProcedureID: 0, Results: nil}, &result
+	}
+	if err := c.GetProcedureManager().Submit(ctx, batchCreateProcedure); err != nil {
+		log.Error("submit batch create table procedure failed", zap.Error(err))
+		result := errResult(ErrSubmitProcedure, err.Error())
+		return BatchCreateTableResponse{ProcedureID: 0, Results: nil}, &result
+	}
+
+	result := BatchCreateTableResponse{
+		ProcedureID: batchCreateProcedure.ID(),
+		Results:     nil,
+	}
+	select {
+	case result.Results = <-resultCh:
+		return result, nil
+	case <-ctx.Done():
+		errResult := errResult(ErrBatchCreateTables, fmt.Sprintf("wait for batch create table procedure, procedureID: %d, err: %s", result.ProcedureID, ctx.Err()))
+		return result, &errResult
+	}
+}
+
+func (a *API) batchDropTables(req *http.Request) apiFuncResult {
+	var batchDropTableRequest BatchDropTableRequest
+	err := json.NewDecoder(req.Body).Decode(&batchDropTableRequest)
+	if err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+	log.Debug("batch drop tables request", zap.String("request", fmt.Sprintf("%+v", batchDropTableRequest)))
+
+	if (len(batchDropTableRequest.Tables) == 0) == (len(batchDropTableRequest.Prefix) == 0) {
+		return errResult(ErrInvalidParamsForBatchDrop, "expect exactly one of tables and prefix")
+	}
+
+	ctx := req.Context()
+	c, err := a.clusterManager.GetCluster(ctx, batchDropTableRequest.ClusterName)
+	if err != nil {
+		log.Error("get cluster failed", zap.String("clusterName", batchDropTableRequest.ClusterName), zap.Error(err))
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", batchDropTableRequest.ClusterName, err.Error()))
+	}
+
+	var tables []storage.Table
+	if len(batchDropTableRequest.Prefix) > 0 {
+		// All the tables matching the prefix are dropped, which may be far more than expected.
+		if err := c.GetMetadata().CheckProtection("batch drop tables by prefix", parseConfirmation(req)); err != nil {
+			return errResult(metadata.ErrClusterProtected, err.Error())
+		}
+		tables, err = c.GetMetadata().GetTablesByPrefix(batchDropTableRequest.SchemaName, batchDropTableRequest.Prefix)
+	} else {
+		tables, err = c.GetMetadata().GetTables(batchDropTableRequest.SchemaName, batchDropTableRequest.Tables)
+	}
+	if err != nil {
+		log.Error("get tables failed", zap.Error(err))
+		return errResult(ErrTable, err.Error())
+	}
+
+	// The partition tables must be dropped with their sub tables, so they are left to the single table drop.
+	tablesToDrop := make([]storage.Table, 0, len(tables))
+	result := BatchDropTableResponse{
+		ProcedureID:   0,
+		Tables:        make([]string, 0, len(tables)),
+		SkippedTables: make([]string, 0),
+	}
+	for _, table := range tables {
+		if table.IsPartitioned() {
+			result.SkippedTables = append(result.SkippedTables, table.Name)
+			continue
+		}
+		tablesToDrop = append(tablesToDrop, table)
+		result.Tables = append(result.Tables, table.Name)
+	}
+	if len(tablesToDrop) == 0 {
+		return okResult(result)
+	}
+
+	batchDropProcedure, err := c.GetProcedureFactory().CreateBatchDropTableProcedure(ctx, coordinator.BatchDropTableRequest{
+		ClusterMetadata: c.GetMetadata(),
+		SchemaName:      batchDropTableRequest.SchemaName,
+		Tables:          tablesToDrop,
+	})
+	if err != nil {
+		log.Error("create batch drop table procedure failed", zap.Error(err))
+		return createProcedureErrResult(err)
+	}
+
+	if err := c.GetProcedureManager().Submit(ctx, batchDropProcedure); err != nil {
+		log.Error("submit batch drop table procedure failed", zap.Error(err))
+		return errResult(ErrSubmitProcedure, err.Error())
+	}
+	result.ProcedureID = batchDropProcedure.ID()
+
+	return okResult(result)
+}
+
 func (a *API) split(req *http.Request) apiFuncResult {
 	var splitRequest SplitRequest
 	err := json.NewDecoder(req.Body).Decode(&splitRequest)
@@ -228,7 +630,7 @@ func (a *API) split(req *http.Request) apiFuncResult {
 
 	log.Info("split request", zap.String("request", fmt.Sprintf("%+v", splitRequest)))
 
-	ctx := context.Background()
+	ctx := req.Context()
 
 	c, err := a.clusterManager.GetCluster(ctx, splitRequest.ClusterName)
 	if err != nil {
@@ -251,28 +653,86 @@ func (a *API) split(req *http.Request) apiFuncResult {
 		NewShardID:      storage.ShardID(newShardID),
 		TargetNodeName:  splitRequest.NodeName,
 	})
+	// The new shard id is given back if the split is not submitted, otherwise it is never reused.
 	if err != nil {
 		log.Error("create split procedure failed", zap.Error(err))
-		return errResult(ErrCreateProcedure, err.Error())
+		collectShardID(ctx, c, newShardID)
+		return createProcedureErrResult(err)
 	}
 
 	if err := c.GetProcedureManager().Submit(ctx, splitProcedure); err != nil {
 		log.Error("submit split procedure failed", zap.Error(err))
+		collectShardID(ctx, c, newShardID)
 		return errResult(ErrSubmitProcedure, err.Error())
 	}
 
 	return okResult(newShardID)
 }
 
+func collectShardID(ctx context.Context, c *cluster.Cluster, shardID uint32) {
+	if err := c.GetMetadata().CollectShardID(ctx, shardID); err != nil {
+		log.Warn("collect shard id failed", zap.Uint32("shardID", shardID), zap.Error(err))
+	}
+}
+
+func (a *API) rebalancePartitionTable(req *http.Request) apiFuncResult {
+	var rebalanceRequest RebalancePartitionTableRequest
+	err := json.NewDecoder(req.Body).Decode(&rebalanceRequest)
+	if err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+
+	log.Debug("rebalance partition table request", zap.String("request", fmt.Sprintf("%+v", rebalanceRequest)))
+
+	ctx := req.Context()
+
+	c, err := a.clusterManager.GetCluster(ctx, rebalanceRequest.ClusterName)
+	if err != nil {
+		log.Error("get cluster failed", zap.String("clusterName", rebalanceRequest.ClusterName), zap.Error(err))
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", rebalanceRequest.ClusterName, err.Error()))
+	}
+
+	rebalanceProcedure, ok, err := c.GetProcedureFactory().CreateRebalancePartitionTableProcedure(ctx, coordinator.RebalancePartitionTableRequest{
+		ClusterMetadata: c.GetMetadata(),
+		SchemaName:      rebalanceRequest.SchemaName,
+		TableName:       rebalanceRequest.TableName,
+		SubTableNames:   rebalanceRequest.SubTableNames,
+		SkewThreshold:   rebalanceRequest.SkewThreshold,
+	})
+	if err != nil {
+		log.Error("create rebalance partition table procedure failed", zap.Error(err))
+		return createProcedureErrResult(err)
+	}
+
+	var result RebalancePartitionTableResult
+	if !ok {
+		return okResult(result)
+	}
+
+	if err := c.GetProcedureManager().Submit(ctx, rebalanceProcedure); err != nil {
+		log.Error("submit rebalance partition table procedure failed", zap.Error(err))
+		return errResult(ErrSubmitProcedure, err.Error())
+	}
+	result.Rebalanced = true
+	result.ProcedureID = rebalanceProcedure.ID()
+
+	return okResult(result)
+}
+
 func (a *API) listClusters(req *http.Request) apiFuncResult {
 	clusters, err := a.clusterManager.ListClusters(req.Context())
 	if err != nil {
 		return errResult(ErrGetCluster, err.Error())
 	}
 
+	// The requests of a tenant only see the clusters owned by it.
+	tenant, isTenant := tenantFromContext(req.Context())
 	clusterMetadatas := make([]storage.Cluster, 0, len(clusters))
 	for i := 0; i < len(clusters); i++ {
 		storageMetadata := clusters[i].GetMetadata().GetStorageMetadata()
+		if isTenant && !tenant.CanAccessCluster(storageMetadata.Name) {
+			continue
+		}
 		clusterMetadatas = append(clusterMetadatas, storageMetadata)
 	}
 
@@ -303,13 +763,36 @@ func (a *API) createCluster(req *http.Request) apiFuncResult {
 		return errResult(ErrParseRequest, err.Error())
 	}
 
-	ctx := context.Background()
+	heartbeatIntervalBounds := storage.HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0}
+	if createClusterRequest.HeartbeatIntervalBounds != nil {
+		heartbeatIntervalBounds = *createClusterRequest.HeartbeatIntervalBounds
+	}
+	quota := storage.ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0}
+	if createClusterRequest.Quota != nil {
+		quota = *createClusterRequest.Quota
+	}
+	schemaCreationPolicy := storage.SchemaCreationPolicyAuto
+	if len(createClusterRequest.SchemaCreationPolicy) > 0 {
+		schemaCreationPolicy, err = metadata.ParseSchemaCreationPolicy(createClusterRequest.SchemaCreationPolicy)
+		if err != nil {
+			return errResult(ErrParseRequest, err.Error())
+		}
+	}
+
+	ctx := req.Context()
 	createClusterOpts := metadata.CreateClusterOpts{
 		NodeCount:                   createClusterRequest.NodeCount,
 		ShardTotal:                  createClusterRequest.ShardTotal,
 		EnableSchedule:              createClusterRequest.EnableSchedule,
 		TopologyType:                topologyType,
 		ProcedureExecutingBatchSize: createClusterRequest.ProcedureExecutingBatchSize,
+		CaseInsensitiveName:         createClusterRequest.CaseInsensitiveName,
+		DefaultSchemaName:           createClusterRequest.DefaultSchemaName,
+		HeartbeatIntervalBounds:     heartbeatIntervalBounds,
+		Quota:                       quota,
+		SchemaCreationPolicy:        schemaCreationPolicy,
+		Protected:                   createClusterRequest.Protected,
+		ShardNodes:                  nil,
 	}
 	c, err := a.clusterManager.CreateCluster(ctx, createClusterRequest.Name, createClusterOpts)
 	if err != nil {
@@ -320,6 +803,44 @@ func (a *API) createCluster(req *http.Request) apiFuncResult {
 	return okResult(c.GetMetadata().GetClusterID())
 }
 
+// cloneCluster creates a cluster mirroring the metadata of the given one, which is denied to the tenants because the new
+// cluster is not owned by them.
+func (a *API) cloneCluster(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	sourceClusterName := Param(ctx, clusterNameParam)
+	if len(sourceClusterName) == 0 {
+		return errResult(ErrParseRequest, "clusterName could not be empty")
+	}
+	if tenant, isTenant := tenantFromContext(ctx); isTenant {
+		return errResult(ErrTenantAccessDenied, "tenant:"+tenant.Name)
+	}
+
+	var cloneClusterRequest CloneClusterRequest
+	if err := json.NewDecoder(req.Body).Decode(&cloneClusterRequest); err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+	if len(cloneClusterRequest.Name) == 0 {
+		return errResult(ErrParseRequest, "name could not be empty")
+	}
+
+	log.Debug("clone cluster request", zap.String("sourceClusterName", sourceClusterName), zap.String("request", fmt.Sprintf("%+v", cloneClusterRequest)))
+
+	c, result, err := a.clusterManager.CloneCluster(ctx, sourceClusterName, cloneClusterRequest.Name, metadata.CloneClusterOpts{
+		NodeCount:      cloneClusterRequest.NodeCount,
+		EnableSchedule: cloneClusterRequest.EnableSchedule,
+		WithTables:     cloneClusterRequest.WithTables,
+	})
+	if err != nil {
+		log.Error("clone cluster failed", zap.Error(err))
+		return errResult(ErrCloneCluster, err.Error())
+	}
+
+	return okResult(CloneClusterResponse{
+		ClusterID:          c.GetMetadata().GetClusterID(),
+		CloneClusterResult: result,
+	})
+}
+
 func (a *API) updateCluster(req *http.Request) apiFuncResult {
 	clusterName := Param(req.Context(), clusterNameParam)
 	if len(clusterName) == 0 {
@@ -346,17 +867,54 @@ func (a *API) updateCluster(req *http.Request) apiFuncResult {
 		return errResult(ErrParseTopology, err.Error())
 	}
 
+	heartbeatIntervalBounds := c.GetMetadata().GetHeartbeatIntervalBounds()
+	if updateClusterRequest.HeartbeatIntervalBounds != nil {
+		heartbeatIntervalBounds = *updateClusterRequest.HeartbeatIntervalBounds
+	}
+	quota := c.GetMetadata().GetQuota()
+	if updateClusterRequest.Quota != nil {
+		quota = *updateClusterRequest.Quota
+	}
+	schemaCreationPolicy := c.GetMetadata().GetSchemaCreationPolicy()
+	if len(updateClusterRequest.SchemaCreationPolicy) > 0 {
+		schemaCreationPolicy, err = metadata.ParseSchemaCreationPolicy(updateClusterRequest.SchemaCreationPolicy)
+		if err != nil {
+			return errResult(ErrParseRequest, err.Error())
+		}
+	}
+	protected := c.GetMetadata().IsProtected()
+	if updateClusterRequest.Protected != nil {
+		protected = *updateClusterRequest.Protected
+	}
+
 	if err := a.clusterManager.UpdateCluster(req.Context(), clusterName, metadata.UpdateClusterOpts{
 		TopologyType:                topologyType,
 		ProcedureExecutingBatchSize: updateClusterRequest.ProcedureExecutingBatchSize,
+		HeartbeatIntervalBounds:     heartbeatIntervalBounds,
+		Quota:                       quota,
+		SchemaCreationPolicy:        schemaCreationPolicy,
+		Protected:                   protected,
+		Confirmation:                parseConfirmation(req),
 	}); err != nil {
 		log.Error("update cluster failed", zap.Error(err))
+		if coderr.Is(err, metadata.ErrClusterProtected.Code()) {
+			return errResult(metadata.ErrClusterProtected, err.Error())
+		}
 		return errResult(metadata.ErrUpdateCluster, err.Error())
 	}
 
 	return okResult(c.GetMetadata().GetClusterID())
 }
 
+// parseConfirmation reads the confirmation forcing the destructive operation on the protected cluster from the query
+// force=true&confirm=<clusterName>.
+func parseConfirmation(req *http.Request) metadata.Confirmation {
+	return metadata.Confirmation{
+		Force: req.URL.Query().Get(forceParam) == "true",
+		Token: req.URL.Query().Get(confirmParam),
+	}
+}
+
 func (a *API) getFlowLimiter(_ *http.Request) apiFuncResult {
 	limiter := a.flowLimiter.GetConfig()
 	return okResult(limiter)
@@ -373,9 +931,10 @@ func (a *API) updateFlowLimiter(req *http.Request) apiFuncResult {
 	log.Info("update flow limiter request", zap.String("request", fmt.Sprintf("%+v", updateFlowLimiterRequest)))
 
 	newLimiterConfig := config.LimiterConfig{
-		Enable: updateFlowLimiterRequest.Enable,
-		Limit:  updateFlowLimiterRequest.Limit,
-		Burst:  updateFlowLimiterRequest.Burst,
+		Enable:        updateFlowLimiterRequest.Enable,
+		Limit:         updateFlowLimiterRequest.Limit,
+		Burst:         updateFlowLimiterRequest.Burst,
+		MethodWeights: updateFlowLimiterRequest.MethodWeights,
 	}
 
 	if err := a.flowLimiter.UpdateLimiter(newLimiterConfig); err != nil {
@@ -386,6 +945,17 @@ func (a *API) updateFlowLimiter(req *http.Request) apiFuncResult {
 	return okResult(statusSuccess)
 }
 
+// reloadConfig is served by the server receiving the request, because every server has its own config file.
+func (a *API) reloadConfig(_ *http.Request) apiFuncResult {
+	result, err := a.configReloader()
+	if err != nil {
+		log.Error("reload config failed", zap.Error(err))
+		return errResult(ErrReloadConfig, err.Error())
+	}
+
+	return okResult(result)
+}
+
 func (a *API) listProcedures(req *http.Request) apiFuncResult {
 	ctx := req.Context()
 	clusterName := Param(ctx, clusterNameParam)
@@ -441,13 +1011,25 @@ func (a *API) addShardAffinities(req *http.Request) apiFuncResult {
 		return errResult(ErrParseRequest, err.Error())
 	}
 
-	log.Info("try to apply shard affinity rule", zap.String("cluster", clusterName), zap.String("affinity", fmt.Sprintf("%+v", affinities)))
-
 	c, err := a.clusterManager.GetCluster(ctx, clusterName)
 	if err != nil {
 		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
 	}
 
+	// The affinities added with the ttl expire together, which is handy for pinning the shards during a migration.
+	if value := req.URL.Query().Get(ttlSecParam); len(value) > 0 {
+		ttlSec, err := strconv.ParseUint(value, 10, 32)
+		if err != nil || ttlSec == 0 {
+			return errResult(ErrParseRequest, fmt.Sprintf("invalid %s:%s", ttlSecParam, value))
+		}
+		expireAt := uint64(c.GetMetadata().Clock().Now().Add(time.Duration(ttlSec) * time.Second).UnixMilli())
+		for i := range affinities {
+			affinities[i].ExpireAt = expireAt
+		}
+	}
+
+	log.Info("try to apply shard affinity rule", zap.String("cluster", clusterName), zap.String("affinity", fmt.Sprintf("%+v", affinities)))
+
 	err = c.GetSchedulerManager().AddShardAffinityRule(ctx, scheduler.ShardAffinityRule{Affinities: affinities})
 	if err != nil {
 		log.Error("failed to apply shard affinity rule", zap.String("cluster", clusterName), zap.String("affinity", fmt.Sprintf("%+v", affinities)))
@@ -490,6 +1072,57 @@ func (a *API) removeShardAffinities(req *http.Request) apiFuncResult {
 	return okResult(nil)
 }
 
+func (a *API) exportShardAffinities(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		return errResult(ErrParseRequest, "clusterName could not be empty")
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	rule, err := c.GetSchedulerManager().ExportShardAffinityRule(ctx)
+	if err != nil {
+		return errResult(ErrListAffinityRules, fmt.Sprintf("err: %v", err))
+	}
+
+	return okResult(ShardAffinityDocument{Affinities: rule.Affinities})
+}
+
+func (a *API) importShardAffinities(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		return errResult(ErrParseRequest, "clusterName could not be empty")
+	}
+
+	var importReq ImportShardAffinitiesRequest
+	if err := json.NewDecoder(req.Body).Decode(&importReq); err != nil {
+		log.Error("decode request body failed", zap.Error(err))
+		return errResult(ErrParseRequest, err.Error())
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	log.Debug("try to import shard affinity rule", zap.String("cluster", clusterName), zap.Bool("dryRun", importReq.DryRun), zap.String("affinity", fmt.Sprintf("%+v", importReq.Affinities)))
+	diff, err := c.GetSchedulerManager().ImportShardAffinityRule(ctx, scheduler.ShardAffinityRule{Affinities: importReq.Affinities}, importReq.DryRun)
+	if err != nil {
+		log.Error("failed to import shard affinity rule", zap.String("cluster", clusterName), zap.Error(err))
+		return errResult(ErrImportAffinityRule, fmt.Sprintf("err: %v", err))
+	}
+
+	return okResult(ImportShardAffinitiesResult{
+		DryRun: importReq.DryRun,
+		Diff:   diff,
+	})
+}
+
 func (a *API) queryTable(r *http.Request) apiFuncResult {
 	var req QueryTableRequest
 	err := json.NewDecoder(r.Body).Decode(&req)
@@ -497,6 +1130,10 @@ func (a *API) queryTable(r *http.Request) apiFuncResult {
 		return errResult(ErrParseRequest, err.Error())
 	}
 
+	if len(req.Prefix) != 0 || len(req.Regex) != 0 {
+		return a.searchTables(req)
+	}
+
 	if len(req.Names) != 0 {
 		tables, err := a.clusterManager.GetTables(req.ClusterName, req.SchemaName, req.Names)
 		if err != nil {
@@ -517,27 +1154,684 @@ func (a *API) queryTable(r *http.Request) apiFuncResult {
 	return okResult(tables)
 }
 
-func (a *API) getEnableSchedule(r *http.Request) apiFuncResult {
-	ctx := r.Context()
-	clusterName := Param(ctx, clusterNameParam)
-	if len(clusterName) == 0 {
-		clusterName = config.DefaultClusterName
+func (a *API) searchTables(req QueryTableRequest) apiFuncResult {
+	if len(req.SchemaName) == 0 {
+		return errResult(ErrParseRequest, "schemaName could not be empty when searching tables")
+	}
+	if len(req.Regex) > maxSearchTablesRegexLen {
+		return errResult(ErrParseRequest, fmt.Sprintf("regex is longer than %d", maxSearchTablesRegexLen))
 	}
 
-	c, err := a.clusterManager.GetCluster(ctx, clusterName)
-	if err != nil {
-		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	var pattern *regexp.Regexp
+	if len(req.Regex) != 0 {
+		compiled, err := regexp.Compile(req.Regex)
+		if err != nil {
+			return errResult(ErrParseRequest, fmt.Sprintf("invalid regex, err:%s", err.Error()))
+		}
+		pattern = compiled
 	}
 
-	enableSchedule, err := c.GetSchedulerManager().GetEnableSchedule(r.Context())
-	if err != nil {
+	limit := defaultSearchTablesLimit
+	if req.Limit > 0 {
+		limit = min(int(req.Limit), maxSearchTablesLimit)
+	}
+
+	tables, truncated, err := a.clusterManager.SearchTables(req.ClusterName, metadata.SearchTablesRequest{
+		SchemaName: req.SchemaName,
+		Prefix:     req.Prefix,
+		Pattern:    pattern,
+		Limit:      limit,
+	})
+	if err != nil {
+		return errResult(ErrTable, err.Error())
+	}
+	return okResult(SearchTablesResponse{
+		Tables:    tables,
+		Truncated: truncated,
+	})
+}
+
+func (a *API) getEnableSchedule(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	enableSchedule, err := c.GetSchedulerManager().GetEnableSchedule(r.Context())
+	if err != nil {
 		return errResult(ErrGetEnableSchedule, err.Error())
 	}
 
-	return okResult(enableSchedule)
+	return okResult(enableSchedule)
+}
+
+func (a *API) updateEnableSchedule(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	var req UpdateEnableScheduleRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+
+	err = c.GetSchedulerManager().UpdateEnableSchedule(r.Context(), req.Enable)
+	if err != nil {
+		return errResult(ErrUpdateEnableSchedule, err.Error())
+	}
+
+	return okResult(req.Enable)
+}
+
+func (a *API) listSchedulers(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	return okResult(c.GetSchedulerManager().ListSchedulerStatus(ctx))
+}
+
+func (a *API) updateSchedulerEnabled(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+	schedulerName := Param(ctx, schedulerParam)
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	var req UpdateSchedulerEnabledRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+
+	if err := c.GetSchedulerManager().UpdateSchedulerEnabled(ctx, schedulerName, req.Enable); err != nil {
+		log.Error("update scheduler enabled failed", zap.String("scheduler", schedulerName), zap.Bool("enable", req.Enable), zap.Error(err))
+		if coderr.Is(err, manager.ErrInvalidSchedulerConfig.Code()) {
+			return errResult(ErrInvalidSchedulerConfig, err.Error())
+		}
+		return errResult(ErrUpdateSchedulerEnabled, err.Error())
+	}
+
+	return okResult(c.GetSchedulerManager().ListSchedulerStatus(ctx))
+}
+
+// listSchedulerDecisions lists the latest rounds of scheduling of the cluster, the latest first.
+func (a *API) listSchedulerDecisions(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	limit := defaultSchedulerDecisionsLimit
+	if value := r.URL.Query().Get(limitParam); len(value) > 0 {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return errResult(ErrParseRequest, fmt.Sprintf("invalid %s:%s", limitParam, value))
+		}
+		limit = parsed
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	return okResult(c.GetSchedulerManager().ListSchedulerDecisions(ctx, limit))
+}
+
+// getRebalanceWindowStatus tells whether the shards of the cluster are allowed to be moved for balance now.
+func (a *API) getRebalanceWindowStatus(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	return okResult(c.GetSchedulerManager().GetRebalanceWindowStatus(ctx))
+}
+
+// listClusterEvents lists the events of the cluster after the given id, the oldest first, so the events can be polled
+// by passing the returned lastID as the next since.
+func (a *API) listClusterEvents(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	var since uint64
+	if value := r.URL.Query().Get(sinceParam); len(value) > 0 {
+		parsed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return errResult(ErrParseRequest, fmt.Sprintf("invalid %s:%s", sinceParam, value))
+		}
+		since = parsed
+	}
+	limit := defaultEventsLimit
+	if value := r.URL.Query().Get(limitParam); len(value) > 0 {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return errResult(ErrParseRequest, fmt.Sprintf("invalid %s:%s", limitParam, value))
+		}
+		limit = min(parsed, maxEventsLimit)
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	return okResult(c.GetEventBus().List(since, limit))
+}
+
+func (a *API) listSchemas(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	return okResult(c.GetMetadata().ListSchemaInfos())
+}
+
+func (a *API) getSchema(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+	schemaName := Param(ctx, schemaNameParam)
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	info, ok := c.GetMetadata().GetSchemaInfo(schemaName)
+	if !ok {
+		return errResult(metadata.ErrSchemaNotFound, fmt.Sprintf("clusterName: %s, schemaName: %s", clusterName, schemaName))
+	}
+	return okResult(info)
+}
+
+func (a *API) listSchemaSettings(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	return okResult(c.GetMetadata().ListSchemaSettings())
+}
+
+func (a *API) getSchemaSettings(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+	schemaName := Param(ctx, schemaNameParam)
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	// The schema without settings has no quota and no defaults, which is the same as the zero settings.
+	settings, _ := c.GetMetadata().GetSchemaSettings(schemaName)
+	return okResult(settings)
+}
+
+func (a *API) updateSchemaSettings(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+	schemaName := Param(ctx, schemaNameParam)
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	var req UpdateSchemaSettingsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+	log.Debug("update schema settings request", zap.String("clusterName", clusterName), zap.String("schemaName", schemaName), zap.String("request", fmt.Sprintf("%+v", req)))
+
+	settings := storage.SchemaSettings{
+		MaxTableCount:       req.MaxTableCount,
+		DefaultTableOptions: req.DefaultTableOptions,
+		ShardPickingPolicy:  storage.ShardPickingPolicy(req.ShardPickingPolicy),
+	}
+	if err := c.GetMetadata().UpdateSchemaSettings(ctx, schemaName, settings); err != nil {
+		log.Error("update schema settings failed", zap.Error(err))
+		if coderr.Is(err, metadata.ErrInvalidSchemaSettings.Code()) {
+			return errResult(ErrInvalidSchemaSettings, err.Error())
+		}
+		return errResult(ErrSchemaSettings, err.Error())
+	}
+
+	return okResult(settings)
+}
+
+func (a *API) deleteSchemaSettings(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+	schemaName := Param(ctx, schemaNameParam)
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	if err := c.GetMetadata().DeleteSchemaSettings(ctx, schemaName); err != nil {
+		log.Error("delete schema settings failed", zap.Error(err))
+		return errResult(ErrSchemaSettings, err.Error())
+	}
+
+	return okResult(statusSuccess)
+}
+
+func (a *API) getSchedulerConfig(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	return okResult(c.GetSchedulerManager().GetSchedulerConfig(ctx))
+}
+
+func (a *API) updateSchedulerConfig(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	var req UpdateSchedulerConfigRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+	log.Debug("update scheduler config request", zap.String("clusterName", clusterName), zap.String("request", fmt.Sprintf("%+v", req)))
+
+	disabledSchedulers := req.DisabledSchedulers
+	if disabledSchedulers == nil {
+		disabledSchedulers = []string{}
+	}
+	currentConfig := c.GetSchedulerManager().GetSchedulerConfig(ctx)
+	shardOperationThrottle := currentConfig.ShardOperationThrottle
+	if req.ShardOperationThrottle != nil {
+		shardOperationThrottle = *req.ShardOperationThrottle
+	}
+	nodeShardCapacity := currentConfig.NodeShardCapacity
+	if req.NodeShardCapacity != nil {
+		nodeShardCapacity = *req.NodeShardCapacity
+	}
+	rebalanceWindows := currentConfig.RebalanceWindows
+	if req.RebalanceWindows != nil {
+		rebalanceWindows = req.RebalanceWindows
+	}
+	hotSpot := currentConfig.HotSpot
+	if req.HotSpot != nil {
+		hotSpot = *req.HotSpot
+	}
+	shardMoveCooldownMs := currentConfig.ShardMoveCooldownMs
+	if req.ShardMoveCooldownMs != nil {
+		shardMoveCooldownMs = *req.ShardMoveCooldownMs
+	}
+	schedulerConfig := manager.SchedulerConfig{
+		IntervalMs:             req.IntervalMs,
+		MaxProceduresPerTick:   req.MaxProceduresPerTick,
+		DisabledSchedulers:     disabledSchedulers,
+		NodeShortfallWebhook:   req.NodeShortfallWebhook,
+		ShardOperationThrottle: shardOperationThrottle,
+		NodeShardCapacity:      nodeShardCapacity,
+		RebalanceWindows:       rebalanceWindows,
+		HotSpot:                hotSpot,
+		ShardMoveCooldownMs:    shardMoveCooldownMs,
+	}
+	if err := c.GetSchedulerManager().UpdateSchedulerConfig(ctx, schedulerConfig); err != nil {
+		log.Error("update scheduler config failed", zap.Error(err))
+		if coderr.Is(err, manager.ErrInvalidSchedulerConfig.Code()) {
+			return errResult(ErrInvalidSchedulerConfig, err.Error())
+		}
+		return errResult(ErrUpdateSchedulerConfig, err.Error())
+	}
+
+	return okResult(schedulerConfig)
+}
+
+func (a *API) listRegistrationTokens(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		return errResult(ErrParseRequest, "clusterName could not be empty")
+	}
+
+	tokens, err := a.clusterManager.ListRegistrationTokens(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrRegistrationToken, err.Error())
+	}
+
+	tokenInfos := make([]RegistrationTokenInfo, 0, len(tokens))
+	for _, token := range tokens {
+		tokenInfos = append(tokenInfos, RegistrationTokenInfo{
+			ID:        token.ID,
+			CreatedAt: token.CreatedAt,
+		})
+	}
+	return okResult(tokenInfos)
+}
+
+func (a *API) createRegistrationToken(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		return errResult(ErrParseRequest, "clusterName could not be empty")
+	}
+
+	token, presentedToken, err := a.clusterManager.CreateRegistrationToken(ctx, clusterName)
+	if err != nil {
+		log.Error("create registration token failed", zap.String("clusterName", clusterName), zap.Error(err))
+		return errResult(ErrRegistrationToken, err.Error())
+	}
+
+	return okResult(CreateRegistrationTokenResponse{
+		ID:        token.ID,
+		Token:     presentedToken,
+		CreatedAt: token.CreatedAt,
+	})
+}
+
+func (a *API) revokeRegistrationToken(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	tokenID := Param(ctx, tokenIDParam)
+	if len(clusterName) == 0 || len(tokenID) == 0 {
+		return errResult(ErrParseRequest, "clusterName and tokenID could not be empty")
+	}
+
+	if err := a.clusterManager.RevokeRegistrationToken(ctx, clusterName, tokenID); err != nil {
+		log.Error("revoke registration token failed", zap.String("clusterName", clusterName), zap.String("tokenID", tokenID), zap.Error(err))
+		return errResult(ErrRegistrationToken, err.Error())
+	}
+
+	return okResult(tokenID)
+}
+
+func (a *API) getClusterTopology(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		return errResult(ErrParseRequest, "clusterName could not be empty")
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	return okResult(buildClusterTopology(c.GetMetadata().Name(), c.GetMetadata().GetTopologyType(), c.GetMetadata().GetClusterSnapshot()))
+}
+
+// buildClusterTopology converts the snapshot to the topology, and all the parts come from the same snapshot so that
+// they are consistent with each other.
+func buildClusterTopology(clusterName string, topologyType storage.TopologyType, snapshot metadata.Snapshot) ClusterTopology {
+	clusterView := snapshot.Topology.ClusterView
+
+	shardNodes := make([]TopologyShardNode, 0, len(clusterView.ShardNodes))
+	for _, shardNode := range clusterView.ShardNodes {
+		shardNodes = append(shardNodes, TopologyShardNode{
+			ShardID:  shardNode.ID,
+			Role:     storage.ConvertShardRoleToString(shardNode.ShardRole),
+			NodeName: shardNode.NodeName,
+		})
+	}
+	sort.Slice(shardNodes, func(i, j int) bool {
+		return shardNodes[i].ShardID < shardNodes[j].ShardID
+	})
+
+	shardViews := make([]TopologyShardView, 0, len(snapshot.Topology.ShardViewsMapping))
+	for _, shardView := range snapshot.Topology.ShardViewsMapping {
+		shardViews = append(shardViews, TopologyShardView{
+			ShardID:   shardView.ShardID,
+			Version:   shardView.Version,
+			TableIDs:  shardView.TableIDs,
+			CreatedAt: shardView.CreatedAt,
+		})
+	}
+	sort.Slice(shardViews, func(i, j int) bool {
+		return shardViews[i].ShardID < shardViews[j].ShardID
+	})
+
+	nodes := make([]TopologyNode, 0, len(snapshot.RegisteredNodes))
+	for _, registeredNode := range snapshot.RegisteredNodes {
+		shards := make([]TopologyNodeShard, 0, len(registeredNode.ShardInfos))
+		for _, shardInfo := range registeredNode.ShardInfos {
+			shards = append(shards, TopologyNodeShard{
+				ShardID: shardInfo.ID,
+				Role:    storage.ConvertShardRoleToString(shardInfo.Role),
+				Version: shardInfo.Version,
+				Status:  storage.ConvertShardStatusToString(shardInfo.Status),
+			})
+		}
+		sort.Slice(shards, func(i, j int) bool {
+			return shards[i].ShardID < shards[j].ShardID
+		})
+
+		nodes = append(nodes, TopologyNode{
+			Name:          registeredNode.Node.Name,
+			State:         storage.ConvertNodeStateToString(registeredNode.Node.State),
+			Zone:          registeredNode.Node.NodeStats.Zone,
+			NodeVersion:   registeredNode.Node.NodeStats.NodeVersion,
+			LastTouchTime: registeredNode.Node.LastTouchTime,
+			Shards:        shards,
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	return ClusterTopology{
+		ClusterName:    clusterName,
+		ClusterID:      clusterView.ClusterID,
+		TopologyType:   string(topologyType),
+		ClusterState:   storage.ConvertClusterStateToString(clusterView.State),
+		ClusterVersion: clusterView.Version,
+		ShardNodes:     shardNodes,
+		ShardViews:     shardViews,
+		Nodes:          nodes,
+	}
+}
+
+// listNodes lists the registered nodes with their liveness, and the nodes are judged by the same expiry as the schedulers
+// unless a stale threshold is given.
+func (a *API) listNodes(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	var threshold time.Duration
+	if value := req.URL.Query().Get(staleThresholdMsParam); len(value) > 0 {
+		thresholdMs, err := strconv.ParseUint(value, 10, 63)
+		if err != nil {
+			return errResult(ErrParseRequest, fmt.Sprintf("invalid %s, err: %s", staleThresholdMsParam, err.Error()))
+		}
+		threshold = time.Duration(thresholdMs) * time.Millisecond
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	now := c.GetMetadata().Clock().Now()
+	registeredNodes := c.GetMetadata().GetRegisteredNodes()
+	nodes := make([]ClusterNode, 0, len(registeredNodes))
+	for _, registeredNode := range registeredNodes {
+		leaderShardCount, followerShardCount := 0, 0
+		for _, shardInfo := range registeredNode.ShardInfos {
+			switch shardInfo.Role {
+			case storage.ShardRoleLeader:
+				leaderShardCount++
+			case storage.ShardRoleFollower:
+				followerShardCount++
+			}
+		}
+
+		heartbeatAge := now.Sub(time.UnixMilli(int64(registeredNode.Node.LastTouchTime)))
+		nodeThreshold := threshold
+		if nodeThreshold == 0 {
+			nodeThreshold = registeredNode.ExpiredThreshold()
+		}
+		nodes = append(nodes, ClusterNode{
+			Name:               registeredNode.Node.Name,
+			State:              storage.ConvertNodeStateToString(registeredNode.Node.State),
+			Zone:               registeredNode.Node.NodeStats.Zone,
+			NodeVersion:        registeredNode.Node.NodeStats.NodeVersion,
+			ProtocolVersion:    registeredNode.Node.NodeStats.ProtocolVersion,
+			LastTouchTime:      registeredNode.Node.LastTouchTime,
+			HeartbeatAgeMs:     heartbeatAge.Milliseconds(),
+			Stale:              heartbeatAge > nodeThreshold,
+			LeaderShardCount:   leaderShardCount,
+			FollowerShardCount: followerShardCount,
+			Heartbeat:          registeredNode.Heartbeat,
+			Cordoned:           c.GetMetadata().IsNodeCordoned(registeredNode.Node.Name),
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Name < nodes[j].Name
+	})
+
+	return okResult(ListNodesResult{
+		StaleThresholdMs: threshold.Milliseconds(),
+		Nodes:            nodes,
+	})
+}
+
+// cordonNode marks the node unschedulable, so the schedulers assign no new shards to it while the shards on it stay.
+func (a *API) cordonNode(req *http.Request) apiFuncResult {
+	return a.updateNodeCordon(req, true)
+}
+
+func (a *API) uncordonNode(req *http.Request) apiFuncResult {
+	return a.updateNodeCordon(req, false)
+}
+
+func (a *API) updateNodeCordon(req *http.Request, cordon bool) apiFuncResult {
+	ctx := req.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+	nodeName := Param(ctx, nodeNameParam)
+	if len(nodeName) == 0 {
+		return errResult(ErrParseRequest, "nodeName could not be empty")
+	}
+	log.Info("update node cordon request", zap.String("clusterName", clusterName), zap.String("nodeName", nodeName), zap.Bool("cordon", cordon))
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	if cordon {
+		err = c.GetMetadata().CordonNode(ctx, nodeName)
+	} else {
+		err = c.GetMetadata().UncordonNode(ctx, nodeName)
+	}
+	if err != nil {
+		log.Error("update node cordon failed", zap.String("nodeName", nodeName), zap.Bool("cordon", cordon), zap.Error(err))
+		if coderr.Is(err, metadata.ErrNodeNotFound.Code()) {
+			return errResult(ErrNodeNotFound, err.Error())
+		}
+		return errResult(ErrCordonNode, err.Error())
+	}
+
+	return okResult(CordonNodeResult{
+		NodeName:      nodeName,
+		Cordoned:      cordon,
+		CordonedNodes: c.GetMetadata().GetCordonedNodes(),
+	})
+}
+
+func (a *API) getNodeShortfall(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	return okResult(c.GetMetadata().GetNodeShortfall())
 }
 
-func (a *API) updateEnableSchedule(r *http.Request) apiFuncResult {
+func (a *API) getClusterStats(r *http.Request) apiFuncResult {
 	ctx := r.Context()
 	clusterName := Param(ctx, clusterNameParam)
 	if len(clusterName) == 0 {
@@ -549,18 +1843,46 @@ func (a *API) updateEnableSchedule(r *http.Request) apiFuncResult {
 		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
 	}
 
-	var req UpdateEnableScheduleRequest
-	err = json.NewDecoder(r.Body).Decode(&req)
+	return okResult(c.GetTableStats().Get())
+}
+
+func (a *API) fsck(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
 	if err != nil {
-		return errResult(ErrParseRequest, err.Error())
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
 	}
 
-	err = c.GetSchedulerManager().UpdateEnableSchedule(r.Context(), req.Enable)
+	report, err := c.GetMetadata().Fsck(ctx)
 	if err != nil {
-		return errResult(ErrUpdateEnableSchedule, err.Error())
+		log.Error("check cluster metadata failed", zap.String("clusterName", clusterName), zap.Error(err))
+		return errResult(ErrFsck, err.Error())
+	}
+	if len(report.Violations) > 0 {
+		log.Warn("cluster metadata is inconsistent", zap.String("clusterName", clusterName), zap.Int("violationCount", len(report.Violations)))
 	}
 
-	return okResult(req.Enable)
+	return okResult(report)
+}
+
+func (a *API) getMemoryStats(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	return okResult(c.GetMetadata().GetMemoryStats())
 }
 
 func (a *API) diagnoseShards(req *http.Request) apiFuncResult {
@@ -583,12 +1905,24 @@ func (a *API) diagnoseShards(req *http.Request) apiFuncResult {
 	ret := DiagnoseShardResult{
 		UnregisteredShards: []storage.ShardID{},
 		UnreadyShards:      make(map[storage.ShardID]DiagnoseShardStatus),
+		InconsistentShards: nil,
+		OrphanShards:       nil,
+		UnreachableShards:  nil,
+		IncompatibleNodes:  make(map[string]DiagnoseNodeVersion),
+		HotShards:          nil,
+		SplitSuggestions:   nil,
 	}
 	shards := c.GetShards()
 
 	registeredShards := make(map[storage.ShardID]struct{}, len(shards))
-	// Check if there are unready shards.
+	// Check if there are unready shards and incompatible nodes.
 	for _, node := range registeredNodes {
+		if !service.IsProtocolVersionSupported(node.Node.NodeStats.ProtocolVersion) {
+			ret.IncompatibleNodes[node.Node.Name] = DiagnoseNodeVersion{
+				NodeVersion:     node.Node.NodeStats.NodeVersion,
+				ProtocolVersion: node.Node.NodeStats.ProtocolVersion,
+			}
+		}
 		for _, shardInfo := range node.ShardInfos {
 			if shardInfo.Status != storage.ShardStatusReady {
 				ret.UnreadyShards[shardInfo.ID] = DiagnoseShardStatus{
@@ -607,15 +1941,411 @@ func (a *API) diagnoseShards(req *http.Request) apiFuncResult {
 		}
 	}
 
+	// Check if there are hot shards by the loads reported.
+	hotSpotReport := hotspot.Analyze(c.GetSchedulerManager().GetSchedulerConfig(ctx).HotSpot, c.GetMetadata().GetClusterSnapshot(), c.GetMetadata().GetShardTables(shards), c.GetTableStats())
+	ret.HotShards, ret.SplitSuggestions = hotSpotReport.HotShards, hotSpotReport.Suggestions
+
+	if req.URL.Query().Get(deepParam) == "true" {
+		ret.InconsistentShards, ret.OrphanShards, ret.UnreachableShards = diagnoseShardTables(c.GetMetadata(), c.GetMetadata().Clock().Now())
+	}
+
+	return okResult(ret)
+}
+
+// diagnoseShardTables compares the shard views in meta with the shards reported by the nodes in their heartbeats, because
+// the nodes provide no way to list the tables they open. The tables added to a shard after the version reported by its
+// leader node are the ones missing on the node, and the tables opened on the node but absent in meta can't be found.
+// The orphan tables are the ones left in the shard views in meta without their metadata.
+func diagnoseShardTables(clusterMetadata *metadata.ClusterMetadata, now time.Time) (map[storage.ShardID]DiagnoseShardTables, map[storage.ShardID][]string, map[storage.ShardID]string) {
+	inconsistentShards := make(map[storage.ShardID]DiagnoseShardTables)
+	orphanShards := make(map[storage.ShardID][]string)
+	unreachableShards := make(map[storage.ShardID]string)
+
+	snapshot := clusterMetadata.GetClusterSnapshot()
+	registeredNodes := make(map[string]metadata.RegisteredNode, len(snapshot.RegisteredNodes))
+	for _, node := range snapshot.RegisteredNodes {
+		registeredNodes[node.Node.Name] = node
+	}
+
+	leaders := make(map[storage.ShardID]string, len(snapshot.Topology.ShardViewsMapping))
+	for _, shardNode := range snapshot.Topology.ClusterView.ShardNodes {
+		if shardNode.ShardRole == storage.ShardRoleLeader {
+			leaders[shardNode.ID] = shardNode.NodeName
+		}
+	}
+	shardIDs := make([]storage.ShardID, 0, len(leaders))
+	for shardID := range leaders {
+		shardIDs = append(shardIDs, shardID)
+	}
+	shardTables := clusterMetadata.GetShardTables(shardIDs)
+
+	for shardID, nodeName := range leaders {
+		node, ok := registeredNodes[nodeName]
+		if !ok {
+			unreachableShards[shardID] = fmt.Sprintf("leader node %s is not registered", nodeName)
+			continue
+		}
+		if node.IsExpired(now) {
+			unreachableShards[shardID] = fmt.Sprintf("heartbeat of leader node %s is expired", nodeName)
+			continue
+		}
+
+		shardView := snapshot.Topology.ShardViewsMapping[shardID]
+		diff := DiagnoseShardTables{
+			NodeName:       nodeName,
+			Reported:       false,
+			NodeRole:       "",
+			MissingTables:  []DiagnoseTable{},
+			OrphanTableIDs: []storage.TableID{},
+			MetaVersion:    shardView.Version,
+			NodeVersion:    0,
+		}
+		for _, shardInfo := range node.ShardInfos {
+			if shardInfo.ID == shardID {
+				diff.Reported = true
+				diff.NodeRole = storage.ConvertShardRoleToString(shardInfo.Role)
+				diff.NodeVersion = shardInfo.Version
+				break
+			}
+		}
+
+		tableIDs := make(map[storage.TableID]struct{}, len(shardTables[shardID].Tables))
+		for _, table := range shardTables[shardID].Tables {
+			tableIDs[table.ID] = struct{}{}
+			if !diff.Reported || shardView.TableVersions[table.ID] > diff.NodeVersion {
+				diff.MissingTables = append(diff.MissingTables, DiagnoseTable{ID: table.ID, Name: table.Name})
+			}
+		}
+		for _, tableID := range shardView.TableIDs {
+			if _, ok := tableIDs[tableID]; !ok {
+				diff.OrphanTableIDs = append(diff.OrphanTableIDs, tableID)
+			}
+		}
+
+		if !diff.Reported || diff.NodeRole != storage.ConvertShardRoleToString(storage.ShardRoleLeader) || diff.MetaVersion != diff.NodeVersion || len(diff.MissingTables) > 0 || len(diff.OrphanTableIDs) > 0 {
+			inconsistentShards[shardID] = diff
+		}
+	}
+
+	for _, node := range snapshot.RegisteredNodes {
+		if node.IsExpired(now) {
+			continue
+		}
+		for _, shardInfo := range node.ShardInfos {
+			if shardInfo.Role == storage.ShardRoleLeader && leaders[shardInfo.ID] != node.Node.Name {
+				orphanShards[shardInfo.ID] = append(orphanShards[shardInfo.ID], node.Node.Name)
+			}
+		}
+	}
+
+	return inconsistentShards, orphanShards, unreachableShards
+}
+
+func (a *API) repairShards(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		return errResult(ErrParseRequest, "clusterName could not be empty")
+	}
+
+	var repairShardsRequest RepairShardsRequest
+	if err := json.NewDecoder(req.Body).Decode(&repairShardsRequest); err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+	log.Debug("repair shards request", zap.String("clusterName", clusterName), zap.String("request", fmt.Sprintf("%+v", repairShardsRequest)))
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	snapshot := c.GetMetadata().GetClusterSnapshot()
+	ret := RepairShardsResult{
+		ProcedureID: 0,
+		Actions:     repair.Plan(snapshot),
+		Results:     []repair.ActionResult{},
+	}
+	if repairShardsRequest.DryRun || len(ret.Actions) == 0 {
+		return okResult(ret)
+	}
+
+	resultCh := make(chan []repair.ActionResult, 1)
+	repairProcedure, err := c.GetProcedureFactory().CreateRepairShardsProcedure(ctx, coordinator.RepairShardsRequest{
+		ClusterMetadata: c.GetMetadata(),
+		Snapshot:        snapshot,
+		Actions:         ret.Actions,
+		Confirmation:    parseConfirmation(req),
+		OnFinished: func(results []repair.ActionResult) error {
+			resultCh <- results
+			return nil
+		},
+	})
+	if err != nil {
+		log.Error("create repair shards procedure failed", zap.Error(err))
+		if coderr.Is(err, metadata.ErrClusterProtected.Code()) {
+			return errResult(metadata.ErrClusterProtected, err.Error())
+		}
+		return errResult(ErrCreateProcedure, err.Error())
+	}
+	if err := c.GetProcedureManager().Submit(ctx, repairProcedure); err != nil {
+		log.Error("submit repair shards procedure failed", zap.Error(err))
+		return errResult(ErrSubmitProcedure, err.Error())
+	}
+	ret.ProcedureID = repairProcedure.ID()
+
+	select {
+	case ret.Results = <-resultCh:
+		return okResult(ret)
+	case <-ctx.Done():
+		return errResult(ErrRepairShards, fmt.Sprintf("wait for repair shards procedure, procedureID: %d, err: %s", ret.ProcedureID, ctx.Err()))
+	}
+}
+
+// failoverShard promotes an online follower of the shard to be the leader.
+func (a *API) failoverShard(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		return errResult(ErrParseRequest, "clusterName could not be empty")
+	}
+	shardID, err := strconv.ParseUint(Param(ctx, shardIDParam), 10, 32)
+	if err != nil {
+		return errResult(ErrParseRequest, fmt.Sprintf("invalid shardID, err: %s", err.Error()))
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	snapshot := c.GetMetadata().GetClusterSnapshot()
+	oldLeaderNodeName, newLeaderNodeName, err := failover.PickNewLeader(snapshot, storage.ShardID(shardID), c.GetMetadata().Clock().Now())
+	if err != nil {
+		return errResult(ErrCreateProcedure, err.Error())
+	}
+	log.Info("failover shard request", zap.String("clusterName", clusterName), zap.Uint64("shardID", shardID), zap.String("oldLeader", oldLeaderNodeName), zap.String("newLeader", newLeaderNodeName))
+
+	p, err := c.GetProcedureFactory().CreateFailoverProcedure(ctx, coordinator.FailoverRequest{
+		ClusterMetadata:   c.GetMetadata(),
+		Snapshot:          snapshot,
+		ShardID:           storage.ShardID(shardID),
+		OldLeaderNodeName: oldLeaderNodeName,
+		NewLeaderNodeName: newLeaderNodeName,
+	})
+	if err != nil {
+		log.Error("create failover procedure failed", zap.Error(err))
+		return errResult(ErrCreateProcedure, err.Error())
+	}
+	if err := c.GetProcedureManager().Submit(ctx, p); err != nil {
+		log.Error("submit failover procedure failed", zap.Error(err))
+		return errResult(ErrSubmitProcedure, err.Error())
+	}
+
+	return okResult(FailoverShardResult{
+		ProcedureID:       p.ID(),
+		OldLeaderNodeName: oldLeaderNodeName,
+		NewLeaderNodeName: newLeaderNodeName,
+	})
+}
+
+// migrateTopology converts the cluster to the other topology type, and the running schedulers are replaced once the
+// topology type is persisted.
+func (a *API) getClusterState(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	return okResult(c.GetMetadata().GetClusterStateStatus())
+}
+
+func (a *API) transitClusterState(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		return errResult(ErrParseRequest, "clusterName could not be empty")
+	}
+
+	var transitRequest TransitClusterStateRequest
+	if err := json.NewDecoder(req.Body).Decode(&transitRequest); err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+	state, err := metadata.ParseClusterState(transitRequest.State)
+	if err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+	log.Debug("transit cluster state request", zap.String("clusterName", clusterName), zap.String("request", fmt.Sprintf("%+v", transitRequest)))
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	reason := transitRequest.Reason
+	if len(reason) == 0 {
+		reason = "operator override"
+	}
+	if err := c.GetMetadata().TransitClusterState(ctx, state, transitRequest.Force, reason); err != nil {
+		log.Error("transit cluster state failed", zap.Error(err))
+		return errResult(ErrTransitClusterState, err.Error())
+	}
+
+	return okResult(c.GetMetadata().GetClusterStateStatus())
+}
+
+func (a *API) migrateTopology(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		return errResult(ErrParseRequest, "clusterName could not be empty")
+	}
+
+	var migrateTopologyRequest MigrateTopologyRequest
+	if err := json.NewDecoder(req.Body).Decode(&migrateTopologyRequest); err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+	topologyType, err := metadata.ParseTopologyType(migrateTopologyRequest.TopologyType)
+	if err != nil {
+		return errResult(ErrParseTopology, err.Error())
+	}
+	log.Debug("migrate topology request", zap.String("clusterName", clusterName), zap.String("request", fmt.Sprintf("%+v", migrateTopologyRequest)))
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	snapshot := c.GetMetadata().GetClusterSnapshot()
+	ret := MigrateTopologyResult{
+		ProcedureID:          0,
+		PreviousTopologyType: string(c.GetMetadata().GetTopologyType()),
+		TopologyType:         string(topologyType),
+	}
+	if migrateTopologyRequest.DryRun {
+		if err := migratetopology.Validate(snapshot, c.GetMetadata().GetTopologyType(), topologyType, c.GetMetadata().GetClusterMinNodeCount(), c.GetMetadata().Clock().Now()); err != nil {
+			return errResult(ErrCreateProcedure, err.Error())
+		}
+		return okResult(ret)
+	}
+
+	p, err := c.GetProcedureFactory().CreateMigrateTopologyProcedure(ctx, coordinator.MigrateTopologyRequest{
+		ClusterMetadata: c.GetMetadata(),
+		Snapshot:        snapshot,
+		TopologyType:    topologyType,
+		Confirmation:    parseConfirmation(req),
+		OnSwitched:      c.GetSchedulerManager().UpdateTopologyType,
+	})
+	if err != nil {
+		log.Error("create migrate topology procedure failed", zap.Error(err))
+		if coderr.Is(err, metadata.ErrClusterProtected.Code()) {
+			return errResult(metadata.ErrClusterProtected, err.Error())
+		}
+		return errResult(ErrCreateProcedure, err.Error())
+	}
+	if err := c.GetProcedureManager().Submit(ctx, p); err != nil {
+		log.Error("submit migrate topology procedure failed", zap.Error(err))
+		return errResult(ErrSubmitProcedure, err.Error())
+	}
+	ret.ProcedureID = p.ID()
+
+	return okResult(ret)
+}
+
+// reshard changes the shard total of the cluster, and the progress is reported by the steps of the returned procedure.
+func (a *API) reshard(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		return errResult(ErrParseRequest, "clusterName could not be empty")
+	}
+
+	var reshardRequest ReshardRequest
+	if err := json.NewDecoder(req.Body).Decode(&reshardRequest); err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+	log.Debug("reshard request", zap.String("clusterName", clusterName), zap.String("request", fmt.Sprintf("%+v", reshardRequest)))
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	snapshot := c.GetMetadata().GetClusterSnapshot()
+	plan, err := reshard.BuildPlan(snapshot, c.GetMetadata().GetTotalShardNum(), reshardRequest.ShardTotal, c.GetMetadata().Clock().Now())
+	if err != nil {
+		return errResult(ErrCreateProcedure, err.Error())
+	}
+	newShardNodes := make([]TopologyShardNode, 0, len(plan.NewShardNodes))
+	for _, shardNode := range plan.NewShardNodes {
+		newShardNodes = append(newShardNodes, TopologyShardNode{
+			ShardID:  shardNode.ID,
+			Role:     storage.ConvertShardRoleToString(shardNode.ShardRole),
+			NodeName: shardNode.NodeName,
+		})
+	}
+	ret := ReshardResult{
+		ProcedureID:        0,
+		PreviousShardTotal: plan.ShardTotal,
+		ShardTotal:         plan.NewShardTotal,
+		NewShardNodes:      newShardNodes,
+		DrainShardIDs:      plan.DrainShardIDs,
+	}
+	if reshardRequest.DryRun {
+		return okResult(ret)
+	}
+
+	p, err := c.GetProcedureFactory().CreateReshardProcedure(ctx, coordinator.ReshardRequest{
+		ClusterMetadata: c.GetMetadata(),
+		Snapshot:        snapshot,
+		ShardTotal:      reshardRequest.ShardTotal,
+		Confirmation:    parseConfirmation(req),
+	})
+	if err != nil {
+		log.Error("create reshard procedure failed", zap.Error(err))
+		if coderr.Is(err, metadata.ErrClusterProtected.Code()) {
+			return errResult(metadata.ErrClusterProtected, err.Error())
+		}
+		return errResult(ErrCreateProcedure, err.Error())
+	}
+	if err := c.GetProcedureManager().Submit(ctx, p); err != nil {
+		log.Error("submit reshard procedure failed", zap.Error(err))
+		return errResult(ErrSubmitProcedure, err.Error())
+	}
+	ret.ProcedureID = p.ID()
+
 	return okResult(ret)
 }
 
 func (a *API) health(_ *http.Request) apiFuncResult {
 	isServerHealthy := a.serverStatus.IsHealthy()
-	if isServerHealthy {
-		return okResult(nil)
+	if !isServerHealthy {
+		return errResult(ErrHealthCheck, fmt.Sprintf("server heath check failed, status is %v", a.serverStatus.Get()))
+	}
+	if reason := a.serverStatus.DegradedReason(); len(reason) > 0 {
+		return errResult(ErrServerDegraded, reason)
 	}
-	return errResult(ErrHealthCheck, fmt.Sprintf("server heath check failed, status is %v", a.serverStatus.Get()))
+	return okResult(nil)
+}
+
+// getStatus reports the build and the supported protocol versions of this server, so it is not forwarded to the leader.
+func (a *API) getStatus(_ *http.Request) apiFuncResult {
+	return okResult(ServerStatus{
+		Build:            a.buildInfo,
+		ProtocolVersions: service.SupportedProtocolVersions(),
+		Healthy:          a.serverStatus.IsHealthy(),
+		DegradedReason:   a.serverStatus.DegradedReason(),
+	})
+}
+
+// listConnections lists the outbound grpc connections of this server, so it is not forwarded to the leader.
+func (a *API) listConnections(_ *http.Request) apiFuncResult {
+	return okResult(service.ListConnPools())
 }
 
 func (a *API) pprofHeap(writer http.ResponseWriter, req *http.Request) {
@@ -638,23 +2368,6 @@ func (a *API) pprofThreadCreate(writer http.ResponseWriter, req *http.Request) {
 	pprof.Handler("threadcreate").ServeHTTP(writer, req)
 }
 
-// printRequestInfo used for printing every request information.
-func printRequestInfo(handlerName string, handler http.HandlerFunc) http.HandlerFunc {
-	return func(writer http.ResponseWriter, request *http.Request) {
-		body := ""
-		bodyByte, err := io.ReadAll(request.Body)
-		if err != nil {
-			log.Error("read request body failed", zap.Error(err))
-			return
-		}
-		body = string(bodyByte)
-		newBody := io.NopCloser(bytes.NewReader(bodyByte))
-		request.Body = newBody
-		log.Info("receive http request", zap.String("handlerName", handlerName), zap.String("client host", request.RemoteAddr), zap.String("method", request.Method), zap.String("params", request.Form.Encode()), zap.String("body", body))
-		handler.ServeHTTP(writer, request)
-	}
-}
-
 func respondForward(w http.ResponseWriter, response *http.Response) {
 	b, err := io.ReadAll(response.Body)
 	if err != nil {
@@ -663,7 +2376,9 @@ func respondForward(w http.ResponseWriter, response *http.Response) {
 		return
 	}
 
+	// The headers of the leader replace the local ones, e.g. the request id, which is the same one.
 	for key, valArr := range response.Header {
+		w.Header().Del(key)
 		for _, val := range valArr {
 			w.Header().Add(key, val)
 		}
@@ -674,13 +2389,18 @@ func respondForward(w http.ResponseWriter, response *http.Response) {
 	}
 }
 
-func respond(w http.ResponseWriter, data interface{}) {
+func respond(w http.ResponseWriter, r *http.Request, data interface{}) {
+	if isStreamRequested(r) && respondStream(w, r, data) {
+		return
+	}
+
 	statusMessage := statusSuccess
 	b, err := json.Marshal(&response{
-		Status: statusMessage,
-		Data:   data,
-		Error:  "",
-		Msg:    "",
+		Status:    statusMessage,
+		Data:      data,
+		Error:     "",
+		Msg:       "",
+		RequestID: requestid.FromContext(r.Context()),
 	})
 	if err != nil {
 		log.Error("marshal json response failed", zap.Error(err))
@@ -695,12 +2415,13 @@ func respond(w http.ResponseWriter, data interface{}) {
 	}
 }
 
-func respondError(w http.ResponseWriter, apiErr coderr.CodeError, msg string) {
+func respondError(w http.ResponseWriter, r *http.Request, apiErr coderr.CodeError, msg string, data interface{}) {
 	b, err := json.Marshal(&response{
-		Status: statusError,
-		Data:   nil,
-		Error:  apiErr.Error(),
-		Msg:    msg,
+		Status:    statusError,
+		Data:      data,
+		Error:     apiErr.Error(),
+		Msg:       msg,
+		RequestID: requestid.FromContext(r.Context()),
 	})
 	if err != nil {
 		log.Error("marshal json response failed", zap.Error(err))
@@ -715,13 +2436,27 @@ func respondError(w http.ResponseWriter, apiErr coderr.CodeError, msg string) {
 	}
 }
 
+// wrapStaleRead serves the request locally from the metadata synced on this node if the request allows the stale read by
+// the header, otherwise forwards it to the leader as usual.
+func wrapStaleRead(f apiFunc, forwardClient *ForwardClient) http.HandlerFunc {
+	forwarded := wrap(f, true, forwardClient)
+	local := wrap(f, false, forwardClient)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if allowed, err := strconv.ParseBool(r.Header.Get(staleReadHeader)); err == nil && allowed {
+			local(w, r)
+			return
+		}
+		forwarded(w, r)
+	}
+}
+
 func wrap(f apiFunc, needForward bool, forwardClient *ForwardClient) http.HandlerFunc {
 	hf := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if needForward {
 			resp, isLeader, err := forwardClient.forwardToLeader(r)
 			if err != nil {
-				log.Error("forward to leader failed", zap.Error(err))
-				respondError(w, ErrForwardToLeader, err.Error())
+				log.Error("forward to leader failed", zap.Error(err), requestid.Field(r.Context()))
+				respondError(w, r, ErrForwardToLeader, err.Error(), nil)
 				return
 			}
 			if !isLeader {
@@ -733,10 +2468,10 @@ func wrap(f apiFunc, needForward bool, forwardClient *ForwardClient) http.Handle
 		}
 		result := f(r)
 		if result.err != nil {
-			respondError(w, result.err, result.errMsg)
+			respondError(w, r, result.err, result.errMsg, result.data)
 			return
 		}
-		respond(w, result.data)
+		respond(w, r, result.data)
 	})
 	return hf
 }
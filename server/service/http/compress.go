@@ -0,0 +1,169 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+	// compressMinSize is the min size of the response to compress, and compressing the smaller ones is not worth it.
+	compressMinSize = 1024
+)
+
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// negotiateEncoding picks the encoding of the response from the Accept-Encoding header, which prefers the higher quality
+// and then gzip, and returns empty if neither gzip nor deflate is accepted.
+func negotiateEncoding(acceptEncoding string) string {
+	best, bestQuality := "", 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != encodingGzip && name != encodingDeflate {
+			continue
+		}
+
+		quality := 1.0
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(q, 64)
+			if err != nil {
+				continue
+			}
+			quality = parsed
+		}
+		if quality <= 0 {
+			continue
+		}
+		if quality > bestQuality || (quality == bestQuality && name == encodingGzip) {
+			best, bestQuality = name, quality
+		}
+	}
+	return best
+}
+
+// compressResponseWriter compresses the response in the negotiated encoding. The status is held until the first write,
+// so that the small responses and the ones encoded already, e.g. forwarded from the leader, are not compressed.
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	isHead   bool
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	// compressor is nil if the response is not compressed.
+	compressor compressor
+}
+
+func newCompressResponseWriter(w http.ResponseWriter, encoding string, isHead bool) *compressResponseWriter {
+	return &compressResponseWriter{
+		ResponseWriter: w,
+		encoding:       encoding,
+		isHead:         isHead,
+		status:         http.StatusOK,
+		wroteHeader:    false,
+		decided:        false,
+		compressor:     nil,
+	}
+}
+
+func (w *compressResponseWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	w.status = status
+}
+
+func (w *compressResponseWriter) Write(p []byte) (int, error) {
+	if !w.decided {
+		w.decide(len(p))
+	}
+	if w.compressor != nil {
+		return w.compressor.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// FlushError flushes the compressed data, and the response is compressed if it is flushed before any write, which means
+// it is streamed.
+func (w *compressResponseWriter) FlushError() error {
+	if !w.decided {
+		w.decide(compressMinSize)
+	}
+	if w.compressor != nil {
+		if err := w.compressor.Flush(); err != nil {
+			return err
+		}
+	}
+	return http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// decide decides whether to compress the response by the size of the first write, and sends the status.
+func (w *compressResponseWriter) decide(size int) {
+	w.decided = true
+
+	header := w.Header()
+	// The response encoded already has its own Vary header.
+	encoded := len(header.Get("Content-Encoding")) > 0
+	if !encoded {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	noBody := w.isHead || w.status == http.StatusNoContent || w.status == http.StatusNotModified
+	if size >= compressMinSize && !encoded && !noBody {
+		header.Set("Content-Encoding", w.encoding)
+		header.Del("Content-Length")
+		switch w.encoding {
+		case encodingGzip:
+			w.compressor = gzip.NewWriter(w.ResponseWriter)
+		case encodingDeflate:
+			// The deflate content coding is the zlib format rather than the raw deflate stream.
+			w.compressor = zlib.NewWriter(w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// close finishes the compressed response, and sends the status if nothing is written.
+func (w *compressResponseWriter) close() error {
+	if !w.decided {
+		if !w.wroteHeader {
+			// The handler writes nothing, and the server responds 200 by itself.
+			return nil
+		}
+		w.decide(0)
+	}
+	if w.compressor != nil {
+		return w.compressor.Close()
+	}
+	return nil
+}
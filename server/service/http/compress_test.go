@@ -0,0 +1,166 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	re := require.New(t)
+
+	for acceptEncoding, expect := range map[string]string{
+		"":                          "",
+		"br":                        "",
+		"gzip":                      encodingGzip,
+		"deflate":                   encodingDeflate,
+		"GZIP":                      encodingGzip,
+		"deflate, gzip":             encodingGzip,
+		"gzip;q=0.5, deflate":       encodingDeflate,
+		"gzip;q=0.8, deflate;q=0.9": encodingDeflate,
+		"gzip;q=0.5, deflate;q=0.5": encodingGzip,
+		"gzip;q=0, deflate;q=0":     "",
+		"gzip;q=0, deflate;q=0.1":   encodingDeflate,
+		"gzip;q=abc, deflate;q=0.1": encodingDeflate,
+		"br;q=1.0, gzip;q=0.2":      encodingGzip,
+	} {
+		re.Equal(expect, negotiateEncoding(acceptEncoding), "acceptEncoding:%s", acceptEncoding)
+	}
+}
+
+// serveCompressed serves the request by the handler registered on the router with the compression.
+func serveCompressed(method, acceptEncoding string, h http.HandlerFunc) *httptest.ResponseRecorder {
+	router := New().WithCompression()
+	router.Get("/test", h)
+	router.Head("/test", h)
+	req := httptest.NewRequest(method, "/test", nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder
+}
+
+func writeBody(status int, body []byte) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write(body)
+	}
+}
+
+func TestCompressResponse(t *testing.T) {
+	re := require.New(t)
+	large := bytes.Repeat([]byte("a"), compressMinSize)
+
+	recorder := serveCompressed(http.MethodGet, "gzip", writeBody(http.StatusOK, large))
+	re.Equal(http.StatusOK, recorder.Code)
+	re.Equal(encodingGzip, recorder.Header().Get("Content-Encoding"))
+	re.Equal("Accept-Encoding", recorder.Header().Get("Vary"))
+	gzipReader, err := gzip.NewReader(recorder.Body)
+	re.NoError(err)
+	body, err := io.ReadAll(gzipReader)
+	re.NoError(err)
+	re.Equal(large, body)
+
+	// The deflate content coding is read as the zlib format.
+	recorder = serveCompressed(http.MethodGet, "deflate", writeBody(http.StatusOK, large))
+	re.Equal(encodingDeflate, recorder.Header().Get("Content-Encoding"))
+	zlibReader, err := zlib.NewReader(recorder.Body)
+	re.NoError(err)
+	body, err = io.ReadAll(zlibReader)
+	re.NoError(err)
+	re.Equal(large, body)
+
+	// The responses are not compressed if the client accepts neither gzip nor deflate.
+	recorder = serveCompressed(http.MethodGet, "br", writeBody(http.StatusOK, large))
+	re.Empty(recorder.Header().Get("Content-Encoding"))
+	re.Equal(large, recorder.Body.Bytes())
+}
+
+func TestCompressThreshold(t *testing.T) {
+	re := require.New(t)
+
+	// The response smaller than the threshold is sent as it is.
+	small := bytes.Repeat([]byte("a"), compressMinSize-1)
+	recorder := serveCompressed(http.MethodGet, "gzip", writeBody(http.StatusCreated, small))
+	re.Equal(http.StatusCreated, recorder.Code)
+	re.Empty(recorder.Header().Get("Content-Encoding"))
+	re.Equal("Accept-Encoding", recorder.Header().Get("Vary"))
+	re.Equal(small, recorder.Body.Bytes())
+
+	// The response of exactly the threshold is compressed.
+	recorder = serveCompressed(http.MethodGet, "gzip", writeBody(http.StatusCreated, bytes.Repeat([]byte("a"), compressMinSize)))
+	re.Equal(http.StatusCreated, recorder.Code)
+	re.Equal(encodingGzip, recorder.Header().Get("Content-Encoding"))
+}
+
+func TestCompressNoBody(t *testing.T) {
+	re := require.New(t)
+	large := bytes.Repeat([]byte("a"), compressMinSize)
+
+	recorder := serveCompressed(http.MethodHead, "gzip", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Length", "1024")
+		w.WriteHeader(http.StatusOK)
+	})
+	re.Equal(http.StatusOK, recorder.Code)
+	re.Empty(recorder.Header().Get("Content-Encoding"))
+	re.Equal("1024", recorder.Header().Get("Content-Length"))
+	re.Empty(recorder.Body.Bytes())
+
+	for _, status := range []int{http.StatusNoContent, http.StatusNotModified} {
+		recorder = serveCompressed(http.MethodGet, "gzip", writeBody(status, nil))
+		re.Equal(status, recorder.Code)
+		re.Empty(recorder.Header().Get("Content-Encoding"), "status:%d", status)
+		re.Empty(recorder.Body.Bytes(), "status:%d", status)
+	}
+
+	// The status is still sent if the handler writes nothing after it.
+	recorder = serveCompressed(http.MethodGet, "gzip", func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	re.Equal(http.StatusAccepted, recorder.Code)
+	re.Empty(recorder.Header().Get("Content-Encoding"))
+
+	// The body written by the handler of HEAD is not compressed either.
+	recorder = serveCompressed(http.MethodHead, "gzip", writeBody(http.StatusOK, large))
+	re.Empty(recorder.Header().Get("Content-Encoding"))
+}
+
+func TestCompressEncodedResponse(t *testing.T) {
+	re := require.New(t)
+
+	// The response encoded already, e.g. forwarded from the leader, is passed through with its own headers even if it is
+	// larger than the threshold.
+	encoded := bytes.Repeat([]byte("a"), compressMinSize*2)
+	recorder := serveCompressed(http.MethodGet, "gzip, deflate", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Encoding", encodingGzip)
+		w.Header().Set("Vary", "Origin")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(encoded)
+	})
+	re.Equal(http.StatusOK, recorder.Code)
+	re.Equal(encodingGzip, recorder.Header().Get("Content-Encoding"))
+	re.Equal([]string{"Origin"}, recorder.Header().Values("Vary"))
+	re.Equal(encoded, recorder.Body.Bytes())
+}
@@ -0,0 +1,48 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"go.uber.org/zap"
+)
+
+// drainRetryAfterSec is the Retry-After of the requests rejected by the draining server, which is long enough for the
+// leadership to move to another member.
+const drainRetryAfterSec = 1
+
+// drainRequests tracks the in-flight requests so that they can be waited before the server is stopped, and rejects the
+// new requests with 503 once the server starts draining, pointing the clients at the leader if it is another member.
+func (a *API) drainRequests(handlerName string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !a.serverStatus.StartRequest() {
+			log.Warn("http request is rejected by draining server", zap.String("handlerName", handlerName))
+			w.Header().Set("Retry-After", strconv.Itoa(drainRetryAfterSec))
+			if addr, isLeader, err := a.forwardClient.getForwardedAddr(req.Context()); err == nil && !isLeader {
+				w.Header().Set(leaderHeader, addr)
+			}
+			respondError(w, req, ErrServerDraining, "retry later or on the leader", nil)
+			return
+		}
+		defer a.serverStatus.FinishRequest()
+
+		handler(w, req)
+	}
+}
@@ -20,7 +20,9 @@ import "github.com/CeresDB/horaemeta/pkg/coderr"
 
 var (
 	ErrParseRequest                  = coderr.NewCodeError(coderr.BadRequest, "parse request params")
+	ErrInvalidParamsForBatchDrop     = coderr.NewCodeError(coderr.BadRequest, "invalid params to batch drop tables")
 	ErrInvalidParamsForCreateCluster = coderr.NewCodeError(coderr.BadRequest, "invalid params to create cluster")
+	ErrInvalidProcedureParams        = coderr.NewCodeError(coderr.BadRequest, "invalid procedure params")
 	ErrTable                         = coderr.NewCodeError(coderr.Internal, "table")
 	ErrRoute                         = coderr.NewCodeError(coderr.Internal, "route table")
 	ErrGetNodeShards                 = coderr.NewCodeError(coderr.Internal, "get node shards")
@@ -35,6 +37,10 @@ var (
 	ErrUpdateFlowLimiter             = coderr.NewCodeError(coderr.Internal, "update flow limiter")
 	ErrGetEnableSchedule             = coderr.NewCodeError(coderr.Internal, "get enableSchedule")
 	ErrUpdateEnableSchedule          = coderr.NewCodeError(coderr.Internal, "update enableSchedule")
+	ErrUpdateSchedulerConfig         = coderr.NewCodeError(coderr.Internal, "update scheduler config")
+	ErrUpdateSchedulerEnabled        = coderr.NewCodeError(coderr.Internal, "update scheduler enabled")
+	ErrInvalidSchedulerConfig        = coderr.NewCodeError(coderr.BadRequest, "invalid scheduler config")
+	ErrRegistrationToken             = coderr.NewCodeError(coderr.Internal, "registration token")
 	ErrAddLearner                    = coderr.NewCodeError(coderr.Internal, "add member as learner")
 	ErrListMembers                   = coderr.NewCodeError(coderr.Internal, "get member list")
 	ErrRemoveMembers                 = coderr.NewCodeError(coderr.Internal, "remove member")
@@ -42,4 +48,42 @@ var (
 	ErrListAffinityRules             = coderr.NewCodeError(coderr.Internal, "list affinity rules")
 	ErrAddAffinityRule               = coderr.NewCodeError(coderr.Internal, "add affinity rule")
 	ErrRemoveAffinityRule            = coderr.NewCodeError(coderr.Internal, "remove affinity rule")
+	ErrImportAffinityRule            = coderr.NewCodeError(coderr.BadRequest, "import affinity rule")
+	ErrRepairShards                  = coderr.NewCodeError(coderr.Internal, "repair shards")
+	ErrBatchCreateTables             = coderr.NewCodeError(coderr.Internal, "batch create tables")
+	ErrIdempotency                   = coderr.NewCodeError(coderr.Internal, "idempotent request")
+	ErrIdempotencyKeyReused          = coderr.NewCodeError(coderr.BadRequest, "idempotency key is reused by a different request")
+	ErrIdempotencyKeyInProgress      = coderr.NewCodeError(coderr.Conflict, "request with the idempotency key is in progress")
+	ErrFailoverDrill                 = coderr.NewCodeError(coderr.Internal, "failover drill")
+	ErrStorageStats                  = coderr.NewCodeError(coderr.Internal, "storage stats")
+	ErrCloneCluster                  = coderr.NewCodeError(coderr.Internal, "clone cluster")
+	ErrDebugKV                       = coderr.NewCodeError(coderr.Internal, "inspect kv")
+	ErrDebugKVDisabled               = coderr.NewCodeError(coderr.Forbidden, "kv inspection is disabled")
+	ErrReloadConfig                  = coderr.NewCodeError(coderr.Internal, "reload config")
+	ErrInvalidLogLevel               = coderr.NewCodeError(coderr.BadRequest, "invalid log level")
+	ErrFsck                          = coderr.NewCodeError(coderr.Internal, "check cluster metadata")
+	ErrSchemaSettings                = coderr.NewCodeError(coderr.Internal, "schema settings")
+	ErrInvalidSchemaSettings         = coderr.NewCodeError(coderr.BadRequest, "invalid schema settings")
+	ErrTenant                        = coderr.NewCodeError(coderr.Internal, "tenant")
+	ErrInvalidTenantToken            = coderr.NewCodeError(coderr.Unauthorized, "invalid tenant token")
+	ErrTenantTokenRequired           = coderr.NewCodeError(coderr.Unauthorized, "tenant token required")
+	ErrTenantAccessDenied            = coderr.NewCodeError(coderr.Forbidden, "tenant access denied")
+	ErrTransitClusterState           = coderr.NewCodeError(coderr.Internal, "transit cluster state")
+	ErrServerDraining                = coderr.NewCodeError(coderr.Unavailable, "server is draining")
+	ErrFailpointsDisabled            = coderr.NewCodeError(coderr.Forbidden, "failpoints are disabled")
+	ErrInvalidFailpoint              = coderr.NewCodeError(coderr.BadRequest, "invalid failpoint")
+	ErrFailpointNotFound             = coderr.NewCodeError(coderr.NotFound, "failpoint not found")
+	ErrProcedureNotFound             = coderr.NewCodeError(coderr.NotFound, "procedure not found")
+	ErrGetProcedure                  = coderr.NewCodeError(coderr.Internal, "get procedure")
+	ErrReplayProcedure               = coderr.NewCodeError(coderr.BadRequest, "replay procedure")
+	ErrExportMetadata                = coderr.NewCodeError(coderr.Internal, "export metadata")
+	ErrImportMetadata                = coderr.NewCodeError(coderr.Internal, "import metadata")
+	ErrInvalidManifest               = coderr.NewCodeError(coderr.BadRequest, "invalid metadata manifest")
+	ErrNodeNotFound                  = coderr.NewCodeError(coderr.NotFound, "node not found")
+	ErrCordonNode                    = coderr.NewCodeError(coderr.Internal, "cordon node")
+	ErrServerDegraded                = coderr.NewCodeError(coderr.Unavailable, "server is degraded")
+	ErrEtcdNotEmbedded               = coderr.NewCodeError(coderr.BadRequest, "etcd is not embedded")
+	ErrTransferLeaderBatch           = coderr.NewCodeError(coderr.Internal, "transfer leader batch")
+	ErrInvalidTransferLeaderBatch    = coderr.NewCodeError(coderr.BadRequest, "invalid transfer leader batch")
+	ErrTransferLeaderBatchNotFound   = coderr.NewCodeError(coderr.NotFound, "transfer leader batch not found")
 )
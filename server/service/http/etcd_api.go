@@ -22,6 +22,7 @@ import (
 	"net/http"
 
 	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/etcdutil"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.uber.org/zap"
 )
@@ -29,6 +30,10 @@ import (
 type EtcdAPI struct {
 	etcdClient    *clientv3.Client
 	forwardClient *ForwardClient
+	maintainer    *etcdutil.Maintainer
+	endpoints     *etcdutil.EndpointManager
+	// embedMonitor is nil if etcd is not embedded.
+	embedMonitor *etcdutil.EmbedMonitor
 }
 
 type AddMemberRequest struct {
@@ -52,10 +57,13 @@ type MoveLeaderRequest struct {
 	MemberName string `json:"memberName"`
 }
 
-func NewEtcdAPI(etcdClient *clientv3.Client, forwardClient *ForwardClient) EtcdAPI {
+func NewEtcdAPI(etcdClient *clientv3.Client, forwardClient *ForwardClient, maintainer *etcdutil.Maintainer, endpoints *etcdutil.EndpointManager, embedMonitor *etcdutil.EmbedMonitor) EtcdAPI {
 	return EtcdAPI{
 		etcdClient:    etcdClient,
 		forwardClient: forwardClient,
+		maintainer:    maintainer,
+		endpoints:     endpoints,
+		embedMonitor:  embedMonitor,
 	}
 }
 
@@ -199,3 +207,23 @@ func (a *EtcdAPI) moveLeader(req *http.Request) apiFuncResult {
 
 	return errResult(ErrGetMember, fmt.Sprintf("member not found, member name: %s", moveLeaderRequest.MemberName))
 }
+
+// getMaintenanceStatus returns the status of the compaction and defragmentation run by the leader.
+func (a *EtcdAPI) getMaintenanceStatus(_ *http.Request) apiFuncResult {
+	return okResult(a.maintainer.Status())
+}
+
+// getEndpointStatus returns the etcd endpoint used by this server, which is served locally because every server manages
+// its own etcd client.
+func (a *EtcdAPI) getEndpointStatus(_ *http.Request) apiFuncResult {
+	return okResult(a.endpoints.Status())
+}
+
+// getEmbedStatus returns the alarms, the db size and the raft status of the embedded etcd of this server, so it is not
+// forwarded to the leader.
+func (a *EtcdAPI) getEmbedStatus(_ *http.Request) apiFuncResult {
+	if a.embedMonitor == nil {
+		return errResult(ErrEtcdNotEmbedded, "the status is only available for the embedded etcd")
+	}
+	return okResult(a.embedMonitor.Status())
+}
@@ -0,0 +1,336 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/member"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultDrillMaxRevisionLag = 100
+	defaultDrillTimeoutSec     = 60
+	drillPollInterval          = 500 * time.Millisecond
+)
+
+type FailoverDrillRequest struct {
+	// TargetMemberName is the name of the follower to promote.
+	TargetMemberName string `json:"targetMemberName"`
+	// MaxRevisionLag is the max etcd revision lag of the target behind the leader, 0 means the default one.
+	MaxRevisionLag int64 `json:"maxRevisionLag"`
+	// TimeoutSec bounds the whole drill, 0 means the default one.
+	TimeoutSec uint32 `json:"timeoutSec"`
+}
+
+type FailoverDrillStep struct {
+	Name      string `json:"name"`
+	Succeeded bool   `json:"succeeded"`
+	ElapsedMs int64  `json:"elapsedMs"`
+	Message   string `json:"message"`
+}
+
+type FailoverDrillReport struct {
+	TargetMemberName string              `json:"targetMemberName"`
+	PreviousLeader   string              `json:"previousLeader"`
+	NewLeader        string              `json:"newLeader"`
+	Succeeded        bool                `json:"succeeded"`
+	ElapsedMs        int64               `json:"elapsedMs"`
+	Steps            []FailoverDrillStep `json:"steps"`
+}
+
+// failoverDrill promotes a follower to be the leader in a controlled way, and records the result of every step.
+// It relies on the leadership of horaemeta following the leadership of the embedded etcd.
+type failoverDrill struct {
+	api           *API
+	req           FailoverDrillRequest
+	report        FailoverDrillReport
+	targetID      uint64
+	targetURLs    []string
+	clusterNames  []string
+	runningBefore int
+	newLeaderHTTP string
+}
+
+// failoverDrill runs the drill on the leader, and the report is returned even if the drill fails at some step.
+func (a *API) failoverDrill(req *http.Request) apiFuncResult {
+	var drillReq FailoverDrillRequest
+	if err := json.NewDecoder(req.Body).Decode(&drillReq); err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+	if len(drillReq.TargetMemberName) == 0 {
+		return errResult(ErrParseRequest, "targetMemberName could not be empty")
+	}
+	if drillReq.MaxRevisionLag == 0 {
+		drillReq.MaxRevisionLag = defaultDrillMaxRevisionLag
+	}
+	if drillReq.TimeoutSec == 0 {
+		drillReq.TimeoutSec = defaultDrillTimeoutSec
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), time.Duration(drillReq.TimeoutSec)*time.Second)
+	defer cancel()
+
+	drill := &failoverDrill{
+		api: a,
+		req: drillReq,
+		report: FailoverDrillReport{
+			TargetMemberName: drillReq.TargetMemberName,
+			PreviousLeader:   "",
+			NewLeader:        "",
+			Succeeded:        false,
+			ElapsedMs:        0,
+			Steps:            make([]FailoverDrillStep, 0),
+		},
+		targetID:      0,
+		targetURLs:    nil,
+		clusterNames:  nil,
+		runningBefore: 0,
+		newLeaderHTTP: "",
+	}
+	report := drill.run(ctx)
+	log.Info("failover drill finished", zap.String("report", fmt.Sprintf("%+v", report)))
+
+	return okResult(report)
+}
+
+func (d *failoverDrill) run(ctx context.Context) FailoverDrillReport {
+	start := time.Now()
+	steps := []struct {
+		name string
+		f    func(context.Context) (string, error)
+	}{
+		{name: "checkTarget", f: d.checkTarget},
+		{name: "snapshotProcedures", f: d.snapshotProcedures},
+		{name: "checkFreshness", f: d.checkFreshness},
+		{name: "moveEtcdLeader", f: d.moveEtcdLeader},
+		{name: "waitMetaLeader", f: d.waitMetaLeader},
+		{name: "verifyProcedures", f: d.verifyProcedures},
+	}
+
+	d.report.Succeeded = true
+	for _, step := range steps {
+		stepStart := time.Now()
+		msg, err := step.f(ctx)
+		if err != nil {
+			msg = err.Error()
+		}
+		d.report.Steps = append(d.report.Steps, FailoverDrillStep{
+			Name:      step.name,
+			Succeeded: err == nil,
+			ElapsedMs: time.Since(stepStart).Milliseconds(),
+			Message:   msg,
+		})
+		if err != nil {
+			log.Error("failover drill step failed", zap.String("step", step.name), zap.Error(err))
+			d.report.Succeeded = false
+			break
+		}
+	}
+	d.report.ElapsedMs = time.Since(start).Milliseconds()
+
+	return d.report
+}
+
+// checkTarget makes sure the target is a voting member other than the current leader.
+func (d *failoverDrill) checkTarget(ctx context.Context) (string, error) {
+	leader, err := d.api.forwardClient.member.GetLeaderAddrFromEtcd(ctx)
+	if err != nil {
+		return "", errors.WithMessage(err, "get leader")
+	}
+	if !leader.IsLocal {
+		return "", errors.WithMessagef(ErrFailoverDrill, "drill must run on the leader, leader:%s", leader.LeaderEndpoint)
+	}
+	d.report.PreviousLeader = leader.LeaderEndpoint
+
+	resp, err := d.api.etcdAPI.etcdClient.MemberList(ctx)
+	if err != nil {
+		return "", ErrListMembers.WithCause(err)
+	}
+	for _, m := range resp.Members {
+		if m.Name != d.req.TargetMemberName {
+			continue
+		}
+		if m.IsLearner {
+			return "", errors.WithMessagef(ErrFailoverDrill, "target is a learner, name:%s", m.Name)
+		}
+		if len(m.ClientURLs) == 0 {
+			return "", errors.WithMessagef(ErrFailoverDrill, "target has no client urls, name:%s", m.Name)
+		}
+		// The meta server is named after the member of the embedded etcd, and the advertised endpoint of the meta server
+		// may differ from the client urls of etcd.
+		if m.Name == leader.LeaderName {
+			return "", errors.WithMessagef(ErrFailoverDrill, "target is the leader already, name:%s", m.Name)
+		}
+		d.targetID = m.ID
+		d.targetURLs = m.ClientURLs
+		return fmt.Sprintf("target:%x, clientURLs:%v", m.ID, m.ClientURLs), nil
+	}
+
+	return "", errors.WithMessagef(ErrGetMember, "member not found, name:%s", d.req.TargetMemberName)
+}
+
+// snapshotProcedures records the clusters and their running procedures before the leadership moves.
+func (d *failoverDrill) snapshotProcedures(ctx context.Context) (string, error) {
+	clusters, err := d.api.clusterManager.ListClusters(ctx)
+	if err != nil {
+		return "", ErrGetCluster.WithCause(err)
+	}
+	d.clusterNames = make([]string, 0, len(clusters))
+	for _, c := range clusters {
+		infos, err := c.GetProcedureManager().ListRunningProcedure(ctx)
+		if err != nil {
+			return "", errors.WithMessagef(err, "list running procedures, cluster:%s", c.GetMetadata().Name())
+		}
+		d.clusterNames = append(d.clusterNames, c.GetMetadata().Name())
+		d.runningBefore += len(infos)
+	}
+
+	return fmt.Sprintf("clusters:%d, runningProcedures:%d", len(d.clusterNames), d.runningBefore), nil
+}
+
+// checkFreshness makes sure the metadata the target will load is not too far behind the leader.
+func (d *failoverDrill) checkFreshness(ctx context.Context) (string, error) {
+	client := d.api.etcdAPI.etcdClient
+	leaderStatus, err := client.Status(ctx, d.report.PreviousLeader)
+	if err != nil {
+		return "", errors.WithMessagef(ErrFailoverDrill, "get status of leader, endpoint:%s, err:%v", d.report.PreviousLeader, err)
+	}
+	targetStatus, err := client.Status(ctx, d.targetURLs[0])
+	if err != nil {
+		return "", errors.WithMessagef(ErrFailoverDrill, "get status of target, endpoint:%s, err:%v", d.targetURLs[0], err)
+	}
+
+	revisionLag := leaderStatus.Header.Revision - targetStatus.Header.Revision
+	appliedIndexLag := int64(leaderStatus.RaftAppliedIndex) - int64(targetStatus.RaftAppliedIndex)
+	msg := fmt.Sprintf("revisionLag:%d, raftAppliedIndexLag:%d", revisionLag, appliedIndexLag)
+	if revisionLag > d.req.MaxRevisionLag {
+		return "", errors.WithMessagef(ErrFailoverDrill, "target is stale, %s, maxRevisionLag:%d", msg, d.req.MaxRevisionLag)
+	}
+
+	return msg, nil
+}
+
+func (d *failoverDrill) moveEtcdLeader(ctx context.Context) (string, error) {
+	if _, err := d.api.etcdAPI.etcdClient.MoveLeader(ctx, d.targetID); err != nil {
+		return "", errors.WithMessagef(ErrFailoverDrill, "move etcd leader, target:%x, err:%v", d.targetID, err)
+	}
+	return fmt.Sprintf("etcd leader moved to %x", d.targetID), nil
+}
+
+// waitMetaLeader waits for the target to campaign for the leadership after the etcd leader moves.
+func (d *failoverDrill) waitMetaLeader(ctx context.Context) (string, error) {
+	var newLeader member.GetLeaderAddrResp
+	err := pollUntil(ctx, func() (bool, error) {
+		leader, err := d.api.forwardClient.member.GetLeaderAddrFromEtcd(ctx)
+		if err != nil {
+			return false, err
+		}
+		if leader.LeaderName != d.req.TargetMemberName {
+			return false, errors.WithMessagef(ErrFailoverDrill, "leader is still %s", leader.LeaderEndpoint)
+		}
+		newLeader = leader
+		return true, nil
+	})
+	if err != nil {
+		return "", errors.WithMessage(err, "wait for the target to be the leader")
+	}
+	d.report.NewLeader = newLeader.LeaderEndpoint
+
+	httpAddr, err := d.api.forwardClient.leaderHTTPAddr(ctx, newLeader)
+	if err != nil {
+		return "", errors.WithMessage(err, "get http addr of leader")
+	}
+	d.newLeaderHTTP = httpAddr
+	return fmt.Sprintf("new leader:%s", d.report.NewLeader), nil
+}
+
+// verifyProcedures makes sure the procedure manager of every cluster is serving on the new leader.
+func (d *failoverDrill) verifyProcedures(ctx context.Context) (string, error) {
+	runningAfter := 0
+	for _, clusterName := range d.clusterNames {
+		var running int
+		err := pollUntil(ctx, func() (bool, error) {
+			n, err := d.listRemoteProcedures(ctx, clusterName)
+			if err != nil {
+				return false, err
+			}
+			running = n
+			return true, nil
+		})
+		if err != nil {
+			return "", errors.WithMessagef(err, "list procedures on new leader, cluster:%s", clusterName)
+		}
+		runningAfter += running
+	}
+
+	return fmt.Sprintf("clusters:%d, runningProcedures before:%d, after:%d", len(d.clusterNames), d.runningBefore, runningAfter), nil
+}
+
+func (d *failoverDrill) listRemoteProcedures(ctx context.Context, clusterName string) (int, error) {
+	url := fmt.Sprintf("http://%s%s/clusters/%s/procedure", d.newLeaderHTTP, apiPrefix, clusterName)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, errors.WithMessagef(ErrFailoverDrill, "build request, url:%s, err:%v", url, err)
+	}
+	resp, err := d.api.forwardClient.client.Do(req)
+	if err != nil {
+		return 0, errors.WithMessagef(ErrFailoverDrill, "send request, url:%s, err:%v", url, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		Status string            `json:"status"`
+		Data   []json.RawMessage `json:"data"`
+		Msg    string            `json:"msg"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return 0, errors.WithMessagef(ErrFailoverDrill, "decode response, url:%s, err:%v", url, err)
+	}
+	if body.Status != statusSuccess {
+		return 0, errors.WithMessagef(ErrFailoverDrill, "request failed, url:%s, msg:%s", url, body.Msg)
+	}
+	return len(body.Data), nil
+}
+
+// pollUntil calls f until it returns true or the ctx is done, and the last error of f is returned in the latter case.
+func pollUntil(ctx context.Context, f func() (bool, error)) error {
+	ticker := time.NewTicker(drillPollInterval)
+	defer ticker.Stop()
+
+	for {
+		ok, err := f()
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return errors.WithMessage(err, ctx.Err().Error())
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/CeresDB/horaemeta/pkg/log"
@@ -36,13 +37,24 @@ type ForwardClient struct {
 	member *member.Member
 	client *http.Client
 	port   int
+
+	// lock protects leaderHTTP, which caches the advertised http address of the leader.
+	lock       sync.Mutex
+	leaderHTTP leaderHTTPAddr
+}
+
+type leaderHTTPAddr struct {
+	endpoint string
+	httpAddr string
 }
 
 func NewForwardClient(member *member.Member, port int) *ForwardClient {
 	return &ForwardClient{
-		member: member,
-		client: getForwardedHTTPClient(),
-		port:   port,
+		member:     member,
+		client:     getForwardedHTTPClient(),
+		port:       port,
+		lock:       sync.Mutex{},
+		leaderHTTP: leaderHTTPAddr{endpoint: "", httpAddr: ""},
 	}
 }
 
@@ -63,15 +75,49 @@ func (s *ForwardClient) getForwardedAddr(ctx context.Context) (string, bool, err
 	if resp.IsLocal {
 		return "", true, nil
 	}
-	// TODO: In the current implementation, if the HTTP port of each node of HoraeMeta is inconsistent, the forwarding address will be wrong
-	httpAddr, err := formatHTTPAddr(resp.LeaderEndpoint, s.port)
+	httpAddr, err := s.leaderHTTPAddr(ctx, resp)
 	if err != nil {
-		return "", false, errors.WithMessage(err, "format http addr")
+		return "", false, errors.WithMessage(err, "get http addr of leader")
 	}
-	log.Info("getForwardedAddr", zap.String("leaderAddr", httpAddr), zap.Int("port", s.port))
+	log.Info("getForwardedAddr", zap.String("leaderAddr", httpAddr), zap.String("leaderEndpoint", resp.LeaderEndpoint))
 	return httpAddr, false, nil
 }
 
+// leaderHTTPAddr returns the address of the http service advertised by the leader, and it falls back to the host of the
+// leader endpoint with the local http port if the leader advertises none, e.g. it is an old version.
+func (s *ForwardClient) leaderHTTPAddr(ctx context.Context, leader member.GetLeaderAddrResp) (string, error) {
+	s.lock.Lock()
+	cached := s.leaderHTTP
+	s.lock.Unlock()
+	if cached.endpoint == leader.LeaderEndpoint && len(cached.httpAddr) > 0 {
+		return cached.httpAddr, nil
+	}
+
+	registration, ok, err := s.member.GetRegistration(ctx, leader.LeaderName)
+	if err != nil {
+		return "", err
+	}
+	// The registration may be left by the previous server of the same name whose endpoint is different, or not be put by
+	// the new leader yet, so the derived address is not cached.
+	if !ok || registration.Endpoint != leader.LeaderEndpoint || len(registration.HTTPEndpoint) == 0 {
+		httpAddr, err := formatHTTPAddr(leader.LeaderEndpoint, s.port)
+		if err != nil {
+			return "", errors.WithMessage(err, "format http addr")
+		}
+		return httpAddr, nil
+	}
+	u, err := url.Parse(registration.HTTPEndpoint)
+	if err != nil {
+		return "", service.ErrParseURL.WithCause(err)
+	}
+	httpAddr := u.Host
+
+	s.lock.Lock()
+	s.leaderHTTP = leaderHTTPAddr{endpoint: leader.LeaderEndpoint, httpAddr: httpAddr}
+	s.lock.Unlock()
+	return httpAddr, nil
+}
+
 func (s *ForwardClient) forwardToLeader(req *http.Request) (*http.Response, bool, error) {
 	addr, isLeader, err := s.getForwardedAddr(req.Context())
 	if err != nil {
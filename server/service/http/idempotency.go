@@ -0,0 +1,252 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/clientv3util"
+	"go.uber.org/zap"
+)
+
+const (
+	idempotencyKeyHeader = "Idempotency-Key"
+	idempotencyKeyPrefix = "idempotency"
+	// idempotencyWaitTimeout bounds the waiting of the request for the result of the one with the same key in progress.
+	idempotencyWaitTimeout = 10 * time.Second
+	// idempotencyPollInterval is how often the result of the request in progress is checked.
+	idempotencyPollInterval = 100 * time.Millisecond
+	// idempotencyReleaseTimeout bounds the releasing of the key, which is done even if the request is canceled.
+	idempotencyReleaseTimeout = 5 * time.Second
+	// idempotencyPendingTTLSec is the ttl of the pending marker, which is kept alive while the request is handled, so the
+	// key is released soon if the server handling it crashes.
+	idempotencyPendingTTLSec int64 = 10
+)
+
+// idempotentResult is the cached result of a request carrying the idempotency key, or the marker of the request still
+// being handled.
+type idempotentResult struct {
+	// BodyDigest is used to reject the key reused by a different request.
+	BodyDigest string `json:"bodyDigest"`
+	// Pending is true until the request reserving the key is done.
+	Pending bool            `json:"pending,omitempty"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// IdempotencyCache caches the results of the mutating requests in etcd, so the retried requests with the same
+// idempotency key get the original result instead of doing the work again.
+type IdempotencyCache struct {
+	client   *clientv3.Client
+	rootPath string
+	ttlSec   int64
+}
+
+func NewIdempotencyCache(client *clientv3.Client, rootPath string, ttlSec int64) *IdempotencyCache {
+	return &IdempotencyCache{
+		client:   client,
+		rootPath: rootPath,
+		ttlSec:   ttlSec,
+	}
+}
+
+func (c *IdempotencyCache) makeKey(handlerName, idempotencyKey string) string {
+	return path.Join(c.rootPath, idempotencyKeyPrefix, handlerName, url.PathEscape(idempotencyKey))
+}
+
+// reserve puts the pending marker with a short lease if the key is missing, so only one of the concurrent requests with
+// the same key does the work. The lease of the marker is returned if the key is reserved, otherwise the result or the
+// marker put by another request is returned, and the second output parameter bool: returns true if it exists.
+func (c *IdempotencyCache) reserve(ctx context.Context, handlerName, idempotencyKey, bodyDigest string) (clientv3.LeaseID, idempotentResult, bool, error) {
+	var existing idempotentResult
+	value, err := json.Marshal(idempotentResult{BodyDigest: bodyDigest, Pending: true, Data: nil})
+	if err != nil {
+		return clientv3.NoLease, existing, false, errors.WithMessage(err, "encode pending idempotent result")
+	}
+
+	lease, err := c.client.Grant(ctx, idempotencyPendingTTLSec)
+	if err != nil {
+		return clientv3.NoLease, existing, false, errors.WithMessagef(err, "grant lease, ttl:%d", idempotencyPendingTTLSec)
+	}
+
+	key := c.makeKey(handlerName, idempotencyKey)
+	resp, err := c.client.Txn(ctx).
+		If(clientv3util.KeyMissing(key)).
+		Then(clientv3.OpPut(key, string(value), clientv3.WithLease(lease.ID))).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		c.release(ctx, lease.ID)
+		return clientv3.NoLease, existing, false, errors.WithMessagef(err, "reserve idempotency key, key:%s", key)
+	}
+	if resp.Succeeded {
+		return lease.ID, existing, false, nil
+	}
+
+	// The lease is attached to nothing if the key exists, so it is revoked at once instead of being left until it expires.
+	c.release(ctx, lease.ID)
+	kvs := resp.Responses[0].GetResponseRange().GetKvs()
+	if len(kvs) == 0 {
+		// The key expires after the transaction.
+		return clientv3.NoLease, existing, false, nil
+	}
+	if err := json.Unmarshal(kvs[0].Value, &existing); err != nil {
+		return clientv3.NoLease, existing, false, errors.WithMessagef(err, "decode idempotent result, key:%s", key)
+	}
+	return clientv3.NoLease, existing, true, nil
+}
+
+// keepPending keeps the lease of the pending marker alive until the returned stop is called.
+func (c *IdempotencyCache) keepPending(ctx context.Context, pendingLeaseID clientv3.LeaseID) func() {
+	ctx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	ch, err := c.client.KeepAlive(ctx, pendingLeaseID)
+	if err != nil {
+		// The marker is released when the lease expires, and the request is still handled.
+		log.Warn("keep idempotency lease alive failed", zap.Int64("leaseID", int64(pendingLeaseID)), zap.Error(err))
+		return cancel
+	}
+	go func() {
+		for range ch {
+			// The responses must be consumed, otherwise the client warns that the queue is full.
+		}
+	}()
+	return cancel
+}
+
+// complete replaces the pending marker of the reserved key with the result, which is kept by a new lease of ttl, and the
+// lease of the pending marker is revoked then.
+func (c *IdempotencyCache) complete(ctx context.Context, handlerName, idempotencyKey string, pendingLeaseID clientv3.LeaseID, result idempotentResult) error {
+	value, err := json.Marshal(result)
+	if err != nil {
+		return errors.WithMessage(err, "encode idempotent result")
+	}
+
+	lease, err := c.client.Grant(ctx, c.ttlSec)
+	if err != nil {
+		return errors.WithMessagef(err, "grant lease, ttl:%d", c.ttlSec)
+	}
+	key := c.makeKey(handlerName, idempotencyKey)
+	if _, err := c.client.Put(ctx, key, string(value), clientv3.WithLease(lease.ID)); err != nil {
+		c.release(ctx, lease.ID)
+		return errors.WithMessagef(err, "put idempotent result, key:%s", key)
+	}
+	// The key is attached to the new lease, so revoking the pending one keeps it.
+	c.release(ctx, pendingLeaseID)
+	return nil
+}
+
+// release revokes the lease, which removes the pending marker attached to it, so the key is able to be reserved again.
+func (c *IdempotencyCache) release(ctx context.Context, leaseID clientv3.LeaseID) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), idempotencyReleaseTimeout)
+	defer cancel()
+	if _, err := c.client.Revoke(ctx, leaseID); err != nil {
+		log.Warn("revoke idempotency lease failed", zap.Int64("leaseID", int64(leaseID)), zap.Error(err))
+	}
+}
+
+// idempotent wraps the apiFunc so that the successful result is cached by the idempotency key in the request header.
+// The key is reserved before the request is handled, and the concurrent requests with the same key wait for the result
+// of the one reserving it, or fail with the conflict if it is not done in time. The requests without the header are
+// handled as usual.
+func (a *API) idempotent(handlerName string, f apiFunc) apiFunc {
+	return func(req *http.Request) apiFuncResult {
+		idempotencyKey := req.Header.Get(idempotencyKeyHeader)
+		if len(idempotencyKey) == 0 {
+			return f(req)
+		}
+
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return errResult(ErrParseRequest, err.Error())
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		digest := sha256.Sum256(body)
+		bodyDigest := hex.EncodeToString(digest[:])
+
+		ctx := req.Context()
+		waitCtx, cancel := context.WithTimeout(ctx, idempotencyWaitTimeout)
+		defer cancel()
+		for {
+			leaseID, existing, exists, err := a.idempotencyCache.reserve(ctx, handlerName, idempotencyKey, bodyDigest)
+			if err != nil {
+				log.Error("reserve idempotency key failed", zap.String("idempotencyKey", idempotencyKey), zap.Error(err))
+				return errResult(ErrIdempotency, err.Error())
+			}
+			if leaseID != clientv3.NoLease {
+				return a.handleReserved(req, handlerName, idempotencyKey, bodyDigest, leaseID, f)
+			}
+
+			if exists {
+				if existing.BodyDigest != bodyDigest {
+					return errResult(ErrIdempotencyKeyReused, idempotencyKey)
+				}
+				if !existing.Pending {
+					log.Info("replay idempotent result", zap.String("handlerName", handlerName), zap.String("idempotencyKey", idempotencyKey))
+					return okResult(existing.Data)
+				}
+			}
+
+			// Check again later, and the key may be reserved by this request if the one in progress fails.
+			select {
+			case <-waitCtx.Done():
+				return errResult(ErrIdempotencyKeyInProgress, idempotencyKey)
+			case <-time.After(idempotencyPollInterval):
+			}
+		}
+	}
+}
+
+// handleReserved handles the request with the reserved key, and the key is released if the request fails, so it can be
+// retried.
+func (a *API) handleReserved(req *http.Request, handlerName, idempotencyKey, bodyDigest string, pendingLeaseID clientv3.LeaseID, f apiFunc) apiFuncResult {
+	ctx := req.Context()
+	stopKeepPending := a.idempotencyCache.keepPending(ctx, pendingLeaseID)
+	result := f(req)
+	stopKeepPending()
+	if result.err != nil {
+		a.idempotencyCache.release(ctx, pendingLeaseID)
+		return result
+	}
+
+	data, err := json.Marshal(result.data)
+	if err != nil {
+		log.Warn("encode result for idempotency key failed", zap.String("idempotencyKey", idempotencyKey), zap.Error(err))
+		a.idempotencyCache.release(ctx, pendingLeaseID)
+		return result
+	}
+	// The work is done, so failing to cache the result should not fail the request.
+	if err := a.idempotencyCache.complete(context.WithoutCancel(ctx), handlerName, idempotencyKey, pendingLeaseID, idempotentResult{
+		BodyDigest: bodyDigest,
+		Pending:    false,
+		Data:       data,
+	}); err != nil {
+		log.Warn("cache result for idempotency key failed", zap.String("idempotencyKey", idempotencyKey), zap.Error(err))
+		a.idempotencyCache.release(ctx, pendingLeaseID)
+	}
+	return result
+}
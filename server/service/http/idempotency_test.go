@@ -0,0 +1,124 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/server/etcdutil"
+	"github.com/stretchr/testify/require"
+)
+
+func newIdempotentRequest(idempotencyKey, body string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/split", bytes.NewBufferString(body))
+	req.Header.Set(idempotencyKeyHeader, idempotencyKey)
+	return req
+}
+
+func TestIdempotentConcurrentRequests(t *testing.T) {
+	re := require.New(t)
+	_, client, closeSrv := etcdutil.PrepareEtcdServerAndClient(t)
+	defer closeSrv()
+
+	api := newTenantTestAPI(false)
+	api.idempotencyCache = NewIdempotencyCache(client, "/idempotency_test", 60)
+
+	var calls atomic.Int32
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+	handler := api.idempotent("/split", func(_ *http.Request) apiFuncResult {
+		if calls.Add(1) == 1 {
+			close(started)
+			<-proceed
+		}
+		return okResult("done")
+	})
+
+	// The retries arriving while the first request is in progress wait for its result instead of doing the work again.
+	results := make([]apiFuncResult, 3)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		results[0] = handler(newIdempotentRequest("key0", "body"))
+	}()
+	<-started
+	for i := 1; i < len(results); i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = handler(newIdempotentRequest("key0", "body"))
+		}(i)
+	}
+	close(proceed)
+	wg.Wait()
+
+	re.Equal(int32(1), calls.Load())
+	re.Nil(results[0].err)
+	re.Equal("done", results[0].data)
+	for _, result := range results[1:] {
+		re.Nil(result.err)
+		re.Equal(json.RawMessage(`"done"`), result.data)
+	}
+
+	// The key reused by a different request is rejected.
+	result := handler(newIdempotentRequest("key0", "another body"))
+	re.True(coderr.Is(result.err, ErrIdempotencyKeyReused.Code()))
+	re.Equal(int32(1), calls.Load())
+}
+
+func TestIdempotentFailedRequest(t *testing.T) {
+	re := require.New(t)
+	_, client, closeSrv := etcdutil.PrepareEtcdServerAndClient(t)
+	defer closeSrv()
+
+	api := newTenantTestAPI(false)
+	api.idempotencyCache = NewIdempotencyCache(client, "/idempotency_test", 60)
+
+	var calls atomic.Int32
+	handler := api.idempotent("/split", func(_ *http.Request) apiFuncResult {
+		if calls.Add(1) == 1 {
+			return errResult(ErrIdempotency, "failed")
+		}
+		return okResult("done")
+	})
+
+	// The key is released if the request fails, so the retry does the work.
+	result := handler(newIdempotentRequest("key0", "body"))
+	re.NotNil(result.err)
+	result = handler(newIdempotentRequest("key0", "body"))
+	re.Nil(result.err)
+	re.Equal(int32(2), calls.Load())
+
+	// The lease of the reservation losing to the existing key is revoked.
+	leases, err := client.Leases(context.Background())
+	re.NoError(err)
+	re.Len(leases.Leases, 1)
+	result = handler(newIdempotentRequest("key0", "body"))
+	re.Nil(result.err)
+	leases, err = client.Leases(context.Background())
+	re.NoError(err)
+	re.Len(leases.Leases, 1)
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/clusterpb"
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	kvValueTypeJSON     = "json"
+	kvValueTypeString   = "string"
+	kvValueTypeBytes    = "bytes"
+	kvValueTypeRedacted = "redacted"
+)
+
+// redactedKeyPrefixes are the keys relative to the root path whose values are never exposed, even if only the hashes of
+// the secrets are persisted.
+var redactedKeyPrefixes = []string{"registration_token/", "tenant_token/"}
+
+type KVRecord struct {
+	Key string `json:"key"`
+	// Type is the full name of the protobuf message the value is decoded as, or one of json, string, bytes and redacted.
+	Type           string          `json:"type"`
+	Value          json.RawMessage `json:"value"`
+	CreateRevision int64           `json:"createRevision"`
+	ModRevision    int64           `json:"modRevision"`
+}
+
+type ListKVResult struct {
+	Records []KVRecord `json:"records"`
+	// More is true if there are more keys with the prefix beyond the limit.
+	More bool `json:"more"`
+}
+
+// listKVs lists the keys with the prefix relative to the root path, and the values are decoded by the layout of the keys.
+func (i *StorageInspector) listKVs(ctx context.Context, prefix string, limit int) (ListKVResult, error) {
+	root := strings.TrimSuffix(i.rootPath, "/") + "/"
+	fullPrefix := root + strings.TrimPrefix(prefix, "/")
+	resp, err := i.client.Get(ctx, fullPrefix, clientv3.WithRange(clientv3.GetPrefixRangeEnd(fullPrefix)), clientv3.WithLimit(int64(limit)))
+	if err != nil {
+		return ListKVResult{}, errors.WithMessagef(err, "list kvs, prefix:%s", fullPrefix)
+	}
+
+	records := make([]KVRecord, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		key := string(kv.Key)
+		valueType, value := decodeKVValue(strings.TrimPrefix(key, root), kv.Value)
+		records = append(records, KVRecord{
+			Key:            key,
+			Type:           valueType,
+			Value:          value,
+			CreateRevision: kv.CreateRevision,
+			ModRevision:    kv.ModRevision,
+		})
+	}
+	return ListKVResult{
+		Records: records,
+		More:    resp.More,
+	}, nil
+}
+
+// decodeKVValue decodes the value by the key relative to the root path, see categorizeKey for the layout of the keys.
+// The value not decoded as a protobuf message falls back to json, string or base64 encoded bytes.
+func decodeKVValue(key string, value []byte) (string, json.RawMessage) {
+	for _, prefix := range redactedKeyPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return kvValueTypeRedacted, json.RawMessage("null")
+		}
+	}
+
+	if msg := protoMessageOfKey(key); msg != nil {
+		if err := proto.Unmarshal(value, msg); err == nil {
+			if decoded, err := protojson.Marshal(msg); err == nil {
+				return string(proto.MessageName(msg)), decoded
+			}
+		}
+	}
+
+	if json.Valid(value) {
+		return kvValueTypeJSON, value
+	}
+	if utf8.Valid(value) {
+		encoded, _ := json.Marshal(string(value))
+		return kvValueTypeString, encoded
+	}
+	encoded, _ := json.Marshal(value)
+	return kvValueTypeBytes, encoded
+}
+
+// protoMessageOfKey returns the message to decode the value of the key, and nil if the value is not a protobuf message.
+func protoMessageOfKey(key string) proto.Message {
+	parts := strings.Split(key, "/")
+	if len(parts) < 4 || parts[0] != "v1" || parts[1] != "cluster" {
+		return nil
+	}
+	if parts[2] == "info" {
+		return &clusterpb.Cluster{}
+	}
+	if len(parts) < 5 {
+		return nil
+	}
+
+	switch parts[3] {
+	case "schema":
+		if len(parts) == 6 && parts[4] == "info" {
+			return &clusterpb.Schema{}
+		}
+		if len(parts) == 7 && parts[5] == "table" {
+			return &clusterpb.Table{}
+		}
+	case "shard_view":
+		if len(parts) == 6 && parts[5] != "latest_version" && parts[5] != "table_versions" {
+			return &clusterpb.ShardView{}
+		}
+	case "cluster_view":
+		if len(parts) == 5 && parts[4] != "latest_version" {
+			return &clusterpb.ClusterView{}
+		}
+	case "node":
+		return &clusterpb.Node{}
+	}
+	return nil
+}
+
+// listKVs inspects the raw keys under the root path, which is disabled unless it is enabled by the config.
+func (a *API) listKVs(req *http.Request) apiFuncResult {
+	if !a.enableDebugKV {
+		return errResult(ErrDebugKVDisabled, "enable-debug-kv is not set")
+	}
+
+	prefix := req.URL.Query().Get(prefixParam)
+	if strings.Contains(prefix, "..") {
+		return errResult(ErrParseRequest, fmt.Sprintf("invalid %s:%s", prefixParam, prefix))
+	}
+	limit := defaultDebugKVLimit
+	if value := req.URL.Query().Get(limitParam); len(value) > 0 {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			return errResult(ErrParseRequest, fmt.Sprintf("invalid %s:%s", limitParam, value))
+		}
+		limit = min(parsed, maxDebugKVLimit)
+	}
+
+	result, err := a.storageInspector.listKVs(req.Context(), prefix, limit)
+	if err != nil {
+		log.Error("list kvs failed", zap.String("prefix", prefix), zap.Error(err))
+		return errResult(ErrDebugKV, err.Error())
+	}
+	return okResult(result)
+}
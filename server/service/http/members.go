@@ -0,0 +1,134 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"sort"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/member"
+	"go.uber.org/zap"
+)
+
+type MemberStatus struct {
+	Name string `json:"name"`
+	// The etcd fields are empty if the member is not found in the etcd cluster, e.g. the etcd is not embedded.
+	EtcdMemberID   uint64   `json:"etcdMemberID"`
+	EtcdPeerURLs   []string `json:"etcdPeerURLs"`
+	EtcdClientURLs []string `json:"etcdClientURLs"`
+	IsLearner      bool     `json:"isLearner"`
+	IsEtcdLeader   bool     `json:"isEtcdLeader"`
+	// Registration is nil if the member is not alive or has not registered yet.
+	Registration *member.Registration `json:"registration"`
+	IsLeader     bool                 `json:"isLeader"`
+}
+
+type ListMembersResponse struct {
+	// Election is nil if it can't be got, e.g. the leader is being elected, and the reason is told by ElectionError.
+	Election      *member.ElectionStatus `json:"election"`
+	ElectionError string                 `json:"electionError"`
+	Members       []MemberStatus         `json:"members"`
+}
+
+// listMembers lists the members of the horaemeta cluster from both the etcd membership and the registrations of the
+// members, so it is not forwarded to the leader.
+func (a *API) listMembers(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	etcdClient := a.etcdAPI.etcdClient
+
+	memberListResp, err := etcdClient.MemberList(ctx)
+	if err != nil {
+		log.Error("list members failed", zap.Error(err))
+		return errResult(ErrListMembers, err.Error())
+	}
+	registrations, err := a.forwardClient.member.ListRegistrations(ctx)
+	if err != nil {
+		log.Error("list registrations failed", zap.Error(err))
+		return errResult(ErrListMembers, err.Error())
+	}
+
+	resp := ListMembersResponse{
+		Election:      nil,
+		ElectionError: "",
+		Members:       nil,
+	}
+	election, err := a.forwardClient.member.GetElectionStatus(ctx)
+	if err != nil {
+		resp.ElectionError = err.Error()
+	} else {
+		resp.Election = &election
+	}
+
+	etcdLeaderID := a.getEtcdLeaderID(ctx)
+	membersByName := make(map[string]*MemberStatus, len(memberListResp.Members))
+	for _, etcdMember := range memberListResp.Members {
+		membersByName[etcdMember.Name] = &MemberStatus{
+			Name:           etcdMember.Name,
+			EtcdMemberID:   etcdMember.ID,
+			EtcdPeerURLs:   etcdMember.PeerURLs,
+			EtcdClientURLs: etcdMember.ClientURLs,
+			IsLearner:      etcdMember.IsLearner,
+			IsEtcdLeader:   etcdMember.ID == etcdLeaderID,
+			Registration:   nil,
+			IsLeader:       false,
+		}
+	}
+	for i := range registrations {
+		registration := registrations[i]
+		status, ok := membersByName[registration.Name]
+		if !ok {
+			status = &MemberStatus{
+				Name:           registration.Name,
+				EtcdMemberID:   0,
+				EtcdPeerURLs:   nil,
+				EtcdClientURLs: nil,
+				IsLearner:      false,
+				IsEtcdLeader:   false,
+				Registration:   nil,
+				IsLeader:       false,
+			}
+			membersByName[registration.Name] = status
+		}
+		status.Registration = &registration
+	}
+
+	resp.Members = make([]MemberStatus, 0, len(membersByName))
+	for _, status := range membersByName {
+		status.IsLeader = resp.Election != nil && status.Name == resp.Election.LeaderName
+		resp.Members = append(resp.Members, *status)
+	}
+	sort.Slice(resp.Members, func(i, j int) bool {
+		return resp.Members[i].Name < resp.Members[j].Name
+	})
+	return okResult(resp)
+}
+
+// getEtcdLeaderID returns the id of the etcd leader from the status of any reachable endpoint, and 0 if it is unknown.
+func (a *API) getEtcdLeaderID(ctx context.Context) uint64 {
+	etcdClient := a.etcdAPI.etcdClient
+	for _, endpoint := range etcdClient.Endpoints() {
+		statusResp, err := etcdClient.Status(ctx, endpoint)
+		if err != nil {
+			log.Warn("get etcd status failed", zap.String("endpoint", endpoint), zap.Error(err))
+			continue
+		}
+		return statusResp.Leader
+	}
+	return 0
+}
@@ -0,0 +1,289 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/clusterpb"
+	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/cluster"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/config"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+const (
+	exportSchemasParam = "schemas"
+
+	defaultImportBatchSize = 100
+)
+
+// MetadataManifest describes the schemas and the tables of a cluster without the shard assignment, which is exported
+// from a cluster and imported into another one, e.g. to migrate the metadata between the environments.
+type MetadataManifest struct {
+	ClusterName string           `json:"clusterName"`
+	Schemas     []ManifestSchema `json:"schemas"`
+}
+
+type ManifestSchema struct {
+	Name string `json:"name"`
+	// Settings are not changed by the import if they are absent.
+	Settings *storage.SchemaSettings `json:"settings"`
+	Tables   []ManifestTable         `json:"tables"`
+}
+
+type ManifestTable struct {
+	Name string `json:"name"`
+	// PartitionInfo is the partition info of the partition table encoded by protojson, which is absent for the others.
+	PartitionInfo json.RawMessage `json:"partitionInfo,omitempty"`
+	// The table schema isn't kept by the metadata, so the fields below are absent in the exported manifest, and they
+	// are passed to the shards as is if they are filled before the import.
+	EncodedSchema []byte            `json:"encodedSchema,omitempty"`
+	Engine        string            `json:"engine,omitempty"`
+	Options       map[string]string `json:"options,omitempty"`
+}
+
+type ImportMetadataRequest struct {
+	Schemas []ManifestSchema `json:"schemas"`
+	// BatchSize is the max number of the tables created by a procedure, and the default one is used if it is 0.
+	BatchSize int `json:"batchSize"`
+}
+
+type ImportSchemaResult struct {
+	Name          string `json:"name"`
+	SchemaCreated bool   `json:"schemaCreated"`
+	// SkippedTables exist in the cluster already.
+	SkippedTables []string `json:"skippedTables"`
+	// PartitionTables are the partition tables created, whose metadata is the only part of them.
+	PartitionTables []string                   `json:"partitionTables"`
+	Batches         []BatchCreateTableResponse `json:"batches"`
+}
+
+type ImportMetadataResult struct {
+	Schemas []ImportSchemaResult `json:"schemas"`
+}
+
+func (a *API) exportMetadata(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	var schemaNames []string
+	if schemas := r.URL.Query().Get(exportSchemasParam); len(schemas) > 0 {
+		schemaNames = strings.Split(schemas, ",")
+	} else {
+		for _, info := range c.GetMetadata().ListSchemaInfos() {
+			schemaNames = append(schemaNames, info.Name)
+		}
+	}
+
+	manifest := MetadataManifest{
+		ClusterName: clusterName,
+		Schemas:     make([]ManifestSchema, 0, len(schemaNames)),
+	}
+	for _, schemaName := range schemaNames {
+		info, ok := c.GetMetadata().GetSchemaInfo(schemaName)
+		if !ok {
+			return errResult(metadata.ErrSchemaNotFound, fmt.Sprintf("clusterName: %s, schemaName: %s", clusterName, schemaName))
+		}
+		schema, err := exportSchema(c, info)
+		if err != nil {
+			log.Error("export schema failed", zap.String("clusterName", clusterName), zap.String("schemaName", schemaName), zap.Error(err))
+			return errResult(ErrExportMetadata, fmt.Sprintf("schemaName: %s, err: %s", schemaName, err.Error()))
+		}
+		manifest.Schemas = append(manifest.Schemas, schema)
+	}
+
+	return okResult(manifest)
+}
+
+func exportSchema(c *cluster.Cluster, info metadata.SchemaInfo) (ManifestSchema, error) {
+	tables, err := c.GetMetadata().GetTablesByPrefix(info.Name, "")
+	if err != nil {
+		var emptySchema ManifestSchema
+		return emptySchema, err
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		return tables[i].Name < tables[j].Name
+	})
+
+	schema := ManifestSchema{
+		Name:     info.Name,
+		Settings: info.Settings,
+		Tables:   make([]ManifestTable, 0, len(tables)),
+	}
+	for _, table := range tables {
+		manifestTable := ManifestTable{
+			Name:          table.Name,
+			PartitionInfo: nil,
+			EncodedSchema: nil,
+			Engine:        "",
+			Options:       nil,
+		}
+		if table.IsPartitioned() {
+			partitionInfo, err := protojson.Marshal(table.PartitionInfo.Info)
+			if err != nil {
+				var emptySchema ManifestSchema
+				return emptySchema, ErrExportMetadata.WithCausef("encode partition info, tableName:%s, err:%v", table.Name, err)
+			}
+			manifestTable.PartitionInfo = partitionInfo
+		}
+		schema.Tables = append(schema.Tables, manifestTable)
+	}
+	return schema, nil
+}
+
+// importMetadata creates the schemas and the tables missing in the cluster, where the tables are created by the batch
+// create table procedures and the existing ones are skipped, so the import can be retried after a partial failure.
+func (a *API) importMetadata(r *http.Request) apiFuncResult {
+	ctx := r.Context()
+	clusterName := Param(ctx, clusterNameParam)
+	if len(clusterName) == 0 {
+		clusterName = config.DefaultClusterName
+	}
+
+	var req ImportMetadataRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+	if req.BatchSize < 0 {
+		return errResult(ErrInvalidManifest, fmt.Sprintf("batch size should not be negative, batchSize: %d", req.BatchSize))
+	}
+	if req.BatchSize == 0 {
+		req.BatchSize = defaultImportBatchSize
+	}
+	log.Info("import metadata request", zap.String("clusterName", clusterName), zap.Int("schemaCount", len(req.Schemas)), zap.Int("batchSize", req.BatchSize))
+
+	c, err := a.clusterManager.GetCluster(ctx, clusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", clusterName, err.Error()))
+	}
+
+	result := ImportMetadataResult{Schemas: make([]ImportSchemaResult, 0, len(req.Schemas))}
+	for _, schema := range req.Schemas {
+		schemaResult := ImportSchemaResult{
+			Name:            schema.Name,
+			SchemaCreated:   false,
+			SkippedTables:   []string{},
+			PartitionTables: []string{},
+			Batches:         []BatchCreateTableResponse{},
+		}
+		if errResult := importSchema(ctx, c, schema, req.BatchSize, &schemaResult); errResult != nil {
+			log.Error("import schema failed", zap.String("clusterName", clusterName), zap.String("schemaName", schema.Name), zap.String("errMsg", errResult.errMsg))
+			if errResult.data != nil {
+				return *errResult
+			}
+			// The schemas imported are returned, so that the progress is known before the import is retried.
+			return errResultWithData(errResult.err, errResult.errMsg, ImportMetadataResult{Schemas: append(result.Schemas, schemaResult)})
+		}
+		result.Schemas = append(result.Schemas, schemaResult)
+	}
+
+	return okResult(result)
+}
+
+func importSchema(ctx context.Context, c *cluster.Cluster, schema ManifestSchema, batchSize int, result *ImportSchemaResult) *apiFuncResult {
+	if len(schema.Name) == 0 {
+		errResult := errResult(ErrInvalidManifest, "schema name could not be empty")
+		return &errResult
+	}
+
+	_, created, err := c.GetMetadata().GetOrCreateSchema(ctx, schema.Name)
+	if err != nil {
+		errResult := errResult(ErrImportMetadata, fmt.Sprintf("get or create schema, schemaName: %s, err: %s", schema.Name, err.Error()))
+		return &errResult
+	}
+	result.SchemaCreated = created
+	if schema.Settings != nil {
+		if err := c.GetMetadata().UpdateSchemaSettings(ctx, schema.Name, *schema.Settings); err != nil {
+			errResult := errResult(ErrInvalidSchemaSettings, fmt.Sprintf("schemaName: %s, err: %s", schema.Name, err.Error()))
+			return &errResult
+		}
+	}
+
+	sourceReqs := make([]*metaservicepb.CreateTableRequest, 0, len(schema.Tables))
+	for _, table := range schema.Tables {
+		_, exists, err := c.GetMetadata().GetTable(schema.Name, table.Name)
+		if err != nil {
+			errResult := errResult(ErrImportMetadata, fmt.Sprintf("get table, schemaName: %s, tableName: %s, err: %s", schema.Name, table.Name, err.Error()))
+			return &errResult
+		}
+		if exists {
+			result.SkippedTables = append(result.SkippedTables, table.Name)
+			continue
+		}
+
+		// The partition table only lives in the metadata, and its sub tables are the ordinary tables in the manifest.
+		if len(table.PartitionInfo) > 0 {
+			partitionInfo := &clusterpb.PartitionInfo{}
+			if err := protojson.Unmarshal(table.PartitionInfo, partitionInfo); err != nil {
+				errResult := errResult(ErrInvalidManifest, fmt.Sprintf("decode partition info, schemaName: %s, tableName: %s, err: %s", schema.Name, table.Name, err.Error()))
+				return &errResult
+			}
+			if _, err := c.GetMetadata().CreateTableMetadata(ctx, metadata.CreateTableMetadataRequest{
+				SchemaName:    schema.Name,
+				TableName:     table.Name,
+				PartitionInfo: storage.PartitionInfo{Info: partitionInfo},
+			}); err != nil {
+				errResult := errResult(ErrImportMetadata, fmt.Sprintf("create partition table metadata, schemaName: %s, tableName: %s, err: %s", schema.Name, table.Name, err.Error()))
+				return &errResult
+			}
+			result.PartitionTables = append(result.PartitionTables, table.Name)
+			continue
+		}
+
+		sourceReqs = append(sourceReqs, &metaservicepb.CreateTableRequest{
+			Header:             nil,
+			SchemaName:         schema.Name,
+			Name:               table.Name,
+			EncodedSchema:      table.EncodedSchema,
+			Engine:             table.Engine,
+			CreateIfNotExist:   true,
+			Options:            table.Options,
+			PartitionTableInfo: nil,
+		})
+	}
+
+	for start := 0; start < len(sourceReqs); start += batchSize {
+		end := start + batchSize
+		if end > len(sourceReqs) {
+			end = len(sourceReqs)
+		}
+		batch, errResult := runBatchCreateTables(ctx, c, schema.Name, sourceReqs[start:end])
+		if errResult != nil {
+			return errResult
+		}
+		result.Batches = append(result.Batches, batch)
+	}
+	return nil
+}
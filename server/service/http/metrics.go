@@ -0,0 +1,31 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// requestDuration observes the latency of the http requests by the routes, including the ones forwarded to the leader.
+var requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "horaemeta",
+	Subsystem: "http",
+	Name:      "request_duration_seconds",
+	Help:      "Duration of handling the http requests.",
+	Buckets:   prometheus.ExponentialBuckets(0.001, 2, 16),
+}, []string{"handler", "method", "code"})
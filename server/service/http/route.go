@@ -19,8 +19,12 @@ package http
 import (
 	"context"
 	"net/http"
+	"time"
 
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/pkg/requestid"
 	"github.com/julienschmidt/httprouter"
+	"go.uber.org/zap"
 )
 
 type param string
@@ -28,24 +32,41 @@ type param string
 const DebugPrefix = "/debug"
 
 // Router wraps httprouter.Router and adds support for prefixed sub-routers,
-// per-request context injections and instrumentation.
+// per-request context injections, request ids, timeouts, compression and instrumentation.
 type Router struct {
 	rtr    *httprouter.Router
 	prefix string
 	instrh func(handlerName string, handler http.HandlerFunc) http.HandlerFunc
+	// timeout bounds the context of the requests, and 0 means no timeout.
+	timeout time.Duration
+	// compress compresses the responses in the encoding accepted by the client.
+	compress bool
 }
 
 func New() *Router {
 	return &Router{
-		rtr:    httprouter.New(),
-		prefix: "",
-		instrh: nil,
+		rtr:      httprouter.New(),
+		prefix:   "",
+		instrh:   nil,
+		timeout:  0,
+		compress: false,
 	}
 }
 
 // WithPrefix returns a router that prefixes all registered routes with prefix.
 func (r *Router) WithPrefix(prefix string) *Router {
-	return &Router{rtr: r.rtr, prefix: r.prefix + prefix, instrh: r.instrh}
+	return &Router{rtr: r.rtr, prefix: r.prefix + prefix, instrh: r.instrh, timeout: r.timeout, compress: r.compress}
+}
+
+// WithTimeout returns a router whose registered routes time out after timeout, and 0 means no timeout.
+func (r *Router) WithTimeout(timeout time.Duration) *Router {
+	return &Router{rtr: r.rtr, prefix: r.prefix, instrh: r.instrh, timeout: timeout, compress: r.compress}
+}
+
+// WithCompression returns a router compressing the large responses in gzip or deflate negotiated by the Accept-Encoding
+// header.
+func (r *Router) WithCompression() *Router {
+	return &Router{rtr: r.rtr, prefix: r.prefix, instrh: r.instrh, timeout: r.timeout, compress: true}
 }
 
 // WithInstrumentation returns a router with instrumentation support.
@@ -56,7 +77,7 @@ func (r *Router) WithInstrumentation(instrh func(handlerName string, handler htt
 			return newInstrh(handlerName, r.instrh(handlerName, handler))
 		}
 	}
-	return &Router{rtr: r.rtr, prefix: r.prefix, instrh: instrh}
+	return &Router{rtr: r.rtr, prefix: r.prefix, instrh: instrh, timeout: r.timeout, compress: r.compress}
 }
 
 // ServeHTTP implements http.Handler.
@@ -84,6 +105,11 @@ func (r *Router) Del(path string, h http.HandlerFunc) {
 	r.rtr.DELETE(r.prefix+path, r.handle(path, h))
 }
 
+// DebugDel registers a new DELETE route without prefix.
+func (r *Router) DebugDel(path string, h http.HandlerFunc) {
+	r.rtr.DELETE(DebugPrefix+path, r.handle(path, h))
+}
+
 // Put registers a new PUT route.
 func (r *Router) Put(path string, h http.HandlerFunc) {
 	r.rtr.PUT(r.prefix+path, r.handle(path, h))
@@ -110,13 +136,37 @@ func (r *Router) handle(handlerName string, h http.HandlerFunc) httprouter.Handl
 		// This needs to be outside the closure to avoid data race when reading and writing to 'h'.
 		h = r.instrh(handlerName, h)
 	}
+	timeout := r.timeout
+	compress := r.compress
 	return func(w http.ResponseWriter, req *http.Request, params httprouter.Params) {
-		ctx, cancel := context.WithCancel(req.Context())
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(req.Context(), timeout)
+		} else {
+			ctx, cancel = context.WithCancel(req.Context())
+		}
 		defer cancel()
 
 		for _, p := range params {
 			ctx = context.WithValue(ctx, param(p.Key), p.Value)
 		}
+
+		// The request id is set on the request too, so that the leader serving the forwarded request keeps it.
+		requestID := requestid.Resolve(req.Header.Get(requestid.Header))
+		ctx = requestid.WithID(ctx, requestID)
+		req.Header.Set(requestid.Header, requestID)
+		w.Header().Set(requestid.Header, requestID)
+
+		if encoding := negotiateEncoding(req.Header.Get("Accept-Encoding")); compress && len(encoding) > 0 {
+			cw := newCompressResponseWriter(w, encoding, req.Method == http.MethodHead)
+			defer func() {
+				if err := cw.close(); err != nil {
+					log.Warn("close compressed response failed", zap.Error(err), requestid.Field(ctx))
+				}
+			}()
+			w = cw
+		}
 		h(w, req.WithContext(ctx))
 	}
 }
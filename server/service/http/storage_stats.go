@@ -0,0 +1,208 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/etcdutil"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+const (
+	storageStatsScanBatchSize = 1000
+
+	keyCategoryClusters     = "clusters"
+	keyCategorySchemas      = "schemas"
+	keyCategoryTables       = "tables"
+	keyCategoryShardViews   = "shardViews"
+	keyCategoryClusterViews = "clusterViews"
+	keyCategoryNodes        = "nodes"
+	keyCategoryProcedures   = "procedures"
+	keyCategoryOthers       = "others"
+)
+
+type KeyStats struct {
+	KeyCount int64 `json:"keyCount"`
+	// Bytes is the total size of the keys and the values.
+	Bytes int64 `json:"bytes"`
+}
+
+type EndpointStatus struct {
+	Endpoint    string `json:"endpoint"`
+	MemberID    uint64 `json:"memberID"`
+	IsLeader    bool   `json:"isLeader"`
+	DBSize      int64  `json:"dbSize"`
+	DBSizeInUse int64  `json:"dbSizeInUse"`
+	Error       string `json:"error"`
+}
+
+type Alarm struct {
+	MemberID uint64 `json:"memberID"`
+	Alarm    string `json:"alarm"`
+}
+
+type StorageStats struct {
+	RootPath   string              `json:"rootPath"`
+	Total      KeyStats            `json:"total"`
+	Categories map[string]KeyStats `json:"categories"`
+	// QuotaBytes is the backend quota of the embedded etcd, and 0 means the default quota of etcd is used.
+	QuotaBytes int64            `json:"quotaBytes"`
+	Endpoints  []EndpointStatus `json:"endpoints"`
+	Alarms     []Alarm          `json:"alarms"`
+}
+
+// StorageInspector reports the usage of the keys under the root path and the status of etcd, so that the runaway growth of
+// the metadata can be caught before etcd hits its quota.
+type StorageInspector struct {
+	client     *clientv3.Client
+	rootPath   string
+	quotaBytes int64
+}
+
+func NewStorageInspector(client *clientv3.Client, rootPath string, quotaBytes int64) *StorageInspector {
+	return &StorageInspector{
+		client:     client,
+		rootPath:   rootPath,
+		quotaBytes: quotaBytes,
+	}
+}
+
+func (i *StorageInspector) collect(ctx context.Context) (StorageStats, error) {
+	stats := StorageStats{
+		RootPath:   i.rootPath,
+		Total:      KeyStats{KeyCount: 0, Bytes: 0},
+		Categories: make(map[string]KeyStats),
+		QuotaBytes: i.quotaBytes,
+		Endpoints:  make([]EndpointStatus, 0, len(i.client.Endpoints())),
+		Alarms:     make([]Alarm, 0),
+	}
+
+	prefix := strings.TrimSuffix(i.rootPath, "/") + "/"
+	err := etcdutil.Scan(ctx, i.client, prefix, clientv3.GetPrefixRangeEnd(prefix), storageStatsScanBatchSize, func(key string, val []byte) error {
+		size := int64(len(key) + len(val))
+		category := categorizeKey(strings.TrimPrefix(key, prefix))
+		categoryStats := stats.Categories[category]
+		categoryStats.KeyCount++
+		categoryStats.Bytes += size
+		stats.Categories[category] = categoryStats
+		stats.Total.KeyCount++
+		stats.Total.Bytes += size
+		return nil
+	})
+	if err != nil {
+		return stats, errors.WithMessagef(err, "scan keys, prefix:%s", prefix)
+	}
+
+	// The status of the unreachable endpoint is reported with the error instead of failing the whole stats.
+	for _, endpoint := range i.client.Endpoints() {
+		status := EndpointStatus{
+			Endpoint:    endpoint,
+			MemberID:    0,
+			IsLeader:    false,
+			DBSize:      0,
+			DBSizeInUse: 0,
+			Error:       "",
+		}
+		resp, err := i.client.Status(ctx, endpoint)
+		if err != nil {
+			log.Warn("get etcd endpoint status failed", zap.String("endpoint", endpoint), zap.Error(err))
+			status.Error = err.Error()
+		} else {
+			status.MemberID = resp.Header.MemberId
+			status.IsLeader = resp.Leader == resp.Header.MemberId
+			status.DBSize = resp.DbSize
+			status.DBSizeInUse = resp.DbSizeInUse
+		}
+		stats.Endpoints = append(stats.Endpoints, status)
+	}
+
+	alarmResp, err := i.client.AlarmList(ctx)
+	if err != nil {
+		return stats, errors.WithMessage(err, "list alarms")
+	}
+	for _, alarm := range alarmResp.Alarms {
+		stats.Alarms = append(stats.Alarms, Alarm{
+			MemberID: alarm.MemberID,
+			Alarm:    alarm.Alarm.String(),
+		})
+	}
+
+	return stats, nil
+}
+
+// categorizeKey returns the category of the key relative to the root path, and the layout of the keys is defined by the
+// storage and the procedure storage:
+//
+//	v1/cluster/info/1 -> clusters
+//	v1/cluster/1/options -> clusters
+//	v1/cluster/1/schema/info/1 -> schemas
+//	v1/cluster/1/schema/1/table/1 -> tables
+//	v1/cluster/1/schema/1/table_name_to_id/table1 -> tables
+//	v1/cluster/1/shard_view/1/latest_version -> shardViews
+//	v1/cluster/1/cluster_view/latest_version -> clusterViews
+//	v1/cluster/1/node/127.0.0.1:8081 -> nodes
+//	v1/procedure/1/0/1 -> procedures
+//	v1/deletedProcedure/1/0/1 -> procedures
+func categorizeKey(key string) string {
+	parts := strings.Split(key, "/")
+	if len(parts) < 3 || parts[0] != "v1" {
+		return keyCategoryOthers
+	}
+
+	if parts[1] == "procedure" || parts[1] == "deletedProcedure" {
+		return keyCategoryProcedures
+	}
+	if parts[1] != "cluster" {
+		return keyCategoryOthers
+	}
+
+	if parts[2] == "info" || len(parts) < 4 {
+		return keyCategoryClusters
+	}
+	switch parts[3] {
+	case "options":
+		return keyCategoryClusters
+	case "schema":
+		if len(parts) >= 6 && (parts[5] == "table" || parts[5] == "table_name_to_id") {
+			return keyCategoryTables
+		}
+		return keyCategorySchemas
+	case "shard_view":
+		return keyCategoryShardViews
+	case "cluster_view":
+		return keyCategoryClusterViews
+	case "node":
+		return keyCategoryNodes
+	default:
+		return keyCategoryOthers
+	}
+}
+
+func (a *API) getStorageStats(req *http.Request) apiFuncResult {
+	stats, err := a.storageInspector.collect(req.Context())
+	if err != nil {
+		log.Error("collect storage stats failed", zap.Error(err))
+		return errResult(ErrStorageStats, err.Error())
+	}
+	return okResult(stats)
+}
@@ -0,0 +1,172 @@
+// Copyright 2015 The Prometheus Authors
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file is copied from:
+// https://github.com/prometheus/common/blob/8c9cb3fa6d01832ea16937b20ea561eed81abd2f/route/route.go
+
+package http
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/pkg/requestid"
+	"go.uber.org/zap"
+)
+
+const (
+	// streamParam is the query param asking for the list to be streamed, e.g. "?stream=true".
+	streamParam = "stream"
+	// streamFlushItems is the number of the items sent to the client at a time when the list is streamed.
+	streamFlushItems = 256
+	streamBufferSize = 32 * 1024
+)
+
+func isStreamRequested(r *http.Request) bool {
+	stream, err := strconv.ParseBool(r.URL.Query().Get(streamParam))
+	return err == nil && stream
+}
+
+// respondStream writes the list or the map in data item by item with the chunked encoding, so that the large listing is
+// not encoded into one buffer and the client receives it progressively. It returns false without writing anything if
+// data is neither a list nor a map, which is responded as usual.
+//
+// The status is sent before the items are encoded, so the response is truncated if any item fails to be encoded, which
+// the client sees as an invalid json.
+func respondStream(w http.ResponseWriter, r *http.Request, data interface{}) bool {
+	v := reflect.ValueOf(data)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Map:
+		if v.IsNil() {
+			return false
+		}
+	case reflect.Array:
+	default:
+		return false
+	}
+
+	requestID, err := json.Marshal(requestid.FromContext(r.Context()))
+	if err != nil {
+		return false
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	sw := &streamWriter{
+		bw: bufio.NewWriterSize(w, streamBufferSize),
+		rc: http.NewResponseController(w),
+	}
+	sw.writeString(fmt.Sprintf(`{"status":%q,"requestID":%s,"data":`, statusSuccess, requestID))
+	if v.Kind() == reflect.Map {
+		sw.writeMap(v)
+	} else {
+		sw.writeList(v)
+	}
+	sw.writeString("}")
+	sw.flush()
+
+	if sw.err != nil {
+		log.Error("stream response failed", zap.Error(sw.err), requestid.Field(r.Context()))
+	}
+	return true
+}
+
+// streamWriter keeps the first error, and it writes nothing after the error.
+type streamWriter struct {
+	bw  *bufio.Writer
+	rc  *http.ResponseController
+	err error
+}
+
+func (w *streamWriter) writeString(s string) {
+	if w.err == nil {
+		_, w.err = w.bw.WriteString(s)
+	}
+}
+
+func (w *streamWriter) write(b []byte) {
+	if w.err == nil {
+		_, w.err = w.bw.Write(b)
+	}
+}
+
+func (w *streamWriter) flush() {
+	if w.err == nil {
+		w.err = w.bw.Flush()
+	}
+	if w.err == nil {
+		// The response is still sent in chunks by the buffer even if it can't be flushed explicitly.
+		if err := w.rc.Flush(); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			w.err = err
+		}
+	}
+}
+
+func (w *streamWriter) writeList(v reflect.Value) {
+	w.writeString("[")
+	for i := 0; i < v.Len() && w.err == nil; i++ {
+		if i > 0 {
+			w.writeString(",")
+		}
+		b, err := json.Marshal(v.Index(i).Interface())
+		if err != nil {
+			w.err = err
+			return
+		}
+		w.write(b)
+		if (i+1)%streamFlushItems == 0 {
+			w.flush()
+		}
+	}
+	w.writeString("]")
+}
+
+// writeMap writes the entries sorted by the keys like json.Marshal, and each entry is encoded as a map of itself so that
+// the key is encoded in the same way.
+func (w *streamWriter) writeMap(v reflect.Value) {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+	})
+
+	w.writeString("{")
+	entry := reflect.MakeMapWithSize(v.Type(), 1)
+	for i, key := range keys {
+		if w.err != nil {
+			return
+		}
+		if i > 0 {
+			w.writeString(",")
+		}
+		entry.SetMapIndex(key, v.MapIndex(key))
+		b, err := json.Marshal(entry.Interface())
+		entry.SetMapIndex(key, reflect.Value{})
+		if err != nil {
+			w.err = err
+			return
+		}
+		// Strip the braces of the map of the entry.
+		w.write(b[1 : len(b)-1])
+		if (i+1)%streamFlushItems == 0 {
+			w.flush()
+		}
+	}
+	w.writeString("}")
+}
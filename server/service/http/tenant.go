@@ -0,0 +1,259 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/cluster"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"go.uber.org/zap"
+)
+
+// probeHandlerNames are the routes of the health check and the metrics, which are allowed without the tenant token even if
+// it is required, so that the probes and the scrapers keep working.
+var probeHandlerNames = map[string]struct{}{
+	"/health":  {},
+	"/metrics": {},
+}
+
+type tenantContextKey struct{}
+
+// tenantFromContext returns the tenant authenticated by the token of the request, the second output parameter bool:
+// returns true if the request carries a tenant token.
+func tenantFromContext(ctx context.Context) (cluster.Tenant, bool) {
+	tenant, ok := ctx.Value(tenantContextKey{}).(cluster.Tenant)
+	return tenant, ok
+}
+
+// isTenantAdminRoute returns true if the route manages the tenants or creates the clusters, which only the admin token can
+// access.
+func isTenantAdminRoute(handlerName string, req *http.Request) bool {
+	return strings.HasPrefix(handlerName, "/tenants") || (handlerName == "/clusters" && req.Method != http.MethodGet)
+}
+
+// requestScope is the cluster and the schema given in the body of the request.
+type requestScope struct {
+	ClusterName string `json:"clusterName"`
+	SchemaName  string `json:"schemaName"`
+}
+
+// peekRequestScope decodes the scope from the body of the request, and the body is restored for the handler.
+func peekRequestScope(req *http.Request) (requestScope, error) {
+	var scope requestScope
+	if req.Body == nil || req.Body == http.NoBody {
+		return scope, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return scope, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	if len(body) == 0 {
+		return scope, nil
+	}
+	if err := json.Unmarshal(body, &scope); err != nil {
+		return scope, err
+	}
+	return scope, nil
+}
+
+// authorizeTenant restricts the requests carrying the tenant token to the resources of the tenant, and the requests
+// without the token are handled as usual unless the token is required, in which case only the probes are allowed. The
+// admin token is accepted in the place of the tenant token to access all the routes.
+//
+// With the tenant token, the routes of a cluster are accessible only if the tenant owns the whole cluster, or the schema
+// given in the route, and the cluster and the schema are taken from the body if the route has no cluster. Listing the
+// clusters is also allowed and filtered by the tenant, while the management of the tenants, the creation of the clusters
+// and the routes of no cluster are denied.
+func (a *API) authorizeTenant(handlerName string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		presentedToken := req.Header.Get(tenantTokenHeader)
+		if len(presentedToken) == 0 {
+			if _, isProbe := probeHandlerNames[handlerName]; a.tenantTokenRequired && !isProbe {
+				respondError(w, req, ErrTenantTokenRequired, "handler:"+handlerName, nil)
+				return
+			}
+			handler(w, req)
+			return
+		}
+		if len(a.tenantAdminToken) > 0 && subtle.ConstantTimeCompare([]byte(presentedToken), []byte(a.tenantAdminToken)) == 1 {
+			handler(w, req)
+			return
+		}
+
+		ctx := req.Context()
+		tenant, err := a.clusterManager.AuthenticateTenant(ctx, presentedToken)
+		if err != nil {
+			log.Warn("authenticate tenant failed", zap.String("handlerName", handlerName), zap.Error(err))
+			if coderr.Is(err, metadata.ErrInvalidTenantToken.Code()) {
+				respondError(w, req, ErrInvalidTenantToken, err.Error(), nil)
+				return
+			}
+			respondError(w, req, ErrTenant, err.Error(), nil)
+			return
+		}
+
+		clusterName := Param(ctx, clusterNameParam)
+		allowed := false
+		switch {
+		case isTenantAdminRoute(handlerName, req):
+		case len(clusterName) > 0:
+			allowed = tenant.CanAccess(clusterName, Param(ctx, schemaNameParam))
+		case handlerName == "/clusters" && req.Method == http.MethodGet:
+			allowed = true
+		default:
+			scope, err := peekRequestScope(req)
+			if err != nil {
+				respondError(w, req, ErrParseRequest, err.Error(), nil)
+				return
+			}
+			allowed = len(scope.ClusterName) > 0 && tenant.CanAccess(scope.ClusterName, scope.SchemaName)
+		}
+		if !allowed {
+			respondError(w, req, ErrTenantAccessDenied, "tenant:"+tenant.Name, nil)
+			return
+		}
+
+		handler(w, req.WithContext(context.WithValue(ctx, tenantContextKey{}, tenant)))
+	}
+}
+
+func (a *API) listTenants(req *http.Request) apiFuncResult {
+	tenants, err := a.clusterManager.ListTenants(req.Context())
+	if err != nil {
+		return errResult(ErrTenant, err.Error())
+	}
+	return okResult(tenants)
+}
+
+func (a *API) getTenant(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	tenantName := Param(ctx, tenantNameParam)
+	if len(tenantName) == 0 {
+		return errResult(ErrParseRequest, "tenantName could not be empty")
+	}
+
+	tenant, err := a.clusterManager.GetTenant(ctx, tenantName)
+	if err != nil {
+		return errResult(ErrTenant, err.Error())
+	}
+	return okResult(tenant)
+}
+
+func (a *API) putTenant(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	tenantName := Param(ctx, tenantNameParam)
+	if len(tenantName) == 0 {
+		return errResult(ErrParseRequest, "tenantName could not be empty")
+	}
+
+	var putTenantRequest PutTenantRequest
+	if err := json.NewDecoder(req.Body).Decode(&putTenantRequest); err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+
+	tenant, err := a.clusterManager.PutTenant(ctx, cluster.Tenant{
+		Name:      tenantName,
+		Clusters:  putTenantRequest.Clusters,
+		CreatedAt: 0,
+	})
+	if err != nil {
+		log.Error("put tenant failed", zap.String("tenantName", tenantName), zap.Error(err))
+		return errResult(ErrTenant, err.Error())
+	}
+	return okResult(tenant)
+}
+
+func (a *API) deleteTenant(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	tenantName := Param(ctx, tenantNameParam)
+	if len(tenantName) == 0 {
+		return errResult(ErrParseRequest, "tenantName could not be empty")
+	}
+
+	if err := a.clusterManager.DeleteTenant(ctx, tenantName); err != nil {
+		log.Error("delete tenant failed", zap.String("tenantName", tenantName), zap.Error(err))
+		return errResult(ErrTenant, err.Error())
+	}
+	return okResult(tenantName)
+}
+
+func (a *API) listTenantTokens(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	tenantName := Param(ctx, tenantNameParam)
+	if len(tenantName) == 0 {
+		return errResult(ErrParseRequest, "tenantName could not be empty")
+	}
+
+	tokens, err := a.clusterManager.ListTenantTokens(ctx, tenantName)
+	if err != nil {
+		return errResult(ErrTenant, err.Error())
+	}
+
+	tokenInfos := make([]TenantTokenInfo, 0, len(tokens))
+	for _, token := range tokens {
+		tokenInfos = append(tokenInfos, TenantTokenInfo{
+			ID:        token.ID,
+			CreatedAt: token.CreatedAt,
+		})
+	}
+	return okResult(tokenInfos)
+}
+
+func (a *API) createTenantToken(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	tenantName := Param(ctx, tenantNameParam)
+	if len(tenantName) == 0 {
+		return errResult(ErrParseRequest, "tenantName could not be empty")
+	}
+
+	token, presentedToken, err := a.clusterManager.CreateTenantToken(ctx, tenantName)
+	if err != nil {
+		log.Error("create tenant token failed", zap.String("tenantName", tenantName), zap.Error(err))
+		return errResult(ErrTenant, err.Error())
+	}
+
+	return okResult(CreateTenantTokenResponse{
+		ID:        token.ID,
+		Token:     presentedToken,
+		CreatedAt: token.CreatedAt,
+	})
+}
+
+func (a *API) revokeTenantToken(req *http.Request) apiFuncResult {
+	ctx := req.Context()
+	tenantName := Param(ctx, tenantNameParam)
+	tokenID := Param(ctx, tokenIDParam)
+	if len(tenantName) == 0 || len(tokenID) == 0 {
+		return errResult(ErrParseRequest, "tenantName and tokenID could not be empty")
+	}
+
+	if err := a.clusterManager.RevokeTenantToken(ctx, tenantName, tokenID); err != nil {
+		log.Error("revoke tenant token failed", zap.String("tenantName", tenantName), zap.String("tokenID", tokenID), zap.Error(err))
+		return errResult(ErrTenant, err.Error())
+	}
+	return okResult(tokenID)
+}
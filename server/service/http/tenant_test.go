@@ -0,0 +1,151 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CeresDB/horaemeta/server/config"
+	"github.com/CeresDB/horaemeta/server/member"
+	"github.com/stretchr/testify/require"
+)
+
+const testTenantAdminToken = "admin-token"
+
+func newTenantTestAPI(tenantTokenRequired bool) *API {
+	return NewAPI(APIOptions{
+		ClusterManager:       nil,
+		ServerStatus:         nil,
+		ForwardClient:        nil,
+		FlowLimiter:          nil,
+		EtcdClient:           nil,
+		EtcdMaintainer:       nil,
+		EtcdEndpointManager:  nil,
+		EtcdEmbedMonitor:     nil,
+		EtcdQuotaBytes:       0,
+		RootPath:             "",
+		IdempotencyKeyTTLSec: 60,
+		EnableDebugKV:        false,
+		ConfigReloader:       nil,
+		BuildInfo:            member.BuildInfo{CommitID: "", BranchName: "", BuildDate: ""},
+		HandleTimeouts:       HandleTimeouts{Default: 0, Long: 0},
+		AccessLog: config.AccessLogConfig{
+			SampleRatio:     0,
+			MaxBodyBytes:    0,
+			SlowThresholdMs: 0,
+			RedactedFields:  nil,
+		},
+		TenantTokenRequired: tenantTokenRequired,
+		TenantAdminToken:    testTenantAdminToken,
+	})
+}
+
+// serveTenant serves the request of the handler authorized by the tenant, and returns the response and whether the
+// handler is called.
+func serveTenant(api *API, handlerName string, req *http.Request) (*httptest.ResponseRecorder, bool) {
+	called := false
+	handler := api.authorizeTenant(handlerName, func(w http.ResponseWriter, _ *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	})
+	recorder := httptest.NewRecorder()
+	handler(recorder, req)
+	return recorder, called
+}
+
+func TestAuthorizeTenantWithoutToken(t *testing.T) {
+	re := require.New(t)
+
+	// The requests without the token are handled as usual if the token is not required.
+	recorder, called := serveTenant(newTenantTestAPI(false), "/clusters", httptest.NewRequest(http.MethodGet, "/api/v1/clusters", nil))
+	re.Equal(http.StatusOK, recorder.Code)
+	re.True(called)
+
+	api := newTenantTestAPI(true)
+	for _, handlerName := range []string{"/clusters", "/tenants", "/split"} {
+		recorder, called := serveTenant(api, handlerName, httptest.NewRequest(http.MethodGet, "/api/v1"+handlerName, nil))
+		re.Equal(http.StatusUnauthorized, recorder.Code, "handler:%s", handlerName)
+		re.False(called, "handler:%s", handlerName)
+
+		var resp response
+		re.NoError(json.Unmarshal(recorder.Body.Bytes(), &resp))
+		re.Equal(statusError, resp.Status)
+		re.Equal(ErrTenantTokenRequired.Error(), resp.Error)
+	}
+
+	// The empty token is taken as no token.
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/clusters", nil)
+	req.Header.Set(tenantTokenHeader, "")
+	recorder, called = serveTenant(api, "/clusters", req)
+	re.Equal(http.StatusUnauthorized, recorder.Code)
+	re.False(called)
+
+	// The probes are still allowed without the token.
+	for _, handlerName := range []string{"/health", "/metrics"} {
+		recorder, called = serveTenant(api, handlerName, httptest.NewRequest(http.MethodGet, "/api/v1"+handlerName, nil))
+		re.Equal(http.StatusOK, recorder.Code, "handler:%s", handlerName)
+		re.True(called, "handler:%s", handlerName)
+	}
+}
+
+func TestAuthorizeTenantWithAdminToken(t *testing.T) {
+	re := require.New(t)
+
+	// The admin token accesses the routes no tenant can access.
+	api := newTenantTestAPI(true)
+	for _, handlerName := range []string{"/clusters", "/tenants", "/split"} {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1"+handlerName, nil)
+		req.Header.Set(tenantTokenHeader, testTenantAdminToken)
+		recorder, called := serveTenant(api, handlerName, req)
+		re.Equal(http.StatusOK, recorder.Code, "handler:%s", handlerName)
+		re.True(called, "handler:%s", handlerName)
+	}
+
+	// The admin token is disabled if empty.
+	api.tenantAdminToken = ""
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/clusters", nil)
+	recorder, called := serveTenant(api, "/clusters", req)
+	re.Equal(http.StatusUnauthorized, recorder.Code)
+	re.False(called)
+}
+
+func TestPeekRequestScope(t *testing.T) {
+	re := require.New(t)
+
+	body := `{"clusterName":"cluster0","schemaName":"schema0","tables":["t0"]}`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tables/batchDrop", strings.NewReader(body))
+	scope, err := peekRequestScope(req)
+	re.NoError(err)
+	re.Equal(requestScope{ClusterName: "cluster0", SchemaName: "schema0"}, scope)
+
+	// The body is restored for the handler.
+	restored, err := io.ReadAll(req.Body)
+	re.NoError(err)
+	re.Equal(body, string(restored))
+
+	scope, err = peekRequestScope(httptest.NewRequest(http.MethodPost, "/api/v1/split", nil))
+	re.NoError(err)
+	re.Empty(scope.ClusterName)
+
+	_, err = peekRequestScope(httptest.NewRequest(http.MethodPost, "/api/v1/split", strings.NewReader("{")))
+	re.Error(err)
+}
@@ -0,0 +1,460 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package http
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	"github.com/CeresDB/horaemeta/server/cluster"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/coordinator"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/storage"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	defaultTransferLeaderBatchParallelism = 4
+	maxTransferLeaderBatchParallelism     = 32
+	// maxTransferLeaderBatches is the number of the batches kept for polling, and the oldest finished ones are removed
+	// beyond it.
+	maxTransferLeaderBatches = 64
+	// transferLeaderStartTimeout bounds the waiting of a procedure to be started, because the procedure manager discards
+	// the waiting procedure silently if the topology it is created on is stale.
+	transferLeaderStartTimeout = time.Minute
+	// transferLeaderTimeout bounds the whole transfer of a shard, after which the procedure is not waited any more.
+	transferLeaderTimeout        = 10 * time.Minute
+	transferLeaderPollInterval   = 500 * time.Millisecond
+	transferLeaderItemPending    = "pending"
+	transferLeaderItemSucceeded  = "succeeded"
+	transferLeaderItemFailed     = "failed"
+	transferLeaderItemInProgress = "inProgress"
+)
+
+type TransferLeaderBatchRequest struct {
+	ClusterName string `json:"clusterName"`
+	// Transfers are the shards and their new leaders, which is exclusive with SourceNodeName.
+	Transfers []TransferLeaderBatchTransfer `json:"transfers"`
+	// SourceNodeName moves all the shard leaders on the node to the other alive and uncordoned nodes, and the node with
+	// the fewest leaders is picked for every shard.
+	SourceNodeName string `json:"sourceNodeName"`
+	// Parallelism bounds the transfers in progress at the same time, 0 means the default one.
+	Parallelism int `json:"parallelism"`
+}
+
+type TransferLeaderBatchTransfer struct {
+	ShardID           uint32 `json:"shardID"`
+	NewLeaderNodeName string `json:"newLeaderNodeName"`
+}
+
+type TransferLeaderBatchItem struct {
+	ShardID           storage.ShardID `json:"shardID"`
+	OldLeaderNodeName string          `json:"oldLeaderNodeName"`
+	NewLeaderNodeName string          `json:"newLeaderNodeName"`
+	// ProcedureID is 0 until the procedure is created.
+	ProcedureID uint64 `json:"procedureID"`
+	// State is pending, inProgress, succeeded or failed.
+	State   string `json:"state"`
+	Message string `json:"message"`
+}
+
+// TransferLeaderBatchStatus is the progress of the batch, which is polled by its id.
+type TransferLeaderBatchStatus struct {
+	ID          string                    `json:"id"`
+	ClusterName string                    `json:"clusterName"`
+	Parallelism int                       `json:"parallelism"`
+	Total       int                       `json:"total"`
+	Pending     int                       `json:"pending"`
+	InProgress  int                       `json:"inProgress"`
+	Succeeded   int                       `json:"succeeded"`
+	Failed      int                       `json:"failed"`
+	Done        bool                      `json:"done"`
+	CreatedAt   time.Time                 `json:"createdAt"`
+	FinishedAt  time.Time                 `json:"finishedAt"`
+	Items       []TransferLeaderBatchItem `json:"items"`
+}
+
+// transferLeaderBatch transfers the leaders of the shards one procedure per shard, and the procedure of every shard is
+// created on the latest topology when it is started, so the transfers done before don't make it stale.
+type transferLeaderBatch struct {
+	id          string
+	cluster     *cluster.Cluster
+	parallelism int
+	createdAt   time.Time
+
+	lock       sync.RWMutex
+	items      []TransferLeaderBatchItem
+	done       bool
+	finishedAt time.Time
+}
+
+// transferLeaderBatches keeps the batches of this server, which are lost if the leadership moves.
+type transferLeaderBatches struct {
+	lock    sync.RWMutex
+	batches map[string]*transferLeaderBatch
+}
+
+func newTransferLeaderBatches() *transferLeaderBatches {
+	return &transferLeaderBatches{
+		lock:    sync.RWMutex{},
+		batches: make(map[string]*transferLeaderBatch),
+	}
+}
+
+func (b *transferLeaderBatches) add(batch *transferLeaderBatch) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.batches[batch.id] = batch
+	if len(b.batches) <= maxTransferLeaderBatches {
+		return
+	}
+	finished := make([]*transferLeaderBatch, 0, len(b.batches))
+	for _, batch := range b.batches {
+		if status := batch.status(); status.Done {
+			finished = append(finished, batch)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].createdAt.Before(finished[j].createdAt)
+	})
+	for i := 0; i < len(finished) && len(b.batches) > maxTransferLeaderBatches; i++ {
+		delete(b.batches, finished[i].id)
+	}
+}
+
+func (b *transferLeaderBatches) get(id string) (*transferLeaderBatch, bool) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	batch, ok := b.batches[id]
+	return batch, ok
+}
+
+// list returns the status of the batches from the newest to the oldest.
+func (b *transferLeaderBatches) list() []TransferLeaderBatchStatus {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	statuses := make([]TransferLeaderBatchStatus, 0, len(b.batches))
+	for _, batch := range b.batches {
+		statuses = append(statuses, batch.status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].CreatedAt.After(statuses[j].CreatedAt)
+	})
+	return statuses
+}
+
+// transferLeaderBatch starts transferring the leaders of the shards in the background, and the returned status carries
+// the id to poll the progress with.
+func (a *API) transferLeaderBatch(req *http.Request) apiFuncResult {
+	var batchReq TransferLeaderBatchRequest
+	if err := json.NewDecoder(req.Body).Decode(&batchReq); err != nil {
+		return errResult(ErrParseRequest, err.Error())
+	}
+	if (len(batchReq.Transfers) == 0) == (len(batchReq.SourceNodeName) == 0) {
+		return errResult(ErrParseRequest, "exactly one of transfers and sourceNodeName should be provided")
+	}
+	if batchReq.Parallelism == 0 {
+		batchReq.Parallelism = defaultTransferLeaderBatchParallelism
+	}
+	if batchReq.Parallelism < 0 || batchReq.Parallelism > maxTransferLeaderBatchParallelism {
+		return errResult(ErrParseRequest, fmt.Sprintf("parallelism should be in [1, %d], parallelism:%d", maxTransferLeaderBatchParallelism, batchReq.Parallelism))
+	}
+	log.Debug("transfer leader batch request", zap.String("request", fmt.Sprintf("%+v", batchReq)))
+
+	c, err := a.clusterManager.GetCluster(req.Context(), batchReq.ClusterName)
+	if err != nil {
+		return errResult(ErrGetCluster, fmt.Sprintf("clusterName: %s, err: %s", batchReq.ClusterName, err.Error()))
+	}
+
+	snapshot := c.GetMetadata().GetClusterSnapshot()
+	var items []TransferLeaderBatchItem
+	if len(batchReq.SourceNodeName) > 0 {
+		items, err = planDrainLeaders(snapshot, batchReq.SourceNodeName, c.GetMetadata().Clock().Now())
+	} else {
+		items, err = planTransferLeaders(snapshot, batchReq.Transfers)
+	}
+	if err != nil {
+		return errResult(ErrInvalidTransferLeaderBatch, err.Error())
+	}
+
+	id, err := newTransferLeaderBatchID()
+	if err != nil {
+		return errResult(ErrTransferLeaderBatch, err.Error())
+	}
+	batch := &transferLeaderBatch{
+		id:          id,
+		cluster:     c,
+		parallelism: batchReq.Parallelism,
+		createdAt:   c.GetMetadata().Clock().Now(),
+		lock:        sync.RWMutex{},
+		items:       items,
+		done:        false,
+		finishedAt:  time.Time{},
+	}
+	a.transferLeaderBatches.add(batch)
+	// The batch outlives the request starting it.
+	go batch.run(context.WithoutCancel(req.Context()))
+
+	return okResult(batch.status())
+}
+
+func (a *API) listTransferLeaderBatches(_ *http.Request) apiFuncResult {
+	return okResult(a.transferLeaderBatches.list())
+}
+
+func (a *API) getTransferLeaderBatch(req *http.Request) apiFuncResult {
+	id := Param(req.Context(), batchIDParam)
+	batch, ok := a.transferLeaderBatches.get(id)
+	if !ok {
+		return errResult(ErrTransferLeaderBatchNotFound, fmt.Sprintf("batchID: %s", id))
+	}
+	return okResult(batch.status())
+}
+
+func newTransferLeaderBatchID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.WithMessage(err, "generate transfer leader batch id")
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// planTransferLeaders checks the shards to transfer, and their old leaders are the current ones in the snapshot.
+func planTransferLeaders(snapshot metadata.Snapshot, transfers []TransferLeaderBatchTransfer) ([]TransferLeaderBatchItem, error) {
+	leaders := shardLeaders(snapshot)
+	items := make([]TransferLeaderBatchItem, 0, len(transfers))
+	seen := make(map[storage.ShardID]struct{}, len(transfers))
+	for _, transfer := range transfers {
+		shardID := storage.ShardID(transfer.ShardID)
+		if _, ok := seen[shardID]; ok {
+			return nil, errors.WithMessagef(ErrInvalidTransferLeaderBatch, "shard is listed twice, shardID:%d", shardID)
+		}
+		seen[shardID] = struct{}{}
+
+		oldLeader, ok := leaders[shardID]
+		if !ok {
+			return nil, errors.WithMessagef(ErrInvalidTransferLeaderBatch, "shard has no leader, shardID:%d", shardID)
+		}
+		if len(transfer.NewLeaderNodeName) == 0 || transfer.NewLeaderNodeName == oldLeader {
+			return nil, errors.WithMessagef(ErrInvalidTransferLeaderBatch, "new leader should be another node, shardID:%d, leader:%s", shardID, oldLeader)
+		}
+		items = append(items, newTransferLeaderBatchItem(shardID, oldLeader, transfer.NewLeaderNodeName))
+	}
+	return items, nil
+}
+
+// planDrainLeaders moves every shard leader on the source node to the alive and uncordoned node with the fewest leaders.
+func planDrainLeaders(snapshot metadata.Snapshot, sourceNodeName string, now time.Time) ([]TransferLeaderBatchItem, error) {
+	leaderCounts := make(map[string]int, len(snapshot.RegisteredNodes))
+	for _, node := range snapshot.RegisteredNodes {
+		if node.Node.Name == sourceNodeName || node.IsExpired(now) || snapshot.IsCordoned(node.Node.Name) {
+			continue
+		}
+		leaderCounts[node.Node.Name] = 0
+	}
+	if len(leaderCounts) == 0 {
+		return nil, errors.WithMessagef(ErrInvalidTransferLeaderBatch, "no node to take over the leaders, sourceNode:%s", sourceNodeName)
+	}
+
+	var shardIDs []storage.ShardID
+	for shardID, leader := range shardLeaders(snapshot) {
+		if leader == sourceNodeName {
+			shardIDs = append(shardIDs, shardID)
+		} else if _, ok := leaderCounts[leader]; ok {
+			leaderCounts[leader]++
+		}
+	}
+	sort.Slice(shardIDs, func(i, j int) bool {
+		return shardIDs[i] < shardIDs[j]
+	})
+
+	items := make([]TransferLeaderBatchItem, 0, len(shardIDs))
+	for _, shardID := range shardIDs {
+		target := ""
+		for nodeName, count := range leaderCounts {
+			if len(target) == 0 || count < leaderCounts[target] || (count == leaderCounts[target] && nodeName < target) {
+				target = nodeName
+			}
+		}
+		leaderCounts[target]++
+		items = append(items, newTransferLeaderBatchItem(shardID, sourceNodeName, target))
+	}
+	return items, nil
+}
+
+func shardLeaders(snapshot metadata.Snapshot) map[storage.ShardID]string {
+	leaders := make(map[storage.ShardID]string, len(snapshot.Topology.ClusterView.ShardNodes))
+	for _, shardNode := range snapshot.Topology.ClusterView.ShardNodes {
+		if shardNode.ShardRole == storage.ShardRoleLeader {
+			leaders[shardNode.ID] = shardNode.NodeName
+		}
+	}
+	return leaders
+}
+
+func newTransferLeaderBatchItem(shardID storage.ShardID, oldLeader, newLeader string) TransferLeaderBatchItem {
+	return TransferLeaderBatchItem{
+		ShardID:           shardID,
+		OldLeaderNodeName: oldLeader,
+		NewLeaderNodeName: newLeader,
+		ProcedureID:       0,
+		State:             transferLeaderItemPending,
+		Message:           "",
+	}
+}
+
+// run transfers the shards in order, and at most parallelism transfers are in progress at the same time.
+func (b *transferLeaderBatch) run(ctx context.Context) {
+	slots := make(chan struct{}, b.parallelism)
+	var wg sync.WaitGroup
+	for i := range b.items {
+		slots <- struct{}{}
+		wg.Add(1)
+		go func(i int) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			b.transfer(ctx, i)
+		}(i)
+	}
+	wg.Wait()
+
+	b.lock.Lock()
+	b.done = true
+	b.finishedAt = b.cluster.GetMetadata().Clock().Now()
+	b.lock.Unlock()
+
+	status := b.status()
+	log.Info("transfer leader batch finished", zap.String("batchID", b.id), zap.Int("total", status.Total), zap.Int("succeeded", status.Succeeded), zap.Int("failed", status.Failed))
+}
+
+func (b *transferLeaderBatch) transfer(ctx context.Context, i int) {
+	b.lock.RLock()
+	item := b.items[i]
+	b.lock.RUnlock()
+
+	p, err := b.cluster.GetProcedureFactory().CreateTransferLeaderProcedure(ctx, coordinator.TransferLeaderRequest{
+		Snapshot:          b.cluster.GetMetadata().GetClusterSnapshot(),
+		ShardID:           item.ShardID,
+		OldLeaderNodeName: item.OldLeaderNodeName,
+		NewLeaderNodeName: item.NewLeaderNodeName,
+	})
+	if err != nil {
+		b.updateItem(i, 0, transferLeaderItemFailed, errors.WithMessage(err, "create transfer leader procedure").Error())
+		return
+	}
+	if err := b.cluster.GetProcedureManager().Submit(ctx, p); err != nil {
+		b.updateItem(i, p.ID(), transferLeaderItemFailed, errors.WithMessage(err, "submit transfer leader procedure").Error())
+		return
+	}
+	b.updateItem(i, p.ID(), transferLeaderItemInProgress, string(procedure.StateInit))
+
+	state, err := waitProcedure(ctx, p)
+	if err != nil {
+		log.Warn("transfer leader of batch failed", zap.String("batchID", b.id), zap.Uint32("shardID", uint32(item.ShardID)), zap.Error(err))
+		b.updateItem(i, p.ID(), transferLeaderItemFailed, err.Error())
+		return
+	}
+	b.updateItem(i, p.ID(), transferLeaderItemSucceeded, string(state))
+}
+
+// waitProcedure waits for the procedure to be finished, and returns error if it fails or is not done in time.
+func waitProcedure(ctx context.Context, p procedure.Procedure) (procedure.State, error) {
+	start := time.Now()
+	ticker := time.NewTicker(transferLeaderPollInterval)
+	defer ticker.Stop()
+
+	for {
+		state := p.State()
+		switch state {
+		case procedure.StateFinished:
+			return state, nil
+		case procedure.StateFailed, procedure.StateCancelled:
+			return state, errors.WithMessagef(ErrTransferLeaderBatch, "procedure is %s, procedureID:%d", state, p.ID())
+		case procedure.StateInit:
+			if time.Since(start) > transferLeaderStartTimeout {
+				return state, errors.WithMessagef(ErrTransferLeaderBatch, "procedure is not started in %s, it may be discarded for the stale topology, procedureID:%d", transferLeaderStartTimeout, p.ID())
+			}
+		}
+		if time.Since(start) > transferLeaderTimeout {
+			return state, errors.WithMessagef(ErrTransferLeaderBatch, "procedure is not done in %s, state:%s, procedureID:%d", transferLeaderTimeout, state, p.ID())
+		}
+
+		select {
+		case <-ctx.Done():
+			return state, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (b *transferLeaderBatch) updateItem(i int, procedureID uint64, state, message string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.items[i].ProcedureID = procedureID
+	b.items[i].State = state
+	b.items[i].Message = message
+}
+
+func (b *transferLeaderBatch) status() TransferLeaderBatchStatus {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	status := TransferLeaderBatchStatus{
+		ID:          b.id,
+		ClusterName: b.cluster.GetMetadata().Name(),
+		Parallelism: b.parallelism,
+		Total:       len(b.items),
+		Pending:     0,
+		InProgress:  0,
+		Succeeded:   0,
+		Failed:      0,
+		Done:        b.done,
+		CreatedAt:   b.createdAt,
+		FinishedAt:  b.finishedAt,
+		Items:       make([]TransferLeaderBatchItem, len(b.items)),
+	}
+	copy(status.Items, b.items)
+	for _, item := range b.items {
+		switch item.State {
+		case transferLeaderItemPending:
+			status.Pending++
+		case transferLeaderItemInProgress:
+			status.InProgress++
+		case transferLeaderItemSucceeded:
+			status.Succeeded++
+		case transferLeaderItemFailed:
+			status.Failed++
+		}
+	}
+	return status
+}
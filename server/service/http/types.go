@@ -17,28 +17,80 @@
 package http
 
 import (
+	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/CeresDB/horaemeta/pkg/coderr"
+	"github.com/CeresDB/horaemeta/pkg/failpoint"
 	"github.com/CeresDB/horaemeta/server/cluster"
+	"github.com/CeresDB/horaemeta/server/cluster/metadata"
+	"github.com/CeresDB/horaemeta/server/config"
+	"github.com/CeresDB/horaemeta/server/coordinator/eventdispatch"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/ddl/batchcreatetable"
+	"github.com/CeresDB/horaemeta/server/coordinator/procedure/operation/repair"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/hotspot"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/manager"
+	"github.com/CeresDB/horaemeta/server/coordinator/scheduler/nodepicker"
+	"github.com/CeresDB/horaemeta/server/etcdutil"
 	"github.com/CeresDB/horaemeta/server/limiter"
+	"github.com/CeresDB/horaemeta/server/member"
+	"github.com/CeresDB/horaemeta/server/service"
 	"github.com/CeresDB/horaemeta/server/status"
 	"github.com/CeresDB/horaemeta/server/storage"
+	clientv3 "go.etcd.io/etcd/client/v3"
 )
 
 const (
-	statusSuccess    string = "success"
-	statusError      string = "error"
-	clusterNameParam string = "cluster"
+	statusSuccess         string = "success"
+	statusError           string = "error"
+	batchIDParam          string = "batchID"
+	clusterNameParam      string = "cluster"
+	confirmParam          string = "confirm"
+	deepParam             string = "deep"
+	failpointNameParam    string = "name"
+	forceParam            string = "force"
+	limitParam            string = "limit"
+	nodeNameParam         string = "node"
+	prefixParam           string = "prefix"
+	procedureIDParam      string = "id"
+	tokenIDParam          string = "tokenID"
+	schedulerParam        string = "scheduler"
+	schemaNameParam       string = "schema"
+	sinceParam            string = "since"
+	shardIDParam          string = "shard"
+	staleThresholdMsParam string = "staleThresholdMs"
+	tenantNameParam       string = "tenant"
+	ttlSecParam           string = "ttlSec"
+
+	// staleReadHeader allows the read to be served by the node which is not the leader if set to true.
+	staleReadHeader string = "X-Horaemeta-Stale-Read"
+	// tenantTokenHeader carries the tenant token restricting the request to the resources of the tenant.
+	tenantTokenHeader string = "X-Horaemeta-Tenant-Token"
+	// leaderHeader tells the http address of the leader when the request is rejected by the draining server.
+	leaderHeader string = "X-Horaemeta-Leader"
 
 	apiPrefix string = "/api/v1"
+
+	defaultSchedulerDecisionsLimit = 50
+	defaultSearchTablesLimit       = 100
+	maxSearchTablesLimit           = 1000
+	defaultDebugKVLimit            = 100
+	maxDebugKVLimit                = 1000
+	defaultEventsLimit             = 100
+	maxEventsLimit                 = 1000
+	// maxSearchTablesRegexLen bounds the cost of compiling the regex given by the users.
+	maxSearchTablesRegexLen = 256
 )
 
 type response struct {
-	Status string      `json:"status"`
-	Data   interface{} `json:"data,omitempty"`
-	Error  string      `json:"error,omitempty"`
-	Msg    string      `json:"msg,omitempty"`
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	Msg       string      `json:"msg,omitempty"`
+	RequestID string      `json:"requestID,omitempty"`
 }
 
 type apiFuncResult struct {
@@ -63,8 +115,47 @@ func errResult(err coderr.CodeError, errMsg string) apiFuncResult {
 	}
 }
 
+// errResultWithData returns the error result carrying the data for the details of the error.
+func errResultWithData(err coderr.CodeError, errMsg string, data interface{}) apiFuncResult {
+	return apiFuncResult{
+		data:   data,
+		err:    err,
+		errMsg: errMsg,
+	}
+}
+
 type apiFunc func(r *http.Request) apiFuncResult
 
+// APIOptions are the dependencies and the settings of the API.
+type APIOptions struct {
+	ClusterManager cluster.Manager
+	ServerStatus   *status.ServerStatus
+	ForwardClient  *ForwardClient
+	FlowLimiter    *limiter.FlowLimiter
+
+	EtcdClient          *clientv3.Client
+	EtcdMaintainer      *etcdutil.Maintainer
+	EtcdEndpointManager *etcdutil.EndpointManager
+	EtcdEmbedMonitor    *etcdutil.EmbedMonitor
+	// EtcdQuotaBytes is the backend quota of etcd, which the storage usage is reported against.
+	EtcdQuotaBytes int64
+	// RootPath is the root path of the metadata in etcd.
+	RootPath             string
+	IdempotencyKeyTTLSec int64
+	// EnableDebugKV exposes the raw keys under the root path, which may reveal the whole metadata.
+	EnableDebugKV bool
+	// ConfigReloader reloads the config file of this server and applies the dynamic settings.
+	ConfigReloader func() (config.ReloadResult, error)
+	// BuildInfo is the build of this server.
+	BuildInfo      member.BuildInfo
+	HandleTimeouts HandleTimeouts
+	AccessLog      config.AccessLogConfig
+	// TenantTokenRequired rejects the requests without the tenant token.
+	TenantTokenRequired bool
+	// TenantAdminToken accesses all the routes in the place of the tenant token, and it is disabled if empty.
+	TenantAdminToken string
+}
+
 type API struct {
 	clusterManager cluster.Manager
 
@@ -73,7 +164,31 @@ type API struct {
 	forwardClient *ForwardClient
 	flowLimiter   *limiter.FlowLimiter
 
-	etcdAPI EtcdAPI
+	etcdAPI          EtcdAPI
+	idempotencyCache *IdempotencyCache
+	storageInspector *StorageInspector
+	// enableDebugKV exposes the raw keys under the root path, which may reveal the whole metadata.
+	enableDebugKV bool
+	// configReloader reloads the config file of this server and applies the dynamic settings.
+	configReloader func() (config.ReloadResult, error)
+	// buildInfo is the build of this server.
+	buildInfo member.BuildInfo
+	// handleTimeouts bounds the handling of the requests routed by the router of the api.
+	handleTimeouts HandleTimeouts
+	accessLogger   *accessLogger
+	// tenantTokenRequired rejects the requests without the tenant token.
+	tenantTokenRequired bool
+	// tenantAdminToken accesses all the routes in the place of the tenant token, and it is disabled if empty.
+	tenantAdminToken string
+	// transferLeaderBatches are the batches of the leader transfers started on this server.
+	transferLeaderBatches *transferLeaderBatches
+}
+
+// HandleTimeouts bounds the handling of the requests, and 0 means no timeout.
+type HandleTimeouts struct {
+	Default time.Duration
+	// Long bounds the requests doing the slow work, e.g. inspecting all the keys or asking all the nodes.
+	Long time.Duration
 }
 
 type DiagnoseShardStatus struct {
@@ -81,10 +196,133 @@ type DiagnoseShardStatus struct {
 	Status   string `json:"status"`
 }
 
+type ServerStatus struct {
+	Build            member.BuildInfo         `json:"build"`
+	ProtocolVersions service.ProtocolVersions `json:"protocolVersions"`
+	Healthy          bool                     `json:"healthy"`
+	// DegradedReason is not empty if the server is degraded, e.g. the backing etcd raises alarms.
+	DegradedReason string `json:"degradedReason,omitempty"`
+}
+
+type DiagnoseNodeVersion struct {
+	NodeVersion string `json:"node_version"`
+	// ProtocolVersion is zero if the node doesn't report it.
+	ProtocolVersion uint32 `json:"protocol_version"`
+}
+
+type DiagnoseTable struct {
+	ID   storage.TableID `json:"id"`
+	Name string          `json:"name"`
+}
+
+// DiagnoseShardTables is the difference between the shard in meta and the shard reported by its leader node.
+//
+// The tables opened on the node but absent in meta aren't reported, because the nodes serve no rpc listing the tables
+// they open.
+type DiagnoseShardTables struct {
+	NodeName string `json:"node_name"`
+	// Reported is false if the node doesn't report the shard in its heartbeats.
+	Reported bool   `json:"reported"`
+	NodeRole string `json:"node_role"`
+	// MissingTables are added to the shard in meta after the version reported by the node, so they aren't opened on the
+	// node yet.
+	MissingTables []DiagnoseTable `json:"missing_tables"`
+	// OrphanTableIDs are in the shard view in meta, while the metadata of the tables doesn't exist.
+	OrphanTableIDs []storage.TableID `json:"orphan_table_ids"`
+	MetaVersion    uint64            `json:"meta_version"`
+	NodeVersion    uint64            `json:"node_version"`
+}
+
 type DiagnoseShardResult struct {
 	// shardID -> nodeName
 	UnregisteredShards []storage.ShardID                       `json:"unregistered_shards"`
 	UnreadyShards      map[storage.ShardID]DiagnoseShardStatus `json:"unready_shards"`
+	// The following fields are only filled in the deep mode.
+	InconsistentShards map[storage.ShardID]DiagnoseShardTables `json:"inconsistent_shards,omitempty"`
+	// shardID -> names of the nodes reporting the shard as the leader, while the shard isn't led by them in meta
+	OrphanShards map[storage.ShardID][]string `json:"orphan_shards,omitempty"`
+	// shardID -> reason why the shard on its leader node is unknown
+	UnreachableShards map[storage.ShardID]string `json:"unreachable_shards,omitempty"`
+	// nodeName -> version of the node speaking the protocol version unsupported by the meta
+	IncompatibleNodes map[string]DiagnoseNodeVersion `json:"incompatible_nodes"`
+	// HotShards exceed the hot spot thresholds of the scheduler config, and SplitSuggestions tell how to split them.
+	HotShards        []hotspot.HotShard        `json:"hot_shards"`
+	SplitSuggestions []hotspot.SplitSuggestion `json:"split_suggestions"`
+}
+
+// ClusterTopology is the complete topology of the cluster at the moment, and the shards and the nodes are ordered.
+type ClusterTopology struct {
+	ClusterName    string              `json:"clusterName"`
+	ClusterID      storage.ClusterID   `json:"clusterID"`
+	TopologyType   string              `json:"topologyType"`
+	ClusterState   string              `json:"clusterState"`
+	ClusterVersion uint64              `json:"clusterVersion"`
+	ShardNodes     []TopologyShardNode `json:"shardNodes"`
+	ShardViews     []TopologyShardView `json:"shardViews"`
+	Nodes          []TopologyNode      `json:"nodes"`
+}
+
+type TopologyShardNode struct {
+	ShardID  storage.ShardID `json:"shardID"`
+	Role     string          `json:"role"`
+	NodeName string          `json:"nodeName"`
+}
+
+type TopologyShardView struct {
+	ShardID   storage.ShardID   `json:"shardID"`
+	Version   uint64            `json:"version"`
+	TableIDs  []storage.TableID `json:"tableIDs"`
+	CreatedAt uint64            `json:"createdAt"`
+}
+
+type TopologyNode struct {
+	Name          string `json:"name"`
+	State         string `json:"state"`
+	Zone          string `json:"zone"`
+	NodeVersion   string `json:"nodeVersion"`
+	LastTouchTime uint64 `json:"lastTouchTime"`
+	// Shards are the shards reported by the node in the latest heartbeat.
+	Shards []TopologyNodeShard `json:"shards"`
+}
+
+type ListNodesResult struct {
+	// StaleThresholdMs is the threshold given in the request, and zero means the nodes are judged by their own leases.
+	StaleThresholdMs int64         `json:"staleThresholdMs"`
+	Nodes            []ClusterNode `json:"nodes"`
+}
+
+type ClusterNode struct {
+	Name        string `json:"name"`
+	State       string `json:"state"`
+	Zone        string `json:"zone"`
+	NodeVersion string `json:"nodeVersion"`
+	// ProtocolVersion is zero if the node doesn't report it.
+	ProtocolVersion uint32 `json:"protocolVersion"`
+	LastTouchTime   uint64 `json:"lastTouchTime"`
+	// HeartbeatAgeMs is the time elapsed since the last heartbeat of the node.
+	HeartbeatAgeMs int64 `json:"heartbeatAgeMs"`
+	// Stale is true if the heartbeat age exceeds the stale threshold.
+	Stale              bool `json:"stale"`
+	LeaderShardCount   int  `json:"leaderShardCount"`
+	FollowerShardCount int  `json:"followerShardCount"`
+	// Heartbeat is the lease and the interval negotiated with the node, and the jitter observed from its heartbeats.
+	Heartbeat metadata.HeartbeatStats `json:"heartbeat"`
+	// Cordoned is true if no new shards are assigned to the node.
+	Cordoned bool `json:"cordoned"`
+}
+
+type CordonNodeResult struct {
+	NodeName string `json:"nodeName"`
+	Cordoned bool   `json:"cordoned"`
+	// CordonedNodes are all the cordoned nodes of the cluster after the request.
+	CordonedNodes []string `json:"cordonedNodes"`
+}
+
+type TopologyNodeShard struct {
+	ShardID storage.ShardID `json:"shardID"`
+	Role    string          `json:"role"`
+	Version uint64          `json:"version"`
+	Status  string          `json:"status"`
 }
 
 type QueryTableRequest struct {
@@ -92,11 +330,27 @@ type QueryTableRequest struct {
 	SchemaName  string   `json:"schemaName"`
 	Names       []string `json:"names"`
 	IDs         []uint64 `json:"ids"`
+	// Prefix and Regex search the tables in the schema, and the tables matching both of them are returned.
+	Prefix string `json:"prefix"`
+	Regex  string `json:"regex"`
+	// Limit is the max number of the tables found by the search, and it is capped by maxSearchTablesLimit.
+	Limit uint32 `json:"limit"`
+}
+
+type SearchTablesResponse struct {
+	Tables []metadata.TableInfo `json:"tables"`
+	// Truncated is true if more tables are matched than the limit.
+	Truncated bool `json:"truncated"`
 }
 
 type GetShardTablesRequest struct {
 	ClusterName string   `json:"clusterName"`
 	ShardIDs    []uint32 `json:"shardIDs"`
+	// Offset and Limit page the tables of every shard in the order of the shard view, and zero limit means no limit.
+	Offset uint32 `json:"offset"`
+	Limit  uint32 `json:"limit"`
+	// CountOnly returns the table counts and the versions of the shards instead of the tables.
+	CountOnly bool `json:"countOnly"`
 }
 
 type TransferLeaderRequest struct {
@@ -122,6 +376,92 @@ type DropTableRequest struct {
 	Table       string `json:"table"`
 }
 
+type RepairShardsRequest struct {
+	// DryRun only returns the planned actions without executing them.
+	DryRun bool `json:"dryRun"`
+}
+
+type RepairShardsResult struct {
+	ProcedureID uint64                `json:"procedureID"`
+	Actions     []repair.Action       `json:"actions"`
+	Results     []repair.ActionResult `json:"results"`
+}
+
+type FailoverShardResult struct {
+	ProcedureID       uint64 `json:"procedureID"`
+	OldLeaderNodeName string `json:"oldLeaderNodeName"`
+	NewLeaderNodeName string `json:"newLeaderNodeName"`
+}
+
+type TransitClusterStateRequest struct {
+	// State is one of "empty", "prepare" and "stable".
+	State string `json:"state"`
+	// Force makes the transitions out of the state machine of the cluster, e.g. resetting a stuck cluster to empty.
+	Force  bool   `json:"force"`
+	Reason string `json:"reason"`
+}
+
+type MigrateTopologyRequest struct {
+	TopologyType string `json:"topologyType"`
+	// DryRun only validates whether the cluster can be migrated.
+	DryRun bool `json:"dryRun"`
+}
+
+type MigrateTopologyResult struct {
+	ProcedureID          uint64 `json:"procedureID"`
+	PreviousTopologyType string `json:"previousTopologyType"`
+	TopologyType         string `json:"topologyType"`
+}
+
+type ReshardRequest struct {
+	ShardTotal uint32 `json:"shardTotal"`
+	// DryRun only plans the resharding without changing the cluster.
+	DryRun bool `json:"dryRun"`
+}
+
+type ReshardResult struct {
+	ProcedureID        uint64 `json:"procedureID"`
+	PreviousShardTotal uint32 `json:"previousShardTotal"`
+	ShardTotal         uint32 `json:"shardTotal"`
+	// NewShardNodes are the nodes the new shards are assigned to, and their shard ids are left 0, since the ids are
+	// allocated when the shards are created.
+	NewShardNodes []TopologyShardNode `json:"newShardNodes"`
+	DrainShardIDs []storage.ShardID   `json:"drainShardIDs"`
+}
+
+type BatchDropTableRequest struct {
+	ClusterName string   `json:"clusterName"`
+	SchemaName  string   `json:"schemaName"`
+	Tables      []string `json:"tables"`
+	Prefix      string   `json:"prefix"`
+}
+
+type BatchDropTableResponse struct {
+	ProcedureID   uint64   `json:"procedureID"`
+	Tables        []string `json:"tables"`
+	SkippedTables []string `json:"skippedTables"`
+}
+
+type BatchCreateTableRequest struct {
+	ClusterName string                   `json:"clusterName"`
+	SchemaName  string                   `json:"schemaName"`
+	Tables      []BatchCreateTableSchema `json:"tables"`
+}
+
+type BatchCreateTableSchema struct {
+	Name string `json:"name"`
+	// EncodedSchema is the table schema encoded by the storage engine, and it is passed to the shards as is.
+	EncodedSchema    []byte            `json:"encodedSchema"`
+	Engine           string            `json:"engine"`
+	CreateIfNotExist bool              `json:"createIfNotExist"`
+	Options          map[string]string `json:"options"`
+}
+
+type BatchCreateTableResponse struct {
+	ProcedureID uint64                         `json:"procedureID"`
+	Results     []batchcreatetable.TableResult `json:"results"`
+}
+
 type SplitRequest struct {
 	ClusterName string   `json:"clusterName"`
 	SchemaName  string   `json:"schemaName"`
@@ -130,6 +470,21 @@ type SplitRequest struct {
 	NodeName    string   `json:"nodeName"`
 }
 
+type RebalancePartitionTableRequest struct {
+	ClusterName   string   `json:"clusterName"`
+	SchemaName    string   `json:"schemaName"`
+	TableName     string   `json:"tableName"`
+	SubTableNames []string `json:"subTableNames"`
+	// SkewThreshold is the max difference allowed between the numbers of the sub tables on the shards.
+	SkewThreshold uint32 `json:"skewThreshold"`
+}
+
+type RebalancePartitionTableResult struct {
+	// Rebalanced is false if the skew doesn't exceed the threshold, and no procedure is submitted.
+	Rebalanced  bool   `json:"rebalanced"`
+	ProcedureID uint64 `json:"procedureID"`
+}
+
 type CreateClusterRequest struct {
 	Name                        string `json:"Name"`
 	NodeCount                   uint32 `json:"NodeCount"`
@@ -137,6 +492,30 @@ type CreateClusterRequest struct {
 	EnableSchedule              bool   `json:"enableSchedule"`
 	TopologyType                string `json:"topologyType"`
 	ProcedureExecutingBatchSize uint32 `json:"procedureExecutingBatchSize"`
+	CaseInsensitiveName         bool   `json:"caseInsensitiveName"`
+	DefaultSchemaName           string `json:"defaultSchemaName"`
+	// HeartbeatIntervalBounds is optional, and no heartbeat interval is advised to the nodes without it.
+	HeartbeatIntervalBounds *storage.HeartbeatIntervalBounds `json:"heartbeatIntervalBounds"`
+	// Quota is optional, and the tables and shards are unlimited without it.
+	Quota *storage.ClusterQuota `json:"quota"`
+	// SchemaCreationPolicy is auto or reject, and the unknown schemas are auto-created if it is empty.
+	SchemaCreationPolicy string `json:"schemaCreationPolicy"`
+	// Protected blocks the destructive operations on the cluster unless they are forced with the confirmation token.
+	Protected bool `json:"protected"`
+}
+
+type CloneClusterRequest struct {
+	Name string `json:"name"`
+	// NodeCount is the min node count of the new cluster, and the one of the source cluster is used if it is zero.
+	NodeCount      uint32 `json:"nodeCount"`
+	EnableSchedule bool   `json:"enableSchedule"`
+	// WithTables clones the table definitions as well as the schemas.
+	WithTables bool `json:"withTables"`
+}
+
+type CloneClusterResponse struct {
+	ClusterID storage.ClusterID `json:"clusterID"`
+	metadata.CloneClusterResult
 }
 
 type UpdateClusterRequest struct {
@@ -145,18 +524,136 @@ type UpdateClusterRequest struct {
 	EnableSchedule              bool   `json:"enableSchedule"`
 	TopologyType                string `json:"topologyType"`
 	ProcedureExecutingBatchSize uint32 `json:"procedureExecutingBatchSize"`
+	// HeartbeatIntervalBounds keeps the current bounds if it is not provided.
+	HeartbeatIntervalBounds *storage.HeartbeatIntervalBounds `json:"heartbeatIntervalBounds"`
+	// Quota keeps the current quota if it is not provided.
+	Quota *storage.ClusterQuota `json:"quota"`
+	// SchemaCreationPolicy keeps the current policy if it is empty.
+	SchemaCreationPolicy string `json:"schemaCreationPolicy"`
+	// Protected keeps the current protection if it is not provided, and the protection is only removed with the query
+	// force=true&confirm=<clusterName>.
+	Protected *bool `json:"protected"`
 }
 
 type UpdateFlowLimiterRequest struct {
 	Enable bool `json:"enable"`
 	Limit  int  `json:"limit"`
 	Burst  int  `json:"burst"`
+	// MethodWeights keeps the current weights of the grpc methods if it is not specified.
+	MethodWeights map[string]int `json:"methodWeights"`
 }
 
 type UpdateEnableScheduleRequest struct {
 	Enable bool `json:"enable"`
 }
 
+type UpdateSchemaSettingsRequest struct {
+	// MaxTableCount is the max number of the tables in the schema, and 0 means unlimited.
+	MaxTableCount       uint32            `json:"maxTableCount"`
+	DefaultTableOptions map[string]string `json:"defaultTableOptions"`
+	// ShardPickingPolicy is one of `leastTable` and `random`, and empty means the shard picker of the cluster.
+	ShardPickingPolicy string `json:"shardPickingPolicy"`
+}
+
+// FailpointsStatus describes the failpoints of the server.
+type FailpointsStatus struct {
+	// Enabled is false unless the server is started with the failpoints enabled.
+	Enabled    bool               `json:"enabled"`
+	Failpoints []failpoint.Status `json:"failpoints"`
+}
+
+// EnableFailpointRequest sets the action of the failpoint, e.g. {"name": "eventdispatch/createTableOnShard", "action":
+// {"kind": "error", "count": 1}} fails the next table creation after the metadata of the table is created.
+type EnableFailpointRequest struct {
+	Name   string           `json:"name"`
+	Action failpoint.Action `json:"action"`
+}
+
+// LogLevel is the level of the logger like `debug` or `info`.
+type LogLevel struct {
+	Level string `json:"level"`
+}
+
+type UpdateSchedulerEnabledRequest struct {
+	Enable bool `json:"enable"`
+}
+
+type CreateRegistrationTokenResponse struct {
+	ID string `json:"id"`
+	// Token is presented by the data nodes in the heartbeat, and it can't be got again.
+	Token     string `json:"token"`
+	CreatedAt uint64 `json:"createdAt"`
+}
+
+type RegistrationTokenInfo struct {
+	ID        string `json:"id"`
+	CreatedAt uint64 `json:"createdAt"`
+}
+
+type PutTenantRequest struct {
+	// Clusters maps the cluster names to the schemas owned by the tenant, and an empty list means the whole cluster.
+	Clusters map[string][]string `json:"clusters"`
+}
+
+type CreateTenantTokenResponse struct {
+	ID string `json:"id"`
+	// Token is presented by the clients in the header, and it can't be got again.
+	Token     string `json:"token"`
+	CreatedAt uint64 `json:"createdAt"`
+}
+
+type TenantTokenInfo struct {
+	ID        string `json:"id"`
+	CreatedAt uint64 `json:"createdAt"`
+}
+
+type UpdateSchedulerConfigRequest struct {
+	IntervalMs           uint64   `json:"intervalMs"`
+	MaxProceduresPerTick uint32   `json:"maxProceduresPerTick"`
+	DisabledSchedulers   []string `json:"disabledSchedulers"`
+	NodeShortfallWebhook string   `json:"nodeShortfallWebhook"`
+	// ShardOperationThrottle keeps the current throttle config if it is not provided.
+	ShardOperationThrottle *eventdispatch.ShardOperationThrottleConfig `json:"shardOperationThrottle"`
+	// NodeShardCapacity keeps the current shard capacity config if it is not provided.
+	NodeShardCapacity *nodepicker.ShardCapacityConfig `json:"nodeShardCapacity"`
+	// RebalanceWindows keeps the current windows if it is not provided, and an empty list removes the restriction.
+	RebalanceWindows []manager.RebalanceWindow `json:"rebalanceWindows"`
+	// HotSpot keeps the current hot spot config if it is not provided.
+	HotSpot *hotspot.Config `json:"hotSpot"`
+	// ShardMoveCooldownMs keeps the current cooldown if it is not provided, and zero removes the cooldown.
+	ShardMoveCooldownMs *uint64 `json:"shardMoveCooldownMs"`
+}
+
 type RemoveShardAffinitiesRequest struct {
 	ShardIDs []storage.ShardID `json:"shardIDs"`
 }
+
+// ShardAffinityDocument is the complete set of the shard affinities of a cluster, which can be kept in version control
+// and imported back as a whole.
+type ShardAffinityDocument struct {
+	Affinities []scheduler.ShardAffinity `json:"affinities"`
+}
+
+type ImportShardAffinitiesRequest struct {
+	// Affinities replace all the existing ones of the cluster.
+	Affinities []scheduler.ShardAffinity `json:"affinities"`
+	// DryRun only validates the affinities and returns the diff without applying them.
+	DryRun bool `json:"dryRun"`
+}
+
+type ImportShardAffinitiesResult struct {
+	DryRun bool                          `json:"dryRun"`
+	Diff   manager.ShardAffinityRuleDiff `json:"diff"`
+}
+
+// ProcedureRaw is the persisted procedure, whose raw data is the state payload decoded to JSON.
+type ProcedureRaw struct {
+	ID        uint64          `json:"id"`
+	Kind      string          `json:"kind"`
+	KindID    procedure.Kind  `json:"kindID"`
+	State     procedure.State `json:"state"`
+	UpdatedAt int64           `json:"updatedAt"`
+	// Deleted is true if the procedure is marked deleted, which is kept until it is collected.
+	Deleted bool            `json:"deleted"`
+	RawData json.RawMessage `json:"rawData"`
+}
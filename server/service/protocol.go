@@ -0,0 +1,48 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package service
+
+// The protocol between horaedb and horaemeta consists of the rpc service and the extensions carried by the grpc metadata,
+// and its version is increased whenever an incompatible change is made.
+const (
+	// LegacyProtocolVersion is assumed for the nodes which don't report their protocol versions.
+	LegacyProtocolVersion uint32 = 1
+	MinProtocolVersion    uint32 = 1
+	MaxProtocolVersion    uint32 = 1
+)
+
+type ProtocolVersions struct {
+	Min uint32 `json:"min"`
+	Max uint32 `json:"max"`
+}
+
+// SupportedProtocolVersions returns the range of the protocol versions supported by this server.
+func SupportedProtocolVersions() ProtocolVersions {
+	return ProtocolVersions{
+		Min: MinProtocolVersion,
+		Max: MaxProtocolVersion,
+	}
+}
+
+// IsProtocolVersionSupported tells whether the node speaking the protocol version is compatible with this server, and
+// zero means the node doesn't report it.
+func IsProtocolVersionSupported(version uint32) bool {
+	if version == 0 {
+		version = LegacyProtocolVersion
+	}
+	return version >= MinProtocolVersion && version <= MaxProtocolVersion
+}
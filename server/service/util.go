@@ -31,9 +31,9 @@ var (
 	ErrGRPCDial = coderr.NewCodeError(coderr.Internal, "grpc dial")
 )
 
-// GetClientConn returns a gRPC client connection.
-func GetClientConn(ctx context.Context, addr string) (*grpc.ClientConn, error) {
-	opt := grpc.WithTransportCredentials(insecure.NewCredentials())
+// GetClientConn returns a gRPC client connection, and the extra options are applied to the dial.
+func GetClientConn(ctx context.Context, addr string, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(insecure.NewCredentials())}, opts...)
 
 	host := addr
 	if strings.HasPrefix(addr, "http") {
@@ -44,7 +44,7 @@ func GetClientConn(ctx context.Context, addr string) (*grpc.ClientConn, error) {
 		host = u.Host
 	}
 
-	cc, err := grpc.DialContext(ctx, host, opt)
+	cc, err := grpc.DialContext(ctx, host, opts...)
 	if err != nil {
 		return nil, ErrGRPCDial.WithCause(err)
 	}
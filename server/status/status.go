@@ -16,23 +16,39 @@
 
 package status
 
-import "sync/atomic"
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
 
 type Status int32
 
 const (
 	StatusWaiting Status = iota
 	StatusRunning
+	// StatusDraining rejects the new requests, and the server is stopped after the in-flight ones finish.
+	StatusDraining
 	Terminated
 )
 
+// drainPollInterval is the interval to check whether the in-flight requests are drained.
+const drainPollInterval = 50 * time.Millisecond
+
 type ServerStatus struct {
 	status Status
+	// inflight is the number of the requests being handled.
+	inflight int64
+	// degradedReason is the reason why the running server is degraded, e.g. the backing etcd raises alarms, and it is
+	// empty if the server is not degraded.
+	degradedReason atomic.Value
 }
 
 func NewServerStatus() *ServerStatus {
 	return &ServerStatus{
-		status: StatusWaiting,
+		status:         StatusWaiting,
+		inflight:       0,
+		degradedReason: atomic.Value{},
 	}
 }
 
@@ -47,3 +63,50 @@ func (s *ServerStatus) Get() Status {
 func (s *ServerStatus) IsHealthy() bool {
 	return s.Get() == StatusRunning
 }
+
+// SetDegraded marks the server as degraded for the reason, and an empty reason clears it.
+func (s *ServerStatus) SetDegraded(reason string) {
+	s.degradedReason.Store(reason)
+}
+
+// DegradedReason returns the reason why the server is degraded, and it is empty if the server is not degraded.
+func (s *ServerStatus) DegradedReason() string {
+	reason, _ := s.degradedReason.Load().(string)
+	return reason
+}
+
+// StartRequest tracks a new request until FinishRequest is called, and returns false if the server is draining or
+// terminated, in which case the request should be rejected and FinishRequest must not be called.
+func (s *ServerStatus) StartRequest() bool {
+	// The request is counted before the status is checked, so it is always waited by WaitInflightRequests if it is
+	// accepted before the draining starts.
+	atomic.AddInt64(&s.inflight, 1)
+	if status := s.Get(); status == StatusDraining || status == Terminated {
+		atomic.AddInt64(&s.inflight, -1)
+		return false
+	}
+	return true
+}
+
+func (s *ServerStatus) FinishRequest() {
+	atomic.AddInt64(&s.inflight, -1)
+}
+
+func (s *ServerStatus) InflightRequests() int64 {
+	return atomic.LoadInt64(&s.inflight)
+}
+
+// WaitInflightRequests waits until all the in-flight requests finish, and returns the error of ctx if it is done first.
+func (s *ServerStatus) WaitInflightRequests(ctx context.Context) error {
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for s.InflightRequests() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
@@ -23,12 +23,16 @@ var (
 	ErrDecode = coderr.NewCodeError(coderr.Internal, "storage decode")
 
 	ErrCreateSchemaAgain         = coderr.NewCodeError(coderr.Internal, "storage create schemas")
+	ErrPutSchemaSettings         = coderr.NewCodeError(coderr.Internal, "storage put schema settings")
 	ErrCreateClusterAgain        = coderr.NewCodeError(coderr.Internal, "storage create cluster")
 	ErrUpdateCluster             = coderr.NewCodeError(coderr.Internal, "storage update cluster")
 	ErrCreateClusterViewAgain    = coderr.NewCodeError(coderr.Internal, "storage create cluster view")
 	ErrUpdateClusterViewConflict = coderr.NewCodeError(coderr.Internal, "storage update cluster view")
 	ErrCreateTableAgain          = coderr.NewCodeError(coderr.Internal, "storage create tables")
 	ErrDeleteTableAgain          = coderr.NewCodeError(coderr.Internal, "storage delete table")
+	ErrUpdateTableAgain          = coderr.NewCodeError(coderr.Internal, "storage update table")
+	ErrTableVersionConflict      = coderr.NewCodeError(coderr.Internal, "storage table version conflict")
 	ErrCreateShardViewAgain      = coderr.NewCodeError(coderr.Internal, "storage create shard view")
 	ErrUpdateShardViewConflict   = coderr.NewCodeError(coderr.Internal, "storage update shard view")
+	ErrWatchCluster              = coderr.NewCodeError(coderr.Internal, "storage watch cluster")
 )
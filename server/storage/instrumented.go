@@ -0,0 +1,430 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CeresDB/horaemeta/pkg/log"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.uber.org/zap"
+)
+
+// opStats collects what an instrumented operation exchanges with etcd, and it is carried by the context of the operation.
+type opStats struct {
+	// The lock is used to protect following fields, for the operations may access etcd concurrently.
+	lock         sync.Mutex
+	payloadBytes int
+	txnRetries   int
+	keyCount     int
+	// keyPrefix is the common prefix of all the keys accessed.
+	keyPrefix string
+}
+
+type opStatsKey struct{}
+
+func withOpStats(ctx context.Context, stats *opStats) context.Context {
+	return context.WithValue(ctx, opStatsKey{}, stats)
+}
+
+// getOpStats returns nil if the operation is not instrumented.
+func getOpStats(ctx context.Context) *opStats {
+	stats, _ := ctx.Value(opStatsKey{}).(*opStats)
+	return stats
+}
+
+func recordAccess(ctx context.Context, key string, payloadBytes int) {
+	stats := getOpStats(ctx)
+	if stats == nil {
+		return
+	}
+
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	stats.payloadBytes += payloadBytes
+	if stats.keyCount == 0 {
+		stats.keyPrefix = key
+	} else {
+		stats.keyPrefix = commonPrefix(stats.keyPrefix, key)
+	}
+	stats.keyCount++
+}
+
+// recordGetResponse records the keys and the values read, whose keys are recorded already by the request.
+func recordGetResponse(ctx context.Context, resp *clientv3.GetResponse) {
+	stats := getOpStats(ctx)
+	if stats == nil || resp == nil {
+		return
+	}
+
+	payloadBytes := 0
+	for _, kv := range resp.Kvs {
+		payloadBytes += len(kv.Key) + len(kv.Value)
+	}
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	stats.payloadBytes += payloadBytes
+}
+
+// recordTxnRetry records the txn retried by the operation after its conditions fail for the concurrent modifications.
+func recordTxnRetry(ctx context.Context) {
+	stats := getOpStats(ctx)
+	if stats == nil {
+		return
+	}
+
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	stats.txnRetries++
+}
+
+// getKeyPrefix returns the accessed key, or the directory of the common prefix of the accessed keys.
+func (s *opStats) getKeyPrefix() string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.keyCount <= 1 {
+		return s.keyPrefix
+	}
+	return s.keyPrefix[:strings.LastIndex(s.keyPrefix, "/")+1]
+}
+
+func commonPrefix(a, b string) string {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return a[:i]
+		}
+	}
+	return a[:n]
+}
+
+// recordingKV records the keys and the payloads exchanged with etcd into the opStats of the context.
+type recordingKV struct {
+	kv clientv3.KV
+}
+
+func newRecordingKV(kv clientv3.KV) clientv3.KV {
+	return &recordingKV{kv: kv}
+}
+
+func (r *recordingKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	recordAccess(ctx, key, len(key)+len(val))
+	return r.kv.Put(ctx, key, val, opts...)
+}
+
+func (r *recordingKV) Get(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.GetResponse, error) {
+	recordAccess(ctx, key, len(key))
+	resp, err := r.kv.Get(ctx, key, opts...)
+	recordGetResponse(ctx, resp)
+	return resp, err
+}
+
+func (r *recordingKV) Delete(ctx context.Context, key string, opts ...clientv3.OpOption) (*clientv3.DeleteResponse, error) {
+	recordAccess(ctx, key, len(key))
+	return r.kv.Delete(ctx, key, opts...)
+}
+
+func (r *recordingKV) Compact(ctx context.Context, rev int64, opts ...clientv3.CompactOption) (*clientv3.CompactResponse, error) {
+	return r.kv.Compact(ctx, rev, opts...)
+}
+
+func (r *recordingKV) Do(ctx context.Context, op clientv3.Op) (clientv3.OpResponse, error) {
+	recordOps(ctx, op)
+	resp, err := r.kv.Do(ctx, op)
+	recordGetResponse(ctx, resp.Get())
+	return resp, err
+}
+
+func (r *recordingKV) Txn(ctx context.Context) clientv3.Txn {
+	return &recordingTxn{ctx: ctx, txn: r.kv.Txn(ctx)}
+}
+
+func recordOps(ctx context.Context, ops ...clientv3.Op) {
+	for _, op := range ops {
+		recordAccess(ctx, string(op.KeyBytes()), len(op.KeyBytes())+len(op.ValueBytes()))
+	}
+}
+
+type recordingTxn struct {
+	ctx context.Context
+	txn clientv3.Txn
+}
+
+func (t *recordingTxn) If(cs ...clientv3.Cmp) clientv3.Txn {
+	t.txn = t.txn.If(cs...)
+	return t
+}
+
+func (t *recordingTxn) Then(ops ...clientv3.Op) clientv3.Txn {
+	recordOps(t.ctx, ops...)
+	t.txn = t.txn.Then(ops...)
+	return t
+}
+
+func (t *recordingTxn) Else(ops ...clientv3.Op) clientv3.Txn {
+	recordOps(t.ctx, ops...)
+	t.txn = t.txn.Else(ops...)
+	return t
+}
+
+func (t *recordingTxn) Commit() (*clientv3.TxnResponse, error) {
+	resp, err := t.txn.Commit()
+	if resp != nil {
+		for _, op := range resp.Responses {
+			if rangeResp := op.GetResponseRange(); rangeResp != nil {
+				recordGetResponse(t.ctx, (*clientv3.GetResponse)(rangeResp))
+			}
+		}
+	}
+	return resp, err
+}
+
+// instrumentedStorage observes the latency, the payloads and the txn retries of the operations of the storage, and the
+// operations slower than the threshold are logged with the keys they access.
+type instrumentedStorage struct {
+	storage       Storage
+	slowThreshold time.Duration
+}
+
+// NewInstrumentedStorage wraps the storage with the metrics of its operations, and zero slowThreshold disables the logging
+// of the slow operations.
+func NewInstrumentedStorage(storage Storage, slowThreshold time.Duration) Storage {
+	return &instrumentedStorage{
+		storage:       storage,
+		slowThreshold: slowThreshold,
+	}
+}
+
+func (s *instrumentedStorage) begin(ctx context.Context, operation string) (context.Context, func(err error)) {
+	stats := &opStats{
+		lock:         sync.Mutex{},
+		payloadBytes: 0,
+		txnRetries:   0,
+		keyCount:     0,
+		keyPrefix:    "",
+	}
+	start := time.Now()
+	return withOpStats(ctx, stats), func(err error) {
+		latency := time.Since(start)
+		result := "success"
+		if err != nil {
+			result = "error"
+		}
+		operationDuration.WithLabelValues(operation, result).Observe(latency.Seconds())
+
+		stats.lock.Lock()
+		payloadBytes, retries := stats.payloadBytes, stats.txnRetries
+		stats.lock.Unlock()
+		operationPayloadBytes.WithLabelValues(operation).Observe(float64(payloadBytes))
+		if retries > 0 {
+			txnRetries.WithLabelValues(operation).Add(float64(retries))
+		}
+
+		if s.slowThreshold > 0 && latency >= s.slowThreshold {
+			log.Warn("slow storage operation", zap.String("operation", operation), zap.Duration("latency", latency),
+				zap.String("keyPrefix", stats.getKeyPrefix()), zap.Int("payloadBytes", payloadBytes), zap.Int("txnRetries", retries), zap.Error(err))
+		}
+	}
+}
+
+func observe[T any](s *instrumentedStorage, ctx context.Context, operation string, f func(ctx context.Context) (T, error)) (T, error) {
+	ctx, done := s.begin(ctx, operation)
+	result, err := f(ctx)
+	done(err)
+	return result, err
+}
+
+func observeErr(s *instrumentedStorage, ctx context.Context, operation string, f func(ctx context.Context) error) error {
+	ctx, done := s.begin(ctx, operation)
+	err := f(ctx)
+	done(err)
+	return err
+}
+
+func (s *instrumentedStorage) GetCluster(ctx context.Context, clusterID ClusterID) (Cluster, error) {
+	return observe(s, ctx, "GetCluster", func(ctx context.Context) (Cluster, error) {
+		return s.storage.GetCluster(ctx, clusterID)
+	})
+}
+
+func (s *instrumentedStorage) ListClusters(ctx context.Context) (ListClustersResult, error) {
+	return observe(s, ctx, "ListClusters", s.storage.ListClusters)
+}
+
+func (s *instrumentedStorage) CreateCluster(ctx context.Context, req CreateClusterRequest) error {
+	return observeErr(s, ctx, "CreateCluster", func(ctx context.Context) error {
+		return s.storage.CreateCluster(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) UpdateCluster(ctx context.Context, req UpdateClusterRequest) error {
+	return observeErr(s, ctx, "UpdateCluster", func(ctx context.Context) error {
+		return s.storage.UpdateCluster(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) CreateClusterView(ctx context.Context, req CreateClusterViewRequest) error {
+	return observeErr(s, ctx, "CreateClusterView", func(ctx context.Context) error {
+		return s.storage.CreateClusterView(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) GetClusterView(ctx context.Context, req GetClusterViewRequest) (GetClusterViewResult, error) {
+	return observe(s, ctx, "GetClusterView", func(ctx context.Context) (GetClusterViewResult, error) {
+		return s.storage.GetClusterView(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) UpdateClusterView(ctx context.Context, req UpdateClusterViewRequest) error {
+	return observeErr(s, ctx, "UpdateClusterView", func(ctx context.Context) error {
+		return s.storage.UpdateClusterView(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) ListSchemas(ctx context.Context, req ListSchemasRequest) (ListSchemasResult, error) {
+	return observe(s, ctx, "ListSchemas", func(ctx context.Context) (ListSchemasResult, error) {
+		return s.storage.ListSchemas(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) CreateSchema(ctx context.Context, req CreateSchemaRequest) error {
+	return observeErr(s, ctx, "CreateSchema", func(ctx context.Context) error {
+		return s.storage.CreateSchema(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) ListSchemaSettings(ctx context.Context, req ListSchemaSettingsRequest) (ListSchemaSettingsResult, error) {
+	return observe(s, ctx, "ListSchemaSettings", func(ctx context.Context) (ListSchemaSettingsResult, error) {
+		return s.storage.ListSchemaSettings(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) PutSchemaSettings(ctx context.Context, req PutSchemaSettingsRequest) error {
+	return observeErr(s, ctx, "PutSchemaSettings", func(ctx context.Context) error {
+		return s.storage.PutSchemaSettings(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) DeleteSchemaSettings(ctx context.Context, req DeleteSchemaSettingsRequest) error {
+	return observeErr(s, ctx, "DeleteSchemaSettings", func(ctx context.Context) error {
+		return s.storage.DeleteSchemaSettings(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) CreateTable(ctx context.Context, req CreateTableRequest) error {
+	return observeErr(s, ctx, "CreateTable", func(ctx context.Context) error {
+		return s.storage.CreateTable(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) CreateTables(ctx context.Context, req CreateTablesRequest) error {
+	return observeErr(s, ctx, "CreateTables", func(ctx context.Context) error {
+		return s.storage.CreateTables(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) GetTable(ctx context.Context, req GetTableRequest) (GetTableResult, error) {
+	return observe(s, ctx, "GetTable", func(ctx context.Context) (GetTableResult, error) {
+		return s.storage.GetTable(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) ListTables(ctx context.Context, req ListTableRequest) (ListTablesResult, error) {
+	return observe(s, ctx, "ListTables", func(ctx context.Context) (ListTablesResult, error) {
+		return s.storage.ListTables(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) UpdateTable(ctx context.Context, req UpdateTableRequest) error {
+	return observeErr(s, ctx, "UpdateTable", func(ctx context.Context) error {
+		return s.storage.UpdateTable(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) DeleteTable(ctx context.Context, req DeleteTableRequest) error {
+	return observeErr(s, ctx, "DeleteTable", func(ctx context.Context) error {
+		return s.storage.DeleteTable(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) DeleteTables(ctx context.Context, req DeleteTablesRequest) error {
+	return observeErr(s, ctx, "DeleteTables", func(ctx context.Context) error {
+		return s.storage.DeleteTables(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) CreateShardViews(ctx context.Context, req CreateShardViewsRequest) error {
+	return observeErr(s, ctx, "CreateShardViews", func(ctx context.Context) error {
+		return s.storage.CreateShardViews(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) ListShardViews(ctx context.Context, req ListShardViewsRequest) (ListShardViewsResult, error) {
+	return observe(s, ctx, "ListShardViews", func(ctx context.Context) (ListShardViewsResult, error) {
+		return s.storage.ListShardViews(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) UpdateShardView(ctx context.Context, req UpdateShardViewRequest) (UpdateShardViewResult, error) {
+	return observe(s, ctx, "UpdateShardView", func(ctx context.Context) (UpdateShardViewResult, error) {
+		return s.storage.UpdateShardView(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) UpdateShardViews(ctx context.Context, req UpdateShardViewsRequest) error {
+	return observeErr(s, ctx, "UpdateShardViews", func(ctx context.Context) error {
+		return s.storage.UpdateShardViews(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) DeleteShardViews(ctx context.Context, req DeleteShardViewsRequest) error {
+	return observeErr(s, ctx, "DeleteShardViews", func(ctx context.Context) error {
+		return s.storage.DeleteShardViews(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) ListNodes(ctx context.Context, req ListNodesRequest) (ListNodesResult, error) {
+	return observe(s, ctx, "ListNodes", func(ctx context.Context) (ListNodesResult, error) {
+		return s.storage.ListNodes(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) CreateOrUpdateNode(ctx context.Context, req CreateOrUpdateNodeRequest) error {
+	return observeErr(s, ctx, "CreateOrUpdateNode", func(ctx context.Context) error {
+		return s.storage.CreateOrUpdateNode(ctx, req)
+	})
+}
+
+func (s *instrumentedStorage) GetRevision(ctx context.Context) (int64, error) {
+	return observe(s, ctx, "GetRevision", s.storage.GetRevision)
+}
+
+// WatchCluster is not observed, for the watch lasts as long as the cluster is served.
+func (s *instrumentedStorage) WatchCluster(ctx context.Context, req WatchClusterRequest) <-chan WatchClusterResult {
+	return s.storage.WatchCluster(ctx, req)
+}
+
+func (s *instrumentedStorage) RequestWatchProgress(ctx context.Context) error {
+	return observeErr(s, ctx, "RequestWatchProgress", s.storage.RequestWatchProgress)
+}
@@ -33,6 +33,9 @@ const (
 	shardView     = "shard_view"
 	latestVersion = "latest_version"
 	info          = "info"
+	options       = "options"
+	tableVersions = "table_versions"
+	settings      = "settings"
 )
 
 // makeSchemaKey returns the key path to the schema meta info.
@@ -44,6 +47,14 @@ func makeSchemaKey(rootPath string, clusterID uint32, schemaID uint32) string {
 	return path.Join(rootPath, version, cluster, fmtID(uint64(clusterID)), schema, info, fmtID(uint64(schemaID)))
 }
 
+// makeSchemaSettingsKey returns the key path to the settings of the schema.
+func makeSchemaSettingsKey(rootPath string, clusterID uint32, schemaID uint32) string {
+	// Example:
+	//	v1/cluster/1/schema/settings/1 -> json encoded SchemaSettings
+	//	v1/cluster/1/schema/settings/2 -> json encoded SchemaSettings
+	return path.Join(rootPath, version, cluster, fmtID(uint64(clusterID)), schema, settings, fmtID(uint64(schemaID)))
+}
+
 // makeClusterKey returns the cluster meta info key path.
 func makeClusterKey(rootPath string, clusterID uint32) string {
 	// Example:
@@ -53,6 +64,21 @@ func makeClusterKey(rootPath string, clusterID uint32) string {
 	return path.Join(rootPath, version, cluster, info, fmtID(uint64(clusterID)))
 }
 
+// makeClusterKeyPrefix returns the prefix of the keys of the metadata in the cluster.
+func makeClusterKeyPrefix(rootPath string, clusterID uint32) string {
+	// Example:
+	//	v1/cluster/1/ -> the schemas, tables, shard views, nodes and cluster view of cluster 1
+	return path.Join(rootPath, version, cluster, fmtID(uint64(clusterID))) + "/"
+}
+
+// makeClusterOptionsKey returns the key path to the cluster options.
+func makeClusterOptionsKey(rootPath string, clusterID uint32) string {
+	// Example:
+	//	v1/cluster/1/options -> json encoded clusterOptions
+	//	v1/cluster/2/options -> json encoded clusterOptions
+	return path.Join(rootPath, version, cluster, fmtID(uint64(clusterID)), options)
+}
+
 // makeClusterViewLatestVersionKey returns the latest version info key path of cluster clusterView.
 func makeClusterViewLatestVersionKey(rootPath string, clusterID uint32) string {
 	// Example:
@@ -99,6 +125,20 @@ func makeShardViewKey(rootPath string, clusterID uint32, shardID uint32, latestV
 	return path.Join(rootPath, version, cluster, fmtID(uint64(clusterID)), shardView, fmtID(uint64(shardID)), latestVersion)
 }
 
+// makeShardViewPrefix returns the prefix of all the keys of the shard view, including its versions and table versions.
+func makeShardViewPrefix(rootPath string, clusterID uint32, shardID uint32) string {
+	// The trailing separator keeps the prefix of shard 1 from matching the keys of shard 10.
+	return path.Join(rootPath, version, cluster, fmtID(uint64(clusterID)), shardView, fmtID(uint64(shardID))) + "/"
+}
+
+// makeShardTableVersionsKey returns the key path to the versions of the tables in the shard view.
+func makeShardTableVersionsKey(rootPath string, clusterID uint32, shardID uint32) string {
+	// Example:
+	//	v1/cluster/1/shard_view/1/table_versions -> json encoded shardTableVersions
+	//	v1/cluster/1/shard_view/2/table_versions -> json encoded shardTableVersions
+	return path.Join(rootPath, version, cluster, fmtID(uint64(clusterID)), shardView, fmtID(uint64(shardID)), tableVersions)
+}
+
 // makeNodeKey returns the node meta info key path.
 func makeNodeKey(rootPath string, clusterID uint32, nodeName string) string {
 	// Example:
@@ -44,27 +44,54 @@ type Storage interface {
 	ListSchemas(ctx context.Context, req ListSchemasRequest) (ListSchemasResult, error)
 	// CreateSchema create schema in specified cluster.
 	CreateSchema(ctx context.Context, req CreateSchemaRequest) error
+	// ListSchemaSettings list the settings of the schemas in specified cluster, the schemas without settings are left out.
+	ListSchemaSettings(ctx context.Context, req ListSchemaSettingsRequest) (ListSchemaSettingsResult, error)
+	// PutSchemaSettings create or replace the settings of the schema, return error if the schema does not exist.
+	PutSchemaSettings(ctx context.Context, req PutSchemaSettingsRequest) error
+	// DeleteSchemaSettings delete the settings of the schema, so the defaults are used.
+	DeleteSchemaSettings(ctx context.Context, req DeleteSchemaSettingsRequest) error
 
 	// CreateTable create new table in specified cluster and schema, return error if table already exists.
 	CreateTable(ctx context.Context, req CreateTableRequest) error
+	// CreateTables create tables in specified cluster and schema, the creations are split into multiple txns if necessary, and
+	// the txn fails if any table in it exists already.
+	CreateTables(ctx context.Context, req CreateTablesRequest) error
 	// GetTable get table by table name in specified cluster and schema.
 	GetTable(ctx context.Context, req GetTableRequest) (GetTableResult, error)
 	// ListTables list all tables in specified cluster and schema.
 	ListTables(ctx context.Context, req ListTableRequest) (ListTablesResult, error)
+	// UpdateTable updates the table in specified cluster and schema if its version is unchanged, and the table is
+	// re-indexed by the new name if it is renamed.
+	UpdateTable(ctx context.Context, req UpdateTableRequest) error
 	// DeleteTable delete table by table name in specified cluster and schema.
 	DeleteTable(ctx context.Context, req DeleteTableRequest) error
+	// DeleteTables delete tables in specified cluster and schema, the deletions are split into multiple txns if necessary.
+	DeleteTables(ctx context.Context, req DeleteTablesRequest) error
 
 	// CreateShardViews create shard views in specified cluster.
 	CreateShardViews(ctx context.Context, req CreateShardViewsRequest) error
-	// ListShardViews list all shard views in specified cluster.
+	// ListShardViews list the shard views of the shards in the request, or all shard views in specified cluster if no shard is specified.
 	ListShardViews(ctx context.Context, req ListShardViewsRequest) (ListShardViewsResult, error)
 	// UpdateShardView update shard views in specified cluster.
-	UpdateShardView(ctx context.Context, req UpdateShardViewRequest) error
+	UpdateShardView(ctx context.Context, req UpdateShardViewRequest) (UpdateShardViewResult, error)
+	// UpdateShardViews update multiple shard views in specified cluster atomically.
+	UpdateShardViews(ctx context.Context, req UpdateShardViewsRequest) error
+	// DeleteShardViews delete all the versions of the shard views in specified cluster, the deletions are split into
+	// multiple txns if necessary.
+	DeleteShardViews(ctx context.Context, req DeleteShardViewsRequest) error
 
 	// ListNodes list all nodes in specified cluster.
 	ListNodes(ctx context.Context, req ListNodesRequest) (ListNodesResult, error)
 	// CreateOrUpdateNode create or update node in specified cluster.
 	CreateOrUpdateNode(ctx context.Context, req CreateOrUpdateNodeRequest) error
+
+	// GetRevision get the current revision of the storage.
+	GetRevision(ctx context.Context) (int64, error)
+	// WatchCluster watch the changes of the schemas, tables, shard views, nodes and cluster view in specified cluster,
+	// and the result channel is closed once the watch is broken or the ctx is done.
+	WatchCluster(ctx context.Context, req WatchClusterRequest) <-chan WatchClusterResult
+	// RequestWatchProgress request the watches to report the revision they are up to even if nothing changes.
+	RequestWatchProgress(ctx context.Context) error
 }
 
 // NewStorageWithEtcdBackend creates a new storage with etcd backend.
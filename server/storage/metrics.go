@@ -0,0 +1,48 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// operationDuration observes the latency of the operations of the storage, labeled by the result of success or error.
+var operationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "horaemeta",
+	Subsystem: "storage",
+	Name:      "operation_duration_seconds",
+	Help:      "Latency of the operations of the metadata storage.",
+	Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+}, []string{"operation", "result"})
+
+// operationPayloadBytes observes the bytes an operation exchanges with etcd.
+var operationPayloadBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "horaemeta",
+	Subsystem: "storage",
+	Name:      "operation_payload_bytes",
+	Help:      "Bytes of the keys and values read and written by the operations of the metadata storage.",
+	Buckets:   prometheus.ExponentialBuckets(64, 4, 10),
+}, []string{"operation"})
+
+// txnRetries counts the txns retried after failing their conditions.
+var txnRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "horaemeta",
+	Subsystem: "storage",
+	Name:      "txn_retries_total",
+	Help:      "Number of the txns retried by the operations of the metadata storage for the concurrent modifications.",
+}, []string{"operation"})
@@ -18,7 +18,9 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
 	"math"
+	"path"
 	"strconv"
 	"strings"
 
@@ -26,12 +28,21 @@ import (
 	"github.com/CeresDB/horaemeta/pkg/log"
 	"github.com/CeresDB/horaemeta/server/etcdutil"
 	"github.com/pkg/errors"
+	"go.etcd.io/etcd/api/v3/mvccpb"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"go.etcd.io/etcd/client/v3/clientv3util"
 	"go.uber.org/zap"
+	"golang.org/x/sync/errgroup"
 	"google.golang.org/protobuf/proto"
 )
 
+const (
+	// maxShardViewMergeRetries is the max times to merge an update into the shard view modified concurrently.
+	maxShardViewMergeRetries = 3
+	// listShardViewsConcurrency is the max number of the shard views got concurrently when listing them.
+	listShardViewsConcurrency = 16
+)
+
 type Options struct {
 
 	// MaxScanLimit is the max limit of the number of keys in a scan.
@@ -47,6 +58,8 @@ type Options struct {
 // which provIDes the default implementations for all kinds of storages.
 type metaStorageImpl struct {
 	client *clientv3.Client
+	// kv is the kv of the client recording what the instrumented operations read and write.
+	kv clientv3.KV
 
 	opts Options
 
@@ -55,14 +68,14 @@ type metaStorageImpl struct {
 
 // newEtcdBackend is used to create a new etcd backend.
 func newEtcdStorage(client *clientv3.Client, rootPath string, opts Options) Storage {
-	return &metaStorageImpl{client, opts, rootPath}
+	return &metaStorageImpl{client, newRecordingKV(client.KV), opts, rootPath}
 }
 
 func (s *metaStorageImpl) GetCluster(ctx context.Context, clusterID ClusterID) (Cluster, error) {
 	clusterKey := makeClusterKey(s.rootPath, uint32(clusterID))
 
 	var cluster Cluster
-	value, err := etcdutil.Get(ctx, s.client, clusterKey)
+	value, err := etcdutil.Get(ctx, s.kv, clusterKey)
 	if err != nil {
 		return cluster, errors.WithMessagef(err, "get cluster, clusterID:%d, key:%s", clusterID, clusterKey)
 	}
@@ -73,6 +86,9 @@ func (s *metaStorageImpl) GetCluster(ctx context.Context, clusterID ClusterID) (
 	}
 
 	cluster = convertClusterPB(clusterProto)
+	if err = s.loadClusterOptions(ctx, &cluster); err != nil {
+		return cluster, errors.WithMessagef(err, "load cluster options, clusterID:%d", clusterID)
+	}
 	return cluster, nil
 }
 
@@ -92,11 +108,17 @@ func (s *metaStorageImpl) ListClusters(ctx context.Context) (ListClustersResult,
 		return nil
 	}
 
-	err := etcdutil.Scan(ctx, s.client, startKey, endKey, rangeLimit, do)
+	err := etcdutil.Scan(ctx, s.kv, startKey, endKey, rangeLimit, do)
 	if err != nil {
 		return ListClustersResult{}, errors.WithMessagef(err, "etcd scan clusters, start key:%s, end key:%s, range limit:%d", startKey, endKey, rangeLimit)
 	}
 
+	for i := range clusters {
+		if err := s.loadClusterOptions(ctx, &clusters[i]); err != nil {
+			return ListClustersResult{}, errors.WithMessagef(err, "load cluster options, clusterID:%d", clusters[i].ID)
+		}
+	}
+
 	return ListClustersResult{
 		Clusters: clusters,
 	}, nil
@@ -110,15 +132,22 @@ func (s *metaStorageImpl) CreateCluster(ctx context.Context, req CreateClusterRe
 		return ErrEncode.WithCausef("encode cluster，clusterID:%d, err:%v", req.Cluster.ID, err)
 	}
 
+	optionsValue, err := encodeClusterOptions(req.Cluster)
+	if err != nil {
+		return err
+	}
+
 	key := makeClusterKey(s.rootPath, c.Id)
+	optionsKey := makeClusterOptionsKey(s.rootPath, c.Id)
 
 	// Check if the key exists, if not，create cluster; Otherwise, the cluster already exists and return an error.
 	keyMissing := clientv3util.KeyMissing(key)
 	opCreateCluster := clientv3.OpPut(key, string(value))
+	opCreateClusterOptions := clientv3.OpPut(optionsKey, optionsValue)
 
-	resp, err := s.client.Txn(ctx).
+	resp, err := s.kv.Txn(ctx).
 		If(keyMissing).
-		Then(opCreateCluster).
+		Then(opCreateCluster, opCreateClusterOptions).
 		Commit()
 	if err != nil {
 		return errors.WithMessagef(err, "create cluster, clusterID:%d, key:%s", req.Cluster.ID, key)
@@ -137,14 +166,21 @@ func (s *metaStorageImpl) UpdateCluster(ctx context.Context, req UpdateClusterRe
 		return ErrEncode.WithCausef("encode cluster，clusterID:%d, err:%v", req.Cluster.ID, err)
 	}
 
+	optionsValue, err := encodeClusterOptions(req.Cluster)
+	if err != nil {
+		return err
+	}
+
 	key := makeClusterKey(s.rootPath, c.Id)
+	optionsKey := makeClusterOptionsKey(s.rootPath, c.Id)
 
 	keyExists := clientv3util.KeyExists(key)
 	opUpdateCluster := clientv3.OpPut(key, string(value))
+	opUpdateClusterOptions := clientv3.OpPut(optionsKey, optionsValue)
 
-	resp, err := s.client.Txn(ctx).
+	resp, err := s.kv.Txn(ctx).
 		If(keyExists).
-		Then(opUpdateCluster).
+		Then(opUpdateCluster, opUpdateClusterOptions).
 		Commit()
 	if err != nil {
 		return errors.WithMessagef(err, "update cluster, clusterID:%d, key:%s", req.Cluster.ID, key)
@@ -155,6 +191,50 @@ func (s *metaStorageImpl) UpdateCluster(ctx context.Context, req UpdateClusterRe
 	return nil
 }
 
+// loadClusterOptions fills the cluster with the options stored beside it.
+// The clusters created before the options are introduced have no options key, and the default options are used.
+func (s *metaStorageImpl) loadClusterOptions(ctx context.Context, cluster *Cluster) error {
+	key := makeClusterOptionsKey(s.rootPath, uint32(cluster.ID))
+	value, err := etcdutil.Get(ctx, s.kv, key)
+	if err == etcdutil.ErrEtcdKVGetNotFound {
+		return nil
+	}
+	if err != nil {
+		return errors.WithMessagef(err, "get cluster options, key:%s", key)
+	}
+
+	var opts clusterOptions
+	if err = json.Unmarshal([]byte(value), &opts); err != nil {
+		return ErrDecode.WithCausef("decode cluster options, clusterID:%d, err:%v", cluster.ID, err)
+	}
+	cluster.CaseInsensitiveName = opts.CaseInsensitiveName
+	cluster.DefaultSchemaName = opts.DefaultSchemaName
+	cluster.HeartbeatIntervalBounds = opts.HeartbeatIntervalBounds
+	cluster.Quota = opts.Quota
+	cluster.CordonedNodes = opts.CordonedNodes
+	if len(opts.SchemaCreationPolicy) > 0 {
+		cluster.SchemaCreationPolicy = opts.SchemaCreationPolicy
+	}
+	cluster.Protected = opts.Protected
+	return nil
+}
+
+func encodeClusterOptions(cluster Cluster) (string, error) {
+	value, err := json.Marshal(clusterOptions{
+		CaseInsensitiveName:     cluster.CaseInsensitiveName,
+		DefaultSchemaName:       cluster.DefaultSchemaName,
+		HeartbeatIntervalBounds: cluster.HeartbeatIntervalBounds,
+		Quota:                   cluster.Quota,
+		CordonedNodes:           cluster.CordonedNodes,
+		SchemaCreationPolicy:    cluster.SchemaCreationPolicy,
+		Protected:               cluster.Protected,
+	})
+	if err != nil {
+		return "", ErrEncode.WithCausef("encode cluster options, clusterID:%d, err:%v", cluster.ID, err)
+	}
+	return string(value), nil
+}
+
 // CreateClusterView return error if the cluster view already exists.
 func (s *metaStorageImpl) CreateClusterView(ctx context.Context, req CreateClusterViewRequest) error {
 	clusterViewPB := convertClusterViewToPB(req.ClusterView)
@@ -172,7 +252,7 @@ func (s *metaStorageImpl) CreateClusterView(ctx context.Context, req CreateClust
 	opCreateClusterTopology := clientv3.OpPut(key, string(value))
 	opCreateClusterTopologyLatestVersion := clientv3.OpPut(latestVersionKey, fmtID(clusterViewPB.Version))
 
-	resp, err := s.client.Txn(ctx).
+	resp, err := s.kv.Txn(ctx).
 		If(latestVersionKeyMissing, keyMissing).
 		Then(opCreateClusterTopology, opCreateClusterTopologyLatestVersion).
 		Commit()
@@ -188,13 +268,13 @@ func (s *metaStorageImpl) CreateClusterView(ctx context.Context, req CreateClust
 func (s *metaStorageImpl) GetClusterView(ctx context.Context, req GetClusterViewRequest) (GetClusterViewResult, error) {
 	var viewRes GetClusterViewResult
 	key := makeClusterViewLatestVersionKey(s.rootPath, uint32(req.ClusterID))
-	version, err := etcdutil.Get(ctx, s.client, key)
+	version, err := etcdutil.Get(ctx, s.kv, key)
 	if err != nil {
 		return viewRes, errors.WithMessagef(err, "get cluster view latest version, clusterID:%d, key:%s", req.ClusterID, key)
 	}
 
 	key = makeClusterViewKey(s.rootPath, uint32(req.ClusterID), version)
-	value, err := etcdutil.Get(ctx, s.client, key)
+	value, err := etcdutil.Get(ctx, s.kv, key)
 	if err != nil {
 		return viewRes, errors.WithMessagef(err, "get cluster view, clusterID:%d, key:%s", req.ClusterID, key)
 	}
@@ -226,7 +306,7 @@ func (s *metaStorageImpl) UpdateClusterView(ctx context.Context, req UpdateClust
 	opPutClusterTopology := clientv3.OpPut(key, string(value))
 	opPutLatestVersion := clientv3.OpPut(latestVersionKey, fmtID(clusterViewPB.Version))
 
-	resp, err := s.client.Txn(ctx).
+	resp, err := s.kv.Txn(ctx).
 		If(latestVersionEquals).
 		Then(opPutClusterTopology, opPutLatestVersion).
 		Commit()
@@ -256,7 +336,7 @@ func (s *metaStorageImpl) ListSchemas(ctx context.Context, req ListSchemasReques
 		return nil
 	}
 
-	err := etcdutil.Scan(ctx, s.client, startKey, endKey, rangeLimit, do)
+	err := etcdutil.Scan(ctx, s.kv, startKey, endKey, rangeLimit, do)
 	if err != nil {
 		return ListSchemasResult{}, errors.WithMessagef(err, "scan schemas, clusterID:%d, start key:%s, end key:%s, range limit:%d", req.ClusterID, startKey, endKey, rangeLimit)
 	}
@@ -278,7 +358,7 @@ func (s *metaStorageImpl) CreateSchema(ctx context.Context, req CreateSchemaRequ
 	keyMissing := clientv3util.KeyMissing(key)
 	opCreateSchema := clientv3.OpPut(key, string(value))
 
-	resp, err := s.client.Txn(ctx).
+	resp, err := s.kv.Txn(ctx).
 		If(keyMissing).
 		Then(opCreateSchema).
 		Commit()
@@ -291,6 +371,65 @@ func (s *metaStorageImpl) CreateSchema(ctx context.Context, req CreateSchemaRequ
 	return nil
 }
 
+func (s *metaStorageImpl) ListSchemaSettings(ctx context.Context, req ListSchemaSettingsRequest) (ListSchemaSettingsResult, error) {
+	startKey := makeSchemaSettingsKey(s.rootPath, uint32(req.ClusterID), 0)
+	endKey := makeSchemaSettingsKey(s.rootPath, uint32(req.ClusterID), math.MaxUint32)
+	rangeLimit := s.opts.MaxScanLimit
+
+	settings := make(map[SchemaID]SchemaSettings)
+	do := func(key string, value []byte) error {
+		schemaID, err := strconv.ParseUint(path.Base(key), 10, 32)
+		if err != nil {
+			return ErrDecode.WithCausef("decode schema id, key:%s, err:%v", key, err)
+		}
+		var schemaSettings SchemaSettings
+		if err := json.Unmarshal(value, &schemaSettings); err != nil {
+			return ErrDecode.WithCausef("decode schema settings, key:%s, clusterID:%d, err:%v", key, req.ClusterID, err)
+		}
+
+		settings[SchemaID(schemaID)] = schemaSettings
+		return nil
+	}
+
+	err := etcdutil.Scan(ctx, s.kv, startKey, endKey, rangeLimit, do)
+	if err != nil {
+		return ListSchemaSettingsResult{}, errors.WithMessagef(err, "scan schema settings, clusterID:%d, start key:%s, end key:%s, range limit:%d", req.ClusterID, startKey, endKey, rangeLimit)
+	}
+
+	return ListSchemaSettingsResult{Settings: settings}, nil
+}
+
+// PutSchemaSettings return error if the schema does not exist.
+func (s *metaStorageImpl) PutSchemaSettings(ctx context.Context, req PutSchemaSettingsRequest) error {
+	value, err := json.Marshal(req.Settings)
+	if err != nil {
+		return ErrEncode.WithCausef("encode schema settings, clusterID:%d, schemaID:%d, err:%v", req.ClusterID, req.SchemaID, err)
+	}
+
+	schemaKey := makeSchemaKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID))
+	key := makeSchemaSettingsKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID))
+
+	resp, err := s.kv.Txn(ctx).
+		If(clientv3util.KeyExists(schemaKey)).
+		Then(clientv3.OpPut(key, string(value))).
+		Commit()
+	if err != nil {
+		return errors.WithMessagef(err, "put schema settings, clusterID:%d, schemaID:%d, key:%s", req.ClusterID, req.SchemaID, key)
+	}
+	if !resp.Succeeded {
+		return ErrPutSchemaSettings.WithCausef("schema may not exist, clusterID:%d, schemaID:%d, key:%s", req.ClusterID, req.SchemaID, key)
+	}
+	return nil
+}
+
+func (s *metaStorageImpl) DeleteSchemaSettings(ctx context.Context, req DeleteSchemaSettingsRequest) error {
+	key := makeSchemaSettingsKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID))
+	if _, err := s.kv.Delete(ctx, key); err != nil {
+		return errors.WithMessagef(err, "delete schema settings, clusterID:%d, schemaID:%d, key:%s", req.ClusterID, req.SchemaID, key)
+	}
+	return nil
+}
+
 // CreateTable return error if the table already exists.
 func (s *metaStorageImpl) CreateTable(ctx context.Context, req CreateTableRequest) error {
 	table := convertTableToPB(req.Table)
@@ -300,7 +439,7 @@ func (s *metaStorageImpl) CreateTable(ctx context.Context, req CreateTableReques
 	}
 
 	key := makeTableKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID), table.Id)
-	nameToIDKey := makeNameToIDKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID), table.Name)
+	nameToIDKey := makeNameToIDKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID), req.NormalizedName)
 
 	// Check if the key and the name to id key exists, if not，create table; Otherwise, the table already exists and return an error.
 	idKeyMissing := clientv3util.KeyMissing(key)
@@ -308,7 +447,7 @@ func (s *metaStorageImpl) CreateTable(ctx context.Context, req CreateTableReques
 	opCreateTable := clientv3.OpPut(key, string(value))
 	opCreateNameToID := clientv3.OpPut(nameToIDKey, fmtID(table.Id))
 
-	resp, err := s.client.Txn(ctx).
+	resp, err := s.kv.Txn(ctx).
 		If(nameKeyMissing, idKeyMissing).
 		Then(opCreateTable, opCreateNameToID).
 		Commit()
@@ -321,9 +460,56 @@ func (s *metaStorageImpl) CreateTable(ctx context.Context, req CreateTableReques
 	return nil
 }
 
+func (s *metaStorageImpl) CreateTables(ctx context.Context, req CreateTablesRequest) error {
+	// Every table takes two operations, one for the table key and the other for the name to id key, and so do the
+	// conditions.
+	maxOps := s.opts.MaxOpsPerTxn - s.opts.MaxOpsPerTxn%2
+	if maxOps < 2 {
+		maxOps = 2
+	}
+
+	conds := make([]clientv3.Cmp, 0, maxOps)
+	opCreates := make([]clientv3.Op, 0, maxOps)
+	commit := func() error {
+		if len(opCreates) == 0 {
+			return nil
+		}
+		resp, err := s.kv.Txn(ctx).If(conds...).Then(opCreates...).Commit()
+		if err != nil {
+			return errors.WithMessagef(err, "create tables, clusterID:%d, schemaID:%d, ops:%d", req.ClusterID, req.SchemaID, len(opCreates))
+		}
+		if !resp.Succeeded {
+			return ErrCreateTableAgain.WithCausef("some tables may already exist, clusterID:%d, schemaID:%d", req.ClusterID, req.SchemaID)
+		}
+		conds = conds[:0]
+		opCreates = opCreates[:0]
+		return nil
+	}
+
+	for normalizedName, table := range req.Tables {
+		tablePB := convertTableToPB(table)
+		value, err := proto.Marshal(&tablePB)
+		if err != nil {
+			return ErrEncode.WithCausef("encode table, clusterID:%d, schemaID:%d, tableID:%d, err:%v", req.ClusterID, req.SchemaID, tablePB.Id, err)
+		}
+
+		key := makeTableKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID), tablePB.Id)
+		nameToIDKey := makeNameToIDKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID), normalizedName)
+		conds = append(conds, clientv3util.KeyMissing(key), clientv3util.KeyMissing(nameToIDKey))
+		opCreates = append(opCreates, clientv3.OpPut(key, string(value)), clientv3.OpPut(nameToIDKey, fmtID(tablePB.Id)))
+		if len(opCreates) >= maxOps {
+			if err := commit(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return commit()
+}
+
 func (s *metaStorageImpl) GetTable(ctx context.Context, req GetTableRequest) (GetTableResult, error) {
 	var res GetTableResult
-	value, err := etcdutil.Get(ctx, s.client, makeNameToIDKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID), req.TableName))
+	value, err := etcdutil.Get(ctx, s.kv, makeNameToIDKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID), req.TableName))
 	if err == etcdutil.ErrEtcdKVGetNotFound {
 		res.Exists = false
 		return res, nil
@@ -338,18 +524,21 @@ func (s *metaStorageImpl) GetTable(ctx context.Context, req GetTableRequest) (Ge
 	}
 
 	key := makeTableKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID), tableID)
-	value, err = etcdutil.Get(ctx, s.client, key)
+	resp, err := s.kv.Get(ctx, key)
 	if err != nil {
 		return res, errors.WithMessagef(err, "get table, clusterID:%d, schemaID:%d, tableID:%d, key:%s", req.ClusterID, req.SchemaID, tableID, key)
 	}
+	if len(resp.Kvs) != 1 {
+		return res, errors.WithMessagef(etcdutil.ErrEtcdKVGetNotFound, "get table, clusterID:%d, schemaID:%d, tableID:%d, key:%s", req.ClusterID, req.SchemaID, tableID, key)
+	}
 
 	table := &clusterpb.Table{}
-	if err = proto.Unmarshal([]byte(value), table); err != nil {
+	if err = proto.Unmarshal(resp.Kvs[0].Value, table); err != nil {
 		return res, ErrDecode.WithCausef("decode table, clusterID:%d, schemaID:%d, tableID:%d, err:%v", req.ClusterID, req.SchemaID, tableID, err)
 	}
 
 	res = GetTableResult{
-		Table:  convertTablePB(table),
+		Table:  convertTablePB(table, resp.Kvs[0].Version),
 		Exists: true,
 	}
 	return res, nil
@@ -361,16 +550,16 @@ func (s *metaStorageImpl) ListTables(ctx context.Context, req ListTableRequest)
 	rangeLimit := s.opts.MaxScanLimit
 
 	var tables []Table
-	do := func(key string, value []byte) error {
+	do := func(kv *mvccpb.KeyValue) error {
 		tablePB := &clusterpb.Table{}
-		if err := proto.Unmarshal(value, tablePB); err != nil {
-			return ErrDecode.WithCausef("decode table, key:%s, value:%v, clusterID:%d, schemaID:%d, err:%v", key, value, req.ClusterID, req.SchemaID, err)
+		if err := proto.Unmarshal(kv.Value, tablePB); err != nil {
+			return ErrDecode.WithCausef("decode table, key:%s, value:%v, clusterID:%d, schemaID:%d, err:%v", kv.Key, kv.Value, req.ClusterID, req.SchemaID, err)
 		}
-		table := convertTablePB(tablePB)
+		table := convertTablePB(tablePB, kv.Version)
 		tables = append(tables, table)
 		return nil
 	}
-	err := etcdutil.Scan(ctx, s.client, startKey, endKey, rangeLimit, do)
+	err := etcdutil.ScanKV(ctx, s.kv, startKey, endKey, rangeLimit, do)
 	if err != nil {
 		return ListTablesResult{}, errors.WithMessagef(err, "scan tables, clusterID:%d, schemaID:%d, start key:%s, end key:%s, range limit:%d", req.ClusterID, req.SchemaID, startKey, endKey, rangeLimit)
 	}
@@ -380,10 +569,49 @@ func (s *metaStorageImpl) ListTables(ctx context.Context, req ListTableRequest)
 	}, nil
 }
 
+// UpdateTable compares the version of the table key to detect the concurrent modifications, e.g. the table is dropped or
+// renamed by others, and the version is increased by one once updated.
+func (s *metaStorageImpl) UpdateTable(ctx context.Context, req UpdateTableRequest) error {
+	table := convertTableToPB(req.Table)
+	value, err := proto.Marshal(&table)
+	if err != nil {
+		return ErrEncode.WithCausef("encode table, clusterID:%d, schemaID:%d, tableID:%d, err:%v", req.ClusterID, req.SchemaID, table.Id, err)
+	}
+
+	key := makeTableKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID), table.Id)
+	prevNameKey := makeNameToIDKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID), req.PrevNormalizedName)
+	conds := []clientv3.Cmp{
+		clientv3.Compare(clientv3.Version(key), "=", int64(req.Table.Version)),
+		clientv3.Compare(clientv3.Value(prevNameKey), "=", fmtID(table.Id)),
+	}
+	ops := []clientv3.Op{clientv3.OpPut(key, string(value))}
+	if req.NormalizedName != req.PrevNormalizedName {
+		nameKey := makeNameToIDKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID), req.NormalizedName)
+		conds = append(conds, clientv3util.KeyMissing(nameKey))
+		ops = append(ops, clientv3.OpDelete(prevNameKey), clientv3.OpPut(nameKey, fmtID(table.Id)))
+	}
+
+	resp, err := s.kv.Txn(ctx).
+		If(conds...).
+		Then(ops...).
+		Else(clientv3.OpGet(key)).
+		Commit()
+	if err != nil {
+		return errors.WithMessagef(err, "update table, clusterID:%d, schemaID:%d, tableID:%d, key:%s", req.ClusterID, req.SchemaID, table.Id, key)
+	}
+	if !resp.Succeeded {
+		if currentVersion, ok := tableVersionInElse(resp); ok && currentVersion != req.Table.Version {
+			return ErrTableVersionConflict.WithCausef("update table, clusterID:%d, schemaID:%d, tableID:%d, version:%d, currentVersion:%d", req.ClusterID, req.SchemaID, table.Id, req.Table.Version, currentVersion)
+		}
+		return ErrUpdateTableAgain.WithCausef("table may have been deleted or the name is taken, clusterID:%d, schemaID:%d, tableID:%d, name:%s", req.ClusterID, req.SchemaID, table.Id, req.NormalizedName)
+	}
+	return nil
+}
+
 func (s *metaStorageImpl) DeleteTable(ctx context.Context, req DeleteTableRequest) error {
 	nameKey := makeNameToIDKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID), req.TableName)
 
-	value, err := etcdutil.Get(ctx, s.client, nameKey)
+	value, err := etcdutil.Get(ctx, s.kv, nameKey)
 	if err != nil {
 		return errors.WithMessagef(err, "get table id, clusterID:%d, schemaID:%d, table name:%s", req.ClusterID, req.SchemaID, req.TableName)
 	}
@@ -395,26 +623,83 @@ func (s *metaStorageImpl) DeleteTable(ctx context.Context, req DeleteTableReques
 
 	key := makeTableKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID), tableID)
 
-	nameKeyExists := clientv3util.KeyExists(nameKey)
-	idKeyExists := clientv3util.KeyExists(key)
+	// The name to id key is compared too, in case that the table is renamed after its id is got.
+	conds := []clientv3.Cmp{
+		clientv3.Compare(clientv3.Value(nameKey), "=", value),
+		clientv3util.KeyExists(key),
+	}
+	if req.Version != 0 {
+		conds = append(conds, clientv3.Compare(clientv3.Version(key), "=", int64(req.Version)))
+	}
 
 	opDeleteNameToID := clientv3.OpDelete(nameKey)
 	opDeleteTable := clientv3.OpDelete(key)
 
-	resp, err := s.client.Txn(ctx).
-		If(nameKeyExists, idKeyExists).
+	resp, err := s.kv.Txn(ctx).
+		If(conds...).
 		Then(opDeleteNameToID, opDeleteTable).
+		Else(clientv3.OpGet(key)).
 		Commit()
 	if err != nil {
 		return errors.WithMessagef(err, "delete table, clusterID:%d, schemaID:%d, tableID:%d, tableName:%s", req.ClusterID, req.SchemaID, tableID, req.TableName)
 	}
 	if !resp.Succeeded {
+		if currentVersion, ok := tableVersionInElse(resp); ok && req.Version != 0 && currentVersion != req.Version {
+			return ErrTableVersionConflict.WithCausef("delete table, clusterID:%d, schemaID:%d, tableID:%d, version:%d, currentVersion:%d", req.ClusterID, req.SchemaID, tableID, req.Version, currentVersion)
+		}
 		return ErrDeleteTableAgain.WithCausef("table may have been deleted, clusterID:%d, schemaID:%d, tableID:%d, tableName:%s", req.ClusterID, req.SchemaID, tableID, req.TableName)
 	}
 
 	return nil
 }
 
+// tableVersionInElse returns the version of the table got by the else branch of the failed txn, and the second output
+// parameter bool: returns false if the table doesn't exist.
+func tableVersionInElse(resp *clientv3.TxnResponse) (uint64, bool) {
+	if len(resp.Responses) == 0 {
+		return 0, false
+	}
+	rangeResp := resp.Responses[0].GetResponseRange()
+	if rangeResp == nil || len(rangeResp.Kvs) == 0 {
+		return 0, false
+	}
+	return uint64(rangeResp.Kvs[0].Version), true
+}
+
+// DeleteTables won't check the existence of the tables, and the tables already deleted are just skipped.
+func (s *metaStorageImpl) DeleteTables(ctx context.Context, req DeleteTablesRequest) error {
+	// Every table takes two operations, one for the table key and the other for the name to id key.
+	maxOps := s.opts.MaxOpsPerTxn - s.opts.MaxOpsPerTxn%2
+	if maxOps < 2 {
+		maxOps = 2
+	}
+
+	opDeletes := make([]clientv3.Op, 0, maxOps)
+	commit := func() error {
+		if len(opDeletes) == 0 {
+			return nil
+		}
+		if _, err := s.kv.Txn(ctx).Then(opDeletes...).Commit(); err != nil {
+			return errors.WithMessagef(err, "delete tables, clusterID:%d, schemaID:%d, ops:%d", req.ClusterID, req.SchemaID, len(opDeletes))
+		}
+		opDeletes = opDeletes[:0]
+		return nil
+	}
+
+	for tableName, tableID := range req.Tables {
+		nameKey := makeNameToIDKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID), tableName)
+		key := makeTableKey(s.rootPath, uint32(req.ClusterID), uint32(req.SchemaID), uint64(tableID))
+		opDeletes = append(opDeletes, clientv3.OpDelete(nameKey), clientv3.OpDelete(key))
+		if len(opDeletes) >= maxOps {
+			if err := commit(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return commit()
+}
+
 func (s *metaStorageImpl) createNShardViews(ctx context.Context, clusterID ClusterID, shardViews []ShardView, ifConds []clientv3.Cmp, opCreates []clientv3.Op) error {
 	for _, shardView := range shardViews {
 		shardViewPB := convertShardViewToPB(shardView)
@@ -431,7 +716,7 @@ func (s *metaStorageImpl) createNShardViews(ctx context.Context, clusterID Clust
 		opCreates = append(opCreates, clientv3.OpPut(key, string(value)), clientv3.OpPut(latestVersionKey, fmtID(shardView.Version)))
 	}
 
-	resp, err := s.client.Txn(ctx).
+	resp, err := s.kv.Txn(ctx).
 		If(ifConds...).
 		Then(opCreates...).
 		Commit()
@@ -468,40 +753,48 @@ func (s *metaStorageImpl) CreateShardViews(ctx context.Context, req CreateShardV
 func (s *metaStorageImpl) ListShardViews(ctx context.Context, req ListShardViewsRequest) (ListShardViewsResult, error) {
 	var listRes ListShardViewsResult
 	var shardViews []ShardView
-	prefix := makeShardViewVersionKey(s.rootPath, uint32(req.ClusterID))
-	keys, err := etcdutil.List(ctx, s.client, prefix)
-	if err != nil {
-		return listRes, errors.WithMessagef(err, "list shard view, clusterID:%d", req.ClusterID)
-	}
-	for _, key := range keys {
-		if strings.HasSuffix(key, latestVersion) {
-			shardIDKey, err := decodeShardViewVersionKey(key)
-			if err != nil {
-				return listRes, errors.WithMessagef(err, "list shard view latest version, clusterID:%d, shardIDKey:%s, key:%s", req.ClusterID, shardIDKey, key)
-			}
-			shardID, err := strconv.ParseUint(shardIDKey, 10, 32)
-			if err != nil {
-				return listRes, errors.WithMessagef(err, "list shard view latest version, clusterID:%d, shardID:%d, key:%s", req.ClusterID, shardID, key)
-			}
-
-			version, err := etcdutil.Get(ctx, s.client, key)
-			if err != nil {
-				return listRes, errors.WithMessagef(err, "list shard view latest version, clusterID:%d, shardID:%d, key:%s", req.ClusterID, shardID, key)
+	shardIDs := req.ShardIDs
+	if len(shardIDs) == 0 {
+		prefix := makeShardViewVersionKey(s.rootPath, uint32(req.ClusterID))
+		keys, err := etcdutil.List(ctx, s.kv, prefix)
+		if err != nil {
+			return listRes, errors.WithMessagef(err, "list shard view, clusterID:%d", req.ClusterID)
+		}
+		for _, key := range keys {
+			if strings.HasSuffix(key, latestVersion) {
+				shardIDKey, err := decodeShardViewVersionKey(key)
+				if err != nil {
+					return listRes, errors.WithMessagef(err, "list shard view latest version, clusterID:%d, shardIDKey:%s, key:%s", req.ClusterID, shardIDKey, key)
+				}
+				shardID, err := strconv.ParseUint(shardIDKey, 10, 32)
+				if err != nil {
+					return listRes, errors.WithMessagef(err, "list shard view latest version, clusterID:%d, shardID:%d, key:%s", req.ClusterID, shardID, key)
+				}
+				shardIDs = append(shardIDs, ShardID(shardID))
 			}
+		}
+	}
 
-			key = makeShardViewKey(s.rootPath, uint32(req.ClusterID), uint32(shardID), version)
-			value, err := etcdutil.Get(ctx, s.client, key)
+	// Every shard view takes several round trips to get, so they are got concurrently.
+	shardViews = make([]ShardView, len(shardIDs))
+	g, gctx := errgroup.WithContext(ctx)
+	limiter := make(chan struct{}, listShardViewsConcurrency)
+	for i, shardID := range shardIDs {
+		i, shardID := i, shardID
+		g.Go(func() error {
+			limiter <- struct{}{}
+			defer func() { <-limiter }()
+
+			shardView, err := s.getShardView(gctx, req.ClusterID, shardID)
 			if err != nil {
-				return listRes, errors.WithMessagef(err, "list shard view, clusterID:%d, shardID:%d, key:%s", req.ClusterID, shardID, key)
-			}
-
-			shardViewPB := &clusterpb.ShardView{}
-			if err = proto.Unmarshal([]byte(value), shardViewPB); err != nil {
-				return listRes, ErrDecode.WithCausef("decode shard view, clusterID:%d, shardID:%d, err:%v", req.ClusterID, shardID, err)
+				return errors.WithMessage(err, "list shard view")
 			}
-			shardView := convertShardViewPB(shardViewPB)
-			shardViews = append(shardViews, shardView)
-		}
+			shardViews[i] = shardView
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return listRes, err
 	}
 
 	listRes = ListShardViewsResult{
@@ -510,40 +803,202 @@ func (s *metaStorageImpl) ListShardViews(ctx context.Context, req ListShardViews
 	return listRes, nil
 }
 
-func (s *metaStorageImpl) UpdateShardView(ctx context.Context, req UpdateShardViewRequest) error {
-	shardViewPB := convertShardViewToPB(req.ShardView)
-	value, err := proto.Marshal(&shardViewPB)
+// getShardView gets the latest shard view along with its table versions.
+func (s *metaStorageImpl) getShardView(ctx context.Context, clusterID ClusterID, shardID ShardID) (ShardView, error) {
+	var shardView ShardView
+	latestVersionKey := makeShardViewLatestVersionKey(s.rootPath, uint32(clusterID), uint32(shardID))
+	version, err := etcdutil.Get(ctx, s.kv, latestVersionKey)
 	if err != nil {
-		return ErrEncode.WithCausef("encode shard view, clusterID:%d, shardID:%d, err:%v", req.ClusterID, req.ShardView.ShardID, err)
+		return shardView, errors.WithMessagef(err, "get shard view latest version, clusterID:%d, shardID:%d, key:%s", clusterID, shardID, latestVersionKey)
 	}
 
-	key := makeShardViewKey(s.rootPath, uint32(req.ClusterID), shardViewPB.ShardId, fmtID(shardViewPB.GetVersion()))
-	oldTopologyKey := makeShardViewKey(s.rootPath, uint32(req.ClusterID), shardViewPB.ShardId, fmtID(req.PrevVersion))
-	latestVersionKey := makeShardViewLatestVersionKey(s.rootPath, uint32(req.ClusterID), shardViewPB.ShardId)
+	key := makeShardViewKey(s.rootPath, uint32(clusterID), uint32(shardID), version)
+	value, err := etcdutil.Get(ctx, s.kv, key)
+	if err != nil {
+		return shardView, errors.WithMessagef(err, "get shard view, clusterID:%d, shardID:%d, key:%s", clusterID, shardID, key)
+	}
 
-	// Check whether the latest version is equal to that in etcd. If it is equal，update shard clusterView and latest version; Otherwise, return an error.
-	opPutLatestVersion := clientv3.OpPut(latestVersionKey, fmtID(shardViewPB.Version))
-	opPutShardTopology := clientv3.OpPut(key, string(value))
+	shardViewPB := &clusterpb.ShardView{}
+	if err = proto.Unmarshal([]byte(value), shardViewPB); err != nil {
+		return shardView, ErrDecode.WithCausef("decode shard view, clusterID:%d, shardID:%d, err:%v", clusterID, shardID, err)
+	}
+	shardView = convertShardViewPB(shardViewPB)
+
+	// The table versions may be missing for the shard view written by the old version, and all the tables are regarded
+	// as being at the shard version in this case.
+	tableVersionsKey := makeShardTableVersionsKey(s.rootPath, uint32(clusterID), uint32(shardID))
+	tableVersionsValue, err := etcdutil.Get(ctx, s.kv, tableVersionsKey)
+	if err == etcdutil.ErrEtcdKVGetNotFound {
+		return shardView, nil
+	}
+	if err != nil {
+		return shardView, errors.WithMessagef(err, "get shard table versions, clusterID:%d, shardID:%d, key:%s", clusterID, shardID, tableVersionsKey)
+	}
+	var stored shardTableVersions
+	if err = json.Unmarshal([]byte(tableVersionsValue), &stored); err != nil {
+		return shardView, ErrDecode.WithCausef("decode shard table versions, clusterID:%d, shardID:%d, err:%v", clusterID, shardID, err)
+	}
+	for _, tableID := range shardView.TableIDs {
+		if version, ok := stored.TableVersions[tableID]; ok {
+			shardView.TableVersions[tableID] = version
+		}
+	}
+
+	return shardView, nil
+}
+
+// UpdateShardView updates the shard view if its latest version in etcd equals to the PrevVersion. Otherwise, the update
+// adding tables is merged into the latest shard view and retried, so the concurrent additions of different tables to the
+// same shard don't fail each other.
+func (s *metaStorageImpl) UpdateShardView(ctx context.Context, req UpdateShardViewRequest) (UpdateShardViewResult, error) {
+	shardView := req.ShardView
+	prevVersion := req.PrevVersion
+	for retry := 0; ; retry++ {
+		succeeded, err := s.putShardView(ctx, req.ClusterID, shardView, prevVersion)
+		if err != nil {
+			return UpdateShardViewResult{}, err
+		}
+		if succeeded {
+			return UpdateShardViewResult{ShardView: shardView}, nil
+		}
+
+		if len(req.AddedTableIDs) == 0 || retry >= maxShardViewMergeRetries {
+			return UpdateShardViewResult{}, ErrUpdateShardViewConflict.WithCausef("shard view may have been modified, clusterID:%d, shardID:%d, prevVersion:%d", req.ClusterID, shardView.ShardID, prevVersion)
+		}
+
+		latest, err := s.getShardView(ctx, req.ClusterID, shardView.ShardID)
+		if err != nil {
+			return UpdateShardViewResult{}, errors.WithMessage(err, "get latest shard view to merge")
+		}
+		merged, ok := mergeShardViewAdditions(latest, req.ShardView, req.AddedTableIDs)
+		if !ok {
+			return UpdateShardViewResult{}, ErrUpdateShardViewConflict.WithCausef("added tables conflict with the latest shard view, clusterID:%d, shardID:%d, version:%d, latestVersion:%d", req.ClusterID, shardView.ShardID, req.ShardView.Version, latest.Version)
+		}
+		recordTxnRetry(ctx)
+		log.Info("merge shard view update", zap.Uint32("shardID", uint32(shardView.ShardID)), zap.Uint64("prevVersion", prevVersion), zap.Uint64("latestVersion", latest.Version), zap.Uint64("mergedVersion", merged.Version))
+		shardView = merged
+		prevVersion = latest.Version
+	}
+}
 
-	resp, err := s.client.Txn(ctx).
-		Then(opPutLatestVersion, opPutShardTopology).
+// UpdateShardViews updates all the shard views in one transaction if the latest versions of all of them in etcd equal to
+// their PrevVersion, and none of them is updated otherwise.
+func (s *metaStorageImpl) UpdateShardViews(ctx context.Context, req UpdateShardViewsRequest) error {
+	ifConds := make([]clientv3.Cmp, 0, len(req.Updates))
+	opPuts := make([]clientv3.Op, 0, len(req.Updates)*3)
+	for _, update := range req.Updates {
+		cmp, ops, err := s.buildPutShardViewOps(req.ClusterID, update.ShardView, update.PrevVersion)
+		if err != nil {
+			return err
+		}
+		ifConds = append(ifConds, cmp)
+		opPuts = append(opPuts, ops...)
+	}
+
+	resp, err := s.kv.Txn(ctx).
+		If(ifConds...).
+		Then(opPuts...).
 		Commit()
 	if err != nil {
-		return errors.WithMessagef(err, "fail to put shard clusterView, clusterID:%d, shardID:%d, key:%s", req.ClusterID, shardViewPB.ShardId, key)
+		return errors.WithMessagef(err, "fail to put shard views, clusterID:%d", req.ClusterID)
 	}
 	if !resp.Succeeded {
-		return ErrUpdateShardViewConflict.WithCausef("shard view may have been modified, clusterID:%d, shardID:%d, key:%s, resp:%v", req.ClusterID, shardViewPB.ShardId, key, resp)
+		return errors.WithMessagef(ErrUpdateShardViewConflict, "shard views may have been modified, clusterID:%d", req.ClusterID)
+	}
+
+	for _, update := range req.Updates {
+		s.removeExpiredShardView(ctx, req.ClusterID, update.ShardView, update.PrevVersion)
 	}
+	return nil
+}
 
-	// Try to remove expired shard view.
-	if req.PrevVersion != shardViewPB.Version {
-		opDelShardTopology := clientv3.OpDelete(oldTopologyKey)
-		if _, err := s.client.Do(ctx, opDelShardTopology); err != nil {
-			log.Warn("remove expired shard view failed", zap.Error(err), zap.String("oldTopologyKey", oldTopologyKey))
+func (s *metaStorageImpl) DeleteShardViews(ctx context.Context, req DeleteShardViewsRequest) error {
+	opDeletes := make([]clientv3.Op, 0, s.opts.MaxOpsPerTxn)
+	commit := func() error {
+		if len(opDeletes) == 0 {
+			return nil
 		}
+		if _, err := s.kv.Txn(ctx).Then(opDeletes...).Commit(); err != nil {
+			return errors.WithMessagef(err, "delete shard views, clusterID:%d, ops:%d", req.ClusterID, len(opDeletes))
+		}
+		opDeletes = opDeletes[:0]
+		return nil
 	}
 
-	return nil
+	for _, shardID := range req.ShardIDs {
+		prefix := makeShardViewPrefix(s.rootPath, uint32(req.ClusterID), uint32(shardID))
+		opDeletes = append(opDeletes, clientv3.OpDelete(prefix, clientv3.WithPrefix()))
+		if len(opDeletes) >= s.opts.MaxOpsPerTxn {
+			if err := commit(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return commit()
+}
+
+// putShardView puts the shard view and its table versions if the latest version in etcd equals to the prevVersion, and
+// returns whether it succeeds.
+func (s *metaStorageImpl) putShardView(ctx context.Context, clusterID ClusterID, shardView ShardView, prevVersion uint64) (bool, error) {
+	latestVersionEquals, opPuts, err := s.buildPutShardViewOps(clusterID, shardView, prevVersion)
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := s.kv.Txn(ctx).
+		If(latestVersionEquals).
+		Then(opPuts...).
+		Commit()
+	if err != nil {
+		return false, errors.WithMessagef(err, "fail to put shard clusterView, clusterID:%d, shardID:%d", clusterID, shardView.ShardID)
+	}
+	if !resp.Succeeded {
+		return false, nil
+	}
+
+	s.removeExpiredShardView(ctx, clusterID, shardView, prevVersion)
+	return true, nil
+}
+
+// buildPutShardViewOps builds the condition that the latest version in etcd equals to the prevVersion, and the ops putting
+// the shard view, its latest version and its table versions.
+func (s *metaStorageImpl) buildPutShardViewOps(clusterID ClusterID, shardView ShardView, prevVersion uint64) (clientv3.Cmp, []clientv3.Op, error) {
+	var latestVersionEquals clientv3.Cmp
+	shardViewPB := convertShardViewToPB(shardView)
+	value, err := proto.Marshal(&shardViewPB)
+	if err != nil {
+		return latestVersionEquals, nil, ErrEncode.WithCausef("encode shard view, clusterID:%d, shardID:%d, err:%v", clusterID, shardView.ShardID, err)
+	}
+	tableVersionsValue, err := json.Marshal(shardTableVersions{TableVersions: shardView.TableVersions})
+	if err != nil {
+		return latestVersionEquals, nil, ErrEncode.WithCausef("encode shard table versions, clusterID:%d, shardID:%d, err:%v", clusterID, shardView.ShardID, err)
+	}
+
+	key := makeShardViewKey(s.rootPath, uint32(clusterID), shardViewPB.ShardId, fmtID(shardViewPB.GetVersion()))
+	latestVersionKey := makeShardViewLatestVersionKey(s.rootPath, uint32(clusterID), shardViewPB.ShardId)
+	tableVersionsKey := makeShardTableVersionsKey(s.rootPath, uint32(clusterID), shardViewPB.ShardId)
+
+	// Check whether the latest version is equal to that in etcd. If it is equal，update shard clusterView and latest version; Otherwise, return an error.
+	latestVersionEquals = clientv3.Compare(clientv3.Value(latestVersionKey), "=", fmtID(prevVersion))
+	opPutLatestVersion := clientv3.OpPut(latestVersionKey, fmtID(shardViewPB.Version))
+	opPutShardTopology := clientv3.OpPut(key, string(value))
+	opPutTableVersions := clientv3.OpPut(tableVersionsKey, string(tableVersionsValue))
+
+	return latestVersionEquals, []clientv3.Op{opPutLatestVersion, opPutShardTopology, opPutTableVersions}, nil
+}
+
+// removeExpiredShardView tries to remove the shard view of the prevVersion replaced by the shardView.
+func (s *metaStorageImpl) removeExpiredShardView(ctx context.Context, clusterID ClusterID, shardView ShardView, prevVersion uint64) {
+	if prevVersion == shardView.Version {
+		return
+	}
+
+	oldTopologyKey := makeShardViewKey(s.rootPath, uint32(clusterID), uint32(shardView.ShardID), fmtID(prevVersion))
+	opDelShardTopology := clientv3.OpDelete(oldTopologyKey)
+	if _, err := s.kv.Do(ctx, opDelShardTopology); err != nil {
+		log.Warn("remove expired shard view failed", zap.Error(err), zap.String("oldTopologyKey", oldTopologyKey))
+	}
 }
 
 func (s *metaStorageImpl) ListNodes(ctx context.Context, req ListNodesRequest) (ListNodesResult, error) {
@@ -562,7 +1017,7 @@ func (s *metaStorageImpl) ListNodes(ctx context.Context, req ListNodesRequest) (
 		return nil
 	}
 
-	err := etcdutil.Scan(ctx, s.client, startKey, endKey, rangeLimit, do)
+	err := etcdutil.Scan(ctx, s.kv, startKey, endKey, rangeLimit, do)
 	if err != nil {
 		return ListNodesResult{}, errors.WithMessagef(err, "scan nodes, clusterID:%d, start key:%s, end key:%s, range limit:%d", req.ClusterID, startKey, endKey, rangeLimit)
 	}
@@ -582,7 +1037,7 @@ func (s *metaStorageImpl) CreateOrUpdateNode(ctx context.Context, req CreateOrUp
 		return ErrEncode.WithCausef("encode node, clusterID:%d, node name:%s, err:%v", req.ClusterID, req.Node.Name, err)
 	}
 
-	_, err = s.client.Put(ctx, key, string(value))
+	_, err = s.kv.Put(ctx, key, string(value))
 	if err != nil {
 		return errors.WithMessagef(err, "create or update node, clusterID:%d, node name:%s, key:%s", req.ClusterID, req.Node.Name, key)
 	}
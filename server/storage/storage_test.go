@@ -19,9 +19,11 @@ package storage
 import (
 	"context"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/CeresDB/horaemeta/pkg/coderr"
 	"github.com/CeresDB/horaemeta/server/etcdutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -48,6 +50,10 @@ func TestStorage_CreateAndListCluster(t *testing.T) {
 	// Test to create expectClusters.
 	expectClusters := make([]Cluster, 0, defaultCount)
 	for i := 0; i < defaultCount; i++ {
+		schemaCreationPolicy := SchemaCreationPolicyAuto
+		if i%3 == 0 {
+			schemaCreationPolicy = SchemaCreationPolicyReject
+		}
 		cluster := Cluster{
 			ID:                          ClusterID(i),
 			Name:                        fmt.Sprintf(nameFormat, i),
@@ -55,6 +61,13 @@ func TestStorage_CreateAndListCluster(t *testing.T) {
 			ShardTotal:                  uint32(i),
 			TopologyType:                TopologyTypeStatic,
 			ProcedureExecutingBatchSize: 100,
+			CaseInsensitiveName:         i%2 == 0,
+			DefaultSchemaName:           fmt.Sprintf("schema_%d", i),
+			HeartbeatIntervalBounds:     HeartbeatIntervalBounds{MinMs: uint64(i) * 1000, MaxMs: uint64(i) * 2000},
+			Quota:                       ClusterQuota{MaxTables: uint32(i) * 100, MaxTablesPerSchema: uint32(i) * 10, MaxShards: uint32(i) * 8},
+			CordonedNodes:               []string{fmt.Sprintf("node_%d", i)},
+			SchemaCreationPolicy:        schemaCreationPolicy,
+			Protected:                   i%2 == 1,
 			CreatedAt:                   uint64(time.Now().UnixMilli()),
 			ModifiedAt:                  0,
 		}
@@ -78,6 +91,11 @@ func TestStorage_CreateAndListCluster(t *testing.T) {
 		re.Equal(expectClusters[i].MinNodeCount, clusters[i].MinNodeCount)
 		re.Equal(expectClusters[i].CreatedAt, clusters[i].CreatedAt)
 		re.Equal(expectClusters[i].ShardTotal, clusters[i].ShardTotal)
+		re.Equal(expectClusters[i].CaseInsensitiveName, clusters[i].CaseInsensitiveName)
+		re.Equal(expectClusters[i].DefaultSchemaName, clusters[i].DefaultSchemaName)
+		re.Equal(expectClusters[i].HeartbeatIntervalBounds, clusters[i].HeartbeatIntervalBounds)
+		re.Equal(expectClusters[i].SchemaCreationPolicy, clusters[i].SchemaCreationPolicy)
+		re.Equal(expectClusters[i].Protected, clusters[i].Protected)
 	}
 }
 
@@ -167,6 +185,40 @@ func TestStorage_CreateAndListScheme(t *testing.T) {
 	}
 }
 
+func TestStorage_PutAndListSchemaSettings(t *testing.T) {
+	re := require.New(t)
+	s := newTestStorage(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+
+	settings := SchemaSettings{
+		MaxTableCount:       10,
+		DefaultTableOptions: map[string]string{"ttl": "7d"},
+		ShardPickingPolicy:  ShardPickingPolicyRandom,
+	}
+	// The settings can't be put before the schema is created.
+	err := s.PutSchemaSettings(ctx, PutSchemaSettingsRequest{ClusterID: defaultClusterID, SchemaID: defaultSchemaID, Settings: settings})
+	re.True(coderr.Is(err, ErrPutSchemaSettings.Code()))
+
+	schema := Schema{ID: defaultSchemaID, ClusterID: defaultClusterID, Name: name0, CreatedAt: uint64(time.Now().UnixMilli())}
+	re.NoError(s.CreateSchema(ctx, CreateSchemaRequest{ClusterID: defaultClusterID, Schema: schema}))
+	re.NoError(s.PutSchemaSettings(ctx, PutSchemaSettingsRequest{ClusterID: defaultClusterID, SchemaID: defaultSchemaID, Settings: settings}))
+
+	ret, err := s.ListSchemaSettings(ctx, ListSchemaSettingsRequest{ClusterID: defaultClusterID})
+	re.NoError(err)
+	re.Equal(map[SchemaID]SchemaSettings{defaultSchemaID: settings}, ret.Settings)
+
+	// The settings are not listed as the schemas.
+	schemasResult, err := s.ListSchemas(ctx, ListSchemasRequest{ClusterID: defaultClusterID})
+	re.NoError(err)
+	re.Len(schemasResult.Schemas, 1)
+
+	re.NoError(s.DeleteSchemaSettings(ctx, DeleteSchemaSettingsRequest{ClusterID: defaultClusterID, SchemaID: defaultSchemaID}))
+	ret, err = s.ListSchemaSettings(ctx, ListSchemaSettingsRequest{ClusterID: defaultClusterID})
+	re.NoError(err)
+	re.Empty(ret.Settings)
+}
+
 func TestStorage_CreateAndGetAndListTable(t *testing.T) {
 	re := require.New(t)
 	s := newTestStorage(t)
@@ -182,11 +234,13 @@ func TestStorage_CreateAndGetAndListTable(t *testing.T) {
 			SchemaID:      defaultSchemaID,
 			CreatedAt:     0,
 			PartitionInfo: PartitionInfo{Info: nil},
+			Version:       InitialTableVersion,
 		}
 		req := CreateTableRequest{
-			ClusterID: defaultClusterID,
-			SchemaID:  defaultSchemaID,
-			Table:     table,
+			ClusterID:      defaultClusterID,
+			SchemaID:       defaultSchemaID,
+			Table:          table,
+			NormalizedName: table.Name,
 		}
 		err := s.CreateTable(ctx, req)
 		re.NoError(err)
@@ -205,6 +259,7 @@ func TestStorage_CreateAndGetAndListTable(t *testing.T) {
 	re.Equal(expectTables[0].Name, tableResult.Table.Name)
 	re.Equal(expectTables[0].SchemaID, tableResult.Table.SchemaID)
 	re.Equal(expectTables[0].CreatedAt, tableResult.Table.CreatedAt)
+	re.Equal(InitialTableVersion, tableResult.Table.Version)
 
 	// Test to list tables.
 	tablesResult, err := s.ListTables(ctx, ListTableRequest{
@@ -219,6 +274,7 @@ func TestStorage_CreateAndGetAndListTable(t *testing.T) {
 		re.Equal(expectTables[i].Name, tablesResult.Tables[i].Name)
 		re.Equal(expectTables[i].SchemaID, tablesResult.Tables[i].SchemaID)
 		re.Equal(expectTables[i].CreatedAt, tablesResult.Tables[i].CreatedAt)
+		re.Equal(InitialTableVersion, tablesResult.Tables[i].Version)
 	}
 
 	// Test to delete table.
@@ -226,6 +282,7 @@ func TestStorage_CreateAndGetAndListTable(t *testing.T) {
 		ClusterID: defaultClusterID,
 		SchemaID:  defaultSchemaID,
 		TableName: name0,
+		Version:   InitialTableVersion,
 	})
 	re.NoError(err)
 
@@ -239,6 +296,143 @@ func TestStorage_CreateAndGetAndListTable(t *testing.T) {
 	re.True(!tableResult.Exists)
 }
 
+func TestStorage_UpdateTable(t *testing.T) {
+	re := require.New(t)
+	s := newTestStorage(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+
+	name1 := fmt.Sprintf(nameFormat, 1)
+	table := Table{ID: 1, Name: name0, SchemaID: defaultSchemaID, CreatedAt: 0, PartitionInfo: PartitionInfo{Info: nil}, Version: InitialTableVersion}
+	re.NoError(s.CreateTable(ctx, CreateTableRequest{ClusterID: defaultClusterID, SchemaID: defaultSchemaID, Table: table, NormalizedName: name0}))
+	other := Table{ID: 2, Name: name1, SchemaID: defaultSchemaID, CreatedAt: 0, PartitionInfo: PartitionInfo{Info: nil}, Version: InitialTableVersion}
+	re.NoError(s.CreateTable(ctx, CreateTableRequest{ClusterID: defaultClusterID, SchemaID: defaultSchemaID, Table: other, NormalizedName: name1}))
+
+	// The table can't be renamed to the name taken by others.
+	renamed := table
+	renamed.Name = name1
+	err := s.UpdateTable(ctx, UpdateTableRequest{ClusterID: defaultClusterID, SchemaID: defaultSchemaID, Table: renamed, PrevNormalizedName: name0, NormalizedName: name1})
+	re.True(coderr.Is(err, ErrUpdateTableAgain.Code()))
+
+	// The table is renamed, and its version is increased.
+	renamed.Name = "renamed"
+	re.NoError(s.UpdateTable(ctx, UpdateTableRequest{ClusterID: defaultClusterID, SchemaID: defaultSchemaID, Table: renamed, PrevNormalizedName: name0, NormalizedName: renamed.Name}))
+	result, err := s.GetTable(ctx, GetTableRequest{ClusterID: defaultClusterID, SchemaID: defaultSchemaID, TableName: renamed.Name})
+	re.NoError(err)
+	re.True(result.Exists)
+	re.Equal(renamed.Name, result.Table.Name)
+	re.Equal(InitialTableVersion+1, result.Table.Version)
+	result, err = s.GetTable(ctx, GetTableRequest{ClusterID: defaultClusterID, SchemaID: defaultSchemaID, TableName: name0})
+	re.NoError(err)
+	re.False(result.Exists)
+
+	// The update and the deletion racing with the rename are detected by the version.
+	err = s.UpdateTable(ctx, UpdateTableRequest{ClusterID: defaultClusterID, SchemaID: defaultSchemaID, Table: renamed, PrevNormalizedName: renamed.Name, NormalizedName: renamed.Name})
+	re.True(coderr.Is(err, ErrTableVersionConflict.Code()))
+	err = s.DeleteTable(ctx, DeleteTableRequest{ClusterID: defaultClusterID, SchemaID: defaultSchemaID, TableName: renamed.Name, Version: InitialTableVersion})
+	re.True(coderr.Is(err, ErrTableVersionConflict.Code()))
+	re.NoError(s.DeleteTable(ctx, DeleteTableRequest{ClusterID: defaultClusterID, SchemaID: defaultSchemaID, TableName: renamed.Name, Version: InitialTableVersion + 1}))
+
+	// The table deleted can't be updated any more.
+	renamed.Version = InitialTableVersion + 1
+	err = s.UpdateTable(ctx, UpdateTableRequest{ClusterID: defaultClusterID, SchemaID: defaultSchemaID, Table: renamed, PrevNormalizedName: renamed.Name, NormalizedName: renamed.Name})
+	re.True(coderr.Is(err, ErrUpdateTableAgain.Code()))
+}
+
+func TestStorage_DeleteTables(t *testing.T) {
+	re := require.New(t)
+	s := newTestStorage(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+
+	// Create more tables than the ops allowed in a single txn.
+	tableCount := defaultCount * 2
+	tablesToDelete := make(map[string]TableID, tableCount)
+	for i := 0; i < tableCount; i++ {
+		table := Table{
+			ID:            TableID(i),
+			Name:          fmt.Sprintf(nameFormat, i),
+			SchemaID:      defaultSchemaID,
+			CreatedAt:     0,
+			PartitionInfo: PartitionInfo{Info: nil},
+			Version:       InitialTableVersion,
+		}
+		err := s.CreateTable(ctx, CreateTableRequest{
+			ClusterID:      defaultClusterID,
+			SchemaID:       defaultSchemaID,
+			Table:          table,
+			NormalizedName: table.Name,
+		})
+		re.NoError(err)
+		tablesToDelete[table.Name] = table.ID
+	}
+
+	err := s.DeleteTables(ctx, DeleteTablesRequest{
+		ClusterID: defaultClusterID,
+		SchemaID:  defaultSchemaID,
+		Tables:    tablesToDelete,
+	})
+	re.NoError(err)
+
+	tablesResult, err := s.ListTables(ctx, ListTableRequest{
+		ClusterID: defaultClusterID,
+		SchemaID:  defaultSchemaID,
+	})
+	re.NoError(err)
+	re.Empty(tablesResult.Tables)
+
+	tableResult, err := s.GetTable(ctx, GetTableRequest{
+		ClusterID: defaultClusterID,
+		SchemaID:  defaultSchemaID,
+		TableName: name0,
+	})
+	re.NoError(err)
+	re.False(tableResult.Exists)
+}
+
+func TestStorage_CreateTables(t *testing.T) {
+	re := require.New(t)
+	s := newTestStorage(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+
+	// Create more tables than the ops allowed in a single txn.
+	tableCount := defaultCount * 2
+	tablesToCreate := make(map[string]Table, tableCount)
+	for i := 0; i < tableCount; i++ {
+		table := Table{
+			ID:            TableID(i),
+			Name:          fmt.Sprintf(nameFormat, i),
+			SchemaID:      defaultSchemaID,
+			CreatedAt:     0,
+			PartitionInfo: PartitionInfo{Info: nil},
+			Version:       InitialTableVersion,
+		}
+		tablesToCreate[table.Name] = table
+	}
+	err := s.CreateTables(ctx, CreateTablesRequest{
+		ClusterID: defaultClusterID,
+		SchemaID:  defaultSchemaID,
+		Tables:    tablesToCreate,
+	})
+	re.NoError(err)
+
+	tablesResult, err := s.ListTables(ctx, ListTableRequest{
+		ClusterID: defaultClusterID,
+		SchemaID:  defaultSchemaID,
+	})
+	re.NoError(err)
+	re.Equal(tableCount, len(tablesResult.Tables))
+
+	// The tables existing already can't be created again.
+	err = s.CreateTables(ctx, CreateTablesRequest{
+		ClusterID: defaultClusterID,
+		SchemaID:  defaultSchemaID,
+		Tables:    map[string]Table{name0: tablesToCreate[name0]},
+	})
+	re.Error(err)
+}
+
 func TestStorage_CreateAndListShardView(t *testing.T) {
 	re := require.New(t)
 	s := newTestStorage(t)
@@ -250,10 +444,11 @@ func TestStorage_CreateAndListShardView(t *testing.T) {
 	var shardIDs []ShardID
 	for i := 0; i < defaultCount; i++ {
 		shardView := ShardView{
-			ShardID:   ShardID(i),
-			Version:   defaultVersion,
-			TableIDs:  nil,
-			CreatedAt: uint64(time.Now().UnixMilli()),
+			ShardID:       ShardID(i),
+			Version:       defaultVersion,
+			TableIDs:      nil,
+			TableVersions: nil,
+			CreatedAt:     uint64(time.Now().UnixMilli()),
 		}
 		expectShardViews = append(expectShardViews, shardView)
 		shardIDs = append(shardIDs, ShardID(i))
@@ -280,10 +475,11 @@ func TestStorage_CreateAndListShardView(t *testing.T) {
 	// Test to put shard topologies.
 	for i := 0; i < defaultCount; i++ {
 		expectShardViews[i].Version = newVersion
-		err = s.UpdateShardView(ctx, UpdateShardViewRequest{
-			ClusterID:   defaultClusterID,
-			ShardView:   expectShardViews[i],
-			PrevVersion: defaultVersion,
+		_, err = s.UpdateShardView(ctx, UpdateShardViewRequest{
+			ClusterID:     defaultClusterID,
+			ShardView:     expectShardViews[i],
+			PrevVersion:   defaultVersion,
+			AddedTableIDs: nil,
 		})
 		re.NoError(err)
 	}
@@ -300,6 +496,247 @@ func TestStorage_CreateAndListShardView(t *testing.T) {
 	}
 }
 
+func TestStorage_MergeShardViewUpdate(t *testing.T) {
+	re := require.New(t)
+	s := newTestStorage(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+
+	shardID := ShardID(0)
+	createdAt := uint64(time.Now().UnixMilli())
+	err := s.CreateShardViews(ctx, CreateShardViewsRequest{
+		ClusterID:  defaultClusterID,
+		ShardViews: []ShardView{NewShardView(shardID, 0, []TableID{}, createdAt)},
+	})
+	re.NoError(err)
+
+	// Add table 1 to the shard at version 1.
+	_, err = s.UpdateShardView(ctx, UpdateShardViewRequest{
+		ClusterID:     defaultClusterID,
+		ShardView:     NewShardView(shardID, 1, []TableID{1}, createdAt),
+		PrevVersion:   0,
+		AddedTableIDs: []TableID{1},
+	})
+	re.NoError(err)
+
+	// Add table 2 based on the stale version 0, and it should be merged with table 1.
+	ret, err := s.UpdateShardView(ctx, UpdateShardViewRequest{
+		ClusterID:     defaultClusterID,
+		ShardView:     NewShardView(shardID, 2, []TableID{2}, createdAt),
+		PrevVersion:   0,
+		AddedTableIDs: []TableID{2},
+	})
+	re.NoError(err)
+	re.Equal(uint64(2), ret.ShardView.Version)
+	re.ElementsMatch([]TableID{1, 2}, ret.ShardView.TableIDs)
+	re.Equal(map[TableID]uint64{1: 1, 2: 2}, ret.ShardView.TableVersions)
+
+	listRet, err := s.ListShardViews(ctx, ListShardViewsRequest{
+		ClusterID: defaultClusterID,
+		ShardIDs:  []ShardID{shardID},
+	})
+	re.NoError(err)
+	re.Equal(1, len(listRet.ShardViews))
+	re.Equal(ret.ShardView.Version, listRet.ShardViews[0].Version)
+	re.ElementsMatch(ret.ShardView.TableIDs, listRet.ShardViews[0].TableIDs)
+	re.Equal(ret.ShardView.TableVersions, listRet.ShardViews[0].TableVersions)
+
+	// Add table 3 and table 4 both based on the version 2. The merged one keeps the version returned by the node, and the
+	// one not newer than the latest version can't be merged.
+	prevShardView := ret.ShardView
+	addTable3 := NewShardView(shardID, 4, []TableID{1, 2, 3}, createdAt)
+	addTable3.InheritTableVersions(prevShardView)
+	ret, err = s.UpdateShardView(ctx, UpdateShardViewRequest{
+		ClusterID:     defaultClusterID,
+		ShardView:     addTable3,
+		PrevVersion:   2,
+		AddedTableIDs: []TableID{3},
+	})
+	re.NoError(err)
+	re.Equal(uint64(4), ret.ShardView.Version)
+	addTable4 := NewShardView(shardID, 3, []TableID{1, 2, 4}, createdAt)
+	addTable4.InheritTableVersions(prevShardView)
+	_, err = s.UpdateShardView(ctx, UpdateShardViewRequest{
+		ClusterID:     defaultClusterID,
+		ShardView:     addTable4,
+		PrevVersion:   2,
+		AddedTableIDs: []TableID{4},
+	})
+	re.True(coderr.Is(err, ErrUpdateShardViewConflict.Code()))
+	addTable4 = NewShardView(shardID, 5, []TableID{1, 2, 4}, createdAt)
+	addTable4.InheritTableVersions(prevShardView)
+	ret, err = s.UpdateShardView(ctx, UpdateShardViewRequest{
+		ClusterID:     defaultClusterID,
+		ShardView:     addTable4,
+		PrevVersion:   2,
+		AddedTableIDs: []TableID{4},
+	})
+	re.NoError(err)
+	re.Equal(uint64(5), ret.ShardView.Version)
+	re.ElementsMatch([]TableID{1, 2, 3, 4}, ret.ShardView.TableIDs)
+	re.Equal(map[TableID]uint64{1: 1, 2: 2, 3: 4, 4: 5}, ret.ShardView.TableVersions)
+
+	// Adding the table already in the shard can't be merged.
+	_, err = s.UpdateShardView(ctx, UpdateShardViewRequest{
+		ClusterID:     defaultClusterID,
+		ShardView:     NewShardView(shardID, 3, []TableID{1}, createdAt),
+		PrevVersion:   0,
+		AddedTableIDs: []TableID{1},
+	})
+	re.Error(err)
+
+	// The update without added tables is never merged.
+	_, err = s.UpdateShardView(ctx, UpdateShardViewRequest{
+		ClusterID:     defaultClusterID,
+		ShardView:     NewShardView(shardID, 3, []TableID{}, createdAt),
+		PrevVersion:   1,
+		AddedTableIDs: nil,
+	})
+	re.Error(err)
+}
+
+func TestStorage_OpStats(t *testing.T) {
+	re := require.New(t)
+	s := newTestStorage(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+
+	createdAt := uint64(time.Now().UnixMilli())
+	re.NoError(s.CreateShardViews(ctx, CreateShardViewsRequest{
+		ClusterID:  defaultClusterID,
+		ShardViews: []ShardView{NewShardView(0, 0, []TableID{}, createdAt)},
+	}))
+	_, err := s.UpdateShardView(ctx, UpdateShardViewRequest{
+		ClusterID:     defaultClusterID,
+		ShardView:     NewShardView(0, 1, []TableID{1}, createdAt),
+		PrevVersion:   0,
+		AddedTableIDs: []TableID{1},
+	})
+	re.NoError(err)
+
+	// The update based on the stale version is retried, and the keys of the shard are accessed.
+	stats := &opStats{}
+	_, err = s.UpdateShardView(withOpStats(ctx, stats), UpdateShardViewRequest{
+		ClusterID:     defaultClusterID,
+		ShardView:     NewShardView(0, 2, []TableID{2}, createdAt),
+		PrevVersion:   0,
+		AddedTableIDs: []TableID{2},
+	})
+	re.NoError(err)
+	re.Equal(1, stats.txnRetries)
+	re.Greater(stats.payloadBytes, 0)
+	re.True(strings.HasPrefix(stats.getKeyPrefix(), makeShardViewVersionKey(defaultRootPath, defaultClusterID)))
+
+	// The prefix is the whole key if only one key is accessed.
+	stats = &opStats{}
+	re.NoError(s.CreateOrUpdateNode(withOpStats(ctx, stats), CreateOrUpdateNodeRequest{
+		ClusterID: defaultClusterID,
+		Node:      Node{Name: name0, NodeStats: NewEmptyNodeStats(), LastTouchTime: createdAt, State: NodeStateOnline},
+	}))
+	re.Equal(makeNodeKey(defaultRootPath, defaultClusterID, name0), stats.getKeyPrefix())
+
+	re.Equal("/a/b", commonPrefix("/a/bc", "/a/bd"))
+	re.Equal("/a", commonPrefix("/a", "/a/b"))
+}
+
+func TestStorage_UpdateShardViews(t *testing.T) {
+	re := require.New(t)
+	s := newTestStorage(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+
+	createdAt := uint64(time.Now().UnixMilli())
+	err := s.CreateShardViews(ctx, CreateShardViewsRequest{
+		ClusterID: defaultClusterID,
+		ShardViews: []ShardView{
+			NewShardView(0, 0, []TableID{1, 2}, createdAt),
+			NewShardView(1, 0, []TableID{}, createdAt),
+		},
+	})
+	re.NoError(err)
+
+	// Move table 2 from shard 0 to shard 1.
+	err = s.UpdateShardViews(ctx, UpdateShardViewsRequest{
+		ClusterID: defaultClusterID,
+		Updates: []ShardViewUpdate{
+			{ShardView: NewShardView(0, 1, []TableID{1}, createdAt), PrevVersion: 0},
+			{ShardView: NewShardView(1, 1, []TableID{2}, createdAt), PrevVersion: 0},
+		},
+	})
+	re.NoError(err)
+
+	// The stale version of shard 1 fails the whole update, and shard 0 is left unchanged.
+	err = s.UpdateShardViews(ctx, UpdateShardViewsRequest{
+		ClusterID: defaultClusterID,
+		Updates: []ShardViewUpdate{
+			{ShardView: NewShardView(0, 2, []TableID{}, createdAt), PrevVersion: 1},
+			{ShardView: NewShardView(1, 2, []TableID{1, 2}, createdAt), PrevVersion: 0},
+		},
+	})
+	re.True(coderr.Is(err, ErrUpdateShardViewConflict.Code()))
+
+	ret, err := s.ListShardViews(ctx, ListShardViewsRequest{
+		ClusterID: defaultClusterID,
+		ShardIDs:  []ShardID{0, 1},
+	})
+	re.NoError(err)
+	re.Equal(2, len(ret.ShardViews))
+	for _, shardView := range ret.ShardViews {
+		re.Equal(uint64(1), shardView.Version)
+		switch shardView.ShardID {
+		case 0:
+			re.Equal([]TableID{1}, shardView.TableIDs)
+		case 1:
+			re.Equal([]TableID{2}, shardView.TableIDs)
+		}
+	}
+}
+
+func TestStorage_DeleteShardViews(t *testing.T) {
+	re := require.New(t)
+	s := newTestStorage(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+
+	createdAt := uint64(time.Now().UnixMilli())
+	err := s.CreateShardViews(ctx, CreateShardViewsRequest{
+		ClusterID: defaultClusterID,
+		ShardViews: []ShardView{
+			NewShardView(1, 0, []TableID{}, createdAt),
+			NewShardView(10, 0, []TableID{}, createdAt),
+		},
+	})
+	re.NoError(err)
+	_, err = s.UpdateShardView(ctx, UpdateShardViewRequest{
+		ClusterID:     defaultClusterID,
+		ShardView:     NewShardView(1, 1, []TableID{}, createdAt),
+		PrevVersion:   0,
+		AddedTableIDs: nil,
+	})
+	re.NoError(err)
+
+	err = s.DeleteShardViews(ctx, DeleteShardViewsRequest{
+		ClusterID: defaultClusterID,
+		ShardIDs:  []ShardID{1},
+	})
+	re.NoError(err)
+
+	// Only the shard view of shard 10 is left, and shard 1 can be created again.
+	ret, err := s.ListShardViews(ctx, ListShardViewsRequest{
+		ClusterID: defaultClusterID,
+		ShardIDs:  []ShardID{},
+	})
+	re.NoError(err)
+	re.Equal(1, len(ret.ShardViews))
+	re.Equal(ShardID(10), ret.ShardViews[0].ShardID)
+
+	err = s.CreateShardViews(ctx, CreateShardViewsRequest{
+		ClusterID:  defaultClusterID,
+		ShardViews: []ShardView{NewShardView(1, 0, []TableID{}, createdAt)},
+	})
+	re.NoError(err)
+}
+
 func TestStorage_CreateOrUpdateNode(t *testing.T) {
 	re := require.New(t)
 	s := newTestStorage(t)
@@ -337,6 +774,62 @@ func TestStorage_CreateOrUpdateNode(t *testing.T) {
 	}
 }
 
+func TestStorage_WatchCluster(t *testing.T) {
+	re := require.New(t)
+	s := newTestStorage(t)
+	ctx, cancel := context.WithTimeout(context.Background(), defaultRequestTimeout)
+	defer cancel()
+
+	revision, err := s.GetRevision(ctx)
+	re.NoError(err)
+	results := s.WatchCluster(ctx, WatchClusterRequest{
+		ClusterID: defaultClusterID,
+		Revision:  revision + 1,
+	})
+
+	createdAt := uint64(time.Now().UnixMilli())
+	schema := Schema{ID: defaultSchemaID, ClusterID: defaultClusterID, Name: name0, CreatedAt: createdAt}
+	re.NoError(s.CreateSchema(ctx, CreateSchemaRequest{ClusterID: defaultClusterID, Schema: schema}))
+	table := Table{ID: 1, Name: name0, SchemaID: defaultSchemaID, CreatedAt: createdAt, PartitionInfo: PartitionInfo{Info: nil}, Version: InitialTableVersion}
+	re.NoError(s.CreateTable(ctx, CreateTableRequest{ClusterID: defaultClusterID, SchemaID: defaultSchemaID, Table: table, NormalizedName: name0}))
+	re.NoError(s.CreateShardViews(ctx, CreateShardViewsRequest{
+		ClusterID:  defaultClusterID,
+		ShardViews: []ShardView{NewShardView(0, defaultVersion, []TableID{table.ID}, createdAt)},
+	}))
+	node := Node{Name: "127.0.0.1:8831", NodeStats: NewEmptyNodeStats(), LastTouchTime: createdAt, State: NodeStateOnline}
+	re.NoError(s.CreateOrUpdateNode(ctx, CreateOrUpdateNodeRequest{ClusterID: defaultClusterID, Node: node}))
+	re.NoError(s.DeleteTable(ctx, DeleteTableRequest{ClusterID: defaultClusterID, SchemaID: defaultSchemaID, TableName: name0, Version: 0}))
+
+	// The changes may be sent in several results, so they are merged until the last one is received.
+	latestRevision, err := s.GetRevision(ctx)
+	re.NoError(err)
+	var changes ClusterChanges
+	for changes.Revision < latestRevision {
+		result, ok := <-results
+		re.True(ok)
+		re.NoError(result.Err)
+		changes.Revision = result.Changes.Revision
+		changes.Schemas = append(changes.Schemas, result.Changes.Schemas...)
+		changes.Tables = append(changes.Tables, result.Changes.Tables...)
+		changes.ShardIDs = append(changes.ShardIDs, result.Changes.ShardIDs...)
+		changes.Nodes = append(changes.Nodes, result.Changes.Nodes...)
+		changes.ClusterViewChanged = changes.ClusterViewChanged || result.Changes.ClusterViewChanged
+	}
+
+	re.Equal([]Schema{schema}, changes.Schemas)
+	var deletedTable Table
+	re.Equal([]TableChange{
+		{Type: ChangeTypePut, SchemaID: defaultSchemaID, TableID: table.ID, Table: table},
+		{Type: ChangeTypeDelete, SchemaID: defaultSchemaID, TableID: table.ID, Table: deletedTable},
+	}, changes.Tables)
+	re.Equal([]ShardID{0}, changes.ShardIDs)
+	re.Len(changes.Nodes, 1)
+	re.Equal(ChangeTypePut, changes.Nodes[0].Type)
+	re.Equal(node.Name, changes.Nodes[0].NodeName)
+	re.Equal(node.State, changes.Nodes[0].Node.State)
+	re.False(changes.ClusterViewChanged)
+}
+
 func newTestStorage(t *testing.T) Storage {
 	cfg := etcdutil.NewTestSingleConfig()
 	etcd, err := embed.StartEtcd(cfg)
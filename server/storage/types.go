@@ -18,7 +18,6 @@ package storage
 
 import (
 	"fmt"
-	"time"
 
 	"github.com/CeresDB/horaedbproto/golang/pkg/clusterpb"
 	"github.com/CeresDB/horaedbproto/golang/pkg/metaservicepb"
@@ -110,6 +109,15 @@ type CreateTableRequest struct {
 	ClusterID ClusterID
 	SchemaID  SchemaID
 	Table     Table
+	// NormalizedName is used to index the table by name, and Table.Name is kept as the display name.
+	NormalizedName string
+}
+
+type CreateTablesRequest struct {
+	ClusterID ClusterID
+	SchemaID  SchemaID
+	// Tables maps the normalized table names to the tables.
+	Tables map[string]Table
 }
 
 type GetTableRequest struct {
@@ -123,6 +131,25 @@ type GetTableResult struct {
 	Exists bool
 }
 
+type ListSchemaSettingsRequest struct {
+	ClusterID ClusterID
+}
+
+type ListSchemaSettingsResult struct {
+	Settings map[SchemaID]SchemaSettings
+}
+
+type PutSchemaSettingsRequest struct {
+	ClusterID ClusterID
+	SchemaID  SchemaID
+	Settings  SchemaSettings
+}
+
+type DeleteSchemaSettingsRequest struct {
+	ClusterID ClusterID
+	SchemaID  SchemaID
+}
+
 type ListTableRequest struct {
 	ClusterID ClusterID
 	SchemaID  SchemaID
@@ -136,6 +163,27 @@ type DeleteTableRequest struct {
 	ClusterID ClusterID
 	SchemaID  SchemaID
 	TableName string
+	// Version is the version of the table to be deleted, and the deletion fails if the table is updated since then. 0
+	// skips the check.
+	Version uint64
+}
+
+type UpdateTableRequest struct {
+	ClusterID ClusterID
+	SchemaID  SchemaID
+	// Table is the updated table, and its Version is the version of the table to be updated.
+	Table Table
+	// PrevNormalizedName is the name the table is indexed by before the update, which differs from NormalizedName if the
+	// table is renamed.
+	PrevNormalizedName string
+	NormalizedName     string
+}
+
+type DeleteTablesRequest struct {
+	ClusterID ClusterID
+	SchemaID  SchemaID
+	// Tables maps the normalized table names to the table ids.
+	Tables map[string]TableID
 }
 
 type CreateShardViewsRequest struct {
@@ -156,6 +204,31 @@ type UpdateShardViewRequest struct {
 	ClusterID   ClusterID
 	ShardView   ShardView
 	PrevVersion uint64
+	// AddedTableIDs are the tables added to the shard by this update. If it is not empty, the update can be merged into the
+	// latest shard view modified concurrently, as long as the added tables are disjoint from the tables in it.
+	AddedTableIDs []TableID
+}
+
+type UpdateShardViewResult struct {
+	// ShardView is the shard view persisted, which may be merged with the concurrent updates, and its version is always
+	// the one of the update.
+	ShardView ShardView
+}
+
+type ShardViewUpdate struct {
+	ShardView   ShardView
+	PrevVersion uint64
+}
+
+type UpdateShardViewsRequest struct {
+	ClusterID ClusterID
+	// Updates are applied all or nothing, and the update of every shard view requires its latest version to be PrevVersion.
+	Updates []ShardViewUpdate
+}
+
+type DeleteShardViewsRequest struct {
+	ClusterID ClusterID
+	ShardIDs  []ShardID
 }
 
 type ListNodesRequest struct {
@@ -171,6 +244,60 @@ type CreateOrUpdateNodeRequest struct {
 	Node      Node
 }
 
+type WatchClusterRequest struct {
+	ClusterID ClusterID
+	// Revision is the revision to watch from, and the changes at the revision are included.
+	Revision int64
+}
+
+type ChangeType int
+
+const (
+	ChangeTypePut ChangeType = iota
+	ChangeTypeDelete
+)
+
+type TableChange struct {
+	Type     ChangeType
+	SchemaID SchemaID
+	TableID  TableID
+	// Table is only set for ChangeTypePut.
+	Table Table
+}
+
+type NodeChange struct {
+	Type     ChangeType
+	NodeName string
+	// Node is only set for ChangeTypePut.
+	Node Node
+}
+
+// ClusterChanges are the changes of the cluster metadata watched from the storage.
+type ClusterChanges struct {
+	// Revision is the revision of the storage the changes are up to.
+	Revision int64
+	Schemas  []Schema
+	Tables   []TableChange
+	// ShardIDs are the shards whose latest shard views are changed.
+	ShardIDs []ShardID
+	// DeletedShardIDs are the shards whose shard views are deleted.
+	DeletedShardIDs []ShardID
+	Nodes           []NodeChange
+	// ClusterViewChanged is true if the latest cluster view is changed.
+	ClusterViewChanged bool
+}
+
+// IsEmpty returns true if there is no change, e.g. the changes only report the progress of the watch.
+func (c ClusterChanges) IsEmpty() bool {
+	return len(c.Schemas) == 0 && len(c.Tables) == 0 && len(c.ShardIDs) == 0 && len(c.DeletedShardIDs) == 0 && len(c.Nodes) == 0 && !c.ClusterViewChanged
+}
+
+type WatchClusterResult struct {
+	Changes ClusterChanges
+	// Err is set if the watch is broken, e.g. the revision to watch from has been compacted, and no more result follows.
+	Err error
+}
+
 type Cluster struct {
 	ID                          ClusterID
 	Name                        string
@@ -178,8 +305,61 @@ type Cluster struct {
 	ShardTotal                  uint32
 	TopologyType                TopologyType
 	ProcedureExecutingBatchSize uint32
-	CreatedAt                   uint64
-	ModifiedAt                  uint64
+	// CaseInsensitiveName makes the schema and table names resolved case-insensitively.
+	// It is persisted in the cluster options because pb.Cluster has no such field.
+	CaseInsensitiveName bool
+	// DefaultSchemaName is the schema created along with the cluster and protected from deletion, empty if there is none.
+	// It is persisted in the cluster options too.
+	DefaultSchemaName string
+	// HeartbeatIntervalBounds bounds the heartbeat interval advised to the nodes, and it is persisted in the cluster options too.
+	HeartbeatIntervalBounds HeartbeatIntervalBounds
+	// Quota limits the tables and shards of the cluster, and it is persisted in the cluster options too.
+	Quota ClusterQuota
+	// CordonedNodes are the names of the nodes no new shards are assigned to, and it is persisted in the cluster options
+	// too, so the nodes stay cordoned after the leader changes.
+	CordonedNodes []string
+	// SchemaCreationPolicy decides whether the unknown schemas are created on demand, and it is persisted in the cluster
+	// options too.
+	SchemaCreationPolicy SchemaCreationPolicy
+	// Protected blocks the destructive operations on the cluster unless they are forced with the confirmation, and it is
+	// persisted in the cluster options too.
+	Protected  bool
+	CreatedAt  uint64
+	ModifiedAt uint64
+}
+
+// HeartbeatIntervalBounds are the min and max heartbeat intervals advised to the nodes of a cluster, and no interval is
+// advised if MaxMs is zero, i.e. the nodes keep their own intervals.
+type HeartbeatIntervalBounds struct {
+	MinMs uint64 `json:"minMs"`
+	MaxMs uint64 `json:"maxMs"`
+}
+
+func (b HeartbeatIntervalBounds) Enabled() bool {
+	return b.MaxMs > 0
+}
+
+// ClusterQuota limits the tables and shards of a cluster, and 0 means unlimited. The quotas are only enforced when the
+// tables or shards are created, so lowering a quota below the current usage keeps the existing ones.
+type ClusterQuota struct {
+	// MaxTables is the max number of the tables in the cluster including the sub tables.
+	MaxTables uint32 `json:"maxTables"`
+	// MaxTablesPerSchema is the max number of the tables in every schema, which is overridden by the max table count in
+	// the schema settings.
+	MaxTablesPerSchema uint32 `json:"maxTablesPerSchema"`
+	// MaxShards is the max number of the shards in the cluster.
+	MaxShards uint32 `json:"maxShards"`
+}
+
+// clusterOptions contains the cluster settings which can't be carried by pb.Cluster, and it is encoded in json.
+type clusterOptions struct {
+	CaseInsensitiveName     bool                    `json:"caseInsensitiveName"`
+	DefaultSchemaName       string                  `json:"defaultSchemaName,omitempty"`
+	HeartbeatIntervalBounds HeartbeatIntervalBounds `json:"heartbeatIntervalBounds"`
+	Quota                   ClusterQuota            `json:"quota"`
+	CordonedNodes           []string                `json:"cordonedNodes,omitempty"`
+	SchemaCreationPolicy    SchemaCreationPolicy    `json:"schemaCreationPolicy,omitempty"`
+	Protected               bool                    `json:"protected,omitempty"`
 }
 
 type ShardNode struct {
@@ -196,13 +376,13 @@ type ClusterView struct {
 	CreatedAt  uint64
 }
 
-func NewClusterView(clusterID ClusterID, version uint64, state ClusterState, shardNodes []ShardNode) ClusterView {
+func NewClusterView(clusterID ClusterID, version uint64, state ClusterState, shardNodes []ShardNode, createdAt uint64) ClusterView {
 	return ClusterView{
 		ClusterID:  clusterID,
 		Version:    version,
 		State:      state,
 		ShardNodes: shardNodes,
-		CreatedAt:  uint64(time.Now().UnixMilli()),
+		CreatedAt:  createdAt,
 	}
 }
 
@@ -213,6 +393,55 @@ type Schema struct {
 	CreatedAt uint64
 }
 
+type SchemaCreationPolicy string
+
+const (
+	// SchemaCreationPolicyAuto creates the unknown schemas on AllocSchemaID and CreateTable, which is the policy of the
+	// clusters created before the policy is introduced.
+	SchemaCreationPolicyAuto SchemaCreationPolicy = "auto"
+	// SchemaCreationPolicyReject rejects AllocSchemaID and CreateTable on the unknown schemas, so the schemas must be
+	// provisioned in advance, e.g. by the schema settings.
+	SchemaCreationPolicyReject SchemaCreationPolicy = "reject"
+)
+
+func (p SchemaCreationPolicy) IsValid() bool {
+	switch p {
+	case SchemaCreationPolicyAuto, SchemaCreationPolicyReject:
+		return true
+	}
+	return false
+}
+
+type ShardPickingPolicy string
+
+const (
+	// ShardPickingPolicyDefault picks the shards with the shard picker of the cluster.
+	ShardPickingPolicyDefault ShardPickingPolicy = ""
+	// ShardPickingPolicyLeastTable picks the shards with the least tables.
+	ShardPickingPolicyLeastTable ShardPickingPolicy = "leastTable"
+	// ShardPickingPolicyRandom picks the shards randomly, which spreads the tables created in bursts.
+	ShardPickingPolicyRandom ShardPickingPolicy = "random"
+)
+
+func (p ShardPickingPolicy) IsValid() bool {
+	switch p {
+	case ShardPickingPolicyDefault, ShardPickingPolicyLeastTable, ShardPickingPolicyRandom:
+		return true
+	}
+	return false
+}
+
+// SchemaSettings are stored beside the schema in json, and the zero value means no quota and no defaults.
+type SchemaSettings struct {
+	// MaxTableCount is the max number of the tables in the schema including the sub tables, and 0 means unlimited.
+	MaxTableCount uint32 `json:"maxTableCount"`
+	// DefaultTableOptions are the options of the tables created in the schema, which are overridden by the options given
+	// in the request.
+	DefaultTableOptions map[string]string `json:"defaultTableOptions"`
+	// ShardPickingPolicy decides the shards of the tables created in the schema.
+	ShardPickingPolicy ShardPickingPolicy `json:"shardPickingPolicy"`
+}
+
 type PartitionInfo struct {
 	Info *clusterpb.PartitionInfo `json:"info,omitempty"`
 }
@@ -223,32 +452,107 @@ type Table struct {
 	SchemaID      SchemaID
 	CreatedAt     uint64
 	PartitionInfo PartitionInfo
+	// Version is the version of the table in the storage, which is InitialTableVersion once the table is created and
+	// increased by every update, so that the concurrent modifications are detected instead of overwritten.
+	Version uint64
 }
 
+// InitialTableVersion is the version of the table just created.
+const InitialTableVersion uint64 = 1
+
 func (t Table) IsPartitioned() bool {
 	return t.PartitionInfo.Info != nil
 }
 
 type ShardView struct {
-	ShardID   ShardID
-	Version   uint64
-	TableIDs  []TableID
-	CreatedAt uint64
+	ShardID  ShardID
+	Version  uint64
+	TableIDs []TableID
+	// TableVersions records the shard version at which each table was added to the shard.
+	TableVersions map[TableID]uint64
+	CreatedAt     uint64
 }
 
-func NewShardView(shardID ShardID, version uint64, tableIDs []TableID) ShardView {
+// NewShardView creates a shard view whose tables are all at the given version.
+func NewShardView(shardID ShardID, version uint64, tableIDs []TableID, createdAt uint64) ShardView {
 	return ShardView{
-		ShardID:   shardID,
-		Version:   version,
-		TableIDs:  tableIDs,
-		CreatedAt: uint64(time.Now().UnixMilli()),
+		ShardID:       shardID,
+		Version:       version,
+		TableIDs:      tableIDs,
+		TableVersions: makeTableVersions(version, tableIDs),
+		CreatedAt:     createdAt,
+	}
+}
+
+// InheritTableVersions keeps the table versions recorded in the previous view for the tables still in the shard.
+func (v *ShardView) InheritTableVersions(prev ShardView) {
+	for _, tableID := range v.TableIDs {
+		if version, ok := prev.TableVersions[tableID]; ok {
+			v.TableVersions[tableID] = version
+		}
+	}
+}
+
+// shardTableVersions is persisted along with the shard view, because the proto of shard view has no place for it.
+type shardTableVersions struct {
+	TableVersions map[TableID]uint64 `json:"tableVersions"`
+}
+
+func makeTableVersions(version uint64, tableIDs []TableID) map[TableID]uint64 {
+	tableVersions := make(map[TableID]uint64, len(tableIDs))
+	for _, tableID := range tableIDs {
+		tableVersions[tableID] = version
 	}
+	return tableVersions
+}
+
+// mergeShardViewAdditions merges the tables added by the update into the latest shard view, and false is returned if any
+// added table already exists in the latest shard view. The version of the update is the one returned by the node, which
+// has applied the additions of the latest shard view if it is newer, so the merged shard view keeps the version of the
+// update, and false is returned if it is not newer than the latest one.
+func mergeShardViewAdditions(latest ShardView, update ShardView, addedTableIDs []TableID) (ShardView, bool) {
+	if update.Version <= latest.Version {
+		return ShardView{}, false
+	}
+
+	tableIDs := make([]TableID, 0, len(latest.TableIDs)+len(addedTableIDs))
+	tableIDs = append(tableIDs, latest.TableIDs...)
+	tableVersions := make(map[TableID]uint64, len(latest.TableVersions)+len(addedTableIDs))
+	for tableID, version := range latest.TableVersions {
+		tableVersions[tableID] = version
+	}
+	for _, tableID := range addedTableIDs {
+		if _, exists := tableVersions[tableID]; exists {
+			return ShardView{}, false
+		}
+		tableIDs = append(tableIDs, tableID)
+		tableVersions[tableID] = update.Version
+	}
+
+	return ShardView{
+		ShardID:       latest.ShardID,
+		Version:       update.Version,
+		TableIDs:      tableIDs,
+		TableVersions: tableVersions,
+		CreatedAt:     update.CreatedAt,
+	}, true
 }
 
 type NodeStats struct {
 	Lease       uint32
 	Zone        string
 	NodeVersion string
+	// Capacity is reported by the heartbeats and it is not persisted.
+	Capacity NodeCapacity
+	// ProtocolVersion is reported by the heartbeats and it is not persisted, and zero means it is not reported.
+	ProtocolVersion uint32
+}
+
+// NodeCapacity is the resources of a node, and zero means the resource is not reported.
+type NodeCapacity struct {
+	CPUCores    uint32
+	MemoryBytes uint64
+	DiskBytes   uint64
 }
 
 func NewEmptyNodeStats() NodeStats {
@@ -291,8 +595,16 @@ func convertClusterPB(cluster *clusterpb.Cluster) Cluster {
 		ShardTotal:                  cluster.ShardTotal,
 		TopologyType:                convertTopologyTypePB(cluster.TopologyType),
 		ProcedureExecutingBatchSize: cluster.ProcedureExecutingBatchSize,
-		CreatedAt:                   cluster.CreatedAt,
-		ModifiedAt:                  cluster.ModifiedAt,
+		// It will be filled with the cluster options.
+		CaseInsensitiveName:     false,
+		DefaultSchemaName:       "",
+		HeartbeatIntervalBounds: HeartbeatIntervalBounds{MinMs: 0, MaxMs: 0},
+		Quota:                   ClusterQuota{MaxTables: 0, MaxTablesPerSchema: 0, MaxShards: 0},
+		CordonedNodes:           nil,
+		SchemaCreationPolicy:    SchemaCreationPolicyAuto,
+		Protected:               false,
+		CreatedAt:               cluster.CreatedAt,
+		ModifiedAt:              cluster.ModifiedAt,
 	}
 }
 
@@ -474,7 +786,8 @@ func convertTableToPB(table Table) clusterpb.Table {
 	}
 }
 
-func convertTablePB(table *clusterpb.Table) Table {
+// convertTablePB converts the table with the version of its key in etcd, which is the version of the table.
+func convertTablePB(table *clusterpb.Table, version int64) Table {
 	return Table{
 		ID:        TableID(table.Id),
 		Name:      table.Name,
@@ -483,6 +796,7 @@ func convertTablePB(table *clusterpb.Table) Table {
 		PartitionInfo: PartitionInfo{
 			Info: table.PartitionInfo,
 		},
+		Version: uint64(version),
 	}
 }
 
@@ -507,10 +821,11 @@ func convertShardViewPB(shardTopology *clusterpb.ShardView) ShardView {
 	}
 
 	return ShardView{
-		ShardID:   ShardID(shardTopology.ShardId),
-		Version:   shardTopology.Version,
-		TableIDs:  tableIDs,
-		CreatedAt: shardTopology.CreatedAt,
+		ShardID:       ShardID(shardTopology.ShardId),
+		Version:       shardTopology.Version,
+		TableIDs:      tableIDs,
+		TableVersions: makeTableVersions(shardTopology.Version, tableIDs),
+		CreatedAt:     shardTopology.CreatedAt,
 	}
 }
 
@@ -524,9 +839,11 @@ func convertNodeStatsToPB(stats NodeStats) clusterpb.NodeStats {
 
 func convertNodeStatsPB(stats *clusterpb.NodeStats) NodeStats {
 	return NodeStats{
-		Lease:       stats.Lease,
-		Zone:        stats.Zone,
-		NodeVersion: stats.NodeVersion,
+		Lease:           stats.Lease,
+		Zone:            stats.Zone,
+		NodeVersion:     stats.NodeVersion,
+		Capacity:        NodeCapacity{CPUCores: 0, MemoryBytes: 0, DiskBytes: 0},
+		ProtocolVersion: 0,
 	}
 }
 
@@ -573,3 +890,37 @@ func ConvertShardStatusToString(status ShardStatus) string {
 	}
 	return "unknown"
 }
+
+func ConvertClusterStateToString(state ClusterState) string {
+	switch state {
+	case ClusterStateEmpty:
+		return "empty"
+	case ClusterStateStable:
+		return "stable"
+	case ClusterStatePrepare:
+		return "prepare"
+	}
+	return "unknown"
+}
+
+func ConvertShardRoleToString(role ShardRole) string {
+	switch role {
+	case ShardRoleLeader:
+		return "leader"
+	case ShardRoleFollower:
+		return "follower"
+	}
+	return "unknown"
+}
+
+func ConvertNodeStateToString(state NodeState) string {
+	switch state {
+	case NodeStateUnknown:
+		return "unknown"
+	case NodeStateOnline:
+		return "online"
+	case NodeStateOffline:
+		return "offline"
+	}
+	return "unknown"
+}
@@ -0,0 +1,199 @@
+/*
+ * Copyright 2022 The HoraeDB Authors
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ */
+
+package storage
+
+import (
+	"context"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/CeresDB/horaedbproto/golang/pkg/clusterpb"
+	"github.com/pkg/errors"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"google.golang.org/protobuf/proto"
+)
+
+func (s *metaStorageImpl) GetRevision(ctx context.Context) (int64, error) {
+	resp, err := s.client.Get(ctx, s.rootPath, clientv3.WithCountOnly())
+	if err != nil {
+		return 0, errors.WithMessage(err, "get revision")
+	}
+	return resp.Header.Revision, nil
+}
+
+// WatchCluster watches the keys under the prefix of the cluster, and the changes in a watch response are sent as a
+// whole, so that the changes made in one txn, e.g. the shard view and its latest version, are never seen partially.
+func (s *metaStorageImpl) WatchCluster(ctx context.Context, req WatchClusterRequest) <-chan WatchClusterResult {
+	results := make(chan WatchClusterResult)
+	prefix := makeClusterKeyPrefix(s.rootPath, uint32(req.ClusterID))
+
+	go func() {
+		defer close(results)
+
+		// The etcd watch is canceled once no more result is sent.
+		watchCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+
+		watchChan := s.client.Watch(watchCtx, prefix, clientv3.WithPrefix(), clientv3.WithRev(req.Revision))
+		for resp := range watchChan {
+			// The revision in the header of the created response may be ahead of the events not sent yet.
+			if resp.Created {
+				continue
+			}
+
+			var result WatchClusterResult
+			if err := resp.Err(); err != nil {
+				result.Err = errors.WithMessagef(ErrWatchCluster, "clusterID:%d, revision:%d, compactRevision:%d, err:%v", req.ClusterID, req.Revision, resp.CompactRevision, err)
+			} else {
+				changes, err := decodeClusterChanges(prefix, resp)
+				if err != nil {
+					result.Err = errors.WithMessagef(err, "decode cluster changes, clusterID:%d", req.ClusterID)
+				}
+				result.Changes = changes
+			}
+
+			select {
+			case results <- result:
+			case <-ctx.Done():
+				return
+			}
+			if result.Err != nil {
+				return
+			}
+		}
+	}()
+
+	return results
+}
+
+func (s *metaStorageImpl) RequestWatchProgress(ctx context.Context) error {
+	if err := s.client.RequestProgress(ctx); err != nil {
+		return errors.WithMessage(err, "request watch progress")
+	}
+	return nil
+}
+
+func decodeClusterChanges(prefix string, resp clientv3.WatchResponse) (ClusterChanges, error) {
+	changes := ClusterChanges{
+		Revision:           resp.Header.Revision,
+		Schemas:            nil,
+		Tables:             nil,
+		ShardIDs:           nil,
+		Nodes:              nil,
+		ClusterViewChanged: false,
+	}
+	// The changes are only known to be up to the last event, unless the response just reports the progress.
+	if len(resp.Events) > 0 {
+		changes.Revision = resp.Events[len(resp.Events)-1].Kv.ModRevision
+	}
+
+	for _, event := range resp.Events {
+		if err := decodeClusterChange(&changes, prefix, event); err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
+
+// decodeClusterChange decodes the event by the layout of the keys in key_path.go, and the events of the other keys, e.g.
+// the expired shard views and the table name to id mappings, are ignored.
+func decodeClusterChange(changes *ClusterChanges, prefix string, event *clientv3.Event) error {
+	key := string(event.Kv.Key)
+	segments := strings.Split(strings.TrimPrefix(key, prefix), "/")
+	isPut := event.Type == clientv3.EventTypePut
+	changeType := ChangeTypePut
+	if !isPut {
+		changeType = ChangeTypeDelete
+	}
+
+	switch {
+	case len(segments) == 3 && segments[0] == schema && segments[1] == info:
+		if !isPut {
+			return nil
+		}
+		schemaPB := &clusterpb.Schema{}
+		if err := proto.Unmarshal(event.Kv.Value, schemaPB); err != nil {
+			return ErrDecode.WithCausef("decode schema, key:%s, err:%v", key, err)
+		}
+		changes.Schemas = append(changes.Schemas, convertSchemaPB(schemaPB))
+
+	case len(segments) == 4 && segments[0] == schema && segments[2] == table:
+		schemaID, err := strconv.ParseUint(segments[1], 10, 32)
+		if err != nil {
+			return ErrDecode.WithCausef("decode schema id, key:%s, err:%v", key, err)
+		}
+		tableID, err := strconv.ParseUint(segments[3], 10, 64)
+		if err != nil {
+			return ErrDecode.WithCausef("decode table id, key:%s, err:%v", key, err)
+		}
+		var changedTable Table
+		if isPut {
+			tablePB := &clusterpb.Table{}
+			if err := proto.Unmarshal(event.Kv.Value, tablePB); err != nil {
+				return ErrDecode.WithCausef("decode table, key:%s, err:%v", key, err)
+			}
+			changedTable = convertTablePB(tablePB, event.Kv.Version)
+		}
+		changes.Tables = append(changes.Tables, TableChange{
+			Type:     changeType,
+			SchemaID: SchemaID(schemaID),
+			TableID:  TableID(tableID),
+			Table:    changedTable,
+		})
+
+	case len(segments) == 3 && segments[0] == shardView && segments[2] == latestVersion:
+		shardID, err := strconv.ParseUint(segments[1], 10, 32)
+		if err != nil {
+			return ErrDecode.WithCausef("decode shard id, key:%s, err:%v", key, err)
+		}
+		// The later event of the same shard wins, so that the shard view deleted and created again is not lost.
+		if !isPut {
+			changes.ShardIDs = slices.DeleteFunc(changes.ShardIDs, func(id ShardID) bool { return id == ShardID(shardID) })
+			if !slices.Contains(changes.DeletedShardIDs, ShardID(shardID)) {
+				changes.DeletedShardIDs = append(changes.DeletedShardIDs, ShardID(shardID))
+			}
+			return nil
+		}
+		changes.DeletedShardIDs = slices.DeleteFunc(changes.DeletedShardIDs, func(id ShardID) bool { return id == ShardID(shardID) })
+		if !slices.Contains(changes.ShardIDs, ShardID(shardID)) {
+			changes.ShardIDs = append(changes.ShardIDs, ShardID(shardID))
+		}
+
+	case len(segments) >= 2 && segments[0] == node:
+		var changedNode Node
+		if isPut {
+			nodePB := &clusterpb.Node{}
+			if err := proto.Unmarshal(event.Kv.Value, nodePB); err != nil {
+				return ErrDecode.WithCausef("decode node, key:%s, err:%v", key, err)
+			}
+			changedNode = convertNodePB(nodePB)
+		}
+		changes.Nodes = append(changes.Nodes, NodeChange{
+			Type:     changeType,
+			NodeName: strings.Join(segments[1:], "/"),
+			Node:     changedNode,
+		})
+
+	case len(segments) == 2 && segments[0] == clusterView && segments[1] == latestVersion:
+		if isPut {
+			changes.ClusterViewChanged = true
+		}
+	}
+
+	return nil
+}